@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/lambda" // Lambda Go runtime
+
+	"troggle-backend/internal/config"                 // environment-driven settings
+	"troggle-backend/internal/functions/auditarchive" // handler implementation
+	"troggle-backend/internal/logging"                // structured JSON logging
+)
+
+// main loads and validates the configuration, builds the handler and its
+// clients once at cold start and then starts the Lambda runtime with it
+func main() {
+	logging.Init()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal("Invalid configuration", err)
+	}
+
+	h, err := auditarchive.New(context.Background(), cfg)
+	if err != nil {
+		logging.Fatal("Error initializing handler", err)
+	}
+	lambda.Start(h.Handle)
+}
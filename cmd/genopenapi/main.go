@@ -0,0 +1,55 @@
+// Command genopenapi writes the OpenAPI 3 document of the troggle API,
+// built from the routes the functions declare (see package api):
+//
+//	go run ./cmd/genopenapi -o openapi.json
+//
+// The document at the root of the repository is generated this way, and the
+// tests fail when it is out of date. Client SDKs and the API Gateway
+// definition are generated from it.
+package main
+
+//go:generate go run . -o ../../openapi.json
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"troggle-backend/internal/api"
+)
+
+// info describes the API in the document.
+var info = api.Info{Title: "Troggle API", Version: "1.0.0"}
+
+func main() {
+	out := flag.String("o", "", "file to write the document to; standard output when empty")
+	flag.Parse()
+
+	doc, err := generate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "genopenapi:", err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(doc)
+	} else {
+		err = os.WriteFile(*out, doc, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "genopenapi:", err)
+		os.Exit(1)
+	}
+}
+
+// generate returns the document of routes as indented JSON.
+func generate() ([]byte, error) {
+	if err := api.Check(routes); err != nil {
+		return nil, err
+	}
+	doc, err := json.MarshalIndent(api.OpenAPI(info, routes), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding document: %w", err)
+	}
+	return append(doc, '\n'), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+
+	"troggle-backend/internal/functions/authorizer"
+)
+
+// TestRoutesMatchAuthorizer checks the declared routes against the
+// authorizer's routes.json: every deployed route is declared, with the same
+// access, and nothing else is.
+func TestRoutesMatchAuthorizer(t *testing.T) {
+	rc, err := authorizer.LoadRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployed := make(map[string]authorizer.Route)
+	for _, r := range rc.Routes {
+		deployed[r.Method+" "+r.Path] = r
+	}
+
+	for _, r := range routes {
+		key := r.Method + " " + r.Path
+		d, ok := deployed[key]
+		if !ok {
+			t.Errorf("%s (%s) is not in routes.json", key, r.Name)
+			continue
+		}
+		delete(deployed, key)
+		if !slices.Equal(d.Scopes, r.Scopes) || !slices.Equal(d.Groups, r.Groups) {
+			t.Errorf("%s: declared scopes %v and groups %v, routes.json has %v and %v", key, r.Scopes, r.Groups, d.Scopes, d.Groups)
+		}
+		if d.Public != r.Public {
+			t.Errorf("%s: declared public %v, routes.json has %v", key, r.Public, d.Public)
+		}
+	}
+	for key := range deployed {
+		t.Errorf("%s is in routes.json but no function declares it", key)
+	}
+}
+
+// TestDocumentUpToDate checks that the checked-in document matches the
+// declared routes.
+func TestDocumentUpToDate(t *testing.T) {
+	want, err := generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../../openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("openapi.json is out of date; run go generate ./cmd/genopenapi")
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+import "troggle-backend/internal/functions"
+
+// routes are the routes of every function serving the API (see package
+// functions).
+var routes = functions.Routes()
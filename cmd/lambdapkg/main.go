@@ -0,0 +1,58 @@
+// Command lambdapkg packages function binaries for the provided.al2023
+// Lambda runtime and checks packages against its contract. The Makefile
+// runs it; see there for how binaries are built.
+//
+//	lambdapkg -arch arm64 -o dist/getVersion.zip build/getVersion/bootstrap
+//	lambdapkg -arch arm64 -verify dist/*.zip
+//
+// A package is a zip holding one executable named bootstrap, which the
+// runtime starts. Checking it makes sure the binary is a static Linux
+// executable for -arch, built without cgo and with the lambda.norpc tag,
+// that talks to the Runtime API through aws-lambda-go: the runtime has no C
+// library to link against and no RPC shim to speak the Go 1.x protocol.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"troggle-backend/internal/logging"
+)
+
+func main() {
+	arch := flag.String("arch", "arm64", "architecture packages must target: arm64 or amd64")
+	out := flag.String("o", "", "zip to write the bootstrap binary given as argument to")
+	verify := flag.Bool("verify", false, "check the zips given as arguments instead of writing one")
+	flag.Parse()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	switch {
+	case *verify:
+		var errs []error
+		for _, path := range flag.Args() {
+			if err := verifyZip(path, *arch); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			logging.Fatal("Invalid packages", err)
+		}
+		slog.Info("Packages valid", "count", flag.NArg(), "arch", *arch)
+
+	case *out != "" && flag.NArg() == 1:
+		if err := checkBinary(flag.Arg(0), *arch); err != nil {
+			logging.Fatal("Invalid binary", fmt.Errorf("%s: %w", flag.Arg(0), err))
+		}
+		if err := writeZip(*out, flag.Arg(0)); err != nil {
+			logging.Fatal("Error writing package", err)
+		}
+
+	default:
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: lambdapkg [-arch arm64] -o <zip> <bootstrap> | -verify <zip>...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+}
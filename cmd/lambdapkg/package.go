@@ -0,0 +1,134 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/buildinfo"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// bootstrap is the name the runtime starts.
+const bootstrap = "bootstrap"
+
+// runtimeModule is the module that implements the Runtime API client.
+const runtimeModule = "github.com/aws/aws-lambda-go"
+
+// machines are the ELF machines of the architectures Lambda runs.
+var machines = map[string]elf.Machine{
+	"arm64": elf.EM_AARCH64,
+	"amd64": elf.EM_X86_64,
+}
+
+// checkBinary checks the binary at path; see check.
+func checkBinary(path, arch string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return check(f, arch)
+}
+
+// check checks that r is a static Linux executable for arch, built by Go
+// without cgo, with the lambda.norpc tag and with aws-lambda-go.
+func check(r io.ReaderAt, arch string) error {
+	want, ok := machines[arch]
+	if !ok {
+		return fmt.Errorf("unknown architecture %q", arch)
+	}
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return fmt.Errorf("not a Linux executable: %w", err)
+	}
+	if f.Machine != want {
+		return fmt.Errorf("built for %s, want %s", f.Machine, want)
+	}
+	if slices.ContainsFunc(f.Progs, func(p *elf.Prog) bool { return p.Type == elf.PT_INTERP }) {
+		return errors.New("dynamically linked")
+	}
+
+	info, err := buildinfo.Read(r)
+	if err != nil {
+		return fmt.Errorf("reading build info: %w", err)
+	}
+	settings := map[string]string{}
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	var errs []error
+	if settings["CGO_ENABLED"] != "0" {
+		errs = append(errs, errors.New("built with cgo"))
+	}
+	if !slices.Contains(strings.Split(settings["-tags"], ","), "lambda.norpc") {
+		errs = append(errs, errors.New("built without the lambda.norpc tag"))
+	}
+	if !slices.ContainsFunc(info.Deps, func(m *debug.Module) bool { return m.Path == runtimeModule }) {
+		errs = append(errs, fmt.Errorf("does not use %s", runtimeModule))
+	}
+	return errors.Join(errs...)
+}
+
+// writeZip writes the binary at path to a zip at out as an executable
+// named bootstrap. Its time is fixed, so the same binary always gives the
+// same zip and deploys of unchanged functions are no-ops.
+func writeZip(out, path string) error {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	hdr := &zip.FileHeader{Name: bootstrap, Method: zip.Deflate, Modified: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	hdr.SetMode(0o755)
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(bin); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(out, buf.Bytes(), 0o644)
+}
+
+// verifyZip checks that the zip at path holds only an executable bootstrap
+// that passes check.
+func verifyZip(path, arch string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	if len(zr.File) != 1 || zr.File[0].Name != bootstrap {
+		return fmt.Errorf("want exactly one file, %s", bootstrap)
+	}
+	zf := zr.File[0]
+	if zf.Mode()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", bootstrap)
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	bin, err := io.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	return check(bytes.NewReader(bin), arch)
+}
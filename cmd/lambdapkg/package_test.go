@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteZip(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bootstrap")
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same binary gives the same zip
+	var zips [][]byte
+	for _, name := range []string{"a.zip", "b.zip"} {
+		out := filepath.Join(dir, "dist", name)
+		if err := writeZip(out, bin); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zips = append(zips, b)
+	}
+	if !bytes.Equal(zips[0], zips[1]) {
+		t.Error("zips of the same binary differ")
+	}
+
+	// The layout passes, the script does not
+	err := verifyZip(filepath.Join(dir, "dist", "a.zip"), "arm64")
+	if err == nil || !strings.Contains(err.Error(), "not a Linux executable") {
+		t.Errorf("verifyZip = %v, want a rejected binary", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := check(bytes.NewReader(nil), "s390x"); err == nil || !strings.Contains(err.Error(), "unknown architecture") {
+		t.Errorf("check(s390x) = %v", err)
+	}
+	if err := checkBinary(filepath.Join(t.TempDir(), "missing"), "arm64"); err == nil {
+		t.Error("checkBinary of a missing file succeeded")
+	}
+}
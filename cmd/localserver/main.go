@@ -0,0 +1,117 @@
+// Command localserver runs every troggle HTTP handler behind a local router,
+// against DynamoDB Local or LocalStack, so the backend can be exercised
+// without deploying to AWS.
+//
+//	docker run -p 8000:8000 amazon/dynamodb-local
+//	go run ./cmd/localserver
+//	curl -X POST localhost:8080/users -d '{"user_id":"u1","email":"jane@example.com"}'
+//
+// Requests are turned into API Gateway REST events, so handlers run exactly
+// as they do when deployed. Authentication is simulated: a bearer token of
+// the form "<sub>" or "<sub>:<group>,<group>" is accepted as is.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/functions"
+	"troggle-backend/internal/localdev"
+	"troggle-backend/internal/logging"
+)
+
+// localDefaults are applied to the environment when unset, so the server
+// starts with no configuration at all. The credentials are dummies: DynamoDB
+// Local and LocalStack accept any.
+var localDefaults = map[string]string{
+	"AWS_REGION":               "us-east-1",
+	"AWS_ACCESS_KEY_ID":        "local",
+	"AWS_SECRET_ACCESS_KEY":    "local",
+	config.EnvDynamoDBEndpoint: "http://localhost:8000",
+	config.EnvUserPoolID:       "us-east-1_local",
+	config.EnvAppClientIDs:     "local",
+	logging.EnvMaskPII:         "false",
+
+	// Queues, buckets and links of the functions that need them: LocalStack
+	// serves the first, and the links only end up in emails.
+	config.EnvEmailQueueURL:     "http://localhost:4566/000000000000/emails",
+	config.EnvExportQueueURL:    "http://localhost:4566/000000000000/exports",
+	config.EnvExportBucket:      "exports",
+	config.EnvAvatarBucket:      "avatars",
+	config.EnvAvatarBaseURL:     "http://localhost:4566/avatars",
+	config.EnvEmailChangeURL:    "http://localhost:3000/email-change",
+	config.EnvInvitationURL:     "http://localhost:3000/invitation",
+	config.EnvSignInURL:         "http://localhost:3000/sign-in",
+	config.EnvWebSocketEndpoint: "https://localhost:4510",
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "listen address")
+	endpoint := flag.String("endpoint", "", "endpoint for every AWS service, e.g. http://localhost:4566 for LocalStack")
+	createTables := flag.Bool("create-tables", true, "create missing tables at startup")
+	flag.Parse()
+
+	if *endpoint != "" {
+		os.Setenv("AWS_ENDPOINT_URL", *endpoint)
+		if os.Getenv(config.EnvDynamoDBEndpoint) == "" {
+			os.Setenv(config.EnvDynamoDBEndpoint, *endpoint)
+		}
+	}
+	for k, v := range localDefaults {
+		if _, ok := os.LookupEnv(k); !ok {
+			os.Setenv(k, v)
+		}
+	}
+
+	logging.Init()
+	ctx := context.Background()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal("Invalid configuration", err)
+	}
+
+	if *createTables {
+		client, err := db.NewSDK(ctx, cfg)
+		if err != nil {
+			logging.Fatal("Error creating DynamoDB client", err)
+		}
+		if err := localdev.CreateTables(ctx, client, cfg); err != nil {
+			logging.Fatal("Error creating tables", err)
+		}
+	}
+
+	rt, err := newRouter(ctx, cfg)
+	if err != nil {
+		logging.Fatal("Error initializing handlers", err)
+	}
+
+	slog.Info("Local server listening", "addr", *addr, "dynamodb_endpoint", cfg.DynamoDBEndpoint)
+	if err := http.ListenAndServe(*addr, rt); err != nil {
+		logging.Fatal("Server stopped", err)
+	}
+}
+
+// newRouter builds the handler of every function and mounts it on the
+// routes API Gateway serves it on.
+func newRouter(ctx context.Context, cfg *config.Config) (*router, error) {
+	rt := &router{}
+	for _, f := range functions.All {
+		h, err := f.New(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Routes[0].Function, err)
+		}
+		localize(h)
+		serve := h.HTTP()
+		for _, r := range f.Routes {
+			rt.mount(r, serve)
+		}
+	}
+	return rt, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"troggle-backend/internal/api"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/cors"
+	"troggle-backend/internal/functions"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/pagination"
+	"troggle-backend/internal/signing"
+)
+
+// requestSeq numbers requests in place of API Gateway request IDs.
+var requestSeq atomic.Int64
+
+// router serves the mounted routes, choosing the route of a path as API
+// Gateway does: segment by segment, a literal segment wins over a {param}
+// one. /users/by-sub/x is therefore served by /users/by-sub/{sub} and
+// /users/x/preferences by /users/{user_id}/preferences, two patterns
+// http.ServeMux refuses to hold together.
+type router struct {
+	routes []route // most specific first
+}
+
+// route is a method of a resource and the handler serving it.
+type route struct {
+	method   string
+	resource string   // e.g. /users/{user_id}
+	segments []string // of resource
+	adapter  func(ctx context.Context, payload json.RawMessage) (httpx.Response, error)
+}
+
+// mount serves h on r, and on r in every API version, through the same
+// adapter the Lambda runtime uses, which also answers the CORS preflight
+// requests of the resource.
+func (rt *router) mount(r api.Route, h httpx.Handler) {
+	rt.routes = append(rt.routes, route{
+		method:   r.Method,
+		resource: r.Path,
+		segments: strings.Split(r.Path, "/"),
+		adapter:  httpx.Adapt(h, allowEveryOrigin),
+	})
+	slices.SortStableFunc(rt.routes, func(a, b route) int {
+		for i := 0; i < len(a.segments) && i < len(b.segments); i++ {
+			if pa, pb := isParam(a.segments[i]), isParam(b.segments[i]); pa != pb {
+				if pb {
+					return -1
+				}
+				return 1
+			}
+		}
+		return 0
+	})
+}
+
+// ServeHTTP serves req with the route of its path and method. Preflight
+// requests go to the first route of the resource.
+func (rt *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	// And below every API version, as API Gateway does
+	for _, v := range httpx.Versions {
+		if rest, ok := strings.CutPrefix(path, "/"+v.String()+"/"); ok {
+			path = "/" + rest
+			break
+		}
+	}
+
+	segments := strings.Split(path, "/")
+	resource := ""
+	var params map[string]string
+	for _, r := range rt.routes {
+		if resource == "" {
+			if params = r.match(segments); params != nil {
+				resource = r.resource
+			}
+		}
+		if resource != r.resource || (r.method != req.Method && req.Method != http.MethodOptions) {
+			continue
+		}
+		payload, err := toEvent(req, r.resource, params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := r.adapter(req.Context(), payload)
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Handler failed", logging.Err(err))
+			http.Error(w, "handler error: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeResponse(w, resp)
+		return
+	}
+	if resource != "" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+// match returns the path parameters of segments, the segments of a request
+// path, or nil when r does not serve the path.
+func (r route) match(segments []string) map[string]string {
+	if len(segments) != len(r.segments) {
+		return nil
+	}
+	params := map[string]string{}
+	for i, s := range r.segments {
+		switch {
+		case isParam(s) && segments[i] != "":
+			params[s[1:len(s)-1]] = segments[i]
+		case s != segments[i]:
+			return nil
+		}
+	}
+	return params
+}
+
+// isParam reports whether segment is a {param} wildcard.
+func isParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// allowEveryOrigin is the CORS policy of the local server: the defaults, as
+// there is no Parameter Store.
+func allowEveryOrigin(next httpx.Handler) httpx.Handler {
+	return cors.Wrap(nil, next)
+}
+
+// toEvent builds the REST API (payload format 1.0) event API Gateway would
+// send for req.
+func toEvent(req *http.Request, resource string, params map[string]string) (json.RawMessage, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+
+	event := events.APIGatewayProxyRequest{
+		Resource:                        resource,
+		Path:                            req.URL.Path,
+		HTTPMethod:                      req.Method,
+		Headers:                         map[string]string{},
+		MultiValueHeaders:               map[string][]string(req.Header),
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: map[string][]string(req.URL.Query()),
+		PathParameters:                  params,
+		Body:                            string(body),
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:    fmt.Sprintf("local-%d", requestSeq.Add(1)),
+			ResourcePath: resource,
+			HTTPMethod:   req.Method,
+			Stage:        "local",
+		},
+	}
+	for name := range req.Header {
+		event.Headers[name] = req.Header.Get(name)
+	}
+	for name, values := range req.URL.Query() {
+		event.QueryStringParameters[name] = values[0]
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		event.RequestContext.Identity.SourceIP = host
+	}
+
+	// Mimic a Cognito user pool authorizer for handlers reading claims
+	if id, err := (devVerifier{}).Verify(req.Context(), bearer(req)); err == nil {
+		event.RequestContext.Authorizer = map[string]any{"claims": map[string]any{
+			"sub":            id.Subject,
+			"cognito:groups": strings.Join(id.Groups, ","),
+		}}
+	}
+
+	return json.Marshal(event)
+}
+
+// writeResponse copies a Lambda proxy response to w.
+func writeResponse(w http.ResponseWriter, resp httpx.Response) {
+	for name, value := range resp.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range resp.MultiValueHeaders {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+
+	body := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(resp.Body)
+		if err != nil {
+			http.Error(w, "invalid base64 response body", http.StatusBadGateway)
+			return
+		}
+		body = decoded
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(body)
+}
+
+// bearer returns the bearer token of req, if any.
+func bearer(req *http.Request) string {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// devVerifier accepts any token of the form "<sub>" or
+// "<sub>:<group>,<group>". It must never be used outside the local server.
+type devVerifier struct{}
+
+func (devVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token == "" {
+		return nil, auth.ErrNoToken
+	}
+	sub, groups, _ := strings.Cut(token, ":")
+	id := &auth.Identity{Subject: sub, Username: sub, TokenUse: "access"}
+	if groups != "" {
+		id.Groups = strings.Split(groups, ",")
+	}
+	return id, nil
+}
+
+// devCursors signs next tokens with a fixed key, as no secrets are read
+// locally. It must never be used outside the local server.
+var devCursors = pagination.NewCodec(signing.StaticKey("local"))
+
+// localize swaps the dependencies of h that need Cognito or Secrets Manager
+// for their local stand-ins: devVerifier and devCursors.
+func localize(h functions.Handler) {
+	v := reflect.ValueOf(h).Elem()
+	for field, local := range map[string]any{"Auth": devVerifier{}, "Cursors": devCursors} {
+		f := v.FieldByName(field)
+		if !f.IsValid() || !reflect.TypeOf(local).AssignableTo(f.Type()) {
+			continue
+		}
+		if k := f.Kind(); (k == reflect.Interface || k == reflect.Pointer) && f.IsNil() {
+			continue // the handler goes without
+		}
+		f.Set(reflect.ValueOf(local))
+	}
+}
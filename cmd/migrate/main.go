@@ -0,0 +1,95 @@
+// Command migrate upgrades the stored items of a table to the current
+// version of their schema (see package migration), reading its
+// configuration from the environment as the functions do.
+//
+//	go run ./cmd/migrate -dry-run
+//	go run ./cmd/migrate -schema users -segments 8 -rate 200
+//
+// Repositories upgrade items as they read them, so running it is never
+// urgent: it persists the upgrades, pacing its scans with -rate so it does
+// not take the read capacity live traffic needs. It can be run again at any
+// time; items already current are only read.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/migration"
+	"troggle-backend/internal/users"
+)
+
+// target is a table and the schema of its items.
+type target struct {
+	schema *migration.Schema
+	table  func(cfg *config.Config) string
+}
+
+// targets are the tables whose items have a schema.
+var targets = []target{
+	{users.Schema, func(cfg *config.Config) string { return cfg.UserTableName }},
+}
+
+func main() {
+	name := flag.String("schema", "", "schema to migrate (default all)")
+	segments := flag.Int("segments", 4, "parallel scan segments per table")
+	rate := flag.Float64("rate", 100, "items read per second per table; 0 for no limit")
+	dryRun := flag.Bool("dry-run", false, "count the items to upgrade without writing them")
+	flag.Parse()
+
+	logging.Init()
+	ctx := context.Background()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal("Invalid configuration", err)
+	}
+	selected, err := selectTargets(*name)
+	if err != nil {
+		logging.Fatal("Invalid -schema", err)
+	}
+	client, err := db.New(ctx, cfg)
+	if err != nil {
+		logging.Fatal("Error creating DynamoDB client", err)
+	}
+
+	for _, t := range selected {
+		table := t.table(cfg)
+		stats, err := t.schema.Backfill(ctx, client, table, migration.Options{
+			Segments: *segments,
+			Rate:     *rate,
+			DryRun:   *dryRun,
+		})
+		if err != nil {
+			logging.Fatal("Migration failed", err)
+		}
+		slog.Info("Migrated items",
+			"schema", stats.Schema,
+			"table", table,
+			"version", t.schema.Current(),
+			"scanned", stats.Scanned,
+			"upgraded", stats.Upgraded,
+			"conflicts", stats.Conflicts,
+			"dry_run", *dryRun,
+		)
+	}
+}
+
+// selectTargets returns the target of the schema named name, or every
+// target for "".
+func selectTargets(name string) ([]target, error) {
+	if name == "" {
+		return targets, nil
+	}
+	for _, t := range targets {
+		if t.schema.Name == name {
+			return []target{t}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown schema %q", name)
+}
@@ -0,0 +1,84 @@
+// Command migrateuserdata backfills the single user data table from the
+// tables the entities of a user live in today, reading its configuration
+// from the environment as the functions do.
+//
+//	go run ./cmd/migrateuserdata -dry-run
+//	go run ./cmd/migrateuserdata -sources sessions,devices -segments 8
+//
+// Rows are copied as they are and overwrite what is there, so the command
+// can be run again after a failure, or once more after writers have moved
+// to the new table to pick up rows written during the first run.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/userdata"
+)
+
+func main() {
+	only := flag.String("sources", "", "comma-separated sources to backfill: users, sessions, preferences, devices, relationships (default all)")
+	segments := flag.Int("segments", 4, "parallel scan segments per source")
+	dryRun := flag.Bool("dry-run", false, "count the rows to copy without writing them")
+	flag.Parse()
+
+	logging.Init()
+	ctx := context.Background()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal("Invalid configuration", err)
+	}
+	sources, err := selectSources(userdata.Sources(cfg), *only)
+	if err != nil {
+		logging.Fatal("Invalid -sources", err)
+	}
+	client, err := db.New(ctx, cfg)
+	if err != nil {
+		logging.Fatal("Error creating DynamoDB client", err)
+	}
+	store := userdata.NewStore(client, cfg)
+
+	for _, src := range sources {
+		stats, err := store.Backfill(ctx, src, *segments, *dryRun)
+		if err != nil {
+			logging.Fatal("Backfill failed", err)
+		}
+		slog.Info("Backfilled user data",
+			"source", stats.Source,
+			"table", src.Table,
+			"read", stats.Read,
+			"written", stats.Written,
+			"dry_run", *dryRun,
+		)
+	}
+}
+
+// selectSources returns the sources named in the comma-separated list only,
+// in the order Backfill moves them, or every source for "".
+func selectSources(all []userdata.Source, only string) ([]userdata.Source, error) {
+	if only == "" {
+		return all, nil
+	}
+	names := strings.Split(only, ",")
+	for _, name := range names {
+		if !slices.ContainsFunc(all, func(s userdata.Source) bool { return s.Name == strings.TrimSpace(name) }) {
+			return nil, fmt.Errorf("unknown source %q", name)
+		}
+	}
+	var sources []userdata.Source
+	for _, src := range all {
+		if slices.ContainsFunc(names, func(n string) bool { return strings.TrimSpace(n) == src.Name }) {
+			sources = append(sources, src)
+		}
+	}
+	return sources, nil
+}
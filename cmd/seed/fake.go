@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"troggle-backend/internal/notifications"
+	"troggle-backend/internal/users"
+)
+
+var (
+	firstNames = []string{
+		"Ada", "Alan", "Amara", "Bea", "Carlos", "Chen", "Dana", "Emeka", "Farah", "Grace",
+		"Hiro", "Ines", "Jonas", "Kai", "Lena", "Mateo", "Nadia", "Omar", "Priya", "Quinn",
+		"Rosa", "Sam", "Tariq", "Uma", "Viktor", "Wen", "Yara", "Zoe",
+	}
+	lastNames = []string{
+		"Abbott", "Baker", "Castillo", "Dubois", "Eriksen", "Fischer", "Garcia", "Haddad",
+		"Ivanova", "Jensen", "Kowalski", "Lee", "Mensah", "Novak", "Okafor", "Patel",
+		"Rossi", "Sato", "Tanaka", "Usman", "Varga", "Walsh", "Yilmaz", "Zhou",
+	}
+	bios = []string{
+		"", "", "Weekend puzzler.", "Chasing the weekly top ten.", "Here for the friendly rivalry.",
+		"Speedrunner at heart.", "Mostly lurking.", "Coffee first, then games.",
+	}
+	titles = []string{
+		"New high score on your board", "A friend passed your best", "Weekly results are in",
+		"You unlocked an achievement", "Scheduled maintenance this weekend",
+	}
+)
+
+// options size the generated data.
+type options struct {
+	Users         int
+	Friends       int // friendships per user, on average
+	Scores        int // results per user and board
+	Notifications int // per user
+	Boards        []string
+}
+
+// score is one result submitted to a board.
+type score struct {
+	Board  string
+	UserID string
+	Score  int64
+}
+
+// notification is a notification with the ref and time it is created from.
+type notification struct {
+	notifications.Notification
+	Ref string
+	At  time.Time
+}
+
+// plan is the data a seed writes. The same seed, options and now give the
+// same plan, so seeding twice writes the same items.
+type plan struct {
+	Users         []users.User
+	Friendships   [][2]string
+	Scores        []score
+	Notifications []notification
+}
+
+// generate builds the plan of seed. Times fall in the days before the start
+// of the day of now.
+func generate(seed uint64, opts options, now time.Time) plan {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	day := now.UTC().Truncate(24 * time.Hour)
+	before := func(maxDays int) time.Time {
+		return day.Add(-time.Duration(rng.Int64N(int64(maxDays) * int64(24*time.Hour))))
+	}
+
+	var p plan
+	for i := range opts.Users {
+		first, last := firstNames[rng.IntN(len(firstNames))], lastNames[rng.IntN(len(lastNames))]
+		handle := fmt.Sprintf("%s.%s%d", strings.ToLower(first), strings.ToLower(last), i)
+		created := before(365).Format(time.RFC3339)
+		p.Users = append(p.Users, users.User{
+			UserID:        fmt.Sprintf("seed-%016x", rng.Uint64()),
+			Email:         handle + "@example.com",
+			EmailVerified: true,
+			Username:      handle,
+			DisplayName:   first + " " + last,
+			Bio:           bios[rng.IntN(len(bios))],
+			Status:        "active",
+			CreatedAt:     created,
+			UpdatedAt:     created,
+			Version:       1,
+		})
+	}
+
+	// Each pair is friends at most once, whichever way it was drawn
+	seen := map[[2]int]bool{}
+	if n := len(p.Users); n > 1 {
+		for range n * opts.Friends / 2 {
+			a, b := rng.IntN(n), rng.IntN(n)
+			if a == b || seen[[2]int{min(a, b), max(a, b)}] {
+				continue
+			}
+			seen[[2]int{min(a, b), max(a, b)}] = true
+			p.Friendships = append(p.Friendships, [2]string{p.Users[a].UserID, p.Users[b].UserID})
+		}
+	}
+
+	for _, u := range p.Users {
+		skill := 1000 + rng.Int64N(9000)
+		for _, board := range opts.Boards {
+			for range opts.Scores {
+				p.Scores = append(p.Scores, score{Board: board, UserID: u.UserID, Score: skill/2 + rng.Int64N(skill)})
+			}
+		}
+		for range opts.Notifications {
+			typ := []string{notifications.TypeFriendAccepted, notifications.TypeAchievement, notifications.TypeSystem}[rng.IntN(3)]
+			p.Notifications = append(p.Notifications, notification{
+				Notification: notifications.Notification{
+					UserID: u.UserID,
+					Type:   typ,
+					Title:  titles[rng.IntN(len(titles))],
+				},
+				Ref: fmt.Sprintf("seed-%016x", rng.Uint64()),
+				At:  before(7),
+			})
+		}
+	}
+	return p
+}
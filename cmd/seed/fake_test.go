@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"troggle-backend/internal/validation"
+)
+
+func TestGenerate(t *testing.T) {
+	opts := options{Users: 40, Friends: 4, Scores: 2, Notifications: 3, Boards: []string{"classic", "daily"}}
+	now := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+
+	p := generate(7, opts, now)
+	if !reflect.DeepEqual(p, generate(7, opts, now.Add(time.Hour))) {
+		t.Error("the same seed on the same day generated different data")
+	}
+	if reflect.DeepEqual(p.Users, generate(8, opts, now).Users) {
+		t.Error("another seed generated the same users")
+	}
+
+	if len(p.Users) != 40 || len(p.Scores) != 40*2*2 || len(p.Notifications) != 40*3 {
+		t.Errorf("generated %d users, %d scores, %d notifications", len(p.Users), len(p.Scores), len(p.Notifications))
+	}
+	ids := map[string]bool{}
+	for _, u := range p.Users {
+		if got, err := validation.NormalizeUsername(u.Username); err != nil || got != u.Username {
+			t.Errorf("username %q: %v", u.Username, err)
+		}
+		ids[u.UserID] = true
+	}
+	if len(ids) != len(p.Users) {
+		t.Errorf("%d distinct user IDs for %d users", len(ids), len(p.Users))
+	}
+
+	pairs := map[[2]string]bool{}
+	for _, f := range p.Friendships {
+		if f[0] == f[1] || !ids[f[0]] || !ids[f[1]] || pairs[f] || pairs[[2]string{f[1], f[0]}] {
+			t.Errorf("friendship %v", f)
+		}
+		pairs[f] = true
+	}
+	for _, n := range p.Notifications {
+		if !n.At.Before(now) || n.At.Before(now.AddDate(0, 0, -8)) {
+			t.Errorf("notification at %v", n.At)
+		}
+	}
+}
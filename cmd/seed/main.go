@@ -0,0 +1,163 @@
+// Command seed fills a dev or staging environment with fake but plausible
+// data: users, friendships between them, leaderboard scores and inbox
+// notifications, written through the same repositories as the functions,
+// so the items have exactly the shape production writes.
+//
+//	go run ./cmd/seed -endpoint http://localhost:4566 -create-tables
+//	go run ./cmd/seed -profile troggle-staging -users 500 -seed 7
+//
+// The data is derived from -seed alone, so seeding twice with the same
+// flags on the same day writes the same items: what already exists is left
+// as it is. Everything the command creates has a user_id starting "seed-".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/leaderboard"
+	"troggle-backend/internal/localdev"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/notifications"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/users"
+)
+
+// localDefaults are applied to the environment when unset and -endpoint is
+// given. LocalStack and DynamoDB Local accept any credentials.
+var localDefaults = map[string]string{
+	"AWS_REGION":            "us-east-1",
+	"AWS_ACCESS_KEY_ID":     "local",
+	"AWS_SECRET_ACCESS_KEY": "local",
+}
+
+func main() {
+	seed := flag.Uint64("seed", 1, "seed of the generated data")
+	opts := options{}
+	flag.IntVar(&opts.Users, "users", 50, "users to create")
+	flag.IntVar(&opts.Friends, "friends", 5, "friendships per user, on average")
+	flag.IntVar(&opts.Scores, "scores", 3, "scores per user and leaderboard")
+	flag.IntVar(&opts.Notifications, "notifications", 5, "notifications per user")
+	endpoint := flag.String("endpoint", "", "endpoint for every AWS service, e.g. http://localhost:4566 for LocalStack")
+	profile := flag.String("profile", "", "named AWS profile to seed with, e.g. of the staging account")
+	createTables := flag.Bool("create-tables", false, "create missing tables first; for local endpoints")
+	flag.Parse()
+
+	if *endpoint != "" {
+		os.Setenv("AWS_ENDPOINT_URL", *endpoint)
+		if os.Getenv(config.EnvDynamoDBEndpoint) == "" {
+			os.Setenv(config.EnvDynamoDBEndpoint, *endpoint)
+		}
+		for k, v := range localDefaults {
+			if _, ok := os.LookupEnv(k); !ok {
+				os.Setenv(k, v)
+			}
+		}
+	}
+	if *profile != "" {
+		os.Setenv("AWS_PROFILE", *profile)
+	}
+
+	logging.Init()
+	ctx := context.Background()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal("Invalid configuration", err)
+	}
+	if *createTables {
+		client, err := db.NewSDK(ctx, cfg)
+		if err != nil {
+			logging.Fatal("Error creating DynamoDB client", err)
+		}
+		if err := localdev.CreateTables(ctx, client, cfg); err != nil {
+			logging.Fatal("Error creating tables", err)
+		}
+	}
+	client, err := db.New(ctx, cfg)
+	if err != nil {
+		logging.Fatal("Error creating DynamoDB client", err)
+	}
+
+	opts.Boards = cfg.Leaderboards
+	s := &seeder{
+		users:         users.NewRepository(client, cfg),
+		relationships: relationships.NewStore(client, cfg),
+		leaderboard:   leaderboard.NewStore(client, cfg),
+		notifications: notifications.NewStore(client, cfg),
+	}
+	if err := s.run(ctx, generate(*seed, opts, time.Now())); err != nil {
+		logging.Fatal("Seeding failed", err)
+	}
+}
+
+// seeder writes a plan through the repositories.
+type seeder struct {
+	users         *users.Repository
+	relationships *relationships.Store
+	leaderboard   *leaderboard.Store
+	notifications *notifications.Store
+}
+
+// run writes p, skipping what is already there, and logs what it wrote.
+func (s *seeder) run(ctx context.Context, p plan) error {
+	var created, friends, scores, inbox int
+	for _, u := range p.Users {
+		err := s.users.Create(ctx, u)
+		switch {
+		case err == nil:
+			created++
+		case errors.Is(err, users.ErrUserExists):
+		case errors.Is(err, users.ErrEmailTaken), errors.Is(err, users.ErrUsernameTaken):
+			slog.Warn("Skipping seed user", "user_id", u.UserID, "username", u.Username, logging.Err(err))
+		default:
+			return err
+		}
+	}
+
+	for _, pair := range p.Friendships {
+		accepted, err := s.relationships.RequestFriend(ctx, pair[0], pair[1])
+		if errors.Is(err, relationships.ErrAlreadyFriends) {
+			continue
+		}
+		if err == nil && !accepted {
+			err = s.relationships.Accept(ctx, pair[1], pair[0])
+		}
+		if err != nil {
+			return err
+		}
+		friends++
+	}
+
+	now := time.Now()
+	for _, sc := range p.Scores {
+		if _, err := s.leaderboard.Submit(ctx, sc.Board, sc.UserID, sc.Score, now); err != nil {
+			return err
+		}
+		scores++
+	}
+
+	for _, n := range p.Notifications {
+		ok, err := s.notifications.Create(ctx, n.Notification, n.Ref, n.At)
+		if err != nil {
+			return err
+		}
+		if ok {
+			inbox++
+		}
+	}
+
+	slog.Info("Seeded",
+		"users", created,
+		"friendships", friends,
+		"scores", scores,
+		"notifications", inbox,
+	)
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+
+	"troggle-backend/internal/accountstatus"
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/events"
+	"troggle-backend/internal/exports"
+	"troggle-backend/internal/sessions"
+	"troggle-backend/internal/users"
+	"troggle-backend/internal/validation"
+)
+
+// CognitoAPI is the part of the Cognito user pool API the commands use.
+type CognitoAPI interface {
+	AdminUserGlobalSignOut(ctx context.Context, params *cognitoidentityprovider.AdminUserGlobalSignOutInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminUserGlobalSignOutOutput, error)
+	ResendConfirmationCode(ctx context.Context, params *cognitoidentityprovider.ResendConfirmationCodeInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.ResendConfirmationCodeOutput, error)
+}
+
+// errNoUser is returned for commands on users that do not exist.
+var errNoUser = errors.New("user not found")
+
+// admin runs the commands. Each prints its result to Out as JSON and, unless
+// DryRun, records an audit entry with Operator as the actor once it is done.
+// With DryRun, commands read what they need and print what they would do,
+// writing nothing: no changes, no Cognito calls and no audit entries.
+type admin struct {
+	Users    *users.Repository // uncached, soft-deleted records included
+	Status   *accountstatus.Store
+	Sessions *sessions.Store
+	Exports  *exports.Collector
+	Cognito  CognitoAPI
+	Events   *events.Publisher
+	Audit    *audit.Store
+	Config   *config.Config
+
+	Operator string
+	DryRun   bool
+	Out      io.Writer
+}
+
+// lookup prints the records of the users matching query: an email, which
+// may match more than one record, or a Cognito sub.
+func (a *admin) lookup(ctx context.Context, query string) error {
+	ids := []string{query}
+	if strings.Contains(query, "@") {
+		email, err := validation.NormalizeEmail(query, a.Config.StripPlusAlias)
+		if err != nil {
+			return err
+		}
+		if ids, err = a.Users.IDsByEmail(ctx, email); err != nil {
+			return err
+		}
+	} else if err := validation.UserID(query); err != nil {
+		return err
+	}
+
+	profiles := []map[string]any{}
+	for _, id := range ids {
+		item, err := a.Users.Get(ctx, id, nil)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			continue
+		}
+		profile, err := users.Profile(item)
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, profile)
+	}
+	if len(profiles) == 0 {
+		return errNoUser
+	}
+	for _, p := range profiles {
+		if err := a.record(ctx, p["user_id"].(string), audit.ActionUserLookup, nil); err != nil {
+			return err
+		}
+	}
+	return a.print(profiles)
+}
+
+// dump prints every item stored about userID, as exports bundle them.
+func (a *admin) dump(ctx context.Context, userID string) error {
+	bundle, err := a.Exports.Collect(ctx, userID)
+	if errors.Is(err, exports.ErrNoUser) {
+		return errNoUser
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.record(ctx, userID, audit.ActionUserDump, nil); err != nil {
+		return err
+	}
+	return a.print(bundle)
+}
+
+// setStatus moves the account of userID to status to, as the
+// changeUserStatus function does: suspend and unsuspend are transitions to
+// accountstatus.Suspended and back to accountstatus.Active.
+func (a *admin) setStatus(ctx context.Context, userID, to, reason, note string) error {
+	if a.DryRun {
+		user, err := a.user(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !accountstatus.CanTransition(user.Status, to) {
+			return apperr.Invalid("INVALID_TRANSITION", "status", fmt.Sprintf("cannot change status from %q to %q", user.Status, to))
+		}
+		return a.print(map[string]any{"user_id": userID, "from": user.Status, "to": to, "reason": reason, "dry_run": true})
+	}
+
+	change, err := a.Status.Transition(ctx, userID, to, reason, note, time.Now())
+	if err != nil {
+		return err
+	}
+	a.Users.Invalidate(userID, "")
+	diff := map[string]audit.Change{"status": {Before: change.From, After: change.To}}
+	if change.Reason != "" || note != "" {
+		diff["status_reason"] = audit.Change{After: change.Reason}
+		diff["status_note"] = audit.Change{After: note}
+	}
+	if err := a.record(ctx, userID, audit.ActionUserStatusChange, diff); err != nil {
+		return err
+	}
+	a.Events.Emit(ctx, events.UserStatusChanged{
+		UserID:    change.UserID,
+		From:      change.From,
+		To:        change.To,
+		Reason:    change.Reason,
+		ChangedAt: change.ChangedAt,
+	})
+	return a.print(change)
+}
+
+// signOut signs userID out everywhere: Cognito revokes their refresh
+// tokens, and their sessions are revoked so the access tokens they hold
+// are turned away at once rather than when they expire.
+func (a *admin) signOut(ctx context.Context, userID string) error {
+	if _, err := a.user(ctx, userID); err != nil {
+		return err
+	}
+	if a.DryRun {
+		active, err := a.Sessions.ListActive(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return a.print(map[string]any{"user_id": userID, "sessions": len(active), "dry_run": true})
+	}
+
+	_, err := a.Cognito.AdminUserGlobalSignOut(ctx, &cognitoidentityprovider.AdminUserGlobalSignOutInput{
+		UserPoolId: aws.String(a.Config.UserPoolID),
+		Username:   aws.String(userID),
+	})
+	if err != nil {
+		return fmt.Errorf("signing out of Cognito: %w", err)
+	}
+	revoked, err := a.Sessions.RevokeAll(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := a.record(ctx, userID, audit.ActionUserSignOut, nil); err != nil {
+		return err
+	}
+	return a.print(map[string]any{"user_id": userID, "sessions_revoked": revoked})
+}
+
+// resendVerification has Cognito send userID a new confirmation code.
+// Cognito refuses for accounts that are already confirmed.
+func (a *admin) resendVerification(ctx context.Context, userID string) error {
+	user, err := a.user(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if a.DryRun {
+		return a.print(map[string]any{"user_id": userID, "email": user.Email, "dry_run": true})
+	}
+
+	_, err = a.Cognito.ResendConfirmationCode(ctx, &cognitoidentityprovider.ResendConfirmationCodeInput{
+		ClientId: aws.String(a.Config.AppClientIDs[0]),
+		Username: aws.String(userID),
+	})
+	if err != nil {
+		return fmt.Errorf("resending confirmation code: %w", err)
+	}
+	if err := a.record(ctx, userID, audit.ActionVerificationResend, nil); err != nil {
+		return err
+	}
+	return a.print(map[string]any{"user_id": userID, "sent": true})
+}
+
+// user reads the record of userID, or fails with errNoUser.
+func (a *admin) user(ctx context.Context, userID string) (users.User, error) {
+	if err := validation.UserID(userID); err != nil {
+		return users.User{}, err
+	}
+	item, err := a.Users.Get(ctx, userID, nil)
+	if err != nil {
+		return users.User{}, err
+	}
+	if item == nil {
+		return users.User{}, errNoUser
+	}
+	return db.Decode[users.User](item)
+}
+
+// record appends the audit entry of action on userID. A failure is an
+// error, unlike in the functions: an operator must know when their action
+// went unrecorded.
+func (a *admin) record(ctx context.Context, userID, action string, diff map[string]audit.Change) error {
+	if a.DryRun {
+		return nil
+	}
+	err := a.Audit.Record(ctx, audit.Entry{
+		Resource: audit.UserResource(userID),
+		Action:   action,
+		Actor:    audit.Actor{ActorType: audit.ActorOperator, ActorID: a.Operator},
+		Source:   audit.SourceCLI,
+		Diff:     diff,
+	})
+	if err != nil {
+		return fmt.Errorf("%s of %s done, but not audited: %w", action, userID, err)
+	}
+	slog.Info("Audited", "action", action, "user_id", userID, "operator", a.Operator)
+	return nil
+}
+
+// print writes v to Out as indented JSON.
+func (a *admin) print(v any) error {
+	enc := json.NewEncoder(a.Out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
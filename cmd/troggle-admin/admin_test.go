@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/accountstatus"
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/users"
+)
+
+type fakeCognito struct {
+	resent []string
+}
+
+func (f *fakeCognito) AdminUserGlobalSignOut(context.Context, *cognitoidentityprovider.AdminUserGlobalSignOutInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminUserGlobalSignOutOutput, error) {
+	return &cognitoidentityprovider.AdminUserGlobalSignOutOutput{}, nil
+}
+
+func (f *fakeCognito) ResendConfirmationCode(_ context.Context, in *cognitoidentityprovider.ResendConfirmationCodeInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.ResendConfirmationCodeOutput, error) {
+	f.resent = append(f.resent, aws.ToString(in.Username))
+	return &cognitoidentityprovider.ResendConfirmationCodeOutput{}, nil
+}
+
+// newTestAdmin returns an admin over m, where u1 is an active user, and
+// the audit entries it writes and what it prints.
+func newTestAdmin(m *dbtest.Mock, dryRun bool) (*admin, *[]audit.Entry, *bytes.Buffer) {
+	cfg := &config.Config{UserTableName: "users", AuditTableName: "audit", AppClientIDs: []string{"client"}}
+	m.GetItemFunc = func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		key, _ := db.Decode[users.User](in.Key)
+		if key.UserID != "u1" {
+			return &dynamodb.GetItemOutput{}, nil
+		}
+		return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1", "email", "jane@example.com", "status", "active")}, nil
+	}
+	var entries []audit.Entry
+	m.PutItemFunc = func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+		if aws.ToString(in.TableName) == "audit" {
+			e, _ := db.Decode[audit.Entry](in.Item)
+			entries = append(entries, e)
+		}
+		return &dynamodb.PutItemOutput{}, nil
+	}
+	client := m.Client()
+	out := &bytes.Buffer{}
+	return &admin{
+		Users:    users.NewRepository(client, cfg).Uncached().WithDeleted(),
+		Status:   accountstatus.NewStore(client, cfg),
+		Cognito:  &fakeCognito{},
+		Audit:    audit.NewStore(client, cfg),
+		Config:   cfg,
+		Operator: "ops-jane",
+		DryRun:   dryRun,
+		Out:      out,
+	}, &entries, out
+}
+
+func TestSuspend(t *testing.T) {
+	m := &dbtest.Mock{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		return &dynamodb.UpdateItemOutput{}, nil
+	}}
+	a, entries, out := newTestAdmin(m, false)
+	if err := run(context.Background(), a, "suspend", []string{"-reason", "spam", "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	var change accountstatus.Change
+	if err := json.Unmarshal(out.Bytes(), &change); err != nil || change.From != "active" || change.To != "suspended" {
+		t.Errorf("printed %s (%v)", out, err)
+	}
+	if len(*entries) != 1 {
+		t.Fatalf("%d audit entries, want 1", len(*entries))
+	}
+	e := (*entries)[0]
+	if e.Action != audit.ActionUserStatusChange || e.ActorType != audit.ActorOperator || e.ActorID != "ops-jane" || e.Source != audit.SourceCLI {
+		t.Errorf("audit entry = %+v", e)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	m := &dbtest.Mock{}
+	a, entries, out := newTestAdmin(m, true)
+	for _, args := range [][]string{
+		{"suspend", "-reason", "spam", "u1"},
+		{"resend-verification", "u1"},
+		{"lookup", "u1"},
+	} {
+		if err := run(context.Background(), a, args[0], args[1:]); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+	if slices.Contains(m.Ops(), "UpdateItem") || len(*entries) > 0 || len(a.Cognito.(*fakeCognito).resent) > 0 {
+		t.Errorf("dry run wrote: ops %v, %d audit entries", m.Ops(), len(*entries))
+	}
+	if !bytes.Contains(out.Bytes(), []byte(`"dry_run": true`)) {
+		t.Errorf("printed %s", out)
+	}
+
+	// Dry runs still check what they can
+	if err := run(context.Background(), a, "unsuspend", []string{"u1"}); err == nil {
+		t.Error("dry run allowed reactivating an active account")
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	a, entries, _ := newTestAdmin(&dbtest.Mock{}, false)
+	if err := run(context.Background(), a, "resend-verification", []string{"u1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Cognito.(*fakeCognito).resent; !slices.Equal(got, []string{"u1"}) {
+		t.Errorf("resent to %v", got)
+	}
+	if len(*entries) != 1 || (*entries)[0].Action != audit.ActionVerificationResend {
+		t.Errorf("audit entries = %+v", *entries)
+	}
+
+	if err := run(context.Background(), a, "resend-verification", []string{"u2"}); !errors.Is(err, errNoUser) {
+		t.Errorf("err = %v, want %v", err, errNoUser)
+	}
+}
@@ -0,0 +1,145 @@
+// Command troggle-admin runs the operational tasks that have no API of
+// their own, through the same repositories and Cognito calls as the
+// functions, with the environment's configuration:
+//
+//	troggle-admin [-dry-run] [-operator name] <command> [flags] <arg>
+//
+//	lookup <email|user_id>                       print the matching user records
+//	dump <user_id>                               print every item stored about a user
+//	suspend -reason <code> [-note text] <user_id>
+//	unsuspend <user_id>
+//	sign-out <user_id>                           revoke Cognito tokens and sessions
+//	resend-verification <user_id>                send a new Cognito confirmation code
+//
+// Results are printed to stdout as JSON, logs go to stderr. Every command,
+// reads included, is recorded in the audit log with the operator as the
+// actor: -operator, by default $USER. -dry-run reads what a command needs
+// and prints what it would do, writing nothing, audit entries included.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/accountstatus"
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/awscfg"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/events"
+	"troggle-backend/internal/exports"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/sessions"
+	"troggle-backend/internal/users"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "print what a command would do without doing it")
+	operator := flag.String("operator", os.Getenv("USER"), "name recorded as the actor of audit entries")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	if *operator == "" {
+		logging.Fatal("Invalid flags", errors.New("-operator is required when $USER is unset"))
+	}
+
+	ctx := context.Background()
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal("Invalid configuration", err)
+	}
+	a, err := newAdmin(ctx, cfg)
+	if err != nil {
+		logging.Fatal("Error creating clients", err)
+	}
+	a.Operator, a.DryRun = *operator, *dryRun
+
+	if err := run(ctx, a, flag.Arg(0), flag.Args()[1:]); err != nil {
+		logging.Fatal("Command failed", err)
+	}
+}
+
+// newAdmin builds the commands' clients from cfg.
+func newAdmin(ctx context.Context, cfg *config.Config) (*admin, error) {
+	client, err := db.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Load(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &admin{
+		Users:    users.NewRepository(client, cfg).Uncached().WithDeleted(),
+		Status:   accountstatus.NewStore(client, cfg),
+		Sessions: sessions.NewStore(client, cfg),
+		Exports:  &exports.Collector{DB: client, Config: cfg},
+		Cognito:  cognitoidentityprovider.NewFromConfig(awsCfg),
+		Events:   events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Audit:    audit.NewStore(client, cfg),
+		Config:   cfg,
+		Out:      os.Stdout,
+	}, nil
+}
+
+// run parses the flags and argument of command and runs it.
+func run(ctx context.Context, a *admin, command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	reason := fs.String("reason", "", "reason code of the suspension, e.g. spam")
+	note := fs.String("note", "", "free text kept with the record for admins")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%s takes exactly one argument", command)
+	}
+	arg := fs.Arg(0)
+
+	switch command {
+	case "lookup":
+		return a.lookup(ctx, arg)
+	case "dump":
+		return a.dump(ctx, arg)
+	case "suspend":
+		return a.setStatus(ctx, arg, accountstatus.Suspended, *reason, *note)
+	case "unsuspend":
+		return a.setStatus(ctx, arg, accountstatus.Active, "", *note)
+	case "sign-out":
+		if err := a.Config.RequireUserPool(); err != nil {
+			return err
+		}
+		return a.signOut(ctx, arg)
+	case "resend-verification":
+		if err := a.Config.RequireAppClients(); err != nil {
+			return err
+		}
+		return a.resendVerification(ctx, arg)
+	}
+	return fmt.Errorf("unknown command %q", command)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: troggle-admin [-dry-run] [-operator name] <command> [flags] <arg>
+
+commands:
+  lookup <email|user_id>
+  dump <user_id>
+  suspend -reason <code> [-note text] <user_id>
+  unsuspend [-note text] <user_id>
+  sign-out <user_id>
+  resend-verification <user_id>
+
+`)
+	flag.PrintDefaults()
+}
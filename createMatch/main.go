@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/lambda" // Lambda Go runtime
+
+	"troggle-backend/internal/config"                // environment-driven settings
+	"troggle-backend/internal/cors"                  // cross-origin browser access
+	"troggle-backend/internal/functions/creatematch" // handler implementation
+	"troggle-backend/internal/httpx"                 // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"               // structured JSON logging
+	"troggle-backend/internal/maintenance"           // maintenance mode switch
+	"troggle-backend/internal/offload"               // S3 fallback for oversized responses
+)
+
+// main loads and validates the configuration, builds the handler and its
+// clients once at cold start and then starts the Lambda runtime with it
+func main() {
+	logging.Init()
+
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal("Invalid configuration", err)
+	}
+
+	h, err := creatematch.New(context.Background(), cfg)
+	if err != nil {
+		logging.Fatal("Error initializing handler", err)
+	}
+	lambda.Start(httpx.Adapt(maintenance.Guard(cfg, h.HTTP()), cors.Middleware(cfg), offload.Middleware(cfg)))
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda" // Lambda Go runtime
+
+	"troggle-backend/internal/functions/defineauthchallenge" // handler implementation
+	"troggle-backend/internal/logging"                       // structured JSON logging
+)
+
+// main starts the Lambda runtime with the handler, which needs neither
+// configuration nor clients
+func main() {
+	logging.Init()
+	lambda.Start(defineauthchallenge.Handle)
+}
@@ -0,0 +1,258 @@
+// Package accountstatus keeps the lifecycle of accounts: the status
+// attribute of the user record and the transitions admins may make between
+// its values.
+//
+//	pending ──▶ active ◀──▶ suspended
+//	   │          ▲ │           │
+//	   │          │ ▼           │
+//	   └──────▶ banned ◀────────┘
+//
+// Suspensions and bans carry a reason code, which callers see when the auth
+// middleware turns their requests away with a 403: Checker rejects the
+// tokens of suspended and banned users. Deletion has statuses of its own,
+// outside this lifecycle; see package erasure.
+package accountstatus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/users"
+)
+
+// Statuses of the lifecycle.
+const (
+	Pending   = "pending"   // signed up, not yet allowed in
+	Active    = "active"    // in good standing
+	Suspended = "suspended" // locked out for now
+	Banned    = "banned"    // locked out for good, unless an admin reactivates it
+)
+
+// transitions lists the statuses each status may move to.
+var transitions = map[string][]string{
+	Pending:   {Active, Banned},
+	Active:    {Suspended, Banned},
+	Suspended: {Active, Banned},
+	Banned:    {Active},
+}
+
+// CanTransition reports whether an account may move from status from to
+// status to.
+func CanTransition(from, to string) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+// reasonCode is the form of reason codes, e.g. "spam" or "chargeback".
+var reasonCode = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// MaxNoteLength bounds the free-text note of a transition, kept for admins.
+const MaxNoteLength = 500
+
+// maxAttempts bounds how often Transition re-reads and retries after the
+// status changed under it.
+const maxAttempts = 3
+
+// ErrConflict is returned when the status kept changing under Transition.
+var ErrConflict = errors.New("account status was modified concurrently")
+
+// Change is one transition of an account.
+type Change struct {
+	UserID    string `json:"user_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason,omitempty"`
+	ChangedAt string `json:"changed_at"` // RFC 3339
+}
+
+// state is the lifecycle part of a user record.
+type state struct {
+	Status string `dynamodbav:"status"`
+	Reason string `dynamodbav:"status_reason"`
+}
+
+// Store changes the status of user records.
+type Store struct {
+	DB    *db.Client
+	Table string
+}
+
+// NewStore returns a store over the user table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.UserTableName}
+}
+
+// Transition moves the account of userID to status to, for the reason code
+// reason, which suspensions and bans require and reactivations drop. note is
+// kept with the record for admins. A missing user is an apperr.NotFound
+// error; a transition the lifecycle does not allow is an apperr.Invalid one.
+func (s *Store) Transition(ctx context.Context, userID, to, reason, note string, now time.Time) (Change, error) {
+	if err := validate(to, reason, note); err != nil {
+		return Change{}, err
+	}
+	if to == Active {
+		reason = ""
+	}
+	stamp := now.UTC().Format(time.RFC3339)
+
+	for range maxAttempts {
+		current, err := s.get(ctx, userID)
+		if err != nil {
+			return Change{}, err
+		}
+		if current == nil {
+			return Change{}, apperr.NotFound("User not found")
+		}
+		if !CanTransition(current.Status, to) {
+			return Change{}, apperr.Invalid("INVALID_TRANSITION", "status", fmt.Sprintf("cannot change status from %q to %q", current.Status, to))
+		}
+
+		set, remove := []string{"#status = :to", "status_changed_at = :now", "updated_at = :now"}, []string{}
+		values := map[string]types.AttributeValue{
+			":to":   &types.AttributeValueMemberS{Value: to},
+			":from": &types.AttributeValueMemberS{Value: current.Status},
+			":now":  &types.AttributeValueMemberS{Value: stamp},
+		}
+		for _, attr := range []struct{ name, placeholder, value string }{
+			{"status_reason", ":reason", reason},
+			{"status_note", ":note", note},
+		} {
+			if attr.value == "" {
+				remove = append(remove, attr.name)
+				continue
+			}
+			set = append(set, attr.name+" = "+attr.placeholder)
+			values[attr.placeholder] = &types.AttributeValueMemberS{Value: attr.value}
+		}
+		expr := "SET " + strings.Join(set, ", ")
+		if len(remove) > 0 {
+			expr += " REMOVE " + strings.Join(remove, ", ")
+		}
+
+		input := &dynamodb.UpdateItemInput{
+			TableName:           aws.String(s.Table),
+			Key:                 users.Key(userID),
+			UpdateExpression:    aws.String(expr),
+			ConditionExpression: aws.String("#status = :from"),
+			ExpressionAttributeNames: map[string]string{
+				"#status": "status", // reserved word
+			},
+			ExpressionAttributeValues: values,
+		}
+
+		start := time.Now()
+		_, err = s.DB.DynamoDB.UpdateItem(ctx, input)
+		db.Observe(ctx, start, err)
+		if db.ConditionFailed(err, -1) {
+			continue
+		}
+		if err != nil {
+			return Change{}, db.Wrap(err, "changing status of "+userID)
+		}
+		return Change{UserID: userID, From: current.Status, To: to, Reason: reason, ChangedAt: stamp}, nil
+	}
+	return Change{}, ErrConflict
+}
+
+// validate checks the target status and the reason code and note of a
+// transition to it.
+func validate(to, reason, note string) error {
+	if _, ok := transitions[to]; !ok || to == Pending {
+		return apperr.Invalid("INVALID_STATUS", "status", fmt.Sprintf("status must be one of %q, %q or %q", Active, Suspended, Banned))
+	}
+	if to != Active && !reasonCode.MatchString(reason) {
+		return apperr.Invalid("INVALID_REASON", "reason", "reason must be a lowercase code of letters, digits and underscores")
+	}
+	if len(note) > MaxNoteLength {
+		return apperr.Invalid("NOTE_TOO_LONG", "note", fmt.Sprintf("note must be at most %d bytes", MaxNoteLength))
+	}
+	return nil
+}
+
+// get reads the lifecycle state of userID, or nil if there is no such user.
+// The read is strongly consistent: Transition bases its condition on it.
+func (s *Store) get(ctx context.Context, userID string) (*state, error) {
+	start := time.Now()
+	result, err := s.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:            aws.String(s.Table),
+		Key:                  users.Key(userID),
+		ProjectionExpression: aws.String("#status, status_reason, " + users.DeletedAttribute),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return nil, db.Wrap(err, "getting user "+userID)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	if _, deleted := result.Item[users.DeletedAttribute]; deleted {
+		return nil, nil
+	}
+	var st state
+	if err := attributevalue.UnmarshalMap(result.Item, &st); err != nil {
+		return nil, fmt.Errorf("decoding user item: %w", err)
+	}
+	return &st, nil
+}
+
+// Checker rejects the tokens of suspended and banned users, implementing
+// auth.StatusChecker. Records are read through the repository cache, so a
+// suspension takes effect in other containers within CACHE_TTL.
+type Checker struct {
+	Users *users.Repository
+}
+
+// NewChecker returns a checker over the user table named in cfg.
+func NewChecker(client *db.Client, cfg *config.Config) *Checker {
+	return &Checker{Users: users.NewRepository(client, cfg)}
+}
+
+// Check returns an apperr.Forbidden error, coded ACCOUNT_SUSPENDED or
+// ACCOUNT_BANNED, if the account of id is locked out. Users without a record
+// yet, on their first sign-in, are let through.
+func (c *Checker) Check(ctx context.Context, id *auth.Identity) error {
+	item, err := c.Users.Get(ctx, id.Subject, []string{"status", "status_reason"})
+	if err != nil || item == nil {
+		return err
+	}
+	var st state
+	if err := attributevalue.UnmarshalMap(item, &st); err != nil {
+		return fmt.Errorf("decoding user item: %w", err)
+	}
+	return Rejection(st.Status, st.Reason)
+}
+
+// Rejection returns the apperr.Forbidden error of requests by accounts of
+// the given status and reason code, or nil if the status lets them in.
+func Rejection(status, reason string) error {
+	var code, message string
+	switch status {
+	case Suspended:
+		code, message = "ACCOUNT_SUSPENDED", "Account suspended"
+	case Banned:
+		code, message = "ACCOUNT_BANNED", "Account banned"
+	default:
+		return nil
+	}
+	if reason != "" {
+		message += " (" + reason + ")"
+	}
+	return &apperr.Error{Kind: apperr.KindForbidden, Code: code, Field: "status", Message: message}
+}
@@ -0,0 +1,152 @@
+package accountstatus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+var now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		name     string
+		stored   []db.Item // returned by successive reads
+		to       string
+		reason   string
+		note     string
+		fail     int // leading updates to fail their condition
+		wantExpr string
+		wantFrom string
+		wantErr  error
+		wantKind apperr.Kind
+	}{
+		{
+			name:   "suspend",
+			stored: []db.Item{dbtest.Item("user_id", "u1", "status", Active)},
+			to:     Suspended, reason: "spam", note: "reported twice",
+			wantExpr: "SET #status = :to, status_changed_at = :now, updated_at = :now, status_reason = :reason, status_note = :note",
+			wantFrom: Active,
+		},
+		{
+			name:   "reactivate drops the reason",
+			stored: []db.Item{dbtest.Item("user_id", "u1", "status", Suspended, "status_reason", "spam")},
+			to:     Active, reason: "spam",
+			wantExpr: "SET #status = :to, status_changed_at = :now, updated_at = :now REMOVE status_reason, status_note",
+			wantFrom: Suspended,
+		},
+		{
+			name:   "changed under it",
+			stored: []db.Item{dbtest.Item("user_id", "u1", "status", Active), dbtest.Item("user_id", "u1", "status", Suspended)},
+			to:     Banned, reason: "fraud", fail: 1,
+			wantExpr: "SET #status = :to, status_changed_at = :now, updated_at = :now, status_reason = :reason REMOVE status_note",
+			wantFrom: Suspended,
+		},
+		{
+			name:   "keeps changing",
+			stored: []db.Item{dbtest.Item("user_id", "u1", "status", Active)},
+			to:     Banned, reason: "fraud", fail: maxAttempts,
+			wantErr: ErrConflict,
+		},
+		{name: "missing", to: Banned, reason: "fraud", wantKind: apperr.KindNotFound},
+		{
+			name:     "deleted",
+			stored:   []db.Item{dbtest.Item("user_id", "u1", "status", "deleted", "deleted_at", "2024-04-20T00:00:00Z")},
+			to:       Active,
+			wantKind: apperr.KindNotFound,
+		},
+		{
+			name:   "not allowed",
+			stored: []db.Item{dbtest.Item("user_id", "u1", "status", Pending)},
+			to:     Suspended, reason: "spam",
+			wantKind: apperr.KindInvalid,
+		},
+		{name: "back to pending", to: Pending, wantKind: apperr.KindInvalid},
+		{name: "no reason", to: Suspended, wantKind: apperr.KindInvalid},
+		{name: "malformed reason", to: Banned, reason: "Terms of service", wantKind: apperr.KindInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reads, updates int
+			var got *dynamodb.UpdateItemInput
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					if !aws.ToBool(in.ConsistentRead) {
+						t.Error("status read without ConsistentRead")
+					}
+					out := &dynamodb.GetItemOutput{}
+					if len(tt.stored) > 0 {
+						out.Item = tt.stored[min(reads, len(tt.stored)-1)]
+					}
+					reads++
+					return out, nil
+				},
+				UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					got = in
+					if updates++; updates <= tt.fail {
+						return nil, dbtest.ConditionFailed()
+					}
+					return &dynamodb.UpdateItemOutput{}, nil
+				},
+			}
+			s := &Store{DB: m.Client(), Table: "users"}
+
+			change, err := s.Transition(context.Background(), "u1", tt.to, tt.reason, tt.note, now)
+			if tt.wantErr != nil || tt.wantKind != 0 {
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantKind != 0 && apperr.KindOf(err) != tt.wantKind {
+					t.Fatalf("err = %v, want kind %v", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expr := aws.ToString(got.UpdateExpression); expr != tt.wantExpr {
+				t.Errorf("update = %q, want %q", expr, tt.wantExpr)
+			}
+			if change.From != tt.wantFrom || change.To != tt.to || change.ChangedAt != "2024-05-01T12:00:00Z" {
+				t.Errorf("change = %+v, want from %s to %s", change, tt.wantFrom, tt.to)
+			}
+		})
+	}
+}
+
+func TestRejection(t *testing.T) {
+	tests := []struct {
+		status, reason string
+		wantCode       string
+		wantMessage    string
+	}{
+		{status: Active},
+		{status: Pending},
+		{status: ""},
+		{status: Suspended, reason: "spam", wantCode: "ACCOUNT_SUSPENDED", wantMessage: "Account suspended (spam)"},
+		{status: Banned, wantCode: "ACCOUNT_BANNED", wantMessage: "Account banned"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			err := Rejection(tt.status, tt.reason)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+			var e *apperr.Error
+			if !errors.As(err, &e) || e.Kind != apperr.KindForbidden || e.Code != tt.wantCode || e.Message != tt.wantMessage {
+				t.Errorf("err = %#v, want a forbidden %s error %q", err, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Package achievements is the badge engine. The achievement table holds the
+// definitions, keyed by achievement_id: what to show, the domain event
+// (EventBridge detail-type) that advances the achievement, and its goal, the
+// number of those events that unlocks it. The user achievement table holds
+// each user's progress, keyed by user_id + achievement_id.
+//
+// The grantAchievement function consumes the domain events and advances
+// every achievement they trigger for the event's user. EventBridge delivers
+// events at least once, so the IDs of the events counted are kept on the
+// progress item until the achievement unlocks, and a redelivered event does
+// not count twice. An achievement unlocks once; the caller that unlocks it
+// is told, and notifies the user.
+package achievements
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+// catalogCacheKey is the cache key of the definitions.
+const catalogCacheKey = "achievements:definitions"
+
+// Definition is an achievement users can unlock.
+type Definition struct {
+	ID          string `json:"achievement_id" dynamodbav:"achievement_id"`
+	Name        string `json:"name" dynamodbav:"name"`
+	Description string `json:"description" dynamodbav:"description"`
+	IconURL     string `json:"icon_url,omitempty" dynamodbav:"icon_url,omitempty"`
+	Trigger     string `json:"-" dynamodbav:"trigger"` // detail-type of the events advancing it
+	Goal        int    `json:"goal" dynamodbav:"goal"`
+	Hidden      bool   `json:"hidden,omitempty" dynamodbav:"hidden,omitempty"` // left out of listings until unlocked
+}
+
+// goal returns the events needed to unlock d; definitions without a goal
+// unlock on their first.
+func (d Definition) goal() int {
+	return max(d.Goal, 1)
+}
+
+// Achievement is a user's progress on a definition.
+type Achievement struct {
+	Definition
+	Progress   int        `json:"progress"`
+	UnlockedAt *time.Time `json:"unlocked_at,omitempty"`
+}
+
+// progress is an item of the user achievement table.
+type progress struct {
+	UserID        string `dynamodbav:"user_id"`
+	AchievementID string `dynamodbav:"achievement_id"`
+	Progress      int    `dynamodbav:"progress"`
+	UnlockedAt    string `dynamodbav:"unlocked_at,omitempty"` // RFC 3339
+}
+
+// Store reads the definitions and reads and writes the progress of users.
+type Store struct {
+	DB            *db.Client
+	Table         string    // definitions
+	ProgressTable string    // progress of users
+	Cache         *db.Cache // holds the definitions; nil reads them every time
+}
+
+// NewStore returns a store over the tables named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{
+		DB:            client,
+		Table:         cfg.AchievementTableName,
+		ProgressTable: cfg.UserAchievementTableName,
+		Cache:         db.SharedCache(cfg),
+	}
+}
+
+// Definitions returns every definition, ordered by ID. The table is a small
+// catalog, so it is scanned, and the result cached for the cache's TTL.
+func (s *Store) Definitions(ctx context.Context) ([]Definition, error) {
+	if cached, ok := s.Cache.Get(ctx, catalogCacheKey); ok {
+		return cached.([]Definition), nil
+	}
+
+	var defs []Definition
+	paginator := dynamodb.NewScanPaginator(s.DB.DynamoDB, &dynamodb.ScanInput{TableName: aws.String(s.Table)})
+	for paginator.HasMorePages() {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		db.Observe(ctx, start, err)
+		if err != nil {
+			return nil, db.Wrap(err, "reading achievement definitions")
+		}
+		var batch []Definition
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("decoding achievement definitions: %w", err)
+		}
+		defs = append(defs, batch...)
+	}
+	slices.SortFunc(defs, func(a, b Definition) int { return strings.Compare(a.ID, b.ID) })
+
+	s.Cache.Set(catalogCacheKey, defs)
+	return defs, nil
+}
+
+// Lookup returns the definition of achievementID, reporting false when there
+// is none.
+func (s *Store) Lookup(ctx context.Context, achievementID string) (Definition, bool, error) {
+	defs, err := s.Definitions(ctx)
+	if err != nil {
+		return Definition{}, false, err
+	}
+	i := slices.IndexFunc(defs, func(d Definition) bool { return d.ID == achievementID })
+	if i < 0 {
+		return Definition{}, false, nil
+	}
+	return defs[i], true, nil
+}
+
+// Triggered returns the definitions advanced by events of detailType.
+func (s *Store) Triggered(ctx context.Context, detailType string) ([]Definition, error) {
+	defs, err := s.Definitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var triggered []Definition
+	for _, d := range defs {
+		if d.Trigger == detailType {
+			triggered = append(triggered, d)
+		}
+	}
+	return triggered, nil
+}
+
+// Advance counts event eventID towards def for userID and reports whether
+// it unlocked the achievement. Events already counted, and achievements
+// already unlocked, change nothing. When an earlier call counted the last
+// event but failed to unlock, counting the event again finishes the unlock.
+func (s *Store) Advance(ctx context.Context, userID string, def Definition, eventID string, now time.Time) (bool, error) {
+	start := time.Now()
+	out, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.ProgressTable),
+		Key:                 key(userID, def.ID),
+		UpdateExpression:    aws.String("ADD progress :one, event_ids :event_ids SET updated_at = :now"),
+		ConditionExpression: aws.String("attribute_not_exists(unlocked_at) AND NOT contains(event_ids, :event_id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":       &types.AttributeValueMemberN{Value: "1"},
+			":event_ids": &types.AttributeValueMemberSS{Value: []string{eventID}},
+			":event_id":  &types.AttributeValueMemberS{Value: eventID},
+			":now":       &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		},
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	db.Observe(ctx, start, err)
+
+	var item db.Item
+	var failed *types.ConditionalCheckFailedException
+	switch {
+	case errors.As(err, &failed):
+		item = failed.Item
+	case err != nil:
+		return false, db.Wrap(err, "advancing achievement")
+	default:
+		item = out.Attributes
+	}
+
+	var p progress
+	if err := attributevalue.UnmarshalMap(item, &p); err != nil {
+		return false, fmt.Errorf("decoding achievement progress: %w", err)
+	}
+	if p.UnlockedAt != "" || p.Progress < def.goal() {
+		return false, nil
+	}
+	return s.unlock(ctx, userID, def, now, "attribute_not_exists(unlocked_at) AND progress >= :goal")
+}
+
+// Grant unlocks def for userID outright, whatever their progress, and
+// reports whether it did; granting an unlocked achievement again does
+// nothing. It is for achievements awarded by a decision rather than
+// counted events, such as those of beta testers.
+func (s *Store) Grant(ctx context.Context, userID string, def Definition, now time.Time) (bool, error) {
+	return s.unlock(ctx, userID, def, now, "attribute_not_exists(unlocked_at)")
+}
+
+// unlock marks def unlocked for userID if condition holds, and reports
+// whether it did. The event IDs are dropped: nothing counts any more.
+func (s *Store) unlock(ctx context.Context, userID string, def Definition, now time.Time, condition string) (bool, error) {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.ProgressTable),
+		Key:                 key(userID, def.ID),
+		UpdateExpression:    aws.String("SET progress = :goal, unlocked_at = :now, updated_at = :now REMOVE event_ids"),
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":goal": &types.AttributeValueMemberN{Value: fmt.Sprint(def.goal())},
+			":now":  &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		},
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return false, nil
+	}
+	if err != nil {
+		return false, db.Wrap(err, "unlocking achievement")
+	}
+	return true, nil
+}
+
+// List returns the achievements of userID with their progress, in the
+// order of the definitions. Hidden achievements are left out until the
+// user unlocks them.
+func (s *Store) List(ctx context.Context, userID string) ([]Achievement, error) {
+	defs, err := s.Definitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]progress{}
+	var decodeErr error
+	err = s.DB.QueryPages(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.ProgressTable),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ProjectionExpression: aws.String("user_id, achievement_id, progress, unlocked_at"),
+	}, func(items []db.Item) bool {
+		var page []progress
+		if decodeErr = attributevalue.UnmarshalListOfMaps(items, &page); decodeErr != nil {
+			return false
+		}
+		for _, p := range page {
+			byID[p.AchievementID] = p
+		}
+		return true
+	})
+	if err == nil && decodeErr != nil {
+		err = fmt.Errorf("decoding achievement progress: %w", decodeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Achievement{}
+	for _, d := range defs {
+		p := byID[d.ID]
+		a := Achievement{Definition: d, Progress: min(p.Progress, d.goal())}
+		if t, err := time.Parse(time.RFC3339, p.UnlockedAt); err == nil {
+			a.UnlockedAt = &t
+		}
+		if d.Hidden && a.UnlockedAt == nil {
+			continue
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// key returns the primary key of a progress item.
+func key(userID, achievementID string) db.Item {
+	return db.Item{
+		"user_id":        &types.AttributeValueMemberS{Value: userID},
+		"achievement_id": &types.AttributeValueMemberS{Value: achievementID},
+	}
+}
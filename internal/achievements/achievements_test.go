@@ -0,0 +1,167 @@
+package achievements
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+// record returns a progress item.
+func record(achievementID, n, unlockedAt string) db.Item {
+	item := dbtest.Item("user_id", "u1", "achievement_id", achievementID)
+	item["progress"] = &types.AttributeValueMemberN{Value: n}
+	if unlockedAt != "" {
+		item["unlocked_at"] = &types.AttributeValueMemberS{Value: unlockedAt}
+	}
+	return item
+}
+
+// definition returns a definition item.
+func definition(id, trigger, goal string, hidden bool) db.Item {
+	item := dbtest.Item("achievement_id", id, "name", id, "trigger", trigger)
+	item["goal"] = &types.AttributeValueMemberN{Value: goal}
+	if hidden {
+		item["hidden"] = &types.AttributeValueMemberBOOL{Value: true}
+	}
+	return item
+}
+
+func TestAdvance(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	def := Definition{ID: "ten_games", Trigger: "GameFinished", Goal: 3}
+	tests := []struct {
+		name         string
+		after        db.Item // returned by the count
+		failed       db.Item // returned by a count that failed its condition
+		unlockErr    error
+		wantUnlocked bool
+		wantOps      []string
+	}{
+		{name: "counts", after: record("ten_games", "1", ""), wantOps: []string{"UpdateItem"}},
+		{name: "reaches the goal", after: record("ten_games", "3", ""), wantUnlocked: true, wantOps: []string{"UpdateItem", "UpdateItem"}},
+		{name: "counted already", failed: record("ten_games", "1", ""), wantOps: []string{"UpdateItem"}},
+		{name: "finishes an unlock", failed: record("ten_games", "3", ""), wantUnlocked: true, wantOps: []string{"UpdateItem", "UpdateItem"}},
+		{name: "unlocked already", failed: record("ten_games", "3", "2026-10-01T00:00:00Z"), wantOps: []string{"UpdateItem"}},
+		{name: "unlocked concurrently", after: record("ten_games", "3", ""), unlockErr: dbtest.ConditionFailed(), wantOps: []string{"UpdateItem", "UpdateItem"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var unlock *dynamodb.UpdateItemInput
+			m := &dbtest.Mock{UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				if _, counting := in.ExpressionAttributeValues[":event_id"]; !counting {
+					unlock = in
+					return &dynamodb.UpdateItemOutput{}, tt.unlockErr
+				}
+				if got := in.ExpressionAttributeValues[":event_ids"].(*types.AttributeValueMemberSS).Value; !slices.Equal(got, []string{"e1"}) {
+					t.Errorf("event IDs = %v", got)
+				}
+				if tt.failed != nil {
+					return nil, &types.ConditionalCheckFailedException{Item: tt.failed}
+				}
+				return &dynamodb.UpdateItemOutput{Attributes: tt.after}, nil
+			}}
+			s := &Store{DB: m.Client(), Table: "achievements", ProgressTable: "progress"}
+
+			unlocked, err := s.Advance(context.Background(), "u1", def, "e1", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unlocked != tt.wantUnlocked {
+				t.Errorf("unlocked = %v, want %v", unlocked, tt.wantUnlocked)
+			}
+			if ops := m.Ops(); !slices.Equal(ops, tt.wantOps) {
+				t.Errorf("ops = %v, want %v", ops, tt.wantOps)
+			}
+			if unlock != nil && unlock.ExpressionAttributeValues[":goal"].(*types.AttributeValueMemberN).Value != "3" {
+				t.Errorf("unlock sets progress to %v, want the goal", unlock.ExpressionAttributeValues[":goal"])
+			}
+		})
+	}
+}
+
+func TestGrant(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantUnlocked bool
+	}{
+		{name: "unlocks", wantUnlocked: true},
+		{name: "unlocked already", err: dbtest.ConditionFailed()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				return &dynamodb.UpdateItemOutput{}, tt.err
+			}}
+			s := &Store{DB: m.Client(), Table: "achievements", ProgressTable: "progress"}
+
+			unlocked, err := s.Grant(context.Background(), "u1", Definition{ID: "beta_tester"}, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unlocked != tt.wantUnlocked {
+				t.Errorf("unlocked = %v, want %v", unlocked, tt.wantUnlocked)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	m := &dbtest.Mock{
+		ScanFunc: func(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+			return &dynamodb.ScanOutput{Items: []db.Item{
+				definition("first_win", "GameWon", "1", false),
+				definition("ten_games", "GameFinished", "10", false),
+				definition("secret", "GameLost", "1", true),
+				definition("night_owl", "GameFinished", "1", true),
+			}}, nil
+		},
+		QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			return &dynamodb.QueryOutput{Items: []db.Item{
+				record("ten_games", "4", ""),
+				record("night_owl", "1", "2026-10-01T00:00:00Z"),
+			}}, nil
+		},
+	}
+	s := &Store{DB: m.Client(), Table: "achievements", ProgressTable: "progress", Cache: db.NewCache(10, time.Minute)}
+
+	list, err := s.List(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range list {
+		got = append(got, a.ID)
+	}
+	if want := []string{"first_win", "night_owl", "ten_games"}; !slices.Equal(got, want) {
+		t.Fatalf("achievements = %v, want %v", got, want)
+	}
+	if list[0].Progress != 0 || list[0].UnlockedAt != nil {
+		t.Errorf("first_win = %+v, want no progress", list[0])
+	}
+	if list[1].UnlockedAt == nil {
+		t.Errorf("night_owl = %+v, want unlocked", list[1])
+	}
+	if list[2].Progress != 4 {
+		t.Errorf("ten_games progress = %d, want 4", list[2].Progress)
+	}
+
+	// The definitions are cached
+	triggered, err := s.Triggered(context.Background(), "GameFinished")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggered) != 2 {
+		t.Errorf("triggered = %+v, want ten_games and night_owl", triggered)
+	}
+	if ops := m.Ops(); !slices.Equal(ops, []string{"Scan", "Query"}) {
+		t.Errorf("ops = %v, want one scan", ops)
+	}
+}
@@ -0,0 +1,92 @@
+// Package api describes the HTTP API the functions serve. Each function
+// package declares its routes, with their request and response types and
+// the access they require, in a Routes variable:
+//
+//	var Routes = []api.Route{{
+//		Name: "createMatch", Function: "createMatch",
+//		Method: "POST", Path: "/matches",
+//		Request: Request{}, Status: 201, Response: matches.Match{},
+//	}}
+//
+// and cmd/genopenapi turns every declaration into the OpenAPI document
+// client SDKs and the API Gateway definition are generated from. Its tests
+// check the declarations against the authorizer's routes.json, so the
+// document cannot drift from what is deployed.
+//
+// Request and response types are described from their json tags, and
+// request types also from their validate tags (see package validation).
+// Request fields named after a path parameter, and fields tagged
+// api:"direct" that only direct invocations set, are left out of the
+// document.
+package api
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Route is one API Gateway resource served by a function.
+type Route struct {
+	Name     string   // operation ID, unique across the API, e.g. "blockUser"
+	Function string   // Lambda serving the route, e.g. "blockUser"
+	Summary  string   // one line describing what the route does
+	Method   string   // HTTP method
+	Path     string   // resource template, e.g. /users/{user_id}
+	Query    []string // names of the query string parameters
+	Request  any      // value of the JSON body's type; nil when there is none
+	Status   int      // status of success; 200 when zero
+	Response any      // value of the success body's type; nil when there is none
+
+	// Scopes and Groups are the access the route requires, as in the
+	// authorizer's routes.json: with neither, any signed-in caller may use
+	// it, otherwise callers need one of the scopes or groups.
+	Scopes []string
+	Groups []string
+	APIKey bool // also accepts API keys; see package apikeys
+
+	// Public routes take no credentials: API Gateway does not call the
+	// authorizer, and the function must guard itself, e.g. with per-IP
+	// rate limits. They have no Scopes, Groups or APIKey.
+	Public bool
+}
+
+// pathParam matches the {name} wildcards of a route path.
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// PathParams returns the names of the path parameters of r.
+func (r Route) PathParams() []string {
+	var names []string
+	for _, m := range pathParam.FindAllStringSubmatch(r.Path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// SuccessStatus returns the status of success of r.
+func (r Route) SuccessStatus() int {
+	if r.Status == 0 {
+		return 200
+	}
+	return r.Status
+}
+
+// Check reports routes declared twice and operation IDs used twice.
+func Check(routes []Route) error {
+	seen := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, r := range routes {
+		key := r.Method + " " + r.Path
+		switch {
+		case r.Name == "" || r.Function == "" || r.Method == "" || r.Path == "":
+			return fmt.Errorf("route %q: name, function, method and path are required", key)
+		case seen[key]:
+			return fmt.Errorf("route %s declared twice", key)
+		case names[r.Name]:
+			return fmt.Errorf("operation %s declared twice", r.Name)
+		case r.Public && (len(r.Scopes) > 0 || len(r.Groups) > 0 || r.APIKey):
+			return fmt.Errorf("route %s: public routes take no scopes, groups or API keys", key)
+		}
+		seen[key], names[r.Name] = true, true
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"troggle-backend/internal/httpx"
+)
+
+// Document is an OpenAPI 3 document.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+	Security   []map[string][]string            `json:"security"`
+}
+
+// Info describes the API.
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Components holds the schemas and security schemes operations refer to.
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+// SecurityScheme is a way of authenticating.
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+// Operation is one method of a path.
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *Body                 `json:"requestBody,omitempty"`
+	Responses   map[string]*Body      `json:"responses"`
+	Security    []map[string][]string `json:"security,omitzero"`  // empty for public routes
+	Scopes      []string              `json:"x-scopes,omitempty"` // see Route
+	Groups      []string              `json:"x-groups,omitempty"`
+}
+
+// Parameter is a path or query string parameter.
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// Body is a request body or a response.
+type Body struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body of one content type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema describes a JSON value.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	MinLength            *float64           `json:"minLength,omitempty"`
+	MaxLength            *float64           `json:"maxLength,omitempty"`
+	MinItems             *float64           `json:"minItems,omitempty"`
+	MaxItems             *float64           `json:"maxItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+// Security scheme names.
+const (
+	schemeBearer = "bearer"
+	schemeAPIKey = "apiKey"
+)
+
+// errorSchema is the name of the schema of error responses.
+const errorSchema = "Error"
+
+// OpenAPI returns the document describing routes. Call Check first.
+func OpenAPI(info Info, routes []Route) *Document {
+	g := &generator{schemas: map[string]*Schema{}}
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   map[string]map[string]*Operation{},
+		Components: Components{
+			Schemas: g.schemas,
+			SecuritySchemes: map[string]*SecurityScheme{
+				schemeBearer: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+				schemeAPIKey: {Type: "apiKey", In: "header", Name: "X-Api-Key"},
+			},
+		},
+		Security: []map[string][]string{{schemeBearer: {}}},
+	}
+	g.schemas[errorSchema] = g.schema(reflect.TypeFor[httpx.ErrorBody](), nil)
+
+	for _, r := range routes {
+		if doc.Paths[r.Path] == nil {
+			doc.Paths[r.Path] = map[string]*Operation{}
+		}
+		doc.Paths[r.Path][strings.ToLower(r.Method)] = g.operation(r)
+	}
+	return doc
+}
+
+// generator builds the schemas of a document.
+type generator struct {
+	schemas map[string]*Schema // named schemas, by component name
+}
+
+// operation describes r.
+func (g *generator) operation(r Route) *Operation {
+	op := &Operation{
+		OperationID: r.Name,
+		Summary:     r.Summary,
+		Tags:        []string{r.Function},
+		Scopes:      r.Scopes,
+		Groups:      r.Groups,
+		Responses: map[string]*Body{
+			"default": {Description: "Error", Content: jsonContent(&Schema{Ref: ref(errorSchema)})},
+		},
+	}
+	switch {
+	case r.APIKey:
+		op.Security = []map[string][]string{{schemeBearer: {}}, {schemeAPIKey: {}}}
+	case r.Public:
+		op.Security = []map[string][]string{}
+	}
+
+	params := r.PathParams()
+	for _, name := range params {
+		op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	for _, name := range r.Query {
+		op.Parameters = append(op.Parameters, Parameter{Name: name, In: "query", Schema: &Schema{Type: "string"}})
+	}
+
+	if r.Request != nil {
+		// Inline, as the fields left out depend on the route
+		s := g.schema(reflect.TypeOf(r.Request), params)
+		op.RequestBody = &Body{Required: len(s.Required) > 0, Content: jsonContent(s)}
+	}
+
+	status := r.SuccessStatus()
+	success := &Body{Description: http.StatusText(status)}
+	if r.Response != nil {
+		success.Content = jsonContent(g.ref(reflect.TypeOf(r.Response)))
+	}
+	op.Responses[strconv.Itoa(status)] = success
+	return op
+}
+
+// ref returns a reference to the named schema of t, adding it to the
+// components, or the inline schema of unnamed types.
+func (g *generator) ref(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Name() == "" || t == reflect.TypeFor[time.Time]() {
+		return g.schema(t, nil)
+	}
+	name := schemaName(t)
+	if _, ok := g.schemas[name]; !ok {
+		g.schemas[name] = nil // placeholder, for recursive types
+		g.schemas[name] = g.schema(t, nil)
+	}
+	return &Schema{Ref: ref(name)}
+}
+
+// schema returns the inline schema of t, leaving out struct fields named in
+// omit.
+func (g *generator) schema(t reflect.Type, omit []string) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return &Schema{Type: "string", Format: "date-time"}
+	case reflect.TypeFor[json.RawMessage]():
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.ref(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.ref(t.Elem())}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		g.fields(s, t, omit)
+		return s
+	}
+	// Interfaces: any JSON value
+	return &Schema{}
+}
+
+// fields adds the properties of struct t to s.
+func (g *generator) fields(s *Schema, t reflect.Type, omit []string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(s, ft, omit)
+				continue
+			}
+		}
+		if !f.IsExported() || name == "-" || f.Tag.Get("api") == "direct" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if slices.Contains(omit, name) {
+			continue
+		}
+
+		p := g.ref(f.Type)
+		if rules(f.Tag.Get("validate"), p) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = p
+	}
+}
+
+// rules applies the validate tag of a field to its schema s, which must be
+// inline for anything but required, and reports whether it is required.
+func rules(tag string, s *Schema) (required bool) {
+	if tag == "" {
+		return false
+	}
+	for _, spec := range strings.Split(tag, ",") {
+		rule, arg, _ := strings.Cut(spec, "=")
+		n, _ := strconv.ParseFloat(arg, 64)
+		switch {
+		case rule == "required":
+			required = true
+		case rule == "email":
+			s.Format = "email"
+		case rule == "oneof":
+			s.Enum = strings.Fields(arg)
+		case s.Type == "string" && rule == "min":
+			s.MinLength = &n
+		case s.Type == "string" && rule == "max":
+			s.MaxLength = &n
+		case s.Type == "array" && rule == "min":
+			s.MinItems = &n
+		case s.Type == "array" && rule == "max":
+			s.MaxItems = &n
+		case rule == "min":
+			s.Minimum = &n
+		case rule == "max":
+			s.Maximum = &n
+		}
+	}
+	return required
+}
+
+// schemaName returns the component name of the named type t, e.g.
+// "matches.Match".
+func schemaName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	return pkg[strings.LastIndex(pkg, "/")+1:] + "." + t.Name()
+}
+
+// ref returns the reference to the named schema.
+func ref(name string) string {
+	return "#/components/schemas/" + name
+}
+
+// jsonContent returns the content of a JSON body described by s.
+func jsonContent(s *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+}
+
+type testRequest struct {
+	UserID string            `json:"user_id"`
+	Caller string            `json:"caller" api:"direct"`
+	Name   string            `json:"name" validate:"required,max=20"`
+	Kind   string            `json:"kind,omitempty" validate:"oneof=a b"`
+	Count  *int64            `json:"count" validate:"min=1"`
+	Tags   []string          `json:"tags" validate:"max=3"`
+	Items  []testItem        `json:"items"`
+	Extra  map[string]any    `json:"extra"`
+	Labels map[string]string `json:"-"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	routes := []Route{{
+		Name: "createThing", Function: "createThing",
+		Method: "POST", Path: "/users/{user_id}/things",
+		Request: testRequest{}, Status: 201, Response: testItem{},
+		Scopes: []string{"troggle/admin"}, APIKey: true,
+	}}
+	doc := OpenAPI(Info{Title: "Test", Version: "1"}, routes)
+
+	op := doc.Paths["/users/{user_id}/things"]["post"]
+	if op == nil {
+		t.Fatal("operation missing")
+	}
+	if len(op.Parameters) != 1 || op.Parameters[0].Name != "user_id" || op.Parameters[0].In != "path" {
+		t.Errorf("parameters = %+v, want the user_id path parameter", op.Parameters)
+	}
+	if len(op.Security) != 2 || !reflect.DeepEqual(op.Scopes, []string{"troggle/admin"}) {
+		t.Errorf("security = %v, scopes = %v", op.Security, op.Scopes)
+	}
+
+	body := op.RequestBody.Content["application/json"].Schema
+	var names []string
+	for name := range body.Properties {
+		names = append(names, name)
+	}
+	for _, hidden := range []string{"user_id", "caller", "Labels"} {
+		if _, ok := body.Properties[hidden]; ok {
+			t.Errorf("request has %s, want it left out", hidden)
+		}
+	}
+	if len(body.Properties) != 6 {
+		t.Errorf("request properties = %v, want 6", names)
+	}
+	if !op.RequestBody.Required || !reflect.DeepEqual(body.Required, []string{"name"}) {
+		t.Errorf("required = %v", body.Required)
+	}
+	if p := body.Properties["name"]; p.MaxLength == nil || *p.MaxLength != 20 {
+		t.Errorf("name = %+v, want maxLength 20", p)
+	}
+	if p := body.Properties["kind"]; !reflect.DeepEqual(p.Enum, []string{"a", "b"}) {
+		t.Errorf("kind = %+v, want enum a, b", p)
+	}
+	if p := body.Properties["count"]; p.Type != "integer" || p.Minimum == nil || *p.Minimum != 1 {
+		t.Errorf("count = %+v, want an integer of at least 1", p)
+	}
+	if p := body.Properties["tags"]; p.Type != "array" || p.MaxItems == nil || *p.MaxItems != 3 {
+		t.Errorf("tags = %+v, want an array of at most 3", p)
+	}
+	if p := body.Properties["items"]; p.Items == nil || p.Items.Ref != "#/components/schemas/api.testItem" {
+		t.Errorf("items = %+v, want references to api.testItem", p)
+	}
+
+	resp := op.Responses["201"].Content["application/json"].Schema
+	if resp.Ref != "#/components/schemas/api.testItem" {
+		t.Errorf("response = %+v, want a reference to api.testItem", resp)
+	}
+	item := doc.Components.Schemas["api.testItem"]
+	if item == nil || item.Properties["created"].Format != "date-time" {
+		t.Errorf("api.testItem = %+v", item)
+	}
+	if doc.Components.Schemas[errorSchema] == nil || op.Responses["default"] == nil {
+		t.Error("error response missing")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	r := Route{Name: "a", Function: "a", Method: "GET", Path: "/a"}
+	if err := Check([]Route{r, {Name: "b", Function: "b", Method: "GET", Path: "/b"}}); err != nil {
+		t.Errorf("Check = %v", err)
+	}
+	if err := Check([]Route{r, {Name: "b", Function: "b", Method: "GET", Path: "/a"}}); err == nil {
+		t.Error("duplicate route: want error")
+	}
+	if err := Check([]Route{r, {Name: "a", Function: "a", Method: "POST", Path: "/a"}}); err == nil {
+		t.Error("duplicate name: want error")
+	}
+	if err := Check([]Route{{Name: "b", Function: "b", Method: "GET", Path: "/b", Public: true, Groups: []string{"admin"}}}); err == nil {
+		t.Error("public route with groups: want error")
+	}
+}
+
+func TestOpenAPIPublic(t *testing.T) {
+	doc := OpenAPI(Info{Title: "Test", Version: "1"}, []Route{
+		{Name: "a", Function: "a", Method: "GET", Path: "/a"},
+		{Name: "b", Function: "b", Method: "POST", Path: "/b", Public: true},
+	})
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Paths map[string]map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := got.Paths["/a"]["get"]["security"]; ok {
+		t.Errorf("/a security = %s, want the document's", s)
+	}
+	if s := string(got.Paths["/b"]["post"]["security"]); s != "[]" {
+		t.Errorf("/b security = %s, want []", s)
+	}
+}
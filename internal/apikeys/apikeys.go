@@ -0,0 +1,352 @@
+// Package apikeys issues and checks the API keys of server-to-server
+// callers, such as partners, who call the backend without Cognito.
+//
+// A key is shown once, when it is issued; the API key table only keeps its
+// SHA-256, so a leaked table does not leak usable keys. Each key carries the
+// scopes it may use, which are either authz permissions or the scopes of
+// the route table, and a rate limit of its own. Rotating a key issues a
+// replacement with the same scopes and limit, and keeps the old key working
+// for API_KEY_ROTATION_GRACE so callers can switch over; revoking one stops
+// it at once.
+//
+// The API Gateway authorizer accepts keys in the X-Api-Key header for the
+// routes their scopes cover, and Middleware authenticates them in functions
+// that verify callers themselves.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/ratelimit"
+)
+
+// Header carries API keys. httpx lower-cases header names.
+const Header = "x-api-key"
+
+// prefix starts every issued key, so leaked keys are easy to scan for.
+const prefix = "trg_"
+
+// Statuses of keys.
+const (
+	StatusActive  = "active"
+	StatusRevoked = "revoked"
+)
+
+// DefaultRateLimit applies to keys issued without a limit of their own.
+var DefaultRateLimit = ratelimit.PerMinute(600)
+
+// MaxScopes bounds the scopes of one key.
+const MaxScopes = 20
+
+var (
+	// ownerName is the form of owners, the principal reported for a key.
+	ownerName = regexp.MustCompile(`^[A-Za-z0-9_.:@-]{1,128}$`)
+
+	// routeScope is the form of route table scopes, e.g. "troggle/admin".
+	routeScope = regexp.MustCompile(`^[a-z0-9_-]+/[a-z0-9_.-]+$`)
+)
+
+// ErrInvalidKey is returned for keys that are unknown, revoked or expired.
+var ErrInvalidKey = errors.New("invalid API key")
+
+// Record is an item of the API key table.
+type Record struct {
+	KeyHash    string   `dynamodbav:"key_hash" json:"-"`
+	KeyID      string   `dynamodbav:"key_id,omitempty" json:"key_id"` // public name of the key; empty for keys issued by hand
+	Name       string   `dynamodbav:"name,omitempty" json:"name,omitempty"`
+	Owner      string   `dynamodbav:"owner" json:"owner"`                               // principal reported to the backend
+	Scopes     []string `dynamodbav:"scopes,stringset" json:"scopes"`                   // authz permissions or route table scopes
+	Status     string   `dynamodbav:"status" json:"status"`                             // StatusActive or StatusRevoked
+	RateLimit  string   `dynamodbav:"rate_limit,omitempty" json:"rate_limit,omitempty"` // ratelimit.ParseLimit format; empty for DefaultRateLimit
+	CreatedAt  string   `dynamodbav:"created_at,omitempty" json:"created_at,omitempty"`
+	ExpiresAt  string   `dynamodbav:"expires_at,omitempty" json:"expires_at,omitempty"`
+	RevokedAt  string   `dynamodbav:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	ReplacedBy string   `dynamodbav:"replaced_by,omitempty" json:"replaced_by,omitempty"` // key_id of the rotated-in key
+}
+
+// Usable reports whether the key is active and not expired.
+func (k *Record) Usable(now time.Time) bool {
+	if k.Status != StatusActive || k.Owner == "" {
+		return false
+	}
+	if k.ExpiresAt == "" {
+		return true
+	}
+	exp, err := time.Parse(time.RFC3339, k.ExpiresAt)
+	return err == nil && now.Before(exp)
+}
+
+// Limit returns the rate limit of the key.
+func (k *Record) Limit() ratelimit.Limit {
+	if k.RateLimit == "" {
+		return DefaultRateLimit
+	}
+	l, err := ratelimit.ParseLimit(k.RateLimit)
+	if err != nil {
+		return DefaultRateLimit
+	}
+	return l
+}
+
+// Spec describes a key to issue.
+type Spec struct {
+	Name      string    `json:"name,omitempty"`
+	Owner     string    `json:"owner"`
+	Scopes    []string  `json:"scopes"`
+	RateLimit string    `json:"rate_limit,omitempty"` // e.g. "120/m"
+	ExpiresAt time.Time `json:"expires_at,omitzero"`  // zero for keys that do not expire
+}
+
+// Validate checks the fields of s.
+func (s Spec) Validate(now time.Time) error {
+	if !ownerName.MatchString(s.Owner) {
+		return apperr.Invalid("INVALID_OWNER", "owner", "owner must be 1-128 letters, digits or _.:@-")
+	}
+	if len(s.Name) > 100 {
+		return apperr.Invalid("NAME_TOO_LONG", "name", "name must be at most 100 bytes")
+	}
+	if len(s.Scopes) == 0 || len(s.Scopes) > MaxScopes {
+		return apperr.Invalid("INVALID_SCOPES", "scopes", fmt.Sprintf("a key needs 1 to %d scopes", MaxScopes))
+	}
+	for _, scope := range s.Scopes {
+		if !authz.Known(scope) && !routeScope.MatchString(scope) {
+			return apperr.Invalid("INVALID_SCOPES", "scopes", fmt.Sprintf("unknown scope %q", scope))
+		}
+	}
+	if s.RateLimit != "" {
+		if _, err := ratelimit.ParseLimit(s.RateLimit); err != nil {
+			return apperr.Invalid("INVALID_RATE_LIMIT", "rate_limit", `rate_limit must look like "120/m"`)
+		}
+	}
+	if !s.ExpiresAt.IsZero() && !s.ExpiresAt.After(now) {
+		return apperr.Invalid("INVALID_EXPIRY", "expires_at", "expires_at must be in the future")
+	}
+	return nil
+}
+
+// Issued is a newly issued key. Key is only ever returned here.
+type Issued struct {
+	Key string `json:"api_key"`
+	Record
+}
+
+// Hash returns the hex SHA-256 of key, the key_hash it is stored under.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// generate returns a new key and its public ID.
+func generate() (key, keyID string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("generating API key: %w", err)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", fmt.Errorf("generating API key ID: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(secret), "ak_" + hex.EncodeToString(id), nil
+}
+
+// Store reads and writes the API key table.
+type Store struct {
+	DB      *db.Client
+	Table   string
+	IDIndex string        // GSI keyed by key_id
+	Grace   time.Duration // how long rotated keys keep working
+}
+
+// NewStore returns a store over the API key table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.APIKeyTableName, IDIndex: cfg.APIKeyIDIndexName, Grace: cfg.APIKeyRotationGrace}
+}
+
+// Create issues a key described by spec, which must be valid.
+func (s *Store) Create(ctx context.Context, spec Spec, now time.Time) (Issued, error) {
+	key, keyID, err := generate()
+	if err != nil {
+		return Issued{}, err
+	}
+	rec := Record{
+		KeyHash:   Hash(key),
+		KeyID:     keyID,
+		Name:      spec.Name,
+		Owner:     spec.Owner,
+		Scopes:    spec.Scopes,
+		Status:    StatusActive,
+		RateLimit: spec.RateLimit,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+	}
+	if !spec.ExpiresAt.IsZero() {
+		rec.ExpiresAt = spec.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	put, err := s.put(rec)
+	if err != nil {
+		return Issued{}, err
+	}
+	start := time.Now()
+	_, err = s.DB.DynamoDB.PutItem(ctx, put)
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return Issued{}, db.Wrap(err, "creating API key")
+	}
+	return Issued{Key: key, Record: rec}, nil
+}
+
+// put returns the conditional put of a new record.
+func (s *Store) put(rec Record) (*dynamodb.PutItemInput, error) {
+	item, err := attributevalue.MarshalMap(rec)
+	if err != nil {
+		return nil, fmt.Errorf("encoding API key: %w", err)
+	}
+	return &dynamodb.PutItemInput{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(key_hash)"),
+	}, nil
+}
+
+// Lookup returns the record of key, or nil if it is unknown.
+func (s *Store) Lookup(ctx context.Context, key string) (*Record, error) {
+	item, err := s.DB.GetItem(ctx, s.Table, db.Item{
+		"key_hash": &types.AttributeValueMemberS{Value: Hash(key)},
+	})
+	if err != nil || item == nil {
+		return nil, err
+	}
+	return decode(item)
+}
+
+// Get returns the record of the key named keyID, or an apperr.NotFound
+// error.
+func (s *Store) Get(ctx context.Context, keyID string) (*Record, error) {
+	items, err := s.DB.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.IDIndex),
+		KeyConditionExpression: aws.String("key_id = :key_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":key_id": &types.AttributeValueMemberS{Value: keyID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, apperr.NotFound("API key not found")
+	}
+	return decode(items[0])
+}
+
+func decode(item db.Item) (*Record, error) {
+	var rec Record
+	if err := attributevalue.UnmarshalMap(item, &rec); err != nil {
+		return nil, fmt.Errorf("decoding API key: %w", err)
+	}
+	return &rec, nil
+}
+
+// Revoke stops the key named keyID at once. It reports false if the key
+// was revoked already.
+func (s *Store) Revoke(ctx context.Context, keyID string, now time.Time) (bool, error) {
+	rec, err := s.Get(ctx, keyID)
+	if err != nil || rec.Status == StatusRevoked {
+		return false, err
+	}
+	start := time.Now()
+	_, err = s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 db.Item{"key_hash": &types.AttributeValueMemberS{Value: rec.KeyHash}},
+		UpdateExpression:    aws.String("SET #status = :revoked, revoked_at = :now"),
+		ConditionExpression: aws.String("#status <> :revoked"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status", // reserved word
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":revoked": &types.AttributeValueMemberS{Value: StatusRevoked},
+			":now":     &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		},
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return false, nil
+	}
+	if err != nil {
+		return false, db.Wrap(err, "revoking API key")
+	}
+	return true, nil
+}
+
+// Rotate issues a replacement for the key named keyID, with the same owner,
+// scopes and limit, and makes the old key expire after the store's grace
+// period, sooner if it expires earlier anyway. Keys that are not usable, or
+// were rotated already, are an apperr.Invalid error.
+func (s *Store) Rotate(ctx context.Context, keyID string, now time.Time) (Issued, error) {
+	old, err := s.Get(ctx, keyID)
+	if err != nil {
+		return Issued{}, err
+	}
+	if !old.Usable(now) {
+		return Issued{}, apperr.Invalid("KEY_NOT_USABLE", "key_id", "only active keys can be rotated")
+	}
+	if old.ReplacedBy != "" {
+		return Issued{}, apperr.Invalid("KEY_ROTATED", "key_id", "key was rotated already, to "+old.ReplacedBy)
+	}
+
+	key, newID, err := generate()
+	if err != nil {
+		return Issued{}, err
+	}
+	rec := *old
+	rec.KeyHash, rec.KeyID, rec.CreatedAt, rec.ReplacedBy = Hash(key), newID, now.UTC().Format(time.RFC3339), ""
+	put, err := s.put(rec)
+	if err != nil {
+		return Issued{}, err
+	}
+
+	retire := now.Add(s.Grace).UTC()
+	if exp, err := time.Parse(time.RFC3339, old.ExpiresAt); err == nil && exp.Before(retire) {
+		retire = exp
+	}
+	err = s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		{Put: &types.Put{TableName: put.TableName, Item: put.Item, ConditionExpression: put.ConditionExpression}},
+		{Update: &types.Update{
+			TableName:           aws.String(s.Table),
+			Key:                 db.Item{"key_hash": &types.AttributeValueMemberS{Value: old.KeyHash}},
+			UpdateExpression:    aws.String("SET expires_at = :retire, replaced_by = :new"),
+			ConditionExpression: aws.String("#status = :active AND attribute_not_exists(replaced_by)"),
+			ExpressionAttributeNames: map[string]string{
+				"#status": "status", // reserved word
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":retire": &types.AttributeValueMemberS{Value: retire.Format(time.RFC3339)},
+				":new":    &types.AttributeValueMemberS{Value: newID},
+				":active": &types.AttributeValueMemberS{Value: StatusActive},
+			},
+		}},
+	})
+	if db.ConditionFailed(err, 1) {
+		return Issued{}, apperr.Invalid("KEY_ROTATED", "key_id", "key was revoked or rotated meanwhile")
+	}
+	if err != nil {
+		return Issued{}, err
+	}
+	return Issued{Key: key, Record: rec}, nil
+}
@@ -0,0 +1,234 @@
+package apikeys
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/ratelimit"
+)
+
+// table is an in-memory API key table, keyed by key_hash.
+type table map[string]db.Item
+
+func (t table) mock() *dbtest.Mock {
+	return &dbtest.Mock{
+		GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{Item: t[in.Key["key_hash"].(*types.AttributeValueMemberS).Value]}, nil
+		},
+		QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			id := in.ExpressionAttributeValues[":key_id"].(*types.AttributeValueMemberS).Value
+			for _, item := range t {
+				if v, ok := item["key_id"].(*types.AttributeValueMemberS); ok && v.Value == id {
+					return &dynamodb.QueryOutput{Items: []db.Item{item}}, nil
+				}
+			}
+			return &dynamodb.QueryOutput{}, nil
+		},
+		PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			t[in.Item["key_hash"].(*types.AttributeValueMemberS).Value] = in.Item
+			return &dynamodb.PutItemOutput{}, nil
+		},
+		UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+			t.update(in.Key, in.ExpressionAttributeValues)
+			return &dynamodb.UpdateItemOutput{}, nil
+		},
+		TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+			for _, op := range in.TransactItems {
+				if op.Put != nil {
+					t[op.Put.Item["key_hash"].(*types.AttributeValueMemberS).Value] = op.Put.Item
+				}
+				if op.Update != nil {
+					t.update(op.Update.Key, op.Update.ExpressionAttributeValues)
+				}
+			}
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		},
+	}
+}
+
+// update applies the SET expressions of Revoke and Rotate.
+func (t table) update(key db.Item, values map[string]types.AttributeValue) {
+	item := t[key["key_hash"].(*types.AttributeValueMemberS).Value]
+	for name, attr := range map[string]string{":revoked": "status", ":now": "revoked_at", ":retire": "expires_at", ":new": "replaced_by"} {
+		if v, ok := values[name]; ok {
+			item[attr] = v
+		}
+	}
+}
+
+func record(t *testing.T, item db.Item) Record {
+	t.Helper()
+	var rec Record
+	if err := attributevalue.UnmarshalMap(item, &rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestSpecValidate(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		spec     Spec
+		wantCode string
+	}{
+		{name: "valid", spec: Spec{Owner: "svc-billing", Scopes: []string{authz.UsersList, "troggle/users.read"}, RateLimit: "120/m"}},
+		{name: "bad owner", spec: Spec{Owner: "svc billing", Scopes: []string{authz.UsersList}}, wantCode: "INVALID_OWNER"},
+		{name: "no scopes", spec: Spec{Owner: "svc-billing"}, wantCode: "INVALID_SCOPES"},
+		{name: "unknown scope", spec: Spec{Owner: "svc-billing", Scopes: []string{"users:own"}}, wantCode: "INVALID_SCOPES"},
+		{name: "bad rate limit", spec: Spec{Owner: "svc-billing", Scopes: []string{authz.UsersList}, RateLimit: "lots"}, wantCode: "INVALID_RATE_LIMIT"},
+		{name: "expired", spec: Spec{Owner: "svc-billing", Scopes: []string{authz.UsersList}, ExpiresAt: now.Add(-time.Hour)}, wantCode: "INVALID_EXPIRY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate(now)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+			if e := apperr.As(err); e == nil || e.Code != tt.wantCode {
+				t.Errorf("err = %v, want code %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	keys := table{}
+	m := keys.mock()
+	s := &Store{DB: m.Client(), Table: "api-keys", IDIndex: "key-id-index", Grace: time.Hour}
+	ctx := context.Background()
+	now := time.Now()
+
+	issued, err := s.Create(ctx, Spec{Owner: "svc-billing", Scopes: []string{authz.UsersList}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(issued.Key, prefix) || issued.KeyID == "" {
+		t.Fatalf("issued = %+v", issued)
+	}
+	for hash := range keys {
+		if hash != Hash(issued.Key) {
+			t.Fatalf("stored under %s, want the hash of the key", hash)
+		}
+	}
+	body, _ := json.Marshal(issued)
+	if strings.Contains(string(body), "key_hash") {
+		t.Errorf("issued key exposes its hash: %s", body)
+	}
+
+	rec, err := s.Lookup(ctx, issued.Key)
+	if err != nil || rec == nil || !rec.Usable(now) {
+		t.Fatalf("Lookup = %+v, %v, want a usable key", rec, err)
+	}
+	if rec, err := s.Lookup(ctx, "trg_unknown"); err != nil || rec != nil {
+		t.Fatalf("Lookup of unknown key = %+v, %v, want nil", rec, err)
+	}
+
+	rotated, err := s.Rotate(ctx, issued.KeyID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := record(t, keys[Hash(issued.Key)])
+	if old.ReplacedBy != rotated.KeyID || !old.Usable(now) || old.Usable(now.Add(2*time.Hour)) {
+		t.Errorf("rotated-out key = %+v, want it usable for the grace period", old)
+	}
+	if _, err := s.Rotate(ctx, issued.KeyID, now); apperr.KindOf(err) != apperr.KindInvalid {
+		t.Errorf("second Rotate err = %v, want invalid", err)
+	}
+
+	if revoked, err := s.Revoke(ctx, rotated.KeyID, now); err != nil || !revoked {
+		t.Fatalf("Revoke = %v, %v, want true", revoked, err)
+	}
+	if revoked, err := s.Revoke(ctx, rotated.KeyID, now); err != nil || revoked {
+		t.Fatalf("second Revoke = %v, %v, want false", revoked, err)
+	}
+	if rec := record(t, keys[Hash(rotated.Key)]); rec.Usable(now) {
+		t.Errorf("revoked key is usable")
+	}
+	if _, err := s.Revoke(ctx, "ak_missing", now); apperr.KindOf(err) != apperr.KindNotFound {
+		t.Errorf("Revoke of unknown key err = %v, want not found", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	keys := table{}
+	m := keys.mock()
+	s := &Store{DB: m.Client(), Table: "api-keys", IDIndex: "key-id-index"}
+	live, err := s.Create(context.Background(), Spec{Owner: "svc-billing", Scopes: []string{authz.UsersSuspend}, RateLimit: "1/m"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := s.Create(context.Background(), Spec{Owner: "svc-old", Scopes: []string{authz.UsersSuspend}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Revoke(context.Background(), revoked.KeyID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// next answers 200 if the context carries an API key identity allowed
+	// to suspend accounts, and 204 if it carries none
+	next := func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+		id, ok := auth.FromContext(ctx)
+		switch {
+		case !ok:
+			return httpx.NoContent(), nil
+		case id.Subject == "svc-billing" && authz.Allowed(id, authz.UsersSuspend) && !authz.Allowed(id, authz.UsersBan):
+			return httpx.Text(200, "ok"), nil
+		}
+		return httpx.Text(500, "unexpected identity"), nil
+	}
+
+	tests := []struct {
+		name       string
+		req        *httpx.Request
+		limited    bool // whether the key's bucket is empty
+		wantStatus int
+	}{
+		{name: "live key", req: &httpx.Request{Headers: map[string]string{Header: live.Key}}, wantStatus: 200},
+		{name: "rate limited", req: &httpx.Request{Headers: map[string]string{Header: live.Key}}, limited: true, wantStatus: 429},
+		{name: "revoked key", req: &httpx.Request{Headers: map[string]string{Header: revoked.Key}}, wantStatus: 401},
+		{name: "unknown key", req: &httpx.Request{Headers: map[string]string{Header: "trg_unknown"}}, wantStatus: 401},
+		{name: "no key", req: &httpx.Request{Headers: map[string]string{}}, wantStatus: 204},
+		{name: "direct", req: &httpx.Request{Direct: true, Headers: map[string]string{Header: "trg_unknown"}}, wantStatus: 204},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := &dbtest.Mock{GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+				if !tt.limited {
+					return &dynamodb.GetItemOutput{}, nil
+				}
+				item := dbtest.Item("bucket", "apikey#"+live.KeyID)
+				item["tokens"] = &types.AttributeValueMemberN{Value: "0"}
+				item["updated_at"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().UnixMilli(), 10)}
+				return &dynamodb.GetItemOutput{Item: item}, nil
+			}}
+			mw := &Middleware{Keys: s, Limiter: &ratelimit.Limiter{DB: buckets.Client(), Table: "rate-limits"}}
+
+			resp, err := mw.Wrap(next)(context.Background(), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+		})
+	}
+}
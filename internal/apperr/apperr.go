@@ -0,0 +1,202 @@
+// Package apperr is the typed error model shared by handlers and the data
+// layer. An *Error carries a Kind describing what went wrong from the
+// caller's point of view, which the response layer maps to an HTTP status,
+// and a machine-readable code clients branch on. Errors without a Kind are
+// treated as Internal; errors without a code get their Kind's default code.
+package apperr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Kind classifies an error.
+type Kind int
+
+const (
+	KindInternal     Kind = iota // unexpected failure; details are not exposed
+	KindNotFound                 // the requested resource does not exist
+	KindThrottled                // a downstream dependency (or we) rate limited the call
+	KindInvalid                  // the input failed validation
+	KindForbidden                // the caller is authenticated but not allowed
+	KindUnauthorized             // the caller could not be authenticated
+	KindUnavailable              // the service is down on purpose, e.g. for maintenance
+	KindConflict                 // the request conflicts with the current state of the resource
+	KindBadRequest               // the request could not be parsed
+	KindTimeout                  // a downstream dependency did not answer before the deadline
+)
+
+// statuses maps each Kind to its HTTP status code.
+var statuses = map[Kind]int{
+	KindInternal:     http.StatusInternalServerError,
+	KindNotFound:     http.StatusNotFound,
+	KindThrottled:    http.StatusTooManyRequests,
+	KindInvalid:      http.StatusUnprocessableEntity,
+	KindForbidden:    http.StatusForbidden,
+	KindUnauthorized: http.StatusUnauthorized,
+	KindUnavailable:  http.StatusServiceUnavailable,
+	KindConflict:     http.StatusConflict,
+	KindBadRequest:   http.StatusBadRequest,
+	KindTimeout:      http.StatusGatewayTimeout,
+}
+
+// codes maps each Kind to the code of errors that do not set their own.
+var codes = map[Kind]string{
+	KindInternal:     "INTERNAL_ERROR",
+	KindNotFound:     "NOT_FOUND",
+	KindThrottled:    "THROTTLED",
+	KindInvalid:      "INVALID_INPUT",
+	KindForbidden:    "FORBIDDEN",
+	KindUnauthorized: "UNAUTHORIZED",
+	KindUnavailable:  "UNAVAILABLE",
+	KindConflict:     "CONFLICT",
+	KindBadRequest:   "BAD_REQUEST",
+	KindTimeout:      "TIMEOUT",
+}
+
+// Error is an error with a Kind and a message safe to show to clients.
+type Error struct {
+	Kind    Kind
+	Code    string       // optional machine-readable code, e.g. "USER_NOT_FOUND"; defaults by Kind
+	Field   string       // optional name of the offending input field
+	Fields  []FieldError // optional per-field details of KindInvalid errors
+	Message string       // client-facing message
+	Err     error        // underlying cause, for logs only
+	Current *Current     // optional current state of the resource, for conflicts of writes based on an old version
+
+	RetryAfter time.Duration // optional hint for throttled and unavailable errors; zero means the default
+}
+
+// FieldError describes one invalid input field.
+type FieldError struct {
+	Field   string `json:"field"` // e.g. "results[1].score"
+	Code    string `json:"code"`  // e.g. "REQUIRED"
+	Message string `json:"message"`
+}
+
+// Current is the state a resource is in when a write based on an older
+// version of it conflicts, so clients can merge their change into it rather
+// than reload and redo it.
+type Current struct {
+	Version int            `json:"version"`                  // version stored now
+	Changed map[string]any `json:"changed_fields,omitempty"` // current values of the written fields that differ from the write
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying cause.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NotFound returns a KindNotFound error.
+func NotFound(message string) *Error {
+	return &Error{Kind: KindNotFound, Message: message}
+}
+
+// Throttled wraps err as a KindThrottled error.
+func Throttled(err error) *Error {
+	return &Error{Kind: KindThrottled, Message: "Too many requests", Err: err}
+}
+
+// RateLimited returns a KindThrottled error for a caller that exceeded its
+// own request quota and may retry after retryAfter.
+func RateLimited(retryAfter time.Duration) *Error {
+	return &Error{Kind: KindThrottled, Code: "RATE_LIMITED", Message: "Rate limit exceeded", RetryAfter: retryAfter}
+}
+
+// Invalid returns a KindInvalid error for the named input field.
+func Invalid(code, field, message string) *Error {
+	return &Error{Kind: KindInvalid, Code: code, Field: field, Message: message}
+}
+
+// InvalidFields returns a KindInvalid error listing every invalid field of
+// the input.
+func InvalidFields(fields []FieldError) *Error {
+	return &Error{Kind: KindInvalid, Code: "VALIDATION_FAILED", Message: "The request has invalid fields", Fields: fields}
+}
+
+// Conflict returns a KindConflict error.
+func Conflict(message string) *Error {
+	return &Error{Kind: KindConflict, Message: message}
+}
+
+// VersionConflict returns a KindConflict error with code VERSION_CONFLICT
+// for a write that was based on an older version than current, wrapping
+// the conflict it is an instance of.
+func VersionConflict(err error, message string, current *Current) *Error {
+	return &Error{Kind: KindConflict, Code: "VERSION_CONFLICT", Message: message, Err: err, Current: current}
+}
+
+// BadRequest returns a KindBadRequest error for a request that could not be
+// parsed, e.g. a body that is not valid JSON.
+func BadRequest(message string) *Error {
+	return &Error{Kind: KindBadRequest, Message: message}
+}
+
+// Forbidden returns a KindForbidden error.
+func Forbidden(message string) *Error {
+	return &Error{Kind: KindForbidden, Message: message}
+}
+
+// Unauthorized returns a KindUnauthorized error wrapping the reason
+// authentication failed.
+func Unauthorized(err error) *Error {
+	return &Error{Kind: KindUnauthorized, Message: "Authentication required", Err: err}
+}
+
+// Unavailable returns a KindUnavailable error telling clients to retry
+// after retryAfter.
+func Unavailable(code, message string, retryAfter time.Duration) *Error {
+	return &Error{Kind: KindUnavailable, Code: code, Message: message, RetryAfter: retryAfter}
+}
+
+// Timeout wraps err, a call that ran out of time, as a KindTimeout error.
+func Timeout(err error) *Error {
+	return &Error{Kind: KindTimeout, Message: "The request timed out", Err: err}
+}
+
+// Internal wraps err as a KindInternal error.
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Message: "Server error", Err: err}
+}
+
+// As returns the *Error in err's chain, converting a missed deadline to
+// Timeout and anything else to Internal.
+func As(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Timeout(err)
+	}
+	return Internal(err)
+}
+
+// KindOf returns the Kind of err (KindInternal for untyped errors).
+func KindOf(err error) Kind {
+	return As(err).Kind
+}
+
+// Code returns the machine-readable code of err: its own, or the default of
+// its Kind.
+func Code(err error) string {
+	if e := As(err); e.Code != "" {
+		return e.Code
+	}
+	return codes[KindOf(err)]
+}
+
+// Status returns the HTTP status code for err.
+func Status(err error) int {
+	return statuses[KindOf(err)]
+}
@@ -0,0 +1,289 @@
+// Package audit keeps the audit trail compliance requires: an append-only
+// log of every change to users and their data, saying who (the actor) did
+// what (the action) to which resource, with the values before and after.
+//
+// Entries live in the audit table, keyed by resource ("user/<id>") and an
+// entry_id that sorts by time. They are only ever added: Record uses a
+// conditional put, so a retried write cannot replace an entry, and no code
+// updates or deletes them; the functions' IAM policies should allow nothing
+// but PutItem on the table. After Retention the table's TTL removes entries,
+// and the auditArchive function copies every removed entry to S3 for long
+// term storage.
+//
+// Three sources write entries. Functions record the changes they make, with
+// the verified caller as the actor and the request ID (Source "api"). The
+// userStream function records every change of the user table from its
+// stream (Source "stream"), which also covers changes made outside the API,
+// such as by operators; those entries have no actor. The troggle-admin
+// command records what operators do with it, reads included, with the
+// operator as the actor (Source "cli").
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+)
+
+// Retention is how long entries stay in the table before being archived.
+const Retention = 365 * 24 * time.Hour
+
+// Actions.
+const (
+	ActionUserCreate           = "user.create"
+	ActionUserUpdate           = "user.update"
+	ActionUserDelete           = "user.delete"
+	ActionSessionRevoke        = "session.revoke"
+	ActionPreferencesUpdate    = "preferences.update"
+	ActionUserExport           = "user.export"
+	ActionDeletionRequest      = "user.deletion_request"
+	ActionDeletionCancel       = "user.deletion_cancel"
+	ActionUserSoftDelete       = "user.soft_delete"
+	ActionUserRestore          = "user.restore"
+	ActionUserMerge            = "user.merge"
+	ActionUserStatusChange     = "user.status_change"
+	ActionEmailChangeRequest   = "user.email_change_request"
+	ActionPasswordResetRequest = "user.password_reset_request"
+	ActionPasswordReset        = "user.password_reset"
+	ActionPasswordResetBurst   = "user.password_reset_burst"
+	ActionMFAEnable            = "mfa.enable"
+	ActionMFADisable           = "mfa.disable"
+	ActionProviderLink         = "user.provider_link"
+	ActionProviderUnlink       = "user.provider_unlink"
+	ActionRoleGrant            = "role.grant"
+	ActionRoleRevoke           = "role.revoke"
+	ActionAPIKeyCreate         = "api_key.create"
+	ActionAPIKeyRotate         = "api_key.rotate"
+	ActionAPIKeyRevoke         = "api_key.revoke"
+	ActionVerificationResend   = "user.verification_resend"
+	ActionInvitationCreate     = "invitation.create"
+	ActionInvitationAccept     = "invitation.accept"
+	ActionWaitlistAdmit        = "waitlist.admit"
+	ActionPolicyAccept         = "policy.accept"
+	ActionPolicyPublish        = "policy.publish"
+
+	// Actions only operators take, through troggle-admin
+	ActionUserLookup  = "user.lookup"
+	ActionUserDump    = "user.dump"
+	ActionUserSignOut = "user.sign_out"
+)
+
+// Sources of entries.
+const (
+	SourceAPI    = "api"
+	SourceStream = "stream"
+	SourceCLI    = "cli" // the troggle-admin command
+)
+
+// Actor types.
+const (
+	ActorUser      = "user"      // a user acting on their own account
+	ActorAdmin     = "admin"     // a holder of the admin role
+	ActorModerator = "moderator" // a holder of the moderator role
+	ActorAPIKey    = "api_key"   // a server-to-server caller; the ID is the key's owner
+	ActorSystem    = "system"    // a direct invocation: another function, Cognito or an operator
+	ActorOperator  = "operator"  // a person running troggle-admin; the ID is their name
+)
+
+// redacted replaces the values of sensitive attributes in diffs.
+const redacted = "[REDACTED]"
+
+// sensitive attributes are never written to the log. A change of one is
+// still recorded, with both values redacted.
+var sensitive = map[string]bool{
+	"phone_number":  true,
+	"mfa_secret":    true,
+	"last_login_ip": true,
+}
+
+// Actor identifies who made a change.
+type Actor struct {
+	ActorType string `dynamodbav:"actor_type,omitempty" json:"actor_type,omitempty"`
+	ActorID   string `dynamodbav:"actor_id,omitempty" json:"actor_id,omitempty"` // user_id, or the invoking function
+}
+
+// ActorOf returns the actor of r: the verified caller of an API request, or
+// the system for direct invocations.
+func ActorOf(ctx context.Context, r *httpx.Request) Actor {
+	if id, ok := auth.FromContext(ctx); ok && !r.Direct {
+		switch {
+		case id.TokenUse == auth.TokenUseAPIKey:
+			return Actor{ActorType: ActorAPIKey, ActorID: id.Subject}
+		case id.HasRole(authz.Admin):
+			return Actor{ActorType: ActorAdmin, ActorID: id.Subject}
+		case id.HasRole(authz.Moderator):
+			return Actor{ActorType: ActorModerator, ActorID: id.Subject}
+		}
+		return Actor{ActorType: ActorUser, ActorID: id.Subject}
+	}
+	return System(ctx)
+}
+
+// System returns the actor of changes made by the function itself, such as
+// a Cognito trigger.
+func System(ctx context.Context) Actor {
+	return Actor{ActorType: ActorSystem, ActorID: lambdacontext.FunctionName}
+}
+
+// RequestID returns the ID to trace the change of r by: the API Gateway
+// request ID, or the Lambda request ID of a direct invocation.
+func RequestID(ctx context.Context, r *httpx.Request) string {
+	if r != nil && r.RequestID != "" {
+		return r.RequestID
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		return lc.AwsRequestID
+	}
+	return ""
+}
+
+// Change is the value of one attribute before and after a change; a nil
+// side means the attribute was absent.
+type Change struct {
+	Before any `dynamodbav:"before,omitempty" json:"before,omitempty"`
+	After  any `dynamodbav:"after,omitempty" json:"after,omitempty"`
+}
+
+// Diff returns the attributes that differ between before and after, either
+// of which may be nil (for creations and deletions).
+func Diff(before, after map[string]any) map[string]Change {
+	diff := map[string]Change{}
+	for name, v := range after {
+		if old, ok := before[name]; !ok || !reflect.DeepEqual(old, v) {
+			diff[name] = Change{Before: before[name], After: v}
+		}
+	}
+	for name, v := range before {
+		if _, ok := after[name]; !ok {
+			diff[name] = Change{Before: v}
+		}
+	}
+	for name := range diff {
+		if sensitive[name] {
+			diff[name] = Change{Before: redacted, After: redacted}
+		}
+	}
+	return diff
+}
+
+// Resource names.
+func UserResource(userID string) string   { return "user/" + userID }
+func APIKeyResource(keyID string) string  { return "api_key/" + keyID }
+func PolicyResource(policy string) string { return "policy/" + policy }
+
+// Entry is one logged change.
+type Entry struct {
+	Resource string `dynamodbav:"resource" json:"resource"`
+	EntryID  string `dynamodbav:"entry_id" json:"entry_id"` // time of the change, then a unique suffix
+	Action   string `dynamodbav:"action" json:"action"`
+	Actor
+	RequestID string            `dynamodbav:"request_id,omitempty" json:"request_id,omitempty"`
+	Source    string            `dynamodbav:"source" json:"source"`
+	Diff      map[string]Change `dynamodbav:"diff,omitempty" json:"diff,omitempty"`
+	At        string            `dynamodbav:"at" json:"at"`        // RFC 3339
+	ExpiresAt int64             `dynamodbav:"expires_at" json:"-"` // Unix seconds, the TTL attribute
+}
+
+// EntryID returns the ID of an entry made at at, unique by suffix. Stream
+// entries use the stream event ID as the suffix, so a stream record
+// processed twice maps to one entry.
+func EntryID(at time.Time, suffix string) string {
+	return at.UTC().Format(time.RFC3339Nano) + "#" + suffix
+}
+
+// Store writes the audit log. A nil *Store records nothing, so handlers
+// built without one (tests) need no checks.
+type Store struct {
+	DB    *db.Client
+	Table string
+}
+
+// NewStore returns a store over the audit table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.AuditTableName}
+}
+
+// Record appends e, filling in its ID, time and expiry if unset. Recording
+// an entry whose ID already exists is a no-op.
+func (s *Store) Record(ctx context.Context, e Entry) error {
+	if s == nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	if e.At == "" {
+		e.At = now.Format(time.RFC3339)
+	}
+	if e.EntryID == "" {
+		e.EntryID = EntryID(now, randomSuffix())
+	}
+	if e.ExpiresAt == 0 {
+		at, err := time.Parse(time.RFC3339, e.At)
+		if err != nil {
+			at = now
+		}
+		e.ExpiresAt = at.Add(Retention).Unix()
+	}
+	if e.Source == "" {
+		e.Source = SourceAPI
+	}
+	item, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return fmt.Errorf("encoding audit entry: %w", err)
+	}
+
+	start := time.Now()
+	_, err = s.DB.DynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(entry_id)"),
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return nil
+	}
+	return db.Wrap(err, "writing audit entry")
+}
+
+// Log records e and logs, rather than returns, a failure. Functions use it
+// after the change is stored; the userStream entry of the change remains.
+func (s *Store) Log(ctx context.Context, e Entry) {
+	if err := s.Record(ctx, e); err != nil {
+		slog.ErrorContext(ctx, "Failed to write audit entry", "resource", e.Resource, "action", e.Action, logging.Err(err))
+		metrics.Count(ctx, metrics.AuditWriteFailed)
+	}
+}
+
+// Names returns the sorted attribute names of diff.
+func Names(diff map[string]Change) []string {
+	names := make([]string, 0, len(diff))
+	for name := range diff {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// randomSuffix returns a short random hex string.
+func randomSuffix() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
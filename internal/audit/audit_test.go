@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after map[string]any
+		want          map[string]Change
+	}{
+		{
+			name:   "update",
+			before: map[string]any{"bio": "old", "version": 1.0, "display_name": "Jane"},
+			after:  map[string]any{"bio": "new", "version": 2.0, "display_name": "Jane"},
+			want: map[string]Change{
+				"bio":     {Before: "old", After: "new"},
+				"version": {Before: 1.0, After: 2.0},
+			},
+		},
+		{
+			name:  "creation",
+			after: map[string]any{"email": "jane@example.com"},
+			want:  map[string]Change{"email": {After: "jane@example.com"}},
+		},
+		{
+			name:   "deletion",
+			before: map[string]any{"email": "jane@example.com"},
+			want:   map[string]Change{"email": {Before: "jane@example.com"}},
+		},
+		{
+			name:   "sensitive values are redacted",
+			before: map[string]any{"phone_number": "+15550100"},
+			after:  map[string]any{"phone_number": "+15550199"},
+			want:   map[string]Change{"phone_number": {Before: redacted, After: redacted}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActorOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller *auth.Identity
+		direct bool
+		want   string
+	}{
+		{name: "user", caller: &auth.Identity{Subject: "u1"}, want: ActorUser},
+		{name: "admin", caller: &auth.Identity{Subject: "a1", Groups: []string{"admin"}}, want: ActorAdmin},
+		{name: "api key", caller: &auth.Identity{Subject: "svc-billing", Scopes: []string{"users:list"}, TokenUse: auth.TokenUseAPIKey}, want: ActorAPIKey},
+		{name: "direct invocation", direct: true, want: ActorSystem},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.caller != nil {
+				ctx = auth.NewContext(ctx, tt.caller)
+			}
+			got := ActorOf(ctx, &httpx.Request{Direct: tt.direct})
+			if got.ActorType != tt.want {
+				t.Errorf("ActorType = %q, want %q", got.ActorType, tt.want)
+			}
+			if tt.caller != nil && got.ActorID != tt.caller.Subject {
+				t.Errorf("ActorID = %q", got.ActorID)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		put     error
+		wantErr bool
+	}{
+		{name: "appended"},
+		{name: "existing entry is kept", put: dbtest.ConditionFailed()},
+		{name: "failure", put: errors.New("unavailable"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{PutItemFunc: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+				return &dynamodb.PutItemOutput{}, tt.put
+			}}
+			s := &Store{DB: m.Client(), Table: "audit"}
+
+			err := s.Record(context.Background(), Entry{Resource: UserResource("u1"), Action: ActionUserUpdate})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			in := m.Calls[0].Input.(*dynamodb.PutItemInput)
+			if got := aws.ToString(in.ConditionExpression); got != "attribute_not_exists(entry_id)" {
+				t.Errorf("condition = %q", got)
+			}
+			for _, name := range []string{"entry_id", "at", "expires_at", "source"} {
+				if in.Item[name] == nil {
+					t.Errorf("%s not filled in", name)
+				}
+			}
+			if got := in.Item["source"].(*types.AttributeValueMemberS).Value; got != SourceAPI {
+				t.Errorf("source = %q", got)
+			}
+		})
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	if err := s.Record(context.Background(), Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Log(context.Background(), Entry{})
+}
@@ -0,0 +1,324 @@
+// Package auth verifies the JWTs issued by the troggle Cognito user pool and
+// makes the caller's identity available to handlers through the context.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"troggle-backend/internal/apperr"  // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/config"  // environment-driven settings
+	"troggle-backend/internal/httpx"   // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging" // structured JSON logging
+	"troggle-backend/internal/tracing" // X-Ray tracing
+	"troggle-backend/internal/warmup"  // cold-start warm-up
+)
+
+// ErrNoToken is returned when a request carries no bearer token.
+var ErrNoToken = errors.New("missing bearer token")
+
+// Identity describes an authenticated caller.
+type Identity struct {
+	Subject  string   // Cognito sub, the user_id of the caller
+	Username string   // Cognito username
+	Groups   []string // cognito:groups
+	Scopes   []string // OAuth scopes; access tokens only
+	TokenUse string   // "id" or "access", or TokenUseAPIKey
+
+	// SessionID identifies the sign-in the token descends from (Cognito's
+	// origin_jti): tokens refreshed from the same sign-in share it. Empty for
+	// tokens issued before Cognito added the claim.
+	SessionID string
+
+	// AuthTime is when the user signed in, answering every challenge of the
+	// sign-in (Cognito's auth_time): tokens refreshed from it keep it. Zero
+	// for API keys.
+	AuthTime time.Time
+
+	// Providers are the external identity providers the user signs in
+	// through (Cognito's identities claim). ID tokens only.
+	Providers []ProviderIdentity
+
+	// Roles are granted in the role table rather than through Cognito
+	// groups; see package authz.
+	Roles []string
+}
+
+// ProviderIdentity is an account at an external identity provider linked to
+// a user.
+type ProviderIdentity struct {
+	Provider string `json:"providerName"` // Cognito's name, e.g. "Google"
+	UserID   string `json:"userId"`       // subject at the provider
+}
+
+// TokenUseAPIKey is the TokenUse of identities established from API keys
+// rather than Cognito tokens; see package apikeys.
+const TokenUseAPIKey = "api_key"
+
+// InGroup reports whether the caller belongs to the Cognito group.
+func (i *Identity) InGroup(group string) bool {
+	return slices.Contains(i.Groups, group)
+}
+
+// HasRole reports whether the caller holds the role, either through the
+// Cognito group of that name or a grant in the role table.
+func (i *Identity) HasRole(role string) bool {
+	return i.InGroup(role) || slices.Contains(i.Roles, role)
+}
+
+// HasScope reports whether the token grants the OAuth scope.
+func (i *Identity) HasScope(scope string) bool {
+	return slices.Contains(i.Scopes, scope)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity stored by NewContext, if any.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(*Identity)
+	return id, ok
+}
+
+// claims are the Cognito-specific claims of id and access tokens.
+type claims struct {
+	jwt.RegisteredClaims
+	TokenUse        string   `json:"token_use"`
+	ClientID        string   `json:"client_id"` // access tokens carry the app client here instead of aud
+	Username        string   `json:"username"`
+	CognitoUsername string   `json:"cognito:username"`
+	Groups          []string `json:"cognito:groups"`
+	Scope           string   `json:"scope"`
+	OriginJTI       string   `json:"origin_jti"`
+	AuthTime        int64    `json:"auth_time"`
+
+	Identities []ProviderIdentity `json:"identities"`
+}
+
+// Verifier checks Cognito tokens against the pool's published signing keys.
+// It is safe for concurrent use and meant to be built once at cold start.
+type Verifier struct {
+	clientIDs []string
+	parser    *jwt.Parser
+	keys      *keySet
+}
+
+// NewVerifier builds a Verifier for the user pool and app clients in cfg.
+// Warming it fetches the pool's signing keys.
+func NewVerifier(cfg *config.Config) (*Verifier, error) {
+	if err := errors.Join(cfg.RequireUserPool(), cfg.RequireAppClients()); err != nil {
+		return nil, err
+	}
+	region := cfg.AWSRegion()
+	if region == "" {
+		return nil, errors.New("no AWS region configured for the user pool")
+	}
+
+	issuer := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, cfg.UserPoolID)
+	v := &Verifier{
+		clientIDs: cfg.AppClientIDs,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{"RS256"}),
+			jwt.WithIssuer(issuer),
+			jwt.WithLeeway(cfg.JWTClockSkew),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+		),
+		keys: &keySet{
+			url:    issuer + "/.well-known/jwks.json",
+			client: tracing.HTTPClient(&http.Client{Timeout: 5 * time.Second}),
+		},
+	}
+	warmup.Register("jwks", v.keys.warm)
+	return v, nil
+}
+
+// Verify checks the signature, issuer, expiry and audience of token and
+// returns the identity it asserts.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	var c claims
+	_, err := v.parser.ParseWithClaims(token, &c, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.TokenUse {
+	case "id":
+		if !slices.ContainsFunc(c.Audience, v.allowedClient) {
+			return nil, errors.New("token audience is not an accepted app client")
+		}
+	case "access":
+		if !v.allowedClient(c.ClientID) {
+			return nil, errors.New("token client_id is not an accepted app client")
+		}
+	default:
+		return nil, fmt.Errorf("unexpected token_use %q", c.TokenUse)
+	}
+	if c.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	id := &Identity{
+		Subject:   c.Subject,
+		Username:  c.Username,
+		Groups:    c.Groups,
+		Scopes:    strings.Fields(c.Scope),
+		TokenUse:  c.TokenUse,
+		SessionID: c.OriginJTI,
+		Providers: c.Identities,
+	}
+	if id.Username == "" {
+		id.Username = c.CognitoUsername
+	}
+	if c.AuthTime > 0 {
+		id.AuthTime = time.Unix(c.AuthTime, 0)
+	}
+	return id, nil
+}
+
+func (v *Verifier) allowedClient(id string) bool {
+	return slices.Contains(v.clientIDs, id)
+}
+
+// TokenVerifier turns a bearer token into the identity it asserts. *Verifier
+// is the production implementation; the local dev server substitutes its own.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*Identity, error)
+}
+
+// ErrSessionRevoked is returned for tokens of a sign-in that was revoked.
+var ErrSessionRevoked = errors.New("session has been revoked")
+
+// RevocationChecker reports whether the session of an identity was revoked.
+// *sessions.Store is the production implementation.
+type RevocationChecker interface {
+	Revoked(ctx context.Context, id *Identity) (bool, error)
+}
+
+// WithRevocation returns a TokenVerifier that also rejects tokens whose
+// session rc reports as revoked. A failed check rejects the token too:
+// revocation is a security control, so it fails closed.
+func WithRevocation(v TokenVerifier, rc RevocationChecker) TokenVerifier {
+	return revocationVerifier{next: v, rc: rc}
+}
+
+type revocationVerifier struct {
+	next TokenVerifier
+	rc   RevocationChecker
+}
+
+func (v revocationVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	id, err := v.next.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := v.rc.Revoked(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("checking session revocation: %w", err)
+	}
+	if revoked {
+		return nil, ErrSessionRevoked
+	}
+	return id, nil
+}
+
+// StatusChecker rejects identities whose account may not use the API, with
+// an apperr.Forbidden error saying why. *accountstatus.Checker is the
+// production implementation.
+type StatusChecker interface {
+	Check(ctx context.Context, id *Identity) error
+}
+
+// WithStatus returns a TokenVerifier that also rejects tokens of accounts sc
+// locks out, such as suspended ones. Like revocation, a failed check rejects
+// the token too.
+func WithStatus(v TokenVerifier, sc StatusChecker) TokenVerifier {
+	return statusVerifier{next: v, sc: sc}
+}
+
+type statusVerifier struct {
+	next TokenVerifier
+	sc   StatusChecker
+}
+
+func (v statusVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	id, err := v.next.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.sc.Check(ctx, id); err != nil {
+		if apperr.KindOf(err) == apperr.KindForbidden {
+			return nil, err
+		}
+		return nil, fmt.Errorf("checking account status: %w", err)
+	}
+	return id, nil
+}
+
+// Middleware returns Require(v, ...) as a middleware.
+func Middleware(v TokenVerifier) httpx.Middleware {
+	return func(next httpx.Handler) httpx.Handler {
+		return Require(v, next)
+	}
+}
+
+// Require wraps next so that it only runs for callers presenting a bearer
+// token v accepts; everyone else gets a 401, except callers whose account is
+// locked out, who get the 403 v rejected them with. The verified identity is
+// stored in the context. Callers an outer middleware authenticated already,
+// with an API key, are let through. Direct invocations are trusted: they
+// need IAM permission to invoke the function, which only operators and
+// other backend services have.
+func Require(v TokenVerifier, next httpx.Handler) httpx.Handler {
+	return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+		if r.Direct {
+			return next(ctx, r)
+		}
+		if _, ok := FromContext(ctx); ok {
+			return next(ctx, r)
+		}
+
+		token := BearerToken(r)
+		if token == "" {
+			return httpx.Error(apperr.Unauthorized(ErrNoToken)), nil
+		}
+		id, err := v.Verify(ctx, token)
+		if apperr.KindOf(err) == apperr.KindForbidden {
+			slog.WarnContext(ctx, "Rejected request of locked-out account", logging.Err(err))
+			return httpx.Error(err), nil
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Rejected unauthenticated request", logging.Err(err))
+			return httpx.Error(apperr.Unauthorized(err)), nil
+		}
+
+		tracing.Annotate(ctx, "user_id", id.Subject)
+		ctx = logging.With(ctx, "caller", id.Subject)
+		return next(NewContext(ctx, id), r)
+	}
+}
+
+// BearerToken extracts the token from the Authorization header, or returns
+// "" when there is none.
+func BearerToken(r *httpx.Request) string {
+	scheme, token, ok := strings.Cut(r.Header("authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
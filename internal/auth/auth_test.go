@@ -0,0 +1,390 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/httpx"
+)
+
+const (
+	testPool   = "eu-west-1_test"
+	testIssuer = "https://cognito-idp.eu-west-1.amazonaws.com/" + testPool
+)
+
+// testVerifier returns a Verifier for testPool accepting app client "web",
+// reading its keys from srv.
+func testVerifier(t *testing.T, srv *jwksServer) *Verifier {
+	t.Helper()
+	v, err := NewVerifier(&config.Config{
+		Region:       "eu-west-1",
+		UserPoolID:   testPool,
+		AppClientIDs: []string{"web"},
+		JWTClockSkew: 30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.keys = srv.keySet()
+	return v
+}
+
+// sign returns claims as an RS256 token signed by key under kid.
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = kid
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// accessClaims are the claims of a valid access token; with modifies them.
+func accessClaims(with func(jwt.MapClaims)) jwt.MapClaims {
+	now := time.Now()
+	c := jwt.MapClaims{
+		"iss":            testIssuer,
+		"sub":            "u1",
+		"token_use":      "access",
+		"client_id":      "web",
+		"username":       "alice",
+		"cognito:groups": []string{"admin"},
+		"scope":          "openid profile",
+		"origin_jti":     "session-1",
+		"auth_time":      now.Add(-time.Hour).Unix(),
+		"iat":            now.Add(-time.Minute).Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+	if with != nil {
+		with(c)
+	}
+	return c
+}
+
+// idClaims are the claims of a valid ID token; with modifies them.
+func idClaims(with func(jwt.MapClaims)) jwt.MapClaims {
+	return accessClaims(func(c jwt.MapClaims) {
+		delete(c, "client_id")
+		delete(c, "username")
+		delete(c, "scope")
+		c["token_use"] = "id"
+		c["aud"] = "web"
+		c["cognito:username"] = "alice"
+		c["identities"] = []map[string]string{{"providerName": "Google", "userId": "g1"}}
+		if with != nil {
+			with(c)
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	k1, k2 := signingKeys(t)
+	srv := newJWKSServer(t)
+	srv.publish(map[string]*rsa.PrivateKey{"k1": k1})
+	v := testVerifier(t, srv)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		token   string
+		want    *Identity
+		wantErr bool
+	}{
+		{
+			name:  "access token",
+			token: sign(t, k1, "k1", accessClaims(nil)),
+			want: &Identity{
+				Subject:   "u1",
+				Username:  "alice",
+				Groups:    []string{"admin"},
+				Scopes:    []string{"openid", "profile"},
+				TokenUse:  "access",
+				SessionID: "session-1",
+				AuthTime:  time.Unix(now.Add(-time.Hour).Unix(), 0),
+			},
+		},
+		{
+			name:  "id token",
+			token: sign(t, k1, "k1", idClaims(nil)),
+			want: &Identity{
+				Subject:   "u1",
+				Username:  "alice",
+				Groups:    []string{"admin"},
+				TokenUse:  "id",
+				SessionID: "session-1",
+				AuthTime:  time.Unix(now.Add(-time.Hour).Unix(), 0),
+				Providers: []ProviderIdentity{{Provider: "Google", UserID: "g1"}},
+			},
+		},
+		{
+			name:  "id token with several audiences",
+			token: sign(t, k1, "k1", idClaims(func(c jwt.MapClaims) { c["aud"] = []string{"other", "web"} })),
+			want: &Identity{
+				Subject:   "u1",
+				Username:  "alice",
+				Groups:    []string{"admin"},
+				TokenUse:  "id",
+				SessionID: "session-1",
+				AuthTime:  time.Unix(now.Add(-time.Hour).Unix(), 0),
+				Providers: []ProviderIdentity{{Provider: "Google", UserID: "g1"}},
+			},
+		},
+		{
+			name: "expired within leeway",
+			token: sign(t, k1, "k1", accessClaims(func(c jwt.MapClaims) {
+				c["exp"] = now.Add(-10 * time.Second).Unix()
+				delete(c, "auth_time")
+				delete(c, "origin_jti")
+			})),
+			want: &Identity{
+				Subject:  "u1",
+				Username: "alice",
+				Groups:   []string{"admin"},
+				Scopes:   []string{"openid", "profile"},
+				TokenUse: "access",
+			},
+		},
+		{
+			name:    "expired beyond leeway",
+			token:   sign(t, k1, "k1", accessClaims(func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:    "no expiry",
+			token:   sign(t, k1, "k1", accessClaims(func(c jwt.MapClaims) { delete(c, "exp") })),
+			wantErr: true,
+		},
+		{
+			name:    "issued in the future",
+			token:   sign(t, k1, "k1", accessClaims(func(c jwt.MapClaims) { c["iat"] = now.Add(time.Hour).Unix() })),
+			wantErr: true,
+		},
+		{
+			name:    "other user pool",
+			token:   sign(t, k1, "k1", accessClaims(func(c jwt.MapClaims) { c["iss"] = testIssuer + "x" })),
+			wantErr: true,
+		},
+		{
+			name:    "id token for another client",
+			token:   sign(t, k1, "k1", idClaims(func(c jwt.MapClaims) { c["aud"] = "other" })),
+			wantErr: true,
+		},
+		{
+			name:    "id token with client_id instead of aud",
+			token:   sign(t, k1, "k1", idClaims(func(c jwt.MapClaims) { delete(c, "aud"); c["client_id"] = "web" })),
+			wantErr: true,
+		},
+		{
+			name:    "access token for another client",
+			token:   sign(t, k1, "k1", accessClaims(func(c jwt.MapClaims) { c["client_id"] = "other" })),
+			wantErr: true,
+		},
+		{
+			name:    "access token with aud instead of client_id",
+			token:   sign(t, k1, "k1", accessClaims(func(c jwt.MapClaims) { delete(c, "client_id"); c["aud"] = "web" })),
+			wantErr: true,
+		},
+		{
+			name:    "refresh token",
+			token:   sign(t, k1, "k1", accessClaims(func(c jwt.MapClaims) { c["token_use"] = "refresh" })),
+			wantErr: true,
+		},
+		{
+			name:    "no subject",
+			token:   sign(t, k1, "k1", accessClaims(func(c jwt.MapClaims) { delete(c, "sub") })),
+			wantErr: true,
+		},
+		{
+			name:    "signed by another key",
+			token:   sign(t, k2, "k1", accessClaims(nil)),
+			wantErr: true,
+		},
+		{
+			name:    "unknown key",
+			token:   sign(t, k2, "k2", accessClaims(nil)),
+			wantErr: true,
+		},
+		{
+			name: "HMAC signed",
+			token: func() string {
+				s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims(nil)).SignedString([]byte("secret"))
+				return s
+			}(),
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   "not.a.token",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.want == nil {
+				return
+			}
+			if got.Subject != tt.want.Subject || got.Username != tt.want.Username ||
+				got.TokenUse != tt.want.TokenUse || got.SessionID != tt.want.SessionID ||
+				!got.AuthTime.Equal(tt.want.AuthTime) ||
+				!slices.Equal(got.Groups, tt.want.Groups) || !slices.Equal(got.Scopes, tt.want.Scopes) ||
+				!slices.Equal(got.Providers, tt.want.Providers) {
+				t.Errorf("Verify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewVerifierRequiresPool(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{name: "no pool", cfg: config.Config{Region: "eu-west-1", AppClientIDs: []string{"web"}}},
+		{name: "no clients", cfg: config.Config{Region: "eu-west-1", UserPoolID: testPool}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewVerifier(&tt.cfg); err == nil {
+				t.Error("NewVerifier succeeded")
+			}
+		})
+	}
+}
+
+// verifierFunc adapts a function to TokenVerifier.
+type verifierFunc func(ctx context.Context, token string) (*Identity, error)
+
+func (f verifierFunc) Verify(ctx context.Context, token string) (*Identity, error) {
+	return f(ctx, token)
+}
+
+func TestRequire(t *testing.T) {
+	alice := &Identity{Subject: "u1"}
+	v := verifierFunc(func(_ context.Context, token string) (*Identity, error) {
+		switch token {
+		case "valid":
+			return alice, nil
+		case "suspended":
+			return nil, apperr.Forbidden("Account suspended")
+		case "revoked":
+			return nil, ErrSessionRevoked
+		}
+		return nil, errors.New("bad token")
+	})
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		req        *httpx.Request
+		wantStatus int
+		wantCaller string
+	}{
+		{
+			name:       "valid token",
+			req:        &httpx.Request{Headers: map[string]string{"authorization": "Bearer valid"}},
+			wantStatus: http.StatusOK,
+			wantCaller: "u1",
+		},
+		{
+			name:       "no token",
+			req:        &httpx.Request{},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "other scheme",
+			req:        &httpx.Request{Headers: map[string]string{"authorization": "Basic valid"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "rejected token",
+			req:        &httpx.Request{Headers: map[string]string{"authorization": "Bearer forged"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "revoked session",
+			req:        &httpx.Request{Headers: map[string]string{"authorization": "Bearer revoked"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "locked-out account",
+			req:        &httpx.Request{Headers: map[string]string{"authorization": "Bearer suspended"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "direct invocation",
+			req:        &httpx.Request{Direct: true},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "already authenticated",
+			ctx:        NewContext(context.Background(), &Identity{Subject: "key-owner", TokenUse: TokenUseAPIKey}),
+			req:        &httpx.Request{},
+			wantStatus: http.StatusOK,
+			wantCaller: "key-owner",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			var caller string
+			h := Require(v, func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+				if id, ok := FromContext(ctx); ok {
+					caller = id.Subject
+				}
+				return httpx.JSON(http.StatusOK, nil), nil
+			})
+
+			resp, err := h(ctx, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if caller != tt.wantCaller {
+				t.Errorf("caller = %q, want %q", caller, tt.wantCaller)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "Bearer abc.def.ghi", want: "abc.def.ghi"},
+		{header: "bearer abc", want: "abc"},
+		{header: "BEARER  abc ", want: "abc"},
+		{header: "Basic abc", want: ""},
+		{header: "Bearer", want: ""},
+		{header: "abc", want: ""},
+		{header: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := &httpx.Request{Headers: map[string]string{"authorization": tt.header}}
+			if got := BearerToken(r); got != tt.want {
+				t.Errorf("BearerToken(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
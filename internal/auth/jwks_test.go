@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	keyOnce  sync.Once
+	testKeys [2]*rsa.PrivateKey
+)
+
+// signingKeys returns two RSA keys, generated once per test binary.
+func signingKeys(t *testing.T) (*rsa.PrivateKey, *rsa.PrivateKey) {
+	t.Helper()
+	keyOnce.Do(func() {
+		for i := range testKeys {
+			k, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				panic(err)
+			}
+			testKeys[i] = k
+		}
+	})
+	return testKeys[0], testKeys[1]
+}
+
+// jwksServer publishes a key set that tests can change and break.
+type jwksServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	keys  []jwk
+	fail  bool
+	fetch atomic.Int32
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetch.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// publish replaces the key set with the public halves of keys, by key ID.
+func (s *jwksServer) publish(keys map[string]*rsa.PrivateKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+	for kid, k := range keys {
+		s.keys = append(s.keys, publicJWK(kid, &k.PublicKey))
+	}
+}
+
+func (s *jwksServer) setFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func (s *jwksServer) keySet() *keySet {
+	return &keySet{url: s.URL, client: s.Client()}
+}
+
+func publicJWK(kid string, k *rsa.PublicKey) jwk {
+	return jwk{
+		Kid: kid,
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	k1, k2 := signingKeys(t)
+
+	tests := []struct {
+		name string
+		// age is how long ago the first key set was fetched when kid is
+		// looked up; the server then publishes k1 and k2 unless fail is set.
+		age       time.Duration
+		fail      bool
+		kid       string
+		want      *rsa.PrivateKey
+		wantErr   bool
+		wantFetch int32
+	}{
+		{name: "cached key", age: 0, kid: "k1", want: k1, wantFetch: 1},
+		{name: "stale key refreshed", age: 2 * jwksTTL, kid: "k1", want: k1, wantFetch: 2},
+		{name: "stale key kept when refresh fails", age: 2 * jwksTTL, fail: true, kid: "k1", want: k1, wantFetch: 2},
+		{name: "rotated key within refresh limit", age: jwksMinRefresh / 2, kid: "k2", wantErr: true, wantFetch: 1},
+		{name: "rotated key after refresh limit", age: 2 * jwksMinRefresh, kid: "k2", want: k2, wantFetch: 2},
+		{name: "unknown key after refresh", age: 2 * jwksMinRefresh, kid: "k3", wantErr: true, wantFetch: 2},
+		{name: "unknown key when refresh fails", age: 2 * jwksMinRefresh, fail: true, kid: "k2", wantErr: true, wantFetch: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newJWKSServer(t)
+			srv.publish(map[string]*rsa.PrivateKey{"k1": k1})
+			s := srv.keySet()
+			if _, err := s.key(context.Background(), "k1"); err != nil {
+				t.Fatalf("first key: %v", err)
+			}
+
+			s.fetched = s.fetched.Add(-tt.age)
+			srv.publish(map[string]*rsa.PrivateKey{"k1": k1, "k2": k2})
+			srv.setFail(tt.fail)
+
+			got, err := s.key(context.Background(), tt.kid)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("key(%q) error = %v, want error %v", tt.kid, err, tt.wantErr)
+			}
+			if tt.want != nil && !got.Equal(&tt.want.PublicKey) {
+				t.Errorf("key(%q) returned the wrong key", tt.kid)
+			}
+			if n := srv.fetch.Load(); n != tt.wantFetch {
+				t.Errorf("JWKS fetched %d times, want %d", n, tt.wantFetch)
+			}
+		})
+	}
+}
+
+func TestKeySetFetchFailsWithoutCache(t *testing.T) {
+	srv := newJWKSServer(t)
+	srv.setFail(true)
+	if _, err := srv.keySet().key(context.Background(), "k1"); err == nil {
+		t.Fatal("key succeeded with no key set available")
+	}
+}
+
+func TestKeySetSkipsNonSigningKeys(t *testing.T) {
+	k1, k2 := signingKeys(t)
+	enc := publicJWK("enc", &k2.PublicKey)
+	enc.Use = "enc"
+	ec := jwk{Kid: "ec", Kty: "EC"}
+
+	srv := newJWKSServer(t)
+	srv.publish(map[string]*rsa.PrivateKey{"k1": k1})
+	srv.keys = append(srv.keys, enc, ec)
+
+	keys, err := srv.keySet().fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys["k1"]; !ok || len(keys) != 1 {
+		t.Errorf("fetched keys %v, want only k1", keys)
+	}
+}
+
+func TestRSAKey(t *testing.T) {
+	k1, _ := signingKeys(t)
+	valid := publicJWK("k1", &k1.PublicKey)
+
+	tests := []struct {
+		name    string
+		mutate  func(*jwk)
+		wantErr bool
+	}{
+		{name: "valid", mutate: func(*jwk) {}},
+		{name: "bad modulus", mutate: func(k *jwk) { k.N = "not base64!" }, wantErr: true},
+		{name: "bad exponent", mutate: func(k *jwk) { k.E = "not base64!" }, wantErr: true},
+		{name: "exponent too small", mutate: func(k *jwk) { k.E = "Ag" }, wantErr: true},
+		{name: "exponent too large", mutate: func(k *jwk) { k.E = "AQAAAAAA" }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := valid
+			tt.mutate(&k)
+			got, err := k.rsaKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rsaKey() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !got.Equal(&k1.PublicKey) {
+				t.Error("rsaKey() decoded the wrong key")
+			}
+		})
+	}
+}
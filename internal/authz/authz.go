@@ -0,0 +1,267 @@
+// Package authz is the role-based access control of the API. Callers hold
+// roles, which grant permissions; handlers ask for a permission, never for a
+// role:
+//
+//	if err := authz.Require(ctx, authz.UsersSuspend); err != nil {
+//		return httpx.Error(err), nil
+//	}
+//
+// Roles come from two places: the Cognito group of the same name, managed in
+// the user pool, and grants in the role table, managed through the
+// manageRoles endpoint. WithRoles adds the latter to verified identities.
+// Everyone holds the user role, which grants no permission over other
+// users' data: acting on one's own account needs none. API keys hold no
+// roles; the permissions they carry as scopes are granted directly.
+package authz
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/httpx"
+)
+
+// Roles, named like the Cognito groups that grant them.
+const (
+	User      = "user"
+	Moderator = "moderator"
+	Admin     = "admin"
+)
+
+// Permissions handlers require.
+const (
+	UsersList       = "users:list"       // list accounts and see data-integrity findings
+	UsersActAs      = "users:act_as"     // read and change any user's data, not just one's own
+	UsersSuspend    = "users:suspend"    // suspend accounts
+	UsersBan        = "users:ban"        // ban accounts
+	UsersReactivate = "users:reactivate" // lift suspensions and bans
+	UsersDelete     = "users:delete"     // delete accounts
+	UsersRestore    = "users:restore"    // restore soft-deleted accounts
+	UsersMerge      = "users:merge"      // merge duplicate accounts of one person
+	SessionsManage  = "sessions:manage"  // list and revoke anyone's sessions
+	MatchesManage   = "matches:manage"   // report the results of any match
+	RolesManage     = "roles:manage"     // grant and revoke roles
+	APIKeysManage   = "api_keys:manage"  // issue, rotate and revoke API keys
+	HealthRead      = "health:read"      // read the status of the backend's dependencies
+	InvitationsRead = "invitations:read" // read the invitation funnel
+	WaitlistAdmit   = "waitlist:admit"   // admit people from the waitlist
+	PoliciesPublish = "policies:publish" // publish new versions of the terms and privacy policy
+)
+
+// policy lists the permissions of each role.
+var policy = map[string][]string{
+	User:      nil,
+	Moderator: {UsersList, UsersSuspend, SessionsManage},
+	Admin: {
+		UsersList, UsersActAs, UsersSuspend, UsersBan, UsersReactivate, UsersDelete, UsersRestore, UsersMerge,
+		SessionsManage, MatchesManage, RolesManage, APIKeysManage, HealthRead, InvitationsRead,
+		WaitlistAdmit, PoliciesPublish,
+	},
+}
+
+// Valid reports whether role is one of the roles above.
+func Valid(role string) bool {
+	_, ok := policy[role]
+	return ok
+}
+
+// Known reports whether perm is one of the permissions above.
+func Known(perm string) bool {
+	return slices.Contains(policy[Admin], perm)
+}
+
+// Granted reports whether one of roles grants perm.
+func Granted(roles []string, perm string) bool {
+	for _, role := range roles {
+		if slices.Contains(policy[role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles returns the roles id holds, user first.
+func Roles(id *auth.Identity) []string {
+	roles := []string{User}
+	for _, role := range []string{Moderator, Admin} {
+		if id.HasRole(role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Allowed reports whether id holds a role granting perm, or is an API key
+// carrying it as a scope.
+func Allowed(id *auth.Identity, perm string) bool {
+	if id.TokenUse == auth.TokenUseAPIKey {
+		return id.HasScope(perm)
+	}
+	return Granted(Roles(id), perm)
+}
+
+// Require returns an apperr.Forbidden error unless the verified caller in
+// ctx holds perm, and an apperr.Unauthorized one if there is none. Handlers
+// trusting direct invocations check r.Direct first.
+func Require(ctx context.Context, perm string) error {
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if !Allowed(id, perm) {
+		return apperr.Forbidden(fmt.Sprintf("Permission %s required", perm))
+	}
+	return nil
+}
+
+// ClaimsAllow reports whether the caller of r holds perm according to the
+// groups claim of the API Gateway authorizer, for endpoints that do not
+// verify tokens themselves. The troggle authorizer passes role table grants
+// in the same claim.
+func ClaimsAllow(r *httpx.Request, perm string) bool {
+	for role, perms := range policy {
+		if r.InGroup(role) && slices.Contains(perms, perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Grant is a record of the role table.
+type Grant struct {
+	UserID    string `dynamodbav:"user_id" json:"user_id"`
+	Role      string `dynamodbav:"role" json:"role"`
+	GrantedBy string `dynamodbav:"granted_by,omitempty" json:"granted_by,omitempty"` // user_id of the admin, or the invoking function
+	GrantedAt string `dynamodbav:"granted_at" json:"granted_at"`                     // RFC 3339
+}
+
+// Store reads and writes the role table.
+type Store struct {
+	DB    *db.Client
+	Table string
+	Cache *db.Cache // serves Roles; nil disables caching
+}
+
+// NewStore returns a store over the role table named in cfg, backed by the
+// container-wide cache.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.RoleTableName, Cache: db.SharedCache(cfg)}
+}
+
+// cacheKey names the cache entry of the roles of userID.
+func cacheKey(userID string) string { return "roles:" + userID }
+
+// Roles returns the roles granted to userID in the table. Answers are
+// cached, users without grants included, so a revocation takes effect in
+// other containers within CACHE_TTL.
+func (s *Store) Roles(ctx context.Context, userID string) ([]string, error) {
+	if cached, ok := s.Cache.Get(ctx, cacheKey(userID)); ok {
+		return cached.([]string), nil
+	}
+	items, err := s.DB.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+		ProjectionExpression: aws.String("#role"),
+		ExpressionAttributeNames: map[string]string{
+			"#role": "role", // reserved word
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]string, 0, len(items))
+	for _, item := range items {
+		if v, ok := item["role"].(*types.AttributeValueMemberS); ok && Valid(v.Value) {
+			roles = append(roles, v.Value)
+		}
+	}
+	s.Cache.Set(cacheKey(userID), roles)
+	return roles, nil
+}
+
+// Grant gives role to userID on behalf of grantedBy. It reports false if
+// the user held the grant already, which is left as it was.
+func (s *Store) Grant(ctx context.Context, userID, role, grantedBy string, now time.Time) (bool, error) {
+	item, err := attributevalue.MarshalMap(Grant{
+		UserID:    userID,
+		Role:      role,
+		GrantedBy: grantedBy,
+		GrantedAt: now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return false, fmt.Errorf("encoding grant: %w", err)
+	}
+	start := time.Now()
+	_, err = s.DB.DynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(user_id)"),
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return false, nil
+	}
+	if err != nil {
+		return false, db.Wrap(err, "granting role")
+	}
+	s.Cache.Invalidate(cacheKey(userID))
+	return true, nil
+}
+
+// Revoke takes role away from userID. It reports false if the user held no
+// such grant. Roles held through Cognito groups are not affected.
+func (s *Store) Revoke(ctx context.Context, userID, role string) (bool, error) {
+	start := time.Now()
+	result, err := s.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+			"role":    &types.AttributeValueMemberS{Value: role},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return false, db.Wrap(err, "revoking role")
+	}
+	s.Cache.Invalidate(cacheKey(userID))
+	return len(result.Attributes) > 0, nil
+}
+
+// WithRoles returns a TokenVerifier that also fills in the roles s grants
+// the verified identity. Like revocation, a failed lookup rejects the token.
+func WithRoles(v auth.TokenVerifier, s *Store) auth.TokenVerifier {
+	return roleVerifier{next: v, store: s}
+}
+
+type roleVerifier struct {
+	next  auth.TokenVerifier
+	store *Store
+}
+
+func (v roleVerifier) Verify(ctx context.Context, token string) (*auth.Identity, error) {
+	id, err := v.next.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := v.store.Roles(ctx, id.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("loading roles: %w", err)
+	}
+	id.Roles = roles
+	return id, nil
+}
@@ -0,0 +1,139 @@
+package authz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+)
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		name string
+		id   *auth.Identity
+		perm string
+		want bool
+	}{
+		{name: "user", id: &auth.Identity{Subject: "u1"}, perm: UsersList, want: false},
+		{name: "unknown group", id: &auth.Identity{Groups: []string{"beta"}}, perm: UsersList, want: false},
+		{name: "moderator group", id: &auth.Identity{Groups: []string{Moderator}}, perm: UsersSuspend, want: true},
+		{name: "moderator cannot ban", id: &auth.Identity{Groups: []string{Moderator}}, perm: UsersBan, want: false},
+		{name: "granted moderator", id: &auth.Identity{Roles: []string{Moderator}}, perm: SessionsManage, want: true},
+		{name: "admin group", id: &auth.Identity{Groups: []string{Admin}}, perm: RolesManage, want: true},
+		{name: "granted admin", id: &auth.Identity{Roles: []string{Admin}}, perm: UsersActAs, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Allowed(tt.id, tt.perm); got != tt.want {
+				t.Errorf("Allowed(%s) = %v, want %v", tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       *auth.Identity // nil for no caller
+		wantKind apperr.Kind
+	}{
+		{name: "allowed", id: &auth.Identity{Subject: "a1", Groups: []string{Admin}}},
+		{name: "forbidden", id: &auth.Identity{Subject: "u1"}, wantKind: apperr.KindForbidden},
+		{name: "no caller", wantKind: apperr.KindUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.id != nil {
+				ctx = auth.NewContext(ctx, tt.id)
+			}
+			err := Require(ctx, UsersBan)
+			if tt.wantKind == 0 {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+			if kind := apperr.KindOf(err); kind != tt.wantKind {
+				t.Errorf("err = %v, want kind %v", err, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestClaimsAllow(t *testing.T) {
+	r := &httpx.Request{Claims: map[string]string{"cognito:groups": "[beta moderator]"}}
+	if !ClaimsAllow(r, UsersList) {
+		t.Error("moderator claim does not grant users:list")
+	}
+	if ClaimsAllow(r, UsersDelete) {
+		t.Error("moderator claim grants users:delete")
+	}
+}
+
+func TestStore(t *testing.T) {
+	var queries int
+	stored := map[string]bool{}
+	m := &dbtest.Mock{
+		QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			queries++
+			var items []db.Item
+			for role := range stored {
+				items = append(items, dbtest.Item("user_id", "u1", "role", role))
+			}
+			return &dynamodb.QueryOutput{Items: items}, nil
+		},
+		PutItemFunc: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			if stored[Moderator] {
+				return nil, dbtest.ConditionFailed()
+			}
+			stored[Moderator] = true
+			return &dynamodb.PutItemOutput{}, nil
+		},
+		DeleteItemFunc: func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+			if !stored[Moderator] {
+				return &dynamodb.DeleteItemOutput{}, nil
+			}
+			delete(stored, Moderator)
+			return &dynamodb.DeleteItemOutput{Attributes: dbtest.Item("user_id", "u1", "role", Moderator)}, nil
+		},
+	}
+	s := &Store{DB: m.Client(), Table: "roles", Cache: db.NewCache(10, time.Minute)}
+	ctx := context.Background()
+
+	check := func(step string, want int, wantQueries int) {
+		t.Helper()
+		roles, err := s.Roles(ctx, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(roles) != want || queries != wantQueries {
+			t.Errorf("%s: roles = %v after %d queries, want %d roles after %d", step, roles, queries, want, wantQueries)
+		}
+	}
+	check("initially", 0, 1)
+	check("cached", 0, 1)
+
+	if granted, err := s.Grant(ctx, "u1", Moderator, "a1", time.Now()); err != nil || !granted {
+		t.Fatalf("Grant = %v, %v, want true", granted, err)
+	}
+	if granted, err := s.Grant(ctx, "u1", Moderator, "a1", time.Now()); err != nil || granted {
+		t.Fatalf("second Grant = %v, %v, want false", granted, err)
+	}
+	check("granted", 1, 2)
+
+	if revoked, err := s.Revoke(ctx, "u1", Moderator); err != nil || !revoked {
+		t.Fatalf("Revoke = %v, %v, want true", revoked, err)
+	}
+	if revoked, err := s.Revoke(ctx, "u1", Moderator); err != nil || revoked {
+		t.Fatalf("second Revoke = %v, %v, want false", revoked, err)
+	}
+	check("revoked", 0, 3)
+}
@@ -0,0 +1,352 @@
+// Package avatars stores profile pictures in the avatar bucket.
+//
+// Clients never send image bytes through API Gateway. getAvatarUploadUrl
+// hands out a presigned PUT of an object under uploads/, bound to the
+// content type and size the client declared; the bucket's ObjectCreated
+// notification then invokes processAvatar, which checks the upload, writes
+// square JPEG thumbnails under avatars/ (see package media) and points the
+// user record at them. Accepted uploads are then deleted; rejected ones are
+// moved under quarantine/ for review. Bucket lifecycle rules expire both
+// prefixes, catching anything left behind.
+package avatars
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/media"
+	"troggle-backend/internal/users"
+)
+
+// ContentTypes are the image types accepted for upload.
+var ContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Sizes are the edge lengths, in pixels, of the thumbnails generated for
+// every avatar. The user record's avatar_url is the largest.
+var Sizes = []int{64, 256, 512}
+
+// UploadTTL is how long an upload URL can be used.
+const UploadTTL = 5 * time.Minute
+
+// ErrRejected marks uploads that are not acceptable avatars. They are
+// quarantined rather than retried.
+var ErrRejected = errors.New("avatar rejected")
+
+// Upload is a presigned avatar upload. Clients PUT the image to URL with
+// every header in Headers.
+type Upload struct {
+	UploadID  string            `json:"upload_id"`
+	URL       string            `json:"upload_url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt string            `json:"expires_at"` // RFC 3339
+}
+
+// Avatar is a processed avatar: the URL of each thumbnail by size.
+type Avatar struct {
+	ID         string            `dynamodbav:"avatar_id"`
+	URL        string            `dynamodbav:"avatar_url"`
+	Thumbnails map[string]string `dynamodbav:"avatar_thumbnails"`
+}
+
+// UploadKey names the object uploadID of userID is uploaded to.
+func UploadKey(userID, uploadID string) string {
+	return "uploads/" + userID + "/" + uploadID
+}
+
+// ParseUploadKey is the inverse of UploadKey.
+func ParseUploadKey(key string) (userID, uploadID string, ok bool) {
+	rest, found := strings.CutPrefix(key, "uploads/")
+	if !found {
+		return "", "", false
+	}
+	userID, uploadID, found = strings.Cut(rest, "/")
+	if !found || userID == "" || uploadID == "" || strings.Contains(uploadID, "/") {
+		return "", "", false
+	}
+	return userID, uploadID, true
+}
+
+// QuarantineKey names the object a rejected upload at key is moved to.
+func QuarantineKey(key string) string {
+	return "quarantine/" + strings.TrimPrefix(key, "uploads/")
+}
+
+// ThumbnailKey names the thumbnail of the given size of an avatar. Keys are
+// never reused, so the CDN may cache them indefinitely.
+func ThumbnailKey(userID, avatarID string, size int) string {
+	return "avatars/" + userID + "/" + avatarID + "/" + strconv.Itoa(size) + ".jpg"
+}
+
+// ObjectAPI is the part of the S3 API the store uses.
+type ObjectAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// PresignAPI is the part of the S3 presign client the store uses.
+type PresignAPI interface {
+	PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// Store keeps avatars in the avatar bucket and on user records.
+type Store struct {
+	S3        ObjectAPI
+	Presign   PresignAPI
+	DB        *db.Client
+	Bucket    string
+	BaseURL   string // public URL prefix of the bucket, without a trailing slash
+	MaxBytes  int64
+	UserTable string
+}
+
+// NewStore returns a store over the avatar bucket and user table named in
+// cfg.
+func NewStore(client *s3.Client, dbClient *db.Client, cfg *config.Config) *Store {
+	return &Store{
+		S3:        client,
+		Presign:   s3.NewPresignClient(client),
+		DB:        dbClient,
+		Bucket:    cfg.AvatarBucket,
+		BaseURL:   cfg.AvatarBaseURL,
+		MaxBytes:  cfg.AvatarMaxBytes,
+		UserTable: cfg.UserTableName,
+	}
+}
+
+// NewID returns a random upload ID. A processed upload's ID becomes its
+// avatar ID.
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// PresignUpload returns an upload URL for an image of contentType and size
+// bytes. Both are signed into the URL, so S3 refuses a different type or
+// length; processAvatar checks the object again all the same.
+func (s *Store) PresignUpload(ctx context.Context, userID, contentType string, size int64) (Upload, error) {
+	if !ContentTypes[contentType] {
+		return Upload{}, apperr.Invalid("AVATAR_TYPE", "content_type", "content_type must be image/jpeg, image/png or image/gif")
+	}
+	if size < 1 || size > s.MaxBytes {
+		return Upload{}, apperr.Invalid("AVATAR_SIZE", "size", fmt.Sprintf("size must be between 1 and %d bytes", s.MaxBytes))
+	}
+
+	uploadID := NewID()
+	expires := time.Now().Add(UploadTTL).UTC().Truncate(time.Second)
+	req, err := s.Presign.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(UploadKey(userID, uploadID)),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(UploadTTL))
+	if err != nil {
+		return Upload{}, fmt.Errorf("presigning avatar upload: %w", err)
+	}
+
+	headers := map[string]string{}
+	for name := range req.SignedHeader {
+		// Clients cannot set Host; their HTTP library derives it from the URL
+		if !strings.EqualFold(name, "host") {
+			headers[name] = req.SignedHeader.Get(name)
+		}
+	}
+	return Upload{
+		UploadID:  uploadID,
+		URL:       req.URL,
+		Method:    req.Method,
+		Headers:   headers,
+		ExpiresAt: expires.Format(time.RFC3339),
+	}, nil
+}
+
+// Fetch reads the uploaded object key and its declared content type,
+// returning nil if it no longer exists (a redelivered notification of a
+// processed upload). Objects declared as another type than an accepted image
+// or exceeding the size limit are ErrRejected.
+func (s *Store) Fetch(ctx context.Context, key string) (body []byte, contentType string, err error) {
+	out, err := s.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *s3types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("getting avatar upload: %w", err)
+	}
+	defer out.Body.Close()
+
+	contentType = aws.ToString(out.ContentType)
+	if !ContentTypes[contentType] {
+		return nil, "", fmt.Errorf("%w: content type %q", ErrRejected, contentType)
+	}
+	if aws.ToInt64(out.ContentLength) > s.MaxBytes {
+		return nil, "", fmt.Errorf("%w: %d bytes", ErrRejected, aws.ToInt64(out.ContentLength))
+	}
+	// The length header is checked above; the limit guards against a body
+	// that does not match it
+	body, err = io.ReadAll(io.LimitReader(out.Body, s.MaxBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading avatar upload: %w", err)
+	}
+	if int64(len(body)) > s.MaxBytes {
+		return nil, "", fmt.Errorf("%w: more than %d bytes", ErrRejected, s.MaxBytes)
+	}
+	return body, contentType, nil
+}
+
+// Save decodes the uploaded image, declared as contentType, and writes its
+// thumbnails as the avatar avatarID of userID. Uploads media does not accept
+// are ErrRejected.
+func (s *Store) Save(ctx context.Context, userID, avatarID string, image []byte, contentType string) (Avatar, error) {
+	thumbnails, err := media.Thumbnails(image, contentType, Sizes)
+	if errors.Is(err, media.ErrInvalid) {
+		return Avatar{}, fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	if err != nil {
+		return Avatar{}, err
+	}
+
+	avatar := Avatar{ID: avatarID, Thumbnails: map[string]string{}}
+	for i, size := range Sizes {
+		key := ThumbnailKey(userID, avatarID, size)
+		_, err := s.S3.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:       aws.String(s.Bucket),
+			Key:          aws.String(key),
+			Body:         bytes.NewReader(thumbnails[i]),
+			ContentType:  aws.String("image/jpeg"),
+			CacheControl: aws.String("public, max-age=31536000, immutable"),
+		})
+		if err != nil {
+			return Avatar{}, fmt.Errorf("uploading %dpx thumbnail: %w", size, err)
+		}
+		avatar.Thumbnails[strconv.Itoa(size)] = s.BaseURL + "/" + key
+	}
+	avatar.URL = avatar.Thumbnails[strconv.Itoa(Sizes[len(Sizes)-1])]
+	return avatar, nil
+}
+
+// Assign makes avatar the user's current avatar, bumping the profile version
+// so concurrent profile updates notice, and returns the avatar it replaced
+// (zero if none) and the new version. A missing user is an apperr.NotFound
+// error.
+func (s *Store) Assign(ctx context.Context, userID string, avatar Avatar) (previous Avatar, version int, err error) {
+	thumbnails, err := attributevalue.Marshal(avatar.Thumbnails)
+	if err != nil {
+		return Avatar{}, 0, fmt.Errorf("encoding thumbnails: %w", err)
+	}
+
+	start := time.Now()
+	result, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.UserTable),
+		Key:                 users.Key(userID),
+		UpdateExpression:    aws.String("SET avatar_id = :id, avatar_url = :url, avatar_thumbnails = :thumbnails, updated_at = :now, #version = if_not_exists(#version, :zero) + :one"),
+		ConditionExpression: aws.String("attribute_exists(user_id)"),
+		ExpressionAttributeNames: map[string]string{
+			"#version": "version",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id":         &types.AttributeValueMemberS{Value: avatar.ID},
+			":url":        &types.AttributeValueMemberS{Value: avatar.URL},
+			":thumbnails": thumbnails,
+			":now":        &types.AttributeValueMemberS{Value: start.UTC().Format(time.RFC3339)},
+			":zero":       &types.AttributeValueMemberN{Value: "0"},
+			":one":        &types.AttributeValueMemberN{Value: "1"},
+		},
+		ReturnValues: types.ReturnValueUpdatedOld,
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return Avatar{}, 0, apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return Avatar{}, 0, db.Wrap(err, "assigning avatar of "+userID)
+	}
+
+	var old struct {
+		Avatar
+		Version int `dynamodbav:"version"`
+	}
+	if err := attributevalue.UnmarshalMap(result.Attributes, &old); err != nil {
+		return Avatar{}, 0, fmt.Errorf("decoding user item: %w", err)
+	}
+	return old.Avatar, old.Version + 1, nil
+}
+
+// Quarantine moves the rejected upload at key under quarantine/, recording
+// reason in its metadata, and returns the new key.
+func (s *Store) Quarantine(ctx context.Context, key, reason string) (string, error) {
+	dest := QuarantineKey(key)
+	_, err := s.S3.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(s.Bucket),
+		Key:               aws.String(dest),
+		CopySource:        aws.String(url.PathEscape(s.Bucket + "/" + key)),
+		MetadataDirective: s3types.MetadataDirectiveReplace,
+		Metadata:          map[string]string{"rejection-reason": truncate(reason, 1024)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("quarantining %s: %w", key, err)
+	}
+	return dest, s.Delete(ctx, key)
+}
+
+// truncate shortens s to at most n bytes, S3 bounding metadata size.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// Delete removes the objects at keys. Missing objects are not an error.
+func (s *Store) Delete(ctx context.Context, keys ...string) error {
+	var errs []error
+	for _, key := range keys {
+		_, err := s.S3.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("deleting %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ThumbnailKeys returns the keys of every thumbnail of avatarID.
+func ThumbnailKeys(userID, avatarID string) []string {
+	keys := make([]string, len(Sizes))
+	for i, size := range Sizes {
+		keys[i] = ThumbnailKey(userID, avatarID, size)
+	}
+	return keys
+}
@@ -0,0 +1,152 @@
+package avatars
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/media"
+)
+
+func TestParseUploadKey(t *testing.T) {
+	tests := []struct {
+		key        string
+		wantUser   string
+		wantUpload string
+		wantOK     bool
+	}{
+		{key: UploadKey("u1", "a1"), wantUser: "u1", wantUpload: "a1", wantOK: true},
+		{key: "avatars/u1/a1/64.jpg"},
+		{key: "uploads/u1"},
+		{key: "uploads/u1/a1/extra"},
+		{key: "uploads//a1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			user, upload, ok := ParseUploadKey(tt.key)
+			if user != tt.wantUser || upload != tt.wantUpload || ok != tt.wantOK {
+				t.Errorf("ParseUploadKey = %q, %q, %v; want %q, %q, %v", user, upload, ok, tt.wantUser, tt.wantUpload, tt.wantOK)
+			}
+		})
+	}
+}
+
+// fakeS3 serves one object and presigns any upload.
+type fakeS3 struct {
+	contentType string
+	body        []byte
+}
+
+func (f *fakeS3) GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(f.body)),
+		ContentType:   aws.String(f.contentType),
+		ContentLength: aws.Int64(int64(len(f.body))),
+	}, nil
+}
+
+func (f *fakeS3) PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) CopyObject(context.Context, *s3.CopyObjectInput, ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) PresignPutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return &v4.PresignedHTTPRequest{
+		URL:          "https://bucket.example/" + aws.ToString(in.Key) + "?sig",
+		Method:       http.MethodPut,
+		SignedHeader: http.Header{"Host": {"bucket.example"}, "Content-Type": {aws.ToString(in.ContentType)}},
+	}, nil
+}
+
+func TestPresignUpload(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		size        int64
+		wantErr     bool
+	}{
+		{name: "png", contentType: "image/png", size: 1000},
+		{name: "svg", contentType: "image/svg+xml", size: 1000, wantErr: true},
+		{name: "too large", contentType: "image/jpeg", size: 1001, wantErr: true},
+		{name: "empty", contentType: "image/jpeg", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{Presign: &fakeS3{}, Bucket: "bucket", MaxBytes: 1000}
+
+			u, err := s.PresignUpload(context.Background(), "u1", tt.contentType, tt.size)
+			if tt.wantErr {
+				if apperr.KindOf(err) != apperr.KindInvalid {
+					t.Fatalf("err = %v, want a validation error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Method != http.MethodPut || u.Headers["Content-Type"] != tt.contentType {
+				t.Errorf("upload = %+v", u)
+			}
+			if _, ok := u.Headers["Host"]; ok {
+				t.Error("Host header returned to the client")
+			}
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantErr     error
+	}{
+		{name: "image", contentType: "image/png", body: []byte("png")},
+		{name: "wrong type", contentType: "text/html", body: []byte("<html>"), wantErr: ErrRejected},
+		{name: "too large", contentType: "image/png", body: make([]byte, 11), wantErr: ErrRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{S3: &fakeS3{contentType: tt.contentType, body: tt.body}, Bucket: "bucket", MaxBytes: 10}
+
+			body, contentType, err := s.Fetch(context.Background(), "uploads/u1/a1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (!bytes.Equal(body, tt.body) || contentType != tt.contentType) {
+				t.Errorf("Fetch = %q, %q", body, contentType)
+			}
+		})
+	}
+}
+
+func TestSaveRejectsDisguisedUpload(t *testing.T) {
+	s := &Store{S3: &fakeS3{}, Bucket: "bucket", MaxBytes: 1000}
+
+	// Declared (and signed) as PNG, but the bytes are a GIF
+	_, err := s.Save(context.Background(), "u1", "a1", []byte("GIF89a\x01\x00\x01\x00"), "image/png")
+	if !errors.Is(err, ErrRejected) || !errors.Is(err, media.ErrInvalid) {
+		t.Errorf("err = %v, want a rejection", err)
+	}
+}
+
+func TestQuarantineKey(t *testing.T) {
+	if got, want := QuarantineKey(UploadKey("u1", "a1")), "quarantine/u1/a1"; got != want {
+		t.Errorf("QuarantineKey = %q, want %q", got, want)
+	}
+}
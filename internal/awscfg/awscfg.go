@@ -0,0 +1,82 @@
+// Package awscfg loads the AWS SDK configuration shared by every client a
+// troggle Lambda creates (DynamoDB, Cognito, EventBridge, ...), so retry
+// policy and region overrides are defined in exactly one place.
+package awscfg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config" // AWS SDK config loader
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/tracing"
+	"troggle-backend/internal/warmup"
+)
+
+const (
+	// maxAttempts is the total number of tries (first call + retries) the SDK
+	// makes for a throttled or transient failure.
+	maxAttempts = 5
+
+	// maxBackoff caps the delay between two retries.
+	maxBackoff = 2 * time.Second
+)
+
+var (
+	sharedOnce sync.Once
+	sharedCfg  aws.Config
+	sharedErr  error
+)
+
+// Shared returns the container-wide AWS config, loading it on first use.
+// cfg is only consulted by the first call. Warming it resolves the
+// credentials, which the first SDK call would otherwise wait for.
+func Shared(ctx context.Context, cfg *config.Config) (aws.Config, error) {
+	sharedOnce.Do(func() {
+		sharedCfg, sharedErr = Load(ctx, cfg)
+		if sharedErr == nil && sharedCfg.Credentials != nil {
+			warmup.Register("credentials", func(ctx context.Context) error {
+				_, err := sharedCfg.Credentials.Retrieve(ctx)
+				return err
+			})
+		}
+	})
+	return sharedCfg, sharedErr
+}
+
+// Load resolves the default AWS config (credentials, region, etc.) with a
+// retryer that backs off on throttled and transient failures and X-Ray
+// instrumentation. A region set in cfg takes precedence over the SDK's own
+// resolution.
+func Load(ctx context.Context, cfg *config.Config) (aws.Config, error) {
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRetryer(func() aws.Retryer { return Retryer() }),
+	}
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("loading AWS config: %w", err)
+	}
+
+	// Trace every SDK call made by clients built from this config
+	tracing.InstrumentAWS(&awsCfg)
+	return awsCfg, nil
+}
+
+// Retryer returns the retryer of the shared config, adjusted by opts.
+// Clients that handle some failures themselves, such as DynamoDB's
+// throttling, build theirs with it so the rest of the policy stays the same.
+func Retryer(opts ...func(*retry.StandardOptions)) aws.Retryer {
+	return retry.NewStandard(append([]func(*retry.StandardOptions){func(o *retry.StandardOptions) {
+		o.MaxAttempts = maxAttempts
+		o.MaxBackoff = maxBackoff
+	}}, opts...)...)
+}
@@ -0,0 +1,66 @@
+// Package buildinfo identifies the build serving traffic: the git commit it
+// was built from, when, and with which Go version. Log lines and error
+// responses carry the commit, and GET /meta/version reports all of it.
+//
+// The Makefile sets Commit and Time at link time:
+//
+//	go build -ldflags "-X troggle-backend/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	    -X troggle-backend/internal/buildinfo.Time=$(git log -1 --format=%cI)" ./getUserProfile
+//
+// Builds without the flags fall back to the VCS stamp go build embeds when
+// building in a git checkout, and report "unknown" otherwise, e.g. in tests.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Unknown is reported for what neither the flags nor the VCS stamp tell.
+const Unknown = "unknown"
+
+// Set with -ldflags "-X troggle-backend/internal/buildinfo.Commit=...".
+var (
+	Commit string // full git SHA of the build
+	Time   string // RFC 3339 time of the build
+)
+
+// Info describes a build.
+type Info struct {
+	Commit    string `json:"git_sha"`
+	Time      string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	Modified  bool   `json:"modified,omitempty"` // built from a checkout with uncommitted changes
+}
+
+// Get returns the info of the running build.
+var Get = sync.OnceValue(read)
+
+// read combines the link-time variables with the VCS stamp.
+func read() Info {
+	info := Info{Commit: Commit, Time: Time, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.Time == "" {
+					info.Time = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = Unknown
+	}
+	if info.Time == "" {
+		info.Time = Unknown
+	}
+	return info
+}
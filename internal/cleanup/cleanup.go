@@ -0,0 +1,320 @@
+// Package cleanup removes the records nothing else removes: those that
+// outlived the user they belong to, because an account erasure failed
+// halfway or raced a sign-in, and those past an expiry DynamoDB's TTL has
+// not caught up with yet. A daily job sweeps for:
+//
+//   - sessions of users who no longer exist, or long expired;
+//   - friend requests left unanswered for FRIEND_REQUEST_TTL;
+//   - device tokens of users who no longer exist, or long expired;
+//   - email and username reservations of users who no longer exist, the
+//     remains of half-deleted accounts.
+//
+// Each table is read with a parallel scan of CLEANUP_SEGMENTS segments and
+// its records are deleted in batches, CLEANUP_BATCH_RATE batches a second
+// at most across all segments, so the job never competes with the API for
+// write capacity. Whatever a failed run leaves behind, the next one finds
+// again.
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/users"
+)
+
+const (
+	// StaleAfter is how long past their expiry records are left to the
+	// table's TTL, which usually removes them within a couple of days.
+	StaleAfter = 48 * time.Hour
+
+	// OrphanGrace is how old a session or device must be before the lack
+	// of its user makes it an orphan: the user record of a first sign-in
+	// may be written after the session.
+	OrphanGrace = 24 * time.Hour
+)
+
+// Report counts the records one run removed.
+type Report struct {
+	Sessions    int `json:"sessions"`    // orphaned or stale sessions
+	Invitations int `json:"invitations"` // expired friend requests
+	Devices     int `json:"devices"`     // dangling device tokens
+	Accounts    int `json:"accounts"`    // reservations of half-deleted accounts
+}
+
+// Sweeper removes stale and orphaned records.
+type Sweeper struct {
+	DB                *db.Client
+	UserTable         string
+	SessionTable      string
+	DeviceTable       string
+	RelationshipTable string
+	FriendRequestTTL  time.Duration
+	Segments          int // parallel scan segments of each table
+	BatchRate         int // delete batches per second, across segments
+}
+
+// NewSweeper returns a sweeper over the tables named in cfg.
+func NewSweeper(client *db.Client, cfg *config.Config) *Sweeper {
+	return &Sweeper{
+		DB:                client,
+		UserTable:         cfg.UserTableName,
+		SessionTable:      cfg.SessionTableName,
+		DeviceTable:       cfg.DeviceTableName,
+		RelationshipTable: cfg.RelationshipTableName,
+		FriendRequestTTL:  cfg.FriendRequestTTL,
+		Segments:          cfg.CleanupSegments,
+		BatchRate:         cfg.CleanupBatchRate,
+	}
+}
+
+// Run sweeps every table and reports what it removed. A sweep that fails
+// does not stop the others; the report counts what was removed before the
+// failure too.
+func (s *Sweeper) Run(ctx context.Context, now time.Time) (Report, error) {
+	pace := time.NewTicker(time.Second / time.Duration(max(s.BatchRate, 1)))
+	defer pace.Stop()
+	r := &run{Sweeper: s, now: now.UTC(), pace: pace.C}
+
+	var report Report
+	var errs []error
+	for _, sweep := range []struct {
+		count *int
+		fn    func(context.Context) (int, error)
+	}{
+		{&report.Sessions, r.sessions},
+		{&report.Invitations, r.invitations},
+		{&report.Devices, r.devices},
+		{&report.Accounts, r.accounts},
+	} {
+		n, err := sweep.fn(ctx)
+		*sweep.count = n
+		errs = append(errs, err)
+	}
+	return report, errors.Join(errs...)
+}
+
+// run is one cleanup run.
+type run struct {
+	*Sweeper
+	now  time.Time
+	pace <-chan time.Time // ticks once for every batch that may be deleted
+
+	users sync.Map // user_id → whether the user record exists
+}
+
+// sessions removes the sessions of missing users and the long expired.
+func (r *run) sessions(ctx context.Context) (int, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(r.SessionTable),
+		ProjectionExpression: aws.String("user_id, session_id, issued_at, expires_at"),
+	}
+	return r.sweep(ctx, input, func(ctx context.Context, item db.Item) ([]db.Item, error) {
+		key := db.Item{"user_id": item["user_id"], "session_id": item["session_id"]}
+		if r.stale(item) {
+			return []db.Item{key}, nil
+		}
+		issued, _ := time.Parse(time.RFC3339, str(item, "issued_at"))
+		return r.orphan(ctx, item, issued, key)
+	})
+}
+
+// devices removes the device tokens of missing users and the long expired.
+func (r *run) devices(ctx context.Context) (int, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(r.DeviceTable),
+		ProjectionExpression: aws.String("user_id, #token, registered_at, expires_at"),
+		ExpressionAttributeNames: map[string]string{
+			"#token": "token", // reserved word
+		},
+	}
+	return r.sweep(ctx, input, func(ctx context.Context, item db.Item) ([]db.Item, error) {
+		key := db.Item{"user_id": item["user_id"], "token": item["token"]}
+		if r.stale(item) {
+			return []db.Item{key}, nil
+		}
+		registered, _ := time.Parse(time.RFC3339, str(item, "registered_at"))
+		return r.orphan(ctx, item, registered, key)
+	})
+}
+
+// invitations removes the friend requests older than FriendRequestTTL.
+// Requests are found by their sender's half, and both halves are deleted
+// together.
+func (r *run) invitations(ctx context.Context) (int, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(r.RelationshipTable),
+		FilterExpression:     aws.String("begins_with(edge, :out) AND created_at < :cutoff"),
+		ProjectionExpression: aws.String("user_id, edge, other_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":out":    &types.AttributeValueMemberS{Value: relationships.RequestOut + "#"},
+			":cutoff": &types.AttributeValueMemberS{Value: r.now.Add(-r.FriendRequestTTL).Format(time.RFC3339)},
+		},
+	}
+	return r.sweep(ctx, input, func(_ context.Context, item db.Item) ([]db.Item, error) {
+		from, to := str(item, "user_id"), str(item, "other_id")
+		return []db.Item{
+			relationships.Key(from, relationships.RequestOut, to),
+			relationships.Key(to, relationships.RequestIn, from),
+		}, nil
+	})
+}
+
+// accounts removes the email and username reservations whose owner is
+// gone. Reservations are written in the transactions that write their
+// owner, so they are never younger than it.
+func (r *run) accounts(ctx context.Context) (int, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(r.UserTable),
+		FilterExpression:     aws.String("begins_with(user_id, :email) OR begins_with(user_id, :username)"),
+		ProjectionExpression: aws.String("user_id, #owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":email":    &types.AttributeValueMemberS{Value: users.EmailLockPrefix},
+			":username": &types.AttributeValueMemberS{Value: users.UsernameLockPrefix},
+		},
+	}
+	return r.sweep(ctx, input, func(ctx context.Context, item db.Item) ([]db.Item, error) {
+		owner := str(item, "owner")
+		if owner == "" {
+			// Not a reservation this sweep understands
+			return nil, nil
+		}
+		exists, err := r.exists(ctx, owner)
+		if err != nil || exists {
+			return nil, err
+		}
+		return []db.Item{{"user_id": item["user_id"]}}, nil
+	})
+}
+
+// sweep scans input and deletes, in batches on the table scanned, the keys
+// pick returns for each item; pick returns none for items to keep. It
+// returns how many items had keys deleted.
+func (r *run) sweep(ctx context.Context, input *dynamodb.ScanInput, pick func(ctx context.Context, item db.Item) ([]db.Item, error)) (int, error) {
+	var removed atomic.Int64
+	err := r.DB.ScanSegments(ctx, input, r.Segments, func(ctx context.Context, items []db.Item) error {
+		b := &batch{run: r, table: aws.ToString(input.TableName), removed: &removed}
+		for _, item := range items {
+			keys, err := pick(ctx, item)
+			if err != nil {
+				return err
+			}
+			if err := b.add(ctx, keys); err != nil {
+				return err
+			}
+		}
+		return b.flush(ctx)
+	})
+	return int(removed.Load()), err
+}
+
+// stale reports whether item expired over StaleAfter ago.
+func (r *run) stale(item db.Item) bool {
+	v, ok := item["expires_at"].(*types.AttributeValueMemberN)
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(v.Value, 10, 64)
+	return err == nil && time.Unix(expires, 0).Add(StaleAfter).Before(r.now)
+}
+
+// orphan returns key if item, created at created, is old enough and its
+// user is gone.
+func (r *run) orphan(ctx context.Context, item db.Item, created time.Time, key db.Item) ([]db.Item, error) {
+	if created.IsZero() || r.now.Sub(created) < OrphanGrace {
+		return nil, nil
+	}
+	exists, err := r.exists(ctx, str(item, "user_id"))
+	if err != nil || exists {
+		return nil, err
+	}
+	return []db.Item{key}, nil
+}
+
+// exists reports whether the record of userID exists, reading each user's
+// once per run. The read is strongly consistent, so a user just created is
+// never taken for a deleted one.
+func (r *run) exists(ctx context.Context, userID string) (bool, error) {
+	if v, ok := r.users.Load(userID); ok {
+		return v.(bool), nil
+	}
+	start := time.Now()
+	result, err := r.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:            aws.String(r.UserTable),
+		Key:                  users.Key(userID),
+		ProjectionExpression: aws.String("user_id"),
+		ConsistentRead:       aws.Bool(true),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return false, db.Wrap(err, "getting user "+userID)
+	}
+	exists := result.Item != nil
+	r.users.Store(userID, exists)
+	return exists, nil
+}
+
+// batch collects the keys one segment deletes from a table.
+type batch struct {
+	*run
+	table   string
+	keys    []db.Item
+	records int           // whose keys are collected
+	removed *atomic.Int64 // records deleted by the sweep
+}
+
+// add collects the keys of one record, deleting the collected ones first
+// if they would not fit in the same batch.
+func (b *batch) add(ctx context.Context, keys []db.Item) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if len(b.keys)+len(keys) > db.MaxBatchWrite {
+		if err := b.flush(ctx); err != nil {
+			return err
+		}
+	}
+	b.keys = append(b.keys, keys...)
+	b.records++
+	return nil
+}
+
+// flush deletes the collected keys, once the pace allows another batch.
+func (b *batch) flush(ctx context.Context) error {
+	if len(b.keys) == 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.pace:
+	}
+	if err := b.DB.BatchDelete(ctx, b.table, b.keys); err != nil {
+		return err
+	}
+	b.removed.Add(int64(b.records))
+	b.keys, b.records = nil, 0
+	return nil
+}
+
+// str returns the string attribute name of item, or "".
+func str(item db.Item, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
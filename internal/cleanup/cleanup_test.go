@@ -0,0 +1,176 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+var now = time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC)
+
+// expiring returns item with expires_at set to at.
+func expiring(item db.Item, at time.Time) db.Item {
+	item["expires_at"] = &types.AttributeValueMemberN{Value: fmt.Sprint(at.Unix())}
+	return item
+}
+
+// ago formats the time d before now.
+func ago(d time.Duration) string {
+	return now.Add(-d).Format(time.RFC3339)
+}
+
+func TestRun(t *testing.T) {
+	day := 24 * time.Hour
+	tables := map[string][]db.Item{
+		"sessions": {
+			expiring(dbtest.Item("user_id", "u1", "session_id", "live", "issued_at", ago(day)), now.Add(day)),
+			expiring(dbtest.Item("user_id", "u1", "session_id", "stale", "issued_at", ago(40*day)), now.Add(-3*day)),
+			expiring(dbtest.Item("user_id", "gone", "session_id", "orphan", "issued_at", ago(2*day)), now.Add(day)),
+			expiring(dbtest.Item("user_id", "new", "session_id", "first", "issued_at", ago(time.Hour)), now.Add(day)),
+		},
+		"relationships": {
+			dbtest.Item("user_id", "u1", "edge", "REQUEST_OUT#u2", "other_id", "u2"),
+		},
+		"devices": {
+			expiring(dbtest.Item("user_id", "gone", "token", "t1", "registered_at", ago(10*day)), now.Add(day)),
+			expiring(dbtest.Item("user_id", "u1", "token", "t2", "registered_at", ago(10*day)), now.Add(day)),
+		},
+		"users": {
+			dbtest.Item("user_id", "EMAIL#a@example.com", "owner", "u1"),
+			dbtest.Item("user_id", "UNAME#gone", "owner", "gone"),
+		},
+	}
+	var mu sync.Mutex
+	var deleted []string
+	m := &dbtest.Mock{
+		ScanFunc: func(in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+			if aws.ToInt32(in.TotalSegments) != 2 {
+				t.Errorf("scan of %d segments, want 2", aws.ToInt32(in.TotalSegments))
+			}
+			if aws.ToInt32(in.Segment) != 0 {
+				return &dynamodb.ScanOutput{}, nil
+			}
+			return &dynamodb.ScanOutput{Items: tables[aws.ToString(in.TableName)]}, nil
+		},
+		GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			id := in.Key["user_id"].(*types.AttributeValueMemberS).Value
+			if id == "gone" {
+				return &dynamodb.GetItemOutput{}, nil
+			}
+			return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", id)}, nil
+		},
+		BatchWriteItemFunc: func(in *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			for table, requests := range in.RequestItems {
+				for _, r := range requests {
+					var parts []string
+					for _, name := range []string{"user_id", "session_id", "edge", "token"} {
+						if v, ok := r.DeleteRequest.Key[name].(*types.AttributeValueMemberS); ok {
+							parts = append(parts, v.Value)
+						}
+					}
+					deleted = append(deleted, fmt.Sprintf("%s %v", table, parts))
+				}
+			}
+			return &dynamodb.BatchWriteItemOutput{}, nil
+		},
+	}
+	s := &Sweeper{
+		DB: m.Client(), UserTable: "users", SessionTable: "sessions", DeviceTable: "devices", RelationshipTable: "relationships",
+		FriendRequestTTL: 30 * day, Segments: 2, BatchRate: 1000,
+	}
+
+	report, err := s.Run(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Report{Sessions: 2, Invitations: 1, Devices: 1, Accounts: 1}); report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+	slices.Sort(deleted)
+	want := []string{
+		"devices [gone t1]",
+		"relationships [u1 REQUEST_OUT#u2]",
+		"relationships [u2 REQUEST_IN#u1]",
+		"sessions [gone orphan]",
+		"sessions [u1 stale]",
+		"users [UNAME#gone]",
+	}
+	if !slices.Equal(deleted, want) {
+		t.Errorf("deleted %q, want %q", deleted, want)
+	}
+	gets := 0
+	for _, op := range m.Ops() {
+		if op == "GetItem" {
+			gets++
+		}
+	}
+	// u1 and gone, each read once
+	if gets != 2 {
+		t.Errorf("%d user reads, want 2", gets)
+	}
+}
+
+func TestRunBatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		requests    int
+		batchErr    error
+		wantBatches int
+		wantRemoved int
+		wantErr     bool
+	}{
+		{name: "one batch", requests: 12, wantBatches: 1, wantRemoved: 12},
+		{name: "requests kept whole", requests: 13, wantBatches: 2, wantRemoved: 13},
+		{name: "failing batch", requests: 13, batchErr: dbtest.Throttled(), wantBatches: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []db.Item
+			for i := range tt.requests {
+				items = append(items, dbtest.Item("user_id", fmt.Sprint("u", i), "edge", "REQUEST_OUT#x", "other_id", "x"))
+			}
+			var sizes []int
+			m := &dbtest.Mock{
+				ScanFunc: func(in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+					if aws.ToString(in.TableName) != "relationships" {
+						return &dynamodb.ScanOutput{}, nil
+					}
+					return &dynamodb.ScanOutput{Items: items}, nil
+				},
+				BatchWriteItemFunc: func(in *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+					sizes = append(sizes, len(in.RequestItems["relationships"]))
+					return &dynamodb.BatchWriteItemOutput{}, tt.batchErr
+				},
+			}
+			s := &Sweeper{DB: m.Client(), UserTable: "users", SessionTable: "sessions", DeviceTable: "devices", RelationshipTable: "relationships", Segments: 1, BatchRate: 1000}
+
+			report, err := s.Run(context.Background(), now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if len(sizes) != tt.wantBatches {
+				t.Errorf("batches of %v, want %d batches", sizes, tt.wantBatches)
+			}
+			for _, n := range sizes {
+				if n > db.MaxBatchWrite || n%2 != 0 {
+					t.Errorf("batch of %d deletes", n)
+				}
+			}
+			if report.Invitations != tt.wantRemoved {
+				t.Errorf("invitations = %d, want %d", report.Invitations, tt.wantRemoved)
+			}
+		})
+	}
+}
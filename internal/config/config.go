@@ -0,0 +1,1047 @@
+// Package config loads the runtime settings shared by every troggle Lambda
+// from environment variables, so the same binary can be deployed against the
+// dev, staging and prod tables.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variable names read by Load.
+const (
+	EnvUserTableName   = "USER_TABLE_NAME"
+	EnvEmailIndexName  = "EMAIL_INDEX_NAME"
+	EnvStatusIndexName = "STATUS_INDEX_NAME"
+	EnvRegion          = "TROGGLE_REGION" // overrides AWS_REGION for SDK clients
+
+	EnvDynamoDBEndpoint = "DYNAMODB_ENDPOINT" // e.g. http://localhost:8000 for DynamoDB Local
+	EnvDAXEndpoint      = "DAX_ENDPOINT"      // e.g. dax://my-cluster.abc123.dax-clusters.us-east-1.amazonaws.com
+	EnvDynamoDBTimeout  = "DYNAMODB_TIMEOUT"  // Go duration a DynamoDB call may take, retries included
+	EnvDynamoDBTimeouts = "DYNAMODB_TIMEOUTS" // comma-separated Operation=duration overrides, e.g. "Scan=10s,TransactWriteItems=5s"
+
+	EnvSessionTableName     = "SESSION_TABLE_NAME"
+	EnvPreferenceTableName  = "PREFERENCE_TABLE_NAME"
+	EnvDeviceTableName      = "DEVICE_TABLE_NAME"
+	EnvDeviceTokenIndexName = "DEVICE_TOKEN_INDEX_NAME"
+	EnvUserDataTableName    = "USER_DATA_TABLE_NAME"
+	EnvAPIKeyTableName      = "API_KEY_TABLE_NAME"
+	EnvAPIKeyIDIndexName    = "API_KEY_ID_INDEX_NAME"
+	EnvAPIKeyRotationGrace  = "API_KEY_ROTATION_GRACE" // Go duration a rotated API key keeps working
+	EnvIdempotencyTableName = "IDEMPOTENCY_TABLE_NAME"
+	EnvRateLimitTableName   = "RATE_LIMIT_TABLE_NAME"
+	EnvSuppressionTableName = "SUPPRESSION_TABLE_NAME"
+	EnvCounterTableName     = "COUNTER_TABLE_NAME"
+	EnvAuditTableName       = "AUDIT_TABLE_NAME"
+	EnvUserPoolID           = "COGNITO_USER_POOL_ID"
+	EnvEventBusName         = "EVENT_BUS_NAME"
+	EnvStripPlusAlias       = "EMAIL_STRIP_PLUS_ALIAS" // "true" folds jane+tag@x.com into jane@x.com
+	EnvEmailIntegrityCheck  = "EMAIL_INTEGRITY_CHECK"  // "true" reads every record of an email to find duplicates
+	EnvAppClientIDs         = "COGNITO_APP_CLIENT_IDS" // comma-separated app clients whose tokens are accepted
+	EnvTrustedSignupDomains = "TRUSTED_SIGNUP_DOMAINS" // comma-separated email domains whose SSO sign-ups are confirmed at once
+	EnvWaitlistMode         = "WAITLIST_MODE"          // "true" closes sign-ups to addresses not admitted from the waitlist
+	EnvJWTClockSkew         = "JWT_CLOCK_SKEW"         // Go duration, e.g. "30s"
+	EnvExistenceCheckMode   = "EXISTENCE_CHECK_MODE"   // one of the ExistenceCheck* modes
+	EnvCacheTTL             = "CACHE_TTL"              // Go duration; "0" disables the lookup cache
+	EnvCacheSize            = "CACHE_SIZE"             // maximum number of cached lookups per container
+	EnvSessionTTL           = "SESSION_TTL"            // Go duration sessions last; match the refresh token validity
+	EnvDeviceTTL            = "DEVICE_TTL"             // Go duration a device token lasts without being re-registered
+
+	EnvEmailQueueURL  = "EMAIL_QUEUE_URL"         // SQS queue the sendEmail worker consumes
+	EnvEmailFrom      = "EMAIL_FROM"              // verified SES sender, e.g. "Troggle <no-reply@troggle.app>"
+	EnvEmailConfigSet = "EMAIL_CONFIGURATION_SET" // SES configuration set publishing bounces and complaints
+	EnvEmailChangeURL = "EMAIL_CHANGE_URL"        // page or app link confirming an email change; ?token=... is appended
+	EnvInvitationURL  = "INVITATION_URL"          // page or app link accepting an invitation; ?code=... is appended
+	EnvSignInURL      = "SIGN_IN_URL"             // page or app link where users admitted from the waitlist sign in
+
+	EnvPushAPNsApp        = "PUSH_APNS_APP_ARN"         // SNS platform application for APNs production
+	EnvPushAPNsSandboxApp = "PUSH_APNS_SANDBOX_APP_ARN" // SNS platform application for APNs development builds
+	EnvPushFCMApp         = "PUSH_FCM_APP_ARN"          // SNS platform application for FCM
+
+	EnvQueueMaxReceiveCount = "QUEUE_MAX_RECEIVE_COUNT" // maxReceiveCount of the worker queues' redrive policy
+
+	EnvAuditArchiveBucket = "AUDIT_ARCHIVE_BUCKET" // S3 bucket expired audit entries are archived to
+
+	EnvExportTableName = "EXPORT_TABLE_NAME"
+	EnvExportQueueURL  = "EXPORT_QUEUE_URL" // SQS queue of data export jobs
+	EnvExportBucket    = "EXPORT_BUCKET"    // S3 bucket export bundles are written to
+	EnvExportURLTTL    = "EXPORT_URL_TTL"   // Go duration download links of export bundles last
+
+	EnvResultBucket = "RESULT_BUCKET"  // S3 bucket responses too large for API Gateway are served from
+	EnvResultURLTTL = "RESULT_URL_TTL" // Go duration links to those responses last
+	EnvCacheControl = "CACHE_CONTROL"  // Cache-Control of the function's cacheable reads, e.g. "private, max-age=60"
+
+	EnvDeletionGracePeriod = "DELETION_GRACE_PERIOD" // Go duration between a deletion request and the erasure
+	EnvDeletionMode        = "DELETION_MODE"         // one of the Deletion* modes
+	EnvSoftDeleteRetention = "SOFT_DELETE_RETENTION" // Go duration soft-deleted users can be restored for
+
+	EnvAvatarBucket   = "AVATAR_BUCKET"    // S3 bucket of avatar uploads and thumbnails
+	EnvAvatarBaseURL  = "AVATAR_BASE_URL"  // public URL the avatar bucket is served from, e.g. a CloudFront distribution
+	EnvAvatarMaxBytes = "AVATAR_MAX_BYTES" // largest avatar upload accepted
+
+	EnvSearchBackend         = "SEARCH_BACKEND" // one of the Search* backends
+	EnvSearchTableName       = "SEARCH_TABLE_NAME"
+	EnvSearchPrefixIndexName = "SEARCH_PREFIX_INDEX_NAME"
+	EnvOpenSearchEndpoint    = "OPENSEARCH_ENDPOINT" // https:// URL of the domain or Serverless collection
+	EnvOpenSearchIndex       = "OPENSEARCH_INDEX"
+
+	EnvRelationshipTableName = "RELATIONSHIP_TABLE_NAME"
+
+	EnvConnectionTableName     = "CONNECTION_TABLE_NAME"
+	EnvConnectionUserIndexName = "CONNECTION_USER_INDEX_NAME"
+	EnvConnectionTTL           = "CONNECTION_TTL"     // Go duration a WebSocket connection counts as online without a heartbeat
+	EnvWebSocketEndpoint       = "WEBSOCKET_ENDPOINT" // https:// URL of the WebSocket API stage's management endpoint
+
+	EnvMessageTableName      = "MESSAGE_TABLE_NAME"
+	EnvMessageInboxIndexName = "MESSAGE_INBOX_INDEX_NAME"
+
+	EnvLeaderboardTableName      = "LEADERBOARD_TABLE_NAME"
+	EnvLeaderboardScoreIndexName = "LEADERBOARD_SCORE_INDEX_NAME"
+	EnvLeaderboards              = "LEADERBOARDS"       // comma-separated names of the boards scores are accepted for
+	EnvLeaderboardShards         = "LEADERBOARD_SHARDS" // write shards of each board in the score index
+
+	EnvAchievementTableName     = "ACHIEVEMENT_TABLE_NAME"
+	EnvUserAchievementTableName = "USER_ACHIEVEMENT_TABLE_NAME"
+
+	EnvMatchTableName   = "MATCH_TABLE_NAME"
+	EnvPlayerTableName  = "PLAYER_TABLE_NAME"
+	EnvMatchJoinTimeout = "MATCH_JOIN_TIMEOUT" // Go duration a match waits for players
+	EnvMatchPlayTimeout = "MATCH_PLAY_TIMEOUT" // Go duration a started match has to report results
+
+	EnvTicketTableName      = "TICKET_TABLE_NAME"
+	EnvTicketQueueIndexName = "TICKET_QUEUE_INDEX_NAME"
+	EnvTicketTTL            = "TICKET_TTL"    // Go duration a matchmaking ticket waits for a match
+	EnvMatchRegions         = "MATCH_REGIONS" // comma-separated latency regions players queue in
+
+	EnvRatingHistoryTableName   = "RATING_HISTORY_TABLE_NAME"
+	EnvRatingKFactor            = "RATING_K_FACTOR"             // Elo K-factor of settled players
+	EnvRatingProvisionalKFactor = "RATING_PROVISIONAL_K_FACTOR" // Elo K-factor of new players
+	EnvRatingDecayAfter         = "RATING_DECAY_AFTER"          // Go duration of inactivity after which ratings decay
+
+	EnvNotificationTableName      = "NOTIFICATION_TABLE_NAME"
+	EnvNotificationInboxIndexName = "NOTIFICATION_INBOX_INDEX_NAME"
+	EnvNotificationTTL            = "NOTIFICATION_TTL" // Go duration notifications are kept
+
+	EnvFriendRequestTTL = "FRIEND_REQUEST_TTL" // Go duration friend requests wait for an answer
+	EnvCleanupSegments  = "CLEANUP_SEGMENTS"   // parallel scan segments of the cleanup job
+	EnvCleanupBatchRate = "CLEANUP_BATCH_RATE" // delete batches per second the cleanup job writes
+
+	EnvRoleTableName = "ROLE_TABLE_NAME"
+
+	EnvChallengeTableName = "CHALLENGE_TABLE_NAME"
+
+	EnvWaitlistTableName      = "WAITLIST_TABLE_NAME"
+	EnvWaitlistQueueIndexName = "WAITLIST_QUEUE_INDEX_NAME"
+
+	EnvPolicyTableName = "POLICY_TABLE_NAME"
+
+	EnvSecretsPrefix   = "SECRETS_PREFIX"    // prefix of the Secrets Manager names of this environment, e.g. "troggle/prod/"
+	EnvSecretsCacheTTL = "SECRETS_CACHE_TTL" // Go duration secrets are cached
+
+	EnvParameterPath     = "PARAMETER_PATH"      // SSM Parameter Store path of this environment's settings, e.g. "/troggle/prod/"
+	EnvParameterCacheTTL = "PARAMETER_CACHE_TTL" // Go duration parameters are cached
+
+	EnvLegacyAuthURL = "LEGACY_AUTH_URL" // https:// base URL of the legacy system's auth API; empty disables user migration
+
+	EnvMFAMaxAge   = "MFA_MAX_AGE"  // Go duration a sign-in counts as a recent MFA challenge
+	EnvMFARequired = "MFA_REQUIRED" // "true" refuses high-risk operations to users without a second factor
+)
+
+// Backends of user search; see package search.
+const (
+	SearchDynamoDB   = "dynamodb"   // term table with a prefix GSI
+	SearchOpenSearch = "opensearch" // OpenSearch domain or Serverless collection
+)
+
+// Modes of account deletion; see package erasure.
+const (
+	DeletionHard = "hard" // data is deleted at once
+	DeletionSoft = "soft" // data is marked deleted and purged after SOFT_DELETE_RETENTION
+)
+
+// Modes of the checkUserExists endpoint, trading convenience for resistance
+// to account enumeration.
+const (
+	ExistenceCheckOpen          = "open"          // anyone may ask; answers reveal existence
+	ExistenceCheckAuthenticated = "authenticated" // callers need a valid Cognito token
+	ExistenceCheckUniform       = "uniform"       // same answer and timing for every email
+)
+
+// Defaults used when a variable is unset. They match the original prod names.
+const (
+	DefaultUserTableName   = "troggle_user"
+	DefaultEmailIndexName  = "email-index"
+	DefaultStatusIndexName = "status-index"
+
+	DefaultSessionTableName     = "troggle_session"
+	DefaultPreferenceTableName  = "troggle_preference"
+	DefaultDeviceTableName      = "troggle_device"
+	DefaultDeviceTokenIndexName = "token-index"
+	DefaultUserDataTableName    = "troggle_user_data"
+	DefaultAPIKeyTableName      = "troggle_api_key"
+	DefaultAPIKeyIDIndexName    = "key-id-index"
+	DefaultAPIKeyRotationGrace  = 24 * time.Hour
+	DefaultIdempotencyTableName = "troggle_idempotency"
+	DefaultRateLimitTableName   = "troggle_rate_limit"
+	DefaultSuppressionTableName = "troggle_email_suppression"
+	DefaultCounterTableName     = "troggle_counter"
+	DefaultAuditTableName       = "troggle_audit"
+	DefaultEventBusName         = "default"
+	DefaultJWTClockSkew         = 30 * time.Second
+	DefaultDynamoDBTimeout      = 3 * time.Second
+	DefaultCacheTTL             = 30 * time.Second
+	DefaultCacheSize            = 1000
+	DefaultSessionTTL           = 30 * 24 * time.Hour // Cognito's default refresh token validity
+	DefaultDeviceTTL            = 60 * 24 * time.Hour // apps re-register on launch, so this is two months unused
+
+	DefaultQueueMaxReceiveCount = 5
+
+	DefaultExportTableName = "troggle_export"
+	DefaultExportURLTTL    = 15 * time.Minute
+
+	DefaultResultURLTTL = 5 * time.Minute
+
+	DefaultDeletionGracePeriod = 30 * 24 * time.Hour
+	DefaultSoftDeleteRetention = 30 * 24 * time.Hour
+
+	DefaultAvatarMaxBytes = 5 << 20
+
+	DefaultSearchTableName       = "troggle_search"
+	DefaultSearchPrefixIndexName = "prefix-index"
+	DefaultOpenSearchIndex       = "users"
+
+	DefaultRelationshipTableName = "troggle_relationship"
+
+	DefaultConnectionTableName     = "troggle_connection"
+	DefaultConnectionUserIndexName = "user-index"
+	DefaultConnectionTTL           = 10 * time.Minute // clients send a heartbeat every few minutes
+
+	DefaultMessageTableName      = "troggle_message"
+	DefaultMessageInboxIndexName = "inbox-index"
+
+	DefaultLeaderboardTableName      = "troggle_leaderboard"
+	DefaultLeaderboardScoreIndexName = "score-index"
+	DefaultLeaderboards              = "main"
+	DefaultLeaderboardShards         = 10
+
+	DefaultAchievementTableName     = "troggle_achievement"
+	DefaultUserAchievementTableName = "troggle_user_achievement"
+
+	DefaultMatchTableName   = "troggle_match"
+	DefaultPlayerTableName  = "troggle_player"
+	DefaultMatchJoinTimeout = 2 * time.Minute
+	DefaultMatchPlayTimeout = time.Hour
+
+	DefaultTicketTableName      = "troggle_ticket"
+	DefaultTicketQueueIndexName = "queue-index"
+	DefaultTicketTTL            = 5 * time.Minute
+	DefaultMatchRegions         = "eu,na,sa,asia,oce"
+
+	DefaultRatingHistoryTableName   = "troggle_rating_history"
+	DefaultRatingKFactor            = 32
+	DefaultRatingProvisionalKFactor = 64
+	DefaultRatingDecayAfter         = 30 * 24 * time.Hour
+
+	DefaultNotificationTableName      = "troggle_notification"
+	DefaultNotificationInboxIndexName = "inbox-index"
+	DefaultNotificationTTL            = 30 * 24 * time.Hour
+
+	DefaultFriendRequestTTL = 30 * 24 * time.Hour
+	DefaultCleanupSegments  = 4
+	DefaultCleanupBatchRate = 10
+
+	DefaultRoleTableName = "troggle_role"
+
+	DefaultChallengeTableName = "troggle_auth_challenge"
+
+	DefaultWaitlistTableName      = "troggle_waitlist"
+	DefaultWaitlistQueueIndexName = "queue-index"
+
+	DefaultPolicyTableName = "troggle_policy"
+
+	DefaultSecretsPrefix   = "troggle/"
+	DefaultSecretsCacheTTL = 5 * time.Minute
+
+	DefaultParameterCacheTTL = 30 * time.Second
+
+	DefaultMFAMaxAge = 15 * time.Minute
+)
+
+// MaxLeaderboardShards bounds LEADERBOARD_SHARDS: reading a board queries
+// every shard.
+const MaxLeaderboardShards = 100
+
+// MaxCleanupSegments bounds CLEANUP_SEGMENTS, the goroutines of each scan
+// of the cleanup job.
+const MaxCleanupSegments = 64
+
+// MaxRatingKFactor bounds the rating K-factors: no single match should
+// move a rating by more than the Elo scale.
+const MaxRatingKFactor = 400
+
+// boardName matches leaderboard names, and regionName matchmaking regions.
+var (
+	boardName  = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+	regionName = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+)
+
+// dynamoName matches the characters and length DynamoDB allows for table and
+// index names.
+var dynamoName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+// Config holds the settings resolved at cold start.
+type Config struct {
+	UserTableName   string // DynamoDB table holding user records
+	EmailIndexName  string // GSI on the user table keyed by email
+	StatusIndexName string // GSI on the user table keyed by status, sorted by created_at
+	Region          string // optional region override; empty means SDK default
+
+	DynamoDBEndpoint string // optional endpoint override for local development
+	DAXEndpoint      string // optional DAX cluster serving DynamoDB calls; empty means DynamoDB only
+
+	DynamoDBTimeout  time.Duration            // longest a DynamoDB call may take, retries included; zero leaves only the invocation's deadline
+	DynamoDBTimeouts map[string]time.Duration // overrides of DynamoDBTimeout by operation, e.g. "Scan"
+
+	SessionTableName     string // sessions, keyed by user_id + session_id
+	PreferenceTableName  string // preferences, keyed by user_id
+	DeviceTableName      string // push device tokens, keyed by user_id + token
+	DeviceTokenIndexName string // GSI on the device table keyed by token
+	UserDataTableName    string // user-adjacent entities in one table, keyed by pk + sk; see package userdata
+	APIKeyTableName      string // API keys, keyed by the SHA-256 of the key
+	APIKeyIDIndexName    string // GSI on the API key table keyed by key_id
+	IdempotencyTableName string // recorded responses of idempotent requests, keyed by idempotency_key
+	RateLimitTableName   string // token buckets, keyed by bucket
+	SuppressionTableName string // email addresses that must not be mailed, keyed by email
+	CounterTableName     string // aggregate counts maintained from the user stream, keyed by counter
+	AuditTableName       string // append-only audit log, keyed by resource + entry_id
+	UserPoolID           string // Cognito user pool; required by functions that manage Cognito users
+	EventBusName         string // EventBridge bus domain events are published to
+	EmailQueueURL        string // SQS queue of outgoing email; required by functions that send email
+	EmailFrom            string // sender address; required by the sendEmail worker
+	EmailConfigSet       string // optional SES configuration set
+	EmailChangeURL       string // link of email change confirmations; required by requestEmailChange and resendVerification
+	InvitationURL        string // link of invitations; required by createInvitation
+	SignInURL            string // link of the emails admitting users from the waitlist; required by admitWaitlist
+	StripPlusAlias       bool   // strip "+tag" from email local parts during normalization
+	EmailIntegrityCheck  bool   // look for duplicate records instead of stopping at the first match
+
+	APIKeyRotationGrace time.Duration // how long a rotated API key keeps working next to its replacement
+
+	AppClientIDs         []string      // Cognito app clients whose tokens are accepted
+	TrustedSignupDomains []string      // email domains, subdomains included, whose SSO sign-ups need no confirmation
+	WaitlistMode         bool          // refuse sign-ups of addresses not admitted from the waitlist
+	JWTClockSkew         time.Duration // tolerated clock difference when checking exp/nbf/iat
+
+	ExistenceCheckMode string // how checkUserExists answers; see the ExistenceCheck* modes
+
+	CacheTTL  time.Duration // how long warm containers cache user lookups; zero disables the cache
+	CacheSize int           // maximum number of cached lookups per container
+
+	SessionTTL time.Duration // lifetime of session records, after which DynamoDB TTL removes them
+	DeviceTTL  time.Duration // lifetime of device tokens since their last registration
+
+	PushAPNsApp        string // SNS platform application for APNs; empty disables pushes to iOS
+	PushAPNsSandboxApp string // SNS platform application for APNs development builds
+	PushFCMApp         string // SNS platform application for FCM; empty disables pushes to Android
+
+	QueueMaxReceiveCount int // deliveries after which SQS moves a failing message to the dead-letter queue
+
+	AuditArchiveBucket string // S3 bucket of archived audit entries; required by the auditArchive function
+
+	ExportTableName string        // data export jobs, keyed by user_id + export_id
+	ExportQueueURL  string        // SQS queue of export jobs; required by exportUserData
+	ExportBucket    string        // S3 bucket of export bundles; required by exportUserData
+	ExportURLTTL    time.Duration // lifetime of presigned download links of export bundles
+
+	ResultBucket string        // S3 bucket of oversized responses; empty fails them instead, see package offload
+	ResultURLTTL time.Duration // lifetime of presigned links to oversized responses
+	CacheControl string        // Cache-Control of cacheable reads; empty keeps the endpoint's default
+
+	DeletionGracePeriod time.Duration // how long a requested account deletion can be cancelled
+	DeletionMode        string        // how accounts are deleted; see the Deletion* modes
+	SoftDeleteRetention time.Duration // how long a soft-deleted account can be restored
+
+	AvatarBucket   string // S3 bucket of avatars; required by getAvatarUploadUrl and processAvatar
+	AvatarBaseURL  string // public URL prefix of avatar thumbnails; required by processAvatar
+	AvatarMaxBytes int64  // largest avatar upload, in bytes
+
+	SearchBackend         string // where users are indexed for search; see the Search* backends
+	SearchTableName       string // search terms of the dynamodb backend, keyed by user_id + term
+	SearchPrefixIndexName string // GSI on the search table keyed by prefix, sorted by term
+	OpenSearchEndpoint    string // required by the opensearch backend
+	OpenSearchIndex       string // index of user documents in OpenSearch
+
+	RelationshipTableName string // friend and follow edges, keyed by user_id + edge
+
+	ConnectionTableName     string        // open WebSocket connections, keyed by connection_id
+	ConnectionUserIndexName string        // GSI on the connection table keyed by user_id
+	ConnectionTTL           time.Duration // how long a connection counts as online after its last heartbeat
+	WebSocketEndpoint       string        // required by functions pushing to WebSocket connections
+
+	MessageTableName      string // conversations, keyed by conversation_id + entry
+	MessageInboxIndexName string // GSI on the message table keyed by inbox, sorted by last_message_at
+
+	LeaderboardTableName      string   // best scores, keyed by board + user_id
+	LeaderboardScoreIndexName string   // GSI on the leaderboard table keyed by shard, sorted by score
+	Leaderboards              []string // names of the boards scores are accepted for
+	LeaderboardShards         int      // write shards of each board in the score index
+
+	AchievementTableName     string // achievement definitions, keyed by achievement_id
+	UserAchievementTableName string // progress of users on achievements, keyed by user_id + achievement_id
+
+	MatchTableName   string        // matches, keyed by match_id
+	PlayerTableName  string        // match records of players, keyed by user_id
+	MatchJoinTimeout time.Duration // how long a new match waits for players before it starts or is abandoned
+	MatchPlayTimeout time.Duration // how long a started match has to report results before it is abandoned
+
+	TicketTableName      string        // matchmaking tickets, keyed by user_id
+	TicketQueueIndexName string        // GSI on the ticket table keyed by queue, sorted by enqueued_at
+	TicketTTL            time.Duration // how long a ticket waits for a match before it expires
+	MatchRegions         []string      // latency regions players queue in
+
+	RatingHistoryTableName   string        // rating changes of players, keyed by user_id + entry
+	RatingKFactor            float64       // Elo K-factor of settled players
+	RatingProvisionalKFactor float64       // Elo K-factor of players' first matches
+	RatingDecayAfter         time.Duration // inactivity after which ratings decay
+
+	NotificationTableName      string        // in-app notifications, keyed by user_id + notification_id
+	NotificationInboxIndexName string        // GSI on the notification table keyed by user_id, sorted by inbox
+	NotificationTTL            time.Duration // how long notifications are kept
+
+	FriendRequestTTL time.Duration // how long a friend request waits for an answer before cleanup removes it
+	CleanupSegments  int           // parallel scan segments of each table the cleanup job sweeps
+	CleanupBatchRate int           // delete batches, of up to 25 items, the cleanup job writes per second
+
+	RoleTableName string // roles granted outside Cognito groups, keyed by user_id + role
+
+	ChallengeTableName string // one-time codes of the email OTP login and password resets, keyed by user_id
+
+	WaitlistTableName      string // waitlist entries, keyed by email
+	WaitlistQueueIndexName string // sparse GSI on the waitlist table keyed by queue, sorted by position
+
+	PolicyTableName string // published versions of the terms of service and privacy policy, keyed by policy + version
+
+	SecretsPrefix   string        // prepended to the names of secrets read from Secrets Manager
+	SecretsCacheTTL time.Duration // how long warm containers cache secrets before refetching them
+
+	ParameterPath     string        // Parameter Store path of dynamic settings, ending in "/"; empty disables them
+	ParameterCacheTTL time.Duration // how long warm containers cache parameters before rereading them
+
+	LegacyAuthURL string // auth API of the legacy system users are migrated from, without a trailing "/"
+
+	MFAMaxAge   time.Duration // how long after signing in users may take high-risk operations; see package mfa
+	MFARequired bool          // high-risk operations need a second factor, rather than only a recent one of users who have it
+}
+
+// Load reads the configuration from the environment and validates it.
+func Load() (*Config, error) {
+	cfg := &Config{
+		UserTableName:   getenv(EnvUserTableName, DefaultUserTableName),
+		EmailIndexName:  getenv(EnvEmailIndexName, DefaultEmailIndexName),
+		StatusIndexName: getenv(EnvStatusIndexName, DefaultStatusIndexName),
+		Region:          os.Getenv(EnvRegion),
+
+		DynamoDBEndpoint: os.Getenv(EnvDynamoDBEndpoint),
+		DAXEndpoint:      os.Getenv(EnvDAXEndpoint),
+		DynamoDBTimeout:  DefaultDynamoDBTimeout,
+
+		SessionTableName:     getenv(EnvSessionTableName, DefaultSessionTableName),
+		PreferenceTableName:  getenv(EnvPreferenceTableName, DefaultPreferenceTableName),
+		DeviceTableName:      getenv(EnvDeviceTableName, DefaultDeviceTableName),
+		DeviceTokenIndexName: getenv(EnvDeviceTokenIndexName, DefaultDeviceTokenIndexName),
+		UserDataTableName:    getenv(EnvUserDataTableName, DefaultUserDataTableName),
+		APIKeyTableName:      getenv(EnvAPIKeyTableName, DefaultAPIKeyTableName),
+		APIKeyIDIndexName:    getenv(EnvAPIKeyIDIndexName, DefaultAPIKeyIDIndexName),
+		APIKeyRotationGrace:  DefaultAPIKeyRotationGrace,
+		IdempotencyTableName: getenv(EnvIdempotencyTableName, DefaultIdempotencyTableName),
+		RateLimitTableName:   getenv(EnvRateLimitTableName, DefaultRateLimitTableName),
+		SuppressionTableName: getenv(EnvSuppressionTableName, DefaultSuppressionTableName),
+		CounterTableName:     getenv(EnvCounterTableName, DefaultCounterTableName),
+		AuditTableName:       getenv(EnvAuditTableName, DefaultAuditTableName),
+		UserPoolID:           os.Getenv(EnvUserPoolID),
+		EventBusName:         getenv(EnvEventBusName, DefaultEventBusName),
+		EmailQueueURL:        os.Getenv(EnvEmailQueueURL),
+		EmailFrom:            os.Getenv(EnvEmailFrom),
+		EmailConfigSet:       os.Getenv(EnvEmailConfigSet),
+		EmailChangeURL:       os.Getenv(EnvEmailChangeURL),
+		InvitationURL:        os.Getenv(EnvInvitationURL),
+		SignInURL:            os.Getenv(EnvSignInURL),
+		PushAPNsApp:          os.Getenv(EnvPushAPNsApp),
+		PushAPNsSandboxApp:   os.Getenv(EnvPushAPNsSandboxApp),
+		PushFCMApp:           os.Getenv(EnvPushFCMApp),
+		StripPlusAlias:       os.Getenv(EnvStripPlusAlias) == "true",
+		EmailIntegrityCheck:  os.Getenv(EnvEmailIntegrityCheck) == "true",
+		AppClientIDs:         splitList(os.Getenv(EnvAppClientIDs)),
+		TrustedSignupDomains: splitList(strings.ToLower(os.Getenv(EnvTrustedSignupDomains))),
+		WaitlistMode:         os.Getenv(EnvWaitlistMode) == "true",
+		JWTClockSkew:         DefaultJWTClockSkew,
+		ExistenceCheckMode:   getenv(EnvExistenceCheckMode, ExistenceCheckOpen),
+		CacheTTL:             DefaultCacheTTL,
+		CacheSize:            DefaultCacheSize,
+		SessionTTL:           DefaultSessionTTL,
+		DeviceTTL:            DefaultDeviceTTL,
+		QueueMaxReceiveCount: DefaultQueueMaxReceiveCount,
+		AuditArchiveBucket:   os.Getenv(EnvAuditArchiveBucket),
+		ExportTableName:      getenv(EnvExportTableName, DefaultExportTableName),
+		ExportQueueURL:       os.Getenv(EnvExportQueueURL),
+		ExportBucket:         os.Getenv(EnvExportBucket),
+		ExportURLTTL:         DefaultExportURLTTL,
+		ResultBucket:         os.Getenv(EnvResultBucket),
+		CacheControl:         os.Getenv(EnvCacheControl),
+		ResultURLTTL:         DefaultResultURLTTL,
+		DeletionGracePeriod:  DefaultDeletionGracePeriod,
+		DeletionMode:         getenv(EnvDeletionMode, DeletionHard),
+		SoftDeleteRetention:  DefaultSoftDeleteRetention,
+		AvatarBucket:         os.Getenv(EnvAvatarBucket),
+		AvatarBaseURL:        strings.TrimSuffix(os.Getenv(EnvAvatarBaseURL), "/"),
+		AvatarMaxBytes:       DefaultAvatarMaxBytes,
+
+		SearchBackend:         getenv(EnvSearchBackend, SearchDynamoDB),
+		SearchTableName:       getenv(EnvSearchTableName, DefaultSearchTableName),
+		SearchPrefixIndexName: getenv(EnvSearchPrefixIndexName, DefaultSearchPrefixIndexName),
+		OpenSearchEndpoint:    strings.TrimSuffix(os.Getenv(EnvOpenSearchEndpoint), "/"),
+		OpenSearchIndex:       getenv(EnvOpenSearchIndex, DefaultOpenSearchIndex),
+
+		RelationshipTableName: getenv(EnvRelationshipTableName, DefaultRelationshipTableName),
+
+		ConnectionTableName:     getenv(EnvConnectionTableName, DefaultConnectionTableName),
+		ConnectionUserIndexName: getenv(EnvConnectionUserIndexName, DefaultConnectionUserIndexName),
+		ConnectionTTL:           DefaultConnectionTTL,
+		WebSocketEndpoint:       strings.TrimSuffix(os.Getenv(EnvWebSocketEndpoint), "/"),
+
+		MessageTableName:      getenv(EnvMessageTableName, DefaultMessageTableName),
+		MessageInboxIndexName: getenv(EnvMessageInboxIndexName, DefaultMessageInboxIndexName),
+
+		LeaderboardTableName:      getenv(EnvLeaderboardTableName, DefaultLeaderboardTableName),
+		LeaderboardScoreIndexName: getenv(EnvLeaderboardScoreIndexName, DefaultLeaderboardScoreIndexName),
+		Leaderboards:              splitList(getenv(EnvLeaderboards, DefaultLeaderboards)),
+		LeaderboardShards:         DefaultLeaderboardShards,
+
+		AchievementTableName:     getenv(EnvAchievementTableName, DefaultAchievementTableName),
+		UserAchievementTableName: getenv(EnvUserAchievementTableName, DefaultUserAchievementTableName),
+
+		MatchTableName:   getenv(EnvMatchTableName, DefaultMatchTableName),
+		PlayerTableName:  getenv(EnvPlayerTableName, DefaultPlayerTableName),
+		MatchJoinTimeout: DefaultMatchJoinTimeout,
+		MatchPlayTimeout: DefaultMatchPlayTimeout,
+
+		TicketTableName:      getenv(EnvTicketTableName, DefaultTicketTableName),
+		TicketQueueIndexName: getenv(EnvTicketQueueIndexName, DefaultTicketQueueIndexName),
+		TicketTTL:            DefaultTicketTTL,
+		MatchRegions:         splitList(getenv(EnvMatchRegions, DefaultMatchRegions)),
+
+		RatingHistoryTableName:   getenv(EnvRatingHistoryTableName, DefaultRatingHistoryTableName),
+		RatingKFactor:            DefaultRatingKFactor,
+		RatingProvisionalKFactor: DefaultRatingProvisionalKFactor,
+		RatingDecayAfter:         DefaultRatingDecayAfter,
+
+		NotificationTableName:      getenv(EnvNotificationTableName, DefaultNotificationTableName),
+		NotificationInboxIndexName: getenv(EnvNotificationInboxIndexName, DefaultNotificationInboxIndexName),
+		NotificationTTL:            DefaultNotificationTTL,
+
+		FriendRequestTTL: DefaultFriendRequestTTL,
+		CleanupSegments:  DefaultCleanupSegments,
+		CleanupBatchRate: DefaultCleanupBatchRate,
+
+		RoleTableName: getenv(EnvRoleTableName, DefaultRoleTableName),
+
+		ChallengeTableName: getenv(EnvChallengeTableName, DefaultChallengeTableName),
+
+		WaitlistTableName:      getenv(EnvWaitlistTableName, DefaultWaitlistTableName),
+		WaitlistQueueIndexName: getenv(EnvWaitlistQueueIndexName, DefaultWaitlistQueueIndexName),
+
+		PolicyTableName: getenv(EnvPolicyTableName, DefaultPolicyTableName),
+
+		SecretsPrefix:   getenv(EnvSecretsPrefix, DefaultSecretsPrefix),
+		SecretsCacheTTL: DefaultSecretsCacheTTL,
+
+		ParameterPath:     os.Getenv(EnvParameterPath),
+		ParameterCacheTTL: DefaultParameterCacheTTL,
+
+		LegacyAuthURL: strings.TrimSuffix(os.Getenv(EnvLegacyAuthURL), "/"),
+
+		MFAMaxAge:   DefaultMFAMaxAge,
+		MFARequired: os.Getenv(EnvMFARequired) == "true",
+	}
+	if cfg.ParameterPath != "" && !strings.HasSuffix(cfg.ParameterPath, "/") {
+		cfg.ParameterPath += "/"
+	}
+
+	var errs []error
+	if v := os.Getenv(EnvDynamoDBTimeout); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvDynamoDBTimeout, v))
+		}
+		cfg.DynamoDBTimeout = d
+	}
+	if v := os.Getenv(EnvDynamoDBTimeouts); v != "" {
+		cfg.DynamoDBTimeouts = map[string]time.Duration{}
+		for _, entry := range splitList(v) {
+			op, dur, _ := strings.Cut(entry, "=")
+			d, err := time.ParseDuration(strings.TrimSpace(dur))
+			if op = strings.TrimSpace(op); op == "" || err != nil || d < 0 {
+				errs = append(errs, fmt.Errorf("%s: invalid timeout %q", EnvDynamoDBTimeouts, entry))
+				continue
+			}
+			cfg.DynamoDBTimeouts[op] = d
+		}
+	}
+	if v := os.Getenv(EnvJWTClockSkew); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvJWTClockSkew, v))
+		}
+		cfg.JWTClockSkew = d
+	}
+	if v := os.Getenv(EnvCacheTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvCacheTTL, v))
+		}
+		cfg.CacheTTL = d
+	}
+	if v := os.Getenv(EnvSessionTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvSessionTTL, v))
+		}
+		cfg.SessionTTL = d
+	}
+	if v := os.Getenv(EnvAPIKeyRotationGrace); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvAPIKeyRotationGrace, v))
+		}
+		cfg.APIKeyRotationGrace = d
+	}
+	if v := os.Getenv(EnvDeviceTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvDeviceTTL, v))
+		}
+		cfg.DeviceTTL = d
+	}
+	if v := os.Getenv(EnvExportURLTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		// Presigned URLs last at most a week
+		if err != nil || d <= 0 || d > 7*24*time.Hour {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvExportURLTTL, v))
+		}
+		cfg.ExportURLTTL = d
+	}
+	if v := os.Getenv(EnvResultURLTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > 7*24*time.Hour {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvResultURLTTL, v))
+		}
+		cfg.ResultURLTTL = d
+	}
+	if v := os.Getenv(EnvDeletionGracePeriod); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvDeletionGracePeriod, v))
+		}
+		cfg.DeletionGracePeriod = d
+	}
+	if v := os.Getenv(EnvSoftDeleteRetention); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvSoftDeleteRetention, v))
+		}
+		cfg.SoftDeleteRetention = d
+	}
+	if v := os.Getenv(EnvConnectionTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvConnectionTTL, v))
+		}
+		cfg.ConnectionTTL = d
+	}
+	if v := os.Getenv(EnvMatchJoinTimeout); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvMatchJoinTimeout, v))
+		}
+		cfg.MatchJoinTimeout = d
+	}
+	if v := os.Getenv(EnvMatchPlayTimeout); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvMatchPlayTimeout, v))
+		}
+		cfg.MatchPlayTimeout = d
+	}
+	if v := os.Getenv(EnvTicketTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvTicketTTL, v))
+		}
+		cfg.TicketTTL = d
+	}
+	if v := os.Getenv(EnvRatingDecayAfter); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvRatingDecayAfter, v))
+		}
+		cfg.RatingDecayAfter = d
+	}
+	if v := os.Getenv(EnvNotificationTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvNotificationTTL, v))
+		}
+		cfg.NotificationTTL = d
+	}
+	if v := os.Getenv(EnvFriendRequestTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvFriendRequestTTL, v))
+		}
+		cfg.FriendRequestTTL = d
+	}
+	if v := os.Getenv(EnvSecretsCacheTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvSecretsCacheTTL, v))
+		}
+		cfg.SecretsCacheTTL = d
+	}
+	if v := os.Getenv(EnvParameterCacheTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvParameterCacheTTL, v))
+		}
+		cfg.ParameterCacheTTL = d
+	}
+	if v := os.Getenv(EnvMFAMaxAge); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", EnvMFAMaxAge, v))
+		}
+		cfg.MFAMaxAge = d
+	}
+	if v := os.Getenv(EnvCacheSize); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs = append(errs, fmt.Errorf("%s: invalid size %q", EnvCacheSize, v))
+		}
+		cfg.CacheSize = n
+	}
+	if v := os.Getenv(EnvQueueMaxReceiveCount); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs = append(errs, fmt.Errorf("%s: invalid count %q", EnvQueueMaxReceiveCount, v))
+		}
+		cfg.QueueMaxReceiveCount = n
+	}
+	if v := os.Getenv(EnvAvatarMaxBytes); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			errs = append(errs, fmt.Errorf("%s: invalid size %q", EnvAvatarMaxBytes, v))
+		}
+		cfg.AvatarMaxBytes = n
+	}
+	if v := os.Getenv(EnvLeaderboardShards); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxLeaderboardShards {
+			errs = append(errs, fmt.Errorf("%s: invalid count %q", EnvLeaderboardShards, v))
+		}
+		cfg.LeaderboardShards = n
+	}
+	if v := os.Getenv(EnvCleanupSegments); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxCleanupSegments {
+			errs = append(errs, fmt.Errorf("%s: invalid count %q", EnvCleanupSegments, v))
+		}
+		cfg.CleanupSegments = n
+	}
+	if v := os.Getenv(EnvCleanupBatchRate); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs = append(errs, fmt.Errorf("%s: invalid rate %q", EnvCleanupBatchRate, v))
+		}
+		cfg.CleanupBatchRate = n
+	}
+	if v := os.Getenv(EnvRatingKFactor); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f > 0 && f <= MaxRatingKFactor) {
+			errs = append(errs, fmt.Errorf("%s: invalid factor %q", EnvRatingKFactor, v))
+		}
+		cfg.RatingKFactor = f
+	}
+	if v := os.Getenv(EnvRatingProvisionalKFactor); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f > 0 && f <= MaxRatingKFactor) {
+			errs = append(errs, fmt.Errorf("%s: invalid factor %q", EnvRatingProvisionalKFactor, v))
+		}
+		cfg.RatingProvisionalKFactor = f
+	}
+	if err := errors.Join(append(errs, cfg.Validate())...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	tables := []struct{ env, name string }{
+		{EnvUserTableName, c.UserTableName},
+		{EnvSessionTableName, c.SessionTableName},
+		{EnvPreferenceTableName, c.PreferenceTableName},
+		{EnvDeviceTableName, c.DeviceTableName},
+		{EnvUserDataTableName, c.UserDataTableName},
+		{EnvAPIKeyTableName, c.APIKeyTableName},
+		{EnvIdempotencyTableName, c.IdempotencyTableName},
+		{EnvRateLimitTableName, c.RateLimitTableName},
+		{EnvSuppressionTableName, c.SuppressionTableName},
+		{EnvCounterTableName, c.CounterTableName},
+		{EnvAuditTableName, c.AuditTableName},
+		{EnvExportTableName, c.ExportTableName},
+		{EnvSearchTableName, c.SearchTableName},
+		{EnvRelationshipTableName, c.RelationshipTableName},
+		{EnvConnectionTableName, c.ConnectionTableName},
+		{EnvMessageTableName, c.MessageTableName},
+		{EnvLeaderboardTableName, c.LeaderboardTableName},
+		{EnvAchievementTableName, c.AchievementTableName},
+		{EnvUserAchievementTableName, c.UserAchievementTableName},
+		{EnvMatchTableName, c.MatchTableName},
+		{EnvPlayerTableName, c.PlayerTableName},
+		{EnvTicketTableName, c.TicketTableName},
+		{EnvRatingHistoryTableName, c.RatingHistoryTableName},
+		{EnvNotificationTableName, c.NotificationTableName},
+		{EnvRoleTableName, c.RoleTableName},
+		{EnvChallengeTableName, c.ChallengeTableName},
+		{EnvWaitlistTableName, c.WaitlistTableName},
+		{EnvPolicyTableName, c.PolicyTableName},
+	}
+	for _, t := range tables {
+		if !dynamoName.MatchString(t.name) {
+			errs = append(errs, fmt.Errorf("%s: invalid table name %q", t.env, t.name))
+		}
+	}
+	indexes := []struct{ env, name string }{
+		{EnvEmailIndexName, c.EmailIndexName},
+		{EnvStatusIndexName, c.StatusIndexName},
+		{EnvDeviceTokenIndexName, c.DeviceTokenIndexName},
+		{EnvAPIKeyIDIndexName, c.APIKeyIDIndexName},
+		{EnvSearchPrefixIndexName, c.SearchPrefixIndexName},
+		{EnvConnectionUserIndexName, c.ConnectionUserIndexName},
+		{EnvMessageInboxIndexName, c.MessageInboxIndexName},
+		{EnvLeaderboardScoreIndexName, c.LeaderboardScoreIndexName},
+		{EnvTicketQueueIndexName, c.TicketQueueIndexName},
+		{EnvNotificationInboxIndexName, c.NotificationInboxIndexName},
+		{EnvWaitlistQueueIndexName, c.WaitlistQueueIndexName},
+	}
+	for _, i := range indexes {
+		if !dynamoName.MatchString(i.name) {
+			errs = append(errs, fmt.Errorf("%s: invalid index name %q", i.env, i.name))
+		}
+	}
+
+	for _, name := range c.Leaderboards {
+		if !boardName.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%s: invalid board name %q", EnvLeaderboards, name))
+		}
+	}
+	for _, name := range c.MatchRegions {
+		if !regionName.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%s: invalid region name %q", EnvMatchRegions, name))
+		}
+	}
+
+	if c.ParameterPath != "" && !strings.HasPrefix(c.ParameterPath, "/") {
+		errs = append(errs, fmt.Errorf("%s: path %q must start with /", EnvParameterPath, c.ParameterPath))
+	}
+
+	switch c.ExistenceCheckMode {
+	case ExistenceCheckOpen, ExistenceCheckAuthenticated, ExistenceCheckUniform:
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown mode %q", EnvExistenceCheckMode, c.ExistenceCheckMode))
+	}
+	switch c.DeletionMode {
+	case DeletionHard, DeletionSoft:
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown mode %q", EnvDeletionMode, c.DeletionMode))
+	}
+	switch c.SearchBackend {
+	case SearchDynamoDB, SearchOpenSearch:
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown backend %q", EnvSearchBackend, c.SearchBackend))
+	}
+
+	return errors.Join(errs...)
+}
+
+// RequireUserPool fails unless a Cognito user pool ID is configured. Only
+// functions that call Cognito need it, so Load does not enforce it.
+func (c *Config) RequireUserPool() error {
+	if c.UserPoolID == "" {
+		return fmt.Errorf("%s must be set", EnvUserPoolID)
+	}
+	return nil
+}
+
+// RequireAppClients fails unless at least one Cognito app client ID is
+// configured. Only functions that verify tokens need it.
+func (c *Config) RequireAppClients() error {
+	if len(c.AppClientIDs) == 0 {
+		return fmt.Errorf("%s must be set", EnvAppClientIDs)
+	}
+	return nil
+}
+
+// RequireEmailSender fails unless a sender address is configured. Only the
+// sendEmail worker needs it.
+func (c *Config) RequireEmailSender() error {
+	if c.EmailFrom == "" {
+		return fmt.Errorf("%s must be set", EnvEmailFrom)
+	}
+	return nil
+}
+
+// RequireEmailQueue fails unless the email queue is configured. Only
+// functions that send email need it.
+func (c *Config) RequireEmailQueue() error {
+	if c.EmailQueueURL == "" {
+		return fmt.Errorf("%s must be set", EnvEmailQueueURL)
+	}
+	return nil
+}
+
+// RequireEmailChange fails unless the email queue and the link of email
+// change confirmations are configured. Only requestEmailChange and
+// resendVerification need them.
+func (c *Config) RequireEmailChange() error {
+	if err := c.RequireEmailQueue(); err != nil {
+		return err
+	}
+	if c.EmailChangeURL == "" {
+		return fmt.Errorf("%s must be set", EnvEmailChangeURL)
+	}
+	return nil
+}
+
+// RequireInvitations fails unless the email queue and the link of
+// invitations are configured. Only createInvitation needs them.
+func (c *Config) RequireInvitations() error {
+	if err := c.RequireEmailQueue(); err != nil {
+		return err
+	}
+	if c.InvitationURL == "" {
+		return fmt.Errorf("%s must be set", EnvInvitationURL)
+	}
+	return nil
+}
+
+// RequireWaitlistAdmission fails unless the user pool, the email queue and
+// the sign-in link are configured. Only admitWaitlist needs them.
+func (c *Config) RequireWaitlistAdmission() error {
+	if err := c.RequireUserPool(); err != nil {
+		return err
+	}
+	if err := c.RequireEmailQueue(); err != nil {
+		return err
+	}
+	if c.SignInURL == "" {
+		return fmt.Errorf("%s must be set", EnvSignInURL)
+	}
+	return nil
+}
+
+// RequireAuditArchive fails unless the audit archive bucket is configured.
+// Only the auditArchive function needs it.
+func (c *Config) RequireAuditArchive() error {
+	if c.AuditArchiveBucket == "" {
+		return fmt.Errorf("%s must be set", EnvAuditArchiveBucket)
+	}
+	return nil
+}
+
+// RequireExports fails unless the export queue and bucket are configured.
+// Only the exportUserData function needs them.
+func (c *Config) RequireExports() error {
+	var errs []error
+	if c.ExportQueueURL == "" {
+		errs = append(errs, fmt.Errorf("%s must be set", EnvExportQueueURL))
+	}
+	if c.ExportBucket == "" {
+		errs = append(errs, fmt.Errorf("%s must be set", EnvExportBucket))
+	}
+	return errors.Join(errs...)
+}
+
+// RequireAvatars fails unless the avatar bucket and its public URL are
+// configured. Only the avatar functions need them.
+func (c *Config) RequireAvatars() error {
+	var errs []error
+	if c.AvatarBucket == "" {
+		errs = append(errs, fmt.Errorf("%s must be set", EnvAvatarBucket))
+	}
+	if c.AvatarBaseURL == "" {
+		errs = append(errs, fmt.Errorf("%s must be set", EnvAvatarBaseURL))
+	}
+	return errors.Join(errs...)
+}
+
+// RequireSearch fails unless the selected search backend is fully
+// configured. Only the functions reading or writing the index need it.
+func (c *Config) RequireSearch() error {
+	if c.SearchBackend == SearchOpenSearch && !strings.HasPrefix(c.OpenSearchEndpoint, "https://") {
+		return fmt.Errorf("%s must be set to an https:// URL", EnvOpenSearchEndpoint)
+	}
+	return nil
+}
+
+// RequireWebSocket fails unless the WebSocket API's management endpoint is
+// configured. Only functions pushing messages to connections need it.
+func (c *Config) RequireWebSocket() error {
+	if !strings.HasPrefix(c.WebSocketEndpoint, "https://") {
+		return fmt.Errorf("%s must be set to an https:// URL", EnvWebSocketEndpoint)
+	}
+	return nil
+}
+
+// RequireLegacyAuth fails unless the legacy auth API is configured. Only the
+// user migration trigger needs it.
+func (c *Config) RequireLegacyAuth() error {
+	if !strings.HasPrefix(c.LegacyAuthURL, "https://") {
+		return fmt.Errorf("%s must be set to an https:// URL", EnvLegacyAuthURL)
+	}
+	return nil
+}
+
+// AWSRegion returns the region SDK clients use: the override if set,
+// otherwise the region Lambda runs in.
+func (c *Config) AWSRegion() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	return os.Getenv("AWS_REGION")
+}
+
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// getenv returns the value of key, or fallback when it is unset or empty.
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
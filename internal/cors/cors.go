@@ -0,0 +1,113 @@
+// Package cors lets browser clients call the API from the origins allowed
+// by the dynconfig cors_allowed_origins parameter, a comma-separated list
+// such as "https://app.troggle.gg,https://*.preview.troggle.gg".
+//
+// Wrap answers CORS preflight requests itself, so API Gateway needs no
+// OPTIONS mock integrations, and other responses to an allowed origin carry
+// Access-Control-Allow-Origin naming it. Every response varies by Origin,
+// so caches never serve one origin's headers to another. While the
+// parameter is unset every origin is allowed, as before it existed.
+package cors
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/dynconfig"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/logging"
+)
+
+// DefaultOrigins applies while cors_allowed_origins is unset.
+const DefaultOrigins = "*"
+
+// Headers of the responses to allowed origins.
+const (
+	allowMethods  = "GET,POST,PUT,PATCH,DELETE,OPTIONS"
+	allowHeaders  = "Accept-Language,Authorization,Content-Type,Idempotency-Key,If-None-Match,X-Api-Key"
+	exposeHeaders = "Deprecation,ETag,Idempotent-Replayed,Link,Policy-Acceptance-Required,Retry-After,Sunset"
+	maxAge        = "600" // seconds browsers may cache a preflight response
+)
+
+// vary are the request headers preflight responses depend on.
+const vary = "Origin, Access-Control-Request-Method, Access-Control-Request-Headers"
+
+// Allowed reports whether origin matches the comma-separated allowlist:
+// "*" matches every origin, "https://*.example.com" the subdomains of
+// example.com, and anything else the origin it names.
+func Allowed(allowlist, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, pattern := range strings.Split(allowlist, ",") {
+		pattern = strings.TrimSuffix(strings.TrimSpace(pattern), "/")
+		switch {
+		case pattern == "*", strings.EqualFold(pattern, origin):
+			return true
+		case strings.Contains(pattern, "://*."):
+			scheme, suffix, _ := strings.Cut(pattern, "://*")
+			u, err := url.Parse(origin)
+			if err == nil && strings.EqualFold(u.Scheme, scheme) && strings.HasSuffix(strings.ToLower(u.Host), strings.ToLower(suffix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Wrap returns next behind the CORS policy of params.
+func Wrap(params *dynconfig.Store, next httpx.Handler) httpx.Handler {
+	return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+		if r.Direct {
+			return next(ctx, r)
+		}
+		origin := r.Header("Origin")
+		allowed := Allowed(params.String(ctx, dynconfig.CORSOrigins, DefaultOrigins), origin)
+
+		if r.Method == "OPTIONS" && r.Header("Access-Control-Request-Method") != "" {
+			resp := httpx.NoContent()
+			resp.Headers["Vary"] = vary
+			if !allowed {
+				// The browser fails the request without the allow headers
+				slog.InfoContext(ctx, "Rejected preflight request", "origin", origin)
+				return resp, nil
+			}
+			resp.Headers["Access-Control-Allow-Origin"] = origin
+			resp.Headers["Access-Control-Allow-Methods"] = allowMethods
+			resp.Headers["Access-Control-Allow-Headers"] = allowHeaders
+			resp.Headers["Access-Control-Max-Age"] = maxAge
+			return resp, nil
+		}
+
+		resp, err := next(ctx, r)
+		if err != nil {
+			return resp, err
+		}
+		if resp.Headers == nil {
+			resp.Headers = map[string]string{}
+		}
+		httpx.AddVary(resp.Headers, "Origin")
+		if allowed {
+			resp.Headers["Access-Control-Allow-Origin"] = origin
+			resp.Headers["Access-Control-Expose-Headers"] = exposeHeaders
+		}
+		return resp, nil
+	}
+}
+
+// Middleware returns the CORS policy of the container's dynconfig store.
+// Lambda entry points pass it to httpx.Adapt, which runs it outside error
+// handling, so error responses carry the headers too, and before the
+// maintenance switch and authentication, which preflight requests skip.
+func Middleware(cfg *config.Config) httpx.Middleware {
+	params, err := dynconfig.Shared(context.Background(), cfg)
+	if err != nil {
+		slog.Warn("CORS allowlist unavailable", logging.Err(err))
+	}
+	return func(next httpx.Handler) httpx.Handler {
+		return Wrap(params, next)
+	}
+}
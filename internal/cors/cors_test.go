@@ -0,0 +1,104 @@
+package cors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"troggle-backend/internal/dynconfig"
+	"troggle-backend/internal/httpx"
+)
+
+// parameters answers parameter reads from values, keyed by name below the
+// path.
+type parameters map[string]string
+
+func (p parameters) GetParametersByPath(_ context.Context, in *ssm.GetParametersByPathInput, _ ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	var out ssm.GetParametersByPathOutput
+	for name, value := range p {
+		out.Parameters = append(out.Parameters, ssmtypes.Parameter{Name: aws.String(aws.ToString(in.Path) + name), Value: aws.String(value)})
+	}
+	return &out, nil
+}
+
+func TestAllowed(t *testing.T) {
+	allowlist := "https://app.troggle.gg, https://*.preview.troggle.gg/"
+	tests := map[string]bool{
+		"https://app.troggle.gg":             true,
+		"https://APP.troggle.gg":             true,
+		"https://pr-12.preview.troggle.gg":   true,
+		"http://pr-12.preview.troggle.gg":    false,
+		"https://preview.troggle.gg":         false,
+		"https://evilpreview.troggle.gg":     false,
+		"https://app.troggle.gg.example.com": false,
+		"":                                   false,
+	}
+	for origin, want := range tests {
+		if got := Allowed(allowlist, origin); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", origin, got, want)
+		}
+	}
+	if !Allowed(DefaultOrigins, "http://localhost:3000") {
+		t.Error("default allowlist rejects localhost")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	store := &dynconfig.Store{API: parameters{"cors_allowed_origins": "https://app.troggle.gg"}, Path: "/troggle/test/", TTL: time.Minute}
+	var called bool
+	h := httpx.Adapt(func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+		called = true
+		return httpx.Response{}, errors.New("boom")
+	}, func(next httpx.Handler) httpx.Handler { return Wrap(store, next) })
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		preflight  bool
+		wantStatus int
+		wantAllow  string
+		wantVary   string
+	}{
+		{name: "preflight", method: "OPTIONS", origin: "https://app.troggle.gg", preflight: true, wantStatus: 204, wantAllow: "https://app.troggle.gg", wantVary: vary},
+		{name: "preflight of another origin", method: "OPTIONS", origin: "https://evil.example", preflight: true, wantStatus: 204, wantVary: vary},
+		{name: "error response", method: "GET", origin: "https://app.troggle.gg", wantStatus: 500, wantAllow: "https://app.troggle.gg", wantVary: "Origin"},
+		{name: "another origin", method: "GET", origin: "https://evil.example", wantStatus: 500, wantVary: "Origin"},
+		{name: "no origin", method: "GET", wantStatus: 500, wantVary: "Origin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			headers := map[string]string{}
+			if tt.origin != "" {
+				headers["Origin"] = tt.origin
+			}
+			if tt.preflight {
+				headers["Access-Control-Request-Method"] = "POST"
+			}
+			event, _ := json.Marshal(map[string]any{"httpMethod": tt.method, "path": "/users", "headers": headers})
+			resp, err := h(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if called == tt.preflight {
+				t.Errorf("handler called = %v on a preflight = %v request", called, tt.preflight)
+			}
+			if got := resp.Headers["Access-Control-Allow-Origin"]; got != tt.wantAllow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllow)
+			}
+			if got := resp.Headers["Vary"]; got != tt.wantVary {
+				t.Errorf("Vary = %q, want %q", got, tt.wantVary)
+			}
+		})
+	}
+}
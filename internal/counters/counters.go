@@ -0,0 +1,145 @@
+// Package counters keeps aggregate counts, such as the number of users in
+// each status, in the counter table. The userStream function maintains them
+// from the user table's stream, so they lag writes by seconds and are
+// approximate: a stream batch retried after a partial failure can count a
+// change twice. They suit dashboards and limits, not billing. Other
+// counters, such as the daily ones of package invitations, are added to by
+// the functions whose writes they count.
+package counters
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+// Users counts user records.
+const Users = "users"
+
+// UsersWithStatus names the counter of users in status.
+func UsersWithStatus(status string) string {
+	return "users#status#" + status
+}
+
+// Store reads and updates counters.
+type Store struct {
+	DB    *db.Client
+	Table string
+}
+
+// NewStore returns a store over the counter table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.CounterTableName}
+}
+
+// Add adds each delta to its counter, creating counters at zero. Each
+// counter is updated atomically, but not all of them together.
+func (s *Store) Add(ctx context.Context, deltas map[string]int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for name, delta := range deltas {
+		start := time.Now()
+		_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:        aws.String(s.Table),
+			Key:              key(name),
+			UpdateExpression: aws.String("ADD #value :delta SET updated_at = :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#value": "value", // reserved word
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":delta": &types.AttributeValueMemberN{Value: strconv.FormatInt(delta, 10)},
+				":now":   &types.AttributeValueMemberS{Value: now},
+			},
+		})
+		db.Observe(ctx, start, err)
+		if err != nil {
+			return db.Wrap(err, "updating counter "+name)
+		}
+	}
+	return nil
+}
+
+// Next adds one to the counter name and returns its new value, so each
+// caller gets its own number of a sequence starting at 1.
+func (s *Store) Next(ctx context.Context, name string) (int64, error) {
+	start := time.Now()
+	out, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(s.Table),
+		Key:              key(name),
+		UpdateExpression: aws.String("ADD #value :one SET updated_at = :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#value": "value", // reserved word
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+			":now": &types.AttributeValueMemberS{Value: start.UTC().Format(time.RFC3339)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return 0, db.Wrap(err, "updating counter "+name)
+	}
+	c, err := db.Decode[counter](out.Attributes)
+	if err != nil {
+		return 0, fmt.Errorf("decoding counter %s: %w", name, err)
+	}
+	return c.Value, nil
+}
+
+// Get returns the value of the counter name; zero if it was never updated.
+func (s *Store) Get(ctx context.Context, name string) (int64, error) {
+	item, err := s.DB.GetItem(ctx, s.Table, key(name))
+	if err != nil || item == nil {
+		return 0, err
+	}
+	v, ok := item["value"].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v.Value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("decoding counter %s: %w", name, err)
+	}
+	return n, nil
+}
+
+// GetMany returns the values of the counters names, by name; counters never
+// updated are left out.
+func (s *Store) GetMany(ctx context.Context, names []string) (map[string]int64, error) {
+	keys := make([]db.Item, len(names))
+	for i, name := range names {
+		keys[i] = key(name)
+	}
+	items, err := s.DB.BatchGet(ctx, s.Table, keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]int64, len(items))
+	for _, item := range items {
+		c, err := db.Decode[counter](item)
+		if err != nil {
+			return nil, err
+		}
+		values[c.Name] = c.Value
+	}
+	return values, nil
+}
+
+// counter is an item of the counter table.
+type counter struct {
+	Name  string `dynamodbav:"counter"`
+	Value int64  `dynamodbav:"value"`
+}
+
+// key returns the primary key of the counter name.
+func key(name string) db.Item {
+	return db.Item{"counter": &types.AttributeValueMemberS{Value: name}}
+}
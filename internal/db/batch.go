@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/tracing"
+)
+
+// Limits of the items of one BatchWriteItem and one BatchGetItem call.
+const (
+	MaxBatchWrite = 25
+	MaxBatchGet   = 100
+)
+
+const (
+	// batchAttempts bounds the calls made for one batch while DynamoDB
+	// keeps returning unprocessed items.
+	batchAttempts = 5
+
+	// batchBackoff is the wait before the first retry of unprocessed
+	// items; it doubles with every retry.
+	batchBackoff = 50 * time.Millisecond
+)
+
+// BatchDelete deletes the items of tableName with the given keys, at most
+// MaxBatchWrite of them. Keys DynamoDB leaves unprocessed, when the table
+// is short of capacity, are retried with backoff. Deleting a missing item
+// is not an error.
+func (c *Client) BatchDelete(ctx context.Context, tableName string, keys []Item) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if len(keys) > MaxBatchWrite {
+		return fmt.Errorf("batch of %d deletes exceeds %d", len(keys), MaxBatchWrite)
+	}
+	return tracing.Capture(ctx, "db.BatchDelete", func(ctx context.Context) error {
+		tracing.Annotate(ctx, "table", tableName)
+		tracing.Annotate(ctx, "item_count", len(keys))
+
+		requests := make([]types.WriteRequest, len(keys))
+		for i, key := range keys {
+			requests[i] = types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: key}}
+		}
+		return c.writeBatch(ctx, tableName, requests, "deleting batch from "+tableName)
+	})
+}
+
+// BatchPut writes items into tableName, replacing any with the same keys,
+// however many there are: they are written MaxBatchWrite at a time, and
+// those DynamoDB leaves unprocessed are retried with backoff. Unlike
+// PutItem, the writes take no condition; a failure may leave some of the
+// items written.
+func (c *Client) BatchPut(ctx context.Context, tableName string, items []Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return tracing.Capture(ctx, "db.BatchPut", func(ctx context.Context) error {
+		tracing.Annotate(ctx, "table", tableName)
+		tracing.Annotate(ctx, "item_count", len(items))
+
+		for chunk := range slices.Chunk(items, MaxBatchWrite) {
+			requests := make([]types.WriteRequest, len(chunk))
+			for i, item := range chunk {
+				requests[i] = types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+			}
+			if err := c.writeBatch(ctx, tableName, requests, "writing batch into "+tableName); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeBatch runs a BatchWriteItem of requests, at most MaxBatchWrite,
+// until DynamoDB has processed all of them. op describes it in errors.
+func (c *Client) writeBatch(ctx context.Context, tableName string, requests []types.WriteRequest, op string) error {
+	pending := map[string][]types.WriteRequest{tableName: requests}
+	return untilProcessed(ctx, op, func() (int, error) {
+		start := time.Now()
+		result, err := c.DynamoDB.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+		Observe(ctx, start, err)
+		if err != nil {
+			return 0, Wrap(err, op)
+		}
+		pending = result.UnprocessedItems
+		return len(pending[tableName]), nil
+	})
+}
+
+// BatchGet reads the items of tableName with the given keys, however many
+// there are: they are read MaxBatchGet at a time, and keys DynamoDB leaves
+// unprocessed are retried with backoff. Items come in no particular order,
+// keys without an item are left out and keys listed twice are read once.
+// attributes, when given, are the only ones read; they must include the
+// key attributes for callers to tell the items apart.
+func (c *Client) BatchGet(ctx context.Context, tableName string, keys []Item, attributes ...string) ([]Item, error) {
+	keys = distinct(keys)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var projection *expression.Expression
+	if len(attributes) > 0 {
+		expr, err := Projection(attributes...)
+		if err != nil {
+			return nil, err
+		}
+		projection = &expr
+	}
+	var items []Item
+	err := tracing.Capture(ctx, "db.BatchGet", func(ctx context.Context) error {
+		tracing.Annotate(ctx, "table", tableName)
+		tracing.Annotate(ctx, "item_count", len(keys))
+
+		op := "reading batch from " + tableName
+		for chunk := range slices.Chunk(keys, MaxBatchGet) {
+			pending := map[string]types.KeysAndAttributes{tableName: {Keys: chunk}}
+			if projection != nil {
+				pending[tableName] = types.KeysAndAttributes{
+					Keys:                     chunk,
+					ProjectionExpression:     projection.Projection(),
+					ExpressionAttributeNames: projection.Names(),
+				}
+			}
+			err := untilProcessed(ctx, op, func() (int, error) {
+				start := time.Now()
+				result, err := c.DynamoDB.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: pending})
+				Observe(ctx, start, err)
+				if err != nil {
+					return 0, Wrap(err, op)
+				}
+				items = append(items, result.Responses[tableName]...)
+				pending = result.UnprocessedKeys
+				return len(pending[tableName].Keys), nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		tracing.Annotate(ctx, "result_count", len(items))
+		return nil
+	})
+	return items, err
+}
+
+// distinct returns keys without the repeated ones, which BatchGetItem
+// rejects.
+func distinct(keys []Item) []Item {
+	seen := make(map[string]bool, len(keys))
+	out := make([]Item, 0, len(keys))
+	for _, k := range keys {
+		if d := Digest(k); !seen[d] {
+			seen[d] = true
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// untilProcessed calls call, which returns how many of its items DynamoDB
+// left unprocessed, until it leaves none, waiting batchBackoff, doubled
+// every time, between calls. It gives up after batchAttempts calls.
+func untilProcessed(ctx context.Context, op string, call func() (left int, err error)) error {
+	wait := batchBackoff
+	for attempt := 1; ; attempt++ {
+		left, err := call()
+		if err != nil {
+			return err
+		}
+		if left == 0 {
+			return nil
+		}
+		if attempt == batchAttempts {
+			return fmt.Errorf("%s: %d items left unprocessed", op, left)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// ScanSegments runs a parallel Scan of input in segments segments, each
+// read page by page in its own goroutine, and calls fn with the items of
+// every page. fn is called concurrently and must be safe for that. The
+// first error, of a page or of fn, stops every segment and is returned
+// along with any others that happened meanwhile.
+func (c *Client) ScanSegments(ctx context.Context, input *dynamodb.ScanInput, segments int, fn func(ctx context.Context, items []Item) error) error {
+	return tracing.Capture(ctx, "db.ScanSegments", func(ctx context.Context) error {
+		segments = max(segments, 1)
+		tracing.Annotate(ctx, "table", aws.ToString(input.TableName))
+		tracing.Annotate(ctx, "segments", segments)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var (
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			errs []error
+		)
+		for segment := range segments {
+			// Each segment pages through its own copy of the input
+			in := *input
+			in.Segment, in.TotalSegments = aws.Int32(int32(segment)), aws.Int32(int32(segments))
+			wg.Go(func() {
+				if err := c.scanSegment(ctx, &in, fn); err != nil {
+					mu.Lock()
+					// Segments stopped by the first failure only echo it
+					if len(errs) == 0 || !errors.Is(err, context.Canceled) {
+						errs = append(errs, err)
+					}
+					mu.Unlock()
+					cancel()
+				}
+			})
+		}
+		wg.Wait()
+		return errors.Join(errs...)
+	})
+}
+
+// scanSegment reads one segment of a parallel Scan.
+func (c *Client) scanSegment(ctx context.Context, input *dynamodb.ScanInput, fn func(ctx context.Context, items []Item) error) error {
+	paginator := dynamodb.NewScanPaginator(c.DynamoDB, input)
+	for paginator.HasMorePages() {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		Observe(ctx, start, err)
+		if err != nil {
+			return Wrap(err, fmt.Sprintf("scanning segment %d of %s", aws.ToInt32(input.Segment), aws.ToString(input.TableName)))
+		}
+		if err := fn(ctx, page.Items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
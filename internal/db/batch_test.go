@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// batchAPI leaves the first unprocessed items of each BatchWriteItem call
+// unprocessed, for as many calls as it has counts, and scans one page of
+// one item per segment. The embedded API is nil: other methods are not
+// used.
+type batchAPI struct {
+	API
+	unprocessed []int
+	scanErr     error
+
+	mu       sync.Mutex
+	calls    int
+	sizes    []int
+	segments []int32
+}
+
+func (b *batchAPI) BatchWriteItem(_ context.Context, in *dynamodb.BatchWriteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	b.calls++
+	for _, requests := range in.RequestItems {
+		b.sizes = append(b.sizes, len(requests))
+	}
+	out := &dynamodb.BatchWriteItemOutput{}
+	if b.calls <= len(b.unprocessed) {
+		for table, requests := range in.RequestItems {
+			out.UnprocessedItems = map[string][]types.WriteRequest{table: requests[:b.unprocessed[b.calls-1]]}
+		}
+	}
+	return out, nil
+}
+
+// BatchGetItem answers with the item of each key not left unprocessed,
+// leaving the first keys unprocessed like BatchWriteItem.
+func (b *batchAPI) BatchGetItem(_ context.Context, in *dynamodb.BatchGetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	out := &dynamodb.BatchGetItemOutput{Responses: map[string][]Item{}}
+	for table, ka := range in.RequestItems {
+		b.sizes = append(b.sizes, len(ka.Keys))
+		keys := ka.Keys
+		if b.calls <= len(b.unprocessed) {
+			n := b.unprocessed[b.calls-1]
+			out.UnprocessedKeys = map[string]types.KeysAndAttributes{table: {Keys: keys[:n]}}
+			keys = keys[n:]
+		}
+		out.Responses[table] = keys
+	}
+	return out, nil
+}
+
+func (b *batchAPI) Scan(_ context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.segments = append(b.segments, aws.ToInt32(in.Segment))
+	if aws.ToInt32(in.Segment) == 1 && b.scanErr != nil {
+		return nil, b.scanErr
+	}
+	return &dynamodb.ScanOutput{Items: []Item{{"id": &types.AttributeValueMemberS{Value: "x"}}}}, nil
+}
+
+func TestBatchDelete(t *testing.T) {
+	keys := []Item{
+		{"id": &types.AttributeValueMemberS{Value: "a"}},
+		{"id": &types.AttributeValueMemberS{Value: "b"}},
+	}
+	tests := []struct {
+		name        string
+		keys        []Item
+		unprocessed []int
+		wantCalls   int
+		wantErr     bool
+	}{
+		{name: "all processed", keys: keys, wantCalls: 1},
+		{name: "retries unprocessed", keys: keys, unprocessed: []int{2, 1}, wantCalls: 3},
+		{name: "gives up", keys: keys, unprocessed: []int{1, 1, 1, 1, 1}, wantCalls: batchAttempts, wantErr: true},
+		{name: "nothing to delete"},
+		{name: "too many", keys: make([]Item, MaxBatchWrite+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &batchAPI{unprocessed: tt.unprocessed}
+			c := &Client{DynamoDB: api}
+
+			err := c.BatchDelete(context.Background(), "table", tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if api.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", api.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// keys returns n distinct keys.
+func keys(n int) []Item {
+	out := make([]Item, n)
+	for i := range out {
+		out[i] = Item{"id": &types.AttributeValueMemberN{Value: strconv.Itoa(i)}}
+	}
+	return out
+}
+
+func TestBatchPut(t *testing.T) {
+	api := &batchAPI{unprocessed: []int{3}}
+	c := &Client{DynamoDB: api}
+
+	if err := c.BatchPut(context.Background(), "table", keys(60)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{25, 3, 25, 10}; !slices.Equal(api.sizes, want) {
+		t.Errorf("batch sizes %v, want %v", api.sizes, want)
+	}
+}
+
+func TestBatchGet(t *testing.T) {
+	tests := []struct {
+		name        string
+		keys        []Item
+		unprocessed []int
+		wantSizes   []int
+		wantItems   int
+		wantErr     bool
+	}{
+		{name: "chunked", keys: keys(250), wantSizes: []int{100, 100, 50}, wantItems: 250},
+		{name: "retries unprocessed", keys: keys(10), unprocessed: []int{4, 1}, wantSizes: []int{10, 4, 1}, wantItems: 10},
+		{name: "gives up", keys: keys(2), unprocessed: []int{1, 1, 1, 1, 1}, wantSizes: []int{2, 1, 1, 1, 1}, wantItems: 1, wantErr: true},
+		{name: "repeated keys read once", keys: append(keys(3), keys(3)...), wantSizes: []int{3}, wantItems: 3},
+		{name: "nothing to read"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &batchAPI{unprocessed: tt.unprocessed}
+			c := &Client{DynamoDB: api}
+
+			items, err := c.BatchGet(context.Background(), "table", tt.keys, "id")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(items) != tt.wantItems {
+				t.Errorf("%d items, want %d", len(items), tt.wantItems)
+			}
+			if !slices.Equal(api.sizes, tt.wantSizes) {
+				t.Errorf("batch sizes %v, want %v", api.sizes, tt.wantSizes)
+			}
+		})
+	}
+}
+
+func TestScanSegments(t *testing.T) {
+	tests := []struct {
+		name      string
+		segments  int
+		scanErr   error
+		wantPages int
+		wantErr   bool
+	}{
+		{name: "every segment", segments: 4, wantPages: 4},
+		{name: "one segment at least", segments: 0, wantPages: 1},
+		{name: "failing segment", segments: 2, scanErr: errUnreachable, wantPages: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &batchAPI{scanErr: tt.scanErr}
+			c := &Client{DynamoDB: api}
+
+			var mu sync.Mutex
+			pages := 0
+			err := c.ScanSegments(context.Background(), &dynamodb.ScanInput{TableName: aws.String("table")}, tt.segments, func(_ context.Context, items []Item) error {
+				mu.Lock()
+				defer mu.Unlock()
+				pages++
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if pages != tt.wantPages {
+				t.Errorf("pages = %d, want %d", pages, tt.wantPages)
+			}
+			if len(api.segments) != max(tt.segments, 1) {
+				t.Errorf("scanned segments %v, want %d", api.segments, max(tt.segments, 1))
+			}
+		})
+	}
+}
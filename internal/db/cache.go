@@ -0,0 +1,156 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/metrics"
+)
+
+// Cache is a small in-memory LRU with a fixed time-to-live, for reads that
+// warm invocations repeat, such as signup retries checking the same email.
+// It lives as long as the container, so each container has its own copy:
+// Invalidate only reaches the container it is called in, and the TTL bounds
+// how stale the others can be.
+//
+// A nil *Cache is valid and caches nothing.
+type Cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+// cacheEntry is the value of an element of Cache.order.
+type cacheEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// NewCache returns a cache holding at most size entries for ttl each. It
+// returns nil, a disabled cache, when either is not positive.
+func NewCache(size int, ttl time.Duration) *Cache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &Cache{
+		ttl:     ttl,
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+		now:     time.Now,
+	}
+}
+
+var (
+	sharedCacheOnce sync.Once
+	sharedCache     *Cache
+)
+
+// SharedCache returns the container-wide cache sized by cfg, creating it on
+// first use; it is nil when cfg.CacheTTL disables caching. Like Shared, cfg
+// is only consulted by the first call.
+func SharedCache(cfg *config.Config) *Cache {
+	sharedCacheOnce.Do(func() {
+		sharedCache = NewCache(cfg.CacheSize, cfg.CacheTTL)
+	})
+	return sharedCache
+}
+
+// Get returns the value cached under key, counting a metrics.CacheHit or
+// metrics.CacheMiss. Expired entries count as misses.
+func (c *Cache) Get(ctx context.Context, key string) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	value, ok := c.get(key)
+	c.mu.Unlock()
+
+	if ok {
+		metrics.Count(ctx, metrics.CacheHit)
+	} else {
+		metrics.Count(ctx, metrics.CacheMiss)
+	}
+	return value, ok
+}
+
+// get looks up key, dropping it if it has expired. c.mu must be held.
+func (c *Cache) get(key string) (any, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+// Set caches value under key for the cache's TTL, evicting the least
+// recently used entry when the cache is full. Cached values are shared, so
+// callers must not modify them.
+func (c *Cache) Set(key string, value any) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate drops the entries cached under keys. Writers call it after a
+// mutation so that later reads in this container see the change.
+func (c *Cache) Invalidate(keys ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// Len returns the number of entries held, including expired ones not yet
+// dropped.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove drops el from the cache. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testCache returns a cache whose clock only moves when the test advances it.
+func testCache(size int, ttl time.Duration) (*Cache, func(time.Duration)) {
+	c := NewCache(size, ttl)
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	c, advance := testCache(10, time.Minute)
+
+	c.Set("a", 1)
+	advance(59 * time.Second)
+	if v, ok := c.Get(ctx, "a"); !ok || v != 1 {
+		t.Fatalf("Get before expiry = %v, %v", v, ok)
+	}
+	advance(time.Second)
+	if _, ok := c.Get(ctx, "a"); ok {
+		t.Fatal("Get after expiry hit")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len = %d, want expired entry dropped", c.Len())
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	c, _ := testCache(2, time.Minute)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get(ctx, "a") // b is now the least recently used
+	c.Set("c", 3)
+
+	if _, ok := c.Get(ctx, "b"); ok {
+		t.Error("least recently used entry was kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(ctx, key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+}
+
+func TestCacheOverwrite(t *testing.T) {
+	ctx := context.Background()
+	c, advance := testCache(2, time.Minute)
+
+	c.Set("a", 1)
+	advance(30 * time.Second)
+	c.Set("a", 2) // also renews the TTL
+	advance(45 * time.Second)
+
+	if v, ok := c.Get(ctx, "a"); !ok || v != 2 {
+		t.Errorf("Get = %v, %v; want 2, true", v, ok)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len = %d, want 1", c.Len())
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	ctx := context.Background()
+	c, _ := testCache(10, time.Minute)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Invalidate("a", "missing")
+
+	if _, ok := c.Get(ctx, "a"); ok {
+		t.Error("invalidated entry still cached")
+	}
+	if _, ok := c.Get(ctx, "b"); !ok {
+		t.Error("unrelated entry was dropped")
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	for _, c := range []*Cache{NewCache(0, time.Minute), NewCache(10, 0)} {
+		if c != nil {
+			t.Fatal("NewCache did not disable the cache")
+		}
+		c.Set("a", 1)
+		c.Invalidate("a")
+		if _, ok := c.Get(context.Background(), "a"); ok {
+			t.Error("disabled cache hit")
+		}
+	}
+}
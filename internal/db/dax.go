@@ -0,0 +1,20 @@
+//go:build dax
+
+package db
+
+import (
+	"context"
+
+	"github.com/aws/aws-dax-go-v2/dax"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// newDAX builds a DAX client for the cluster at endpoint, e.g.
+// dax://my-cluster.abc123.dax-clusters.us-east-1.amazonaws.com.
+//
+// Building with the dax tag needs the DAX SDK in go.mod:
+//
+//	go get github.com/aws/aws-dax-go-v2
+func newDAX(ctx context.Context, awsCfg aws.Config, endpoint string) (API, error) {
+	return dax.New(dax.NewConfig(awsCfg, endpoint))
+}
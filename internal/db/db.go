@@ -0,0 +1,326 @@
+// Package db owns the DynamoDB client shared by every troggle Lambda.
+//
+// The client is built once per container (at cold start) and reused by every
+// warm invocation, so function packages never have to construct the SDK
+// client themselves. Retry policy comes from the shared awscfg config, except
+// for throttling: Resilient backs off on it and sheds load while DynamoDB
+// keeps failing.
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/awscfg"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+	"troggle-backend/internal/tracing"
+	"troggle-backend/internal/warmup"
+)
+
+// Item is a raw DynamoDB item as returned by the SDK.
+type Item = map[string]types.AttributeValue
+
+// DynamoQueryAPI is the read side of DynamoDB that lookups depend on.
+// *dynamodb.Client implements it, and so does dbtest.Mock.
+type DynamoQueryAPI interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// DynamoScanAPI reads whole tables. Only small catalogs, such as the
+// achievement definitions, and tables swept by daily background jobs are
+// scanned.
+type DynamoScanAPI interface {
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// API is the subset of the DynamoDB client the Lambdas use. Keeping it
+// narrow lets tests substitute a mock for the real client.
+type API interface {
+	DynamoQueryAPI
+	DynamoScanAPI
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+}
+
+// Client wraps a DynamoDB API with the small set of helpers the Lambdas
+// actually use.
+type Client struct {
+	DynamoDB API
+}
+
+var (
+	sharedOnce   sync.Once
+	sharedClient *Client
+	sharedErr    error
+)
+
+// Shared returns the container-wide client, creating it on first use.
+// Later calls return the same client (or the same construction error); cfg is
+// only consulted by the first call. Warming it opens a connection to
+// DynamoDB; see warm.
+func Shared(ctx context.Context, cfg *config.Config) (*Client, error) {
+	sharedOnce.Do(func() {
+		sharedClient, sharedErr = New(ctx, cfg)
+		if sharedErr == nil {
+			warmup.Register("dynamodb", func(ctx context.Context) error {
+				return sharedClient.warm(ctx, cfg.UserTableName)
+			})
+		}
+	})
+	return sharedClient, sharedErr
+}
+
+// warm reads a key that does not exist from table, which has the SDK open
+// and keep a connection. Any answer of DynamoDB will do, so errors of the
+// service, such as access being denied to a function not reading table,
+// are not failures.
+func (c *Client) warm(ctx context.Context, table string) error {
+	_, err := c.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key:       Item{"user_id": &types.AttributeValueMemberS{Value: "warmup"}},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return nil
+	}
+	return err
+}
+
+// New builds a Client around the SDK client returned by NewSDK, guarded by
+// Resilient with the timeouts of cfg. When
+// cfg.DAXEndpoint is set, calls go through the DAX cluster instead, failing
+// over to DynamoDB while the cluster is unreachable; a cluster that cannot
+// be set up at all leaves the client on DynamoDB.
+func New(ctx context.Context, cfg *config.Config) (*Client, error) {
+	sdk, err := NewSDK(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := Timeouts{Default: cfg.DynamoDBTimeout, ByOperation: cfg.DynamoDBTimeouts}
+	if cfg.DAXEndpoint == "" {
+		return &Client{DynamoDB: NewResilient(sdk, timeouts)}, nil
+	}
+
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	dax, err := newDAX(ctx, awsCfg, cfg.DAXEndpoint)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to create DAX client, using DynamoDB", "endpoint", cfg.DAXEndpoint, logging.Err(err))
+		return &Client{DynamoDB: NewResilient(sdk, timeouts)}, nil
+	}
+	failover := NewFailover(dax, sdk)
+	// A failed probe is not fatal: calls use DynamoDB until the cooldown ends
+	_ = failover.Check(ctx, cfg.UserTableName)
+	return &Client{DynamoDB: NewResilient(failover, timeouts)}, nil
+}
+
+// NewSDK builds an SDK DynamoDB client from the shared AWS config, pointed
+// at cfg.DynamoDBEndpoint when one is set. Most code wants New; this is for
+// tooling that needs the full API, such as table management.
+func NewSDK(ctx context.Context, cfg *config.Config) (*dynamodb.Client, error) {
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+		// Throttling is retried by Resilient, which also counts it
+		o.Retryer = awscfg.Retryer(func(so *retry.StandardOptions) {
+			so.Retryables = append([]retry.IsErrorRetryable{retry.IsErrorRetryableFunc(func(err error) aws.Ternary {
+				if Throttled(err) {
+					return aws.FalseTernary
+				}
+				return aws.UnknownTernary
+			})}, so.Retryables...)
+		})
+		if cfg.DynamoDBEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+		}
+	}), nil
+}
+
+// Query runs a Query and returns the matching items of the first page.
+func (c *Client) Query(ctx context.Context, input *dynamodb.QueryInput) ([]Item, error) {
+	var items []Item
+	err := tracing.Capture(ctx, "db.Query", func(ctx context.Context) error {
+		tracing.Annotate(ctx, "table", aws.ToString(input.TableName))
+		if input.IndexName != nil {
+			tracing.Annotate(ctx, "index", aws.ToString(input.IndexName))
+		}
+
+		start := time.Now()
+		result, err := c.DynamoDB.Query(ctx, input)
+		Observe(ctx, start, err)
+		if err != nil {
+			return Wrap(err, "querying "+aws.ToString(input.TableName))
+		}
+		items = result.Items
+		tracing.Annotate(ctx, "result_count", len(items))
+		return nil
+	})
+	return items, err
+}
+
+// QueryPages runs a Query and calls fn with the items of each page, in
+// order, until fn returns false or there are no more pages. Limit on input
+// is the page size.
+func (c *Client) QueryPages(ctx context.Context, input *dynamodb.QueryInput, fn func(items []Item) bool) error {
+	return tracing.Capture(ctx, "db.QueryPages", func(ctx context.Context) error {
+		tracing.Annotate(ctx, "table", aws.ToString(input.TableName))
+		if input.IndexName != nil {
+			tracing.Annotate(ctx, "index", aws.ToString(input.IndexName))
+		}
+
+		pages := 0
+		defer func() { tracing.Annotate(ctx, "page_count", pages) }()
+
+		paginator := dynamodb.NewQueryPaginator(c.DynamoDB, input)
+		for paginator.HasMorePages() {
+			start := time.Now()
+			page, err := paginator.NextPage(ctx)
+			Observe(ctx, start, err)
+			if err != nil {
+				return Wrap(err, "querying "+aws.ToString(input.TableName))
+			}
+			pages++
+			if !fn(page.Items) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// GetItem fetches a single item by primary key. A missing item is reported as
+// a nil Item with a nil error.
+func (c *Client) GetItem(ctx context.Context, tableName string, key Item) (Item, error) {
+	var item Item
+	err := tracing.Capture(ctx, "db.GetItem", func(ctx context.Context) error {
+		tracing.Annotate(ctx, "table", tableName)
+
+		start := time.Now()
+		result, err := c.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(tableName),
+			Key:       key,
+		})
+		Observe(ctx, start, err)
+		if err != nil {
+			return Wrap(err, "getting item from "+tableName)
+		}
+		item = result.Item
+		tracing.Annotate(ctx, "found", item != nil)
+		return nil
+	})
+	return item, err
+}
+
+// PutItem writes (or replaces) a single item.
+func (c *Client) PutItem(ctx context.Context, tableName string, item Item) error {
+	return tracing.Capture(ctx, "db.PutItem", func(ctx context.Context) error {
+		tracing.Annotate(ctx, "table", tableName)
+
+		start := time.Now()
+		_, err := c.DynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(tableName),
+			Item:      item,
+		})
+		Observe(ctx, start, err)
+		return Wrap(err, "putting item into "+tableName)
+	})
+}
+
+// TransactWriteItems writes all items atomically: either every put, update,
+// delete and condition check succeeds or none of them is applied.
+func (c *Client) TransactWriteItems(ctx context.Context, items []types.TransactWriteItem) error {
+	return tracing.Capture(ctx, "db.TransactWriteItems", func(ctx context.Context) error {
+		tracing.Annotate(ctx, "item_count", len(items))
+
+		start := time.Now()
+		_, err := c.DynamoDB.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		Observe(ctx, start, err)
+		return Wrap(err, fmt.Sprintf("writing transaction of %d items", len(items)))
+	})
+}
+
+// Observe records the latency of a DynamoDB call and, if it failed for a
+// reason other than a false condition, a dynamo_error. Functions calling the
+// SDK directly use it to keep the metrics complete.
+func Observe(ctx context.Context, start time.Time, err error) {
+	metrics.Since(ctx, metrics.DynamoLatency, start)
+	if err != nil && !ConditionFailed(err, -1) {
+		metrics.Count(ctx, metrics.DynamoError)
+	}
+}
+
+// ConditionFailed reports whether err (or, for a transaction, the item at
+// index) was rejected because its ConditionExpression evaluated to false.
+// Pass index -1 to match any item of a cancelled transaction.
+func ConditionFailed(err error, index int) bool {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return true
+	}
+
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return false
+	}
+	for i, reason := range canceled.CancellationReasons {
+		if (index < 0 || i == index) && aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
+
+// throttlingCodes are the DynamoDB error codes meaning "slow down". They are
+// only surfaced once Resilient has given up.
+var throttlingCodes = map[string]bool{
+	"ProvisionedThroughputExceededException": true,
+	"RequestLimitExceeded":                   true,
+	"ThrottlingException":                    true,
+}
+
+// Wrap annotates a DynamoDB error with the failed operation and classifies
+// it: throttling becomes an apperr.KindThrottled error, anything else keeps
+// its original chain (so ConditionFailed still works) and is treated as
+// internal by the response layer.
+func Wrap(err error, op string) error {
+	if err == nil {
+		return nil
+	}
+	wrapped := fmt.Errorf("%s: %w", op, err)
+
+	if Throttled(err) {
+		return apperr.Throttled(wrapped)
+	}
+	return wrapped
+}
+
+// Throttled reports whether DynamoDB rejected the call in err for exceeding
+// the capacity of a table or the account.
+func Throttled(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && throttlingCodes[apiErr.ErrorCode()]
+}
@@ -0,0 +1,178 @@
+// Package dbtest provides an in-memory stand-in for the DynamoDB API, so
+// data-access code and handlers can be unit tested without AWS.
+package dbtest
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+)
+
+// Mock implements db.API by delegating to the function fields. A nil field
+// answers with an empty output, as DynamoDB would for a missing item or an
+// empty query. Every call is recorded, in order, in Calls.
+type Mock struct {
+	QueryFunc              func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+	GetItemFunc            func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	ScanFunc               func(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error)
+	PutItemFunc            func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+	UpdateItemFunc         func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+	DeleteItemFunc         func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error)
+	TransactWriteItemsFunc func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error)
+	BatchWriteItemFunc     func(*dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error)
+	BatchGetItemFunc       func(*dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error)
+
+	mu    sync.Mutex
+	Calls []Call
+}
+
+// Call is one recorded API call.
+type Call struct {
+	Op    string // e.g. "Query"
+	Input any    // the *dynamodb.<Op>Input
+}
+
+var _ db.API = (*Mock)(nil)
+
+// Client returns a db.Client backed by m.
+func (m *Mock) Client() *db.Client {
+	return &db.Client{DynamoDB: m}
+}
+
+// Ops returns the names of the recorded calls.
+func (m *Mock) Ops() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ops := make([]string, len(m.Calls))
+	for i, c := range m.Calls {
+		ops[i] = c.Op
+	}
+	return ops
+}
+
+func (m *Mock) record(op string, input any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Calls = append(m.Calls, Call{Op: op, Input: input})
+}
+
+func (m *Mock) Query(_ context.Context, in *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	m.record("Query", in)
+	if m.QueryFunc == nil {
+		return &dynamodb.QueryOutput{}, nil
+	}
+	return m.QueryFunc(in)
+}
+
+func (m *Mock) GetItem(_ context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	m.record("GetItem", in)
+	if m.GetItemFunc == nil {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return m.GetItemFunc(in)
+}
+
+func (m *Mock) Scan(_ context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	m.record("Scan", in)
+	if m.ScanFunc == nil {
+		return &dynamodb.ScanOutput{}, nil
+	}
+	return m.ScanFunc(in)
+}
+
+func (m *Mock) PutItem(_ context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.record("PutItem", in)
+	if m.PutItemFunc == nil {
+		return &dynamodb.PutItemOutput{}, nil
+	}
+	return m.PutItemFunc(in)
+}
+
+func (m *Mock) UpdateItem(_ context.Context, in *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	m.record("UpdateItem", in)
+	if m.UpdateItemFunc == nil {
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	return m.UpdateItemFunc(in)
+}
+
+func (m *Mock) DeleteItem(_ context.Context, in *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	m.record("DeleteItem", in)
+	if m.DeleteItemFunc == nil {
+		return &dynamodb.DeleteItemOutput{}, nil
+	}
+	return m.DeleteItemFunc(in)
+}
+
+func (m *Mock) TransactWriteItems(_ context.Context, in *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	m.record("TransactWriteItems", in)
+	if m.TransactWriteItemsFunc == nil {
+		return &dynamodb.TransactWriteItemsOutput{}, nil
+	}
+	return m.TransactWriteItemsFunc(in)
+}
+
+func (m *Mock) BatchWriteItem(_ context.Context, in *dynamodb.BatchWriteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	m.record("BatchWriteItem", in)
+	if m.BatchWriteItemFunc == nil {
+		return &dynamodb.BatchWriteItemOutput{}, nil
+	}
+	return m.BatchWriteItemFunc(in)
+}
+
+func (m *Mock) BatchGetItem(_ context.Context, in *dynamodb.BatchGetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	m.record("BatchGetItem", in)
+	if m.BatchGetItemFunc == nil {
+		return &dynamodb.BatchGetItemOutput{}, nil
+	}
+	return m.BatchGetItemFunc(in)
+}
+
+// Projected returns the attributes a ProjectionExpression reads, in order,
+// with the placeholders of names resolved.
+func Projected(expr *string, names map[string]string) []string {
+	var out []string
+	for _, p := range strings.Split(aws.ToString(expr), ",") {
+		p = strings.TrimSpace(p)
+		if name, ok := names[p]; ok {
+			p = name
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// Item builds an item of string attributes from name/value pairs.
+func Item(pairs ...string) db.Item {
+	item := make(db.Item, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		item[pairs[i]] = &types.AttributeValueMemberS{Value: pairs[i+1]}
+	}
+	return item
+}
+
+// Throttled returns the error DynamoDB reports when throughput is exceeded.
+func Throttled() error {
+	return &types.ProvisionedThroughputExceededException{Message: aws.String("throttled")}
+}
+
+// ConditionFailed returns the error of a failed condition expression.
+func ConditionFailed() error {
+	return &types.ConditionalCheckFailedException{Message: aws.String("condition failed")}
+}
+
+// TransactionCanceled returns a canceled transaction with one reason code per
+// item; use "None" for items that did not fail.
+func TransactionCanceled(codes ...string) error {
+	reasons := make([]types.CancellationReason, len(codes))
+	for i, c := range codes {
+		reasons[i] = types.CancellationReason{Code: aws.String(c)}
+	}
+	return &types.TransactionCanceledException{Message: aws.String("canceled"), CancellationReasons: reasons}
+}
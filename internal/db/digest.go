@@ -0,0 +1,88 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"slices"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Digest returns a hash of the attributes of item that changes whenever any
+// of them does, whatever the order they were read in. Entity tags are built
+// from it: unlike a version attribute, it also covers the counters and
+// status changes that are written without bumping the version.
+func Digest(item Item) string {
+	h := sha256.New()
+	digestMap(h, item)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digestMap writes the attributes of m to h in name order.
+func digestMap(h hash.Hash, m map[string]types.AttributeValue) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	h.Write([]byte("M" + strconv.Itoa(len(names))))
+	for _, name := range names {
+		digestString(h, name)
+		digestValue(h, m[name])
+	}
+}
+
+// digestValue writes v to h, prefixed with its type so that, say, the
+// string "1" and the number 1 differ.
+func digestValue(h hash.Hash, v types.AttributeValue) {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		h.Write([]byte("S"))
+		digestString(h, v.Value)
+	case *types.AttributeValueMemberN:
+		h.Write([]byte("N"))
+		digestString(h, v.Value)
+	case *types.AttributeValueMemberB:
+		h.Write([]byte("B"))
+		digestString(h, string(v.Value))
+	case *types.AttributeValueMemberBOOL:
+		h.Write([]byte("BOOL" + strconv.FormatBool(v.Value)))
+	case *types.AttributeValueMemberNULL:
+		h.Write([]byte("NULL"))
+	case *types.AttributeValueMemberL:
+		h.Write([]byte("L" + strconv.Itoa(len(v.Value))))
+		for _, e := range v.Value {
+			digestValue(h, e)
+		}
+	case *types.AttributeValueMemberM:
+		digestMap(h, v.Value)
+	case *types.AttributeValueMemberSS:
+		digestSet(h, "SS", v.Value)
+	case *types.AttributeValueMemberNS:
+		digestSet(h, "NS", v.Value)
+	case *types.AttributeValueMemberBS:
+		members := make([]string, len(v.Value))
+		for i, b := range v.Value {
+			members[i] = string(b)
+		}
+		digestSet(h, "BS", members)
+	}
+}
+
+// digestSet writes the members of a set to h in sorted order: DynamoDB
+// returns them in any order.
+func digestSet(h hash.Hash, typ string, members []string) {
+	members = slices.Sorted(slices.Values(members))
+	h.Write([]byte(typ + strconv.Itoa(len(members))))
+	for _, m := range members {
+		digestString(h, m)
+	}
+}
+
+// digestString writes s to h prefixed with its length, so that adjacent
+// strings cannot run into each other.
+func digestString(h hash.Hash, s string) {
+	h.Write([]byte(strconv.Itoa(len(s)) + ":" + s))
+}
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDigest(t *testing.T) {
+	item := func(count string, tags ...string) Item {
+		return Item{
+			"user_id":      &types.AttributeValueMemberS{Value: "u1"},
+			"friend_count": &types.AttributeValueMemberN{Value: count},
+			"tags":         &types.AttributeValueMemberSS{Value: tags},
+		}
+	}
+	if Digest(item("1", "a", "b")) != Digest(item("1", "b", "a")) {
+		t.Error("digest depends on the order of set members")
+	}
+	if Digest(item("1", "a")) == Digest(item("2", "a")) {
+		t.Error("digest ignores a counter change")
+	}
+	s := Item{"n": &types.AttributeValueMemberS{Value: "1"}}
+	n := Item{"n": &types.AttributeValueMemberN{Value: "1"}}
+	if Digest(s) == Digest(n) {
+		t.Error("digest ignores attribute types")
+	}
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+)
+
+const (
+	// daxCooldown is how long calls bypass DAX after it failed, before the
+	// next call tries it again.
+	daxCooldown = 30 * time.Second
+
+	// daxProbeTimeout bounds the health check made at cold start.
+	daxProbeTimeout = time.Second
+
+	// daxProbeKey is the user_id the health check reads. No user has it.
+	daxProbeKey = "__dax_health__"
+)
+
+// Failover sends calls to a DAX cluster and falls back to DynamoDB itself
+// when the cluster is unreachable.
+//
+// A DAX failure marks the cluster down for daxCooldown, during which every
+// call goes straight to DynamoDB; the first call after that tries DAX again.
+// Failed reads are retried on DynamoDB at once. Failed writes are not, since
+// DAX may have applied them before the connection broke: they return the
+// error and the caller's retry lands on DynamoDB.
+//
+// Only transport-level failures count against DAX. Errors DynamoDB answered
+// with (a false condition, throttling, validation) are returned as they are.
+type Failover struct {
+	DAX      API
+	DynamoDB API
+
+	mu        sync.Mutex
+	downUntil time.Time
+	now       func() time.Time
+}
+
+// NewFailover returns a Failover preferring dax over dynamo.
+func NewFailover(dax, dynamo API) *Failover {
+	return &Failover{DAX: dax, DynamoDB: dynamo, now: time.Now}
+}
+
+// Check probes the cluster with a read of a user_id no user has in
+// userTable, marking it down if the read fails.
+func (f *Failover) Check(ctx context.Context, userTable string) error {
+	ctx, cancel := context.WithTimeout(ctx, daxProbeTimeout)
+	defer cancel()
+
+	_, err := f.DAX.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(userTable),
+		Key:       Item{"user_id": &types.AttributeValueMemberS{Value: daxProbeKey}},
+	})
+	if err != nil {
+		f.fail(ctx, err)
+	}
+	return err
+}
+
+// Healthy reports whether calls currently go to DAX.
+func (f *Failover) Healthy() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.now().Before(f.downUntil)
+}
+
+// fail marks the cluster down after err.
+func (f *Failover) fail(ctx context.Context, err error) {
+	f.mu.Lock()
+	f.downUntil = f.now().Add(daxCooldown)
+	f.mu.Unlock()
+
+	slog.WarnContext(ctx, "DAX unavailable, falling back to DynamoDB", "cooldown", daxCooldown.String(), logging.Err(err))
+	metrics.Count(ctx, metrics.DAXFailover)
+}
+
+// daxFailure reports whether err means DAX could not serve the call, as
+// opposed to DynamoDB rejecting it or the caller giving up.
+func daxFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var apiErr smithy.APIError
+	return !errors.As(err, &apiErr)
+}
+
+// read runs a read on DAX when it is healthy, retrying on DynamoDB when DAX
+// fails.
+func read[T any](ctx context.Context, f *Failover, call func(API) (T, error)) (T, error) {
+	if !f.Healthy() {
+		return call(f.DynamoDB)
+	}
+	out, err := call(f.DAX)
+	if daxFailure(ctx, err) {
+		f.fail(ctx, err)
+		return call(f.DynamoDB)
+	}
+	return out, err
+}
+
+// write runs a write on DAX when it is healthy, without retrying it
+// elsewhere.
+func write[T any](ctx context.Context, f *Failover, call func(API) (T, error)) (T, error) {
+	if !f.Healthy() {
+		return call(f.DynamoDB)
+	}
+	out, err := call(f.DAX)
+	if daxFailure(ctx, err) {
+		f.fail(ctx, err)
+	}
+	return out, err
+}
+
+// Query implements API as a read.
+func (f *Failover) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return read(ctx, f, func(api API) (*dynamodb.QueryOutput, error) { return api.Query(ctx, params, optFns...) })
+}
+
+// GetItem implements API as a read.
+func (f *Failover) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return read(ctx, f, func(api API) (*dynamodb.GetItemOutput, error) { return api.GetItem(ctx, params, optFns...) })
+}
+
+// Scan implements API as a read.
+func (f *Failover) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return read(ctx, f, func(api API) (*dynamodb.ScanOutput, error) { return api.Scan(ctx, params, optFns...) })
+}
+
+// BatchGetItem implements API as a read.
+func (f *Failover) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	return read(ctx, f, func(api API) (*dynamodb.BatchGetItemOutput, error) { return api.BatchGetItem(ctx, params, optFns...) })
+}
+
+// PutItem implements API as a write.
+func (f *Failover) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return write(ctx, f, func(api API) (*dynamodb.PutItemOutput, error) { return api.PutItem(ctx, params, optFns...) })
+}
+
+// UpdateItem implements API as a write.
+func (f *Failover) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	return write(ctx, f, func(api API) (*dynamodb.UpdateItemOutput, error) { return api.UpdateItem(ctx, params, optFns...) })
+}
+
+// DeleteItem implements API as a write.
+func (f *Failover) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	return write(ctx, f, func(api API) (*dynamodb.DeleteItemOutput, error) { return api.DeleteItem(ctx, params, optFns...) })
+}
+
+// TransactWriteItems implements API as a write.
+func (f *Failover) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	return write(ctx, f, func(api API) (*dynamodb.TransactWriteItemsOutput, error) {
+		return api.TransactWriteItems(ctx, params, optFns...)
+	})
+}
+
+// BatchWriteItem implements API as a write.
+func (f *Failover) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return write(ctx, f, func(api API) (*dynamodb.BatchWriteItemOutput, error) {
+		return api.BatchWriteItem(ctx, params, optFns...)
+	})
+}
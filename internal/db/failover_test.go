@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+)
+
+// fakeAPI answers GetItem and PutItem with err and counts the calls. The
+// embedded API is nil: other methods are not used.
+type fakeAPI struct {
+	API
+	err   error
+	calls int
+}
+
+func (f *fakeAPI) GetItem(context.Context, *dynamodb.GetItemInput, ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.calls++
+	return &dynamodb.GetItemOutput{}, f.err
+}
+
+func (f *fakeAPI) PutItem(context.Context, *dynamodb.PutItemInput, ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.calls++
+	return &dynamodb.PutItemOutput{}, f.err
+}
+
+var (
+	errUnreachable = errors.New("dial tcp: connection refused")
+	errCondition   = &smithy.GenericAPIError{Code: "ConditionalCheckFailedException"}
+)
+
+// testFailover returns a Failover whose clock only moves when the test
+// advances it.
+func testFailover(dax, dynamo API) (*Failover, func(time.Duration)) {
+	f := NewFailover(dax, dynamo)
+	now := time.Unix(1700000000, 0)
+	f.now = func() time.Time { return now }
+	return f, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestFailoverRead(t *testing.T) {
+	tests := []struct {
+		name        string
+		daxErr      error
+		wantErr     error
+		wantDynamo  int
+		wantHealthy bool
+	}{
+		{name: "served by DAX", wantHealthy: true},
+		{name: "DAX unreachable", daxErr: errUnreachable, wantDynamo: 1},
+		{name: "DynamoDB error passed through", daxErr: errCondition, wantErr: errCondition, wantHealthy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dax, dynamo := &fakeAPI{err: tt.daxErr}, &fakeAPI{}
+			f, _ := testFailover(dax, dynamo)
+
+			_, err := f.GetItem(context.Background(), &dynamodb.GetItemInput{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if dax.calls != 1 || dynamo.calls != tt.wantDynamo {
+				t.Errorf("calls: DAX %d, DynamoDB %d; want 1, %d", dax.calls, dynamo.calls, tt.wantDynamo)
+			}
+			if f.Healthy() != tt.wantHealthy {
+				t.Errorf("Healthy = %v, want %v", f.Healthy(), tt.wantHealthy)
+			}
+		})
+	}
+}
+
+func TestFailoverWriteNotRetried(t *testing.T) {
+	dax, dynamo := &fakeAPI{err: errUnreachable}, &fakeAPI{}
+	f, _ := testFailover(dax, dynamo)
+
+	if _, err := f.PutItem(context.Background(), &dynamodb.PutItemInput{}); !errors.Is(err, errUnreachable) {
+		t.Fatalf("err = %v, want %v", err, errUnreachable)
+	}
+	if dynamo.calls != 0 {
+		t.Fatal("failed write was replayed on DynamoDB")
+	}
+
+	// The retry goes to DynamoDB
+	if _, err := f.PutItem(context.Background(), &dynamodb.PutItemInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if dax.calls != 1 || dynamo.calls != 1 {
+		t.Errorf("calls: DAX %d, DynamoDB %d; want 1, 1", dax.calls, dynamo.calls)
+	}
+}
+
+func TestFailoverRecovers(t *testing.T) {
+	ctx := context.Background()
+	dax, dynamo := &fakeAPI{err: errUnreachable}, &fakeAPI{}
+	f, advance := testFailover(dax, dynamo)
+
+	if err := f.Check(ctx, "users"); err == nil {
+		t.Fatal("Check passed against an unreachable cluster")
+	}
+	f.GetItem(ctx, &dynamodb.GetItemInput{})
+	if dax.calls != 1 {
+		t.Fatalf("DAX called %d times during the cooldown", dax.calls)
+	}
+
+	dax.err = nil
+	advance(daxCooldown)
+	f.GetItem(ctx, &dynamodb.GetItemInput{})
+	if dax.calls != 2 || !f.Healthy() {
+		t.Errorf("DAX not used again after the cooldown (calls %d, healthy %v)", dax.calls, f.Healthy())
+	}
+}
+
+func TestFailoverCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dax, dynamo := &fakeAPI{err: context.Canceled}, &fakeAPI{}
+	f, _ := testFailover(dax, dynamo)
+
+	f.GetItem(ctx, &dynamodb.GetItemInput{})
+	if dynamo.calls != 0 || !f.Healthy() {
+		t.Error("a canceled call counted against DAX")
+	}
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// Decode unmarshals item into a model of type T. Models are structs with
+// dynamodbav tags, one per kind of item; repositories read and write items
+// through them rather than through maps of attribute values built by hand.
+func Decode[T any](item Item) (T, error) {
+	var v T
+	if err := attributevalue.UnmarshalMap(item, &v); err != nil {
+		return v, fmt.Errorf("decoding %T: %w", v, err)
+	}
+	return v, nil
+}
+
+// Encode marshals v, a model, into an item.
+func Encode(v any) (Item, error) {
+	item, err := attributevalue.MarshalMap(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %T: %w", v, err)
+	}
+	return item, nil
+}
+
+// MustEncode marshals v, a model of plain attributes such as a primary key,
+// whose encoding cannot fail. It panics if it does.
+func MustEncode(v any) Item {
+	item, err := Encode(v)
+	if err != nil {
+		panic(err)
+	}
+	return item
+}
+
+// Projection builds the expression reading only attributes, at least one.
+// The builder names every attribute with a placeholder, so attributes
+// colliding with DynamoDB reserved words still work.
+func Projection(attributes ...string) (expression.Expression, error) {
+	names := make([]expression.NameBuilder, len(attributes))
+	for i, a := range attributes {
+		names[i] = expression.Name(a)
+	}
+	expr, err := expression.NewBuilder().
+		WithProjection(expression.NamesList(names[0], names[1:]...)).
+		Build()
+	if err != nil {
+		return expr, fmt.Errorf("building projection: %w", err)
+	}
+	return expr, nil
+}
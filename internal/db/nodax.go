@@ -0,0 +1,16 @@
+//go:build !dax
+
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// newDAX fails: DAX support is only compiled in with the dax build tag,
+// which keeps the DAX SDK out of binaries that do not use it.
+func newDAX(ctx context.Context, awsCfg aws.Config, endpoint string) (API, error) {
+	return nil, errors.New("built without DAX support; rebuild with -tags dax")
+}
@@ -0,0 +1,325 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/metrics"
+)
+
+const (
+	// throttleAttempts bounds the tries of a call DynamoDB keeps throttling.
+	throttleAttempts = 4
+
+	// throttleBackoff is the cap of the wait before the first retry of a
+	// throttled call; it doubles with every retry, up to throttleMaxBackoff,
+	// and the actual wait is drawn at random below it.
+	throttleBackoff    = 25 * time.Millisecond
+	throttleMaxBackoff = 500 * time.Millisecond
+
+	// breakerWindow is the span over which the breaker counts outcomes.
+	breakerWindow = 10 * time.Second
+
+	// breakerMinCalls is the fewest calls of a window that can open the
+	// breaker, so a couple of failures of an idle container do not.
+	breakerMinCalls = 20
+
+	// breakerFailureRatio is the share of failed calls of a window that
+	// opens the breaker.
+	breakerFailureRatio = 0.5
+
+	// breakerCooldown is how long an open breaker sheds calls before it
+	// lets one through to probe DynamoDB.
+	breakerCooldown = 5 * time.Second
+)
+
+// ErrCircuitOpen is returned without calling DynamoDB while the breaker is
+// open. It is a 503 telling clients when to retry.
+var ErrCircuitOpen = apperr.Unavailable("DATABASE_OVERLOADED", "The service is overloaded; retry shortly", breakerCooldown)
+
+// Resilient guards calls to a DynamoDB API. Throttled calls are retried
+// with jittered exponential backoff; retrying is safe even for writes, as
+// DynamoDB applies nothing it throttles. Calls that still fail, throttled
+// or otherwise failing on DynamoDB's side, count against a Breaker shared
+// by the container's calls, which sheds calls with ErrCircuitOpen while
+// most of them fail, giving DynamoDB room to recover.
+//
+// Each call, retries included, is bounded by its operation's timeout. One
+// that runs out of time, or out of the caller's deadline, fails with a
+// KindTimeout error, a 504, after counting how long it waited; the
+// timeouts firing count against the breaker like any other failure.
+//
+// Errors a caller causes (a false condition, validation) and calls the
+// caller gave up on are neither retried nor counted.
+type Resilient struct {
+	API      API
+	Breaker  *Breaker
+	Timeouts Timeouts
+
+	sleep func(context.Context, time.Duration) error
+}
+
+// Timeouts bounds how long DynamoDB calls may take.
+type Timeouts struct {
+	Default     time.Duration            // zero leaves only the caller's deadline
+	ByOperation map[string]time.Duration // overrides by operation name, e.g. "Scan"
+}
+
+// of returns the timeout of operation op.
+func (t Timeouts) of(op string) time.Duration {
+	if d, ok := t.ByOperation[op]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// NewResilient returns a Resilient guarding api with a new Breaker and
+// timeouts.
+func NewResilient(api API, timeouts Timeouts) *Resilient {
+	return &Resilient{API: api, Breaker: NewBreaker(), Timeouts: timeouts, sleep: sleep}
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns the wait before retry attempt, counted from 1.
+func backoff(attempt int) time.Duration {
+	limit := throttleBackoff << (attempt - 1)
+	if limit > throttleMaxBackoff {
+		limit = throttleMaxBackoff
+	}
+	return rand.N(limit) + 1
+}
+
+// guard runs call, the operation op, through the breaker within its
+// timeout, retrying it while it is throttled.
+func guard[T any](ctx context.Context, r *Resilient, op string, call func(context.Context, API) (T, error)) (T, error) {
+	ok, probe := r.Breaker.Allow()
+	if !ok {
+		metrics.Count(ctx, metrics.DynamoShed)
+		var zero T
+		return zero, ErrCircuitOpen
+	}
+	callCtx := ctx
+	if d := r.Timeouts.of(op); d > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	start := time.Now()
+	var (
+		out T
+		err error
+	)
+	for attempt := 1; ; attempt++ {
+		out, err = call(callCtx, r.API)
+		if !Throttled(err) {
+			break
+		}
+		metrics.Count(ctx, metrics.DynamoThrottled)
+		if attempt == throttleAttempts || r.sleep(callCtx, backoff(attempt)) != nil {
+			break
+		}
+	}
+	if err != nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		// DynamoDB did not answer in time: whatever the call returned
+		// was caused by the deadline
+		elapsed := time.Since(start)
+		metrics.Count(ctx, metrics.DynamoTimeout)
+		metrics.Since(ctx, metrics.DynamoTimeoutLatency, start)
+		slog.WarnContext(ctx, "DynamoDB call timed out", "operation", op, "elapsed", elapsed.String())
+		err = apperr.Timeout(fmt.Errorf("DynamoDB %s after %s: %w", op, elapsed.Round(time.Millisecond), err))
+	}
+
+	// Only our own timeout firing is DynamoDB's fault; the caller's
+	// deadline or cancellation is not
+	failed := failure(err) || (callCtx.Err() != nil && ctx.Err() == nil)
+	switch {
+	case probe:
+		// A probe the caller gave up on proves nothing: keep shedding
+		r.Breaker.Record(true, failed || ctx.Err() != nil)
+	case ctx.Err() == nil:
+		if r.Breaker.Record(false, failed) {
+			slog.WarnContext(ctx, "DynamoDB failing, shedding calls", "cooldown", breakerCooldown.String())
+			metrics.Count(ctx, metrics.CircuitOpened)
+		}
+	}
+	return out, err
+}
+
+// failure reports whether err means DynamoDB could not serve the call: it
+// was throttled, failed on the server's side or never got an answer.
+func failure(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case Throttled(err):
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorFault() == smithy.FaultServer
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// Query implements API.
+func (r *Resilient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	return guard(ctx, r, "Query", func(ctx context.Context, api API) (*dynamodb.QueryOutput, error) {
+		return api.Query(ctx, params, optFns...)
+	})
+}
+
+// GetItem implements API.
+func (r *Resilient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return guard(ctx, r, "GetItem", func(ctx context.Context, api API) (*dynamodb.GetItemOutput, error) {
+		return api.GetItem(ctx, params, optFns...)
+	})
+}
+
+// Scan implements API.
+func (r *Resilient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return guard(ctx, r, "Scan", func(ctx context.Context, api API) (*dynamodb.ScanOutput, error) {
+		return api.Scan(ctx, params, optFns...)
+	})
+}
+
+// BatchGetItem implements API.
+func (r *Resilient) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	return guard(ctx, r, "BatchGetItem", func(ctx context.Context, api API) (*dynamodb.BatchGetItemOutput, error) {
+		return api.BatchGetItem(ctx, params, optFns...)
+	})
+}
+
+// PutItem implements API.
+func (r *Resilient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return guard(ctx, r, "PutItem", func(ctx context.Context, api API) (*dynamodb.PutItemOutput, error) {
+		return api.PutItem(ctx, params, optFns...)
+	})
+}
+
+// UpdateItem implements API.
+func (r *Resilient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	return guard(ctx, r, "UpdateItem", func(ctx context.Context, api API) (*dynamodb.UpdateItemOutput, error) {
+		return api.UpdateItem(ctx, params, optFns...)
+	})
+}
+
+// DeleteItem implements API.
+func (r *Resilient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	return guard(ctx, r, "DeleteItem", func(ctx context.Context, api API) (*dynamodb.DeleteItemOutput, error) {
+		return api.DeleteItem(ctx, params, optFns...)
+	})
+}
+
+// TransactWriteItems implements API.
+func (r *Resilient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	return guard(ctx, r, "TransactWriteItems", func(ctx context.Context, api API) (*dynamodb.TransactWriteItemsOutput, error) {
+		return api.TransactWriteItems(ctx, params, optFns...)
+	})
+}
+
+// BatchWriteItem implements API.
+func (r *Resilient) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	return guard(ctx, r, "BatchWriteItem", func(ctx context.Context, api API) (*dynamodb.BatchWriteItemOutput, error) {
+		return api.BatchWriteItem(ctx, params, optFns...)
+	})
+}
+
+// Breaker is a circuit breaker over the outcomes of calls.
+//
+// It starts closed, letting every call through. Once at least
+// breakerMinCalls calls of a breakerWindow were recorded and
+// breakerFailureRatio of them failed, it opens and rejects calls for
+// breakerCooldown. It then lets a single call through: if that succeeds it
+// closes again, otherwise it stays open for another cooldown.
+type Breaker struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	calls       int
+	failures    int
+	openUntil   time.Time // zero while closed
+	probing     bool      // the call after a cooldown is in flight
+	now         func() time.Time
+}
+
+// NewBreaker returns a closed Breaker.
+func NewBreaker() *Breaker {
+	return &Breaker{now: time.Now}
+}
+
+// Allow reports whether a call may go through and whether it is the probe
+// of an open breaker. The outcome of a call allowed must be recorded.
+func (b *Breaker) Allow() (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true, false
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// Open reports whether the breaker is rejecting calls.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openUntil.IsZero()
+}
+
+// Record records the outcome of an allowed call, reporting whether it
+// opened the breaker. The outcomes of calls that were in flight when it
+// opened are ignored: only the probe decides when it closes.
+func (b *Breaker) Record(probe, failed bool) (opened bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if probe {
+		b.probing = false
+		if failed {
+			b.openUntil = now.Add(breakerCooldown)
+		} else {
+			b.openUntil = time.Time{}
+			b.windowStart, b.calls, b.failures = now, 0, 0
+		}
+		return false
+	}
+	if !b.openUntil.IsZero() {
+		return false
+	}
+
+	if now.Sub(b.windowStart) >= breakerWindow {
+		b.windowStart, b.calls, b.failures = now, 0, 0
+	}
+	b.calls++
+	if failed {
+		b.failures++
+	}
+	if b.calls >= breakerMinCalls && float64(b.failures) >= breakerFailureRatio*float64(b.calls) {
+		b.openUntil = now.Add(breakerCooldown)
+		return true
+	}
+	return false
+}
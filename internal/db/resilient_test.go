@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+
+	"troggle-backend/internal/apperr"
+)
+
+var errThrottled = &smithy.GenericAPIError{Code: "ProvisionedThroughputExceededException"}
+
+// testResilient returns a Resilient around api that does not sleep and
+// whose breaker's clock only moves when the test advances it.
+func testResilient(api API) (*Resilient, func(time.Duration)) {
+	r := NewResilient(api, Timeouts{})
+	r.sleep = func(context.Context, time.Duration) error { return nil }
+	now := time.Unix(1700000000, 0)
+	r.Breaker.now = func() time.Time { return now }
+	return r, func(d time.Duration) { now = now.Add(d) }
+}
+
+// seqAPI answers GetItem with errs in turn, then with success.
+type seqAPI struct {
+	API
+	errs  []error
+	calls int
+}
+
+func (s *seqAPI) GetItem(context.Context, *dynamodb.GetItemInput, ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	s.calls++
+	if len(s.errs) == 0 {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return nil, err
+}
+
+func TestResilientRetriesThrottling(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "succeeds", wantCalls: 1},
+		{name: "throttled twice", errs: []error{errThrottled, errThrottled}, wantCalls: 3},
+		{name: "throttled throughout", errs: []error{errThrottled, errThrottled, errThrottled, errThrottled, errThrottled}, wantErr: errThrottled, wantCalls: throttleAttempts},
+		{name: "other errors not retried", errs: []error{errCondition}, wantErr: errCondition, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &seqAPI{errs: tt.errs}
+			r, _ := testResilient(api)
+
+			_, err := r.GetItem(context.Background(), &dynamodb.GetItemInput{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if api.calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", api.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResilientSheds(t *testing.T) {
+	api := &fakeAPI{err: errUnreachable}
+	r, advance := testResilient(api)
+	ctx := context.Background()
+
+	for range breakerMinCalls {
+		if _, err := r.GetItem(ctx, &dynamodb.GetItemInput{}); !errors.Is(err, errUnreachable) {
+			t.Fatalf("err = %v, want %v", err, errUnreachable)
+		}
+	}
+	if !r.Breaker.Open() {
+		t.Fatal("breaker closed after every call failed")
+	}
+	_, err := r.GetItem(ctx, &dynamodb.GetItemInput{})
+	if apperr.KindOf(err) != apperr.KindUnavailable || api.calls != breakerMinCalls {
+		t.Fatalf("open breaker: err = %v after %d calls, want a shed call", err, api.calls)
+	}
+
+	// A failed probe keeps it open for another cooldown
+	advance(breakerCooldown)
+	if _, err := r.GetItem(ctx, &dynamodb.GetItemInput{}); !errors.Is(err, errUnreachable) {
+		t.Fatalf("probe err = %v", err)
+	}
+	if _, err := r.GetItem(ctx, &dynamodb.GetItemInput{}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after failed probe: err = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	advance(breakerCooldown)
+	api.err = nil
+	if _, err := r.GetItem(ctx, &dynamodb.GetItemInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if r.Breaker.Open() {
+		t.Error("breaker still open after a successful probe")
+	}
+}
+
+func TestBreakerIgnoresCallerErrors(t *testing.T) {
+	api := &fakeAPI{err: errCondition}
+	r, _ := testResilient(api)
+	for range 2 * breakerMinCalls {
+		_, _ = r.GetItem(context.Background(), &dynamodb.GetItemInput{})
+	}
+	if r.Breaker.Open() {
+		t.Error("false conditions opened the breaker")
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	b := NewBreaker()
+	now := time.Unix(1700000000, 0)
+	b.now = func() time.Time { return now }
+
+	// Failures spread over windows never reach the minimum in one
+	for range 3 * breakerMinCalls {
+		if b.Record(false, true) {
+			t.Fatal("opened")
+		}
+		if b.calls == breakerMinCalls-1 {
+			now = now.Add(breakerWindow)
+		}
+	}
+	if b.Open() {
+		t.Error("open")
+	}
+}
+
+// slowAPI answers GetItem when ctx is done.
+type slowAPI struct{ API }
+
+func (slowAPI) GetItem(ctx context.Context, _ *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestResilientTimeouts(t *testing.T) {
+	r, _ := testResilient(slowAPI{})
+	r.Timeouts = Timeouts{Default: time.Hour, ByOperation: map[string]time.Duration{"GetItem": time.Millisecond}}
+
+	_, err := r.GetItem(context.Background(), &dynamodb.GetItemInput{})
+	if apperr.KindOf(err) != apperr.KindTimeout || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if r.Breaker.calls != 1 || r.Breaker.failures != 1 {
+		t.Errorf("breaker recorded %d calls, %d failures; want the timeout", r.Breaker.calls, r.Breaker.failures)
+	}
+
+	// The caller's own deadline is not DynamoDB's fault
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	r.Timeouts = Timeouts{}
+	if _, err := r.GetItem(ctx, &dynamodb.GetItemInput{}); apperr.Status(err) != 504 {
+		t.Errorf("caller deadline: status %d, want 504", apperr.Status(err))
+	}
+	if r.Breaker.calls != 1 {
+		t.Errorf("breaker recorded the caller's deadline")
+	}
+}
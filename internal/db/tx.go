@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+)
+
+// MaxTransactWrites is the most writes DynamoDB accepts in one transaction.
+const MaxTransactWrites = 100
+
+// ErrTransactionConflict is returned by Transact when another request wrote
+// one of the items while the transaction was in flight. Retrying the
+// request re-reads the new state.
+var ErrTransactionConflict = &apperr.Error{
+	Kind:    apperr.KindConflict,
+	Code:    "TRANSACTION_CONFLICT",
+	Message: "The resource was modified by another request; retry",
+}
+
+// Write is one write of a transaction, with the error its condition failing
+// means to the caller, e.g. users.ErrEmailTaken for the put of an email
+// reservation.
+type Write struct {
+	Item     types.TransactWriteItem
+	Conflict error // returned when the condition fails; nil leaves the cancellation as it is
+}
+
+// Put returns the conditional put of item into table.
+func Put(table string, item Item, condition string, conflict error) Write {
+	p := &types.Put{TableName: aws.String(table), Item: item}
+	if condition != "" {
+		p.ConditionExpression = aws.String(condition)
+	}
+	return Write{Item: types.TransactWriteItem{Put: p}, Conflict: conflict}
+}
+
+// Delete returns the unconditional delete of the item of table with key.
+func Delete(table string, key Item) Write {
+	return Write{Item: types.TransactWriteItem{Delete: &types.Delete{TableName: aws.String(table), Key: key}}}
+}
+
+// Transact applies writes atomically, like TransactWriteItems, and
+// translates why DynamoDB cancelled the transaction: the Conflict of the
+// first write whose condition failed, carrying the item it failed on for
+// CurrentItem when the write asked for it, or ErrTransactionConflict when a
+// concurrent transaction got in the way. Other failures keep their chain, so
+// ConditionFailed still works for writes without a Conflict.
+func (c *Client) Transact(ctx context.Context, writes ...Write) error {
+	if len(writes) > MaxTransactWrites {
+		return fmt.Errorf("transaction of %d writes exceeds the limit of %d", len(writes), MaxTransactWrites)
+	}
+	items := make([]types.TransactWriteItem, len(writes))
+	for i, w := range writes {
+		items[i] = w.Item
+	}
+	err := c.TransactWriteItems(ctx, items)
+
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return err
+	}
+	conflicted := false
+	for i, reason := range canceled.CancellationReasons {
+		switch aws.ToString(reason.Code) {
+		case "ConditionalCheckFailed":
+			if i < len(writes) && writes[i].Conflict != nil {
+				if len(reason.Item) > 0 {
+					return &conflictError{err: writes[i].Conflict, item: reason.Item}
+				}
+				return writes[i].Conflict
+			}
+		case "TransactionConflict":
+			conflicted = true
+		}
+	}
+	if conflicted {
+		return ErrTransactionConflict
+	}
+	return err
+}
+
+// conflictError is the Conflict of a write with the item its condition
+// failed on.
+type conflictError struct {
+	err  error
+	item Item
+}
+
+func (e *conflictError) Error() string { return e.err.Error() }
+func (e *conflictError) Unwrap() error { return e.err }
+
+// CurrentItem returns the item a failed condition was evaluated against,
+// from the error of an UpdateItem, PutItem or DeleteItem call or of
+// Transact, for writes that set ReturnValuesOnConditionCheckFailure to
+// ALL_OLD. It returns nil if there is none, e.g. because the item does not
+// exist.
+func CurrentItem(err error) Item {
+	var c *conflictError
+	if errors.As(err, &c) {
+		return c.item
+	}
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) && len(ccf.Item) > 0 {
+		return ccf.Item
+	}
+	return nil
+}
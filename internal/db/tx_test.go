@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// txAPI cancels every transaction with reasons, one code per write. The
+// reasons of failed conditions carry item, if set.
+type txAPI struct {
+	API
+	reasons []string
+	item    Item
+}
+
+func (a *txAPI) TransactWriteItems(_ context.Context, in *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if a.reasons == nil {
+		return &dynamodb.TransactWriteItemsOutput{}, nil
+	}
+	canceled := &types.TransactionCanceledException{Message: aws.String("canceled")}
+	for _, code := range a.reasons {
+		reason := types.CancellationReason{Code: aws.String(code)}
+		if code == "ConditionalCheckFailed" {
+			reason.Item = a.item
+		}
+		canceled.CancellationReasons = append(canceled.CancellationReasons, reason)
+	}
+	return nil, canceled
+}
+
+func TestTransact(t *testing.T) {
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+	writes := []Write{
+		Put("t", Item{}, "attribute_not_exists(pk)", errFirst),
+		Put("t", Item{}, "attribute_not_exists(pk)", errSecond),
+		Delete("t", Item{}),
+	}
+	tests := []struct {
+		name    string
+		reasons []string
+		want    error // nil, unless wantOK, means the raw cancellation
+		wantOK  bool
+	}{
+		{name: "committed", wantOK: true},
+		{name: "second condition", reasons: []string{"None", "ConditionalCheckFailed", "None"}, want: errSecond},
+		{name: "both conditions", reasons: []string{"ConditionalCheckFailed", "ConditionalCheckFailed", "None"}, want: errFirst},
+		{name: "concurrent transaction", reasons: []string{"None", "TransactionConflict", "None"}, want: ErrTransactionConflict},
+		{name: "condition without a conflict", reasons: []string{"None", "None", "ConditionalCheckFailed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{DynamoDB: &txAPI{reasons: tt.reasons}}
+			err := c.Transact(context.Background(), writes...)
+			switch {
+			case tt.wantOK:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case tt.want != nil:
+				if !errors.Is(err, tt.want) {
+					t.Errorf("err = %v, want %v", err, tt.want)
+				}
+			default:
+				if !ConditionFailed(err, 2) {
+					t.Errorf("err = %v, want the cancellation", err)
+				}
+			}
+		})
+	}
+
+	if err := (&Client{}).Transact(context.Background(), make([]Write, MaxTransactWrites+1)...); err == nil {
+		t.Error("oversized transaction accepted")
+	}
+}
+
+func TestCurrentItem(t *testing.T) {
+	errStale := errors.New("stale")
+	item := Item{"pk": &types.AttributeValueMemberS{Value: "u1"}}
+	c := &Client{DynamoDB: &txAPI{reasons: []string{"ConditionalCheckFailed"}, item: item}}
+
+	err := c.Transact(context.Background(), Put("t", Item{}, "#version = :v", errStale))
+	if !errors.Is(err, errStale) {
+		t.Fatalf("err = %v, want %v", err, errStale)
+	}
+	if got := CurrentItem(err); got["pk"] != item["pk"] {
+		t.Errorf("CurrentItem = %v, want %v", got, item)
+	}
+
+	ccf := &types.ConditionalCheckFailedException{Item: item}
+	if got := CurrentItem(ccf); got["pk"] != item["pk"] {
+		t.Errorf("CurrentItem(ConditionalCheckFailedException) = %v", got)
+	}
+	if got := CurrentItem(&types.ConditionalCheckFailedException{}); got != nil {
+		t.Errorf("CurrentItem without an item = %v, want nil", got)
+	}
+}
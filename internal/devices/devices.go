@@ -0,0 +1,296 @@
+// Package devices keeps the push notification tokens of each user's devices
+// in the device table, keyed by user_id and token, with a GSI on token.
+//
+// A token identifies one app install, so it belongs to at most one user:
+// registering it again under another account (someone signing out and a
+// different person signing in on the same phone) removes it from the
+// previous user. Apps re-register on every launch, which pushes expires_at,
+// the table's TTL attribute, forward; tokens of uninstalled apps stop being
+// refreshed and expire. Senders use ForUser to find where to push and
+// Unregister for tokens the push service reports as no longer valid.
+package devices
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/validation"
+)
+
+// Push platforms.
+const (
+	PlatformAPNs = "apns" // Apple Push Notification service
+	PlatformFCM  = "fcm"  // Firebase Cloud Messaging
+)
+
+const (
+	// APNs tokens are hex; Apple documents them as variable length, in
+	// practice 32 bytes.
+	minAPNsTokenLength = 64
+	maxAPNsTokenLength = 200
+
+	// maxTokenLength keeps tokens within DynamoDB's 1024-byte sort key limit.
+	maxTokenLength = 1024
+)
+
+// Device is a registered push token of a user.
+type Device struct {
+	UserID       string    `json:"user_id"`
+	Token        string    `json:"token"`
+	Platform     string    `json:"platform"`
+	AppVersion   string    `json:"app_version,omitempty"`
+	Model        string    `json:"model,omitempty"`   // e.g. "iPhone15,2"
+	Sandbox      bool      `json:"sandbox,omitempty"` // APNs development environment
+	RegisteredAt time.Time `json:"registered_at"`     // first registration
+	LastSeenAt   time.Time `json:"last_seen_at"`      // latest registration
+	ExpiresAt    time.Time `json:"expires_at"`        // when the token expires unless refreshed
+	EndpointARN  string    `json:"-"`                 // SNS platform endpoint of the token, once created
+}
+
+// NormalizeToken validates platform and token and returns them in their
+// stored form: both lower-cased for APNs, whose tokens are hex.
+func NormalizeToken(platform, token string) (string, string, error) {
+	platform = strings.ToLower(strings.TrimSpace(platform))
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", "", apperr.Invalid(validation.CodeDeviceTokenRequired, "token", "token is required")
+	}
+
+	invalid := apperr.Invalid(validation.CodeDeviceTokenInvalid, "token", "token is not a valid "+platform+" token")
+	switch platform {
+	case PlatformAPNs:
+		token = strings.ToLower(token)
+		if len(token) < minAPNsTokenLength || len(token) > maxAPNsTokenLength || len(token)%2 != 0 ||
+			strings.IndexFunc(token, notHex) >= 0 {
+			return "", "", invalid
+		}
+	case PlatformFCM:
+		if len(token) > maxTokenLength || strings.IndexFunc(token, notFCMRune) >= 0 {
+			return "", "", invalid
+		}
+	default:
+		return "", "", apperr.Invalid(validation.CodePlatformInvalid, "platform", `platform must be "apns" or "fcm"`)
+	}
+	return platform, token, nil
+}
+
+func notHex(r rune) bool {
+	return !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f')
+}
+
+func notFCMRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == ':')
+}
+
+// record is an item of the device table.
+type record struct {
+	UserID       string `dynamodbav:"user_id"`
+	Token        string `dynamodbav:"token"`
+	Platform     string `dynamodbav:"platform"`
+	AppVersion   string `dynamodbav:"app_version"`
+	Model        string `dynamodbav:"model"`
+	Sandbox      bool   `dynamodbav:"sandbox"`
+	RegisteredAt string `dynamodbav:"registered_at"` // RFC 3339
+	LastSeenAt   string `dynamodbav:"last_seen_at"`  // RFC 3339
+	ExpiresAt    int64  `dynamodbav:"expires_at"`    // Unix seconds, the TTL attribute
+	EndpointARN  string `dynamodbav:"endpoint_arn,omitempty"`
+	DeletedAt    string `dynamodbav:"deleted_at,omitempty"` // RFC 3339; set while the user is soft deleted
+}
+
+func (r *record) device() Device {
+	d := Device{
+		UserID:      r.UserID,
+		Token:       r.Token,
+		Platform:    r.Platform,
+		AppVersion:  r.AppVersion,
+		Model:       r.Model,
+		Sandbox:     r.Sandbox,
+		ExpiresAt:   time.Unix(r.ExpiresAt, 0).UTC(),
+		EndpointARN: r.EndpointARN,
+	}
+	d.RegisteredAt, _ = time.Parse(time.RFC3339, r.RegisteredAt)
+	d.LastSeenAt, _ = time.Parse(time.RFC3339, r.LastSeenAt)
+	return d
+}
+
+// Store reads and writes device tokens.
+type Store struct {
+	DB         *db.Client
+	Table      string
+	TokenIndex string        // GSI keyed by token
+	TTL        time.Duration // how long a registration lasts
+}
+
+// NewStore returns a store over the device table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.DeviceTableName, TokenIndex: cfg.DeviceTokenIndexName, TTL: cfg.DeviceTTL}
+}
+
+// Register records d.Token for d.UserID, or refreshes its metadata and
+// expiry if it is already registered, in which case created is false. The
+// token is then removed from any other user it was registered to. Callers
+// must have normalized the token with NormalizeToken.
+func (s *Store) Register(ctx context.Context, d Device) (stored Device, created bool, err error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	d.RegisteredAt, d.LastSeenAt, d.ExpiresAt = now, now, now.Add(s.TTL)
+	stamp := now.Format(time.RFC3339)
+
+	start := time.Now()
+	out, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.Table),
+		Key:       key(d.UserID, d.Token),
+		UpdateExpression: aws.String("SET platform = :platform, app_version = :app_version, model = :model, " +
+			"sandbox = :sandbox, last_seen_at = :now, expires_at = :expires_at, " +
+			"registered_at = if_not_exists(registered_at, :now)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":platform":    &types.AttributeValueMemberS{Value: d.Platform},
+			":app_version": &types.AttributeValueMemberS{Value: d.AppVersion},
+			":model":       &types.AttributeValueMemberS{Value: d.Model},
+			":sandbox":     &types.AttributeValueMemberBOOL{Value: d.Sandbox},
+			":now":         &types.AttributeValueMemberS{Value: stamp},
+			":expires_at":  &types.AttributeValueMemberN{Value: fmt.Sprint(d.ExpiresAt.Unix())},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return Device{}, false, db.Wrap(err, "registering device")
+	}
+
+	created = len(out.Attributes) == 0
+	if !created {
+		var old record
+		if err := attributevalue.UnmarshalMap(out.Attributes, &old); err == nil {
+			d.RegisteredAt = old.device().RegisteredAt
+		}
+	}
+
+	// The registration stands even if this fails; the client retries and
+	// the retry finishes the cleanup
+	if err := s.removeElsewhere(ctx, d.Token, d.UserID); err != nil {
+		return Device{}, false, err
+	}
+	return d, created, nil
+}
+
+// removeElsewhere deletes token from every user but keep.
+func (s *Store) removeElsewhere(ctx context.Context, token, keep string) error {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.TokenIndex),
+		KeyConditionExpression: aws.String("#token = :token"),
+		ExpressionAttributeNames: map[string]string{
+			"#token": "token", // reserved word
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":token": &types.AttributeValueMemberS{Value: token},
+		},
+	}
+
+	var owners []string
+	err := s.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		for _, item := range items {
+			if v, ok := item["user_id"].(*types.AttributeValueMemberS); ok && v.Value != keep {
+				owners = append(owners, v.Value)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, userID := range owners {
+		if err := s.Unregister(ctx, userID, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unregister removes token from userID. Removing a token that is not
+// registered is not an error, so clients can retry and senders can drop
+// tokens the push service rejected without checking first.
+func (s *Store) Unregister(ctx context.Context, userID, token string) error {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key:       key(userID, token),
+	})
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, "unregistering device")
+}
+
+// SetEndpoint remembers the SNS platform endpoint created for the token, so
+// later pushes reuse it. A device unregistered in the meantime is left
+// alone.
+func (s *Store) SetEndpoint(ctx context.Context, userID, token, arn string) error {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 key(userID, token),
+		UpdateExpression:    aws.String("SET endpoint_arn = :arn"),
+		ConditionExpression: aws.String("attribute_exists(user_id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":arn": &types.AttributeValueMemberS{Value: arn},
+		},
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return nil
+	}
+	return db.Wrap(err, "saving device endpoint")
+}
+
+// ForUser returns the unexpired devices of userID, the targets of a push to
+// that user. DynamoDB deletes expired items lazily, so they are filtered here,
+// along with those of a soft-deleted user.
+func (s *Store) ForUser(ctx context.Context, userID string) ([]Device, error) {
+	now := time.Now()
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+
+	devices := []Device{}
+	var decodeErr error
+	err := s.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		var recs []record
+		if decodeErr = attributevalue.UnmarshalListOfMaps(items, &recs); decodeErr != nil {
+			return false
+		}
+		for _, rec := range recs {
+			if d := rec.device(); now.Before(d.ExpiresAt) && rec.DeletedAt == "" {
+				devices = append(devices, d)
+			}
+		}
+		return true
+	})
+	if err == nil && decodeErr != nil {
+		err = fmt.Errorf("decoding devices: %w", decodeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// key returns the primary key of a device item.
+func key(userID, token string) db.Item {
+	return db.Item{
+		"user_id": &types.AttributeValueMemberS{Value: userID},
+		"token":   &types.AttributeValueMemberS{Value: token},
+	}
+}
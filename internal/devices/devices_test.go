@@ -0,0 +1,106 @@
+package devices
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/validation"
+)
+
+func TestNormalizeToken(t *testing.T) {
+	apns := strings.Repeat("AB12", 16)
+	tests := []struct {
+		platform, token string
+		wantToken       string
+		wantCode        string // empty means valid
+	}{
+		{platform: "APNs", token: apns, wantToken: strings.ToLower(apns)},
+		{platform: "fcm", token: " dGVzdA:APA91b-x_y ", wantToken: "dGVzdA:APA91b-x_y"},
+		{platform: "apns", token: apns[:62], wantCode: validation.CodeDeviceTokenInvalid},
+		{platform: "apns", token: apns[:63] + "g", wantCode: validation.CodeDeviceTokenInvalid},
+		{platform: "fcm", token: "a/b", wantCode: validation.CodeDeviceTokenInvalid},
+		{platform: "fcm", token: strings.Repeat("a", maxTokenLength+1), wantCode: validation.CodeDeviceTokenInvalid},
+		{platform: "fcm", token: "", wantCode: validation.CodeDeviceTokenRequired},
+		{platform: "wns", token: "abc", wantCode: validation.CodePlatformInvalid},
+	}
+	for _, tt := range tests {
+		_, token, err := NormalizeToken(tt.platform, tt.token)
+		var ae *apperr.Error
+		switch {
+		case tt.wantCode == "" && (err != nil || token != tt.wantToken):
+			t.Errorf("NormalizeToken(%s, %.10s) = %q, %v; want %q", tt.platform, tt.token, token, err, tt.wantToken)
+		case tt.wantCode != "" && (!errors.As(err, &ae) || ae.Code != tt.wantCode):
+			t.Errorf("NormalizeToken(%s, %.10s) error = %v, want %s", tt.platform, tt.token, err, tt.wantCode)
+		}
+	}
+}
+
+func TestRegisterRemovesOtherOwners(t *testing.T) {
+	m := &dbtest.Mock{
+		UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+			return &dynamodb.UpdateItemOutput{Attributes: dbtest.Item("user_id", "u1", "registered_at", "2026-01-01T00:00:00Z")}, nil
+		},
+		QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			return &dynamodb.QueryOutput{Items: []db.Item{
+				dbtest.Item("user_id", "u1", "token", "t1"),
+				dbtest.Item("user_id", "u0", "token", "t1"),
+			}}, nil
+		},
+	}
+	s := &Store{DB: m.Client(), Table: "devices", TokenIndex: "token-index", TTL: time.Hour}
+
+	d, created, err := s.Register(context.Background(), Device{UserID: "u1", Token: "t1", Platform: PlatformFCM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || d.RegisteredAt.Year() != 2026 || d.RegisteredAt.Month() != time.January {
+		t.Errorf("Register = %+v, created %v; want the refreshed registration", d, created)
+	}
+
+	var deleted []string
+	for _, c := range m.Calls {
+		if in, ok := c.Input.(*dynamodb.DeleteItemInput); ok {
+			deleted = append(deleted, in.Key["user_id"].(*types.AttributeValueMemberS).Value)
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != "u0" {
+		t.Errorf("deleted the token of %v, want [u0]", deleted)
+	}
+}
+
+func TestForUserSkipsExpired(t *testing.T) {
+	expires := func(d time.Duration) types.AttributeValue {
+		return &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(d).Unix(), 10)}
+	}
+	live := dbtest.Item("user_id", "u1", "token", "live", "platform", PlatformFCM)
+	live["expires_at"] = expires(time.Hour)
+	stale := dbtest.Item("user_id", "u1", "token", "stale", "platform", PlatformFCM)
+	stale["expires_at"] = expires(-time.Hour)
+
+	m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		if aws.ToString(in.TableName) != "devices" {
+			t.Errorf("queried %s", aws.ToString(in.TableName))
+		}
+		return &dynamodb.QueryOutput{Items: []db.Item{live, stale}}, nil
+	}}
+	s := &Store{DB: m.Client(), Table: "devices"}
+
+	got, err := s.ForUser(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Token != "live" {
+		t.Errorf("ForUser = %+v, want only the live token", got)
+	}
+}
@@ -0,0 +1,167 @@
+// Package dynconfig reads operational settings that change without a
+// deploy, such as rate limits and maintenance mode, from SSM Parameter
+// Store.
+//
+// Every parameter under PARAMETER_PATH, e.g. "/troggle/prod/", is read with
+// one paginated call and cached for PARAMETER_CACHE_TTL, so a change reaches
+// every warm container within that time. Parameters are named by their path
+// below the prefix, e.g. "maintenance". A failed refresh keeps the values
+// read before, and lookups of parameters that were never read return the
+// caller's default, so an outage of Parameter Store leaves functions
+// running on their built-in settings. With PARAMETER_PATH unset, as in
+// local development, every lookup returns its default.
+package dynconfig
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+
+	"troggle-backend/internal/awscfg"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/warmup"
+)
+
+// Maintenance is the parameter putting the API in maintenance mode: "true"
+// answers every request but those of admins with a 503. See package
+// maintenance.
+const Maintenance = "maintenance"
+
+// CORSOrigins is the parameter listing the origins browsers may call the
+// API from, comma separated. See package cors.
+const CORSOrigins = "cors_allowed_origins"
+
+// BlockedSignupDomains is the parameter listing the email domains refused
+// at sign-up, comma separated, in addition to the disposable email
+// providers. See package presignup.
+const BlockedSignupDomains = "blocked_signup_domains"
+
+// RateLimit returns the parameter overriding the limit of one kind ("per_ip"
+// or "per_user") of the named function. See package ratelimit.
+func RateLimit(function, kind string) string {
+	return "rate_limits/" + function + "/" + kind
+}
+
+// API is the subset of the SSM client used to read parameters.
+type API interface {
+	GetParametersByPath(ctx context.Context, params *ssm.GetParametersByPathInput, optFns ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error)
+}
+
+// Store caches the parameters under one path. The nil Store, like one
+// without an API, returns every default.
+type Store struct {
+	API  API
+	Path string        // prefix of the parameters, ending in "/"
+	TTL  time.Duration // how long read parameters are used before being refreshed
+
+	mu      sync.Mutex
+	values  map[string]string
+	fetched time.Time
+}
+
+var (
+	sharedOnce  sync.Once
+	sharedStore *Store
+	sharedErr   error
+)
+
+// Shared returns the container-wide store, creating it on first use. cfg is
+// only consulted by the first call. Warming it reads the parameters.
+func Shared(ctx context.Context, cfg *config.Config) (*Store, error) {
+	sharedOnce.Do(func() {
+		if cfg.ParameterPath == "" {
+			sharedStore = &Store{}
+			return
+		}
+		awsCfg, err := awscfg.Shared(ctx, cfg)
+		if err != nil {
+			sharedErr = err
+			return
+		}
+		sharedStore = NewStore(ssm.NewFromConfig(awsCfg), cfg)
+		warmup.Register("parameters", func(ctx context.Context) error {
+			sharedStore.lookup(ctx, "")
+			return nil
+		})
+	})
+	return sharedStore, sharedErr
+}
+
+// NewStore returns a store reading the parameter path in cfg.
+func NewStore(client API, cfg *config.Config) *Store {
+	return &Store{API: client, Path: cfg.ParameterPath, TTL: cfg.ParameterCacheTTL}
+}
+
+// String returns the value of the named parameter, or def when it is not
+// set.
+func (s *Store) String(ctx context.Context, name, def string) string {
+	if v, ok := s.lookup(ctx, name); ok {
+		return v
+	}
+	return def
+}
+
+// Bool returns the named parameter as a boolean, or def when it is not set
+// or not a boolean.
+func (s *Store) Bool(ctx context.Context, name string, def bool) bool {
+	v, ok := s.lookup(ctx, name)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.WarnContext(ctx, "Ignoring invalid parameter", "parameter", name, "value", v)
+		return def
+	}
+	return b
+}
+
+// lookup returns the value of the named parameter, refreshing the cache
+// when it is stale.
+func (s *Store) lookup(ctx context.Context, name string) (string, bool) {
+	if s == nil || s.API == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.fetched) >= s.TTL {
+		values, err := s.fetch(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to refresh parameters", "path", s.Path, logging.Err(err))
+		} else {
+			s.values = values
+		}
+		// Failures are retried after the TTL too, not on every lookup
+		s.fetched = time.Now()
+	}
+	v, ok := s.values[name]
+	return v, ok
+}
+
+// fetch reads every parameter under the store's path, named relative to it.
+func (s *Store) fetch(ctx context.Context) (map[string]string, error) {
+	values := make(map[string]string)
+	pages := ssm.NewGetParametersByPathPaginator(s.API, &ssm.GetParametersByPathInput{
+		Path:           aws.String(s.Path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Parameters {
+			values[strings.TrimPrefix(aws.ToString(p.Name), s.Path)] = aws.ToString(p.Value)
+		}
+	}
+	return values, nil
+}
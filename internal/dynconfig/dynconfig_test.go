@@ -0,0 +1,107 @@
+package dynconfig
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// fakeSSM answers reads from values, keyed by full name, one parameter per
+// page.
+type fakeSSM struct {
+	values map[string]string
+	err    error
+	reads  int // pages served
+}
+
+func (f *fakeSSM) GetParametersByPath(_ context.Context, in *ssm.GetParametersByPathInput, _ ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.reads++
+	// Serve the parameters in name order, the token being the last name
+	// served
+	next := aws.ToString(in.NextToken)
+	var out ssm.GetParametersByPathOutput
+	for _, name := range slices.Sorted(maps.Keys(f.values)) {
+		if name > next {
+			out.Parameters = []ssmtypes.Parameter{{Name: aws.String(name), Value: aws.String(f.values[name])}}
+			out.NextToken = aws.String(name)
+			break
+		}
+	}
+	if out.Parameters == nil {
+		out.NextToken = nil
+	}
+	return &out, nil
+}
+
+func TestLookup(t *testing.T) {
+	api := &fakeSSM{values: map[string]string{
+		"/troggle/test/maintenance":                    "true",
+		"/troggle/test/rate_limits/searchUsers/per_ip": "30/m",
+		"/troggle/test/broken":                         "maybe",
+	}}
+	s := &Store{API: api, Path: "/troggle/test/", TTL: time.Minute}
+	ctx := context.Background()
+
+	if !s.Bool(ctx, Maintenance, false) {
+		t.Error("maintenance = false, want true")
+	}
+	if got := s.String(ctx, RateLimit("searchUsers", "per_ip"), "off"); got != "30/m" {
+		t.Errorf("rate limit = %q, want 30/m", got)
+	}
+	if got := s.String(ctx, "missing", "def"); got != "def" {
+		t.Errorf("missing = %q, want the default", got)
+	}
+	if !s.Bool(ctx, "broken", true) {
+		t.Error("invalid boolean: want the default")
+	}
+	// Four pages: three parameters and the empty last one, read once
+	if api.reads != 4 {
+		t.Errorf("reads = %d, want 4", api.reads)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	api := &fakeSSM{values: map[string]string{"/p/maintenance": "true"}}
+	s := &Store{API: api, Path: "/p/", TTL: time.Minute}
+	ctx := context.Background()
+	if !s.Bool(ctx, Maintenance, false) {
+		t.Fatal("maintenance = false, want true")
+	}
+
+	// Changes show once the TTL has passed
+	api.values["/p/maintenance"] = "false"
+	if !s.Bool(ctx, Maintenance, false) {
+		t.Error("within TTL: maintenance = false, want the cached true")
+	}
+	s.fetched = time.Now().Add(-2 * time.Minute)
+	if s.Bool(ctx, Maintenance, true) {
+		t.Error("after TTL: maintenance = true, want false")
+	}
+
+	// A failed refresh keeps the values read before
+	api.err = errors.New("throttled")
+	s.fetched = time.Now().Add(-2 * time.Minute)
+	if s.Bool(ctx, Maintenance, true) {
+		t.Error("failed refresh: maintenance = true, want the cached false")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var s *Store
+	if got := s.String(context.Background(), "anything", "def"); got != "def" {
+		t.Errorf("nil store: got %q, want the default", got)
+	}
+	if !(&Store{}).Bool(context.Background(), Maintenance, true) {
+		t.Error("store without API: want the default")
+	}
+}
@@ -0,0 +1,154 @@
+// Package email sends the transactional email of troggle (welcome, password
+// reset, sign-in codes, account changes) through SES.
+//
+// Functions never call SES themselves: they Enqueue a Message naming a
+// template and its data on the email queue, and the sendEmail worker renders
+// and sends it. That keeps SES throttling and retries out of request paths
+// and gives every message the same checks on the way out: the recipient must
+// not be on the suppression list, and each template has a per-recipient rate
+// limit so a retry loop or an abusive caller cannot flood an inbox.
+//
+// Templates are Go templates embedded in the binary (templates/*.tmpl), each
+// defining a "subject", a "text" and an "html" part. Bounces and complaints
+// reported by SES through SNS add the address to the suppression list; see
+// ParseFeedback.
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/validation"
+)
+
+// Template names.
+const (
+	TemplateWelcome              = "welcome"                // data: name, email
+	TemplatePasswordReset        = "password_reset"         // data: name, code, expires_in
+	TemplateEmailChanged         = "email_changed"          // data: name, old_email, new_email
+	TemplateAccountDeleted       = "account_deleted"        // data: name
+	TemplateLoginCode            = "login_code"             // data: name, code, expires_in
+	TemplateVerifyEmail          = "verify_email"           // data: name, new_email, link, expires_in
+	TemplateEmailChangeRequested = "email_change_requested" // data: name, new_email
+	TemplateVerifyAddress        = "verify_address"         // data: name, email, link, expires_in
+	TemplateInvitation           = "invitation"             // data: inviter, link, expires_in
+	TemplateWaitlistJoined       = "waitlist_joined"        // data: position, referral_code
+	TemplateWaitlistAdmitted     = "waitlist_admitted"      // data: email, link
+)
+
+//go:embed templates/*.tmpl
+var files embed.FS
+
+// Template is a parsed email template and its sending policy.
+type Template struct {
+	Name string
+
+	// Limit bounds how often one recipient gets this template.
+	Limit ratelimit.Limit
+
+	// Essential templates concern account security. They are still sent to
+	// addresses that complained, which only stops everything else; a hard
+	// bounce stops them too.
+	Essential bool
+
+	text *texttemplate.Template // "subject" and "text"
+	html *htmltemplate.Template // "html", escaped for HTML
+}
+
+// templates holds every known template by name.
+var templates = map[string]*Template{
+	TemplateWelcome:              mustParse(TemplateWelcome, perHour(1), false),
+	TemplatePasswordReset:        mustParse(TemplatePasswordReset, perHour(5), true),
+	TemplateEmailChanged:         mustParse(TemplateEmailChanged, perHour(10), true),
+	TemplateAccountDeleted:       mustParse(TemplateAccountDeleted, perHour(1), true),
+	TemplateLoginCode:            mustParse(TemplateLoginCode, perHour(10), true),
+	TemplateVerifyEmail:          mustParse(TemplateVerifyEmail, perHour(5), true),
+	TemplateEmailChangeRequested: mustParse(TemplateEmailChangeRequested, perHour(5), true),
+	TemplateVerifyAddress:        mustParse(TemplateVerifyAddress, perHour(3), true),
+	TemplateInvitation:           mustParse(TemplateInvitation, perHour(2), false),
+	TemplateWaitlistJoined:       mustParse(TemplateWaitlistJoined, perHour(2), false),
+	TemplateWaitlistAdmitted:     mustParse(TemplateWaitlistAdmitted, perHour(1), false),
+}
+
+// perHour returns a limit of n messages per hour with a burst of n.
+func perHour(n int) ratelimit.Limit {
+	return ratelimit.Limit{Rate: float64(n) / 3600, Burst: n}
+}
+
+// mustParse parses templates/<name>.tmpl; a broken template fails at init,
+// so it cannot be deployed.
+func mustParse(name string, limit ratelimit.Limit, essential bool) *Template {
+	file := "templates/" + name + ".tmpl"
+	return &Template{
+		Name:      name,
+		Limit:     limit,
+		Essential: essential,
+		text:      texttemplate.Must(texttemplate.New(name).Option("missingkey=error").ParseFS(files, file)),
+		html:      htmltemplate.Must(htmltemplate.New(name).Option("missingkey=error").ParseFS(files, file)),
+	}
+}
+
+// Lookup returns the template called name.
+func Lookup(name string) (*Template, bool) {
+	t, ok := templates[name]
+	return t, ok
+}
+
+// Message is an email waiting on the queue.
+type Message struct {
+	Template string            `json:"template"`
+	To       string            `json:"to"`
+	UserID   string            `json:"user_id,omitempty"` // logged, for tracing a message to its account
+	Data     map[string]string `json:"data"`
+}
+
+// Validate checks that m names a known template and a valid recipient, and
+// normalizes the recipient.
+func (m *Message) Validate() error {
+	if _, ok := Lookup(m.Template); !ok {
+		return fmt.Errorf("unknown email template %q", m.Template)
+	}
+	to, err := validation.NormalizeEmail(m.To, false)
+	if err != nil {
+		return err
+	}
+	m.To = to
+	return nil
+}
+
+// Rendered is a message ready to be sent.
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Render executes the template with data. Every field the template uses must
+// be present in data.
+func (t *Template) Render(data map[string]string) (Rendered, error) {
+	var r Rendered
+	parts := []struct {
+		name string
+		dst  *string
+		exec func(*bytes.Buffer, string) error
+	}{
+		{"subject", &r.Subject, func(b *bytes.Buffer, n string) error { return t.text.ExecuteTemplate(b, n, data) }},
+		{"text", &r.Text, func(b *bytes.Buffer, n string) error { return t.text.ExecuteTemplate(b, n, data) }},
+		{"html", &r.HTML, func(b *bytes.Buffer, n string) error { return t.html.ExecuteTemplate(b, n, data) }},
+	}
+	for _, p := range parts {
+		var buf bytes.Buffer
+		if err := p.exec(&buf, p.name); err != nil {
+			return Rendered{}, fmt.Errorf("rendering %s of %s: %w", p.name, t.Name, err)
+		}
+		*p.dst = strings.TrimSpace(buf.String())
+	}
+	// Subjects are one line; a newline in the data must not start a header
+	r.Subject = strings.Join(strings.Fields(r.Subject), " ")
+	return r, nil
+}
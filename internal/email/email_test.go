@@ -0,0 +1,100 @@
+package email
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	data := map[string]string{
+		"name":          "<Jane>",
+		"inviter":       "<Jane>",
+		"email":         "jane@example.com",
+		"code":          "123456",
+		"expires_in":    "1 hour",
+		"old_email":     "jane@example.com",
+		"new_email":     "jane@example.org",
+		"link":          "https://troggle.example/email-change?token=t",
+		"position":      "42",
+		"referral_code": "ABCD2345",
+	}
+	for name, tmpl := range templates {
+		r, err := tmpl.Render(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if r.Subject == "" || r.Text == "" || r.HTML == "" {
+			t.Errorf("%s: empty part in %+v", name, r)
+		}
+		if strings.Contains(r.HTML, "<Jane>") {
+			t.Errorf("%s: HTML part does not escape data: %s", name, r.HTML)
+		}
+	}
+}
+
+func TestRenderMissingData(t *testing.T) {
+	tmpl, _ := Lookup(TemplatePasswordReset)
+	if _, err := tmpl.Render(map[string]string{"name": "Jane"}); err == nil {
+		t.Error("Render without a code succeeded")
+	}
+}
+
+func TestRenderSubjectOneLine(t *testing.T) {
+	tmpl, _ := Lookup(TemplateWelcome)
+	r, err := tmpl.Render(map[string]string{"name": "Jane\r\nBcc: x@example.com", "email": "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(r.Subject, "\r\n") {
+		t.Errorf("Subject = %q, want one line", r.Subject)
+	}
+}
+
+func TestParseFeedback(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []Suppression
+	}{
+		{
+			name:    "permanent bounce",
+			message: `{"notificationType":"Bounce","bounce":{"bounceType":"Permanent","bounceSubType":"General","bouncedRecipients":[{"emailAddress":"a@example.com"}]}}`,
+			want:    []Suppression{{Email: "a@example.com", Reason: ReasonBounce, Detail: "General"}},
+		},
+		{
+			name:    "transient bounce",
+			message: `{"notificationType":"Bounce","bounce":{"bounceType":"Transient","bouncedRecipients":[{"emailAddress":"a@example.com"}]}}`,
+		},
+		{
+			name:    "complaint event",
+			message: `{"eventType":"Complaint","complaint":{"complaintFeedbackType":"abuse","complainedRecipients":[{"emailAddress":"b@example.com"}]}}`,
+			want:    []Suppression{{Email: "b@example.com", Reason: ReasonComplaint, Detail: "abuse"}},
+		},
+		{
+			name:    "delivery",
+			message: `{"notificationType":"Delivery"}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseFeedback(tt.message)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ParseFeedback = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	welcome, _ := Lookup(TemplateWelcome)
+	reset, _ := Lookup(TemplatePasswordReset)
+	bounce := &Suppression{Reason: ReasonBounce}
+	complaint := &Suppression{Reason: ReasonComplaint}
+
+	if !bounce.Blocks(reset) || !bounce.Blocks(welcome) {
+		t.Error("a bounce must block every template")
+	}
+	if complaint.Blocks(reset) || !complaint.Blocks(welcome) {
+		t.Error("a complaint must block only non-essential templates")
+	}
+}
@@ -0,0 +1,58 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// notification is an SES bounce or complaint notification, as delivered to
+// SNS either by identity notifications ("notificationType") or by a
+// configuration set event destination ("eventType").
+type notification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Bounce           struct {
+		BounceType        string      `json:"bounceType"`
+		BounceSubType     string      `json:"bounceSubType"`
+		BouncedRecipients []recipient `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint struct {
+		ComplaintFeedbackType string      `json:"complaintFeedbackType"`
+		ComplainedRecipients  []recipient `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+type recipient struct {
+	EmailAddress string `json:"emailAddress"`
+}
+
+// ParseFeedback returns the suppressions an SES notification calls for.
+// Permanent bounces and complaints suppress their recipients; transient
+// bounces (full mailboxes, auto-replies) and other notification types, such
+// as deliveries, call for none.
+func ParseFeedback(message string) ([]Suppression, error) {
+	var n notification
+	if err := json.Unmarshal([]byte(message), &n); err != nil {
+		return nil, fmt.Errorf("decoding SES notification: %w", err)
+	}
+	kind := n.NotificationType
+	if kind == "" {
+		kind = n.EventType
+	}
+
+	var sups []Suppression
+	switch kind {
+	case "Bounce":
+		if n.Bounce.BounceType != "Permanent" {
+			return nil, nil
+		}
+		for _, r := range n.Bounce.BouncedRecipients {
+			sups = append(sups, Suppression{Email: r.EmailAddress, Reason: ReasonBounce, Detail: n.Bounce.BounceSubType})
+		}
+	case "Complaint":
+		for _, r := range n.Complaint.ComplainedRecipients {
+			sups = append(sups, Suppression{Email: r.EmailAddress, Reason: ReasonComplaint, Detail: n.Complaint.ComplaintFeedbackType})
+		}
+	}
+	return sups, nil
+}
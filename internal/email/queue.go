@@ -0,0 +1,57 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"troggle-backend/internal/config"
+)
+
+// SQSAPI is the subset of the SQS client used to enqueue email.
+type SQSAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// Queue puts messages on the email queue for the sendEmail worker.
+type Queue struct {
+	SQS SQSAPI
+	URL string
+}
+
+// NewQueue returns the queue configured in cfg.
+func NewQueue(client SQSAPI, cfg *config.Config) (*Queue, error) {
+	if err := cfg.RequireEmailQueue(); err != nil {
+		return nil, err
+	}
+	return &Queue{SQS: client, URL: cfg.EmailQueueURL}, nil
+}
+
+// Enqueue validates m and queues it. The worker sends it at least once:
+// callers should not enqueue the same email twice, but a redelivered message
+// may occasionally reach its recipient twice.
+func (q *Queue) Enqueue(ctx context.Context, m Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("encoding email: %w", err)
+	}
+
+	_, err = q.SQS.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.URL),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"template": {DataType: aws.String("String"), StringValue: aws.String(m.Template)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("enqueueing %s email: %w", m.Template, err)
+	}
+	return nil
+}
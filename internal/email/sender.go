@@ -0,0 +1,64 @@
+package email
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+
+	"troggle-backend/internal/config"
+)
+
+// SESAPI is the subset of the SES v2 client used to send email.
+type SESAPI interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+}
+
+// Sender sends rendered messages through SES.
+type Sender struct {
+	SES       SESAPI
+	From      string
+	ConfigSet string // optional; the configuration set routes bounces and complaints to SNS
+}
+
+// NewSender returns a sender using the address and configuration set in cfg.
+func NewSender(client SESAPI, cfg *config.Config) *Sender {
+	return &Sender{SES: client, From: cfg.EmailFrom, ConfigSet: cfg.EmailConfigSet}
+}
+
+// Send sends r to the address to, tagged with the template name so SES
+// events can be broken down by template, and returns the SES message ID.
+func (s *Sender) Send(ctx context.Context, to, template string, r Rendered) (string, error) {
+	input := &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(s.From),
+		Destination:      &sestypes.Destination{ToAddresses: []string{to}},
+		Content: &sestypes.EmailContent{Simple: &sestypes.Message{
+			Subject: &sestypes.Content{Data: aws.String(r.Subject), Charset: aws.String("UTF-8")},
+			Body: &sestypes.Body{
+				Text: &sestypes.Content{Data: aws.String(r.Text), Charset: aws.String("UTF-8")},
+				Html: &sestypes.Content{Data: aws.String(r.HTML), Charset: aws.String("UTF-8")},
+			},
+		}},
+		EmailTags: []sestypes.MessageTag{{Name: aws.String("template"), Value: aws.String(template)}},
+	}
+	if s.ConfigSet != "" {
+		input.ConfigurationSetName = aws.String(s.ConfigSet)
+	}
+
+	out, err := s.SES.SendEmail(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.MessageId), nil
+}
+
+// Permanent reports whether SES rejected a message in a way retrying cannot
+// fix, such as an invalid address or content. Throttling, paused sending and
+// outages are worth retrying.
+func Permanent(err error) bool {
+	var rejected *sestypes.MessageRejected
+	var bad *sestypes.BadRequestException
+	return errors.As(err, &rejected) || errors.As(err, &bad)
+}
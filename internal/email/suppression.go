@@ -0,0 +1,103 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+// Suppression reasons.
+const (
+	ReasonBounce    = "bounce"    // the address does not exist or permanently rejects mail
+	ReasonComplaint = "complaint" // the recipient marked a message as spam
+)
+
+// Suppression is an address that must not be mailed.
+type Suppression struct {
+	Email        string `dynamodbav:"email"`
+	Reason       string `dynamodbav:"reason"`
+	Detail       string `dynamodbav:"detail,omitempty"` // SES bounce subtype or complaint feedback type
+	SuppressedAt string `dynamodbav:"suppressed_at"`    // RFC 3339
+}
+
+// Blocks reports whether s stops t from being sent.
+func (s *Suppression) Blocks(t *Template) bool {
+	return s.Reason == ReasonBounce || !t.Essential
+}
+
+// Suppressions reads and writes the suppression list.
+type Suppressions struct {
+	DB    *db.Client
+	Table string
+}
+
+// NewSuppressions returns the suppression list in the table named in cfg.
+func NewSuppressions(client *db.Client, cfg *config.Config) *Suppressions {
+	return &Suppressions{DB: client, Table: cfg.SuppressionTableName}
+}
+
+// Get returns the suppression of addr, or nil if it may be mailed.
+func (s *Suppressions) Get(ctx context.Context, addr string) (*Suppression, error) {
+	item, err := s.DB.GetItem(ctx, s.Table, key(addr))
+	if err != nil || item == nil {
+		return nil, err
+	}
+	var sup Suppression
+	if err := attributevalue.UnmarshalMap(item, &sup); err != nil {
+		return nil, fmt.Errorf("decoding suppression: %w", err)
+	}
+	return &sup, nil
+}
+
+// Add suppresses sup.Email. An address already suppressed keeps its first
+// record, except that a bounce replaces a complaint, since it blocks more.
+func (s *Suppressions) Add(ctx context.Context, sup Suppression) error {
+	sup.Email = normalize(sup.Email)
+	if sup.SuppressedAt == "" {
+		sup.SuppressedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	item, err := attributevalue.MarshalMap(sup)
+	if err != nil {
+		return fmt.Errorf("encoding suppression: %w", err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(email)"),
+	}
+	if sup.Reason == ReasonBounce {
+		input.ConditionExpression = aws.String("attribute_not_exists(email) OR #reason <> :bounce")
+		input.ExpressionAttributeNames = map[string]string{"#reason": "reason"}
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":bounce": &types.AttributeValueMemberS{Value: ReasonBounce},
+		}
+	}
+
+	start := time.Now()
+	_, err = s.DB.DynamoDB.PutItem(ctx, input)
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return nil
+	}
+	return db.Wrap(err, "suppressing address")
+}
+
+// normalize returns the form addresses are keyed by.
+func normalize(addr string) string {
+	return strings.ToLower(strings.TrimSpace(addr))
+}
+
+// key returns the primary key of the suppression of addr.
+func key(addr string) db.Item {
+	return db.Item{"email": &types.AttributeValueMemberS{Value: normalize(addr)}}
+}
@@ -0,0 +1,113 @@
+// Package emailchange encodes the tokens confirming a change of email
+// address. requestEmailChange mails one to the new address; following its
+// link proves the user reads that mailbox, and confirmEmailChange only then
+// moves the account. A token holds the user, both addresses and its expiry,
+// signed by package signing.
+//
+// Tokens are not stored. Since the record must still be at the old address
+// for a change to go through, a token cannot be used twice, and requesting
+// another change does not invalidate the links already sent until one of
+// them is confirmed. The key is the email_change_key secret; rotating it
+// invalidates the links in flight.
+//
+// resendVerification mails tokens of a change from an address to itself
+// (see Change.Verification): confirming one marks the address verified
+// instead of moving the account.
+package emailchange
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/secrets"
+	"troggle-backend/internal/signing"
+)
+
+// SecretName is the secret, below SECRETS_PREFIX, holding the signing key.
+const SecretName = "email_change_key"
+
+// TTL is how long a token stays valid.
+const TTL = 24 * time.Hour
+
+// ErrInvalidToken is returned for tokens that are forged, malformed or
+// expired.
+var ErrInvalidToken = apperr.Invalid("INVALID_TOKEN", "token", "The confirmation link is invalid or has expired")
+
+// Change is a requested change of email address.
+type Change struct {
+	UserID    string    `json:"u"`
+	OldEmail  string    `json:"o"`
+	NewEmail  string    `json:"n"`
+	ExpiresAt time.Time `json:"e"`
+}
+
+// Verification reports whether ch only verifies the address the account
+// has, rather than moving it.
+func (ch Change) Verification() bool {
+	return ch.OldEmail == ch.NewEmail
+}
+
+// Codec signs and verifies tokens.
+type Codec struct {
+	Signer signing.Signer
+	Now    func() time.Time // time.Now when nil
+}
+
+// NewCodec returns a codec signing with the email_change_key secret of keys.
+func NewCodec(keys signing.Keys) *Codec {
+	return &Codec{Signer: signing.Signer{Keys: keys, Secret: SecretName}}
+}
+
+// New returns the codec signing with the container's secrets cache.
+func New(ctx context.Context, cfg *config.Config) (*Codec, error) {
+	cache, err := secrets.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating secrets cache: %w", err)
+	}
+	return NewCodec(cache), nil
+}
+
+// Encode returns the token of ch.
+func (c *Codec) Encode(ctx context.Context, ch Change) (string, error) {
+	raw, err := json.Marshal(ch)
+	if err != nil {
+		return "", err
+	}
+	return c.Signer.Sign(ctx, raw)
+}
+
+// Decode returns the change of token, or ErrInvalidToken when it was not
+// signed with the current key or has expired.
+func (c *Codec) Decode(ctx context.Context, token string) (Change, error) {
+	raw, err := c.Signer.Open(ctx, token)
+	if errors.Is(err, signing.ErrInvalid) {
+		return Change{}, ErrInvalidToken
+	}
+	if err != nil {
+		return Change{}, err
+	}
+
+	var ch Change
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ch); err != nil || ch.UserID == "" || ch.OldEmail == "" || ch.NewEmail == "" {
+		return Change{}, ErrInvalidToken
+	}
+	if !c.now().Before(ch.ExpiresAt) {
+		return Change{}, ErrInvalidToken
+	}
+	return ch, nil
+}
+
+func (c *Codec) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
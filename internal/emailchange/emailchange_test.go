@@ -0,0 +1,64 @@
+package emailchange
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"troggle-backend/internal/signing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := NewCodec(signing.StaticKey("secret"))
+	c.Now = func() time.Time { return now }
+	want := Change{UserID: "u1", OldEmail: "jane@example.com", NewEmail: "jane@example.org", ExpiresAt: now.Add(TTL)}
+
+	token, err := c.Encode(ctx, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Decode(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != want.UserID || got.OldEmail != want.OldEmail || got.NewEmail != want.NewEmail || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("Decode = %+v, want %+v", got, want)
+	}
+
+	now = now.Add(TTL)
+	if _, err := c.Decode(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Decode(expired): err = %v", err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	ctx := context.Background()
+	c := NewCodec(signing.StaticKey("secret"))
+	token, err := c.Encode(ctx, Change{UserID: "u1", OldEmail: "a@example.com", NewEmail: "b@example.com", ExpiresAt: time.Now().Add(TTL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	other, err := NewCodec(signing.StaticKey("other")).Encode(ctx, Change{UserID: "u2", OldEmail: "a@example.com", NewEmail: "b@example.com", ExpiresAt: time.Now().Add(TTL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	for name, token := range map[string]string{
+		"empty":           "",
+		"no signature":    payload,
+		"bad encoding":    payload + ".!!",
+		"swapped payload": otherPayload + "." + sig,
+		"other key":       other,
+		"truncated":       token[:len(token)-2],
+	} {
+		if _, err := c.Decode(ctx, token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
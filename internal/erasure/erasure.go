@@ -0,0 +1,203 @@
+// Package erasure runs account deletion with a grace period (the GDPR right
+// to erasure without the risk of an irreversible mistake).
+//
+// A deletion request marks the user record pending_deletion and schedules
+// its erasure DELETION_GRACE_PERIOD later. Until then the user can cancel,
+// explicitly or simply by signing in again, which restores the previous
+// status. The deleteUser function erases due accounts when an EventBridge
+// rule invokes it (hourly, say): Due lists them through the status index, so
+// a lost or failed run is caught up by the next one without any per-user
+// schedule to clean up.
+//
+// With DELETION_MODE=soft an erasure only marks the account deleted: the
+// user record takes the deleted status and a purge_at time
+// SOFT_DELETE_RETENTION later, and the devices and preferences of the user
+// a deleted_at time and, as expires_at, the purge time, so DynamoDB's TTL
+// removes them then. Read paths skip whatever carries deleted_at. Until
+// purge_at an admin can restore the account through restoreUser; after it
+// the deleteUser sweep erases what is left, the Cognito account included,
+// which is why the user record itself carries no TTL.
+package erasure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/users"
+)
+
+// StatusPendingDeletion is the status of accounts awaiting erasure.
+const StatusPendingDeletion = "pending_deletion"
+
+// defaultStatus is restored on cancellation when the record has no previous
+// status.
+const defaultStatus = "active"
+
+// Pending is a scheduled erasure.
+type Pending struct {
+	UserID      string `json:"user_id" dynamodbav:"user_id"`
+	Status      string `json:"status" dynamodbav:"status"`
+	RequestedAt string `json:"deletion_requested_at" dynamodbav:"deletion_requested_at"` // RFC 3339
+	ScheduledAt string `json:"deletion_scheduled_at" dynamodbav:"deletion_scheduled_at"` // RFC 3339
+}
+
+// Store changes the deletion state of user records.
+type Store struct {
+	DB          *db.Client
+	Table       string
+	StatusIndex string
+	Grace       time.Duration // time between a request and the erasure
+	Retention   time.Duration // time a soft-deleted account can be restored
+	Related     []Related     // user data soft deleted along with the record
+}
+
+// NewStore returns a store over the user table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{
+		DB:          client,
+		Table:       cfg.UserTableName,
+		StatusIndex: cfg.StatusIndexName,
+		Grace:       cfg.DeletionGracePeriod,
+		Retention:   cfg.SoftDeleteRetention,
+		Related: []Related{
+			{Table: cfg.DeviceTableName, Keys: []string{"user_id", "token"}, TTL: cfg.DeviceTTL},
+			{Table: cfg.PreferenceTableName, Keys: []string{"user_id"}},
+		},
+	}
+}
+
+// Request schedules the erasure of userID. Requesting it again keeps the
+// first schedule and reports created false. A missing user is an
+// apperr.NotFound error.
+func (s *Store) Request(ctx context.Context, userID string) (p Pending, created bool, err error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	p = Pending{
+		UserID:      userID,
+		Status:      StatusPendingDeletion,
+		RequestedAt: now.Format(time.RFC3339),
+		ScheduledAt: now.Add(s.Grace).Format(time.RFC3339),
+	}
+
+	start := time.Now()
+	_, err = s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 users.Key(userID),
+		UpdateExpression:    aws.String("SET previous_status = #status, #status = :pending, deletion_requested_at = :now, deletion_scheduled_at = :at, updated_at = :now"),
+		ConditionExpression: aws.String("attribute_exists(user_id) AND #status <> :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status", // reserved word
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: StatusPendingDeletion},
+			":now":     &types.AttributeValueMemberS{Value: p.RequestedAt},
+			":at":      &types.AttributeValueMemberS{Value: p.ScheduledAt},
+		},
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		existing, err := s.Get(ctx, userID)
+		if err != nil {
+			return Pending{}, false, err
+		}
+		if existing == nil {
+			return Pending{}, false, apperr.NotFound("User not found")
+		}
+		return *existing, false, nil
+	}
+	if err != nil {
+		return Pending{}, false, db.Wrap(err, "requesting deletion of "+userID)
+	}
+	return p, true, nil
+}
+
+// Cancel cancels the pending erasure of userID, restoring the status the
+// account had before, and reports whether one was pending.
+func (s *Store) Cancel(ctx context.Context, userID string) (bool, error) {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 users.Key(userID),
+		UpdateExpression:    aws.String("SET #status = if_not_exists(previous_status, :default), updated_at = :now REMOVE previous_status, deletion_requested_at, deletion_scheduled_at"),
+		ConditionExpression: aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: StatusPendingDeletion},
+			":default": &types.AttributeValueMemberS{Value: defaultStatus},
+			":now":     &types.AttributeValueMemberS{Value: start.UTC().Format(time.RFC3339)},
+		},
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return false, nil
+	}
+	if err != nil {
+		return false, db.Wrap(err, "cancelling deletion of "+userID)
+	}
+	return true, nil
+}
+
+// Get returns the pending erasure of userID, or nil if none is pending. The
+// read is strongly consistent, so the erasure never acts on a cancelled
+// request.
+func (s *Store) Get(ctx context.Context, userID string) (*Pending, error) {
+	start := time.Now()
+	result, err := s.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.Table),
+		Key:            users.Key(userID),
+		ConsistentRead: aws.Bool(true),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return nil, db.Wrap(err, "getting user "+userID)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var p Pending
+	if err := attributevalue.UnmarshalMap(result.Item, &p); err != nil {
+		return nil, fmt.Errorf("decoding user item: %w", err)
+	}
+	if p.Status != StatusPendingDeletion {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// Due returns the users whose erasure is scheduled at or before now.
+func (s *Store) Due(ctx context.Context, now time.Time) ([]string, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.StatusIndex),
+		KeyConditionExpression: aws.String("#status = :pending"),
+		FilterExpression:       aws.String("deletion_scheduled_at <= :now"),
+		ProjectionExpression:   aws.String("user_id"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: StatusPendingDeletion},
+			":now":     &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		},
+	}
+	var due []string
+	err := s.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		for _, item := range items {
+			if v, ok := item["user_id"].(*types.AttributeValueMemberS); ok {
+				due = append(due, v.Value)
+			}
+		}
+		return true
+	})
+	return due, err
+}
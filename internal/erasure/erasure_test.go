@@ -0,0 +1,130 @@
+package erasure
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func TestRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		stored      db.Item // the record read back after a failed condition
+		wantCreated bool
+		wantAt      string
+		wantErr     bool
+	}{
+		{name: "new", wantCreated: true},
+		{
+			name:   "already pending",
+			stored: dbtest.Item("user_id", "u1", "status", StatusPendingDeletion, "deletion_scheduled_at", "2024-05-31T00:00:00Z"),
+			wantAt: "2024-05-31T00:00:00Z",
+		},
+		{name: "no user", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					if !tt.wantCreated {
+						return nil, dbtest.ConditionFailed()
+					}
+					return &dynamodb.UpdateItemOutput{}, nil
+				},
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.stored}, nil
+				},
+			}
+			s := &Store{DB: m.Client(), Table: "users", Grace: 30 * 24 * time.Hour}
+
+			p, created, err := s.Request(context.Background(), "u1")
+			if tt.wantErr {
+				if apperr.KindOf(err) != apperr.KindNotFound {
+					t.Fatalf("err = %v, want not found", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created != tt.wantCreated {
+				t.Errorf("created = %v, want %v", created, tt.wantCreated)
+			}
+			if p.Status != StatusPendingDeletion {
+				t.Errorf("status = %q", p.Status)
+			}
+			if tt.wantAt != "" && p.ScheduledAt != tt.wantAt {
+				t.Errorf("scheduled at %s, want the first schedule %s", p.ScheduledAt, tt.wantAt)
+			}
+			if created {
+				requested, _ := time.Parse(time.RFC3339, p.RequestedAt)
+				scheduled, _ := time.Parse(time.RFC3339, p.ScheduledAt)
+				if scheduled.Sub(requested) != s.Grace {
+					t.Errorf("scheduled %s after the request, want %s", scheduled.Sub(requested), s.Grace)
+				}
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		want    bool
+		wantErr bool
+	}{
+		{name: "pending", want: true},
+		{name: "not pending", err: dbtest.ConditionFailed()},
+		{name: "throttled", err: dbtest.Throttled(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			}}
+			s := &Store{DB: m.Client(), Table: "users"}
+
+			got, err := s.Cancel(context.Background(), "u1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("cancelled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDue(t *testing.T) {
+	m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1"), dbtest.Item("user_id", "u2")}}, nil
+	}}
+	s := &Store{DB: m.Client(), Table: "users", StatusIndex: "status-index"}
+
+	due, err := s.Due(context.Background(), time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"u1", "u2"}; !reflect.DeepEqual(due, want) {
+		t.Errorf("due = %v, want %v", due, want)
+	}
+	in := m.Calls[0].Input.(*dynamodb.QueryInput)
+	if aws.ToString(in.IndexName) != "status-index" {
+		t.Errorf("index = %q", aws.ToString(in.IndexName))
+	}
+	if got := in.ExpressionAttributeValues[":now"]; !reflect.DeepEqual(got, dbtest.Item("v", "2024-05-01T12:00:00Z")["v"]) {
+		t.Errorf(":now = %v", got)
+	}
+}
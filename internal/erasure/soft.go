@@ -0,0 +1,283 @@
+package erasure
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/users"
+)
+
+// StatusDeleted is the status of soft-deleted accounts.
+const StatusDeleted = "deleted"
+
+// Related is a table of user data soft deleted along with the user record.
+type Related struct {
+	Table string
+	Keys  []string      // key attribute names, the user_id partition key first
+	TTL   time.Duration // expiry given to restored items anew; zero removes it
+}
+
+// Deleted is a soft-deleted account.
+type Deleted struct {
+	UserID    string `json:"user_id" dynamodbav:"user_id"`
+	Status    string `json:"status" dynamodbav:"status"`
+	DeletedAt string `json:"deleted_at" dynamodbav:"deleted_at"` // RFC 3339
+	PurgeAt   string `json:"purge_at" dynamodbav:"purge_at"`     // RFC 3339, the end of the retention window
+}
+
+// SoftDelete marks the record user deleted at now and returns it as
+// updated. The email moves to deleted_email, taking the record out of the
+// email index, while the email and username reservations stay so no one
+// else claims them before the purge. A missing user, or one deleted
+// already, is an apperr.NotFound error.
+func (s *Store) SoftDelete(ctx context.Context, user db.Item, now time.Time) (db.Item, error) {
+	now = now.UTC().Truncate(time.Second)
+	set := []string{
+		"previous_status = if_not_exists(previous_status, #status)",
+		"#status = :deleted",
+		"deleted_at = :now",
+		"purge_at = :purge",
+		"updated_at = :now",
+	}
+	expr := "SET "
+	if _, ok := user["email"]; ok {
+		set = append(set, "deleted_email = email")
+		expr = "REMOVE email SET "
+	}
+
+	start := time.Now()
+	result, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 db.Item{"user_id": user["user_id"]},
+		UpdateExpression:    aws.String(expr + strings.Join(set, ", ")),
+		ConditionExpression: aws.String("attribute_exists(user_id) AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status", // reserved word
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deleted": &types.AttributeValueMemberS{Value: StatusDeleted},
+			":now":     &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			":purge":   &types.AttributeValueMemberS{Value: now.Add(s.Retention).Format(time.RFC3339)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return nil, apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return nil, db.Wrap(err, "soft deleting user")
+	}
+	return result.Attributes, nil
+}
+
+// SoftDeleteRelated marks the items of userID in every related table
+// deleted at now, to expire at purgeAt.
+func (s *Store) SoftDeleteRelated(ctx context.Context, userID string, now, purgeAt time.Time) error {
+	values := map[string]types.AttributeValue{
+		":now":   &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		":purge": &types.AttributeValueMemberN{Value: strconv.FormatInt(purgeAt.Unix(), 10)},
+	}
+	for _, rel := range s.Related {
+		err := s.updateRelated(ctx, rel, userID, "", "SET deleted_at = :now, expires_at = :purge", values)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreRelated clears the deletion marks of the items of userID in every
+// related table. Items of tables with a TTL expire that long after now.
+func (s *Store) RestoreRelated(ctx context.Context, userID string, now time.Time) error {
+	for _, rel := range s.Related {
+		expr, values := "REMOVE deleted_at, expires_at", map[string]types.AttributeValue(nil)
+		if rel.TTL > 0 {
+			expr = "REMOVE deleted_at SET expires_at = :expires"
+			values = map[string]types.AttributeValue{
+				":expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(rel.TTL).Unix(), 10)},
+			}
+		}
+		if err := s.updateRelated(ctx, rel, userID, "attribute_exists(deleted_at)", expr, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateRelated applies update to the items of userID in rel matching
+// filter, if not empty. Items deleted meanwhile are skipped rather than
+// written back.
+func (s *Store) updateRelated(ctx context.Context, rel Related, userID, filter, update string, values map[string]types.AttributeValue) error {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(rel.Table),
+		KeyConditionExpression: aws.String("#pk = :pk"),
+		ExpressionAttributeNames: map[string]string{
+			"#pk": rel.Keys[0],
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+	if filter != "" {
+		input.FilterExpression = aws.String(filter)
+	}
+
+	var updateErr error
+	err := s.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		for _, item := range items {
+			key := db.Item{}
+			for _, attr := range rel.Keys {
+				key[attr] = item[attr]
+			}
+			start := time.Now()
+			_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(rel.Table),
+				Key:                       key,
+				UpdateExpression:          aws.String(update),
+				ConditionExpression:       aws.String("attribute_exists(#pk)"),
+				ExpressionAttributeNames:  map[string]string{"#pk": rel.Keys[0]},
+				ExpressionAttributeValues: values,
+			})
+			db.Observe(ctx, start, err)
+			if err != nil && !db.ConditionFailed(err, -1) {
+				updateErr = db.Wrap(err, "updating "+rel.Table)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// Restore restores the soft-deleted account of userID, with the status it
+// had before the deletion, clears the deletion marks of its related items
+// and returns the record as restored. A missing user, or one past the
+// retention window, is an apperr.NotFound error; one not deleted is an
+// apperr.Invalid error.
+func (s *Store) Restore(ctx context.Context, userID string, now time.Time) (db.Item, error) {
+	item, d, err := s.getDeleted(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case item == nil:
+		return nil, apperr.NotFound("User not found")
+	case d == nil:
+		return nil, apperr.Invalid("USER_NOT_DELETED", "user_id", "user is not deleted")
+	case d.PurgeAt <= now.UTC().Format(time.RFC3339):
+		return nil, apperr.NotFound("Deleted user is past its retention window")
+	}
+
+	set := []string{"#status = if_not_exists(previous_status, :default)", "updated_at = :now"}
+	remove := []string{"previous_status", "deleted_at", "purge_at", "deletion_requested_at", "deletion_scheduled_at"}
+	if _, ok := item["deleted_email"]; ok {
+		set = append(set, "email = deleted_email")
+		remove = append(remove, "deleted_email")
+	}
+
+	start := time.Now()
+	result, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 users.Key(userID),
+		UpdateExpression:    aws.String("SET " + strings.Join(set, ", ") + " REMOVE " + strings.Join(remove, ", ")),
+		ConditionExpression: aws.String("deleted_at = :deleted_at"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":default":    &types.AttributeValueMemberS{Value: defaultStatus},
+			":now":        &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+			":deleted_at": &types.AttributeValueMemberS{Value: d.DeletedAt},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		// Purged or restored since the read
+		return nil, apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return nil, db.Wrap(err, "restoring user "+userID)
+	}
+	if err := s.RestoreRelated(ctx, userID, now); err != nil {
+		return nil, err
+	}
+	return result.Attributes, nil
+}
+
+// GetDeleted returns the soft deletion of userID, or nil if the account is
+// not soft deleted. The read is strongly consistent, so the purge never acts
+// on a restored account.
+func (s *Store) GetDeleted(ctx context.Context, userID string) (*Deleted, error) {
+	_, d, err := s.getDeleted(ctx, userID)
+	return d, err
+}
+
+// getDeleted reads the record of userID and its soft deletion, nil unless
+// soft deleted.
+func (s *Store) getDeleted(ctx context.Context, userID string) (db.Item, *Deleted, error) {
+	start := time.Now()
+	result, err := s.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.Table),
+		Key:            users.Key(userID),
+		ConsistentRead: aws.Bool(true),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return nil, nil, db.Wrap(err, "getting user "+userID)
+	}
+	if result.Item == nil {
+		return nil, nil, nil
+	}
+	var d Deleted
+	if err := attributevalue.UnmarshalMap(result.Item, &d); err != nil {
+		return nil, nil, fmt.Errorf("decoding user item: %w", err)
+	}
+	if d.Status != StatusDeleted || d.DeletedAt == "" {
+		return result.Item, nil, nil
+	}
+	return result.Item, &d, nil
+}
+
+// Purgeable returns the soft-deleted users whose retention window ended at
+// or before now.
+func (s *Store) Purgeable(ctx context.Context, now time.Time) ([]string, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.StatusIndex),
+		KeyConditionExpression: aws.String("#status = :deleted"),
+		FilterExpression:       aws.String("purge_at <= :now"),
+		ProjectionExpression:   aws.String("user_id"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deleted": &types.AttributeValueMemberS{Value: StatusDeleted},
+			":now":     &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		},
+	}
+	var purgeable []string
+	err := s.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		for _, item := range items {
+			if v, ok := item["user_id"].(*types.AttributeValueMemberS); ok {
+				purgeable = append(purgeable, v.Value)
+			}
+		}
+		return true
+	})
+	return purgeable, err
+}
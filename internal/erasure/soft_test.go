@@ -0,0 +1,140 @@
+package erasure
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+var now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestSoftDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     db.Item
+		err      error
+		wantExpr string
+		wantKind apperr.Kind
+	}{
+		{
+			name:     "with email",
+			user:     dbtest.Item("user_id", "u1", "email", "jane@example.com"),
+			wantExpr: "REMOVE email SET previous_status = if_not_exists(previous_status, #status), #status = :deleted, deleted_at = :now, purge_at = :purge, updated_at = :now, deleted_email = email",
+		},
+		{
+			name:     "without email",
+			user:     dbtest.Item("user_id", "u1"),
+			wantExpr: "SET previous_status = if_not_exists(previous_status, #status), #status = :deleted, deleted_at = :now, purge_at = :purge, updated_at = :now",
+		},
+		{
+			name:     "deleted already",
+			user:     dbtest.Item("user_id", "u1"),
+			err:      dbtest.ConditionFailed(),
+			wantKind: apperr.KindNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *dynamodb.UpdateItemInput
+			m := &dbtest.Mock{UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				got = in
+				return &dynamodb.UpdateItemOutput{}, tt.err
+			}}
+			s := &Store{DB: m.Client(), Table: "users", Retention: 30 * 24 * time.Hour}
+
+			_, err := s.SoftDelete(context.Background(), tt.user, now)
+			if tt.wantKind != 0 {
+				if kind := apperr.KindOf(err); kind != tt.wantKind {
+					t.Fatalf("err = %v, want kind %v", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expr := aws.ToString(got.UpdateExpression); expr != tt.wantExpr {
+				t.Errorf("update = %q, want %q", expr, tt.wantExpr)
+			}
+			if purge := got.ExpressionAttributeValues[":purge"].(*types.AttributeValueMemberS).Value; purge != "2024-05-31T12:00:00Z" {
+				t.Errorf("purge_at = %s, want the end of the retention window", purge)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	deleted := func(purgeAt string) db.Item {
+		return dbtest.Item("user_id", "u1", "status", StatusDeleted, "deleted_at", "2024-04-20T00:00:00Z", "purge_at", purgeAt, "deleted_email", "jane@example.com")
+	}
+	tests := []struct {
+		name        string
+		stored      db.Item
+		err         error // of the update
+		wantKind    apperr.Kind
+		wantRelated int // related items restored
+	}{
+		{name: "restored", stored: deleted("2024-05-20T00:00:00Z"), wantRelated: 1},
+		{name: "missing", wantKind: apperr.KindNotFound},
+		{name: "not deleted", stored: dbtest.Item("user_id", "u1", "status", "active"), wantKind: apperr.KindInvalid},
+		{name: "past retention", stored: deleted("2024-05-01T11:00:00Z"), wantKind: apperr.KindNotFound},
+		{name: "purged meanwhile", stored: deleted("2024-05-20T00:00:00Z"), err: dbtest.ConditionFailed(), wantKind: apperr.KindNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var record *dynamodb.UpdateItemInput
+			var related []*dynamodb.UpdateItemInput
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.stored}, nil
+				},
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if aws.ToString(in.FilterExpression) != "attribute_exists(deleted_at)" {
+						t.Errorf("related items queried with filter %q", aws.ToString(in.FilterExpression))
+					}
+					return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1", "token", "t1")}}, nil
+				},
+				UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					if aws.ToString(in.TableName) != "users" {
+						related = append(related, in)
+						return &dynamodb.UpdateItemOutput{}, nil
+					}
+					record = in
+					return &dynamodb.UpdateItemOutput{}, tt.err
+				},
+			}
+			s := &Store{DB: m.Client(), Table: "users", Related: []Related{{Table: "devices", Keys: []string{"user_id", "token"}, TTL: time.Hour}}}
+
+			_, err := s.Restore(context.Background(), "u1", now)
+			if tt.wantKind != 0 {
+				if kind := apperr.KindOf(err); kind != tt.wantKind {
+					t.Fatalf("err = %v, want kind %v", err, tt.wantKind)
+				}
+				if len(related) != 0 {
+					t.Errorf("%d related items restored, want none", len(related))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expr := aws.ToString(record.UpdateExpression); !strings.Contains(expr, "email = deleted_email") || !strings.Contains(expr, "REMOVE previous_status, deleted_at, purge_at") {
+				t.Errorf("update = %q, want the email back and the marks removed", expr)
+			}
+			if len(related) != tt.wantRelated {
+				t.Fatalf("%d related items restored, want %d", len(related), tt.wantRelated)
+			}
+			if expr := aws.ToString(related[0].UpdateExpression); expr != "REMOVE deleted_at SET expires_at = :expires" {
+				t.Errorf("related update = %q, want a new expiry", expr)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+// Package events publishes troggle's domain events to EventBridge, so other
+// services (analytics, email, search) can react to changes of users without
+// the user functions knowing about them.
+//
+// Every event is a typed struct whose JSON form is the event detail; its
+// DetailType names the EventBridge detail-type rules match on. All events
+// share the source "troggle.users". Publishing is best effort from the
+// point of view of the caller: the change is already stored when its event
+// is published, so Emit logs a lost event instead of failing the request.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/logging"
+)
+
+// Source is the EventBridge source of every troggle event.
+const Source = "troggle.users"
+
+const (
+	// maxBatch is the most entries PutEvents accepts in one call.
+	maxBatch = 10
+
+	// maxAttempts bounds how often entries EventBridge failed to accept
+	// (throttled, internal errors) are sent again.
+	maxAttempts = 3
+
+	// retryDelay is the pause before the first resend; it doubles after
+	// every attempt.
+	retryDelay = 50 * time.Millisecond
+)
+
+// Event is a domain event.
+type Event interface {
+	DetailType() string
+}
+
+// UserCreated is published when an account is created.
+type UserCreated struct {
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Name      string `json:"name,omitempty"`
+	CreatedAt string `json:"created_at"` // RFC 3339
+}
+
+// UserDeleted is published once an account and its data are deleted.
+type UserDeleted struct {
+	UserID    string `json:"user_id"`
+	DeletedAt string `json:"deleted_at"`
+}
+
+// UserStatusChanged is published when an admin moves an account to another
+// status of its lifecycle; see package accountstatus.
+type UserStatusChanged struct {
+	UserID    string `json:"user_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason,omitempty"` // reason code of a suspension or ban
+	ChangedAt string `json:"changed_at"`
+}
+
+// ProfileUpdated is published when profile fields of a user change.
+type ProfileUpdated struct {
+	UserID    string   `json:"user_id"`
+	Changed   []string `json:"changed"` // names of the updated fields
+	Version   int      `json:"version"` // profile version after the update
+	UpdatedAt string   `json:"updated_at"`
+}
+
+// NotificationPreferencesChanged is published when notification settings
+// change, carrying the complete new settings.
+type NotificationPreferencesChanged struct {
+	UserID        string         `json:"user_id"`
+	Notifications map[string]any `json:"notifications"`
+	Changed       []string       `json:"changed"`
+	ChangedAt     string         `json:"changed_at"`
+}
+
+// SessionCreated is published when a sign-in is recorded.
+type SessionCreated struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	Device    string `json:"device,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// SessionRevoked is published when a session is revoked.
+type SessionRevoked struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	RevokedAt string `json:"revoked_at"`
+}
+
+// DeviceRegistered is published when a push token is registered for the
+// first time.
+type DeviceRegistered struct {
+	UserID       string `json:"user_id"`
+	Platform     string `json:"platform"`
+	RegisteredAt string `json:"registered_at"`
+}
+
+// DeviceUnregistered is published when a push token is removed by its app.
+type DeviceUnregistered struct {
+	UserID         string `json:"user_id"`
+	Platform       string `json:"platform"`
+	UnregisteredAt string `json:"unregistered_at"`
+}
+
+// AchievementUnlocked is published when a user unlocks an achievement.
+type AchievementUnlocked struct {
+	UserID        string `json:"user_id"`
+	AchievementID string `json:"achievement_id"`
+	Name          string `json:"name"`
+	UnlockedAt    string `json:"unlocked_at"`
+}
+
+// FriendRequestSent is published when a user asks another to be friends.
+type FriendRequestSent struct {
+	UserID string `json:"user_id"` // the recipient
+	FromID string `json:"from_id"`
+	SentAt string `json:"sent_at"`
+}
+
+// FriendRequestAccepted is published when a friend request is accepted,
+// including by a request crossing one from the other user.
+type FriendRequestAccepted struct {
+	UserID     string `json:"user_id"` // who sent the request
+	FriendID   string `json:"friend_id"`
+	AcceptedAt string `json:"accepted_at"`
+}
+
+// InvitationAccepted is published when an account created through an
+// invitation accepts it, crediting the inviter with the referral.
+type InvitationAccepted struct {
+	UserID       string `json:"user_id"` // the inviter
+	InviteeID    string `json:"invitee_id"`
+	InvitationID string `json:"invitation_id"`
+	AcceptedAt   string `json:"accepted_at"`
+}
+
+// MatchCreated is published when a match is created. It starts the
+// execution of the match state machine, which ends the match when it times
+// out; see package matches.
+type MatchCreated struct {
+	MatchID   string `json:"match_id"`
+	HostID    string `json:"host_id"`
+	Board     string `json:"board,omitempty"`
+	CreatedAt string `json:"created_at"`
+	JoinBy    string `json:"join_by"`   // RFC 3339; the match starts or is abandoned then
+	FinishBy  string `json:"finish_by"` // RFC 3339; a match without results is abandoned then
+}
+
+// MatchFinished is published when the results of a match are recorded.
+type MatchFinished struct {
+	MatchID    string        `json:"match_id"`
+	Board      string        `json:"board,omitempty"`
+	Results    []MatchResult `json:"results"` // by place
+	FinishedAt string        `json:"finished_at"`
+}
+
+// MatchResult is the result of one player of a match.
+type MatchResult struct {
+	UserID string `json:"user_id"`
+	Score  int64  `json:"score"`
+	Place  int    `json:"place"` // 1 for the winners
+}
+
+// MatchPlayed is published for every player of a finished match, so
+// consumers keyed on users (achievements, leaderboard submissions) need not
+// unpack MatchFinished.
+type MatchPlayed struct {
+	UserID     string `json:"user_id"`
+	MatchID    string `json:"match_id"`
+	Board      string `json:"board,omitempty"`
+	Score      int64  `json:"score"`
+	Place      int    `json:"place"`
+	Won        bool   `json:"won"`
+	FinishedAt string `json:"finished_at"`
+}
+
+// MatchAbandoned is published when a match times out without results.
+type MatchAbandoned struct {
+	MatchID     string   `json:"match_id"`
+	Players     []string `json:"players"`
+	Stage       string   `json:"stage"` // the status the match was in, "waiting" or "in_progress"
+	AbandonedAt string   `json:"abandoned_at"`
+}
+
+func (UserCreated) DetailType() string                    { return "UserCreated" }
+func (UserDeleted) DetailType() string                    { return "UserDeleted" }
+func (UserStatusChanged) DetailType() string              { return "UserStatusChanged" }
+func (ProfileUpdated) DetailType() string                 { return "ProfileUpdated" }
+func (NotificationPreferencesChanged) DetailType() string { return "NotificationPreferencesChanged" }
+func (SessionCreated) DetailType() string                 { return "SessionCreated" }
+func (SessionRevoked) DetailType() string                 { return "SessionRevoked" }
+func (DeviceRegistered) DetailType() string               { return "DeviceRegistered" }
+func (DeviceUnregistered) DetailType() string             { return "DeviceUnregistered" }
+func (AchievementUnlocked) DetailType() string            { return "AchievementUnlocked" }
+func (FriendRequestSent) DetailType() string              { return "FriendRequestSent" }
+func (FriendRequestAccepted) DetailType() string          { return "FriendRequestAccepted" }
+func (InvitationAccepted) DetailType() string             { return "InvitationAccepted" }
+func (MatchCreated) DetailType() string                   { return "MatchCreated" }
+func (MatchFinished) DetailType() string                  { return "MatchFinished" }
+func (MatchPlayed) DetailType() string                    { return "MatchPlayed" }
+func (MatchAbandoned) DetailType() string                 { return "MatchAbandoned" }
+
+// Now returns the current time in the format event timestamps use.
+func Now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// API is the subset of the EventBridge client used to publish.
+type API interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// Publisher sends events to one bus. A nil *Publisher is valid and drops
+// every event, so handlers built without one (tests) need no checks.
+type Publisher struct {
+	API API
+	Bus string
+
+	sleep func(time.Duration) // replaced in tests
+}
+
+// NewPublisher returns a publisher to the bus named in cfg.
+func NewPublisher(client API, cfg *config.Config) *Publisher {
+	return &Publisher{API: client, Bus: cfg.EventBusName}
+}
+
+// Publish sends evs in batches of up to ten. Entries EventBridge did not
+// accept are sent again, up to three times in all; the error reports the
+// events still unpublished after that, or the first call that failed
+// outright (the SDK has already retried those).
+func (p *Publisher) Publish(ctx context.Context, evs ...Event) error {
+	if p == nil || len(evs) == 0 {
+		return nil
+	}
+
+	entries := make([]ebtypes.PutEventsRequestEntry, len(evs))
+	for i, e := range evs {
+		detail, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("encoding %s event: %w", e.DetailType(), err)
+		}
+		entries[i] = ebtypes.PutEventsRequestEntry{
+			EventBusName: aws.String(p.Bus),
+			Source:       aws.String(Source),
+			DetailType:   aws.String(e.DetailType()),
+			Detail:       aws.String(string(detail)),
+			Time:         aws.Time(time.Now()),
+		}
+	}
+
+	var errs []error
+	for start := 0; start < len(entries); start += maxBatch {
+		batch := entries[start:min(start+maxBatch, len(entries))]
+		if err := p.putBatch(ctx, batch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// putBatch sends one batch, resending the entries that failed.
+func (p *Publisher) putBatch(ctx context.Context, batch []ebtypes.PutEventsRequestEntry) error {
+	sleep := p.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		out, err := p.API.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: batch})
+		if err != nil {
+			return fmt.Errorf("publishing %s: %w", detailTypes(batch), err)
+		}
+		if out.FailedEntryCount == 0 {
+			return nil
+		}
+
+		// Results are in the order of the entries
+		var failed []ebtypes.PutEventsRequestEntry
+		var reason string
+		for i, res := range out.Entries {
+			if res.ErrorCode != nil && i < len(batch) {
+				failed = append(failed, batch[i])
+				reason = aws.ToString(res.ErrorCode) + ": " + aws.ToString(res.ErrorMessage)
+			}
+		}
+		if len(failed) == 0 || attempt == maxAttempts || ctx.Err() != nil {
+			return fmt.Errorf("%d events rejected (%s), last error %s", out.FailedEntryCount, detailTypes(failed), reason)
+		}
+		batch = failed
+		sleep(delay)
+		delay *= 2
+	}
+}
+
+// Emit publishes evs and logs, rather than returns, a failure. Callers use
+// it after the change the events describe has been stored: failing the
+// request then would only make the client retry a change that succeeded.
+func (p *Publisher) Emit(ctx context.Context, evs ...Event) {
+	if err := p.Publish(ctx, evs...); err != nil {
+		types := make([]string, len(evs))
+		for i, e := range evs {
+			types[i] = e.DetailType()
+		}
+		slog.ErrorContext(ctx, "Failed to publish events", "detail_types", types, logging.Err(err))
+	}
+}
+
+// detailTypes lists the detail types of entries, for error messages.
+func detailTypes(entries []ebtypes.PutEventsRequestEntry) string {
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = aws.ToString(e.DetailType)
+	}
+	return strings.Join(names, ", ")
+}
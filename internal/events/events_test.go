@@ -0,0 +1,158 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// fakeBus records the detail types of each PutEvents call and rejects
+// entries whose detail type is in reject, for that many calls.
+type fakeBus struct {
+	calls  [][]string
+	reject map[string]int
+	err    error
+}
+
+func (f *fakeBus) PutEvents(_ context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	var types []string
+	out := &eventbridge.PutEventsOutput{}
+	for _, e := range in.Entries {
+		dt := aws.ToString(e.DetailType)
+		types = append(types, dt)
+		if f.reject[dt] > 0 {
+			f.reject[dt]--
+			out.FailedEntryCount++
+			out.Entries = append(out.Entries, ebtypes.PutEventsResultEntry{
+				ErrorCode:    aws.String("ThrottlingException"),
+				ErrorMessage: aws.String("rate exceeded"),
+			})
+			continue
+		}
+		out.Entries = append(out.Entries, ebtypes.PutEventsResultEntry{EventId: aws.String("id")})
+	}
+	f.calls = append(f.calls, types)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return out, nil
+}
+
+func TestPublish(t *testing.T) {
+	deleted := func(n int) []Event {
+		evs := make([]Event, n)
+		for i := range evs {
+			evs[i] = UserDeleted{UserID: "u1"}
+		}
+		return evs
+	}
+	repeat := func(s string, n int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = s
+		}
+		return out
+	}
+
+	tests := []struct {
+		name      string
+		events    []Event
+		reject    map[string]int
+		err       error
+		wantCalls [][]string
+		wantErr   bool
+	}{
+		{
+			name:      "one event",
+			events:    []Event{UserCreated{UserID: "u1"}},
+			wantCalls: [][]string{{"UserCreated"}},
+		},
+		{
+			name:      "batches of ten",
+			events:    deleted(12),
+			wantCalls: [][]string{repeat("UserDeleted", 10), repeat("UserDeleted", 2)},
+		},
+		{
+			name:      "failed entries are resent",
+			events:    []Event{UserCreated{UserID: "u1"}, ProfileUpdated{UserID: "u1"}},
+			reject:    map[string]int{"ProfileUpdated": 1},
+			wantCalls: [][]string{{"UserCreated", "ProfileUpdated"}, {"ProfileUpdated"}},
+		},
+		{
+			name:      "gives up after three attempts",
+			events:    []Event{ProfileUpdated{UserID: "u1"}},
+			reject:    map[string]int{"ProfileUpdated": 5},
+			wantCalls: [][]string{{"ProfileUpdated"}, {"ProfileUpdated"}, {"ProfileUpdated"}},
+			wantErr:   true,
+		},
+		{
+			name:      "call errors are not retried",
+			events:    []Event{SessionRevoked{UserID: "u1"}},
+			err:       errors.New("unreachable"),
+			wantCalls: [][]string{{"SessionRevoked"}},
+			wantErr:   true,
+		},
+		{
+			name: "no events",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := &fakeBus{reject: tt.reject, err: tt.err}
+			p := &Publisher{API: bus, Bus: "bus", sleep: func(time.Duration) {}}
+
+			err := p.Publish(context.Background(), tt.events...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(bus.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", bus.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPublishEntry(t *testing.T) {
+	var got *eventbridge.PutEventsInput
+	p := &Publisher{API: recorder(func(in *eventbridge.PutEventsInput) { got = in }), Bus: "troggle"}
+
+	ev := NotificationPreferencesChanged{UserID: "u1", Notifications: map[string]any{"push_enabled": false}, Changed: []string{"push_enabled"}}
+	if err := p.Publish(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	e := got.Entries[0]
+	if aws.ToString(e.EventBusName) != "troggle" || aws.ToString(e.Source) != Source ||
+		aws.ToString(e.DetailType) != "NotificationPreferencesChanged" {
+		t.Errorf("entry = %s/%s/%s", aws.ToString(e.EventBusName), aws.ToString(e.Source), aws.ToString(e.DetailType))
+	}
+	var detail map[string]any
+	if err := json.Unmarshal([]byte(aws.ToString(e.Detail)), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail["user_id"] != "u1" || !reflect.DeepEqual(detail["changed"], []any{"push_enabled"}) {
+		t.Errorf("detail = %v", detail)
+	}
+}
+
+func TestNilPublisher(t *testing.T) {
+	var p *Publisher
+	if err := p.Publish(context.Background(), UserCreated{UserID: "u1"}); err != nil {
+		t.Errorf("nil publisher: %v", err)
+	}
+	p.Emit(context.Background(), UserCreated{UserID: "u1"})
+}
+
+// recorder is an API that accepts everything and passes each call to f.
+type recorder func(*eventbridge.PutEventsInput)
+
+func (r recorder) PutEvents(_ context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	r(in)
+	return &eventbridge.PutEventsOutput{}, nil
+}
@@ -0,0 +1,177 @@
+package exports
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"troggle-backend/internal/config"
+)
+
+// Zip encodes b as a zip archive holding data.json, the whole bundle, and
+// one CSV file per section for spreadsheet users.
+func (b *Bundle) Zip() ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	add := func(name string, body []byte) error {
+		f, err := w.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(body)
+		return err
+	}
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding bundle: %w", err)
+	}
+	if err := add("data.json", data); err != nil {
+		return nil, err
+	}
+
+	sections := []struct {
+		name    string
+		records []map[string]any
+	}{
+		{"profile.csv", single(b.Profile)},
+		{"preferences.csv", single(b.Preferences)},
+		{"sessions.csv", b.Sessions},
+		{"devices.csv", b.Devices},
+		{"email_suppression.csv", single(b.Suppression)},
+		{"audit_log.csv", b.AuditLog},
+	}
+	for _, s := range sections {
+		body, err := toCSV(s.records)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", s.name, err)
+		}
+		if err := add(s.name, body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// single returns the section of one record, or none.
+func single(rec map[string]any) []map[string]any {
+	if rec == nil {
+		return nil
+	}
+	return []map[string]any{rec}
+}
+
+// toCSV writes records as CSV, one column per attribute in name order.
+// Strings are written as they are and anything else as JSON.
+func toCSV(records []map[string]any) ([]byte, error) {
+	var columns []string
+	for _, rec := range records {
+		for name := range rec {
+			if !slices.Contains(columns, name) {
+				columns = append(columns, name)
+			}
+		}
+	}
+	slices.Sort(columns)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(columns); err != nil {
+		return nil, err
+	}
+	row := make([]string, len(columns))
+	for _, rec := range records {
+		for i, name := range columns {
+			switch v := rec[name].(type) {
+			case nil:
+				row[i] = ""
+			case string:
+				row[i] = v
+			default:
+				b, err := json.Marshal(v)
+				if err != nil {
+					return nil, err
+				}
+				row[i] = string(b)
+			}
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// ObjectAPI is the part of the S3 API the archive uses.
+type ObjectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// PresignAPI is the part of the S3 presign client the archive uses.
+type PresignAPI interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// Archive stores bundles in the export bucket and links to them.
+type Archive struct {
+	S3      ObjectAPI
+	Presign PresignAPI
+	Bucket  string
+	URLTTL  time.Duration // lifetime of download links
+}
+
+// NewArchive returns the archive over the bucket named in cfg.
+func NewArchive(client *s3.Client, cfg *config.Config) *Archive {
+	return &Archive{S3: client, Presign: s3.NewPresignClient(client), Bucket: cfg.ExportBucket, URLTTL: cfg.ExportURLTTL}
+}
+
+// ObjectKey names the bundle of job; retried uploads overwrite it.
+func ObjectKey(job Job) string {
+	return "exports/" + job.UserID + "/" + job.ExportID + ".zip"
+}
+
+// Upload stores the bundle of job, encrypted at rest with the bucket's key,
+// and returns its object key.
+func (a *Archive) Upload(ctx context.Context, job Job, bundle []byte) (string, error) {
+	key := ObjectKey(job)
+	_, err := a.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(a.Bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(bundle),
+		ContentType:          aws.String("application/zip"),
+		ContentDisposition:   aws.String(`attachment; filename="troggle-export.zip"`),
+		ServerSideEncryption: s3types.ServerSideEncryptionAwsKms,
+	})
+	if err != nil {
+		return "", fmt.Errorf("uploading export: %w", err)
+	}
+	return key, nil
+}
+
+// URL returns a link downloading the object key until expires.
+func (a *Archive) URL(ctx context.Context, key string) (url string, expires time.Time, err error) {
+	expires = time.Now().Add(a.URLTTL).UTC().Truncate(time.Second)
+	req, err := a.Presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(a.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(a.URLTTL))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("presigning export: %w", err)
+	}
+	return req.URL, expires, nil
+}
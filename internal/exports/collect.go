@@ -0,0 +1,136 @@
+package exports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/users"
+)
+
+// ErrNoUser is returned by Collect when the user does not exist.
+var ErrNoUser = errors.New("user not found")
+
+// withheld attributes are secrets or internal references rather than data
+// about the user, and are left out of exports.
+var withheld = map[string]bool{
+	"mfa_secret":   true,
+	"endpoint_arn": true, // SNS endpoint of a device token
+}
+
+// Bundle is everything stored about a user. Sections are the raw records of
+// each table, so attributes added later are exported without changes here.
+type Bundle struct {
+	UserID      string           `json:"user_id"`
+	GeneratedAt string           `json:"generated_at"` // RFC 3339
+	Profile     map[string]any   `json:"profile"`
+	Preferences map[string]any   `json:"preferences,omitempty"`
+	Sessions    []map[string]any `json:"sessions"`
+	Devices     []map[string]any `json:"devices"`
+	Suppression map[string]any   `json:"email_suppression,omitempty"`
+	AuditLog    []map[string]any `json:"audit_log"`
+}
+
+// Collector gathers bundles from the tables named in Config.
+type Collector struct {
+	DB     *db.Client
+	Config *config.Config
+}
+
+// Collect reads every record about userID. The reads are not a snapshot: a
+// change made while they run may or may not be included.
+func (c *Collector) Collect(ctx context.Context, userID string) (*Bundle, error) {
+	cfg := c.Config
+	user, err := c.DB.GetItem(ctx, cfg.UserTableName, users.Key(userID))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrNoUser
+	}
+
+	b := &Bundle{UserID: userID, GeneratedAt: time.Now().UTC().Format(time.RFC3339)}
+	if b.Profile, err = decode(user); err != nil {
+		return nil, err
+	}
+	if b.Sessions, err = c.query(ctx, cfg.SessionTableName, "user_id", userID); err != nil {
+		return nil, err
+	}
+	if b.Devices, err = c.query(ctx, cfg.DeviceTableName, "user_id", userID); err != nil {
+		return nil, err
+	}
+	if b.AuditLog, err = c.query(ctx, cfg.AuditTableName, "resource", audit.UserResource(userID)); err != nil {
+		return nil, err
+	}
+	if b.Preferences, err = c.get(ctx, cfg.PreferenceTableName, db.Item{"user_id": user["user_id"]}); err != nil {
+		return nil, err
+	}
+	if email, ok := user["email"].(*types.AttributeValueMemberS); ok {
+		if b.Suppression, err = c.get(ctx, cfg.SuppressionTableName, db.Item{"email": email}); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// get returns the decoded item of table with key, or nil if there is none.
+func (c *Collector) get(ctx context.Context, table string, key db.Item) (map[string]any, error) {
+	item, err := c.DB.GetItem(ctx, table, key)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	return decode(item)
+}
+
+// query returns the decoded items of table whose partition key name equals
+// value, in key order.
+func (c *Collector) query(ctx context.Context, table, name, value string) ([]map[string]any, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(table),
+		KeyConditionExpression:   aws.String("#pk = :pk"),
+		ExpressionAttributeNames: map[string]string{"#pk": name},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: value},
+		},
+	}
+	records := []map[string]any{}
+	var decodeErr error
+	err := c.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		for _, item := range items {
+			var rec map[string]any
+			if rec, decodeErr = decode(item); decodeErr != nil {
+				return false
+			}
+			records = append(records, rec)
+		}
+		return true
+	})
+	if err == nil && decodeErr != nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// decode converts item to plain values, without the withheld attributes.
+func decode(item db.Item) (map[string]any, error) {
+	var rec map[string]any
+	if err := attributevalue.UnmarshalMap(item, &rec); err != nil {
+		return nil, fmt.Errorf("decoding item: %w", err)
+	}
+	for name := range withheld {
+		delete(rec, name)
+	}
+	return rec, nil
+}
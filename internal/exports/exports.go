@@ -0,0 +1,166 @@
+// Package exports produces the copy of their data users are entitled to
+// under the GDPR right of access.
+//
+// An export is a job: the exportUserData endpoint records it in the export
+// table as pending and queues it, its worker collects the user's data from
+// every table (Collect), writes the bundle to the export bucket and marks
+// the job ready, and the endpoint then hands out presigned links to the
+// bundle. Jobs and bundles are removed after Retention, the jobs by the
+// table's TTL and the bundles by a lifecycle rule on the bucket's
+// "exports/" prefix.
+package exports
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+// Retention is how long jobs and their bundles are kept.
+const Retention = 7 * 24 * time.Hour
+
+// Job statuses.
+const (
+	StatusPending = "pending" // queued
+	StatusRunning = "running" // being collected; retried deliveries stay here
+	StatusReady   = "ready"   // the bundle can be downloaded
+	StatusFailed  = "failed"  // gave up; the user may request a new export
+)
+
+// Job is one export of a user's data.
+type Job struct {
+	UserID      string `json:"user_id"`
+	ExportID    string `json:"export_id"`
+	Status      string `json:"status"`
+	RequestedAt string `json:"requested_at"`           // RFC 3339
+	CompletedAt string `json:"completed_at,omitempty"` // RFC 3339
+	Error       string `json:"error,omitempty"`        // why the export failed, for the user
+	Key         string `json:"-"`                      // object key of the bundle
+}
+
+// NewID returns a random export ID.
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// record is an item of the export table.
+type record struct {
+	UserID      string `dynamodbav:"user_id"`
+	ExportID    string `dynamodbav:"export_id"`
+	Status      string `dynamodbav:"status"`
+	RequestedAt string `dynamodbav:"requested_at"`
+	CompletedAt string `dynamodbav:"completed_at,omitempty"`
+	Error       string `dynamodbav:"error,omitempty"`
+	Key         string `dynamodbav:"object_key,omitempty"`
+	ExpiresAt   int64  `dynamodbav:"expires_at"` // Unix seconds, the TTL attribute
+}
+
+func (r *record) job() Job {
+	return Job{
+		UserID:      r.UserID,
+		ExportID:    r.ExportID,
+		Status:      r.Status,
+		RequestedAt: r.RequestedAt,
+		CompletedAt: r.CompletedAt,
+		Error:       r.Error,
+		Key:         r.Key,
+	}
+}
+
+// Store reads and writes export jobs.
+type Store struct {
+	DB    *db.Client
+	Table string
+}
+
+// NewStore returns a store over the export table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.ExportTableName}
+}
+
+// Create records a new pending export of userID and returns it.
+func (s *Store) Create(ctx context.Context, userID string) (Job, error) {
+	now := time.Now().UTC()
+	job := Job{
+		UserID:      userID,
+		ExportID:    NewID(),
+		Status:      StatusPending,
+		RequestedAt: now.Format(time.RFC3339),
+	}
+	item, err := attributevalue.MarshalMap(record{
+		UserID:      job.UserID,
+		ExportID:    job.ExportID,
+		Status:      job.Status,
+		RequestedAt: job.RequestedAt,
+		ExpiresAt:   now.Add(Retention).Unix(),
+	})
+	if err != nil {
+		return Job{}, fmt.Errorf("encoding export: %w", err)
+	}
+	if err := s.DB.PutItem(ctx, s.Table, item); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// Get returns the export, or nil if there is no such export.
+func (s *Store) Get(ctx context.Context, userID, exportID string) (*Job, error) {
+	item, err := s.DB.GetItem(ctx, s.Table, key(userID, exportID))
+	if err != nil || item == nil {
+		return nil, err
+	}
+	var rec record
+	if err := attributevalue.UnmarshalMap(item, &rec); err != nil {
+		return nil, fmt.Errorf("decoding export: %w", err)
+	}
+	job := rec.job()
+	return &job, nil
+}
+
+// Update stores the status, bundle key, completion time and error of job. A
+// missing export is an apperr.NotFound error.
+func (s *Store) Update(ctx context.Context, job Job) error {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 key(job.UserID, job.ExportID),
+		UpdateExpression:    aws.String("SET #status = :status, object_key = :key, completed_at = :completed_at, #error = :error"),
+		ConditionExpression: aws.String("attribute_exists(export_id)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status", // reserved word
+			"#error":  "error",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":       &types.AttributeValueMemberS{Value: job.Status},
+			":key":          &types.AttributeValueMemberS{Value: job.Key},
+			":completed_at": &types.AttributeValueMemberS{Value: job.CompletedAt},
+			":error":        &types.AttributeValueMemberS{Value: job.Error},
+		},
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return apperr.NotFound("Export not found")
+	}
+	return db.Wrap(err, "updating export")
+}
+
+// key returns the primary key of an export item.
+func key(userID, exportID string) db.Item {
+	return db.Item{
+		"user_id":   &types.AttributeValueMemberS{Value: userID},
+		"export_id": &types.AttributeValueMemberS{Value: exportID},
+	}
+}
@@ -0,0 +1,114 @@
+package exports
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func TestToCSV(t *testing.T) {
+	got, err := toCSV([]map[string]any{
+		{"session_id": "s1", "ip": "192.0.2.1"},
+		{"session_id": "s2", "expires_at": 1700000000.0, "tags": []any{"a", "b"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "expires_at,ip,session_id,tags\n,192.0.2.1,s1,\n1700000000,,s2,\"[\"\"a\"\",\"\"b\"\"]\"\n"
+	if string(got) != want {
+		t.Errorf("csv = %q, want %q", got, want)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	cfg := &config.Config{
+		UserTableName:        "users",
+		SessionTableName:     "sessions",
+		DeviceTableName:      "devices",
+		PreferenceTableName:  "preferences",
+		SuppressionTableName: "suppressions",
+		AuditTableName:       "audit",
+	}
+	tests := []struct {
+		name    string
+		user    db.Item
+		wantErr error
+	}{
+		{name: "user", user: dbtest.Item("user_id", "u1", "email", "jane@example.com", "mfa_secret", "s3cret")},
+		{name: "no user", wantErr: ErrNoUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					if aws.ToString(in.TableName) == "users" {
+						return &dynamodb.GetItemOutput{Item: tt.user}, nil
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if aws.ToString(in.TableName) == "sessions" {
+						return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1", "session_id", "s1")}}, nil
+					}
+					return &dynamodb.QueryOutput{}, nil
+				},
+			}
+			c := &Collector{DB: m.Client(), Config: cfg}
+
+			b, err := c.Collect(context.Background(), "u1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if _, ok := b.Profile["mfa_secret"]; ok {
+				t.Error("mfa_secret exported")
+			}
+			if len(b.Sessions) != 1 || b.Sessions[0]["session_id"] != "s1" {
+				t.Errorf("sessions = %v", b.Sessions)
+			}
+			if b.Devices == nil || b.AuditLog == nil {
+				t.Error("empty sections should be empty lists")
+			}
+		})
+	}
+}
+
+func TestZip(t *testing.T) {
+	b := &Bundle{UserID: "u1", Profile: map[string]any{"user_id": "u1"}, Sessions: []map[string]any{}}
+	body, err := b.Zip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	want := []string{"data.json", "profile.csv", "preferences.csv", "sessions.csv", "devices.csv", "email_suppression.csv", "audit_log.csv"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+
+	f, _ := r.File[1].Open()
+	profile, _ := io.ReadAll(f)
+	if !strings.HasPrefix(string(profile), "user_id\nu1\n") {
+		t.Errorf("profile.csv = %q", profile)
+	}
+}
@@ -0,0 +1,59 @@
+package exports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/config"
+)
+
+// SQSAPI is the subset of the SQS client used to queue exports.
+type SQSAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// Message is the queue message of an export job.
+type Message struct {
+	UserID   string `json:"user_id"`
+	ExportID string `json:"export_id"`
+}
+
+// Validate reports whether m names a job.
+func (m *Message) Validate() error {
+	if m.UserID == "" || m.ExportID == "" {
+		return errors.New("user_id and export_id are required")
+	}
+	return nil
+}
+
+// Queue puts export jobs on the export queue for the worker.
+type Queue struct {
+	SQS SQSAPI
+	URL string
+}
+
+// NewQueue returns the queue configured in cfg.
+func NewQueue(client SQSAPI, cfg *config.Config) *Queue {
+	return &Queue{SQS: client, URL: cfg.ExportQueueURL}
+}
+
+// Enqueue queues job.
+func (q *Queue) Enqueue(ctx context.Context, job Job) error {
+	body, err := json.Marshal(Message{UserID: job.UserID, ExportID: job.ExportID})
+	if err != nil {
+		return fmt.Errorf("encoding export message: %w", err)
+	}
+	_, err = q.SQS.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.URL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("enqueueing export: %w", err)
+	}
+	return nil
+}
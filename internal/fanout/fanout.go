@@ -0,0 +1,123 @@
+// Package fanout runs independent reads in parallel: the items of several
+// tables or indexes a response is assembled from, the shards of a
+// leaderboard, one lookup per user of a batch. Reads are bounded, so a
+// large batch cannot exhaust a table's capacity or the container's
+// connections, and the first failure cancels the others, so a response
+// that cannot be assembled fails as early as possible.
+//
+// A profile page, say, reads three tables at once:
+//
+//	g := fanout.New(ctx, fanout.DefaultLimit)
+//	user := fanout.Fetch(g, func(ctx context.Context) (db.Item, error) { return repo.Get(ctx, id, nil) })
+//	prefs := fanout.Fetch(g, func(ctx context.Context) (preferences.Preferences, error) { ... })
+//	player := fanout.Fetch(g, func(ctx context.Context) (matches.Player, error) { ... })
+//	if err := g.Wait(); err != nil {
+//		return httpx.Response{}, err
+//	}
+//	// user.Value(), prefs.Value() and player.Value() are set
+package fanout
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultLimit bounds the reads of a Group in flight at once when callers
+// have no better bound. It is well below the SDK's connection pool.
+const DefaultLimit = 10
+
+// Group runs reads in parallel, at most limit at a time. Its context is
+// cancelled by the first failure, which Wait returns.
+type Group struct {
+	g   *errgroup.Group
+	ctx context.Context
+}
+
+// New returns a Group whose reads run under a context derived from ctx. A
+// limit of zero or less leaves them unbounded.
+func New(ctx context.Context, limit int) *Group {
+	g, gctx := errgroup.WithContext(ctx)
+	if limit > 0 {
+		g.SetLimit(limit)
+	}
+	return &Group{g: g, ctx: gctx}
+}
+
+// Go runs read in the group, waiting for a slot while limit reads are in
+// flight. Reads started after the group failed return at once.
+func (g *Group) Go(read func(ctx context.Context) error) {
+	g.g.Go(func() error {
+		if err := g.ctx.Err(); err != nil {
+			return err
+		}
+		return read(g.ctx)
+	})
+}
+
+// Wait waits for every read of the group and returns the first failure.
+func (g *Group) Wait() error {
+	return g.g.Wait()
+}
+
+// Result is the value of a read started by Fetch.
+type Result[T any] struct {
+	value T
+}
+
+// Value returns the value read. It is only set once Wait returned nil.
+func (r *Result[T]) Value() T {
+	return r.value
+}
+
+// Fetch runs read in g and returns its result, to be read after g.Wait.
+func Fetch[T any](g *Group, read func(ctx context.Context) (T, error)) *Result[T] {
+	r := &Result[T]{}
+	g.Go(func(ctx context.Context) error {
+		v, err := read(ctx)
+		if err != nil {
+			return err
+		}
+		r.value = v
+		return nil
+	})
+	return r
+}
+
+// Each calls read with each of keys in parallel, at most limit at a time,
+// and returns the first failure.
+func Each[K any](ctx context.Context, limit int, keys []K, read func(ctx context.Context, key K) error) error {
+	g := New(ctx, limit)
+	for _, k := range keys {
+		g.Go(func(ctx context.Context) error { return read(ctx, k) })
+	}
+	return g.Wait()
+}
+
+// Map calls read with each of keys in parallel, at most limit at a time,
+// and returns the values read by key, or the first failure.
+func Map[K comparable, V any](ctx context.Context, limit int, keys []K, read func(ctx context.Context, key K) (V, error)) (map[K]V, error) {
+	values := make([]V, len(keys))
+	err := Each(ctx, limit, indexes(len(keys)), func(ctx context.Context, i int) error {
+		v, err := read(ctx, keys[i])
+		values[i] = v
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[K]V, len(keys))
+	for i, k := range keys {
+		out[k] = values[i]
+	}
+	return out, nil
+}
+
+// indexes returns 0 through n-1.
+func indexes(n int) []int {
+	out := make([]int, n)
+	for i := range out {
+		out[i] = i
+	}
+	return out
+}
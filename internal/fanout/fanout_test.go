@@ -0,0 +1,84 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	g := New(context.Background(), DefaultLimit)
+	name := Fetch(g, func(context.Context) (string, error) { return "jane", nil })
+	count := Fetch(g, func(context.Context) (int, error) { return 3, nil })
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if name.Value() != "jane" || count.Value() != 3 {
+		t.Errorf("values = %q, %d", name.Value(), count.Value())
+	}
+}
+
+func TestFirstFailureCancels(t *testing.T) {
+	errRead := errors.New("read failed")
+	g := New(context.Background(), 0)
+	g.Go(func(context.Context) error { return errRead })
+	g.Go(func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Minute):
+			return errors.New("not cancelled")
+		}
+	})
+	if err := g.Wait(); !errors.Is(err, errRead) {
+		t.Errorf("err = %v, want %v", err, errRead)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	keys := make([]int, 50)
+	err := Each(context.Background(), 3, keys, func(context.Context, int) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p > 3 {
+		t.Errorf("%d reads in flight, want at most 3", p)
+	}
+}
+
+func TestMap(t *testing.T) {
+	got, err := Map(context.Background(), 2, []string{"a", "bb", "ccc"}, func(_ context.Context, k string) (int, error) {
+		return len(k), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got["a"] != 1 || got["ccc"] != 3 {
+		t.Errorf("Map = %v", got)
+	}
+
+	errRead := errors.New("read failed")
+	_, err = Map(context.Background(), 2, []string{"a", "b"}, func(_ context.Context, k string) (int, error) {
+		if k == "b" {
+			return 0, errRead
+		}
+		return 1, nil
+	})
+	if !errors.Is(err, errRead) {
+		t.Errorf("err = %v, want %v", err, errRead)
+	}
+}
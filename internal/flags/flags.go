@@ -0,0 +1,130 @@
+// Package flags evaluates feature flags, so a change can ship dark and be
+// turned on for some users before everyone:
+//
+//	if flags.Enabled(ctx, "new_profile_api") { ... }
+//
+// Flags are parameters of package dynconfig named flags/<name>. A value of
+// "true" or "false" turns a flag on or off for everyone; otherwise it is a
+// JSON Flag turning it on for listed users, for members of segments (Cognito
+// groups or roles, e.g. "beta"), and for a percentage of all other users.
+// Users are assigned to the percentage by a hash of their ID and the flag's
+// name, so each user keeps their answer as the rollout grows and different
+// flags reach different users. Callers without an identity only see flags
+// that are on for everyone. Unknown and malformed flags are off.
+//
+// Each result is attached to the request's log lines as flag.<name>, so a
+// request can be debugged knowing which code paths it took.
+package flags
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/dynconfig"
+	"troggle-backend/internal/logging"
+)
+
+// prefix starts the dynconfig names of flags.
+const prefix = "flags/"
+
+// Flag is the definition of a flag that is on for some users.
+type Flag struct {
+	Percentage float64  `json:"percentage,omitempty"` // share of users it is on for, 0 to 100
+	Segments   []string `json:"segments,omitempty"`   // groups or roles it is on for
+	Users      []string `json:"users,omitempty"`      // user IDs it is on for
+}
+
+// Parse decodes the value of a flag parameter.
+func Parse(v string) (Flag, error) {
+	if on, err := strconv.ParseBool(v); err == nil {
+		if on {
+			return Flag{Percentage: 100}, nil
+		}
+		return Flag{}, nil
+	}
+	var f Flag
+	if err := json.Unmarshal([]byte(v), &f); err != nil {
+		return Flag{}, fmt.Errorf("decoding flag: %w", err)
+	}
+	if f.Percentage < 0 || f.Percentage > 100 {
+		return Flag{}, fmt.Errorf("percentage %v out of range", f.Percentage)
+	}
+	return f, nil
+}
+
+// On reports whether the flag named name is on for id, which is nil for
+// anonymous callers.
+func (f Flag) On(name string, id *auth.Identity) bool {
+	if f.Percentage >= 100 {
+		return true
+	}
+	if id == nil {
+		return false
+	}
+	if slices.Contains(f.Users, id.Subject) || slices.ContainsFunc(f.Segments, id.HasRole) {
+		return true
+	}
+	return bucket(name, id.Subject) < f.Percentage
+}
+
+// bucket places a user at a stable point from 0 up to 100 for the named
+// flag.
+func bucket(name, userID string) float64 {
+	sum := sha256.Sum256([]byte(name + "/" + userID))
+	return float64(binary.BigEndian.Uint64(sum[:8])%10000) / 100
+}
+
+// Set evaluates the flags of a dynconfig store.
+type Set struct {
+	Params *dynconfig.Store // nil turns every flag off
+}
+
+// Enabled reports whether the named flag is on for the caller in ctx, and
+// attaches the result to the log lines of the request.
+func (s *Set) Enabled(ctx context.Context, name string) bool {
+	on := s.enabled(ctx, name)
+	logging.Add(ctx, slog.Bool("flag."+name, on))
+	return on
+}
+
+func (s *Set) enabled(ctx context.Context, name string) bool {
+	v := s.Params.String(ctx, prefix+name, "")
+	if v == "" {
+		return false
+	}
+	f, err := Parse(v)
+	if err != nil {
+		slog.WarnContext(ctx, "Ignoring invalid flag", "flag", name, logging.Err(err))
+		return false
+	}
+	id, _ := auth.FromContext(ctx)
+	return f.On(name, id)
+}
+
+// std is the Set of Enabled.
+var std Set
+
+// Init points Enabled at the container's dynconfig store. Functions
+// evaluating flags call it at cold start; until then every flag is off.
+func Init(ctx context.Context, cfg *config.Config) error {
+	params, err := dynconfig.Shared(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	std.Params = params
+	return nil
+}
+
+// Enabled reports whether the named flag is on for the caller in ctx; see
+// Set.Enabled.
+func Enabled(ctx context.Context, name string) bool {
+	return std.Enabled(ctx, name)
+}
@@ -0,0 +1,107 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/dynconfig"
+)
+
+// parameters answers parameter reads from values, keyed by name below the
+// path.
+type parameters map[string]string
+
+func (p parameters) GetParametersByPath(_ context.Context, in *ssm.GetParametersByPathInput, _ ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	var out ssm.GetParametersByPathOutput
+	for name, value := range p {
+		out.Parameters = append(out.Parameters, ssmtypes.Parameter{Name: aws.String(aws.ToString(in.Path) + name), Value: aws.String(value)})
+	}
+	return &out, nil
+}
+
+func TestEnabled(t *testing.T) {
+	s := &Set{Params: &dynconfig.Store{API: parameters{
+		"flags/everyone": "true",
+		"flags/nobody":   "false",
+		"flags/beta":     `{"segments":["beta"],"users":["u-listed"]}`,
+		"flags/half":     `{"percentage":50}`,
+		"flags/broken":   `{"percentage":"lots"}`,
+	}, Path: "/troggle/test/", TTL: time.Minute}}
+
+	user := &auth.Identity{Subject: "u1"}
+	tester := &auth.Identity{Subject: "u2", Groups: []string{"beta"}}
+	listed := &auth.Identity{Subject: "u-listed"}
+
+	tests := []struct {
+		flag   string
+		caller *auth.Identity
+		want   bool
+	}{
+		{"everyone", nil, true},
+		{"everyone", user, true},
+		{"nobody", tester, false},
+		{"beta", user, false},
+		{"beta", tester, true},
+		{"beta", listed, true},
+		{"beta", nil, false},
+		{"half", nil, false},
+		{"broken", tester, false},
+		{"unknown", tester, false},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.caller != nil {
+			ctx = auth.NewContext(ctx, tt.caller)
+		}
+		if got := s.Enabled(ctx, tt.flag); got != tt.want {
+			t.Errorf("Enabled(%s) for %v = %v, want %v", tt.flag, tt.caller, got, tt.want)
+		}
+	}
+}
+
+func TestPercentage(t *testing.T) {
+	f, err := Parse(`{"percentage":25}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	on := 0
+	for i := range 10000 {
+		id := &auth.Identity{Subject: fmt.Sprintf("user-%d", i)}
+		if f.On("rollout", id) {
+			on++
+		}
+	}
+	if on < 2300 || on > 2700 {
+		t.Errorf("on for %d of 10000 users, want about 2500", on)
+	}
+
+	// Growing the rollout keeps users who had the flag
+	wider := Flag{Percentage: 50}
+	for i := range 1000 {
+		id := &auth.Identity{Subject: fmt.Sprintf("user-%d", i)}
+		if f.On("rollout", id) && !wider.On("rollout", id) {
+			t.Fatalf("%s lost the flag when the rollout grew", id.Subject)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, v := range []string{`{"percentage":101}`, `{"percentage":-1}`, "yes please"} {
+		if _, err := Parse(v); err == nil {
+			t.Errorf("Parse(%q) succeeded", v)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	if (&Set{}).Enabled(context.Background(), "everyone") {
+		t.Error("set without a store: flag on")
+	}
+}
@@ -0,0 +1,131 @@
+// Package acceptfriendrequest accepts a pending friend request (POST
+// /users/{user_id}/friend-requests/{other_id}/accept, other_id being the
+// sender), publishing FriendRequestAccepted for the sender's notification
+// inbox. See package relationships.
+package acceptfriendrequest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge" // EventBridge client
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/events"        // domain event publishing
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the same values as path parameters.
+type Request struct {
+	UserID  string `json:"user_id"`  // the recipient of the request
+	OtherID string `json:"other_id"` // its sender
+}
+
+// authorize lets callers accept requests sent to them only, unless they
+// are admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only accept your own friend requests")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "acceptFriendRequest",
+	Function: "acceptFriendRequest",
+	Summary:  "Accepts a friend request",
+	Method:   "POST",
+	Path:     "/users/{user_id}/friend-requests/{other_id}/accept",
+	Status:   204,
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Graph  *relationships.Store
+	Users  *users.Repository
+	Events *events.Publisher
+	Auth   auth.TokenVerifier
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Graph:  relationships.NewStore(client, cfg),
+		Users:  users.NewRepository(client, cfg),
+		Events: events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Auth:   verifier,
+		Config: cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle turns the request into a friendship.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"], OtherID: r.PathParams["other_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	for _, id := range []string{req.UserID, req.OtherID} {
+		if err := validation.UserID(id); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	err := h.Graph.Accept(ctx, req.UserID, req.OtherID)
+	switch {
+	case errors.Is(err, relationships.ErrAlreadyFriends):
+		return httpx.Error(apperr.Conflict("Already friends")), nil
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	h.Users.Invalidate(req.UserID, "")
+	h.Users.Invalidate(req.OtherID, "")
+	slog.InfoContext(ctx, "Friend request accepted", "user_id", req.UserID, "other_id", req.OtherID)
+	h.Events.Emit(ctx, events.FriendRequestAccepted{UserID: req.OtherID, FriendID: req.UserID, AcceptedAt: events.Now()})
+	return httpx.NoContent(), nil
+}
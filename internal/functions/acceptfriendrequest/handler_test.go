@@ -0,0 +1,72 @@
+package acceptfriendrequest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/users"
+)
+
+// apiEvent is a POST /users/{user_id}/friend-requests/{other_id}/accept REST
+// API event.
+func apiEvent(userID, otherID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/friend-requests/" + otherID + "/accept",
+		"pathParameters": map[string]string{"user_id": userID, "other_id": otherID},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	none := "None"
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		txErr      error
+		wantStatus int
+	}{
+		{name: "accepted", payload: apiEvent("u1", "u2"), wantStatus: 204},
+		{
+			name: "no request", payload: apiEvent("u1", "u2"),
+			txErr:      dbtest.TransactionCanceled("ConditionalCheckFailed", none, none, none, none, none, none, none),
+			wantStatus: 404,
+		},
+		{
+			name: "already friends", payload: apiEvent("u1", "u2"),
+			txErr:      dbtest.TransactionCanceled(none, none, none, none, "ConditionalCheckFailed", none, none, none),
+			wantStatus: 409,
+		},
+		{name: "request to another user", payload: apiEvent("u2", "u3"), wantStatus: 403},
+		{name: "invalid sender", payload: apiEvent("u1", "a#b"), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				return &dynamodb.TransactWriteItemsOutput{}, tt.txErr
+			}}
+			h := &Handler{
+				Graph:  &relationships.Store{DB: m.Client(), Table: "relationships", UserTable: "users"},
+				Users:  &users.Repository{DB: m.Client(), Table: "users"},
+				Config: &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+		})
+	}
+}
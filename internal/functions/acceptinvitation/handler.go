@@ -0,0 +1,210 @@
+// Package acceptinvitation redeems the code of an invitation for the
+// caller's account (POST /invitations/accept), recording the inviter as
+// the account's referrer and crediting them with the referral. Only
+// accounts created after the invitation qualify, and each account accepts
+// one invitation. The InvitationAccepted event it publishes notifies the
+// inviter. See package invitations.
+package acceptinvitation
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge" // EventBridge client
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"       // audit log
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/events"      // domain event publishing
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/invitations" // invitations and referrals
+	"troggle-backend/internal/ratelimit"   // DynamoDB token buckets
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/users"       // user table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// rateLimits bound how often a caller tries codes. They can be tuned per
+// stage through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(20),
+	PerUser: ratelimit.PerMinute(10),
+}
+
+// Request represents the JSON input. API Gateway callers accept for the
+// account of their token.
+type Request struct {
+	UserID string `json:"user_id" api:"direct"` // the invitee
+	Code   string `json:"code"`
+}
+
+// Response represents the JSON output.
+type Response struct {
+	InvitationID string `json:"invitation_id"`
+	InviterID    string `json:"inviter_id"`
+	AcceptedAt   string `json:"accepted_at"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "acceptInvitation",
+	Function: "acceptInvitation",
+	Summary:  "Accepts an invitation, crediting the inviter with the referral",
+	Method:   "POST",
+	Path:     "/invitations/accept",
+	Request:  Request{},
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Invitations *invitations.Store
+	Codes       *invitations.Codec
+	Users       *users.Repository
+	Events      *events.Publisher
+	Auth        auth.TokenVerifier
+	Limiter     *ratelimit.Limiter
+	Limits      ratelimit.Policy
+	Audit       *audit.Store
+	Config      *config.Config
+	Now         func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	codes, err := invitations.NewCodecFromConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Invitations: invitations.NewStore(client, cfg),
+		Codes:       codes,
+		Users:       users.NewRepository(client, cfg),
+		Events:      events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Auth:        verifier,
+		Limiter:     ratelimit.New(client, cfg),
+		Limits:      limits,
+		Audit:       audit.NewStore(client, cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle accepts the invitation of the code in the body for the caller.
+// Forged and expired codes, and those of invitations that are gone, answer
+// 422 INVALID_INVITATION; invitations or accounts used already answer 409.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req.UserID = id.Subject
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	code, err := h.Codes.Decode(ctx, req.Code)
+	if apperr.KindOf(err) == apperr.KindInvalid {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if code.InviterID == req.UserID {
+		return httpx.Error(invitations.ErrOwnInvitation), nil
+	}
+	inv, err := h.Invitations.Get(ctx, code.InviterID, code.InvitationID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if inv == nil {
+		return httpx.Error(invitations.ErrInvalidCode), nil
+	}
+	if inv.Status == invitations.StatusAccepted {
+		return httpx.Error(invitations.ErrAccepted), nil
+	}
+
+	item, err := h.Users.Uncached().Get(ctx, req.UserID, []string{"created_at"})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if item == nil {
+		return httpx.Error(apperr.NotFound("User not found")), nil
+	}
+	invitee, err := db.Decode[users.User](item)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	// Both are RFC 3339 in UTC, which sort as strings
+	if invitee.CreatedAt < inv.CreatedAt {
+		return httpx.Error(invitations.ErrExistingAccount), nil
+	}
+
+	now := h.now().UTC().Truncate(time.Second)
+	err = h.Invitations.Accept(ctx, *inv, req.UserID, now)
+	switch kind := apperr.KindOf(err); {
+	case kind == apperr.KindInvalid, kind == apperr.KindConflict:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	h.Users.Invalidate(req.UserID, "")
+	h.Users.Invalidate(inv.InviterID, "")
+
+	acceptedAt := now.Format(time.RFC3339)
+	slog.InfoContext(ctx, "Invitation accepted", "user_id", req.UserID, "inviter_id", inv.InviterID, "invitation_id", inv.ID)
+	h.Events.Emit(ctx, events.InvitationAccepted{
+		UserID:       inv.InviterID,
+		InviteeID:    req.UserID,
+		InvitationID: inv.ID,
+		AcceptedAt:   acceptedAt,
+	})
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionInvitationAccept,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"referred_by": {After: inv.InviterID}},
+	})
+	return httpx.JSON(200, Response{InvitationID: inv.ID, InviterID: inv.InviterID, AcceptedAt: acceptedAt}), nil
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,178 @@
+package acceptinvitation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/events"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/invitations"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/signing"
+	"troggle-backend/internal/userdata"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeEvents records published events.
+type fakeEvents struct {
+	published []*eventbridge.PutEventsInput
+}
+
+func (e *fakeEvents) PutEvents(_ context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	e.published = append(e.published, in)
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+// apiEvent is a REST API event of the accept route.
+func apiEvent(code string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"code": code})
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/invitations/accept",
+		"headers":    map[string]string{"Authorization": "Bearer valid"},
+		"body":       string(body),
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	sent := now.Add(-48 * time.Hour).Format(time.RFC3339)
+	tests := []struct {
+		name       string
+		caller     string
+		inviterID  string
+		status     string // of the stored invitation; none when empty
+		createdAt  string // of the caller's account
+		txErr      error
+		wantStatus int
+	}{
+		{name: "accepted", caller: "u2", inviterID: "u1", status: invitations.StatusPending, createdAt: now.Add(-time.Hour).Format(time.RFC3339), wantStatus: 200},
+		{name: "own invitation", caller: "u1", inviterID: "u1", status: invitations.StatusPending, createdAt: now.Format(time.RFC3339), wantStatus: 422},
+		{name: "missing invitation", caller: "u2", inviterID: "u1", createdAt: now.Format(time.RFC3339), wantStatus: 422},
+		{name: "used invitation", caller: "u2", inviterID: "u1", status: invitations.StatusAccepted, createdAt: now.Format(time.RFC3339), wantStatus: 409},
+		{name: "existing account", caller: "u2", inviterID: "u1", status: invitations.StatusPending, createdAt: "2025-01-01T00:00:00Z", wantStatus: 422},
+		{name: "referred account", caller: "u2", inviterID: "u1", status: invitations.StatusPending, createdAt: now.Format(time.RFC3339),
+			txErr: dbtest.TransactionCanceled("None", "ConditionalCheckFailed", "None"), wantStatus: 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					if aws.ToString(in.TableName) == "users" {
+						return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", tt.caller, "created_at", tt.createdAt)}, nil
+					}
+					if tt.status == "" {
+						return &dynamodb.GetItemOutput{}, nil
+					}
+					item, err := userdata.Marshal(userdata.Invitation, tt.inviterID, userdata.InviteSK("i1"), invitations.Invitation{
+						ID: "i1", InviterID: tt.inviterID, Email: "sam@example.com", Status: tt.status, CreatedAt: sent,
+					})
+					return &dynamodb.GetItemOutput{Item: item}, err
+				},
+				TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					return &dynamodb.TransactWriteItemsOutput{}, tt.txErr
+				},
+			}
+			codes := &invitations.Codec{Signer: signing.Signer{Keys: signing.StaticKey("secret"), Secret: invitations.SecretName}, Now: func() time.Time { return now }}
+			code, err := codes.Encode(context.Background(), invitations.Code{InviterID: tt.inviterID, InvitationID: "i1", ExpiresAt: now.Add(time.Hour)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{UserTableName: "users", UserDataTableName: "user-data", CounterTableName: "counters", AuditTableName: "audit"}
+			fake := &fakeEvents{}
+			h := &Handler{
+				Invitations: invitations.NewStore(m.Client(), cfg),
+				Codes:       codes,
+				Users:       &users.Repository{DB: m.Client(), Table: "users"},
+				Events:      &events.Publisher{API: fake, Bus: "bus"},
+				Auth:        stubVerifier{&auth.Identity{Subject: tt.caller}},
+				Limiter:     &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+				Config:      cfg,
+				Now:         func() time.Time { return now },
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(code))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 200 {
+				if len(fake.published) != 0 {
+					t.Errorf("published %d events, want none", len(fake.published))
+				}
+				return
+			}
+
+			var got Response
+			if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.InviterID != "u1" || got.InvitationID != "i1" {
+				t.Errorf("response = %+v", got)
+			}
+			if len(fake.published) != 1 {
+				t.Fatalf("published %d events, want 1", len(fake.published))
+			}
+			var detail events.InvitationAccepted
+			if err := json.Unmarshal([]byte(aws.ToString(fake.published[0].Entries[0].Detail)), &detail); err != nil {
+				t.Fatal(err)
+			}
+			if detail.UserID != "u1" || detail.InviteeID != "u2" {
+				t.Errorf("event = %+v, want the referral of u2 credited to u1", detail)
+			}
+		})
+	}
+}
+
+func TestHandleInvalidCode(t *testing.T) {
+	m := &dbtest.Mock{}
+	cfg := &config.Config{UserTableName: "users", UserDataTableName: "user-data"}
+	h := &Handler{
+		Invitations: invitations.NewStore(m.Client(), cfg),
+		Codes:       invitations.NewCodec(signing.StaticKey("secret")),
+		Users:       &users.Repository{DB: m.Client(), Table: "users"},
+		Auth:        stubVerifier{&auth.Identity{Subject: "u2"}},
+		Limiter:     &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+		Config:      cfg,
+	}
+	forged, err := invitations.NewCodec(signing.StaticKey("other")).Encode(context.Background(),
+		invitations.Code{InviterID: "u1", InvitationID: "i1", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(forged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 422 {
+		t.Fatalf("status = %d, want 422 (%s)", resp.StatusCode, resp.Body)
+	}
+	for _, op := range m.Ops() {
+		if op == "GetItem" || op == "TransactWriteItems" {
+			t.Errorf("ops = %v, want forged codes to cost no read", m.Ops())
+		}
+	}
+}
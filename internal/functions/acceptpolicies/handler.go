@@ -0,0 +1,276 @@
+// Package acceptpolicies lets users see and accept the terms of service and
+// the privacy policy (see package policies): GET /users/{user_id}/policies
+// returns the latest version of each, what the user accepted and whether
+// they must accept a new version, and POST /users/{user_id}/policies
+// records the acceptance of the latest versions, with the time and the
+// caller's IP address.
+//
+// Clients call it when a response carries the Policy-Acceptance-Required
+// header, show the listed policies and accept them.
+package acceptpolicies
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/policies"   // terms and privacy policy acceptance
+	"troggle-backend/internal/ratelimit"  // DynamoDB token buckets
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// rateLimits bound the requests of one caller. They can be tuned per stage
+// through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(60),
+	PerUser: ratelimit.PerMinute(20),
+}
+
+// Request represents the JSON input. API Gateway callers name the user in
+// the path; direct invocations without acceptances read the user's status.
+type Request struct {
+	UserID      string     `json:"user_id"`
+	Acceptances []Accepted `json:"acceptances" validate:"max=2"`
+}
+
+// Accepted names a policy version the user accepts.
+type Accepted struct {
+	Policy  string `json:"policy" validate:"required"`
+	Version int64  `json:"version" validate:"required,min=1"`
+}
+
+// Status is the latest version of a policy and where the user stands.
+type Status struct {
+	policies.Version
+	Accepted *policies.Acceptance `json:"accepted,omitempty"` // the user's latest acceptance, if any
+	Pending  bool                 `json:"pending"`            // the user must accept the latest version
+}
+
+// Response represents the JSON output: the status of each published
+// policy.
+type Response struct {
+	Policies []Status `json:"policies"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "getPolicies",
+		Function: "acceptPolicies",
+		Summary:  "Returns the latest terms and privacy policy and what a user accepted",
+		Method:   "GET",
+		Path:     "/users/{user_id}/policies",
+		Response: Response{},
+	},
+	{
+		Name:     "acceptPolicies",
+		Function: "acceptPolicies",
+		Summary:  "Records that a user accepted the latest terms or privacy policy",
+		Method:   "POST",
+		Path:     "/users/{user_id}/policies",
+		Request:  Request{},
+		Response: Response{},
+	},
+}
+
+// authorize lets callers read their own acceptances, or anyone's as admins,
+// but accept policies as themselves only. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject == userID || (r.Method == "GET" && authz.Allowed(id, authz.UsersActAs)) {
+		return nil
+	}
+	return apperr.Forbidden("You may only accept policies as yourself")
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Policies *policies.Store
+	Auth     auth.TokenVerifier
+	Limiter  *ratelimit.Limiter
+	Limits   ratelimit.Policy
+	Audit    *audit.Store
+	Config   *config.Config
+	Now      func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Policies: policies.NewStore(client, cfg),
+		Auth:     verifier,
+		Limiter:  ratelimit.New(client, cfg),
+		Limits:   limits,
+		Audit:    audit.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits), validation.Body[Request]())
+}
+
+// Handle records the acceptances in the body, if any, and answers 200 with
+// the status of each policy. Versions a newer one replaced are refused
+// with 409 POLICY_VERSION_OUTDATED, and nothing is recorded: the caller
+// should show the latest version.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if r.Direct || r.Method != "GET" {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	if !r.Direct {
+		req.UserID = r.PathParams["user_id"]
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if !r.Direct && r.Method != "GET" && len(req.Acceptances) == 0 {
+		return httpx.Error(apperr.Invalid(validation.CodeRequired, "acceptances", "acceptances is required")), nil
+	}
+	ctx = logging.With(ctx, "user_id", req.UserID)
+
+	current, err := h.latest(ctx)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if len(req.Acceptances) > 0 {
+		if err := h.accept(ctx, r, req, current); err != nil {
+			if kind := apperr.KindOf(err); kind == apperr.KindInvalid || kind == apperr.KindConflict {
+				return httpx.Error(err), nil
+			}
+			return httpx.Response{}, err
+		}
+	}
+
+	acceptances, err := h.Policies.Acceptances(ctx, req.UserID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSON(200, status(current, acceptances)), nil
+}
+
+// latest reads the latest version of each policy, bypassing the cache so
+// that users are shown what they can accept.
+func (h *Handler) latest(ctx context.Context) (map[string]policies.Version, error) {
+	current := make(map[string]policies.Version, len(policies.Names))
+	for _, policy := range policies.Names {
+		v, err := h.Policies.Latest(ctx, policy)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			current[policy] = *v
+		}
+	}
+	return current, nil
+}
+
+// accept checks every acceptance of req against current before recording
+// any, so a request is recorded whole or not at all, unless a version is
+// published in between.
+func (h *Handler) accept(ctx context.Context, r *httpx.Request, req Request, current map[string]policies.Version) error {
+	for i, a := range req.Acceptances {
+		if !policies.Valid(a.Policy) {
+			return policies.ErrUnknownPolicy
+		}
+		for _, b := range req.Acceptances[:i] {
+			if b.Policy == a.Policy {
+				return apperr.Invalid("DUPLICATE_POLICY", "acceptances", "Each policy can be accepted once per request")
+			}
+		}
+		v, ok := current[a.Policy]
+		switch {
+		case !ok || a.Version > v.Version:
+			return policies.ErrUnknownVersion
+		case a.Version < v.Version:
+			return policies.ErrOutdatedVersion
+		}
+	}
+
+	now := h.now()
+	for _, a := range req.Acceptances {
+		_, created, err := h.Policies.Accept(ctx, req.UserID, a.Policy, a.Version, r.SourceIP, now)
+		if err != nil {
+			return err
+		}
+		if !created {
+			continue // accepted before
+		}
+		slog.InfoContext(ctx, "Policy accepted", "policy", a.Policy, "version", a.Version)
+		h.Audit.Log(ctx, audit.Entry{
+			Resource:  audit.UserResource(req.UserID),
+			Action:    audit.ActionPolicyAccept,
+			Actor:     audit.ActorOf(ctx, r),
+			RequestID: audit.RequestID(ctx, r),
+			Diff:      map[string]audit.Change{a.Policy: {After: a.Version}},
+		})
+	}
+	return nil
+}
+
+// status returns the status of each policy of current.
+func status(current map[string]policies.Version, acceptances []policies.Acceptance) Response {
+	pending := policies.PendingOf(current, acceptances)
+	resp := Response{Policies: []Status{}}
+	for _, policy := range policies.Names {
+		v, ok := current[policy]
+		if !ok {
+			continue
+		}
+		s := Status{Version: v}
+		for _, a := range acceptances {
+			if a.Policy == policy && (s.Accepted == nil || a.Version > s.Accepted.Version) {
+				s.Accepted = &a
+			}
+		}
+		for _, p := range pending {
+			s.Pending = s.Pending || p == policy
+		}
+		resp.Policies = append(resp.Policies, s)
+	}
+	return resp
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,140 @@
+package acceptpolicies
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/policies"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/userdata"
+)
+
+// stubVerifier accepts the token "valid" as user u1.
+type stubVerifier struct{}
+
+func (stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return &auth.Identity{Subject: "u1"}, nil
+}
+
+// apiEvent is a REST API event of the policies route of userID.
+func apiEvent(method, userID, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     method,
+		"path":           "/users/" + userID + "/policies",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           body,
+		"requestContext": map[string]any{"identity": map[string]string{"sourceIp": "198.51.100.7"}},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	published := map[string]policies.Version{
+		policies.Terms:   {Policy: policies.Terms, Version: 3, RequiredVersion: 2, URL: "https://troggle.example/terms/3"},
+		policies.Privacy: {Policy: policies.Privacy, Version: 1, RequiredVersion: 1, URL: "https://troggle.example/privacy/1"},
+	}
+	tests := []struct {
+		name        string
+		method      string
+		userID      string
+		body        string
+		wantStatus  int
+		wantPuts    int
+		wantPending map[string]bool
+	}{
+		{
+			name: "status", method: "GET", userID: "u1", wantStatus: 200,
+			wantPending: map[string]bool{policies.Terms: false, policies.Privacy: true},
+		},
+		{
+			name: "accept", method: "POST", userID: "u1", body: `{"acceptances":[{"policy":"privacy","version":1}]}`,
+			wantStatus: 200, wantPuts: 1,
+			wantPending: map[string]bool{policies.Terms: false, policies.Privacy: false},
+		},
+		{name: "outdated", method: "POST", userID: "u1", body: `{"acceptances":[{"policy":"privacy","version":1},{"policy":"terms","version":2}]}`, wantStatus: 409},
+		{name: "unknown policy", method: "POST", userID: "u1", body: `{"acceptances":[{"policy":"cookies","version":1}]}`, wantStatus: 422},
+		{name: "twice", method: "POST", userID: "u1", body: `{"acceptances":[{"policy":"terms","version":3},{"policy":"terms","version":3}]}`, wantStatus: 422},
+		{name: "nothing", method: "POST", userID: "u1", body: `{}`, wantStatus: 422},
+		{name: "someone else", method: "POST", userID: "u2", body: `{"acceptances":[{"policy":"terms","version":3}]}`, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item, _ := userdata.Marshal(userdata.Acceptance, "u1", userdata.AcceptanceSK(policies.Terms, 2),
+				policies.Acceptance{UserID: "u1", Policy: policies.Terms, Version: 2, AcceptedAt: "2026-09-01T10:00:00Z"})
+			accepted := []db.Item{item}
+
+			m := &dbtest.Mock{
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if aws.ToString(in.TableName) == "user-data" {
+						return &dynamodb.QueryOutput{Items: accepted}, nil
+					}
+					policy := in.ExpressionAttributeValues[":policy"].(*types.AttributeValueMemberS).Value
+					return &dynamodb.QueryOutput{Items: []db.Item{db.MustEncode(published[policy])}}, nil
+				},
+				TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					accepted = append(accepted, in.TransactItems[0].Put.Item)
+					return &dynamodb.TransactWriteItemsOutput{}, nil
+				},
+			}
+			cfg := &config.Config{PolicyTableName: "policies", UserDataTableName: "user-data"}
+			h := &Handler{
+				Policies: policies.NewStore(m.Client(), cfg),
+				Auth:     stubVerifier{},
+				Limiter:  &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+				Config:   cfg,
+				Now:      func() time.Time { return time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC) },
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.method, tt.userID, tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if n := len(accepted) - 1; n != tt.wantPuts {
+				t.Errorf("recorded %d acceptances, want %d", n, tt.wantPuts)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+
+			var got Response
+			if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Policies) != len(tt.wantPending) {
+				t.Fatalf("policies = %+v, want %d", got.Policies, len(tt.wantPending))
+			}
+			for _, s := range got.Policies {
+				if s.Pending != tt.wantPending[s.Policy] {
+					t.Errorf("%s pending = %v, want %v", s.Policy, s.Pending, tt.wantPending[s.Policy])
+				}
+			}
+			if tt.wantPuts > 0 {
+				a, err := userdata.Unmarshal[policies.Acceptance](accepted[1], userdata.Acceptance)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if a.IP != "198.51.100.7" || a.AcceptedAt != "2026-10-15T09:00:00Z" {
+					t.Errorf("acceptance = %+v, want the caller's IP and the time", a)
+				}
+			}
+		})
+	}
+}
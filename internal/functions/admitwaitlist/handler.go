@@ -0,0 +1,344 @@
+// Package admitwaitlist lets the next wave of people in from the waitlist
+// (POST /waitlist/admissions), for admins, or on a schedule invoking it
+// directly. Each call admits up to count waiting entries, first in line
+// first, so sign-ups open in waves of a controlled size. See package
+// waitlist.
+//
+// Admitting someone creates their Cognito account, with the address
+// verified and a random password nobody knows, and their user record, then
+// mails them a link to sign in: with an emailed code, or by resetting the
+// password, either of which proves the address is theirs. Addresses that
+// have an account already are admitted without either. An entry failing
+// midway stays waiting and is retried with the next wave; every step
+// tolerates having been done before.
+package admitwaitlist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws" // aws package
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge" // EventBridge client
+	"github.com/aws/aws-sdk-go-v2/service/sqs"         // SQS client for the email queue
+
+	"troggle-backend/internal/accountstatus" // account lifecycle
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apikeys"       // API keys of server-to-server callers
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"         // audit log
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/email"         // transactional email
+	"troggle-backend/internal/events"        // domain event publishing
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"       // structured JSON logging
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/waitlist"      // waitlist of sign-ups
+)
+
+const (
+	// defaultCount and maxCount bound the size of a wave. Each admission
+	// takes a few Cognito calls, whose quotas are per account and Region.
+	defaultCount = 25
+	maxCount     = 100
+)
+
+// Request represents the JSON input.
+type Request struct {
+	Count  int  `json:"count,omitempty"`   // defaultCount when zero
+	DryRun bool `json:"dry_run,omitempty"` // list who would be admitted, admitting nobody
+}
+
+// Response represents the JSON output.
+type Response struct {
+	DryRun   bool     `json:"dry_run,omitempty"`
+	Admitted []string `json:"admitted"` // addresses admitted, or that would be on a dry run
+	Failed   []string `json:"failed"`   // addresses left waiting for the next wave
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "admitWaitlist",
+	Function: "admitWaitlist",
+	Summary:  "Admits the next wave of people from the waitlist",
+	Method:   "POST",
+	Path:     "/waitlist/admissions",
+	Request:  Request{},
+	Response: Response{},
+	Scopes:   []string{"troggle/admin", "waitlist:admit"},
+	Groups:   []string{"admin"},
+	APIKey:   true,
+}}
+
+// authorize lets callers holding the waitlist:admit permission only admit
+// people. Direct invocations, such as the schedule's, are trusted.
+func authorize(ctx context.Context, r *httpx.Request) error {
+	if r.Direct {
+		return nil
+	}
+	return authz.Require(ctx, authz.WaitlistAdmit)
+}
+
+// CognitoAPI is the part of the Cognito user pool API admissions use.
+type CognitoAPI interface {
+	AdminCreateUser(ctx context.Context, params *cognitoidentityprovider.AdminCreateUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminCreateUserOutput, error)
+	AdminGetUser(ctx context.Context, params *cognitoidentityprovider.AdminGetUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminGetUserOutput, error)
+	AdminSetUserPassword(ctx context.Context, params *cognitoidentityprovider.AdminSetUserPasswordInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminSetUserPasswordOutput, error)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Waitlist *waitlist.Store
+	Users    *users.Repository
+	Cognito  CognitoAPI
+	Email    *email.Queue
+	Events   *events.Publisher
+	Auth     auth.TokenVerifier
+	APIKey   *apikeys.Middleware // nil accepts bearer tokens only
+	Audit    *audit.Store
+	Config   *config.Config
+	Now      func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// Cognito user pool, the email queue and the sign-in link.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireWaitlistAdmission(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	queue, err := email.NewQueue(sqs.NewFromConfig(awsCfg), cfg)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Waitlist: waitlist.NewStore(client, cfg),
+		Users:    users.NewRepository(client, cfg),
+		Cognito:  cognitoidentityprovider.NewFromConfig(awsCfg),
+		Email:    queue,
+		Events:   events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Auth:     verifier,
+		APIKey:   apikeys.NewMiddleware(client, cfg),
+		Audit:    audit.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth))
+}
+
+// Handle admits the next count people in line and lists who was admitted
+// and who is left waiting after a failure. Entries admitted concurrently,
+// e.g. by overlapping waves, are in neither list.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if err := authorize(ctx, r); err != nil {
+		return httpx.Error(err), nil
+	}
+	if req.Count == 0 {
+		req.Count = defaultCount
+	}
+	if req.Count < 1 || req.Count > maxCount {
+		return httpx.Error(apperr.Invalid("INVALID_COUNT", "count", fmt.Sprintf("count must be between 1 and %d", maxCount))), nil
+	}
+
+	entries, err := h.Waitlist.Next(ctx, req.Count)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	resp := Response{DryRun: req.DryRun, Admitted: []string{}, Failed: []string{}}
+	if req.DryRun {
+		for _, e := range entries {
+			resp.Admitted = append(resp.Admitted, e.Email)
+		}
+		return httpx.JSON(200, resp), nil
+	}
+
+	for _, e := range entries {
+		err := h.admit(ctx, r, e)
+		switch {
+		case errors.Is(err, waitlist.ErrNotWaiting):
+		case err != nil:
+			slog.ErrorContext(ctx, "Failed to admit from the waitlist", "position", e.Position, logging.EmailHash(e.Email), logging.Err(err))
+			resp.Failed = append(resp.Failed, e.Email)
+		default:
+			resp.Admitted = append(resp.Admitted, e.Email)
+		}
+	}
+	slog.InfoContext(ctx, "Waitlist wave admitted", "admitted", len(resp.Admitted), "failed", len(resp.Failed))
+	return httpx.JSON(200, resp), nil
+}
+
+// admit lets the person of e in. It fails with waitlist.ErrNotWaiting when
+// e was admitted meanwhile.
+func (h *Handler) admit(ctx context.Context, r *httpx.Request, e waitlist.Entry) error {
+	now := h.now().UTC().Truncate(time.Second)
+	owner, err := h.Users.Uncached().IDByEmail(ctx, e.Email)
+	if err != nil {
+		return err
+	}
+	if owner != "" {
+		// Signed up some other way, e.g. before waitlist mode
+		return h.Waitlist.Admit(ctx, e, owner, now)
+	}
+
+	userID, err := h.createCognitoUser(ctx, e.Email)
+	if err != nil {
+		return err
+	}
+	ts := now.Format(time.RFC3339)
+	name, _, _ := strings.Cut(e.Email, "@")
+	user := users.User{
+		UserID:        userID,
+		Email:         e.Email,
+		EmailVerified: true, // signing in takes a code mailed to it
+		DisplayName:   name,
+		Status:        accountstatus.Active,
+		CreatedAt:     ts,
+		UpdatedAt:     ts,
+		Version:       1,
+	}
+	created := true
+	err = h.Users.Create(ctx, user)
+	if errors.Is(err, users.ErrUserExists) {
+		// Created by an earlier wave that failed later on
+		created = false
+	} else if err != nil {
+		return err
+	}
+	if err := h.Waitlist.Admit(ctx, e, userID, now); err != nil {
+		return err
+	}
+
+	ctx = logging.With(ctx, "user_id", userID)
+	slog.InfoContext(ctx, "Admitted from the waitlist", "position", e.Position)
+	err = h.Email.Enqueue(ctx, email.Message{
+		Template: email.TemplateWaitlistAdmitted,
+		To:       e.Email,
+		UserID:   userID,
+		Data:     map[string]string{"email": e.Email, "link": h.Config.SignInURL},
+	})
+	if err != nil {
+		// The admission cannot be undone; the person can still sign in
+		slog.ErrorContext(ctx, "Failed to mail the admission", logging.Err(err))
+	}
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionWaitlistAdmit,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff: audit.Diff(nil, map[string]any{
+			"email":       user.Email,
+			"status":      user.Status,
+			"referred_by": e.ReferredBy,
+		}),
+		At: ts,
+	})
+	if created {
+		h.Events.Emit(ctx, events.UserCreated{UserID: userID, Email: user.Email, Name: name, CreatedAt: ts})
+	}
+	return nil
+}
+
+// createCognitoUser creates the Cognito account of address, verified, with
+// a random permanent password and no invitation message, and returns its
+// sub. An account existing already, e.g. created by an earlier wave, is
+// reused, and only given a password if it has none of its own yet.
+func (h *Handler) createCognitoUser(ctx context.Context, address string) (string, error) {
+	out, err := h.Cognito.AdminCreateUser(ctx, &cognitoidentityprovider.AdminCreateUserInput{
+		UserPoolId:    aws.String(h.Config.UserPoolID),
+		Username:      aws.String(address),
+		MessageAction: cognitotypes.MessageActionTypeSuppress,
+		UserAttributes: []cognitotypes.AttributeType{
+			{Name: aws.String("email"), Value: aws.String(address)},
+			{Name: aws.String("email_verified"), Value: aws.String("true")},
+		},
+	})
+	var exists *cognitotypes.UsernameExistsException
+	var attrs []cognitotypes.AttributeType
+	temporary := true
+	switch {
+	case errors.As(err, &exists):
+		got, err := h.Cognito.AdminGetUser(ctx, &cognitoidentityprovider.AdminGetUserInput{
+			UserPoolId: aws.String(h.Config.UserPoolID),
+			Username:   aws.String(address),
+		})
+		if err != nil {
+			return "", fmt.Errorf("reading Cognito user: %w", err)
+		}
+		attrs = got.UserAttributes
+		temporary = got.UserStatus == cognitotypes.UserStatusTypeForceChangePassword
+	case err != nil:
+		return "", fmt.Errorf("creating Cognito user: %w", err)
+	default:
+		attrs = out.User.Attributes
+	}
+	var sub string
+	for _, a := range attrs {
+		if aws.ToString(a.Name) == "sub" {
+			sub = aws.ToString(a.Value)
+		}
+	}
+	if sub == "" {
+		return "", fmt.Errorf("Cognito user of the address has no sub")
+	}
+	if !temporary {
+		return sub, nil
+	}
+
+	// Created users must change their password on first sign-in; a
+	// permanent one nobody knows confirms the account instead
+	_, err = h.Cognito.AdminSetUserPassword(ctx, &cognitoidentityprovider.AdminSetUserPasswordInput{
+		UserPoolId: aws.String(h.Config.UserPoolID),
+		Username:   aws.String(sub),
+		Password:   aws.String(randomPassword()),
+		Permanent:  true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("setting Cognito password: %w", err)
+	}
+	return sub, nil
+}
+
+// randomPassword returns a password of 32 random bytes, with a character
+// of each class a password policy may require.
+func randomPassword() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b) + "aA1!"
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
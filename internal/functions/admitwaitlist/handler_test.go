@@ -0,0 +1,243 @@
+package admitwaitlist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/email"
+	"troggle-backend/internal/events"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/users"
+	"troggle-backend/internal/waitlist"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeCognito creates users whose sub is "sub-" and their address, and
+// records the admin calls it receives. Addresses in existing have an
+// account with the status given.
+type fakeCognito struct {
+	existing map[string]cognitotypes.UserStatusType
+	fail     map[string]error // by address
+	calls    []string
+}
+
+func attrs(address string) []cognitotypes.AttributeType {
+	return []cognitotypes.AttributeType{
+		{Name: aws.String("sub"), Value: aws.String("sub-" + address)},
+		{Name: aws.String("email"), Value: aws.String(address)},
+	}
+}
+
+func (c *fakeCognito) AdminCreateUser(_ context.Context, in *cognitoidentityprovider.AdminCreateUserInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminCreateUserOutput, error) {
+	address := aws.ToString(in.Username)
+	c.calls = append(c.calls, "AdminCreateUser "+address)
+	if err := c.fail[address]; err != nil {
+		return nil, err
+	}
+	if _, ok := c.existing[address]; ok {
+		return nil, &cognitotypes.UsernameExistsException{}
+	}
+	return &cognitoidentityprovider.AdminCreateUserOutput{User: &cognitotypes.UserType{Attributes: attrs(address)}}, nil
+}
+
+func (c *fakeCognito) AdminGetUser(_ context.Context, in *cognitoidentityprovider.AdminGetUserInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminGetUserOutput, error) {
+	address := aws.ToString(in.Username)
+	c.calls = append(c.calls, "AdminGetUser "+address)
+	return &cognitoidentityprovider.AdminGetUserOutput{UserAttributes: attrs(address), UserStatus: c.existing[address]}, nil
+}
+
+func (c *fakeCognito) AdminSetUserPassword(_ context.Context, in *cognitoidentityprovider.AdminSetUserPasswordInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminSetUserPasswordOutput, error) {
+	c.calls = append(c.calls, "AdminSetUserPassword "+aws.ToString(in.Username))
+	if !in.Permanent {
+		return nil, errors.New("temporary password")
+	}
+	return &cognitoidentityprovider.AdminSetUserPasswordOutput{}, nil
+}
+
+// fakeSQS records sent messages.
+type fakeSQS struct{ sent []email.Message }
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	var m email.Message
+	if err := json.Unmarshal([]byte(aws.ToString(in.MessageBody)), &m); err != nil {
+		return nil, err
+	}
+	f.sent = append(f.sent, m)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// fakeEvents records published events.
+type fakeEvents struct {
+	published []*eventbridge.PutEventsInput
+}
+
+func (e *fakeEvents) PutEvents(_ context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	e.published = append(e.published, in)
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+// apiEvent is a REST API event of the admissions route.
+func apiEvent(body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/waitlist/admissions",
+		"headers":    map[string]string{"Authorization": "Bearer valid"},
+		"body":       body,
+	})
+	return event
+}
+
+// entry returns the waiting entry of address.
+func entry(address string, position int) db.Item {
+	item := dbtest.Item("email", address, "status", waitlist.StatusWaiting, "queue", "waiting", "referral_code", "ABCD2345")
+	item["position"] = &types.AttributeValueMemberN{Value: strconv.Itoa(position)}
+	return item
+}
+
+func TestHandle(t *testing.T) {
+	admin := &auth.Identity{Subject: "a1", Groups: []string{authz.Admin}}
+	waiting := []db.Item{entry("ann@example.com", 1), entry("bob@example.com", 2), entry("cat@example.com", 3), entry("dan@example.com", 4)}
+	tests := []struct {
+		name         string
+		caller       *auth.Identity
+		body         string
+		existing     map[string]cognitotypes.UserStatusType
+		wantStatus   int
+		wantAdmitted []string
+		wantFailed   []string
+		wantCognito  []string
+		wantMailed   []string
+	}{
+		{
+			name: "wave", caller: admin, body: `{"count":4}`,
+			existing:     map[string]cognitotypes.UserStatusType{"dan@example.com": cognitotypes.UserStatusTypeConfirmed},
+			wantStatus:   200,
+			wantAdmitted: []string{"ann@example.com", "bob@example.com", "dan@example.com"},
+			wantFailed:   []string{"cat@example.com"},
+			wantCognito: []string{
+				"AdminCreateUser ann@example.com", "AdminSetUserPassword sub-ann@example.com",
+				"AdminCreateUser cat@example.com",
+				"AdminCreateUser dan@example.com", "AdminGetUser dan@example.com",
+			},
+			wantMailed: []string{"ann@example.com", "dan@example.com"},
+		},
+		{
+			name: "earlier wave", caller: admin, body: `{"count":1}`,
+			existing:     map[string]cognitotypes.UserStatusType{"ann@example.com": cognitotypes.UserStatusTypeForceChangePassword},
+			wantStatus:   200,
+			wantAdmitted: []string{"ann@example.com"},
+			wantFailed:   []string{},
+			wantCognito:  []string{"AdminCreateUser ann@example.com", "AdminGetUser ann@example.com", "AdminSetUserPassword sub-ann@example.com"},
+			wantMailed:   []string{"ann@example.com"},
+		},
+		{
+			name: "dry run", caller: admin, body: `{"count":2,"dry_run":true}`,
+			wantStatus:   200,
+			wantAdmitted: []string{"ann@example.com", "bob@example.com"},
+			wantFailed:   []string{},
+		},
+		{name: "too many", caller: admin, body: `{"count":1000}`, wantStatus: 422},
+		{name: "user", caller: &auth.Identity{Subject: "u1"}, body: `{}`, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if aws.ToString(in.TableName) == "waitlist" {
+						return &dynamodb.QueryOutput{Items: waiting[:aws.ToInt32(in.Limit)]}, nil
+					}
+					// bob signed up before waitlist mode
+					for _, v := range in.ExpressionAttributeValues {
+						if s, ok := v.(*types.AttributeValueMemberS); ok && s.Value == "bob@example.com" {
+							return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u2")}}, nil
+						}
+					}
+					return &dynamodb.QueryOutput{}, nil
+				},
+			}
+			cognito := &fakeCognito{existing: tt.existing, fail: map[string]error{"cat@example.com": errors.New("throttled")}}
+			queue := &fakeSQS{}
+			published := &fakeEvents{}
+			cfg := &config.Config{
+				UserTableName:          "users",
+				WaitlistTableName:      "waitlist",
+				WaitlistQueueIndexName: "queue-index",
+				CounterTableName:       "counters",
+				UserPoolID:             "pool",
+				SignInURL:              "https://troggle.example/sign-in",
+			}
+			h := &Handler{
+				Waitlist: waitlist.NewStore(m.Client(), cfg),
+				Users:    &users.Repository{DB: m.Client(), Table: "users", EmailIndex: "email-index"},
+				Cognito:  cognito,
+				Email:    &email.Queue{SQS: queue, URL: "https://sqs.example/email"},
+				Events:   &events.Publisher{API: published, Bus: "bus"},
+				Auth:     stubVerifier{tt.caller},
+				Config:   cfg,
+				Now:      func() time.Time { return time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC) },
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+
+			var got Response
+			if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Admitted, tt.wantAdmitted) || !reflect.DeepEqual(got.Failed, tt.wantFailed) {
+				t.Errorf("response = %+v, want admitted %v and failed %v", got, tt.wantAdmitted, tt.wantFailed)
+			}
+			if !reflect.DeepEqual(cognito.calls, tt.wantCognito) {
+				t.Errorf("Cognito calls = %v, want %v", cognito.calls, tt.wantCognito)
+			}
+			var mailed []string
+			for _, msg := range queue.sent {
+				if msg.Template != email.TemplateWaitlistAdmitted || msg.Data["link"] != cfg.SignInURL {
+					t.Errorf("sent %+v, want the sign-in link", msg)
+				}
+				mailed = append(mailed, msg.To)
+			}
+			if !reflect.DeepEqual(mailed, tt.wantMailed) {
+				t.Errorf("mailed %v, want %v", mailed, tt.wantMailed)
+			}
+			if len(published.published) != len(tt.wantMailed) {
+				t.Errorf("published %d events, want a UserCreated per account created", len(published.published))
+			}
+		})
+	}
+}
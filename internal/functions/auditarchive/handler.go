@@ -0,0 +1,114 @@
+// Package auditarchive consumes the stream of the audit table and copies the
+// entries the table's TTL removes to S3, where compliance keeps them past the
+// table's retention.
+//
+// Each batch becomes one object of JSON lines, named after the day of the
+// removal and the first record's event ID, so a retried batch overwrites its
+// own object instead of duplicating it. The stream must include old images.
+package auditarchive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-lambda-go/events"                          // Lambda event payloads
+	"github.com/aws/aws-sdk-go-v2/aws"                             // aws package
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue" // item decoding
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"troggle-backend/internal/audit"   // audit log
+	"troggle-backend/internal/awscfg"  // shared AWS SDK config
+	"troggle-backend/internal/config"  // environment-driven settings
+	"troggle-backend/internal/logging" // structured JSON logging
+	"troggle-backend/internal/metrics" // CloudWatch EMF metrics
+	"troggle-backend/internal/streams" // stream record decoding
+)
+
+// ObjectAPI is the part of the S3 API the archive uses.
+type ObjectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	S3     ObjectAPI
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// archive bucket.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireAuditArchive(); err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{S3: s3.NewFromConfig(awsCfg), Config: cfg}, nil
+}
+
+// Handle archives the removed entries of one batch. Failing the batch makes
+// Lambda retry it, so no entry is lost while S3 is unavailable.
+func (h *Handler) Handle(ctx context.Context, event events.DynamoDBEvent) error {
+	ctx, rec := metrics.NewContext(ctx)
+	defer rec.Flush()
+
+	var buf bytes.Buffer
+	var first *streams.Change
+	archived := 0
+	for _, r := range event.Records {
+		c, err := streams.Decode(r)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping undecodable audit record", "event_id", r.EventID, logging.Err(err))
+			continue
+		}
+		if c.Action != streams.Remove || c.Old == nil {
+			continue
+		}
+		if !c.Expired {
+			// Entries are append-only; anything else removing one is an incident
+			slog.WarnContext(ctx, "Audit entry deleted before expiry", "event_id", c.EventID, "resource", streams.Attr(c.Keys, "resource"))
+		}
+
+		var e audit.Entry
+		if err := attributevalue.UnmarshalMap(c.Old, &e); err != nil {
+			return fmt.Errorf("decoding audit entry %s: %w", c.EventID, err)
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("encoding audit entry %s: %w", c.EventID, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		if first == nil {
+			first = c
+		}
+		archived++
+	}
+	if first == nil {
+		return nil
+	}
+
+	key := ObjectKey(first)
+	_, err := h.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(h.Config.AuditArchiveBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", key, err)
+	}
+	slog.InfoContext(ctx, "Archived audit entries", "key", key, "count", archived)
+	metrics.Add(ctx, metrics.AuditArchived, float64(archived), metrics.UnitCount)
+	return nil
+}
+
+// ObjectKey names the archive object of a batch starting with c.
+func ObjectKey(c *streams.Change) string {
+	return c.At.UTC().Format("audit/2006/01/02/") + c.EventID + ".jsonl"
+}
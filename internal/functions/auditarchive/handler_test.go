@@ -0,0 +1,122 @@
+package auditarchive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/config"
+)
+
+// fakeS3 records the objects put.
+type fakeS3 struct {
+	keys   []string
+	bodies []string
+	err    error
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	body, _ := io.ReadAll(in.Body)
+	f.keys = append(f.keys, aws.ToString(in.Key))
+	f.bodies = append(f.bodies, string(body))
+	return &s3.PutObjectOutput{}, nil
+}
+
+// record is a stream record of the audit table; ttl marks a TTL removal.
+func record(id, action string, ttl bool) events.DynamoDBEventRecord {
+	entry := map[string]events.DynamoDBAttributeValue{
+		"resource": events.NewStringAttribute("user/u1"),
+		"entry_id": events.NewStringAttribute("2025-05-01T12:00:00Z#" + id),
+		"action":   events.NewStringAttribute(audit.ActionUserUpdate),
+		"source":   events.NewStringAttribute(audit.SourceAPI),
+		"at":       events.NewStringAttribute("2025-05-01T12:00:00Z"),
+	}
+	r := events.DynamoDBEventRecord{
+		EventID:   id,
+		EventName: action,
+		Change: events.DynamoDBStreamRecord{
+			ApproximateCreationDateTime: events.SecondsEpochTime{Time: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)},
+			Keys: map[string]events.DynamoDBAttributeValue{
+				"resource": entry["resource"],
+				"entry_id": entry["entry_id"],
+			},
+		},
+	}
+	if action == "REMOVE" {
+		r.Change.OldImage = entry
+	} else {
+		r.Change.NewImage = entry
+	}
+	if ttl {
+		r.UserIdentity = &events.DynamoDBUserIdentity{Type: "Service", PrincipalID: "dynamodb.amazonaws.com"}
+	}
+	return r
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name      string
+		records   []events.DynamoDBEventRecord
+		putErr    error
+		wantKeys  []string
+		wantLines int
+		wantErr   bool
+	}{
+		{
+			name:      "removed entries",
+			records:   []events.DynamoDBEventRecord{record("e1", "INSERT", false), record("e2", "REMOVE", true), record("e3", "REMOVE", false)},
+			wantKeys:  []string{"audit/2026/05/01/e2.jsonl"},
+			wantLines: 2,
+		},
+		{
+			name:    "nothing removed",
+			records: []events.DynamoDBEventRecord{record("e1", "INSERT", false)},
+		},
+		{
+			name:    "S3 failure fails the batch",
+			records: []events.DynamoDBEventRecord{record("e1", "REMOVE", true)},
+			putErr:  errors.New("unavailable"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{err: tt.putErr}
+			h := &Handler{S3: fake, Config: &config.Config{AuditArchiveBucket: "archive"}}
+
+			err := h.Handle(context.Background(), events.DynamoDBEvent{Records: tt.records})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(fake.keys, ",") != strings.Join(tt.wantKeys, ",") {
+				t.Errorf("keys = %v, want %v", fake.keys, tt.wantKeys)
+			}
+			if tt.wantLines == 0 {
+				return
+			}
+			lines := strings.Split(strings.TrimSpace(fake.bodies[0]), "\n")
+			if len(lines) != tt.wantLines {
+				t.Fatalf("lines = %d, want %d", len(lines), tt.wantLines)
+			}
+			var e audit.Entry
+			if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Resource != "user/u1" || e.Action != audit.ActionUserUpdate {
+				t.Errorf("entry = %+v", e)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+// Package authorizer is the API Gateway Lambda authorizer: it turns Cognito
+// tokens and API keys into IAM policies covering the routes the caller may use.
+package authorizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events" // API Gateway authorizer event definitions
+
+	"troggle-backend/internal/apikeys"  // API keys of server-to-server callers
+	"troggle-backend/internal/apperr"   // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"     // Cognito JWT verification
+	"troggle-backend/internal/config"   // environment-driven settings
+	"troggle-backend/internal/db"       // shared DynamoDB client
+	"troggle-backend/internal/logging"  // structured JSON logging
+	"troggle-backend/internal/sessions" // session table access
+)
+
+// errUnauthorized is the exact error message API Gateway turns into a 401.
+// Any other error becomes a 500.
+var errUnauthorized = errors.New("Unauthorized")
+
+// lockedOutPrincipal is the principal of the policies denying suspended and
+// banned accounts, whose identity the verifier does not return.
+const lockedOutPrincipal = "locked-out"
+
+// caller is the identity established from either credential type.
+type caller struct {
+	principal string
+	username  string
+	scopes    []string
+	groups    []string
+	authType  string // "cognito" or "api_key"
+
+	// rejection is why the account of a verified token is locked out
+	rejection *apperr.Error
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+// The verifier, and with it the JWKS cache, lives as long as the container.
+type Handler struct {
+	Keys     *apikeys.Store
+	Verifier auth.TokenVerifier
+	Routes   *RouteConfig
+	Config   *config.Config
+}
+
+// New loads the route table and builds the handler, its DynamoDB client and
+// token verifier from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	routes, err := LoadRoutes()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	// API Gateway caches policies per token, so a revocation can take up to
+	// the authorizer cache TTL to reach the routes behind it
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{Keys: apikeys.NewStore(client, cfg), Verifier: verifier, Routes: routes, Config: cfg}, nil
+}
+
+// Authorize authenticates the caller from a bearer token or an API key and
+// returns a policy allowing every route its scopes and groups grant and
+// explicitly denying the others the allowed wildcards reach (see
+// RouteConfig.Denied). Callers without valid credentials get a 401;
+// authenticated callers get a policy, which denies everything when no route
+// matches.
+func (h *Handler) Authorize(ctx context.Context, event events.APIGatewayCustomAuthorizerRequestTypeRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+	ctx = logging.With(ctx, "apigw_request_id", event.RequestContext.RequestID)
+
+	c, err := h.authenticate(ctx, event.Headers)
+	if err != nil {
+		if errors.Is(err, errUnauthorized) {
+			return events.APIGatewayCustomAuthorizerResponse{}, errUnauthorized
+		}
+		slog.ErrorContext(ctx, "Authorizer failed", logging.Err(err))
+		return events.APIGatewayCustomAuthorizerResponse{}, err
+	}
+
+	base, err := apiBase(event.MethodArn)
+	if err != nil {
+		return events.APIGatewayCustomAuthorizerResponse{}, err
+	}
+	if c.rejection != nil {
+		return lockedOut(base, c.rejection), nil
+	}
+
+	resources := h.Routes.Allowed(base, c.scopes, c.groups)
+	effect := "Allow"
+	if len(resources) == 0 {
+		effect, resources = "Deny", []string{base + "/*/*"}
+	}
+	statements := []events.IAMPolicyStatement{{
+		Action:   []string{"execute-api:Invoke"},
+		Effect:   effect,
+		Resource: resources,
+	}}
+	if effect == "Allow" {
+		if denied := h.Routes.Denied(base, c.scopes, c.groups); len(denied) > 0 {
+			statements = append(statements, events.IAMPolicyStatement{
+				Action:   []string{"execute-api:Invoke"},
+				Effect:   "Deny",
+				Resource: denied,
+			})
+		}
+	}
+
+	slog.InfoContext(ctx, "Authorized caller", "caller", c.principal, "auth_type", c.authType,
+		"effect", effect, "route_count", len(resources))
+
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID: c.principal,
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version:   "2012-10-17",
+			Statement: statements,
+		},
+		// Read back by httpx as the request claims
+		Context: map[string]any{
+			"sub":            c.principal,
+			"username":       c.username,
+			"scope":          strings.Join(c.scopes, " "),
+			"cognito:groups": strings.Join(c.groups, ","),
+			"auth_type":      c.authType,
+		},
+	}, nil
+}
+
+// lockedOut returns the policy denying every route to a suspended or banned
+// account. API Gateway answers 403; its ACCESS_DENIED gateway response
+// renders the error_code and error_message of the context, so clients see
+// why.
+func lockedOut(base string, e *apperr.Error) events.APIGatewayCustomAuthorizerResponse {
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID: lockedOutPrincipal,
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version: "2012-10-17",
+			Statement: []events.IAMPolicyStatement{{
+				Action:   []string{"execute-api:Invoke"},
+				Effect:   "Deny",
+				Resource: []string{base + "/*/*"},
+			}},
+		},
+		Context: map[string]any{
+			"error_code":    e.Code,
+			"error_message": e.Message,
+		},
+	}
+}
+
+// authenticate establishes the caller from the Authorization or x-api-key
+// header. Rejected credentials return errUnauthorized; other errors mean the
+// check itself failed.
+func (h *Handler) authenticate(ctx context.Context, headers map[string]string) (*caller, error) {
+	var authz, key string
+	for name, v := range headers {
+		switch strings.ToLower(name) {
+		case "authorization":
+			authz = v
+		case apikeys.Header:
+			key = v
+		}
+	}
+
+	if scheme, token, ok := strings.Cut(authz, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		id, err := h.Verifier.Verify(ctx, strings.TrimSpace(token))
+		if e := apperr.As(err); err != nil && e.Kind == apperr.KindForbidden {
+			slog.WarnContext(ctx, "Rejected token of locked-out account", logging.Err(err))
+			return &caller{principal: lockedOutPrincipal, authType: "cognito", rejection: e}, nil
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Rejected token", logging.Err(err))
+			return nil, errUnauthorized
+		}
+		return &caller{
+			principal: id.Subject,
+			username:  id.Username,
+			scopes:    id.Scopes,
+			groups:    groupsOf(id),
+			authType:  "cognito",
+		}, nil
+	}
+
+	if key != "" {
+		k, err := h.Keys.Lookup(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if k == nil || !k.Usable(time.Now()) {
+			slog.WarnContext(ctx, "Rejected API key")
+			return nil, errUnauthorized
+		}
+		slog.InfoContext(ctx, "Accepted API key", "key_id", k.KeyID)
+		return &caller{principal: k.Owner, username: k.Name, scopes: k.Scopes, authType: auth.TokenUseAPIKey}, nil
+	}
+
+	return nil, errUnauthorized
+}
+
+// groupsOf returns the Cognito groups of id together with the roles the role
+// table grants it: routes and the claims passed on to functions treat both
+// alike.
+func groupsOf(id *auth.Identity) []string {
+	groups := slices.Clone(id.Groups)
+	for _, role := range id.Roles {
+		if !id.InGroup(role) {
+			groups = append(groups, role)
+		}
+	}
+	return groups
+}
+
+// apiBase trims a method ARN
+// ("arn:aws:execute-api:region:account:api/stage/GET/users/123") to the
+// API and stage, which prefix every resource in the policy.
+func apiBase(methodArn string) (string, error) {
+	parts := strings.SplitN(methodArn, "/", 3)
+	if len(parts) < 3 || !strings.HasPrefix(parts[0], "arn:") {
+		return "", fmt.Errorf("unexpected method ARN %q", methodArn)
+	}
+	return parts[0] + "/" + parts[1], nil
+}
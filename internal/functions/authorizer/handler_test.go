@@ -0,0 +1,319 @@
+package authorizer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/accountstatus"
+	"troggle-backend/internal/apikeys"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+)
+
+const (
+	testBase      = "arn:aws:execute-api:eu-west-1:123456789012:abc123/prod"
+	testMethodArn = testBase + "/GET/users/u1"
+)
+
+// tokenVerifier accepts the token "valid" as id, and rejects the token
+// "suspended" as that of a suspended account.
+type tokenVerifier struct{ id *auth.Identity }
+
+func (v tokenVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	switch token {
+	case "valid":
+		return v.id, nil
+	case "suspended":
+		return nil, accountstatus.Rejection(accountstatus.Suspended, "spam")
+	}
+	return nil, errors.New("signature is invalid")
+}
+
+// apiKeys answers key lookups from records keyed by the plaintext key.
+func apiKeys(keys map[string]apikeys.Record) func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		hash := in.Key["key_hash"].(*types.AttributeValueMemberS).Value
+		for plain, k := range keys {
+			if apikeys.Hash(plain) != hash {
+				continue
+			}
+			item := dbtest.Item("key_hash", hash, "owner", k.Owner, "status", k.Status, "expires_at", k.ExpiresAt)
+			item["scopes"] = &types.AttributeValueMemberSS{Value: k.Scopes}
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		}
+		return &dynamodb.GetItemOutput{}, nil
+	}
+}
+
+// versioned returns the resources of a route: its unversioned path and the
+// path in every API version.
+func versioned(method, path string) []string {
+	return []string{
+		testBase + "/" + method + "/" + path,
+		testBase + "/" + method + "/v1/" + path,
+		testBase + "/" + method + "/v2/" + path,
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	if _, err := ParseRoutes(defaultRoutes); err != nil {
+		t.Fatalf("bundled routes: %v", err)
+	}
+	for _, raw := range []string{`{"routes":[{"method":"GET","path":"users"}]}`, `{"routes":[{"path":"/users"}]}`, `[`} {
+		if _, err := ParseRoutes([]byte(raw)); err == nil {
+			t.Errorf("ParseRoutes(%s) succeeded", raw)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	rc := &RouteConfig{Routes: []Route{
+		{Method: "GET", Path: "/users/{user_id}"},
+		{Method: "ANY", Path: "/admin/{proxy}", Groups: []string{"admin"}},
+		{Method: "POST", Path: "/users", Scopes: []string{"troggle/users.write"}},
+	}}
+	tests := []struct {
+		name   string
+		scopes []string
+		groups []string
+		want   []string
+	}{
+		{name: "no grants", want: versioned("GET", "users/*")},
+		{name: "scope", scopes: []string{"troggle/users.write"}, want: append(versioned("GET", "users/*"), versioned("POST", "users")...)},
+		{name: "group", groups: []string{"admin"}, want: append(versioned("GET", "users/*"), versioned("*", "admin/*")...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rc.Allowed(testBase, tt.scopes, tt.groups); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allowed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	keys := apiKeys(map[string]apikeys.Record{
+		"live":    {Owner: "svc-billing", Status: "active", Scopes: []string{"troggle/users.read"}, ExpiresAt: future},
+		"revoked": {Owner: "svc-old", Status: "revoked", Scopes: []string{"troggle/users.read"}},
+		"expired": {Owner: "svc-old", Status: "active", Scopes: []string{"troggle/users.read"}, ExpiresAt: past},
+	})
+	routes := &RouteConfig{Routes: []Route{
+		{Method: "GET", Path: "/users/{user_id}", Groups: []string{"players"}},
+		{Method: "GET", Path: "/users/by-sub/{sub}", Groups: []string{"admin"}},
+		{Method: "POST", Path: "/users/exists", Scopes: []string{"troggle/users.read"}},
+	}}
+
+	tests := []struct {
+		name             string
+		headers          map[string]string
+		wantUnauthorized bool
+		wantEffect       string
+		wantPrincipal    string
+		wantResources    []string
+		wantDenied       []string // resources of the explicit deny statement
+		wantCode         string   // error_code of the context
+	}{
+		{
+			name:       "user token",
+			headers:    map[string]string{"Authorization": "Bearer valid"},
+			wantEffect: "Allow", wantPrincipal: "u1",
+			wantResources: versioned("GET", "users/*"),
+			wantDenied:    versioned("GET", "users/by-sub/*"),
+		},
+		{
+			name:       "api key",
+			headers:    map[string]string{"X-Api-Key": "live"},
+			wantEffect: "Allow", wantPrincipal: "svc-billing",
+			wantResources: versioned("POST", "users/exists"),
+		},
+		{
+			name:             "bad token",
+			headers:          map[string]string{"authorization": "Bearer forged"},
+			wantUnauthorized: true,
+		},
+		{name: "revoked key", headers: map[string]string{"x-api-key": "revoked"}, wantUnauthorized: true},
+		{name: "expired key", headers: map[string]string{"x-api-key": "expired"}, wantUnauthorized: true},
+		{name: "unknown key", headers: map[string]string{"x-api-key": "guess"}, wantUnauthorized: true},
+		{name: "no credentials", wantUnauthorized: true},
+		{
+			name:       "suspended account",
+			headers:    map[string]string{"Authorization": "Bearer suspended"},
+			wantEffect: "Deny", wantPrincipal: lockedOutPrincipal,
+			wantResources: []string{testBase + "/*/*"},
+			wantCode:      "ACCOUNT_SUSPENDED",
+		},
+		{
+			name:       "no matching route",
+			headers:    map[string]string{"Authorization": "Bearer valid"},
+			wantEffect: "Deny", wantPrincipal: "u2",
+			wantResources: []string{testBase + "/*/*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := &auth.Identity{Subject: "u1", Username: "jane", Groups: []string{"players"}}
+			if tt.wantEffect == "Deny" {
+				id = &auth.Identity{Subject: "u2", Username: "john"}
+			}
+			m := &dbtest.Mock{GetItemFunc: keys}
+			h := &Handler{
+				Keys:     &apikeys.Store{DB: m.Client(), Table: "api-keys"},
+				Verifier: tokenVerifier{id: id},
+				Routes:   routes,
+				Config:   &config.Config{},
+			}
+
+			resp, err := h.Authorize(context.Background(), events.APIGatewayCustomAuthorizerRequestTypeRequest{
+				MethodArn: testMethodArn,
+				Headers:   tt.headers,
+			})
+			if tt.wantUnauthorized {
+				if err == nil || !errors.Is(err, errUnauthorized) {
+					t.Fatalf("err = %v, want Unauthorized", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			statement := resp.PolicyDocument.Statement[0]
+			if statement.Effect != tt.wantEffect || resp.PrincipalID != tt.wantPrincipal {
+				t.Errorf("policy = %s for %s, want %s for %s", statement.Effect, resp.PrincipalID, tt.wantEffect, tt.wantPrincipal)
+			}
+			if !reflect.DeepEqual(statement.Resource, tt.wantResources) {
+				t.Errorf("resources = %v, want %v", statement.Resource, tt.wantResources)
+			}
+			var denied []string
+			if len(resp.PolicyDocument.Statement) > 1 {
+				denied = resp.PolicyDocument.Statement[1].Resource
+			}
+			if !reflect.DeepEqual(denied, tt.wantDenied) {
+				t.Errorf("denied resources = %v, want %v", denied, tt.wantDenied)
+			}
+			if tt.wantCode != "" {
+				if resp.Context["error_code"] != tt.wantCode {
+					t.Errorf("context = %v, want error_code %s", resp.Context, tt.wantCode)
+				}
+				return
+			}
+			if resp.Context["sub"] != tt.wantPrincipal {
+				t.Errorf("context = %v", resp.Context)
+			}
+		})
+	}
+}
+
+// permits reports whether policy lets the caller invoke resource: an
+// allow matches it and no deny does, as IAM evaluates policies.
+func permits(policy events.APIGatewayCustomAuthorizerPolicy, resource string) bool {
+	allowed := false
+	for _, st := range policy.Statement {
+		for _, pattern := range st.Resource {
+			if !wildcardMatch(pattern, resource) {
+				continue
+			}
+			if st.Effect == "Deny" {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// TestBundledPolicy builds the policies of the bundled routes.json and
+// checks that every caller can invoke exactly the routes it is granted, in
+// spite of wildcards spanning slashes.
+func TestBundledPolicy(t *testing.T) {
+	routes, err := ParseRoutes(defaultRoutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := regexp.MustCompile(`\{\w+\}`)
+	callers := map[string]*auth.Identity{
+		"user":      {Subject: "u1"},
+		"moderator": {Subject: "m1", Groups: []string{"moderator"}},
+		"admin":     {Subject: "a1", Groups: []string{"admin"}},
+	}
+	for name, id := range callers {
+		t.Run(name, func(t *testing.T) {
+			h := &Handler{Verifier: tokenVerifier{id: id}, Routes: routes, Config: &config.Config{}}
+			resp, err := h.Authorize(context.Background(), events.APIGatewayCustomAuthorizerRequestTypeRequest{
+				MethodArn: testMethodArn,
+				Headers:   map[string]string{"Authorization": "Bearer valid"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range routes.Routes {
+				if r.Public {
+					continue
+				}
+				want := r.allows(nil, groupsOf(id))
+				for _, prefix := range []string{"", "/v2"} {
+					resource := testBase + "/" + r.Method + prefix + params.ReplaceAllString(r.Path, "x1")
+					if got := permits(resp.PolicyDocument, resource); got != want {
+						t.Errorf("%s %s%s permitted = %v, want %v", r.Method, prefix, r.Path, got, want)
+					}
+				}
+			}
+		})
+	}
+
+	// The case that motivated the check: a deny of the admin-only DELETE
+	// /users/* must not close the user's own DELETE routes.
+	h := &Handler{Verifier: tokenVerifier{id: callers["user"]}, Routes: routes, Config: &config.Config{}}
+	resp, err := h.Authorize(context.Background(), events.APIGatewayCustomAuthorizerRequestTypeRequest{
+		MethodArn: testMethodArn,
+		Headers:   map[string]string{"Authorization": "Bearer valid"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !permits(resp.PolicyDocument, testBase+"/DELETE/users/u1/friends/u2") {
+		t.Error("user cannot remove a friend")
+	}
+	if permits(resp.PolicyDocument, testBase+"/DELETE/users/u2") {
+		t.Error("user can delete an account")
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"GET/users/*", "GET/users/by-sub/x", true},
+		{"GET/users/*", "GET/users", false},
+		{"DELETE/users/*/friends/*", "DELETE/users/u1/friends/u2", true},
+		{"DELETE/users/*/friends/*", "DELETE/users/u1/blocks/u2", false},
+		{"*/users", "PATCH/users", true},
+		{"GET/user?", "GET/users", true},
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestAPIBase(t *testing.T) {
+	if got, err := apiBase(testMethodArn); err != nil || got != testBase {
+		t.Errorf("apiBase = %q, %v", got, err)
+	}
+	if _, err := apiBase("not-an-arn"); err == nil || !strings.Contains(err.Error(), "unexpected method ARN") {
+		t.Errorf("apiBase accepted an invalid ARN: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package authorizer
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"troggle-backend/internal/httpx"
+)
+
+// envRoutesFile optionally points at a route config replacing the bundled one.
+const envRoutesFile = "AUTHORIZER_ROUTES_FILE"
+
+//go:embed routes.json
+var defaultRoutes []byte
+
+// Route is one API Gateway resource and the access it requires. A route with
+// neither Scopes nor Groups is open to any authenticated caller; otherwise
+// the caller needs at least one of the listed scopes or groups. Public
+// routes are deployed without the authorizer and listed for completeness.
+type Route struct {
+	Method string   `json:"method"` // HTTP method, or "ANY"
+	Path   string   `json:"path"`   // resource template, e.g. /users/{user_id}
+	Scopes []string `json:"scopes,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Public bool     `json:"public,omitempty"`
+}
+
+// RouteConfig is the document read from routes.json.
+type RouteConfig struct {
+	Routes []Route `json:"routes"`
+}
+
+// LoadRoutes reads the route config from envRoutesFile, falling back to the
+// bundled routes.json.
+func LoadRoutes() (*RouteConfig, error) {
+	raw := defaultRoutes
+	if path := os.Getenv(envRoutesFile); path != "" {
+		var err error
+		if raw, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("reading route config: %w", err)
+		}
+	}
+	return ParseRoutes(raw)
+}
+
+// ParseRoutes decodes and validates a route config.
+func ParseRoutes(raw []byte) (*RouteConfig, error) {
+	var rc RouteConfig
+	if err := json.Unmarshal(raw, &rc); err != nil {
+		return nil, fmt.Errorf("decoding route config: %w", err)
+	}
+	for i, r := range rc.Routes {
+		if r.Method == "" || !strings.HasPrefix(r.Path, "/") {
+			return nil, fmt.Errorf("route %d: method and an absolute path are required", i)
+		}
+	}
+	return &rc, nil
+}
+
+// allows reports whether a caller with the given scopes and groups may use
+// the route.
+func (r Route) allows(scopes, groups []string) bool {
+	if len(r.Scopes) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+	for _, s := range r.Scopes {
+		if slices.Contains(scopes, s) {
+			return true
+		}
+	}
+	for _, g := range r.Groups {
+		if slices.Contains(groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// resources returns the execute-api resources of the route below base
+// ("arn:aws:execute-api:region:account:api/stage"): the unversioned path and
+// the path in every API version (see httpx.Version). Path parameters become
+// wildcards.
+func (r Route) resources(base string) []string {
+	method := strings.ToUpper(r.Method)
+	if method == "ANY" {
+		method = "*"
+	}
+	segments := strings.Split(strings.TrimPrefix(r.Path, "/"), "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, "{") {
+			segments[i] = "*"
+		}
+	}
+	path := strings.Join(segments, "/")
+
+	resources := []string{base + "/" + method + "/" + path}
+	for _, v := range httpx.Versions {
+		resources = append(resources, base+"/"+method+"/"+v.String()+"/"+path)
+	}
+	return resources
+}
+
+// Allowed returns the resources, below base, of every route the caller may
+// use. The policy covers all of them rather than just the requested method,
+// so API Gateway can cache it per token without denying other routes.
+func (rc *RouteConfig) Allowed(base string, scopes, groups []string) []string {
+	return rc.matching(base, func(r Route) bool { return r.allows(scopes, groups) })
+}
+
+// Denied returns the resources, below base, of the routes the caller may
+// not use but an allowed resource reaches anyway. Resource wildcards span
+// slashes, so an allowed GET /users/* also matches a restricted GET
+// /users/by-sub/*; an explicit deny, which IAM always lets win, keeps the
+// restricted route closed. Other restricted routes are left out: their
+// wildcards would reach allowed routes in turn, as a deny of DELETE
+// /users/* would close DELETE /users/{user_id}/friends/{other_id}.
+func (rc *RouteConfig) Denied(base string, scopes, groups []string) []string {
+	allowed := rc.Allowed(base, scopes, groups)
+	return rc.matching(base, func(r Route) bool {
+		if r.allows(scopes, groups) {
+			return false
+		}
+		for _, sample := range r.samples(base) {
+			for _, a := range allowed {
+				if wildcardMatch(a, sample) {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
+
+// samples returns resources of requests the route serves: its resources
+// with a placeholder for each path parameter, in every method for ANY.
+func (r Route) samples(base string) []string {
+	methods := []string{r.Method}
+	if strings.EqualFold(r.Method, "ANY") {
+		methods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	var samples []string
+	for _, m := range methods {
+		for _, res := range (Route{Method: m, Path: r.Path}).resources(base) {
+			samples = append(samples, strings.ReplaceAll(res, "/*", "/_"))
+		}
+	}
+	return samples
+}
+
+// wildcardMatch reports whether resource s matches pattern, in which * is
+// any run of characters, slashes included, and ? any one character, as in
+// execute-api resources.
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// matching returns the resources of the routes for which keep is true.
+func (rc *RouteConfig) matching(base string, keep func(Route) bool) []string {
+	var resources []string
+	for _, r := range rc.Routes {
+		if keep(r) {
+			resources = append(resources, r.resources(base)...)
+		}
+	}
+	return resources
+}
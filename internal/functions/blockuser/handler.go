@@ -0,0 +1,178 @@
+// Package blockuser manages a user's block and mute lists:
+//
+//	PUT    /users/{user_id}/blocks/{other_id}  blocks
+//	DELETE /users/{user_id}/blocks/{other_id}  unblocks
+//	PUT    /users/{user_id}/mutes/{other_id}   mutes
+//	DELETE /users/{user_id}/mutes/{other_id}   unmutes
+//
+// Every operation is idempotent. See package relationships for what blocks
+// and mutes do.
+package blockuser
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// Lists, named after the collection in the path.
+const (
+	Blocks = "blocks"
+	Mutes  = "mutes"
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the same values in the method and path.
+type Request struct {
+	UserID  string `json:"user_id"`
+	OtherID string `json:"other_id"`
+	List    string `json:"list"`   // Blocks or Mutes
+	Remove  bool   `json:"remove"` // unblock or unmute
+}
+
+// authorize lets callers change their own lists only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only change your own block and mute lists")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "blockUser",
+		Function: "blockUser",
+		Summary:  "Blocks a user",
+		Method:   "PUT",
+		Path:     "/users/{user_id}/blocks/{other_id}",
+		Status:   204,
+	},
+	{
+		Name:     "unblockUser",
+		Function: "blockUser",
+		Summary:  "Unblocks a user",
+		Method:   "DELETE",
+		Path:     "/users/{user_id}/blocks/{other_id}",
+		Status:   204,
+	},
+	{
+		Name:     "muteUser",
+		Function: "blockUser",
+		Summary:  "Mutes a user",
+		Method:   "PUT",
+		Path:     "/users/{user_id}/mutes/{other_id}",
+		Status:   204,
+	},
+	{
+		Name:     "unmuteUser",
+		Function: "blockUser",
+		Summary:  "Unmutes a user",
+		Method:   "DELETE",
+		Path:     "/users/{user_id}/mutes/{other_id}",
+		Status:   204,
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Graph  *relationships.Store
+	Users  *users.Repository
+	Auth   auth.TokenVerifier
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Graph:  relationships.NewStore(client, cfg),
+		Users:  users.NewRepository(client, cfg),
+		Auth:   verifier,
+		Config: cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle adds the other user to the list the path names, or removes them.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{
+		UserID:  r.PathParams["user_id"],
+		OtherID: r.PathParams["other_id"],
+		List:    path.Base(path.Dir(r.Path)),
+		Remove:  r.Method == "DELETE",
+	}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	for _, id := range []string{req.UserID, req.OtherID} {
+		if err := validation.UserID(id); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	var err error
+	switch {
+	case req.List == Blocks && !req.Remove:
+		err = h.Graph.Block(ctx, req.UserID, req.OtherID)
+	case req.List == Blocks:
+		err = h.Graph.Unblock(ctx, req.UserID, req.OtherID)
+	case req.List == Mutes && !req.Remove:
+		err = h.Graph.Mute(ctx, req.UserID, req.OtherID)
+	case req.List == Mutes:
+		err = h.Graph.Unmute(ctx, req.UserID, req.OtherID)
+	default:
+		return httpx.Error(apperr.Invalid("LIST_INVALID", "list", "list must be blocks or mutes")), nil
+	}
+	switch {
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+
+	if req.List == Blocks && !req.Remove {
+		// Blocking may have ended a friendship or follows
+		h.Users.Invalidate(req.UserID, "")
+		h.Users.Invalidate(req.OtherID, "")
+	}
+	slog.InfoContext(ctx, "Block list updated", "user_id", req.UserID, "other_id", req.OtherID, "list", req.List, "remove", req.Remove)
+	return httpx.NoContent(), nil
+}
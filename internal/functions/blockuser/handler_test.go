@@ -0,0 +1,86 @@
+package blockuser
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/users"
+)
+
+// apiEvent is a <method> /users/{user_id}/<list>/{other_id} REST API event.
+func apiEvent(method, userID, list, otherID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     method,
+		"path":           "/users/" + userID + "/" + list + "/" + otherID,
+		"pathParameters": map[string]string{"user_id": userID, "other_id": otherID},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantOps    []string
+	}{
+		{
+			name: "block", payload: apiEvent("PUT", "u1", Blocks, "u2"), wantStatus: 204,
+			// The block, then unfriending, two unfollows and the requests
+			wantOps: []string{"TransactWriteItems", "TransactWriteItems", "TransactWriteItems", "TransactWriteItems", "TransactWriteItems"},
+		},
+		{name: "unblock", payload: apiEvent("DELETE", "u1", Blocks, "u2"), wantStatus: 204, wantOps: []string{"TransactWriteItems", "DeleteItem", "DeleteItem"}},
+		{name: "mute", payload: apiEvent("PUT", "u1", Mutes, "u2"), wantStatus: 204, wantOps: []string{"TransactWriteItems"}},
+		{name: "unmute", payload: apiEvent("DELETE", "u1", Mutes, "u2"), wantStatus: 204, wantOps: []string{"DeleteItem"}},
+		{name: "another user's list", payload: apiEvent("PUT", "u2", Blocks, "u3"), wantStatus: 403},
+		{name: "themselves", payload: apiEvent("PUT", "u1", Mutes, "u1"), wantStatus: 422},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u3","other_id":"u4","list":"mutes","remove":true}`), wantStatus: 204, wantOps: []string{"DeleteItem"}},
+		{name: "direct without list", payload: json.RawMessage(`{"user_id":"u3","other_id":"u4"}`), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{}
+			h := &Handler{
+				Graph:  &relationships.Store{DB: m.Client(), Table: "relationships", UserTable: "users"},
+				Users:  &users.Repository{DB: m.Client(), Table: "users"},
+				Config: &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if ops := m.Ops(); len(ops)+len(tt.wantOps) > 0 && !reflect.DeepEqual(ops, tt.wantOps) {
+				t.Errorf("ops = %v, want %v", ops, tt.wantOps)
+			}
+		})
+	}
+}
+
+func TestMuteUnknownUser(t *testing.T) {
+	m := &dbtest.Mock{TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+		return nil, dbtest.TransactionCanceled("None", "ConditionalCheckFailed")
+	}}
+	h := &Handler{Graph: &relationships.Store{DB: m.Client(), Table: "relationships", UserTable: "users"}, Config: &config.Config{}}
+
+	resp, err := httpx.Adapt(h.Handle)(context.Background(), json.RawMessage(`{"user_id":"u1","other_id":"u9","list":"mutes"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 404 {
+		t.Errorf("status = %d, want 404 (%s)", resp.StatusCode, resp.Body)
+	}
+}
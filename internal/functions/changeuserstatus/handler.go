@@ -0,0 +1,195 @@
+// Package changeuserstatus moves accounts through their lifecycle: POST
+// /users/{user_id}/suspend, /ban and /reactivate, each behind a permission
+// of its own (moderators may suspend, only admins ban and reactivate). Each
+// change is audited and published as a UserStatusChanged event; the auth
+// middleware then keeps suspended and banned users out. See package
+// accountstatus.
+package changeuserstatus
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/accountstatus" // account lifecycle
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apikeys"       // API keys of server-to-server callers
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"         // audit log
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/events"        // domain event publishing
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// actions maps the last path segment of each route to the status it sets
+// and the permission it requires.
+var actions = map[string]struct{ status, perm string }{
+	"suspend":    {accountstatus.Suspended, authz.UsersSuspend},
+	"ban":        {accountstatus.Banned, authz.UsersBan},
+	"reactivate": {accountstatus.Active, authz.UsersReactivate},
+}
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers name the action in the path and pass the rest in the body.
+type Request struct {
+	UserID string `json:"user_id"`
+	Action string `json:"action" api:"direct"` // "suspend", "ban" or "reactivate"
+	Reason string `json:"reason,omitempty"`    // reason code, required to suspend or ban
+	Note   string `json:"note,omitempty"`      // free text kept for admins
+}
+
+// authorize lets callers holding perm only take an action. Direct
+// invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, perm string) error {
+	if r.Direct {
+		return nil
+	}
+	return authz.Require(ctx, perm)
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "suspendUser",
+		Function: "changeUserStatus",
+		Summary:  "Suspends an account",
+		Method:   "POST",
+		Path:     "/users/{user_id}/suspend",
+		Request:  Request{},
+		Response: accountstatus.Change{},
+		Scopes:   []string{"troggle/admin", "users:suspend"},
+		Groups:   []string{"admin", "moderator"},
+		APIKey:   true,
+	},
+	{
+		Name:     "banUser",
+		Function: "changeUserStatus",
+		Summary:  "Bans an account",
+		Method:   "POST",
+		Path:     "/users/{user_id}/ban",
+		Request:  Request{},
+		Response: accountstatus.Change{},
+		Scopes:   []string{"troggle/admin", "users:ban"},
+		Groups:   []string{"admin"},
+		APIKey:   true,
+	},
+	{
+		Name:     "reactivateUser",
+		Function: "changeUserStatus",
+		Summary:  "Reactivates a suspended or banned account",
+		Method:   "POST",
+		Path:     "/users/{user_id}/reactivate",
+		Request:  Request{},
+		Response: accountstatus.Change{},
+		Scopes:   []string{"troggle/admin", "users:reactivate"},
+		Groups:   []string{"admin"},
+		APIKey:   true,
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Status *accountstatus.Store
+	Users  *users.Repository // cache invalidated after each change
+	Events *events.Publisher
+	Auth   auth.TokenVerifier
+	APIKey *apikeys.Middleware // nil accepts bearer tokens only
+	Audit  *audit.Store
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Status: accountstatus.NewStore(client, cfg),
+		Users:  users.NewRepository(client, cfg),
+		Events: events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Auth:   verifier,
+		APIKey: apikeys.NewMiddleware(client, cfg),
+		Audit:  audit.NewStore(client, cfg),
+		Config: cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth))
+}
+
+// Handle applies the action of the route to the account named by the
+// user_id path parameter and answers 200 with the change.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	// Reactivations need no body
+	if len(r.Body) > 0 || r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	if !r.Direct {
+		// The route, not the body, names the account and the action
+		req.UserID, req.Action = r.PathParams["user_id"], path.Base(r.Path)
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	action, ok := actions[req.Action]
+	if !ok {
+		return httpx.Error(apperr.Invalid("INVALID_ACTION", "action", `action must be "suspend", "ban" or "reactivate"`)), nil
+	}
+	if err := authorize(ctx, r, action.perm); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	change, err := h.Status.Transition(ctx, req.UserID, action.status, req.Reason, req.Note, time.Now())
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	h.Users.Invalidate(req.UserID, "")
+
+	slog.InfoContext(ctx, "Account status changed", "user_id", req.UserID, "from", change.From, "to", change.To, "reason", change.Reason)
+	diff := map[string]audit.Change{"status": {Before: change.From, After: change.To}}
+	if change.Reason != "" || req.Note != "" {
+		diff["status_reason"] = audit.Change{After: change.Reason}
+		diff["status_note"] = audit.Change{After: req.Note}
+	}
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionUserStatusChange,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      diff,
+	})
+	h.Events.Emit(ctx, events.UserStatusChanged{
+		UserID:    change.UserID,
+		From:      change.From,
+		To:        change.To,
+		Reason:    change.Reason,
+		ChangedAt: change.ChangedAt,
+	})
+	return httpx.JSON(200, change), nil
+}
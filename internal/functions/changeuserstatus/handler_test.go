@@ -0,0 +1,161 @@
+package changeuserstatus
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/accountstatus"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/events"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeEvents records published events.
+type fakeEvents struct {
+	published []*eventbridge.PutEventsInput
+}
+
+func (e *fakeEvents) PutEvents(_ context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	e.published = append(e.published, in)
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+// apiEvent is a REST API event of the route of action.
+func apiEvent(userID, action, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/" + action,
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	admin := &auth.Identity{Subject: "a1", Groups: []string{authz.Admin}}
+	moderator := &auth.Identity{Subject: "m1", Roles: []string{authz.Moderator}}
+	owner := &auth.Identity{Subject: "u1"}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		status     string // stored status of u1
+		wantStatus int
+		wantBody   string
+		wantEvents int
+	}{
+		{
+			name:    "suspend",
+			payload: apiEvent("u1", "suspend", `{"reason":"spam","note":"reported twice"}`), caller: admin, status: accountstatus.Active,
+			wantStatus: 200, wantBody: `"to":"suspended","reason":"spam"`, wantEvents: 1,
+		},
+		{
+			name:    "ban",
+			payload: apiEvent("u1", "ban", `{"reason":"chargeback"}`), caller: admin, status: accountstatus.Suspended,
+			wantStatus: 200, wantBody: `"from":"suspended","to":"banned"`, wantEvents: 1,
+		},
+		{
+			name:    "reactivate without body",
+			payload: apiEvent("u1", "reactivate", ""), caller: admin, status: accountstatus.Banned,
+			wantStatus: 200, wantBody: `"to":"active"`, wantEvents: 1,
+		},
+		{
+			name:    "missing reason",
+			payload: apiEvent("u1", "suspend", `{}`), caller: admin, status: accountstatus.Active,
+			wantStatus: 422, wantBody: `"code":"INVALID_REASON"`,
+		},
+		{
+			name:    "disallowed transition",
+			payload: apiEvent("u1", "suspend", `{"reason":"spam"}`), caller: admin, status: accountstatus.Banned,
+			wantStatus: 422, wantBody: `"code":"INVALID_TRANSITION"`,
+		},
+		{
+			name:    "missing user",
+			payload: apiEvent("u1", "ban", `{"reason":"spam"}`), caller: admin,
+			wantStatus: 404,
+		},
+		{
+			name:    "not an admin",
+			payload: apiEvent("u1", "suspend", `{"reason":"spam"}`), caller: owner, status: accountstatus.Active,
+			wantStatus: 403,
+		},
+		{
+			name:    "moderator suspends",
+			payload: apiEvent("u1", "suspend", `{"reason":"spam"}`), caller: moderator, status: accountstatus.Active,
+			wantStatus: 200, wantEvents: 1,
+		},
+		{
+			name:    "moderator bans",
+			payload: apiEvent("u1", "ban", `{"reason":"spam"}`), caller: moderator, status: accountstatus.Active,
+			wantStatus: 403,
+		},
+		{
+			name:    "direct",
+			payload: json.RawMessage(`{"user_id":"u1","action":"ban","reason":"fraud"}`), status: accountstatus.Active,
+			wantStatus: 200, wantBody: `"to":"banned"`, wantEvents: 1,
+		},
+		{
+			name:    "direct unknown action",
+			payload: json.RawMessage(`{"user_id":"u1","action":"delete"}`), status: accountstatus.Active,
+			wantStatus: 422, wantBody: `"code":"INVALID_ACTION"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					if tt.status == "" {
+						return &dynamodb.GetItemOutput{}, nil
+					}
+					return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1", "status", tt.status)}, nil
+				},
+				UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					return &dynamodb.UpdateItemOutput{}, nil
+				},
+			}
+			fake := &fakeEvents{}
+			h := &Handler{
+				Status: &accountstatus.Store{DB: m.Client(), Table: "users"},
+				Users:  &users.Repository{},
+				Events: &events.Publisher{API: fake, Bus: "bus"},
+				Auth:   stubVerifier{tt.caller},
+				Config: &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+			if len(fake.published) != tt.wantEvents {
+				t.Errorf("%d events published, want %d", len(fake.published), tt.wantEvents)
+			}
+		})
+	}
+}
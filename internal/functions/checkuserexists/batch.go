@@ -0,0 +1,91 @@
+package checkuserexists
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/fanout"     // bounded parallel reads
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+const (
+	// maxBatchEmails is the largest list accepted in one request. Each
+	// address costs a rate limit token, so the rate limit policy may refuse
+	// smaller batches still, with REQUEST_TOO_LARGE.
+	maxBatchEmails = 100
+
+	// batchConcurrency bounds the GSI queries in flight per request. GSIs
+	// cannot be read with BatchGetItem, so each email is its own Query.
+	batchConcurrency = 10
+)
+
+// BatchResponse represents the JSON output of a batch check: the existence of
+// each email, keyed exactly as the caller sent it.
+type BatchResponse struct {
+	Results map[string]bool `json:"results"`
+}
+
+// UsersExist checks every email (already normalized) in parallel and returns
+// whether each one exists. The first lookup failure cancels the remaining
+// queries and is returned.
+func UsersExist(ctx context.Context, emails []string, repo *users.Repository) (map[string]bool, error) {
+	start := time.Now()
+
+	results, err := fanout.Map(ctx, batchConcurrency, emails, func(ctx context.Context, email string) (bool, error) {
+		return UserExists(ctx, email, repo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Batch existence check completed", "count", len(emails), logging.Latency(start))
+	return results, nil
+}
+
+// handleBatch validates the list, runs the lookups on the distinct normalized
+// addresses and maps the results back to the emails as sent. Every distinct
+// address costs the caller a rate limit token, as a single check does, so
+// batches probe no more addresses than single checks would.
+func (h *Handler) handleBatch(ctx context.Context, r *httpx.Request, emails []string) (BatchResponse, error) {
+	if len(emails) > maxBatchEmails {
+		return BatchResponse{}, apperr.Invalid("TOO_MANY_EMAILS", "emails",
+			fmt.Sprintf("at most %d emails may be checked at once", maxBatchEmails))
+	}
+
+	normalized := make(map[string]string, len(emails)) // as sent -> normalized
+	var distinct []string
+	seen := map[string]bool{}
+	for i, raw := range emails {
+		email, err := validation.NormalizeEmail(raw, h.Config.StripPlusAlias)
+		if err != nil {
+			e := apperr.As(err)
+			e.Field = fmt.Sprintf("emails[%d]", i)
+			return BatchResponse{}, e
+		}
+		normalized[raw] = email
+		if !seen[email] {
+			seen[email] = true
+			distinct = append(distinct, email)
+		}
+	}
+
+	if err := h.Limiter.Charge(ctx, r, h.Limits, len(distinct)); err != nil {
+		return BatchResponse{}, err
+	}
+
+	found, err := UsersExist(ctx, distinct, h.Users)
+	if err != nil {
+		return BatchResponse{}, err
+	}
+
+	results := make(map[string]bool, len(emails))
+	for raw, email := range normalized {
+		results[raw] = found[email]
+	}
+	return BatchResponse{Results: results}, nil
+}
@@ -0,0 +1,97 @@
+package checkuserexists
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"troggle-backend/internal/metrics" // CloudWatch EMF metrics
+)
+
+const (
+	// minLatency and maxJitter shape the response time in uniform mode:
+	// every answer takes at least minLatency plus a random extra, hiding the
+	// difference between a hit, a miss, and an invalid address.
+	minLatency = 150 * time.Millisecond
+	maxJitter  = 100 * time.Millisecond
+
+	// missWindow and missThreshold define a suspected enumeration: one source
+	// IP checking missThreshold unknown emails within missWindow.
+	missWindow    = 10 * time.Minute
+	missThreshold = 20
+
+	// maxTrackedIPs bounds the memory of the miss tracker.
+	maxTrackedIPs = 10000
+)
+
+// UniformResponse is returned for every check in uniform mode, whatever the
+// outcome.
+type UniformResponse struct {
+	Message string `json:"message"`
+}
+
+// uniformMessage is the body of every UniformResponse.
+const uniformMessage = "Request received"
+
+// missTracker counts lookups of unknown emails per source IP over a sliding
+// window. It lives in the warm container, so it sees a share of the traffic
+// rather than all of it; the metric it emits is meant to be alarmed on, not
+// to block anyone (that is the rate limiter's job).
+type missTracker struct {
+	mu     sync.Mutex
+	counts map[string]*missCount
+}
+
+type missCount struct {
+	since   time.Time
+	misses  int
+	flagged bool
+}
+
+func newMissTracker() *missTracker {
+	return &missTracker{counts: map[string]*missCount{}}
+}
+
+// observe records misses lookups of unknown emails from ip and flags the IP
+// the first time it crosses the threshold within a window.
+func (t *missTracker) observe(ctx context.Context, ip string, misses int) {
+	if t == nil || ip == "" || misses == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	c, ok := t.counts[ip]
+	if !ok || now.Sub(c.since) > missWindow {
+		if len(t.counts) >= maxTrackedIPs {
+			t.counts = map[string]*missCount{}
+		}
+		c = &missCount{since: now}
+		t.counts[ip] = c
+	}
+	c.misses += misses
+	suspected := c.misses >= missThreshold && !c.flagged
+	if suspected {
+		c.flagged = true
+	}
+	total := c.misses
+	t.mu.Unlock()
+
+	if suspected {
+		slog.WarnContext(ctx, "Suspected account enumeration", slog.String("source_ip", ip),
+			"misses", total, "window", missWindow.String())
+		metrics.Count(ctx, metrics.EnumerationSuspected)
+	}
+}
+
+// countMisses returns how many results are false.
+func countMisses(results map[string]bool) int {
+	n := 0
+	for _, exists := range results {
+		if !exists {
+			n++
+		}
+	}
+	return n
+}
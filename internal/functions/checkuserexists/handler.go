@@ -0,0 +1,254 @@
+// Package checkuserexists answers whether accounts exist for one or more
+// email addresses.
+package checkuserexists
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/metrics"    // CloudWatch EMF metrics
+	"troggle-backend/internal/ratelimit"  // DynamoDB token buckets
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/timing"     // response latency padding
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// rateLimits keep the endpoint from being used to enumerate accounts. They
+// can be tuned per stage through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(30),
+	PerUser: ratelimit.PerMinute(60),
+}
+
+// Request represents the JSON input. Either Email (single check) or Emails
+// (batch of up to 100) is set.
+type Request struct {
+	Email  string   `json:"email"`            // User email to check
+	Emails []string `json:"emails,omitempty"` // Batch of emails to check
+
+	// Consistent makes a single-email check that misses the (eventually
+	// consistent) email index confirm with a strongly consistent read, so a
+	// user who signed up a moment ago is found. It costs one more read.
+	Consistent bool `json:"consistent,omitempty"`
+}
+
+// errUserNotFound answers checks of emails without an account.
+var errUserNotFound = &apperr.Error{Kind: apperr.KindNotFound, Code: "USER_NOT_FOUND", Message: "User not found"}
+
+// Response represents the JSON output
+type Response struct {
+	Exists bool `json:"exists"`
+
+	// Duplicate is set in integrity mode when several records share the
+	// email. Only callers holding users:list and direct invocations see it.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// UserExists checks if a user with the given email exists, using the email
+// Global Secondary Index rather than the cognito user_id key.
+// Returns true if the user exists, false if not, and an error if the lookup
+// itself failed, so callers can tell "missing" from "backend broken".
+func UserExists(ctx context.Context, email string, repo *users.Repository) (bool, error) {
+	start := time.Now()
+	slog.InfoContext(ctx, "Checking if user exists", logging.EmailHash(email), "table", repo.Table)
+
+	userID, err := repo.IDByEmail(ctx, email)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching item from DynamoDB", logging.EmailHash(email), logging.Err(err), logging.Latency(start))
+		return false, err
+	}
+
+	if userID != "" {
+		slog.InfoContext(ctx, "User found", logging.EmailHash(email), logging.Latency(start))
+		metrics.Count(ctx, metrics.LookupHit)
+		return true, nil
+	}
+
+	slog.InfoContext(ctx, "User not found", logging.EmailHash(email), logging.Latency(start))
+	metrics.Count(ctx, metrics.LookupMiss)
+	return false, nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "checkUserExists",
+	Function: "checkUserExists",
+	Summary:  "Reports whether an account exists for an email",
+	Method:   "POST",
+	Path:     "/users/exists",
+	Request:  Request{},
+	Response: Response{},
+	Scopes:   []string{"troggle/users.read"},
+	Groups:   []string{"admin"},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+// It is built once at cold start so warm invocations reuse the same client.
+type Handler struct {
+	Users   *users.Repository
+	Limiter *ratelimit.Limiter
+	Limits  ratelimit.Policy
+	Auth    auth.TokenVerifier // set in authenticated mode
+	Misses  *missTracker       // flags source IPs probing many unknown emails
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+
+	h := &Handler{
+		Users:   users.NewRepository(client, cfg),
+		Limiter: ratelimit.New(client, cfg),
+		Limits:  limits,
+		Misses:  newMissTracker(),
+		Config:  cfg,
+	}
+
+	// In authenticated mode only signed-in callers may check emails
+	if cfg.ExistenceCheckMode == config.ExistenceCheckAuthenticated {
+		verifier, err := sessions.NewVerifier(client, cfg)
+		if err != nil {
+			return nil, err
+		}
+		h.Auth = verifier
+	}
+	return h, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies.
+func (h *Handler) HTTP() httpx.Handler {
+	handler := h.Limiter.Wrap(h.Limits, h.Handle)
+	if h.Auth != nil {
+		handler = auth.Require(h.Auth, handler)
+	}
+	return handler
+}
+
+// Handle extracts the email from the request body, checks DynamoDB, and
+// returns JSON: 200 when the user exists, a USER_NOT_FOUND 404 when it does
+// not, and an error status (500, or 429 when DynamoDB throttles) when the
+// lookup fails. A body with an "emails" list is answered with a per-email
+// existence map instead. The request may come from API Gateway or a direct
+// invocation.
+//
+// In uniform mode (EXISTENCE_CHECK_MODE=uniform) every successful check is
+// answered with the same 200 body, padded to a randomized minimum latency, so
+// the response reveals nothing about which emails have accounts.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	uniform := h.Config.ExistenceCheckMode == config.ExistenceCheckUniform
+	if uniform {
+		defer timing.Pad(ctx, time.Now(), minLatency, maxJitter)
+	}
+
+	var req Request
+
+	// Parse JSON body
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+
+	if req.Emails != nil {
+		resp, err := h.handleBatch(ctx, r, req.Emails)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		h.Misses.observe(ctx, r.SourceIP, countMisses(resp.Results))
+		if uniform {
+			return httpx.JSON(200, UniformResponse{Message: uniformMessage}), nil
+		}
+		return httpx.JSON(200, resp), nil
+	}
+
+	// Normalize and validate before touching the database
+	email, err := validation.NormalizeEmail(req.Email, h.Config.StripPlusAlias)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	// Check if the user exists
+	exists, duplicate, err := h.lookup(ctx, email, req.Consistent)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if !exists {
+		h.Misses.observe(ctx, r.SourceIP, 1)
+	}
+	if uniform {
+		return httpx.JSON(200, UniformResponse{Message: uniformMessage}), nil
+	}
+	if !exists {
+		return httpx.Error(errUserNotFound), nil
+	}
+
+	resp := Response{Exists: true}
+	if duplicate && (r.Direct || authz.ClaimsAllow(r, authz.UsersList)) {
+		resp.Duplicate = true
+	}
+	return httpx.JSON(200, resp), nil
+}
+
+// lookup reports whether email has an account. In integrity mode
+// (EMAIL_INTEGRITY_CHECK=true) it reads every record of the email rather
+// than stopping at the first, and also reports whether there are several.
+// With consistent set, an index miss is confirmed against the email
+// reservation, which is read with strong consistency.
+func (h *Handler) lookup(ctx context.Context, email string, consistent bool) (exists, duplicate bool, err error) {
+	if h.Config.EmailIntegrityCheck {
+		exists, duplicate, err = h.checkIntegrity(ctx, email)
+	} else {
+		exists, err = UserExists(ctx, email, h.Users)
+	}
+	if err != nil {
+		return false, false, err
+	}
+	if exists || !consistent {
+		return exists, duplicate, nil
+	}
+
+	owner, err := h.Users.ReservedBy(ctx, email)
+	if err != nil {
+		return false, false, err
+	}
+	if owner == "" {
+		return false, false, nil
+	}
+	// The index has not caught up with a recent sign-up yet
+	slog.InfoContext(ctx, "User found by consistent read", logging.EmailHash(email), "user_id", owner)
+	metrics.Count(ctx, metrics.ConsistentReadHit)
+	return true, false, nil
+}
+
+// checkIntegrity reads every record of email and reports whether there is
+// at least one, and whether there are several.
+func (h *Handler) checkIntegrity(ctx context.Context, email string) (exists, duplicate bool, err error) {
+	ids, err := h.Users.IDsByEmail(ctx, email)
+	if err != nil {
+		return false, false, err
+	}
+	if len(ids) == 0 {
+		metrics.Count(ctx, metrics.LookupMiss)
+		return false, false, nil
+	}
+	metrics.Count(ctx, metrics.LookupHit)
+	return true, len(ids) > 1, nil
+}
@@ -0,0 +1,311 @@
+package checkuserexists
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/users"
+)
+
+// existing answers queries for the given (normalized) emails with one item.
+func existing(emails ...string) func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		var email string
+		for _, v := range in.ExpressionAttributeValues { // the one value is the email
+			email = v.(*types.AttributeValueMemberS).Value
+		}
+		for _, e := range emails {
+			if e == email {
+				return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u-"+e, "email", e)}}, nil
+			}
+		}
+		return &dynamodb.QueryOutput{}, nil
+	}
+}
+
+// hitPage is a query page holding one record of jane@example.com.
+var hitPage = &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1", "email", "jane@example.com")}}
+
+// pages answers successive queries with the given pages.
+func pages(outputs ...*dynamodb.QueryOutput) func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		out := outputs[0]
+		outputs = outputs[1:]
+		return out, nil
+	}
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		UserTableName:      "users",
+		EmailIndexName:     "email-index",
+		ExistenceCheckMode: config.ExistenceCheckOpen,
+	}
+}
+
+func TestUserExists(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+		want    bool
+		wantErr bool // expect a throttling error
+	}{
+		{name: "hit", query: existing("jane@example.com"), want: true},
+		{name: "miss", query: existing("john@example.com"), want: false},
+		{
+			name:  "hit on a later page",
+			query: pages(&dynamodb.QueryOutput{LastEvaluatedKey: dbtest.Item("user_id", "x", "email", "x")}, hitPage),
+			want:  true,
+		},
+		{
+			name:  "miss across pages",
+			query: pages(&dynamodb.QueryOutput{LastEvaluatedKey: dbtest.Item("user_id", "x", "email", "x")}, &dynamodb.QueryOutput{}),
+			want:  false,
+		},
+		{
+			name:    "throttled",
+			query:   func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) { return nil, dbtest.Throttled() },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: tt.query}
+			got, err := UserExists(context.Background(), "jane@example.com", users.NewRepository(m.Client(), testConfig()))
+			if tt.wantErr {
+				if err == nil || apperr.KindOf(err) != apperr.KindThrottled {
+					t.Fatalf("err = %v, want a throttling error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("UserExists = %v, want %v", got, tt.want)
+			}
+
+			in := m.Calls[0].Input.(*dynamodb.QueryInput)
+			if aws.ToString(in.IndexName) != "email-index" || aws.ToString(in.TableName) != "users" {
+				t.Errorf("queried %s/%s", aws.ToString(in.TableName), aws.ToString(in.IndexName))
+			}
+			if aws.ToInt32(in.Limit) != 1 {
+				t.Errorf("Limit = %d, want the single-item fast path", aws.ToInt32(in.Limit))
+			}
+		})
+	}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		body       string
+		query      func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+		get        func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		integrity  bool
+		wantStatus int
+		wantBody   string // substring
+		hideBody   string // substring that must not appear
+		wantCalls  int
+	}{
+		{
+			name:       "exists",
+			body:       `{"email":" Jane@Example.com "}`,
+			query:      existing("jane@example.com"),
+			wantStatus: 200, wantBody: `"exists":true`, wantCalls: 1,
+		},
+		{
+			name:       "does not exist",
+			body:       `{"email":"john@example.com"}`,
+			query:      existing("jane@example.com"),
+			wantStatus: 404, wantBody: `"code":"USER_NOT_FOUND"`, wantCalls: 1,
+		},
+		{
+			name:       "invalid email",
+			body:       `{"email":"not-an-email"}`,
+			wantStatus: 422, wantBody: "EMAIL_INVALID",
+		},
+		{
+			name:       "malformed body",
+			body:       `[1,2`,
+			wantStatus: 400,
+		},
+		{
+			name:       "throttled",
+			body:       `{"email":"jane@example.com"}`,
+			query:      func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) { return nil, dbtest.Throttled() },
+			wantStatus: 429, wantCalls: 1,
+		},
+		{
+			name:       "batch",
+			body:       `{"emails":["jane@example.com","JANE@example.com","john@example.com"]}`,
+			query:      existing("jane@example.com"),
+			wantStatus: 200, wantBody: `"JANE@example.com":true`, wantCalls: 2,
+		},
+		{
+			name:       "batch with invalid email",
+			body:       `{"emails":["jane@example.com","nope"]}`,
+			wantStatus: 422, wantBody: `"field":"emails[1]"`,
+		},
+		{
+			name:       "batch too large",
+			body:       `{"emails":[` + strings.Repeat(`"a@b.co",`, maxBatchEmails) + `"a@b.co"]}`,
+			wantStatus: 422, wantBody: "TOO_MANY_EMAILS",
+		},
+		{
+			name:       "integrity mode reports duplicates",
+			integrity:  true,
+			body:       `{"email":"jane@example.com"}`,
+			query:      pages(&dynamodb.QueryOutput{Items: hitPage.Items, LastEvaluatedKey: hitPage.Items[0]}, hitPage),
+			wantStatus: 200, wantBody: `"duplicate":true`, wantCalls: 2,
+		},
+		{
+			name:       "integrity mode hides duplicates from API callers",
+			integrity:  true,
+			body:       `{"httpMethod":"POST","path":"/users/exists","body":"{\"email\":\"jane@example.com\"}"}`,
+			query:      pages(&dynamodb.QueryOutput{Items: hitPage.Items, LastEvaluatedKey: hitPage.Items[0]}, hitPage),
+			wantStatus: 200, wantBody: `"exists":true`, hideBody: "duplicate", wantCalls: 2,
+		},
+		{
+			name:  "consistent read finds a fresh sign-up",
+			body:  `{"email":"jane@example.com","consistent":true}`,
+			query: existing(),
+			get: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+				if in.Key["user_id"].(*types.AttributeValueMemberS).Value != "EMAIL#jane@example.com" || !aws.ToBool(in.ConsistentRead) {
+					return &dynamodb.GetItemOutput{}, nil
+				}
+				return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "EMAIL#jane@example.com", "owner", "u1")}, nil
+			},
+			wantStatus: 200, wantBody: `"exists":true`, wantCalls: 2,
+		},
+		{
+			name:       "consistent read confirms a miss",
+			body:       `{"email":"john@example.com","consistent":true}`,
+			query:      existing(),
+			wantStatus: 404, wantCalls: 2,
+		},
+		{
+			name:       "consistent read skipped on a hit",
+			body:       `{"email":"jane@example.com","consistent":true}`,
+			query:      existing("jane@example.com"),
+			wantStatus: 200, wantCalls: 1,
+		},
+		{
+			name:       "uniform mode hides existence",
+			mode:       config.ExistenceCheckUniform,
+			body:       `{"email":"john@example.com"}`,
+			query:      existing("jane@example.com"),
+			wantStatus: 200, wantBody: uniformMessage, wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: tt.query, GetItemFunc: tt.get}
+			cfg := testConfig()
+			if tt.mode != "" {
+				cfg.ExistenceCheckMode = tt.mode
+			}
+			cfg.EmailIntegrityCheck = tt.integrity
+			h := &Handler{Users: users.NewRepository(m.Client(), cfg), Config: cfg}
+
+			resp, err := httpx.Adapt(h.Handle)(context.Background(), json.RawMessage(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+			if tt.hideBody != "" && strings.Contains(resp.Body, tt.hideBody) {
+				t.Errorf("body = %s, want no %s", resp.Body, tt.hideBody)
+			}
+			if len(m.Calls) != tt.wantCalls {
+				t.Errorf("%d DynamoDB calls, want %d", len(m.Calls), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBatchRateLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		emails     int
+		wantStatus int
+		wantCode   string
+		wantTokens string // left in the caller's bucket
+	}{
+		{name: "within the tokens left", emails: 4, wantStatus: 200, wantTokens: "1.000"},
+		{name: "beyond the tokens left", emails: 6, wantStatus: 429, wantCode: "RATE_LIMITED", wantTokens: "4.000"},
+		{name: "beyond the burst", emails: 11, wantStatus: 422, wantCode: "REQUEST_TOO_LARGE", wantTokens: "4.000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The caller's bucket holds 5 of its 10 tokens
+			bucket := dbtest.Item("bucket", "#ip#198.51.100.7")
+			bucket["tokens"] = &types.AttributeValueMemberN{Value: "5"}
+			bucket["updated_at"] = &types.AttributeValueMemberN{Value: fmt.Sprint(time.Now().UnixMilli())}
+			m := &dbtest.Mock{
+				QueryFunc: existing("u0@example.com"),
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: bucket}, nil
+				},
+				PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					bucket = in.Item
+					return &dynamodb.PutItemOutput{}, nil
+				},
+			}
+			cfg := testConfig()
+			h := &Handler{
+				Users:   users.NewRepository(m.Client(), cfg),
+				Limiter: &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+				Limits:  ratelimit.Policy{PerIP: ratelimit.PerMinute(10)},
+				Misses:  newMissTracker(),
+				Config:  cfg,
+			}
+
+			emails := make([]string, tt.emails)
+			for i := range emails {
+				emails[i] = fmt.Sprintf("u%d@example.com", i)
+			}
+			body, _ := json.Marshal(Request{Emails: emails})
+			event, _ := json.Marshal(map[string]any{
+				"httpMethod":     "POST",
+				"path":           "/users/exists",
+				"body":           string(body),
+				"requestContext": map[string]any{"identity": map[string]string{"sourceIp": "198.51.100.7"}},
+			})
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus || !strings.Contains(resp.Body, tt.wantCode) {
+				t.Fatalf("status = %d (%s), want %d %s", resp.StatusCode, resp.Body, tt.wantStatus, tt.wantCode)
+			}
+			if got := bucket["tokens"].(*types.AttributeValueMemberN).Value; got[:5] != tt.wantTokens {
+				t.Errorf("tokens left = %s, want %s", got, tt.wantTokens)
+			}
+			if tt.wantStatus != 200 && slices.Contains(m.Ops(), "Query") {
+				t.Errorf("DynamoDB calls = %v, want no lookups", m.Ops())
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Package checkusernameavailable answers whether a username can be claimed
+// (POST /usernames/availability), so clients can check a handle as it is
+// typed. Claiming it is a profile update; see package updateuserprofile.
+package checkusernameavailable
+
+import (
+	"context"
+	"fmt"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/ratelimit"  // DynamoDB token buckets
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// rateLimits are generous enough for checks as the user types. They can be
+// tuned per stage through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(120),
+	PerUser: ratelimit.PerMinute(60),
+}
+
+// Request represents the JSON input.
+type Request struct {
+	Username string `json:"username"`
+}
+
+// Response represents the JSON output. Username is the normalized form the
+// name would be stored as.
+type Response struct {
+	Username  string `json:"username"`
+	Available bool   `json:"available"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "checkUsernameAvailable",
+	Function: "checkUsernameAvailable",
+	Summary:  "Reports whether a username is free",
+	Method:   "POST",
+	Path:     "/usernames/availability",
+	Request:  Request{},
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users   *users.Repository
+	Limiter *ratelimit.Limiter
+	Limits  ratelimit.Policy
+	Auth    auth.TokenVerifier
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Users:   users.NewRepository(client, cfg),
+		Limiter: ratelimit.New(client, cfg),
+		Limits:  limits,
+		Auth:    verifier,
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle normalizes the requested username and reports whether it is free.
+// Names that are malformed or reserved are answered with 422 and the
+// validation code, so clients can say why. A name the caller already holds
+// counts as available.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+
+	username, err := validation.NormalizeUsername(req.Username)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	owner, err := h.Users.UsernameOwner(ctx, username)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	available := owner == ""
+	if id, ok := auth.FromContext(ctx); ok && id.Subject == owner {
+		available = true
+	}
+	return httpx.JSON(200, Response{Username: username, Available: available}), nil
+}
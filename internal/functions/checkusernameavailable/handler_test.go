@@ -0,0 +1,88 @@
+package checkusernameavailable
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a POST /usernames/availability REST API event.
+func apiEvent(token, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/usernames/availability",
+		"headers":    map[string]string{"Authorization": "Bearer " + token},
+		"body":       body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	// u1 holds "jane.doe"
+	held := func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		if in.Key["user_id"].(*types.AttributeValueMemberS).Value != "UNAME#janedoe" || !aws.ToBool(in.ConsistentRead) {
+			return &dynamodb.GetItemOutput{}, nil
+		}
+		return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "UNAME#janedoe", "owner", "u1")}, nil
+	}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "free", payload: apiEvent("valid", `{"username":"John"}`), caller: "u2", wantStatus: 200, wantBody: `{"username":"john","available":true}`},
+		{name: "taken", payload: apiEvent("valid", `{"username":"jane.doe"}`), caller: "u2", wantStatus: 200, wantBody: `"available":false`},
+		{name: "confusable with a taken one", payload: apiEvent("valid", `{"username":"Jane_D0e"}`), caller: "u2", wantStatus: 200, wantBody: `"available":false`},
+		{name: "held by the caller", payload: apiEvent("valid", `{"username":"jane_doe"}`), caller: "u1", wantStatus: 200, wantBody: `"available":true`},
+		{name: "reserved", payload: apiEvent("valid", `{"username":"admin"}`), caller: "u2", wantStatus: 422, wantBody: "USERNAME_RESERVED"},
+		{name: "invalid", payload: apiEvent("valid", `{"username":"a b"}`), caller: "u2", wantStatus: 422, wantBody: "USERNAME_INVALID"},
+		{name: "no token", payload: apiEvent("", `{"username":"john"}`), wantStatus: 401},
+		{name: "direct invocation", payload: json.RawMessage(`{"username":"jane.doe"}`), wantStatus: 200, wantBody: `"available":false`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: held}
+			cfg := &config.Config{UserTableName: "users"}
+			h := &Handler{
+				Users:  users.NewRepository(m.Client(), cfg),
+				Auth:   stubVerifier{id: &auth.Identity{Subject: tt.caller}},
+				Config: cfg,
+			}
+
+			resp, err := httpx.Adapt(auth.Require(h.Auth, h.Handle))(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
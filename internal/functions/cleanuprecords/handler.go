@@ -0,0 +1,51 @@
+// Package cleanuprecords removes the stale and orphaned records the rest
+// of the backend leaves behind. An EventBridge schedule runs it daily and
+// the counts it removed are published as metrics; see package cleanup.
+package cleanuprecords
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events" // Lambda event payloads
+
+	"troggle-backend/internal/cleanup" // stale and orphaned record cleanup
+	"troggle-backend/internal/config"  // environment-driven settings
+	"troggle-backend/internal/db"      // shared DynamoDB client
+	"troggle-backend/internal/metrics" // CloudWatch EMF metrics
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Cleanup *cleanup.Sweeper
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	return &Handler{Cleanup: cleanup.NewSweeper(client, cfg), Config: cfg}, nil
+}
+
+// Handle runs the cleanup. The counts are published even when a sweep
+// failed, for what was removed before the failure; failing the invocation
+// only marks the run as failed, as the next run finds whatever was left.
+func (h *Handler) Handle(ctx context.Context, _ events.CloudWatchEvent) error {
+	ctx, rec := metrics.NewContext(ctx)
+	defer rec.Flush()
+
+	report, err := h.Cleanup.Run(ctx, time.Now())
+	metrics.Add(ctx, metrics.CleanupSessionsRemoved, float64(report.Sessions), metrics.UnitCount)
+	metrics.Add(ctx, metrics.CleanupInvitationsRemoved, float64(report.Invitations), metrics.UnitCount)
+	metrics.Add(ctx, metrics.CleanupDevicesRemoved, float64(report.Devices), metrics.UnitCount)
+	metrics.Add(ctx, metrics.CleanupAccountsRemoved, float64(report.Accounts), metrics.UnitCount)
+	slog.InfoContext(ctx, "Cleanup finished",
+		"sessions", report.Sessions, "invitations", report.Invitations,
+		"devices", report.Devices, "accounts", report.Accounts)
+	return err
+}
@@ -0,0 +1,44 @@
+package cleanuprecords
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/cleanup"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name    string
+		scanErr error
+		wantErr bool
+	}{
+		{name: "nothing to remove"},
+		{name: "failing scan", scanErr: dbtest.Throttled(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{ScanFunc: func(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+				return &dynamodb.ScanOutput{}, tt.scanErr
+			}}
+			h := &Handler{
+				Cleanup: &cleanup.Sweeper{DB: m.Client(), UserTable: "users", SessionTable: "sessions", DeviceTable: "devices", RelationshipTable: "relationships", Segments: 2, BatchRate: 10},
+				Config:  &config.Config{},
+			}
+
+			err := h.Handle(context.Background(), events.CloudWatchEvent{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			// Every table is swept, even after one failed
+			if scans := len(m.Ops()); scans != 8 {
+				t.Errorf("%d scans, want both segments of 4 tables", scans)
+			}
+		})
+	}
+}
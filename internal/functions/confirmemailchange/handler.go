@@ -0,0 +1,284 @@
+// Package confirmemailchange redeems the links requestEmailChange sends
+// (POST /users/{user_id}/email-change/confirm). The token proves the user
+// reads the new mailbox; the record then moves to the new address in one
+// transaction with the email reservations, which settles two accounts racing
+// for the same address, the email index following the record. The Cognito
+// account is updated to match, with the address marked verified, and the
+// previous address is told of the change.
+//
+// The links resendVerification sends are changes from the account's address
+// to itself: confirming one marks the address verified, on the record and
+// in Cognito, and nothing is mailed.
+package confirmemailchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"                             // aws package
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider" // Cognito user pool client
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/sqs" // SQS client for the email queue
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"       // audit log
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/email"       // transactional email
+	"troggle-backend/internal/emailchange" // email change tokens
+	"troggle-backend/internal/events"      // domain event publishing
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"     // structured JSON logging
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/users"       // user table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// Request represents the JSON input. API Gateway callers name the account
+// in the path.
+type Request struct {
+	UserID string `json:"user_id"`
+	Token  string `json:"token"` // from the confirmation link
+}
+
+// Response is the account's address after the change.
+type Response struct {
+	Email   string `json:"email"`
+	Version int    `json:"version"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "confirmEmailChange",
+	Function: "confirmEmailChange",
+	Summary:  "Moves an account to the address of a confirmation link",
+	Method:   "POST",
+	Path:     "/users/{user_id}/email-change/confirm",
+	Request:  Request{},
+	Response: Response{},
+}}
+
+// authorize lets callers confirm changes of their own account only. Direct
+// invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID {
+		return apperr.Forbidden("You may only change your own email address")
+	}
+	return nil
+}
+
+// CognitoAPI is the part of the Cognito user pool API the change uses.
+type CognitoAPI interface {
+	AdminUpdateUserAttributes(ctx context.Context, params *cognitoidentityprovider.AdminUpdateUserAttributesInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminUpdateUserAttributesOutput, error)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users   *users.Repository
+	Tokens  *emailchange.Codec
+	Cognito CognitoAPI
+	Email   *email.Queue
+	Auth    auth.TokenVerifier
+	Events  *events.Publisher
+	Audit   *audit.Store
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// Cognito user pool and the email queue.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireUserPool(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	queue, err := email.NewQueue(sqs.NewFromConfig(awsCfg), cfg)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := emailchange.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Users:   users.NewRepository(client, cfg),
+		Tokens:  tokens,
+		Cognito: cognitoidentityprovider.NewFromConfig(awsCfg),
+		Email:   queue,
+		Auth:    verifier,
+		Events:  events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Audit:   audit.NewStore(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle moves the account to the new address of the token and answers 200
+// with it. Tokens that are invalid, expired or of another account answer
+// 422 INVALID_TOKEN, a change already confirmed or overtaken by another 409
+// EMAIL_CHANGED, and an address taken since the request 409 EMAIL_TAKEN.
+// Verification links of an address verified already answer 409
+// EMAIL_ALREADY_VERIFIED.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.UserID = r.PathParams["user_id"]
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	change, err := h.Tokens.Decode(ctx, req.Token)
+	if apperr.KindOf(err) == apperr.KindInvalid {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if change.UserID != req.UserID {
+		return httpx.Error(emailchange.ErrInvalidToken), nil
+	}
+	ctx = logging.With(ctx, "user_id", req.UserID)
+	if change.Verification() {
+		return h.verify(ctx, r, change)
+	}
+
+	now := time.Now().UTC()
+	version, err := h.Users.ChangeEmail(ctx, req.UserID, change.OldEmail, change.NewEmail, now)
+	if errors.Is(err, users.ErrEmailChanged) || errors.Is(err, users.ErrEmailTaken) {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	// Cognito is updated second: the transaction decides who gets the
+	// address, and is undone if the sign-in address cannot follow
+	if err := h.setCognitoEmail(ctx, req.UserID, change.NewEmail); err != nil {
+		if _, rerr := h.Users.ChangeEmail(ctx, req.UserID, change.NewEmail, change.OldEmail, time.Now()); rerr != nil {
+			slog.ErrorContext(ctx, "Failed to restore the email of a change Cognito refused", logging.Err(rerr))
+		}
+		var alias *cognitotypes.AliasExistsException
+		if errors.As(err, &alias) {
+			return httpx.Error(users.ErrEmailTaken), nil
+		}
+		return httpx.Response{}, fmt.Errorf("updating Cognito email: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Email changed", logging.EmailHash(change.NewEmail))
+	name := "there"
+	if item, err := h.Users.Get(ctx, req.UserID, []string{"display_name"}); err == nil && item != nil {
+		if user, err := db.Decode[users.User](item); err == nil && user.DisplayName != "" {
+			name = user.DisplayName
+		}
+	}
+	err = h.Email.Enqueue(ctx, email.Message{
+		Template: email.TemplateEmailChanged,
+		To:       change.OldEmail,
+		UserID:   req.UserID,
+		Data:     map[string]string{"name": name, "old_email": change.OldEmail, "new_email": change.NewEmail},
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to notify the previous address of an email change", logging.Err(err))
+	}
+
+	ts := now.Format(time.RFC3339)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionUserUpdate,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff: audit.Diff(
+			map[string]any{"email": change.OldEmail, "version": version - 1},
+			map[string]any{"email": change.NewEmail, "version": version},
+		),
+		At: ts,
+	})
+	h.Events.Emit(ctx, events.ProfileUpdated{UserID: req.UserID, Changed: []string{"email"}, Version: version, UpdatedAt: ts})
+	return httpx.JSON(200, Response{Email: change.NewEmail, Version: version}), nil
+}
+
+// verify marks the address of change verified and answers 200 with it.
+func (h *Handler) verify(ctx context.Context, r *httpx.Request, change emailchange.Change) (httpx.Response, error) {
+	now := time.Now().UTC()
+	version, err := h.Users.VerifyEmail(ctx, change.UserID, change.NewEmail, now)
+	if errors.Is(err, users.ErrEmailChanged) || errors.Is(err, users.ErrEmailVerified) {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	// The record is what the guard reads; Cognito catching up later is
+	// harmless, so a failure is logged rather than undoing the verification
+	_, err = h.Cognito.AdminUpdateUserAttributes(ctx, &cognitoidentityprovider.AdminUpdateUserAttributesInput{
+		UserPoolId:     aws.String(h.Config.UserPoolID),
+		Username:       aws.String(change.UserID),
+		UserAttributes: []cognitotypes.AttributeType{{Name: aws.String("email_verified"), Value: aws.String("true")}},
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to mark the Cognito email verified", logging.Err(err))
+	}
+
+	slog.InfoContext(ctx, "Email verified", logging.EmailHash(change.NewEmail))
+	ts := now.Format(time.RFC3339)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(change.UserID),
+		Action:    audit.ActionUserUpdate,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff: audit.Diff(
+			map[string]any{"email_verified": false, "version": version - 1},
+			map[string]any{"email_verified": true, "version": version},
+		),
+		At: ts,
+	})
+	h.Events.Emit(ctx, events.ProfileUpdated{UserID: change.UserID, Changed: []string{"email_verified"}, Version: version, UpdatedAt: ts})
+	return httpx.JSON(200, Response{Email: change.NewEmail, Version: version}), nil
+}
+
+// setCognitoEmail sets the email of the Cognito account, verified: the
+// confirmation link proved it.
+func (h *Handler) setCognitoEmail(ctx context.Context, userID, address string) error {
+	_, err := h.Cognito.AdminUpdateUserAttributes(ctx, &cognitoidentityprovider.AdminUpdateUserAttributesInput{
+		UserPoolId: aws.String(h.Config.UserPoolID),
+		Username:   aws.String(userID),
+		UserAttributes: []cognitotypes.AttributeType{
+			{Name: aws.String("email"), Value: aws.String(address)},
+			{Name: aws.String("email_verified"), Value: aws.String("true")},
+		},
+	})
+	return err
+}
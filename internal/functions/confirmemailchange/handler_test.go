@@ -0,0 +1,238 @@
+package confirmemailchange
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/email"
+	"troggle-backend/internal/emailchange"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/signing"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeSQS records sent messages.
+type fakeSQS struct{ sent []email.Message }
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	var m email.Message
+	if err := json.Unmarshal([]byte(aws.ToString(in.MessageBody)), &m); err != nil {
+		return nil, err
+	}
+	f.sent = append(f.sent, m)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// fakeCognito records the emails set, and the updates marking the email
+// verified, failing with err.
+type fakeCognito struct {
+	emails   []string
+	verified int
+	err      error
+}
+
+func (f *fakeCognito) AdminUpdateUserAttributes(_ context.Context, in *cognitoidentityprovider.AdminUpdateUserAttributesInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminUpdateUserAttributesOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	for _, a := range in.UserAttributes {
+		switch aws.ToString(a.Name) {
+		case "email":
+			f.emails = append(f.emails, aws.ToString(a.Value))
+		case "email_verified":
+			f.verified++
+		}
+	}
+	return &cognitoidentityprovider.AdminUpdateUserAttributesOutput{}, nil
+}
+
+// apiEvent is a REST API event of the confirmation route.
+func apiEvent(userID, token string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"token": token})
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/email-change/confirm",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           string(body),
+	})
+	return event
+}
+
+// fixture is a handler over mocks, for a user u1 whose record is at the
+// address stored and follows the changes written.
+type fixture struct {
+	h       *Handler
+	db      *dbtest.Mock
+	cognito *fakeCognito
+	queue   *fakeSQS
+	stored  string
+	moves   []string // addresses the record was moved to
+}
+
+func newFixture(stored string) *fixture {
+	f := &fixture{cognito: &fakeCognito{}, queue: &fakeSQS{}, stored: stored}
+	m := &dbtest.Mock{
+		GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			item := dbtest.Item("user_id", "u1", "email", f.stored, "display_name", "Jane")
+			item["version"] = &types.AttributeValueMemberN{Value: "3"}
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		},
+		TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+			f.stored = in.TransactItems[0].Update.ExpressionAttributeValues[":new"].(*types.AttributeValueMemberS).Value
+			f.moves = append(f.moves, f.stored)
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		},
+	}
+	f.db = m
+	cfg := &config.Config{UserTableName: "users", EmailIndexName: "email-index", UserPoolID: "pool"}
+	f.h = &Handler{
+		Users:   users.NewRepository(m.Client(), cfg),
+		Tokens:  emailchange.NewCodec(signing.StaticKey("secret")),
+		Cognito: f.cognito,
+		Email:   &email.Queue{SQS: f.queue, URL: "https://sqs.example/email"},
+		Auth:    stubVerifier{&auth.Identity{Subject: "u1"}},
+		Config:  cfg,
+	}
+	return f
+}
+
+func (f *fixture) token(t *testing.T, userID string, expires time.Time) string {
+	token, err := f.h.Tokens.Encode(context.Background(), emailchange.Change{
+		UserID: userID, OldEmail: "jane@example.com", NewEmail: "jane@example.org", ExpiresAt: expires,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (f *fixture) call(t *testing.T, userID, token string) httpx.Response {
+	resp, err := httpx.Adapt(f.h.HTTP())(context.Background(), apiEvent(userID, token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHandle(t *testing.T) {
+	f := newFixture("jane@example.com")
+	resp := f.call(t, "u1", f.token(t, "u1", time.Now().Add(time.Hour)))
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+	var got Response
+	if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "jane@example.org" || got.Version != 4 {
+		t.Errorf("response = %+v", got)
+	}
+	if len(f.moves) != 1 || f.stored != "jane@example.org" {
+		t.Errorf("moves = %v, want the record moved once", f.moves)
+	}
+	if len(f.cognito.emails) != 1 || f.cognito.emails[0] != "jane@example.org" {
+		t.Errorf("Cognito emails = %v", f.cognito.emails)
+	}
+	if len(f.queue.sent) != 1 || f.queue.sent[0].Template != email.TemplateEmailChanged || f.queue.sent[0].To != "jane@example.com" {
+		t.Errorf("sent = %+v, want the previous address notified", f.queue.sent)
+	}
+}
+
+func TestHandleRefused(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     string // address of the record
+		userID     string // of the path
+		tokenUser  string
+		expires    time.Duration
+		wantStatus int
+	}{
+		{name: "expired", stored: "jane@example.com", userID: "u1", tokenUser: "u1", expires: -time.Minute, wantStatus: 422},
+		{name: "token of another account", stored: "jane@example.com", userID: "u1", tokenUser: "u2", expires: time.Hour, wantStatus: 422},
+		{name: "another user", stored: "jane@example.com", userID: "u2", tokenUser: "u2", expires: time.Hour, wantStatus: 403},
+		{name: "already confirmed", stored: "jane@example.org", userID: "u1", tokenUser: "u1", expires: time.Hour, wantStatus: 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(tt.stored)
+			resp := f.call(t, tt.userID, f.token(t, tt.tokenUser, time.Now().Add(tt.expires)))
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if len(f.moves) != 0 || len(f.cognito.emails) != 0 || len(f.queue.sent) != 0 {
+				t.Errorf("moves = %v, Cognito emails = %v, sent = %v, want nothing changed", f.moves, f.cognito.emails, f.queue.sent)
+			}
+		})
+	}
+}
+
+func TestHandleCognitoRefuses(t *testing.T) {
+	f := newFixture("jane@example.com")
+	f.cognito.err = &cognitotypes.AliasExistsException{Message: aws.String("exists")}
+	resp := f.call(t, "u1", f.token(t, "u1", time.Now().Add(time.Hour)))
+	if resp.StatusCode != 409 {
+		t.Fatalf("status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+	// The record moved, then back when Cognito refused the address
+	if len(f.moves) != 2 || f.stored != "jane@example.com" {
+		t.Errorf("moves = %v, want the record back at the previous address", f.moves)
+	}
+	if len(f.queue.sent) != 0 {
+		t.Errorf("sent = %+v, want nothing", f.queue.sent)
+	}
+}
+
+func TestHandleVerification(t *testing.T) {
+	f := newFixture("jane@example.com")
+	f.db.UpdateItemFunc = func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		return &dynamodb.UpdateItemOutput{Attributes: map[string]types.AttributeValue{
+			"version": &types.AttributeValueMemberN{Value: "4"},
+		}}, nil
+	}
+	token, err := f.h.Tokens.Encode(context.Background(), emailchange.Change{
+		UserID: "u1", OldEmail: "jane@example.com", NewEmail: "jane@example.com", ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := f.call(t, "u1", token)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+	var got Response
+	if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "jane@example.com" || got.Version != 4 {
+		t.Errorf("response = %+v", got)
+	}
+	if len(f.moves) != 0 || len(f.cognito.emails) != 0 || f.cognito.verified != 1 {
+		t.Errorf("moves = %v, Cognito emails = %v, verified %d times; want only the address verified", f.moves, f.cognito.emails, f.cognito.verified)
+	}
+	if len(f.queue.sent) != 0 {
+		t.Errorf("sent = %+v, want nothing", f.queue.sent)
+	}
+}
@@ -0,0 +1,209 @@
+// Package confirmpasswordreset completes the password resets
+// requestPasswordReset starts (POST /password-reset/confirm). The route is
+// public; the code emailed to the account is the credential. A right code
+// sets the new password in Cognito and signs the account out everywhere, so
+// whoever knew the old password loses the sessions they hold.
+package confirmpasswordreset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"                             // aws package
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider" // Cognito user pool client
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/metrics"    // CloudWatch EMF metrics
+	"troggle-backend/internal/otp"        // one-time codes
+	"troggle-backend/internal/ratelimit"  // DynamoDB token buckets
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// rateLimits bound the guesses of one source IP; each code also takes
+// otp.MaxAttempts answers only. They can be tuned per stage through
+// RATE_LIMIT_PER_IP.
+var rateLimits = ratelimit.Policy{
+	PerIP: ratelimit.PerMinute(10),
+}
+
+// Bounds of new passwords. Cognito enforces the rest of the user pool's
+// policy.
+const (
+	minPassword = 8
+	maxPassword = 256
+)
+
+var (
+	// ErrInvalidCode answers wrong, expired and used codes, and addresses
+	// without an account, alike.
+	ErrInvalidCode = apperr.Invalid("INVALID_CODE", "code", "The reset code is invalid or has expired")
+
+	// ErrInvalidPassword refuses passwords the user pool's policy rejects.
+	ErrInvalidPassword = apperr.Invalid("INVALID_PASSWORD", "password", "The password does not meet the requirements")
+)
+
+// Request represents the JSON input.
+type Request struct {
+	Email    string `json:"email"`
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "confirmPasswordReset",
+	Function: "confirmPasswordReset",
+	Summary:  "Sets the password of an account with an emailed reset code",
+	Method:   "POST",
+	Path:     "/password-reset/confirm",
+	Status:   204,
+	Request:  Request{},
+	Public:   true,
+}}
+
+// CognitoAPI is the part of the Cognito user pool API the reset uses.
+type CognitoAPI interface {
+	AdminSetUserPassword(ctx context.Context, params *cognitoidentityprovider.AdminSetUserPasswordInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminSetUserPasswordOutput, error)
+	AdminUserGlobalSignOut(ctx context.Context, params *cognitoidentityprovider.AdminUserGlobalSignOutInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminUserGlobalSignOutOutput, error)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users    *users.Repository
+	Codes    *otp.Store
+	Cognito  CognitoAPI
+	Sessions *sessions.Store
+	Limiter  *ratelimit.Limiter
+	Limits   ratelimit.Policy
+	Audit    *audit.Store
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// Cognito user pool.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireUserPool(); err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Users:    users.NewRepository(client, cfg),
+		Codes:    otp.NewResetStore(client, cfg),
+		Cognito:  cognitoidentityprovider.NewFromConfig(awsCfg),
+		Sessions: sessions.NewStore(client, cfg),
+		Limiter:  ratelimit.New(client, cfg),
+		Limits:   limits,
+		Audit:    audit.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.Limiter.Middleware(h.Limits))
+}
+
+// Handle sets the password of the account of the address in the body when
+// the code is right, and answers 204. Wrong codes answer 422 INVALID_CODE,
+// and passwords the user pool refuses 422 INVALID_PASSWORD; the code is
+// spent by then, so the user must ask for another.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	address, err := validation.NormalizeEmail(req.Email, h.Config.StripPlusAlias)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+	if n := len(req.Password); n < minPassword || n > maxPassword {
+		return httpx.Error(ErrInvalidPassword), nil
+	}
+
+	userID, err := h.Users.IDByEmail(ctx, address)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if userID == "" {
+		metrics.Count(ctx, metrics.PasswordResetFailed)
+		return httpx.Error(ErrInvalidCode), nil
+	}
+	ctx = logging.With(ctx, "user_id", userID)
+	ok, err := h.Codes.Verify(ctx, userID, req.Code)
+	if errors.Is(err, otp.ErrNoCode) {
+		ok, err = false, nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if !ok {
+		slog.InfoContext(ctx, "Password reset code refused")
+		metrics.Count(ctx, metrics.PasswordResetFailed)
+		return httpx.Error(ErrInvalidCode), nil
+	}
+
+	_, err = h.Cognito.AdminSetUserPassword(ctx, &cognitoidentityprovider.AdminSetUserPasswordInput{
+		UserPoolId: aws.String(h.Config.UserPoolID),
+		Username:   aws.String(userID),
+		Password:   aws.String(req.Password),
+		Permanent:  true,
+	})
+	var invalid *cognitotypes.InvalidPasswordException
+	if errors.As(err, &invalid) {
+		return httpx.Error(ErrInvalidPassword), nil
+	}
+	if err != nil {
+		return httpx.Response{}, fmt.Errorf("setting Cognito password: %w", err)
+	}
+	slog.InfoContext(ctx, "Password reset", logging.EmailHash(address))
+
+	// The password is changed: failing to end the old sessions is logged
+	// rather than failing a request the code cannot be replayed for
+	_, err = h.Cognito.AdminUserGlobalSignOut(ctx, &cognitoidentityprovider.AdminUserGlobalSignOutInput{
+		UserPoolId: aws.String(h.Config.UserPoolID),
+		Username:   aws.String(userID),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to sign out a reset account", logging.Err(err))
+	}
+	revoked, err := h.Sessions.RevokeAll(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to revoke the sessions of a reset account", logging.Err(err))
+	}
+
+	metrics.Count(ctx, metrics.PasswordResetCompleted)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionPasswordReset,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff: map[string]audit.Change{
+			"source_ip":        {After: r.SourceIP},
+			"sessions_revoked": {After: revoked},
+		},
+	})
+	return httpx.NoContent(), nil
+}
@@ -0,0 +1,195 @@
+package confirmpasswordreset
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/otp"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/sessions"
+	"troggle-backend/internal/users"
+)
+
+// fakeCognito records the passwords set and the sign-outs, failing password
+// changes with err.
+type fakeCognito struct {
+	passwords []string
+	signOuts  int
+	err       error
+}
+
+func (f *fakeCognito) AdminSetUserPassword(_ context.Context, in *cognitoidentityprovider.AdminSetUserPasswordInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminSetUserPasswordOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.passwords = append(f.passwords, aws.ToString(in.Password))
+	return &cognitoidentityprovider.AdminSetUserPasswordOutput{}, nil
+}
+
+func (f *fakeCognito) AdminUserGlobalSignOut(context.Context, *cognitoidentityprovider.AdminUserGlobalSignOutInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminUserGlobalSignOutOutput, error) {
+	f.signOuts++
+	return &cognitoidentityprovider.AdminUserGlobalSignOutOutput{}, nil
+}
+
+// apiEvent is an anonymous REST API event of the confirmation route.
+func apiEvent(address, code, password string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"email": address, "code": code, "password": password})
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/password-reset/confirm",
+		"body":       string(body),
+	})
+	return event
+}
+
+// fixture is a handler over mocks, for jane@example.com, account u1, whose
+// reset code is kept in code.
+type fixture struct {
+	h       *Handler
+	db      *dbtest.Mock
+	cognito *fakeCognito
+	code    db.Item // the stored code, nil once deleted
+}
+
+func newFixture(t *testing.T) (*fixture, string) {
+	f := &fixture{cognito: &fakeCognito{}}
+	f.db = &dbtest.Mock{
+		QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			if aws.ToString(in.TableName) != "users" {
+				return &dynamodb.QueryOutput{}, nil
+			}
+			for _, v := range in.ExpressionAttributeValues { // the one value is the email
+				if v.(*types.AttributeValueMemberS).Value != "jane@example.com" {
+					return &dynamodb.QueryOutput{}, nil
+				}
+			}
+			return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1")}}, nil
+		},
+		PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			if aws.ToString(in.TableName) == "challenges" {
+				f.code = in.Item
+				f.code["attempts"] = &types.AttributeValueMemberN{Value: "0"}
+			}
+			return &dynamodb.PutItemOutput{}, nil
+		},
+		UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+			if f.code == nil {
+				return nil, dbtest.ConditionFailed()
+			}
+			n, _ := strconv.Atoi(f.code["attempts"].(*types.AttributeValueMemberN).Value)
+			if n >= otp.MaxAttempts {
+				return nil, dbtest.ConditionFailed()
+			}
+			f.code["attempts"] = &types.AttributeValueMemberN{Value: strconv.Itoa(n + 1)}
+			return &dynamodb.UpdateItemOutput{Attributes: f.code}, nil
+		},
+		DeleteItemFunc: func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+			f.code = nil
+			return &dynamodb.DeleteItemOutput{}, nil
+		},
+	}
+	cfg := &config.Config{UserTableName: "users", EmailIndexName: "email-index", SessionTableName: "sessions", UserPoolID: "pool"}
+	codes := &otp.Store{DB: f.db.Client(), Table: "challenges", Prefix: otp.ResetPrefix}
+	f.h = &Handler{
+		Users:    users.NewRepository(f.db.Client(), cfg),
+		Codes:    codes,
+		Cognito:  f.cognito,
+		Sessions: sessions.NewStore(f.db.Client(), cfg),
+		Limiter:  &ratelimit.Limiter{DB: f.db.Client(), Table: "rate-limits"},
+		Config:   cfg,
+	}
+	code, _, err := codes.Issue(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, code
+}
+
+func (f *fixture) call(t *testing.T, address, code, password string) httpx.Response {
+	resp, err := httpx.Adapt(f.h.HTTP())(context.Background(), apiEvent(address, code, password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHandle(t *testing.T) {
+	f, code := newFixture(t)
+	resp := f.call(t, " Jane@Example.com ", code, "correct horse")
+	if resp.StatusCode != 204 {
+		t.Fatalf("status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+	if len(f.cognito.passwords) != 1 || f.cognito.passwords[0] != "correct horse" || f.cognito.signOuts != 1 {
+		t.Errorf("Cognito = %+v, want the password set and the account signed out", f.cognito)
+	}
+	if f.code != nil {
+		t.Error("code not deleted")
+	}
+
+	// The code cannot be used twice
+	resp = f.call(t, "jane@example.com", code, "battery staple")
+	if resp.StatusCode != 422 {
+		t.Errorf("replay status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestHandleRefused(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		code       string // "" for the right one
+		password   string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "wrong code", email: "jane@example.com", code: "x", password: "correct horse", wantStatus: 422, wantCode: "INVALID_CODE"},
+		{name: "unknown address", email: "joe@example.com", password: "correct horse", wantStatus: 422, wantCode: "INVALID_CODE"},
+		{name: "short password", email: "jane@example.com", password: "short", wantStatus: 422, wantCode: "INVALID_PASSWORD"},
+		{name: "invalid address", email: "jane", password: "correct horse", wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, code := newFixture(t)
+			if tt.code != "" {
+				code = tt.code
+			}
+			resp := f.call(t, tt.email, code, tt.password)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			var body struct {
+				Error struct{ Code string }
+			}
+			if err := json.Unmarshal([]byte(resp.Body), &body); err != nil || (tt.wantCode != "" && body.Error.Code != tt.wantCode) {
+				t.Errorf("body = %s, want code %s", resp.Body, tt.wantCode)
+			}
+			if len(f.cognito.passwords) != 0 || f.cognito.signOuts != 0 {
+				t.Errorf("Cognito = %+v, want nothing changed", f.cognito)
+			}
+		})
+	}
+}
+
+func TestHandleWeakPassword(t *testing.T) {
+	f, code := newFixture(t)
+	f.cognito.err = &cognitotypes.InvalidPasswordException{Message: aws.String("weak")}
+	resp := f.call(t, "jane@example.com", code, "password")
+	if resp.StatusCode != 422 {
+		t.Fatalf("status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+	if f.cognito.signOuts != 0 {
+		t.Error("account signed out of a failed reset")
+	}
+}
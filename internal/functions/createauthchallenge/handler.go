@@ -0,0 +1,146 @@
+// Package createauthchallenge is the Cognito CreateAuthChallenge trigger of
+// the passwordless email login. The first challenge of a sign-in issues a
+// one-time code and emails it to the user; the challenges that follow a
+// wrong answer ask for the same code again.
+//
+// Each user can be sent codeLimit codes, whatever the number of sign-ins
+// started: past it, the sign-in fails with ErrTooManyCodes. Unknown users,
+// which DefineAuthChallenge challenges too, are sent nothing.
+package createauthchallenge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"      // Cognito trigger event definitions
+	"github.com/aws/aws-sdk-go-v2/service/sqs" // SQS client for the email queue
+
+	"troggle-backend/internal/awscfg"    // shared AWS SDK configuration
+	"troggle-backend/internal/config"    // environment-driven settings
+	"troggle-backend/internal/db"        // DynamoDB client
+	"troggle-backend/internal/email"     // transactional email
+	"troggle-backend/internal/logging"   // structured JSON logging
+	"troggle-backend/internal/metrics"   // CloudWatch EMF metrics
+	"troggle-backend/internal/otp"       // one-time sign-in codes
+	"troggle-backend/internal/ratelimit" // DynamoDB token buckets
+)
+
+// challengeName is the challenge this trigger creates.
+const challengeName = "CUSTOM_CHALLENGE"
+
+// challengeMetadata tags the challenge in the session Cognito hands the
+// other triggers.
+const challengeMetadata = "EMAIL_OTP"
+
+// codeLimit bounds the codes sent to one user.
+var codeLimit = ratelimit.Limit{Rate: 5.0 / 3600, Burst: 5}
+
+// ErrTooManyCodes fails sign-ins of users over codeLimit. Cognito hands its
+// message to the app, which shows it.
+var ErrTooManyCodes = errors.New("Too many sign-in codes requested. Please try again later.")
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Codes   *otp.Store
+	Email   *email.Queue
+	Limiter *ratelimit.Limiter
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// email queue.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	queue, err := email.NewQueue(sqs.NewFromConfig(awsCfg), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Codes:   otp.NewStore(client, cfg),
+		Email:   queue,
+		Limiter: ratelimit.New(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// Handle creates the challenge of event, sending a code on the first one of
+// the sign-in.
+func (h *Handler) Handle(ctx context.Context, event events.CognitoEventUserPoolsCreateAuthChallenge) (events.CognitoEventUserPoolsCreateAuthChallenge, error) {
+	ctx, recorder := metrics.NewContext(ctx)
+	defer recorder.Flush()
+
+	if event.Request.ChallengeName != challengeName {
+		return event, fmt.Errorf("unexpected challenge %q", event.Request.ChallengeName)
+	}
+	attrs := event.Request.UserAttributes
+	userID, to := attrs["sub"], attrs["email"]
+	event.Response.ChallengeMetadata = challengeMetadata
+	// Unknown users see the address they typed, so the response does not
+	// tell them apart
+	destination := to
+	if destination == "" {
+		destination = event.UserName
+	}
+	event.Response.PublicChallengeParameters = map[string]string{"delivery": "EMAIL", "destination": mask(destination)}
+
+	// Answers are checked against the stored code; a retry keeps it
+	if len(event.Request.Session) > 0 || userID == "" || to == "" {
+		return event, nil
+	}
+	ctx = logging.With(ctx, "user_id", userID)
+
+	wait, err := h.Limiter.Take(ctx, "login_code#"+userID, codeLimit)
+	switch {
+	case err != nil:
+		slog.WarnContext(ctx, "Sign-in code rate limiter unavailable", logging.Err(err))
+	case wait > 0:
+		slog.WarnContext(ctx, "Refusing sign-in code over its rate limit", "retry_after_ms", wait.Milliseconds())
+		metrics.Count(ctx, metrics.RateLimited)
+		return event, ErrTooManyCodes
+	}
+
+	code, _, err := h.Codes.Issue(ctx, userID)
+	if err != nil {
+		return event, err
+	}
+	name := attrs["name"]
+	if name == "" {
+		name = "there"
+	}
+	err = h.Email.Enqueue(ctx, email.Message{
+		Template: email.TemplateLoginCode,
+		To:       to,
+		UserID:   userID,
+		Data: map[string]string{
+			"name":       name,
+			"code":       code,
+			"expires_in": fmt.Sprintf("%d minutes", int(otp.TTL.Minutes())),
+		},
+	})
+	if err != nil {
+		return event, err
+	}
+	slog.InfoContext(ctx, "Sign-in code sent", logging.EmailHash(to))
+	metrics.Count(ctx, metrics.LoginCodeSent)
+	return event, nil
+}
+
+// mask hides most of the local part of address, leaving enough for users
+// to tell which of their addresses the code went to.
+func mask(address string) string {
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || local == "" {
+		return ""
+	}
+	return local[:1] + "***@" + domain
+}
@@ -0,0 +1,137 @@
+package createauthchallenge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/email"
+	"troggle-backend/internal/otp"
+	"troggle-backend/internal/ratelimit"
+)
+
+// fakeSQS records sent messages.
+type fakeSQS struct{ sent []email.Message }
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	var m email.Message
+	if err := json.Unmarshal([]byte(aws.ToString(in.MessageBody)), &m); err != nil {
+		return nil, err
+	}
+	f.sent = append(f.sent, m)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// newHandler returns a handler over mocks, whose rate limit buckets hold
+// tokens tokens.
+func newHandler(tokens float64) (*Handler, *dbtest.Mock, *fakeSQS) {
+	m := &dbtest.Mock{
+		GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			item := dbtest.Item("bucket", "b")
+			item["tokens"], item["updated_at"] = num(tokens), num(time.Now().UnixMilli())
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		},
+	}
+	queue := &fakeSQS{}
+	return &Handler{
+		Codes:   &otp.Store{DB: m.Client(), Table: "challenges"},
+		Email:   &email.Queue{SQS: queue, URL: "https://sqs.example/email"},
+		Limiter: &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+	}, m, queue
+}
+
+func challenge(attrs map[string]string, retries int) events.CognitoEventUserPoolsCreateAuthChallenge {
+	var e events.CognitoEventUserPoolsCreateAuthChallenge
+	e.UserName = "jane@example.com"
+	e.Request.ChallengeName = challengeName
+	e.Request.UserAttributes = attrs
+	for range retries {
+		e.Request.Session = append(e.Request.Session, &events.CognitoEventUserPoolsChallengeResult{ChallengeName: challengeName})
+	}
+	return e
+}
+
+var jane = map[string]string{"sub": "u1", "email": "jane@example.com", "name": "Jane"}
+
+func TestHandle(t *testing.T) {
+	h, m, queue := newHandler(5)
+	got, err := h.Handle(context.Background(), challenge(jane, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.sent) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(queue.sent))
+	}
+	msg := queue.sent[0]
+	if msg.Template != email.TemplateLoginCode || msg.To != "jane@example.com" || len(msg.Data["code"]) != otp.Digits {
+		t.Errorf("email = %+v", msg)
+	}
+	if !stored(m) {
+		t.Errorf("ops = %v, want the code stored", m.Ops())
+	}
+	if got.Response.PublicChallengeParameters["destination"] != "j***@example.com" || got.Response.ChallengeMetadata != challengeMetadata {
+		t.Errorf("response = %+v", got.Response)
+	}
+	tmpl, _ := email.Lookup(msg.Template)
+	if _, err := tmpl.Render(msg.Data); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandleRetry(t *testing.T) {
+	h, m, queue := newHandler(5)
+	if _, err := h.Handle(context.Background(), challenge(jane, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.sent) != 0 || len(m.Calls) != 0 {
+		t.Errorf("retry sent %d emails and made calls %v, want the same code reused", len(queue.sent), m.Ops())
+	}
+}
+
+func TestHandleUnknownUser(t *testing.T) {
+	h, _, queue := newHandler(5)
+	got, err := h.Handle(context.Background(), challenge(nil, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.sent) != 0 {
+		t.Errorf("%d emails sent to an unknown user", len(queue.sent))
+	}
+	if got.Response.PublicChallengeParameters["destination"] != "j***@example.com" {
+		t.Errorf("response = %+v, want it to look like a known user's", got.Response)
+	}
+}
+
+func TestHandleRateLimited(t *testing.T) {
+	h, m, queue := newHandler(0)
+	if _, err := h.Handle(context.Background(), challenge(jane, 0)); !errors.Is(err, ErrTooManyCodes) {
+		t.Fatalf("err = %v, want ErrTooManyCodes", err)
+	}
+	if len(queue.sent) != 0 || stored(m) {
+		t.Errorf("code issued over the limit: ops %v", m.Ops())
+	}
+}
+
+// stored reports whether a code was written to the challenge table.
+func stored(m *dbtest.Mock) bool {
+	for _, c := range m.Calls {
+		if in, ok := c.Input.(*dynamodb.PutItemInput); ok && aws.ToString(in.TableName) == "challenges" {
+			return true
+		}
+	}
+	return false
+}
+
+func num(v any) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: fmt.Sprint(v)}
+}
@@ -0,0 +1,250 @@
+// Package createinvitation invites someone to troggle by email (POST
+// /users/{user_id}/invitations). The invitation is stored with the
+// inviter's entities and its link, carrying a signed code that expires
+// with it, is mailed to the address; acceptInvitation redeems the code once
+// the invitee has an account. See package invitations.
+package createinvitation
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs" // SQS client for the email queue
+
+	"troggle-backend/internal/api"          // API route declarations
+	"troggle-backend/internal/apperr"       // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"        // audit log
+	"troggle-backend/internal/auth"         // Cognito JWT verification
+	"troggle-backend/internal/awscfg"       // shared AWS SDK config
+	"troggle-backend/internal/config"       // environment-driven settings
+	"troggle-backend/internal/db"           // shared DynamoDB client
+	"troggle-backend/internal/email"        // transactional email
+	"troggle-backend/internal/httpx"        // API Gateway / direct invocation adapter
+	"troggle-backend/internal/invitations"  // invitations and referrals
+	"troggle-backend/internal/logging"      // structured JSON logging
+	"troggle-backend/internal/ratelimit"    // DynamoDB token buckets
+	"troggle-backend/internal/sessions"     // session table access
+	"troggle-backend/internal/users"        // user table access
+	"troggle-backend/internal/validation"   // input normalization and validation
+	"troggle-backend/internal/verification" // email verification gate
+)
+
+// rateLimits keep accounts from using invitations to mail strangers. They
+// can be tuned per stage through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(20),
+	PerUser: ratelimit.Limit{Rate: 10.0 / 86400, Burst: 10},
+}
+
+// Request represents the JSON input. API Gateway callers name the inviter
+// in the path.
+type Request struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"` // the invitee's address
+}
+
+// Response is the invitation and its link, which inviters may also share
+// themselves.
+type Response struct {
+	Invitation invitations.Invitation `json:"invitation"`
+	Link       string                 `json:"link"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "createInvitation",
+	Function: "createInvitation",
+	Summary:  "Invites someone to troggle by email",
+	Method:   "POST",
+	Path:     "/users/{user_id}/invitations",
+	Status:   201,
+	Request:  Request{},
+	Response: Response{},
+}}
+
+// authorize lets callers invite as themselves only: referrals are credited
+// to the inviter. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID {
+		return apperr.Forbidden("You may only send invitations as yourself")
+	}
+	return nil
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Invitations *invitations.Store
+	Codes       *invitations.Codec
+	Users       *users.Repository
+	Email       *email.Queue
+	Auth        auth.TokenVerifier
+	Limiter     *ratelimit.Limiter
+	Limits      ratelimit.Policy
+	Verified    *verification.Guard // nil lets unverified accounts through
+	Audit       *audit.Store
+	Config      *config.Config
+	Now         func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// email queue and the invitation link.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireInvitations(); err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	queue, err := email.NewQueue(sqs.NewFromConfig(awsCfg), cfg)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := invitations.NewCodecFromConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Invitations: invitations.NewStore(client, cfg),
+		Codes:       codes,
+		Users:       users.NewRepository(client, cfg),
+		Email:       queue,
+		Auth:        verifier,
+		Limiter:     ratelimit.New(client, cfg),
+		Limits:      limits,
+		Verified:    verification.NewGuard(client, cfg),
+		Audit:       audit.NewStore(client, cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. Accounts whose
+// email address is not verified are refused, like the other endpoints
+// reaching people.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits), h.Verified.Middleware())
+}
+
+// Handle stores the invitation, mails its link and answers 201. Addresses
+// registered already are refused with 409 EMAIL_TAKEN.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.UserID = r.PathParams["user_id"]
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	to, err := validation.NormalizeEmail(req.Email, h.Config.StripPlusAlias)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	item, err := h.Users.Get(ctx, req.UserID, []string{"display_name", "username"})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if item == nil {
+		return httpx.Error(apperr.NotFound("User not found")), nil
+	}
+	inviter, err := db.Decode[users.User](item)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	owner, err := h.Users.Uncached().IDByEmail(ctx, to)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if owner != "" {
+		return httpx.Error(users.ErrEmailTaken), nil
+	}
+
+	now := h.now().UTC().Truncate(time.Second)
+	inv := invitations.Invitation{
+		ID:        invitations.NewID(),
+		InviterID: req.UserID,
+		Email:     to,
+		Status:    invitations.StatusPending,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: now.Add(invitations.TTL).Format(time.RFC3339),
+	}
+	code, err := h.Codes.Encode(ctx, invitations.Code{
+		InviterID:    inv.InviterID,
+		InvitationID: inv.ID,
+		ExpiresAt:    now.Add(invitations.TTL),
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if err := h.Invitations.Create(ctx, inv); err != nil {
+		return httpx.Response{}, err
+	}
+
+	name := inviter.DisplayName
+	if name == "" {
+		name = inviter.Username
+	}
+	if name == "" {
+		name = "A friend"
+	}
+	link := h.Config.InvitationURL + "?code=" + url.QueryEscape(code)
+	err = h.Email.Enqueue(ctx, email.Message{
+		Template: email.TemplateInvitation,
+		To:       to,
+		UserID:   req.UserID,
+		Data: map[string]string{
+			"inviter":    name,
+			"link":       link,
+			"expires_in": fmt.Sprintf("%d days", int(invitations.TTL.Hours()/24)),
+		},
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+
+	slog.InfoContext(ctx, "Invitation sent", "user_id", req.UserID, "invitation_id", inv.ID, logging.EmailHash(to))
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionInvitationCreate,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"invitation_id": {After: inv.ID}},
+	})
+	return httpx.JSON(201, Response{Invitation: inv, Link: link}), nil
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
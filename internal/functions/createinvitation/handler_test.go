@@ -0,0 +1,156 @@
+package createinvitation
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/email"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/invitations"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/signing"
+	"troggle-backend/internal/users"
+	"troggle-backend/internal/verification"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeSQS records sent messages.
+type fakeSQS struct{ sent []email.Message }
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	var m email.Message
+	if err := json.Unmarshal([]byte(aws.ToString(in.MessageBody)), &m); err != nil {
+		return nil, err
+	}
+	f.sent = append(f.sent, m)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// apiEvent is a REST API event of the invitation route.
+func apiEvent(userID, to string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"email": to})
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/invitations",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           string(body),
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     string
+		to         string
+		unverified bool
+		taken      bool
+		wantStatus int
+	}{
+		{name: "invited", userID: "u1", to: "Sam@Example.com", wantStatus: 201},
+		{name: "registered address", userID: "u1", to: "sam@example.com", taken: true, wantStatus: 409},
+		{name: "invalid address", userID: "u1", to: "sam", wantStatus: 422},
+		{name: "another user", userID: "u2", to: "sam@example.com", wantStatus: 403},
+		{name: "unverified inviter", userID: "u1", to: "sam@example.com", unverified: true, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					item := dbtest.Item("user_id", "u1", "display_name", "Jane")
+					item[verification.Attribute] = &types.AttributeValueMemberBOOL{Value: !tt.unverified}
+					return &dynamodb.GetItemOutput{Item: item}, nil
+				},
+				QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if tt.taken {
+						return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u3")}}, nil
+					}
+					return &dynamodb.QueryOutput{}, nil
+				},
+			}
+			queue := &fakeSQS{}
+			codes := invitations.NewCodec(signing.StaticKey("secret"))
+			cfg := &config.Config{
+				UserTableName:     "users",
+				UserDataTableName: "user-data",
+				CounterTableName:  "counters",
+				AuditTableName:    "audit",
+				InvitationURL:     "https://troggle.example/invite",
+			}
+			repo := &users.Repository{DB: m.Client(), Table: "users", EmailIndex: "email-index"}
+			h := &Handler{
+				Invitations: invitations.NewStore(m.Client(), cfg),
+				Codes:       codes,
+				Users:       repo,
+				Email:       &email.Queue{SQS: queue, URL: "https://sqs.example/email"},
+				Auth:        stubVerifier{&auth.Identity{Subject: "u1"}},
+				Limiter:     &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+				Verified:    &verification.Guard{Users: repo},
+				Config:      cfg,
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.userID, tt.to))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 201 {
+				if len(queue.sent) != 0 {
+					t.Errorf("sent = %+v, want nothing", queue.sent)
+				}
+				for _, op := range m.Ops() {
+					if op == "PutItem" {
+						t.Errorf("ops = %v, want no invitation stored", m.Ops())
+					}
+				}
+				return
+			}
+
+			var got Response
+			if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Invitation.InviterID != "u1" || got.Invitation.Email != "sam@example.com" || got.Invitation.Status != invitations.StatusPending {
+				t.Errorf("invitation = %+v", got.Invitation)
+			}
+			if len(queue.sent) != 1 || queue.sent[0].Template != email.TemplateInvitation || queue.sent[0].To != "sam@example.com" ||
+				queue.sent[0].Data["inviter"] != "Jane" || queue.sent[0].Data["link"] != got.Link {
+				t.Fatalf("sent = %+v, want the link mailed to the invitee", queue.sent)
+			}
+			link, err := url.Parse(got.Link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, err := codes.Decode(context.Background(), link.Query().Get("code"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code.InviterID != "u1" || code.InvitationID != got.Invitation.ID {
+				t.Errorf("code = %+v, want the invitation of u1", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Package creatematch creates a match (POST /matches with {"max_players": 4,
+// "board": "main"}) hosted by the caller, who is its first player. Its
+// MatchCreated event starts the match state machine, which ends the match
+// when it times out. See package matches.
+package creatematch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge" // EventBridge client
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/events"      // domain event publishing
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/idempotency" // Idempotency-Key handling
+	"troggle-backend/internal/matches"     // match records
+	"troggle-backend/internal/ratelimit"   // DynamoDB token buckets
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// defaultMaxPlayers is the size of matches that do not ask for one.
+const defaultMaxPlayers = 2
+
+// rateLimits allow a match every few seconds. They can be tuned per stage
+// through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(60),
+	PerUser: ratelimit.PerMinute(20),
+}
+
+// Request represents the JSON input. API Gateway callers host the match
+// themselves; direct invocations name the host.
+type Request struct {
+	HostID     string `json:"host_id" api:"direct"`
+	Board      string `json:"board"`       // optional leaderboard the scores count towards
+	MaxPlayers int    `json:"max_players"` // defaults to 2
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "createMatch",
+	Function: "createMatch",
+	Summary:  "Creates a match hosted by the caller",
+	Method:   "POST",
+	Path:     "/matches",
+	Request:  Request{},
+	Status:   201,
+	Response: matches.Match{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Matches     *matches.Store
+	Events      *events.Publisher
+	Idempotency *idempotency.Store // replays responses for retried API requests
+	Limiter     *ratelimit.Limiter
+	Limits      ratelimit.Policy
+	Auth        auth.TokenVerifier
+	Config      *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Matches:     matches.NewStore(client, cfg),
+		Events:      events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Idempotency: idempotency.New(client, cfg),
+		Limiter:     ratelimit.New(client, cfg),
+		Limits:      limits,
+		Auth:        verifier,
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies, and before
+// idempotency so keys are scoped to the caller.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits), h.Idempotency.Wrap)
+}
+
+// Handle creates the match and publishes its MatchCreated event.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	// Every field has a default, so API callers may send no body
+	var req Request
+	if err := r.Decode(&req); err != nil && !errors.Is(err, httpx.ErrEmptyPayload) {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req.HostID = id.Subject
+	}
+	if err := validation.UserID(req.HostID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if req.MaxPlayers == 0 {
+		req.MaxPlayers = defaultMaxPlayers
+	}
+
+	m, err := h.Matches.Create(ctx, req.HostID, req.Board, req.MaxPlayers, time.Now())
+	switch {
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Match created", "match_id", m.ID, "host_id", m.HostID, "board", m.Board)
+
+	// Without its event the match would never time out, so unlike most
+	// events a lost one fails the request. The retry creates another match;
+	// nobody learns the ID of this one.
+	err = h.Events.Publish(ctx, events.MatchCreated{
+		MatchID:   m.ID,
+		HostID:    m.HostID,
+		Board:     m.Board,
+		CreatedAt: m.CreatedAt.Format(time.RFC3339),
+		JoinBy:    m.JoinBy.Format(time.RFC3339),
+		FinishBy:  m.FinishBy.Format(time.RFC3339),
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSON(201, m), nil
+}
@@ -0,0 +1,73 @@
+package creatematch
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/matches"
+)
+
+// apiEvent is a POST /matches REST API event.
+func apiEvent(body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/matches",
+		"body":       body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantHost   string // of the stored match
+		wantBody   string
+	}{
+		{name: "defaults", payload: apiEvent(""), wantStatus: 201, wantHost: "u1", wantBody: `"max_players":2`},
+		{name: "on a board", payload: apiEvent(`{"board":"main","max_players":4}`), wantStatus: 201, wantHost: "u1", wantBody: `"board":"main"`},
+		{name: "too many players", payload: apiEvent(`{"max_players":20}`), wantStatus: 422, wantBody: matches.CodeMaxPlayersInvalid},
+		{name: "unknown board", payload: apiEvent(`{"board":"speed"}`), wantStatus: 404},
+		{name: "bad JSON", payload: apiEvent(`{"max_players":`), wantStatus: 400},
+		{name: "direct", payload: json.RawMessage(`{"host_id":"u2"}`), wantStatus: 201, wantHost: "u2", wantBody: `"status":"waiting"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var host string
+			m := &dbtest.Mock{PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+				host = in.Item["host_id"].(*types.AttributeValueMemberS).Value
+				return &dynamodb.PutItemOutput{}, nil
+			}}
+			h := &Handler{
+				Matches: &matches.Store{DB: m.Client(), Table: "matches", Boards: []string{"main"}, JoinTimeout: time.Minute, PlayTimeout: time.Hour},
+				Config:  &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if host != tt.wantHost {
+				t.Errorf("host = %q, want %q", host, tt.wantHost)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Package createnotification writes the in-app notification inbox. An
+// EventBridge rule delivers it the events users are notified of
+// (FriendRequestSent, FriendRequestAccepted, AchievementUnlocked and
+// InvitationAccepted), each becoming a notification of the user of its
+// detail. Operators post system announcements by invoking it directly with
+// {"user_ids": [...], "title": "...", "body": "..."}. See package
+// notifications.
+package createnotification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/events"        // domain event publishing
+	"troggle-backend/internal/logging"       // structured JSON logging
+	"troggle-backend/internal/notifications" // in-app notification inbox
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// maxRecipients bounds the users of one announcement; larger audiences
+// are split across invocations.
+const maxRecipients = 500
+
+// Event is the part of an EventBridge event the handler reads.
+type Event struct {
+	ID         string          `json:"id"`
+	DetailType string          `json:"detail-type"`
+	Time       time.Time       `json:"time"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// Announcement represents the JSON input of a direct invocation.
+type Announcement struct {
+	AnnouncementID string            `json:"announcement_id"` // optional; with sent_at, makes retries create nothing twice
+	SentAt         time.Time         `json:"sent_at"`         // optional; defaults to now
+	UserIDs        []string          `json:"user_ids"`
+	Title          string            `json:"title"`
+	Body           string            `json:"body"`
+	Data           map[string]string `json:"data"`
+}
+
+// Response is the JSON output of an announcement.
+type Response struct {
+	Created int `json:"created"` // users notified by this call
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Notifications *notifications.Store
+	Config        *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	return &Handler{Notifications: notifications.NewStore(client, cfg), Config: cfg}, nil
+}
+
+// Invoke is the Lambda entry point. EventBridge events, which name their
+// detail-type, are notified to their user; anything else is an
+// announcement.
+func (h *Handler) Invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err == nil && event.DetailType != "" {
+		return nil, h.HandleEvent(ctx, event)
+	}
+	var a Announcement
+	if err := json.Unmarshal(payload, &a); err != nil {
+		return nil, fmt.Errorf("decoding announcement: %w", err)
+	}
+	created, err := h.Announce(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	return Response{Created: created}, nil
+}
+
+// HandleEvent stores the notification of event. The event's ID and time
+// name the notification, so a redelivered event creates nothing new.
+func (h *Handler) HandleEvent(ctx context.Context, event Event) error {
+	n, ok, err := notificationOf(event)
+	if err != nil || !ok {
+		slog.WarnContext(ctx, "Skipping event without a notification", "event_id", event.ID, "detail_type", event.DetailType, logging.Err(err))
+		return nil
+	}
+	at := event.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	created, err := h.Notifications.Create(ctx, n, event.ID, at)
+	if err != nil {
+		return err
+	}
+	if created {
+		slog.InfoContext(ctx, "Notification created", "user_id", n.UserID, "type", n.Type, "event_id", event.ID)
+	}
+	return nil
+}
+
+// Announce notifies every user of a, and returns how many it notified. It
+// writes in batches; after a failure, which makes the caller retry, some
+// users may be notified already, and a retry with the same announcement
+// ID and sent_at only notifies the users left.
+func (h *Handler) Announce(ctx context.Context, a Announcement) (int, error) {
+	if a.Title == "" {
+		return 0, apperr.Invalid("TITLE_REQUIRED", "title", "title is required")
+	}
+	if len(a.UserIDs) == 0 || len(a.UserIDs) > maxRecipients {
+		return 0, apperr.Invalid("USER_IDS_INVALID", "user_ids", fmt.Sprintf("user_ids must list 1 to %d users", maxRecipients))
+	}
+	for _, id := range a.UserIDs {
+		if err := validation.UserID(id); err != nil {
+			return 0, err
+		}
+	}
+	ref := a.AnnouncementID
+	if ref == "" {
+		ref = notifications.NewRef()
+	}
+	if a.SentAt.IsZero() {
+		a.SentAt = time.Now()
+	}
+
+	ns := make([]notifications.Notification, len(a.UserIDs))
+	for i, userID := range a.UserIDs {
+		ns[i] = notifications.Notification{
+			UserID: userID,
+			Type:   notifications.TypeSystem,
+			Title:  a.Title,
+			Body:   a.Body,
+			Data:   a.Data,
+		}
+	}
+	created, err := h.Notifications.CreateMany(ctx, ns, ref, a.SentAt)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to notify announcement", "announcement_id", ref, logging.Err(err))
+		return 0, fmt.Errorf("notifying announcement %s: %w", ref, err)
+	}
+	slog.InfoContext(ctx, "Announcement notified", "announcement_id", ref, "created", created)
+	return created, nil
+}
+
+// notificationOf returns the notification of event, reporting false for
+// events users are not notified of.
+func notificationOf(event Event) (notifications.Notification, bool, error) {
+	switch event.DetailType {
+	case events.FriendRequestSent{}.DetailType():
+		var d events.FriendRequestSent
+		if err := decode(event, &d, &d.UserID); err != nil {
+			return notifications.Notification{}, false, err
+		}
+		return notifications.Notification{
+			UserID: d.UserID,
+			Type:   notifications.TypeFriendRequest,
+			Title:  "New friend request",
+			Data:   map[string]string{"from_id": d.FromID},
+		}, true, nil
+	case events.FriendRequestAccepted{}.DetailType():
+		var d events.FriendRequestAccepted
+		if err := decode(event, &d, &d.UserID); err != nil {
+			return notifications.Notification{}, false, err
+		}
+		return notifications.Notification{
+			UserID: d.UserID,
+			Type:   notifications.TypeFriendAccepted,
+			Title:  "Friend request accepted",
+			Data:   map[string]string{"friend_id": d.FriendID},
+		}, true, nil
+	case events.AchievementUnlocked{}.DetailType():
+		var d events.AchievementUnlocked
+		if err := decode(event, &d, &d.UserID); err != nil {
+			return notifications.Notification{}, false, err
+		}
+		return notifications.Notification{
+			UserID: d.UserID,
+			Type:   notifications.TypeAchievement,
+			Title:  "Achievement unlocked: " + d.Name,
+			Data:   map[string]string{"achievement_id": d.AchievementID},
+		}, true, nil
+	case events.InvitationAccepted{}.DetailType():
+		var d events.InvitationAccepted
+		if err := decode(event, &d, &d.UserID); err != nil {
+			return notifications.Notification{}, false, err
+		}
+		return notifications.Notification{
+			UserID: d.UserID,
+			Type:   notifications.TypeReferral,
+			Title:  "Your invitation was accepted",
+			Data:   map[string]string{"invitee_id": d.InviteeID, "invitation_id": d.InvitationID},
+		}, true, nil
+	}
+	return notifications.Notification{}, false, nil
+}
+
+// decode unmarshals the detail of event into v, and validates userID, the
+// user v names.
+func decode(event Event, v any, userID *string) error {
+	if err := json.Unmarshal(event.Detail, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", event.DetailType, err)
+	}
+	return validation.UserID(*userID)
+}
@@ -0,0 +1,127 @@
+package createnotification
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/notifications"
+)
+
+func TestInvoke(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		exists    bool // the notification, of every user for events and of u1 for announcements, is stored already
+		want      any
+		wantTypes []string // of the stored notifications
+		wantKind  apperr.Kind
+		wantErr   bool
+	}{
+		{
+			name:      "friend request",
+			payload:   `{"id":"e1","detail-type":"FriendRequestSent","time":"2026-10-14T12:00:00Z","detail":{"user_id":"u1","from_id":"u2"}}`,
+			wantTypes: []string{notifications.TypeFriendRequest},
+		},
+		{
+			name:      "achievement",
+			payload:   `{"id":"e2","detail-type":"AchievementUnlocked","detail":{"user_id":"u1","achievement_id":"first_win","name":"First win"}}`,
+			wantTypes: []string{notifications.TypeAchievement},
+		},
+		{
+			name:      "referral",
+			payload:   `{"id":"e5","detail-type":"InvitationAccepted","detail":{"user_id":"u1","invitee_id":"u2","invitation_id":"i1"}}`,
+			wantTypes: []string{notifications.TypeReferral},
+		},
+		{
+			name:      "redelivered event",
+			payload:   `{"id":"e1","detail-type":"FriendRequestAccepted","detail":{"user_id":"u1","friend_id":"u2"}}`,
+			exists:    true,
+			wantTypes: []string{notifications.TypeFriendAccepted},
+		},
+		{
+			name:    "event of no notification",
+			payload: `{"id":"e3","detail-type":"UserCreated","detail":{"user_id":"u1"}}`,
+		},
+		{
+			name:    "event without a user",
+			payload: `{"id":"e4","detail-type":"FriendRequestSent","detail":{}}`,
+		},
+		{
+			name:      "announcement",
+			payload:   `{"announcement_id":"maintenance","user_ids":["u1","u2","u2"],"title":"Maintenance tonight"}`,
+			want:      Response{Created: 2},
+			wantTypes: []string{notifications.TypeSystem, notifications.TypeSystem},
+		},
+		{
+			name:      "retried announcement",
+			payload:   `{"announcement_id":"maintenance","sent_at":"2026-10-14T12:00:00Z","user_ids":["u1","u2"],"title":"Maintenance tonight"}`,
+			exists:    true,
+			want:      Response{Created: 1},
+			wantTypes: []string{notifications.TypeSystem},
+		},
+		{
+			name:     "announcement without a title",
+			payload:  `{"user_ids":["u1"]}`,
+			wantKind: apperr.KindInvalid,
+			wantErr:  true,
+		},
+		{
+			name:     "announcement to nobody",
+			payload:  `{"title":"Hello"}`,
+			wantKind: apperr.KindInvalid,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored []string
+			m := &dbtest.Mock{
+				PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					stored = append(stored, in.Item["type"].(*types.AttributeValueMemberS).Value)
+					if tt.exists {
+						return nil, dbtest.ConditionFailed()
+					}
+					return &dynamodb.PutItemOutput{}, nil
+				},
+				BatchGetItemFunc: func(in *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+					var items []db.Item
+					if tt.exists {
+						items = append(items, dbtest.Item("user_id", "u1"))
+					}
+					return &dynamodb.BatchGetItemOutput{Responses: map[string][]db.Item{"notifications": items}}, nil
+				},
+				BatchWriteItemFunc: func(in *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+					for _, w := range in.RequestItems["notifications"] {
+						stored = append(stored, w.PutRequest.Item["type"].(*types.AttributeValueMemberS).Value)
+					}
+					return &dynamodb.BatchWriteItemOutput{}, nil
+				},
+			}
+			h := &Handler{Notifications: &notifications.Store{DB: m.Client(), Table: "notifications", TTL: time.Hour}, Config: &config.Config{}}
+
+			got, err := h.Invoke(context.Background(), json.RawMessage(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && apperr.KindOf(err) != tt.wantKind {
+				t.Errorf("kind = %v, want %v", apperr.KindOf(err), tt.wantKind)
+			}
+			if got != tt.want {
+				t.Errorf("response = %+v, want %+v", got, tt.want)
+			}
+			if !slices.Equal(stored, tt.wantTypes) {
+				t.Errorf("stored %v, want %v", stored, tt.wantTypes)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Package createsession records a sign-in: clients call it once after
+// authenticating, naming their device, so the session can later be listed
+// and revoked. Signing in again cancels a pending account deletion.
+package createsession
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/erasure"    // deletion grace period
+	"troggle-backend/internal/events"     // domain event publishing
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON body. API Gateway callers only send the device;
+// the user and session come from their token. Direct invocations name them.
+type Request struct {
+	UserID    string `json:"user_id" api:"direct"`    // direct invocations only
+	SessionID string `json:"session_id" api:"direct"` // direct invocations only; generated when empty
+	IP        string `json:"ip" api:"direct"`         // direct invocations only
+	Device    string `json:"device" validate:"max=128"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "createSession",
+	Function: "createSession",
+	Summary:  "Records the caller's current sign-in",
+	Method:   "POST",
+	Path:     "/sessions",
+	Request:  Request{},
+	Status:   201,
+	Response: sessions.Session{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Sessions *sessions.Store
+	Auth     auth.TokenVerifier
+	Events   *events.Publisher
+	Erasure  *erasure.Store
+	Users    *users.Repository // cache invalidated when a deletion is cancelled
+	Audit    *audit.Store
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Sessions: sessions.NewStore(client, cfg),
+		Auth:     verifier,
+		Events:   events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Erasure:  erasure.NewStore(client, cfg),
+		Users:    users.NewRepository(client, cfg),
+		Audit:    audit.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), validation.Body[Request]())
+}
+
+// Handle records the caller's current sign-in and returns it: 201 when it is
+// new, 200 when it was already recorded.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if len(r.Body) > 0 {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	sess := sessions.Session{Device: req.Device}
+	if r.Direct {
+		sess.UserID, sess.SessionID, sess.IP = req.UserID, req.SessionID, req.IP
+	} else {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		sess.UserID, sess.SessionID = id.Subject, id.SessionID
+		sess.IP, sess.UserAgent = r.SourceIP, r.Header("user-agent")
+	}
+	if sess.SessionID == "" {
+		sess.SessionID = sessions.NewID()
+	}
+
+	if err := validation.UserID(sess.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := validation.SessionID(sess.SessionID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	// Cancelled before the session is recorded, so a retry after a failure
+	// reaches it again rather than the "already recorded" answer
+	if err := h.cancelDeletion(ctx, r, sess.UserID); err != nil {
+		return httpx.Response{}, err
+	}
+
+	stored, created, err := h.Sessions.Create(ctx, sess)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if !created {
+		slog.InfoContext(ctx, "Session already recorded", "user_id", stored.UserID, "session_id", stored.SessionID)
+		return httpx.JSON(200, stored), nil
+	}
+	slog.InfoContext(ctx, "Session created", "user_id", stored.UserID, "session_id", stored.SessionID)
+	h.Events.Emit(ctx, events.SessionCreated{
+		UserID:    stored.UserID,
+		SessionID: stored.SessionID,
+		Device:    stored.Device,
+		CreatedAt: stored.IssuedAt.UTC().Format(time.RFC3339),
+	})
+	return httpx.JSON(201, stored), nil
+}
+
+// cancelDeletion cancels the pending deletion of the signed-in user's
+// account, if any.
+func (h *Handler) cancelDeletion(ctx context.Context, r *httpx.Request, userID string) error {
+	cancelled, err := h.Erasure.Cancel(ctx, userID)
+	if err != nil || !cancelled {
+		return err
+	}
+	h.Users.Invalidate(userID, "")
+	slog.InfoContext(ctx, "Account deletion cancelled by sign-in", "user_id", userID)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionDeletionCancel,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"status": {Before: erasure.StatusPendingDeletion}},
+	})
+	return nil
+}
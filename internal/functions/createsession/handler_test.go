@@ -0,0 +1,129 @@
+package createsession
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/erasure"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/sessions"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a POST /sessions REST API event.
+func apiEvent(token, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/sessions",
+		"headers":    map[string]string{"Authorization": "Bearer " + token, "User-Agent": "troggle-ios/2.1"},
+		"body":       body,
+		"requestContext": map[string]any{
+			"identity": map[string]string{"sourceIp": "203.0.113.7"},
+		},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	caller := &auth.Identity{Subject: "u1", SessionID: "origin-1"}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		put        func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+		pending    bool // a deletion of the account is pending
+		wantStatus int
+		wantBody   []string
+	}{
+		{
+			name:       "from token",
+			payload:    apiEvent("valid", `{"device":"Phone"}`),
+			wantStatus: 201,
+			wantBody:   []string{`"session_id":"origin-1"`, `"ip":"203.0.113.7"`, `"user_agent":"troggle-ios/2.1"`, `"device":"Phone"`},
+		},
+		{
+			name:       "cancels pending deletion",
+			payload:    apiEvent("valid", `{"device":"Phone"}`),
+			pending:    true,
+			wantStatus: 201,
+		},
+		{
+			name:       "direct",
+			payload:    json.RawMessage(`{"user_id":"u2","session_id":"s-2","device":"Laptop"}`),
+			wantStatus: 201,
+			wantBody:   []string{`"user_id":"u2"`, `"session_id":"s-2"`},
+		},
+		{
+			name:       "unauthenticated",
+			payload:    apiEvent("", `{}`),
+			wantStatus: 401,
+		},
+		{
+			name:       "device too long",
+			payload:    apiEvent("valid", `{"device":"`+strings.Repeat("x", 129)+`"}`),
+			wantStatus: 422,
+			wantBody:   []string{`"field":"device","code":"TOO_LONG"`},
+		},
+		{
+			name:       "invalid session id",
+			payload:    json.RawMessage(`{"user_id":"u2","session_id":"s/2"}`),
+			wantStatus: 422,
+		},
+		{
+			name:    "throttled",
+			payload: apiEvent("valid", `{}`),
+			put: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+				return nil, dbtest.Throttled()
+			},
+			wantStatus: 429,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{PutItemFunc: tt.put, UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				if !tt.pending {
+					return nil, dbtest.ConditionFailed()
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			}}
+			h := &Handler{
+				Sessions: &sessions.Store{DB: m.Client(), Table: "sessions", TTL: time.Hour},
+				Erasure:  &erasure.Store{DB: m.Client(), Table: "users"},
+				Users:    &users.Repository{},
+				Auth:     stubVerifier{id: caller},
+				Config:   &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(resp.Body, want) {
+					t.Errorf("body = %s, want it to contain %s", resp.Body, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+// Package createuser creates user records, either from the Cognito
+// PostConfirmation trigger or through the REST endpoint.
+package createuser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events" // Cognito trigger event definitions
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"         // audit log
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	domain "troggle-backend/internal/events" // domain event publishing
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/idempotency"   // Idempotency-Key handling
+	"troggle-backend/internal/logging"       // structured JSON logging
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// ErrEmailTaken is returned when another user already owns the email.
+var ErrEmailTaken = users.ErrEmailTaken
+
+// Request represents the JSON input of the REST endpoint
+type Request struct {
+	UserID      string `json:"user_id"`                // Cognito sub of the new user
+	Email       string `json:"email"`                  // User email
+	DisplayName string `json:"display_name,omitempty"` // Optional; defaults to the email local part
+}
+
+// User is the record written to the user table
+type User struct {
+	UserID        string `json:"user_id"`
+	Email         string `json:"email"`
+	DisplayName   string `json:"display_name"`
+	EmailVerified bool   `json:"email_verified"` // Cognito's email_verified; REST-created users start unverified
+	Bio           string `json:"bio"`
+	AvatarURL     string `json:"avatar_url"`
+	Status        string `json:"status"` // account status; new users start "active"
+	CreatedAt     string `json:"created_at"`
+	UpdatedAt     string `json:"updated_at"`
+	Version       int    `json:"version"` // optimistic-locking counter, bumped on every update
+}
+
+// NewUser returns a user record with the profile defaults filled in.
+func NewUser(userID, email, displayName string, now time.Time) User {
+	if displayName == "" {
+		displayName, _, _ = strings.Cut(email, "@")
+	}
+
+	ts := now.UTC().Format(time.RFC3339)
+	return User{
+		UserID:      userID,
+		Email:       email,
+		DisplayName: displayName,
+		Status:      "active",
+		CreatedAt:   ts,
+		UpdatedAt:   ts,
+		Version:     1,
+	}
+}
+
+// CreateUser writes the user record together with a sentinel item reserving
+// the email, in a single transaction. Both puts are conditional, so a second
+// user with the same email is rejected with ErrEmailTaken even when two
+// sign-ups race. Re-creating an existing user (e.g. a retried Cognito trigger)
+// is a no-op and reports created as false.
+func CreateUser(ctx context.Context, user User, repo *users.Repository) (created bool, err error) {
+	slog.InfoContext(ctx, "Creating user", "user_id", user.UserID, logging.EmailHash(user.Email), "table", repo.Table)
+
+	// Check the email GSI first: records created before email sentinels existed
+	// are only discoverable there. A cached answer could be stale.
+	owner, err := repo.Uncached().IDByEmail(ctx, user.Email)
+	if err != nil {
+		return false, err
+	}
+	if owner == user.UserID {
+		slog.InfoContext(ctx, "User already exists", "user_id", user.UserID)
+		return false, nil
+	}
+	if owner != "" {
+		return false, ErrEmailTaken
+	}
+
+	err = repo.Create(ctx, users.User{
+		UserID:        user.UserID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarURL:     user.AvatarURL,
+		Status:        user.Status,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Version:       user.Version,
+	})
+	switch {
+	case err == nil:
+		slog.InfoContext(ctx, "User created", "user_id", user.UserID)
+		return true, nil
+	case errors.Is(err, users.ErrUserExists):
+		slog.InfoContext(ctx, "User already exists", "user_id", user.UserID)
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "createUser",
+	Function: "createUser",
+	Summary:  "Creates the profile of a new user",
+	Method:   "POST",
+	Path:     "/users",
+	Request:  Request{},
+	Status:   201,
+	Response: User{},
+	Scopes:   []string{"troggle/users.write"},
+	Groups:   []string{"admin"},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users       *users.Repository
+	Idempotency *idempotency.Store // replays responses for retried API requests
+	Events      *domain.Publisher
+	Audit       *audit.Store
+	Config      *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Users:       users.NewRepository(client, cfg),
+		Idempotency: idempotency.New(client, cfg),
+		Events:      domain.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Audit:       audit.NewStore(client, cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.Idempotency.Wrap)
+}
+
+// triggerProbe detects Cognito trigger events among incoming payloads.
+type triggerProbe struct {
+	TriggerSource string `json:"triggerSource"`
+}
+
+// Invoke is the Lambda entry point. Cognito PostConfirmation events are
+// handled as a trigger; anything else goes through the REST handler.
+func (h *Handler) Invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+	var probe triggerProbe
+	if err := json.Unmarshal(payload, &probe); err == nil && probe.TriggerSource != "" {
+		var event events.CognitoEventUserPoolsPostConfirmation
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding Cognito event: %w", err)
+		}
+		return h.HandlePostConfirmation(ctx, event)
+	}
+
+	return httpx.Adapt(h.HTTP())(ctx, payload)
+}
+
+// HandlePostConfirmation creates the user record once Cognito has confirmed a
+// sign-up. Returning an error makes Cognito fail the confirmation, so the
+// account never exists without its profile.
+func (h *Handler) HandlePostConfirmation(ctx context.Context, event events.CognitoEventUserPoolsPostConfirmation) (events.CognitoEventUserPoolsPostConfirmation, error) {
+	// Password resets also fire PostConfirmation; only sign-ups create users
+	if event.TriggerSource != "PostConfirmation_ConfirmSignUp" {
+		return event, nil
+	}
+
+	attrs := event.Request.UserAttributes
+	if err := validation.UserID(attrs["sub"]); err != nil {
+		return event, fmt.Errorf("confirmation event: %w", err)
+	}
+	email, err := validation.NormalizeEmail(attrs["email"], h.Config.StripPlusAlias)
+	if err != nil {
+		return event, fmt.Errorf("confirmation event: %w", err)
+	}
+
+	user := NewUser(attrs["sub"], email, attrs["name"], time.Now())
+	user.EmailVerified = attrs["email_verified"] == "true"
+
+	if err := h.create(ctx, user, audit.System(ctx), audit.RequestID(ctx, nil)); err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+// create stores user and, if it is new, audits the creation by actor and
+// publishes UserCreated.
+func (h *Handler) create(ctx context.Context, user User, actor audit.Actor, requestID string) error {
+	created, err := CreateUser(ctx, user, h.Users)
+	if err != nil || !created {
+		return err
+	}
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(user.UserID),
+		Action:    audit.ActionUserCreate,
+		Actor:     actor,
+		RequestID: requestID,
+		Diff: audit.Diff(nil, map[string]any{
+			"email":        user.Email,
+			"display_name": user.DisplayName,
+			"status":       user.Status,
+			"version":      user.Version,
+		}),
+		At: user.CreatedAt,
+	})
+	h.Events.Emit(ctx, domain.UserCreated{
+		UserID:    user.UserID,
+		Email:     user.Email,
+		Name:      user.DisplayName,
+		CreatedAt: user.CreatedAt,
+	})
+	return nil
+}
+
+// Handle serves the REST endpoint: it creates the user described by the JSON
+// body and returns the stored record.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+
+	// Parse JSON body
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	email, err := validation.NormalizeEmail(req.Email, h.Config.StripPlusAlias)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	user := NewUser(req.UserID, email, req.DisplayName, time.Now())
+
+	err = h.create(ctx, user, audit.ActorOf(ctx, r), audit.RequestID(ctx, r))
+	if errors.Is(err, ErrEmailTaken) {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+
+	return httpx.JSON(201, user), nil
+}
@@ -0,0 +1,180 @@
+package createuser
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/users"
+)
+
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// indexedOwner answers email index queries with a record owned by userID.
+func indexedOwner(userID string) func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", userID)}}, nil
+	}
+}
+
+func canceled(codes ...string) func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	return func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+		return nil, dbtest.TransactionCanceled(codes...)
+	}
+}
+
+func testHandler(m *dbtest.Mock) *Handler {
+	cfg := &config.Config{UserTableName: "users", EmailIndexName: "email-index"}
+	return &Handler{Users: users.NewRepository(m.Client(), cfg), Config: cfg}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		query      func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+		transact   func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error)
+		wantStatus int
+		wantBody   string
+		wantOps    []string
+	}{
+		{
+			name:       "created",
+			body:       `{"user_id":"u1","email":"Jane@Example.com"}`,
+			wantStatus: 201, wantBody: `"display_name":"jane"`,
+			wantOps: []string{"Query", "TransactWriteItems"},
+		},
+		{
+			name:       "already exists",
+			body:       `{"user_id":"u1","email":"jane@example.com"}`,
+			transact:   canceled("ConditionalCheckFailed", "ConditionalCheckFailed"),
+			wantStatus: 201,
+			wantOps:    []string{"Query", "TransactWriteItems"},
+		},
+		{
+			name:       "email reserved by another user",
+			body:       `{"user_id":"u1","email":"jane@example.com"}`,
+			transact:   canceled("None", "ConditionalCheckFailed"),
+			wantStatus: 409,
+			wantOps:    []string{"Query", "TransactWriteItems"},
+		},
+		{
+			name:       "email indexed for another user",
+			body:       `{"user_id":"u1","email":"jane@example.com"}`,
+			query:      indexedOwner("u2"),
+			wantStatus: 409,
+			wantOps:    []string{"Query"},
+		},
+		{
+			name:       "email indexed for the same user",
+			body:       `{"user_id":"u1","email":"jane@example.com"}`,
+			query:      indexedOwner("u1"),
+			wantStatus: 201,
+			wantOps:    []string{"Query"},
+		},
+		{
+			name:       "invalid email",
+			body:       `{"user_id":"u1","email":"jane"}`,
+			wantStatus: 422,
+		},
+		{
+			name:       "invalid user id",
+			body:       `{"user_id":"EMAIL#jane@example.com","email":"jane@example.com"}`,
+			wantStatus: 422,
+		},
+		{
+			name: "throttled",
+			body: `{"user_id":"u1","email":"jane@example.com"}`,
+			transact: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				return nil, dbtest.Throttled()
+			},
+			wantStatus: 429,
+			wantOps:    []string{"Query", "TransactWriteItems"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: tt.query, TransactWriteItemsFunc: tt.transact}
+
+			resp, err := httpx.Adapt(testHandler(m).Handle)(context.Background(), json.RawMessage(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+			if ops := m.Ops(); !reflect.DeepEqual(ops, tt.wantOps) && len(ops)+len(tt.wantOps) > 0 {
+				t.Errorf("DynamoDB calls = %v, want %v", ops, tt.wantOps)
+			}
+		})
+	}
+}
+
+func TestCreateUserTransaction(t *testing.T) {
+	m := &dbtest.Mock{}
+	user := NewUser("u1", "jane@example.com", "", testNow)
+	if _, err := CreateUser(context.Background(), user, testHandler(m).Users); err != nil {
+		t.Fatal(err)
+	}
+
+	in := m.Calls[1].Input.(*dynamodb.TransactWriteItemsInput)
+	if len(in.TransactItems) != 2 {
+		t.Fatalf("%d transaction items, want 2", len(in.TransactItems))
+	}
+	lock := in.TransactItems[1].Put.Item
+	if got := lock["user_id"].(*types.AttributeValueMemberS).Value; got != "EMAIL#jane@example.com" {
+		t.Errorf("sentinel user_id = %s", got)
+	}
+	if _, ok := lock["email"]; ok {
+		t.Error("sentinel must not carry an email attribute")
+	}
+}
+
+func TestInvokePostConfirmation(t *testing.T) {
+	tests := []struct {
+		source  string
+		sub     string
+		wantErr bool
+		wantOps int
+	}{
+		{source: "PostConfirmation_ConfirmSignUp", sub: "u1", wantOps: 2},
+		{source: "PostConfirmation_ConfirmForgotPassword", sub: "u1"},
+		{source: "PostConfirmation_ConfirmSignUp", sub: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			var event events.CognitoEventUserPoolsPostConfirmation
+			event.TriggerSource = tt.source
+			event.Request.UserAttributes = map[string]string{"sub": tt.sub, "email": "jane@example.com"}
+			payload, _ := json.Marshal(event)
+
+			m := &dbtest.Mock{}
+			out, err := testHandler(m).Invoke(context.Background(), payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if _, ok := out.(events.CognitoEventUserPoolsPostConfirmation); !ok {
+					t.Errorf("Invoke returned %T, want the trigger event", out)
+				}
+			}
+			if len(m.Calls) != tt.wantOps {
+				t.Errorf("%d DynamoDB calls, want %d", len(m.Calls), tt.wantOps)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// Package decayratings decays the ratings of inactive players. An
+// EventBridge schedule runs it daily; running it again the same day
+// changes nothing. See package rating.
+package decayratings
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events" // Lambda event payloads
+
+	"troggle-backend/internal/config" // environment-driven settings
+	"troggle-backend/internal/db"     // shared DynamoDB client
+	"troggle-backend/internal/rating" // skill ratings
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Ratings *rating.Store
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	return &Handler{Ratings: rating.NewStore(client, cfg), Config: cfg}, nil
+}
+
+// Handle decays every inactive player. Failing the invocation makes
+// EventBridge retry it, and the retry skips the players decayed already.
+func (h *Handler) Handle(ctx context.Context, _ events.CloudWatchEvent) error {
+	decayed, err := h.Ratings.Decay(ctx, time.Now())
+	slog.InfoContext(ctx, "Ratings decayed", "players", decayed)
+	return err
+}
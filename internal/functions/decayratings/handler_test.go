@@ -0,0 +1,41 @@
+package decayratings
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/rating"
+)
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name    string
+		scanErr error
+		wantErr bool
+	}{
+		{name: "nobody inactive"},
+		{name: "failing scan", scanErr: dbtest.Throttled(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{ScanFunc: func(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+				return &dynamodb.ScanOutput{}, tt.scanErr
+			}}
+			h := &Handler{
+				Ratings: &rating.Store{DB: m.Client(), PlayerTable: "players", HistoryTable: "history", Params: rating.Params{DecayAfter: 30 * 24 * time.Hour}},
+				Config:  &config.Config{},
+			}
+
+			err := h.Handle(context.Background(), events.CloudWatchEvent{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Package defineauthchallenge is the Cognito DefineAuthChallenge trigger of
+// the passwordless email login. Cognito calls it at the start of a
+// CUSTOM_AUTH sign-in and after every answer, and it decides what comes
+// next: another CUSTOM_CHALLENGE, tokens, or failure.
+//
+// The challenge is a one-time code sent by email; see createAuthChallenge
+// and verifyAuthChallenge. A sign-in gets otp.MaxAttempts answers. Unknown
+// users are challenged like everyone else and simply never answer right,
+// so the login does not tell which addresses have accounts.
+package defineauthchallenge
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/aws/aws-lambda-go/events" // Cognito trigger event definitions
+
+	"troggle-backend/internal/otp" // one-time sign-in codes
+)
+
+// ChallengeName is the only challenge of the login.
+const ChallengeName = "CUSTOM_CHALLENGE"
+
+// Handle sets the next step of the sign-in of event.
+func Handle(ctx context.Context, event events.CognitoEventUserPoolsDefineAuthChallenge) (events.CognitoEventUserPoolsDefineAuthChallenge, error) {
+	session := event.Request.Session
+	failed := 0
+	for _, c := range session {
+		if c.ChallengeName != ChallengeName {
+			// Password and SRP challenges are not part of this flow
+			slog.WarnContext(ctx, "Unexpected challenge in custom sign-in", "challenge", c.ChallengeName)
+			event.Response.FailAuthentication = true
+			return event, nil
+		}
+		if !c.ChallengeResult {
+			failed++
+		}
+	}
+
+	switch {
+	case len(session) > 0 && session[len(session)-1].ChallengeResult:
+		event.Response.IssueTokens = true
+	case failed >= otp.MaxAttempts:
+		slog.InfoContext(ctx, "Sign-in failed", "attempts", failed)
+		event.Response.FailAuthentication = true
+	default:
+		event.Response.ChallengeName = ChallengeName
+	}
+	return event, nil
+}
@@ -0,0 +1,50 @@
+package defineauthchallenge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func session(results ...bool) []*events.CognitoEventUserPoolsChallengeResult {
+	var s []*events.CognitoEventUserPoolsChallengeResult
+	for _, r := range results {
+		s = append(s, &events.CognitoEventUserPoolsChallengeResult{ChallengeName: ChallengeName, ChallengeResult: r})
+	}
+	return s
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name                string
+		session             []*events.CognitoEventUserPoolsChallengeResult
+		userNotFound        bool
+		wantChallenge       string
+		wantTokens, wantErr bool
+	}{
+		{name: "start", wantChallenge: ChallengeName},
+		{name: "correct", session: session(true), wantTokens: true},
+		{name: "wrong", session: session(false), wantChallenge: ChallengeName},
+		{name: "correct after wrong", session: session(false, false, true), wantTokens: true},
+		{name: "out of attempts", session: session(false, false, false), wantErr: true},
+		{name: "unknown user", userNotFound: true, wantChallenge: ChallengeName},
+		{name: "password", session: []*events.CognitoEventUserPoolsChallengeResult{{ChallengeName: "SRP_A", ChallengeResult: true}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e events.CognitoEventUserPoolsDefineAuthChallenge
+			e.Request.Session = tt.session
+			e.Request.UserNotFound = tt.userNotFound
+
+			got, err := Handle(context.Background(), e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := got.Response
+			if r.ChallengeName != tt.wantChallenge || r.IssueTokens != tt.wantTokens || r.FailAuthentication != tt.wantErr {
+				t.Errorf("response = %+v", r)
+			}
+		})
+	}
+}
@@ -0,0 +1,576 @@
+// Package deleteuser removes a user and everything keyed on them, undoing
+// completed steps when a later one fails. With DELETION_MODE=soft it only
+// marks them deleted, for restoreUser to undo within the retention window.
+// Invoked by the erasure schedule rule, it deletes the accounts whose
+// deletion grace period has ended and purges the soft-deleted ones past
+// their retention window; see package erasure.
+package deleteuser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws" // aws package
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/erasure"    // deletion grace period
+	"troggle-backend/internal/events"     // domain event publishing
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/sessions"   // sign-in records
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// adminScope grants API key callers the right to delete accounts; users need
+// the users:delete permission.
+const adminScope = "troggle/admin"
+
+// Request represents the JSON input of a direct invocation
+type Request struct {
+	UserID string `json:"user_id"` // Cognito sub of the user to delete
+}
+
+// step is one stage of the deletion. Completed steps are undone in reverse
+// order, through compensate, when a later step fails. Steps without a
+// compensation cannot be undone; once one has run, later failures are only
+// logged.
+type step struct {
+	name       string
+	run        func(ctx context.Context) error
+	compensate func(ctx context.Context) error
+}
+
+// runSteps executes steps in order. When a step fails it rolls back the
+// completed, still-reversible steps and logs every action so an operator can
+// finish a partial deletion by hand.
+func runSteps(ctx context.Context, userID string, steps []step) error {
+	var done []step
+	for _, s := range steps {
+		slog.InfoContext(ctx, "Running deletion step", "user_id", userID, "step", s.name)
+		if err := s.run(ctx); err != nil {
+			slog.ErrorContext(ctx, "Deletion step failed", "user_id", userID, "step", s.name, logging.Err(err))
+			// A failed step may have partially applied, so it is compensated too
+			if s.compensate != nil {
+				done = append(done, s)
+			}
+			rollback(ctx, userID, done)
+			return fmt.Errorf("step %s: %w", s.name, err)
+		}
+
+		// An irreversible step commits everything before it
+		if s.compensate == nil {
+			done = nil
+			continue
+		}
+		done = append(done, s)
+	}
+	return nil
+}
+
+// rollback compensates the given steps, most recent first. Compensation
+// failures are logged and do not stop the remaining compensations.
+func rollback(ctx context.Context, userID string, done []step) {
+	for i := len(done) - 1; i >= 0; i-- {
+		s := done[i]
+		if err := s.compensate(ctx); err != nil {
+			slog.ErrorContext(ctx, "ROLLBACK FAILED", "user_id", userID, "step", s.name, logging.Err(err))
+			continue
+		}
+		slog.WarnContext(ctx, "Rolled back deletion step", "user_id", userID, "step", s.name)
+	}
+}
+
+// CognitoAPI is the part of the Cognito user pool API the deletion uses.
+type CognitoAPI interface {
+	AdminDisableUser(ctx context.Context, params *cognitoidentityprovider.AdminDisableUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDisableUserOutput, error)
+	AdminEnableUser(ctx context.Context, params *cognitoidentityprovider.AdminEnableUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminEnableUserOutput, error)
+	AdminDeleteUser(ctx context.Context, params *cognitoidentityprovider.AdminDeleteUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDeleteUserOutput, error)
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "deleteUser",
+	Function: "deleteUser",
+	Summary:  "Deletes a user",
+	Method:   "DELETE",
+	Path:     "/users/{user_id}",
+	Status:   204,
+	Scopes:   []string{"troggle/admin"},
+	Groups:   []string{"admin"},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	DB       *db.Client
+	Users    *users.Repository
+	Sessions *sessions.Store
+	Cognito  CognitoAPI
+	Events   *events.Publisher
+	Audit    *audit.Store
+	Erasure  *erasure.Store
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// Cognito user pool.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireUserPool(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		DB:       client,
+		Users:    users.NewRepository(client, cfg),
+		Sessions: sessions.NewStore(client, cfg),
+		Cognito:  cognitoidentityprovider.NewFromConfig(awsCfg),
+		Events:   events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Audit:    audit.NewStore(client, cfg),
+		Erasure:  erasure.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns the handler served through API Gateway. The endpoint has no
+// middleware of its own.
+func (h *Handler) HTTP() httpx.Handler {
+	return h.Handle
+}
+
+// scheduleProbe detects EventBridge scheduled events among incoming payloads.
+type scheduleProbe struct {
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+}
+
+// Invoke is the Lambda entry point. Scheduled events run the erasure sweep;
+// anything else goes through the REST handler.
+func (h *Handler) Invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+	var probe scheduleProbe
+	if err := json.Unmarshal(payload, &probe); err == nil && probe.Source == "aws.events" && probe.DetailType == "Scheduled Event" {
+		return nil, h.Sweep(ctx, time.Now())
+	}
+	return httpx.Adapt(h.HTTP())(ctx, payload)
+}
+
+// Sweep deletes every account whose deletion was scheduled at or before now,
+// and then purges the soft-deleted accounts whose retention window ended by
+// then. Each one is checked again first, so a deletion cancelled, or an
+// account restored, since the index was read is left alone. Failures are
+// logged and reported together at the end; the accounts are picked up again
+// by the next run.
+func (h *Handler) Sweep(ctx context.Context, now time.Time) error {
+	due, err := h.Erasure.Due(ctx, now)
+	if err != nil {
+		return err
+	}
+	actor, requestID := audit.System(ctx), audit.RequestID(ctx, nil)
+	cutoff := now.UTC().Format(time.RFC3339)
+
+	var failed int
+	for _, userID := range due {
+		pending, err := h.Erasure.Get(ctx, userID)
+		if err == nil && (pending == nil || pending.ScheduledAt > cutoff) {
+			slog.InfoContext(ctx, "Account deletion no longer due", "user_id", userID)
+			continue
+		}
+		if err == nil {
+			err = h.DeleteUser(ctx, userID, actor, requestID)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Account erasure failed", "user_id", userID, logging.Err(err))
+			failed++
+			continue
+		}
+		slog.InfoContext(ctx, "Account erased", "user_id", userID, "requested_at", pending.RequestedAt)
+	}
+	var errs []error
+	if failed > 0 {
+		errs = append(errs, fmt.Errorf("%d of %d due account erasures failed", failed, len(due)))
+	}
+
+	purgeable, err := h.Erasure.Purgeable(ctx, now)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	failed = 0
+	for _, userID := range purgeable {
+		deleted, err := h.Erasure.GetDeleted(ctx, userID)
+		if err == nil && (deleted == nil || deleted.PurgeAt > cutoff) {
+			slog.InfoContext(ctx, "Account purge no longer due", "user_id", userID)
+			continue
+		}
+		if err == nil {
+			err = h.erase(ctx, userID, actor, requestID)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Account purge failed", "user_id", userID, logging.Err(err))
+			failed++
+			continue
+		}
+		slog.InfoContext(ctx, "Account purged", "user_id", userID, "deleted_at", deleted.DeletedAt)
+	}
+	if failed > 0 {
+		errs = append(errs, fmt.Errorf("%d of %d due account purges failed", failed, len(purgeable)))
+	}
+	return errors.Join(errs...)
+}
+
+// DeleteUser deletes the account of userID as the deletion mode says: at
+// once, or by marking it deleted. Either way the deletion is audited by
+// actor.
+func (h *Handler) DeleteUser(ctx context.Context, userID string, actor audit.Actor, requestID string) error {
+	if h.Config.DeletionMode == config.DeletionSoft {
+		return h.softDelete(ctx, userID, actor, requestID)
+	}
+	return h.erase(ctx, userID, actor, requestID)
+}
+
+// softDelete disables the Cognito account, marks the user record and its
+// related rows deleted, to be purged after the retention window, and
+// revokes every session. Revoked sessions stay revoked when the account is
+// restored: the user signs in again.
+func (h *Handler) softDelete(ctx context.Context, userID string, actor audit.Actor, requestID string) error {
+	user, err := h.Users.Uncached().Get(ctx, userID, nil)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return apperr.NotFound("User not found")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	var deleted db.Item
+
+	steps := []step{
+		{
+			name:       "disable-cognito-user",
+			run:        func(ctx context.Context) error { return h.setCognitoEnabled(ctx, userID, false) },
+			compensate: func(ctx context.Context) error { return h.setCognitoEnabled(ctx, userID, true) },
+		},
+		{
+			name: "mark-related-deleted",
+			run: func(ctx context.Context) error {
+				return h.Erasure.SoftDeleteRelated(ctx, userID, now, now.Add(h.Erasure.Retention))
+			},
+			compensate: func(ctx context.Context) error { return h.Erasure.RestoreRelated(ctx, userID, now) },
+		},
+		{
+			name: "mark-user-record-deleted",
+			run: func(ctx context.Context) (err error) {
+				deleted, err = h.Erasure.SoftDelete(ctx, user, now)
+				return err
+			},
+			compensate: func(ctx context.Context) error {
+				_, err := h.Erasure.Restore(ctx, userID, now)
+				if apperr.KindOf(err) == apperr.KindInvalid {
+					// The failed step left the record as it was
+					return nil
+				}
+				return err
+			},
+		},
+		{
+			// Irreversible: after this point failures are only logged
+			name: "revoke-sessions",
+			run: func(ctx context.Context) error {
+				_, err := h.Sessions.RevokeAll(ctx, userID)
+				return err
+			},
+		},
+	}
+
+	if err := runSteps(ctx, userID, steps); err != nil {
+		return err
+	}
+	var email string
+	if v, ok := user["email"].(*types.AttributeValueMemberS); ok {
+		email = v.Value
+	}
+	h.Users.Invalidate(userID, email)
+
+	before, err := users.Profile(user)
+	if err != nil {
+		slog.WarnContext(ctx, "Deleted user record not decodable", "user_id", userID, logging.Err(err))
+	}
+	after, err := users.Profile(deleted)
+	if err != nil {
+		slog.WarnContext(ctx, "Deleted user record not decodable", "user_id", userID, logging.Err(err))
+	}
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionUserSoftDelete,
+		Actor:     actor,
+		RequestID: requestID,
+		Diff:      audit.Diff(before, after),
+	})
+	return nil
+}
+
+// erase removes the user record, the email reservation, every related row
+// (sessions, preferences, device tokens) and the Cognito account, and then
+// audits the deletion by actor and publishes a UserDeleted event. It also
+// purges soft-deleted accounts.
+func (h *Handler) erase(ctx context.Context, userID string, actor audit.Actor, requestID string) error {
+	cfg := h.Config
+
+	// The record is restored on rollback, so it must not come from the cache
+	user, err := h.Users.Uncached().WithDeleted().Get(ctx, userID, nil)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return apperr.NotFound("User not found")
+	}
+
+	var sessions, devices, preferences []db.Item
+
+	steps := []step{
+		{
+			// Disable first so the user cannot sign in while their data disappears
+			name:       "disable-cognito-user",
+			run:        func(ctx context.Context) error { return h.setCognitoEnabled(ctx, userID, false) },
+			compensate: func(ctx context.Context) error { return h.setCognitoEnabled(ctx, userID, true) },
+		},
+		{
+			name: "delete-sessions",
+			run: func(ctx context.Context) (err error) {
+				sessions, err = h.deleteRelated(ctx, cfg.SessionTableName, userID, "user_id", "session_id")
+				return err
+			},
+			compensate: func(ctx context.Context) error { return h.restore(ctx, cfg.SessionTableName, sessions) },
+		},
+		{
+			name: "delete-devices",
+			run: func(ctx context.Context) (err error) {
+				devices, err = h.deleteRelated(ctx, cfg.DeviceTableName, userID, "user_id", "token")
+				return err
+			},
+			compensate: func(ctx context.Context) error { return h.restore(ctx, cfg.DeviceTableName, devices) },
+		},
+		{
+			name: "delete-preferences",
+			run: func(ctx context.Context) (err error) {
+				preferences, err = h.deleteRelated(ctx, cfg.PreferenceTableName, userID, "user_id")
+				return err
+			},
+			compensate: func(ctx context.Context) error { return h.restore(ctx, cfg.PreferenceTableName, preferences) },
+		},
+		{
+			name:       "delete-user-record",
+			run:        func(ctx context.Context) error { return h.deleteUserRecord(ctx, user) },
+			compensate: func(ctx context.Context) error { return h.restoreUserRecord(ctx, user) },
+		},
+		{
+			// Irreversible: after this point failures are only logged
+			name: "delete-cognito-user",
+			run:  func(ctx context.Context) error { return h.deleteCognitoUser(ctx, userID) },
+		},
+	}
+
+	if err := runSteps(ctx, userID, steps); err != nil {
+		return err
+	}
+	h.Users.Invalidate(userID, emailOf(user))
+
+	before, err := users.Profile(user)
+	if err != nil {
+		slog.WarnContext(ctx, "Deleted user record not decodable", "user_id", userID, logging.Err(err))
+	}
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionUserDelete,
+		Actor:     actor,
+		RequestID: requestID,
+		Diff:      audit.Diff(before, nil),
+	})
+
+	// The account is gone either way; a lost event is logged for replay
+	h.Events.Emit(ctx, events.UserDeleted{UserID: userID, DeletedAt: events.Now()})
+	return nil
+}
+
+// setCognitoEnabled enables or disables the Cognito account. An account that
+// no longer exists counts as success so retries of a partial deletion work.
+func (h *Handler) setCognitoEnabled(ctx context.Context, userID string, enabled bool) error {
+	var err error
+	if enabled {
+		_, err = h.Cognito.AdminEnableUser(ctx, &cognitoidentityprovider.AdminEnableUserInput{
+			UserPoolId: aws.String(h.Config.UserPoolID),
+			Username:   aws.String(userID),
+		})
+	} else {
+		_, err = h.Cognito.AdminDisableUser(ctx, &cognitoidentityprovider.AdminDisableUserInput{
+			UserPoolId: aws.String(h.Config.UserPoolID),
+			Username:   aws.String(userID),
+		})
+	}
+	return ignoreCognitoNotFound(err)
+}
+
+// deleteCognitoUser removes the Cognito account.
+func (h *Handler) deleteCognitoUser(ctx context.Context, userID string) error {
+	_, err := h.Cognito.AdminDeleteUser(ctx, &cognitoidentityprovider.AdminDeleteUserInput{
+		UserPoolId: aws.String(h.Config.UserPoolID),
+		Username:   aws.String(userID),
+	})
+	return ignoreCognitoNotFound(err)
+}
+
+// ignoreCognitoNotFound treats a missing Cognito user as success.
+func ignoreCognitoNotFound(err error) error {
+	var notFound *cognitotypes.UserNotFoundException
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
+// deleteRelated deletes every item of tableName whose partition key equals
+// userID and returns the deleted items so they can be restored. keyAttrs are
+// the table's key attribute names (partition key first).
+func (h *Handler) deleteRelated(ctx context.Context, tableName, userID string, keyAttrs ...string) ([]db.Item, error) {
+	var deleted []db.Item
+
+	paginator := dynamodb.NewQueryPaginator(h.DB.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("#pk = :pk"),
+		ExpressionAttributeNames: map[string]string{
+			"#pk": keyAttrs[0],
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: userID},
+		},
+	})
+	for paginator.HasMorePages() {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		db.Observe(ctx, start, err)
+		if err != nil {
+			return deleted, db.Wrap(err, "querying "+tableName)
+		}
+
+		for _, item := range page.Items {
+			key := db.Item{}
+			for _, attr := range keyAttrs {
+				key[attr] = item[attr]
+			}
+			start := time.Now()
+			_, err := h.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName: aws.String(tableName),
+				Key:       key,
+			})
+			db.Observe(ctx, start, err)
+			if err != nil {
+				return deleted, db.Wrap(err, "deleting from "+tableName)
+			}
+			deleted = append(deleted, item)
+		}
+	}
+
+	slog.InfoContext(ctx, "Deleted related items", "user_id", userID, "table", tableName, "count", len(deleted))
+	return deleted, nil
+}
+
+// restore writes previously deleted items back.
+func (h *Handler) restore(ctx context.Context, tableName string, items []db.Item) error {
+	var errs []error
+	for _, item := range items {
+		if err := h.DB.PutItem(ctx, tableName, item); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// emailOf returns the email of the user record, which a soft deletion moves
+// to deleted_email, or "".
+func emailOf(user db.Item) string {
+	for _, attr := range []string{"email", "deleted_email"} {
+		if v, ok := user[attr].(*types.AttributeValueMemberS); ok {
+			return v.Value
+		}
+	}
+	return ""
+}
+
+// deleteUserRecord deletes the user item and its email and username
+// reservations together.
+func (h *Handler) deleteUserRecord(ctx context.Context, user db.Item) error {
+	return h.Users.Delete(ctx, user)
+}
+
+// restoreUserRecord puts the user item and its reservations back together.
+func (h *Handler) restoreUserRecord(ctx context.Context, user db.Item) error {
+	table := h.Config.UserTableName
+	owner, _ := user["user_id"].(*types.AttributeValueMemberS)
+	if owner == nil {
+		return errors.New("user record has no user_id")
+	}
+	writes := []db.Write{db.Put(table, user, "", nil)}
+	if email := emailOf(user); email != "" {
+		writes = append(writes, db.Put(table, users.EmailLock(email, owner.Value), "", nil))
+	}
+	if username, ok := user["username"].(*types.AttributeValueMemberS); ok {
+		writes = append(writes, db.Put(table, users.UsernameLock(username.Value, owner.Value), "", nil))
+	}
+	return h.DB.Transact(ctx, writes...)
+}
+
+// authorize checks that the caller holds the admin scope or the users:delete
+// permission, on top of the route checks of the authorizer. Direct
+// invocations are trusted.
+func authorize(r *httpx.Request) error {
+	if r.Direct || r.HasScope(adminScope) || authz.ClaimsAllow(r, authz.UsersDelete) {
+		return nil
+	}
+	return apperr.Forbidden("Permission " + authz.UsersDelete + " required")
+}
+
+// Handle deletes the user named by the user_id path parameter (or the body of
+// a direct invocation).
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"]}
+
+	// Direct invocations carry the user_id in the body
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(r); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	if err := h.DeleteUser(ctx, req.UserID, audit.ActorOf(ctx, r), audit.RequestID(ctx, r)); err != nil {
+		return httpx.Response{}, err
+	}
+
+	return httpx.NoContent(), nil
+}
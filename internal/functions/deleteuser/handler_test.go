@@ -0,0 +1,368 @@
+package deleteuser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/erasure"
+	"troggle-backend/internal/events"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/sessions"
+	"troggle-backend/internal/users"
+)
+
+// fakeCognito records the admin calls it receives and fails those named in
+// fail.
+type fakeCognito struct {
+	calls []string
+	fail  map[string]error
+}
+
+func (c *fakeCognito) call(op string) error {
+	c.calls = append(c.calls, op)
+	return c.fail[op]
+}
+
+func (c *fakeCognito) AdminDisableUser(context.Context, *cognitoidentityprovider.AdminDisableUserInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDisableUserOutput, error) {
+	return &cognitoidentityprovider.AdminDisableUserOutput{}, c.call("AdminDisableUser")
+}
+
+func (c *fakeCognito) AdminEnableUser(context.Context, *cognitoidentityprovider.AdminEnableUserInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminEnableUserOutput, error) {
+	return &cognitoidentityprovider.AdminEnableUserOutput{}, c.call("AdminEnableUser")
+}
+
+func (c *fakeCognito) AdminDeleteUser(context.Context, *cognitoidentityprovider.AdminDeleteUserInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDeleteUserOutput, error) {
+	return &cognitoidentityprovider.AdminDeleteUserOutput{}, c.call("AdminDeleteUser")
+}
+
+// fakeEvents records published events.
+type fakeEvents struct {
+	published []*eventbridge.PutEventsInput
+}
+
+func (e *fakeEvents) PutEvents(_ context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	e.published = append(e.published, in)
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		UserTableName:       "users",
+		SessionTableName:    "sessions",
+		DeviceTableName:     "devices",
+		PreferenceTableName: "preferences",
+		UserPoolID:          "pool",
+		EventBusName:        "bus",
+	}
+}
+
+// storedUser answers user-table lookups for u1.
+func storedUser(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1", "email", "jane@example.com")}, nil
+}
+
+// oneSession answers session queries with one item and everything else with
+// none.
+func oneSession(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	if aws.ToString(in.TableName) != "sessions" {
+		return &dynamodb.QueryOutput{}, nil
+	}
+	return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1", "session_id", "s1")}}, nil
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		get         func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		transact    func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error)
+		cognitoFail map[string]error
+		wantStatus  int
+		wantCognito []string
+		wantOps     []string
+		wantEvents  int
+	}{
+		{
+			name:        "deleted",
+			body:        `{"user_id":"u1"}`,
+			get:         storedUser,
+			wantStatus:  204,
+			wantCognito: []string{"AdminDisableUser", "AdminDeleteUser"},
+			wantOps:     []string{"GetItem", "Query", "DeleteItem", "Query", "Query", "TransactWriteItems"},
+			wantEvents:  1,
+		},
+		{
+			name:       "not found",
+			body:       `{"user_id":"u1"}`,
+			wantStatus: 404,
+			wantOps:    []string{"GetItem"},
+		},
+		{
+			name:       "invalid id",
+			body:       `{"user_id":""}`,
+			wantStatus: 422,
+		},
+		{
+			name: "record deletion fails",
+			body: `{"user_id":"u1"}`,
+			get:  storedUser,
+			transact: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				return nil, dbtest.Throttled()
+			},
+			wantStatus:  429,
+			wantCognito: []string{"AdminDisableUser", "AdminEnableUser"},
+			// The failed transaction is compensated too: the user record with
+			// its email reservation, then the deleted session
+			wantOps: []string{"GetItem", "Query", "DeleteItem", "Query", "Query", "TransactWriteItems", "TransactWriteItems", "PutItem"},
+		},
+		{
+			name:        "cognito user already gone",
+			body:        `{"user_id":"u1"}`,
+			get:         storedUser,
+			cognitoFail: map[string]error{"AdminDeleteUser": &cognitotypes.UserNotFoundException{}},
+			wantStatus:  204,
+			wantCognito: []string{"AdminDisableUser", "AdminDeleteUser"},
+			wantOps:     []string{"GetItem", "Query", "DeleteItem", "Query", "Query", "TransactWriteItems"},
+			wantEvents:  1,
+		},
+		{
+			name:        "cognito deletion fails",
+			body:        `{"user_id":"u1"}`,
+			get:         storedUser,
+			cognitoFail: map[string]error{"AdminDeleteUser": errors.New("boom")},
+			wantStatus:  500,
+			wantCognito: []string{"AdminDisableUser", "AdminDeleteUser", "AdminEnableUser"},
+			// Nothing was committed, so every earlier step is undone, the user
+			// record and its reservation in one transaction
+			wantOps: []string{"GetItem", "Query", "DeleteItem", "Query", "Query", "TransactWriteItems", "TransactWriteItems", "PutItem"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: tt.get, QueryFunc: oneSession, TransactWriteItemsFunc: tt.transact}
+			cognito := &fakeCognito{fail: tt.cognitoFail}
+			fake := &fakeEvents{}
+			cfg := testConfig()
+			h := &Handler{DB: m.Client(), Users: users.NewRepository(m.Client(), cfg), Cognito: cognito, Events: &events.Publisher{API: fake, Bus: "bus"}, Config: cfg}
+
+			resp, err := httpx.Adapt(h.Handle)(context.Background(), json.RawMessage(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !reflect.DeepEqual(cognito.calls, tt.wantCognito) {
+				t.Errorf("Cognito calls = %v, want %v", cognito.calls, tt.wantCognito)
+			}
+			if ops := m.Ops(); !reflect.DeepEqual(ops, tt.wantOps) && len(ops)+len(tt.wantOps) > 0 {
+				t.Errorf("DynamoDB calls = %v, want %v", ops, tt.wantOps)
+			}
+			if len(fake.published) != tt.wantEvents {
+				t.Errorf("%d events published, want %d", len(fake.published), tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestDeleteRelatedPaginates(t *testing.T) {
+	pages := []*dynamodb.QueryOutput{
+		{
+			Items:            []db.Item{dbtest.Item("user_id", "u1", "token", "t1")},
+			LastEvaluatedKey: dbtest.Item("user_id", "u1", "token", "t1"),
+		},
+		{Items: []db.Item{dbtest.Item("user_id", "u1", "token", "t2", "platform", "ios")}},
+	}
+	m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		page := pages[0]
+		pages = pages[1:]
+		return page, nil
+	}}
+	h := &Handler{DB: m.Client(), Config: testConfig()}
+
+	deleted, err := h.deleteRelated(context.Background(), "devices", "u1", "user_id", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("deleted %d items, want 2", len(deleted))
+	}
+	if want := []string{"Query", "DeleteItem", "Query", "DeleteItem"}; !reflect.DeepEqual(m.Ops(), want) {
+		t.Errorf("DynamoDB calls = %v, want %v", m.Ops(), want)
+	}
+	if m.Calls[2].Input.(*dynamodb.QueryInput).ExclusiveStartKey == nil {
+		t.Error("second page was queried without ExclusiveStartKey")
+	}
+	key := m.Calls[3].Input.(*dynamodb.DeleteItemInput).Key
+	if _, ok := key["platform"]; ok || len(key) != 2 {
+		t.Errorf("DeleteItem key = %v, want only the key attributes", key)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// u1 is due; u2 cancelled after the index was read. u3 is past its
+	// retention window; u4 was restored after the index was read
+	records := map[string]db.Item{
+		"u1": dbtest.Item("user_id", "u1", "status", erasure.StatusPendingDeletion, "deletion_scheduled_at", "2024-05-01T11:00:00Z"),
+		"u2": dbtest.Item("user_id", "u2", "status", "active"),
+		"u3": dbtest.Item("user_id", "u3", "status", erasure.StatusDeleted, "deleted_at", "2024-04-01T11:00:00Z", "purge_at", "2024-05-01T11:00:00Z", "deleted_email", "u3@example.com"),
+		"u4": dbtest.Item("user_id", "u4", "status", "active"),
+	}
+	m := &dbtest.Mock{
+		GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			id := in.Key["user_id"].(*types.AttributeValueMemberS).Value
+			return &dynamodb.GetItemOutput{Item: records[id]}, nil
+		},
+		QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			if aws.ToString(in.IndexName) != "status-index" {
+				return &dynamodb.QueryOutput{}, nil
+			}
+			if _, ok := in.ExpressionAttributeValues[":deleted"]; ok {
+				return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u3"), dbtest.Item("user_id", "u4")}}, nil
+			}
+			return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1"), dbtest.Item("user_id", "u2")}}, nil
+		},
+	}
+	cognito := &fakeCognito{}
+	cfg := testConfig()
+	h := &Handler{
+		DB:      m.Client(),
+		Users:   users.NewRepository(m.Client(), cfg),
+		Cognito: cognito,
+		Erasure: &erasure.Store{DB: m.Client(), Table: "users", StatusIndex: "status-index"},
+		Config:  cfg,
+	}
+
+	if err := h.Sweep(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"AdminDisableUser", "AdminDeleteUser", "AdminDisableUser", "AdminDeleteUser"}; !reflect.DeepEqual(cognito.calls, want) {
+		t.Errorf("Cognito calls = %v, want %v (only u1 and u3 erased)", cognito.calls, want)
+	}
+	var locks []string
+	for _, c := range m.Calls {
+		if in, ok := c.Input.(*dynamodb.TransactWriteItemsInput); ok {
+			for _, item := range in.TransactItems {
+				locks = append(locks, item.Delete.Key["user_id"].(*types.AttributeValueMemberS).Value)
+			}
+		}
+	}
+	if want := []string{"u1", "u3", users.EmailLockPrefix + "u3@example.com"}; !reflect.DeepEqual(locks, want) {
+		t.Errorf("deleted %v, want %v (the purged email reservation included)", locks, want)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	tests := []struct {
+		name        string
+		update      func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+		wantStatus  int
+		wantCognito []string
+		wantOps     []string
+	}{
+		{
+			name:        "marked deleted",
+			wantStatus:  204,
+			wantCognito: []string{"AdminDisableUser"},
+			// User, devices, preferences, the record marked, then sessions
+			wantOps: []string{"GetItem", "Query", "UpdateItem", "Query", "UpdateItem", "Query", "UpdateItem"},
+		},
+		{
+			name: "record marking fails",
+			update: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				if aws.ToString(in.TableName) == "users" {
+					return nil, dbtest.Throttled()
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			},
+			wantStatus:  429,
+			wantCognito: []string{"AdminDisableUser", "AdminEnableUser"},
+			// The record is read back and found unchanged; the device is
+			// restored
+			wantOps: []string{"GetItem", "Query", "UpdateItem", "Query", "UpdateItem", "GetItem", "Query", "UpdateItem", "Query"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: storedUser,
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					switch aws.ToString(in.TableName) {
+					case "devices":
+						return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1", "token", "t1")}}, nil
+					case "sessions":
+						item := dbtest.Item("user_id", "u1", "session_id", "s1")
+						item["expires_at"] = &types.AttributeValueMemberN{Value: "9999999999"}
+						return &dynamodb.QueryOutput{Items: []db.Item{item}}, nil
+					}
+					return &dynamodb.QueryOutput{}, nil
+				},
+				UpdateItemFunc: tt.update,
+			}
+			cognito := &fakeCognito{}
+			fake := &fakeEvents{}
+			cfg := testConfig()
+			cfg.DeletionMode = config.DeletionSoft
+			h := &Handler{
+				DB:       m.Client(),
+				Users:    users.NewRepository(m.Client(), cfg),
+				Sessions: &sessions.Store{DB: m.Client(), Table: "sessions"},
+				Cognito:  cognito,
+				Events:   &events.Publisher{API: fake, Bus: "bus"},
+				Erasure: &erasure.Store{
+					DB: m.Client(), Table: "users", Retention: 30 * 24 * time.Hour,
+					Related: []erasure.Related{
+						{Table: "devices", Keys: []string{"user_id", "token"}, TTL: time.Hour},
+						{Table: "preferences", Keys: []string{"user_id"}},
+					},
+				},
+				Config: cfg,
+			}
+
+			resp, err := httpx.Adapt(h.Handle)(context.Background(), json.RawMessage(`{"user_id":"u1"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !reflect.DeepEqual(cognito.calls, tt.wantCognito) {
+				t.Errorf("Cognito calls = %v, want %v", cognito.calls, tt.wantCognito)
+			}
+			if ops := m.Ops(); !reflect.DeepEqual(ops, tt.wantOps) {
+				t.Errorf("DynamoDB calls = %v, want %v", ops, tt.wantOps)
+			}
+			if len(fake.published) != 0 {
+				t.Errorf("%d events published, want none: the account is not gone yet", len(fake.published))
+			}
+		})
+	}
+}
+
+func TestInvokeScheduledEvent(t *testing.T) {
+	m := &dbtest.Mock{}
+	h := &Handler{DB: m.Client(), Erasure: &erasure.Store{DB: m.Client(), Table: "users", StatusIndex: "status-index"}, Config: testConfig()}
+
+	payload := json.RawMessage(`{"source":"aws.events","detail-type":"Scheduled Event","detail":{}}`)
+	if _, err := h.Invoke(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	if ops := m.Ops(); !reflect.DeepEqual(ops, []string{"Query", "Query"}) {
+		t.Errorf("DynamoDB calls = %v, want the due-account and purgeable-account queries", ops)
+	}
+}
@@ -0,0 +1,58 @@
+// Package emailfeedback subscribes to the SNS topic SES reports bounces and
+// complaints to, and adds the affected addresses to the suppression list so
+// the sendEmail worker stops mailing them.
+package emailfeedback
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-lambda-go/events" // Lambda event payloads
+
+	"troggle-backend/internal/config"  // environment-driven settings
+	"troggle-backend/internal/db"      // shared DynamoDB client
+	"troggle-backend/internal/email"   // suppression list and SES notification parsing
+	"troggle-backend/internal/logging" // structured JSON logging
+	"troggle-backend/internal/metrics" // CloudWatch EMF metrics
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Suppressions *email.Suppressions
+	Config       *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	return &Handler{Suppressions: email.NewSuppressions(client, cfg), Config: cfg}, nil
+}
+
+// Handle records the suppressions of every notification in the event. A
+// failed write fails the invocation so SNS delivers the event again; adding
+// a suppression twice is harmless.
+func (h *Handler) Handle(ctx context.Context, event events.SNSEvent) error {
+	ctx, recorder := metrics.NewContext(ctx)
+	defer recorder.Flush()
+
+	for _, record := range event.Records {
+		ctx := logging.With(ctx, "sns_message_id", record.SNS.MessageID)
+		sups, err := email.ParseFeedback(record.SNS.Message)
+		if err != nil {
+			slog.ErrorContext(ctx, "Ignoring malformed SES notification", logging.Err(err))
+			continue
+		}
+		for _, sup := range sups {
+			if err := h.Suppressions.Add(ctx, sup); err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "Address suppressed", "reason", sup.Reason, "detail", sup.Detail, logging.EmailHash(sup.Email))
+			metrics.Count(ctx, metrics.SuppressionAdded)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Package enqueueformatch queues the caller for a match (POST
+// /matchmaking/tickets with {"region": "eu", "board": "main"}). The
+// matchmaking worker matches them with players of a similar rating in the
+// same queue, and tells them about the match over their WebSocket
+// connections or by push. Enqueuing again replaces the caller's ticket.
+// See package matchmaking.
+package enqueueformatch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/matchmaking" // matchmaking queue
+	"troggle-backend/internal/ratelimit"   // DynamoDB token buckets
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// rateLimits allow a ticket every few seconds. They can be tuned per stage
+// through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(60),
+	PerUser: ratelimit.PerMinute(20),
+}
+
+// Request represents the JSON input. API Gateway callers queue themselves;
+// direct invocations name the user.
+type Request struct {
+	UserID string `json:"user_id" api:"direct"`
+	Region string `json:"region"` // latency region of the player
+	Board  string `json:"board"`  // optional leaderboard the match counts towards
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "enqueueForMatch",
+	Function: "enqueueForMatch",
+	Summary:  "Queues the caller for matchmaking",
+	Method:   "POST",
+	Path:     "/matchmaking/tickets",
+	Request:  Request{},
+	Status:   202,
+	Response: matchmaking.Ticket{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Matchmaking *matchmaking.Store
+	Limiter     *ratelimit.Limiter
+	Limits      ratelimit.Policy
+	Auth        auth.TokenVerifier
+	Config      *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Matchmaking: matchmaking.NewStore(client, cfg),
+		Limiter:     ratelimit.New(client, cfg),
+		Limits:      limits,
+		Auth:        verifier,
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle stores the ticket and returns it. Storing the same ticket twice
+// only moves the player back in the queue, so retries need no idempotency
+// key.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req.UserID = id.Subject
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	t, err := h.Matchmaking.Enqueue(ctx, req.UserID, req.Region, req.Board, time.Now())
+	switch {
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Queued for a match", "user_id", t.UserID, "region", t.Region, "board", t.Board, "rating", t.Rating)
+	return httpx.JSON(202, t), nil
+}
@@ -0,0 +1,78 @@
+package enqueueformatch
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/matches"
+	"troggle-backend/internal/matchmaking"
+)
+
+// apiEvent is a POST /matchmaking/tickets REST API event.
+func apiEvent(body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/matchmaking/tickets",
+		"body":       body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantUser   string // of the stored ticket
+		wantBody   string
+	}{
+		{name: "queues", payload: apiEvent(`{"region":"eu"}`), wantStatus: 202, wantUser: "u1", wantBody: `"rating":1000`},
+		{name: "on a board", payload: apiEvent(`{"region":"na","board":"main"}`), wantStatus: 202, wantUser: "u1", wantBody: `"board":"main"`},
+		{name: "unknown region", payload: apiEvent(`{"region":"mars"}`), wantStatus: 422, wantBody: matchmaking.CodeRegionInvalid},
+		{name: "no region", payload: apiEvent(`{}`), wantStatus: 422},
+		{name: "unknown board", payload: apiEvent(`{"region":"eu","board":"speed"}`), wantStatus: 404},
+		{name: "no body", payload: apiEvent(""), wantStatus: 400},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u2","region":"eu"}`), wantStatus: 202, wantUser: "u2", wantBody: `"user_id":"u2"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user string
+			m := &dbtest.Mock{PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+				user = in.Item["user_id"].(*types.AttributeValueMemberS).Value
+				return &dynamodb.PutItemOutput{}, nil
+			}}
+			h := &Handler{
+				Matchmaking: &matchmaking.Store{
+					DB: m.Client(), Table: "tickets", TTL: 5 * time.Minute, Regions: []string{"eu", "na"},
+					Matches: &matches.Store{DB: m.Client(), PlayerTable: "players", Boards: []string{"main"}},
+				},
+				Config: &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if user != tt.wantUser {
+				t.Errorf("user = %q, want %q", user, tt.wantUser)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+// Package exportuserdata serves GDPR data access requests. Users (or
+// admins) start an export with POST /users/{user_id}/exports and poll
+// GET /users/{user_id}/exports/{export_id} until it is ready, when the
+// answer carries a presigned link to the bundle. The collection runs in the
+// same function, invoked by the export queue; see package exports.
+package exportuserdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"     // Lambda event payloads
+	"github.com/aws/aws-sdk-go-v2/service/s3" // S3 client
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/exports"    // export jobs, collection and bundles
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/metrics"    // CloudWatch EMF metrics
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/sqsx"       // SQS batch handling
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON input of a direct invocation: a user_id starts
+// an export, and a user_id with an export_id asks for its status.
+type Request struct {
+	UserID   string `json:"user_id"`
+	ExportID string `json:"export_id,omitempty"`
+}
+
+// Status is the answer about an export.
+type Status struct {
+	exports.Job
+	DownloadURL  string `json:"download_url,omitempty"`
+	URLExpiresAt string `json:"url_expires_at,omitempty"` // RFC 3339
+}
+
+// authorize lets callers export their own data only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only export your own data")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "exportUserData",
+		Function: "exportUserData",
+		Summary:  "Starts an export of the data of a user",
+		Method:   "POST",
+		Path:     "/users/{user_id}/exports",
+		Status:   202,
+		Response: Status{},
+	},
+	{
+		Name:     "getUserDataExport",
+		Function: "exportUserData",
+		Summary:  "Returns the state of an export, with a link once it is ready",
+		Method:   "GET",
+		Path:     "/users/{user_id}/exports/{export_id}",
+		Response: Status{},
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Exports   *exports.Store
+	Queue     *exports.Queue
+	Collector *exports.Collector
+	Archive   *exports.Archive
+	Auth      auth.TokenVerifier
+	Audit     *audit.Store
+	Config    *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// export queue and bucket.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireExports(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Exports:   exports.NewStore(client, cfg),
+		Queue:     exports.NewQueue(sqs.NewFromConfig(awsCfg), cfg),
+		Collector: &exports.Collector{DB: client, Config: cfg},
+		Archive:   exports.NewArchive(s3.NewFromConfig(awsCfg), cfg),
+		Auth:      verifier,
+		Audit:     audit.NewStore(client, cfg),
+		Config:    cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// sqsProbe detects SQS events among incoming payloads.
+type sqsProbe struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+	} `json:"Records"`
+}
+
+// Invoke is the Lambda entry point. Batches of the export queue are
+// processed as jobs; anything else goes through the REST handler.
+func (h *Handler) Invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+	var probe sqsProbe
+	if err := json.Unmarshal(payload, &probe); err == nil && len(probe.Records) > 0 && probe.Records[0].EventSource == "aws:sqs" {
+		var event events.SQSEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding SQS event: %w", err)
+		}
+		c := sqsx.Consumer[exports.Message]{Process: h.ProcessMessage, MaxReceiveCount: h.Config.QueueMaxReceiveCount}
+		return c.Handle(ctx, event)
+	}
+	return httpx.Adapt(h.HTTP())(ctx, payload)
+}
+
+// Handle starts an export (POST, or a direct invocation without export_id)
+// or reports on one.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"], ExportID: r.PathParams["export_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	if req.ExportID == "" {
+		return h.start(ctx, r, req.UserID)
+	}
+	return h.status(ctx, req.UserID, req.ExportID)
+}
+
+// start records and queues a new export and answers 202 with the job.
+func (h *Handler) start(ctx context.Context, r *httpx.Request, userID string) (httpx.Response, error) {
+	job, err := h.Exports.Create(ctx, userID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if err := h.Queue.Enqueue(ctx, job); err != nil {
+		job.Status, job.Error = exports.StatusFailed, "The export could not be started; request a new one"
+		if uerr := h.Exports.Update(ctx, job); uerr != nil {
+			slog.ErrorContext(ctx, "Failed to mark export failed", "user_id", userID, "export_id", job.ExportID, logging.Err(uerr))
+		}
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Export requested", "user_id", userID, "export_id", job.ExportID)
+
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionUserExport,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"exports." + job.ExportID: {After: exports.StatusPending}},
+	})
+	return httpx.JSON(202, Status{Job: job}), nil
+}
+
+// status answers with the export, linking to the bundle once it is ready.
+func (h *Handler) status(ctx context.Context, userID, exportID string) (httpx.Response, error) {
+	job, err := h.Exports.Get(ctx, userID, exportID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if job == nil {
+		return httpx.Error(apperr.NotFound("Export not found")), nil
+	}
+
+	s := Status{Job: *job}
+	if job.Status == exports.StatusReady {
+		url, expires, err := h.Archive.URL(ctx, job.Key)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		s.DownloadURL, s.URLExpiresAt = url, expires.Format(time.RFC3339)
+	}
+	return httpx.JSON(200, s), nil
+}
+
+// ProcessMessage runs one export job. It returns an error only when the job
+// should be tried again.
+func (h *Handler) ProcessMessage(ctx context.Context, msg *sqsx.Message[exports.Message]) error {
+	m := &msg.Body
+	if err := m.Validate(); err != nil {
+		return sqsx.Drop(fmt.Errorf("invalid export message: %w", err))
+	}
+	ctx = logging.With(ctx, "user_id", m.UserID, "export_id", m.ExportID)
+
+	job, err := h.Exports.Get(ctx, m.UserID, m.ExportID)
+	if err != nil {
+		return err
+	}
+	if job == nil || job.Status == exports.StatusReady || job.Status == exports.StatusFailed {
+		// Expired, or a redelivery of a finished job
+		return nil
+	}
+
+	if job.Status == exports.StatusPending {
+		job.Status = exports.StatusRunning
+		if err := h.Exports.Update(ctx, *job); err != nil {
+			return err
+		}
+	}
+
+	key, err := h.export(ctx, *job)
+	if errors.Is(err, exports.ErrNoUser) {
+		return sqsx.Drop(h.fail(ctx, *job, "The user no longer exists", err))
+	}
+	if err != nil {
+		if msg.ReceiveCount >= h.Config.QueueMaxReceiveCount {
+			return h.fail(ctx, *job, "The export failed; request a new one", err)
+		}
+		return err
+	}
+
+	job.Status, job.Key, job.CompletedAt = exports.StatusReady, key, time.Now().UTC().Format(time.RFC3339)
+	if err := h.Exports.Update(ctx, *job); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Export ready", "key", key)
+	metrics.Count(ctx, metrics.ExportCompleted)
+	return nil
+}
+
+// export collects the user's data and uploads the bundle.
+func (h *Handler) export(ctx context.Context, job exports.Job) (string, error) {
+	bundle, err := h.Collector.Collect(ctx, job.UserID)
+	if err != nil {
+		return "", err
+	}
+	body, err := bundle.Zip()
+	if err != nil {
+		return "", err
+	}
+	return h.Archive.Upload(ctx, job, body)
+}
+
+// fail marks job failed with reason for the user and returns err, the cause.
+func (h *Handler) fail(ctx context.Context, job exports.Job, reason string, err error) error {
+	slog.ErrorContext(ctx, "Export failed", logging.Err(err))
+	metrics.Count(ctx, metrics.ExportFailed)
+	job.Status, job.Error, job.CompletedAt = exports.StatusFailed, reason, time.Now().UTC().Format(time.RFC3339)
+	if uerr := h.Exports.Update(ctx, job); uerr != nil {
+		return errors.Join(err, uerr)
+	}
+	return err
+}
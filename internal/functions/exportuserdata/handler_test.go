@@ -0,0 +1,207 @@
+package exportuserdata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/exports"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/sqsx"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeSQS records sent messages.
+type fakeSQS struct{ sent []string }
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.sent = append(f.sent, aws.ToString(in.MessageBody))
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// fakeS3 records uploaded keys and presigns any key.
+type fakeS3 struct {
+	keys []string
+	err  error
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.keys = append(f.keys, aws.ToString(in.Key))
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) PresignGetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return &v4.PresignedHTTPRequest{URL: "https://bucket.example/" + aws.ToString(in.Key) + "?sig"}, nil
+}
+
+// apiEvent is a REST API event of the export routes.
+func apiEvent(method, userID, exportID string) json.RawMessage {
+	params := map[string]string{"user_id": userID}
+	if exportID != "" {
+		params["export_id"] = exportID
+	}
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     method,
+		"path":           "/users/" + userID + "/exports",
+		"pathParameters": params,
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+	})
+	return event
+}
+
+// job answers GetItem of the export table with an export in status.
+func job(status string) func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		if aws.ToString(in.TableName) != "exports" {
+			return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1", "email", "jane@example.com")}, nil
+		}
+		return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1", "export_id", "e1", "status", status, "object_key", "exports/u1/e1.zip")}, nil
+	}
+}
+
+func newHandler(m *dbtest.Mock, queue *fakeSQS, store *fakeS3, caller *auth.Identity) *Handler {
+	cfg := &config.Config{ExportTableName: "exports", UserTableName: "users", QueueMaxReceiveCount: 3}
+	return &Handler{
+		Exports:   &exports.Store{DB: m.Client(), Table: "exports"},
+		Queue:     &exports.Queue{SQS: queue, URL: "queue"},
+		Collector: &exports.Collector{DB: m.Client(), Config: cfg},
+		Archive:   &exports.Archive{S3: store, Presign: store, Bucket: "bucket"},
+		Auth:      stubVerifier{caller},
+		Config:    cfg,
+	}
+}
+
+func TestHandle(t *testing.T) {
+	owner := &auth.Identity{Subject: "u1"}
+	other := &auth.Identity{Subject: "u2"}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		get        func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		wantStatus int
+		wantBody   string
+		wantQueued int
+	}{
+		{
+			name:    "start",
+			payload: apiEvent("POST", "u1", ""), caller: owner,
+			wantStatus: 202, wantBody: `"status":"pending"`, wantQueued: 1,
+		},
+		{
+			name:       "direct start",
+			payload:    json.RawMessage(`{"user_id":"u1"}`),
+			wantStatus: 202, wantQueued: 1,
+		},
+		{
+			name:    "another user",
+			payload: apiEvent("POST", "u1", ""), caller: other,
+			wantStatus: 403,
+		},
+		{
+			name:    "running",
+			payload: apiEvent("GET", "u1", "e1"), caller: owner, get: job(exports.StatusRunning),
+			wantStatus: 200, wantBody: `"status":"running"`,
+		},
+		{
+			name:    "ready",
+			payload: apiEvent("GET", "u1", "e1"), caller: owner, get: job(exports.StatusReady),
+			wantStatus: 200, wantBody: `"download_url":"https://bucket.example/exports/u1/e1.zip?sig"`,
+		},
+		{
+			name:    "unknown export",
+			payload: apiEvent("GET", "u1", "e9"), caller: owner,
+			wantStatus: 404,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: tt.get}
+			queue := &fakeSQS{}
+			h := newHandler(m, queue, &fakeS3{}, tt.caller)
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+			if len(queue.sent) != tt.wantQueued {
+				t.Errorf("queued = %d, want %d", len(queue.sent), tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestProcessMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		uploadErr    error
+		receiveCount int
+		wantErr      bool
+		wantStatus   string // status of the last update; empty means none
+	}{
+		{name: "pending", status: exports.StatusPending, receiveCount: 1, wantStatus: exports.StatusReady},
+		{name: "redelivered after completion", status: exports.StatusReady, receiveCount: 2},
+		{name: "upload failure is retried", status: exports.StatusRunning, uploadErr: errors.New("unavailable"), receiveCount: 1, wantErr: true},
+		{name: "last attempt fails the job", status: exports.StatusRunning, uploadErr: errors.New("unavailable"), receiveCount: 3, wantErr: true, wantStatus: exports.StatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: job(tt.status), QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				return &dynamodb.QueryOutput{Items: []db.Item{}}, nil
+			}}
+			h := newHandler(m, &fakeSQS{}, &fakeS3{err: tt.uploadErr}, nil)
+
+			msg := &sqsx.Message[exports.Message]{
+				Body:         exports.Message{UserID: "u1", ExportID: "e1"},
+				ReceiveCount: tt.receiveCount,
+			}
+			err := h.ProcessMessage(context.Background(), msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var last string
+			for _, c := range m.Calls {
+				if in, ok := c.Input.(*dynamodb.UpdateItemInput); ok {
+					last = in.ExpressionAttributeValues[":status"].(*types.AttributeValueMemberS).Value
+				}
+			}
+			if last != tt.wantStatus {
+				t.Errorf("final status = %q, want %q", last, tt.wantStatus)
+			}
+		})
+	}
+}
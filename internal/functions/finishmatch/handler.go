@@ -0,0 +1,226 @@
+// Package finishmatch records the results of a match in progress (POST
+// /matches/{match_id}/results with {"results": [{"user_id": "...",
+// "score": 1200}, ...]}), reported by its host or a trusted game server.
+// The match state machine invokes it too, with {"match_id": "...",
+// "timeout": "join"|"play"}, when a deadline of the match passes. See
+// package matches.
+//
+// Finished matches publish a MatchFinished event and a MatchPlayed event
+// per player, which the leaderboard and achievement consumers subscribe
+// to; abandoned ones publish MatchAbandoned.
+package finishmatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge" // EventBridge client
+
+	"troggle-backend/internal/api"       // API route declarations
+	"troggle-backend/internal/apperr"    // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"      // Cognito JWT verification
+	"troggle-backend/internal/authz"     // role-based access control
+	"troggle-backend/internal/awscfg"    // shared AWS SDK config
+	"troggle-backend/internal/config"    // environment-driven settings
+	"troggle-backend/internal/db"        // shared DynamoDB client
+	"troggle-backend/internal/events"    // domain event publishing
+	"troggle-backend/internal/httpx"     // API Gateway / direct invocation adapter
+	"troggle-backend/internal/matches"   // match records
+	"troggle-backend/internal/ratelimit" // DynamoDB token buckets
+	"troggle-backend/internal/sessions"  // session table access
+)
+
+// rateLimits allow a result every few seconds, more than any game produces.
+// They can be tuned per stage through RATE_LIMIT_PER_IP and
+// RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(120),
+	PerUser: ratelimit.PerMinute(30),
+}
+
+// Request represents the JSON input. API Gateway callers pass the match as
+// a path parameter and only the results in the request body.
+type Request struct {
+	MatchID string           `json:"match_id"`
+	Results []matches.Result `json:"results"` // places are derived from the scores
+}
+
+// Timeout is the input of the match state machine.
+type Timeout struct {
+	MatchID string `json:"match_id"`
+	Timeout string `json:"timeout"` // one of the matches.Timeout* values
+}
+
+// TimeoutResponse is the output the state machine chooses its next state by.
+type TimeoutResponse struct {
+	Status string `json:"status"` // of the match after the timeout
+}
+
+// authorize lets hosts report the results of their matches only, unless
+// they are admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, m matches.Match) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != m.HostID && !authz.Allowed(id, authz.MatchesManage) {
+		return apperr.Forbidden("Only the host may report the results of a match")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "finishMatch",
+	Function: "finishMatch",
+	Summary:  "Records the results of a match",
+	Method:   "POST",
+	Path:     "/matches/{match_id}/results",
+	Request:  Request{},
+	Response: matches.Match{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Matches *matches.Store
+	Events  *events.Publisher
+	Limiter *ratelimit.Limiter
+	Limits  ratelimit.Policy
+	Auth    auth.TokenVerifier
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Matches: matches.NewStore(client, cfg),
+		Events:  events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Limiter: ratelimit.New(client, cfg),
+		Limits:  limits,
+		Auth:    verifier,
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies. Reporting
+// results again returns those recorded, so the endpoint needs no
+// idempotency key.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Invoke is the Lambda entry point. Timeouts of the state machine expire
+// the match; anything else goes through the REST handler.
+func (h *Handler) Invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+	var t Timeout
+	if err := json.Unmarshal(payload, &t); err == nil && t.Timeout != "" {
+		return h.Expire(ctx, t)
+	}
+	return httpx.Adapt(h.HTTP())(ctx, payload)
+}
+
+// Expire applies the timeout t. Errors fail the invocation, so the state
+// machine retries; expiring a match again does nothing.
+func (h *Handler) Expire(ctx context.Context, t Timeout) (TimeoutResponse, error) {
+	now := time.Now()
+	m, abandoned, err := h.Matches.Expire(ctx, t.MatchID, t.Timeout, now)
+	if apperr.KindOf(err) == apperr.KindNotFound {
+		// Nothing to end: the execution of a match that was never stored
+		// reports no status and stops
+		slog.WarnContext(ctx, "Timeout of an unknown match", "match_id", t.MatchID, "timeout", t.Timeout)
+		return TimeoutResponse{}, nil
+	}
+	if err != nil {
+		return TimeoutResponse{}, err
+	}
+	if abandoned {
+		slog.InfoContext(ctx, "Match abandoned", "match_id", m.ID, "timeout", t.Timeout)
+		stage := matches.Waiting
+		if t.Timeout == matches.TimeoutPlay {
+			stage = matches.InProgress
+		}
+		h.Events.Emit(ctx, events.MatchAbandoned{MatchID: m.ID, Players: m.Players, Stage: stage, AbandonedAt: events.Now()})
+	}
+	return TimeoutResponse{Status: m.Status}, nil
+}
+
+// Handle records the results and returns the finished match.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.MatchID = r.PathParams["match_id"]
+	}
+	if req.MatchID == "" {
+		return httpx.Error(apperr.NotFound("Match not found")), nil
+	}
+
+	m, err := h.Matches.Get(ctx, req.MatchID)
+	switch {
+	case apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	if err := authorize(ctx, r, m); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	m, finished, err := h.Matches.Finish(ctx, req.MatchID, req.Results, time.Now())
+	switch {
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	if finished {
+		slog.InfoContext(ctx, "Match finished", "match_id", m.ID, "players", len(m.Players))
+		h.Events.Emit(ctx, finishedEvents(m)...)
+	}
+	return httpx.JSON(200, m), nil
+}
+
+// finishedEvents returns the events of the finished match m.
+func finishedEvents(m matches.Match) []events.Event {
+	at := m.EndedAt.Format(time.RFC3339)
+	finished := events.MatchFinished{MatchID: m.ID, Board: m.Board, FinishedAt: at}
+	evs := []events.Event{}
+	for _, r := range m.Results {
+		finished.Results = append(finished.Results, events.MatchResult{UserID: r.UserID, Score: r.Score, Place: r.Place})
+		evs = append(evs, events.MatchPlayed{
+			UserID:     r.UserID,
+			MatchID:    m.ID,
+			Board:      m.Board,
+			Score:      r.Score,
+			Place:      r.Place,
+			Won:        r.Place == 1,
+			FinishedAt: at,
+		})
+	}
+	return append([]events.Event{finished}, evs...)
+}
@@ -0,0 +1,133 @@
+package finishmatch
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/matches"
+	"troggle-backend/internal/rating"
+)
+
+// apiEvent is a POST /matches/{match_id}/results REST API event.
+func apiEvent(matchID, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/matches/" + matchID + "/results",
+		"pathParameters": map[string]string{"match_id": matchID},
+		"body":           body,
+	})
+	return event
+}
+
+// match returns the item of match m1 of status, hosted by u1 and played by
+// u1 and u2.
+func match(status string) db.Item {
+	item, _ := attributevalue.MarshalMap(matches.Match{
+		ID: "m1", HostID: "u1", Status: status, Players: []string{"u1", "u2"}, MaxPlayers: 2,
+		JoinBy: time.Now(), FinishBy: time.Now().Add(time.Hour),
+	})
+	return item
+}
+
+func TestHandle(t *testing.T) {
+	results := `{"results":[{"user_id":"u1","score":10},{"user_id":"u2","score":20}]}`
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "host reports",
+			payload:    apiEvent("m1", results),
+			caller:     &auth.Identity{Subject: "u1"},
+			wantStatus: 200, wantBody: `"results":[{"user_id":"u2","score":20,"place":1},{"user_id":"u1","score":10,"place":2}]`,
+		},
+		{name: "player reports", payload: apiEvent("m1", results), caller: &auth.Identity{Subject: "u2"}, wantStatus: 403},
+		{name: "admin reports", payload: apiEvent("m1", results), caller: &auth.Identity{Subject: "a1", Groups: []string{"admin"}}, wantStatus: 200},
+		{name: "incomplete", payload: apiEvent("m1", `{"results":[{"user_id":"u1","score":10}]}`), caller: &auth.Identity{Subject: "u1"}, wantStatus: 422, wantBody: matches.CodeResultsInvalid},
+		{name: "unknown match", payload: apiEvent("m9", results), caller: &auth.Identity{Subject: "u1"}, wantStatus: 404},
+		{name: "direct", payload: json.RawMessage(`{"match_id":"m1","results":[{"user_id":"u1","score":1},{"user_id":"u2","score":1}]}`), wantStatus: 200, wantBody: `"place":1}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+				if id, ok := in.Key["match_id"].(*types.AttributeValueMemberS); !ok || id.Value != "m1" {
+					return &dynamodb.GetItemOutput{}, nil
+				}
+				return &dynamodb.GetItemOutput{Item: match(matches.InProgress)}, nil
+			}}
+			ratings := &rating.Store{HistoryTable: "history", Params: rating.Params{K: 16, ProvisionalK: 32}}
+			h := &Handler{Matches: &matches.Store{DB: m.Client(), Table: "matches", PlayerTable: "players", Ratings: ratings}, Config: &config.Config{}}
+
+			ctx := context.Background()
+			if tt.caller != nil {
+				ctx = auth.NewContext(ctx, tt.caller)
+			}
+			r, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", r.StatusCode, tt.wantStatus, r.Body)
+			}
+			if !strings.Contains(r.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", r.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		stored     db.Item
+		wantStatus string
+		wantErr    bool
+	}{
+		{name: "lobby starts", payload: `{"match_id":"m1","timeout":"join"}`, stored: match(matches.Waiting), wantStatus: matches.InProgress},
+		{name: "match abandoned", payload: `{"match_id":"m1","timeout":"play"}`, stored: match(matches.InProgress), wantStatus: matches.Abandoned},
+		{name: "finished match", payload: `{"match_id":"m1","timeout":"play"}`, stored: match(matches.Finished), wantStatus: matches.Finished},
+		{name: "unknown match", payload: `{"match_id":"m9","timeout":"join"}`},
+		{name: "unknown timeout", payload: `{"match_id":"m1","timeout":"soon"}`, stored: match(matches.Waiting), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.stored}, nil
+				},
+				UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					item := match(in.ExpressionAttributeValues[":to"].(*types.AttributeValueMemberS).Value)
+					return &dynamodb.UpdateItemOutput{Attributes: item}, nil
+				},
+			}
+			h := &Handler{Matches: &matches.Store{DB: m.Client(), Table: "matches"}, Config: &config.Config{}}
+
+			resp, err := h.Invoke(context.Background(), json.RawMessage(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := resp.(TimeoutResponse).Status; got != tt.wantStatus {
+				t.Errorf("status = %q, want %q", got, tt.wantStatus)
+			}
+		})
+	}
+}
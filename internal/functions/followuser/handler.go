@@ -0,0 +1,146 @@
+// Package followuser follows another user (PUT
+// /users/{user_id}/following/{other_id}). Following needs no consent, and
+// following a user twice is not an error. See package relationships.
+package followuser
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/ratelimit"     // DynamoDB token buckets
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+	"troggle-backend/internal/verification"  // email verification gate
+)
+
+// rateLimits keep accounts from mass-following. They can be tuned per stage
+// through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(120),
+	PerUser: ratelimit.PerMinute(60),
+}
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the same values as path parameters.
+type Request struct {
+	UserID  string `json:"user_id"`  // the follower
+	OtherID string `json:"other_id"` // the user followed
+}
+
+// authorize lets callers follow as themselves only, unless they are admins.
+// Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only follow users as yourself")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "followUser",
+	Function: "followUser",
+	Summary:  "Follows a user",
+	Method:   "PUT",
+	Path:     "/users/{user_id}/following/{other_id}",
+	Status:   204,
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Graph    *relationships.Store
+	Users    *users.Repository
+	Limiter  *ratelimit.Limiter
+	Limits   ratelimit.Policy
+	Auth     auth.TokenVerifier
+	Verified *verification.Guard // nil lets unverified accounts through
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Graph:    relationships.NewStore(client, cfg),
+		Users:    users.NewRepository(client, cfg),
+		Limiter:  ratelimit.New(client, cfg),
+		Limits:   limits,
+		Auth:     verifier,
+		Verified: verification.NewGuard(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies. Accounts
+// whose email address is not verified are refused.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits), h.Verified.Middleware())
+}
+
+// Handle follows the other user.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"], OtherID: r.PathParams["other_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	for _, id := range []string{req.UserID, req.OtherID} {
+		if err := validation.UserID(id); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	blocked, err := h.Graph.IsBlocked(ctx, req.UserID, req.OtherID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if blocked {
+		return httpx.Error(apperr.Forbidden("You cannot interact with this user")), nil
+	}
+
+	created, err := h.Graph.Follow(ctx, req.UserID, req.OtherID)
+	switch {
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	if created {
+		h.Users.Invalidate(req.UserID, "")
+		h.Users.Invalidate(req.OtherID, "")
+		slog.InfoContext(ctx, "User followed", "user_id", req.UserID, "other_id", req.OtherID)
+	}
+	return httpx.NoContent(), nil
+}
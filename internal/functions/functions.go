@@ -0,0 +1,174 @@
+// Package functions lists every function serving the API, with its routes
+// and the constructor of its handler. cmd/genopenapi documents the routes
+// and cmd/localserver serves them from this list, so neither can drift from
+// the other. A function added to the API is added to All.
+package functions
+
+import (
+	"context"
+	"slices"
+
+	"troggle-backend/internal/api"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/functions/acceptfriendrequest"
+	"troggle-backend/internal/functions/acceptinvitation"
+	"troggle-backend/internal/functions/acceptpolicies"
+	"troggle-backend/internal/functions/admitwaitlist"
+	"troggle-backend/internal/functions/blockuser"
+	"troggle-backend/internal/functions/changeuserstatus"
+	"troggle-backend/internal/functions/checkuserexists"
+	"troggle-backend/internal/functions/checkusernameavailable"
+	"troggle-backend/internal/functions/confirmemailchange"
+	"troggle-backend/internal/functions/confirmpasswordreset"
+	"troggle-backend/internal/functions/createinvitation"
+	"troggle-backend/internal/functions/creatematch"
+	"troggle-backend/internal/functions/createsession"
+	"troggle-backend/internal/functions/createuser"
+	"troggle-backend/internal/functions/deleteuser"
+	"troggle-backend/internal/functions/enqueueformatch"
+	"troggle-backend/internal/functions/exportuserdata"
+	"troggle-backend/internal/functions/finishmatch"
+	"troggle-backend/internal/functions/followuser"
+	"troggle-backend/internal/functions/getavataruploadurl"
+	"troggle-backend/internal/functions/getinvitationfunnel"
+	"troggle-backend/internal/functions/getleaderboard"
+	"troggle-backend/internal/functions/getonlinestatus"
+	"troggle-backend/internal/functions/getpreferences"
+	"troggle-backend/internal/functions/getuserbycognitosub"
+	"troggle-backend/internal/functions/getuserprofile"
+	"troggle-backend/internal/functions/getversion"
+	"troggle-backend/internal/functions/healthcheck"
+	"troggle-backend/internal/functions/joinmatch"
+	"troggle-backend/internal/functions/joinwaitlist"
+	"troggle-backend/internal/functions/linkprovider"
+	"troggle-backend/internal/functions/listachievements"
+	"troggle-backend/internal/functions/listblocks"
+	"troggle-backend/internal/functions/listconversations"
+	"troggle-backend/internal/functions/listfollowers"
+	"troggle-backend/internal/functions/listfriends"
+	"troggle-backend/internal/functions/listmessages"
+	"troggle-backend/internal/functions/listnotifications"
+	"troggle-backend/internal/functions/listsessions"
+	"troggle-backend/internal/functions/listusers"
+	"troggle-backend/internal/functions/manageapikeys"
+	"troggle-backend/internal/functions/managemfa"
+	"troggle-backend/internal/functions/manageroles"
+	"troggle-backend/internal/functions/markconversationread"
+	"troggle-backend/internal/functions/marknotificationsread"
+	"troggle-backend/internal/functions/mergeaccounts"
+	"troggle-backend/internal/functions/publishpolicy"
+	"troggle-backend/internal/functions/registerdevice"
+	"troggle-backend/internal/functions/removerelationship"
+	"troggle-backend/internal/functions/requestaccountdeletion"
+	"troggle-backend/internal/functions/requestemailchange"
+	"troggle-backend/internal/functions/requestpasswordreset"
+	"troggle-backend/internal/functions/resendverification"
+	"troggle-backend/internal/functions/restoreuser"
+	"troggle-backend/internal/functions/revokesession"
+	"troggle-backend/internal/functions/searchusers"
+	"troggle-backend/internal/functions/sendfriendrequest"
+	"troggle-backend/internal/functions/sendmessage"
+	"troggle-backend/internal/functions/submitscore"
+	"troggle-backend/internal/functions/unlinkprovider"
+	"troggle-backend/internal/functions/unregisterdevice"
+	"troggle-backend/internal/functions/updatepreferences"
+	"troggle-backend/internal/functions/updateuserprofile"
+	"troggle-backend/internal/functions/validatesession"
+	"troggle-backend/internal/httpx"
+)
+
+// Handler is the handler of a function.
+type Handler interface {
+	HTTP() httpx.Handler // the handler wrapped in its middleware
+}
+
+// Function is a function serving the API.
+type Function struct {
+	Routes []api.Route
+	New    func(ctx context.Context, cfg *config.Config) (Handler, error)
+}
+
+// function returns the function of routes built by build, the New of its
+// package.
+func function[H Handler](routes []api.Route, build func(context.Context, *config.Config) (H, error)) Function {
+	return Function{Routes: routes, New: func(ctx context.Context, cfg *config.Config) (Handler, error) {
+		return build(ctx, cfg)
+	}}
+}
+
+// All are the functions serving the API.
+var All = []Function{
+	function(acceptfriendrequest.Routes, acceptfriendrequest.New),
+	function(acceptinvitation.Routes, acceptinvitation.New),
+	function(acceptpolicies.Routes, acceptpolicies.New),
+	function(admitwaitlist.Routes, admitwaitlist.New),
+	function(blockuser.Routes, blockuser.New),
+	function(changeuserstatus.Routes, changeuserstatus.New),
+	function(checkuserexists.Routes, checkuserexists.New),
+	function(checkusernameavailable.Routes, checkusernameavailable.New),
+	function(confirmemailchange.Routes, confirmemailchange.New),
+	function(confirmpasswordreset.Routes, confirmpasswordreset.New),
+	function(createinvitation.Routes, createinvitation.New),
+	function(creatematch.Routes, creatematch.New),
+	function(createsession.Routes, createsession.New),
+	function(createuser.Routes, createuser.New),
+	function(deleteuser.Routes, deleteuser.New),
+	function(enqueueformatch.Routes, enqueueformatch.New),
+	function(exportuserdata.Routes, exportuserdata.New),
+	function(finishmatch.Routes, finishmatch.New),
+	function(followuser.Routes, followuser.New),
+	function(getavataruploadurl.Routes, getavataruploadurl.New),
+	function(getinvitationfunnel.Routes, getinvitationfunnel.New),
+	function(getleaderboard.Routes, getleaderboard.New),
+	function(getonlinestatus.Routes, getonlinestatus.New),
+	function(getpreferences.Routes, getpreferences.New),
+	function(getuserbycognitosub.Routes, getuserbycognitosub.New),
+	function(getuserprofile.Routes, getuserprofile.New),
+	function(getversion.Routes, getversion.New),
+	function(healthcheck.Routes, healthcheck.New),
+	function(joinmatch.Routes, joinmatch.New),
+	function(joinwaitlist.Routes, joinwaitlist.New),
+	function(linkprovider.Routes, linkprovider.New),
+	function(listachievements.Routes, listachievements.New),
+	function(listblocks.Routes, listblocks.New),
+	function(listconversations.Routes, listconversations.New),
+	function(listfollowers.Routes, listfollowers.New),
+	function(listfriends.Routes, listfriends.New),
+	function(listmessages.Routes, listmessages.New),
+	function(listnotifications.Routes, listnotifications.New),
+	function(listsessions.Routes, listsessions.New),
+	function(listusers.Routes, listusers.New),
+	function(manageapikeys.Routes, manageapikeys.New),
+	function(managemfa.Routes, managemfa.New),
+	function(manageroles.Routes, manageroles.New),
+	function(markconversationread.Routes, markconversationread.New),
+	function(marknotificationsread.Routes, marknotificationsread.New),
+	function(mergeaccounts.Routes, mergeaccounts.New),
+	function(publishpolicy.Routes, publishpolicy.New),
+	function(registerdevice.Routes, registerdevice.New),
+	function(removerelationship.Routes, removerelationship.New),
+	function(requestaccountdeletion.Routes, requestaccountdeletion.New),
+	function(requestemailchange.Routes, requestemailchange.New),
+	function(requestpasswordreset.Routes, requestpasswordreset.New),
+	function(resendverification.Routes, resendverification.New),
+	function(restoreuser.Routes, restoreuser.New),
+	function(revokesession.Routes, revokesession.New),
+	function(searchusers.Routes, searchusers.New),
+	function(sendfriendrequest.Routes, sendfriendrequest.New),
+	function(sendmessage.Routes, sendmessage.New),
+	function(submitscore.Routes, submitscore.New),
+	function(unlinkprovider.Routes, unlinkprovider.New),
+	function(unregisterdevice.Routes, unregisterdevice.New),
+	function(updatepreferences.Routes, updatepreferences.New),
+	function(updateuserprofile.Routes, updateuserprofile.New),
+	function(validatesession.Routes, validatesession.New),
+}
+
+// Routes returns the routes of every function of All.
+func Routes() []api.Route {
+	var routes [][]api.Route
+	for _, f := range All {
+		routes = append(routes, f.Routes)
+	}
+	return slices.Concat(routes...)
+}
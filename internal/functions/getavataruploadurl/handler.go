@@ -0,0 +1,124 @@
+// Package getavataruploadurl hands out presigned S3 upload URLs for profile
+// pictures (POST /users/{user_id}/avatar/upload-url). The upload itself goes
+// straight to S3; processAvatar picks it up from there. See package avatars.
+package getavataruploadurl
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3" // S3 client
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/avatars"    // avatar storage
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON body: the image the client is about to
+// upload. Direct invocations also name the user.
+type Request struct {
+	UserID      string `json:"user_id" api:"direct"` // direct invocations only
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"` // in bytes
+}
+
+// authorize lets callers upload their own avatar only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only change your own avatar")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "getAvatarUploadUrl",
+	Function: "getAvatarUploadUrl",
+	Summary:  "Returns a presigned upload of a new avatar",
+	Method:   "POST",
+	Path:     "/users/{user_id}/avatar/upload-url",
+	Request:  Request{},
+	Response: avatars.Upload{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Avatars *avatars.Store
+	Auth    auth.TokenVerifier
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// avatar bucket.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireAvatars(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Avatars: avatars.NewStore(s3.NewFromConfig(awsCfg), client, cfg),
+		Auth:    verifier,
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle answers with a presigned upload of the declared image.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.UserID = r.PathParams["user_id"]
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	upload, err := h.Avatars.PresignUpload(ctx, req.UserID, req.ContentType, req.Size)
+	if apperr.KindOf(err) == apperr.KindInvalid {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Avatar upload URL issued", "user_id", req.UserID, "upload_id", upload.UploadID, "content_type", req.ContentType, "size", req.Size)
+	return httpx.JSON(200, upload), nil
+}
@@ -0,0 +1,106 @@
+package getavataruploadurl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/avatars"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/httpx"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakePresign presigns any upload.
+type fakePresign struct{}
+
+func (fakePresign) PresignPutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return &v4.PresignedHTTPRequest{
+		URL:          "https://bucket.example/" + aws.ToString(in.Key) + "?sig",
+		Method:       http.MethodPut,
+		SignedHeader: http.Header{"Content-Type": {aws.ToString(in.ContentType)}},
+	}, nil
+}
+
+// apiEvent is a POST /users/{user_id}/avatar/upload-url REST API event.
+func apiEvent(userID, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/avatar/upload-url",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "own avatar",
+			payload:    apiEvent("u1", `{"content_type":"image/jpeg","size":2048}`),
+			wantStatus: 200, wantBody: `"upload_url":"https://bucket.example/uploads/u1/`,
+		},
+		{
+			name:       "another user",
+			payload:    apiEvent("u2", `{"content_type":"image/jpeg","size":2048}`),
+			wantStatus: 403,
+		},
+		{
+			name:       "unsupported type",
+			payload:    apiEvent("u1", `{"content_type":"image/svg+xml","size":2048}`),
+			wantStatus: 422, wantBody: `"field":"content_type"`,
+		},
+		{
+			name:       "too large",
+			payload:    apiEvent("u1", `{"content_type":"image/png","size":10485760}`),
+			wantStatus: 422, wantBody: `"field":"size"`,
+		},
+		{
+			name:       "direct",
+			payload:    json.RawMessage(`{"user_id":"u3","content_type":"image/gif","size":10}`),
+			wantStatus: 200, wantBody: `"Content-Type":"image/gif"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{
+				Avatars: &avatars.Store{Presign: fakePresign{}, Bucket: "bucket", MaxBytes: 5 << 20},
+				Auth:    stubVerifier{&auth.Identity{Subject: "u1"}},
+				Config:  &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
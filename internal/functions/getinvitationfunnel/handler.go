@@ -0,0 +1,147 @@
+// Package getinvitationfunnel reports, for admins, how invitations convert
+// (GET /invitations/funnel): the invitations sent and accepted on each of
+// the last days, from the daily counters of package invitations, and their
+// totals. Counters are approximate, and an invitation accepted on another
+// day than it was sent counts towards both days, so daily conversion rates
+// are indicative only.
+package getinvitationfunnel
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apikeys"     // API keys of server-to-server callers
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/authz"       // role-based access control
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/invitations" // invitations and referrals
+	"troggle-backend/internal/sessions"    // session table access
+)
+
+const (
+	// defaultDays and maxDays bound the days reported.
+	defaultDays = 30
+	maxDays     = 90
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass days as a query string parameter.
+type Request struct {
+	Days string `json:"days"`
+}
+
+// Response represents the JSON output.
+type Response struct {
+	From           string            `json:"from"` // YYYY-MM-DD, UTC
+	To             string            `json:"to"`   // today
+	Sent           int64             `json:"sent"`
+	Accepted       int64             `json:"accepted"`
+	ConversionRate float64           `json:"conversion_rate"` // accepted / sent, 0 when none were sent
+	Days           []invitations.Day `json:"days"`            // oldest first
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "getInvitationFunnel",
+	Function: "getInvitationFunnel",
+	Summary:  "Reports the invitations sent and accepted on each of the last days",
+	Method:   "GET",
+	Path:     "/invitations/funnel",
+	Query:    []string{"days"},
+	Response: Response{},
+	Scopes:   []string{"troggle/admin", "invitations:read"},
+	Groups:   []string{"admin"},
+	APIKey:   true,
+}}
+
+// authorize lets callers holding the invitations:read permission only read
+// the funnel. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request) error {
+	if r.Direct {
+		return nil
+	}
+	return authz.Require(ctx, authz.InvitationsRead)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Invitations *invitations.Store
+	Auth        auth.TokenVerifier
+	APIKey      *apikeys.Middleware // nil accepts bearer tokens only
+	Config      *config.Config
+	Now         func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Invitations: invitations.NewStore(client, cfg),
+		Auth:        verifier,
+		APIKey:      apikeys.NewMiddleware(client, cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth))
+}
+
+// Handle returns the funnel of the last days, today included.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{Days: r.Query("days")}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	if err := authorize(ctx, r); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	days := defaultDays
+	if req.Days != "" {
+		n, err := strconv.Atoi(req.Days)
+		if err != nil || n < 1 || n > maxDays {
+			return httpx.Error(apperr.Invalid("INVALID_DAYS", "days", fmt.Sprintf("days must be between 1 and %d", maxDays))), nil
+		}
+		days = n
+	}
+
+	to := h.now().UTC()
+	from := to.AddDate(0, 0, 1-days)
+	funnel, err := h.Invitations.Funnel(ctx, from, to)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	resp := Response{From: from.Format(time.DateOnly), To: to.Format(time.DateOnly), Days: funnel}
+	for _, d := range funnel {
+		resp.Sent += d.Sent
+		resp.Accepted += d.Accepted
+	}
+	if resp.Sent > 0 {
+		resp.ConversionRate = float64(resp.Accepted) / float64(resp.Sent)
+	}
+	return httpx.JSON(200, resp), nil
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,97 @@
+package getinvitationfunnel
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/invitations"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a GET /invitations/funnel REST API event.
+func apiEvent(days string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "GET",
+		"path":                  "/invitations/funnel",
+		"headers":               map[string]string{"Authorization": "Bearer valid"},
+		"queryStringParameters": map[string]string{"days": days},
+	})
+	return event
+}
+
+// counter returns the item of the counter name holding value.
+func counter(name, value string) db.Item {
+	return db.Item{"counter": &types.AttributeValueMemberS{Value: name}, "value": &types.AttributeValueMemberN{Value: value}}
+}
+
+func TestHandle(t *testing.T) {
+	admin := &auth.Identity{Subject: "a1", Groups: []string{authz.Admin}}
+	tests := []struct {
+		name       string
+		caller     *auth.Identity
+		days       string
+		wantStatus int
+		want       Response
+	}{
+		{name: "funnel", caller: admin, days: "3", wantStatus: 200, want: Response{From: "2026-10-12", To: "2026-10-14", Sent: 5, Accepted: 2, ConversionRate: 0.4}},
+		{name: "too many days", caller: admin, days: "365", wantStatus: 422},
+		{name: "user", caller: &auth.Identity{Subject: "u1"}, days: "3", wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{BatchGetItemFunc: func(*dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+				return &dynamodb.BatchGetItemOutput{Responses: map[string][]db.Item{"counters": {
+					counter("invitations#sent#2026-10-12", "4"),
+					counter("invitations#sent#2026-10-14", "1"),
+					counter("invitations#accepted#2026-10-13", "2"),
+				}}}, nil
+			}}
+			cfg := &config.Config{UserDataTableName: "user-data", UserTableName: "users", CounterTableName: "counters"}
+			h := &Handler{
+				Invitations: invitations.NewStore(m.Client(), cfg),
+				Auth:        stubVerifier{tt.caller},
+				Config:      cfg,
+				Now:         func() time.Time { return time.Date(2026, 10, 14, 18, 0, 0, 0, time.UTC) },
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.days))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+			var got Response
+			if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.From != tt.want.From || got.To != tt.want.To || got.Sent != tt.want.Sent || got.Accepted != tt.want.Accepted ||
+				got.ConversionRate != tt.want.ConversionRate || len(got.Days) != 3 {
+				t.Errorf("funnel = %+v, want %+v over 3 days", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+// Package getleaderboard returns the top of a leaderboard (GET
+// /leaderboards/{board}?period=global|weekly&scope=all|friends), with the
+// caller's own rank. period=weekly&week=2026-W41 returns the final
+// standings of a past week. Entries past the first page are read with the
+// next_token of the previous one, down to maxDepth. Any signed-in user may
+// ask. See package leaderboard.
+package getleaderboard
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/leaderboard"   // scores and ranks
+	"troggle-backend/internal/pagination"    // signed next tokens
+	"troggle-backend/internal/ratelimit"     // DynamoDB token buckets
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// Scopes of a board.
+const (
+	ScopeAll     = "all"     // everyone with a score
+	ScopeFriends = "friends" // the user and their friends, ranked among themselves
+)
+
+const (
+	// defaultLimit and maxLimit bound the number of entries returned.
+	defaultLimit = 25
+	maxLimit     = 100
+
+	// maxFriends bounds the friends read for a friends board; friends past
+	// it, in user ID order, are left out.
+	maxFriends = 500
+
+	// friendPage is the page size of the friend listing.
+	friendPage = 100
+
+	// maxDepth bounds the entries paged through: every page reads the
+	// board from the top, which gets slower the deeper it is.
+	maxDepth = 1000
+)
+
+// rateLimits keep clients from polling ranks, the most expensive read of a
+// board. They can be tuned per stage through RATE_LIMIT_PER_IP and
+// RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(120),
+	PerUser: ratelimit.PerMinute(60),
+}
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the board in the path and the rest as query string
+// parameters; their user is the caller.
+type Request struct {
+	Board     string `json:"board"`
+	Period    string `json:"period"` // leaderboard.Global (default) or leaderboard.Weekly
+	Week      string `json:"week"`   // a past week of the weekly period; empty means the current one
+	Scope     string `json:"scope"`  // ScopeAll (default) or ScopeFriends
+	Limit     string `json:"limit"`
+	NextToken string `json:"next_token"`
+	UserID    string `json:"user_id"` // whose rank to return, and whose friends; optional for ScopeAll
+}
+
+// Response represents the JSON output
+type Response struct {
+	Board     string              `json:"board"`
+	Period    string              `json:"period"`
+	Week      string              `json:"week,omitempty"` // of the weekly period
+	Scope     string              `json:"scope"`
+	Closed    bool                `json:"closed"` // final standings of a past week
+	Entries   []leaderboard.Entry `json:"entries"`
+	Me        *leaderboard.Entry  `json:"me,omitempty"`         // absent when the user has no score, or is not in the final standings
+	NextToken string              `json:"next_token,omitempty"` // absent on the last page
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "getLeaderboard",
+	Function: "getLeaderboard",
+	Summary:  "Returns the standings of a leaderboard",
+	Method:   "GET",
+	Path:     "/leaderboards/{board}",
+	Query:    []string{"period", "week", "scope", "limit", "next_token"},
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Leaderboards *leaderboard.Store
+	Graph        *relationships.Store
+	Limiter      *ratelimit.Limiter
+	Limits       ratelimit.Policy
+	Auth         auth.TokenVerifier
+	Cursors      *pagination.Codec
+	Config       *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cursors, err := pagination.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Leaderboards: leaderboard.NewStore(client, cfg),
+		Graph:        relationships.NewStore(client, cfg),
+		Limiter:      ratelimit.New(client, cfg),
+		Limits:       limits,
+		Auth:         verifier,
+		Cursors:      cursors,
+		Config:       cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle returns the entries of the board asked for.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{
+		Board:     r.PathParams["board"],
+		Period:    r.Query("period"),
+		Week:      r.Query("week"),
+		Scope:     r.Query("scope"),
+		Limit:     r.Query("limit"),
+		NextToken: r.Query("next_token"),
+	}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	} else {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req.UserID = id.Subject
+	}
+	if req.Period == "" {
+		req.Period = leaderboard.Global
+	}
+	if req.Scope == "" {
+		req.Scope = ScopeAll
+	}
+
+	now := time.Now()
+	closed, err := checkPeriod(&req, now)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+	switch {
+	case req.Scope != ScopeAll && req.Scope != ScopeFriends:
+		return httpx.Error(apperr.Invalid("INVALID_SCOPE", "scope", "scope must be all or friends")), nil
+	case req.Scope == ScopeFriends && closed:
+		return httpx.Error(apperr.Invalid("INVALID_SCOPE", "scope", "friends boards are only kept for the current week")), nil
+	}
+	if req.UserID != "" || req.Scope == ScopeFriends {
+		if err := validation.UserID(req.UserID); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+	limit := defaultLimit
+	if req.Limit != "" {
+		n, err := strconv.Atoi(req.Limit)
+		if err != nil || n < 1 || n > maxLimit {
+			return httpx.Error(apperr.Invalid("INVALID_LIMIT", "limit", fmt.Sprintf("limit must be between 1 and %d", maxLimit))), nil
+		}
+		limit = n
+	}
+	if err := h.Leaderboards.Check(req.Board); err != nil {
+		return httpx.Error(err), nil
+	}
+	var offset int
+	if req.NextToken != "" {
+		cur, err := h.Cursors.Decode(ctx, req.NextToken, filtersOf(req))
+		if err != nil {
+			return httpx.Error(err), nil
+		}
+		offset = cur.Offset
+	}
+
+	resp := Response{Board: req.Board, Period: req.Period, Week: req.Week, Scope: req.Scope, Closed: closed}
+	var more bool
+	switch {
+	case closed:
+		standings, err := h.Leaderboards.Standings(ctx, req.Board, req.Week)
+		if apperr.KindOf(err) == apperr.KindNotFound {
+			return httpx.Error(err), nil
+		}
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		resp.Entries, resp.Me, more = pageOf(standings, offset, limit, req.UserID)
+	case req.Scope == ScopeFriends:
+		ids, err := h.friends(ctx, req.UserID)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		entries, err := h.Leaderboards.Among(ctx, h.board(req), append(ids, req.UserID))
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		resp.Entries, resp.Me, more = pageOf(entries, offset, limit, req.UserID)
+	default:
+		// One entry past the page tells whether there is another
+		top, err := h.Leaderboards.Top(ctx, h.board(req), offset+limit+1)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		resp.Entries, _, more = pageOf(top, offset, limit, "")
+		if req.UserID != "" {
+			if resp.Me, err = h.Leaderboards.Rank(ctx, h.board(req), req.UserID); err != nil {
+				return httpx.Response{}, err
+			}
+		}
+	}
+	if more && offset+limit < maxDepth {
+		if resp.NextToken, err = h.Cursors.Encode(ctx, pagination.Cursor{Offset: offset + limit}, filtersOf(req)); err != nil {
+			return httpx.Response{}, err
+		}
+	}
+	return httpx.JSON(200, resp), nil
+}
+
+// filtersOf returns the parameters a next token of req is bound to. The
+// user is among them as the friends board depends on them.
+func filtersOf(req Request) pagination.Filters {
+	return pagination.Filters{"board": req.Board, "period": req.Period, "week": req.Week, "scope": req.Scope, "user_id": req.UserID}
+}
+
+// checkPeriod validates the period and week of req, filling in the current
+// week of a weekly board, and reports whether the week asked for is over.
+func checkPeriod(req *Request, now time.Time) (closed bool, err error) {
+	invalidWeek := apperr.Invalid("INVALID_WEEK", "week", "week must be a past or the current ISO week, e.g. 2026-W41")
+	switch req.Period {
+	case leaderboard.Global:
+		if req.Week != "" {
+			return false, apperr.Invalid("INVALID_WEEK", "week", "week is only valid for the weekly period")
+		}
+		return false, nil
+	case leaderboard.Weekly:
+		current := leaderboard.Week(now)
+		if req.Week == "" || req.Week == current {
+			req.Week = current
+			return false, nil
+		}
+		start, err := leaderboard.WeekStart(req.Week)
+		if err != nil || start.After(now) {
+			return false, invalidWeek
+		}
+		return true, nil
+	}
+	return false, apperr.Invalid("INVALID_PERIOD", "period", "period must be global or weekly")
+}
+
+// board returns the partition of the live board asked for.
+func (h *Handler) board(req Request) string {
+	if req.Period == leaderboard.Weekly {
+		return leaderboard.Board(req.Board, req.Week)
+	}
+	return leaderboard.Board(req.Board, leaderboard.Global)
+}
+
+// friends returns the IDs of up to maxFriends friends of userID.
+func (h *Handler) friends(ctx context.Context, userID string) ([]string, error) {
+	var ids []string
+	var start db.Item
+	for {
+		edges, next, err := h.Graph.List(ctx, userID, relationships.Friend, friendPage, start)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range edges {
+			if len(ids) == maxFriends {
+				return ids, nil
+			}
+			ids = append(ids, e.OtherID)
+		}
+		if next == nil {
+			return ids, nil
+		}
+		start = next
+	}
+}
+
+// pageOf returns the limit ranked entries after the first offset, the entry
+// of userID among them all, and whether entries follow the page.
+func pageOf(entries []leaderboard.Entry, offset, limit int, userID string) ([]leaderboard.Entry, *leaderboard.Entry, bool) {
+	var me *leaderboard.Entry
+	for i := range entries {
+		if userID != "" && entries[i].UserID == userID {
+			e := entries[i]
+			me = &e
+			break
+		}
+	}
+	more := len(entries) > offset+limit
+	entries = entries[min(offset, len(entries)):min(offset+limit, len(entries))]
+	return entries, me, more
+}
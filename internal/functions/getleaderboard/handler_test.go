@@ -0,0 +1,163 @@
+package getleaderboard
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/leaderboard"
+	"troggle-backend/internal/pagination"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/signing"
+)
+
+// apiEvent is a GET /leaderboards/{board} REST API event.
+func apiEvent(board string, query map[string]string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "GET",
+		"path":                  "/leaderboards/" + board,
+		"pathParameters":        map[string]string{"board": board},
+		"queryStringParameters": query,
+	})
+	return event
+}
+
+// score returns the item of a user's best score.
+func score(userID, n string) db.Item {
+	item := dbtest.Item("user_id", userID, "achieved_at", "2026-10-01T00:00:00Z")
+	item["score"] = &types.AttributeValueMemberN{Value: n}
+	return item
+}
+
+func TestHandle(t *testing.T) {
+	now := time.Now()
+	lastWeek := leaderboard.Week(now.AddDate(0, 0, -7))
+	nextWeek := leaderboard.Week(now.AddDate(0, 0, 7))
+	standings, _ := attributevalue.MarshalMap(map[string]any{
+		"standings": []leaderboard.Entry{{Rank: 1, UserID: "u4", Score: 50}, {Rank: 2, UserID: "u1", Score: 40}},
+	})
+	scores := map[string]string{"u1": "40", "u2": "70", "u4": "90"}
+	cursors := pagination.NewCodec(signing.StaticKey("test"))
+	second, err := cursors.Encode(context.Background(), pagination.Cursor{Offset: 1}, filtersOf(Request{Board: "main", Period: leaderboard.Global, Scope: ScopeAll, UserID: "u1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		payload     json.RawMessage
+		wantStatus  int
+		wantEntries []string
+		wantMe      int // rank; 0 means no entry
+		wantNext    bool
+	}{
+		{name: "all time", payload: apiEvent("main", nil), wantStatus: 200, wantEntries: []string{"u4", "u2"}, wantMe: 4},
+		{name: "friends this week", payload: apiEvent("main", map[string]string{"period": "weekly", "scope": "friends"}), wantStatus: 200, wantEntries: []string{"u2", "u1"}, wantMe: 2},
+		{name: "last week", payload: apiEvent("main", map[string]string{"period": "weekly", "week": lastWeek, "limit": "1"}), wantStatus: 200, wantEntries: []string{"u4"}, wantMe: 2, wantNext: true},
+		{name: "first page", payload: apiEvent("main", map[string]string{"limit": "1"}), wantStatus: 200, wantEntries: []string{"u4"}, wantMe: 4, wantNext: true},
+		{name: "second page", payload: apiEvent("main", map[string]string{"limit": "1", "next_token": second}), wantStatus: 200, wantEntries: []string{"u2"}, wantMe: 4},
+		{name: "token of another board", payload: apiEvent("speed", map[string]string{"next_token": second}), wantStatus: 422},
+		{name: "last week not closed", payload: apiEvent("speed", map[string]string{"period": "weekly", "week": lastWeek}), wantStatus: 404},
+		{name: "next week", payload: apiEvent("main", map[string]string{"period": "weekly", "week": nextWeek}), wantStatus: 422},
+		{name: "week of all time", payload: apiEvent("main", map[string]string{"week": lastWeek}), wantStatus: 422},
+		{name: "friends last week", payload: apiEvent("main", map[string]string{"period": "weekly", "week": lastWeek, "scope": "friends"}), wantStatus: 422},
+		{name: "unknown period", payload: apiEvent("main", map[string]string{"period": "daily"}), wantStatus: 422},
+		{name: "unknown scope", payload: apiEvent("main", map[string]string{"scope": "mine"}), wantStatus: 422},
+		{name: "unknown board", payload: apiEvent("other", nil), wantStatus: 404},
+		{name: "direct without user", payload: json.RawMessage(`{"board":"main"}`), wantStatus: 200, wantEntries: []string{"u4", "u2"}},
+		{name: "direct friends without user", payload: json.RawMessage(`{"board":"main","scope":"friends"}`), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					switch {
+					case aws.ToString(in.TableName) == "relationships":
+						return &dynamodb.QueryOutput{Items: []db.Item{
+							dbtest.Item("user_id", "u1", "edge", relationships.Friend+"#u2", "created_at", "2026-01-01T00:00:00Z"),
+							dbtest.Item("user_id", "u1", "edge", relationships.Friend+"#u3", "created_at", "2026-01-01T00:00:00Z"),
+						}}, nil
+					case in.ExpressionAttributeValues[":shard"].(*types.AttributeValueMemberS).Value != "main#global#0":
+						return &dynamodb.QueryOutput{}, nil
+					case in.Select == types.SelectCount:
+						return &dynamodb.QueryOutput{Count: 3}, nil
+					}
+					// Everyone is on the first shard
+					return &dynamodb.QueryOutput{Items: []db.Item{score("u4", "90"), score("u2", "70")}}, nil
+				},
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					board := in.Key["board"].(*types.AttributeValueMemberS).Value
+					userID := in.Key["user_id"].(*types.AttributeValueMemberS).Value
+					if board == "main#"+lastWeek {
+						return &dynamodb.GetItemOutput{Item: standings}, nil
+					}
+					if n, ok := scores[userID]; ok && board != "speed#"+lastWeek {
+						return &dynamodb.GetItemOutput{Item: score(userID, n)}, nil
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+				BatchGetItemFunc: func(in *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+					var items []db.Item
+					for _, key := range in.RequestItems["leaderboard"].Keys {
+						userID := key["user_id"].(*types.AttributeValueMemberS).Value
+						if n, ok := scores[userID]; ok {
+							items = append(items, score(userID, n))
+						}
+					}
+					return &dynamodb.BatchGetItemOutput{Responses: map[string][]db.Item{"leaderboard": items}}, nil
+				},
+			}
+			h := &Handler{
+				Leaderboards: &leaderboard.Store{DB: m.Client(), Table: "leaderboard", ScoreIndex: "score-index", Boards: []string{"main", "speed"}, Shards: 2},
+				Graph:        &relationships.Store{DB: m.Client(), Table: "relationships", UserTable: "users"},
+				Cursors:      cursors,
+				Config:       &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+
+			var got Response
+			if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, e := range got.Entries {
+				ids = append(ids, e.UserID)
+			}
+			if !slices.Equal(ids, tt.wantEntries) {
+				t.Errorf("entries = %v, want %v", ids, tt.wantEntries)
+			}
+			if (got.NextToken != "") != tt.wantNext {
+				t.Errorf("next_token = %q, want one: %v", got.NextToken, tt.wantNext)
+			}
+			switch {
+			case tt.wantMe == 0 && got.Me != nil:
+				t.Errorf("me = %+v, want none", got.Me)
+			case tt.wantMe != 0 && (got.Me == nil || got.Me.Rank != tt.wantMe):
+				t.Errorf("me = %+v, want rank %d", got.Me, tt.wantMe)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Package getonlinestatus reports which of a list of users are online (GET
+// /presence?user_ids=a,b,c): connected to the WebSocket API with a recent
+// heartbeat. Any signed-in user may ask. See package presence.
+package getonlinestatus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/presence"   // WebSocket connections
+	"troggle-backend/internal/ratelimit"  // DynamoDB token buckets
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// maxUsers is the largest list accepted in one request, a screen of
+// friends.
+const maxUsers = 50
+
+// rateLimits keep clients from polling too eagerly; they should poll at
+// most every few seconds. They can be tuned per stage through
+// RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(120),
+	PerUser: ratelimit.PerMinute(60),
+}
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the users as a comma-separated user_ids query string
+// parameter.
+type Request struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// Status is whether one user is online.
+type Status struct {
+	UserID string `json:"user_id"`
+	Online bool   `json:"online"`
+}
+
+// Response represents the JSON output
+type Response struct {
+	Users []Status `json:"users"` // in the order asked
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "getOnlineStatus",
+	Function: "getOnlineStatus",
+	Summary:  "Reports which of a list of users are online",
+	Method:   "GET",
+	Path:     "/presence",
+	Query:    []string{"user_ids"},
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Presence *presence.Store
+	Limiter  *ratelimit.Limiter
+	Limits   ratelimit.Policy
+	Auth     auth.TokenVerifier
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Presence: presence.NewStore(client, cfg),
+		Limiter:  ratelimit.New(client, cfg),
+		Limits:   limits,
+		Auth:     verifier,
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle returns the status of each distinct user asked about.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	} else if v := r.Query("user_ids"); v != "" {
+		req.UserIDs = strings.Split(v, ",")
+	}
+
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range req.UserIDs {
+		id = strings.TrimSpace(id)
+		if err := validation.UserID(id); err != nil {
+			return httpx.Error(err), nil
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	switch {
+	case len(ids) == 0:
+		return httpx.Error(apperr.Invalid("USER_IDS_REQUIRED", "user_ids", "user_ids is required")), nil
+	case len(ids) > maxUsers:
+		return httpx.Error(apperr.Invalid("TOO_MANY_USERS", "user_ids",
+			fmt.Sprintf("at most %d users may be checked at once", maxUsers))), nil
+	}
+
+	online, err := h.Presence.Online(ctx, ids)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	resp := Response{Users: make([]Status, 0, len(ids))}
+	for _, id := range ids {
+		resp.Users = append(resp.Users, Status{UserID: id, Online: online[id]})
+	}
+	return httpx.JSON(200, resp), nil
+}
@@ -0,0 +1,88 @@
+package getonlinestatus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/presence"
+)
+
+// apiEvent is a GET /presence REST API event.
+func apiEvent(userIDs string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "GET",
+		"path":                  "/presence",
+		"queryStringParameters": map[string]string{"user_ids": userIDs},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	many := make([]string, maxUsers+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("u%d", i)
+	}
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "in order asked, once each",
+			payload:    apiEvent("u2, u1,u2"),
+			wantStatus: 200,
+			wantBody:   `{"users":[{"user_id":"u2","online":false},{"user_id":"u1","online":true}]}`,
+		},
+		{
+			name:       "direct",
+			payload:    json.RawMessage(`{"user_ids":["u1"]}`),
+			wantStatus: 200,
+			wantBody:   `{"users":[{"user_id":"u1","online":true}]}`,
+		},
+		{name: "none", payload: apiEvent(""), wantStatus: 422, wantBody: "USER_IDS_REQUIRED"},
+		{name: "too many", payload: apiEvent(strings.Join(many, ",")), wantStatus: 422, wantBody: "TOO_MANY_USERS"},
+		{name: "invalid", payload: apiEvent("u1,"), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expires := fmt.Sprint(time.Now().Add(time.Minute).Unix())
+			m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				if in.ExpressionAttributeValues[":user_id"].(*types.AttributeValueMemberS).Value != "u1" {
+					return &dynamodb.QueryOutput{}, nil
+				}
+				item := dbtest.Item("connection_id", "c1", "user_id", "u1")
+				item["expires_at"] = &types.AttributeValueMemberN{Value: expires}
+				return &dynamodb.QueryOutput{Items: []db.Item{item}}, nil
+			}}
+			h := &Handler{
+				Presence: &presence.Store{DB: m.Client(), Table: "connections", UserIndex: "user-index"},
+				Config:   &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u9"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Package getpreferences returns the notification and privacy settings of a
+// user, with defaults filled in for anything they never set.
+package getpreferences
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/authz"       // role-based access control
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/policies"    // terms and privacy policy acceptance
+	"troggle-backend/internal/preferences" // preference table access
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the user_id as a path parameter.
+type Request struct {
+	UserID string `json:"user_id"`
+}
+
+// authorize lets callers read their own preferences only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only read your own preferences")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "getPreferences",
+	Function: "getPreferences",
+	Summary:  "Returns the preferences of a user, defaults included",
+	Method:   "GET",
+	Path:     "/users/{user_id}/preferences",
+	Response: preferences.Preferences{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Preferences *preferences.Store
+	Auth        auth.TokenVerifier
+	Policies    *policies.Guard // nil leaves pending policies unflagged
+	Config      *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Preferences: preferences.NewStore(client, cfg),
+		Auth:        verifier,
+		Policies:    policies.NewGuard(client, cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. Clients read
+// the preferences at start-up, so its responses flag the policies the
+// caller must accept.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Policies.Middleware())
+}
+
+// Handle returns the preferences of the user named by the user_id path
+// parameter (or the user_id field of a direct invocation). Users with no
+// stored preferences get the defaults.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"]}
+
+	// Direct invocations carry the user_id in the body
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	slog.InfoContext(ctx, "Fetching preferences", "user_id", req.UserID, "table", h.Preferences.Table)
+	stored, _, err := h.Preferences.Get(ctx, req.UserID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSON(200, preferences.Resolve(stored)), nil
+}
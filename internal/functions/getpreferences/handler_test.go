@@ -0,0 +1,80 @@
+package getpreferences
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/preferences"
+)
+
+func TestHandle(t *testing.T) {
+	private := func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		item, err := attributevalue.MarshalMap(map[string]any{
+			"user_id":     "u1",
+			"preferences": preferences.Preferences{preferences.Privacy: {"profile_visibility": "private"}},
+			"version":     1,
+		})
+		return &dynamodb.GetItemOutput{Item: item}, err
+	}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		get        func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		wantStatus int
+		wantBody   []string
+	}{
+		{
+			name:       "nothing stored",
+			payload:    json.RawMessage(`{"user_id":"u1"}`),
+			wantStatus: 200,
+			wantBody:   []string{`"profile_visibility":"public"`, `"digest_frequency":"weekly"`},
+		},
+		{
+			name:       "stored settings over defaults",
+			payload:    json.RawMessage(`{"user_id":"u1"}`),
+			get:        private,
+			wantStatus: 200,
+			wantBody:   []string{`"profile_visibility":"private"`, `"push_enabled":true`},
+		},
+		{
+			name:       "throttled",
+			payload:    json.RawMessage(`{"user_id":"u1"}`),
+			get:        func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) { return nil, dbtest.Throttled() },
+			wantStatus: 429,
+		},
+		{
+			name:       "missing id",
+			payload:    json.RawMessage(`{}`),
+			wantStatus: 422,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: tt.get}
+			cfg := &config.Config{PreferenceTableName: "preferences"}
+			h := &Handler{Preferences: preferences.NewStore(m.Client(), cfg), Config: cfg}
+
+			resp, err := httpx.Adapt(h.Handle)(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(resp.Body, want) {
+					t.Errorf("body = %s, want it to contain %s", resp.Body, want)
+				}
+			}
+		})
+	}
+}
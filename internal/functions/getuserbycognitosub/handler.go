@@ -0,0 +1,99 @@
+// Package getuserbycognitosub resolves a Cognito sub to the full user record,
+// for services that only hold the caller's token claims.
+package getuserbycognitosub
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/metrics"    // CloudWatch EMF metrics
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// cacheControl lets services reuse a record briefly before revalidating it
+// with its ETag. CACHE_CONTROL overrides it.
+const cacheControl = "private, max-age=60"
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the sub as a path parameter.
+type Request struct {
+	Sub string `json:"sub"` // Cognito sub, i.e. the user_id
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "getUserByCognitoSub",
+	Function: "getUserByCognitoSub",
+	Summary:  "Returns the profile of the user with a Cognito sub",
+	Method:   "GET",
+	Path:     "/users/by-sub/{sub}",
+	Response: map[string]any{},
+	Scopes:   []string{"troggle/users.read"},
+	Groups:   []string{"admin"},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users  *users.Repository
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	return &Handler{Users: users.NewRepository(client, cfg), Config: cfg}, nil
+}
+
+// HTTP returns the handler served through API Gateway. The endpoint has no
+// middleware of its own.
+func (h *Handler) HTTP() httpx.Handler {
+	return h.Handle
+}
+
+// Handle reads the record whose primary key is the sub path parameter (or
+// the sub field of a direct invocation) and returns it without its sensitive
+// attributes.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{Sub: r.PathParams["sub"]}
+
+	// Direct invocations carry the sub in the body
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	if err := validation.UserID(req.Sub); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	slog.InfoContext(ctx, "Resolving Cognito sub", "user_id", req.Sub, "table", h.Users.Table)
+	item, err := h.Users.Get(ctx, req.Sub, nil)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if item == nil {
+		metrics.Count(ctx, metrics.LookupMiss)
+		return httpx.Error(apperr.NotFound("User not found")), nil
+	}
+	metrics.Count(ctx, metrics.LookupHit)
+
+	return httpx.Conditional(r, httpx.ETag(r, db.Digest(item)), cmp.Or(h.Config.CacheControl, cacheControl), func() (httpx.Response, error) {
+		user, err := users.Profile(item)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		return httpx.JSON(200, user), nil
+	})
+}
@@ -0,0 +1,215 @@
+// Package getuserprofile returns user profiles looked up by user_id or, for
+// admins, by email. Profiles carry an ETag: clients polling one send it back
+// in If-None-Match and get a 304 until the profile changes.
+package getuserprofile
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/flags"      // feature flags
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/metrics"    // CloudWatch EMF metrics
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// cacheControl lets clients reuse a profile briefly before revalidating it.
+// CACHE_CONTROL overrides it.
+const cacheControl = "private, max-age=30"
+
+// uncachedReads is the feature flag reading profiles from DynamoDB on every
+// request, bypassing the container cache, for the callers it is on for: a
+// profile edited through another container shows up at once, at the cost
+// of a read per request, which the rollout measures.
+const uncachedReads = "profile_uncached_reads"
+
+// fieldName matches attribute names callers may request.
+var fieldName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the same values as path or query string parameters.
+type Request struct {
+	UserID string `json:"user_id"` // Cognito sub
+	Email  string `json:"email"`   // Alternative lookup key
+	Fields string `json:"fields"`  // Optional comma-separated attribute list
+}
+
+// ParseFields turns the comma-separated fields parameter into a list of
+// attribute names, dropping sensitive ones. An empty parameter returns nil,
+// meaning "all non-sensitive attributes".
+func ParseFields(param string) ([]string, error) {
+	if strings.TrimSpace(param) == "" {
+		return nil, nil
+	}
+
+	// user_id is always returned so callers can tell records apart
+	fields := []string{"user_id"}
+	seen := map[string]bool{"user_id": true}
+	for _, f := range strings.Split(param, ",") {
+		f = strings.TrimSpace(f)
+		if !fieldName.MatchString(f) {
+			return nil, fmt.Errorf("invalid field %q", f)
+		}
+		if seen[f] || users.SensitiveAttributes[f] {
+			continue
+		}
+		seen[f] = true
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// GetUserByID fetches the record of the user with the given primary key,
+// limited to fields when it is non-empty. Returns nil if there is no such
+// user.
+func GetUserByID(ctx context.Context, userID string, fields []string, repo *users.Repository) (db.Item, error) {
+	slog.InfoContext(ctx, "Fetching user profile", "user_id", userID, "table", repo.Table)
+	return repo.Get(ctx, userID, fields)
+}
+
+// GetUserByEmail fetches the record of the user registered with email.
+// Returns nil if there is no such user.
+func GetUserByEmail(ctx context.Context, email string, fields []string, repo *users.Repository) (db.Item, error) {
+	slog.InfoContext(ctx, "Looking up user by email", logging.EmailHash(email), "index", repo.EmailIndex)
+	return repo.GetByEmail(ctx, email, fields)
+}
+
+// usersReadScope grants API key callers lookups by email; users need the
+// users:act_as permission.
+const usersReadScope = "troggle/users.read"
+
+// Routes are the API routes the function serves. Looking users up by email
+// tells whether an address has an account, so it is for admins only.
+var Routes = []api.Route{
+	{
+		Name:     "getUserProfile",
+		Function: "getUserProfile",
+		Summary:  "Returns the profile of a user",
+		Method:   "GET",
+		Path:     "/users/{user_id}",
+		Query:    []string{"fields"},
+		Response: map[string]any{},
+	},
+	{
+		Name:     "getUserByEmail",
+		Function: "getUserProfile",
+		Summary:  "Returns the profile of the user registered with an email address",
+		Method:   "GET",
+		Path:     "/users/by-email",
+		Query:    []string{"email", "fields"},
+		Response: map[string]any{},
+		Scopes:   []string{usersReadScope},
+		Groups:   []string{"admin"},
+	},
+}
+
+// authorizeEmailLookup lets callers holding the users.read scope or the
+// users:act_as permission look users up by email, as the authorizer does
+// on the route. Direct invocations are trusted.
+func authorizeEmailLookup(r *httpx.Request) error {
+	if r.Direct || r.HasScope(usersReadScope) || authz.ClaimsAllow(r, authz.UsersActAs) {
+		return nil
+	}
+	return apperr.Forbidden("Admin access required")
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users  *users.Repository
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	if err := flags.Init(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("loading feature flags: %w", err)
+	}
+	return &Handler{Users: users.NewRepository(client, cfg), Config: cfg}, nil
+}
+
+// HTTP returns the handler served through API Gateway. The endpoint has no
+// middleware of its own.
+func (h *Handler) HTTP() httpx.Handler {
+	return h.Handle
+}
+
+// Handle looks up a profile by user_id (path or query parameter) or by email
+// (query parameter, admins only) and returns it as JSON, or a 304 when the
+// caller has it already.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{
+		UserID: r.PathParams["user_id"],
+		Email:  r.Query("email"),
+		Fields: r.Query("fields"),
+	}
+	if req.UserID == "" {
+		req.UserID = r.Query("user_id")
+	}
+
+	// Direct invocations carry the parameters in the body instead
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	fields, err := ParseFields(req.Fields)
+	if err != nil {
+		return httpx.Error(apperr.BadRequest(err.Error())), nil
+	}
+
+	repo := h.Users
+	if flags.Enabled(ctx, uncachedReads) {
+		repo = repo.Uncached()
+	}
+	var item db.Item
+	switch {
+	case req.UserID != "":
+		if err := validation.UserID(req.UserID); err != nil {
+			return httpx.Error(err), nil
+		}
+		item, err = GetUserByID(ctx, req.UserID, fields, repo)
+	case req.Email != "":
+		if err := authorizeEmailLookup(r); err != nil {
+			return httpx.Error(err), nil
+		}
+		email, verr := validation.NormalizeEmail(req.Email, h.Config.StripPlusAlias)
+		if verr != nil {
+			return httpx.Error(verr), nil
+		}
+		item, err = GetUserByEmail(ctx, email, fields, repo)
+	default:
+		return httpx.Error(apperr.BadRequest("user_id or email is required")), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if item == nil {
+		metrics.Count(ctx, metrics.LookupMiss)
+		return httpx.Error(apperr.NotFound("User not found")), nil
+	}
+	metrics.Count(ctx, metrics.LookupHit)
+
+	return httpx.Conditional(r, httpx.ETag(r, db.Digest(item)), cmp.Or(h.Config.CacheControl, cacheControl), func() (httpx.Response, error) {
+		profile, err := users.Profile(item)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		return httpx.JSON(200, profile), nil
+	})
+}
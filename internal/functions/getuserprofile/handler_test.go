@@ -0,0 +1,240 @@
+package getuserprofile
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/users"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		param   string
+		want    []string
+		wantErr bool
+	}{
+		{param: "", want: nil},
+		{param: "display_name, bio", want: []string{"user_id", "display_name", "bio"}},
+		{param: "bio,bio,user_id", want: []string{"user_id", "bio"}},
+		{param: "bio,phone_number,mfa_secret,status_note", want: []string{"user_id", "bio"}},
+		{param: "Bio", wantErr: true},
+		{param: "bio,", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFields(tt.param)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFields(%q) error = %v, want error %v", tt.param, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFields(%q) = %v, want %v", tt.param, got, tt.want)
+		}
+	}
+}
+
+// storedUser is the record returned by GetItem in the tests.
+var storedUser = dbtest.Item(
+	"user_id", "u1",
+	"email", "jane@example.com",
+	"display_name", "Jane",
+	"phone_number", "+15555550100",
+	"status", "suspended",
+	"status_reason", "spam",
+	"status_note", "Reported by three users for link spam",
+	"referred_by", "u9",
+)
+
+func TestHandle(t *testing.T) {
+	found := func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		return &dynamodb.GetItemOutput{Item: storedUser}, nil
+	}
+	indexed := func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1", "email", "jane@example.com")}}, nil
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		query      func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+		get        func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		wantStatus int
+		wantBody   string // substring
+		wantOps    []string
+	}{
+		{
+			name: "by id", body: `{"user_id":"u1"}`, get: found,
+			wantStatus: 200, wantBody: `"display_name":"Jane"`, wantOps: []string{"GetItem"},
+		},
+		{
+			name: "by id not found", body: `{"user_id":"u2"}`,
+			wantStatus: 404, wantBody: `"code":"NOT_FOUND"`, wantOps: []string{"GetItem"},
+		},
+		{
+			name: "invalid id", body: `{"user_id":"EMAIL#x"}`,
+			wantStatus: 422, wantBody: "USER_ID_INVALID",
+		},
+		{
+			name: "by email", body: `{"email":"Jane@Example.com"}`, query: indexed, get: found,
+			wantStatus: 200, wantBody: `"user_id":"u1"`, wantOps: []string{"Query", "GetItem"},
+		},
+		{
+			name: "by email not found", body: `{"email":"john@example.com"}`,
+			wantStatus: 404, wantBody: `"code":"NOT_FOUND"`, wantOps: []string{"Query"},
+		},
+		{
+			name: "throttled",
+			body: `{"user_id":"u1"}`,
+			get: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+				return nil, dbtest.Throttled()
+			},
+			wantStatus: 429, wantOps: []string{"GetItem"},
+		},
+		{
+			name: "no key", body: `{}`,
+			wantStatus: 400, wantBody: `"code":"BAD_REQUEST"`,
+		},
+		{
+			name: "bad body", body: `[1]`,
+			wantStatus: 400, wantBody: `"code":"BAD_REQUEST"`,
+		},
+		{
+			name: "bad fields", body: `{"user_id":"u1","fields":"a b"}`,
+			wantStatus: 400, wantBody: `"code":"BAD_REQUEST"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: tt.query, GetItemFunc: tt.get}
+			cfg := &config.Config{UserTableName: "users", EmailIndexName: "email-index"}
+			h := &Handler{Users: users.NewRepository(m.Client(), cfg), Config: cfg}
+
+			resp, err := httpx.Adapt(h.Handle)(context.Background(), json.RawMessage(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+			for _, attr := range []string{"phone_number", "status_reason", "status_note", "referred_by"} {
+				if strings.Contains(resp.Body, attr) {
+					t.Errorf("body leaks %s: %s", attr, resp.Body)
+				}
+			}
+			if ops := m.Ops(); !reflect.DeepEqual(ops, tt.wantOps) && len(ops)+len(tt.wantOps) > 0 {
+				t.Errorf("DynamoDB calls = %v, want %v", ops, tt.wantOps)
+			}
+		})
+	}
+}
+
+func TestGetUserByIDProjection(t *testing.T) {
+	m := &dbtest.Mock{}
+	if _, err := GetUserByID(context.Background(), "u1", []string{"user_id", "status"}, &users.Repository{DB: m.Client(), Table: "users"}); err != nil {
+		t.Fatal(err)
+	}
+
+	in := m.Calls[0].Input.(*dynamodb.GetItemInput)
+	// The deletion mark is read along to skip soft-deleted users
+	got := dbtest.Projected(in.ProjectionExpression, in.ExpressionAttributeNames)
+	if want := []string{"user_id", "status", users.DeletedAttribute}; !reflect.DeepEqual(got, want) {
+		t.Errorf("projection = %v (%s), want %v", got, aws.ToString(in.ProjectionExpression), want)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	item := storedUser
+	m := &dbtest.Mock{GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		return &dynamodb.GetItemOutput{Item: item}, nil
+	}}
+	cfg := &config.Config{UserTableName: "users"}
+	h := &Handler{Users: &users.Repository{DB: m.Client(), Table: "users"}, Config: cfg}
+	get := func(etag string) httpx.Response {
+		event, _ := json.Marshal(map[string]any{
+			"httpMethod":     "GET",
+			"path":           "/users/u1",
+			"pathParameters": map[string]string{"user_id": "u1"},
+			"headers":        map[string]string{"If-None-Match": etag},
+		})
+		resp, err := httpx.Adapt(h.Handle)(context.Background(), event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	first := get("")
+	etag := first.Headers["ETag"]
+	if first.StatusCode != 200 || !strings.HasPrefix(etag, `W/"`) || first.Headers["Cache-Control"] != cacheControl {
+		t.Fatalf("first read = %d, headers %v", first.StatusCode, first.Headers)
+	}
+	if again := get(etag); again.StatusCode != 304 || again.Body != "" || again.Headers["ETag"] != etag {
+		t.Errorf("revalidation = %d %q, headers %v", again.StatusCode, again.Body, again.Headers)
+	}
+
+	item = dbtest.Item("user_id", "u1", "display_name", "Janet")
+	cfg.CacheControl = "no-cache"
+	changed := get(etag)
+	if changed.StatusCode != 200 || changed.Headers["ETag"] == etag || !strings.Contains(changed.Body, "Janet") {
+		t.Errorf("read after a change = %d %s, headers %v", changed.StatusCode, changed.Body, changed.Headers)
+	}
+	if changed.Headers["Cache-Control"] != "no-cache" {
+		t.Errorf("Cache-Control = %q, want the configured one", changed.Headers["Cache-Control"])
+	}
+}
+
+func TestEmailLookupAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		authorizer map[string]any
+		wantStatus int
+	}{
+		{name: "user", authorizer: map[string]any{"sub": "u2"}, wantStatus: 403},
+		{name: "moderator", authorizer: map[string]any{"sub": "m1", "cognito:groups": "moderator"}, wantStatus: 403},
+		{name: "admin", authorizer: map[string]any{"sub": "a1", "cognito:groups": "admin"}, wantStatus: 200},
+		{name: "service", authorizer: map[string]any{"scope": usersReadScope}, wantStatus: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1", "email", "jane@example.com")}}, nil
+				},
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: storedUser}, nil
+				},
+			}
+			cfg := &config.Config{UserTableName: "users", EmailIndexName: "email-index"}
+			h := &Handler{Users: users.NewRepository(m.Client(), cfg), Config: cfg}
+			event, _ := json.Marshal(map[string]any{
+				"httpMethod":            "GET",
+				"path":                  "/users/by-email",
+				"queryStringParameters": map[string]string{"email": "jane@example.com"},
+				"requestContext":        map[string]any{"authorizer": tt.authorizer},
+			})
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus == 403 && len(m.Ops()) > 0 {
+				t.Errorf("DynamoDB calls = %v, want none for a refused lookup", m.Ops())
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// Package getversion reports the build serving the API, so deploy tooling
+// and on-call can tell which commit is live: GET /meta/version answers the
+// git SHA, build time and Go version of package buildinfo. Any signed-in
+// caller, or API key, may read it.
+package getversion
+
+import (
+	"context"
+	"fmt"
+
+	"troggle-backend/internal/api"       // API route declarations
+	"troggle-backend/internal/apikeys"   // API keys of server-to-server callers
+	"troggle-backend/internal/auth"      // Cognito JWT verification
+	"troggle-backend/internal/buildinfo" // git SHA and build time
+	"troggle-backend/internal/config"    // environment-driven settings
+	"troggle-backend/internal/db"        // shared DynamoDB client
+	"troggle-backend/internal/httpx"     // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"  // session table access
+)
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "getVersion",
+		Function: "getVersion",
+		Summary:  "Returns the build serving the API",
+		Method:   "GET",
+		Path:     "/meta/version",
+		Response: buildinfo.Info{},
+		APIKey:   true,
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Auth   auth.TokenVerifier
+	APIKey *apikeys.Middleware // nil accepts bearer tokens only
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{Auth: verifier, APIKey: apikeys.NewMiddleware(client, cfg)}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth))
+}
+
+// Handle answers the build info. It is not cached: during a deploy,
+// consecutive requests may be served by different builds.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	resp := httpx.JSON(200, buildinfo.Get())
+	resp.Headers["Cache-Control"] = "no-store"
+	return resp, nil
+}
@@ -0,0 +1,55 @@
+package getversion
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/buildinfo"
+	"troggle-backend/internal/httpx"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+func TestHandle(t *testing.T) {
+	h := &Handler{Auth: stubVerifier{&auth.Identity{Subject: "u1"}}}
+	for _, tt := range []struct {
+		token      string
+		wantStatus int
+	}{
+		{token: "valid", wantStatus: 200},
+		{token: "expired", wantStatus: 401},
+	} {
+		event, _ := json.Marshal(map[string]any{
+			"httpMethod": "GET",
+			"path":       "/meta/version",
+			"headers":    map[string]string{"Authorization": "Bearer " + tt.token},
+		})
+		resp, err := httpx.Adapt(h.HTTP())(context.Background(), event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.wantStatus {
+			t.Fatalf("token %s: status = %d, want %d", tt.token, resp.StatusCode, tt.wantStatus)
+		}
+		if tt.wantStatus != 200 {
+			continue
+		}
+		var info buildinfo.Info
+		if err := json.Unmarshal([]byte(resp.Body), &info); err != nil {
+			t.Fatal(err)
+		}
+		if info != buildinfo.Get() {
+			t.Errorf("info = %+v, want %+v", info, buildinfo.Get())
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Package grantachievement advances and unlocks achievements. An EventBridge
+// rule delivers it the domain events achievements are triggered by, and
+// every achievement an event triggers advances for the event's user (the
+// user_id of its detail). Other backend functions and operators may also
+// invoke it directly with {"user_id": "...", "achievement_id": "..."} to
+// grant an achievement outright.
+//
+// Unlocks publish an AchievementUnlocked event and push a notification to
+// the user's devices, unless they turned pushes off. See package
+// achievements.
+package grantachievement
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge" // EventBridge client
+	"github.com/aws/aws-sdk-go-v2/service/sns"         // SNS client
+
+	"troggle-backend/internal/achievements" // badge engine
+	"troggle-backend/internal/apperr"       // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/awscfg"       // shared AWS SDK config
+	"troggle-backend/internal/config"       // environment-driven settings
+	"troggle-backend/internal/db"           // shared DynamoDB client
+	"troggle-backend/internal/devices"      // device token registry
+	"troggle-backend/internal/events"       // domain event publishing
+	"troggle-backend/internal/logging"      // structured JSON logging
+	"troggle-backend/internal/preferences"  // notification preferences
+	"troggle-backend/internal/push"         // SNS mobile push
+	"troggle-backend/internal/validation"   // input normalization and validation
+)
+
+// Event is the part of an EventBridge event the handler reads.
+type Event struct {
+	ID         string          `json:"id"`
+	DetailType string          `json:"detail-type"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// detail is the part of a domain event's detail naming its user.
+type detail struct {
+	UserID string `json:"user_id"`
+}
+
+// Request represents the JSON input of a direct grant.
+type Request struct {
+	UserID        string `json:"user_id"`
+	AchievementID string `json:"achievement_id"`
+}
+
+// Response is the JSON output of a direct grant.
+type Response struct {
+	Unlocked bool `json:"unlocked"` // false when the user had it already
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Achievements *achievements.Store
+	Preferences  *preferences.Store
+	Push         *push.Sender
+	Events       *events.Publisher
+	Config       *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Achievements: achievements.NewStore(client, cfg),
+		Preferences:  preferences.NewStore(client, cfg),
+		Push:         push.NewSender(sns.NewFromConfig(awsCfg), devices.NewStore(client, cfg), cfg),
+		Events:       events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Config:       cfg,
+	}, nil
+}
+
+// Invoke is the Lambda entry point. EventBridge events, which name their
+// detail-type, advance achievements; anything else is a direct grant.
+func (h *Handler) Invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err == nil && event.DetailType != "" {
+		return nil, h.HandleEvent(ctx, event)
+	}
+	var req Request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, fmt.Errorf("decoding request: %w", err)
+	}
+	unlocked, err := h.Grant(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return Response{Unlocked: unlocked}, nil
+}
+
+// HandleEvent advances the achievements event triggers for its user. An
+// achievement that fails does not stop the others; failing the invocation
+// makes EventBridge deliver the event again, which only advances the ones
+// still behind.
+func (h *Handler) HandleEvent(ctx context.Context, event Event) error {
+	defs, err := h.Achievements.Triggered(ctx, event.DetailType)
+	if err != nil || len(defs) == 0 {
+		return err
+	}
+	var d detail
+	if err := json.Unmarshal(event.Detail, &d); err != nil || validation.UserID(d.UserID) != nil {
+		slog.WarnContext(ctx, "Skipping event without a user", "event_id", event.ID, "detail_type", event.DetailType)
+		return nil
+	}
+
+	now := time.Now()
+	var errs []error
+	for _, def := range defs {
+		unlocked, err := h.Achievements.Advance(ctx, d.UserID, def, event.ID, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to advance achievement", "user_id", d.UserID, "achievement_id", def.ID, logging.Err(err))
+			errs = append(errs, fmt.Errorf("advancing %s: %w", def.ID, err))
+			continue
+		}
+		if unlocked {
+			h.notify(ctx, d.UserID, def, now)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Grant unlocks the achievement of req outright and reports whether it did.
+func (h *Handler) Grant(ctx context.Context, req Request) (bool, error) {
+	if err := validation.UserID(req.UserID); err != nil {
+		return false, err
+	}
+	def, ok, err := h.Achievements.Lookup(ctx, req.AchievementID)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, apperr.NotFound("Achievement not found")
+	}
+
+	now := time.Now()
+	unlocked, err := h.Achievements.Grant(ctx, req.UserID, def, now)
+	if err != nil {
+		return false, err
+	}
+	if unlocked {
+		h.notify(ctx, req.UserID, def, now)
+	}
+	return unlocked, nil
+}
+
+// notify tells userID about the unlock of def. The unlock is stored
+// already, so failures are only logged.
+func (h *Handler) notify(ctx context.Context, userID string, def achievements.Definition, now time.Time) {
+	slog.InfoContext(ctx, "Achievement unlocked", "user_id", userID, "achievement_id", def.ID)
+	h.Events.Emit(ctx, events.AchievementUnlocked{
+		UserID:        userID,
+		AchievementID: def.ID,
+		Name:          def.Name,
+		UnlockedAt:    now.UTC().Format(time.RFC3339),
+	})
+
+	stored, _, err := h.Preferences.Get(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read notification preferences", "user_id", userID, logging.Err(err))
+		return
+	}
+	if preferences.Resolve(stored)[preferences.Notifications]["push_enabled"] == false {
+		return
+	}
+	n := push.Notification{
+		Title: "Achievement unlocked: " + def.Name,
+		Body:  def.Description,
+		Data:  map[string]string{"type": "achievement", "achievement_id": def.ID},
+	}
+	if _, err := h.Push.Send(ctx, userID, n); err != nil {
+		slog.WarnContext(ctx, "Failed to push achievement", "user_id", userID, "achievement_id", def.ID, logging.Err(err))
+	}
+}
@@ -0,0 +1,120 @@
+package grantachievement
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/achievements"
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/devices"
+	"troggle-backend/internal/preferences"
+	"troggle-backend/internal/push"
+)
+
+func TestInvoke(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		advance  error // of the count
+		want     any
+		wantKind apperr.Kind // of the error
+		wantErr  bool
+		wantOps  []string
+	}{
+		{
+			name:    "unlocking event",
+			payload: `{"id":"e1","detail-type":"GameWon","detail":{"user_id":"u1"}}`,
+			// count, unlock, then the preferences and devices of the push
+			wantOps: []string{"Scan", "UpdateItem", "UpdateItem", "GetItem", "Query"},
+		},
+		{
+			name:    "redelivered event",
+			payload: `{"id":"e1","detail-type":"GameWon","detail":{"user_id":"u1"}}`,
+			advance: dbtest.ConditionFailed(),
+			wantOps: []string{"Scan", "UpdateItem"},
+		},
+		{
+			name:    "event of no achievement",
+			payload: `{"id":"e2","detail-type":"UserCreated","detail":{"user_id":"u1"}}`,
+			wantOps: []string{"Scan"},
+		},
+		{
+			name:    "event without a user",
+			payload: `{"id":"e3","detail-type":"GameWon","detail":{}}`,
+			wantOps: []string{"Scan"},
+		},
+		{
+			name:     "failing count",
+			payload:  `{"id":"e4","detail-type":"GameWon","detail":{"user_id":"u1"}}`,
+			advance:  dbtest.Throttled(),
+			wantKind: apperr.KindThrottled,
+			wantErr:  true,
+			wantOps:  []string{"Scan", "UpdateItem"},
+		},
+		{
+			name:    "grant",
+			payload: `{"user_id":"u1","achievement_id":"beta_tester"}`,
+			want:    Response{Unlocked: true},
+			wantOps: []string{"Scan", "UpdateItem", "GetItem", "Query"},
+		},
+		{
+			name:     "grant of no achievement",
+			payload:  `{"user_id":"u1","achievement_id":"nope"}`,
+			wantKind: apperr.KindNotFound,
+			wantErr:  true,
+			wantOps:  []string{"Scan"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				ScanFunc: func(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+					first := dbtest.Item("achievement_id", "first_win", "name", "First win", "trigger", "GameWon")
+					first["goal"] = &types.AttributeValueMemberN{Value: "1"}
+					beta := dbtest.Item("achievement_id", "beta_tester", "name", "Beta tester", "trigger", "")
+					return &dynamodb.ScanOutput{Items: []db.Item{first, beta}}, nil
+				},
+				UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					if _, counting := in.ExpressionAttributeValues[":event_id"]; !counting {
+						return &dynamodb.UpdateItemOutput{}, nil
+					}
+					if tt.advance != nil {
+						return nil, tt.advance
+					}
+					item := dbtest.Item("user_id", "u1", "achievement_id", "first_win")
+					item["progress"] = &types.AttributeValueMemberN{Value: "1"}
+					return &dynamodb.UpdateItemOutput{Attributes: item}, nil
+				},
+			}
+			cfg := &config.Config{PreferenceTableName: "preferences", DeviceTableName: "devices"}
+			h := &Handler{
+				Achievements: &achievements.Store{DB: m.Client(), Table: "achievements", ProgressTable: "progress"},
+				Preferences:  preferences.NewStore(m.Client(), cfg),
+				Push:         push.NewSender(nil, devices.NewStore(m.Client(), cfg), cfg),
+				Config:       cfg,
+			}
+
+			got, err := h.Invoke(context.Background(), json.RawMessage(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && apperr.KindOf(err) != tt.wantKind {
+				t.Errorf("kind = %v, want %v", apperr.KindOf(err), tt.wantKind)
+			}
+			if got != tt.want {
+				t.Errorf("response = %+v, want %+v", got, tt.want)
+			}
+			if ops := m.Ops(); !slices.Equal(ops, tt.wantOps) {
+				t.Errorf("ops = %v, want %v", ops, tt.wantOps)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+// Package healthcheck reports whether the backend's dependencies are
+// reachable and set up as deployed, for uptime monitors and the smoke tests
+// run after each deploy: GET /health describes every table and its indexes,
+// reads the Parameter Store path and fetches the secrets functions need.
+//
+// The report lists one component per check, each "ok", "failed" or
+// "skipped", and is answered with a 200 when nothing failed and a 503
+// otherwise, so monitors that only look at the status still alert.
+// Callers need health:read, which monitors hold as an API key scope.
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apikeys"     // API keys of server-to-server callers
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/authz"       // role-based access control
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/buildinfo"   // git SHA and build time
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/dynconfig"   // Parameter Store settings
+	"troggle-backend/internal/emailchange" // email change tokens
+	"troggle-backend/internal/fanout"      // bounded parallel reads
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/invitations" // invitation codes
+	"troggle-backend/internal/localdev"    // table and index definitions
+	"troggle-backend/internal/pagination"  // signed next tokens
+	"troggle-backend/internal/secrets"     // Secrets Manager access
+	"troggle-backend/internal/sessions"    // session table access
+)
+
+// Component statuses.
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// checkTimeout bounds each check, so one hanging dependency is reported as
+// failed rather than timing out the whole report.
+const checkTimeout = 3 * time.Second
+
+// Secrets are the secrets, below SECRETS_PREFIX, the functions need.
+var Secrets = []string{pagination.SecretName, emailchange.SecretName, invitations.SecretName}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "healthCheck",
+		Function: "healthCheck",
+		Summary:  "Reports the status of the backend's dependencies",
+		Method:   "GET",
+		Path:     "/health",
+		Status:   200,
+		Scopes:   []string{"troggle/admin", "health:read"},
+		Groups:   []string{"admin"},
+		APIKey:   true,
+	},
+}
+
+// DynamoDBAPI is the part of the DynamoDB API the table checks use.
+type DynamoDBAPI interface {
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+}
+
+// Component is the result of one check.
+type Component struct {
+	Name      string `json:"name"` // e.g. "dynamodb:troggle-users"
+	Status    string `json:"status"`
+	Detail    string `json:"detail,omitempty"` // why the check failed or was skipped
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// Report is the response body: StatusOK when no component failed,
+// StatusFailed otherwise. Smoke tests compare Build with the commit they
+// deployed.
+type Report struct {
+	Status     string         `json:"status"`
+	CheckedAt  string         `json:"checked_at"`
+	Build      buildinfo.Info `json:"build"`
+	Components []Component    `json:"components"`
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	DynamoDB DynamoDBAPI
+	SSM      dynconfig.API
+	Secrets  secrets.API
+	Auth     auth.TokenVerifier
+	APIKey   *apikeys.Middleware // nil accepts bearer tokens only
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	sdk, err := db.NewSDK(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		DynamoDB: sdk,
+		SSM:      ssm.NewFromConfig(awsCfg),
+		Secrets:  secretsmanager.NewFromConfig(awsCfg),
+		Auth:     verifier,
+		APIKey:   apikeys.NewMiddleware(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth))
+}
+
+// Handle runs every check and answers the report. Direct invocations are
+// trusted.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	if !r.Direct {
+		if err := authz.Require(ctx, authz.HealthRead); err != nil {
+			return httpx.Response{}, err
+		}
+	}
+	report := h.Check(ctx)
+	status := 200
+	if report.Status != StatusOK {
+		status = 503
+	}
+	resp := httpx.JSON(status, report)
+	resp.Headers["Cache-Control"] = "no-store"
+	return resp, nil
+}
+
+// check is one named check. It returns the detail of a skipped check, or
+// an error when the check failed.
+type check struct {
+	name string
+	run  func(ctx context.Context) (skipped string, err error)
+}
+
+// Check runs every check in parallel and reports their results, in the
+// order of the checks.
+func (h *Handler) Check(ctx context.Context) Report {
+	checks := h.checks()
+	components := make([]Component, len(checks))
+	g := fanout.New(ctx, fanout.DefaultLimit)
+	for i, c := range checks {
+		g.Go(func(ctx context.Context) error {
+			components[i] = run(ctx, c)
+			return nil
+		})
+	}
+	g.Wait()
+
+	report := Report{
+		Status:     StatusOK,
+		CheckedAt:  time.Now().UTC().Format(time.RFC3339),
+		Build:      buildinfo.Get(),
+		Components: components,
+	}
+	if slices.ContainsFunc(components, func(c Component) bool { return c.Status == StatusFailed }) {
+		report.Status = StatusFailed
+	}
+	return report
+}
+
+// run runs c under checkTimeout and times it.
+func run(ctx context.Context, c check) Component {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	start := time.Now()
+	skipped, err := c.run(ctx)
+	comp := Component{Name: c.name, Status: StatusOK, LatencyMS: time.Since(start).Milliseconds()}
+	switch {
+	case err != nil:
+		comp.Status, comp.Detail = StatusFailed, err.Error()
+	case skipped != "":
+		comp.Status, comp.Detail = StatusSkipped, skipped
+	}
+	return comp
+}
+
+// checks lists the checks: one per table, then Parameter Store, then one
+// per secret.
+func (h *Handler) checks() []check {
+	var checks []check
+	for _, t := range localdev.Tables(h.Config) {
+		checks = append(checks, check{
+			name: "dynamodb:" + aws.ToString(t.TableName),
+			run: func(ctx context.Context) (string, error) {
+				return "", h.checkTable(ctx, t)
+			},
+		})
+	}
+	checks = append(checks, check{name: "ssm:parameters", run: h.checkParameters})
+
+	// A cache of its own, empty, so a failed fetch fails the check rather
+	// than falling back to a value cached earlier
+	cache := &secrets.Cache{API: h.Secrets, Prefix: h.Config.SecretsPrefix}
+	for _, name := range Secrets {
+		checks = append(checks, check{
+			name: "secretsmanager:" + name,
+			run: func(ctx context.Context) (string, error) {
+				_, err := cache.Get(ctx, name)
+				return "", err
+			},
+		})
+	}
+	return checks
+}
+
+// checkTable checks that the table of want is active and has each of its
+// global secondary indexes, active too.
+func (h *Handler) checkTable(ctx context.Context, want *dynamodb.CreateTableInput) error {
+	out, err := h.DynamoDB.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: want.TableName})
+	if err != nil {
+		return fmt.Errorf("describing table: %w", err)
+	}
+	if s := out.Table.TableStatus; s != types.TableStatusActive {
+		return fmt.Errorf("table is %s", s)
+	}
+	indexes := make(map[string]types.IndexStatus, len(out.Table.GlobalSecondaryIndexes))
+	for _, gsi := range out.Table.GlobalSecondaryIndexes {
+		indexes[aws.ToString(gsi.IndexName)] = gsi.IndexStatus
+	}
+	for _, gsi := range want.GlobalSecondaryIndexes {
+		name := aws.ToString(gsi.IndexName)
+		s, ok := indexes[name]
+		if !ok {
+			return fmt.Errorf("index %s is missing", name)
+		}
+		if s != types.IndexStatusActive {
+			return fmt.Errorf("index %s is %s", name, s)
+		}
+	}
+	return nil
+}
+
+// checkParameters reads the first page of the Parameter Store path. An
+// empty path needs no parameters, and is skipped.
+func (h *Handler) checkParameters(ctx context.Context) (string, error) {
+	if h.Config.ParameterPath == "" {
+		return "PARAMETER_PATH is not set", nil
+	}
+	_, err := h.SSM.GetParametersByPath(ctx, &ssm.GetParametersByPathInput{
+		Path:       aws.String(h.Config.ParameterPath),
+		MaxResults: aws.Int32(1),
+	})
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", h.Config.ParameterPath, err)
+	}
+	return "", nil
+}
@@ -0,0 +1,156 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/localdev"
+)
+
+// fakeDynamoDB describes the tables of localdev.Tables as active, except
+// for the index it drops.
+type fakeDynamoDB struct {
+	cfg       *config.Config
+	dropIndex string
+}
+
+func (f fakeDynamoDB) DescribeTable(_ context.Context, in *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	for _, t := range localdev.Tables(f.cfg) {
+		if aws.ToString(t.TableName) != aws.ToString(in.TableName) {
+			continue
+		}
+		desc := &types.TableDescription{TableName: t.TableName, TableStatus: types.TableStatusActive}
+		for _, gsi := range t.GlobalSecondaryIndexes {
+			if aws.ToString(gsi.IndexName) != f.dropIndex {
+				desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{
+					IndexName:   gsi.IndexName,
+					IndexStatus: types.IndexStatusActive,
+				})
+			}
+		}
+		return &dynamodb.DescribeTableOutput{Table: desc}, nil
+	}
+	return nil, &types.ResourceNotFoundException{Message: aws.String("table not found")}
+}
+
+type fakeSSM struct{ err error }
+
+func (f fakeSSM) GetParametersByPath(context.Context, *ssm.GetParametersByPathInput, ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	return &ssm.GetParametersByPathOutput{}, f.err
+}
+
+type fakeSecrets struct{ missing bool }
+
+func (f fakeSecrets) GetSecretValue(context.Context, *secretsmanager.GetSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if f.missing {
+		return nil, &smtypes.ResourceNotFoundException{Message: aws.String("secret not found")}
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String("s3cr3t"), VersionId: aws.String("v1")}, nil
+}
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+func apiEvent() json.RawMessage {
+	return json.RawMessage(`{"httpMethod": "GET", "path": "/health", "headers": {"Authorization": "Bearer valid"}}`)
+}
+
+// testConfig returns the default configuration, with every table and index
+// named.
+func testConfig(t *testing.T) *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ParameterPath = ""
+	return cfg
+}
+
+func TestHandle(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ParameterPath = "/troggle/test/"
+	admin := &auth.Identity{Subject: "a1", Roles: []string{authz.Admin}}
+
+	tests := []struct {
+		name       string
+		dynamo     fakeDynamoDB
+		ssm        fakeSSM
+		secrets    fakeSecrets
+		caller     *auth.Identity
+		wantStatus int
+		wantFailed []string
+	}{
+		{name: "healthy", caller: admin, wantStatus: 200},
+		{name: "missing index", dynamo: fakeDynamoDB{dropIndex: config.DefaultEmailIndexName}, caller: admin, wantStatus: 503, wantFailed: []string{"dynamodb:" + config.DefaultUserTableName}},
+		{name: "ssm down", ssm: fakeSSM{err: errors.New("throttled")}, caller: admin, wantStatus: 503, wantFailed: []string{"ssm:parameters"}},
+		{name: "missing secret", secrets: fakeSecrets{missing: true}, caller: admin, wantStatus: 503, wantFailed: []string{"secretsmanager:pagination_key", "secretsmanager:email_change_key", "secretsmanager:invitation_key"}},
+		{name: "user", caller: &auth.Identity{Subject: "u1"}, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.dynamo.cfg = cfg
+			h := &Handler{DynamoDB: tt.dynamo, SSM: tt.ssm, Secrets: tt.secrets, Auth: stubVerifier{tt.caller}, Config: cfg}
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus == 403 {
+				return
+			}
+
+			var report Report
+			if err := json.Unmarshal([]byte(resp.Body), &report); err != nil {
+				t.Fatal(err)
+			}
+			if want := len(localdev.Tables(cfg)) + 1 + len(Secrets); len(report.Components) != want {
+				t.Errorf("%d components, want %d", len(report.Components), want)
+			}
+			var failed []string
+			for _, c := range report.Components {
+				if c.Status == StatusFailed {
+					failed = append(failed, c.Name)
+				}
+			}
+			if len(failed) != len(tt.wantFailed) || (len(failed) > 0 && failed[0] != tt.wantFailed[0]) {
+				t.Errorf("failed = %v, want %v", failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestCheckSkipsParameters(t *testing.T) {
+	cfg := testConfig(t)
+	h := &Handler{DynamoDB: fakeDynamoDB{cfg: cfg}, SSM: fakeSSM{err: errors.New("unreachable")}, Secrets: fakeSecrets{}, Config: cfg}
+	report := h.Check(context.Background())
+	if report.Status != StatusOK {
+		t.Errorf("status = %q, want %q: %+v", report.Status, StatusOK, report.Components)
+	}
+	for _, c := range report.Components {
+		if c.Name == "ssm:parameters" && c.Status != StatusSkipped {
+			t.Errorf("parameters = %+v, want skipped", c)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package joinmatch adds the caller to the players of a waiting match (POST
+// /matches/{match_id}/players). The player filling the match starts it.
+// Joining again returns the match unchanged. See package matches.
+package joinmatch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/matches"       // match records
+	"troggle-backend/internal/ratelimit"     // DynamoDB token buckets
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// rateLimits keep clients from hammering full lobbies. They can be tuned per
+// stage through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(120),
+	PerUser: ratelimit.PerMinute(30),
+}
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the match in the path and join it themselves.
+type Request struct {
+	MatchID string `json:"match_id"`
+	UserID  string `json:"user_id"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "joinMatch",
+	Function: "joinMatch",
+	Summary:  "Joins a match",
+	Method:   "POST",
+	Path:     "/matches/{match_id}/players",
+	Response: matches.Match{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Matches *matches.Store
+	Graph   *relationships.Store
+	Limiter *ratelimit.Limiter
+	Limits  ratelimit.Policy
+	Auth    auth.TokenVerifier
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Matches: matches.NewStore(client, cfg),
+		Graph:   relationships.NewStore(client, cfg),
+		Limiter: ratelimit.New(client, cfg),
+		Limits:  limits,
+		Auth:    verifier,
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies. Joining is
+// idempotent, so the endpoint needs no idempotency key.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle adds the user to the match and returns the match.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{MatchID: r.PathParams["match_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	} else {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req.UserID = id.Subject
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if req.MatchID == "" {
+		return httpx.Error(apperr.NotFound("Match not found")), nil
+	}
+
+	m, err := h.Matches.Get(ctx, req.MatchID)
+	switch {
+	case apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	// Users who blocked each other are kept out of each other's matches
+	if m.HostID != req.UserID {
+		blocked, err := h.Graph.IsBlocked(ctx, m.HostID, req.UserID)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		if blocked {
+			return httpx.Error(apperr.Forbidden("You cannot interact with this user")), nil
+		}
+	}
+
+	m, err = h.Matches.Join(ctx, req.MatchID, req.UserID, time.Now())
+	switch {
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Match joined", "match_id", m.ID, "user_id", req.UserID, "status", m.Status)
+	return httpx.JSON(200, m), nil
+}
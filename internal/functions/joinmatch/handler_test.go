@@ -0,0 +1,98 @@
+package joinmatch
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/matches"
+	"troggle-backend/internal/relationships"
+)
+
+// apiEvent is a POST /matches/{match_id}/players REST API event.
+func apiEvent(matchID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/matches/" + matchID + "/players",
+		"pathParameters": map[string]string{"match_id": matchID},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	match := func(players ...string) db.Item {
+		item, _ := attributevalue.MarshalMap(matches.Match{
+			ID: "m1", HostID: "host", Status: matches.Waiting, Players: players, MaxPlayers: 4,
+			JoinBy: time.Now().Add(time.Minute), FinishBy: time.Now().Add(time.Hour),
+		})
+		return item
+	}
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		blocked    bool
+		wantStatus int
+		wantJoined string // user the join adds
+		wantBody   string
+	}{
+		{name: "joins", payload: apiEvent("m1"), wantStatus: 200, wantJoined: "u1", wantBody: `"players":["host","u1"]`},
+		{name: "blocked by the host", payload: apiEvent("m1"), blocked: true, wantStatus: 403},
+		{name: "unknown match", payload: apiEvent("m9"), wantStatus: 404},
+		{name: "direct", payload: json.RawMessage(`{"match_id":"m1","user_id":"u2"}`), wantStatus: 200, wantJoined: "u2"},
+		{name: "direct without a user", payload: json.RawMessage(`{"match_id":"m1"}`), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var joined string
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					switch {
+					case aws.ToString(in.TableName) == "relationships" && tt.blocked:
+						item := dbtest.Item("user_id", "host", "edge", "BLOCK#u1")
+						item["blocked_by"] = &types.AttributeValueMemberSS{Value: []string{"host"}}
+						return &dynamodb.GetItemOutput{Item: item}, nil
+					case aws.ToString(in.TableName) == "matches" && in.Key["match_id"].(*types.AttributeValueMemberS).Value == "m1":
+						return &dynamodb.GetItemOutput{Item: match("host")}, nil
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+				UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					joined = in.ExpressionAttributeValues[":user_id"].(*types.AttributeValueMemberS).Value
+					return &dynamodb.UpdateItemOutput{Attributes: match("host", joined)}, nil
+				},
+			}
+			h := &Handler{
+				Matches: &matches.Store{DB: m.Client(), Table: "matches"},
+				Graph:   &relationships.Store{DB: m.Client(), Table: "relationships"},
+				Config:  &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if joined != tt.wantJoined {
+				t.Errorf("joined %q, want %q", joined, tt.wantJoined)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
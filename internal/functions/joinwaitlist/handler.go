@@ -0,0 +1,164 @@
+// Package joinwaitlist puts an address on the waitlist (POST /waitlist),
+// optionally with the referral code of whoever sent the person. The route
+// is public: people on the waitlist have no account yet. See package
+// waitlist.
+//
+// Every valid request is answered with the same 202, whether the address
+// just joined or was on the waitlist already, so the endpoint cannot be
+// used to find out who is. The address is mailed its estimated place in
+// line and its own referral code instead; joining again mails the current
+// estimate, within the template's limit.
+package joinwaitlist
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs" // SQS client for the email queue
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/email"      // transactional email
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/ratelimit"  // DynamoDB token buckets
+	"troggle-backend/internal/validation" // input normalization and validation
+	"troggle-backend/internal/waitlist"   // waitlist of sign-ups
+)
+
+// rateLimits bound the requests of one source IP. They can be tuned per
+// stage through RATE_LIMIT_PER_IP; callers are anonymous, so there is no
+// per-user limit.
+var rateLimits = ratelimit.Policy{
+	PerIP: ratelimit.PerMinute(10),
+}
+
+// uniformMessage is the body of every accepted request.
+const uniformMessage = "You're on the waitlist; check your inbox for your place in line"
+
+// Request represents the JSON input.
+type Request struct {
+	Email        string `json:"email"`
+	ReferralCode string `json:"referral_code,omitempty"` // of the person who referred the caller
+}
+
+// UniformResponse is returned for every accepted request.
+type UniformResponse struct {
+	Message string `json:"message"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "joinWaitlist",
+	Function: "joinWaitlist",
+	Summary:  "Puts an address on the waitlist and emails its place in line",
+	Method:   "POST",
+	Path:     "/waitlist",
+	Status:   202,
+	Request:  Request{},
+	Response: UniformResponse{},
+	Public:   true,
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Waitlist *waitlist.Store
+	Email    *email.Queue
+	Limiter  *ratelimit.Limiter
+	Limits   ratelimit.Policy
+	Config   *config.Config
+	Now      func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// email queue.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	queue, err := email.NewQueue(sqs.NewFromConfig(awsCfg), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Waitlist: waitlist.NewStore(client, cfg),
+		Email:    queue,
+		Limiter:  ratelimit.New(client, cfg),
+		Limits:   limits,
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.Limiter.Middleware(h.Limits))
+}
+
+// Handle puts the address in the body on the waitlist, unless it is on it
+// already, mails it its place and answers 202. Malformed addresses and
+// unknown referral codes, which reveal nothing about the address, are
+// refused with 422.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	address, err := validation.NormalizeEmail(req.Email, h.Config.StripPlusAlias)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	entry, joined, err := h.Waitlist.Join(ctx, address, waitlist.NormalizeCode(req.ReferralCode), h.now())
+	if apperr.KindOf(err) == apperr.KindInvalid {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if joined {
+		slog.InfoContext(ctx, "Joined the waitlist", "position", entry.Position, "referred", entry.ReferredBy != "", logging.EmailHash(address))
+	}
+	if entry.Status != waitlist.StatusWaiting {
+		// Admitted people were mailed how to sign in
+		return httpx.JSON(202, UniformResponse{Message: uniformMessage}), nil
+	}
+
+	place, err := h.Waitlist.Place(ctx, entry)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	err = h.Email.Enqueue(ctx, email.Message{
+		Template: email.TemplateWaitlistJoined,
+		To:       address,
+		Data: map[string]string{
+			"position":      strconv.FormatInt(place, 10),
+			"referral_code": entry.ReferralCode,
+		},
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSON(202, UniformResponse{Message: uniformMessage}), nil
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
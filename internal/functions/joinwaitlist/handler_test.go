@@ -0,0 +1,126 @@
+package joinwaitlist
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/email"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/waitlist"
+)
+
+// fakeSQS records sent messages.
+type fakeSQS struct{ sent []email.Message }
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	var m email.Message
+	if err := json.Unmarshal([]byte(aws.ToString(in.MessageBody)), &m); err != nil {
+		return nil, err
+	}
+	f.sent = append(f.sent, m)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// apiEvent is an anonymous REST API event of the waitlist route.
+func apiEvent(address, code string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"email": address, "referral_code": code})
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/waitlist",
+		"body":       string(body),
+	})
+	return event
+}
+
+func num(v string) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: v}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name      string
+		email     string
+		code      string
+		status    string // of the address's entry; none when empty
+		wantCode  int
+		wantJoin  bool
+		wantPlace string // mailed; nothing mailed when empty
+	}{
+		{name: "joined", email: " Sam@Example.com ", wantCode: 202, wantJoin: true, wantPlace: "60"},
+		{name: "referred", email: "sam@example.com", code: "abcd2345 ", wantCode: 202, wantJoin: true, wantPlace: "60"},
+		{name: "on the waitlist", email: "sam@example.com", status: waitlist.StatusWaiting, wantCode: 202, wantPlace: "3"},
+		{name: "admitted", email: "sam@example.com", status: waitlist.StatusAdmitted, wantCode: 202},
+		{name: "unknown code", email: "sam@example.com", code: "ZZZZ2345", wantCode: 422},
+		{name: "invalid address", email: "sam", wantCode: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					switch aws.ToString(in.TableName) {
+					case "counters":
+						item := dbtest.Item("counter", "waitlist#admitted")
+						item["value"] = num("40")
+						return &dynamodb.GetItemOutput{Item: item}, nil
+					case "waitlist":
+						switch k := in.Key["email"].(*types.AttributeValueMemberS).Value; {
+						case k == "REF#ABCD2345":
+							return &dynamodb.GetItemOutput{Item: dbtest.Item("email", k, "owner", "kim@example.com")}, nil
+						case k == "sam@example.com" && tt.status != "":
+							item := dbtest.Item("email", k, "referral_code", "QRST6789", "status", tt.status)
+							item["position"] = num("43")
+							return &dynamodb.GetItemOutput{Item: item}, nil
+						}
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+				UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					return &dynamodb.UpdateItemOutput{Attributes: db.Item{"value": num("100")}}, nil
+				},
+			}
+			queue := &fakeSQS{}
+			cfg := &config.Config{WaitlistTableName: "waitlist", CounterTableName: "counters"}
+			h := &Handler{
+				Waitlist: waitlist.NewStore(m.Client(), cfg),
+				Email:    &email.Queue{SQS: queue, URL: "https://sqs.example/email"},
+				Limiter:  &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+				Config:   cfg,
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.email, tt.code))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantCode, resp.Body)
+			}
+			joined := false
+			for _, op := range m.Ops() {
+				joined = joined || op == "TransactWriteItems"
+			}
+			if joined != tt.wantJoin {
+				t.Errorf("ops = %v, want joined %v", m.Ops(), tt.wantJoin)
+			}
+			if tt.wantPlace == "" {
+				if len(queue.sent) != 0 {
+					t.Errorf("sent = %+v, want nothing", queue.sent)
+				}
+				return
+			}
+			if len(queue.sent) != 1 || queue.sent[0].Template != email.TemplateWaitlistJoined || queue.sent[0].To != "sam@example.com" ||
+				queue.sent[0].Data["position"] != tt.wantPlace || queue.sent[0].Data["referral_code"] == "" {
+				t.Errorf("sent = %+v, want place %s mailed", queue.sent, tt.wantPlace)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+// Package linkprovider links a Google or Apple account to a user (POST
+// /users/{user_id}/providers), so they can sign in with it (see package
+// providers).
+//
+// The caller proves they own the provider account with the ID token of a
+// fresh sign-in with it, which Cognito issued to the provider account's own
+// user. That user is deleted and its identity linked to the caller's.
+// Should it have a profile of its own, typically when its address was
+// another than the caller's, it is merged into the caller's, filling the
+// fields the caller left empty, and scheduled for erasure; profiles with
+// friends or followers are never merged, so linking cannot lose them. When
+// its address was the caller's, signing up had already been refused for it
+// and there is nothing to merge.
+package linkprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"                             // aws package
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider" // Cognito user pool client
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/erasure"    // deletion grace period
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/mfa"        // step-up of high-risk operations
+	"troggle-backend/internal/providers"  // linked identity providers
+	"troggle-backend/internal/ratelimit"  // DynamoDB token buckets
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// signInMaxAge bounds the age of the provider sign-in a link is made with,
+// so a leaked token cannot be used for long.
+const signInMaxAge = 10 * time.Minute
+
+// rateLimits bound the tokens one user can try. They can be tuned per stage
+// through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(20),
+	PerUser: ratelimit.PerMinute(5),
+}
+
+var (
+	// ErrInvalidToken refuses provider tokens that do not verify, are not
+	// of a provider sign-in, or are older than signInMaxAge.
+	ErrInvalidToken = apperr.Invalid("INVALID_PROVIDER_TOKEN", "token", "Sign in with the provider again")
+
+	// ErrUnsupportedProvider refuses providers other than Google and Apple.
+	ErrUnsupportedProvider = apperr.Invalid("UNSUPPORTED_PROVIDER", "token", "This provider cannot be linked")
+
+	// ErrLinkedElsewhere refuses provider accounts linked to another user.
+	ErrLinkedElsewhere = &apperr.Error{Kind: apperr.KindConflict, Code: "PROVIDER_LINKED_ELSEWHERE", Message: "This provider account is linked to another user"}
+
+	// ErrSignInRequired refuses direct invocations, which have no user to
+	// link to.
+	ErrSignInRequired = apperr.BadRequest("Linking a provider needs the user's sign-in")
+)
+
+// Request represents the JSON input. API Gateway callers name the account
+// in the path.
+type Request struct {
+	UserID string `json:"user_id"`
+	Token  string `json:"token"` // ID token of the sign-in with the provider
+}
+
+// Link is the response.
+type Link struct {
+	Provider string   `json:"provider"`         // "google" or "apple"
+	LinkedAt string   `json:"linked_at"`        // RFC 3339
+	Merged   []string `json:"merged,omitempty"` // profile fields taken from the provider account
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "linkProvider",
+	Function: "linkProvider",
+	Summary:  "Links a Google or Apple account to a user",
+	Method:   "POST",
+	Path:     "/users/{user_id}/providers",
+	Status:   201,
+	Request:  Request{},
+	Response: Link{},
+}}
+
+// authorize lets callers link to their own account only.
+func authorize(ctx context.Context, r *httpx.Request, userID string) (*auth.Identity, error) {
+	if r.Direct {
+		return nil, ErrSignInRequired
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID || id.TokenUse == auth.TokenUseAPIKey {
+		return nil, apperr.Forbidden("You may only link providers to your own account")
+	}
+	return id, nil
+}
+
+// CognitoAPI is the part of the Cognito user pool API the function uses.
+type CognitoAPI interface {
+	AdminDeleteUser(ctx context.Context, params *cognitoidentityprovider.AdminDeleteUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDeleteUserOutput, error)
+	AdminLinkProviderForUser(ctx context.Context, params *cognitoidentityprovider.AdminLinkProviderForUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminLinkProviderForUserOutput, error)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users    *users.Repository
+	Links    *providers.Store
+	Erasure  *erasure.Store
+	Sessions *sessions.Store
+	Cognito  CognitoAPI
+	Auth     auth.TokenVerifier
+	Limiter  *ratelimit.Limiter
+	Limits   ratelimit.Policy
+	MFA      *mfa.Guard // nil skips the step-up check
+	Audit    *audit.Store
+	Config   *config.Config
+	Now      func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// Cognito user pool.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireUserPool(); err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Users:    users.NewRepository(client, cfg),
+		Links:    providers.NewStore(client, cfg),
+		Erasure:  erasure.NewStore(client, cfg),
+		Sessions: sessions.NewStore(client, cfg),
+		Cognito:  cognitoidentityprovider.NewFromConfig(awsCfg),
+		Auth:     verifier,
+		Limiter:  ratelimit.New(client, cfg),
+		Limits:   limits,
+		MFA:      mfa.NewGuard(client, cfg),
+		Audit:    audit.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. Adding a way
+// to sign in is a high-risk operation: callers with a second factor must
+// have signed in recently.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits), h.MFA.Middleware())
+}
+
+// Handle links the provider account of the token in the body to the caller
+// and answers 201. Provider accounts linked to someone else, or whose
+// profile has relationships, are refused with 409.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.UserID = r.PathParams["user_id"]
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	id, err := authorize(ctx, r, req.UserID)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+	ctx = logging.With(ctx, "user_id", req.UserID)
+	if req.Token == "" {
+		return httpx.Error(apperr.Invalid("REQUIRED", "token", "token is required")), nil
+	}
+
+	source, provider, err := h.verify(ctx, req.Token)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+	if source.Subject == req.UserID {
+		return httpx.Error(providers.ErrAlreadyLinked), nil
+	}
+	cognitoName, _ := providers.CognitoName(provider)
+	subject := source.Providers[0].UserID
+	// Cognito names the users of provider accounts after them; a provider
+	// account linked to a user signs in as that user instead
+	if !strings.EqualFold(source.Username, cognitoName+"_"+subject) {
+		return httpx.Error(ErrLinkedElsewhere), nil
+	}
+
+	user, err := h.Users.Uncached().Get(ctx, req.UserID, nil)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if user == nil {
+		return httpx.Error(apperr.NotFound("User not found")), nil
+	}
+	if providers.Subject(user, provider) != "" {
+		return httpx.Error(providers.ErrAlreadyLinked), nil
+	}
+	// The record of the provider account, if it has one and it is not
+	// deleted, is merged
+	other, err := h.Users.Uncached().Get(ctx, source.Subject, nil)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if err := providers.Mergeable(other); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	now := h.now().UTC().Truncate(time.Second)
+	if err := h.Links.Link(ctx, req.UserID, provider, subject, now); err != nil {
+		if apperr.As(err) != nil {
+			return httpx.Error(err), nil
+		}
+		return httpx.Response{}, err
+	}
+	if err := h.linkCognito(ctx, id.Username, source.Username, cognitoName, subject); err != nil {
+		if _, uerr := h.Links.Unlink(ctx, req.UserID, provider); uerr != nil {
+			slog.ErrorContext(ctx, "Failed to undo the record of a provider link", "provider", provider, logging.Err(uerr))
+		}
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Provider linked", "provider", provider)
+
+	link := Link{Provider: provider, LinkedAt: now.Format(time.RFC3339)}
+	diff := map[string]audit.Change{providers.Attribute + "." + provider: {After: link.LinkedAt}}
+	if other != nil {
+		// The link is made: the rest is logged rather than failing a request
+		// the client cannot retry
+		merged, err := h.Links.Merge(ctx, user, other, now)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to merge the profile of a linked provider account", "source", source.Subject, logging.Err(err))
+		}
+		for name, value := range merged {
+			link.Merged = append(link.Merged, name)
+			diff[name] = audit.Change{After: value}
+		}
+		if _, _, err := h.Erasure.Request(ctx, source.Subject); err != nil {
+			slog.WarnContext(ctx, "Failed to schedule the erasure of a linked provider account", "source", source.Subject, logging.Err(err))
+		}
+	}
+	if _, err := h.Sessions.RevokeAll(ctx, source.Subject); err != nil {
+		slog.WarnContext(ctx, "Failed to revoke the sessions of a linked provider account", "source", source.Subject, logging.Err(err))
+	}
+
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionProviderLink,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      diff,
+	})
+	return httpx.JSON(201, link), nil
+}
+
+// verify returns the identity token asserts and its provider, or
+// ErrInvalidToken or ErrUnsupportedProvider.
+func (h *Handler) verify(ctx context.Context, token string) (*auth.Identity, string, error) {
+	source, err := h.Auth.Verify(ctx, token)
+	if err != nil {
+		slog.InfoContext(ctx, "Refused provider token", logging.Err(err))
+		return nil, "", ErrInvalidToken
+	}
+	if source.TokenUse != "id" || len(source.Providers) != 1 || h.now().Sub(source.AuthTime) > signInMaxAge {
+		return nil, "", ErrInvalidToken
+	}
+	provider, ok := providers.FromCognito(source.Providers[0].Provider)
+	if !ok {
+		return nil, "", ErrUnsupportedProvider
+	}
+	return source, provider, nil
+}
+
+// linkCognito deletes the user pool user of the provider account and links
+// its identity to the user username instead. Cognito refuses links of
+// identities that have a user of their own.
+func (h *Handler) linkCognito(ctx context.Context, username, sourceUsername, cognitoName, subject string) error {
+	_, err := h.Cognito.AdminDeleteUser(ctx, &cognitoidentityprovider.AdminDeleteUserInput{
+		UserPoolId: aws.String(h.Config.UserPoolID),
+		Username:   aws.String(sourceUsername),
+	})
+	var notFound *cognitotypes.UserNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return fmt.Errorf("deleting provider user: %w", err)
+	}
+	_, err = h.Cognito.AdminLinkProviderForUser(ctx, &cognitoidentityprovider.AdminLinkProviderForUserInput{
+		UserPoolId: aws.String(h.Config.UserPoolID),
+		DestinationUser: &cognitotypes.ProviderUserIdentifierType{
+			ProviderName:           aws.String("Cognito"),
+			ProviderAttributeValue: aws.String(username),
+		},
+		SourceUser: &cognitotypes.ProviderUserIdentifierType{
+			ProviderName:           aws.String(cognitoName),
+			ProviderAttributeName:  aws.String("Cognito_Subject"),
+			ProviderAttributeValue: aws.String(subject),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("linking provider user: %w", err)
+	}
+	return nil
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
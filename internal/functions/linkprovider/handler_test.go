@@ -0,0 +1,194 @@
+package linkprovider
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/erasure"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/providers"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/sessions"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the tokens it maps to identities.
+type stubVerifier map[string]*auth.Identity
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	id, ok := v[token]
+	if !ok {
+		return nil, auth.ErrNoToken
+	}
+	return id, nil
+}
+
+// fakeCognito records the users deleted and the links made.
+type fakeCognito struct {
+	deleted []string
+	links   []string // destination/provider/subject
+}
+
+func (f *fakeCognito) AdminDeleteUser(_ context.Context, in *cognitoidentityprovider.AdminDeleteUserInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDeleteUserOutput, error) {
+	f.deleted = append(f.deleted, aws.ToString(in.Username))
+	return &cognitoidentityprovider.AdminDeleteUserOutput{}, nil
+}
+
+func (f *fakeCognito) AdminLinkProviderForUser(_ context.Context, in *cognitoidentityprovider.AdminLinkProviderForUserInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminLinkProviderForUserOutput, error) {
+	f.links = append(f.links, aws.ToString(in.DestinationUser.ProviderAttributeValue)+"/"+aws.ToString(in.SourceUser.ProviderName)+"/"+aws.ToString(in.SourceUser.ProviderAttributeValue))
+	return &cognitoidentityprovider.AdminLinkProviderForUserOutput{}, nil
+}
+
+// apiEvent is an authenticated REST API event linking the provider account
+// of token to userID.
+func apiEvent(userID, token string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"token": token})
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/providers",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           string(body),
+	})
+	return event
+}
+
+// withFriends returns item counting n friends.
+func withFriends(item db.Item, n int) db.Item {
+	item["friend_count"] = &types.AttributeValueMemberN{Value: strconv.Itoa(n)}
+	return item
+}
+
+func TestHandle(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	google := func(sub, username string, signedIn time.Time) *auth.Identity {
+		return &auth.Identity{
+			Subject:   sub,
+			Username:  username,
+			TokenUse:  "id",
+			AuthTime:  signedIn,
+			Providers: []auth.ProviderIdentity{{Provider: "Google", UserID: "g-123"}},
+		}
+	}
+	tests := []struct {
+		name       string
+		userID     string
+		source     *auth.Identity
+		profile    db.Item // of the provider account
+		wantStatus int
+		wantCode   string
+		wantMerged int
+	}{
+		{name: "same address", userID: "u1", source: google("u2", "Google_g-123", now.Add(-time.Minute)), wantStatus: 201},
+		{name: "merges profile", userID: "u1", source: google("u2", "Google_g-123", now.Add(-time.Minute)), profile: dbtest.Item("user_id", "u2", "status", "active", "bio", "Hi"), wantStatus: 201, wantMerged: 1},
+		{name: "profile in use", userID: "u1", source: google("u2", "Google_g-123", now.Add(-time.Minute)), profile: withFriends(dbtest.Item("user_id", "u2", "status", "active"), 1), wantStatus: 409, wantCode: "PROVIDER_ACCOUNT_IN_USE"},
+		{name: "old sign-in", userID: "u1", source: google("u2", "Google_g-123", now.Add(-time.Hour)), wantStatus: 422, wantCode: "INVALID_PROVIDER_TOKEN"},
+		{name: "linked elsewhere", userID: "u1", source: google("u3", "joe", now.Add(-time.Minute)), wantStatus: 409, wantCode: "PROVIDER_LINKED_ELSEWHERE"},
+		{name: "no provider", userID: "u1", source: &auth.Identity{Subject: "u2", TokenUse: "id", AuthTime: now}, wantStatus: 422, wantCode: "INVALID_PROVIDER_TOKEN"},
+		{name: "other user", userID: "u9", source: google("u2", "Google_g-123", now.Add(-time.Minute)), wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					if aws.ToString(in.TableName) != "users" {
+						return &dynamodb.GetItemOutput{}, nil
+					}
+					switch in.Key["user_id"].(*types.AttributeValueMemberS).Value {
+					case "u1":
+						return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1", "status", "active", "display_name", "Jane", "bio", "")}, nil
+					case "u2":
+						return &dynamodb.GetItemOutput{Item: tt.profile}, nil
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+			}
+			cognito := &fakeCognito{}
+			cfg := &config.Config{UserTableName: "users", SessionTableName: "sessions", AuditTableName: "audit", UserPoolID: "pool"}
+			h := &Handler{
+				Users:    users.NewRepository(m.Client(), cfg),
+				Links:    providers.NewStore(m.Client(), cfg),
+				Erasure:  erasure.NewStore(m.Client(), cfg),
+				Sessions: sessions.NewStore(m.Client(), cfg),
+				Cognito:  cognito,
+				Auth: stubVerifier{
+					"valid":  {Subject: "u1", Username: "jane", TokenUse: "access", AuthTime: now},
+					"google": tt.source,
+				},
+				Limiter: &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+				Audit:   audit.NewStore(m.Client(), cfg),
+				Config:  cfg,
+				Now:     func() time.Time { return now },
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.userID, "google"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 201 {
+				var body struct {
+					Error struct{ Code string }
+				}
+				if err := json.Unmarshal([]byte(resp.Body), &body); err != nil || (tt.wantCode != "" && body.Error.Code != tt.wantCode) {
+					t.Errorf("body = %s, want code %s", resp.Body, tt.wantCode)
+				}
+				if len(cognito.deleted)+len(cognito.links) != 0 {
+					t.Errorf("Cognito = %+v, want nothing changed", cognito)
+				}
+				return
+			}
+
+			var link Link
+			if err := json.Unmarshal([]byte(resp.Body), &link); err != nil {
+				t.Fatal(err)
+			}
+			if link.Provider != providers.Google || len(link.Merged) != tt.wantMerged {
+				t.Errorf("link = %+v", link)
+			}
+			if len(cognito.deleted) != 1 || cognito.deleted[0] != "Google_g-123" || len(cognito.links) != 1 || cognito.links[0] != "jane/Google/g-123" {
+				t.Errorf("Cognito = %+v, want the provider user deleted and linked to jane", cognito)
+			}
+			var recorded, scheduled, audited bool
+			for _, c := range m.Calls {
+				switch in := c.Input.(type) {
+				case *dynamodb.UpdateItemInput:
+					key, ok := in.Key["user_id"].(*types.AttributeValueMemberS)
+					if !ok {
+						continue
+					}
+					switch key.Value {
+					case "u1":
+						_, ok := in.ExpressionAttributeValues[":sub"]
+						recorded = recorded || ok
+					case "u2":
+						scheduled = true
+					}
+				case *dynamodb.PutItemInput:
+					audited = audited || aws.ToString(in.TableName) == "audit"
+				}
+			}
+			if !recorded || !audited {
+				t.Errorf("recorded = %v, audited = %v, want both", recorded, audited)
+			}
+			if scheduled != (tt.profile != nil) {
+				t.Errorf("erasure of the provider account scheduled = %v", scheduled)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Package listachievements returns the achievements of a user with their
+// progress (GET /users/{user_id}/achievements). Achievements are visible to
+// every signed-in user, like friends; hidden ones appear once unlocked. See
+// package achievements.
+package listachievements
+
+import (
+	"context"
+	"fmt"
+
+	"troggle-backend/internal/achievements" // badge engine
+	"troggle-backend/internal/api"          // API route declarations
+	"troggle-backend/internal/apperr"       // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"         // Cognito JWT verification
+	"troggle-backend/internal/config"       // environment-driven settings
+	"troggle-backend/internal/db"           // shared DynamoDB client
+	"troggle-backend/internal/httpx"        // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"     // session table access
+	"troggle-backend/internal/validation"   // input normalization and validation
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the user in the path.
+type Request struct {
+	UserID string `json:"user_id"`
+}
+
+// Response represents the JSON output
+type Response struct {
+	Achievements []achievements.Achievement `json:"achievements"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "listAchievements",
+	Function: "listAchievements",
+	Summary:  "Lists the achievements of a user",
+	Method:   "GET",
+	Path:     "/users/{user_id}/achievements",
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Achievements *achievements.Store
+	Auth         auth.TokenVerifier
+	Config       *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{Achievements: achievements.NewStore(client, cfg), Auth: verifier, Config: cfg}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle returns the achievements of the user, in achievement ID order.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	list, err := h.Achievements.List(ctx, req.UserID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSON(200, Response{Achievements: list}), nil
+}
@@ -0,0 +1,92 @@
+package listachievements
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/achievements"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+)
+
+// apiEvent is a GET /users/{user_id}/achievements REST API event.
+func apiEvent(userID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "GET",
+		"path":           "/users/" + userID + "/achievements",
+		"pathParameters": map[string]string{"user_id": userID},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantUser   string // whose progress is queried
+		wantBody   string
+	}{
+		{
+			name:       "own",
+			payload:    apiEvent("u1"),
+			wantStatus: 200, wantUser: "u1", wantBody: `"achievement_id":"first_win","name":"First win"`,
+		},
+		{
+			name:       "of another user",
+			payload:    apiEvent("u2"),
+			wantStatus: 200, wantUser: "u2", wantBody: `"progress":1,"unlocked_at":"2026-10-01T00:00:00Z"`,
+		},
+		{name: "bad user", payload: apiEvent("u#1"), wantStatus: 422},
+		{
+			name:       "direct",
+			payload:    json.RawMessage(`{"user_id":"u3"}`),
+			wantStatus: 200, wantUser: "u3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queried string
+			m := &dbtest.Mock{
+				ScanFunc: func(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+					def := dbtest.Item("achievement_id", "first_win", "name", "First win", "trigger", "GameWon")
+					def["goal"] = &types.AttributeValueMemberN{Value: "1"}
+					return &dynamodb.ScanOutput{Items: []db.Item{def}}, nil
+				},
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					queried = in.ExpressionAttributeValues[":user_id"].(*types.AttributeValueMemberS).Value
+					item := dbtest.Item("user_id", queried, "achievement_id", "first_win", "unlocked_at", "2026-10-01T00:00:00Z")
+					item["progress"] = &types.AttributeValueMemberN{Value: "1"}
+					return &dynamodb.QueryOutput{Items: []db.Item{item}}, nil
+				},
+			}
+			h := &Handler{
+				Achievements: &achievements.Store{DB: m.Client(), Table: "achievements", ProgressTable: "progress"},
+				Config:       &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if queried != tt.wantUser {
+				t.Errorf("queried %q, want %q", queried, tt.wantUser)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
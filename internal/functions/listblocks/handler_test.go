@@ -0,0 +1,73 @@
+package listblocks
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/pagination"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/signing"
+)
+
+// apiEvent is a GET /users/{user_id}/<list> REST API event.
+func apiEvent(userID, list string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "GET",
+		"path":           "/users/" + userID + "/" + list,
+		"pathParameters": map[string]string{"user_id": userID},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantPrefix string // of the queried edges
+		wantFilter bool   // only blocks by the user
+	}{
+		{name: "blocks", payload: apiEvent("u1", "blocks"), wantStatus: 200, wantPrefix: "BLOCK#", wantFilter: true},
+		{name: "mutes", payload: apiEvent("u1", "mutes"), wantStatus: 200, wantPrefix: "MUTE#"},
+		{name: "another user's", payload: apiEvent("u2", "blocks"), wantStatus: 403},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u2","mutes":true}`), wantStatus: 200, wantPrefix: "MUTE#"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prefix string
+			var filtered bool
+			m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				prefix = in.ExpressionAttributeValues[":type"].(*types.AttributeValueMemberS).Value
+				filtered = aws.ToString(in.FilterExpression) != ""
+				return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u1", "edge", prefix+"u3")}}, nil
+			}}
+			h := &Handler{Graph: &relationships.Store{DB: m.Client(), Table: "relationships"}, Cursors: pagination.NewCodec(signing.StaticKey("test")), Config: &config.Config{}}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if prefix != tt.wantPrefix || filtered != tt.wantFilter {
+				t.Errorf("queried %q (filtered %v), want %q (%v)", prefix, filtered, tt.wantPrefix, tt.wantFilter)
+			}
+			if tt.wantStatus == 200 && !strings.Contains(resp.Body, `"user_id":"u3"`) {
+				t.Errorf("body = %s", resp.Body)
+			}
+		})
+	}
+}
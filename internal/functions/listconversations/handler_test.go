@@ -0,0 +1,87 @@
+package listconversations
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/messages"
+	"troggle-backend/internal/pagination"
+	"troggle-backend/internal/signing"
+)
+
+// apiEvent is a GET /users/{user_id}/conversations REST API event.
+func apiEvent(userID string, query map[string]string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "GET",
+		"path":                  "/users/" + userID + "/conversations",
+		"pathParameters":        map[string]string{"user_id": userID},
+		"queryStringParameters": query,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	last := dbtest.Item("conversation_id", "u1#u2", "entry", "MEMBER#u1", "inbox", "u1", "last_message_at", "2026-10-01T12:00:00.000000Z")
+	cursors := pagination.NewCodec(signing.StaticKey("test"))
+	token, err := cursors.Next(context.Background(), last, pagination.Filters{"user_id": "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"user_id":"u1"}`))
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantStart  bool
+	}{
+		{name: "first page", payload: apiEvent("u1", nil), wantStatus: 200},
+		{name: "next page", payload: apiEvent("u1", map[string]string{"next_token": token}), wantStatus: 200, wantStart: true},
+		{name: "unsigned token", payload: apiEvent("u1", map[string]string{"next_token": unsigned}), wantStatus: 422},
+		{name: "another user's token", payload: apiEvent("u2", map[string]string{"next_token": token}), wantStatus: 403},
+		{name: "bad limit", payload: apiEvent("u1", map[string]string{"limit": "500"}), wantStatus: 422},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u2"}`), wantStatus: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var started, descending bool
+			m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				started = in.ExclusiveStartKey != nil
+				descending = !aws.ToBool(in.ScanIndexForward)
+				item := dbtest.Item("other_id", "u2", "last_message", "hi", "last_sender_id", "u2", "last_message_at", "2026-10-01T12:00:00.000000Z")
+				item["unread_count"] = &types.AttributeValueMemberN{Value: "3"}
+				return &dynamodb.QueryOutput{Items: []db.Item{item}, LastEvaluatedKey: last}, nil
+			}}
+			h := &Handler{Messages: &messages.Store{DB: m.Client(), Table: "messages", InboxIndex: "inbox-index"}, Cursors: cursors, Config: &config.Config{}}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+			if started != tt.wantStart || !descending {
+				t.Errorf("query started %v (want %v), descending %v", started, tt.wantStart, descending)
+			}
+			if !strings.Contains(resp.Body, `"unread_count":3`) || !strings.Contains(resp.Body, `"next_token"`) {
+				t.Errorf("body = %s", resp.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Package listfollowers pages through the followers of a user (GET
+// /users/{user_id}/followers) and the users they follow (GET
+// /users/{user_id}/following). Both are visible to every signed-in user.
+// See package relationships.
+package listfollowers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/pagination"    // signed next tokens
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+const (
+	// defaultLimit and maxLimit bound the page size.
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the user in the path and the rest as query string
+// parameters.
+type Request struct {
+	UserID    string `json:"user_id"`
+	Following bool   `json:"following"` // list the users followed instead of the followers
+	Limit     string `json:"limit"`
+	NextToken string `json:"next_token"`
+}
+
+// Response represents the JSON output
+type Response struct {
+	Users     []relationships.Edge `json:"users"`
+	NextToken string               `json:"next_token,omitempty"` // absent on the last page
+}
+
+// Shape returns resp in the shape of v: from version 2 on, a page of items
+// like every other list.
+func (resp Response) Shape(v httpx.Version) any {
+	if v < httpx.V2 {
+		return resp
+	}
+	return httpx.Page[relationships.Edge]{Items: resp.Users, NextToken: resp.NextToken}
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "listFollowers",
+		Function: "listFollowers",
+		Summary:  "Pages through the followers of a user",
+		Method:   "GET",
+		Path:     "/users/{user_id}/followers",
+		Query:    []string{"limit", "next_token"},
+		Response: Response{},
+	},
+	{
+		Name:     "listFollowing",
+		Function: "listFollowers",
+		Summary:  "Pages through the users a user follows",
+		Method:   "GET",
+		Path:     "/users/{user_id}/following",
+		Query:    []string{"limit", "next_token"},
+		Response: Response{},
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Graph   *relationships.Store
+	Auth    auth.TokenVerifier
+	Cursors *pagination.Codec
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cursors, err := pagination.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{Graph: relationships.NewStore(client, cfg), Auth: verifier, Cursors: cursors, Config: cfg}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle returns one page of followers or followed users, ordered by user
+// ID.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{
+		UserID:    r.PathParams["user_id"],
+		Following: path.Base(r.Path) == "following",
+		Limit:     r.Query("limit"),
+		NextToken: r.Query("next_token"),
+	}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	typ := relationships.Follower
+	if req.Following {
+		typ = relationships.Following
+	}
+
+	limit := int32(defaultLimit)
+	if req.Limit != "" {
+		n, err := strconv.Atoi(req.Limit)
+		if err != nil || n < 1 || n > maxLimit {
+			return httpx.Error(apperr.Invalid("INVALID_LIMIT", "limit", fmt.Sprintf("limit must be between 1 and %d", maxLimit))), nil
+		}
+		limit = int32(n)
+	}
+	filters := pagination.Filters{"user_id": req.UserID, "type": typ}
+	start, err := h.Cursors.Start(ctx, req.NextToken, filters)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	edges, next, err := h.Graph.List(ctx, req.UserID, typ, limit, start)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	resp := Response{Users: edges}
+	if resp.NextToken, err = h.Cursors.Next(ctx, next, filters); err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSONFor(r, 200, resp), nil
+}
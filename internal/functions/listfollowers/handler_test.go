@@ -0,0 +1,85 @@
+package listfollowers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/pagination"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/signing"
+)
+
+// apiEvent is a GET /users/{user_id}/<collection> REST API event.
+func apiEvent(userID, collection string, query map[string]string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "GET",
+		"path":                  "/users/" + userID + "/" + collection,
+		"pathParameters":        map[string]string{"user_id": userID},
+		"queryStringParameters": query,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	cursors := pagination.NewCodec(signing.StaticKey("test"))
+	token := func(typ string) string {
+		s, _ := cursors.Next(context.Background(), dbtest.Item("user_id", "u2", "edge", typ+"#u3"), pagination.Filters{"user_id": "u2", "type": typ})
+		return s
+	}
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		more       bool
+		wantStatus int
+		wantPrefix string // of the queried edges
+		wantBody   string
+	}{
+		{name: "followers", payload: apiEvent("u2", "followers", nil), wantStatus: 200, wantPrefix: "FOLLOWER#", wantBody: `"users":[{"user_id":"u3","type":"FOLLOWER"`},
+		{name: "following", payload: apiEvent("u2", "following", nil), more: true, wantStatus: 200, wantPrefix: "FOLLOWING#", wantBody: `"next_token":"`},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u2","following":true}`), wantStatus: 200, wantPrefix: "FOLLOWING#"},
+		{name: "next page", payload: apiEvent("u2", "followers", map[string]string{"next_token": token(relationships.Follower)}), wantStatus: 200, wantPrefix: "FOLLOWER#"},
+		{name: "token of another listing", payload: apiEvent("u2", "followers", map[string]string{"next_token": token(relationships.Following)}), wantStatus: 422, wantBody: "INVALID_NEXT_TOKEN"},
+		{name: "bad token", payload: apiEvent("u2", "followers", map[string]string{"next_token": "x"}), wantStatus: 422, wantBody: "INVALID_NEXT_TOKEN"},
+		{name: "bad limit", payload: apiEvent("u2", "followers", map[string]string{"limit": "0"}), wantStatus: 422, wantBody: "INVALID_LIMIT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prefix string
+			m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				prefix = in.ExpressionAttributeValues[":type"].(*types.AttributeValueMemberS).Value
+				out := &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", "u2", "edge", prefix+"u3")}}
+				if tt.more {
+					out.LastEvaluatedKey = dbtest.Item("user_id", "u2", "edge", prefix+"u3")
+				}
+				return out, nil
+			}}
+			h := &Handler{Graph: &relationships.Store{DB: m.Client(), Table: "relationships"}, Cursors: cursors, Config: &config.Config{}}
+
+			resp, err := httpx.Adapt(h.Handle)(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if prefix != tt.wantPrefix {
+				t.Errorf("queried %q, want %q", prefix, tt.wantPrefix)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+			if tt.wantStatus == 200 && !tt.more && strings.Contains(resp.Body, "next_token") {
+				t.Errorf("body = %s, want no next_token on the last page", resp.Body)
+			}
+		})
+	}
+}
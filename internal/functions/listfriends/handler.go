@@ -0,0 +1,234 @@
+// Package listfriends pages through the friends of a user (GET
+// /users/{user_id}/friends) and, for that user only, their pending friend
+// requests (GET /users/{user_id}/friend-requests?direction=in|out). See
+// package relationships.
+package listfriends
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/pagination"    // signed next tokens
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+const (
+	// defaultLimit and maxLimit bound the page size.
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// profileFields are the attributes of the listed users shown with them.
+var profileFields = []string{"username", "display_name", "avatar_url"}
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the user in the path and the rest as query string
+// parameters.
+type Request struct {
+	UserID    string `json:"user_id"`
+	Requests  bool   `json:"requests"`  // list friend requests instead of friends
+	Direction string `json:"direction"` // of requests: "in" (the default) or "out"
+	Limit     string `json:"limit"`
+	NextToken string `json:"next_token"`
+}
+
+// Response represents the JSON output
+type Response struct {
+	Users     []relationships.Edge `json:"users"`
+	NextToken string               `json:"next_token,omitempty"` // absent on the last page
+}
+
+// Shape returns resp in the shape of v: from version 2 on, a page of items
+// like every other list.
+func (resp Response) Shape(v httpx.Version) any {
+	if v < httpx.V2 {
+		return resp
+	}
+	return httpx.Page[relationships.Edge]{Items: resp.Users, NextToken: resp.NextToken}
+}
+
+// edgeType returns the type of the edges req lists.
+func edgeType(req Request) (string, error) {
+	if !req.Requests {
+		return relationships.Friend, nil
+	}
+	switch req.Direction {
+	case "", "in":
+		return relationships.RequestIn, nil
+	case "out":
+		return relationships.RequestOut, nil
+	}
+	return "", apperr.Invalid("INVALID_DIRECTION", "direction", "direction must be in or out")
+}
+
+// authorize lets callers see their own friend requests only, unless they
+// are admins. Friends are visible to every signed-in user. Direct
+// invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, req Request) error {
+	if r.Direct || !req.Requests {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != req.UserID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only see your own friend requests")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "listFriends",
+		Function: "listFriends",
+		Summary:  "Pages through the friends of a user",
+		Method:   "GET",
+		Path:     "/users/{user_id}/friends",
+		Query:    []string{"limit", "next_token"},
+		Response: Response{},
+	},
+	{
+		Name:     "listFriendRequests",
+		Function: "listFriends",
+		Summary:  "Pages through the pending friend requests of a user",
+		Method:   "GET",
+		Path:     "/users/{user_id}/friend-requests",
+		Query:    []string{"direction", "limit", "next_token"},
+		Response: Response{},
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Graph   *relationships.Store
+	Users   *users.Repository
+	Auth    auth.TokenVerifier
+	Cursors *pagination.Codec
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cursors, err := pagination.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Graph:   relationships.NewStore(client, cfg),
+		Users:   users.NewRepository(client, cfg),
+		Auth:    verifier,
+		Cursors: cursors,
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle returns one page of friends or friend requests, ordered by user ID,
+// with the profile of each user. Users gone since are listed without one.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{
+		UserID:    r.PathParams["user_id"],
+		Requests:  path.Base(r.Path) == "friend-requests",
+		Direction: r.Query("direction"),
+		Limit:     r.Query("limit"),
+		NextToken: r.Query("next_token"),
+	}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	typ, err := edgeType(req)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	limit := int32(defaultLimit)
+	if req.Limit != "" {
+		n, err := strconv.Atoi(req.Limit)
+		if err != nil || n < 1 || n > maxLimit {
+			return httpx.Error(apperr.Invalid("INVALID_LIMIT", "limit", fmt.Sprintf("limit must be between 1 and %d", maxLimit))), nil
+		}
+		limit = int32(n)
+	}
+	filters := pagination.Filters{"user_id": req.UserID, "type": typ}
+	start, err := h.Cursors.Start(ctx, req.NextToken, filters)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	edges, next, err := h.Graph.List(ctx, req.UserID, typ, limit, start)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if err := h.hydrate(ctx, edges); err != nil {
+		return httpx.Response{}, err
+	}
+	resp := Response{Users: edges}
+	if resp.NextToken, err = h.Cursors.Next(ctx, next, filters); err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSONFor(r, 200, resp), nil
+}
+
+// hydrate fills in the profiles of the other users of edges, read in
+// batches.
+func (h *Handler) hydrate(ctx context.Context, edges []relationships.Edge) error {
+	ids := make([]string, len(edges))
+	for i, e := range edges {
+		ids[i] = e.OtherID
+	}
+	profiles, err := h.Users.GetMany(ctx, ids, profileFields)
+	if err != nil {
+		return err
+	}
+	for i, e := range edges {
+		p := profiles[e.OtherID]
+		edges[i].Username = str(p, "username")
+		edges[i].DisplayName = str(p, "display_name")
+		edges[i].AvatarURL = str(p, "avatar_url")
+	}
+	return nil
+}
+
+// str returns the string attribute name of item, or "".
+func str(item db.Item, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
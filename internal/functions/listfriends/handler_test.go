@@ -0,0 +1,133 @@
+package listfriends
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/pagination"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/signing"
+	"troggle-backend/internal/users"
+)
+
+// apiEvent is a GET /users/{user_id}/<collection> REST API event.
+func apiEvent(userID, collection string, query map[string]string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "GET",
+		"path":                  "/users/" + userID + "/" + collection,
+		"pathParameters":        map[string]string{"user_id": userID},
+		"queryStringParameters": query,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	cursors := pagination.NewCodec(signing.StaticKey("test"))
+	token := func(userID, typ string) string {
+		s, _ := cursors.Next(context.Background(), dbtest.Item("user_id", userID, "edge", typ+"#u3"), pagination.Filters{"user_id": userID, "type": typ})
+		return s
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"user_id":"u2","edge":"FRIEND#u3"}`))
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantPrefix string // of the queried edges
+		wantBody   string
+	}{
+		{
+			name:       "friends of another user",
+			payload:    apiEvent("u2", "friends", map[string]string{"limit": "1"}),
+			wantStatus: 200, wantPrefix: "FRIEND#", wantBody: `"next_token":"`,
+		},
+		{
+			name:       "incoming requests",
+			payload:    apiEvent("u1", "friend-requests", nil),
+			wantStatus: 200, wantPrefix: "REQUEST_IN#", wantBody: `"users":[{"user_id":"u3","type":"REQUEST_IN"`,
+		},
+		{
+			name:       "profiles",
+			payload:    apiEvent("u1", "friends", nil),
+			wantStatus: 200, wantPrefix: "FRIEND#", wantBody: `"username":"jane","display_name":"Jane"}`,
+		},
+		{
+			name:       "version 2",
+			payload:    json.RawMessage(`{"httpMethod":"GET","path":"/v2/users/u1/friend-requests","pathParameters":{"user_id":"u1"}}`),
+			wantStatus: 200, wantPrefix: "REQUEST_IN#", wantBody: `"items":[{"user_id":"u3"`,
+		},
+		{
+			name:       "outgoing requests",
+			payload:    apiEvent("u1", "friend-requests", map[string]string{"direction": "out"}),
+			wantStatus: 200, wantPrefix: "REQUEST_OUT#",
+		},
+		{name: "requests of another user", payload: apiEvent("u2", "friend-requests", nil), wantStatus: 403},
+		{name: "bad direction", payload: apiEvent("u1", "friend-requests", map[string]string{"direction": "up"}), wantStatus: 422},
+		{name: "limit too large", payload: apiEvent("u1", "friends", map[string]string{"limit": "101"}), wantStatus: 422},
+		{
+			name:       "token of another listing",
+			payload:    apiEvent("u1", "friends", map[string]string{"next_token": token("u1", "FOLLOWER")}),
+			wantStatus: 422, wantBody: "INVALID_NEXT_TOKEN",
+		},
+		{
+			name:       "unsigned token",
+			payload:    apiEvent("u1", "friends", map[string]string{"next_token": unsigned}),
+			wantStatus: 422, wantBody: "INVALID_NEXT_TOKEN",
+		},
+		{
+			name:       "next page",
+			payload:    apiEvent("u1", "friends", map[string]string{"next_token": token("u1", "FRIEND")}),
+			wantStatus: 200, wantPrefix: "FRIEND#",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prefix string
+			m := &dbtest.Mock{
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					prefix = in.ExpressionAttributeValues[":type"].(*types.AttributeValueMemberS).Value
+					return &dynamodb.QueryOutput{
+						Items:            []db.Item{dbtest.Item("user_id", "u1", "edge", prefix+"u3")},
+						LastEvaluatedKey: dbtest.Item("user_id", "u1", "edge", prefix+"u3"),
+					}, nil
+				},
+				BatchGetItemFunc: func(in *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+					return &dynamodb.BatchGetItemOutput{Responses: map[string][]db.Item{
+						"users": {dbtest.Item("user_id", "u3", "username", "jane", "display_name", "Jane")},
+					}}, nil
+				},
+			}
+			h := &Handler{
+				Graph:   &relationships.Store{DB: m.Client(), Table: "relationships"},
+				Users:   &users.Repository{DB: m.Client(), Table: "users"},
+				Cursors: cursors,
+				Config:  &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if prefix != tt.wantPrefix {
+				t.Errorf("queried %q, want %q", prefix, tt.wantPrefix)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package listmessages
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/messages"
+	"troggle-backend/internal/pagination"
+	"troggle-backend/internal/signing"
+)
+
+// apiEvent is a GET /users/{user_id}/conversations/{other_id}/messages REST
+// API event.
+func apiEvent(userID, otherID string, query map[string]string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "GET",
+		"path":                  "/users/" + userID + "/conversations/" + otherID + "/messages",
+		"pathParameters":        map[string]string{"user_id": userID, "other_id": otherID},
+		"queryStringParameters": query,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	cursors := pagination.NewCodec(signing.StaticKey("test"))
+	token := func(conversationID string) string {
+		key := dbtest.Item("conversation_id", conversationID, "entry", "MSG#2026-10-01T12:00:00.000000Z#ab")
+		s, err := cursors.Next(context.Background(), key, pagination.Filters{"conversation_id": conversationID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantID     string // of the queried conversation
+	}{
+		{name: "own", payload: apiEvent("u1", "u2", nil), wantStatus: 200, wantID: "u1#u2"},
+		{name: "next page", payload: apiEvent("u1", "u2", map[string]string{"next_token": token("u1#u2")}), wantStatus: 200, wantID: "u1#u2"},
+		{name: "token of another conversation", payload: apiEvent("u1", "u2", map[string]string{"next_token": token("u1#u3")}), wantStatus: 422},
+		{name: "another user's", payload: apiEvent("u3", "u4", nil), wantStatus: 403},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u3","other_id":"u4"}`), wantStatus: 200, wantID: "u3#u4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queried string
+			m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				queried = in.ExpressionAttributeValues[":id"].(*types.AttributeValueMemberS).Value
+				return &dynamodb.QueryOutput{Items: []db.Item{
+					dbtest.Item("message_id", "ab", "sender_id", "u1", "body", "hi", "sent_at", "2026-10-01T12:00:00.000000Z"),
+				}}, nil
+			}}
+			h := &Handler{Messages: &messages.Store{DB: m.Client(), Table: "messages"}, Cursors: cursors, Config: &config.Config{}}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if queried != tt.wantID {
+				t.Errorf("queried conversation %q, want %q", queried, tt.wantID)
+			}
+			if tt.wantStatus == 200 && (!strings.Contains(resp.Body, `"body":"hi"`) || strings.Contains(resp.Body, "next_token")) {
+				t.Errorf("body = %s", resp.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Package listnotifications pages through the notification inbox of a user
+// (GET /users/{user_id}/notifications), unread notifications first, each
+// part newest first. Only the user and admins may read it. See package
+// notifications.
+package listnotifications
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/notifications" // in-app notification inbox
+	"troggle-backend/internal/pagination"    // signed next tokens
+	"troggle-backend/internal/policies"      // terms and privacy policy acceptance
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+const (
+	// defaultLimit and maxLimit bound the page size.
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the user in the path and the rest as query string
+// parameters.
+type Request struct {
+	UserID    string `json:"user_id"`
+	Limit     string `json:"limit"`
+	NextToken string `json:"next_token"`
+}
+
+// Response represents the JSON output
+type Response struct {
+	Notifications []notifications.Notification `json:"notifications"`
+	NextToken     string                       `json:"next_token,omitempty"` // absent on the last page
+}
+
+// Shape returns resp in the shape of v: from version 2 on, a page of items
+// like every other list.
+func (resp Response) Shape(v httpx.Version) any {
+	if v < httpx.V2 {
+		return resp
+	}
+	return httpx.Page[notifications.Notification]{Items: resp.Notifications, NextToken: resp.NextToken}
+}
+
+// authorize lets callers read their own notifications only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only read your own notifications")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "listNotifications",
+	Function: "listNotifications",
+	Summary:  "Pages through the notifications of a user, latest first",
+	Method:   "GET",
+	Path:     "/users/{user_id}/notifications",
+	Query:    []string{"limit", "next_token"},
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Notifications *notifications.Store
+	Auth          auth.TokenVerifier
+	Policies      *policies.Guard // nil leaves pending policies unflagged
+	Cursors       *pagination.Codec
+	Config        *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cursors, err := pagination.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Notifications: notifications.NewStore(client, cfg),
+		Auth:          verifier,
+		Policies:      policies.NewGuard(client, cfg),
+		Cursors:       cursors,
+		Config:        cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. Clients poll
+// the inbox, so its responses flag the policies the caller must accept.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Policies.Middleware())
+}
+
+// Handle returns one page of notifications. An empty inbox is an empty
+// page, not an error.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{
+		UserID:    r.PathParams["user_id"],
+		Limit:     r.Query("limit"),
+		NextToken: r.Query("next_token"),
+	}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	limit := int32(defaultLimit)
+	if req.Limit != "" {
+		n, err := strconv.Atoi(req.Limit)
+		if err != nil || n < 1 || n > maxLimit {
+			return httpx.Error(apperr.Invalid("INVALID_LIMIT", "limit", fmt.Sprintf("limit must be between 1 and %d", maxLimit))), nil
+		}
+		limit = int32(n)
+	}
+	filters := pagination.Filters{"user_id": req.UserID}
+	start, err := h.Cursors.Start(ctx, req.NextToken, filters)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	list, next, err := h.Notifications.List(ctx, req.UserID, limit, start)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	resp := Response{Notifications: list}
+	if resp.NextToken, err = h.Cursors.Next(ctx, next, filters); err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSONFor(r, 200, resp), nil
+}
@@ -0,0 +1,92 @@
+package listnotifications
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/notifications"
+	"troggle-backend/internal/pagination"
+	"troggle-backend/internal/signing"
+)
+
+// apiEvent is a GET /users/{user_id}/notifications REST API event.
+func apiEvent(userID string, query map[string]string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "GET",
+		"path":                  "/users/" + userID + "/notifications",
+		"pathParameters":        map[string]string{"user_id": userID},
+		"queryStringParameters": query,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	last := dbtest.Item("user_id", "u1", "notification_id", "20261014T120000Z-e1", "inbox", "1#20261014T120000Z-e1")
+	cursors := pagination.NewCodec(signing.StaticKey("test"))
+	token, err := cursors.Next(context.Background(), last, pagination.Filters{"user_id": "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"user_id":"u1"}`))
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		more       bool // the query has another page
+		wantStatus int
+		wantUser   string // of the query
+		wantBody   string
+	}{
+		{name: "own", payload: apiEvent("u1", nil), wantStatus: 200, wantUser: "u1", wantBody: `"title":"Hi"`},
+		{name: "first of pages", payload: apiEvent("u1", map[string]string{"limit": "1"}), more: true, wantStatus: 200, wantUser: "u1", wantBody: `"next_token":`},
+		{name: "next page", payload: apiEvent("u1", map[string]string{"next_token": token}), wantStatus: 200, wantUser: "u1"},
+		{name: "unsigned token", payload: apiEvent("u1", map[string]string{"next_token": unsigned}), wantStatus: 422},
+		{name: "token of another user", payload: apiEvent("u2", map[string]string{"next_token": token}), wantStatus: 403},
+		{name: "bad limit", payload: apiEvent("u1", map[string]string{"limit": "0"}), wantStatus: 422},
+		{name: "another user's", payload: apiEvent("u2", nil), wantStatus: 403},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u2"}`), wantStatus: 200, wantUser: "u2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queried string
+			m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				queried = in.ExpressionAttributeValues[":user_id"].(*types.AttributeValueMemberS).Value
+				if *in.ScanIndexForward {
+					t.Error("query is not newest first")
+				}
+				item := dbtest.Item("user_id", queried, "notification_id", "20261014T120000Z-e1", "type", "system", "title", "Hi", "created_at", "2026-10-14T12:00:00Z")
+				out := &dynamodb.QueryOutput{Items: []db.Item{item}}
+				if tt.more {
+					out.LastEvaluatedKey = last
+				}
+				return out, nil
+			}}
+			h := &Handler{Notifications: &notifications.Store{DB: m.Client(), Table: "notifications", InboxIndex: "inbox-index"}, Cursors: cursors, Config: &config.Config{}}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if queried != tt.wantUser {
+				t.Errorf("queried %q, want %q", queried, tt.wantUser)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Package listsessions lists the active sessions of a user, so they can see
+// where they are signed in.
+package listsessions
+
+import (
+	"context"
+	"fmt"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers list their own sessions, or pass ?user_id= as admins.
+type Request struct {
+	UserID string `json:"user_id"`
+}
+
+// Session is a listed session.
+type Session struct {
+	sessions.Session
+	Current bool `json:"current,omitempty"` // the session of the caller's token
+}
+
+// Response is the JSON output.
+type Response struct {
+	Sessions []Session `json:"sessions"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "listSessions",
+	Function: "listSessions",
+	Summary:  "Lists the active sessions of the caller",
+	Method:   "GET",
+	Path:     "/sessions",
+	Query:    []string{"user_id"},
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Sessions *sessions.Store
+	Auth     auth.TokenVerifier
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{Sessions: sessions.NewStore(client, cfg), Auth: verifier, Config: cfg}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle returns the active sessions of the user.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	var current string
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	} else {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req.UserID, current = id.Subject, id.SessionID
+		if other := r.Query("user_id"); other != "" && other != id.Subject {
+			if !authz.Allowed(id, authz.SessionsManage) {
+				return httpx.Error(apperr.Forbidden("You may only list your own sessions")), nil
+			}
+			req.UserID, current = other, ""
+		}
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	active, err := h.Sessions.ListActive(ctx, req.UserID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	resp := Response{Sessions: make([]Session, len(active))}
+	for i, s := range active {
+		resp.Sessions[i] = Session{Session: s, Current: current != "" && s.SessionID == current}
+	}
+	return httpx.JSON(200, resp), nil
+}
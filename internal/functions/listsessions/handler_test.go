@@ -0,0 +1,160 @@
+package listsessions
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/sessions"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" || v.id == nil {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a REST API event listing sessions, of userID if set.
+func apiEvent(userID string) json.RawMessage {
+	query := map[string]string{}
+	if userID != "" {
+		query["user_id"] = userID
+	}
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "GET",
+		"path":                  "/sessions",
+		"queryStringParameters": query,
+		"headers":               map[string]string{"Authorization": "Bearer valid"},
+	})
+	return event
+}
+
+// storedSessions are the sessions of any user: s1 and s2 are active, s3 was
+// revoked and s4 has expired.
+func storedSessions(t *testing.T, userID string) []db.Item {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Second)
+	revokedAt := now.Add(-time.Minute)
+	list := []sessions.Session{
+		{SessionID: "s1", Device: "phone", IssuedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{SessionID: "s2", Device: "laptop", IssuedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{SessionID: "s3", IssuedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour), RevokedAt: &revokedAt},
+		{SessionID: "s4", IssuedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+	}
+	items := make([]db.Item, len(list))
+	for i, s := range list {
+		s.UserID = userID
+		item, err := db.Encode(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items[i] = item
+	}
+	return items
+}
+
+func TestHandle(t *testing.T) {
+	user := &auth.Identity{Subject: "u1", SessionID: "s1"}
+	moderator := &auth.Identity{Subject: "m1", SessionID: "s1", Groups: []string{authz.Moderator}}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		wantStatus int
+		wantUser   string   // whose sessions are queried
+		wantListed []string // session IDs, the current one starred
+	}{
+		{name: "own sessions", payload: apiEvent(""), caller: user, wantStatus: 200, wantUser: "u1", wantListed: []string{"s1*", "s2"}},
+		{name: "own sessions by user_id", payload: apiEvent("u1"), caller: user, wantStatus: 200, wantUser: "u1", wantListed: []string{"s1*", "s2"}},
+		{name: "token without session", payload: apiEvent(""), caller: &auth.Identity{Subject: "u1"}, wantStatus: 200, wantUser: "u1", wantListed: []string{"s1", "s2"}},
+		{name: "other user's sessions", payload: apiEvent("u2"), caller: user, wantStatus: 403},
+		{name: "moderator", payload: apiEvent("u2"), caller: moderator, wantStatus: 200, wantUser: "u2", wantListed: []string{"s1", "s2"}},
+		{name: "invalid user_id", payload: apiEvent("u 2"), caller: moderator, wantStatus: 422},
+		{name: "no token", payload: apiEvent(""), wantStatus: 401},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u2"}`), wantStatus: 200, wantUser: "u2", wantListed: []string{"s1", "s2"}},
+		{name: "direct without user_id", payload: json.RawMessage(`{}`), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queried string
+			m := &dbtest.Mock{
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					for _, v := range in.ExpressionAttributeValues {
+						queried = v.(*types.AttributeValueMemberS).Value
+					}
+					return &dynamodb.QueryOutput{Items: storedSessions(t, queried)}, nil
+				},
+			}
+			h := &Handler{
+				Sessions: &sessions.Store{DB: m.Client(), Table: "sessions"},
+				Auth:     stubVerifier{tt.caller},
+				Config:   &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if queried != tt.wantUser {
+				t.Errorf("queried sessions of %q, want %q", queried, tt.wantUser)
+			}
+			if resp.StatusCode != 200 {
+				return
+			}
+
+			var body Response
+			if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+				t.Fatal(err)
+			}
+			var listed []string
+			for _, s := range body.Sessions {
+				if s.UserID != tt.wantUser {
+					t.Errorf("session %s of %q, want %q", s.SessionID, s.UserID, tt.wantUser)
+				}
+				if s.Current {
+					s.SessionID += "*"
+				}
+				listed = append(listed, s.SessionID)
+			}
+			if !reflect.DeepEqual(listed, tt.wantListed) {
+				t.Errorf("listed %v, want %v", listed, tt.wantListed)
+			}
+		})
+	}
+}
+
+func TestHandleEmpty(t *testing.T) {
+	m := &dbtest.Mock{}
+	h := &Handler{
+		Sessions: &sessions.Store{DB: m.Client(), Table: "sessions"},
+		Auth:     stubVerifier{&auth.Identity{Subject: "u1"}},
+		Config:   &config.Config{},
+	}
+
+	resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Body != `{"sessions":[]}` {
+		t.Errorf("response = %d %s, want an empty list", resp.StatusCode, resp.Body)
+	}
+}
@@ -0,0 +1,258 @@
+// Package listusers pages through users by status for admin tooling.
+package listusers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws" // aws package
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/pagination" // signed next tokens
+)
+
+const (
+	// defaultLimit and maxLimit bound the page size.
+	defaultLimit = 25
+	maxLimit     = 100
+
+	// defaultStatus is listed when no status filter is given.
+	defaultStatus = "active"
+
+	// adminScope grants API key callers access to this endpoint; users need
+	// the users:list permission.
+	adminScope = "troggle/admin"
+)
+
+// sensitiveAttributes are never returned to callers.
+var sensitiveAttributes = []string{"phone_number", "last_login_ip", "mfa_secret"}
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the same values as query string parameters.
+type Request struct {
+	Status        string `json:"status"`
+	CreatedAfter  string `json:"created_after"`  // RFC 3339, inclusive
+	CreatedBefore string `json:"created_before"` // RFC 3339, inclusive
+	Limit         string `json:"limit"`
+	NextToken     string `json:"next_token"`
+}
+
+// Response represents the JSON output
+type Response struct {
+	Users     []map[string]any `json:"users"`
+	NextToken string           `json:"next_token,omitempty"` // absent on the last page
+}
+
+// Shape returns resp in the shape of v: from version 2 on, a page of items
+// like every other list.
+func (resp Response) Shape(v httpx.Version) any {
+	if v < httpx.V2 {
+		return resp
+	}
+	return httpx.Page[map[string]any]{Items: resp.Users, NextToken: resp.NextToken}
+}
+
+// Query is a validated listing request.
+type Query struct {
+	Status        string
+	CreatedAfter  string
+	CreatedBefore string
+	Limit         int32
+	StartKey      db.Item // decoded next token; nil for the first page
+}
+
+// filters returns the parameters a next token of q is bound to.
+func (q Query) filters() pagination.Filters {
+	return pagination.Filters{"status": q.Status, "created_after": q.CreatedAfter, "created_before": q.CreatedBefore}
+}
+
+// ParseQuery validates the request parameters, decoding the next token with
+// cursors.
+func ParseQuery(ctx context.Context, cursors *pagination.Codec, req Request) (Query, error) {
+	q := Query{Status: req.Status, Limit: defaultLimit}
+	if q.Status == "" {
+		q.Status = defaultStatus
+	}
+
+	bounds := []struct{ field, value string }{
+		{"created_after", req.CreatedAfter},
+		{"created_before", req.CreatedBefore},
+	}
+	for _, b := range bounds {
+		if b.value == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, b.value); err != nil {
+			return Query{}, apperr.Invalid("INVALID_TIMESTAMP", b.field, b.field+" must be an RFC 3339 timestamp")
+		}
+	}
+	q.CreatedAfter, q.CreatedBefore = req.CreatedAfter, req.CreatedBefore
+
+	if req.Limit != "" {
+		n, err := strconv.Atoi(req.Limit)
+		if err != nil || n < 1 || n > maxLimit {
+			return Query{}, apperr.Invalid("INVALID_LIMIT", "limit", fmt.Sprintf("limit must be between 1 and %d", maxLimit))
+		}
+		q.Limit = int32(n)
+	}
+
+	var err error
+	if q.StartKey, err = cursors.Start(ctx, req.NextToken, q.filters()); err != nil {
+		return Query{}, err
+	}
+	return q, nil
+}
+
+// ListUsers returns one page of users with the given status, newest first,
+// optionally restricted to a created_at range.
+func ListUsers(ctx context.Context, q Query, client *db.Client, cursors *pagination.Codec, tableName, indexName string) (Response, error) {
+	slog.InfoContext(ctx, "Listing users", "status", q.Status, "limit", q.Limit, "index", indexName)
+
+	keyCond := "#status = :status"
+	values := map[string]types.AttributeValue{
+		":status": &types.AttributeValueMemberS{Value: q.Status},
+	}
+	switch {
+	case q.CreatedAfter != "" && q.CreatedBefore != "":
+		keyCond += " AND #created_at BETWEEN :after AND :before"
+	case q.CreatedAfter != "":
+		keyCond += " AND #created_at >= :after"
+	case q.CreatedBefore != "":
+		keyCond += " AND #created_at <= :before"
+	}
+	if q.CreatedAfter != "" {
+		values[":after"] = &types.AttributeValueMemberS{Value: q.CreatedAfter}
+	}
+	if q.CreatedBefore != "" {
+		values[":before"] = &types.AttributeValueMemberS{Value: q.CreatedBefore}
+	}
+
+	names := map[string]string{"#status": "status"}
+	if q.CreatedAfter != "" || q.CreatedBefore != "" {
+		names["#created_at"] = "created_at"
+	}
+
+	start := time.Now()
+	result, err := client.DynamoDB.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(tableName),
+		IndexName:                 aws.String(indexName),
+		KeyConditionExpression:    aws.String(keyCond),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ExclusiveStartKey:         q.StartKey,
+		Limit:                     aws.Int32(q.Limit),
+		ScanIndexForward:          aws.Bool(false), // newest first
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return Response{}, db.Wrap(err, "listing users")
+	}
+
+	resp := Response{Users: make([]map[string]any, 0, len(result.Items))}
+	for _, item := range result.Items {
+		var user map[string]any
+		if err := attributevalue.UnmarshalMap(item, &user); err != nil {
+			return Response{}, fmt.Errorf("decoding user item: %w", err)
+		}
+		for _, attr := range sensitiveAttributes {
+			delete(user, attr)
+		}
+		resp.Users = append(resp.Users, user)
+	}
+
+	if resp.NextToken, err = cursors.Next(ctx, result.LastEvaluatedKey, q.filters()); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+// requireAdmin checks that the caller holds the admin scope or the
+// users:list permission. Direct invocations are trusted: they need IAM permission to
+// invoke the function, which only operators have.
+func requireAdmin(r *httpx.Request) error {
+	if r.Direct || r.HasScope(adminScope) || authz.ClaimsAllow(r, authz.UsersList) {
+		return nil
+	}
+	return apperr.Forbidden("Admin access required")
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "listUsers",
+	Function: "listUsers",
+	Summary:  "Pages through users by status and sign-up time",
+	Method:   "GET",
+	Path:     "/users",
+	Query:    []string{"status", "created_after", "created_before", "limit", "next_token"},
+	Response: Response{},
+	Scopes:   []string{"troggle/admin"},
+	Groups:   []string{"admin", "moderator"},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	DB      *db.Client
+	Cursors *pagination.Codec
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	cursors, err := pagination.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{DB: client, Cursors: cursors, Config: cfg}, nil
+}
+
+// HTTP returns the handler served through API Gateway. The endpoint has no
+// middleware of its own.
+func (h *Handler) HTTP() httpx.Handler {
+	return h.Handle
+}
+
+// Handle returns one page of users matching the filters.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	if err := requireAdmin(r); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	req := Request{
+		Status:        r.Query("status"),
+		CreatedAfter:  r.Query("created_after"),
+		CreatedBefore: r.Query("created_before"),
+		Limit:         r.Query("limit"),
+		NextToken:     r.Query("next_token"),
+	}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	q, err := ParseQuery(ctx, h.Cursors, req)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	resp, err := ListUsers(ctx, q, h.DB, h.Cursors, h.Config.UserTableName, h.Config.StatusIndexName)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSONFor(r, 200, resp), nil
+}
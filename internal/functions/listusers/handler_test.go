@@ -0,0 +1,131 @@
+package listusers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/pagination"
+	"troggle-backend/internal/signing"
+)
+
+// cursors signs the next tokens of the tests.
+var cursors = pagination.NewCodec(signing.StaticKey("test"))
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      Request
+		wantCode string // expected validation code; empty means valid
+	}{
+		{name: "defaults", req: Request{}},
+		{name: "range", req: Request{CreatedAfter: "2024-01-01T00:00:00Z", CreatedBefore: "2024-02-01T00:00:00Z"}},
+		{name: "bad timestamp", req: Request{CreatedAfter: "yesterday"}, wantCode: "INVALID_TIMESTAMP"},
+		{name: "limit too large", req: Request{Limit: "101"}, wantCode: "INVALID_LIMIT"},
+		{name: "limit not a number", req: Request{Limit: "ten"}, wantCode: "INVALID_LIMIT"},
+		{name: "bad token", req: Request{NextToken: "!!"}, wantCode: "INVALID_NEXT_TOKEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseQuery(context.Background(), cursors, tt.req)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if q.Status != defaultStatus || q.Limit != defaultLimit {
+					t.Errorf("query = %+v", q)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(httpx.Error(err).Body, tt.wantCode) {
+				t.Errorf("err = %v, want code %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+// apiEvent is a REST API event from a caller in the given Cognito groups.
+func apiEvent(groups, query string) json.RawMessage {
+	return json.RawMessage(`{
+		"httpMethod": "GET",
+		"path": "/users",
+		"queryStringParameters": ` + query + `,
+		"requestContext": {"authorizer": {"claims": {"sub": "caller", "cognito:groups": "` + groups + `"}}}
+	}`)
+}
+
+func TestHandle(t *testing.T) {
+	lastKey := dbtest.Item("user_id", "u2", "status", "active", "created_at", "2024-01-02T00:00:00Z")
+	page := func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		return &dynamodb.QueryOutput{
+			Items:            []db.Item{dbtest.Item("user_id", "u1", "mfa_secret", "s"), lastKey},
+			LastEvaluatedKey: lastKey,
+		}, nil
+	}
+	token, _ := cursors.Next(context.Background(), lastKey, pagination.Filters{"status": "active", "created_after": "", "created_before": ""})
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"user_id":"u2","status":"active","created_at":"2024-01-02T00:00:00Z"}`))
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		query      func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+		wantStatus int
+		wantBody   string
+		wantStart  bool // expect ExclusiveStartKey on the query
+	}{
+		{name: "not an admin", payload: apiEvent("players", `{}`), wantStatus: 403},
+		{name: "admin group", payload: apiEvent("players,admin", `{}`), query: page, wantStatus: 200, wantBody: `"next_token":"` + token + `"`},
+		{name: "direct invocation", payload: json.RawMessage(`{"limit":"2"}`), query: page, wantStatus: 200, wantBody: `"user_id":"u1"`},
+		{name: "next page", payload: apiEvent("admin", `{"next_token":"`+token+`"}`), wantStatus: 200, wantBody: `"users":[]`, wantStart: true},
+		{name: "unsigned token", payload: apiEvent("admin", `{"next_token":"`+forged+`"}`), wantStatus: 422},
+		{name: "invalid limit", payload: apiEvent("admin", `{"limit":"0"}`), wantStatus: 422},
+		{
+			name:    "throttled",
+			payload: apiEvent("admin", `{}`),
+			query: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				return nil, dbtest.Throttled()
+			},
+			wantStatus: 429,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: tt.query}
+			h := &Handler{DB: m.Client(), Cursors: cursors, Config: &config.Config{UserTableName: "users", StatusIndexName: "status-index"}}
+
+			resp, err := httpx.Adapt(h.Handle)(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+			if strings.Contains(resp.Body, "mfa_secret") {
+				t.Errorf("body leaks a sensitive attribute: %s", resp.Body)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+
+			in := m.Calls[0].Input.(*dynamodb.QueryInput)
+			if aws.ToString(in.IndexName) != "status-index" || aws.ToBool(in.ScanIndexForward) {
+				t.Errorf("query = %+v, want status-index newest first", in)
+			}
+			if (in.ExclusiveStartKey != nil) != tt.wantStart {
+				t.Errorf("ExclusiveStartKey = %v", in.ExclusiveStartKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Package manageapikeys issues, rotates and revokes the API keys of
+// server-to-server callers, for callers holding api_keys:manage:
+// POST /api-keys issues a key, POST /api-keys/{key_id}/rotate replaces one
+// and DELETE /api-keys/{key_id} revokes one. Keys are shown once, in the
+// answer that issues them. Every change is audited. See package apikeys.
+package manageapikeys
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+
+	"troggle-backend/internal/api"      // API route declarations
+	"troggle-backend/internal/apikeys"  // API keys of server-to-server callers
+	"troggle-backend/internal/apperr"   // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"    // audit log
+	"troggle-backend/internal/auth"     // Cognito JWT verification
+	"troggle-backend/internal/authz"    // role-based access control
+	"troggle-backend/internal/config"   // environment-driven settings
+	"troggle-backend/internal/db"       // shared DynamoDB client
+	"troggle-backend/internal/httpx"    // API Gateway / direct invocation adapter
+	"troggle-backend/internal/mfa"      // step-up of high-risk operations
+	"troggle-backend/internal/sessions" // session table access
+)
+
+// Actions, picked by route for API Gateway callers.
+const (
+	actionCreate = "create"
+	actionRotate = "rotate"
+	actionRevoke = "revoke"
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass key_id as a path parameter and the spec of the key to issue
+// as the body.
+type Request struct {
+	Action string `json:"action"` // "create", "rotate" or "revoke"
+	KeyID  string `json:"key_id"` // key to rotate or revoke
+	apikeys.Spec
+}
+
+// authorize lets callers holding api_keys:manage only manage keys. API keys
+// may not manage keys themselves, so a leaked key cannot mint others.
+// Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request) error {
+	if r.Direct {
+		return nil
+	}
+	if id, ok := auth.FromContext(ctx); ok && id.TokenUse == auth.TokenUseAPIKey {
+		return apperr.Forbidden("API keys may not manage API keys")
+	}
+	return authz.Require(ctx, authz.APIKeysManage)
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "createApiKey",
+		Function: "manageApiKeys",
+		Summary:  "Issues an API key",
+		Method:   "POST",
+		Path:     "/api-keys",
+		Request:  apikeys.Spec{},
+		Status:   201,
+		Response: apikeys.Issued{},
+		Scopes:   []string{"troggle/admin"},
+		Groups:   []string{"admin"},
+	},
+	{
+		Name:     "rotateApiKey",
+		Function: "manageApiKeys",
+		Summary:  "Replaces an API key",
+		Method:   "POST",
+		Path:     "/api-keys/{key_id}/rotate",
+		Status:   201,
+		Response: apikeys.Issued{},
+		Scopes:   []string{"troggle/admin"},
+		Groups:   []string{"admin"},
+	},
+	{
+		Name:     "revokeApiKey",
+		Function: "manageApiKeys",
+		Summary:  "Revokes an API key",
+		Method:   "DELETE",
+		Path:     "/api-keys/{key_id}",
+		Status:   204,
+		Scopes:   []string{"troggle/admin"},
+		Groups:   []string{"admin"},
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Keys   *apikeys.Store
+	Auth   auth.TokenVerifier
+	APIKey *apikeys.Middleware // nil accepts bearer tokens only
+	MFA    *mfa.Guard          // nil skips the step-up check
+	Audit  *audit.Store
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Keys:   apikeys.NewStore(client, cfg),
+		Auth:   verifier,
+		APIKey: apikeys.NewMiddleware(client, cfg),
+		MFA:    mfa.NewGuard(client, cfg),
+		Audit:  audit.NewStore(client, cfg),
+		Config: cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. Minting
+// credentials is a high-risk operation: callers with a second factor must
+// have signed in recently.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth), h.MFA.Middleware())
+}
+
+// Handle issues or rotates a key and answers 201 with it, or revokes one and
+// answers 204. Revoking a revoked key succeeds.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{KeyID: r.PathParams["key_id"]}
+	switch {
+	case r.Direct:
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	case r.Method == "DELETE":
+		req.Action = actionRevoke
+	case path.Base(r.Path) == actionRotate:
+		req.Action = actionRotate
+	default:
+		req.Action = actionCreate
+		if err := r.Decode(&req.Spec); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	now := time.Now()
+	switch req.Action {
+	case actionCreate:
+		if err := req.Spec.Validate(now); err != nil {
+			return httpx.Error(err), nil
+		}
+	case actionRotate, actionRevoke:
+		if req.KeyID == "" {
+			return httpx.Error(apperr.Invalid("MISSING_KEY_ID", "key_id", "key_id is required")), nil
+		}
+	default:
+		return httpx.Error(apperr.Invalid("INVALID_ACTION", "action", `action must be "create", "rotate" or "revoke"`)), nil
+	}
+	if err := authorize(ctx, r); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	entry := audit.Entry{Actor: audit.ActorOf(ctx, r), RequestID: audit.RequestID(ctx, r)}
+	switch req.Action {
+	case actionCreate:
+		issued, err := h.Keys.Create(ctx, req.Spec, now)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		slog.InfoContext(ctx, "API key issued", "key_id", issued.KeyID, "owner", issued.Owner)
+		entry.Resource, entry.Action = audit.APIKeyResource(issued.KeyID), audit.ActionAPIKeyCreate
+		entry.Diff = map[string]audit.Change{"status": {After: issued.Status}}
+		h.Audit.Log(ctx, entry)
+		return httpx.JSON(201, issued), nil
+
+	case actionRotate:
+		issued, err := h.Keys.Rotate(ctx, req.KeyID, now)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		slog.InfoContext(ctx, "API key rotated", "key_id", req.KeyID, "replaced_by", issued.KeyID)
+		entry.Resource, entry.Action = audit.APIKeyResource(req.KeyID), audit.ActionAPIKeyRotate
+		entry.Diff = map[string]audit.Change{"replaced_by": {After: issued.KeyID}}
+		h.Audit.Log(ctx, entry)
+		return httpx.JSON(201, issued), nil
+	}
+
+	revoked, err := h.Keys.Revoke(ctx, req.KeyID, now)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if revoked {
+		slog.InfoContext(ctx, "API key revoked", "key_id", req.KeyID)
+		entry.Resource, entry.Action = audit.APIKeyResource(req.KeyID), audit.ActionAPIKeyRevoke
+		entry.Diff = map[string]audit.Change{"status": {Before: apikeys.StatusActive, After: apikeys.StatusRevoked}}
+		h.Audit.Log(ctx, entry)
+	}
+	return httpx.NoContent(), nil
+}
@@ -0,0 +1,162 @@
+package manageapikeys
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apikeys"
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a REST API event of an API key route.
+func apiEvent(method, path, keyID, body string) json.RawMessage {
+	params := map[string]string{}
+	if keyID != "" {
+		params["key_id"] = keyID
+	}
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     method,
+		"path":           path,
+		"pathParameters": params,
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	admin := &auth.Identity{Subject: "a1", Groups: []string{authz.Admin}}
+	moderator := &auth.Identity{Subject: "m1", Groups: []string{authz.Moderator}}
+	spec := `{"name":"billing","owner":"svc-billing","scopes":["users:list"],"rate_limit":"120/m"}`
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		status     string // status of the stored key ak_1; empty for none
+		replaced   bool   // whether ak_1 was rotated already
+		wantStatus int
+		wantOps    []string
+	}{
+		{name: "create", payload: apiEvent("POST", "/api-keys", "", spec), caller: admin, wantStatus: 201, wantOps: []string{"PutItem", "PutItem"}},
+		{name: "create invalid", payload: apiEvent("POST", "/api-keys", "", `{"owner":"svc-billing","scopes":["everything"]}`), caller: admin, wantStatus: 422},
+		{name: "create bad json", payload: apiEvent("POST", "/api-keys", "", `{`), caller: admin, wantStatus: 400},
+		{name: "moderator", payload: apiEvent("POST", "/api-keys", "", spec), caller: moderator, wantStatus: 403},
+		{
+			name:    "rotate",
+			payload: apiEvent("POST", "/api-keys/ak_1/rotate", "ak_1", ""), caller: admin, status: apikeys.StatusActive,
+			wantStatus: 201, wantOps: []string{"Query", "TransactWriteItems", "PutItem"},
+		},
+		{
+			name:    "rotate twice",
+			payload: apiEvent("POST", "/api-keys/ak_1/rotate", "ak_1", ""), caller: admin, status: apikeys.StatusActive, replaced: true,
+			wantStatus: 422, wantOps: []string{"Query"},
+		},
+		{name: "rotate missing", payload: apiEvent("POST", "/api-keys/ak_1/rotate", "ak_1", ""), caller: admin, wantStatus: 404, wantOps: []string{"Query"}},
+		{
+			name:    "revoke",
+			payload: apiEvent("DELETE", "/api-keys/ak_1", "ak_1", ""), caller: admin, status: apikeys.StatusActive,
+			wantStatus: 204, wantOps: []string{"Query", "UpdateItem", "PutItem"},
+		},
+		{
+			name:    "revoke again",
+			payload: apiEvent("DELETE", "/api-keys/ak_1", "ak_1", ""), caller: admin, status: apikeys.StatusRevoked,
+			wantStatus: 204, wantOps: []string{"Query"},
+		},
+		{
+			name:       "direct",
+			payload:    json.RawMessage(`{"action":"revoke","key_id":"ak_1"}`),
+			status:     apikeys.StatusActive,
+			wantStatus: 204, wantOps: []string{"Query", "UpdateItem", "PutItem"},
+		},
+		{name: "direct without action", payload: json.RawMessage(`{"key_id":"ak_1"}`), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if tt.status == "" {
+						return &dynamodb.QueryOutput{}, nil
+					}
+					item := dbtest.Item("key_hash", "h1", "key_id", "ak_1", "owner", "svc-billing", "status", tt.status)
+					if tt.replaced {
+						item["replaced_by"] = &types.AttributeValueMemberS{Value: "ak_2"}
+					}
+					return &dynamodb.QueryOutput{Items: []db.Item{item}}, nil
+				},
+			}
+			h := &Handler{
+				Keys:   &apikeys.Store{DB: m.Client(), Table: "api-keys", IDIndex: "key-id-index"},
+				Auth:   stubVerifier{tt.caller},
+				Audit:  &audit.Store{DB: m.Client(), Table: "audit"},
+				Config: &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if resp.StatusCode == 201 && (!strings.Contains(resp.Body, `"api_key":"trg_`) || strings.Contains(resp.Body, "key_hash")) {
+				t.Errorf("body = %s, want the key and not its hash", resp.Body)
+			}
+			if ops := m.Ops(); len(ops)+len(tt.wantOps) > 0 && !reflect.DeepEqual(ops, tt.wantOps) {
+				t.Errorf("ops = %v, want %v", ops, tt.wantOps)
+			}
+		})
+	}
+}
+
+func TestHandleAPIKeyCaller(t *testing.T) {
+	key := dbtest.Item("key_hash", apikeys.Hash("trg_live"), "owner", "svc-ops", "status", apikeys.StatusActive)
+	key["scopes"] = &types.AttributeValueMemberSS{Value: []string{authz.APIKeysManage}}
+	m := &dbtest.Mock{
+		GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{Item: key}, nil
+		},
+	}
+	h := &Handler{
+		Keys:   &apikeys.Store{DB: m.Client(), Table: "api-keys"},
+		Auth:   stubVerifier{},
+		APIKey: &apikeys.Middleware{Keys: &apikeys.Store{DB: m.Client(), Table: "api-keys"}},
+		Config: &config.Config{},
+	}
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/api-keys",
+		"headers":    map[string]string{"X-Api-Key": "trg_live"},
+		"body":       `{"owner":"svc-other","scopes":["users:ban"]}`,
+	})
+
+	resp, err := httpx.Adapt(h.HTTP())(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 403 {
+		t.Errorf("status = %d, want 403 (%s)", resp.StatusCode, resp.Body)
+	}
+}
@@ -0,0 +1,437 @@
+// Package managemfa lets users manage their second factors (see package
+// mfa): GET /users/{user_id}/mfa lists them, POST /users/{user_id}/mfa/totp
+// starts enrolling an authenticator app, POST /users/{user_id}/mfa/totp/verify
+// completes it with a first code, turning on MFA in Cognito, and DELETE
+// /users/{user_id}/mfa/totp turns it off again.
+//
+// Enrolling uses the caller's access token, which Cognito associates the
+// secret with. Turning an active factor off, or replacing it, needs a
+// recent sign-in, so a stolen session cannot strip an account of its second
+// factor. Direct invocations, having no access token, can only list and
+// turn factors off, for support to help users who lost their authenticator.
+package managemfa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"                             // aws package
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider" // Cognito user pool client
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/mfa"        // second factors
+	"troggle-backend/internal/ratelimit"  // DynamoDB token buckets
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Actions, picked by route for API Gateway callers.
+const (
+	actionList    = "list"
+	actionEnroll  = "enroll"
+	actionVerify  = "verify"
+	actionDisable = "disable"
+)
+
+// issuer names the account in authenticator apps.
+const issuer = "Troggle"
+
+// maxNameLength bounds factor names.
+const maxNameLength = 64
+
+// rateLimits bound the codes one user can try; Cognito only limits them
+// per sign-in. They can be tuned per stage through RATE_LIMIT_PER_IP and
+// RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(30),
+	PerUser: ratelimit.PerMinute(10),
+}
+
+var (
+	// ErrInvalidCode refuses codes Cognito does not accept.
+	ErrInvalidCode = apperr.Invalid("INVALID_CODE", "code", "The code is invalid")
+
+	// ErrAccessTokenRequired refuses enrollments signed with ID tokens.
+	ErrAccessTokenRequired = &apperr.Error{Kind: apperr.KindBadRequest, Code: "ACCESS_TOKEN_REQUIRED", Message: "Enrolling a factor needs an access token"}
+
+	// ErrNotEnrolling refuses verifications without a pending enrollment.
+	ErrNotEnrolling = &apperr.Error{Kind: apperr.KindConflict, Code: "NOT_ENROLLING", Message: "No authenticator is being enrolled"}
+)
+
+// Request represents the JSON input. API Gateway callers name the account
+// in the path, and the action by route.
+type Request struct {
+	Action string `json:"action,omitempty"` // "list" or "disable" for direct invocations
+	UserID string `json:"user_id"`
+	Name   string `json:"name,omitempty"` // of the factor to enroll
+	Code   string `json:"code,omitempty"` // verifying an enrollment
+}
+
+// EnrollRequest is the body of an enrollment.
+type EnrollRequest struct {
+	Name string `json:"name,omitempty"` // defaults to "Authenticator"
+}
+
+// VerifyRequest is the body of a verification.
+type VerifyRequest struct {
+	Code string `json:"code"` // the first code the authenticator shows
+}
+
+// Enrollment is the response of an enrollment: the secret to add to the
+// authenticator, as the key to type and as the URI of a QR code.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// Factors is the response of a listing.
+type Factors struct {
+	Factors []mfa.Factor `json:"factors"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "listMfaFactors",
+		Function: "manageMfa",
+		Summary:  "Lists the second factors of an account",
+		Method:   "GET",
+		Path:     "/users/{user_id}/mfa",
+		Response: Factors{},
+	},
+	{
+		Name:     "enrollTotp",
+		Function: "manageMfa",
+		Summary:  "Starts enrolling an authenticator app",
+		Method:   "POST",
+		Path:     "/users/{user_id}/mfa/totp",
+		Request:  EnrollRequest{},
+		Status:   201,
+		Response: Enrollment{},
+	},
+	{
+		Name:     "verifyTotp",
+		Function: "manageMfa",
+		Summary:  "Completes the enrollment of an authenticator app",
+		Method:   "POST",
+		Path:     "/users/{user_id}/mfa/totp/verify",
+		Request:  VerifyRequest{},
+		Response: mfa.Factor{},
+	},
+	{
+		Name:     "disableTotp",
+		Function: "manageMfa",
+		Summary:  "Turns off an authenticator app",
+		Method:   "DELETE",
+		Path:     "/users/{user_id}/mfa/totp",
+		Status:   204,
+	},
+}
+
+// authorize lets callers manage their own factors only. Direct invocations
+// are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID {
+		return apperr.Forbidden("You may only manage your own second factors")
+	}
+	return nil
+}
+
+// CognitoAPI is the part of the Cognito user pool API the function uses.
+type CognitoAPI interface {
+	AssociateSoftwareToken(ctx context.Context, params *cognitoidentityprovider.AssociateSoftwareTokenInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AssociateSoftwareTokenOutput, error)
+	VerifySoftwareToken(ctx context.Context, params *cognitoidentityprovider.VerifySoftwareTokenInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.VerifySoftwareTokenOutput, error)
+	AdminSetUserMFAPreference(ctx context.Context, params *cognitoidentityprovider.AdminSetUserMFAPreferenceInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminSetUserMFAPreferenceOutput, error)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Factors *mfa.Store
+	Guard   *mfa.Guard
+	Cognito CognitoAPI
+	Auth    auth.TokenVerifier
+	Limiter *ratelimit.Limiter
+	Limits  ratelimit.Policy
+	Audit   *audit.Store
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// Cognito user pool.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireUserPool(); err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Factors: mfa.NewStore(client, cfg),
+		Guard:   mfa.NewGuard(client, cfg),
+		Cognito: cognitoidentityprovider.NewFromConfig(awsCfg),
+		Auth:    verifier,
+		Limiter: ratelimit.New(client, cfg),
+		Limits:  limits,
+		Audit:   audit.NewStore(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle runs the action of the route. Enrolling answers 201 with the
+// secret, verifying 200 with the active factor, or 422 INVALID_CODE, and
+// turning off 204, or 403 REAUTHENTICATION_REQUIRED when the caller's
+// sign-in is older than MFA_MAX_AGE.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	switch {
+	case r.Direct:
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+		if req.Action != actionList && req.Action != actionDisable {
+			return httpx.Error(apperr.Invalid("INVALID_ACTION", "action", `action must be "list" or "disable"`)), nil
+		}
+	case r.Method == "GET":
+		req.Action = actionList
+	case r.Method == "DELETE":
+		req.Action = actionDisable
+	default:
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+		req.Action = actionEnroll
+		if path.Base(r.Path) == actionVerify {
+			req.Action = actionVerify
+		}
+	}
+	if !r.Direct {
+		req.UserID = r.PathParams["user_id"]
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	ctx = logging.With(ctx, "user_id", req.UserID)
+
+	switch req.Action {
+	case actionList:
+		factors, err := h.Factors.List(ctx, req.UserID)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		return httpx.JSON(200, Factors{Factors: factors}), nil
+	case actionEnroll:
+		return h.enroll(ctx, r, req)
+	case actionVerify:
+		return h.verify(ctx, r, req)
+	}
+	return h.disable(ctx, r, req)
+}
+
+// enroll associates a new secret with the caller's account and records the
+// pending factor. Cognito drops the previous secret at once, turning MFA
+// off until the new one is verified, so replacing an active factor needs a
+// recent sign-in, like turning it off.
+func (h *Handler) enroll(ctx context.Context, r *httpx.Request, req Request) (httpx.Response, error) {
+	if req.Name == "" {
+		req.Name = "Authenticator"
+	}
+	if len(req.Name) > maxNameLength {
+		return httpx.Error(apperr.Invalid("NAME_TOO_LONG", "name", fmt.Sprintf("name must be at most %d characters", maxNameLength))), nil
+	}
+	token, err := accessToken(ctx, r)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+	existing, err := h.Factors.Get(ctx, req.UserID, mfa.TypeTOTP)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	id, _ := auth.FromContext(ctx)
+	if existing != nil && existing.Status == mfa.StatusActive {
+		if err := h.Guard.CheckRecent(id); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+
+	out, err := h.Cognito.AssociateSoftwareToken(ctx, &cognitoidentityprovider.AssociateSoftwareTokenInput{
+		AccessToken: aws.String(token),
+	})
+	if err != nil {
+		return httpx.Response{}, fmt.Errorf("associating software token: %w", err)
+	}
+	secret := aws.ToString(out.SecretCode)
+
+	err = h.Factors.Put(ctx, mfa.Factor{
+		UserID:    req.UserID,
+		Type:      mfa.TypeTOTP,
+		Name:      req.Name,
+		Status:    mfa.StatusPending,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+
+	label := url.PathEscape(issuer + ":" + id.Username)
+	uri := "otpauth://totp/" + label + "?" + url.Values{"secret": {secret}, "issuer": {issuer}}.Encode()
+	slog.InfoContext(ctx, "Authenticator enrollment started")
+	return httpx.JSON(201, Enrollment{Secret: secret, URI: uri}), nil
+}
+
+// verify checks the first code of the authenticator being enrolled, and
+// makes it the account's preferred MFA method.
+func (h *Handler) verify(ctx context.Context, r *httpx.Request, req Request) (httpx.Response, error) {
+	if req.Code == "" {
+		return httpx.Error(apperr.Invalid("REQUIRED", "code", "code is required")), nil
+	}
+	token, err := accessToken(ctx, r)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+	factor, err := h.Factors.Get(ctx, req.UserID, mfa.TypeTOTP)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if factor == nil {
+		return httpx.Error(ErrNotEnrolling), nil
+	}
+
+	out, err := h.Cognito.VerifySoftwareToken(ctx, &cognitoidentityprovider.VerifySoftwareTokenInput{
+		AccessToken:        aws.String(token),
+		UserCode:           aws.String(req.Code),
+		FriendlyDeviceName: aws.String(factor.Name),
+	})
+	var mismatch *cognitotypes.CodeMismatchException
+	var refused *cognitotypes.EnableSoftwareTokenMFAException
+	if errors.As(err, &mismatch) || errors.As(err, &refused) {
+		return httpx.Error(ErrInvalidCode), nil
+	}
+	if err != nil {
+		return httpx.Response{}, fmt.Errorf("verifying software token: %w", err)
+	}
+	if out.Status != cognitotypes.VerifySoftwareTokenResponseTypeSuccess {
+		return httpx.Error(ErrInvalidCode), nil
+	}
+	if err := h.setPreference(ctx, req.UserID, true); err != nil {
+		return httpx.Response{}, err
+	}
+
+	before := factor.Status
+	factor.Status = mfa.StatusActive
+	factor.VerifiedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := h.Factors.Put(ctx, *factor); err != nil {
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Authenticator enrolled")
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionMFAEnable,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"totp": {Before: before, After: factor.Status}},
+	})
+	return httpx.JSON(200, factor), nil
+}
+
+// disable turns the authenticator off in Cognito and removes its record.
+// Removing an active one needs a recent sign-in.
+func (h *Handler) disable(ctx context.Context, r *httpx.Request, req Request) (httpx.Response, error) {
+	factor, err := h.Factors.Get(ctx, req.UserID, mfa.TypeTOTP)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if factor == nil {
+		return httpx.NoContent(), nil
+	}
+	if factor.Status == mfa.StatusActive && !r.Direct {
+		id, _ := auth.FromContext(ctx)
+		if err := h.Guard.CheckRecent(id); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+	if err := h.setPreference(ctx, req.UserID, false); err != nil {
+		return httpx.Response{}, err
+	}
+	if err := h.Factors.Delete(ctx, req.UserID, mfa.TypeTOTP); err != nil {
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Authenticator turned off", "status", factor.Status)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionMFADisable,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"totp": {Before: factor.Status}},
+	})
+	return httpx.NoContent(), nil
+}
+
+// setPreference turns software token MFA of userID on, as the preferred
+// method, or off.
+func (h *Handler) setPreference(ctx context.Context, userID string, enabled bool) error {
+	_, err := h.Cognito.AdminSetUserMFAPreference(ctx, &cognitoidentityprovider.AdminSetUserMFAPreferenceInput{
+		UserPoolId: aws.String(h.Config.UserPoolID),
+		Username:   aws.String(userID),
+		SoftwareTokenMfaSettings: &cognitotypes.SoftwareTokenMfaSettingsType{
+			Enabled:      enabled,
+			PreferredMfa: enabled,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("setting MFA preference: %w", err)
+	}
+	return nil
+}
+
+// accessToken returns the caller's Cognito access token, which enrollment
+// calls Cognito with.
+func accessToken(ctx context.Context, r *httpx.Request) (string, error) {
+	id, ok := auth.FromContext(ctx)
+	if r.Direct || !ok || id.TokenUse != "access" {
+		return "", ErrAccessTokenRequired
+	}
+	return auth.BearerToken(r), nil
+}
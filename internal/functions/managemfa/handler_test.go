@@ -0,0 +1,212 @@
+package managemfa
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/mfa"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/userdata"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeCognito accepts the code "123456" and records MFA preferences.
+type fakeCognito struct {
+	associated  int
+	preferences []bool
+}
+
+func (f *fakeCognito) AssociateSoftwareToken(context.Context, *cognitoidentityprovider.AssociateSoftwareTokenInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AssociateSoftwareTokenOutput, error) {
+	f.associated++
+	return &cognitoidentityprovider.AssociateSoftwareTokenOutput{SecretCode: aws.String("SECRET")}, nil
+}
+
+func (f *fakeCognito) VerifySoftwareToken(_ context.Context, in *cognitoidentityprovider.VerifySoftwareTokenInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.VerifySoftwareTokenOutput, error) {
+	if aws.ToString(in.UserCode) != "123456" {
+		return nil, &cognitotypes.CodeMismatchException{Message: aws.String("mismatch")}
+	}
+	return &cognitoidentityprovider.VerifySoftwareTokenOutput{Status: cognitotypes.VerifySoftwareTokenResponseTypeSuccess}, nil
+}
+
+func (f *fakeCognito) AdminSetUserMFAPreference(_ context.Context, in *cognitoidentityprovider.AdminSetUserMFAPreferenceInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminSetUserMFAPreferenceOutput, error) {
+	f.preferences = append(f.preferences, in.SoftwareTokenMfaSettings.Enabled)
+	return &cognitoidentityprovider.AdminSetUserMFAPreferenceOutput{}, nil
+}
+
+// apiEvent is an authenticated REST API event of the route at method and
+// path for the account userID.
+func apiEvent(method, path, userID string, body any) json.RawMessage {
+	raw, _ := json.Marshal(body)
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     method,
+		"path":           strings.ReplaceAll(path, "{user_id}", userID),
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           string(raw),
+	})
+	return event
+}
+
+// fixture is a handler over mocks for the caller u1, whose TOTP factor,
+// if any, is kept in factor.
+type fixture struct {
+	h       *Handler
+	db      *dbtest.Mock
+	cognito *fakeCognito
+	factor  db.Item
+}
+
+func newFixture(status string, signedIn time.Time) *fixture {
+	f := &fixture{cognito: &fakeCognito{}}
+	if status != "" {
+		f.factor, _ = userdata.Marshal(userdata.MFAFactor, "u1", userdata.MFASK(mfa.TypeTOTP), mfa.Factor{UserID: "u1", Type: mfa.TypeTOTP, Name: "Phone", Status: status})
+	}
+	f.db = &dbtest.Mock{
+		GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			if aws.ToString(in.TableName) == "user-data" {
+				return &dynamodb.GetItemOutput{Item: f.factor}, nil
+			}
+			return &dynamodb.GetItemOutput{}, nil
+		},
+		PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			if aws.ToString(in.TableName) == "user-data" {
+				f.factor = in.Item
+			}
+			return &dynamodb.PutItemOutput{}, nil
+		},
+		DeleteItemFunc: func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+			f.factor = nil
+			return &dynamodb.DeleteItemOutput{}, nil
+		},
+	}
+	cfg := &config.Config{UserDataTableName: "user-data", AuditTableName: "audit", UserPoolID: "pool"}
+	factors := &mfa.Store{Data: userdata.NewStore(f.db.Client(), cfg)}
+	f.h = &Handler{
+		Factors: factors,
+		Guard:   &mfa.Guard{Factors: factors, MaxAge: 15 * time.Minute},
+		Cognito: f.cognito,
+		Auth:    stubVerifier{&auth.Identity{Subject: "u1", Username: "jane", TokenUse: "access", AuthTime: signedIn}},
+		Limiter: &ratelimit.Limiter{DB: f.db.Client(), Table: "rate-limits"},
+		Audit:   audit.NewStore(f.db.Client(), cfg),
+		Config:  cfg,
+	}
+	return f
+}
+
+func (f *fixture) call(t *testing.T, event json.RawMessage) httpx.Response {
+	resp, err := httpx.Adapt(f.h.HTTP())(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// status returns the status of the stored factor, "" if there is none.
+func (f *fixture) status() string {
+	if f.factor == nil {
+		return ""
+	}
+	return f.factor["status"].(*types.AttributeValueMemberS).Value
+}
+
+func TestEnrollAndVerify(t *testing.T) {
+	f := newFixture("", time.Now())
+	resp := f.call(t, apiEvent("POST", "/users/{user_id}/mfa/totp", "u1", EnrollRequest{Name: "Phone"}))
+	if resp.StatusCode != 201 {
+		t.Fatalf("enroll status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+	var enrollment Enrollment
+	if err := json.Unmarshal([]byte(resp.Body), &enrollment); err != nil {
+		t.Fatal(err)
+	}
+	if enrollment.Secret != "SECRET" || enrollment.URI != "otpauth://totp/Troggle:jane?issuer=Troggle&secret=SECRET" {
+		t.Errorf("enrollment = %+v", enrollment)
+	}
+	if f.status() != mfa.StatusPending {
+		t.Errorf("factor status = %q, want pending", f.status())
+	}
+
+	resp = f.call(t, apiEvent("POST", "/users/{user_id}/mfa/totp/verify", "u1", VerifyRequest{Code: "000000"}))
+	if resp.StatusCode != 422 {
+		t.Errorf("wrong code status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+	resp = f.call(t, apiEvent("POST", "/users/{user_id}/mfa/totp/verify", "u1", VerifyRequest{Code: "123456"}))
+	if resp.StatusCode != 200 {
+		t.Fatalf("verify status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+	if f.status() != mfa.StatusActive {
+		t.Errorf("factor status = %q, want active", f.status())
+	}
+	if len(f.cognito.preferences) != 1 || !f.cognito.preferences[0] {
+		t.Errorf("preferences = %v, want MFA turned on", f.cognito.preferences)
+	}
+
+	resp = f.call(t, apiEvent("GET", "/users/{user_id}/mfa", "u1", nil))
+	if resp.StatusCode != 200 {
+		t.Fatalf("list status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestVerifyNotEnrolling(t *testing.T) {
+	f := newFixture("", time.Now())
+	resp := f.call(t, apiEvent("POST", "/users/{user_id}/mfa/totp/verify", "u1", VerifyRequest{Code: "123456"}))
+	if resp.StatusCode != 409 {
+		t.Errorf("status = %d (%s)", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	tests := []struct {
+		name       string
+		event      json.RawMessage
+		signedIn   time.Time
+		wantStatus int
+	}{
+		{name: "disable, recent sign-in", event: apiEvent("DELETE", "/users/{user_id}/mfa/totp", "u1", nil), signedIn: time.Now(), wantStatus: 204},
+		{name: "disable, old sign-in", event: apiEvent("DELETE", "/users/{user_id}/mfa/totp", "u1", nil), signedIn: time.Now().Add(-time.Hour), wantStatus: 403},
+		{name: "replace, old sign-in", event: apiEvent("POST", "/users/{user_id}/mfa/totp", "u1", EnrollRequest{}), signedIn: time.Now().Add(-time.Hour), wantStatus: 403},
+		{name: "other user", event: apiEvent("DELETE", "/users/{user_id}/mfa/totp", "u2", nil), signedIn: time.Now(), wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(mfa.StatusActive, tt.signedIn)
+			resp := f.call(t, tt.event)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus == 204 {
+				if f.factor != nil || len(f.cognito.preferences) != 1 || f.cognito.preferences[0] {
+					t.Errorf("factor = %v, preferences = %v, want it removed and MFA turned off", f.factor, f.cognito.preferences)
+				}
+				return
+			}
+			if f.status() != mfa.StatusActive || len(f.cognito.preferences) != 0 || f.cognito.associated != 0 {
+				t.Errorf("factor = %q, Cognito = %+v, want nothing changed", f.status(), f.cognito)
+			}
+		})
+	}
+}
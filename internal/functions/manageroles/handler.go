@@ -0,0 +1,178 @@
+// Package manageroles grants and revokes the roles of the role table, for
+// callers holding roles:manage: PUT /users/{user_id}/roles/{role} grants,
+// DELETE revokes. Roles held through Cognito groups are managed in the user
+// pool instead. Every change is audited. See package authz.
+package manageroles
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apikeys"    // API keys of server-to-server callers
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Actions of direct invocations.
+const (
+	actionGrant  = "grant"
+	actionRevoke = "revoke"
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass user_id and role as path parameters and pick the action by
+// method.
+type Request struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+	Action string `json:"action"` // "grant" or "revoke"
+}
+
+// authorize lets callers holding roles:manage only change roles, and keeps
+// them from revoking their own admin grant, which could leave no one able
+// to grant it back. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, req Request) error {
+	if r.Direct {
+		return nil
+	}
+	if err := authz.Require(ctx, authz.RolesManage); err != nil {
+		return err
+	}
+	if id, _ := auth.FromContext(ctx); req.Action == actionRevoke && req.Role == authz.Admin && id.Subject == req.UserID {
+		return apperr.Forbidden("You may not revoke your own admin role")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "grantRole",
+		Function: "manageRoles",
+		Summary:  "Grants a role",
+		Method:   "PUT",
+		Path:     "/users/{user_id}/roles/{role}",
+		Status:   204,
+		Scopes:   []string{"troggle/admin", "roles:manage"},
+		Groups:   []string{"admin"},
+		APIKey:   true,
+	},
+	{
+		Name:     "revokeRole",
+		Function: "manageRoles",
+		Summary:  "Revokes a role",
+		Method:   "DELETE",
+		Path:     "/users/{user_id}/roles/{role}",
+		Status:   204,
+		Scopes:   []string{"troggle/admin", "roles:manage"},
+		Groups:   []string{"admin"},
+		APIKey:   true,
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Roles  *authz.Store
+	Auth   auth.TokenVerifier
+	APIKey *apikeys.Middleware // nil accepts bearer tokens only
+	Audit  *audit.Store
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Roles:  authz.NewStore(client, cfg),
+		Auth:   verifier,
+		APIKey: apikeys.NewMiddleware(client, cfg),
+		Audit:  audit.NewStore(client, cfg),
+		Config: cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth))
+}
+
+// Handle grants or revokes the role and answers 204. Granting a role held
+// already succeeds; revoking one that is not granted is a 404.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"], Role: r.PathParams["role"]}
+	switch {
+	case r.Direct:
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	case r.Method == "PUT":
+		req.Action = actionGrant
+	case r.Method == "DELETE":
+		req.Action = actionRevoke
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if !authz.Valid(req.Role) || req.Role == authz.User {
+		return httpx.Error(apperr.Invalid("INVALID_ROLE", "role", fmt.Sprintf("role must be %q or %q", authz.Moderator, authz.Admin))), nil
+	}
+	if req.Action != actionGrant && req.Action != actionRevoke {
+		return httpx.Error(apperr.Invalid("INVALID_ACTION", "action", `action must be "grant" or "revoke"`)), nil
+	}
+	if err := authorize(ctx, r, req); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	actor := audit.ActorOf(ctx, r)
+	var changed bool
+	var err error
+	diff := map[string]audit.Change{}
+	if req.Action == actionGrant {
+		changed, err = h.Roles.Grant(ctx, req.UserID, req.Role, actor.ActorID, time.Now())
+		diff["role"] = audit.Change{After: req.Role}
+	} else {
+		changed, err = h.Roles.Revoke(ctx, req.UserID, req.Role)
+		diff["role"] = audit.Change{Before: req.Role}
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if !changed {
+		if req.Action == actionRevoke {
+			return httpx.Error(apperr.NotFound("Role not granted")), nil
+		}
+		return httpx.NoContent(), nil
+	}
+
+	slog.InfoContext(ctx, "Role changed", "user_id", req.UserID, "role", req.Role, "action", req.Action)
+	action := audit.ActionRoleGrant
+	if req.Action == actionRevoke {
+		action = audit.ActionRoleRevoke
+	}
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    action,
+		Actor:     actor,
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      diff,
+	})
+	return httpx.NoContent(), nil
+}
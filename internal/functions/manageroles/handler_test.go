@@ -0,0 +1,103 @@
+package manageroles
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a REST API event of the role route.
+func apiEvent(method, userID, role string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     method,
+		"path":           "/users/" + userID + "/roles/" + role,
+		"pathParameters": map[string]string{"user_id": userID, "role": role},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	admin := &auth.Identity{Subject: "a1", Roles: []string{authz.Admin}}
+	moderator := &auth.Identity{Subject: "m1", Groups: []string{authz.Moderator}}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		granted    bool // whether u1 holds the role already
+		wantStatus int
+		wantOps    []string
+	}{
+		{name: "grant", payload: apiEvent("PUT", "u1", authz.Moderator), caller: admin, wantStatus: 204, wantOps: []string{"PutItem", "PutItem"}},
+		{name: "grant again", payload: apiEvent("PUT", "u1", authz.Moderator), caller: admin, granted: true, wantStatus: 204, wantOps: []string{"PutItem"}},
+		{name: "revoke", payload: apiEvent("DELETE", "u1", authz.Moderator), caller: admin, granted: true, wantStatus: 204, wantOps: []string{"DeleteItem", "PutItem"}},
+		{name: "revoke missing", payload: apiEvent("DELETE", "u1", authz.Moderator), caller: admin, wantStatus: 404, wantOps: []string{"DeleteItem"}},
+		{name: "unknown role", payload: apiEvent("PUT", "u1", "owner"), caller: admin, wantStatus: 422},
+		{name: "user role", payload: apiEvent("PUT", "u1", authz.User), caller: admin, wantStatus: 422},
+		{name: "own admin role", payload: apiEvent("DELETE", "a1", authz.Admin), caller: admin, granted: true, wantStatus: 403},
+		{name: "moderator", payload: apiEvent("PUT", "u1", authz.Admin), caller: moderator, wantStatus: 403},
+		{
+			name:       "direct",
+			payload:    json.RawMessage(`{"user_id":"u1","role":"admin","action":"grant"}`),
+			wantStatus: 204, wantOps: []string{"PutItem", "PutItem"},
+		},
+		{name: "direct without action", payload: json.RawMessage(`{"user_id":"u1","role":"admin"}`), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					if tt.granted && aws.ToString(in.TableName) == "roles" {
+						return nil, dbtest.ConditionFailed()
+					}
+					return &dynamodb.PutItemOutput{}, nil
+				},
+				DeleteItemFunc: func(*dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+					if !tt.granted {
+						return &dynamodb.DeleteItemOutput{}, nil
+					}
+					return &dynamodb.DeleteItemOutput{Attributes: dbtest.Item("user_id", "u1", "role", authz.Moderator)}, nil
+				},
+			}
+			h := &Handler{
+				Roles:  &authz.Store{DB: m.Client(), Table: "roles"},
+				Auth:   stubVerifier{tt.caller},
+				Audit:  &audit.Store{DB: m.Client(), Table: "audit"},
+				Config: &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if ops := m.Ops(); len(ops)+len(tt.wantOps) > 0 && !reflect.DeepEqual(ops, tt.wantOps) {
+				t.Errorf("ops = %v, want %v", ops, tt.wantOps)
+			}
+		})
+	}
+}
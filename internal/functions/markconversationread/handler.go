@@ -0,0 +1,140 @@
+// Package markconversationread marks a conversation read (POST
+// /users/{user_id}/conversations/{other_id}/read), resetting its unread
+// count. The other user's open WebSocket connections are told, so their
+// apps can show the read receipt. See package messages.
+package markconversationread
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/messages"   // direct messages
+	"troggle-backend/internal/presence"   // WebSocket connections
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// MessageType is the type of the realtime message carrying a read receipt.
+const MessageType = "read"
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the same values as path parameters.
+type Request struct {
+	UserID  string `json:"user_id"`  // the reader
+	OtherID string `json:"other_id"` // the other user of the conversation
+}
+
+// Receipt is the data of a read receipt.
+type Receipt struct {
+	UserID string    `json:"user_id"` // the reader
+	ReadAt time.Time `json:"read_at"`
+}
+
+// authorize lets callers mark their own conversations only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only mark your own conversations read")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "markConversationRead",
+	Function: "markConversationRead",
+	Summary:  "Marks a conversation read",
+	Method:   "POST",
+	Path:     "/users/{user_id}/conversations/{other_id}/read",
+	Status:   204,
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Messages *messages.Store
+	Realtime *presence.Poster
+	Auth     auth.TokenVerifier
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// WebSocket API.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireWebSocket(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Messages: messages.NewStore(client, cfg),
+		Realtime: presence.NewPoster(presence.NewStore(client, cfg), awsCfg, cfg),
+		Auth:     verifier,
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle marks the conversation read and sends the receipt.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"], OtherID: r.PathParams["other_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	for _, id := range []string{req.UserID, req.OtherID} {
+		if err := validation.UserID(id); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	readAt, err := h.Messages.MarkRead(ctx, req.UserID, req.OtherID)
+	switch {
+	case apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+
+	// The read is stored; a receipt that does not arrive is only cosmetic
+	receipt := presence.Message{Type: MessageType, Data: Receipt{UserID: req.UserID, ReadAt: readAt}}
+	if _, err := h.Realtime.Send(ctx, req.OtherID, receipt); err != nil {
+		slog.WarnContext(ctx, "Failed to push read receipt", "user_id", req.UserID, "other_id", req.OtherID, logging.Err(err))
+	}
+	return httpx.NoContent(), nil
+}
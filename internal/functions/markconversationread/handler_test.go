@@ -0,0 +1,110 @@
+package markconversationread
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/messages"
+	"troggle-backend/internal/presence"
+)
+
+// apiEvent is a POST /users/{user_id}/conversations/{other_id}/read REST API
+// event.
+func apiEvent(userID, otherID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/conversations/" + otherID + "/read",
+		"pathParameters": map[string]string{"user_id": userID, "other_id": otherID},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		updateErr  error
+		wantStatus int
+		wantPushed string // connection receiving the receipt, named c-<user>
+	}{
+		{name: "read", payload: apiEvent("u1", "u2"), wantStatus: 204, wantPushed: "c-u2"},
+		{name: "unknown conversation", payload: apiEvent("u1", "u2"), updateErr: dbtest.ConditionFailed(), wantStatus: 404},
+		{name: "as another user", payload: apiEvent("u2", "u1"), wantStatus: 403},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u3","other_id":"u4"}`), wantStatus: 204, wantPushed: "c-u4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var pushed, receipt string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				defer mu.Unlock()
+				pushed, receipt = strings.TrimPrefix(r.URL.Path, "/@connections/"), string(body)
+			}))
+			defer srv.Close()
+
+			expires := fmt.Sprint(time.Now().Add(time.Minute).Unix())
+			m := &dbtest.Mock{
+				UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					return &dynamodb.UpdateItemOutput{}, tt.updateErr
+				},
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					user := in.ExpressionAttributeValues[":user_id"].(*types.AttributeValueMemberS).Value
+					item := dbtest.Item("connection_id", "c-"+user, "user_id", user)
+					item["expires_at"] = &types.AttributeValueMemberN{Value: expires}
+					return &dynamodb.QueryOutput{Items: []db.Item{item}}, nil
+				},
+			}
+			h := &Handler{
+				Messages: &messages.Store{DB: m.Client(), Table: "messages"},
+				Realtime: &presence.Poster{
+					Endpoint: srv.URL,
+					Region:   "eu-west-1",
+					Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+						return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+					}),
+					Signer:      v4.NewSigner(),
+					HTTP:        srv.Client(),
+					Connections: &presence.Store{DB: m.Client(), Table: "connections", UserIndex: "user-index"},
+				},
+				Config: &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if pushed != tt.wantPushed {
+				t.Errorf("pushed to %q, want %q", pushed, tt.wantPushed)
+			}
+			if pushed != "" && !strings.Contains(receipt, `"type":"read"`) {
+				t.Errorf("receipt = %s", receipt)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// Package marknotificationsread marks notifications of a user read (POST
+// /users/{user_id}/notifications/read with {"notification_ids": [...]}),
+// moving them behind the unread ones of the inbox. Notifications read
+// already, or expired, are skipped. See package notifications.
+package marknotificationsread
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/notifications" // in-app notification inbox
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// Request represents the JSON input. API Gateway callers pass the user as
+// a path parameter.
+type Request struct {
+	UserID          string   `json:"user_id"`
+	NotificationIDs []string `json:"notification_ids"`
+}
+
+// Response represents the JSON output
+type Response struct {
+	Marked int `json:"marked"` // notifications this call marked read
+}
+
+// authorize lets callers mark their own notifications only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only mark your own notifications read")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "markNotificationsRead",
+	Function: "markNotificationsRead",
+	Summary:  "Marks notifications read",
+	Method:   "POST",
+	Path:     "/users/{user_id}/notifications/read",
+	Request:  Request{},
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Notifications *notifications.Store
+	Auth          auth.TokenVerifier
+	Config        *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{Notifications: notifications.NewStore(client, cfg), Auth: verifier, Config: cfg}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle marks the notifications read. Marking them again marks nothing,
+// so retries are harmless.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.UserID = r.PathParams["user_id"]
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	marked, err := h.Notifications.MarkRead(ctx, req.UserID, req.NotificationIDs, time.Now())
+	switch {
+	case apperr.KindOf(err) == apperr.KindInvalid:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	return httpx.JSON(200, Response{Marked: marked}), nil
+}
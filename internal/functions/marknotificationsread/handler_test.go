@@ -0,0 +1,62 @@
+package marknotificationsread
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/notifications"
+)
+
+// apiEvent is a POST /users/{user_id}/notifications/read REST API event.
+func apiEvent(userID, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/notifications/read",
+		"pathParameters": map[string]string{"user_id": userID},
+		"body":           body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "own", payload: apiEvent("u1", `{"notification_ids":["a","b"]}`), wantStatus: 200, wantBody: `"marked":2`},
+		{name: "none", payload: apiEvent("u1", `{"notification_ids":[]}`), wantStatus: 422},
+		{name: "no body", payload: apiEvent("u1", ""), wantStatus: 400},
+		{name: "another user's", payload: apiEvent("u2", `{"notification_ids":["a"]}`), wantStatus: 403},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u2","notification_ids":["a"]}`), wantStatus: 200, wantBody: `"marked":1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				return &dynamodb.UpdateItemOutput{}, nil
+			}}
+			h := &Handler{Notifications: &notifications.Store{DB: m.Client(), Table: "notifications"}, Config: &config.Config{}}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// Package mergeaccounts merges the duplicate account of a user, typically
+// one made by signing in with another provider, into the account they keep
+// (POST /users/{user_id}/merge), for admins only. Sessions, relationships,
+// leaderboard scores and notifications move from the duplicate to the
+// surviving account, each item in a transaction of its own with whatever
+// must change with it (see the Move method of each store). A merge that
+// fails part way is finished by running it again. A dry run reports what
+// would move without writing anything.
+//
+// The duplicate itself is left in place, emptied, for support to delete
+// once the user confirms which account they keep.
+package mergeaccounts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apikeys"       // API keys of server-to-server callers
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"         // audit log
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/leaderboard"   // leaderboard table access
+	"troggle-backend/internal/logging"       // structured JSON logging
+	"troggle-backend/internal/notifications" // notification table access
+	"troggle-backend/internal/relationships" // relationship table access
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// CodeMergeSelf is the error code of a merge of an account into itself.
+const CodeMergeSelf = "MERGE_SELF"
+
+// Request represents the JSON body. API Gateway callers name the surviving
+// account in the path.
+type Request struct {
+	UserID       string `json:"user_id"`                            // the surviving account
+	SourceUserID string `json:"source_user_id" validate:"required"` // the duplicate, emptied by the merge
+	DryRun       bool   `json:"dry_run"`                            // report what would move, writing nothing
+}
+
+// Tally counts the items of one kind a merge moved to the surviving
+// account, and those it dropped because the account had them already.
+type Tally struct {
+	Moved   int `json:"moved"`
+	Dropped int `json:"dropped"`
+}
+
+// Report is the response: what the merge moved, or would move in a dry run.
+type Report struct {
+	UserID        string `json:"user_id"`
+	SourceUserID  string `json:"source_user_id"`
+	DryRun        bool   `json:"dry_run"`
+	Sessions      Tally  `json:"sessions"`
+	Relationships Tally  `json:"relationships"`
+	Scores        Tally  `json:"scores"`
+	Notifications Tally  `json:"notifications"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "mergeAccounts",
+	Function: "mergeAccounts",
+	Summary:  "Merges a duplicate account into the account a user keeps",
+	Method:   "POST",
+	Path:     "/users/{user_id}/merge",
+	Request:  Request{},
+	Response: Report{},
+	Scopes:   []string{"troggle/admin", "users:merge"},
+	Groups:   []string{"admin"},
+	APIKey:   true,
+}}
+
+// authorize lets callers holding the users:merge permission only merge
+// accounts. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request) error {
+	if r.Direct {
+		return nil
+	}
+	return authz.Require(ctx, authz.UsersMerge)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users         *users.Repository // cache invalidated after each merge
+	Sessions      *sessions.Store
+	Relationships *relationships.Store
+	Leaderboard   *leaderboard.Store
+	Notifications *notifications.Store
+	Auth          auth.TokenVerifier
+	APIKey        *apikeys.Middleware // nil accepts bearer tokens only
+	Audit         *audit.Store
+	Config        *config.Config
+	Now           func() time.Time
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Users:         users.NewRepository(client, cfg),
+		Sessions:      sessions.NewStore(client, cfg),
+		Relationships: relationships.NewStore(client, cfg),
+		Leaderboard:   leaderboard.NewStore(client, cfg),
+		Notifications: notifications.NewStore(client, cfg),
+		Auth:          verifier,
+		APIKey:        apikeys.NewMiddleware(client, cfg),
+		Audit:         audit.NewStore(client, cfg),
+		Config:        cfg,
+		Now:           time.Now,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth), validation.Body[Request]())
+}
+
+// Handle merges the source account into the surviving one and answers 200
+// with the report.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.UserID = r.PathParams["user_id"]
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := validation.UserID(req.SourceUserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if req.SourceUserID == req.UserID {
+		return httpx.Error(apperr.Invalid(CodeMergeSelf, "source_user_id", "source_user_id must name another account")), nil
+	}
+	if err := authorize(ctx, r); err != nil {
+		return httpx.Error(err), nil
+	}
+	ctx = logging.With(ctx, "user_id", req.UserID, "source_user_id", req.SourceUserID)
+
+	for _, id := range []string{req.UserID, req.SourceUserID} {
+		user, err := h.Users.Uncached().Get(ctx, id, []string{"user_id"})
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		if user == nil {
+			return httpx.Error(apperr.NotFound("User not found")), nil
+		}
+	}
+
+	report, err := h.merge(ctx, req)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if req.DryRun {
+		return httpx.JSON(200, report), nil
+	}
+
+	h.Users.Invalidate(req.UserID, "")
+	h.Users.Invalidate(req.SourceUserID, "")
+	slog.InfoContext(ctx, "Accounts merged", "sessions", report.Sessions, "relationships", report.Relationships,
+		"scores", report.Scores, "notifications", report.Notifications)
+	for _, e := range []struct{ id, change, other string }{
+		{req.UserID, "merged_from", req.SourceUserID},
+		{req.SourceUserID, "merged_into", req.UserID},
+	} {
+		h.Audit.Log(ctx, audit.Entry{
+			Resource:  audit.UserResource(e.id),
+			Action:    audit.ActionUserMerge,
+			Actor:     audit.ActorOf(ctx, r),
+			RequestID: audit.RequestID(ctx, r),
+			Diff:      map[string]audit.Change{e.change: {After: e.other}},
+		})
+	}
+	return httpx.JSON(200, report), nil
+}
+
+// merge moves everything of the source account to the surviving one, one
+// kind after the other. Relationships go first: a block moved along ends
+// the surviving account's ties with the blocked user before anything else
+// shows up.
+func (h *Handler) merge(ctx context.Context, req Request) (Report, error) {
+	report := Report{UserID: req.UserID, SourceUserID: req.SourceUserID, DryRun: req.DryRun}
+	from, to, dryRun := req.SourceUserID, req.UserID, req.DryRun
+	steps := []struct {
+		tally *Tally
+		move  func() (int, int, error)
+	}{
+		{&report.Relationships, func() (int, int, error) { return h.Relationships.Move(ctx, from, to, dryRun) }},
+		{&report.Scores, func() (int, int, error) { return h.Leaderboard.Move(ctx, from, to, h.Now(), dryRun) }},
+		{&report.Notifications, func() (int, int, error) { return h.Notifications.Move(ctx, from, to, dryRun) }},
+		{&report.Sessions, func() (int, int, error) { return h.Sessions.Move(ctx, from, to, dryRun) }},
+	}
+	for _, step := range steps {
+		moved, dropped, err := step.move()
+		if err != nil {
+			return report, err
+		}
+		*step.tally = Tally{Moved: moved, Dropped: dropped}
+	}
+	return report, nil
+}
@@ -0,0 +1,149 @@
+package mergeaccounts
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/leaderboard"
+	"troggle-backend/internal/notifications"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/sessions"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is an authenticated REST API event merging source into userID.
+func apiEvent(userID, source string, dryRun bool) json.RawMessage {
+	body, _ := json.Marshal(map[string]any{"source_user_id": source, "dry_run": dryRun})
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/merge",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           string(body),
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	admin := &auth.Identity{Subject: "a1", Groups: []string{authz.Admin}}
+	tests := []struct {
+		name        string
+		caller      *auth.Identity
+		userID      string
+		source      string
+		dryRun      bool
+		wantStatus  int
+		wantMoved   int // notifications
+		wantAudited int
+	}{
+		{name: "merge", caller: admin, userID: "u1", source: "u2", wantStatus: 200, wantMoved: 1, wantAudited: 2},
+		{name: "dry run", caller: admin, userID: "u1", source: "u2", dryRun: true, wantStatus: 200, wantMoved: 1},
+		{name: "into itself", caller: admin, userID: "u1", source: "u1", wantStatus: 422},
+		{name: "unknown source", caller: admin, userID: "u1", source: "u9", wantStatus: 404},
+		{name: "not an admin", caller: &auth.Identity{Subject: "u1"}, userID: "u1", source: "u2", wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					if aws.ToString(in.TableName) != "users" {
+						return &dynamodb.GetItemOutput{}, nil
+					}
+					switch id := in.Key["user_id"].(*types.AttributeValueMemberS).Value; id {
+					case "u1", "u2":
+						return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", id, "status", "active")}, nil
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if aws.ToString(in.TableName) == "notifications" && in.ExpressionAttributeValues[":user_id"].(*types.AttributeValueMemberS).Value == "u2" {
+						return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{dbtest.Item("user_id", "u2", "notification_id", "n1")}}, nil
+					}
+					return &dynamodb.QueryOutput{}, nil
+				},
+			}
+			cfg := &config.Config{
+				UserTableName:         "users",
+				SessionTableName:      "sessions",
+				RelationshipTableName: "relationships",
+				LeaderboardTableName:  "leaderboard",
+				NotificationTableName: "notifications",
+				AuditTableName:        "audit",
+				Leaderboards:          []string{"main"},
+				LeaderboardShards:     4,
+			}
+			h := &Handler{
+				Users:         users.NewRepository(m.Client(), cfg),
+				Sessions:      sessions.NewStore(m.Client(), cfg),
+				Relationships: relationships.NewStore(m.Client(), cfg),
+				Leaderboard:   leaderboard.NewStore(m.Client(), cfg),
+				Notifications: notifications.NewStore(m.Client(), cfg),
+				Auth:          stubVerifier{tt.caller},
+				Audit:         audit.NewStore(m.Client(), cfg),
+				Config:        cfg,
+				Now:           func() time.Time { return time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC) },
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.userID, tt.source, tt.dryRun))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 200 {
+				if len(m.Calls) > 2 {
+					t.Errorf("ops = %v, want no more than the users read", m.Ops())
+				}
+				return
+			}
+
+			var report Report
+			if err := json.Unmarshal([]byte(resp.Body), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.UserID != "u1" || report.SourceUserID != "u2" || report.DryRun != tt.dryRun || report.Notifications.Moved != tt.wantMoved {
+				t.Errorf("report = %+v", report)
+			}
+			var moves, audited int
+			for _, c := range m.Calls {
+				switch in := c.Input.(type) {
+				case *dynamodb.TransactWriteItemsInput:
+					moves++
+				case *dynamodb.PutItemInput:
+					if aws.ToString(in.TableName) == "audit" {
+						audited++
+					}
+				}
+			}
+			if tt.dryRun && moves != 0 {
+				t.Errorf("dry run wrote %d transactions", moves)
+			}
+			if audited != tt.wantAudited {
+				t.Errorf("%d audit entries, want %d", audited, tt.wantAudited)
+			}
+		})
+	}
+}
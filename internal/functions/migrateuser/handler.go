@@ -0,0 +1,244 @@
+// Package migrateuser moves the accounts of the legacy system into Cognito
+// as their owners come back. It handles two Cognito triggers:
+//
+//   - UserMigration, fired when someone signs in or asks for a password
+//     reset with a username Cognito does not know. The legacy Verifier
+//     checks the password, or only that the account exists for a reset,
+//     and Cognito creates the user with the attributes returned, including
+//     custom:legacy_id, the account's ID in the legacy system.
+//   - PostAuthentication, fired after every sign-in. Cognito creates
+//     migrated users without confirming a sign-up, so PostConfirmation never
+//     creates their record; the first sign-in of a user with a
+//     custom:legacy_id and no record does, marked with legacy_id and
+//     migrated_at so the migration's progress can be followed and the
+//     legacy path retired.
+//
+// Accounts whose email is already registered here are not migrated: the
+// record could not be created.
+package migrateuser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events" // Cognito trigger event definitions
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/audit"         // audit log
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	domain "troggle-backend/internal/events" // domain event publishing
+	"troggle-backend/internal/legacy"        // legacy account verification
+	"troggle-backend/internal/logging"       // structured JSON logging
+	"troggle-backend/internal/metrics"       // CloudWatch EMF metrics
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// Trigger sources handled.
+const (
+	sourceAuthentication     = "UserMigration_Authentication"
+	sourceForgotPassword     = "UserMigration_ForgotPassword"
+	sourcePostAuthentication = "PostAuthentication_Authentication"
+)
+
+// attrLegacyID is the Cognito attribute holding the legacy account ID; the
+// user pool must define it.
+const attrLegacyID = "custom:legacy_id"
+
+// ErrNotMigrated fails the migration of unknown users and wrong passwords
+// alike. Cognito hands its message to the app, which shows it.
+var ErrNotMigrated = errors.New("Incorrect username or password.")
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Legacy legacy.Verifier
+	Users  *users.Repository
+	Events *domain.Publisher
+	Audit  *audit.Store
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// legacy auth API.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	verifier, err := legacy.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Legacy: verifier,
+		Users:  users.NewRepository(client, cfg),
+		Events: domain.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Audit:  audit.NewStore(client, cfg),
+		Config: cfg,
+	}, nil
+}
+
+// triggerProbe reads the trigger source of incoming events.
+type triggerProbe struct {
+	TriggerSource string `json:"triggerSource"`
+}
+
+// Invoke is the Lambda entry point, dispatching on the trigger source.
+func (h *Handler) Invoke(ctx context.Context, payload json.RawMessage) (any, error) {
+	var probe triggerProbe
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, fmt.Errorf("decoding Cognito event: %w", err)
+	}
+	if probe.TriggerSource == sourcePostAuthentication {
+		var event events.CognitoEventUserPoolsPostAuthentication
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding Cognito event: %w", err)
+		}
+		return h.HandlePostAuthentication(ctx, event)
+	}
+	var event events.CognitoEventUserPoolsMigrateUser
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("decoding Cognito event: %w", err)
+	}
+	return h.HandleMigration(ctx, event)
+}
+
+// HandleMigration returns the attributes of the legacy account of event's
+// username, when it exists and, for a sign-in, the password is right.
+// Otherwise it fails with ErrNotMigrated and Cognito refuses the sign-in or
+// reset.
+func (h *Handler) HandleMigration(ctx context.Context, event events.CognitoEventUserPoolsMigrateUser) (events.CognitoEventUserPoolsMigrateUser, error) {
+	ctx, recorder := metrics.NewContext(ctx)
+	defer recorder.Flush()
+
+	var account legacy.Account
+	var err error
+	switch event.TriggerSource {
+	case sourceAuthentication:
+		account, err = h.Legacy.Authenticate(ctx, event.UserName, event.Password)
+	case sourceForgotPassword:
+		account, err = h.Legacy.Lookup(ctx, event.UserName)
+	default:
+		return event, fmt.Errorf("unexpected trigger %q", event.TriggerSource)
+	}
+	if errors.Is(err, legacy.ErrNotFound) || errors.Is(err, legacy.ErrInvalidCredentials) {
+		slog.InfoContext(ctx, "Legacy account not migrated", "source", event.TriggerSource, logging.Err(err))
+		return event, ErrNotMigrated
+	}
+	if err != nil {
+		return event, err
+	}
+	ctx = logging.With(ctx, "legacy_id", account.ID)
+
+	email, err := validation.NormalizeEmail(account.Email, h.Config.StripPlusAlias)
+	if err != nil {
+		return event, fmt.Errorf("legacy account: %w", err)
+	}
+	owner, err := h.Users.Uncached().IDByEmail(ctx, email)
+	if err != nil {
+		return event, err
+	}
+	if owner != "" {
+		slog.WarnContext(ctx, "Legacy account email already registered", "owner", owner)
+		return event, users.ErrEmailTaken
+	}
+
+	event.CognitoEventUserPoolsMigrateUserResponse = events.CognitoEventUserPoolsMigrateUserResponse{
+		UserAttributes: map[string]string{
+			"email":          email,
+			"email_verified": strconv.FormatBool(account.EmailVerified),
+			"name":           account.DisplayName,
+			attrLegacyID:     account.ID,
+		},
+		// The user chose their password long ago; no welcome message
+		MessageAction: "SUPPRESS",
+	}
+	if event.TriggerSource == sourceAuthentication {
+		event.FinalUserStatus = "CONFIRMED"
+	}
+	slog.InfoContext(ctx, "Migrating legacy account", "source", event.TriggerSource)
+	return event, nil
+}
+
+// HandlePostAuthentication creates the record of a migrated user on their
+// first sign-in. Failing makes Cognito fail the sign-in, so a migrated user
+// never gets tokens without a record.
+func (h *Handler) HandlePostAuthentication(ctx context.Context, event events.CognitoEventUserPoolsPostAuthentication) (events.CognitoEventUserPoolsPostAuthentication, error) {
+	attrs := event.Request.UserAttributes
+	legacyID, userID := attrs[attrLegacyID], attrs["sub"]
+	if legacyID == "" {
+		return event, nil
+	}
+	ctx, recorder := metrics.NewContext(ctx)
+	defer recorder.Flush()
+	ctx = logging.With(ctx, "user_id", userID, "legacy_id", legacyID)
+
+	if err := validation.UserID(userID); err != nil {
+		return event, fmt.Errorf("authentication event: %w", err)
+	}
+	existing, err := h.Users.Get(ctx, userID, []string{"user_id"})
+	if err != nil || existing != nil {
+		return event, err
+	}
+	email, err := validation.NormalizeEmail(attrs["email"], h.Config.StripPlusAlias)
+	if err != nil {
+		return event, fmt.Errorf("authentication event: %w", err)
+	}
+
+	name := attrs["name"]
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	ts := time.Now().UTC().Format(time.RFC3339)
+	user := users.User{
+		UserID:        userID,
+		Email:         email,
+		EmailVerified: attrs["email_verified"] == "true", // as the legacy system had it; see HandleMigration
+		DisplayName:   name,
+		Status:        "active",
+		CreatedAt:     ts,
+		UpdatedAt:     ts,
+		Version:       1,
+		LegacyID:      legacyID,
+		MigratedAt:    ts,
+	}
+	err = h.Users.Create(ctx, user)
+	if errors.Is(err, users.ErrUserExists) {
+		// A concurrent sign-in, or a soft-deleted record
+		return event, nil
+	}
+	if err != nil {
+		return event, err
+	}
+
+	slog.InfoContext(ctx, "Migrated user created")
+	metrics.Count(ctx, metrics.UserMigrated)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionUserCreate,
+		Actor:     audit.System(ctx),
+		RequestID: audit.RequestID(ctx, nil),
+		Diff: audit.Diff(nil, map[string]any{
+			"email":        email,
+			"display_name": name,
+			"status":       user.Status,
+			"version":      user.Version,
+			"legacy_id":    legacyID,
+		}),
+		At: ts,
+	})
+	h.Events.Emit(ctx, domain.UserCreated{UserID: userID, Email: email, Name: name, CreatedAt: ts})
+	return event, nil
+}
@@ -0,0 +1,143 @@
+package migrateuser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/legacy"
+	"troggle-backend/internal/users"
+)
+
+// fakeLegacy knows jane, whose password is "hunter2".
+type fakeLegacy struct{}
+
+var jane = legacy.Account{ID: "L42", Email: "Jane@Example.com", EmailVerified: true, DisplayName: "Jane"}
+
+func (fakeLegacy) Authenticate(_ context.Context, username, password string) (legacy.Account, error) {
+	a, err := fakeLegacy{}.Lookup(context.Background(), username)
+	if err == nil && password != "hunter2" {
+		return legacy.Account{}, legacy.ErrInvalidCredentials
+	}
+	return a, err
+}
+
+func (fakeLegacy) Lookup(_ context.Context, username string) (legacy.Account, error) {
+	if username != "jane" {
+		return legacy.Account{}, legacy.ErrNotFound
+	}
+	return jane, nil
+}
+
+func testHandler(m *dbtest.Mock) *Handler {
+	cfg := &config.Config{UserTableName: "users", EmailIndexName: "email-index"}
+	return &Handler{Legacy: fakeLegacy{}, Users: users.NewRepository(m.Client(), cfg), Config: cfg}
+}
+
+func migration(source, username, password string) events.CognitoEventUserPoolsMigrateUser {
+	var e events.CognitoEventUserPoolsMigrateUser
+	e.TriggerSource = source
+	e.UserName = username
+	e.Password = password
+	return e
+}
+
+func TestHandleMigration(t *testing.T) {
+	tests := []struct {
+		name       string
+		event      events.CognitoEventUserPoolsMigrateUser
+		owner      string
+		wantErr    error
+		wantStatus string
+	}{
+		{name: "sign-in", event: migration(sourceAuthentication, "jane", "hunter2"), wantStatus: "CONFIRMED"},
+		{name: "reset", event: migration(sourceForgotPassword, "jane", "")},
+		{name: "wrong password", event: migration(sourceAuthentication, "jane", "hunter3"), wantErr: ErrNotMigrated},
+		{name: "unknown", event: migration(sourceAuthentication, "joe", "hunter2"), wantErr: ErrNotMigrated},
+		{name: "unknown reset", event: migration(sourceForgotPassword, "joe", ""), wantErr: ErrNotMigrated},
+		{name: "email taken", event: migration(sourceAuthentication, "jane", "hunter2"), owner: "u9", wantErr: users.ErrEmailTaken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				if tt.owner == "" {
+					return &dynamodb.QueryOutput{}, nil
+				}
+				return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", tt.owner)}}, nil
+			}}
+			got, err := testHandler(m).HandleMigration(context.Background(), tt.event)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			attrs := got.UserAttributes
+			if attrs["email"] != "jane@example.com" || attrs["email_verified"] != "true" || attrs[attrLegacyID] != "L42" {
+				t.Errorf("attributes = %v", attrs)
+			}
+			if got.FinalUserStatus != tt.wantStatus || got.MessageAction != "SUPPRESS" {
+				t.Errorf("response = %+v", got.CognitoEventUserPoolsMigrateUserResponse)
+			}
+		})
+	}
+}
+
+func authentication(attrs map[string]string) json.RawMessage {
+	var e events.CognitoEventUserPoolsPostAuthentication
+	e.TriggerSource = sourcePostAuthentication
+	e.Request.UserAttributes = attrs
+	payload, _ := json.Marshal(e)
+	return payload
+}
+
+func TestHandlePostAuthentication(t *testing.T) {
+	migrated := map[string]string{"sub": "u1", "email": "jane@example.com", "name": "Jane", attrLegacyID: "L42"}
+
+	t.Run("first sign-in", func(t *testing.T) {
+		m := &dbtest.Mock{}
+		if _, err := testHandler(m).Invoke(context.Background(), authentication(migrated)); err != nil {
+			t.Fatal(err)
+		}
+		if ops := m.Ops(); len(ops) != 2 || ops[1] != "TransactWriteItems" {
+			t.Fatalf("ops = %v, want the record created", ops)
+		}
+		item := m.Calls[1].Input.(*dynamodb.TransactWriteItemsInput).TransactItems[0].Put.Item
+		user, err := db.Decode[users.User](item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.UserID != "u1" || user.LegacyID != "L42" || user.MigratedAt == "" || user.DisplayName != "Jane" {
+			t.Errorf("record = %+v", user)
+		}
+	})
+
+	t.Run("later sign-in", func(t *testing.T) {
+		m := &dbtest.Mock{GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1")}, nil
+		}}
+		if _, err := testHandler(m).Invoke(context.Background(), authentication(migrated)); err != nil {
+			t.Fatal(err)
+		}
+		if ops := m.Ops(); len(ops) != 1 {
+			t.Errorf("ops = %v, want only the record read", ops)
+		}
+	})
+
+	t.Run("not migrated", func(t *testing.T) {
+		m := &dbtest.Mock{}
+		if _, err := testHandler(m).Invoke(context.Background(), authentication(map[string]string{"sub": "u1", "email": "jane@example.com"})); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Calls) != 0 {
+			t.Errorf("ops = %v, want none", m.Ops())
+		}
+	})
+}
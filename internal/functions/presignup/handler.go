@@ -0,0 +1,172 @@
+// Package presignup is the Cognito PreSignUp trigger. It refuses sign-ups
+// whose email domain is blocked, which Cognito reports to the app as the
+// failure of the sign-up, and confirms SSO sign-ups from trusted domains at
+// once.
+//
+// Blocked domains are the disposable email providers of disposable.txt and
+// those of the blocked_signup_domains parameter, subdomains included.
+// Trusted domains are TRUSTED_SIGNUP_DOMAINS. Only sign-ups through an
+// external identity provider are confirmed: anyone can type an address of a
+// trusted domain into the sign-up form, but only its SSO vouches for it.
+// Users created by admins are let through as they are.
+//
+// In waitlist mode (WAITLIST_MODE), sign-ups are also refused unless their
+// address was admitted from the waitlist (see package waitlist). Admissions
+// create the accounts themselves, so this mostly concerns admitted people
+// signing up again, e.g. through SSO.
+package presignup
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events" // Cognito trigger event definitions
+
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/dynconfig"  // Parameter Store settings
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/metrics"    // CloudWatch EMF metrics
+	"troggle-backend/internal/validation" // input normalization and validation
+	"troggle-backend/internal/waitlist"   // waitlist of sign-ups
+)
+
+// Trigger sources of the sign-ups handled differently.
+const (
+	sourceExternalProvider = "PreSignUp_ExternalProvider"
+	sourceAdminCreateUser  = "PreSignUp_AdminCreateUser"
+)
+
+// ErrBlockedDomain fails sign-ups with a blocked email domain. Cognito
+// hands its message to the app, which shows it.
+var ErrBlockedDomain = errors.New("Sign-ups with this email domain are not allowed. Please use a different email address.")
+
+// ErrWaitlisted fails sign-ups in waitlist mode of addresses not admitted
+// from the waitlist.
+var ErrWaitlisted = errors.New("Troggle is invite-only for now. Join the waitlist and we will email you when it is your turn.")
+
+//go:embed disposable.txt
+var disposableList string
+
+// disposable holds the domains of disposable.txt.
+var disposable = parseDomains(disposableList)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Params   *dynconfig.Store // nil blocks disposable domains only
+	Waitlist *waitlist.Store  // nil unless in waitlist mode
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	params, err := dynconfig.Shared(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	h := &Handler{Params: params, Config: cfg}
+	if cfg.WaitlistMode {
+		client, err := db.Shared(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+		}
+		h.Waitlist = waitlist.NewStore(client, cfg)
+	}
+	return h, nil
+}
+
+// Handle refuses the sign-up of event with ErrBlockedDomain when its email
+// domain is blocked, or with ErrWaitlisted in waitlist mode, and otherwise
+// returns event, marked confirmed for SSO sign-ups from trusted domains.
+func (h *Handler) Handle(ctx context.Context, event events.CognitoEventUserPoolsPreSignup) (events.CognitoEventUserPoolsPreSignup, error) {
+	ctx, recorder := metrics.NewContext(ctx)
+	defer recorder.Flush()
+
+	if event.TriggerSource == sourceAdminCreateUser {
+		return event, nil
+	}
+	email := event.Request.UserAttributes["email"]
+	domain := domainOf(email)
+	if domain != "" && h.blocked(ctx, domain) {
+		slog.InfoContext(ctx, "Blocked sign-up", "domain", domain, "source", event.TriggerSource, logging.EmailHash(email))
+		metrics.Count(ctx, metrics.SignupBlocked)
+		return event, ErrBlockedDomain
+	}
+	if h.Waitlist != nil {
+		admitted, err := h.admitted(ctx, email)
+		if err != nil {
+			return event, err
+		}
+		if !admitted {
+			slog.InfoContext(ctx, "Waitlisted sign-up", "source", event.TriggerSource, logging.EmailHash(email))
+			metrics.Count(ctx, metrics.SignupBlocked)
+			return event, ErrWaitlisted
+		}
+	}
+	if event.TriggerSource == sourceExternalProvider && matches(domain, h.Config.TrustedSignupDomains) {
+		event.Response.AutoConfirmUser = true
+		event.Response.AutoVerifyEmail = true
+		slog.InfoContext(ctx, "Confirmed trusted sign-up", "domain", domain)
+	}
+	return event, nil
+}
+
+// admitted reports whether email was admitted from the waitlist. Malformed
+// addresses were not.
+func (h *Handler) admitted(ctx context.Context, email string) (bool, error) {
+	normalized, err := validation.NormalizeEmail(email, h.Config.StripPlusAlias)
+	if err != nil {
+		return false, nil
+	}
+	return h.Waitlist.Admitted(ctx, normalized)
+}
+
+// blocked reports whether domain is disposable or listed in the
+// blocked_signup_domains parameter.
+func (h *Handler) blocked(ctx context.Context, domain string) bool {
+	if matches(domain, disposable) {
+		return true
+	}
+	banned := strings.Split(strings.ToLower(h.Params.String(ctx, dynconfig.BlockedSignupDomains, "")), ",")
+	for i := range banned {
+		banned[i] = strings.TrimSpace(banned[i])
+	}
+	return matches(domain, banned)
+}
+
+// domainOf returns the lower-cased domain of email, or "" when it has none.
+func domainOf(email string) string {
+	i := strings.LastIndexByte(email, '@')
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(email[i+1:])), ".")
+}
+
+// matches reports whether domain is one of domains or a subdomain of one.
+func matches(domain string, domains []string) bool {
+	for _, d := range domains {
+		if d != "" && (domain == d || strings.HasSuffix(domain, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDomains reads one domain per line, skipping blank lines and
+// comments.
+func parseDomains(list string) []string {
+	var domains []string
+	sc := bufio.NewScanner(strings.NewReader(list))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			domains = append(domains, strings.ToLower(line))
+		}
+	}
+	return domains
+}
@@ -0,0 +1,98 @@
+package presignup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/waitlist"
+)
+
+func signup(source, email string) events.CognitoEventUserPoolsPreSignup {
+	var e events.CognitoEventUserPoolsPreSignup
+	e.TriggerSource = source
+	e.Request.UserAttributes = map[string]string{"email": email}
+	return e
+}
+
+func TestHandle(t *testing.T) {
+	h := &Handler{Config: &config.Config{TrustedSignupDomains: []string{"troggle.dev"}}}
+
+	tests := []struct {
+		name        string
+		event       events.CognitoEventUserPoolsPreSignup
+		wantErr     error
+		wantConfirm bool
+	}{
+		{name: "ordinary", event: signup("PreSignUp_SignUp", "jane@example.com")},
+		{name: "disposable", event: signup("PreSignUp_SignUp", "jane@Mailinator.com"), wantErr: ErrBlockedDomain},
+		{name: "disposable subdomain", event: signup("PreSignUp_SignUp", "jane@eu.yopmail.com"), wantErr: ErrBlockedDomain},
+		{name: "lookalike", event: signup("PreSignUp_SignUp", "jane@notmailinator.com")},
+		{name: "disposable SSO", event: signup(sourceExternalProvider, "jane@mailinator.com"), wantErr: ErrBlockedDomain},
+		{name: "trusted SSO", event: signup(sourceExternalProvider, "jane@eng.troggle.dev"), wantConfirm: true},
+		{name: "trusted form", event: signup("PreSignUp_SignUp", "jane@troggle.dev")},
+		{name: "admin", event: signup(sourceAdminCreateUser, "jane@mailinator.com")},
+		{name: "no email", event: signup("PreSignUp_SignUp", "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.Handle(context.Background(), tt.event)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got.Response.AutoConfirmUser != tt.wantConfirm || got.Response.AutoVerifyEmail != tt.wantConfirm {
+				t.Errorf("response = %+v, want confirmed %v", got.Response, tt.wantConfirm)
+			}
+		})
+	}
+}
+
+func TestDisposable(t *testing.T) {
+	if len(disposable) < 10 {
+		t.Fatalf("%d disposable domains parsed", len(disposable))
+	}
+	for _, d := range disposable {
+		if domainOf("x@"+d) != d {
+			t.Errorf("%q is not a lower-case domain", d)
+		}
+	}
+}
+
+func TestHandleWaitlistMode(t *testing.T) {
+	m := &dbtest.Mock{GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		switch k := in.Key["email"].(*types.AttributeValueMemberS).Value; k {
+		case "jane@example.com":
+			return &dynamodb.GetItemOutput{Item: dbtest.Item("email", k, "status", waitlist.StatusAdmitted)}, nil
+		case "sam@example.com":
+			return &dynamodb.GetItemOutput{Item: dbtest.Item("email", k, "status", waitlist.StatusWaiting)}, nil
+		}
+		return &dynamodb.GetItemOutput{}, nil
+	}}
+	cfg := &config.Config{WaitlistTableName: "waitlist"}
+	h := &Handler{Waitlist: waitlist.NewStore(m.Client(), cfg), Config: cfg}
+
+	tests := []struct {
+		name    string
+		event   events.CognitoEventUserPoolsPreSignup
+		wantErr error
+	}{
+		{name: "admitted", event: signup(sourceExternalProvider, "Jane@Example.com")},
+		{name: "waiting", event: signup("PreSignUp_SignUp", "sam@example.com"), wantErr: ErrWaitlisted},
+		{name: "not on the waitlist", event: signup("PreSignUp_SignUp", "kim@example.com"), wantErr: ErrWaitlisted},
+		{name: "no email", event: signup("PreSignUp_SignUp", ""), wantErr: ErrWaitlisted},
+		{name: "admin", event: signup(sourceAdminCreateUser, "kim@example.com")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := h.Handle(context.Background(), tt.event); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Package processavatar turns avatar uploads into thumbnails. The avatar
+// bucket's ObjectCreated notifications for uploads/ invoke it; for each
+// upload it checks that the bytes are an image of the declared type and
+// within the limits, writes metadata-free thumbnails, points the user record
+// at them and deletes the upload. Rejected uploads are quarantined. See
+// packages avatars and media.
+package processavatar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"github.com/aws/aws-lambda-go/events"              // Lambda event payloads
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge" // EventBridge client
+	"github.com/aws/aws-sdk-go-v2/service/s3"          // S3 client
+
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"         // audit log
+	"troggle-backend/internal/avatars"       // avatar storage
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	domain "troggle-backend/internal/events" // domain event publishing
+	"troggle-backend/internal/logging"       // structured JSON logging
+	"troggle-backend/internal/metrics"       // CloudWatch EMF metrics
+	"troggle-backend/internal/users"         // user table access
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Avatars *avatars.Store
+	Users   *users.Repository // cache invalidated after each change
+	Events  *domain.Publisher
+	Audit   *audit.Store
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// avatar bucket and its public URL.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireAvatars(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Avatars: avatars.NewStore(s3.NewFromConfig(awsCfg), client, cfg),
+		Users:   users.NewRepository(client, cfg),
+		Events:  domain.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Audit:   audit.NewStore(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// errNoUser reports an upload of a user deleted while it was in flight.
+var errNoUser = errors.New("user no longer exists")
+
+// Handle processes every upload of the notification. Rejected uploads are
+// logged and quarantined; any other failure fails the invocation so Lambda
+// retries it, which is safe because thumbnails are overwritten and the
+// upload is deleted last.
+func (h *Handler) Handle(ctx context.Context, event events.S3Event) error {
+	ctx, rec := metrics.NewContext(ctx)
+	defer rec.Flush()
+
+	var errs []error
+	for _, r := range event.Records {
+		// Keys arrive URL-encoded, spaces as '+'
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping undecodable object key", "key", r.S3.Object.Key)
+			continue
+		}
+		userID, uploadID, ok := avatars.ParseUploadKey(key)
+		if !ok {
+			slog.WarnContext(ctx, "Skipping object outside uploads/", "key", key)
+			continue
+		}
+		if err := h.process(logging.With(ctx, "user_id", userID, "upload_id", uploadID), key, userID, uploadID); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// process handles the upload at key.
+func (h *Handler) process(ctx context.Context, key, userID, uploadID string) error {
+	avatar, previous, version, err := h.apply(ctx, key, userID, uploadID)
+	if errors.Is(err, avatars.ErrRejected) {
+		dest, qerr := h.Avatars.Quarantine(ctx, key, err.Error())
+		slog.WarnContext(ctx, "Avatar upload rejected", "quarantine_key", dest, logging.Err(err))
+		metrics.Count(ctx, metrics.AvatarRejected)
+		return qerr
+	}
+	if errors.Is(err, errNoUser) {
+		slog.InfoContext(ctx, "Discarding avatar upload of deleted user")
+		return h.Avatars.Delete(ctx, append(avatars.ThumbnailKeys(userID, uploadID), key)...)
+	}
+	if err != nil || avatar.ID == "" {
+		return err
+	}
+
+	h.Users.Invalidate(userID, "")
+	slog.InfoContext(ctx, "Avatar updated", "avatar_url", avatar.URL, "version", version)
+	metrics.Count(ctx, metrics.AvatarProcessed)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionUserUpdate,
+		Actor:     audit.System(ctx),
+		RequestID: audit.RequestID(ctx, nil),
+		Diff:      map[string]audit.Change{"avatar_url": {Before: nilIfEmpty(previous.URL), After: avatar.URL}},
+	})
+	h.Events.Emit(ctx, domain.ProfileUpdated{
+		UserID:    userID,
+		Changed:   []string{"avatar_url"},
+		Version:   version,
+		UpdatedAt: domain.Now(),
+	})
+
+	// Nothing refers to the upload or the replaced avatar any more
+	stale := []string{key}
+	if previous.ID != "" && previous.ID != avatar.ID {
+		stale = append(stale, avatars.ThumbnailKeys(userID, previous.ID)...)
+	}
+	if err := h.Avatars.Delete(ctx, stale...); err != nil {
+		slog.WarnContext(ctx, "Failed to delete stale avatar objects", logging.Err(err))
+	}
+	return nil
+}
+
+// apply makes the upload at key the user's avatar and returns it with the
+// avatar it replaced and the new profile version. An upload that is already
+// gone returns a zero avatar.
+func (h *Handler) apply(ctx context.Context, key, userID, uploadID string) (avatar, previous avatars.Avatar, version int, err error) {
+	image, contentType, err := h.Avatars.Fetch(ctx, key)
+	if err != nil {
+		return avatar, previous, 0, err
+	}
+	if image == nil {
+		slog.InfoContext(ctx, "Avatar upload already processed")
+		return avatar, previous, 0, nil
+	}
+	avatar, err = h.Avatars.Save(ctx, userID, uploadID, image, contentType)
+	if err != nil {
+		return avatar, previous, 0, err
+	}
+
+	previous, version, err = h.Avatars.Assign(ctx, userID, avatar)
+	if apperr.KindOf(err) == apperr.KindNotFound {
+		return avatar, previous, 0, errNoUser
+	}
+	return avatar, previous, version, err
+}
+
+// nilIfEmpty keeps an absent attribute out of the audit diff.
+func nilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,199 @@
+package processavatar
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"troggle-backend/internal/avatars"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/users"
+)
+
+// fakeS3 is a bucket holding objects, recording writes and deletes.
+type fakeS3 struct {
+	objects map[string][]byte
+	types   map[string]string
+	put     []string
+	copied  []string
+	deleted []string
+}
+
+func (f *fakeS3) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	key := aws.ToString(in.Key)
+	body, ok := f.objects[key]
+	if !ok {
+		return nil, &s3types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentType:   aws.String(f.types[key]),
+		ContentLength: aws.Int64(int64(len(body))),
+	}, nil
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.put = append(f.put, aws.ToString(in.Key))
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) CopyObject(_ context.Context, in *s3.CopyObjectInput, _ ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	f.copied = append(f.copied, aws.ToString(in.Key))
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(_ context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.deleted = append(f.deleted, aws.ToString(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// notification is an ObjectCreated event of key.
+func notification(key string) events.S3Event {
+	var r events.S3EventRecord
+	r.EventName = "ObjectCreated:Put"
+	r.S3.Object.Key = key
+	return events.S3Event{Records: []events.S3EventRecord{r}}
+}
+
+func pngImage(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHandle(t *testing.T) {
+	upload := avatars.UploadKey("u1", "a2")
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		missing     bool // the upload was already processed
+		noUser      bool
+		wantPut     int
+		wantUpdate  bool
+		quarantined bool
+		wantDeleted []string
+	}{
+		{
+			name:        "accepted",
+			contentType: "image/png",
+			body:        pngImage(t),
+			wantPut:     len(avatars.Sizes),
+			wantUpdate:  true,
+			// The upload and the thumbnails of the replaced avatar a1
+			wantDeleted: append([]string{upload}, avatars.ThumbnailKeys("u1", "a1")...),
+		},
+		{
+			name:        "not an image",
+			contentType: "image/png",
+			body:        []byte("<script>"),
+			quarantined: true,
+			wantDeleted: []string{upload},
+		},
+		{
+			name:        "wrong content type",
+			contentType: "text/html",
+			body:        pngImage(t),
+			quarantined: true,
+			wantDeleted: []string{upload},
+		},
+		{
+			name:        "declared type does not match the bytes",
+			contentType: "image/gif",
+			body:        pngImage(t),
+			quarantined: true,
+			wantDeleted: []string{upload},
+		},
+		{
+			name:        "oversized",
+			contentType: "image/png",
+			body:        make([]byte, 1<<20+1),
+			quarantined: true,
+			wantDeleted: []string{upload},
+		},
+		{
+			name:        "user deleted meanwhile",
+			contentType: "image/png",
+			body:        pngImage(t),
+			noUser:      true,
+			wantPut:     len(avatars.Sizes),
+			wantUpdate:  true,
+			wantDeleted: append(avatars.ThumbnailKeys("u1", "a2"), upload),
+		},
+		{name: "redelivered", missing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := &fakeS3{objects: map[string][]byte{}, types: map[string]string{}}
+			if !tt.missing {
+				bucket.objects[upload], bucket.types[upload] = tt.body, tt.contentType
+			}
+			m := &dbtest.Mock{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				if tt.noUser {
+					return nil, dbtest.ConditionFailed()
+				}
+				return &dynamodb.UpdateItemOutput{Attributes: dbtest.Item("avatar_id", "a1", "avatar_url", "https://cdn.example/avatars/u1/a1/512.jpg")}, nil
+			}}
+			cfg := &config.Config{UserTableName: "users"}
+			h := &Handler{
+				Avatars: &avatars.Store{S3: bucket, DB: m.Client(), Bucket: "bucket", BaseURL: "https://cdn.example", MaxBytes: 1 << 20, UserTable: "users"},
+				Users:   &users.Repository{},
+				Config:  cfg,
+			}
+
+			if err := h.Handle(context.Background(), notification(upload)); err != nil {
+				t.Fatal(err)
+			}
+			if len(bucket.put) != tt.wantPut {
+				t.Errorf("%d thumbnails written, want %d", len(bucket.put), tt.wantPut)
+			}
+			if updated := len(m.Calls) > 0; updated != tt.wantUpdate {
+				t.Errorf("user record updated = %v, want %v", updated, tt.wantUpdate)
+			}
+			if quarantined := len(bucket.copied) == 1 && bucket.copied[0] == avatars.QuarantineKey(upload); quarantined != tt.quarantined {
+				t.Errorf("copied %v, quarantined want %v", bucket.copied, tt.quarantined)
+			}
+			sort.Strings(bucket.deleted)
+			sort.Strings(tt.wantDeleted)
+			if !reflect.DeepEqual(bucket.deleted, tt.wantDeleted) && len(bucket.deleted)+len(tt.wantDeleted) > 0 {
+				t.Errorf("deleted %v, want %v", bucket.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestHandleAssignsLargestThumbnail(t *testing.T) {
+	upload := avatars.UploadKey("u1", "a2")
+	bucket := &fakeS3{objects: map[string][]byte{upload: pngImage(t)}, types: map[string]string{upload: "image/png"}}
+	m := &dbtest.Mock{}
+	h := &Handler{
+		Avatars: &avatars.Store{S3: bucket, DB: m.Client(), Bucket: "bucket", BaseURL: "https://cdn.example", MaxBytes: 1 << 20, UserTable: "users"},
+		Users:   &users.Repository{},
+		Config:  &config.Config{},
+	}
+
+	if err := h.Handle(context.Background(), notification(upload)); err != nil {
+		t.Fatal(err)
+	}
+	in := m.Calls[0].Input.(*dynamodb.UpdateItemInput)
+	want := dbtest.Item("v", "https://cdn.example/avatars/u1/a2/512.jpg")["v"]
+	if got := in.ExpressionAttributeValues[":url"]; !reflect.DeepEqual(got, want) {
+		t.Errorf(":url = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,157 @@
+// Package publishpolicy publishes a new version of the terms of service or
+// the privacy policy (POST /policies/{policy}/versions), for admins. The
+// text itself is published elsewhere, at the version's URL; the backend
+// numbers the versions and tracks who accepted which. See package policies.
+//
+// Publishing a required version makes it pending for every user: within a
+// minute, the responses to users who have not accepted it carry the
+// Policy-Acceptance-Required header, and clients prompt them. Versions that
+// are not required, such as corrections, are shown to users who look but
+// prompt nobody.
+package publishpolicy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apikeys"    // API keys of server-to-server callers
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/policies"   // terms and privacy policy acceptance
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON input. API Gateway callers name the policy
+// in the path.
+type Request struct {
+	Policy   string `json:"policy"`
+	URL      string `json:"url" validate:"required,max=2048"`
+	Summary  string `json:"summary,omitempty" validate:"max=500"`
+	Required bool   `json:"required"` // users must accept it
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "publishPolicy",
+	Function: "publishPolicy",
+	Summary:  "Publishes a new version of the terms or the privacy policy",
+	Method:   "POST",
+	Path:     "/policies/{policy}/versions",
+	Request:  Request{},
+	Status:   201,
+	Response: policies.Version{},
+	Scopes:   []string{"troggle/admin", "policies:publish"},
+	Groups:   []string{"admin"},
+	APIKey:   true,
+}}
+
+// authorize lets callers holding the policies:publish permission only
+// publish. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request) error {
+	if r.Direct {
+		return nil
+	}
+	return authz.Require(ctx, authz.PoliciesPublish)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Policies *policies.Store
+	Auth     auth.TokenVerifier
+	APIKey   *apikeys.Middleware // nil accepts bearer tokens only
+	Audit    *audit.Store
+	Config   *config.Config
+	Now      func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Policies: policies.NewStore(client, cfg),
+		Auth:     verifier,
+		APIKey:   apikeys.NewMiddleware(client, cfg),
+		Audit:    audit.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth), validation.Body[Request]())
+}
+
+// Handle publishes the next version of the policy and answers 201 with it.
+// A version published concurrently fails the request with 409
+// CONCURRENT_PUBLISH.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.Policy = r.PathParams["policy"]
+	}
+	if err := authorize(ctx, r); err != nil {
+		return httpx.Error(err), nil
+	}
+	if !policies.Valid(req.Policy) {
+		return httpx.Error(policies.ErrUnknownPolicy), nil
+	}
+	if u, err := url.Parse(req.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+		return httpx.Error(apperr.Invalid("INVALID_URL", "url", "url must be an absolute https URL")), nil
+	}
+
+	actor := audit.ActorOf(ctx, r)
+	v, err := h.Policies.Publish(ctx, policies.Version{
+		Policy:      req.Policy,
+		URL:         req.URL,
+		Summary:     req.Summary,
+		Required:    req.Required,
+		PublishedBy: actor.ActorID,
+	}, h.now())
+	if apperr.KindOf(err) == apperr.KindConflict {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+
+	slog.InfoContext(ctx, "Policy published", "policy", v.Policy, "version", v.Version, "required", v.Required)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.PolicyResource(v.Policy),
+		Action:    audit.ActionPolicyPublish,
+		Actor:     actor,
+		RequestID: audit.RequestID(ctx, r),
+		Diff: audit.Diff(nil, map[string]any{
+			"version":  v.Version,
+			"url":      v.URL,
+			"required": v.Required,
+		}),
+	})
+	return httpx.JSON(201, v), nil
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
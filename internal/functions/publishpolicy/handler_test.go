@@ -0,0 +1,99 @@
+package publishpolicy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/policies"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a REST API event of the versions route of policy.
+func apiEvent(policy, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/policies/" + policy + "/versions",
+		"pathParameters": map[string]string{"policy": policy},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	admin := &auth.Identity{Subject: "a1", Groups: []string{authz.Admin}}
+	tests := []struct {
+		name        string
+		caller      *auth.Identity
+		policy      string
+		body        string
+		wantStatus  int
+		wantVersion int64
+	}{
+		{name: "required", caller: admin, policy: policies.Terms, body: `{"url":"https://troggle.example/terms/3","required":true}`, wantStatus: 201, wantVersion: 3},
+		{name: "unknown policy", caller: admin, policy: "cookies", body: `{"url":"https://troggle.example/cookies"}`, wantStatus: 422},
+		{name: "plain http", caller: admin, policy: policies.Terms, body: `{"url":"http://troggle.example/terms/3"}`, wantStatus: 422},
+		{name: "no URL", caller: admin, policy: policies.Terms, body: `{}`, wantStatus: 422},
+		{name: "user", caller: &auth.Identity{Subject: "u1"}, policy: policies.Terms, body: `{"url":"https://troggle.example/terms/3"}`, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				prev := policies.Version{Policy: policies.Terms, Version: 2, RequiredVersion: 1}
+				return &dynamodb.QueryOutput{Items: []db.Item{db.MustEncode(prev)}}, nil
+			}}
+			cfg := &config.Config{PolicyTableName: "policies", UserDataTableName: "user-data"}
+			h := &Handler{
+				Policies: policies.NewStore(m.Client(), cfg),
+				Auth:     stubVerifier{tt.caller},
+				Config:   cfg,
+				Now:      func() time.Time { return time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC) },
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.policy, tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 201 {
+				if len(m.Ops()) > 0 && m.Ops()[len(m.Ops())-1] == "TransactWriteItems" {
+					t.Errorf("ops = %v, want nothing published", m.Ops())
+				}
+				return
+			}
+
+			var got policies.Version
+			if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Version != tt.wantVersion || got.RequiredVersion != tt.wantVersion || got.PublishedAt != "2026-10-15T09:00:00Z" {
+				t.Errorf("published %+v, want required version %d", got, tt.wantVersion)
+			}
+			put := m.Calls[len(m.Calls)-1].Input.(*dynamodb.TransactWriteItemsInput).TransactItems[0].Put
+			if v, err := db.Decode[policies.Version](put.Item); err != nil || v.PublishedBy != "a1" {
+				t.Errorf("stored %+v, want published by the caller", v)
+			}
+		})
+	}
+}
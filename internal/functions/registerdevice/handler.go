@@ -0,0 +1,129 @@
+// Package registerdevice records the push notification token of an app
+// install, so the user's notifications reach that device.
+package registerdevice
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/devices"    // device token registry
+	"troggle-backend/internal/events"     // domain event publishing
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"   // revocation-checking token verifier
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON body. API Gateway callers register devices of
+// their own account; direct invocations name the user.
+type Request struct {
+	UserID     string `json:"user_id" api:"direct"` // direct invocations only
+	Platform   string `json:"platform"`
+	Token      string `json:"token" validate:"required"`
+	AppVersion string `json:"app_version" validate:"max=64"`
+	Model      string `json:"model" validate:"max=64"`
+	Sandbox    bool   `json:"sandbox"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "registerDevice",
+	Function: "registerDevice",
+	Summary:  "Registers the push notification token of a device",
+	Method:   "POST",
+	Path:     "/devices",
+	Request:  Request{},
+	Status:   201,
+	Response: devices.Device{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Devices *devices.Store
+	Auth    auth.TokenVerifier
+	Events  *events.Publisher
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Devices: devices.NewStore(client, cfg),
+		Auth:    verifier,
+		Events:  events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), validation.Body[Request]())
+}
+
+// Handle registers the token and returns the device: 201 when the token is
+// new for the user, 200 when an existing registration was refreshed.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req.UserID = id.Subject
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	platform, token, err := devices.NormalizeToken(req.Platform, req.Token)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	device, created, err := h.Devices.Register(ctx, devices.Device{
+		UserID:     req.UserID,
+		Token:      token,
+		Platform:   platform,
+		AppVersion: req.AppVersion,
+		Model:      req.Model,
+		Sandbox:    req.Sandbox && platform == devices.PlatformAPNs,
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if !created {
+		slog.DebugContext(ctx, "Device registration refreshed", "user_id", device.UserID, "platform", device.Platform)
+		return httpx.JSON(200, device), nil
+	}
+	slog.InfoContext(ctx, "Device registered", "user_id", device.UserID, "platform", device.Platform)
+	h.Events.Emit(ctx, events.DeviceRegistered{
+		UserID:       device.UserID,
+		Platform:     device.Platform,
+		RegisteredAt: device.RegisteredAt.Format(time.RFC3339),
+	})
+	return httpx.JSON(201, device), nil
+}
@@ -0,0 +1,117 @@
+package registerdevice
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/devices"
+	"troggle-backend/internal/httpx"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a POST /devices REST API event.
+func apiEvent(token, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/devices",
+		"headers":    map[string]string{"Authorization": "Bearer " + token},
+		"body":       body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	apns := strings.Repeat("ab", 32)
+	refreshed := func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		return &dynamodb.UpdateItemOutput{Attributes: dbtest.Item("user_id", "u1", "token", apns)}, nil
+	}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		update     func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+		wantStatus int
+		wantBody   []string
+		wantAbsent string // must not appear in the body
+	}{
+		{
+			name:       "new token",
+			payload:    apiEvent("valid", `{"platform":"apns","token":"`+apns+`","app_version":"2.1.0","sandbox":true}`),
+			wantStatus: 201,
+			wantBody:   []string{`"user_id":"u1"`, `"platform":"apns"`, `"sandbox":true`},
+		},
+		{
+			name:       "refreshed",
+			payload:    apiEvent("valid", `{"platform":"apns","token":"`+apns+`"}`),
+			update:     refreshed,
+			wantStatus: 200,
+		},
+		{
+			name:       "sandbox ignored for fcm",
+			payload:    json.RawMessage(`{"user_id":"u2","platform":"fcm","token":"abc:def","sandbox":true}`),
+			wantStatus: 201,
+			wantBody:   []string{`"user_id":"u2"`, `"platform":"fcm"`},
+			wantAbsent: "sandbox",
+		},
+		{
+			name:       "unauthenticated",
+			payload:    apiEvent("", `{"platform":"fcm","token":"abc"}`),
+			wantStatus: 401,
+		},
+		{
+			name:       "bad platform",
+			payload:    apiEvent("valid", `{"platform":"wns","token":"abc"}`),
+			wantStatus: 422,
+			wantBody:   []string{"PLATFORM_INVALID"},
+		},
+		{
+			name:       "long model",
+			payload:    apiEvent("valid", `{"platform":"fcm","token":"abc","model":"`+strings.Repeat("m", 65)+`"}`),
+			wantStatus: 422,
+			wantBody:   []string{`"field":"model","code":"TOO_LONG"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{UpdateItemFunc: tt.update}
+			h := &Handler{
+				Devices: &devices.Store{DB: m.Client(), Table: "devices", TokenIndex: "token-index", TTL: time.Hour},
+				Auth:    stubVerifier{id: &auth.Identity{Subject: "u1"}},
+				Config:  &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(resp.Body, want) {
+					t.Errorf("body = %s, want it to contain %s", resp.Body, want)
+				}
+			}
+			if tt.wantAbsent != "" && strings.Contains(resp.Body, tt.wantAbsent) {
+				t.Errorf("body = %s, want no %s", resp.Body, tt.wantAbsent)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Package removerelationship ends a relationship with another user:
+//
+//	DELETE /users/{user_id}/friends/{other_id}          unfriends
+//	DELETE /users/{user_id}/following/{other_id}        unfollows
+//	DELETE /users/{user_id}/friend-requests/{other_id}  withdraws or declines a request
+//
+// Removing a relationship that does not exist is not an error. See package
+// relationships.
+package removerelationship
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// Relationships that can be removed, named after the collection in the path.
+const (
+	Friends        = "friends"
+	Following      = "following"
+	FriendRequests = "friend-requests"
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the same values in the path.
+type Request struct {
+	UserID       string `json:"user_id"`
+	OtherID      string `json:"other_id"`
+	Relationship string `json:"relationship"` // one of Friends, Following or FriendRequests
+}
+
+// authorize lets callers remove their own relationships only, unless they
+// are admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only change your own relationships")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "removeFriend",
+		Function: "removeRelationship",
+		Summary:  "Unfriends a user",
+		Method:   "DELETE",
+		Path:     "/users/{user_id}/friends/{other_id}",
+		Status:   204,
+	},
+	{
+		Name:     "removeFriendRequest",
+		Function: "removeRelationship",
+		Summary:  "Withdraws or declines a friend request",
+		Method:   "DELETE",
+		Path:     "/users/{user_id}/friend-requests/{other_id}",
+		Status:   204,
+	},
+	{
+		Name:     "unfollowUser",
+		Function: "removeRelationship",
+		Summary:  "Unfollows a user",
+		Method:   "DELETE",
+		Path:     "/users/{user_id}/following/{other_id}",
+		Status:   204,
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Graph  *relationships.Store
+	Users  *users.Repository
+	Auth   auth.TokenVerifier
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Graph:  relationships.NewStore(client, cfg),
+		Users:  users.NewRepository(client, cfg),
+		Auth:   verifier,
+		Config: cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle removes the relationship the path names.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{
+		UserID:       r.PathParams["user_id"],
+		OtherID:      r.PathParams["other_id"],
+		Relationship: path.Base(path.Dir(r.Path)),
+	}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	for _, id := range []string{req.UserID, req.OtherID} {
+		if err := validation.UserID(id); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	var err error
+	switch req.Relationship {
+	case Friends:
+		err = h.Graph.Unfriend(ctx, req.UserID, req.OtherID)
+	case Following:
+		err = h.Graph.Unfollow(ctx, req.UserID, req.OtherID)
+	case FriendRequests:
+		err = h.Graph.RemoveRequests(ctx, req.UserID, req.OtherID)
+	default:
+		return httpx.Error(apperr.Invalid("RELATIONSHIP_INVALID", "relationship", "relationship must be friends, following or friend-requests")), nil
+	}
+	switch {
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+
+	if req.Relationship != FriendRequests {
+		h.Users.Invalidate(req.UserID, "")
+		h.Users.Invalidate(req.OtherID, "")
+	}
+	slog.InfoContext(ctx, "Relationship removed", "user_id", req.UserID, "other_id", req.OtherID, "relationship", req.Relationship)
+	return httpx.NoContent(), nil
+}
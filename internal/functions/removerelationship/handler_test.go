@@ -0,0 +1,82 @@
+package removerelationship
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/users"
+)
+
+// apiEvent is a DELETE /users/{user_id}/<collection>/{other_id} REST API
+// event.
+func apiEvent(userID, collection, otherID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "DELETE",
+		"path":           "/users/" + userID + "/" + collection + "/" + otherID,
+		"pathParameters": map[string]string{"user_id": userID, "other_id": otherID},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		wantStatus int
+		wantEdges  []string // of user_id deleted
+	}{
+		{name: "unfriend", payload: apiEvent("u1", Friends, "u2"), wantStatus: 204, wantEdges: []string{"FRIEND#u2"}},
+		{name: "unfollow", payload: apiEvent("u1", Following, "u2"), wantStatus: 204, wantEdges: []string{"FOLLOWING#u2"}},
+		{
+			name: "requests", payload: apiEvent("u1", FriendRequests, "u2"), wantStatus: 204,
+			wantEdges: []string{"REQUEST_OUT#u2", "REQUEST_IN#u2"},
+		},
+		{name: "another user's", payload: apiEvent("u2", Friends, "u3"), wantStatus: 403},
+		{
+			name:       "direct",
+			payload:    json.RawMessage(`{"user_id":"u1","other_id":"u2","relationship":"following"}`),
+			wantStatus: 204, wantEdges: []string{"FOLLOWING#u2"},
+		},
+		{name: "direct without relationship", payload: json.RawMessage(`{"user_id":"u1","other_id":"u2"}`), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var edges []string
+			m := &dbtest.Mock{TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				for _, w := range in.TransactItems {
+					if w.Delete != nil && w.Delete.Key["user_id"].(*types.AttributeValueMemberS).Value == "u1" {
+						edges = append(edges, w.Delete.Key["edge"].(*types.AttributeValueMemberS).Value)
+					}
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}}
+			h := &Handler{
+				Graph:  &relationships.Store{DB: m.Client(), Table: "relationships", UserTable: "users"},
+				Users:  &users.Repository{DB: m.Client(), Table: "users"},
+				Config: &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !reflect.DeepEqual(edges, tt.wantEdges) {
+				t.Errorf("deleted edges of u1 = %v, want %v", edges, tt.wantEdges)
+			}
+		})
+	}
+}
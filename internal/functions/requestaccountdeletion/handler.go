@@ -0,0 +1,169 @@
+// Package requestaccountdeletion lets users ask for their account to be
+// erased (POST /users/{user_id}/deletion) and change their mind
+// (DELETE /users/{user_id}/deletion) during the grace period. The erasure
+// itself is run by deleteUser; see package erasure.
+package requestaccountdeletion
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/erasure"    // deletion grace period
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/mfa"        // step-up of high-risk operations
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON input of a direct invocation.
+type Request struct {
+	UserID string `json:"user_id"`
+	Cancel bool   `json:"cancel,omitempty"` // cancel the pending deletion instead
+}
+
+// authorize lets callers manage the deletion of their own account only,
+// unless they are admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only delete your own account")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{
+	{
+		Name:     "requestAccountDeletion",
+		Function: "requestAccountDeletion",
+		Summary:  "Schedules the erasure of an account",
+		Method:   "POST",
+		Path:     "/users/{user_id}/deletion",
+		Status:   202,
+		Response: erasure.Pending{},
+	},
+	{
+		Name:     "cancelAccountDeletion",
+		Function: "requestAccountDeletion",
+		Summary:  "Cancels a scheduled erasure",
+		Method:   "DELETE",
+		Path:     "/users/{user_id}/deletion",
+		Status:   204,
+	},
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Erasure *erasure.Store
+	Users   *users.Repository // cache invalidated after each change
+	Auth    auth.TokenVerifier
+	MFA     *mfa.Guard // nil skips the step-up check
+	Audit   *audit.Store
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Erasure: erasure.NewStore(client, cfg),
+		Users:   users.NewRepository(client, cfg),
+		Auth:    verifier,
+		MFA:     mfa.NewGuard(client, cfg),
+		Audit:   audit.NewStore(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle schedules the deletion of the account named by the user_id path
+// parameter and answers 202 with the schedule, or cancels it (DELETE) and
+// answers 204. Scheduling is a high-risk operation: callers with a second
+// factor must have signed in recently (see package mfa).
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"], Cancel: r.Method == "DELETE"}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	if req.Cancel {
+		cancelled, err := h.Erasure.Cancel(ctx, req.UserID)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		if !cancelled {
+			return httpx.Error(apperr.NotFound("No deletion is pending")), nil
+		}
+		h.Users.Invalidate(req.UserID, "")
+		slog.InfoContext(ctx, "Account deletion cancelled", "user_id", req.UserID)
+		h.Audit.Log(ctx, audit.Entry{
+			Resource:  audit.UserResource(req.UserID),
+			Action:    audit.ActionDeletionCancel,
+			Actor:     audit.ActorOf(ctx, r),
+			RequestID: audit.RequestID(ctx, r),
+			Diff:      map[string]audit.Change{"status": {Before: erasure.StatusPendingDeletion}},
+		})
+		return httpx.NoContent(), nil
+	}
+
+	if err := h.MFA.Require(ctx, r); err != nil {
+		if kind := apperr.KindOf(err); kind == apperr.KindForbidden || kind == apperr.KindUnauthorized {
+			return httpx.Error(err), nil
+		}
+		return httpx.Response{}, err
+	}
+	pending, created, err := h.Erasure.Request(ctx, req.UserID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if created {
+		h.Users.Invalidate(req.UserID, "")
+		slog.InfoContext(ctx, "Account deletion requested", "user_id", req.UserID, "scheduled_at", pending.ScheduledAt)
+		h.Audit.Log(ctx, audit.Entry{
+			Resource:  audit.UserResource(req.UserID),
+			Action:    audit.ActionDeletionRequest,
+			Actor:     audit.ActorOf(ctx, r),
+			RequestID: audit.RequestID(ctx, r),
+			Diff: map[string]audit.Change{
+				"status":                {After: erasure.StatusPendingDeletion},
+				"deletion_scheduled_at": {After: pending.ScheduledAt},
+			},
+		})
+	}
+	return httpx.JSON(202, pending), nil
+}
@@ -0,0 +1,129 @@
+package requestaccountdeletion
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/erasure"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a REST API event of the deletion route.
+func apiEvent(method, userID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     method,
+		"path":           "/users/" + userID + "/deletion",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	owner := &auth.Identity{Subject: "u1"}
+	other := &auth.Identity{Subject: "u2"}
+	admin := &auth.Identity{Subject: "a1", Groups: []string{authz.Admin}}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		pending    bool // a deletion is already pending
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:    "request",
+			payload: apiEvent("POST", "u1"), caller: owner,
+			wantStatus: 202, wantBody: `"status":"pending_deletion"`,
+		},
+		{
+			name:    "request again keeps the schedule",
+			payload: apiEvent("POST", "u1"), caller: owner, pending: true,
+			wantStatus: 202, wantBody: `"deletion_scheduled_at":"2024-05-31T00:00:00Z"`,
+		},
+		{
+			name:    "admin",
+			payload: apiEvent("POST", "u1"), caller: admin,
+			wantStatus: 202,
+		},
+		{
+			name:    "another user",
+			payload: apiEvent("POST", "u1"), caller: other,
+			wantStatus: 403,
+		},
+		{
+			name:    "cancel",
+			payload: apiEvent("DELETE", "u1"), caller: owner, pending: true,
+			wantStatus: 204,
+		},
+		{
+			name:    "cancel with nothing pending",
+			payload: apiEvent("DELETE", "u1"), caller: owner,
+			wantStatus: 404,
+		},
+		{
+			name:       "direct cancel",
+			payload:    json.RawMessage(`{"user_id":"u1","cancel":true}`),
+			pending:    true,
+			wantStatus: 204,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					// Requests need no pending deletion, cancellations one
+					cancel := strings.Contains(*in.UpdateExpression, "REMOVE")
+					if cancel != tt.pending {
+						return nil, dbtest.ConditionFailed()
+					}
+					return &dynamodb.UpdateItemOutput{}, nil
+				},
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: dbtest.Item(
+						"user_id", "u1", "status", erasure.StatusPendingDeletion,
+						"deletion_requested_at", "2024-05-01T00:00:00Z", "deletion_scheduled_at", "2024-05-31T00:00:00Z",
+					)}, nil
+				},
+			}
+			h := &Handler{
+				Erasure: &erasure.Store{DB: m.Client(), Table: "users", Grace: 30 * 24 * time.Hour},
+				Users:   &users.Repository{},
+				Auth:    stubVerifier{tt.caller},
+				Config:  &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+// Package requestemailchange starts a change of a user's email address
+// (POST /users/{user_id}/email-change). Nothing is changed yet: the new
+// address is sent a link carrying a signed token (see package emailchange),
+// which confirmEmailChange redeems, and the current address is told a change
+// was asked for, so a hijacked session cannot move an account away quietly.
+package requestemailchange
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs" // SQS client for the email queue
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"       // audit log
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/email"       // transactional email
+	"troggle-backend/internal/emailchange" // email change tokens
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"     // structured JSON logging
+	"troggle-backend/internal/mfa"         // step-up of high-risk operations
+	"troggle-backend/internal/ratelimit"   // DynamoDB token buckets
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/users"       // user table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// rateLimits keep accounts from flooding mailboxes with confirmation links.
+// They can be tuned per stage through RATE_LIMIT_PER_IP and
+// RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(20),
+	PerUser: ratelimit.Limit{Rate: 5.0 / 3600, Burst: 5},
+}
+
+// ErrSameEmail refuses changes to the address the account already has.
+var ErrSameEmail = apperr.Invalid("SAME_EMAIL", "email", "This is already the email address of the account")
+
+// Request represents the JSON input. API Gateway callers name the account
+// in the path.
+type Request struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"` // the new address
+}
+
+// Pending is the response: where the link went and until when it works.
+type Pending struct {
+	Email     string `json:"email"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "requestEmailChange",
+	Function: "requestEmailChange",
+	Summary:  "Sends a link confirming a new email address",
+	Method:   "POST",
+	Path:     "/users/{user_id}/email-change",
+	Status:   202,
+	Request:  Request{},
+	Response: Pending{},
+}}
+
+// authorize lets callers change their own address only: the link goes to
+// the new address, which must be the user's. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID {
+		return apperr.Forbidden("You may only change your own email address")
+	}
+	return nil
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users   *users.Repository
+	Tokens  *emailchange.Codec
+	Email   *email.Queue
+	Auth    auth.TokenVerifier
+	Limiter *ratelimit.Limiter
+	Limits  ratelimit.Policy
+	MFA     *mfa.Guard // nil skips the step-up check
+	Audit   *audit.Store
+	Config  *config.Config
+	Now     func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// email queue and the confirmation link.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireEmailChange(); err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	queue, err := email.NewQueue(sqs.NewFromConfig(awsCfg), cfg)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := emailchange.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Users:   users.NewRepository(client, cfg),
+		Tokens:  tokens,
+		Email:   queue,
+		Auth:    verifier,
+		Limiter: ratelimit.New(client, cfg),
+		Limits:  limits,
+		MFA:     mfa.NewGuard(client, cfg),
+		Audit:   audit.NewStore(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. Moving an
+// account is a high-risk operation: callers with a second factor must have
+// signed in recently.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits), h.MFA.Middleware())
+}
+
+// Handle sends the confirmation link of the address in the body to it and
+// answers 202. An address registered to another account is refused with
+// 409; the check is repeated on confirmation, where the transaction settles
+// races.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.UserID = r.PathParams["user_id"]
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	newEmail, err := validation.NormalizeEmail(req.Email, h.Config.StripPlusAlias)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	item, err := h.Users.Uncached().Get(ctx, req.UserID, []string{"email", "display_name"})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if item == nil {
+		return httpx.Error(apperr.NotFound("User not found")), nil
+	}
+	user, err := db.Decode[users.User](item)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if user.Email == newEmail {
+		return httpx.Error(ErrSameEmail), nil
+	}
+	owner, err := h.Users.Uncached().IDByEmail(ctx, newEmail)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if owner != "" {
+		return httpx.Error(users.ErrEmailTaken), nil
+	}
+
+	expires := h.now().Add(emailchange.TTL).UTC().Truncate(time.Second)
+	token, err := h.Tokens.Encode(ctx, emailchange.Change{
+		UserID:    req.UserID,
+		OldEmail:  user.Email,
+		NewEmail:  newEmail,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	name := user.DisplayName
+	if name == "" {
+		name = "there"
+	}
+	err = h.Email.Enqueue(ctx, email.Message{
+		Template: email.TemplateVerifyEmail,
+		To:       newEmail,
+		UserID:   req.UserID,
+		Data: map[string]string{
+			"name":       name,
+			"new_email":  newEmail,
+			"link":       h.Config.EmailChangeURL + "?token=" + url.QueryEscape(token),
+			"expires_in": fmt.Sprintf("%d hours", int(emailchange.TTL.Hours())),
+		},
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	// The link is on its way: failing to warn the current address is logged
+	// rather than failing a request the client would retry
+	err = h.Email.Enqueue(ctx, email.Message{
+		Template: email.TemplateEmailChangeRequested,
+		To:       user.Email,
+		UserID:   req.UserID,
+		Data:     map[string]string{"name": name, "new_email": newEmail},
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to notify the current address of an email change", logging.Err(err))
+	}
+
+	slog.InfoContext(ctx, "Email change requested", "user_id", req.UserID, logging.EmailHash(newEmail))
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionEmailChangeRequest,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"email": {Before: user.Email, After: newEmail}},
+	})
+	return httpx.JSON(202, Pending{Email: newEmail, ExpiresAt: expires.Format(time.RFC3339)}), nil
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,139 @@
+package requestemailchange
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/email"
+	"troggle-backend/internal/emailchange"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/signing"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeSQS records sent messages.
+type fakeSQS struct{ sent []email.Message }
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	var m email.Message
+	if err := json.Unmarshal([]byte(aws.ToString(in.MessageBody)), &m); err != nil {
+		return nil, err
+	}
+	f.sent = append(f.sent, m)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// apiEvent is a REST API event of the email change route.
+func apiEvent(userID, address string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"email": address})
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/email-change",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+		"body":           string(body),
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     string
+		email      string
+		owner      string // current owner of the new address
+		wantStatus int
+		wantSent   int
+	}{
+		{name: "sends link", userID: "u1", email: " Jane@Example.org ", wantStatus: 202, wantSent: 2},
+		{name: "another user", userID: "u2", email: "jane@example.org", wantStatus: 403},
+		{name: "invalid address", userID: "u1", email: "jane", wantStatus: 422},
+		{name: "same address", userID: "u1", email: "jane@example.com", wantStatus: 422},
+		{name: "taken", userID: "u1", email: "joe@example.org", owner: "u9", wantStatus: 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1", "email", "jane@example.com", "display_name", "Jane")}, nil
+				},
+				QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if tt.owner == "" {
+						return &dynamodb.QueryOutput{}, nil
+					}
+					return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", tt.owner)}}, nil
+				},
+			}
+			queue := &fakeSQS{}
+			codec := emailchange.NewCodec(signing.StaticKey("secret"))
+			cfg := &config.Config{UserTableName: "users", EmailIndexName: "email-index", EmailChangeURL: "https://troggle.example/email-change"}
+			h := &Handler{
+				Users:   users.NewRepository(m.Client(), cfg),
+				Tokens:  codec,
+				Email:   &email.Queue{SQS: queue, URL: "https://sqs.example/email"},
+				Auth:    stubVerifier{&auth.Identity{Subject: "u1"}},
+				Limiter: &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+				Config:  cfg,
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.userID, tt.email))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if len(queue.sent) != tt.wantSent {
+				t.Fatalf("sent %d emails, want %d", len(queue.sent), tt.wantSent)
+			}
+			if tt.wantSent == 0 {
+				return
+			}
+
+			verify, notice := queue.sent[0], queue.sent[1]
+			if verify.Template != email.TemplateVerifyEmail || verify.To != "jane@example.org" {
+				t.Errorf("verification = %+v", verify)
+			}
+			if notice.Template != email.TemplateEmailChangeRequested || notice.To != "jane@example.com" {
+				t.Errorf("notice = %+v", notice)
+			}
+			link, err := url.Parse(verify.Data["link"])
+			if err != nil || !strings.HasPrefix(verify.Data["link"], cfg.EmailChangeURL+"?") {
+				t.Fatalf("link = %q", verify.Data["link"])
+			}
+			change, err := codec.Decode(context.Background(), link.Query().Get("token"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if change.UserID != "u1" || change.OldEmail != "jane@example.com" || change.NewEmail != "jane@example.org" {
+				t.Errorf("change = %+v", change)
+			}
+			if d := time.Until(change.ExpiresAt); d <= 0 || d > emailchange.TTL {
+				t.Errorf("expires in %v", d)
+			}
+		})
+	}
+}
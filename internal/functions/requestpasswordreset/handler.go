@@ -0,0 +1,251 @@
+// Package requestpasswordreset starts a password reset (POST
+// /password-reset). The route is public: whoever forgot their password
+// cannot sign in. Active accounts of the address are sent a one-time code
+// (see package otp), which confirmPasswordReset redeems.
+//
+// Every request is answered with the same 202, padded to a randomized
+// minimum latency, whether the address has an account or not, so the
+// endpoint cannot be used to find out which addresses do. Beyond the per-IP
+// limit, each account can be sent resetLimit codes; requests past it send
+// nothing and are logged, counted and audited as a suspected burst, so a
+// mailbox cannot be flooded from many IPs.
+package requestpasswordreset
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs" // SQS client for the email queue
+
+	"troggle-backend/internal/accountstatus" // account lifecycle
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"         // audit log
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/email"         // transactional email
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"       // structured JSON logging
+	"troggle-backend/internal/metrics"       // CloudWatch EMF metrics
+	"troggle-backend/internal/otp"           // one-time codes
+	"troggle-backend/internal/ratelimit"     // DynamoDB token buckets
+	"troggle-backend/internal/timing"        // response latency padding
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+)
+
+// rateLimits bound the requests of one source IP. They can be tuned per
+// stage through RATE_LIMIT_PER_IP; callers are anonymous, so there is no
+// per-user limit.
+var rateLimits = ratelimit.Policy{
+	PerIP: ratelimit.PerMinute(10),
+}
+
+var (
+	// resetLimit bounds the codes sent to one account.
+	resetLimit = ratelimit.Limit{Rate: 3.0 / 3600, Burst: 3}
+
+	// burstLimit bounds the audit entries of the bursts of one account, so
+	// a sustained attack leaves a trace without filling the audit log.
+	burstLimit = ratelimit.Limit{Rate: 1.0 / 3600, Burst: 1}
+)
+
+const (
+	// minLatency and maxJitter shape the response time: every answer takes
+	// at least minLatency plus a random extra, hiding whether a code was
+	// sent.
+	minLatency = 300 * time.Millisecond
+	maxJitter  = 200 * time.Millisecond
+
+	// uniformMessage is the body of every response.
+	uniformMessage = "If an account exists for this address, a reset code is on its way"
+)
+
+// Request represents the JSON input.
+type Request struct {
+	Email string `json:"email"`
+}
+
+// UniformResponse is returned for every request, whatever the outcome.
+type UniformResponse struct {
+	Message string `json:"message"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "requestPasswordReset",
+	Function: "requestPasswordReset",
+	Summary:  "Emails a password reset code to the account of an address",
+	Method:   "POST",
+	Path:     "/password-reset",
+	Status:   202,
+	Request:  Request{},
+	Response: UniformResponse{},
+	Public:   true,
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users   *users.Repository
+	Codes   *otp.Store
+	Email   *email.Queue
+	Limiter *ratelimit.Limiter
+	Limits  ratelimit.Policy
+	Audit   *audit.Store
+	Config  *config.Config
+	Pad     func(ctx context.Context, start time.Time) // timing.Pad when nil
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// email queue.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	queue, err := email.NewQueue(sqs.NewFromConfig(awsCfg), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Users:   users.NewRepository(client, cfg),
+		Codes:   otp.NewResetStore(client, cfg),
+		Email:   queue,
+		Limiter: ratelimit.New(client, cfg),
+		Limits:  limits,
+		Audit:   audit.NewStore(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.Limiter.Middleware(h.Limits))
+}
+
+// Handle sends a reset code to the account of the address in the body, if
+// it has an active one and is under resetLimit, and answers 202 either way.
+// Only malformed addresses, which reveal nothing, are refused with 422.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	defer h.pad(ctx, time.Now())
+
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	address, err := validation.NormalizeEmail(req.Email, h.Config.StripPlusAlias)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := h.send(ctx, r, address); err != nil {
+		return httpx.Response{}, err
+	}
+	return httpx.JSON(202, UniformResponse{Message: uniformMessage}), nil
+}
+
+// send sends a code to the account of address, if it should get one.
+func (h *Handler) send(ctx context.Context, r *httpx.Request, address string) error {
+	userID, err := h.Users.IDByEmail(ctx, address)
+	if err != nil {
+		return err
+	}
+	if userID == "" {
+		slog.InfoContext(ctx, "Password reset of an unknown address", logging.EmailHash(address))
+		return nil
+	}
+	ctx = logging.With(ctx, "user_id", userID)
+	item, err := h.Users.Get(ctx, userID, []string{"display_name", "status"})
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return nil
+	}
+	user, err := db.Decode[users.User](item)
+	if err != nil {
+		return err
+	}
+	if user.Status != accountstatus.Active {
+		// Suspended and banned users would only be refused on sign-in
+		slog.InfoContext(ctx, "Password reset of an inactive account", "status", user.Status)
+		return nil
+	}
+
+	wait, err := h.Limiter.Take(ctx, "password_reset#"+userID, resetLimit)
+	switch {
+	case err != nil:
+		slog.WarnContext(ctx, "Password reset rate limiter unavailable", logging.Err(err))
+	case wait > 0:
+		h.burst(ctx, r, userID)
+		return nil
+	}
+
+	code, _, err := h.Codes.Issue(ctx, userID)
+	if err != nil {
+		return err
+	}
+	name := user.DisplayName
+	if name == "" {
+		name = "there"
+	}
+	err = h.Email.Enqueue(ctx, email.Message{
+		Template: email.TemplatePasswordReset,
+		To:       address,
+		UserID:   userID,
+		Data: map[string]string{
+			"name":       name,
+			"code":       code,
+			"expires_in": fmt.Sprintf("%d minutes", int(otp.TTL.Minutes())),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "Password reset code sent", logging.EmailHash(address))
+	metrics.Count(ctx, metrics.PasswordResetSent)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionPasswordResetRequest,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"source_ip": {After: r.SourceIP}},
+	})
+	return nil
+}
+
+// burst records a request for the account of userID over resetLimit. The
+// first of each hour is audited.
+func (h *Handler) burst(ctx context.Context, r *httpx.Request, userID string) {
+	slog.WarnContext(ctx, "Password reset requests over the account's limit", "source_ip", r.SourceIP)
+	metrics.Count(ctx, metrics.PasswordResetBurst)
+	if wait, err := h.Limiter.Take(ctx, "password_reset_burst#"+userID, burstLimit); err != nil || wait > 0 {
+		return
+	}
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionPasswordResetBurst,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"source_ip": {After: r.SourceIP}},
+	})
+}
+
+func (h *Handler) pad(ctx context.Context, start time.Time) {
+	if h.Pad != nil {
+		h.Pad(ctx, start)
+		return
+	}
+	timing.Pad(ctx, start, minLatency, maxJitter)
+}
@@ -0,0 +1,144 @@
+package requestpasswordreset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/email"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/otp"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/users"
+)
+
+// fakeSQS records sent messages.
+type fakeSQS struct{ sent []email.Message }
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	var m email.Message
+	if err := json.Unmarshal([]byte(aws.ToString(in.MessageBody)), &m); err != nil {
+		return nil, err
+	}
+	f.sent = append(f.sent, m)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// apiEvent is an anonymous REST API event of the reset route.
+func apiEvent(address string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"email": address})
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "POST",
+		"path":       "/password-reset",
+		"body":       string(body),
+	})
+	return event
+}
+
+func num(v any) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: fmt.Sprint(v)}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		owner      string  // account of the address
+		status     string  // of the account
+		tokens     float64 // left in the account's bucket
+		wantStatus int
+		wantSent   bool
+		wantAudit  string // action audited, if any
+	}{
+		{name: "sends code", email: " Jane@Example.com ", owner: "u1", status: "active", tokens: 3, wantStatus: 202, wantSent: true, wantAudit: "user.password_reset_request"},
+		{name: "unknown address", email: "joe@example.com", wantStatus: 202},
+		{name: "suspended account", email: "jane@example.com", owner: "u1", status: "suspended", tokens: 3, wantStatus: 202},
+		{name: "burst", email: "jane@example.com", owner: "u1", status: "active", tokens: 0, wantStatus: 202, wantAudit: "user.password_reset_burst"},
+		{name: "invalid address", email: "jane", wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					if aws.ToString(in.TableName) == "users" {
+						return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", tt.owner, "display_name", "Jane", "status", tt.status)}, nil
+					}
+					// The burst bucket is always full
+					tokens := tt.tokens
+					if in.Key["bucket"].(*types.AttributeValueMemberS).Value == "password_reset_burst#u1" {
+						tokens = 1
+					}
+					item := dbtest.Item("bucket", "b")
+					item["tokens"], item["updated_at"] = num(tokens), num(time.Now().UnixMilli())
+					return &dynamodb.GetItemOutput{Item: item}, nil
+				},
+				QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if tt.owner == "" {
+						return &dynamodb.QueryOutput{}, nil
+					}
+					return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("user_id", tt.owner)}}, nil
+				},
+			}
+			queue := &fakeSQS{}
+			cfg := &config.Config{UserTableName: "users", EmailIndexName: "email-index", AuditTableName: "audit"}
+			h := &Handler{
+				Users:   users.NewRepository(m.Client(), cfg),
+				Codes:   &otp.Store{DB: m.Client(), Table: "challenges", Prefix: otp.ResetPrefix},
+				Email:   &email.Queue{SQS: queue, URL: "https://sqs.example/email"},
+				Limiter: &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+				Audit:   audit.NewStore(m.Client(), cfg),
+				Config:  cfg,
+				Pad:     func(context.Context, time.Time) {},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.email))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus == 202 {
+				var got UniformResponse
+				if err := json.Unmarshal([]byte(resp.Body), &got); err != nil || got.Message != uniformMessage {
+					t.Errorf("body = %s, want the uniform message", resp.Body)
+				}
+			}
+
+			if !tt.wantSent {
+				if len(queue.sent) != 0 {
+					t.Errorf("sent = %+v, want nothing", queue.sent)
+				}
+			} else {
+				if len(queue.sent) != 1 {
+					t.Fatalf("sent %d emails, want 1", len(queue.sent))
+				}
+				msg := queue.sent[0]
+				if msg.Template != email.TemplatePasswordReset || msg.To != "jane@example.com" || len(msg.Data["code"]) != otp.Digits {
+					t.Errorf("message = %+v", msg)
+				}
+			}
+
+			var audited string
+			for _, c := range m.Calls {
+				if in, ok := c.Input.(*dynamodb.PutItemInput); ok && aws.ToString(in.TableName) == "audit" {
+					audited = in.Item["action"].(*types.AttributeValueMemberS).Value
+				}
+			}
+			if audited != tt.wantAudit {
+				t.Errorf("audited %q, want %q", audited, tt.wantAudit)
+			}
+		})
+	}
+}
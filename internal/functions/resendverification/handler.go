@@ -0,0 +1,219 @@
+// Package resendverification mails a new verification link to users whose
+// email address is not verified (POST /users/{user_id}/email-verification),
+// such as those created through the REST API, or who lost the first one.
+// The link carries an email change token from the account's address to
+// itself (see package emailchange), which confirmEmailChange redeems by
+// marking the address verified. Until then the endpoints gated by package
+// verification refuse the account.
+package resendverification
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs" // SQS client for the email queue
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"       // audit log
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/email"       // transactional email
+	"troggle-backend/internal/emailchange" // email change tokens
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"     // structured JSON logging
+	"troggle-backend/internal/ratelimit"   // DynamoDB token buckets
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/users"       // user table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// rateLimits keep accounts from flooding their mailbox with links. They can
+// be tuned per stage through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(20),
+	PerUser: ratelimit.Limit{Rate: 3.0 / 3600, Burst: 3},
+}
+
+// Request represents the JSON input of a direct invocation.
+type Request struct {
+	UserID string `json:"user_id"`
+}
+
+// Pending is the response: where the link went and until when it works.
+type Pending struct {
+	Email     string `json:"email"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "resendVerification",
+	Function: "resendVerification",
+	Summary:  "Sends a new link verifying the email address of an account",
+	Method:   "POST",
+	Path:     "/users/{user_id}/email-verification",
+	Status:   202,
+	Response: Pending{},
+}}
+
+// authorize lets callers verify their own address only. Direct invocations
+// are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID {
+		return apperr.Forbidden("You may only verify your own email address")
+	}
+	return nil
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users   *users.Repository
+	Tokens  *emailchange.Codec
+	Email   *email.Queue
+	Auth    auth.TokenVerifier
+	Limiter *ratelimit.Limiter
+	Limits  ratelimit.Policy
+	Audit   *audit.Store
+	Config  *config.Config
+	Now     func() time.Time // time.Now when nil
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// email queue and the confirmation link.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireEmailChange(); err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	queue, err := email.NewQueue(sqs.NewFromConfig(awsCfg), cfg)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := emailchange.New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Users:   users.NewRepository(client, cfg),
+		Tokens:  tokens,
+		Email:   queue,
+		Auth:    verifier,
+		Limiter: ratelimit.New(client, cfg),
+		Limits:  limits,
+		Audit:   audit.NewStore(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle sends a verification link to the account's address and answers
+// 202. An address verified already answers 409 EMAIL_ALREADY_VERIFIED.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	item, err := h.Users.Uncached().Get(ctx, req.UserID, []string{"email", "email_verified", "display_name"})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	user, err := db.Decode[users.User](item)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if item == nil || user.Email == "" {
+		// Soft deletion moves the email aside
+		return httpx.Error(apperr.NotFound("User not found")), nil
+	}
+	// Records from before the attribute count as verified; see users.Schema
+	if _, tracked := item["email_verified"]; user.EmailVerified || !tracked {
+		return httpx.Error(users.ErrEmailVerified), nil
+	}
+
+	expires := h.now().Add(emailchange.TTL).UTC().Truncate(time.Second)
+	token, err := h.Tokens.Encode(ctx, emailchange.Change{
+		UserID:    req.UserID,
+		OldEmail:  user.Email,
+		NewEmail:  user.Email,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	name := user.DisplayName
+	if name == "" {
+		name = "there"
+	}
+	err = h.Email.Enqueue(ctx, email.Message{
+		Template: email.TemplateVerifyAddress,
+		To:       user.Email,
+		UserID:   req.UserID,
+		Data: map[string]string{
+			"name":       name,
+			"email":      user.Email,
+			"link":       h.Config.EmailChangeURL + "?token=" + url.QueryEscape(token),
+			"expires_in": fmt.Sprintf("%d hours", int(emailchange.TTL.Hours())),
+		},
+	})
+	if err != nil {
+		return httpx.Response{}, err
+	}
+
+	slog.InfoContext(ctx, "Verification link sent", "user_id", req.UserID, logging.EmailHash(user.Email))
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionVerificationResend,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+	})
+	return httpx.JSON(202, Pending{Email: user.Email, ExpiresAt: expires.Format(time.RFC3339)}), nil
+}
+
+func (h *Handler) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,131 @@
+package resendverification
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/email"
+	"troggle-backend/internal/emailchange"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/ratelimit"
+	"troggle-backend/internal/signing"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeSQS records sent messages.
+type fakeSQS struct{ sent []email.Message }
+
+func (f *fakeSQS) SendMessage(_ context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	var m email.Message
+	if err := json.Unmarshal([]byte(aws.ToString(in.MessageBody)), &m); err != nil {
+		return nil, err
+	}
+	f.sent = append(f.sent, m)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+// apiEvent is a REST API event of the verification route.
+func apiEvent(userID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/email-verification",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+	})
+	return event
+}
+
+// record returns the record of u1, with email_verified set to verified
+// unless it is nil.
+func record(verified *bool) db.Item {
+	item := dbtest.Item("user_id", "u1", "email", "jane@example.com", "display_name", "Jane")
+	if verified != nil {
+		item["email_verified"] = &types.AttributeValueMemberBOOL{Value: *verified}
+	}
+	return item
+}
+
+func TestHandle(t *testing.T) {
+	no, yes := false, true
+	tests := []struct {
+		name       string
+		userID     string
+		verified   *bool
+		wantStatus int
+	}{
+		{name: "unverified", userID: "u1", verified: &no, wantStatus: 202},
+		{name: "verified", userID: "u1", verified: &yes, wantStatus: 409},
+		{name: "record from before", userID: "u1", wantStatus: 409},
+		{name: "another user", userID: "u2", verified: &no, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: record(tt.verified)}, nil
+				},
+			}
+			queue := &fakeSQS{}
+			codec := emailchange.NewCodec(signing.StaticKey("secret"))
+			cfg := &config.Config{UserTableName: "users", AuditTableName: "audit", EmailChangeURL: "https://troggle.example/email-change"}
+			h := &Handler{
+				Users:   users.NewRepository(m.Client(), cfg),
+				Tokens:  codec,
+				Email:   &email.Queue{SQS: queue, URL: "https://sqs.example/email"},
+				Auth:    stubVerifier{&auth.Identity{Subject: "u1"}},
+				Limiter: &ratelimit.Limiter{DB: m.Client(), Table: "rate-limits"},
+				Config:  cfg,
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.userID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if tt.wantStatus != 202 {
+				if len(queue.sent) != 0 {
+					t.Errorf("sent = %+v, want nothing", queue.sent)
+				}
+				return
+			}
+
+			if len(queue.sent) != 1 || queue.sent[0].Template != email.TemplateVerifyAddress || queue.sent[0].To != "jane@example.com" {
+				t.Fatalf("sent = %+v, want the link mailed to the address", queue.sent)
+			}
+			link, err := url.Parse(queue.sent[0].Data["link"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			change, err := codec.Decode(context.Background(), link.Query().Get("token"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if change.UserID != "u1" || !change.Verification() || change.NewEmail != "jane@example.com" {
+				t.Errorf("change = %+v, want a verification of the address", change)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// Package restoreuser restores a soft-deleted account within its retention
+// window (POST /users/{user_id}/restore), for admins only: the user record,
+// its devices and preferences, and the Cognito account. Sessions revoked by
+// the deletion stay revoked. See package erasure.
+package restoreuser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws" // aws package
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apikeys"    // API keys of server-to-server callers
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/erasure"    // soft deletion and restore
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON input of a direct invocation.
+type Request struct {
+	UserID string `json:"user_id"`
+}
+
+// authorize lets callers holding the users:restore permission only restore
+// accounts. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request) error {
+	if r.Direct {
+		return nil
+	}
+	return authz.Require(ctx, authz.UsersRestore)
+}
+
+// CognitoAPI is the part of the Cognito user pool API the restore uses.
+type CognitoAPI interface {
+	AdminEnableUser(ctx context.Context, params *cognitoidentityprovider.AdminEnableUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminEnableUserOutput, error)
+	AdminDisableUser(ctx context.Context, params *cognitoidentityprovider.AdminDisableUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDisableUserOutput, error)
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "restoreUser",
+	Function: "restoreUser",
+	Summary:  "Restores a deleted user within the retention window",
+	Method:   "POST",
+	Path:     "/users/{user_id}/restore",
+	Response: map[string]any{},
+	Scopes:   []string{"troggle/admin", "users:restore"},
+	Groups:   []string{"admin"},
+	APIKey:   true,
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Erasure *erasure.Store
+	Users   *users.Repository // cache invalidated after each restore
+	Cognito CognitoAPI
+	Auth    auth.TokenVerifier
+	APIKey  *apikeys.Middleware // nil accepts bearer tokens only
+	Audit   *audit.Store
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// Cognito user pool.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireUserPool(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Erasure: erasure.NewStore(client, cfg),
+		Users:   users.NewRepository(client, cfg),
+		Cognito: cognitoidentityprovider.NewFromConfig(awsCfg),
+		Auth:    verifier,
+		APIKey:  apikeys.NewMiddleware(client, cfg),
+		Audit:   audit.NewStore(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, h.APIKey.Wrap, auth.Middleware(h.Auth))
+}
+
+// Handle restores the account named by the user_id path parameter and
+// answers 200 with the restored profile.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	// The account is enabled first so a restored user can always sign in;
+	// it is disabled again if the records cannot be restored
+	if err := h.setCognitoEnabled(ctx, req.UserID, true); err != nil {
+		return httpx.Response{}, fmt.Errorf("enabling Cognito user: %w", err)
+	}
+	user, err := h.Erasure.Restore(ctx, req.UserID, time.Now())
+	if err != nil {
+		if err := h.setCognitoEnabled(ctx, req.UserID, false); err != nil {
+			slog.ErrorContext(ctx, "Failed to disable Cognito user of unrestored account", "user_id", req.UserID, logging.Err(err))
+		}
+		return httpx.Response{}, err
+	}
+
+	var email string
+	if v, ok := user["email"].(*types.AttributeValueMemberS); ok {
+		email = v.Value
+	}
+	h.Users.Invalidate(req.UserID, email)
+
+	profile, err := users.Profile(user)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Account restored", "user_id", req.UserID)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionUserRestore,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"status": {Before: erasure.StatusDeleted, After: profile["status"]}},
+	})
+	return httpx.JSON(200, profile), nil
+}
+
+// setCognitoEnabled enables or disables the Cognito account. An account that
+// no longer exists counts as success: the user can sign up again.
+func (h *Handler) setCognitoEnabled(ctx context.Context, userID string, enabled bool) error {
+	var err error
+	if enabled {
+		_, err = h.Cognito.AdminEnableUser(ctx, &cognitoidentityprovider.AdminEnableUserInput{
+			UserPoolId: aws.String(h.Config.UserPoolID),
+			Username:   aws.String(userID),
+		})
+	} else {
+		_, err = h.Cognito.AdminDisableUser(ctx, &cognitoidentityprovider.AdminDisableUserInput{
+			UserPoolId: aws.String(h.Config.UserPoolID),
+			Username:   aws.String(userID),
+		})
+	}
+	var notFound *cognitotypes.UserNotFoundException
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
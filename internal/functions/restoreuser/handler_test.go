@@ -0,0 +1,144 @@
+package restoreuser
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/erasure"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeCognito records the admin calls it receives.
+type fakeCognito struct{ calls []string }
+
+func (c *fakeCognito) AdminEnableUser(context.Context, *cognitoidentityprovider.AdminEnableUserInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminEnableUserOutput, error) {
+	c.calls = append(c.calls, "AdminEnableUser")
+	return &cognitoidentityprovider.AdminEnableUserOutput{}, nil
+}
+
+func (c *fakeCognito) AdminDisableUser(context.Context, *cognitoidentityprovider.AdminDisableUserInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDisableUserOutput, error) {
+	c.calls = append(c.calls, "AdminDisableUser")
+	return &cognitoidentityprovider.AdminDisableUserOutput{}, nil
+}
+
+// apiEvent is a REST API event of the restore route.
+func apiEvent(userID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/restore",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	admin := &auth.Identity{Subject: "a1", Groups: []string{authz.Admin}}
+	owner := &auth.Identity{Subject: "u1"}
+	purgeAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	deleted := dbtest.Item("user_id", "u1", "status", erasure.StatusDeleted, "deleted_at", "2024-04-20T00:00:00Z", "purge_at", purgeAt)
+
+	tests := []struct {
+		name        string
+		payload     json.RawMessage
+		caller      *auth.Identity
+		stored      db.Item
+		wantStatus  int
+		wantBody    string
+		wantCognito []string
+	}{
+		{
+			name:    "restored",
+			payload: apiEvent("u1"), caller: admin, stored: deleted,
+			wantStatus: 200, wantBody: `"status":"active"`,
+			wantCognito: []string{"AdminEnableUser"},
+		},
+		{
+			name:    "not deleted",
+			payload: apiEvent("u1"), caller: admin, stored: dbtest.Item("user_id", "u1", "status", "active"),
+			wantStatus:  422,
+			wantCognito: []string{"AdminEnableUser", "AdminDisableUser"},
+		},
+		{
+			name:    "past retention",
+			payload: apiEvent("u1"), caller: admin,
+			stored:      dbtest.Item("user_id", "u1", "status", erasure.StatusDeleted, "deleted_at", "2024-04-20T00:00:00Z", "purge_at", "2024-05-20T00:00:00Z"),
+			wantStatus:  404,
+			wantCognito: []string{"AdminEnableUser", "AdminDisableUser"},
+		},
+		{
+			name:    "missing",
+			payload: apiEvent("u1"), caller: admin,
+			wantStatus:  404,
+			wantCognito: []string{"AdminEnableUser", "AdminDisableUser"},
+		},
+		{
+			name:    "not an admin",
+			payload: apiEvent("u1"), caller: owner, stored: deleted,
+			wantStatus: 403,
+		},
+		{
+			name:       "direct",
+			payload:    json.RawMessage(`{"user_id":"u1"}`),
+			stored:     deleted,
+			wantStatus: 200, wantCognito: []string{"AdminEnableUser"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.stored}, nil
+				},
+				UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					return &dynamodb.UpdateItemOutput{Attributes: dbtest.Item("user_id", "u1", "status", "active")}, nil
+				},
+			}
+			cognito := &fakeCognito{}
+			h := &Handler{
+				Erasure: &erasure.Store{DB: m.Client(), Table: "users"},
+				Users:   &users.Repository{},
+				Cognito: cognito,
+				Auth:    stubVerifier{tt.caller},
+				Config:  &config.Config{UserPoolID: "pool"},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+			if !reflect.DeepEqual(cognito.calls, tt.wantCognito) {
+				t.Errorf("Cognito calls = %v, want %v", cognito.calls, tt.wantCognito)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Package revokesession signs a user out of one session. Tokens of the
+// session are rejected from then on by every endpoint that checks
+// revocation (see sessions.NewVerifier).
+package revokesession
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/authz"      // role-based access control
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/events"     // domain event publishing
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the session_id as a path parameter and revoke their own
+// sessions, or pass ?user_id= as admins.
+type Request struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "revokeSession",
+	Function: "revokeSession",
+	Summary:  "Signs a session out",
+	Method:   "DELETE",
+	Path:     "/sessions/{session_id}",
+	Query:    []string{"user_id"},
+	Status:   204,
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Sessions *sessions.Store
+	Auth     auth.TokenVerifier
+	Events   *events.Publisher
+	Audit    *audit.Store
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Sessions: sessions.NewStore(client, cfg),
+		Auth:     verifier,
+		Events:   events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Audit:    audit.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle revokes the session and answers 204, or 404 if there is no such
+// session.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{SessionID: r.PathParams["session_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	} else {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req.UserID = id.Subject
+		if other := r.Query("user_id"); other != "" && other != id.Subject {
+			if !authz.Allowed(id, authz.SessionsManage) {
+				return httpx.Error(apperr.Forbidden("You may only revoke your own sessions")), nil
+			}
+			req.UserID = other
+		}
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := validation.SessionID(req.SessionID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	if err := h.Sessions.Revoke(ctx, req.UserID, req.SessionID); err != nil {
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Session revoked", "user_id", req.UserID, "session_id", req.SessionID)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionSessionRevoke,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{"sessions." + req.SessionID: {Before: "active", After: "revoked"}},
+	})
+	h.Events.Emit(ctx, events.SessionRevoked{UserID: req.UserID, SessionID: req.SessionID, RevokedAt: events.Now()})
+	return httpx.NoContent(), nil
+}
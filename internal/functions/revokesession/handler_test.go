@@ -0,0 +1,154 @@
+package revokesession
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/events"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/sessions"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" || v.id == nil {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeEvents records published events.
+type fakeEvents struct {
+	published []*eventbridge.PutEventsInput
+}
+
+func (e *fakeEvents) PutEvents(_ context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	e.published = append(e.published, in)
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+// apiEvent is a REST API event revoking sessionID, for userID if set.
+func apiEvent(sessionID, userID string) json.RawMessage {
+	query := map[string]string{}
+	if userID != "" {
+		query["user_id"] = userID
+	}
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":            "DELETE",
+		"path":                  "/sessions/" + sessionID,
+		"pathParameters":        map[string]string{"session_id": sessionID},
+		"queryStringParameters": query,
+		"headers":               map[string]string{"Authorization": "Bearer valid"},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	user := &auth.Identity{Subject: "u1", SessionID: "s1"}
+	moderator := &auth.Identity{Subject: "m1", Groups: []string{authz.Moderator}}
+	granted := &auth.Identity{Subject: "m2", Roles: []string{authz.Admin}}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		missing    bool // the session does not exist
+		wantStatus int
+		wantKey    [2]string // user_id and session_id revoked
+		wantOps    []string
+	}{
+		{
+			name: "own session", payload: apiEvent("s2", ""), caller: user,
+			wantStatus: 204, wantKey: [2]string{"u1", "s2"}, wantOps: []string{"UpdateItem", "PutItem"},
+		},
+		{
+			name: "own session by user_id", payload: apiEvent("s2", "u1"), caller: user,
+			wantStatus: 204, wantKey: [2]string{"u1", "s2"}, wantOps: []string{"UpdateItem", "PutItem"},
+		},
+		{name: "other user's session", payload: apiEvent("s9", "u2"), caller: user, wantStatus: 403},
+		{
+			name: "moderator", payload: apiEvent("s9", "u2"), caller: moderator,
+			wantStatus: 204, wantKey: [2]string{"u2", "s9"}, wantOps: []string{"UpdateItem", "PutItem"},
+		},
+		{
+			name: "granted admin", payload: apiEvent("s9", "u2"), caller: granted,
+			wantStatus: 204, wantKey: [2]string{"u2", "s9"}, wantOps: []string{"UpdateItem", "PutItem"},
+		},
+		{
+			name: "no such session", payload: apiEvent("s2", ""), caller: user, missing: true,
+			wantStatus: 404, wantKey: [2]string{"u1", "s2"}, wantOps: []string{"UpdateItem"},
+		},
+		{
+			name: "moderator, no such session", payload: apiEvent("s9", "u2"), caller: moderator, missing: true,
+			wantStatus: 404, wantKey: [2]string{"u2", "s9"}, wantOps: []string{"UpdateItem"},
+		},
+		{name: "invalid session_id", payload: apiEvent("s_2", ""), caller: user, wantStatus: 422},
+		{name: "invalid user_id", payload: apiEvent("s2", "u#2"), caller: moderator, wantStatus: 422},
+		{name: "no token", payload: apiEvent("s2", ""), wantStatus: 401},
+		{
+			name: "direct", payload: json.RawMessage(`{"user_id":"u2","session_id":"s9"}`),
+			wantStatus: 204, wantKey: [2]string{"u2", "s9"}, wantOps: []string{"UpdateItem", "PutItem"},
+		},
+		{name: "direct without session_id", payload: json.RawMessage(`{"user_id":"u2"}`), wantStatus: 422},
+		{name: "direct without user_id", payload: json.RawMessage(`{"session_id":"s9"}`), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revoked [2]string
+			m := &dbtest.Mock{
+				UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					revoked = [2]string{
+						in.Key["user_id"].(*types.AttributeValueMemberS).Value,
+						in.Key["session_id"].(*types.AttributeValueMemberS).Value,
+					}
+					if tt.missing {
+						return nil, dbtest.ConditionFailed()
+					}
+					return &dynamodb.UpdateItemOutput{}, nil
+				},
+			}
+			published := &fakeEvents{}
+			h := &Handler{
+				Sessions: &sessions.Store{DB: m.Client(), Table: "sessions"},
+				Auth:     stubVerifier{tt.caller},
+				Events:   &events.Publisher{API: published, Bus: "bus"},
+				Audit:    &audit.Store{DB: m.Client(), Table: "audit"},
+				Config:   &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if ops := m.Ops(); len(ops)+len(tt.wantOps) > 0 && !reflect.DeepEqual(ops, tt.wantOps) {
+				t.Errorf("ops = %v, want %v", ops, tt.wantOps)
+			}
+			if revoked != tt.wantKey {
+				t.Errorf("revoked %v, want %v", revoked, tt.wantKey)
+			}
+			wantEvents := 0
+			if tt.wantStatus == 204 {
+				wantEvents = 1
+			}
+			if len(published.published) != wantEvents {
+				t.Errorf("published %d events, want %d", len(published.published), wantEvents)
+			}
+		})
+	}
+}
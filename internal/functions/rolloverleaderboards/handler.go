@@ -0,0 +1,63 @@
+// Package rolloverleaderboards closes the week that ended on every
+// leaderboard, storing its final standings before the week's entries
+// expire. An EventBridge schedule runs it shortly after midnight UTC on
+// Mondays; running it again for a closed week changes nothing. See package
+// leaderboard.
+package rolloverleaderboards
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events" // Lambda event payloads
+
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/leaderboard" // scores and ranks
+	"troggle-backend/internal/logging"     // structured JSON logging
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Leaderboards *leaderboard.Store
+	Config       *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	return &Handler{Leaderboards: leaderboard.NewStore(client, cfg), Config: cfg}, nil
+}
+
+// Handle closes, on every board, the week before the one the event was
+// scheduled in. A board that fails does not stop the others; failing the
+// invocation makes EventBridge retry it, and the retry only closes the
+// boards still open.
+func (h *Handler) Handle(ctx context.Context, event events.CloudWatchEvent) error {
+	now := time.Now()
+	scheduled := event.Time
+	if scheduled.IsZero() {
+		scheduled = now
+	}
+	week := leaderboard.Week(scheduled.AddDate(0, 0, -7))
+
+	var errs []error
+	for _, name := range h.Leaderboards.Boards {
+		closed, err := h.Leaderboards.Close(ctx, name, week, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to close leaderboard week", "board", name, "week", week, logging.Err(err))
+			errs = append(errs, fmt.Errorf("closing %s %s: %w", name, week, err))
+			continue
+		}
+		if closed {
+			slog.InfoContext(ctx, "Leaderboard week closed", "board", name, "week", week)
+		}
+	}
+	return errors.Join(errs...)
+}
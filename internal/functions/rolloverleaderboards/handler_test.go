@@ -0,0 +1,55 @@
+package rolloverleaderboards
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/leaderboard"
+)
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		failBoard  string // whose standings fail to store
+		wantErr    bool
+		wantClosed []string
+	}{
+		{name: "every board", wantClosed: []string{"main#2026-W41", "speed#2026-W41"}},
+		{name: "one fails", failBoard: "main#2026-W41", wantErr: true, wantClosed: []string{"main#2026-W41", "speed#2026-W41"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var closed []string
+			m := &dbtest.Mock{PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+				board := in.Item["board"].(*types.AttributeValueMemberS).Value
+				closed = append(closed, board)
+				if board == tt.failBoard {
+					return nil, dbtest.Throttled()
+				}
+				return &dynamodb.PutItemOutput{}, nil
+			}}
+			h := &Handler{
+				Leaderboards: &leaderboard.Store{DB: m.Client(), Table: "leaderboard", Boards: []string{"main", "speed"}, Shards: 2},
+				Config:       &config.Config{},
+			}
+
+			// Monday of week 42
+			event := events.CloudWatchEvent{DetailType: "Scheduled Event", Source: "aws.events", Time: time.Date(2026, 10, 12, 0, 5, 0, 0, time.UTC)}
+			err := h.Handle(context.Background(), event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(closed, tt.wantClosed) {
+				t.Errorf("closed %v, want %v", closed, tt.wantClosed)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Package runmatchmaking matches the players waiting in the matchmaking
+// queues. An EventBridge schedule runs it every minute; each run groups the
+// tickets of every queue by rating, creates the match of every group it
+// claims and tells its players, over their WebSocket connections or, when
+// they have none open, by push. See package matchmaking.
+package runmatchmaking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	lambdaevents "github.com/aws/aws-lambda-go/events" // Lambda event payloads
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge" // EventBridge client
+	"github.com/aws/aws-sdk-go-v2/service/sns"         // SNS client
+
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/devices"     // device token registry
+	"troggle-backend/internal/events"      // domain event publishing
+	"troggle-backend/internal/logging"     // structured JSON logging
+	"troggle-backend/internal/matches"     // match records
+	"troggle-backend/internal/matchmaking" // matchmaking queue
+	"troggle-backend/internal/preferences" // notification preferences
+	"troggle-backend/internal/presence"    // WebSocket connections
+	"troggle-backend/internal/push"        // SNS mobile push
+)
+
+// MessageType is the type of the realtime message carrying a found match.
+const MessageType = "match_found"
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Matchmaking *matchmaking.Store
+	Events      *events.Publisher
+	Realtime    *presence.Poster
+	Preferences *preferences.Store
+	Push        *push.Sender
+	Config      *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireWebSocket(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Matchmaking: matchmaking.NewStore(client, cfg),
+		Events:      events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Realtime:    presence.NewPoster(presence.NewStore(client, cfg), awsCfg, cfg),
+		Preferences: preferences.NewStore(client, cfg),
+		Push:        push.NewSender(sns.NewFromConfig(awsCfg), devices.NewStore(client, cfg), cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// Handle matches the players of every queue. A queue that fails does not
+// stop the others; failing the invocation only marks the run as failed,
+// as the next run retries whatever was left waiting anyway.
+func (h *Handler) Handle(ctx context.Context, _ lambdaevents.CloudWatchEvent) error {
+	var errs []error
+	for _, q := range h.Matchmaking.Queues() {
+		if err := h.match(ctx, q, time.Now()); err != nil {
+			slog.ErrorContext(ctx, "Failed to match queue", "queue", q.String(), logging.Err(err))
+			errs = append(errs, fmt.Errorf("matching %s: %w", q, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// match claims the groups formed from the tickets waiting in q.
+func (h *Handler) match(ctx context.Context, q matchmaking.Queue, now time.Time) error {
+	tickets, err := h.Matchmaking.Waiting(ctx, q, now)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, group := range matchmaking.Group(tickets, matchmaking.PlayersPerMatch, now) {
+		m, claimed, err := h.Matchmaking.Claim(ctx, group, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !claimed {
+			slog.InfoContext(ctx, "Tickets changed before they were matched", "queue", q.String())
+			continue
+		}
+		slog.InfoContext(ctx, "Match found", "match_id", m.ID, "queue", q.String(), "players", m.Players)
+
+		// The MatchCreated event starts the state machine that abandons
+		// the match if its results never come. The match is stored
+		// already, so a lost event only fails the run, for an alarm.
+		err = h.Events.Publish(ctx, events.MatchCreated{
+			MatchID:   m.ID,
+			HostID:    m.HostID,
+			Board:     m.Board,
+			CreatedAt: m.CreatedAt.Format(time.RFC3339),
+			JoinBy:    m.JoinBy.Format(time.RFC3339),
+			FinishBy:  m.FinishBy.Format(time.RFC3339),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("publishing match %s: %w", m.ID, err))
+		}
+		for _, userID := range m.Players {
+			h.notify(ctx, userID, m)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notify tells userID about m: over their WebSocket connections, or by push
+// if they have none open and did not turn pushes off. The match is stored
+// already, and clients can poll it, so failures are only logged.
+func (h *Handler) notify(ctx context.Context, userID string, m matches.Match) {
+	res, err := h.Realtime.Send(ctx, userID, presence.Message{Type: MessageType, Data: m})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to send match", "user_id", userID, "match_id", m.ID, logging.Err(err))
+	}
+	if err == nil && res.Sent > 0 {
+		return
+	}
+
+	stored, _, err := h.Preferences.Get(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read notification preferences", "user_id", userID, logging.Err(err))
+		return
+	}
+	if preferences.Resolve(stored)[preferences.Notifications]["push_enabled"] == false {
+		return
+	}
+	n := push.Notification{
+		Title: "Match found",
+		Body:  "Your match is ready to play.",
+		Data:  map[string]string{"type": MessageType, "match_id": m.ID},
+	}
+	if _, err := h.Push.Send(ctx, userID, n); err != nil {
+		slog.WarnContext(ctx, "Failed to push match", "user_id", userID, "match_id", m.ID, logging.Err(err))
+	}
+}
@@ -0,0 +1,98 @@
+package runmatchmaking
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/devices"
+	"troggle-backend/internal/matches"
+	"troggle-backend/internal/matchmaking"
+	"troggle-backend/internal/preferences"
+	"troggle-backend/internal/presence"
+	"troggle-backend/internal/push"
+)
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name     string
+		ratings  []int // of the waiting tickets, oldest first
+		queueErr error
+		claimErr error
+		wantErr  bool
+		wantOps  []string
+	}{
+		{
+			name:    "matches a pair",
+			ratings: []int{1000, 1050},
+			// the queue, the claim, then per player their connections,
+			// preferences and devices
+			wantOps: []string{"Query", "TransactWriteItems", "Query", "GetItem", "Query", "Query", "GetItem", "Query"},
+		},
+		{name: "nobody close", ratings: []int{1000, 1500}, wantOps: []string{"Query"}},
+		{name: "alone", ratings: []int{1000}, wantOps: []string{"Query"}},
+		{
+			name:     "tickets changed",
+			ratings:  []int{1000, 1050},
+			claimErr: dbtest.TransactionCanceled("None", "ConditionalCheckFailed", "None"),
+			wantOps:  []string{"Query", "TransactWriteItems"},
+		},
+		{name: "failing queue", queueErr: dbtest.Throttled(), wantErr: true, wantOps: []string{"Query"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now().UTC().Truncate(time.Second)
+			var tickets []db.Item
+			for i, rating := range tt.ratings {
+				item, _ := attributevalue.MarshalMap(matchmaking.Ticket{
+					UserID:     []string{"u1", "u2"}[i],
+					Queue:      "eu#",
+					Region:     "eu",
+					Rating:     rating,
+					EnqueuedAt: now,
+					ExpiresAt:  now.Add(time.Minute).Unix(),
+				})
+				tickets = append(tickets, item)
+			}
+			m := &dbtest.Mock{
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if aws.ToString(in.TableName) != "tickets" {
+						return &dynamodb.QueryOutput{}, nil
+					}
+					return &dynamodb.QueryOutput{Items: tickets}, tt.queueErr
+				},
+				TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					return &dynamodb.TransactWriteItemsOutput{}, tt.claimErr
+				},
+			}
+			cfg := &config.Config{PreferenceTableName: "preferences", DeviceTableName: "devices", ConnectionTableName: "connections"}
+			h := &Handler{
+				Matchmaking: &matchmaking.Store{
+					DB: m.Client(), Table: "tickets", QueueIndex: "queue-index", Regions: []string{"eu"},
+					Matches: &matches.Store{DB: m.Client(), Table: "matches", PlayTimeout: time.Hour},
+				},
+				Realtime:    presence.NewPoster(presence.NewStore(m.Client(), cfg), aws.Config{}, cfg),
+				Preferences: preferences.NewStore(m.Client(), cfg),
+				Push:        push.NewSender(nil, devices.NewStore(m.Client(), cfg), cfg),
+				Config:      cfg,
+			}
+
+			err := h.Handle(context.Background(), events.CloudWatchEvent{Time: now})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if ops := m.Ops(); !slices.Equal(ops, tt.wantOps) {
+				t.Errorf("ops = %v, want %v", ops, tt.wantOps)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Package searchusers finds users by username or display name (GET
+// /users/search?q=...), by prefix and, with fuzzy=true, allowing for typos.
+// See package search for the index behind it.
+package searchusers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"troggle-backend/internal/api"       // API route declarations
+	"troggle-backend/internal/apperr"    // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"      // Cognito JWT verification
+	"troggle-backend/internal/awscfg"    // shared AWS SDK config
+	"troggle-backend/internal/config"    // environment-driven settings
+	"troggle-backend/internal/db"        // shared DynamoDB client
+	"troggle-backend/internal/httpx"     // API Gateway / direct invocation adapter
+	"troggle-backend/internal/ratelimit" // DynamoDB token buckets
+	"troggle-backend/internal/search"    // user search index
+	"troggle-backend/internal/sessions"  // session table access
+)
+
+const (
+	// defaultLimit and maxLimit bound the page size.
+	defaultLimit = 20
+	maxLimit     = 50
+)
+
+// rateLimits leave room for search-as-you-type while keeping the endpoint
+// from being used to walk the whole user base. They can be tuned per stage
+// through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(120),
+	PerUser: ratelimit.PerMinute(60),
+}
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the same values as query string parameters.
+type Request struct {
+	Q         string `json:"q"`
+	Fuzzy     string `json:"fuzzy"` // "true" tolerates typos
+	Limit     string `json:"limit"`
+	NextToken string `json:"next_token"`
+}
+
+// Response represents the JSON output
+type Response struct {
+	Users     []search.Hit `json:"users"`
+	NextToken string       `json:"next_token,omitempty"` // absent on the last page
+}
+
+// Shape returns resp in the shape of v: from version 2 on, a page of items
+// like every other list.
+func (resp Response) Shape(v httpx.Version) any {
+	if v < httpx.V2 {
+		return resp
+	}
+	return httpx.Page[search.Hit]{Items: resp.Users, NextToken: resp.NextToken}
+}
+
+// ParseQuery validates the request parameters.
+func ParseQuery(req Request) (search.Query, error) {
+	text, err := search.CheckQuery(req.Q)
+	if err != nil {
+		return search.Query{}, err
+	}
+	q := search.Query{Text: text, Fuzzy: req.Fuzzy == "true", Limit: defaultLimit}
+
+	if req.Limit != "" {
+		n, err := strconv.Atoi(req.Limit)
+		if err != nil || n < 1 || n > maxLimit {
+			return search.Query{}, apperr.Invalid("INVALID_LIMIT", "limit", fmt.Sprintf("limit must be between 1 and %d", maxLimit))
+		}
+		q.Limit = n
+	}
+	if req.NextToken != "" {
+		if q.Offset, err = search.DecodeToken(req.NextToken); err != nil {
+			return search.Query{}, apperr.Invalid("INVALID_NEXT_TOKEN", "next_token", "next_token is not valid")
+		}
+	}
+	return q, nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "searchUsers",
+	Function: "searchUsers",
+	Summary:  "Searches users by username and display name",
+	Method:   "GET",
+	Path:     "/users/search",
+	Query:    []string{"q", "fuzzy", "limit", "next_token"},
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Index   search.Index
+	Limiter *ratelimit.Limiter
+	Limits  ratelimit.Policy
+	Auth    auth.TokenVerifier
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireSearch(); err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Index:   search.New(client, awsCfg, cfg),
+		Limiter: ratelimit.New(client, cfg),
+		Limits:  limits,
+		Auth:    verifier,
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle returns one page of the users matching the query, best first.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{
+		Q:         r.Query("q"),
+		Fuzzy:     r.Query("fuzzy"),
+		Limit:     r.Query("limit"),
+		NextToken: r.Query("next_token"),
+	}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+
+	q, err := ParseQuery(req)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	hits, more, err := h.Index.Search(ctx, q)
+	if errors.Is(err, search.ErrTooDeep) {
+		return httpx.Error(apperr.Invalid("INVALID_NEXT_TOKEN", "next_token", err.Error())), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+
+	resp := Response{Users: hits}
+	if resp.Users == nil {
+		resp.Users = []search.Hit{}
+	}
+	if more {
+		resp.NextToken = search.EncodeToken(q.Offset + len(hits))
+	}
+	return httpx.JSONFor(r, 200, resp), nil
+}
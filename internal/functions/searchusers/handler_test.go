@@ -0,0 +1,82 @@
+package searchusers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/search"
+)
+
+// fakeIndex serves hits of "u0"... "u<total-1>" for any query.
+type fakeIndex struct {
+	total int
+	last  search.Query
+}
+
+func (f *fakeIndex) Put(context.Context, search.Document) error { return nil }
+func (f *fakeIndex) Delete(context.Context, string) error       { return nil }
+
+func (f *fakeIndex) Search(_ context.Context, q search.Query) ([]search.Hit, bool, error) {
+	f.last = q
+	var hits []search.Hit
+	for i := q.Offset; i < f.total && len(hits) < q.Limit; i++ {
+		hits = append(hits, search.Hit{Document: search.Document{UserID: "u" + string(rune('0'+i))}})
+	}
+	return hits, q.Offset+len(hits) < f.total, nil
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		wantStatus int
+		wantBody   string
+		wantQuery  search.Query
+	}{
+		{
+			name:       "first page",
+			payload:    `{"q":" Jane ","limit":"2"}`,
+			wantStatus: 200, wantBody: `"next_token":"` + search.EncodeToken(2) + `"`,
+			wantQuery: search.Query{Text: "jane", Limit: 2},
+		},
+		{
+			name:       "last page",
+			payload:    `{"q":"jane","fuzzy":"true","limit":"2","next_token":"` + search.EncodeToken(2) + `"}`,
+			wantStatus: 200, wantBody: `"users":[{"user_id":"u2","score":0}]}`,
+			wantQuery: search.Query{Text: "jane", Fuzzy: true, Limit: 2, Offset: 2},
+		},
+		{
+			name:       "through API Gateway",
+			payload:    `{"httpMethod":"GET","path":"/users/search","queryStringParameters":{"q":"ja"}}`,
+			wantStatus: 200, wantBody: `"user_id":"u0"`,
+			wantQuery: search.Query{Text: "ja", Limit: defaultLimit},
+		},
+		{name: "query too short", payload: `{"q":"j"}`, wantStatus: 422, wantBody: "SEARCH_QUERY_INVALID"},
+		{name: "limit too large", payload: `{"q":"jane","limit":"51"}`, wantStatus: 422, wantBody: "INVALID_LIMIT"},
+		{name: "bad token", payload: `{"q":"jane","next_token":"x"}`, wantStatus: 422, wantBody: "INVALID_NEXT_TOKEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := &fakeIndex{total: 3}
+			h := &Handler{Index: index, Config: &config.Config{}}
+
+			resp, err := httpx.Adapt(h.Handle)(context.Background(), json.RawMessage(tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+			if tt.wantStatus == 200 && index.last != tt.wantQuery {
+				t.Errorf("query = %+v, want %+v", index.last, tt.wantQuery)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Package sendemail is the worker draining the email queue: it renders each
+// queued message and sends it through SES, unless the recipient is
+// suppressed or over the template's rate limit.
+//
+// Messages that can never be sent (malformed, unknown template, rejected by
+// SES) are logged and dropped rather than retried into the dead-letter queue;
+// see package sqsx for the batch handling.
+package sendemail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-lambda-go/events"        // Lambda event payloads
+	"github.com/aws/aws-sdk-go-v2/service/sesv2" // SES v2 client
+
+	"troggle-backend/internal/awscfg"    // shared AWS SDK config
+	"troggle-backend/internal/config"    // environment-driven settings
+	"troggle-backend/internal/db"        // shared DynamoDB client
+	"troggle-backend/internal/email"     // templates, suppression list and SES sender
+	"troggle-backend/internal/logging"   // structured JSON logging
+	"troggle-backend/internal/metrics"   // CloudWatch EMF metrics
+	"troggle-backend/internal/ratelimit" // DynamoDB token buckets
+	"troggle-backend/internal/sqsx"      // SQS batch handling
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Sender       *email.Sender
+	Suppressions *email.Suppressions
+	Limiter      *ratelimit.Limiter
+	Config       *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireEmailSender(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Sender:       email.NewSender(sesv2.NewFromConfig(awsCfg), cfg),
+		Suppressions: email.NewSuppressions(client, cfg),
+		Limiter:      ratelimit.New(client, cfg),
+		Config:       cfg,
+	}, nil
+}
+
+// Handle sends every message of the batch and reports the ones to retry.
+func (h *Handler) Handle(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	c := sqsx.Consumer[email.Message]{Process: h.ProcessMessage, MaxReceiveCount: h.Config.QueueMaxReceiveCount}
+	return c.Handle(ctx, event)
+}
+
+// ProcessMessage sends one message. It returns an error only when the
+// message should be delivered again.
+func (h *Handler) ProcessMessage(ctx context.Context, msg *sqsx.Message[email.Message]) error {
+	m := &msg.Body
+	if err := m.Validate(); err != nil {
+		return sqsx.Drop(fmt.Errorf("invalid email message: %w", err))
+	}
+	tmpl, _ := email.Lookup(m.Template)
+	ctx = logging.With(ctx, "template", tmpl.Name, "user_id", m.UserID, logging.EmailHash(m.To))
+
+	sup, err := h.Suppressions.Get(ctx, m.To)
+	if err != nil {
+		return err
+	}
+	if sup != nil && sup.Blocks(tmpl) {
+		slog.InfoContext(ctx, "Email suppressed", "reason", sup.Reason)
+		metrics.Count(ctx, metrics.EmailSuppressed)
+		return nil
+	}
+
+	// Only the first delivery counts against the limit: a retry after an
+	// SES failure is the same email, not another one
+	if msg.First() {
+		wait, err := h.Limiter.Take(ctx, bucket(tmpl.Name, m.To), tmpl.Limit)
+		switch {
+		case err != nil:
+			slog.WarnContext(ctx, "Email rate limiter unavailable", logging.Err(err))
+		case wait > 0:
+			slog.WarnContext(ctx, "Dropping email over its rate limit", "retry_after_ms", wait.Milliseconds())
+			metrics.Count(ctx, metrics.EmailRateLimited)
+			return nil
+		}
+	}
+
+	rendered, err := tmpl.Render(m.Data)
+	if err != nil {
+		return sqsx.Drop(err)
+	}
+
+	id, err := h.Sender.Send(ctx, m.To, tmpl.Name, rendered)
+	if email.Permanent(err) {
+		metrics.Count(ctx, metrics.EmailRejected)
+		return sqsx.Drop(fmt.Errorf("SES rejected email: %w", err))
+	}
+	if err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	slog.InfoContext(ctx, "Email sent", "ses_message_id", id)
+	metrics.Count(ctx, metrics.EmailSent)
+	return nil
+}
+
+// bucket names the rate limit bucket of template for one recipient, without
+// storing the address itself.
+func bucket(template, to string) string {
+	sum := sha256.Sum256([]byte(to))
+	return "email#" + template + "#" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,127 @@
+package sendemail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/email"
+	"troggle-backend/internal/ratelimit"
+)
+
+// fakeSES records sent messages and fails with err, if set.
+type fakeSES struct {
+	err  error
+	sent []*sesv2.SendEmailInput
+}
+
+func (s *fakeSES) SendEmail(_ context.Context, in *sesv2.SendEmailInput, _ ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.sent = append(s.sent, in)
+	return &sesv2.SendEmailOutput{MessageId: aws.String("ses-1")}, nil
+}
+
+// message is an SQS message carrying m, received for the first time.
+func message(id string, m email.Message) events.SQSMessage {
+	body, _ := json.Marshal(m)
+	return events.SQSMessage{MessageId: id, Body: string(body), Attributes: map[string]string{"ApproximateReceiveCount": "1"}}
+}
+
+func TestHandle(t *testing.T) {
+	welcome := email.Message{Template: email.TemplateWelcome, To: "Jane@Example.com", Data: map[string]string{"name": "Jane", "email": "jane@example.com"}}
+	suppressed := func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		if aws.ToString(in.TableName) == "suppressions" {
+			return &dynamodb.GetItemOutput{Item: dbtest.Item("email", "jane@example.com", "reason", email.ReasonComplaint)}, nil
+		}
+		return &dynamodb.GetItemOutput{}, nil
+	}
+
+	tests := []struct {
+		name       string
+		records    []events.SQSMessage
+		get        func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		sesErr     error
+		wantSent   int
+		wantFailed []string
+	}{
+		{
+			name:     "sent",
+			records:  []events.SQSMessage{message("m1", welcome)},
+			wantSent: 1,
+		},
+		{
+			name:    "suppressed",
+			records: []events.SQSMessage{message("m1", welcome)},
+			get:     suppressed,
+		},
+		{
+			name: "malformed and missing data dropped",
+			records: []events.SQSMessage{
+				{MessageId: "m1", Body: "{"},
+				message("m2", email.Message{Template: email.TemplateWelcome, To: "jane@example.com"}),
+				message("m3", email.Message{Template: "newsletter", To: "jane@example.com"}),
+			},
+		},
+		{
+			name:    "rejected by SES",
+			records: []events.SQSMessage{message("m1", welcome)},
+			sesErr:  &sestypes.MessageRejected{Message: aws.String("bad address")},
+		},
+		{
+			name:       "SES throttled",
+			records:    []events.SQSMessage{message("m1", welcome)},
+			sesErr:     &sestypes.TooManyRequestsException{Message: aws.String("slow down")},
+			wantFailed: []string{"m1"},
+		},
+		{
+			name:    "suppression list unavailable",
+			records: []events.SQSMessage{message("m1", welcome)},
+			get: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+				return nil, errors.New("connection reset")
+			},
+			wantFailed: []string{"m1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: tt.get}
+			ses := &fakeSES{err: tt.sesErr}
+			cfg := &config.Config{SuppressionTableName: "suppressions", RateLimitTableName: "rate_limits", EmailFrom: "no-reply@troggle.app"}
+			h := &Handler{
+				Sender:       email.NewSender(ses, cfg),
+				Suppressions: email.NewSuppressions(m.Client(), cfg),
+				Limiter:      ratelimit.New(m.Client(), cfg),
+				Config:       cfg,
+			}
+
+			resp, err := h.Handle(context.Background(), events.SQSEvent{Records: tt.records})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var failed []string
+			for _, f := range resp.BatchItemFailures {
+				failed = append(failed, f.ItemIdentifier)
+			}
+			if len(failed) != len(tt.wantFailed) || (len(failed) > 0 && failed[0] != tt.wantFailed[0]) {
+				t.Errorf("failures = %v, want %v", failed, tt.wantFailed)
+			}
+			if len(ses.sent) != tt.wantSent {
+				t.Fatalf("sent %d emails, want %d", len(ses.sent), tt.wantSent)
+			}
+			if tt.wantSent > 0 && ses.sent[0].Destination.ToAddresses[0] != "jane@example.com" {
+				t.Errorf("sent to %v, want the normalized address", ses.sent[0].Destination.ToAddresses)
+			}
+		})
+	}
+}
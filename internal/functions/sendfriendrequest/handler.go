@@ -0,0 +1,179 @@
+// Package sendfriendrequest asks another user to be friends (PUT
+// /users/{user_id}/friend-requests/{other_id}). If they already asked, the
+// two become friends right away. Either way an event, FriendRequestSent or
+// FriendRequestAccepted, tells the other user's notification inbox. See
+// package relationships.
+package sendfriendrequest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge" // EventBridge client
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/events"        // domain event publishing
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/ratelimit"     // DynamoDB token buckets
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/users"         // user table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+	"troggle-backend/internal/verification"  // email verification gate
+)
+
+// Response statuses.
+const (
+	StatusRequested = "requested"
+	StatusFriends   = "friends"
+)
+
+// rateLimits keep accounts from spamming requests. They can be tuned per
+// stage through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(60),
+	PerUser: ratelimit.PerMinute(30),
+}
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the same values as path parameters.
+type Request struct {
+	UserID  string `json:"user_id"`  // the sender
+	OtherID string `json:"other_id"` // the recipient
+}
+
+// Response represents the JSON output
+type Response struct {
+	Status string `json:"status"` // one of the Status* values
+}
+
+// authorize lets callers send requests as themselves only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only send friend requests as yourself")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "sendFriendRequest",
+	Function: "sendFriendRequest",
+	Summary:  "Asks a user to be friends",
+	Method:   "PUT",
+	Path:     "/users/{user_id}/friend-requests/{other_id}",
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Graph    *relationships.Store
+	Users    *users.Repository
+	Events   *events.Publisher
+	Limiter  *ratelimit.Limiter
+	Limits   ratelimit.Policy
+	Auth     auth.TokenVerifier
+	Verified *verification.Guard // nil lets unverified accounts through
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Graph:    relationships.NewStore(client, cfg),
+		Users:    users.NewRepository(client, cfg),
+		Events:   events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Limiter:  ratelimit.New(client, cfg),
+		Limits:   limits,
+		Auth:     verifier,
+		Verified: verification.NewGuard(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies. Accounts
+// whose email address is not verified are refused.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits), h.Verified.Middleware())
+}
+
+// Handle sends the friend request, or accepts the recipient's.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{UserID: r.PathParams["user_id"], OtherID: r.PathParams["other_id"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	}
+	for _, id := range []string{req.UserID, req.OtherID} {
+		if err := validation.UserID(id); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	blocked, err := h.Graph.IsBlocked(ctx, req.UserID, req.OtherID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if blocked {
+		return httpx.Error(apperr.Forbidden("You cannot interact with this user")), nil
+	}
+
+	accepted, err := h.Graph.RequestFriend(ctx, req.UserID, req.OtherID)
+	switch {
+	case errors.Is(err, relationships.ErrAlreadyFriends):
+		return httpx.Error(apperr.Conflict("Already friends")), nil
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+
+	if !accepted {
+		slog.InfoContext(ctx, "Friend request sent", "user_id", req.UserID, "other_id", req.OtherID)
+		h.Events.Emit(ctx, events.FriendRequestSent{UserID: req.OtherID, FromID: req.UserID, SentAt: events.Now()})
+		return httpx.JSON(200, Response{Status: StatusRequested}), nil
+	}
+	h.Users.Invalidate(req.UserID, "")
+	h.Users.Invalidate(req.OtherID, "")
+	slog.InfoContext(ctx, "Friend request accepted", "user_id", req.UserID, "other_id", req.OtherID)
+	// The other user's request crossed this one, so it is theirs that was
+	// accepted
+	h.Events.Emit(ctx, events.FriendRequestAccepted{UserID: req.OtherID, FriendID: req.UserID, AcceptedAt: events.Now()})
+	return httpx.JSON(200, Response{Status: StatusFriends}), nil
+}
@@ -0,0 +1,97 @@
+package sendfriendrequest
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/users"
+)
+
+// apiEvent is a PUT /users/{user_id}/friend-requests/{other_id} REST API
+// event.
+func apiEvent(userID, otherID string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "PUT",
+		"path":           "/users/" + userID + "/friend-requests/" + otherID,
+		"pathParameters": map[string]string{"user_id": userID, "other_id": otherID},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		pending    bool  // the other user asked first
+		blocked    bool  // either user blocked the other
+		txErr      error // of the request transaction
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "sent", payload: apiEvent("u1", "u2"), wantStatus: 200, wantBody: `"status":"requested"`},
+		{name: "crossing request", payload: apiEvent("u1", "u2"), pending: true, wantStatus: 200, wantBody: `"status":"friends"`},
+		{
+			name: "already friends", payload: apiEvent("u1", "u2"),
+			txErr:      dbtest.TransactionCanceled("ConditionalCheckFailed", "None", "None", "None"),
+			wantStatus: 409,
+		},
+		{
+			name: "no such user", payload: apiEvent("u1", "u9"),
+			txErr:      dbtest.TransactionCanceled("None", "None", "None", "ConditionalCheckFailed"),
+			wantStatus: 404,
+		},
+		{name: "blocked", payload: apiEvent("u1", "u2"), blocked: true, wantStatus: 403},
+		{name: "as another user", payload: apiEvent("u2", "u3"), wantStatus: 403},
+		{name: "to themselves", payload: apiEvent("u1", "u1"), wantStatus: 422, wantBody: relationships.CodeSelf},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u3","other_id":"u4"}`), wantStatus: 200, wantBody: `"status":"requested"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					if edge := in.Key["edge"].(*types.AttributeValueMemberS).Value; strings.HasPrefix(edge, "BLOCK#") {
+						if tt.blocked {
+							return &dynamodb.GetItemOutput{Item: db.Item{"blocked_by": &types.AttributeValueMemberSS{Value: []string{"u2"}}}}, nil
+						}
+						return &dynamodb.GetItemOutput{}, nil
+					}
+					if tt.pending {
+						return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1", "edge", "REQUEST_IN#u2")}, nil
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+				TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					return &dynamodb.TransactWriteItemsOutput{}, tt.txErr
+				},
+			}
+			h := &Handler{
+				Graph:  &relationships.Store{DB: m.Client(), Table: "relationships", UserTable: "users"},
+				Users:  &users.Repository{DB: m.Client(), Table: "users"},
+				Config: &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
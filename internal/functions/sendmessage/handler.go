@@ -0,0 +1,194 @@
+// Package sendmessage sends a direct message (POST
+// /users/{user_id}/conversations/{other_id}/messages with {"body": "..."}).
+// Users who blocked each other cannot message each other. The message is
+// pushed to the recipient's open WebSocket connections, unless they muted
+// the sender, and to the sender's, so their other devices see it too. See
+// package messages.
+package sendmessage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"troggle-backend/internal/api"           // API route declarations
+	"troggle-backend/internal/apperr"        // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"          // Cognito JWT verification
+	"troggle-backend/internal/authz"         // role-based access control
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/httpx"         // API Gateway / direct invocation adapter
+	"troggle-backend/internal/idempotency"   // Idempotency-Key handling
+	"troggle-backend/internal/logging"       // structured JSON logging
+	"troggle-backend/internal/messages"      // direct messages
+	"troggle-backend/internal/presence"      // WebSocket connections
+	"troggle-backend/internal/ratelimit"     // DynamoDB token buckets
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/sessions"      // session table access
+	"troggle-backend/internal/validation"    // input normalization and validation
+	"troggle-backend/internal/verification"  // email verification gate
+)
+
+// MessageType is the type of the realtime message carrying a new message.
+const MessageType = "message"
+
+// rateLimits keep accounts from spamming. They can be tuned per stage
+// through RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(300),
+	PerUser: ratelimit.PerMinute(120),
+}
+
+// Request represents the JSON input. API Gateway callers pass the users as
+// path parameters and only the body in the request body.
+type Request struct {
+	UserID  string `json:"user_id"`  // the sender
+	OtherID string `json:"other_id"` // the recipient
+	Body    string `json:"body"`
+}
+
+// authorize lets callers send as themselves only, unless they are admins.
+// Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only send messages as yourself")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "sendMessage",
+	Function: "sendMessage",
+	Summary:  "Sends a message",
+	Method:   "POST",
+	Path:     "/users/{user_id}/conversations/{other_id}/messages",
+	Request:  Request{},
+	Status:   201,
+	Response: messages.Message{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Messages    *messages.Store
+	Graph       *relationships.Store
+	Realtime    *presence.Poster
+	Idempotency *idempotency.Store // replays responses for retried API requests
+	Limiter     *ratelimit.Limiter
+	Limits      ratelimit.Policy
+	Auth        auth.TokenVerifier
+	Verified    *verification.Guard // nil lets unverified accounts through
+	Config      *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// WebSocket API.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireWebSocket(); err != nil {
+		return nil, err
+	}
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Messages:    messages.NewStore(client, cfg),
+		Graph:       relationships.NewStore(client, cfg),
+		Realtime:    presence.NewPoster(presence.NewStore(client, cfg), awsCfg, cfg),
+		Idempotency: idempotency.New(client, cfg),
+		Limiter:     ratelimit.New(client, cfg),
+		Limits:      limits,
+		Auth:        verifier,
+		Verified:    verification.NewGuard(client, cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies, and before
+// idempotency so keys are scoped to the caller. Accounts whose email
+// address is not verified are refused.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits), h.Verified.Middleware(), h.Idempotency.Wrap)
+}
+
+// Handle stores the message and pushes it to the users' connections.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.UserID, req.OtherID = r.PathParams["user_id"], r.PathParams["other_id"]
+	}
+	for _, id := range []string{req.UserID, req.OtherID} {
+		if err := validation.UserID(id); err != nil {
+			return httpx.Error(err), nil
+		}
+	}
+	body, err := messages.NormalizeBody(req.Body)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	blocked, err := h.Graph.IsBlocked(ctx, req.UserID, req.OtherID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if blocked {
+		return httpx.Error(apperr.Forbidden("You cannot interact with this user")), nil
+	}
+
+	msg, err := h.Messages.Send(ctx, req.UserID, req.OtherID, body)
+	switch {
+	case apperr.KindOf(err) == apperr.KindInvalid, apperr.KindOf(err) == apperr.KindNotFound:
+		return httpx.Error(err), nil
+	case err != nil:
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Message sent", "user_id", req.UserID, "other_id", req.OtherID, "message_id", msg.ID)
+
+	h.push(ctx, msg)
+	return httpx.JSON(201, msg), nil
+}
+
+// push delivers msg to the open connections of both users. The message is
+// stored already, and clients fetch what they missed, so failures are only
+// logged.
+func (h *Handler) push(ctx context.Context, msg messages.Message) {
+	recipients := []string{msg.SenderID}
+	muted, err := h.Graph.IsMuted(ctx, msg.RecipientID, msg.SenderID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to check mute", "message_id", msg.ID, logging.Err(err))
+	}
+	if err == nil && !muted {
+		recipients = append(recipients, msg.RecipientID)
+	}
+	for _, userID := range recipients {
+		if _, err := h.Realtime.Send(ctx, userID, presence.Message{Type: MessageType, Data: msg}); err != nil {
+			slog.WarnContext(ctx, "Failed to push message", "user_id", userID, "message_id", msg.ID, logging.Err(err))
+		}
+	}
+}
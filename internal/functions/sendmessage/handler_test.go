@@ -0,0 +1,145 @@
+package sendmessage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/messages"
+	"troggle-backend/internal/presence"
+	"troggle-backend/internal/relationships"
+)
+
+// apiEvent is a POST /users/{user_id}/conversations/{other_id}/messages
+// REST API event.
+func apiEvent(userID, otherID, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/conversations/" + otherID + "/messages",
+		"pathParameters": map[string]string{"user_id": userID, "other_id": otherID},
+		"body":           body,
+	})
+	return event
+}
+
+// fakeAPI accepts every post to a connection and records the connections
+// posted to.
+func fakeAPI(t *testing.T) (*presence.Poster, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		posted = append(posted, strings.TrimPrefix(r.URL.Path, "/@connections/"))
+	}))
+	t.Cleanup(srv.Close)
+	poster := &presence.Poster{
+		Endpoint: srv.URL,
+		Region:   "eu-west-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+		Signer: v4.NewSigner(),
+		HTTP:   srv.Client(),
+	}
+	return poster, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		slices.Sort(posted)
+		return posted
+	}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		edge       string // the relationship item read for blocks and mutes
+		txErr      error
+		wantStatus int
+		wantPushed []string // connections, one per user named c-<user>
+	}{
+		{name: "sent", payload: apiEvent("u1", "u2", `{"body":" hi "}`), wantStatus: 201, wantPushed: []string{"c-u1", "c-u2"}},
+		{name: "muted by recipient", payload: apiEvent("u1", "u2", `{"body":"hi"}`), edge: "MUTE", wantStatus: 201, wantPushed: []string{"c-u1"}},
+		{name: "blocked", payload: apiEvent("u1", "u2", `{"body":"hi"}`), edge: "BLOCK", wantStatus: 403},
+		{name: "empty", payload: apiEvent("u1", "u2", `{"body":"  "}`), wantStatus: 422},
+		{name: "not JSON", payload: apiEvent("u1", "u2", `hi`), wantStatus: 400},
+		{name: "as another user", payload: apiEvent("u2", "u3", `{"body":"hi"}`), wantStatus: 403},
+		{name: "themselves", payload: apiEvent("u1", "u1", `{"body":"hi"}`), wantStatus: 422},
+		{
+			name: "no such user", payload: apiEvent("u1", "u9", `{"body":"hi"}`),
+			txErr:      dbtest.TransactionCanceled("None", "None", "None", "ConditionalCheckFailed"),
+			wantStatus: 404,
+		},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u3","other_id":"u4","body":"hi"}`), wantStatus: 201, wantPushed: []string{"c-u3", "c-u4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expires := fmt.Sprint(time.Now().Add(time.Minute).Unix())
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					edge := in.Key["edge"].(*types.AttributeValueMemberS).Value
+					switch {
+					case tt.edge == "BLOCK" && strings.HasPrefix(edge, "BLOCK#"):
+						return &dynamodb.GetItemOutput{Item: db.Item{"blocked_by": &types.AttributeValueMemberSS{Value: []string{"u2"}}}}, nil
+					case tt.edge == "MUTE" && strings.HasPrefix(edge, "MUTE#"):
+						return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u2", "edge", edge)}, nil
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+				TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					return &dynamodb.TransactWriteItemsOutput{}, tt.txErr
+				},
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					user := in.ExpressionAttributeValues[":user_id"].(*types.AttributeValueMemberS).Value
+					item := dbtest.Item("connection_id", "c-"+user, "user_id", user)
+					item["expires_at"] = &types.AttributeValueMemberN{Value: expires}
+					return &dynamodb.QueryOutput{Items: []db.Item{item}}, nil
+				},
+			}
+			poster, pushed := fakeAPI(t)
+			poster.Connections = &presence.Store{DB: m.Client(), Table: "connections", UserIndex: "user-index"}
+			h := &Handler{
+				Messages: &messages.Store{DB: m.Client(), Table: "messages", UserTable: "users"},
+				Graph:    &relationships.Store{DB: m.Client(), Table: "relationships", UserTable: "users"},
+				Realtime: poster,
+				Config:   &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if got := pushed(); !slices.Equal(got, tt.wantPushed) {
+				t.Errorf("pushed to %v, want %v", got, tt.wantPushed)
+			}
+			if tt.wantStatus == 201 && !strings.Contains(resp.Body, `"body":"hi"`) {
+				t.Errorf("body = %s", resp.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Package sendpush pushes a notification to every registered device of a
+// user. Other backend functions invoke it directly; it has no API route.
+package sendpush
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns" // SNS client
+
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/devices"     // device token registry
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/preferences" // notification preferences
+	"troggle-backend/internal/push"        // SNS mobile push
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// Request represents the JSON input.
+type Request struct {
+	UserID       string            `json:"user_id"`
+	Notification push.Notification `json:"notification"`
+}
+
+// Response is the JSON output. A push the user's preferences turn off is
+// not sent, and Skipped says why.
+type Response struct {
+	push.Result
+	Skipped string `json:"skipped_reason,omitempty"`
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Push        *push.Sender
+	Preferences *preferences.Store
+	Config      *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Push:        push.NewSender(sns.NewFromConfig(awsCfg), devices.NewStore(client, cfg), cfg),
+		Preferences: preferences.NewStore(client, cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// Handle pushes the notification, unless the user turned such pushes off,
+// and returns how many devices got it.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	if !r.Direct {
+		return httpx.Error(apperr.Forbidden("sendPush can only be invoked directly")), nil
+	}
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := req.Notification.Validate(); err != nil {
+		return httpx.Error(apperr.BadRequest(err.Error())), nil
+	}
+
+	stored, _, err := h.Preferences.Get(ctx, req.UserID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	settings := preferences.Resolve(stored)[preferences.Notifications]
+	switch {
+	case settings["push_enabled"] == false:
+		return httpx.JSON(200, Response{Skipped: "push_disabled"}), nil
+	case req.Notification.Kind == push.KindSocial && settings["push_social"] == false:
+		return httpx.JSON(200, Response{Skipped: "push_social_disabled"}), nil
+	}
+
+	res, err := h.Push.Send(ctx, req.UserID, req.Notification)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Push sent", "user_id", req.UserID, "sent", res.Sent, "failed", res.Failed, "pruned", res.Pruned)
+	return httpx.JSON(200, Response{Result: res}), nil
+}
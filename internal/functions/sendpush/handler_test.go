@@ -0,0 +1,90 @@
+package sendpush
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/devices"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/preferences"
+	"troggle-backend/internal/push"
+)
+
+func TestHandle(t *testing.T) {
+	stored := func(settings map[string]any) func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		return func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			item, err := attributevalue.MarshalMap(map[string]any{
+				"user_id":     "u1",
+				"preferences": preferences.Preferences{preferences.Notifications: settings},
+				"version":     1,
+			})
+			return &dynamodb.GetItemOutput{Item: item}, err
+		}
+	}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		get        func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "no devices",
+			payload:    json.RawMessage(`{"user_id":"u1","notification":{"title":"Hi"}}`),
+			wantStatus: 200, wantBody: `"sent":0`,
+		},
+		{
+			name:       "push disabled",
+			payload:    json.RawMessage(`{"user_id":"u1","notification":{"title":"Hi"}}`),
+			get:        stored(map[string]any{"push_enabled": false}),
+			wantStatus: 200, wantBody: `"skipped_reason":"push_disabled"`,
+		},
+		{
+			name:       "social pushes disabled",
+			payload:    json.RawMessage(`{"user_id":"u1","notification":{"title":"Hi","kind":"social"}}`),
+			get:        stored(map[string]any{"push_social": false}),
+			wantStatus: 200, wantBody: `"skipped_reason":"push_social_disabled"`,
+		},
+		{
+			name:       "empty notification",
+			payload:    json.RawMessage(`{"user_id":"u1","notification":{}}`),
+			wantStatus: 400,
+		},
+		{
+			name: "API caller",
+			payload: json.RawMessage(`{"httpMethod":"POST","path":"/push","headers":{},` +
+				`"body":"{\"user_id\":\"u1\",\"notification\":{\"title\":\"Hi\"}}"}`),
+			wantStatus: 403,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: tt.get}
+			cfg := &config.Config{PreferenceTableName: "preferences", DeviceTableName: "devices"}
+			h := &Handler{
+				Push:        push.NewSender(nil, devices.NewStore(m.Client(), cfg), cfg),
+				Preferences: preferences.NewStore(m.Client(), cfg),
+				Config:      cfg,
+			}
+
+			resp, err := httpx.Adapt(h.Handle)(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Package submitscore records a score (POST
+// /users/{user_id}/leaderboards/{board}/scores with {"score": 1234}),
+// raising the user's all-time and weekly bests on the board where it beats
+// them. See package leaderboard.
+package submitscore
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/authz"       // role-based access control
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/leaderboard" // scores and ranks
+	"troggle-backend/internal/ratelimit"   // DynamoDB token buckets
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// rateLimits allow a score every few seconds, more than any game produces.
+// They can be tuned per stage through RATE_LIMIT_PER_IP and
+// RATE_LIMIT_PER_USER.
+var rateLimits = ratelimit.Policy{
+	PerIP:   ratelimit.PerMinute(120),
+	PerUser: ratelimit.PerMinute(30),
+}
+
+// Request represents the JSON input. API Gateway callers pass the user and
+// board as path parameters and only the score in the request body.
+type Request struct {
+	UserID string `json:"user_id"`
+	Board  string `json:"board"`
+	Score  *int64 `json:"score"`
+}
+
+// Response represents the JSON output
+type Response struct {
+	Bests []leaderboard.Best `json:"bests"` // all-time, then weekly
+}
+
+// authorize lets callers submit their own scores only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only submit your own scores")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "submitScore",
+	Function: "submitScore",
+	Summary:  "Records a score on a leaderboard",
+	Method:   "POST",
+	Path:     "/users/{user_id}/leaderboards/{board}/scores",
+	Request:  Request{},
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Leaderboards *leaderboard.Store
+	Limiter      *ratelimit.Limiter
+	Limits       ratelimit.Policy
+	Auth         auth.TokenVerifier
+	Config       *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	limits, err := rateLimits.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Leaderboards: leaderboard.NewStore(client, cfg),
+		Limiter:      ratelimit.New(client, cfg),
+		Limits:       limits,
+		Auth:         verifier,
+		Config:       cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. The token is
+// verified before rate limiting so the per-user limit applies. A retried
+// submission cannot lower a best, so the endpoint needs no idempotency key.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Limiter.Middleware(h.Limits))
+}
+
+// Handle records the score and returns the bests it leaves.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if err := r.Decode(&req); err != nil {
+		return httpx.Error(apperr.BadRequest("Invalid request")), nil
+	}
+	if !r.Direct {
+		req.UserID, req.Board = r.PathParams["user_id"], r.PathParams["board"]
+	}
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if req.Score == nil {
+		return httpx.Error(apperr.Invalid(leaderboard.CodeScoreRequired, "score", "score is required")), nil
+	}
+	if err := leaderboard.CheckScore(*req.Score); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := h.Leaderboards.Check(req.Board); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	bests, err := h.Leaderboards.Submit(ctx, req.Board, req.UserID, *req.Score, time.Now())
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Score submitted", "user_id", req.UserID, "board", req.Board, "score", *req.Score)
+	return httpx.JSON(200, Response{Bests: bests}), nil
+}
@@ -0,0 +1,73 @@
+package submitscore
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/leaderboard"
+)
+
+// apiEvent is a POST /users/{user_id}/leaderboards/{board}/scores REST API
+// event.
+func apiEvent(userID, board, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "POST",
+		"path":           "/users/" + userID + "/leaderboards/" + board + "/scores",
+		"pathParameters": map[string]string{"user_id": userID, "board": board},
+		"body":           body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     json.RawMessage
+		wantStatus  int
+		wantUpdates int
+	}{
+		{name: "submitted", payload: apiEvent("u1", "main", `{"score":1200}`), wantStatus: 200, wantUpdates: 2},
+		{name: "zero", payload: apiEvent("u1", "main", `{"score":0}`), wantStatus: 200, wantUpdates: 2},
+		{name: "missing score", payload: apiEvent("u1", "main", `{}`), wantStatus: 422},
+		{name: "negative", payload: apiEvent("u1", "main", `{"score":-1}`), wantStatus: 422},
+		{name: "fraction", payload: apiEvent("u1", "main", `{"score":1.5}`), wantStatus: 400},
+		{name: "too high", payload: apiEvent("u1", "main", `{"score":9007199254740992}`), wantStatus: 422},
+		{name: "unknown board", payload: apiEvent("u1", "other", `{"score":1}`), wantStatus: 404},
+		{name: "as another user", payload: apiEvent("u2", "main", `{"score":1}`), wantStatus: 403},
+		{name: "direct", payload: json.RawMessage(`{"user_id":"u2","board":"main","score":5}`), wantStatus: 200, wantUpdates: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				return &dynamodb.UpdateItemOutput{}, nil
+			}}
+			h := &Handler{
+				Leaderboards: &leaderboard.Store{DB: m.Client(), Table: "leaderboard", Boards: []string{"main"}, Shards: 4},
+				Config:       &config.Config{},
+			}
+
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1"})
+			resp, err := httpx.Adapt(h.Handle)(ctx, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if n := len(m.Calls); n != tt.wantUpdates {
+				t.Errorf("%d updates, want %d", n, tt.wantUpdates)
+			}
+			if tt.wantStatus == 200 && !strings.Contains(resp.Body, `"new_best":true`) {
+				t.Errorf("body = %s", resp.Body)
+			}
+		})
+	}
+}
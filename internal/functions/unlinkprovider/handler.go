@@ -0,0 +1,188 @@
+// Package unlinkprovider unlinks a Google or Apple account from a user
+// (DELETE /users/{user_id}/providers/{provider}; see package providers).
+// Cognito stops signing the provider account in as the user; signing in
+// with it again creates a new user of its own.
+package unlinkprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"                             // aws package
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider" // Cognito user pool client
+	cognitotypes "github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"      // audit log
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/logging"    // structured JSON logging
+	"troggle-backend/internal/mfa"        // step-up of high-risk operations
+	"troggle-backend/internal/providers"  // linked identity providers
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/users"      // user table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// ErrUnsupportedProvider refuses providers other than Google and Apple.
+var ErrUnsupportedProvider = apperr.Invalid("UNSUPPORTED_PROVIDER", "provider", `provider must be "google" or "apple"`)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers name the account and the provider in the path.
+type Request struct {
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"` // "google" or "apple"
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "unlinkProvider",
+	Function: "unlinkProvider",
+	Summary:  "Unlinks a Google or Apple account from a user",
+	Method:   "DELETE",
+	Path:     "/users/{user_id}/providers/{provider}",
+	Status:   204,
+}}
+
+// authorize lets callers unlink from their own account only. Direct
+// invocations are trusted, for support to help users who lost the
+// provider account.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID || id.TokenUse == auth.TokenUseAPIKey {
+		return apperr.Forbidden("You may only unlink providers from your own account")
+	}
+	return nil
+}
+
+// CognitoAPI is the part of the Cognito user pool API the function uses.
+type CognitoAPI interface {
+	AdminDisableProviderForUser(ctx context.Context, params *cognitoidentityprovider.AdminDisableProviderForUserInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDisableProviderForUserOutput, error)
+}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Users   *users.Repository
+	Links   *providers.Store
+	Cognito CognitoAPI
+	Auth    auth.TokenVerifier
+	MFA     *mfa.Guard // nil skips the step-up check
+	Audit   *audit.Store
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg, which must name the
+// Cognito user pool.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireUserPool(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Users:   users.NewRepository(client, cfg),
+		Links:   providers.NewStore(client, cfg),
+		Cognito: cognitoidentityprovider.NewFromConfig(awsCfg),
+		Auth:    verifier,
+		MFA:     mfa.NewGuard(client, cfg),
+		Audit:   audit.NewStore(client, cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. Unlinking is a
+// high-risk operation: callers with a second factor must have signed in
+// recently.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.MFA.Middleware())
+}
+
+// Handle unlinks the provider and answers 204, whether or not it was
+// linked.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	} else {
+		req.UserID = r.PathParams["user_id"]
+		req.Provider = r.PathParams["provider"]
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	cognitoName, ok := providers.CognitoName(req.Provider)
+	if !ok {
+		return httpx.Error(ErrUnsupportedProvider), nil
+	}
+	ctx = logging.With(ctx, "user_id", req.UserID)
+
+	user, err := h.Users.Uncached().Get(ctx, req.UserID, nil)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if user == nil {
+		return httpx.Error(apperr.NotFound("User not found")), nil
+	}
+	subject := providers.Subject(user, req.Provider)
+	if subject == "" {
+		return httpx.NoContent(), nil
+	}
+
+	_, err = h.Cognito.AdminDisableProviderForUser(ctx, &cognitoidentityprovider.AdminDisableProviderForUserInput{
+		UserPoolId: aws.String(h.Config.UserPoolID),
+		User: &cognitotypes.ProviderUserIdentifierType{
+			ProviderName:           aws.String(cognitoName),
+			ProviderAttributeName:  aws.String("Cognito_Subject"),
+			ProviderAttributeValue: aws.String(subject),
+		},
+	})
+	var notFound *cognitotypes.UserNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return httpx.Response{}, fmt.Errorf("unlinking provider user: %w", err)
+	}
+	unlinked, err := h.Links.Unlink(ctx, req.UserID, req.Provider)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if !unlinked {
+		return httpx.NoContent(), nil
+	}
+
+	slog.InfoContext(ctx, "Provider unlinked", "provider", req.Provider)
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(req.UserID),
+		Action:    audit.ActionProviderUnlink,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      map[string]audit.Change{providers.Attribute + "." + req.Provider: {Before: "linked"}},
+	})
+	return httpx.NoContent(), nil
+}
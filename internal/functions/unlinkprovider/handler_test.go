@@ -0,0 +1,110 @@
+package unlinkprovider
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/providers"
+	"troggle-backend/internal/users"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeCognito records the provider accounts unlinked.
+type fakeCognito struct{ unlinked []string }
+
+func (f *fakeCognito) AdminDisableProviderForUser(_ context.Context, in *cognitoidentityprovider.AdminDisableProviderForUserInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDisableProviderForUserOutput, error) {
+	f.unlinked = append(f.unlinked, aws.ToString(in.User.ProviderName)+"/"+aws.ToString(in.User.ProviderAttributeValue))
+	return &cognitoidentityprovider.AdminDisableProviderForUserOutput{}, nil
+}
+
+// apiEvent is an authenticated REST API event unlinking provider from
+// userID.
+func apiEvent(userID, provider string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "DELETE",
+		"path":           "/users/" + userID + "/providers/" + provider,
+		"pathParameters": map[string]string{"user_id": userID, "provider": provider},
+		"headers":        map[string]string{"Authorization": "Bearer valid"},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	linked := dbtest.Item("user_id", "u1")
+	linked[providers.Attribute] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+		providers.Google: &types.AttributeValueMemberS{Value: "2026-01-01T00:00:00Z"},
+	}}
+	linked[providers.SubjectsAttribute] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+		providers.Google: &types.AttributeValueMemberS{Value: "g-123"},
+	}}
+
+	tests := []struct {
+		name         string
+		userID       string
+		provider     string
+		user         db.Item
+		wantStatus   int
+		wantUnlinked []string
+	}{
+		{name: "linked", userID: "u1", provider: "google", user: linked, wantStatus: 204, wantUnlinked: []string{"Google/g-123"}},
+		{name: "not linked", userID: "u1", provider: "apple", user: linked, wantStatus: 204},
+		{name: "unknown provider", userID: "u1", provider: "facebook", user: linked, wantStatus: 422},
+		{name: "other user", userID: "u2", provider: "google", user: linked, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.user}, nil
+				},
+			}
+			cognito := &fakeCognito{}
+			cfg := &config.Config{UserTableName: "users", UserPoolID: "pool"}
+			h := &Handler{
+				Users:   users.NewRepository(m.Client(), cfg),
+				Links:   providers.NewStore(m.Client(), cfg),
+				Cognito: cognito,
+				Auth:    stubVerifier{&auth.Identity{Subject: "u1", TokenUse: "access"}},
+				Config:  cfg,
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), apiEvent(tt.userID, tt.provider))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if len(cognito.unlinked) != len(tt.wantUnlinked) || (len(tt.wantUnlinked) > 0 && cognito.unlinked[0] != tt.wantUnlinked[0]) {
+				t.Errorf("unlinked = %v, want %v", cognito.unlinked, tt.wantUnlinked)
+			}
+			var removed bool
+			for _, op := range m.Ops() {
+				removed = removed || op == "UpdateItem"
+			}
+			if removed != (len(tt.wantUnlinked) > 0) {
+				t.Errorf("ops = %v", m.Ops())
+			}
+		})
+	}
+}
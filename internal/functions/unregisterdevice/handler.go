@@ -0,0 +1,110 @@
+// Package unregisterdevice stops push notifications to a device, for apps
+// signing out or turning notifications off.
+package unregisterdevice
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/awscfg"     // shared AWS SDK config
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/devices"    // device token registry
+	"troggle-backend/internal/events"     // domain event publishing
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"   // revocation-checking token verifier
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers pass the token as a path parameter and ?platform=, and unregister
+// devices of their own account.
+type Request struct {
+	UserID   string `json:"user_id"`
+	Platform string `json:"platform"`
+	Token    string `json:"token"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "unregisterDevice",
+	Function: "unregisterDevice",
+	Summary:  "Stops push notifications to a device",
+	Method:   "DELETE",
+	Path:     "/devices/{token}",
+	Query:    []string{"platform"},
+	Status:   204,
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Devices *devices.Store
+	Auth    auth.TokenVerifier
+	Events  *events.Publisher
+	Config  *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Devices: devices.NewStore(client, cfg),
+		Auth:    verifier,
+		Events:  events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Config:  cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle removes the token and answers 204, whether or not it was
+// registered, so clients can safely retry.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	req := Request{Platform: r.Query("platform"), Token: r.PathParams["token"]}
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	} else {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req.UserID = id.Subject
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	platform, token, err := devices.NormalizeToken(req.Platform, req.Token)
+	if err != nil {
+		return httpx.Error(err), nil
+	}
+
+	if err := h.Devices.Unregister(ctx, req.UserID, token); err != nil {
+		return httpx.Response{}, err
+	}
+	slog.InfoContext(ctx, "Device unregistered", "user_id", req.UserID)
+	h.Events.Emit(ctx, events.DeviceUnregistered{UserID: req.UserID, Platform: platform, UnregisteredAt: events.Now()})
+	return httpx.NoContent(), nil
+}
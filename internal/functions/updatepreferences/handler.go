@@ -0,0 +1,165 @@
+// Package updatepreferences applies partial updates to a user's notification
+// and privacy settings and announces notification changes on the event bus,
+// so the senders stop (or start) contacting the user.
+package updatepreferences
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"       // audit log
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/authz"       // role-based access control
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/events"      // domain event publishing
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/preferences" // preference table access
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// authorize lets callers update their own preferences only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only update your own preferences")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "updatePreferences",
+	Function: "updatePreferences",
+	Summary:  "Updates some preferences of a user",
+	Method:   "PATCH",
+	Path:     "/users/{user_id}/preferences",
+	Request:  preferences.Preferences{},
+	Response: preferences.Preferences{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Preferences *preferences.Store
+	Auth        auth.TokenVerifier
+	Events      *events.Publisher
+	Audit       *audit.Store
+	Config      *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		Preferences: preferences.NewStore(client, cfg),
+		Auth:        verifier,
+		Events:      events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Audit:       audit.NewStore(client, cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle applies a partial update to the preferences of the user named by
+// the user_id path parameter (or the user_id field of a direct invocation)
+// and returns the resulting preferences, defaults included.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	userID := r.PathParams["user_id"]
+	body := r.Body
+
+	// Direct invocations carry the user_id inside the document
+	if r.Direct {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+		_ = json.Unmarshal(doc["user_id"], &userID)
+		delete(doc, "user_id")
+		body, _ = json.Marshal(doc)
+	}
+
+	if err := validation.UserID(userID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, userID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	patch, err := preferences.ParsePatch(body)
+	if err != nil {
+		return httpx.Error(apperr.BadRequest(err.Error())), nil
+	}
+
+	slog.InfoContext(ctx, "Updating preferences", "user_id", userID, "table", h.Preferences.Table)
+	before, after, err := h.Preferences.Update(ctx, userID, patch)
+	if errors.Is(err, preferences.ErrConflict) {
+		return httpx.Error(apperr.Conflict("Preferences are being modified by another request; retry")), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+
+	if diff := audit.Diff(flatten(before), flatten(after)); len(diff) > 0 {
+		h.Audit.Log(ctx, audit.Entry{
+			Resource:  audit.UserResource(userID),
+			Action:    audit.ActionPreferencesUpdate,
+			Actor:     audit.ActorOf(ctx, r),
+			RequestID: audit.RequestID(ctx, r),
+			Diff:      diff,
+		})
+	}
+
+	// The update is stored either way; a lost event is logged for replay
+	if changed := before.Changed(after, preferences.Notifications); len(changed) > 0 {
+		h.Events.Emit(ctx, events.NotificationPreferencesChanged{
+			UserID:        userID,
+			Notifications: after[preferences.Notifications],
+			Changed:       changed,
+			ChangedAt:     events.Now(),
+		})
+	}
+
+	return httpx.JSON(200, after), nil
+}
+
+// flatten names each setting of p by group, as in "notifications.email".
+func flatten(p preferences.Preferences) map[string]any {
+	flat := map[string]any{}
+	for group, settings := range p {
+		for name, value := range settings {
+			flat[group+"."+name] = value
+		}
+	}
+	return flat
+}
@@ -0,0 +1,181 @@
+package updatepreferences
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/events"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/preferences"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// fakeEvents records published events.
+type fakeEvents struct {
+	published []*eventbridge.PutEventsInput
+}
+
+func (e *fakeEvents) PutEvents(_ context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	e.published = append(e.published, in)
+	return &eventbridge.PutEventsOutput{}, nil
+}
+
+// apiEvent is a PATCH /users/{user_id}/preferences REST API event.
+func apiEvent(userID, token, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "PATCH",
+		"path":           "/users/" + userID + "/preferences",
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer " + token},
+		"body":           body,
+	})
+	return event
+}
+
+// stored answers GetItem with a preference item at version 1.
+func stored(prefs preferences.Preferences) func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		item, err := attributevalue.MarshalMap(map[string]any{"user_id": "u1", "preferences": prefs, "version": 1})
+		return &dynamodb.GetItemOutput{Item: item}, err
+	}
+}
+
+func TestHandle(t *testing.T) {
+	owner := &auth.Identity{Subject: "u1"}
+	other := &auth.Identity{Subject: "u2"}
+	marketing := stored(preferences.Preferences{preferences.Notifications: {"email_marketing": true}})
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		get        func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		put        func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+		wantStatus int
+		wantBody   string
+		wantPuts   int
+		wantEvent  string // "changed" of the published event; empty means none
+	}{
+		{
+			name:       "first update",
+			payload:    json.RawMessage(`{"user_id":"u1","notifications":{"push_enabled":false}}`),
+			wantStatus: 200, wantBody: `"push_enabled":false`, wantPuts: 1, wantEvent: `["push_enabled"]`,
+		},
+		{
+			name:       "owner",
+			payload:    apiEvent("u1", "valid", `{"notifications":{"email_marketing":false}}`),
+			caller:     owner,
+			get:        marketing,
+			wantStatus: 200, wantBody: `"email_marketing":false`, wantPuts: 1, wantEvent: `["email_marketing"]`,
+		},
+		{
+			name:       "unchanged notifications",
+			payload:    json.RawMessage(`{"user_id":"u1","notifications":{"email_marketing":true}}`),
+			get:        marketing,
+			wantStatus: 200, wantPuts: 1,
+		},
+		{
+			name:       "privacy only",
+			payload:    json.RawMessage(`{"user_id":"u1","privacy":{"searchable":false}}`),
+			wantStatus: 200, wantBody: `"searchable":false`, wantPuts: 1,
+		},
+		{
+			name:    "concurrent updates",
+			payload: json.RawMessage(`{"user_id":"u1","privacy":{"searchable":false}}`),
+			get:     marketing,
+			put: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+				return nil, dbtest.ConditionFailed()
+			},
+			wantStatus: 409, wantPuts: 3,
+		},
+		{
+			name:       "another user",
+			payload:    apiEvent("u1", "valid", `{"privacy":{"searchable":false}}`),
+			caller:     other,
+			wantStatus: 403,
+		},
+		{
+			name:       "unknown setting",
+			payload:    json.RawMessage(`{"user_id":"u1","privacy":{"show_email":true}}`),
+			wantStatus: 400, wantBody: "unknown preference",
+		},
+		{
+			name:       "invalid id",
+			payload:    json.RawMessage(`{"user_id":"USER#1","privacy":{"searchable":false}}`),
+			wantStatus: 422,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: tt.get, PutItemFunc: tt.put}
+			fake := &fakeEvents{}
+			cfg := &config.Config{PreferenceTableName: "preferences", EventBusName: "bus"}
+			h := &Handler{
+				Preferences: preferences.NewStore(m.Client(), cfg),
+				Auth:        stubVerifier{id: tt.caller},
+				Events:      &events.Publisher{API: fake, Bus: "bus"},
+				Config:      cfg,
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+
+			puts := 0
+			for _, op := range m.Ops() {
+				if op == "PutItem" {
+					puts++
+				}
+			}
+			if puts != tt.wantPuts {
+				t.Errorf("PutItem calls = %d, want %d", puts, tt.wantPuts)
+			}
+
+			if tt.wantEvent == "" {
+				if len(fake.published) != 0 {
+					t.Errorf("published %d events, want none", len(fake.published))
+				}
+				return
+			}
+			if len(fake.published) != 1 {
+				t.Fatalf("published %d events, want 1", len(fake.published))
+			}
+			var detail struct {
+				Changed json.RawMessage `json:"changed"`
+			}
+			entry := fake.published[0].Entries[0]
+			if err := json.Unmarshal([]byte(aws.ToString(entry.Detail)), &detail); err != nil {
+				t.Fatal(err)
+			}
+			if aws.ToString(entry.DetailType) != "NotificationPreferencesChanged" || string(detail.Changed) != tt.wantEvent {
+				t.Errorf("event %s changed %s, want %s", aws.ToString(entry.DetailType), detail.Changed, tt.wantEvent)
+			}
+		})
+	}
+}
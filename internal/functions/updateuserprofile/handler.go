@@ -0,0 +1,420 @@
+// Package updateuserprofile applies partial, optimistically locked updates to
+// user profiles.
+package updateuserprofile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws" // aws package
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	"troggle-backend/internal/api"         // API route declarations
+	"troggle-backend/internal/apperr"      // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/audit"       // audit log
+	"troggle-backend/internal/auth"        // Cognito JWT verification
+	"troggle-backend/internal/authz"       // role-based access control
+	"troggle-backend/internal/awscfg"      // shared AWS SDK config
+	"troggle-backend/internal/config"      // environment-driven settings
+	"troggle-backend/internal/db"          // shared DynamoDB client
+	"troggle-backend/internal/events"      // domain event publishing
+	"troggle-backend/internal/httpx"       // API Gateway / direct invocation adapter
+	"troggle-backend/internal/idempotency" // Idempotency-Key handling
+	"troggle-backend/internal/sessions"    // session table access
+	"troggle-backend/internal/users"       // user table access
+	"troggle-backend/internal/validation"  // input normalization and validation
+)
+
+// updatableFields lists the profile attributes callers may change, with the
+// maximum length (in characters) of each value.
+var updatableFields = map[string]int{
+	"display_name": 64,
+	"bio":          500,
+	"avatar_url":   2048,
+	"username":     30,
+}
+
+// ErrVersionConflict is returned when the stored version no longer matches the
+// version the caller based its update on, or the user does not exist. The
+// error returned wraps it with the current version and values of the fields.
+var ErrVersionConflict = users.ErrVersionConflict
+
+// ErrUsernameTaken is returned when another user holds the username, or one
+// confusable with it.
+var ErrUsernameTaken = users.ErrUsernameTaken
+
+// Update is a validated partial profile update.
+type Update struct {
+	UserID  string
+	Version int               // version the caller read; 0 for records that predate versioning
+	Fields  map[string]string // attribute name -> new value
+}
+
+// ParseUpdate validates a partial JSON document. It must contain the
+// "version" the caller last read and at least one updatable field; unknown
+// fields are rejected rather than silently ignored. A username is normalized,
+// and rejected with an apperr validation error if it is not acceptable.
+func ParseUpdate(userID string, body []byte) (Update, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return Update{}, errors.New("body must be a JSON object")
+	}
+
+	update := Update{UserID: userID, Fields: map[string]string{}}
+
+	rawVersion, ok := doc["version"]
+	if !ok {
+		return Update{}, errors.New("version is required")
+	}
+	if err := json.Unmarshal(rawVersion, &update.Version); err != nil || update.Version < 0 {
+		return Update{}, errors.New("version must be a non-negative integer")
+	}
+	delete(doc, "version")
+
+	for name, raw := range doc {
+		maxLen, ok := updatableFields[name]
+		if !ok {
+			return Update{}, fmt.Errorf("field %q cannot be updated", name)
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return Update{}, fmt.Errorf("field %q must be a string", name)
+		}
+		if utf8.RuneCountInString(value) > maxLen {
+			return Update{}, fmt.Errorf("field %q exceeds %d characters", name, maxLen)
+		}
+		update.Fields[name] = value
+	}
+
+	if len(update.Fields) == 0 {
+		return Update{}, errors.New("no fields to update")
+	}
+	if raw, ok := update.Fields["username"]; ok {
+		username, err := validation.NormalizeUsername(raw)
+		if err != nil {
+			return Update{}, err
+		}
+		update.Fields["username"] = username
+	}
+	return update, nil
+}
+
+// BuildUpdateInput builds an UpdateItem call that sets only the supplied
+// fields, bumps version and updated_at, and only succeeds if the stored
+// version still equals the one the caller read. It returns the previous
+// values of the changed attributes, for the audit log, or, if the condition
+// fails, the stored item, for the conflict.
+func BuildUpdateInput(update Update, tableName string, now time.Time) *dynamodb.UpdateItemInput {
+	names := map[string]string{
+		"#version":    "version",
+		"#updated_at": "updated_at",
+	}
+	values := map[string]types.AttributeValue{
+		":next_version": &types.AttributeValueMemberN{Value: strconv.Itoa(update.Version + 1)},
+		":updated_at":   &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+	}
+	sets := []string{"#version = :next_version", "#updated_at = :updated_at"}
+
+	// Iterate in a stable order so identical updates produce identical expressions
+	fields := make([]string, 0, len(update.Fields))
+	for name := range update.Fields {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+
+	for i, name := range fields {
+		n, v := fmt.Sprintf("#f%d", i), fmt.Sprintf(":f%d", i)
+		names[n] = name
+		values[v] = &types.AttributeValueMemberS{Value: update.Fields[name]}
+		sets = append(sets, n+" = "+v)
+	}
+
+	// Records written before versioning have no version attribute; a caller
+	// that read such a record sends version 0
+	condition := "attribute_exists(user_id) AND #version = :expected_version"
+	if update.Version == 0 {
+		condition = "attribute_exists(user_id) AND attribute_not_exists(#version)"
+	} else {
+		values[":expected_version"] = &types.AttributeValueMemberN{Value: strconv.Itoa(update.Version)}
+	}
+
+	return &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"user_id": &types.AttributeValueMemberS{Value: update.UserID},
+		},
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ")),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueUpdatedOld,
+
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+}
+
+// UpdateProfile applies the update and returns the attributes it changed,
+// including the new version, with their new and their previous values.
+// Updates changing the username also move its reservation and fail with
+// ErrUsernameTaken if another user holds it.
+func UpdateProfile(ctx context.Context, update Update, client *db.Client, tableName string) (after, before map[string]any, err error) {
+	slog.InfoContext(ctx, "Updating user profile", "user_id", update.UserID, "version", update.Version, "table", tableName)
+	if _, ok := update.Fields["username"]; ok {
+		return updateWithUsername(ctx, update, client, tableName)
+	}
+
+	start := time.Now()
+	result, err := client.DynamoDB.UpdateItem(ctx, BuildUpdateInput(update, tableName, start))
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return nil, nil, users.VersionConflict(db.CurrentItem(err), update.Fields)
+	}
+	if err != nil {
+		return nil, nil, db.Wrap(err, "updating user "+update.UserID)
+	}
+
+	if err := attributevalue.UnmarshalMap(result.Attributes, &before); err != nil {
+		return nil, nil, fmt.Errorf("decoding user item: %w", err)
+	}
+	after = map[string]any{
+		"version":    update.Version + 1,
+		"updated_at": start.UTC().Format(time.RFC3339),
+	}
+	for name, value := range update.Fields {
+		after[name] = value
+	}
+	return after, before, nil
+}
+
+// updateWithUsername applies an update setting the username in one
+// transaction with the reservation of the new name and the release of the
+// old one, so two users can never end up holding the same handle.
+func updateWithUsername(ctx context.Context, update Update, client *db.Client, tableName string) (after, before map[string]any, err error) {
+	// Transactions cannot return the previous values, and the old name's
+	// reservation must be released, so the record is read first. The version
+	// condition guarantees it has not changed by the time the write applies.
+	start := time.Now()
+	current, err := client.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		Key:            users.Key(update.UserID),
+		ConsistentRead: aws.Bool(true),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return nil, nil, db.Wrap(err, "reading user "+update.UserID)
+	}
+	if current.Item == nil {
+		return nil, nil, ErrVersionConflict
+	}
+
+	username := update.Fields["username"]
+	in := BuildUpdateInput(update, tableName, time.Now())
+	owned := map[string]types.AttributeValue{":me": &types.AttributeValueMemberS{Value: update.UserID}}
+	writes := []db.Write{
+		{
+			Item: types.TransactWriteItem{Update: &types.Update{
+				TableName:                 in.TableName,
+				Key:                       in.Key,
+				UpdateExpression:          in.UpdateExpression,
+				ConditionExpression:       in.ConditionExpression,
+				ExpressionAttributeNames:  in.ExpressionAttributeNames,
+				ExpressionAttributeValues: in.ExpressionAttributeValues,
+
+				ReturnValuesOnConditionCheckFailure: in.ReturnValuesOnConditionCheckFailure,
+			}},
+			Conflict: ErrVersionConflict,
+		},
+		{
+			Item: types.TransactWriteItem{Put: &types.Put{
+				TableName:                 in.TableName,
+				Item:                      users.UsernameLock(username, update.UserID),
+				ConditionExpression:       aws.String("attribute_not_exists(user_id) OR #owner = :me"),
+				ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+				ExpressionAttributeValues: owned,
+			}},
+			Conflict: ErrUsernameTaken,
+		},
+	}
+	if old, ok := current.Item["username"].(*types.AttributeValueMemberS); ok &&
+		validation.UsernameSkeleton(old.Value) != validation.UsernameSkeleton(username) {
+		writes = append(writes, db.Write{Item: types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 in.TableName,
+			Key:                       users.UsernameLockKey(old.Value),
+			ConditionExpression:       aws.String("attribute_not_exists(user_id) OR #owner = :me"),
+			ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+			ExpressionAttributeValues: owned,
+		}}})
+	}
+
+	err = client.Transact(ctx, writes...)
+	if errors.Is(err, ErrVersionConflict) {
+		return nil, nil, users.VersionConflict(db.CurrentItem(err), update.Fields)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := in.ExpressionAttributeValues
+	after = map[string]any{
+		"version":    update.Version + 1,
+		"updated_at": values[":updated_at"].(*types.AttributeValueMemberS).Value,
+	}
+	for name, value := range update.Fields {
+		after[name] = value
+	}
+	old := make(db.Item, len(after))
+	for name := range after {
+		if v, ok := current.Item[name]; ok {
+			old[name] = v
+		}
+	}
+	if err := attributevalue.UnmarshalMap(old, &before); err != nil {
+		return nil, nil, fmt.Errorf("decoding user item: %w", err)
+	}
+	return after, before, nil
+}
+
+// authorize lets callers update their own profile only, unless they are
+// admins. Direct invocations are trusted.
+func authorize(ctx context.Context, r *httpx.Request, userID string) error {
+	if r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	if id.Subject != userID && !authz.Allowed(id, authz.UsersActAs) {
+		return apperr.Forbidden("You may only update your own profile")
+	}
+	return nil
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "updateUserProfile",
+	Function: "updateUserProfile",
+	Summary:  "Updates fields of a profile",
+	Method:   "PATCH",
+	Path:     "/users/{user_id}",
+	Request:  map[string]any{},
+	Response: map[string]any{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	DB          *db.Client
+	Users       *users.Repository // cache invalidated after each update
+	Auth        auth.TokenVerifier
+	Idempotency *idempotency.Store // replays responses for retried API requests
+	Events      *events.Publisher
+	Audit       *audit.Store
+	Config      *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &Handler{
+		DB:          client,
+		Users:       users.NewRepository(client, cfg),
+		Auth:        verifier,
+		Idempotency: idempotency.New(client, cfg),
+		Events:      events.NewPublisher(eventbridge.NewFromConfig(awsCfg), cfg),
+		Audit:       audit.NewStore(client, cfg),
+		Config:      cfg,
+	}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware. Authentication
+// runs first so idempotency keys are scoped to the verified caller.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth), h.Idempotency.Wrap)
+}
+
+// Handle applies a partial update to the profile named by the user_id path
+// parameter (or the user_id field of a direct invocation) and returns the
+// changed attributes. Callers may only update their own profile unless they
+// are admins.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	userID := r.PathParams["user_id"]
+	body := r.Body
+
+	// Direct invocations carry the user_id inside the document
+	if r.Direct {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+		_ = json.Unmarshal(doc["user_id"], &userID)
+		delete(doc, "user_id")
+		body, _ = json.Marshal(doc)
+	}
+
+	if err := validation.UserID(userID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := authorize(ctx, r, userID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	update, err := ParseUpdate(userID, body)
+	if apperr.KindOf(err) == apperr.KindInvalid {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Error(apperr.BadRequest(err.Error())), nil
+	}
+
+	profile, before, err := UpdateProfile(ctx, update, h.DB, h.Config.UserTableName)
+	if errors.Is(err, ErrVersionConflict) || errors.Is(err, ErrUsernameTaken) {
+		return httpx.Error(err), nil
+	}
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	h.Users.Invalidate(userID, "")
+	h.Audit.Log(ctx, audit.Entry{
+		Resource:  audit.UserResource(userID),
+		Action:    audit.ActionUserUpdate,
+		Actor:     audit.ActorOf(ctx, r),
+		RequestID: audit.RequestID(ctx, r),
+		Diff:      audit.Diff(before, profile),
+	})
+
+	changed := make([]string, 0, len(update.Fields))
+	for name := range update.Fields {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	h.Events.Emit(ctx, events.ProfileUpdated{
+		UserID:    userID,
+		Changed:   changed,
+		Version:   update.Version + 1,
+		UpdatedAt: profile["updated_at"].(string),
+	})
+
+	return httpx.JSON(200, profile), nil
+}
@@ -0,0 +1,307 @@
+package updateuserprofile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/users"
+)
+
+func TestParseUpdate(t *testing.T) {
+	tests := []struct {
+		body    string
+		wantErr string // substring; empty means valid
+	}{
+		{body: `{"version":3,"bio":"hi"}`},
+		{body: `{"version":0,"display_name":"Jane","avatar_url":"https://example.com/a.png"}`},
+		{body: `{"bio":"hi"}`, wantErr: "version is required"},
+		{body: `{"version":-1,"bio":"hi"}`, wantErr: "non-negative"},
+		{body: `{"version":1}`, wantErr: "no fields"},
+		{body: `{"version":1,"email":"x@example.com"}`, wantErr: `"email" cannot be updated`},
+		{body: `{"version":1,"bio":7}`, wantErr: "must be a string"},
+		{body: `{"version":1,"display_name":"` + strings.Repeat("é", 65) + `"}`, wantErr: "exceeds 64"},
+		{body: `[]`, wantErr: "JSON object"},
+		{body: `{"version":1,"username":"Jane.Doe"}`},
+		{body: `{"version":1,"username":"jane doe"}`, wantErr: "letters, digits"},
+	}
+	for _, tt := range tests {
+		_, err := ParseUpdate("u1", []byte(tt.body))
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("ParseUpdate(%s) = %v", tt.body, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseUpdate(%s) error = %v, want %q", tt.body, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBuildUpdateInput(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		update        Update
+		wantSet       string
+		wantCondition string
+		wantNext      string
+	}{
+		{
+			name:          "versioned",
+			update:        Update{UserID: "u1", Version: 2, Fields: map[string]string{"display_name": "Jane", "bio": "hi"}},
+			wantSet:       "SET #version = :next_version, #updated_at = :updated_at, #f0 = :f0, #f1 = :f1",
+			wantCondition: "attribute_exists(user_id) AND #version = :expected_version",
+			wantNext:      "3",
+		},
+		{
+			name:          "predates versioning",
+			update:        Update{UserID: "u1", Version: 0, Fields: map[string]string{"bio": "hi"}},
+			wantSet:       "SET #version = :next_version, #updated_at = :updated_at, #f0 = :f0",
+			wantCondition: "attribute_exists(user_id) AND attribute_not_exists(#version)",
+			wantNext:      "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := BuildUpdateInput(tt.update, "users", now)
+			if got := aws.ToString(in.UpdateExpression); got != tt.wantSet {
+				t.Errorf("update = %q, want %q", got, tt.wantSet)
+			}
+			if got := aws.ToString(in.ConditionExpression); got != tt.wantCondition {
+				t.Errorf("condition = %q, want %q", got, tt.wantCondition)
+			}
+			if in.ExpressionAttributeNames["#f0"] != "bio" {
+				t.Errorf("fields are not sorted: %v", in.ExpressionAttributeNames)
+			}
+			next := in.ExpressionAttributeValues[":next_version"].(*types.AttributeValueMemberN).Value
+			if next != tt.wantNext {
+				t.Errorf("next version = %s, want %s", next, tt.wantNext)
+			}
+		})
+	}
+}
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a PATCH /users/{user_id} REST API event.
+func apiEvent(userID, token, body string) json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod":     "PATCH",
+		"path":           "/users/" + userID,
+		"pathParameters": map[string]string{"user_id": userID},
+		"headers":        map[string]string{"Authorization": "Bearer " + token},
+		"body":           body,
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	updated := func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		return &dynamodb.UpdateItemOutput{Attributes: dbtest.Item("bio", "hi")}, nil
+	}
+	owner := &auth.Identity{Subject: "u1"}
+	admin := &auth.Identity{Subject: "a1", Groups: []string{"admin"}}
+	other := &auth.Identity{Subject: "u2"}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		update     func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+		wantStatus int
+		wantBody   string
+		wantCalls  int
+	}{
+		{
+			name:    "direct invocation",
+			payload: json.RawMessage(`{"user_id":"u1","version":1,"bio":"hi"}`),
+			update:  updated, wantStatus: 200, wantBody: `"bio":"hi"`, wantCalls: 1,
+		},
+		{
+			name:    "owner",
+			payload: apiEvent("u1", "valid", `{"version":1,"bio":"hi"}`), caller: owner,
+			update: updated, wantStatus: 200, wantCalls: 1,
+		},
+		{
+			name:    "admin",
+			payload: apiEvent("u1", "valid", `{"version":1,"bio":"hi"}`), caller: admin,
+			update: updated, wantStatus: 200, wantCalls: 1,
+		},
+		{
+			name:    "another user",
+			payload: apiEvent("u1", "valid", `{"version":1,"bio":"hi"}`), caller: other,
+			wantStatus: 403,
+		},
+		{
+			name:    "no token",
+			payload: apiEvent("u1", "", `{"version":1,"bio":"hi"}`), caller: owner,
+			wantStatus: 401,
+		},
+		{
+			name:    "version conflict",
+			payload: json.RawMessage(`{"user_id":"u1","version":1,"bio":"hi"}`),
+			update: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				return nil, dbtest.ConditionFailed()
+			},
+			wantStatus: 409, wantBody: `"code":"VERSION_CONFLICT"`, wantCalls: 1,
+		},
+		{
+			name:    "version conflict with the current state",
+			payload: json.RawMessage(`{"user_id":"u1","version":1,"bio":"hi","display_name":"Jane"}`),
+			update: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				if in.ReturnValuesOnConditionCheckFailure != types.ReturnValuesOnConditionCheckFailureAllOld {
+					t.Error("update does not return the item on conflicts")
+				}
+				current := dbtest.Item("user_id", "u1", "bio", "hello", "display_name", "Jane")
+				current["version"] = &types.AttributeValueMemberN{Value: "3"}
+				return nil, &types.ConditionalCheckFailedException{Item: current}
+			},
+			wantStatus: 409, wantBody: `"current":{"version":3,"changed_fields":{"bio":"hello"}}`, wantCalls: 1,
+		},
+		{
+			name:       "unknown field",
+			payload:    json.RawMessage(`{"user_id":"u1","version":1,"status":"banned"}`),
+			wantStatus: 400, wantBody: "cannot be updated",
+		},
+		{
+			name:       "invalid id",
+			payload:    json.RawMessage(`{"user_id":"USER#1","version":1,"bio":"hi"}`),
+			wantStatus: 422,
+		},
+		{
+			name:    "throttled",
+			payload: json.RawMessage(`{"user_id":"u1","version":1,"bio":"hi"}`),
+			update: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				return nil, dbtest.Throttled()
+			},
+			wantStatus: 429, wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{UpdateItemFunc: tt.update}
+			cfg := &config.Config{UserTableName: "users"}
+			h := &Handler{
+				DB:     m.Client(),
+				Users:  users.NewRepository(m.Client(), cfg),
+				Auth:   stubVerifier{id: tt.caller},
+				Config: cfg,
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+			if len(m.Calls) != tt.wantCalls {
+				t.Errorf("%d DynamoDB calls, want %d", len(m.Calls), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestUpdateProfileUsername(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   db.Item
+		tx        func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error)
+		wantItems int
+		wantErr   error
+	}{
+		{
+			name:      "first username",
+			current:   dbtest.Item("user_id", "u1", "version", "1"),
+			wantItems: 2,
+		},
+		{
+			name:      "renamed",
+			current:   dbtest.Item("user_id", "u1", "username", "jane"),
+			wantItems: 3, // the old reservation is released
+		},
+		{
+			name:      "same handle, other separators",
+			current:   dbtest.Item("user_id", "u1", "username", "jane_doe"),
+			wantItems: 2,
+		},
+		{
+			name:    "taken",
+			current: dbtest.Item("user_id", "u1"),
+			tx: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				return nil, dbtest.TransactionCanceled("None", "ConditionalCheckFailed")
+			},
+			wantItems: 2, wantErr: ErrUsernameTaken,
+		},
+		{
+			name:    "version conflict",
+			current: dbtest.Item("user_id", "u1"),
+			tx: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				return nil, dbtest.TransactionCanceled("ConditionalCheckFailed", "None")
+			},
+			wantItems: 2, wantErr: ErrVersionConflict,
+		},
+		{name: "no such user", wantErr: ErrVersionConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []types.TransactWriteItem
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.current}, nil
+				},
+				TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					items = in.TransactItems
+					if tt.tx != nil {
+						return tt.tx(in)
+					}
+					return &dynamodb.TransactWriteItemsOutput{}, nil
+				},
+			}
+			update := Update{UserID: "u1", Version: 1, Fields: map[string]string{"username": "jane.doe"}}
+
+			after, _, err := UpdateProfile(context.Background(), update, m.Client(), "users")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(items) != tt.wantItems {
+				t.Fatalf("%d transaction items, want %d", len(items), tt.wantItems)
+			}
+			if err != nil {
+				return
+			}
+			if after["username"] != "jane.doe" {
+				t.Errorf("after = %v", after)
+			}
+			lock := items[1].Put.Item["user_id"].(*types.AttributeValueMemberS).Value
+			if lock != "UNAME#janedoe" {
+				t.Errorf("reserved %s, want UNAME#janedoe", lock)
+			}
+		})
+	}
+}
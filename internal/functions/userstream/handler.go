@@ -0,0 +1,214 @@
+// Package userstream consumes the stream of the user table and keeps the
+// data derived from user records in sync: the audit log, the user counters,
+// the search index and the relationships of deleted users. New projections are registered in NewDispatcher.
+//
+// The table's stream must include new and old images, and its event source
+// mapping must enable ReportBatchItemFailures; a tumbling window (e.g. 60
+// seconds) lets the counters be written once per window instead of once per
+// batch. See package streams for the failure semantics.
+package userstream
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"                          // Lambda event payloads
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue" // item decoding
+
+	"troggle-backend/internal/audit"         // audit log
+	"troggle-backend/internal/awscfg"        // shared AWS SDK config
+	"troggle-backend/internal/config"        // environment-driven settings
+	"troggle-backend/internal/counters"      // aggregate counts
+	"troggle-backend/internal/db"            // shared DynamoDB client
+	"troggle-backend/internal/relationships" // friend and follow graph
+	"troggle-backend/internal/search"        // user search index
+	"troggle-backend/internal/streams"       // stream dispatching
+	"troggle-backend/internal/users"         // user table access
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Dispatcher *streams.Dispatcher
+	Config     *config.Config
+}
+
+// New builds the handler, its clients and its projections from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	if err := cfg.RequireSearch(); err != nil {
+		return nil, err
+	}
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	awsCfg, err := awscfg.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	d := NewDispatcher(audit.NewStore(client, cfg), counters.NewStore(client, cfg), search.New(client, awsCfg, cfg),
+		relationships.NewStore(client, cfg))
+	return &Handler{Dispatcher: d, Config: cfg}, nil
+}
+
+// NewDispatcher returns the dispatcher with every projection of user
+// changes registered. A nil index leaves search out, a nil graph the
+// removal of deleted users' relationships.
+func NewDispatcher(log *audit.Store, counts *counters.Store, index search.Index, graph *relationships.Store) *streams.Dispatcher {
+	d := &streams.Dispatcher{Skip: lock}
+	d.Register(auditLog{log})
+	if index != nil {
+		d.Register(searchIndex{index})
+	}
+	if graph != nil {
+		d.Register(relationshipCleanup{graph})
+	}
+	d.RegisterAggregator(userCounts{counts})
+	return d
+}
+
+// Handle dispatches the records of one batch.
+func (h *Handler) Handle(ctx context.Context, event events.DynamoDBTimeWindowEvent) (events.DynamoDBTimeWindowEventResponse, error) {
+	return h.Dispatcher.Handle(ctx, event)
+}
+
+// lock reports whether c concerns an item reserving an email or a username
+// rather than a user.
+func lock(c *streams.Change) bool {
+	return users.IsLock(streams.Attr(c.Keys, "user_id"))
+}
+
+// auditLog records every change of a user in the audit log, whether or not
+// it was made through the API.
+type auditLog struct {
+	store *audit.Store
+}
+
+func (auditLog) Name() string { return "audit" }
+
+func (a auditLog) Apply(ctx context.Context, c *streams.Change) error {
+	if c.Action == streams.Modify && c.Old != nil && onlyCounters(c.Changed()) {
+		// Follows and friendships are not changes to the user
+		return nil
+	}
+	var before, after map[string]any
+	if err := attributevalue.UnmarshalMap(c.Old, &before); err != nil {
+		return fmt.Errorf("decoding old image: %w", err)
+	}
+	if err := attributevalue.UnmarshalMap(c.New, &after); err != nil {
+		return fmt.Errorf("decoding new image: %w", err)
+	}
+	e := audit.Entry{
+		Resource: audit.UserResource(streams.Attr(c.Keys, "user_id")),
+		EntryID:  audit.EntryID(c.At, c.EventID),
+		Source:   audit.SourceStream,
+		Diff:     audit.Diff(before, after),
+		At:       c.At.UTC().Format(time.RFC3339),
+	}
+	switch c.Action {
+	case streams.Insert:
+		e.Action = audit.ActionUserCreate
+	case streams.Modify:
+		e.Action = audit.ActionUserUpdate
+	case streams.Remove:
+		e.Action = audit.ActionUserDelete
+		if c.Expired {
+			e.Actor = audit.Actor{ActorType: audit.ActorSystem, ActorID: "dynamodb-ttl"}
+		}
+	}
+	return a.store.Record(ctx, e)
+}
+
+// searchIndex keeps the search index in sync with the searchable
+// attributes of users. Writes replace whole documents, so applying a change
+// twice is harmless.
+type searchIndex struct {
+	index search.Index
+}
+
+func (searchIndex) Name() string { return "search" }
+
+func (s searchIndex) Apply(ctx context.Context, c *streams.Change) error {
+	userID := streams.Attr(c.Keys, "user_id")
+	if c.Action == streams.Remove {
+		return s.index.Delete(ctx, userID)
+	}
+	if c.Action == streams.Modify && c.Old != nil && !slices.ContainsFunc(c.Changed(), isIndexed) {
+		return nil
+	}
+	doc, ok := search.DocumentOf(c.New)
+	if !ok {
+		return s.index.Delete(ctx, userID)
+	}
+	return s.index.Put(ctx, doc)
+}
+
+func isIndexed(attr string) bool {
+	return slices.Contains(search.Indexed, attr)
+}
+
+// onlyCounters reports whether attrs are all relationship counters.
+func onlyCounters(attrs []string) bool {
+	counters := slices.Collect(maps.Values(relationships.Counters))
+	for _, attr := range attrs {
+		if !slices.Contains(counters, attr) {
+			return false
+		}
+	}
+	return len(attrs) > 0
+}
+
+// relationshipCleanup removes the friends, follows, friend requests, blocks
+// and mutes of deleted users, from both ends where there are two, so nobody
+// keeps counting them.
+type relationshipCleanup struct {
+	graph *relationships.Store
+}
+
+func (relationshipCleanup) Name() string { return "relationships" }
+
+func (r relationshipCleanup) Apply(ctx context.Context, c *streams.Change) error {
+	if c.Action != streams.Remove {
+		return nil
+	}
+	return r.graph.RemoveAll(ctx, streams.Attr(c.Keys, "user_id"))
+}
+
+// userCounts maintains the number of users, in total and by status.
+type userCounts struct {
+	store *counters.Store
+}
+
+func (userCounts) Name() string { return "user_counts" }
+
+func (userCounts) Aggregate(c *streams.Change, totals map[string]int64) {
+	oldStatus, newStatus := streams.Attr(c.Old, "status"), streams.Attr(c.New, "status")
+	switch c.Action {
+	case streams.Insert:
+		totals[counters.Users]++
+		if newStatus != "" {
+			totals[counters.UsersWithStatus(newStatus)]++
+		}
+	case streams.Remove:
+		totals[counters.Users]--
+		if oldStatus != "" {
+			totals[counters.UsersWithStatus(oldStatus)]--
+		}
+	case streams.Modify:
+		// Without an old image a status change cannot be told apart
+		if c.Old != nil && oldStatus != newStatus {
+			if oldStatus != "" {
+				totals[counters.UsersWithStatus(oldStatus)]--
+			}
+			if newStatus != "" {
+				totals[counters.UsersWithStatus(newStatus)]++
+			}
+		}
+	}
+}
+
+func (u userCounts) Flush(ctx context.Context, totals map[string]int64) error {
+	return u.store.Add(ctx, totals)
+}
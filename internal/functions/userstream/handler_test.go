@@ -0,0 +1,176 @@
+package userstream
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/audit"
+	"troggle-backend/internal/counters"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/search"
+	"troggle-backend/internal/streams"
+)
+
+// change is a stream record of user id; empty statuses mean no image.
+func change(seq, action, id, oldStatus, newStatus string) events.DynamoDBEventRecord {
+	image := func(status string) map[string]events.DynamoDBAttributeValue {
+		if status == "" {
+			return nil
+		}
+		return map[string]events.DynamoDBAttributeValue{
+			"user_id":      events.NewStringAttribute(id),
+			"status":       events.NewStringAttribute(status),
+			"display_name": events.NewStringAttribute("Jane"),
+		}
+	}
+	return events.DynamoDBEventRecord{
+		EventID:   "ev" + seq,
+		EventName: action,
+		Change: events.DynamoDBStreamRecord{
+			SequenceNumber:              seq,
+			ApproximateCreationDateTime: events.SecondsEpochTime{Time: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)},
+			Keys:                        map[string]events.DynamoDBAttributeValue{"user_id": events.NewStringAttribute(id)},
+			OldImage:                    image(oldStatus),
+			NewImage:                    image(newStatus),
+		},
+	}
+}
+
+func TestHandle(t *testing.T) {
+	m := &dbtest.Mock{}
+	h := &Handler{Dispatcher: NewDispatcher(
+		&audit.Store{DB: m.Client(), Table: "audit"},
+		&counters.Store{DB: m.Client(), Table: "counters"},
+		nil, nil,
+	)}
+
+	event := events.DynamoDBTimeWindowEvent{DynamoDBEvent: events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		change("1", "INSERT", "u1", "", "active"),
+		change("2", "INSERT", "EMAIL#jane@example.com", "", "active"),
+		change("3", "MODIFY", "u1", "active", "suspended"),
+		change("4", "REMOVE", "u2", "active", ""),
+	}}}
+	resp, err := h.Handle(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.BatchItemFailures) != 0 {
+		t.Fatalf("failures = %v", resp.BatchItemFailures)
+	}
+
+	var actions []string
+	deltas := map[string]string{}
+	for _, c := range m.Calls {
+		switch in := c.Input.(type) {
+		case *dynamodb.PutItemInput:
+			actions = append(actions, in.Item["action"].(*types.AttributeValueMemberS).Value)
+			if got := in.Item["resource"].(*types.AttributeValueMemberS).Value; got != "user/u1" && got != "user/u2" {
+				t.Errorf("resource = %q", got)
+			}
+			if got := in.Item["entry_id"].(*types.AttributeValueMemberS).Value; got[:20] != "2026-05-01T12:00:00Z" {
+				t.Errorf("entry_id = %q", got)
+			}
+		case *dynamodb.UpdateItemInput:
+			name := in.Key["counter"].(*types.AttributeValueMemberS).Value
+			deltas[name] = in.ExpressionAttributeValues[":delta"].(*types.AttributeValueMemberN).Value
+		}
+	}
+
+	if want := []string{audit.ActionUserCreate, audit.ActionUserUpdate, audit.ActionUserDelete}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("audit actions = %v, want %v", actions, want)
+	}
+	// u1 created active, then suspended; u2 (active) removed. The total is
+	// unchanged, so it is not written
+	want := map[string]string{
+		counters.UsersWithStatus("active"):    "-1",
+		counters.UsersWithStatus("suspended"): "1",
+	}
+	if !reflect.DeepEqual(deltas, want) {
+		t.Errorf("counter deltas = %v, want %v", deltas, want)
+	}
+}
+
+// fakeIndex records the writes of the search projection.
+type fakeIndex struct {
+	ops []string
+}
+
+func (f *fakeIndex) Put(_ context.Context, doc search.Document) error {
+	f.ops = append(f.ops, "put "+doc.UserID)
+	return nil
+}
+
+func (f *fakeIndex) Delete(_ context.Context, userID string) error {
+	f.ops = append(f.ops, "delete "+userID)
+	return nil
+}
+
+func (f *fakeIndex) Search(context.Context, search.Query) ([]search.Hit, bool, error) {
+	return nil, false, nil
+}
+
+func TestSearchIndex(t *testing.T) {
+	jane := dbtest.Item("user_id", "u1", "display_name", "Jane", "status", "active")
+	renamed := dbtest.Item("user_id", "u1", "display_name", "Janet", "status", "active")
+	touched := dbtest.Item("user_id", "u1", "display_name", "Jane", "status", "active", "last_login_at", "2026-05-01T12:00:00Z")
+	suspended := dbtest.Item("user_id", "u1", "display_name", "Jane", "status", "suspended")
+	keys := dbtest.Item("user_id", "u1")
+
+	tests := []struct {
+		name   string
+		change streams.Change
+		want   []string
+	}{
+		{name: "created", change: streams.Change{Action: streams.Insert, Keys: keys, New: jane}, want: []string{"put u1"}},
+		{name: "renamed", change: streams.Change{Action: streams.Modify, Keys: keys, Old: jane, New: renamed}, want: []string{"put u1"}},
+		{name: "unindexed attribute changed", change: streams.Change{Action: streams.Modify, Keys: keys, Old: jane, New: touched}},
+		{name: "suspended", change: streams.Change{Action: streams.Modify, Keys: keys, Old: jane, New: suspended}, want: []string{"delete u1"}},
+		{name: "deleted", change: streams.Change{Action: streams.Remove, Keys: keys, Old: jane}, want: []string{"delete u1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := &fakeIndex{}
+			if err := (searchIndex{index}).Apply(context.Background(), &tt.change); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(index.ops, tt.want) {
+				t.Errorf("ops = %v, want %v", index.ops, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditSkipsRelationshipCounters(t *testing.T) {
+	jane := dbtest.Item("user_id", "u1", "display_name", "Jane")
+	followed := dbtest.Item("user_id", "u1", "display_name", "Jane")
+	followed["follower_count"] = &types.AttributeValueMemberN{Value: "1"}
+	keys := dbtest.Item("user_id", "u1")
+
+	tests := []struct {
+		name      string
+		change    streams.Change
+		wantEntry bool
+	}{
+		{name: "counter only", change: streams.Change{Action: streams.Modify, Keys: keys, Old: jane, New: followed}},
+		{name: "unfollowed", change: streams.Change{Action: streams.Modify, Keys: keys, Old: followed, New: jane}},
+		{name: "renamed", change: streams.Change{Action: streams.Modify, Keys: keys, Old: jane, New: dbtest.Item("user_id", "u1", "display_name", "Janet")}, wantEntry: true},
+		{name: "created", change: streams.Change{Action: streams.Insert, Keys: keys, New: followed}, wantEntry: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{}
+			if err := (auditLog{&audit.Store{DB: m.Client(), Table: "audit"}}).Apply(context.Background(), &tt.change); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(m.Calls) > 0; got != tt.wantEntry {
+				t.Errorf("recorded = %v, want %v", got, tt.wantEntry)
+			}
+		})
+	}
+}
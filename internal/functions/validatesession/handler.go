@@ -0,0 +1,106 @@
+// Package validatesession tells whether a session is still active, for
+// clients checking their own sign-in and for backend services holding a
+// user_id and session_id.
+package validatesession
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"troggle-backend/internal/api"        // API route declarations
+	"troggle-backend/internal/apperr"     // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/auth"       // Cognito JWT verification
+	"troggle-backend/internal/config"     // environment-driven settings
+	"troggle-backend/internal/db"         // shared DynamoDB client
+	"troggle-backend/internal/httpx"      // API Gateway / direct invocation adapter
+	"troggle-backend/internal/sessions"   // session table access
+	"troggle-backend/internal/validation" // input normalization and validation
+)
+
+// statusUnknown is reported for sessions that were never recorded (or have
+// been deleted by TTL).
+const statusUnknown = "unknown"
+
+// Request represents the JSON input of a direct invocation. API Gateway
+// callers validate the session of their own token.
+type Request struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+}
+
+// Response is the JSON output.
+type Response struct {
+	Valid   bool              `json:"valid"`
+	Status  string            `json:"status"` // a sessions.Status* value or "unknown"
+	Session *sessions.Session `json:"session,omitempty"`
+}
+
+// Routes are the API routes the function serves.
+var Routes = []api.Route{{
+	Name:     "validateSession",
+	Function: "validateSession",
+	Summary:  "Reports whether the caller's session is still valid",
+	Method:   "GET",
+	Path:     "/sessions/current",
+	Response: Response{},
+}}
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Sessions *sessions.Store
+	Auth     auth.TokenVerifier // does not check revocation, so revoked sessions get an answer
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := auth.NewVerifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{Sessions: sessions.NewStore(client, cfg), Auth: verifier, Config: cfg}, nil
+}
+
+// HTTP returns Handle wrapped in the endpoint's middleware.
+func (h *Handler) HTTP() httpx.Handler {
+	return httpx.Chain(h.Handle, auth.Middleware(h.Auth))
+}
+
+// Handle looks up the session and reports its status. Only active sessions
+// are valid.
+func (h *Handler) Handle(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+	var req Request
+	if r.Direct {
+		if err := r.Decode(&req); err != nil {
+			return httpx.Error(apperr.BadRequest("Invalid request")), nil
+		}
+	} else {
+		id, ok := auth.FromContext(ctx)
+		if !ok {
+			return httpx.Error(apperr.Unauthorized(auth.ErrNoToken)), nil
+		}
+		req = Request{UserID: id.Subject, SessionID: id.SessionID}
+	}
+
+	if err := validation.UserID(req.UserID); err != nil {
+		return httpx.Error(err), nil
+	}
+	if err := validation.SessionID(req.SessionID); err != nil {
+		return httpx.Error(err), nil
+	}
+
+	sess, err := h.Sessions.Get(ctx, req.UserID, req.SessionID)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	if sess == nil {
+		return httpx.JSON(200, Response{Status: statusUnknown}), nil
+	}
+	status := sess.Status(time.Now())
+	return httpx.JSON(200, Response{Valid: status == sessions.StatusActive, Status: status, Session: sess}), nil
+}
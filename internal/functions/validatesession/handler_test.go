@@ -0,0 +1,132 @@
+package validatesession
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/sessions"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" || v.id == nil {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+// apiEvent is a REST API event of the current session route.
+func apiEvent() json.RawMessage {
+	event, _ := json.Marshal(map[string]any{
+		"httpMethod": "GET",
+		"path":       "/sessions/current",
+		"headers":    map[string]string{"Authorization": "Bearer valid"},
+	})
+	return event
+}
+
+func TestHandle(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	revokedAt := now.Add(-time.Minute)
+	active := &sessions.Session{IssuedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}
+	revoked := &sessions.Session{IssuedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour), RevokedAt: &revokedAt}
+	expired := &sessions.Session{IssuedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	caller := &auth.Identity{Subject: "u1", SessionID: "s1"}
+
+	tests := []struct {
+		name       string
+		payload    json.RawMessage
+		caller     *auth.Identity
+		stored     *sessions.Session // the session looked up; nil for none
+		wantStatus int
+		wantKey    [2]string // user_id and session_id looked up
+		want       Response
+	}{
+		{
+			name: "active", payload: apiEvent(), caller: caller, stored: active,
+			wantStatus: 200, wantKey: [2]string{"u1", "s1"}, want: Response{Valid: true, Status: sessions.StatusActive},
+		},
+		{
+			name: "revoked", payload: apiEvent(), caller: caller, stored: revoked,
+			wantStatus: 200, wantKey: [2]string{"u1", "s1"}, want: Response{Status: sessions.StatusRevoked},
+		},
+		{
+			name: "expired", payload: apiEvent(), caller: caller, stored: expired,
+			wantStatus: 200, wantKey: [2]string{"u1", "s1"}, want: Response{Status: sessions.StatusExpired},
+		},
+		{
+			name: "unknown", payload: apiEvent(), caller: caller,
+			wantStatus: 200, wantKey: [2]string{"u1", "s1"}, want: Response{Status: statusUnknown},
+		},
+		{name: "token without session", payload: apiEvent(), caller: &auth.Identity{Subject: "u1"}, wantStatus: 422},
+		{name: "no token", payload: apiEvent(), wantStatus: 401},
+		{
+			name: "direct", payload: json.RawMessage(`{"user_id":"u2","session_id":"s9"}`), stored: active,
+			wantStatus: 200, wantKey: [2]string{"u2", "s9"}, want: Response{Valid: true, Status: sessions.StatusActive},
+		},
+		{name: "direct without user_id", payload: json.RawMessage(`{"session_id":"s9"}`), wantStatus: 422},
+		{name: "direct with invalid session_id", payload: json.RawMessage(`{"user_id":"u2","session_id":"s 9"}`), wantStatus: 422},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var looked [2]string
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					looked = [2]string{
+						in.Key["user_id"].(*types.AttributeValueMemberS).Value,
+						in.Key["session_id"].(*types.AttributeValueMemberS).Value,
+					}
+					if tt.stored == nil {
+						return &dynamodb.GetItemOutput{}, nil
+					}
+					s := *tt.stored
+					s.UserID, s.SessionID = looked[0], looked[1]
+					item, err := db.Encode(s)
+					return &dynamodb.GetItemOutput{Item: item}, err
+				},
+			}
+			h := &Handler{
+				Sessions: &sessions.Store{DB: m.Client(), Table: "sessions"},
+				Auth:     stubVerifier{tt.caller},
+				Config:   &config.Config{},
+			}
+
+			resp, err := httpx.Adapt(h.HTTP())(context.Background(), tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.wantStatus, resp.Body)
+			}
+			if looked != tt.wantKey {
+				t.Errorf("looked up %v, want %v", looked, tt.wantKey)
+			}
+			if resp.StatusCode != 200 {
+				return
+			}
+
+			var got Response
+			if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Valid != tt.want.Valid || got.Status != tt.want.Status {
+				t.Errorf("response = %s, want valid %v and status %q", resp.Body, tt.want.Valid, tt.want.Status)
+			}
+			if (got.Session != nil) != (tt.stored != nil) {
+				t.Errorf("session = %+v, want it only for recorded sessions", got.Session)
+			}
+		})
+	}
+}
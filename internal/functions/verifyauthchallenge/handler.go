@@ -0,0 +1,65 @@
+// Package verifyauthchallenge is the Cognito VerifyAuthChallengeResponse
+// trigger of the passwordless email login. It checks the code the user
+// answered against the one createAuthChallenge sent, using up one of the
+// code's attempts; DefineAuthChallenge then issues tokens or asks again.
+package verifyauthchallenge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events" // Cognito trigger event definitions
+
+	"troggle-backend/internal/config"  // environment-driven settings
+	"troggle-backend/internal/db"      // DynamoDB client
+	"troggle-backend/internal/logging" // structured JSON logging
+	"troggle-backend/internal/metrics" // CloudWatch EMF metrics
+	"troggle-backend/internal/otp"     // one-time sign-in codes
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Codes  *otp.Store
+	Config *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	return &Handler{Codes: otp.NewStore(client, cfg), Config: cfg}, nil
+}
+
+// Handle sets whether the answer of event is the user's code. Unknown
+// users, who were sent no code, never answer right.
+func (h *Handler) Handle(ctx context.Context, event events.CognitoEventUserPoolsVerifyAuthChallenge) (events.CognitoEventUserPoolsVerifyAuthChallenge, error) {
+	ctx, recorder := metrics.NewContext(ctx)
+	defer recorder.Flush()
+
+	userID := event.Request.UserAttributes["sub"]
+	answer, _ := event.Request.ChallengeAnswer.(string)
+	answer = strings.TrimSpace(answer)
+	event.Response.AnswerCorrect = false
+	if userID == "" || len(answer) != otp.Digits {
+		metrics.Count(ctx, metrics.LoginCodeFailed)
+		return event, nil
+	}
+	ctx = logging.With(ctx, "user_id", userID)
+
+	ok, err := h.Codes.Verify(ctx, userID, answer)
+	if errors.Is(err, otp.ErrNoCode) {
+		slog.InfoContext(ctx, "Sign-in code expired or used up")
+	} else if err != nil {
+		return event, err
+	}
+	if !ok {
+		metrics.Count(ctx, metrics.LoginCodeFailed)
+	}
+	event.Response.AnswerCorrect = ok
+	return event, nil
+}
@@ -0,0 +1,74 @@
+package verifyauthchallenge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/otp"
+)
+
+func answer(userID string, code any) events.CognitoEventUserPoolsVerifyAuthChallenge {
+	var e events.CognitoEventUserPoolsVerifyAuthChallenge
+	e.Request.UserAttributes = map[string]string{"sub": userID}
+	e.Request.ChallengeAnswer = code
+	return e
+}
+
+func TestHandle(t *testing.T) {
+	ctx := context.Background()
+	m := &dbtest.Mock{}
+	codes := &otp.Store{DB: m.Client(), Table: "challenges"}
+	code, _, err := codes.Issue(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := m.Calls[0].Input.(*dynamodb.PutItemInput).Item
+	m.UpdateItemFunc = func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		return &dynamodb.UpdateItemOutput{Attributes: item}, nil
+	}
+	h := &Handler{Codes: codes}
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	tests := []struct {
+		name  string
+		event events.CognitoEventUserPoolsVerifyAuthChallenge
+		want  bool
+	}{
+		{name: "correct", event: answer("u1", code), want: true},
+		{name: "padded", event: answer("u1", " "+code+"\n"), want: true},
+		{name: "wrong", event: answer("u1", wrong)},
+		{name: "short", event: answer("u1", code[:3])},
+		{name: "not a string", event: answer("u1", 123456)},
+		{name: "unknown user", event: answer("", code)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.Handle(ctx, tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Response.AnswerCorrect != tt.want {
+				t.Errorf("AnswerCorrect = %v, want %v", got.Response.AnswerCorrect, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleExpired(t *testing.T) {
+	m := &dbtest.Mock{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		return nil, dbtest.ConditionFailed()
+	}}
+	h := &Handler{Codes: &otp.Store{DB: m.Client(), Table: "challenges"}}
+
+	got, err := h.Handle(context.Background(), answer("u1", "123456"))
+	if err != nil || got.Response.AnswerCorrect {
+		t.Errorf("Handle = %+v, %v, want a wrong answer", got.Response, err)
+	}
+}
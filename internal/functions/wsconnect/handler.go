@@ -0,0 +1,85 @@
+// Package wsconnect handles the $connect route of the WebSocket API: it
+// verifies the caller's Cognito token and records the connection, making
+// the user online. Browsers cannot set headers on a WebSocket handshake, so
+// the token may come in the token query parameter instead of the
+// Authorization header. See package presence.
+package wsconnect
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events" // API Gateway WebSocket event definitions
+
+	"troggle-backend/internal/auth"     // Cognito JWT verification
+	"troggle-backend/internal/config"   // environment-driven settings
+	"troggle-backend/internal/db"       // shared DynamoDB client
+	"troggle-backend/internal/logging"  // structured JSON logging
+	"troggle-backend/internal/metrics"  // CloudWatch EMF metrics
+	"troggle-backend/internal/presence" // WebSocket connections
+	"troggle-backend/internal/sessions" // session table access
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Presence *presence.Store
+	Auth     auth.TokenVerifier
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	verifier, err := sessions.NewVerifier(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Presence: presence.NewStore(client, cfg),
+		Auth:     verifier,
+		Config:   cfg,
+	}, nil
+}
+
+// Handle accepts the connection of a signed-in user. Any status but 200
+// makes API Gateway refuse the handshake.
+func (h *Handler) Handle(ctx context.Context, req events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, rec := metrics.NewContext(ctx)
+	defer rec.Flush()
+
+	connID := req.RequestContext.ConnectionID
+	token := token(req)
+	if token == "" {
+		return events.APIGatewayProxyResponse{StatusCode: 401}, nil
+	}
+	id, err := h.Auth.Verify(ctx, token)
+	if err != nil {
+		slog.WarnContext(ctx, "Rejected unauthenticated connection", "connection_id", connID, logging.Err(err))
+		return events.APIGatewayProxyResponse{StatusCode: 401}, nil
+	}
+
+	if _, err := h.Presence.Connect(ctx, connID, id.Subject); err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	slog.InfoContext(ctx, "Connection opened", "connection_id", connID, "user_id", id.Subject)
+	return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+}
+
+// token returns the bearer token of the Authorization header, or else the
+// token query parameter.
+func token(req events.APIGatewayWebsocketProxyRequest) string {
+	for name, v := range req.Headers {
+		if !strings.EqualFold(name, "authorization") {
+			continue
+		}
+		if scheme, t, ok := strings.Cut(v, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(t)
+		}
+	}
+	return strings.TrimSpace(req.QueryStringParameters["token"])
+}
@@ -0,0 +1,70 @@
+package wsconnect
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/presence"
+)
+
+// stubVerifier accepts the token "valid" as the given identity.
+type stubVerifier struct{ id *auth.Identity }
+
+func (v stubVerifier) Verify(_ context.Context, token string) (*auth.Identity, error) {
+	if token != "valid" {
+		return nil, auth.ErrNoToken
+	}
+	return v.id, nil
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		query      map[string]string
+		wantStatus int
+	}{
+		{name: "query token", query: map[string]string{"token": "valid"}, wantStatus: 200},
+		{name: "header token", headers: map[string]string{"Authorization": "Bearer valid"}, wantStatus: 200},
+		{name: "header wins", headers: map[string]string{"authorization": "Bearer valid"}, query: map[string]string{"token": "forged"}, wantStatus: 200},
+		{name: "invalid token", query: map[string]string{"token": "forged"}, wantStatus: 401},
+		{name: "no token", wantStatus: 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{}
+			h := &Handler{
+				Presence: &presence.Store{DB: m.Client(), Table: "connections", TTL: time.Minute},
+				Auth:     stubVerifier{&auth.Identity{Subject: "u1"}},
+			}
+			req := events.APIGatewayWebsocketProxyRequest{Headers: tt.headers, QueryStringParameters: tt.query}
+			req.RequestContext.ConnectionID = "c1"
+
+			resp, err := h.Handle(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != 200 {
+				if len(m.Calls) != 0 {
+					t.Errorf("rejected connection was stored: %v", m.Ops())
+				}
+				return
+			}
+			in := m.Calls[0].Input.(*dynamodb.PutItemInput)
+			if in.Item["connection_id"].(*types.AttributeValueMemberS).Value != "c1" ||
+				in.Item["user_id"].(*types.AttributeValueMemberS).Value != "u1" {
+				t.Errorf("stored %v", in.Item)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+// Package wsdisconnect handles the $disconnect route of the WebSocket API:
+// it removes the connection, so the user is offline once their last one
+// goes. API Gateway does not deliver every disconnect; connections it
+// misses expire. See package presence.
+package wsdisconnect
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-lambda-go/events" // API Gateway WebSocket event definitions
+
+	"troggle-backend/internal/config"   // environment-driven settings
+	"troggle-backend/internal/db"       // shared DynamoDB client
+	"troggle-backend/internal/metrics"  // CloudWatch EMF metrics
+	"troggle-backend/internal/presence" // WebSocket connections
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Presence *presence.Store
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	return &Handler{
+		Presence: presence.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// Handle removes the closed connection.
+func (h *Handler) Handle(ctx context.Context, req events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, rec := metrics.NewContext(ctx)
+	defer rec.Flush()
+
+	connID := req.RequestContext.ConnectionID
+	if err := h.Presence.Disconnect(ctx, connID); err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	slog.InfoContext(ctx, "Connection closed", "connection_id", connID)
+	return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+}
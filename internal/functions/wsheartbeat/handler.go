@@ -0,0 +1,68 @@
+// Package wsheartbeat handles the heartbeat route of the WebSocket API,
+// which clients send ({"action": "heartbeat"}) every few minutes to stay
+// online. The route is two-way: the reply is {"type": "pong"}, or
+// {"type": "reconnect"} when the connection already expired, in which case
+// the client must open a new one to be online again. See package presence.
+package wsheartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-lambda-go/events" // API Gateway WebSocket event definitions
+
+	"troggle-backend/internal/apperr"   // typed errors mapped to HTTP statuses
+	"troggle-backend/internal/config"   // environment-driven settings
+	"troggle-backend/internal/db"       // shared DynamoDB client
+	"troggle-backend/internal/metrics"  // CloudWatch EMF metrics
+	"troggle-backend/internal/presence" // WebSocket connections
+)
+
+// Reply types.
+const (
+	Pong      = "pong"
+	Reconnect = "reconnect"
+)
+
+// Handler holds the dependencies shared across invocations of this Lambda.
+type Handler struct {
+	Presence *presence.Store
+	Config   *config.Config
+}
+
+// New builds the handler and its clients from cfg.
+func New(ctx context.Context, cfg *config.Config) (*Handler, error) {
+	client, err := db.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating DynamoDB client: %w", err)
+	}
+	return &Handler{
+		Presence: presence.NewStore(client, cfg),
+		Config:   cfg,
+	}, nil
+}
+
+// Handle keeps the connection alive for another CONNECTION_TTL.
+func (h *Handler) Handle(ctx context.Context, req events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	ctx, rec := metrics.NewContext(ctx)
+	defer rec.Flush()
+
+	connID := req.RequestContext.ConnectionID
+	reply := Pong
+	err := h.Presence.Heartbeat(ctx, connID)
+	switch {
+	case apperr.KindOf(err) == apperr.KindNotFound:
+		slog.InfoContext(ctx, "Heartbeat of expired connection", "connection_id", connID)
+		reply = Reconnect
+	case err != nil:
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	body, err := json.Marshal(presence.Message{Type: reply})
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: string(body)}, nil
+}
@@ -0,0 +1,46 @@
+package wsheartbeat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/presence"
+)
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantBody string
+		wantErr  bool
+	}{
+		{name: "alive", wantBody: `{"type":"pong"}`},
+		{name: "expired", err: dbtest.ConditionFailed(), wantBody: `{"type":"reconnect"}`},
+		{name: "throttled", err: dbtest.Throttled(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					return &dynamodb.UpdateItemOutput{}, tt.err
+				},
+			}
+			h := &Handler{Presence: &presence.Store{DB: m.Client(), Table: "connections", TTL: time.Minute}}
+			var req events.APIGatewayWebsocketProxyRequest
+			req.RequestContext.ConnectionID = "c1"
+
+			resp, err := h.Handle(context.Background(), req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (resp.StatusCode != 200 || resp.Body != tt.wantBody) {
+				t.Errorf("reply = %d %s, want 200 %s", resp.StatusCode, resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package httpx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	"troggle-backend/internal/logging"
+)
+
+// minCompressSize is the smallest body worth compressing; below it the
+// encoding overhead outweighs the savings.
+const minCompressSize = 1024
+
+// brotliLevel trades compression for the CPU time Lambda bills.
+const brotliLevel = 5
+
+// Content codings, in order of preference at equal quality.
+const (
+	codingBrotli = "br"
+	codingGzip   = "gzip"
+)
+
+// compress encodes response bodies with the best coding the client accepts.
+// API Gateway passes them on base64-encoded, which REST APIs only decode
+// with binary media types enabled for every content type.
+func compress(next Handler) Handler {
+	return func(ctx context.Context, r *Request) (Response, error) {
+		resp, err := next(ctx, r)
+		if err != nil || r.Direct || resp.IsBase64Encoded || len(resp.Body) < minCompressSize || resp.Headers["Content-Encoding"] != "" {
+			return resp, err
+		}
+		if resp.Headers == nil {
+			resp.Headers = map[string]string{}
+		}
+		AddVary(resp.Headers, "Accept-Encoding")
+
+		coding := negotiate(r.Header("Accept-Encoding"))
+		if coding == "" {
+			return resp, nil
+		}
+		body, cerr := encode(coding, []byte(resp.Body))
+		if cerr != nil {
+			slog.WarnContext(ctx, "Error compressing response", "coding", coding, logging.Err(cerr))
+			return resp, nil
+		}
+		resp.Body = base64.StdEncoding.EncodeToString(body)
+		resp.IsBase64Encoded = true
+		resp.Headers["Content-Encoding"] = coding
+		return resp, nil
+	}
+}
+
+// negotiate returns the coding of an Accept-Encoding header to use: the
+// supported one of the highest quality, or "" for none.
+func negotiate(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if (coding != codingBrotli && coding != codingGzip) || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && coding == codingBrotli) {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// encode compresses body with coding.
+func encode(coding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if coding == codingBrotli {
+		w = brotli.NewWriterLevel(&buf, brotliLevel)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
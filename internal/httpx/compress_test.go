@@ -0,0 +1,88 @@
+package httpx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"identity":                  "",
+		"gzip, deflate":             "gzip",
+		"gzip, deflate, br":         "br",
+		"br;q=0.5, gzip":            "gzip",
+		"br;q=0, gzip;q=0.1":        "gzip",
+		"GZIP;q=0.8, BR;q=0.8":      "br",
+		"deflate, br;q=0, gzip;q=0": "",
+	}
+	for header, want := range tests {
+		if got := negotiate(header); got != want {
+			t.Errorf("negotiate(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := `"` + strings.Repeat("troggle ", 500) + `"`
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	tests := []struct {
+		name           string
+		body           string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{name: "gzip", body: large, acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "brotli", body: large, acceptEncoding: "gzip, br", wantEncoding: "br"},
+		{name: "not accepted", body: large},
+		{name: "small", body: `"small"`, acceptEncoding: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := compress(func(context.Context, *Request) (Response, error) {
+				return respond(200, "application/json", tt.body), nil
+			})
+			resp, err := h(context.Background(), &Request{Headers: map[string]string{"accept-encoding": tt.acceptEncoding}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Headers["Content-Encoding"]; got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if tt.wantEncoding == "" {
+				if resp.Body != tt.body || resp.IsBase64Encoded {
+					t.Errorf("body changed without compression")
+				}
+				return
+			}
+			if resp.Headers["Vary"] != "Accept-Encoding" || !resp.IsBase64Encoded {
+				t.Errorf("headers = %v, base64 = %v", resp.Headers, resp.IsBase64Encoded)
+			}
+			raw, err := base64.StdEncoding.DecodeString(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := decoders[tt.wantEncoding](bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("decoded body differs from the original")
+			}
+		})
+	}
+}
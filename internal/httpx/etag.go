@@ -0,0 +1,65 @@
+package httpx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ETag returns the weak entity tag of a representation derived from parts,
+// e.g. the digest of the item it is built from. The API version of r is
+// part of it, as versions shape bodies differently. Tags are weak because
+// compression changes the bytes but not the content.
+func ETag(r *Request, parts ...string) string {
+	h := sha256.New()
+	h.Write([]byte(r.Version.String()))
+	for _, p := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(p))
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// Conditional answers r with the representation tagged etag. A client
+// holding it, per If-None-Match, gets a 304 Not Modified and build is not
+// called; everyone else gets its response. Both carry the tag and
+// cacheControl, when not empty. Direct invocations always get build's
+// response.
+func Conditional(r *Request, etag, cacheControl string, build func() (Response, error)) (Response, error) {
+	var resp Response
+	if !r.Direct && matches(r.Header("If-None-Match"), etag) {
+		resp = respond(304, "", "")
+	} else {
+		var err error
+		if resp, err = build(); err != nil {
+			return resp, err
+		}
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 304 {
+		return resp, nil
+	}
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	resp.Headers["ETag"] = etag
+	if cacheControl != "" {
+		resp.Headers["Cache-Control"] = cacheControl
+	}
+	return resp, nil
+}
+
+// matches reports whether the If-None-Match header lists etag. The
+// comparison is weak, as RFC 9110 requires for If-None-Match.
+func matches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
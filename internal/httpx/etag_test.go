@@ -0,0 +1,34 @@
+package httpx
+
+import "testing"
+
+func TestMatches(t *testing.T) {
+	const etag = `W/"abc"`
+	tests := map[string]bool{
+		"":               false,
+		`W/"abc"`:        true,
+		`"abc"`:          true,
+		`"xyz", W/"abc"`: true,
+		"*":              true,
+		`W/"xyz"`:        false,
+		`W/"abcd", "ab"`: false,
+	}
+	for header, want := range tests {
+		if got := matches(header, etag); got != want {
+			t.Errorf("matches(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	v1, v2 := &Request{Version: V1}, &Request{Version: V2}
+	if ETag(v1, "a") != ETag(v1, "a") {
+		t.Error("ETag is not deterministic")
+	}
+	if ETag(v1, "a") == ETag(v2, "a") {
+		t.Error("ETag ignores the API version")
+	}
+	if ETag(v1, "ab", "c") == ETag(v1, "a", "bc") {
+		t.Error("ETag parts run into each other")
+	}
+}
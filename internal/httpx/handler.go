@@ -0,0 +1,61 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"time"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+	"troggle-backend/internal/warmup"
+)
+
+// Handler is the signature every HTTP-facing troggle function implements.
+type Handler func(ctx context.Context, req *Request) (Response, error)
+
+// Adapt turns a Handler into a Lambda entry point that accepts API Gateway
+// proxy events (REST or HTTP API) as well as direct JSON invocations.
+//
+// Unparseable payloads get a 400, and an error returned by the handler is
+// logged and converted to a response by Error (a 500 unless it is a typed
+// apperr error), as is a panic, so API Gateway never answers with a bare
+// 502.
+// Requests are served in the API version their path names; see Version.
+// Response bodies of 1KB and more are compressed with brotli or gzip when
+// the client accepts either.
+// Every request ends with one summary log line carrying status and latency,
+// and one EMF document with the metrics recorded while handling it.
+//
+// The outer middlewares run outside the error and panic handling, so they
+// see every response as the client gets it, such as cors.Middleware.
+//
+// Warm-up pings are answered with a 200 once the warmers of package warmup
+// have run, without reaching h. In containers initialized for provisioned
+// concurrency, Adapt runs them at once, so main calls it after building the
+// handler and its clients.
+func Adapt(h Handler, outer ...Middleware) func(ctx context.Context, payload json.RawMessage) (Response, error) {
+	h = Chain(h, slices.Concat(edge, outer, standard)...)
+	if warmup.Provisioned() {
+		_ = warmup.Run(context.Background())
+	}
+	return func(ctx context.Context, payload json.RawMessage) (Response, error) {
+		if warmup.IsPing(payload) {
+			return JSON(200, map[string]bool{"warm": warmup.Run(ctx) == nil}), nil
+		}
+		start := time.Now()
+
+		ctx, recorder := metrics.NewContext(ctx)
+		defer recorder.Flush()
+		defer metrics.Since(ctx, metrics.HandlerDuration, start)
+
+		req, err := Parse(payload)
+		if err != nil {
+			slog.WarnContext(ctx, "Error parsing request", logging.Err(err))
+			return withRequestID(ctx, Error(apperr.BadRequest("Invalid request"))), nil
+		}
+		return h(ctx, req)
+	}
+}
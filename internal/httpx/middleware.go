@@ -0,0 +1,122 @@
+package httpx
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+	"troggle-backend/internal/tracing"
+)
+
+// Middleware wraps a Handler with behaviour shared across endpoints, such
+// as authentication, rate limiting or idempotency.
+type Middleware func(Handler) Handler
+
+// Chain returns h wrapped in mws. The first middleware sees each request
+// first and its response last. Nil middlewares are skipped.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			h = mws[i](h)
+		}
+	}
+	return h
+}
+
+// edge and standard are the middleware Adapt puts around every handler,
+// outside and inside the handler's outer middleware.
+var (
+	edge     = []Middleware{logRequests, requestIDs}
+	standard = []Middleware{compress, versions, handleErrors, trace, recoverPanics}
+)
+
+// logRequests ends every request with one summary log line carrying status
+// and latency. Attributes the handler adds with logging.Add, such as the
+// feature flags it evaluated, appear on that line.
+func logRequests(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (Response, error) {
+		start := time.Now()
+		if req.RequestID != "" {
+			ctx = logging.With(ctx, "apigw_request_id", req.RequestID)
+		}
+		ctx = logging.NewScope(ctx)
+
+		resp, err := next(ctx, req)
+		slog.InfoContext(ctx, "Request completed",
+			"method", req.Method,
+			"path", req.Path,
+			"status", resp.StatusCode,
+			"source_ip", req.SourceIP, // masked by the logger
+			logging.Latency(start),
+		)
+		return resp, err
+	}
+}
+
+// requestIDs puts the invocation's request ID into error responses; see
+// ErrorBody.
+func requestIDs(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (Response, error) {
+		resp, err := next(ctx, req)
+		return withRequestID(ctx, resp), err
+	}
+}
+
+// handleErrors logs an error returned by the handler and converts it to a
+// response by Error: a 500 unless it is a typed apperr error.
+func handleErrors(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (Response, error) {
+		resp, err := next(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		level := slog.LevelWarn
+		if apperr.KindOf(err) == apperr.KindInternal {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "Request failed", logging.Err(err))
+		metrics.Count(ctx, metrics.HandlerError)
+		return Error(err), nil
+	}
+}
+
+// trace records the handler as an X-Ray subsegment annotated with the
+// route and status.
+func trace(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (Response, error) {
+		var resp Response
+		err := tracing.Capture(ctx, "handler", func(ctx context.Context) error {
+			tracing.Annotate(ctx, "method", req.Method)
+			tracing.Annotate(ctx, "path", req.Path)
+
+			var herr error
+			resp, herr = next(ctx, req)
+			if herr == nil {
+				tracing.Annotate(ctx, "status", resp.StatusCode)
+			}
+			return herr
+		})
+		return resp, err
+	}
+}
+
+// recoverPanics turns a panic in the handler into an internal error, so the
+// caller gets a sanitized 500 and the container lives on. The stack is
+// logged with the request's context.
+func recoverPanics(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (resp Response, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				slog.ErrorContext(ctx, "Handler panicked", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+				metrics.Count(ctx, metrics.HandlerPanic)
+				resp, err = Response{}, apperr.Internal(fmt.Errorf("panic: %v", r))
+			}
+		}()
+		return next(ctx, req)
+	}
+}
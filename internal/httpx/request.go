@@ -0,0 +1,241 @@
+// Package httpx adapts Lambda invocations to a single request/response model.
+//
+// Functions can be invoked through an API Gateway REST API (payload format
+// 1.0), an HTTP API (payload format 2.0), or directly with a bare JSON
+// document (Lambda Invoke, Step Functions, tests). Handlers written against
+// Request never need to know which one happened.
+package httpx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events" // API Gateway proxy event definitions
+)
+
+// Request is the normalized view of an incoming invocation.
+type Request struct {
+	Method      string            // HTTP method; empty for direct invocations
+	Path        string            // request path, without the version segment; empty for direct invocations
+	Headers     map[string]string // header names are lower-cased
+	PathParams  map[string]string
+	QueryParams map[string]string
+	Body        []byte
+	RequestID   string  // API Gateway request ID, when available
+	SourceIP    string  // caller IP as seen by API Gateway, when available
+	Direct      bool    // true when the payload was not an API Gateway event
+	Version     Version // API version the caller asked for; see Version
+
+	// Claims and Scopes come from the API Gateway authorizer (Cognito user
+	// pool or JWT authorizer) that already verified the caller's token.
+	Claims map[string]string
+	Scopes []string
+}
+
+// ErrEmptyPayload is returned by Parse for a zero-length invocation payload.
+var ErrEmptyPayload = errors.New("empty payload")
+
+// eventProbe holds just enough fields to tell the payload formats apart.
+// Version is untyped because direct invocation payloads may carry their own
+// numeric "version" field (e.g. updateUserProfile).
+type eventProbe struct {
+	Version    any    `json:"version"`
+	RouteKey   string `json:"routeKey"`
+	HTTPMethod string `json:"httpMethod"`
+}
+
+// Parse turns a raw invocation payload into a Request.
+func Parse(payload json.RawMessage) (*Request, error) {
+	if len(payload) == 0 {
+		return nil, ErrEmptyPayload
+	}
+
+	var probe eventProbe
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, fmt.Errorf("decoding payload: %w", err)
+	}
+
+	switch {
+	case probe.Version == "2.0" && probe.RouteKey != "":
+		var event events.APIGatewayV2HTTPRequest
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding HTTP API event: %w", err)
+		}
+		return fromV2(event)
+
+	case probe.HTTPMethod != "":
+		var event events.APIGatewayProxyRequest
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding REST API event: %w", err)
+		}
+		return fromV1(event)
+
+	default:
+		// Direct invocation: the whole payload is the body
+		return &Request{
+			Headers:     map[string]string{},
+			PathParams:  map[string]string{},
+			QueryParams: map[string]string{},
+			Body:        payload,
+			Direct:      true,
+			Claims:      map[string]string{},
+		}, nil
+	}
+}
+
+// fromV1 converts a REST API (payload format 1.0) event.
+func fromV1(event events.APIGatewayProxyRequest) (*Request, error) {
+	body, err := decodeBody(event.Body, event.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := lowerKeys(event.Headers)
+	for name, values := range event.MultiValueHeaders {
+		if _, ok := headers[strings.ToLower(name)]; !ok && len(values) > 0 {
+			headers[strings.ToLower(name)] = strings.Join(values, ",")
+		}
+	}
+
+	return &Request{
+		Method:      event.HTTPMethod,
+		Path:        event.Path,
+		Headers:     headers,
+		PathParams:  orEmpty(event.PathParameters),
+		QueryParams: orEmpty(event.QueryStringParameters),
+		Body:        body,
+		RequestID:   event.RequestContext.RequestID,
+		SourceIP:    event.RequestContext.Identity.SourceIP,
+		Claims:      v1Claims(event.RequestContext.Authorizer),
+		Scopes:      strings.Fields(v1Claims(event.RequestContext.Authorizer)["scope"]),
+	}, nil
+}
+
+// v1Claims extracts the token claims a REST API Cognito authorizer places
+// under requestContext.authorizer.claims. Lambda authorizers return a flat
+// context instead, which is used as is.
+func v1Claims(authorizer map[string]any) map[string]string {
+	claims := map[string]string{}
+	raw, ok := authorizer["claims"].(map[string]any)
+	if !ok {
+		raw = authorizer
+	}
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			claims[k] = s
+		} else {
+			claims[k] = fmt.Sprint(v)
+		}
+	}
+	return claims
+}
+
+// fromV2 converts an HTTP API (payload format 2.0) event.
+func fromV2(event events.APIGatewayV2HTTPRequest) (*Request, error) {
+	body, err := decodeBody(event.Body, event.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, scopes := map[string]string{}, []string(nil)
+	if auth := event.RequestContext.Authorizer; auth != nil && auth.JWT != nil {
+		claims = orEmpty(auth.JWT.Claims)
+		scopes = auth.JWT.Scopes
+		if len(scopes) == 0 {
+			scopes = strings.Fields(claims["scope"])
+		}
+	}
+
+	return &Request{
+		Method:      event.RequestContext.HTTP.Method,
+		Path:        event.RawPath,
+		Headers:     lowerKeys(event.Headers),
+		PathParams:  orEmpty(event.PathParameters),
+		QueryParams: orEmpty(event.QueryStringParameters),
+		Body:        body,
+		RequestID:   event.RequestContext.RequestID,
+		SourceIP:    event.RequestContext.HTTP.SourceIP,
+		Claims:      claims,
+		Scopes:      scopes,
+	}, nil
+}
+
+// Header returns the value of the named header, ignoring case.
+func (r *Request) Header(name string) string {
+	return r.Headers[strings.ToLower(name)]
+}
+
+// Subject returns the authenticated caller's user ID (the token's "sub"
+// claim), or "" for unauthenticated requests.
+func (r *Request) Subject() string {
+	return r.Claims["sub"]
+}
+
+// HasScope reports whether the caller's token was granted scope.
+func (r *Request) HasScope(scope string) bool {
+	for _, s := range r.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// InGroup reports whether the caller belongs to the Cognito group. Group
+// claims arrive either as a JSON-ish "[a b]" list or comma separated,
+// depending on the authorizer.
+func (r *Request) InGroup(group string) bool {
+	raw := strings.Trim(r.Claims["cognito:groups"], "[]")
+	for _, g := range strings.FieldsFunc(raw, func(c rune) bool { return c == ',' || c == ' ' }) {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the value of the named query string parameter.
+func (r *Request) Query(name string) string {
+	return r.QueryParams[name]
+}
+
+// Decode unmarshals the JSON body into v.
+func (r *Request) Decode(v any) error {
+	if len(r.Body) == 0 {
+		return ErrEmptyPayload
+	}
+	return json.Unmarshal(r.Body, v)
+}
+
+// decodeBody returns the raw bytes of an API Gateway body.
+func decodeBody(body string, isBase64 bool) ([]byte, error) {
+	if !isBase64 {
+		return []byte(body), nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding base64 body: %w", err)
+	}
+	return decoded, nil
+}
+
+// lowerKeys copies m with every key lower-cased.
+func lowerKeys(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
+
+// orEmpty returns m, or an empty map when m is nil, so handlers can index
+// parameter maps without nil checks.
+func orEmpty(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
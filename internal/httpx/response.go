@@ -0,0 +1,144 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/buildinfo"
+)
+
+// Response is what every handler returns. API Gateway REST and HTTP APIs both
+// accept this shape, and direct callers simply read StatusCode and Body.
+type Response = events.APIGatewayProxyResponse
+
+// JSON marshals v and returns it with the given status code.
+func JSON(status int, v any) Response {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Error marshalling response", "error", err)
+		return Text(500, "Server error")
+	}
+	return respond(status, "application/json", string(body))
+}
+
+// Text returns a plain-text response with the given status code.
+func Text(status int, message string) Response {
+	return respond(status, "text/plain; charset=utf-8", message)
+}
+
+// NoContent returns an empty 204 response.
+func NoContent() Response {
+	return respond(204, "", "")
+}
+
+// respond builds a Response with the content-type header set. CORS headers
+// are added by cors.Middleware.
+func respond(status int, contentType, body string) Response {
+	headers := make(map[string]string, 1)
+	if contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+
+	return Response{
+		StatusCode: status,
+		Headers:    headers,
+		Body:       body,
+	}
+}
+
+// AddVary adds name to the Vary header of headers.
+func AddVary(headers map[string]string, name string) {
+	if v := headers["Vary"]; v != "" {
+		headers["Vary"] = v + ", " + name
+	} else {
+		headers["Vary"] = name
+	}
+}
+
+// ErrorBody is the JSON body of every error response:
+//
+//	{"error": {"code": "USER_NOT_FOUND", "message": "User not found", "request_id": "...", "build": "..."}}
+//
+// Clients branch on the code, which does not change, and may show the
+// message, which may. The request ID is the one of the invocation's log
+// lines, so a client reporting a failure can quote it, and the build the
+// git SHA of the code that failed.
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error in an ErrorBody.
+type ErrorDetail struct {
+	Code      string              `json:"code"`
+	Message   string              `json:"message"`
+	Field     string              `json:"field,omitempty"`   // offending input field, for invalid input
+	Fields    []apperr.FieldError `json:"fields,omitempty"`  // every invalid field, for invalid input
+	Current   *apperr.Current     `json:"current,omitempty"` // state of the resource, for version conflicts
+	RequestID string              `json:"request_id,omitempty"`
+	Build     string              `json:"build,omitempty"` // git SHA; see package buildinfo
+}
+
+// Error converts err to a response using its apperr.Kind: the status code
+// comes from the Kind and the body is an ErrorBody holding its code and
+// client-facing message, never the underlying cause. Throttled and
+// unavailable responses tell the client when to retry, and 401s which
+// authentication scheme to use. Handlers return every error response
+// through Error, so all of them share one shape.
+func Error(err error) Response {
+	e := apperr.As(err)
+
+	resp := JSON(apperr.Status(e), ErrorBody{Error: ErrorDetail{
+		Code:    apperr.Code(e),
+		Message: e.Message,
+		Field:   e.Field,
+		Fields:  e.Fields,
+		Current: e.Current,
+	}})
+	switch e.Kind {
+	case apperr.KindThrottled, apperr.KindUnavailable:
+		resp.Headers["Retry-After"] = retryAfter(e.RetryAfter)
+	case apperr.KindUnauthorized:
+		resp.Headers["WWW-Authenticate"] = "Bearer"
+	}
+	return resp
+}
+
+// retryAfter formats d as whole seconds for the Retry-After header, rounding
+// up and defaulting to one second.
+func retryAfter(d time.Duration) string {
+	secs := int64(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+// withRequestID sets the request ID of an error response built by Error to
+// the Lambda request ID in ctx, and its build to the running one. Other
+// responses are returned as they are.
+func withRequestID(ctx context.Context, resp Response) Response {
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok || resp.StatusCode < 400 || resp.Headers["Content-Type"] != "application/json" {
+		return resp
+	}
+	var body ErrorBody
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil || body.Error.Code == "" || body.Error.RequestID != "" {
+		return resp
+	}
+	body.Error.RequestID = lc.AwsRequestID
+	body.Error.Build = buildinfo.Get().Commit
+	b, err := json.Marshal(body)
+	if err != nil {
+		return resp
+	}
+	resp.Body = string(b)
+	return resp
+}
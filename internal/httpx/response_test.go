@@ -0,0 +1,85 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+
+	"troggle-backend/internal/apperr"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		want       ErrorDetail
+	}{
+		{
+			name:       "coded",
+			err:        &apperr.Error{Kind: apperr.KindNotFound, Code: "USER_NOT_FOUND", Message: "User not found"},
+			wantStatus: 404,
+			want:       ErrorDetail{Code: "USER_NOT_FOUND", Message: "User not found"},
+		},
+		{
+			name:       "default code",
+			err:        apperr.Conflict("Email already registered"),
+			wantStatus: 409,
+			want:       ErrorDetail{Code: "CONFLICT", Message: "Email already registered"},
+		},
+		{
+			name:       "field",
+			err:        apperr.Invalid("EMAIL_INVALID", "email", "Invalid email"),
+			wantStatus: 422,
+			want:       ErrorDetail{Code: "EMAIL_INVALID", Message: "Invalid email", Field: "email"},
+		},
+		{
+			name:       "untyped",
+			err:        errors.New("connection reset"),
+			wantStatus: 500,
+			want:       ErrorDetail{Code: "INTERNAL_ERROR", Message: "Server error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := Error(tt.err)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			var body ErrorBody
+			if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+				t.Fatalf("body %s: %v", resp.Body, err)
+			}
+			if !reflect.DeepEqual(body.Error, tt.want) {
+				t.Errorf("error = %+v, want %+v", body.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "req-1"})
+
+	var body ErrorBody
+	resp := withRequestID(ctx, Error(apperr.NotFound("User not found")))
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.RequestID != "req-1" {
+		t.Errorf("request_id = %q, want req-1", body.Error.RequestID)
+	}
+	if body.Error.Build == "" {
+		t.Error("build is empty")
+	}
+
+	// Other bodies are left alone
+	for _, resp := range []Response{JSON(200, map[string]bool{"exists": true}), JSON(404, map[string]bool{"exists": false}), Text(400, "Invalid request")} {
+		if got := withRequestID(ctx, resp); got.Body != resp.Body {
+			t.Errorf("body %s changed to %s", resp.Body, got.Body)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+)
+
+// Version is a version of the API. Clients pick one with the first segment
+// of the path, /v1/users/{user_id} or /v2/users/{user_id}; unversioned paths
+// are version 1, which is what the mobile clients released before
+// versioning call. Functions serve every version with the same business
+// logic and only shape their responses differently (see Shaper), so a
+// version is a contract about response bodies, not a separate deployment.
+type Version int
+
+// Versions of the API.
+const (
+	V1 Version = 1
+	V2 Version = 2
+)
+
+// Versions are the versions served, oldest first.
+var Versions = []Version{V1, V2}
+
+// String returns the path segment of v, e.g. "v2".
+func (v Version) String() string {
+	return "v" + strconv.Itoa(int(v))
+}
+
+// Deprecation describes a version clients should move off.
+type Deprecation struct {
+	Since  time.Time // when the version was deprecated
+	Sunset time.Time // when it stops being served; zero until decided
+}
+
+// deprecations are the deprecated versions. Responses to them carry the
+// Deprecation (RFC 9745) and Sunset (RFC 8594) headers, and a Link to the
+// same resource in the latest version, which the mobile clients surface as
+// an update prompt.
+var deprecations = map[Version]Deprecation{}
+
+// errUnknownVersion answers paths naming a version that is not served.
+var errUnknownVersion = &apperr.Error{Kind: apperr.KindNotFound, Code: "UNKNOWN_API_VERSION", Message: "Unknown API version"}
+
+// versionPrefix matches the version segment of a path.
+var versionPrefix = regexp.MustCompile(`^/v([0-9]+)(/.*)?$`)
+
+// splitVersion returns the version a path names and the path without it.
+// Unversioned paths are version 1. ok is false for unknown versions.
+func splitVersion(path string) (v Version, rest string, ok bool) {
+	m := versionPrefix.FindStringSubmatch(path)
+	if m == nil {
+		return V1, path, true
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n < int(V1) || n > int(Versions[len(Versions)-1]) {
+		return 0, path, false
+	}
+	if rest = m[2]; rest == "" {
+		rest = "/"
+	}
+	return Version(n), rest, true
+}
+
+// versionKey is the context key of the request's version.
+type versionKey struct{}
+
+// VersionOf returns the version the request of ctx asked for: version 1
+// for direct invocations and outside a request.
+func VersionOf(ctx context.Context) Version {
+	if v, ok := ctx.Value(versionKey{}).(Version); ok {
+		return v
+	}
+	return V1
+}
+
+// versions resolves the version of API Gateway requests. The handler sees
+// the path without the version segment, so path-based dispatch works alike
+// in every version, and Request.Version set. Metrics of the request are
+// dimensioned by version as well, and responses to a deprecated version
+// carry its deprecation headers.
+func versions(next Handler) Handler {
+	return func(ctx context.Context, r *Request) (Response, error) {
+		if r.Direct {
+			r.Version = V1
+			return next(ctx, r)
+		}
+		v, rest, ok := splitVersion(r.Path)
+		if !ok {
+			return Error(errUnknownVersion), nil
+		}
+
+		ctx = context.WithValue(ctx, versionKey{}, v)
+		logging.Add(ctx, "api_version", v.String())
+		metrics.SetDimension(ctx, "ApiVersion", v.String())
+		metrics.Count(ctx, metrics.APIRequest)
+
+		req := *r
+		req.Path, req.Version = rest, v
+		resp, err := next(ctx, &req)
+
+		if d, ok := deprecations[v]; ok && err == nil {
+			metrics.Count(ctx, metrics.DeprecatedRequest)
+			resp = deprecate(resp, d, rest)
+		}
+		return resp, err
+	}
+}
+
+// deprecate adds the headers announcing d to resp, a response served at
+// path in a deprecated version.
+func deprecate(resp Response, d Deprecation, path string) Response {
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	resp.Headers["Deprecation"] = "@" + strconv.FormatInt(d.Since.Unix(), 10)
+	if !d.Sunset.IsZero() {
+		resp.Headers["Sunset"] = d.Sunset.UTC().Format(http.TimeFormat)
+	}
+	latest := Versions[len(Versions)-1]
+	resp.Headers["Link"] = "</" + latest.String() + path + `>; rel="successor-version"`
+	return resp
+}
+
+// Shaper is implemented by response bodies whose shape differs between
+// versions. Shape returns the body to encode for v.
+type Shaper interface {
+	Shape(v Version) any
+}
+
+// JSONFor is JSON for a response to r: a body implementing Shaper is shaped
+// for the version r asked for first.
+func JSONFor(r *Request, status int, v any) Response {
+	if s, ok := v.(Shaper); ok {
+		v = s.Shape(r.Version)
+	}
+	return JSON(status, v)
+}
+
+// Page is the version 2 shape of every paged list, which version 1 keys by
+// what it lists ("users", "messages", ...).
+type Page[T any] struct {
+	Items     []T    `json:"items"`
+	NextToken string `json:"next_token,omitempty"` // absent on the last page
+}
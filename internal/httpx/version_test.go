@@ -0,0 +1,97 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSplitVersion(t *testing.T) {
+	tests := []struct {
+		path     string
+		want     Version
+		wantRest string
+		wantOK   bool
+	}{
+		{path: "/users/u1", want: V1, wantRest: "/users/u1", wantOK: true},
+		{path: "/v1/users/u1", want: V1, wantRest: "/users/u1", wantOK: true},
+		{path: "/v2/users/u1", want: V2, wantRest: "/users/u1", wantOK: true},
+		{path: "/v2", want: V2, wantRest: "/", wantOK: true},
+		{path: "/videos/v1", want: V1, wantRest: "/videos/v1", wantOK: true},
+		{path: "/v0/users", wantOK: false},
+		{path: "/v9/users", wantOK: false},
+	}
+	for _, tt := range tests {
+		v, rest, ok := splitVersion(tt.path)
+		if ok != tt.wantOK || (ok && (v != tt.want || rest != tt.wantRest)) {
+			t.Errorf("splitVersion(%q) = %v, %q, %v, want %v, %q, %v", tt.path, v, rest, ok, tt.want, tt.wantRest, tt.wantOK)
+		}
+	}
+}
+
+// shaped answers "old" in version 1 and "new" from version 2 on.
+type shaped struct{}
+
+func (shaped) Shape(v Version) any {
+	if v < V2 {
+		return "old"
+	}
+	return "new"
+}
+
+func TestVersions(t *testing.T) {
+	saved := deprecations
+	t.Cleanup(func() { deprecations = saved })
+	since := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	deprecations = map[Version]Deprecation{V1: {Since: since, Sunset: since.AddDate(0, 6, 0)}}
+
+	var seen *Request
+	h := Adapt(func(ctx context.Context, r *Request) (Response, error) {
+		seen = r
+		if VersionOf(ctx) != r.Version {
+			t.Errorf("VersionOf = %v, want %v", VersionOf(ctx), r.Version)
+		}
+		return JSONFor(r, 200, shaped{}), nil
+	})
+	event := func(path string) json.RawMessage {
+		return json.RawMessage(`{"httpMethod":"GET","path":"` + path + `"}`)
+	}
+
+	resp, err := h(context.Background(), event("/v2/users/u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen.Path != "/users/u1" || seen.Version != V2 || resp.Body != `"new"` {
+		t.Errorf("v2: handler saw %s in %v and answered %s", seen.Path, seen.Version, resp.Body)
+	}
+	if _, ok := resp.Headers["Deprecation"]; ok {
+		t.Errorf("v2: headers = %v, want no deprecation", resp.Headers)
+	}
+
+	resp, err = h(context.Background(), event("/users/u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen.Version != V1 || resp.Body != `"old"` {
+		t.Errorf("unversioned: handler saw %v and answered %s", seen.Version, resp.Body)
+	}
+	want := map[string]string{
+		"Deprecation": "@1788220800",
+		"Sunset":      "Mon, 01 Mar 2027 00:00:00 GMT",
+		"Link":        `</v2/users/u1>; rel="successor-version"`,
+	}
+	for name, value := range want {
+		if resp.Headers[name] != value {
+			t.Errorf("%s = %q, want %q", name, resp.Headers[name], value)
+		}
+	}
+
+	resp, err = h(context.Background(), event("/v7/users/u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 404 {
+		t.Errorf("unknown version: status = %d, want 404", resp.StatusCode)
+	}
+}
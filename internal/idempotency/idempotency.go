@@ -0,0 +1,270 @@
+// Package idempotency makes mutating endpoints safe to retry. A request
+// carrying an Idempotency-Key header is executed at most once per key; later
+// requests with the same key get the recorded response back instead of
+// running the handler again.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/logging"
+)
+
+const (
+	// Header is the request header carrying the client-chosen key.
+	Header = "idempotency-key"
+
+	// maxKeyLength bounds client-chosen keys.
+	maxKeyLength = 255
+
+	// ttl is how long completed responses are replayed.
+	ttl = 24 * time.Hour
+
+	// lockTimeout is how long an in-progress record blocks retries. It must
+	// exceed the function timeout, so a crashed invocation cannot hold its
+	// key forever.
+	lockTimeout = 5 * time.Minute
+)
+
+// Record statuses.
+const (
+	statusInProgress = "in_progress"
+	statusCompleted  = "completed"
+)
+
+// record is an item of the idempotency table.
+type record struct {
+	Status      string
+	RequestHash string
+	Response    string // JSON-encoded httpx.Response
+}
+
+// Store records the outcome of idempotent requests in DynamoDB. Items expire
+// through the table's TTL attribute, expires_at.
+type Store struct {
+	DB    *db.Client
+	Table string
+}
+
+// New returns a Store backed by the configured idempotency table.
+func New(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.IdempotencyTableName}
+}
+
+// Wrap returns a handler that honours the Idempotency-Key header. Requests
+// without the header run normally. Keys are scoped to the function and the
+// caller, so two users cannot collide on the same key. Reusing a key with a
+// different request is rejected, as is retrying while the first attempt is
+// still running. Failed attempts (errors and 5xx responses) release the key
+// so the client can retry. A nil Store disables the check.
+func (s *Store) Wrap(next httpx.Handler) httpx.Handler {
+	if s == nil {
+		return next
+	}
+	return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+		key := r.Header(Header)
+		if key == "" {
+			return next(ctx, r)
+		}
+		if len(key) > maxKeyLength {
+			return httpx.Error(apperr.Invalid("IDEMPOTENCY_KEY_INVALID", "Idempotency-Key",
+				fmt.Sprintf("Idempotency-Key must be at most %d characters", maxKeyLength))), nil
+		}
+
+		id := scopedKey(ctx, r, key)
+		hash := requestHash(r)
+		ctx = logging.With(ctx, "idempotency_key", key)
+
+		acquired, err := s.acquire(ctx, id, hash)
+		if err != nil {
+			return httpx.Response{}, err
+		}
+		if !acquired {
+			return s.replay(ctx, id, hash)
+		}
+
+		resp, err := next(ctx, r)
+		if err != nil || resp.StatusCode >= 500 {
+			if rerr := s.release(ctx, id); rerr != nil {
+				slog.WarnContext(ctx, "Error releasing idempotency key", logging.Err(rerr))
+			}
+			return resp, err
+		}
+		if err := s.complete(ctx, id, resp); err != nil {
+			// The mutation already happened; failing now would invite a
+			// duplicate on retry, so answer normally and let the lock expire.
+			slog.WarnContext(ctx, "Error recording idempotent response", logging.Err(err))
+		}
+		return resp, nil
+	}
+}
+
+// scopedKey combines the function, caller and client key.
+func scopedKey(ctx context.Context, r *httpx.Request, key string) string {
+	caller := r.Subject()
+	if id, ok := auth.FromContext(ctx); ok {
+		caller = id.Subject
+	}
+	return lambdacontext.FunctionName + "#" + caller + "#" + key
+}
+
+// requestHash fingerprints the parts of the request that define it, so a key
+// reused for a different request can be detected.
+func requestHash(r *httpx.Request) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.Path)
+	h.Write(r.Body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// acquire writes an in-progress record for id. It returns false if a live
+// record already exists. Stale in-progress records left by crashed
+// invocations are taken over.
+func (s *Store) acquire(ctx context.Context, id, hash string) (bool, error) {
+	now := time.Now()
+	_, err := s.DB.DynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.Table),
+		Item: db.Item{
+			"idempotency_key": &types.AttributeValueMemberS{Value: id},
+			"status":          &types.AttributeValueMemberS{Value: statusInProgress},
+			"request_hash":    &types.AttributeValueMemberS{Value: hash},
+			"locked_until":    epoch(now.Add(lockTimeout)),
+			"expires_at":      epoch(now.Add(ttl)),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(idempotency_key) OR (#status = :in_progress AND locked_until < :now)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":in_progress": &types.AttributeValueMemberS{Value: statusInProgress},
+			":now":         epoch(now),
+		},
+	})
+	db.Observe(ctx, now, err)
+	if db.ConditionFailed(err, -1) {
+		return false, nil
+	}
+	if err != nil {
+		return false, db.Wrap(err, "acquiring idempotency key")
+	}
+	return true, nil
+}
+
+// replay answers a request whose key is already recorded.
+func (s *Store) replay(ctx context.Context, id, hash string) (httpx.Response, error) {
+	rec, err := s.get(ctx, id)
+	if err != nil {
+		return httpx.Response{}, err
+	}
+	switch {
+	case rec == nil:
+		// Released between our write attempt and this read
+		return httpx.Error(apperr.Conflict("A request with this Idempotency-Key failed; retry it")), nil
+	case rec.RequestHash != hash:
+		return httpx.Error(apperr.Invalid("IDEMPOTENCY_KEY_REUSED", "Idempotency-Key",
+			"Idempotency-Key was already used for a different request")), nil
+	case rec.Status != statusCompleted:
+		return httpx.Error(apperr.Conflict("A request with this Idempotency-Key is still in progress")), nil
+	}
+
+	var resp httpx.Response
+	if err := json.Unmarshal([]byte(rec.Response), &resp); err != nil {
+		return httpx.Response{}, fmt.Errorf("decoding recorded response: %w", err)
+	}
+	if resp.Headers == nil {
+		resp.Headers = map[string]string{}
+	}
+	resp.Headers["Idempotent-Replayed"] = "true"
+	slog.InfoContext(ctx, "Replayed idempotent response", "status", resp.StatusCode)
+	return resp, nil
+}
+
+// get reads the record for id with a strongly consistent read, or nil.
+func (s *Store) get(ctx context.Context, id string) (*record, error) {
+	start := time.Now()
+	result, err := s.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.Table),
+		Key:            key(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return nil, db.Wrap(err, "reading idempotency key")
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	rec := &record{}
+	for name, dst := range map[string]*string{
+		"status":       &rec.Status,
+		"request_hash": &rec.RequestHash,
+		"response":     &rec.Response,
+	} {
+		if v, ok := result.Item[name].(*types.AttributeValueMemberS); ok {
+			*dst = v.Value
+		}
+	}
+	return rec, nil
+}
+
+// complete stores the response and marks the record completed.
+func (s *Store) complete(ctx context.Context, id string, resp httpx.Response) error {
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	_, err = s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(s.Table),
+		Key:              key(id),
+		UpdateExpression: aws.String("SET #status = :completed, #response = :response REMOVE locked_until"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":   "status",
+			"#response": "response",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":completed": &types.AttributeValueMemberS{Value: statusCompleted},
+			":response":  &types.AttributeValueMemberS{Value: string(raw)},
+		},
+	})
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, "completing idempotency key")
+}
+
+// release deletes the record so the request can be retried.
+func (s *Store) release(ctx context.Context, id string) error {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key:       key(id),
+	})
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, "releasing idempotency key")
+}
+
+func key(id string) db.Item {
+	return db.Item{"idempotency_key": &types.AttributeValueMemberS{Value: id}}
+}
+
+// epoch encodes t as the Unix seconds DynamoDB TTL expects.
+func epoch(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
@@ -0,0 +1,365 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+)
+
+// table is an in-memory idempotency table, keyed by idempotency_key, that
+// enforces the acquire condition.
+type table map[string]db.Item
+
+func (t table) mock() *dbtest.Mock {
+	id := func(k db.Item) string { return k["idempotency_key"].(*types.AttributeValueMemberS).Value }
+	return &dbtest.Mock{
+		PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			if old, ok := t[id(in.Item)]; ok {
+				now := in.ExpressionAttributeValues[":now"].(*types.AttributeValueMemberN).Value
+				if str(old, "status") != statusInProgress || num(old, "locked_until") >= mustAtoi(now) {
+					return nil, dbtest.ConditionFailed()
+				}
+			}
+			t[id(in.Item)] = in.Item
+			return &dynamodb.PutItemOutput{}, nil
+		},
+		GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{Item: t[id(in.Key)]}, nil
+		},
+		UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+			item := t[id(in.Key)]
+			item["status"] = in.ExpressionAttributeValues[":completed"]
+			item["response"] = in.ExpressionAttributeValues[":response"]
+			delete(item, "locked_until")
+			return &dynamodb.UpdateItemOutput{}, nil
+		},
+		DeleteItemFunc: func(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+			delete(t, id(in.Key))
+			return &dynamodb.DeleteItemOutput{}, nil
+		},
+	}
+}
+
+func str(item db.Item, name string) string {
+	v, _ := item[name].(*types.AttributeValueMemberS)
+	if v == nil {
+		return ""
+	}
+	return v.Value
+}
+
+func num(item db.Item, name string) int64 {
+	v, _ := item[name].(*types.AttributeValueMemberN)
+	if v == nil {
+		return 0
+	}
+	return mustAtoi(v.Value)
+}
+
+func mustAtoi(s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// request is a POST by user u1 carrying the Idempotency-Key k1.
+func request(body string) *httpx.Request {
+	return &httpx.Request{
+		Method:  http.MethodPost,
+		Path:    "/users/u1/friends",
+		Headers: map[string]string{Header: "k1"},
+		Body:    []byte(body),
+		Claims:  map[string]string{"sub": "u1"},
+	}
+}
+
+// stored is the record a previous request left for request(body).
+func stored(status, body string, lockedFor time.Duration, resp *httpx.Response) db.Item {
+	item := db.Item{
+		"idempotency_key": &types.AttributeValueMemberS{Value: "#u1#k1"},
+		"status":          &types.AttributeValueMemberS{Value: status},
+		"request_hash":    &types.AttributeValueMemberS{Value: requestHash(request(body))},
+	}
+	if status == statusInProgress {
+		item["locked_until"] = epoch(time.Now().Add(lockedFor))
+	}
+	if resp != nil {
+		raw, _ := json.Marshal(resp)
+		item["response"] = &types.AttributeValueMemberS{Value: string(raw)}
+	}
+	return item
+}
+
+func TestWrap(t *testing.T) {
+	created := httpx.JSON(http.StatusCreated, map[string]string{"friend_id": "u2"})
+
+	tests := []struct {
+		name     string
+		stored   db.Item // record of key k1 before the request, if any
+		req      *httpx.Request
+		respond  httpx.Response // of the handler
+		fail     error          // returned by the handler
+		wantRun  bool
+		wantCode int
+		wantBody string // substring of the response body
+		replayed bool
+		// wantStatus is the status of the record after the request; "" when
+		// there is none.
+		wantStatus string
+	}{
+		{
+			name:       "first request",
+			req:        request(`{"friend_id":"u2"}`),
+			respond:    created,
+			wantRun:    true,
+			wantCode:   http.StatusCreated,
+			wantBody:   `"friend_id":"u2"`,
+			wantStatus: statusCompleted,
+		},
+		{
+			name:       "no key",
+			req:        &httpx.Request{Method: http.MethodPost, Path: "/users/u1/friends"},
+			respond:    created,
+			wantRun:    true,
+			wantCode:   http.StatusCreated,
+			wantStatus: "",
+		},
+		{
+			name:     "key too long",
+			req:      &httpx.Request{Headers: map[string]string{Header: strings.Repeat("k", maxKeyLength+1)}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "IDEMPOTENCY_KEY_INVALID",
+		},
+		{
+			name:       "replayed",
+			stored:     stored(statusCompleted, `{"friend_id":"u2"}`, 0, &created),
+			req:        request(`{"friend_id":"u2"}`),
+			wantCode:   http.StatusCreated,
+			wantBody:   `"friend_id":"u2"`,
+			replayed:   true,
+			wantStatus: statusCompleted,
+		},
+		{
+			name:       "reused for another request",
+			stored:     stored(statusCompleted, `{"friend_id":"u2"}`, 0, &created),
+			req:        request(`{"friend_id":"u3"}`),
+			wantCode:   http.StatusUnprocessableEntity,
+			wantBody:   "IDEMPOTENCY_KEY_REUSED",
+			wantStatus: statusCompleted,
+		},
+		{
+			name:       "in progress",
+			stored:     stored(statusInProgress, `{"friend_id":"u2"}`, time.Minute, nil),
+			req:        request(`{"friend_id":"u2"}`),
+			wantCode:   http.StatusConflict,
+			wantBody:   "still in progress",
+			wantStatus: statusInProgress,
+		},
+		{
+			name:       "stale lock taken over",
+			stored:     stored(statusInProgress, `{"friend_id":"u2"}`, -time.Minute, nil),
+			req:        request(`{"friend_id":"u2"}`),
+			respond:    created,
+			wantRun:    true,
+			wantCode:   http.StatusCreated,
+			wantStatus: statusCompleted,
+		},
+		{
+			name:       "client error recorded",
+			req:        request(`{}`),
+			respond:    httpx.JSON(http.StatusBadRequest, nil),
+			wantRun:    true,
+			wantCode:   http.StatusBadRequest,
+			wantStatus: statusCompleted,
+		},
+		{
+			name:       "server error releases the key",
+			req:        request(`{"friend_id":"u2"}`),
+			respond:    httpx.JSON(http.StatusServiceUnavailable, nil),
+			wantRun:    true,
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "",
+		},
+		{
+			name:       "error releases the key",
+			req:        request(`{"friend_id":"u2"}`),
+			fail:       errors.New("boom"),
+			wantRun:    true,
+			wantStatus: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tbl := table{}
+			if tt.stored != nil {
+				tbl["#u1#k1"] = tt.stored
+			}
+			s := &Store{DB: tbl.mock().Client(), Table: "idempotency"}
+			ran := false
+			h := s.Wrap(func(context.Context, *httpx.Request) (httpx.Response, error) {
+				ran = true
+				return tt.respond, tt.fail
+			})
+
+			resp, err := h(context.Background(), tt.req)
+			if !errors.Is(err, tt.fail) {
+				t.Fatalf("error = %v, want %v", err, tt.fail)
+			}
+			if ran != tt.wantRun {
+				t.Errorf("handler ran = %v, want %v", ran, tt.wantRun)
+			}
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if !strings.Contains(resp.Body, tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", resp.Body, tt.wantBody)
+			}
+			if got := resp.Headers["Idempotent-Replayed"] == "true"; got != tt.replayed {
+				t.Errorf("replayed = %v, want %v", got, tt.replayed)
+			}
+			if got := str(tbl["#u1#k1"], "status"); got != tt.wantStatus {
+				t.Errorf("record status = %q, want %q", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestWrapRetry(t *testing.T) {
+	tbl := table{}
+	s := &Store{DB: tbl.mock().Client(), Table: "idempotency"}
+	runs := 0
+	h := s.Wrap(func(context.Context, *httpx.Request) (httpx.Response, error) {
+		runs++
+		if runs == 1 {
+			return httpx.JSON(http.StatusInternalServerError, nil), nil
+		}
+		return httpx.JSON(http.StatusCreated, map[string]int{"run": runs}), nil
+	})
+
+	for i, want := range []int{http.StatusInternalServerError, http.StatusCreated, http.StatusCreated} {
+		resp, err := h(context.Background(), request(`{"friend_id":"u2"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("attempt %d: status = %d, want %d", i+1, resp.StatusCode, want)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("handler ran %d times, want 2: once failing, once recorded", runs)
+	}
+}
+
+func TestWrapScopesKeys(t *testing.T) {
+	tbl := table{}
+	s := &Store{DB: tbl.mock().Client(), Table: "idempotency"}
+	runs := 0
+	h := s.Wrap(func(context.Context, *httpx.Request) (httpx.Response, error) {
+		runs++
+		return httpx.JSON(http.StatusCreated, nil), nil
+	})
+
+	ctx := context.Background()
+	other := request(`{"friend_id":"u2"}`)
+	other.Claims = map[string]string{"sub": "u2"}
+	keyOwner := auth.NewContext(ctx, &auth.Identity{Subject: "u3", TokenUse: auth.TokenUseAPIKey})
+
+	h(ctx, request(`{"friend_id":"u2"}`))
+	h(ctx, other)
+	h(keyOwner, request(`{"friend_id":"u2"}`))
+	if runs != 3 {
+		t.Errorf("handler ran %d times, want once per caller", runs)
+	}
+	for _, id := range []string{"#u1#k1", "#u2#k1", "#u3#k1"} {
+		if _, ok := tbl[id]; !ok {
+			t.Errorf("no record %q in %v", id, tbl)
+		}
+	}
+}
+
+func TestWrapStoreFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		mock     *dbtest.Mock
+		wantErr  bool
+		wantRun  bool
+		wantCode int
+	}{
+		{
+			name: "acquire fails",
+			mock: &dbtest.Mock{
+				PutItemFunc: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					return nil, dbtest.Throttled()
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "released before replay",
+			mock: &dbtest.Mock{
+				PutItemFunc: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					return nil, dbtest.ConditionFailed()
+				},
+			},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name: "recording fails",
+			mock: &dbtest.Mock{
+				UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					return nil, dbtest.Throttled()
+				},
+			},
+			wantRun:  true,
+			wantCode: http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Store{DB: tt.mock.Client(), Table: "idempotency"}
+			ran := false
+			h := s.Wrap(func(context.Context, *httpx.Request) (httpx.Response, error) {
+				ran = true
+				return httpx.JSON(http.StatusCreated, nil), nil
+			})
+
+			resp, err := h(context.Background(), request(`{}`))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if ran != tt.wantRun {
+				t.Errorf("handler ran = %v, want %v", ran, tt.wantRun)
+			}
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	ran := false
+	h := s.Wrap(func(context.Context, *httpx.Request) (httpx.Response, error) {
+		ran = true
+		return httpx.JSON(http.StatusCreated, nil), nil
+	})
+	if _, err := h(context.Background(), request(`{}`)); err != nil || !ran {
+		t.Errorf("nil Store: ran = %v, error = %v", ran, err)
+	}
+}
@@ -0,0 +1,309 @@
+// Package invitations lets users invite people to troggle by email, and
+// credits them when an invitation brings in a new account.
+//
+// createInvitation records an invitation as an entity of the inviter in the
+// single table (see package userdata) and mails its link. The link carries
+// a code naming the inviter, the invitation and its expiry, signed by
+// package signing, so acceptInvitation
+// finds the invitation without an index and forged codes cost no read.
+//
+// Accepting marks the invitation accepted by the new account, records the
+// inviter on the account's user record as referred_by and counts the
+// referral on the inviter's as referral_count, in one transaction. Neither
+// is returned with profiles (see users.SensitiveAttributes). An
+// invitation is accepted once, and an account referred once. Only accounts
+// created after the invitation can accept it, so existing users cannot
+// trade referrals. The InvitationAccepted event it publishes is what
+// referral rewards, such as achievements, hang off.
+//
+// Daily counters of the invitations sent and accepted (see package
+// counters) make up the funnel admins read through getInvitationFunnel.
+package invitations
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/counters"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/secrets"
+	"troggle-backend/internal/signing"
+	"troggle-backend/internal/userdata"
+	"troggle-backend/internal/users"
+)
+
+// SecretName is the secret, below SECRETS_PREFIX, holding the signing key.
+const SecretName = "invitation_key"
+
+// TTL is how long an invitation can be accepted.
+const TTL = 14 * 24 * time.Hour
+
+// Statuses of an invitation. Pending invitations past their expiry can no
+// longer be accepted.
+const (
+	StatusPending  = "pending"
+	StatusAccepted = "accepted"
+)
+
+// Errors of accepting an invitation.
+var (
+	// ErrInvalidCode is returned for codes that are forged, malformed or
+	// expired, and for invitations that are gone.
+	ErrInvalidCode = apperr.Invalid("INVALID_INVITATION", "code", "The invitation is invalid or has expired")
+
+	// ErrOwnInvitation refuses inviters accepting their own invitation.
+	ErrOwnInvitation = apperr.Invalid("OWN_INVITATION", "code", "You cannot accept your own invitation")
+
+	// ErrExistingAccount refuses accounts created before the invitation.
+	ErrExistingAccount = apperr.Invalid("EXISTING_ACCOUNT", "code", "Invitations are for accounts created after them")
+
+	ErrAccepted        = &apperr.Error{Kind: apperr.KindConflict, Code: "INVITATION_ACCEPTED", Message: "The invitation was accepted already"}
+	ErrAlreadyReferred = &apperr.Error{Kind: apperr.KindConflict, Code: "ALREADY_REFERRED", Message: "The account accepted an invitation already"}
+)
+
+// Invitation is the model of an invitation.
+type Invitation struct {
+	ID         string `dynamodbav:"invitation_id" json:"invitation_id"`
+	InviterID  string `dynamodbav:"user_id" json:"inviter_id"`
+	Email      string `dynamodbav:"email" json:"email"` // normalized
+	Status     string `dynamodbav:"status" json:"status"`
+	CreatedAt  string `dynamodbav:"created_at" json:"created_at"` // RFC 3339
+	ExpiresAt  string `dynamodbav:"expires_at" json:"expires_at"` // RFC 3339
+	AcceptedBy string `dynamodbav:"accepted_by,omitempty" json:"accepted_by,omitempty"`
+	AcceptedAt string `dynamodbav:"accepted_at,omitempty" json:"accepted_at,omitempty"` // RFC 3339
+}
+
+// NewID returns a random invitation ID.
+func NewID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Code is what the code of an invitation holds.
+type Code struct {
+	InviterID    string    `json:"u"`
+	InvitationID string    `json:"i"`
+	ExpiresAt    time.Time `json:"e"`
+}
+
+// Codec signs and verifies codes. Rotating the invitation_key secret
+// invalidates the codes in flight.
+type Codec struct {
+	Signer signing.Signer
+	Now    func() time.Time // time.Now when nil
+}
+
+// NewCodec returns a codec signing with the invitation_key secret of keys.
+func NewCodec(keys signing.Keys) *Codec {
+	return &Codec{Signer: signing.Signer{Keys: keys, Secret: SecretName}}
+}
+
+// NewCodecFromConfig returns the codec signing with the container's secrets
+// cache.
+func NewCodecFromConfig(ctx context.Context, cfg *config.Config) (*Codec, error) {
+	cache, err := secrets.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating secrets cache: %w", err)
+	}
+	return NewCodec(cache), nil
+}
+
+// Encode returns the signed form of code.
+func (c *Codec) Encode(ctx context.Context, code Code) (string, error) {
+	raw, err := json.Marshal(code)
+	if err != nil {
+		return "", err
+	}
+	return c.Signer.Sign(ctx, raw)
+}
+
+// Decode returns what code holds, or ErrInvalidCode when it was not signed
+// with the current key or has expired.
+func (c *Codec) Decode(ctx context.Context, code string) (Code, error) {
+	raw, err := c.Signer.Open(ctx, code)
+	if errors.Is(err, signing.ErrInvalid) {
+		return Code{}, ErrInvalidCode
+	}
+	if err != nil {
+		return Code{}, err
+	}
+
+	var out Code
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&out); err != nil || out.InviterID == "" || out.InvitationID == "" {
+		return Code{}, ErrInvalidCode
+	}
+	if !c.now().Before(out.ExpiresAt) {
+		return Code{}, ErrInvalidCode
+	}
+	return out, nil
+}
+
+func (c *Codec) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// Store reads and writes invitations.
+type Store struct {
+	Data      *userdata.Store
+	UserTable string // referrals are recorded on user records
+	Counters  *counters.Store
+}
+
+// NewStore returns a store over the tables named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{
+		Data:      userdata.NewStore(client, cfg),
+		UserTable: cfg.UserTableName,
+		Counters:  counters.NewStore(client, cfg),
+	}
+}
+
+// Create stores inv, new, and counts it as sent on the day of its creation.
+func (s *Store) Create(ctx context.Context, inv Invitation) error {
+	if err := s.Data.Put(ctx, userdata.Invitation, inv.InviterID, userdata.InviteSK(inv.ID), inv); err != nil {
+		return err
+	}
+	s.count(ctx, sentCounter(date(inv.CreatedAt)))
+	return nil
+}
+
+// Get returns invitation id of inviterID, or nil if there is none.
+func (s *Store) Get(ctx context.Context, inviterID, id string) (*Invitation, error) {
+	item, err := s.Data.Get(ctx, inviterID, userdata.InviteSK(id))
+	if err != nil || item == nil {
+		return nil, err
+	}
+	inv, err := userdata.Unmarshal[Invitation](item, userdata.Invitation)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// Accept marks inv, pending, accepted by inviteeID, and records the
+// referral on the user records of both. It fails with ErrAccepted when inv
+// was accepted already, ErrAlreadyReferred when the invitee accepted
+// another invitation, and ErrInvalidCode when the inviter is gone, writing
+// nothing.
+func (s *Store) Accept(ctx context.Context, inv Invitation, inviteeID string, now time.Time) error {
+	at := &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)}
+	err := s.Data.DB.Transact(ctx,
+		db.Write{
+			Item: types.TransactWriteItem{Update: &types.Update{
+				TableName:                aws.String(s.Data.Table),
+				Key:                      userdata.Key(inv.InviterID, userdata.InviteSK(inv.ID)),
+				UpdateExpression:         aws.String("SET #status = :accepted, accepted_by = :invitee, accepted_at = :at"),
+				ConditionExpression:      aws.String("#status = :pending"),
+				ExpressionAttributeNames: map[string]string{"#status": "status"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":accepted": &types.AttributeValueMemberS{Value: StatusAccepted},
+					":pending":  &types.AttributeValueMemberS{Value: StatusPending},
+					":invitee":  &types.AttributeValueMemberS{Value: inviteeID},
+					":at":       at,
+				},
+			}},
+			Conflict: ErrAccepted,
+		},
+		db.Write{
+			Item: types.TransactWriteItem{Update: &types.Update{
+				TableName:        aws.String(s.UserTable),
+				Key:              users.Key(inviteeID),
+				UpdateExpression: aws.String("SET referred_by = :inviter, referred_at = :at"),
+				ConditionExpression: aws.String("attribute_exists(user_id) AND attribute_not_exists(referred_by) AND attribute_not_exists(" +
+					users.DeletedAttribute + ")"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":inviter": &types.AttributeValueMemberS{Value: inv.InviterID},
+					":at":      at,
+				},
+			}},
+			Conflict: ErrAlreadyReferred,
+		},
+		db.Write{
+			Item: types.TransactWriteItem{Update: &types.Update{
+				TableName:           aws.String(s.UserTable),
+				Key:                 users.Key(inv.InviterID),
+				UpdateExpression:    aws.String("ADD referral_count :one"),
+				ConditionExpression: aws.String("attribute_exists(user_id) AND attribute_not_exists(" + users.DeletedAttribute + ")"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one": &types.AttributeValueMemberN{Value: "1"},
+				},
+			}},
+			Conflict: ErrInvalidCode,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	s.count(ctx, acceptedCounter(date(at.Value)))
+	return nil
+}
+
+// count adds one to the counter name. The counters are approximate anyway,
+// so a failure is logged rather than failing a write that went through.
+func (s *Store) count(ctx context.Context, name string) {
+	if err := s.Counters.Add(ctx, map[string]int64{name: 1}); err != nil {
+		slog.WarnContext(ctx, "Failed to count invitation", "counter", name, logging.Err(err))
+	}
+}
+
+// Day is the funnel of one day: the invitations sent on it, and those
+// accepted on it, whenever they were sent.
+type Day struct {
+	Date     string `json:"date"` // YYYY-MM-DD, UTC
+	Sent     int64  `json:"sent"`
+	Accepted int64  `json:"accepted"`
+}
+
+// Funnel returns the funnel of each day from from to to, both included, in
+// order.
+func (s *Store) Funnel(ctx context.Context, from, to time.Time) ([]Day, error) {
+	var days []Day
+	var names []string
+	for d := from.UTC().Truncate(24 * time.Hour); !d.After(to); d = d.AddDate(0, 0, 1) {
+		date := d.Format(time.DateOnly)
+		days = append(days, Day{Date: date})
+		names = append(names, sentCounter(date), acceptedCounter(date))
+	}
+	if len(names) == 0 {
+		return days, nil
+	}
+	values, err := s.Counters.GetMany(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	for i := range days {
+		days[i].Sent = values[sentCounter(days[i].Date)]
+		days[i].Accepted = values[acceptedCounter(days[i].Date)]
+	}
+	return days, nil
+}
+
+// date returns the UTC date of ts, an RFC 3339 time.
+func date(ts string) string {
+	t, _ := time.Parse(time.RFC3339, ts)
+	return t.UTC().Format(time.DateOnly)
+}
+
+// sentCounter and acceptedCounter name the counters of the invitations
+// sent and accepted on date, YYYY-MM-DD.
+func sentCounter(date string) string     { return "invitations#sent#" + date }
+func acceptedCounter(date string) string { return "invitations#accepted#" + date }
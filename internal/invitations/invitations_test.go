@@ -0,0 +1,150 @@
+package invitations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/signing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	c := &Codec{Signer: signing.Signer{Keys: signing.StaticKey("secret"), Secret: SecretName}, Now: func() time.Time { return now }}
+	want := Code{InviterID: "u1", InvitationID: "i1", ExpiresAt: now.Add(TTL)}
+
+	code, err := c.Encode(ctx, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Decode(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.InviterID != want.InviterID || got.InvitationID != want.InvitationID || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("Decode = %+v, want %+v", got, want)
+	}
+
+	now = now.Add(TTL)
+	if _, err := c.Decode(ctx, code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Decode(expired): err = %v", err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	ctx := context.Background()
+	c := NewCodec(signing.StaticKey("secret"))
+	code, err := c.Encode(ctx, Code{InviterID: "u1", InvitationID: "i1", ExpiresAt: time.Now().Add(TTL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(code, ".")
+	other, err := NewCodec(signing.StaticKey("other")).Encode(ctx, Code{InviterID: "u2", InvitationID: "i2", ExpiresAt: time.Now().Add(TTL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	for name, code := range map[string]string{
+		"empty":           "",
+		"no signature":    payload,
+		"swapped payload": otherPayload + "." + sig,
+		"other key":       other,
+		"truncated":       code[:len(code)-2],
+	} {
+		if _, err := c.Decode(ctx, code); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("%s: err = %v, want ErrInvalidCode", name, err)
+		}
+	}
+}
+
+func testStore(m *dbtest.Mock) *Store {
+	return NewStore(m.Client(), &config.Config{UserDataTableName: "user-data", UserTableName: "users", CounterTableName: "counters"})
+}
+
+func TestAccept(t *testing.T) {
+	tests := []struct {
+		name  string
+		txErr error
+		want  error
+	}{
+		{name: "accepted"},
+		{name: "invitation used", txErr: dbtest.TransactionCanceled("ConditionalCheckFailed", "None", "None"), want: ErrAccepted},
+		{name: "invitee referred", txErr: dbtest.TransactionCanceled("None", "ConditionalCheckFailed", "None"), want: ErrAlreadyReferred},
+		{name: "inviter gone", txErr: dbtest.TransactionCanceled("None", "None", "ConditionalCheckFailed"), want: ErrInvalidCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tx *dynamodb.TransactWriteItemsInput
+			m := &dbtest.Mock{TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				tx = in
+				return &dynamodb.TransactWriteItemsOutput{}, tt.txErr
+			}}
+			inv := Invitation{ID: "i1", InviterID: "u1", Status: StatusPending}
+			now := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+
+			err := testStore(m).Accept(context.Background(), inv, "u2", now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Accept = %v, want %v", err, tt.want)
+			}
+			if len(tx.TransactItems) != 3 {
+				t.Fatalf("transaction has %d items, want 3", len(tx.TransactItems))
+			}
+			if tt.want != nil {
+				if ops := m.Ops(); !reflect.DeepEqual(ops, []string{"TransactWriteItems"}) {
+					t.Errorf("ops = %v, want the transaction only", ops)
+				}
+				return
+			}
+
+			referral := tx.TransactItems[1].Update
+			if aws.ToString(referral.TableName) != "users" || referral.ExpressionAttributeValues[":inviter"].(*types.AttributeValueMemberS).Value != "u1" {
+				t.Errorf("referral update = %+v, want referred_by u1 on the invitee", referral)
+			}
+			if ops := m.Ops(); !reflect.DeepEqual(ops, []string{"TransactWriteItems", "UpdateItem"}) {
+				t.Fatalf("ops = %v, want the transaction and the counter", ops)
+			}
+			counter := m.Calls[1].Input.(*dynamodb.UpdateItemInput)
+			if name := counter.Key["counter"].(*types.AttributeValueMemberS).Value; name != "invitations#accepted#2026-10-14" {
+				t.Errorf("counter = %q, want the accepted counter of the day", name)
+			}
+		})
+	}
+}
+
+func TestFunnel(t *testing.T) {
+	m := &dbtest.Mock{BatchGetItemFunc: func(in *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+		if n := len(in.RequestItems["counters"].Keys); n != 6 {
+			t.Errorf("read %d counters, want 6", n)
+		}
+		return &dynamodb.BatchGetItemOutput{Responses: map[string][]db.Item{"counters": {
+			{"counter": &types.AttributeValueMemberS{Value: "invitations#sent#2026-10-12"}, "value": &types.AttributeValueMemberN{Value: "4"}},
+			{"counter": &types.AttributeValueMemberS{Value: "invitations#accepted#2026-10-14"}, "value": &types.AttributeValueMemberN{Value: "1"}},
+		}}}, nil
+	}}
+	to := time.Date(2026, 10, 14, 18, 0, 0, 0, time.UTC)
+
+	got, err := testStore(m).Funnel(context.Background(), to.AddDate(0, 0, -2), to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Day{
+		{Date: "2026-10-12", Sent: 4},
+		{Date: "2026-10-13"},
+		{Date: "2026-10-14", Accepted: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Funnel = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,554 @@
+// Package leaderboard keeps the best scores of users and ranks them, on the
+// boards named in LEADERBOARDS, each with an all-time and a weekly period.
+//
+// The leaderboard table is keyed by board, "<name>#<period>" with period
+// "global" or an ISO week such as "2026-W41", and user_id, and holds each
+// user's best score of the period. A best is only ever raised, by one
+// conditional update, so concurrent or replayed submissions cannot lower it.
+//
+// Ranks come from the score GSI, keyed by shard and sorted by score. Each
+// board is written across Shards shards, "<board>#<n>" with n derived from
+// the user ID, so a busy board does not concentrate its writes on one index
+// partition; the top of a board merges the tops of its shards, and a user's
+// rank counts the higher scores in every shard. Equal scores share a rank.
+//
+// Weeks run from Monday to Sunday in UTC. Once a week ends, the scheduled
+// rolloverLeaderboards function stores its final standings (see Close), and
+// the week's entries expire through the expires_at TTL attribute later.
+package leaderboard
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/fanout"
+)
+
+// Periods of a board.
+const (
+	Global = "global" // all time
+	Weekly = "weekly" // the current ISO week
+)
+
+const (
+	// MaxScore is the highest score accepted, the largest integer clients
+	// decode from JSON without losing precision.
+	MaxScore = 1<<53 - 1
+
+	// ResultSize is the number of entries kept in the final standings of a
+	// week.
+	ResultSize = 100
+
+	// weeklyRetention is how long the entries of a week outlive it.
+	weeklyRetention = 28 * 24 * time.Hour
+
+	// resultsKey is the user_id of the final standings of a week. User IDs
+	// cannot contain "#", so it never names a user's entry.
+	resultsKey = "#RESULTS"
+
+	// concurrency bounds the parallel reads of one call.
+	concurrency = 10
+)
+
+// Error codes of invalid submissions.
+const (
+	CodeScoreRequired = "SCORE_REQUIRED"
+	CodeScoreInvalid  = "SCORE_INVALID"
+)
+
+// Entry is a user's best score on a board, and its rank.
+type Entry struct {
+	Rank       int       `json:"rank" dynamodbav:"rank"`
+	UserID     string    `json:"user_id" dynamodbav:"user_id"`
+	Score      int64     `json:"score" dynamodbav:"score"`
+	AchievedAt time.Time `json:"achieved_at" dynamodbav:"achieved_at"`
+}
+
+// Best is the outcome of a submission for one period.
+type Best struct {
+	Period  string `json:"period"`         // Global or Weekly
+	Week    string `json:"week,omitempty"` // of the Weekly period
+	Score   int64  `json:"score"`          // the best score of the period, maybe the submitted one
+	NewBest bool   `json:"new_best"`
+}
+
+// record is a user's item of the leaderboard table.
+type record struct {
+	UserID     string `dynamodbav:"user_id"`
+	Score      int64  `dynamodbav:"score"`
+	AchievedAt string `dynamodbav:"achieved_at"` // RFC 3339
+}
+
+func (r *record) entry() Entry {
+	e := Entry{UserID: r.UserID, Score: r.Score}
+	e.AchievedAt, _ = time.Parse(time.RFC3339, r.AchievedAt)
+	return e
+}
+
+// results is the item holding the final standings of a week.
+type results struct {
+	Board     string  `dynamodbav:"board"`
+	UserID    string  `dynamodbav:"user_id"` // resultsKey
+	Standings []Entry `dynamodbav:"standings"`
+	ClosedAt  string  `dynamodbav:"closed_at"` // RFC 3339
+}
+
+// Store reads and writes the leaderboard table.
+type Store struct {
+	DB         *db.Client
+	Table      string
+	ScoreIndex string   // GSI keyed by shard, sorted by score
+	Boards     []string // names of the boards scores are accepted for
+	Shards     int      // write shards of each board
+}
+
+// NewStore returns a store over the leaderboard table and boards named in
+// cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{
+		DB:         client,
+		Table:      cfg.LeaderboardTableName,
+		ScoreIndex: cfg.LeaderboardScoreIndexName,
+		Boards:     cfg.Leaderboards,
+		Shards:     cfg.LeaderboardShards,
+	}
+}
+
+// Check reports apperr.NotFound unless name is one of the configured boards.
+func (s *Store) Check(name string) error {
+	if !slices.Contains(s.Boards, name) {
+		return apperr.NotFound("Leaderboard not found")
+	}
+	return nil
+}
+
+// CheckScore validates a submitted score.
+func CheckScore(score int64) error {
+	if score < 0 || score > MaxScore {
+		return apperr.Invalid(CodeScoreInvalid, "score", fmt.Sprintf("score must be between 0 and %d", int64(MaxScore)))
+	}
+	return nil
+}
+
+// Board returns the partition of a period of board name: Global, or a week
+// as returned by Week.
+func Board(name, period string) string {
+	return name + "#" + period
+}
+
+// Week returns the ISO week of t in UTC, e.g. "2026-W41".
+func Week(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// WeekStart returns the Monday, 00:00 UTC, beginning week, which must be
+// formatted as by Week.
+func WeekStart(week string) (time.Time, error) {
+	var year, n int
+	if _, err := fmt.Sscanf(week, "%4d-W%2d", &year, &n); err != nil {
+		return time.Time{}, fmt.Errorf("invalid week %q", week)
+	}
+	// January 4 is always in week 1
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	start := jan4.AddDate(0, 0, -(int(jan4.Weekday())+6)%7+(n-1)*7)
+	if Week(start) != week {
+		return time.Time{}, fmt.Errorf("invalid week %q", week)
+	}
+	return start, nil
+}
+
+// Submit records score as a result of userID on board name, raising their
+// best of all time and of the week of now where it is higher. Equal scores
+// keep the earlier best, so ties rank whoever got there first. The periods
+// are updated one after the other; as a best is never lowered, a failed
+// submission is safely submitted again.
+func (s *Store) Submit(ctx context.Context, name, userID string, score int64, now time.Time) ([]Best, error) {
+	week := Week(now)
+	start, _ := WeekStart(week)
+
+	global, err := s.raise(ctx, Board(name, Global), userID, score, now, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	weekly, err := s.raise(ctx, Board(name, week), userID, score, now, start.AddDate(0, 0, 7).Add(weeklyRetention))
+	if err != nil {
+		return nil, err
+	}
+	global.Period = Global
+	weekly.Period, weekly.Week = Weekly, week
+	return []Best{global, weekly}, nil
+}
+
+// raise sets the best of userID on board to score unless it is already at
+// least as high. A non-zero expires sets the item's TTL.
+func (s *Store) raise(ctx context.Context, board, userID string, score int64, now, expires time.Time) (Best, error) {
+	update := "SET score = :score, achieved_at = :now, shard = :shard"
+	values := map[string]types.AttributeValue{
+		":score": &types.AttributeValueMemberN{Value: fmt.Sprint(score)},
+		":now":   &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		":shard": &types.AttributeValueMemberS{Value: s.shard(board, userID)},
+	}
+	if !expires.IsZero() {
+		update += ", expires_at = :expires_at"
+		values[":expires_at"] = &types.AttributeValueMemberN{Value: fmt.Sprint(expires.Unix())}
+	}
+
+	start := time.Now()
+	_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                           aws.String(s.Table),
+		Key:                                 key(board, userID),
+		UpdateExpression:                    aws.String(update),
+		ConditionExpression:                 aws.String("attribute_not_exists(score) OR score < :score"),
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	db.Observe(ctx, start, err)
+
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		var rec record
+		if err := attributevalue.UnmarshalMap(failed.Item, &rec); err != nil {
+			return Best{}, fmt.Errorf("decoding best score: %w", err)
+		}
+		return Best{Score: rec.Score}, nil
+	}
+	if err != nil {
+		return Best{}, db.Wrap(err, "submitting score")
+	}
+	return Best{Score: score, NewBest: true}, nil
+}
+
+// Top returns the limit highest entries of board, ranked. Which of the
+// users tied at the last rank make the cut is arbitrary.
+func (s *Store) Top(ctx context.Context, board string, limit int) ([]Entry, error) {
+	var mu sync.Mutex
+	var entries []Entry
+	err := fanout.Each(ctx, concurrency, s.shards(board), func(ctx context.Context, shard string) error {
+		items, err := s.DB.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(s.Table),
+			IndexName:              aws.String(s.ScoreIndex),
+			KeyConditionExpression: aws.String("shard = :shard"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":shard": &types.AttributeValueMemberS{Value: shard},
+			},
+			ScanIndexForward: aws.Bool(false),
+			Limit:            aws.Int32(int32(limit)),
+		})
+		if err != nil {
+			return err
+		}
+		var recs []record
+		if err := attributevalue.UnmarshalListOfMaps(items, &recs); err != nil {
+			return fmt.Errorf("decoding scores: %w", err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rec := range recs {
+			entries = append(entries, rec.entry())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rank(entries)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	if entries == nil {
+		entries = []Entry{}
+	}
+	return entries, nil
+}
+
+// Rank returns the entry of userID on board, ranked among everyone on it,
+// or nil when they have none. The rank counts the higher scores of every
+// shard, which reads the whole of the board above the user.
+func (s *Store) Rank(ctx context.Context, board, userID string) (*Entry, error) {
+	item, err := s.DB.GetItem(ctx, s.Table, key(board, userID))
+	if err != nil || item == nil {
+		return nil, err
+	}
+	var rec record
+	if err := attributevalue.UnmarshalMap(item, &rec); err != nil {
+		return nil, fmt.Errorf("decoding score: %w", err)
+	}
+	e := rec.entry()
+
+	var mu sync.Mutex
+	higher := 0
+	err = fanout.Each(ctx, concurrency, s.shards(board), func(ctx context.Context, shard string) error {
+		n, err := s.countAbove(ctx, shard, e.Score)
+		mu.Lock()
+		defer mu.Unlock()
+		higher += n
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.Rank = higher + 1
+	return &e, nil
+}
+
+// countAbove counts the entries of shard with a score higher than score.
+func (s *Store) countAbove(ctx context.Context, shard string, score int64) (int, error) {
+	paginator := dynamodb.NewQueryPaginator(s.DB.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.ScoreIndex),
+		KeyConditionExpression: aws.String("shard = :shard AND score > :score"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":shard": &types.AttributeValueMemberS{Value: shard},
+			":score": &types.AttributeValueMemberN{Value: fmt.Sprint(score)},
+		},
+		Select: types.SelectCount,
+	})
+	n := 0
+	for paginator.HasMorePages() {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		db.Observe(ctx, start, err)
+		if err != nil {
+			return 0, db.Wrap(err, "counting scores")
+		}
+		n += int(page.Count)
+	}
+	return n, nil
+}
+
+// Among returns the entries of userIDs on board, ranked among themselves;
+// users without an entry are left out. This is the friends-only board.
+func (s *Store) Among(ctx context.Context, board string, userIDs []string) ([]Entry, error) {
+	keys := make([]db.Item, len(userIDs))
+	for i, id := range userIDs {
+		keys[i] = key(board, id)
+	}
+	items, err := s.DB.BatchGet(ctx, s.Table, keys)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(items))
+	for _, item := range items {
+		var rec record
+		if err := attributevalue.UnmarshalMap(item, &rec); err != nil {
+			return nil, fmt.Errorf("decoding score: %w", err)
+		}
+		entries = append(entries, rec.entry())
+	}
+	rank(entries)
+	return entries, nil
+}
+
+// Close stores the final standings of week on board name, its ResultSize
+// highest entries, and reports whether it did: a week is closed once, so
+// the standings do not change when the rollover runs again.
+func (s *Store) Close(ctx context.Context, name, week string, now time.Time) (bool, error) {
+	board := Board(name, week)
+	standings, err := s.Top(ctx, board, ResultSize)
+	if err != nil {
+		return false, err
+	}
+	item, err := attributevalue.MarshalMap(results{
+		Board:     board,
+		UserID:    resultsKey,
+		Standings: standings,
+		ClosedAt:  now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	_, err = s.DB.DynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(board)"),
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return false, nil
+	}
+	if err != nil {
+		return false, db.Wrap(err, "closing week")
+	}
+	return true, nil
+}
+
+// Standings returns the final standings of a closed week of board name. A
+// week that is not closed is apperr.NotFound.
+func (s *Store) Standings(ctx context.Context, name, week string) ([]Entry, error) {
+	item, err := s.DB.GetItem(ctx, s.Table, key(Board(name, week), resultsKey))
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, apperr.NotFound("Leaderboard not found")
+	}
+	var res results
+	if err := attributevalue.UnmarshalMap(item, &res); err != nil {
+		return nil, fmt.Errorf("decoding standings: %w", err)
+	}
+	if res.Standings == nil {
+		res.Standings = []Entry{}
+	}
+	return res.Standings, nil
+}
+
+// Conflicts of the move of an entry to another account.
+var (
+	errEntryGone   = errors.New("entry already moved")
+	errEntryBeaten = errors.New("surviving account has a higher score")
+)
+
+// Move re-parents the entries of from, a duplicate account merged into to,
+// on every board, of all time and of each week whose entries have not
+// expired at now, and returns how many it moved and dropped. Each moves in
+// one transaction, which raises the best of to to it, expiry included, and
+// deletes the entry of from; an entry no higher than the best of to is only
+// deleted, and dropped. The final standings of closed weeks are left as
+// they are. With dryRun nothing is written.
+func (s *Store) Move(ctx context.Context, from, to string, now time.Time, dryRun bool) (moved, dropped int, err error) {
+	periods := []string{Global}
+	for t := now; t.After(now.Add(-7*24*time.Hour - weeklyRetention)); t = t.AddDate(0, 0, -7) {
+		periods = append(periods, Week(t))
+	}
+	for _, name := range s.Boards {
+		for _, period := range periods {
+			board := Board(name, period)
+			item, err := s.DB.GetItem(ctx, s.Table, key(board, from))
+			if err != nil {
+				return moved, dropped, err
+			}
+			if item == nil {
+				continue
+			}
+
+			if dryRun {
+				beaten, err := s.beaten(ctx, board, to, item)
+				if err != nil {
+					return moved, dropped, err
+				}
+				if beaten {
+					dropped++
+				} else {
+					moved++
+				}
+				continue
+			}
+			err = s.move(ctx, board, from, to, item)
+			switch {
+			case errors.Is(err, errEntryGone):
+			case errors.Is(err, errEntryBeaten):
+				dropped++
+			case err != nil:
+				return moved, dropped, err
+			default:
+				moved++
+			}
+		}
+	}
+	return moved, dropped, nil
+}
+
+// move moves item, the entry of from on board, to to. An entry beaten by
+// theirs is deleted alone and reported as errEntryBeaten.
+func (s *Store) move(ctx context.Context, board, from, to string, item db.Item) error {
+	remove := db.Write{Item: types.TransactWriteItem{Delete: &types.Delete{
+		TableName:           aws.String(s.Table),
+		Key:                 key(board, from),
+		ConditionExpression: aws.String("attribute_exists(score)"),
+	}}, Conflict: errEntryGone}
+	moving := maps.Clone(item)
+	moving["user_id"] = &types.AttributeValueMemberS{Value: to}
+	moving["shard"] = &types.AttributeValueMemberS{Value: s.shard(board, to)}
+
+	err := s.DB.Transact(ctx, remove, db.Write{Item: types.TransactWriteItem{Put: &types.Put{
+		TableName:                 aws.String(s.Table),
+		Item:                      moving,
+		ConditionExpression:       aws.String("attribute_not_exists(score) OR score < :score"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":score": item["score"]},
+	}}, Conflict: errEntryBeaten})
+	if !errors.Is(err, errEntryBeaten) {
+		return err
+	}
+	if err := s.DB.Transact(ctx, remove); err != nil {
+		return err
+	}
+	return errEntryBeaten
+}
+
+// beaten reports whether the entry of userID on board has a score at least
+// as high as that of item.
+func (s *Store) beaten(ctx context.Context, board, userID string, item db.Item) (bool, error) {
+	best, err := s.DB.GetItem(ctx, s.Table, key(board, userID))
+	if err != nil || best == nil {
+		return false, err
+	}
+	var theirs, ours record
+	if err := attributevalue.UnmarshalMap(best, &theirs); err != nil {
+		return false, fmt.Errorf("decoding score: %w", err)
+	}
+	if err := attributevalue.UnmarshalMap(item, &ours); err != nil {
+		return false, fmt.Errorf("decoding score: %w", err)
+	}
+	return theirs.Score >= ours.Score, nil
+}
+
+// shard returns the write shard of userID's entry on board.
+func (s *Store) shard(board, userID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return fmt.Sprintf("%s#%d", board, h.Sum32()%uint32(s.Shards))
+}
+
+// shards returns every write shard of board.
+func (s *Store) shards(board string) []string {
+	shards := make([]string, s.Shards)
+	for i := range shards {
+		shards[i] = fmt.Sprintf("%s#%d", board, i)
+	}
+	return shards
+}
+
+// rank sorts entries, highest score first and earlier bests first among
+// equal scores, and numbers them. Equal scores share a rank, and the next
+// rank skips as many: 1, 2, 2, 4.
+func rank(entries []Entry) {
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), a.AchievedAt.Compare(b.AchievedAt), strings.Compare(a.UserID, b.UserID))
+	})
+	for i := range entries {
+		if i > 0 && entries[i].Score == entries[i-1].Score {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+}
+
+// key returns the primary key of an item of the leaderboard table.
+func key(board, userID string) db.Item {
+	return db.Item{
+		"board":   &types.AttributeValueMemberS{Value: board},
+		"user_id": &types.AttributeValueMemberS{Value: userID},
+	}
+}
@@ -0,0 +1,271 @@
+package leaderboard
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+// score returns the item of a user's best score.
+func score(userID, n, at string) db.Item {
+	item := dbtest.Item("user_id", userID, "achieved_at", at)
+	item["score"] = &types.AttributeValueMemberN{Value: n}
+	return item
+}
+
+func str(av types.AttributeValue) string {
+	s, _ := av.(*types.AttributeValueMemberS)
+	if s == nil {
+		return ""
+	}
+	return s.Value
+}
+
+func TestWeek(t *testing.T) {
+	tests := []struct {
+		week    string
+		start   string
+		wantErr bool
+	}{
+		{week: "2026-W42", start: "2026-10-12"},
+		{week: "2026-W01", start: "2025-12-29"},
+		{week: "2020-W53", start: "2020-12-28"},
+		{week: "2025-W53", wantErr: true},
+		{week: "2026-W00", wantErr: true},
+		{week: "2026-W4", wantErr: true},
+		{week: "2026-W42x", wantErr: true},
+		{week: "last", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.week, func(t *testing.T) {
+			start, err := WeekStart(tt.week)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := start.Format(time.DateOnly); got != tt.start {
+				t.Errorf("start = %s, want %s", got, tt.start)
+			}
+			if got := Week(start.Add(7*24*time.Hour - time.Second)); got != tt.week {
+				t.Errorf("week of the last second = %s, want %s", got, tt.week)
+			}
+		})
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	m := &dbtest.Mock{UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		// The all-time best is higher already, the week's is not
+		if str(in.Key["board"]) == "main#global" {
+			return nil, &types.ConditionalCheckFailedException{Item: score("u1", "900", "2026-01-01T00:00:00Z")}
+		}
+		return &dynamodb.UpdateItemOutput{}, nil
+	}}
+	s := &Store{DB: m.Client(), Table: "leaderboard", Shards: 4}
+
+	bests, err := s.Submit(context.Background(), "main", "u1", 500, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Best{
+		{Period: Global, Score: 900},
+		{Period: Weekly, Week: "2026-W42", Score: 500, NewBest: true},
+	}
+	if !slices.Equal(bests, want) {
+		t.Errorf("bests = %+v, want %+v", bests, want)
+	}
+
+	for _, c := range m.Calls {
+		in := c.Input.(*dynamodb.UpdateItemInput)
+		board := str(in.Key["board"])
+		if shard := str(in.ExpressionAttributeValues[":shard"]); !strings.HasPrefix(shard, board+"#") {
+			t.Errorf("%s: shard %q is not one of the board", board, shard)
+		}
+		_, expires := in.ExpressionAttributeValues[":expires_at"]
+		if expires != (board == "main#2026-W42") {
+			t.Errorf("%s: expires %v", board, expires)
+		}
+	}
+}
+
+func TestTop(t *testing.T) {
+	byShard := map[string][]db.Item{
+		"main#global#0": {score("u1", "300", "2026-10-02T00:00:00Z"), score("u2", "100", "2026-10-01T00:00:00Z")},
+		"main#global#1": {score("u3", "300", "2026-10-01T00:00:00Z"), score("u4", "200", "2026-10-01T00:00:00Z")},
+	}
+	m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		if aws.ToBool(in.ScanIndexForward) {
+			t.Error("query is not descending")
+		}
+		return &dynamodb.QueryOutput{Items: byShard[str(in.ExpressionAttributeValues[":shard"])]}, nil
+	}}
+	s := &Store{DB: m.Client(), Table: "leaderboard", ScoreIndex: "score-index", Shards: 3}
+
+	entries, err := s.Top(context.Background(), "main#global", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("%s:%d", e.UserID, e.Rank))
+	}
+	// u3 tied with u1 but got there first
+	if want := []string{"u3:1", "u1:1", "u4:3"}; !slices.Equal(got, want) {
+		t.Errorf("top = %v, want %v", got, want)
+	}
+	if n := len(m.Calls); n != 3 {
+		t.Errorf("%d queries, want one per shard", n)
+	}
+}
+
+func TestRank(t *testing.T) {
+	m := &dbtest.Mock{
+		GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			if str(in.Key["user_id"]) != "u1" {
+				return &dynamodb.GetItemOutput{}, nil
+			}
+			return &dynamodb.GetItemOutput{Item: score("u1", "250", "2026-10-01T00:00:00Z")}, nil
+		},
+		QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			if in.Select != types.SelectCount {
+				t.Error("rank query does not count")
+			}
+			// Two pages on the first shard, one on the others
+			if str(in.ExpressionAttributeValues[":shard"]) == "main#global#0" && in.ExclusiveStartKey == nil {
+				return &dynamodb.QueryOutput{Count: 5, LastEvaluatedKey: dbtest.Item("board", "main#global")}, nil
+			}
+			return &dynamodb.QueryOutput{Count: 2}, nil
+		},
+	}
+	s := &Store{DB: m.Client(), Table: "leaderboard", ScoreIndex: "score-index", Shards: 2}
+
+	e, err := s.Rank(context.Background(), "main#global", "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e == nil || e.Rank != 10 || e.Score != 250 {
+		t.Errorf("entry = %+v, want rank 10", e)
+	}
+
+	if e, err := s.Rank(context.Background(), "main#global", "u2"); err != nil || e != nil {
+		t.Errorf("entry of a user without a score = %+v, %v", e, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	now := time.Date(2026, 10, 19, 0, 5, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		putErr     error
+		wantClosed bool
+	}{
+		{name: "closes", wantClosed: true},
+		{name: "closed already", putErr: dbtest.ConditionFailed()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored db.Item
+			m := &dbtest.Mock{
+				QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					return &dynamodb.QueryOutput{Items: []db.Item{score("u1", "10", "2026-10-13T00:00:00Z")}}, nil
+				},
+				PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					stored = in.Item
+					return &dynamodb.PutItemOutput{}, tt.putErr
+				},
+			}
+			s := &Store{DB: m.Client(), Table: "leaderboard", ScoreIndex: "score-index", Shards: 1}
+
+			closed, err := s.Close(context.Background(), "main", "2026-W42", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if closed != tt.wantClosed {
+				t.Errorf("closed = %v, want %v", closed, tt.wantClosed)
+			}
+			if str(stored["board"]) != "main#2026-W42" || str(stored["user_id"]) != resultsKey {
+				t.Errorf("stored under %v", stored)
+			}
+			if _, shard := stored["shard"]; shard {
+				t.Error("standings are in the score index")
+			}
+
+			m.GetItemFunc = func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+				return &dynamodb.GetItemOutput{Item: stored}, nil
+			}
+			standings, err := s.Standings(context.Background(), "main", "2026-W42")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(standings) != 1 || standings[0].UserID != "u1" || standings[0].Rank != 1 {
+				t.Errorf("standings = %+v", standings)
+			}
+		})
+	}
+}
+
+func TestMove(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	week := Board("main", Week(now))
+	entries := map[string]db.Item{
+		"main#global/u1": score("u1", "10", "2026-01-01T00:00:00Z"),
+		"main#global/u2": score("u2", "20", "2026-02-01T00:00:00Z"), // beats u1
+		week + "/u1":     score("u1", "5", "2026-10-14T00:00:00Z"),
+	}
+	for k, item := range entries {
+		board, _, _ := strings.Cut(k, "/")
+		item["board"] = &types.AttributeValueMemberS{Value: board}
+	}
+	get := func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		return &dynamodb.GetItemOutput{Item: entries[str(in.Key["board"])+"/"+str(in.Key["user_id"])]}, nil
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		var txs []*dynamodb.TransactWriteItemsInput
+		m := &dbtest.Mock{
+			GetItemFunc: get,
+			TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				txs = append(txs, in)
+				if put := in.TransactItems; len(put) == 2 && str(put[1].Put.Item["board"]) == "main#global" {
+					return nil, dbtest.TransactionCanceled("None", "ConditionalCheckFailed")
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			},
+		}
+		s := &Store{DB: m.Client(), Table: "leaderboard", Boards: []string{"main"}, Shards: 4}
+		moved, dropped, err := s.Move(context.Background(), "u1", "u2", now, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved != 1 || dropped != 1 {
+			t.Errorf("dry run %v: moved %d, dropped %d; want the weekly entry moved and the global one dropped", dryRun, moved, dropped)
+		}
+		if dryRun {
+			if len(txs) != 0 {
+				t.Errorf("dry run wrote %d transactions", len(txs))
+			}
+			continue
+		}
+		// The global entry, beaten, is deleted alone
+		if len(txs) != 3 || len(txs[1].TransactItems) != 1 || txs[1].TransactItems[0].Delete == nil {
+			t.Fatalf("transactions = %+v", txs)
+		}
+		put := txs[2].TransactItems[1].Put.Item
+		if str(put["user_id"]) != "u2" || str(put["shard"]) != s.shard(week, "u2") || put["score"].(*types.AttributeValueMemberN).Value != "5" {
+			t.Errorf("moved entry = %v", put)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package legacy reads the accounts of the system troggle replaces, so the
+// userMigration trigger can move them into Cognito one by one as their
+// owners sign in or reset their password.
+//
+// The old system is behind a Verifier. The one in use talks to its auth API
+// over HTTPS, authenticated with the bearer token of the legacy_auth_token
+// secret:
+//
+//	POST <LEGACY_AUTH_URL>/authenticate  {"username": ..., "password": ...}
+//	POST <LEGACY_AUTH_URL>/lookup        {"username": ...}
+//
+// Both answer 200 with the Account as JSON, 404 for unknown users and 401
+// for a wrong password. Once no account is left to migrate, the trigger and
+// this package can go.
+package legacy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/secrets"
+	"troggle-backend/internal/tracing"
+)
+
+// SecretName is the secret, below SECRETS_PREFIX, holding the bearer token
+// of the legacy auth API.
+const SecretName = "legacy_auth_token"
+
+// Errors of a Verifier.
+var (
+	ErrNotFound           = errors.New("legacy account not found")
+	ErrInvalidCredentials = errors.New("invalid legacy credentials")
+)
+
+// Account is an account of the legacy system.
+type Account struct {
+	ID            string `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	DisplayName   string `json:"display_name"`
+}
+
+// Verifier checks credentials against the legacy system.
+type Verifier interface {
+	// Authenticate returns the account of username when password is its
+	// password, ErrNotFound when there is no such account and
+	// ErrInvalidCredentials when the password is wrong.
+	Authenticate(ctx context.Context, username, password string) (Account, error)
+
+	// Lookup returns the account of username, or ErrNotFound.
+	Lookup(ctx context.Context, username string) (Account, error)
+}
+
+// Keys returns the value of a secret; *secrets.Cache implements it.
+type Keys interface {
+	Get(ctx context.Context, name string) (string, error)
+	Refresh(ctx context.Context, name string) (string, error)
+}
+
+// HTTPVerifier is the Verifier of the legacy auth API.
+type HTTPVerifier struct {
+	URL  string // base URL, without a trailing "/"
+	Keys Keys
+	HTTP *http.Client
+}
+
+// New returns the verifier of the legacy auth API configured in cfg.
+func New(ctx context.Context, cfg *config.Config) (*HTTPVerifier, error) {
+	if err := cfg.RequireLegacyAuth(); err != nil {
+		return nil, err
+	}
+	keys, err := secrets.Shared(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := keys.Preload(ctx, SecretName); err != nil {
+		return nil, err
+	}
+	return &HTTPVerifier{
+		URL:  cfg.LegacyAuthURL,
+		Keys: keys,
+		HTTP: tracing.HTTPClient(&http.Client{Timeout: 3 * time.Second}),
+	}, nil
+}
+
+// Authenticate implements Verifier.
+func (v *HTTPVerifier) Authenticate(ctx context.Context, username, password string) (Account, error) {
+	return v.call(ctx, "/authenticate", map[string]string{"username": username, "password": password})
+}
+
+// Lookup implements Verifier.
+func (v *HTTPVerifier) Lookup(ctx context.Context, username string) (Account, error) {
+	return v.call(ctx, "/lookup", map[string]string{"username": username})
+}
+
+// call posts body to path and decodes the account answered. A 403 means
+// the token was rotated: it is refreshed and the call made once more.
+func (v *HTTPVerifier) call(ctx context.Context, path string, body map[string]string) (Account, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return Account{}, err
+	}
+	token, err := v.Keys.Get(ctx, SecretName)
+	if err != nil {
+		return Account{}, err
+	}
+	resp, raw, err := v.post(ctx, path, token, payload)
+	if err == nil && resp.StatusCode == http.StatusForbidden {
+		if token, err = v.Keys.Refresh(ctx, SecretName); err != nil {
+			return Account{}, err
+		}
+		resp, raw, err = v.post(ctx, path, token, payload)
+	}
+	if err != nil {
+		return Account{}, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var a Account
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return Account{}, fmt.Errorf("decoding legacy account: %w", err)
+		}
+		if a.ID == "" || a.Email == "" {
+			return Account{}, errors.New("legacy account without id or email")
+		}
+		return a, nil
+	case http.StatusNotFound:
+		return Account{}, ErrNotFound
+	case http.StatusUnauthorized:
+		return Account{}, ErrInvalidCredentials
+	case http.StatusTooManyRequests:
+		return Account{}, apperr.Throttled(fmt.Errorf("legacy auth %s: %s", path, resp.Status))
+	}
+	return Account{}, fmt.Errorf("legacy auth %s: %s: %.200s", path, resp.Status, raw)
+}
+
+// post sends one request and reads its response body.
+func (v *HTTPVerifier) post(ctx context.Context, path, token string, payload []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := v.HTTP.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("legacy auth %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading legacy auth response: %w", err)
+	}
+	return resp, raw, nil
+}
@@ -0,0 +1,90 @@
+package legacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// keys serves token, or rotated once refreshed.
+type keys struct {
+	token, rotated string
+	refreshed      bool
+}
+
+func (k *keys) Get(context.Context, string) (string, error) {
+	if k.refreshed {
+		return k.rotated, nil
+	}
+	return k.token, nil
+}
+
+func (k *keys) Refresh(context.Context, string) (string, error) {
+	k.refreshed = true
+	return k.rotated, nil
+}
+
+// server is a legacy auth API knowing jane, whose password is "hunter2",
+// and accepting the token "t2".
+func server(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t2" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case body["username"] != "jane":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/authenticate" && body["password"] != "hunter2":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			json.NewEncoder(w).Encode(Account{ID: "L42", Email: "jane@example.com", EmailVerified: true, DisplayName: "Jane"})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPVerifier(t *testing.T) {
+	ctx := context.Background()
+	srv := server(t)
+	v := &HTTPVerifier{URL: srv.URL, Keys: &keys{token: "t2"}, HTTP: srv.Client()}
+
+	a, err := v.Authenticate(ctx, "jane", "hunter2")
+	if err != nil || a.ID != "L42" || a.Email != "jane@example.com" || !a.EmailVerified {
+		t.Errorf("Authenticate = %+v, %v", a, err)
+	}
+	if _, err := v.Authenticate(ctx, "jane", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate(wrong password): err = %v", err)
+	}
+	if _, err := v.Authenticate(ctx, "joe", "hunter2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Authenticate(unknown): err = %v", err)
+	}
+	if a, err := v.Lookup(ctx, "jane"); err != nil || a.ID != "L42" {
+		t.Errorf("Lookup = %+v, %v", a, err)
+	}
+	if _, err := v.Lookup(ctx, "joe"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup(unknown): err = %v", err)
+	}
+}
+
+func TestHTTPVerifierRotatedToken(t *testing.T) {
+	srv := server(t)
+	k := &keys{token: "t1", rotated: "t2"}
+	v := &HTTPVerifier{URL: srv.URL, Keys: k, HTTP: srv.Client()}
+
+	if _, err := v.Lookup(context.Background(), "jane"); err != nil {
+		t.Fatal(err)
+	}
+	if !k.refreshed {
+		t.Error("token not refreshed after 403")
+	}
+}
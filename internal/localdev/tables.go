@@ -0,0 +1,244 @@
+// Package localdev creates the troggle tables in DynamoDB Local or
+// LocalStack, with the same keys and indexes as the deployed stacks, so the
+// backend can run without AWS.
+package localdev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+)
+
+// Tables returns the definitions of every table named in cfg.
+func Tables(cfg *config.Config) []*dynamodb.CreateTableInput {
+	return []*dynamodb.CreateTableInput{
+		{
+			TableName:            aws.String(cfg.UserTableName),
+			AttributeDefinitions: attrs("user_id", "email", "status", "created_at"),
+			KeySchema:            key("user_id", ""),
+			// The userStream function consumes the stream
+			StreamSpecification: &types.StreamSpecification{
+				StreamEnabled:  aws.Bool(true),
+				StreamViewType: types.StreamViewTypeNewAndOldImages,
+			},
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName:  aws.String(cfg.EmailIndexName),
+					KeySchema:  key("email", ""),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+				},
+				{
+					IndexName:  aws.String(cfg.StatusIndexName),
+					KeySchema:  key("status", "created_at"),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			},
+		},
+		table(cfg.SessionTableName, "user_id", "session_id"),
+		table(cfg.PreferenceTableName, "user_id", ""),
+		table(cfg.UserDataTableName, "pk", "sk"),
+		{
+			TableName:            aws.String(cfg.DeviceTableName),
+			AttributeDefinitions: attrs("user_id", "token"),
+			KeySchema:            key("user_id", "token"),
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName:  aws.String(cfg.DeviceTokenIndexName),
+					KeySchema:  key("token", ""),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+				},
+			},
+		},
+		{
+			TableName:            aws.String(cfg.APIKeyTableName),
+			AttributeDefinitions: attrs("key_hash", "key_id"),
+			KeySchema:            key("key_hash", ""),
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName:  aws.String(cfg.APIKeyIDIndexName),
+					KeySchema:  key("key_id", ""),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			},
+		},
+		table(cfg.IdempotencyTableName, "idempotency_key", ""),
+		table(cfg.RateLimitTableName, "bucket", ""),
+		table(cfg.SuppressionTableName, "email", ""),
+		table(cfg.CounterTableName, "counter", ""),
+		table(cfg.ExportTableName, "user_id", "export_id"),
+		table(cfg.RelationshipTableName, "user_id", "edge"),
+		{
+			TableName:            aws.String(cfg.ConnectionTableName),
+			AttributeDefinitions: attrs("connection_id", "user_id"),
+			KeySchema:            key("connection_id", ""),
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName:  aws.String(cfg.ConnectionUserIndexName),
+					KeySchema:  key("user_id", ""),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			},
+		},
+		{
+			TableName:            aws.String(cfg.MessageTableName),
+			AttributeDefinitions: attrs("conversation_id", "entry", "inbox", "last_message_at"),
+			KeySchema:            key("conversation_id", "entry"),
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName:  aws.String(cfg.MessageInboxIndexName),
+					KeySchema:  key("inbox", "last_message_at"),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			},
+		},
+		{
+			TableName: aws.String(cfg.LeaderboardTableName),
+			AttributeDefinitions: append(attrs("board", "user_id", "shard"),
+				types.AttributeDefinition{AttributeName: aws.String("score"), AttributeType: types.ScalarAttributeTypeN}),
+			KeySchema: key("board", "user_id"),
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName:  aws.String(cfg.LeaderboardScoreIndexName),
+					KeySchema:  key("shard", "score"),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			},
+		},
+		table(cfg.AchievementTableName, "achievement_id", ""),
+		table(cfg.UserAchievementTableName, "user_id", "achievement_id"),
+		table(cfg.MatchTableName, "match_id", ""),
+		table(cfg.PlayerTableName, "user_id", ""),
+		table(cfg.RatingHistoryTableName, "user_id", "entry"),
+		table(cfg.RoleTableName, "user_id", "role"),
+		table(cfg.ChallengeTableName, "user_id", ""),
+		{
+			TableName:            aws.String(cfg.NotificationTableName),
+			AttributeDefinitions: attrs("user_id", "notification_id", "inbox"),
+			KeySchema:            key("user_id", "notification_id"),
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName:  aws.String(cfg.NotificationInboxIndexName),
+					KeySchema:  key("user_id", "inbox"),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			},
+		},
+		{
+			TableName:            aws.String(cfg.TicketTableName),
+			AttributeDefinitions: attrs("user_id", "queue", "enqueued_at"),
+			KeySchema:            key("user_id", ""),
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName:  aws.String(cfg.TicketQueueIndexName),
+					KeySchema:  key("queue", "enqueued_at"),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			},
+		},
+		{
+			TableName: aws.String(cfg.WaitlistTableName),
+			AttributeDefinitions: append(attrs("email", "queue"),
+				types.AttributeDefinition{AttributeName: aws.String("position"), AttributeType: types.ScalarAttributeTypeN}),
+			KeySchema: key("email", ""),
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName:  aws.String(cfg.WaitlistQueueIndexName),
+					KeySchema:  key("queue", "position"),
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			},
+		},
+		{
+			TableName: aws.String(cfg.PolicyTableName),
+			AttributeDefinitions: append(attrs("policy"),
+				types.AttributeDefinition{AttributeName: aws.String("version"), AttributeType: types.ScalarAttributeTypeN}),
+			KeySchema: key("policy", "version"),
+		},
+		{
+			TableName:            aws.String(cfg.AuditTableName),
+			AttributeDefinitions: attrs("resource", "entry_id"),
+			KeySchema:            key("resource", "entry_id"),
+			// The auditArchive function archives expired entries from the stream
+			StreamSpecification: &types.StreamSpecification{
+				StreamEnabled:  aws.Bool(true),
+				StreamViewType: types.StreamViewTypeOldImage,
+			},
+		},
+	}
+}
+
+// CreateTables creates every missing table and waits until all are active.
+// Existing tables are left untouched.
+func CreateTables(ctx context.Context, client *dynamodb.Client, cfg *config.Config) error {
+	for _, input := range Tables(cfg) {
+		input.BillingMode = types.BillingModePayPerRequest
+
+		_, err := client.CreateTable(ctx, input)
+		var inUse *types.ResourceInUseException
+		if errors.As(err, &inUse) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("creating table %s: %w", aws.ToString(input.TableName), err)
+		}
+
+		waiter := dynamodb.NewTableExistsWaiter(client)
+		if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: input.TableName}, time.Minute); err != nil {
+			return fmt.Errorf("waiting for table %s: %w", aws.ToString(input.TableName), err)
+		}
+		slog.InfoContext(ctx, "Created table", "table", aws.ToString(input.TableName))
+	}
+	return nil
+}
+
+// DeleteTables drops every table named in cfg, ignoring missing ones.
+func DeleteTables(ctx context.Context, client *dynamodb.Client, cfg *config.Config) error {
+	var errs []error
+	for _, input := range Tables(cfg) {
+		_, err := client.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: input.TableName})
+		var missing *types.ResourceNotFoundException
+		if err != nil && !errors.As(err, &missing) {
+			errs = append(errs, fmt.Errorf("deleting table %s: %w", aws.ToString(input.TableName), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// table defines a table with a string hash key and optional string range key.
+func table(name, hash, rangeKey string) *dynamodb.CreateTableInput {
+	names := []string{hash}
+	if rangeKey != "" {
+		names = append(names, rangeKey)
+	}
+	return &dynamodb.CreateTableInput{
+		TableName:            aws.String(name),
+		AttributeDefinitions: attrs(names...),
+		KeySchema:            key(hash, rangeKey),
+	}
+}
+
+// attrs declares string key attributes.
+func attrs(names ...string) []types.AttributeDefinition {
+	defs := make([]types.AttributeDefinition, len(names))
+	for i, n := range names {
+		defs[i] = types.AttributeDefinition{AttributeName: aws.String(n), AttributeType: types.ScalarAttributeTypeS}
+	}
+	return defs
+}
+
+// key builds a key schema; rangeKey may be empty.
+func key(hash, rangeKey string) []types.KeySchemaElement {
+	schema := []types.KeySchemaElement{{AttributeName: aws.String(hash), KeyType: types.KeyTypeHash}}
+	if rangeKey != "" {
+		schema = append(schema, types.KeySchemaElement{AttributeName: aws.String(rangeKey), KeyType: types.KeyTypeRange})
+	}
+	return schema
+}
@@ -0,0 +1,200 @@
+// Package logging configures the structured JSON logger used by every troggle
+// Lambda.
+//
+// Each line carries the function name, the git SHA of the build (see
+// package buildinfo) and, when the context comes from a Lambda invocation,
+// the request ID, so CloudWatch Logs Insights queries can follow one
+// request across functions:
+//
+//	fields @timestamp, msg, latency_ms | filter request_id = "..."
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+
+	"troggle-backend/internal/buildinfo"
+)
+
+// EnvLevel selects the minimum level logged (debug, info, warn, error).
+const EnvLevel = "LOG_LEVEL"
+
+// output is where New writes; tests replace it.
+var output io.Writer = os.Stdout
+
+// ctxKey is the context key under which per-request attributes are stored.
+type ctxKey struct{}
+
+// scopeKey is the context key of the scope attributes are added to by Add.
+type scopeKey struct{}
+
+// scope holds the attributes added to one invocation.
+type scope struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// Init installs the JSON logger as the slog default. Output from the standard
+// log package (including the SDKs) is routed through it as well.
+func Init() {
+	slog.SetDefault(New())
+}
+
+// New returns a JSON logger writing to stdout. PII is masked (see Redact)
+// unless LOG_MASK_PII=false.
+func New() *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level: parseLevel(os.Getenv(EnvLevel)),
+	}
+	if maskingEnabled() {
+		opts.ReplaceAttr = redactAttr
+	}
+	handler := slog.NewJSONHandler(output, opts)
+
+	logger := slog.New(contextHandler{handler}).With("git_sha", buildinfo.Get().Commit)
+	if name := lambdacontext.FunctionName; name != "" {
+		logger = logger.With("function_name", name)
+	}
+	return logger
+}
+
+// With returns a context whose log lines carry the given attributes in
+// addition to any already attached.
+func With(ctx context.Context, args ...any) context.Context {
+	attrs := append(attrsFrom(ctx), argsToAttrs(args)...)
+	return context.WithValue(ctx, ctxKey{}, attrs)
+}
+
+// NewScope returns a context that Add can attach attributes to after the
+// fact: lines logged with it, or with any context derived from it, carry
+// the attributes added so far. httpx.Adapt sets one up per request, so the
+// summary line of a request carries what its handler added.
+func NewScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &scope{})
+}
+
+// Add attaches the given attributes to the scope of ctx, replacing any of
+// the same key. Without a scope it does nothing.
+func Add(ctx context.Context, args ...any) {
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range argsToAttrs(args) {
+		i := slices.IndexFunc(s.attrs, func(b slog.Attr) bool { return b.Key == a.Key })
+		if i < 0 {
+			s.attrs = append(s.attrs, a)
+		} else {
+			s.attrs[i] = a
+		}
+	}
+}
+
+// EmailHash returns an email_hash attribute: a short, stable SHA-256 digest
+// of the normalized address, so one user's lines can be correlated without
+// logging the address itself.
+func EmailHash(email string) slog.Attr {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return slog.String("email_hash", hex.EncodeToString(sum[:8]))
+}
+
+// Latency returns a latency_ms attribute measuring the time since start.
+func Latency(start time.Time) slog.Attr {
+	return slog.Int64("latency_ms", time.Since(start).Milliseconds())
+}
+
+// Err returns an error attribute.
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
+
+// Fatal logs msg at error level and exits. It is meant for cold-start
+// failures, where the Lambda cannot do anything useful.
+func Fatal(msg string, err error) {
+	slog.Error(msg, Err(err))
+	os.Exit(1)
+}
+
+// contextHandler adds the Lambda request ID and the attributes attached with
+// With or Add to every record logged with a context.
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle implements slog.Handler.
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		r.AddAttrs(slog.String("request_id", lc.AwsRequestID))
+	}
+	r.AddAttrs(attrsFrom(ctx)...)
+	if s, _ := ctx.Value(scopeKey{}).(*scope); s != nil {
+		s.mu.Lock()
+		r.AddAttrs(s.attrs...)
+		s.mu.Unlock()
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// attrsFrom returns the attributes attached to ctx.
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	return attrs[:len(attrs):len(attrs)]
+}
+
+// argsToAttrs converts slog-style alternating key/value arguments.
+func argsToAttrs(args []any) []slog.Attr {
+	var attrs []slog.Attr
+	for len(args) > 0 {
+		switch a := args[0].(type) {
+		case slog.Attr:
+			attrs = append(attrs, a)
+			args = args[1:]
+		case string:
+			if len(args) == 1 {
+				attrs = append(attrs, slog.String("!BADKEY", a))
+				args = nil
+				continue
+			}
+			attrs = append(attrs, slog.Any(a, args[1]))
+			args = args[2:]
+		default:
+			attrs = append(attrs, slog.Any("!BADKEY", a))
+			args = args[1:]
+		}
+	}
+	return attrs
+}
+
+// parseLevel maps a LOG_LEVEL value to a slog level, defaulting to info.
+func parseLevel(s string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
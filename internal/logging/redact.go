@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EnvMaskPII disables PII masking when set to "false". It exists for local
+// development only and must never be turned off in a deployed stage.
+const EnvMaskPII = "LOG_MASK_PII"
+
+// redacted replaces the value of attributes that are never logged.
+const redacted = "[REDACTED]"
+
+// emailPattern finds email addresses embedded in free text such as messages
+// and error strings.
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// piiKeys are attribute keys whose values are always treated as PII.
+var piiKeys = map[string]func(string) string{
+	"email":         MaskEmail,
+	"phone_number":  func(string) string { return redacted },
+	"source_ip":     MaskIP,
+	"ip":            MaskIP,
+	"last_login_ip": MaskIP,
+}
+
+// MaskEmail keeps just enough of an address to eyeball it in a log line:
+// "jane.doe@example.com" becomes "j***@e***.com".
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" || domain == "" {
+		return redacted
+	}
+
+	tld := ""
+	if i := strings.LastIndex(domain, "."); i > 0 {
+		domain, tld = domain[:i], domain[i:]
+	}
+	return local[:1] + "***@" + domain[:1] + "***" + tld
+}
+
+// MaskIP drops the host part of an address: the last octet of an IPv4
+// address, or everything after the first three groups of an IPv6 address.
+func MaskIP(ip string) string {
+	if strings.Contains(ip, ":") {
+		groups := strings.SplitN(ip, ":", 4)
+		if len(groups) < 4 {
+			return redacted
+		}
+		return strings.Join(groups[:3], ":") + "::"
+	}
+	if i := strings.LastIndex(ip, "."); i > 0 {
+		return ip[:i] + ".0"
+	}
+	return redacted
+}
+
+// Redact masks every email address found in s.
+func Redact(s string) string {
+	return emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+}
+
+// Email returns an email attribute. The logger masks it unless masking is
+// disabled, so prefer EmailHash when the address is only needed for
+// correlation.
+func Email(email string) slog.Attr {
+	return slog.String("email", email)
+}
+
+// maskingEnabled reports whether PII masking is on (the default).
+func maskingEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv(EnvMaskPII))
+	return err != nil || enabled
+}
+
+// redactAttr is a slog ReplaceAttr function masking PII in known keys, and
+// email addresses embedded in any string or error value (messages included).
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if mask, ok := piiKeys[a.Key]; ok {
+		return slog.String(a.Key, mask(a.Value.String()))
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if s := a.Value.String(); strings.Contains(s, "@") {
+			return slog.String(a.Key, Redact(s))
+		}
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
@@ -0,0 +1,173 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+// logLine logs one line through a logger built by New and returns it
+// decoded.
+func logLine(t *testing.T, log func(*slog.Logger)) map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	old := output
+	output = &buf
+	defer func() { output = old }()
+
+	log(New())
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	return line
+}
+
+func TestRedaction(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(*slog.Logger)
+		key  string
+		want any
+	}{
+		{
+			name: "email attribute",
+			log:  func(l *slog.Logger) { l.Info("Signed up", Email("jane.doe@example.com")) },
+			key:  "email",
+			want: "j***@e***.com",
+		},
+		{
+			name: "email in message",
+			log:  func(l *slog.Logger) { l.Info("Sent reset code to jane.doe@example.com") },
+			key:  "msg",
+			want: "Sent reset code to j***@e***.com",
+		},
+		{
+			name: "email in other attribute",
+			log:  func(l *slog.Logger) { l.Info("Lookup", "query", "name:jane email:jane@example.org") },
+			key:  "query",
+			want: "name:jane email:j***@e***.org",
+		},
+		{
+			name: "email in error",
+			log: func(l *slog.Logger) {
+				l.Error("Failed", Err(fmt.Errorf("sending to %s: %w", "jane@example.com", errors.New("bounced"))))
+			},
+			key:  "error",
+			want: "sending to j***@e***.com: bounced",
+		},
+		{
+			name: "email in group",
+			log:  func(l *slog.Logger) { l.Info("Invited", slog.Group("invite", Email("bob@example.com"))) },
+			key:  "invite",
+			want: map[string]any{"email": "b***@e***.com"},
+		},
+		{
+			name: "phone number",
+			log:  func(l *slog.Logger) { l.Info("Verified", "phone_number", "+15555550100") },
+			key:  "phone_number",
+			want: redacted,
+		},
+		{
+			name: "IPv4",
+			log:  func(l *slog.Logger) { l.Info("Request", "source_ip", "203.0.113.42") },
+			key:  "source_ip",
+			want: "203.0.113.0",
+		},
+		{
+			name: "IPv6",
+			log:  func(l *slog.Logger) { l.Info("Request", "ip", "2001:db8:85a3:8d3:1319:8a2e:370:7348") },
+			key:  "ip",
+			want: "2001:db8:85a3::",
+		},
+		{
+			name: "last login IP",
+			log:  func(l *slog.Logger) { l.Info("Login", "last_login_ip", "198.51.100.7") },
+			key:  "last_login_ip",
+			want: "198.51.100.0",
+		},
+		{
+			name: "unrelated attribute",
+			log:  func(l *slog.Logger) { l.Info("Lookup", "user_id", "u1", "count", 3) },
+			key:  "user_id",
+			want: "u1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvMaskPII, "")
+			line := logLine(t, tt.log)
+			got, _ := json.Marshal(line[tt.key])
+			want, _ := json.Marshal(tt.want)
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s = %s, want %s", tt.key, got, want)
+			}
+		})
+	}
+}
+
+func TestMaskPIISetting(t *testing.T) {
+	tests := []struct {
+		env        string
+		wantMasked bool
+	}{
+		{env: "", wantMasked: true},
+		{env: "true", wantMasked: true},
+		{env: "false", wantMasked: false},
+		{env: "off", wantMasked: true}, // not a boolean, so the default holds
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv(EnvMaskPII, tt.env)
+			line := logLine(t, func(l *slog.Logger) {
+				l.Info("Sent to jane@example.com", Email("jane@example.com"), "source_ip", "203.0.113.42")
+			})
+
+			want := map[string]string{"msg": "Sent to jane@example.com", "email": "jane@example.com", "source_ip": "203.0.113.42"}
+			if tt.wantMasked {
+				want = map[string]string{"msg": "Sent to j***@e***.com", "email": "j***@e***.com", "source_ip": "203.0.113.0"}
+			}
+			for key, v := range want {
+				if line[key] != v {
+					t.Errorf("%s = %v, want %q", key, line[key], v)
+				}
+			}
+		})
+	}
+}
+
+func TestMaskEmail(t *testing.T) {
+	tests := map[string]string{
+		"jane.doe@example.com":    "j***@e***.com",
+		"a@b.co.uk":               "a***@b***.uk",
+		"jane@localhost":          "j***@l***",
+		"@example.com":            redacted,
+		"jane@":                   redacted,
+		"not an email":            redacted,
+		"Jane+tag@Example.COM":    "J***@E***.COM",
+		"x@sub.domain.example.io": "x***@s***.io",
+	}
+	for in, want := range tests {
+		if got := MaskEmail(in); got != want {
+			t.Errorf("MaskEmail(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMaskIP(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.42":        "203.0.113.0",
+		"2001:db8:85a3::7348": "2001:db8:85a3::",
+		"::1":                 redacted,
+		"localhost":           redacted,
+		"":                    redacted,
+	}
+	for in, want := range tests {
+		if got := MaskIP(in); got != want {
+			t.Errorf("MaskIP(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Package maintenance takes the API offline for a safe window, e.g. during
+// a migration, without a deploy.
+//
+// While the dynconfig maintenance parameter is "true", Wrap answers every
+// API Gateway request with a 503, a Retry-After taken from the
+// maintenance_retry_after parameter and a message in the caller's language.
+// Admins, who hold the admin group or role in the claims of the authorizer,
+// are let through, so the admin routes keep working, as are direct
+// invocations by operators and other backend services.
+package maintenance
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/dynconfig"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/logging"
+)
+
+// paramRetryAfter is the Go duration clients are told to wait.
+const paramRetryAfter = "maintenance_retry_after"
+
+// DefaultRetryAfter applies when maintenance_retry_after is unset.
+const DefaultRetryAfter = 5 * time.Minute
+
+// Code is the error code of maintenance responses.
+const Code = "MAINTENANCE"
+
+// messages are the maintenance message by language; English is the
+// fallback.
+var messages = map[string]string{
+	"en": "Troggle is down for maintenance. Please try again in a few minutes.",
+	"de": "Troggle wird gerade gewartet. Bitte versuche es in ein paar Minuten erneut.",
+	"es": "Troggle está en mantenimiento. Vuelve a intentarlo en unos minutos.",
+	"fr": "Troggle est en maintenance. Réessaie dans quelques minutes.",
+	"pt": "O Troggle está em manutenção. Tente novamente em alguns minutos.",
+}
+
+// Message returns the maintenance message in the first language of an
+// Accept-Language header that has one.
+func Message(acceptLanguage string) string {
+	for _, tag := range strings.Split(acceptLanguage, ",") {
+		tag, _, _ = strings.Cut(strings.TrimSpace(tag), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if m, ok := messages[lang]; ok {
+			return m
+		}
+	}
+	return messages["en"]
+}
+
+// Wrap returns next behind the maintenance switch of params.
+func Wrap(params *dynconfig.Store, next httpx.Handler) httpx.Handler {
+	return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+		if r.Direct || r.InGroup(authz.Admin) || !params.Bool(ctx, dynconfig.Maintenance, false) {
+			return next(ctx, r)
+		}
+
+		retry := DefaultRetryAfter
+		if v := params.String(ctx, paramRetryAfter, ""); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d > 0 {
+				retry = d
+			}
+		}
+		slog.InfoContext(ctx, "Rejected request during maintenance")
+		return httpx.Error(apperr.Unavailable(Code, Message(r.Header("Accept-Language")), retry)), nil
+	}
+}
+
+// Guard returns next behind the maintenance switch of the container's
+// dynconfig store. Lambda entry points put it around their HTTP handler.
+func Guard(cfg *config.Config, next httpx.Handler) httpx.Handler {
+	params, err := dynconfig.Shared(context.Background(), cfg)
+	if err != nil {
+		slog.Warn("Maintenance switch unavailable", logging.Err(err))
+	}
+	return Wrap(params, next)
+}
@@ -0,0 +1,89 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"troggle-backend/internal/dynconfig"
+	"troggle-backend/internal/httpx"
+)
+
+// parameters answers parameter reads from values, keyed by name below the
+// path.
+type parameters map[string]string
+
+func (p parameters) GetParametersByPath(_ context.Context, in *ssm.GetParametersByPathInput, _ ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	var out ssm.GetParametersByPathOutput
+	for name, value := range p {
+		out.Parameters = append(out.Parameters, ssmtypes.Parameter{Name: aws.String(aws.ToString(in.Path) + name), Value: aws.String(value)})
+	}
+	return &out, nil
+}
+
+func ok(context.Context, *httpx.Request) (httpx.Response, error) {
+	return httpx.NoContent(), nil
+}
+
+func TestWrap(t *testing.T) {
+	on := parameters{"maintenance": "true", "maintenance_retry_after": "10m"}
+	tests := []struct {
+		name       string
+		params     parameters
+		req        httpx.Request
+		wantStatus int
+	}{
+		{name: "off", params: parameters{}, wantStatus: 204},
+		{name: "user", params: on, req: httpx.Request{Headers: map[string]string{"accept-language": "de-DE,en;q=0.8"}}, wantStatus: 503},
+		{name: "admin", params: on, req: httpx.Request{Claims: map[string]string{"cognito:groups": "players,admin"}}, wantStatus: 204},
+		{name: "direct invocation", params: on, req: httpx.Request{Direct: true}, wantStatus: 204},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &dynconfig.Store{API: tt.params, Path: "/troggle/test/", TTL: time.Minute}
+			if tt.req.Headers == nil {
+				tt.req.Headers = map[string]string{}
+			}
+			resp, err := Wrap(store, ok)(context.Background(), &tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != 503 {
+				return
+			}
+			if resp.Headers["Retry-After"] != "600" {
+				t.Errorf("Retry-After = %q, want 600", resp.Headers["Retry-After"])
+			}
+			var body httpx.ErrorBody
+			if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != Code || body.Error.Message != messages["de"] {
+				t.Errorf("body = %+v, want the German maintenance message", body.Error)
+			}
+		})
+	}
+}
+
+func TestMessage(t *testing.T) {
+	tests := map[string]string{
+		"":                  messages["en"],
+		"fr-CA":             messages["fr"],
+		"nl-NL, pt;q=0.7":   messages["pt"],
+		"ja":                messages["en"],
+		"ES-mx;q=0.9,en-GB": messages["es"],
+	}
+	for header, want := range tests {
+		if got := Message(header); got != want {
+			t.Errorf("Message(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
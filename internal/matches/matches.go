@@ -0,0 +1,525 @@
+// Package matches records game matches. A match is one item of the match
+// table, keyed by match_id, that moves through the statuses
+//
+//	waiting -> in_progress -> finished
+//	   |            |
+//	   +------------+--------> abandoned
+//
+// The host creates it waiting, and players join until it is full, which
+// starts it, or until its join deadline. The match state machine
+// (statemachine.asl.json), started by the MatchCreated event, enforces the
+// deadlines: at join_by it expires the lobby, starting matches with enough
+// players and abandoning the others, and at finish_by it abandons matches
+// still without results. Every transition is a conditional write on the
+// status, so a late or repeated expiry does nothing.
+//
+// The results of a match and the records of its players, in the player
+// table, are written in one transaction, along with the rating changes of
+// the players and their rating history entries; see package rating.
+// Matchmaking pairs players of similar ratings.
+package matches
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/leaderboard"
+	"troggle-backend/internal/rating"
+)
+
+// StateMachine is the Amazon States Language definition of the match state
+// machine. Deployments substitute ${FinishMatchFunctionArn}.
+//
+//go:embed statemachine.asl.json
+var StateMachine string
+
+// Statuses of a match.
+const (
+	Waiting    = "waiting"
+	InProgress = "in_progress"
+	Finished   = "finished"
+	Abandoned  = "abandoned"
+)
+
+// Timeouts the state machine reports to finishMatch.
+const (
+	TimeoutJoin = "join" // the join deadline passed
+	TimeoutPlay = "play" // the finish deadline passed
+)
+
+const (
+	// MinPlayers is the fewest players a match starts with.
+	MinPlayers = 2
+
+	// MaxPlayers bounds the players of a match, keeping its results and
+	// player records well within one transaction.
+	MaxPlayers = 8
+)
+
+// Error codes of invalid match requests.
+const (
+	CodeMaxPlayersInvalid = "MATCH_MAX_PLAYERS_INVALID"
+	CodeClosed            = "MATCH_CLOSED"
+	CodeFull              = "MATCH_FULL"
+	CodeNotInProgress     = "MATCH_NOT_IN_PROGRESS"
+	CodeResultsInvalid    = "MATCH_RESULTS_INVALID"
+)
+
+// Match is a match and, once finished, its results.
+type Match struct {
+	ID         string     `json:"match_id" dynamodbav:"match_id"`
+	HostID     string     `json:"host_id" dynamodbav:"host_id"`
+	Board      string     `json:"board,omitempty" dynamodbav:"board,omitempty"` // leaderboard the scores count towards
+	Status     string     `json:"status" dynamodbav:"status"`
+	Players    []string   `json:"players" dynamodbav:"players"` // in the order they joined, the host first
+	MaxPlayers int        `json:"max_players" dynamodbav:"max_players"`
+	CreatedAt  time.Time  `json:"created_at" dynamodbav:"created_at"`
+	JoinBy     time.Time  `json:"join_by" dynamodbav:"join_by"`
+	FinishBy   time.Time  `json:"finish_by" dynamodbav:"finish_by"`
+	StartedAt  *time.Time `json:"started_at,omitempty" dynamodbav:"started_at,omitempty"`
+	EndedAt    *time.Time `json:"ended_at,omitempty" dynamodbav:"ended_at,omitempty"` // finished or abandoned
+	Results    []Result   `json:"results,omitempty" dynamodbav:"results,omitempty"`   // by place
+}
+
+// Result is the result of one player.
+type Result struct {
+	UserID string `json:"user_id" dynamodbav:"user_id"`
+	Score  int64  `json:"score" dynamodbav:"score"`
+	Place  int    `json:"place" dynamodbav:"place"` // 1 for the winners; ties share a place
+}
+
+// Player is the match record of a player.
+type Player struct {
+	UserID        string `json:"user_id" dynamodbav:"user_id"`
+	Rating        int    `json:"rating" dynamodbav:"rating"`
+	MatchesPlayed int    `json:"matches_played" dynamodbav:"matches_played"`
+	Wins          int    `json:"wins" dynamodbav:"wins"`
+	TotalScore    int64  `json:"total_score" dynamodbav:"total_score"`
+	LastMatchID   string `json:"last_match_id,omitempty" dynamodbav:"last_match_id,omitempty"`
+	LastPlayedAt  string `json:"last_played_at,omitempty" dynamodbav:"last_played_at,omitempty"`
+}
+
+// Store reads and writes the match and player tables.
+type Store struct {
+	DB          *db.Client
+	Table       string
+	PlayerTable string
+	Boards      []string      // leaderboards matches may count towards
+	JoinTimeout time.Duration // from creation to join_by
+	PlayTimeout time.Duration // from join_by to finish_by
+	Ratings     *rating.Store
+}
+
+// NewStore returns a store over the tables named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{
+		DB:          client,
+		Table:       cfg.MatchTableName,
+		PlayerTable: cfg.PlayerTableName,
+		Boards:      cfg.Leaderboards,
+		JoinTimeout: cfg.MatchJoinTimeout,
+		PlayTimeout: cfg.MatchPlayTimeout,
+		Ratings:     rating.NewStore(client, cfg),
+	}
+}
+
+// NewID returns a random match ID.
+func NewID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Create stores a new match of hostID for up to maxPlayers players and
+// returns it. A board, if any, must be one of the configured leaderboards.
+func (s *Store) Create(ctx context.Context, hostID, board string, maxPlayers int, now time.Time) (Match, error) {
+	if maxPlayers < MinPlayers || maxPlayers > MaxPlayers {
+		return Match{}, apperr.Invalid(CodeMaxPlayersInvalid, "max_players", fmt.Sprintf("max_players must be between %d and %d", MinPlayers, MaxPlayers))
+	}
+	if board != "" && !slices.Contains(s.Boards, board) {
+		return Match{}, apperr.NotFound("Leaderboard not found")
+	}
+
+	// Whole seconds keep the stored times comparable as strings
+	now = now.UTC().Truncate(time.Second)
+	m := Match{
+		ID:         NewID(),
+		HostID:     hostID,
+		Board:      board,
+		Status:     Waiting,
+		Players:    []string{hostID},
+		MaxPlayers: maxPlayers,
+		CreatedAt:  now,
+		JoinBy:     now.Add(s.JoinTimeout),
+		FinishBy:   now.Add(s.JoinTimeout + s.PlayTimeout),
+	}
+	item, err := attributevalue.MarshalMap(m)
+	if err != nil {
+		return Match{}, fmt.Errorf("encoding match: %w", err)
+	}
+	if err := s.DB.PutItem(ctx, s.Table, item); err != nil {
+		return Match{}, err
+	}
+	return m, nil
+}
+
+// Matched returns a match of players, in progress from now, and the write
+// creating it, which matchmaking commits along with the claim of the
+// players' tickets. The first player hosts it.
+func (s *Store) Matched(board string, players []string, now time.Time) (Match, types.TransactWriteItem, error) {
+	now = now.UTC().Truncate(time.Second)
+	m := Match{
+		ID:         NewID(),
+		HostID:     players[0],
+		Board:      board,
+		Status:     InProgress,
+		Players:    players,
+		MaxPlayers: len(players),
+		CreatedAt:  now,
+		JoinBy:     now,
+		FinishBy:   now.Add(s.PlayTimeout),
+		StartedAt:  &now,
+	}
+	item, err := attributevalue.MarshalMap(m)
+	if err != nil {
+		return Match{}, types.TransactWriteItem{}, fmt.Errorf("encoding match: %w", err)
+	}
+	return m, types.TransactWriteItem{Put: &types.Put{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(match_id)"),
+	}}, nil
+}
+
+// Player returns the record of userID; players without one have never
+// finished a match, and are returned with the default rating.
+func (s *Store) Player(ctx context.Context, userID string) (Player, error) {
+	item, err := s.DB.GetItem(ctx, s.PlayerTable, db.Item{"user_id": &types.AttributeValueMemberS{Value: userID}})
+	if err != nil {
+		return Player{}, err
+	}
+	if item == nil {
+		return Player{UserID: userID, Rating: rating.Default}, nil
+	}
+	p := Player{Rating: rating.Default}
+	if err := attributevalue.UnmarshalMap(item, &p); err != nil {
+		return Player{}, fmt.Errorf("decoding player: %w", err)
+	}
+	return p, nil
+}
+
+// Get returns the match of matchID; a missing one is apperr.NotFound.
+func (s *Store) Get(ctx context.Context, matchID string) (Match, error) {
+	item, err := s.DB.GetItem(ctx, s.Table, key(matchID))
+	if err != nil {
+		return Match{}, err
+	}
+	if item == nil {
+		return Match{}, apperr.NotFound("Match not found")
+	}
+	return decode(item)
+}
+
+// Join adds userID to the players of a waiting match and returns the
+// match, started if userID filled it. Joining a match again returns it
+// unchanged.
+func (s *Store) Join(ctx context.Context, matchID, userID string, now time.Time) (Match, error) {
+	start := time.Now()
+	out, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 key(matchID),
+		UpdateExpression:    aws.String("SET players = list_append(players, :players)"),
+		ConditionExpression: aws.String("#status = :waiting AND join_by > :now AND size(players) < max_players AND NOT contains(players, :user_id)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":players": &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberS{Value: userID}}},
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+			":waiting": &types.AttributeValueMemberS{Value: Waiting},
+			":now":     &types.AttributeValueMemberS{Value: format(now)},
+		},
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	db.Observe(ctx, start, err)
+
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		if failed.Item == nil {
+			return Match{}, apperr.NotFound("Match not found")
+		}
+		m, err := decode(failed.Item)
+		switch {
+		case err != nil:
+			return Match{}, err
+		case slices.Contains(m.Players, userID):
+			return m, nil
+		case m.Status != Waiting || !now.Before(m.JoinBy):
+			return Match{}, apperr.Invalid(CodeClosed, "match_id", "the match is no longer open to join")
+		}
+		return Match{}, apperr.Invalid(CodeFull, "match_id", "the match is full")
+	}
+	if err != nil {
+		return Match{}, db.Wrap(err, "joining match")
+	}
+
+	m, err := decode(out.Attributes)
+	if err != nil || len(m.Players) < m.MaxPlayers {
+		return m, err
+	}
+	started, _, err := s.start(ctx, matchID, now)
+	return started, err
+}
+
+// Expire applies timeout, one of the Timeout* values, to the match of
+// matchID, and returns the match and whether the timeout abandoned it. At
+// the join deadline a waiting match starts if it has MinPlayers, and is
+// abandoned otherwise; at the finish deadline a match in progress is
+// abandoned. Matches past the stage of the timeout are returned as they
+// are.
+func (s *Store) Expire(ctx context.Context, matchID, timeout string, now time.Time) (Match, bool, error) {
+	if timeout != TimeoutJoin && timeout != TimeoutPlay {
+		return Match{}, false, fmt.Errorf("unknown match timeout %q", timeout)
+	}
+	m, err := s.Get(ctx, matchID)
+	if err != nil {
+		return Match{}, false, err
+	}
+	switch {
+	case timeout == TimeoutJoin && m.Status == Waiting && len(m.Players) >= MinPlayers:
+		m, _, err = s.start(ctx, matchID, now)
+		return m, false, err
+	case timeout == TimeoutJoin && m.Status == Waiting, timeout == TimeoutPlay && m.Status == InProgress:
+		return s.abandon(ctx, matchID, m.Status, now)
+	}
+	return m, false, nil
+}
+
+// start moves a waiting match with enough players in progress, and reports
+// whether it did; either way it returns the match as it is now.
+func (s *Store) start(ctx context.Context, matchID string, now time.Time) (Match, bool, error) {
+	return s.transition(ctx, matchID, "SET #status = :to, started_at = :now", "#status = :from AND size(players) >= :min",
+		map[string]types.AttributeValue{
+			":from": &types.AttributeValueMemberS{Value: Waiting},
+			":to":   &types.AttributeValueMemberS{Value: InProgress},
+			":min":  &types.AttributeValueMemberN{Value: strconv.Itoa(MinPlayers)},
+			":now":  &types.AttributeValueMemberS{Value: format(now)},
+		})
+}
+
+// abandon ends a match still in status from without results, and reports
+// whether it did.
+func (s *Store) abandon(ctx context.Context, matchID, from string, now time.Time) (Match, bool, error) {
+	return s.transition(ctx, matchID, "SET #status = :to, ended_at = :now", "#status = :from",
+		map[string]types.AttributeValue{
+			":from": &types.AttributeValueMemberS{Value: from},
+			":to":   &types.AttributeValueMemberS{Value: Abandoned},
+			":now":  &types.AttributeValueMemberS{Value: format(now)},
+		})
+}
+
+// transition applies update to the match of matchID if condition holds. It
+// returns the match after the update, or as it was when the condition
+// failed, and whether the update applied.
+func (s *Store) transition(ctx context.Context, matchID, update, condition string, values map[string]types.AttributeValue) (Match, bool, error) {
+	start := time.Now()
+	out, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                           aws.String(s.Table),
+		Key:                                 key(matchID),
+		UpdateExpression:                    aws.String(update),
+		ConditionExpression:                 aws.String("attribute_exists(match_id) AND " + condition),
+		ExpressionAttributeNames:            map[string]string{"#status": "status"},
+		ExpressionAttributeValues:           values,
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	db.Observe(ctx, start, err)
+
+	var failed *types.ConditionalCheckFailedException
+	switch {
+	case errors.As(err, &failed) && failed.Item == nil:
+		return Match{}, false, apperr.NotFound("Match not found")
+	case errors.As(err, &failed):
+		m, err := decode(failed.Item)
+		return m, false, err
+	case err != nil:
+		return Match{}, false, db.Wrap(err, "updating match")
+	}
+	m, err := decode(out.Attributes)
+	return m, err == nil, err
+}
+
+// Finish records the scores of a match in progress, which must name every
+// player once, and returns the finished match and whether this call
+// finished it. The places are derived from the scores, highest first. Each
+// player's record counts the match, and the win of the players placed
+// first, and takes their rating change. Reporting the results of a finished match again returns the
+// results recorded.
+func (s *Store) Finish(ctx context.Context, matchID string, scores []Result, now time.Time) (Match, bool, error) {
+	notInProgress := apperr.Invalid(CodeNotInProgress, "match_id", "results can only be reported for a match in progress")
+	m, err := s.Get(ctx, matchID)
+	if err != nil {
+		return Match{}, false, err
+	}
+	switch m.Status {
+	case Finished:
+		return m, false, nil
+	case InProgress:
+	default:
+		return Match{}, false, notInProgress
+	}
+	results, err := place(m.Players, scores)
+	if err != nil {
+		return Match{}, false, err
+	}
+
+	encoded, err := attributevalue.Marshal(results)
+	if err != nil {
+		return Match{}, false, fmt.Errorf("encoding results: %w", err)
+	}
+	at := format(now)
+	items := []types.TransactWriteItem{{Update: &types.Update{
+		TableName:           aws.String(s.Table),
+		Key:                 key(matchID),
+		UpdateExpression:    aws.String("SET #status = :finished, results = :results, ended_at = :now"),
+		ConditionExpression: aws.String("#status = :in_progress"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":finished":    &types.AttributeValueMemberS{Value: Finished},
+			":in_progress": &types.AttributeValueMemberS{Value: InProgress},
+			":results":     encoded,
+			":now":         &types.AttributeValueMemberS{Value: at},
+		},
+	}}}
+	players := make([]rating.Player, len(results))
+	for i, r := range results {
+		p, err := s.Player(ctx, r.UserID)
+		if err != nil {
+			return Match{}, false, err
+		}
+		players[i] = rating.Player{UserID: r.UserID, Rating: p.Rating, MatchesPlayed: p.MatchesPlayed, Place: r.Place}
+	}
+	// Changes are added to the stored ratings, not set, so a player
+	// finishing two matches at once keeps both; only the before of one
+	// history entry is then stale.
+	changes := s.Ratings.Params.Changes(players)
+	for i, r := range results {
+		history, err := s.Ratings.Record(rating.Entry{
+			UserID:  r.UserID,
+			Reason:  rating.ReasonMatch,
+			MatchID: matchID,
+			Before:  players[i].Rating,
+			After:   players[i].Rating + changes[i],
+			At:      now,
+		})
+		if err != nil {
+			return Match{}, false, err
+		}
+		items = append(items, s.record(matchID, r, changes[i], at), history)
+	}
+	err = s.DB.TransactWriteItems(ctx, items)
+	if db.ConditionFailed(err, 0) {
+		return Match{}, false, notInProgress
+	}
+	if err != nil {
+		return Match{}, false, err
+	}
+
+	ended, _ := time.Parse(time.RFC3339, at)
+	m.Status, m.Results, m.EndedAt = Finished, results, &ended
+	return m, true, nil
+}
+
+// record returns the update of the player record of r's player, whose
+// rating changes by change.
+func (s *Store) record(matchID string, r Result, change int, at string) types.TransactWriteItem {
+	win := "0"
+	if r.Place == 1 {
+		win = "1"
+	}
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:        aws.String(s.PlayerTable),
+		Key:              db.Item{"user_id": &types.AttributeValueMemberS{Value: r.UserID}},
+		UpdateExpression: aws.String("ADD matches_played :one, wins :win, total_score :score SET last_match_id = :match_id, last_played_at = :now, rating = if_not_exists(rating, :rating) + :change"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":rating":   &types.AttributeValueMemberN{Value: strconv.Itoa(rating.Default)},
+			":change":   &types.AttributeValueMemberN{Value: strconv.Itoa(change)},
+			":one":      &types.AttributeValueMemberN{Value: "1"},
+			":win":      &types.AttributeValueMemberN{Value: win},
+			":score":    &types.AttributeValueMemberN{Value: strconv.FormatInt(r.Score, 10)},
+			":match_id": &types.AttributeValueMemberS{Value: matchID},
+			":now":      &types.AttributeValueMemberS{Value: at},
+		},
+	}}
+}
+
+// place validates that scores name each of players exactly once, and
+// returns them ordered and placed: highest score first, ties sharing a
+// place and ordered by user ID.
+func place(players []string, scores []Result) ([]Result, error) {
+	invalid := apperr.Invalid(CodeResultsInvalid, "results", "results must list every player of the match once")
+	if len(scores) != len(players) {
+		return nil, invalid
+	}
+	seen := map[string]bool{}
+	for _, r := range scores {
+		if seen[r.UserID] || !slices.Contains(players, r.UserID) {
+			return nil, invalid
+		}
+		seen[r.UserID] = true
+		if err := leaderboard.CheckScore(r.Score); err != nil {
+			return nil, err
+		}
+	}
+
+	results := slices.Clone(scores)
+	slices.SortFunc(results, func(a, b Result) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.UserID, b.UserID))
+	})
+	for i := range results {
+		results[i].Place = i + 1
+		if i > 0 && results[i].Score == results[i-1].Score {
+			results[i].Place = results[i-1].Place
+		}
+	}
+	return results, nil
+}
+
+// decode unmarshals a match item.
+func decode(item db.Item) (Match, error) {
+	var m Match
+	if err := attributevalue.UnmarshalMap(item, &m); err != nil {
+		return Match{}, fmt.Errorf("decoding match: %w", err)
+	}
+	return m, nil
+}
+
+// format returns t as stored: RFC 3339 in whole seconds, which sorts as
+// strings.
+func format(t time.Time) string {
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+// key returns the primary key of a match.
+func key(matchID string) db.Item {
+	return db.Item{"match_id": &types.AttributeValueMemberS{Value: matchID}}
+}
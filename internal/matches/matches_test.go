@@ -0,0 +1,360 @@
+package matches
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/rating"
+)
+
+var now = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+// item returns the item of a match of status with players.
+func item(t *testing.T, status string, players ...string) db.Item {
+	t.Helper()
+	item, err := attributevalue.MarshalMap(Match{
+		ID:         "m1",
+		HostID:     players[0],
+		Status:     status,
+		Players:    players,
+		MaxPlayers: 3,
+		CreatedAt:  now.Add(-time.Minute),
+		JoinBy:     now.Add(time.Minute),
+		FinishBy:   now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+// with returns item with the attribute name set to value.
+func with(item db.Item, name, value string) db.Item {
+	item[name] = &types.AttributeValueMemberS{Value: value}
+	return item
+}
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		board      string
+		maxPlayers int
+		wantKind   apperr.Kind
+		wantErr    bool
+	}{
+		{name: "match", maxPlayers: 2},
+		{name: "on a board", board: "main", maxPlayers: 8},
+		{name: "too small", maxPlayers: 1, wantKind: apperr.KindInvalid, wantErr: true},
+		{name: "too large", maxPlayers: 9, wantKind: apperr.KindInvalid, wantErr: true},
+		{name: "unknown board", board: "speed", maxPlayers: 2, wantKind: apperr.KindNotFound, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored db.Item
+			m := &dbtest.Mock{PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+				stored = in.Item
+				return &dynamodb.PutItemOutput{}, nil
+			}}
+			s := &Store{DB: m.Client(), Table: "matches", Boards: []string{"main"}, JoinTimeout: 2 * time.Minute, PlayTimeout: time.Hour}
+
+			match, err := s.Create(context.Background(), "u1", tt.board, tt.maxPlayers, now.Add(500*time.Millisecond))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if apperr.KindOf(err) != tt.wantKind {
+					t.Errorf("kind = %v, want %v", apperr.KindOf(err), tt.wantKind)
+				}
+				return
+			}
+			if match.Status != Waiting || !slices.Equal(match.Players, []string{"u1"}) {
+				t.Errorf("match = %+v, want waiting for the host", match)
+			}
+			if got := stored["join_by"].(*types.AttributeValueMemberS).Value; got != "2026-10-14T12:02:00Z" {
+				t.Errorf("join_by = %s, want whole seconds", got)
+			}
+			if !match.FinishBy.Equal(now.Add(62 * time.Minute)) {
+				t.Errorf("finish_by = %v", match.FinishBy)
+			}
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		name       string
+		after      db.Item // returned by the join
+		failed     db.Item // returned by a join that failed its condition
+		missing    bool
+		wantStatus string
+		wantCode   string
+		wantKind   apperr.Kind
+		wantErr    bool
+		wantOps    []string
+	}{
+		{name: "joins", after: item(t, Waiting, "u1", "u2"), wantStatus: Waiting, wantOps: []string{"UpdateItem"}},
+		{name: "fills", after: item(t, Waiting, "u1", "u3", "u2"), wantStatus: InProgress, wantOps: []string{"UpdateItem", "UpdateItem"}},
+		{name: "joined already", failed: item(t, InProgress, "u1", "u2"), wantStatus: InProgress, wantOps: []string{"UpdateItem"}},
+		{name: "full", failed: item(t, Waiting, "u1", "u3", "u4"), wantCode: CodeFull, wantErr: true, wantOps: []string{"UpdateItem"}},
+		{name: "started", failed: item(t, InProgress, "u1", "u3"), wantCode: CodeClosed, wantErr: true, wantOps: []string{"UpdateItem"}},
+		{name: "past the deadline", failed: with(item(t, Waiting, "u1"), "join_by", "2026-10-14T12:00:00Z"), wantCode: CodeClosed, wantErr: true, wantOps: []string{"UpdateItem"}},
+		{name: "unknown", missing: true, wantKind: apperr.KindNotFound, wantErr: true, wantOps: []string{"UpdateItem"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				if _, starting := in.ExpressionAttributeValues[":min"]; starting {
+					return &dynamodb.UpdateItemOutput{Attributes: with(tt.after, "status", InProgress)}, nil
+				}
+				if got := in.ExpressionAttributeValues[":now"].(*types.AttributeValueMemberS).Value; got != "2026-10-14T12:00:00Z" {
+					t.Errorf(":now = %s", got)
+				}
+				switch {
+				case tt.missing:
+					return nil, &types.ConditionalCheckFailedException{}
+				case tt.failed != nil:
+					return nil, &types.ConditionalCheckFailedException{Item: tt.failed}
+				}
+				return &dynamodb.UpdateItemOutput{Attributes: tt.after}, nil
+			}}
+			s := &Store{DB: m.Client(), Table: "matches"}
+
+			match, err := s.Join(context.Background(), "m1", "u2", now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			switch {
+			case tt.wantCode != "" && (apperr.As(err) == nil || apperr.As(err).Code != tt.wantCode):
+				t.Errorf("err = %v, want %s", err, tt.wantCode)
+			case tt.wantKind != apperr.KindInternal && apperr.KindOf(err) != tt.wantKind:
+				t.Errorf("kind = %v, want %v", apperr.KindOf(err), tt.wantKind)
+			case !tt.wantErr && match.Status != tt.wantStatus:
+				t.Errorf("status = %s, want %s", match.Status, tt.wantStatus)
+			}
+			if ops := m.Ops(); !slices.Equal(ops, tt.wantOps) {
+				t.Errorf("ops = %v, want %v", ops, tt.wantOps)
+			}
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	tests := []struct {
+		name          string
+		timeout       string
+		stored        db.Item
+		wantUpdate    string // status the match is moved to, if any
+		wantAbandoned bool
+		wantErr       bool
+	}{
+		{name: "lobby with players", timeout: TimeoutJoin, stored: item(t, Waiting, "u1", "u2"), wantUpdate: InProgress},
+		{name: "empty lobby", timeout: TimeoutJoin, stored: item(t, Waiting, "u1"), wantUpdate: Abandoned, wantAbandoned: true},
+		{name: "started lobby", timeout: TimeoutJoin, stored: item(t, InProgress, "u1", "u2")},
+		{name: "match without results", timeout: TimeoutPlay, stored: item(t, InProgress, "u1", "u2"), wantUpdate: Abandoned, wantAbandoned: true},
+		{name: "finished match", timeout: TimeoutPlay, stored: item(t, Finished, "u1", "u2")},
+		{name: "abandoned match", timeout: TimeoutPlay, stored: item(t, Abandoned, "u1")},
+		{name: "unknown timeout", timeout: "later", stored: item(t, Waiting, "u1"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var update string
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.stored}, nil
+				},
+				UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					update = in.ExpressionAttributeValues[":to"].(*types.AttributeValueMemberS).Value
+					return &dynamodb.UpdateItemOutput{Attributes: with(tt.stored, "status", update)}, nil
+				},
+			}
+			s := &Store{DB: m.Client(), Table: "matches"}
+
+			match, abandoned, err := s.Expire(context.Background(), "m1", tt.timeout, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if update != tt.wantUpdate {
+				t.Errorf("moved to %q, want %q", update, tt.wantUpdate)
+			}
+			if abandoned != tt.wantAbandoned {
+				t.Errorf("abandoned = %v, want %v", abandoned, tt.wantAbandoned)
+			}
+			if tt.wantUpdate != "" && match.Status != tt.wantUpdate {
+				t.Errorf("status = %s, want %s", match.Status, tt.wantUpdate)
+			}
+		})
+	}
+}
+
+func TestFinish(t *testing.T) {
+	tests := []struct {
+		name         string
+		stored       db.Item
+		scores       []Result
+		cancel       error
+		wantPlaces   []string
+		wantFinished bool
+		wantCode     string
+	}{
+		{
+			name:         "places by score",
+			stored:       item(t, InProgress, "u1", "u2", "u3"),
+			scores:       []Result{{UserID: "u1", Score: 10}, {UserID: "u2", Score: 30}, {UserID: "u3", Score: 10}},
+			wantPlaces:   []string{"u2:1", "u1:2", "u3:2"},
+			wantFinished: true,
+		},
+		{
+			name:     "missing player",
+			stored:   item(t, InProgress, "u1", "u2"),
+			scores:   []Result{{UserID: "u1", Score: 10}, {UserID: "u1", Score: 30}},
+			wantCode: CodeResultsInvalid,
+		},
+		{
+			name:     "stranger",
+			stored:   item(t, InProgress, "u1", "u2"),
+			scores:   []Result{{UserID: "u1", Score: 10}, {UserID: "u9", Score: 30}},
+			wantCode: CodeResultsInvalid,
+		},
+		{
+			name:     "negative score",
+			stored:   item(t, InProgress, "u1", "u2"),
+			scores:   []Result{{UserID: "u1", Score: 10}, {UserID: "u2", Score: -1}},
+			wantCode: "SCORE_INVALID",
+		},
+		{
+			name:     "waiting",
+			stored:   item(t, Waiting, "u1", "u2"),
+			scores:   []Result{{UserID: "u1", Score: 10}, {UserID: "u2", Score: 30}},
+			wantCode: CodeNotInProgress,
+		},
+		{
+			name:     "abandoned meanwhile",
+			stored:   item(t, InProgress, "u1", "u2"),
+			scores:   []Result{{UserID: "u1", Score: 10}, {UserID: "u2", Score: 30}},
+			cancel:   dbtest.TransactionCanceled("ConditionalCheckFailed", "None", "None"),
+			wantCode: CodeNotInProgress,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var written []types.TransactWriteItem
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.stored}, nil
+				},
+				TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					written = in.TransactItems
+					return &dynamodb.TransactWriteItemsOutput{}, tt.cancel
+				},
+			}
+			ratings := &rating.Store{HistoryTable: "history", Params: rating.Params{K: 16, ProvisionalK: 64}}
+			s := &Store{DB: m.Client(), Table: "matches", PlayerTable: "players", Ratings: ratings}
+
+			match, finished, err := s.Finish(context.Background(), "m1", tt.scores, now)
+			if tt.wantCode != "" {
+				if aerr := apperr.As(err); aerr == nil || aerr.Code != tt.wantCode {
+					t.Fatalf("err = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if finished != tt.wantFinished {
+				t.Errorf("finished = %v, want %v", finished, tt.wantFinished)
+			}
+			var places []string
+			for _, r := range match.Results {
+				places = append(places, fmt.Sprintf("%s:%d", r.UserID, r.Place))
+			}
+			if !slices.Equal(places, tt.wantPlaces) {
+				t.Errorf("places = %v, want %v", places, tt.wantPlaces)
+			}
+			if len(written) != 1+2*len(tt.scores) {
+				t.Fatalf("%d items written, want the match and every player's record and history", len(written))
+			}
+			winner, loser := written[1].Update.ExpressionAttributeValues, written[3].Update.ExpressionAttributeValues
+			if winner[":win"].(*types.AttributeValueMemberN).Value != "1" || loser[":win"].(*types.AttributeValueMemberN).Value != "0" {
+				t.Error("only the winner's record counts a win")
+			}
+			if winner[":change"].(*types.AttributeValueMemberN).Value != "32" || loser[":change"].(*types.AttributeValueMemberN).Value != "-16" {
+				t.Errorf("rating changes %v and %v, want 32 and -16", winner[":change"], loser[":change"])
+			}
+			if written[2].Put == nil || written[4].Put == nil {
+				t.Error("rating history not written")
+			}
+		})
+	}
+}
+
+func TestFinishAgain(t *testing.T) {
+	stored := item(t, Finished, "u1", "u2")
+	results, _ := attributevalue.Marshal([]Result{{UserID: "u2", Score: 5, Place: 1}, {UserID: "u1", Score: 1, Place: 2}})
+	stored["results"] = results
+	m := &dbtest.Mock{GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		return &dynamodb.GetItemOutput{Item: stored}, nil
+	}}
+	s := &Store{DB: m.Client(), Table: "matches", PlayerTable: "players"}
+
+	match, finished, err := s.Finish(context.Background(), "m1", []Result{{UserID: "u1", Score: 9}, {UserID: "u2", Score: 0}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished || len(match.Results) != 2 || match.Results[0].UserID != "u2" {
+		t.Errorf("finished %v with %+v, want the recorded results", finished, match.Results)
+	}
+	if ops := m.Ops(); !slices.Equal(ops, []string{"GetItem"}) {
+		t.Errorf("ops = %v, want nothing written", ops)
+	}
+}
+
+func TestStateMachine(t *testing.T) {
+	var def struct {
+		StartAt string
+		States  map[string]struct {
+			Type       string
+			Next       string
+			Default    string
+			Choices    []struct{ Next string }
+			Parameters struct {
+				Payload map[string]string
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(StateMachine), &def); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := def.States[def.StartAt]; !ok {
+		t.Fatalf("start state %q is not defined", def.StartAt)
+	}
+	var timeouts []string
+	for name, state := range def.States {
+		next := []string{state.Next, state.Default}
+		for _, c := range state.Choices {
+			next = append(next, c.Next)
+		}
+		for _, n := range next {
+			if _, ok := def.States[n]; n != "" && !ok {
+				t.Errorf("%s: next state %q is not defined", name, n)
+			}
+		}
+		if state.Type == "Task" {
+			timeouts = append(timeouts, state.Parameters.Payload["timeout"])
+		}
+	}
+	slices.Sort(timeouts)
+	if want := []string{TimeoutJoin, TimeoutPlay}; !slices.Equal(timeouts, want) {
+		t.Errorf("timeouts = %v, want %v", timeouts, want)
+	}
+}
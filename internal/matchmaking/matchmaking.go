@@ -0,0 +1,259 @@
+// Package matchmaking queues players for matches. A player waiting for a
+// match holds one ticket in the ticket table, keyed by user_id, for one
+// queue: a latency region and, optionally, the leaderboard the match counts
+// towards. The queue index lists the tickets of a queue oldest first.
+//
+// The matchmaking worker runs every minute, groups the waiting tickets of
+// each queue by skill rating and claims every group it forms: one
+// transaction deletes the group's tickets, on the condition that each is
+// still the ticket it read, and creates the group's match, already in
+// progress. A ticket that was replaced, cancelled or claimed meanwhile
+// cancels the transaction, and its group is formed again, if it still can
+// be, on the next run. Tickets expire, through the table's TTL attribute,
+// when no match was found within TICKET_TTL.
+package matchmaking
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/matches"
+)
+
+// PlayersPerMatch is the size of the matches matchmaking forms.
+const PlayersPerMatch = matches.MinPlayers
+
+const (
+	// RatingWindow is how far apart the ratings of a match's players may
+	// be when its oldest ticket was just enqueued.
+	RatingWindow = 100
+
+	// WindowGrowth widens the window for every minute the oldest ticket
+	// waited, trading fair matches for shorter waits.
+	WindowGrowth = 100
+)
+
+// CodeRegionInvalid is the error code of tickets for unknown regions.
+const CodeRegionInvalid = "MATCH_REGION_INVALID"
+
+// Ticket is a player waiting for a match.
+type Ticket struct {
+	UserID     string    `json:"user_id" dynamodbav:"user_id"`
+	Queue      string    `json:"-" dynamodbav:"queue"` // region#board
+	Region     string    `json:"region" dynamodbav:"region"`
+	Board      string    `json:"board,omitempty" dynamodbav:"board,omitempty"`
+	Rating     int       `json:"rating" dynamodbav:"rating"` // of the player when enqueued
+	EnqueuedAt time.Time `json:"enqueued_at" dynamodbav:"enqueued_at"`
+	ExpiresAt  int64     `json:"-" dynamodbav:"expires_at"` // Unix seconds, the TTL attribute
+}
+
+// Queue names the players who may be matched with each other.
+type Queue struct {
+	Region string
+	Board  string // empty for matches without a leaderboard
+}
+
+// String returns the queue key of q.
+func (q Queue) String() string {
+	return q.Region + "#" + q.Board
+}
+
+// Store reads and writes the ticket table, and creates the matches of the
+// tickets it claims.
+type Store struct {
+	DB         *db.Client
+	Table      string
+	QueueIndex string
+	TTL        time.Duration
+	Regions    []string
+	Matches    *matches.Store
+}
+
+// NewStore returns a store over the ticket table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{
+		DB:         client,
+		Table:      cfg.TicketTableName,
+		QueueIndex: cfg.TicketQueueIndexName,
+		TTL:        cfg.TicketTTL,
+		Regions:    cfg.MatchRegions,
+		Matches:    matches.NewStore(client, cfg),
+	}
+}
+
+// Enqueue stores the ticket of userID for the queue of region and board,
+// replacing any ticket userID held, and returns it. Replacing a ticket
+// moves the player to the back of its queue.
+func (s *Store) Enqueue(ctx context.Context, userID, region, board string, now time.Time) (Ticket, error) {
+	if !slices.Contains(s.Regions, region) {
+		return Ticket{}, apperr.Invalid(CodeRegionInvalid, "region", "region must be one of the matchmaking regions")
+	}
+	if board != "" && !slices.Contains(s.Matches.Boards, board) {
+		return Ticket{}, apperr.NotFound("Leaderboard not found")
+	}
+	player, err := s.Matches.Player(ctx, userID)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	// Whole seconds keep the claim condition on enqueued_at exact
+	now = now.UTC().Truncate(time.Second)
+	t := Ticket{
+		UserID:     userID,
+		Queue:      Queue{Region: region, Board: board}.String(),
+		Region:     region,
+		Board:      board,
+		Rating:     player.Rating,
+		EnqueuedAt: now,
+		ExpiresAt:  now.Add(s.TTL).Unix(),
+	}
+	item, err := attributevalue.MarshalMap(t)
+	if err != nil {
+		return Ticket{}, fmt.Errorf("encoding ticket: %w", err)
+	}
+	if err := s.DB.PutItem(ctx, s.Table, item); err != nil {
+		return Ticket{}, err
+	}
+	return t, nil
+}
+
+// Queues returns every queue: each region, alone and with each board.
+func (s *Store) Queues() []Queue {
+	var queues []Queue
+	for _, region := range s.Regions {
+		queues = append(queues, Queue{Region: region})
+		for _, board := range s.Matches.Boards {
+			queues = append(queues, Queue{Region: region, Board: board})
+		}
+	}
+	return queues
+}
+
+// Waiting returns the unexpired tickets of q, oldest first. DynamoDB
+// deletes expired items lazily, so they are filtered here.
+func (s *Store) Waiting(ctx context.Context, q Queue, now time.Time) ([]Ticket, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.QueueIndex),
+		KeyConditionExpression: aws.String("queue = :queue"),
+		FilterExpression:       aws.String("expires_at > :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":queue": &types.AttributeValueMemberS{Value: q.String()},
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	}
+
+	tickets := []Ticket{}
+	var decodeErr error
+	err := s.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		var page []Ticket
+		if decodeErr = attributevalue.UnmarshalListOfMaps(items, &page); decodeErr != nil {
+			return false
+		}
+		tickets = append(tickets, page...)
+		return true
+	})
+	if err == nil && decodeErr != nil {
+		err = fmt.Errorf("decoding tickets: %w", decodeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tickets, nil
+}
+
+// Group forms groups of size from tickets, which must be oldest first. The
+// oldest ticket not yet grouped is grouped with the tickets closest to its
+// rating, if enough are within its window; otherwise it waits for the next
+// run, and the tickets after it are tried.
+func Group(tickets []Ticket, size int, now time.Time) [][]Ticket {
+	var groups [][]Ticket
+	left := slices.Clone(tickets)
+	for len(left) >= size {
+		oldest, rest := left[0], left[1:]
+		window := Window(now.Sub(oldest.EnqueuedAt))
+		var near []Ticket
+		for _, t := range rest {
+			if distance(t, oldest) <= window {
+				near = append(near, t)
+			}
+		}
+		if len(near) < size-1 {
+			left = rest
+			continue
+		}
+
+		// Stable, so equally close tickets are taken oldest first
+		slices.SortStableFunc(near, func(a, b Ticket) int {
+			return cmp.Compare(distance(a, oldest), distance(b, oldest))
+		})
+		group := append([]Ticket{oldest}, near[:size-1]...)
+		groups = append(groups, group)
+		left = slices.DeleteFunc(rest, func(t Ticket) bool {
+			return slices.ContainsFunc(group, func(g Ticket) bool { return g.UserID == t.UserID })
+		})
+	}
+	return groups
+}
+
+// Window returns how far apart the ratings grouped with a ticket that
+// waited this long may be.
+func Window(waited time.Duration) int {
+	return RatingWindow + int(WindowGrowth*waited.Minutes())
+}
+
+// Claim deletes the tickets of group and creates their match, in progress
+// from now, in one transaction. It returns the match and whether it was
+// created; it was not if any of the tickets changed since it was read.
+func (s *Store) Claim(ctx context.Context, group []Ticket, now time.Time) (matches.Match, bool, error) {
+	players := make([]string, len(group))
+	for i, t := range group {
+		players[i] = t.UserID
+	}
+	m, put, err := s.Matches.Matched(group[0].Board, players, now)
+	if err != nil {
+		return matches.Match{}, false, err
+	}
+
+	items := []types.TransactWriteItem{put}
+	for _, t := range group {
+		enqueuedAt, err := attributevalue.Marshal(t.EnqueuedAt)
+		if err != nil {
+			return matches.Match{}, false, fmt.Errorf("encoding ticket: %w", err)
+		}
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(s.Table),
+			Key:                       db.Item{"user_id": &types.AttributeValueMemberS{Value: t.UserID}},
+			ConditionExpression:       aws.String("enqueued_at = :enqueued_at"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":enqueued_at": enqueuedAt},
+		}})
+	}
+	err = s.DB.TransactWriteItems(ctx, items)
+	if db.ConditionFailed(err, -1) {
+		return matches.Match{}, false, nil
+	}
+	if err != nil {
+		return matches.Match{}, false, err
+	}
+	return m, true, nil
+}
+
+// distance returns how far apart the ratings of a and b are.
+func distance(a, b Ticket) int {
+	if a.Rating > b.Rating {
+		return a.Rating - b.Rating
+	}
+	return b.Rating - a.Rating
+}
@@ -0,0 +1,185 @@
+package matchmaking
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/matches"
+	"troggle-backend/internal/rating"
+)
+
+var now = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+// ticket returns the ticket of userID enqueued waited ago.
+func ticket(userID string, rating int, waited time.Duration) Ticket {
+	return Ticket{UserID: userID, Region: "eu", Rating: rating, EnqueuedAt: now.Add(-waited)}
+}
+
+func TestGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		tickets []Ticket
+		size    int
+		want    []string
+	}{
+		{
+			name:    "pairs the closest ratings",
+			tickets: []Ticket{ticket("u1", 1000, 0), ticket("u2", 1090, 0), ticket("u3", 1020, 0), ticket("u4", 1050, 0)},
+			size:    2,
+			want:    []string{"u1,u3", "u2,u4"},
+		},
+		{
+			name:    "too far apart",
+			tickets: []Ticket{ticket("u1", 1000, 0), ticket("u2", 1200, 0)},
+			size:    2,
+		},
+		{
+			name:    "window widens with waiting",
+			tickets: []Ticket{ticket("u1", 1000, 2*time.Minute), ticket("u2", 1200, 0)},
+			size:    2,
+			want:    []string{"u1,u2"},
+		},
+		{
+			name:    "skips an unmatchable oldest ticket",
+			tickets: []Ticket{ticket("u1", 2000, 0), ticket("u2", 1000, 0), ticket("u3", 1010, 0)},
+			size:    2,
+			want:    []string{"u2,u3"},
+		},
+		{
+			name:    "groups of three",
+			tickets: []Ticket{ticket("u1", 1000, 0), ticket("u2", 1010, 0), ticket("u3", 1500, 0), ticket("u4", 990, 0)},
+			size:    3,
+			want:    []string{"u1,u2,u4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, g := range Group(tt.tickets, tt.size, now) {
+				var ids []string
+				for _, t := range g {
+					ids = append(ids, t.UserID)
+				}
+				got = append(got, strings.Join(ids, ","))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("groups = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	tests := []struct {
+		name       string
+		region     string
+		board      string
+		player     db.Item
+		wantRating int
+		wantKind   apperr.Kind
+	}{
+		{name: "new player", region: "eu", wantRating: rating.Default},
+		{name: "rated player", region: "eu", board: "main", player: rated("1234"), wantRating: 1234},
+		{name: "unknown region", region: "mars", wantKind: apperr.KindInvalid},
+		{name: "unknown board", region: "eu", board: "weekly", wantKind: apperr.KindNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored db.Item
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.player}, nil
+				},
+				PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					stored = in.Item
+					return &dynamodb.PutItemOutput{}, nil
+				},
+			}
+			s := &Store{
+				DB: m.Client(), Table: "tickets", TTL: 5 * time.Minute, Regions: []string{"eu", "na"},
+				Matches: &matches.Store{DB: m.Client(), PlayerTable: "players", Boards: []string{"main"}},
+			}
+
+			got, err := s.Enqueue(context.Background(), "u1", tt.region, tt.board, now)
+			if tt.wantKind != 0 {
+				if kind := apperr.KindOf(err); kind != tt.wantKind {
+					t.Fatalf("err = %v, want kind %v", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Rating != tt.wantRating {
+				t.Errorf("rating = %d, want %d", got.Rating, tt.wantRating)
+			}
+			if queue := stored["queue"].(*types.AttributeValueMemberS).Value; queue != tt.region+"#"+tt.board {
+				t.Errorf("queue = %q", queue)
+			}
+			if expires := stored["expires_at"].(*types.AttributeValueMemberN).Value; expires != fmt.Sprint(now.Add(5*time.Minute).Unix()) {
+				t.Errorf("expires_at = %s", expires)
+			}
+		})
+	}
+}
+
+func TestClaim(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantClaimed bool
+		wantErr     bool
+	}{
+		{name: "claims", wantClaimed: true},
+		{name: "ticket replaced", err: dbtest.TransactionCanceled("None", "None", "ConditionalCheckFailed")},
+		{name: "throttled", err: dbtest.Throttled(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var written []types.TransactWriteItem
+			m := &dbtest.Mock{TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				written = in.TransactItems
+				return &dynamodb.TransactWriteItemsOutput{}, tt.err
+			}}
+			s := &Store{DB: m.Client(), Table: "tickets", Matches: &matches.Store{DB: m.Client(), Table: "matches", PlayTimeout: time.Hour}}
+
+			group := []Ticket{ticket("u1", 1000, time.Minute), ticket("u2", 1010, 0)}
+			match, claimed, err := s.Claim(context.Background(), group, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if claimed != tt.wantClaimed {
+				t.Errorf("claimed = %v, want %v", claimed, tt.wantClaimed)
+			}
+			if len(written) != 3 || written[0].Put == nil || written[1].Delete == nil || written[2].Delete == nil {
+				t.Fatalf("written %+v, want the match and both tickets", written)
+			}
+			if !tt.wantClaimed {
+				return
+			}
+			if match.Status != matches.InProgress || match.HostID != "u1" || !slices.Equal(match.Players, []string{"u1", "u2"}) {
+				t.Errorf("match = %+v, want u1 and u2 in progress", match)
+			}
+			if !match.FinishBy.Equal(now.Add(time.Hour)) {
+				t.Errorf("finish_by = %v, want an hour from now", match.FinishBy)
+			}
+		})
+	}
+}
+
+// rated returns the player record of u1 with rating.
+func rated(rating string) db.Item {
+	item := dbtest.Item("user_id", "u1")
+	item["rating"] = &types.AttributeValueMemberN{Value: rating}
+	return item
+}
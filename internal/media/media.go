@@ -0,0 +1,150 @@
+// Package media turns user-uploaded images into images safe to serve.
+// Nothing of the upload is passed through: it is decoded and re-encoded as
+// a fresh JPEG, so EXIF metadata (GPS position, camera serial numbers),
+// comments and anything appended to the file are dropped. The EXIF
+// orientation is applied to the pixels first so photos stay upright.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder
+	"net/http"
+)
+
+// MaxPixels bounds the decoded size of uploads, so a small, highly
+// compressed file cannot exhaust the function's memory.
+const MaxPixels = 25_000_000
+
+// jpegQuality is the quality output images are encoded with.
+const jpegQuality = 85
+
+// ErrInvalid marks uploads that are not a usable image.
+var ErrInvalid = errors.New("invalid image")
+
+// Sniff returns the content type the leading bytes of data identify, e.g.
+// "image/png", whatever the uploader claimed.
+func Sniff(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// Thumbnails decodes an uploaded image declared as contentType and returns
+// it, upright and cropped to its central square, as a JPEG of each of sizes.
+// Uploads whose bytes are not of the declared type, that do not decode or
+// that are too large are ErrInvalid.
+func Thumbnails(data []byte, contentType string, sizes []int) ([][]byte, error) {
+	if sniffed := Sniff(data); sniffed != contentType {
+		return nil, fmt.Errorf("%w: declared %s but content is %s", ErrInvalid, contentType, sniffed)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrInvalid, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	// A square stays square under every orientation and its center stays
+	// put, so orienting after the crop is equivalent and cheaper
+	square := orient(crop(img), Orientation(data))
+	out := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resize(square, size), &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, fmt.Errorf("encoding %dpx image: %w", size, err)
+		}
+		out[i] = buf.Bytes()
+	}
+	return out, nil
+}
+
+// crop returns the central square of img, flattened onto white so
+// transparent pixels do not turn black in the JPEG.
+func crop(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	edge := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-edge)/2, b.Min.Y+(b.Dy()-edge)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, edge, edge))
+	draw.Draw(square, square.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(square, square.Bounds(), img, origin, draw.Over)
+	return square
+}
+
+// orient applies an EXIF orientation (1 to 8) to the square src.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	n := src.Bounds().Dx() - 1
+	dst := image.NewRGBA(src.Bounds())
+	for y := 0; y <= n; y++ {
+		for x := 0; x <= n; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = n-x, y
+			case 3: // upside down
+				sx, sy = n-x, n-y
+			case 4: // mirrored upside down
+				sx, sy = x, n-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° counterclockwise; turn it clockwise
+				sx, sy = y, n-x
+			case 7: // transversed
+				sx, sy = n-y, n-x
+			case 8: // rotated 90° clockwise; turn it counterclockwise
+				sx, sy = n-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
+
+// resize scales the square src to size×size pixels. Each output pixel
+// averages the source pixels it covers, which keeps downscaled photos free
+// of aliasing; upscaling repeats pixels.
+func resize(src *image.RGBA, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	edge := src.Bounds().Dx()
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, size, edge)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, size, edge)
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+int(p[0]), g+int(p[1]), b+int(p[2]), a+int(p[3])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// span returns the source pixel range [lo, hi) covered by output pixel i
+// when scaling edge source pixels to size output pixels. It is never empty.
+func span(i, size, edge int) (lo, hi int) {
+	lo, hi = i*edge/size, (i+1)*edge/size
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
@@ -0,0 +1,161 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// split draws a w×h image, red on the left half and blue on the right.
+func split(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func pngBytes(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// jpegWithOrientation encodes img as a JPEG carrying an EXIF segment with
+// the given orientation and a GPS-looking marker string.
+func jpegWithOrientation(t *testing.T, img image.Image, orientation byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // big-endian header, IFD0 at 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, // orientation, SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	payload = append(payload, []byte("GPS 48.8584N 2.2945E")...)
+	segment := append([]byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+
+	out := append([]byte{}, buf.Bytes()[:2]...) // SOI
+	out = append(out, segment...)
+	return append(out, buf.Bytes()[2:]...)
+}
+
+func TestThumbnails(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		wantErr     error
+	}{
+		{name: "landscape", data: pngBytes(t, split(300, 200)), contentType: "image/png"},
+		{name: "smaller than a thumbnail", data: pngBytes(t, split(20, 20)), contentType: "image/png"},
+		{name: "not an image", data: []byte("%PDF-1.7"), contentType: "image/png", wantErr: ErrInvalid},
+		{name: "declared as another type", data: pngBytes(t, split(20, 20)), contentType: "image/jpeg", wantErr: ErrInvalid},
+		{name: "truncated", data: pngBytes(t, split(20, 20))[:40], contentType: "image/png", wantErr: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumbs, err := Thumbnails(tt.data, tt.contentType, []int{64, 128})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for i, size := range []int{64, 128} {
+				img, err := jpeg.Decode(bytes.NewReader(thumbs[i]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+					t.Errorf("thumbnail %d is %dx%d", size, b.Dx(), b.Dy())
+				}
+			}
+		})
+	}
+}
+
+// isRed and isBlue tell the halves of split apart after JPEG compression.
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xc000 && b < 0x4000
+}
+
+func isBlue(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return b > 0xc000 && r < 0x4000
+}
+
+func TestThumbnailsCropsCenter(t *testing.T) {
+	// The central square of a 300x100 image split at x=150 is half red, half
+	// blue, so the left and right edges keep their colors
+	thumbs, err := Thumbnails(pngBytes(t, split(300, 100)), "image/png", []int{64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := jpeg.Decode(bytes.NewReader(thumbs[0]))
+	if !isRed(img.At(2, 32)) || !isBlue(img.At(61, 32)) {
+		t.Errorf("edges = %v, %v; want red, blue", img.At(2, 32), img.At(61, 32))
+	}
+}
+
+func TestThumbnailsAppliesOrientationAndStripsMetadata(t *testing.T) {
+	// Orientation 6: the camera was turned, so the stored left half is the
+	// top of the picture
+	data := jpegWithOrientation(t, split(64, 64), 6)
+	if got := Orientation(data); got != 6 {
+		t.Fatalf("Orientation = %d, want 6", got)
+	}
+
+	thumbs, err := Thumbnails(data, "image/jpeg", []int{64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := jpeg.Decode(bytes.NewReader(thumbs[0]))
+	if !isRed(img.At(32, 2)) || !isBlue(img.At(32, 61)) {
+		t.Errorf("top, bottom = %v, %v; want red, blue", img.At(32, 2), img.At(32, 61))
+	}
+	if bytes.Contains(thumbs[0], []byte("Exif")) || bytes.Contains(thumbs[0], []byte("GPS")) {
+		t.Error("thumbnail carries the upload's metadata")
+	}
+	if Orientation(thumbs[0]) != 1 {
+		t.Error("thumbnail carries an orientation")
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{name: "mirrored", data: jpegWithOrientation(t, split(8, 8), 2), want: 2},
+		{name: "out of range", data: jpegWithOrientation(t, split(8, 8), 9), want: 1},
+		{name: "no exif", data: pngBytes(t, split(8, 8)), want: 1},
+		{name: "truncated", data: []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x10}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Orientation(tt.data); got != tt.want {
+				t.Errorf("Orientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+// Package messages stores direct messages between two users. The message
+// table is a single-table design: each conversation is one partition
+// (conversation_id, derived from the two user IDs), holding
+//
+//   - its messages, sorted by time: entry "MSG#<sent_at>#<message_id>";
+//   - one member item per user, entry "MEMBER#<user_id>", with the user's
+//     unread count and a copy of the last message. Member items carry the
+//     attribute inbox, the user, so the sparse GSI on inbox, sorted by
+//     last_message_at, lists a user's conversations, latest first.
+//
+// A message and both member items are written in one transaction, so the
+// inbox never disagrees with the conversation.
+package messages
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+const (
+	// MaxBodyLength bounds message bodies, in characters.
+	MaxBodyLength = 2000
+
+	// previewLength bounds the copy of the last message kept in the inbox.
+	previewLength = 100
+
+	// timeLayout is RFC 3339 with fixed-width microseconds, so entries sort
+	// by time as strings.
+	timeLayout = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// Entry prefixes of the sort key.
+const (
+	messagePrefix = "MSG#"
+	memberPrefix  = "MEMBER#"
+)
+
+// Error codes of invalid messages.
+const (
+	CodeBodyRequired = "MESSAGE_BODY_REQUIRED"
+	CodeBodyInvalid  = "MESSAGE_BODY_INVALID"
+	CodeSelf         = "MESSAGE_SELF"
+)
+
+// Message is one message of a conversation.
+type Message struct {
+	ID          string    `json:"message_id"`
+	SenderID    string    `json:"sender_id"`
+	RecipientID string    `json:"recipient_id"`
+	Body        string    `json:"body"`
+	SentAt      time.Time `json:"sent_at"`
+}
+
+// Conversation is a conversation as one of its users sees it in their
+// inbox.
+type Conversation struct {
+	OtherID       string     `json:"user_id"`
+	LastMessage   string     `json:"last_message"` // shortened to 100 characters
+	LastSenderID  string     `json:"last_sender_id"`
+	LastMessageAt time.Time  `json:"last_message_at"`
+	UnreadCount   int        `json:"unread_count"`
+	ReadAt        *time.Time `json:"read_at,omitempty"` // when the user last read the conversation
+}
+
+// Store reads and writes the message table.
+type Store struct {
+	DB         *db.Client
+	Table      string
+	InboxIndex string // GSI keyed by inbox, sorted by last_message_at
+	UserTable  string
+}
+
+// NewStore returns a store over the tables named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.MessageTableName, InboxIndex: cfg.MessageInboxIndexName, UserTable: cfg.UserTableName}
+}
+
+// ConversationID returns the ID of the conversation of a and b, the same
+// whichever of them asks.
+func ConversationID(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + "#" + b
+}
+
+// NormalizeBody validates a message body and returns it trimmed.
+func NormalizeBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	switch {
+	case body == "":
+		return "", apperr.Invalid(CodeBodyRequired, "body", "body is required")
+	case !utf8.ValidString(body), utf8.RuneCountInString(body) > MaxBodyLength,
+		strings.IndexFunc(body, func(r rune) bool { return unicode.IsControl(r) && r != '\n' && r != '\t' }) >= 0:
+		return "", apperr.Invalid(CodeBodyInvalid, "body", fmt.Sprintf("body must be at most %d characters of text", MaxBodyLength))
+	}
+	return body, nil
+}
+
+// NewID returns a random message ID.
+func NewID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Send stores a message of body, which callers must have normalized with
+// NormalizeBody, from one user to another. The sender's unread count is
+// reset, as replying reads the conversation; the recipient's goes up by one.
+func (s *Store) Send(ctx context.Context, from, to, body string) (Message, error) {
+	if from == to {
+		return Message{}, apperr.Invalid(CodeSelf, "other_id", "a user cannot message themselves")
+	}
+	msg := Message{ID: NewID(), SenderID: from, RecipientID: to, Body: body, SentAt: time.Now().UTC()}
+	at := msg.SentAt.Format(timeLayout)
+	cid := ConversationID(from, to)
+
+	err := s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName: aws.String(s.Table),
+			Item: db.Item{
+				"conversation_id": &types.AttributeValueMemberS{Value: cid},
+				"entry":           &types.AttributeValueMemberS{Value: messagePrefix + at + "#" + msg.ID},
+				"message_id":      &types.AttributeValueMemberS{Value: msg.ID},
+				"sender_id":       &types.AttributeValueMemberS{Value: from},
+				"recipient_id":    &types.AttributeValueMemberS{Value: to},
+				"body":            &types.AttributeValueMemberS{Value: body},
+				"sent_at":         &types.AttributeValueMemberS{Value: at},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(entry)"),
+		}},
+		s.touch(from, to, msg, true),
+		s.touch(to, from, msg, false),
+		{ConditionCheck: &types.ConditionCheck{
+			TableName:           aws.String(s.UserTable),
+			Key:                 db.Item{"user_id": &types.AttributeValueMemberS{Value: to}},
+			ConditionExpression: aws.String("attribute_exists(user_id)"),
+		}},
+	})
+	if db.ConditionFailed(err, 3) {
+		return Message{}, apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return Message{}, db.Wrap(err, "sending message")
+	}
+	return msg, nil
+}
+
+// touch updates the member item of owner for msg, creating it for the
+// first message of the conversation.
+func (s *Store) touch(owner, other string, msg Message, read bool) types.TransactWriteItem {
+	at := msg.SentAt.Format(timeLayout)
+	values := map[string]types.AttributeValue{
+		":owner":   &types.AttributeValueMemberS{Value: owner},
+		":other":   &types.AttributeValueMemberS{Value: other},
+		":at":      &types.AttributeValueMemberS{Value: at},
+		":preview": &types.AttributeValueMemberS{Value: preview(msg.Body)},
+		":sender":  &types.AttributeValueMemberS{Value: msg.SenderID},
+	}
+	update := "SET inbox = :owner, other_id = :other, last_message_at = :at, last_message = :preview, last_sender_id = :sender"
+	if read {
+		update += ", unread_count = :zero, read_at = :at"
+		values[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+	} else {
+		update += " ADD unread_count :one"
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+	}
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(s.Table),
+		Key:                       memberKey(owner, other),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: values,
+	}}
+}
+
+// MarkRead resets the unread count of me's conversation with other and
+// returns when me read it. A conversation without messages is
+// apperr.NotFound.
+func (s *Store) MarkRead(ctx context.Context, me, other string) (time.Time, error) {
+	now := time.Now().UTC()
+	start := time.Now()
+	_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 memberKey(me, other),
+		UpdateExpression:    aws.String("SET unread_count = :zero, read_at = :now"),
+		ConditionExpression: aws.String("attribute_exists(entry)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+			":now":  &types.AttributeValueMemberS{Value: now.Format(timeLayout)},
+		},
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return time.Time{}, apperr.NotFound("Conversation not found")
+	}
+	return now, db.Wrap(err, "marking conversation read")
+}
+
+// Conversations returns one page of the conversations of me, latest first,
+// and the key to pass as start for the next page (nil on the last).
+func (s *Store) Conversations(ctx context.Context, me string, limit int32, start db.Item) ([]Conversation, db.Item, error) {
+	items, next, err := s.query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.InboxIndex),
+		KeyConditionExpression: aws.String("inbox = :me"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":me": &types.AttributeValueMemberS{Value: me},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		ExclusiveStartKey: start,
+		Limit:             aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	convs := make([]Conversation, 0, len(items))
+	for _, item := range items {
+		convs = append(convs, conversationOf(item))
+	}
+	return convs, next, nil
+}
+
+// Messages returns one page of the conversation of me and other, latest
+// first, and the key to pass as start for the next page (nil on the last).
+func (s *Store) Messages(ctx context.Context, me, other string, limit int32, start db.Item) ([]Message, db.Item, error) {
+	items, next, err := s.query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("conversation_id = :id AND begins_with(entry, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id":     &types.AttributeValueMemberS{Value: ConversationID(me, other)},
+			":prefix": &types.AttributeValueMemberS{Value: messagePrefix},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		ExclusiveStartKey: start,
+		Limit:             aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	msgs := make([]Message, 0, len(items))
+	for _, item := range items {
+		msgs = append(msgs, messageOf(item))
+	}
+	return msgs, next, nil
+}
+
+// query runs one page of input.
+func (s *Store) query(ctx context.Context, input *dynamodb.QueryInput) ([]db.Item, db.Item, error) {
+	begin := time.Now()
+	result, err := s.DB.DynamoDB.Query(ctx, input)
+	db.Observe(ctx, begin, err)
+	if err != nil {
+		return nil, nil, db.Wrap(err, "listing messages")
+	}
+	if len(result.LastEvaluatedKey) == 0 {
+		return result.Items, nil, nil
+	}
+	return result.Items, result.LastEvaluatedKey, nil
+}
+
+// memberKey returns the primary key of owner's member item of their
+// conversation with other.
+func memberKey(owner, other string) db.Item {
+	return db.Item{
+		"conversation_id": &types.AttributeValueMemberS{Value: ConversationID(owner, other)},
+		"entry":           &types.AttributeValueMemberS{Value: memberPrefix + owner},
+	}
+}
+
+// preview shortens body to the copy kept in the inbox.
+func preview(body string) string {
+	if utf8.RuneCountInString(body) <= previewLength {
+		return body
+	}
+	return string([]rune(body)[:previewLength-1]) + "…"
+}
+
+func messageOf(item db.Item) Message {
+	m := Message{
+		ID:          str(item, "message_id"),
+		SenderID:    str(item, "sender_id"),
+		RecipientID: str(item, "recipient_id"),
+		Body:        str(item, "body"),
+	}
+	m.SentAt, _ = time.Parse(timeLayout, str(item, "sent_at"))
+	return m
+}
+
+func conversationOf(item db.Item) Conversation {
+	c := Conversation{
+		OtherID:      str(item, "other_id"),
+		LastMessage:  str(item, "last_message"),
+		LastSenderID: str(item, "last_sender_id"),
+	}
+	c.LastMessageAt, _ = time.Parse(timeLayout, str(item, "last_message_at"))
+	if n, ok := item["unread_count"].(*types.AttributeValueMemberN); ok {
+		c.UnreadCount, _ = strconv.Atoi(n.Value)
+	}
+	if t, err := time.Parse(timeLayout, str(item, "read_at")); err == nil {
+		c.ReadAt = &t
+	}
+	return c
+}
+
+// str returns the string attribute name of item, or "".
+func str(item db.Item, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package messages
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func TestNormalizeBody(t *testing.T) {
+	tests := []struct {
+		body     string
+		want     string
+		wantCode string // empty means valid
+	}{
+		{body: "  hi there\n", want: "hi there"},
+		{body: "line one\nline two\ttabbed", want: "line one\nline two\ttabbed"},
+		{body: strings.Repeat("é", MaxBodyLength), want: strings.Repeat("é", MaxBodyLength)},
+		{body: strings.Repeat("a", MaxBodyLength+1), wantCode: CodeBodyInvalid},
+		{body: "bell\a", wantCode: CodeBodyInvalid},
+		{body: "\xff", wantCode: CodeBodyInvalid},
+		{body: " \n ", wantCode: CodeBodyRequired},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeBody(tt.body)
+		switch {
+		case tt.wantCode == "" && (err != nil || got != tt.want):
+			t.Errorf("NormalizeBody(%.20q) = %.20q, %v; want %.20q", tt.body, got, err, tt.want)
+		case tt.wantCode != "" && apperr.As(err).Code != tt.wantCode:
+			t.Errorf("NormalizeBody(%.20q) error = %v, want %s", tt.body, err, tt.wantCode)
+		}
+	}
+}
+
+func TestConversationID(t *testing.T) {
+	if ConversationID("u1", "u2") != ConversationID("u2", "u1") {
+		t.Error("conversation ID depends on who asks")
+	}
+}
+
+func TestSend(t *testing.T) {
+	m := &dbtest.Mock{}
+	s := &Store{DB: m.Client(), Table: "messages", UserTable: "users"}
+
+	msg, err := s.Send(context.Background(), "u2", "u1", strings.Repeat("x", 150))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID == "" || msg.SenderID != "u2" || msg.RecipientID != "u1" || msg.SentAt.IsZero() {
+		t.Errorf("Send = %+v", msg)
+	}
+
+	items := m.Calls[0].Input.(*dynamodb.TransactWriteItemsInput).TransactItems
+	if len(items) != 4 {
+		t.Fatalf("transaction of %d items, want 4", len(items))
+	}
+	put := items[0].Put.Item
+	if str(put, "conversation_id") != "u1#u2" || !strings.HasPrefix(str(put, "entry"), "MSG#") {
+		t.Errorf("message stored under %s %s", str(put, "conversation_id"), str(put, "entry"))
+	}
+	sender, recipient := items[1].Update, items[2].Update
+	if str(sender.Key, "entry") != "MEMBER#u2" || !strings.Contains(aws.ToString(sender.UpdateExpression), "unread_count = :zero") {
+		t.Errorf("sender update %s %s", str(sender.Key, "entry"), aws.ToString(sender.UpdateExpression))
+	}
+	if str(recipient.Key, "entry") != "MEMBER#u1" || !strings.Contains(aws.ToString(recipient.UpdateExpression), "ADD unread_count :one") {
+		t.Errorf("recipient update %s %s", str(recipient.Key, "entry"), aws.ToString(recipient.UpdateExpression))
+	}
+	if p := str(recipient.ExpressionAttributeValues, ":preview"); len([]rune(p)) != previewLength {
+		t.Errorf("preview of %d characters, want %d", len([]rune(p)), previewLength)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		to       string
+		err      error
+		wantKind apperr.Kind
+	}{
+		{name: "unknown recipient", to: "u9", err: dbtest.TransactionCanceled("None", "None", "None", "ConditionalCheckFailed"), wantKind: apperr.KindNotFound},
+		{name: "self", to: "u1", wantKind: apperr.KindInvalid},
+		{name: "throttled", to: "u2", err: dbtest.Throttled(), wantKind: apperr.KindThrottled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				return nil, tt.err
+			}}
+			s := &Store{DB: m.Client(), Table: "messages", UserTable: "users"}
+
+			if _, err := s.Send(context.Background(), "u1", tt.to, "hi"); apperr.KindOf(err) != tt.wantKind || err == nil {
+				t.Errorf("Send = %v, want kind %v", err, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestMarkReadUnknownConversation(t *testing.T) {
+	m := &dbtest.Mock{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		return nil, dbtest.ConditionFailed()
+	}}
+	s := &Store{DB: m.Client(), Table: "messages"}
+
+	if _, err := s.MarkRead(context.Background(), "u1", "u2"); apperr.KindOf(err) != apperr.KindNotFound {
+		t.Errorf("MarkRead = %v, want not found", err)
+	}
+}
@@ -0,0 +1,266 @@
+// Package metrics records CloudWatch metrics using the Embedded Metric Format
+// (EMF): metrics are written to stdout as structured log lines, which
+// CloudWatch Logs turns into metrics without any PutMetricData API calls.
+//
+// Handlers collect metrics on a per-invocation Recorder attached to the
+// context (httpx.Adapt sets one up and flushes it once the response is
+// built), so an invocation produces a single EMF document. Metrics recorded
+// on a context without a Recorder are flushed immediately.
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// EnvNamespace overrides the CloudWatch namespace metrics are published to.
+const EnvNamespace = "METRICS_NAMESPACE"
+
+// DefaultNamespace is used when METRICS_NAMESPACE is unset.
+const DefaultNamespace = "Troggle"
+
+// Unit is a CloudWatch metric unit.
+type Unit string
+
+// Units used by troggle metrics.
+const (
+	UnitCount        Unit = "Count"
+	UnitMilliseconds Unit = "Milliseconds"
+)
+
+// Metric names shared across functions.
+const (
+	LookupHit              = "lookup_hit"
+	LookupMiss             = "lookup_miss"
+	DynamoError            = "dynamo_error"
+	DynamoLatency          = "dynamo_latency"
+	HandlerDuration        = "handler_duration"
+	HandlerError           = "handler_error"
+	HandlerPanic           = "handler_panic"
+	APIRequest             = "api_request"
+	DeprecatedRequest      = "deprecated_request"
+	ResponseOffloaded      = "response_offloaded"
+	RateLimited            = "rate_limited"
+	EnumerationSuspected   = "enumeration_suspected"
+	DuplicateEmail         = "duplicate_email"
+	SignupBlocked          = "signup_blocked"
+	LoginCodeSent          = "login_code_sent"
+	LoginCodeFailed        = "login_code_failed"
+	UserMigrated           = "user_migrated"
+	PasswordResetSent      = "password_reset_sent"
+	PasswordResetCompleted = "password_reset_completed"
+	PasswordResetFailed    = "password_reset_failed"
+	PasswordResetBurst     = "password_reset_burst"
+	ConsistentReadHit      = "consistent_read_hit"
+	CacheHit               = "cache_hit"
+	CacheMiss              = "cache_miss"
+	DAXFailover            = "dax_failover"
+	DynamoThrottled        = "dynamo_throttled"
+	DynamoTimeout          = "dynamo_timeout"
+	DynamoTimeoutLatency   = "dynamo_timeout_latency"
+	DynamoShed             = "dynamo_shed"
+	CircuitOpened          = "circuit_opened"
+	EmailSent              = "email_sent"
+	EmailSuppressed        = "email_suppressed"
+	EmailRateLimited       = "email_rate_limited"
+	EmailRejected          = "email_rejected"
+	SuppressionAdded       = "suppression_added"
+	PushSent               = "push_sent"
+	PushFailed             = "push_failed"
+	PushPruned             = "push_pruned"
+
+	QueueMessageRetried      = "queue_message_retried"
+	QueueMessageDropped      = "queue_message_dropped"
+	QueueMessageDeadLettered = "queue_message_dead_lettered"
+	QueuePanic               = "queue_panic"
+
+	StreamRecordAge    = "stream_record_age"
+	StreamRecordFailed = "stream_record_failed"
+
+	AuditWriteFailed = "audit_write_failed"
+	AuditArchived    = "audit_archived"
+
+	ExportCompleted = "export_completed"
+	ExportFailed    = "export_failed"
+
+	AvatarProcessed = "avatar_processed"
+	AvatarRejected  = "avatar_rejected"
+
+	SearchLatency = "search_latency"
+
+	RealtimeSent    = "realtime_sent"
+	RealtimeFailed  = "realtime_failed"
+	RealtimePruned  = "realtime_pruned"
+	RealtimeLatency = "realtime_latency"
+
+	CleanupSessionsRemoved    = "cleanup_sessions_removed"
+	CleanupInvitationsRemoved = "cleanup_invitations_removed"
+	CleanupDevicesRemoved     = "cleanup_devices_removed"
+	CleanupAccountsRemoved    = "cleanup_accounts_removed"
+)
+
+// output is where EMF documents are written; Lambda ships stdout to
+// CloudWatch Logs.
+var output io.Writer = os.Stdout
+
+// ctxKey is the context key of the invocation's Recorder.
+type ctxKey struct{}
+
+// Recorder accumulates metric values until Flush.
+type Recorder struct {
+	mu         sync.Mutex
+	namespace  string
+	dimensions map[string]string
+	extra      map[string]string // see SetDimension
+	units      map[string]Unit
+	values     map[string][]float64
+	order      []string // metric names in first-recorded order
+}
+
+// NewRecorder returns an empty Recorder dimensioned by function name.
+func NewRecorder() *Recorder {
+	namespace := os.Getenv(EnvNamespace)
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	dims := map[string]string{}
+	if name := lambdacontext.FunctionName; name != "" {
+		dims["FunctionName"] = name
+	}
+
+	return &Recorder{
+		namespace:  namespace,
+		dimensions: dims,
+		extra:      map[string]string{},
+		units:      map[string]Unit{},
+		values:     map[string][]float64{},
+	}
+}
+
+// NewContext returns a context carrying a fresh Recorder.
+func NewContext(ctx context.Context) (context.Context, *Recorder) {
+	r := NewRecorder()
+	return context.WithValue(ctx, ctxKey{}, r), r
+}
+
+// Add records one observation of a metric.
+func (r *Recorder) Add(name string, value float64, unit Unit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.units[name]; !ok {
+		r.order = append(r.order, name)
+	}
+	r.units[name] = unit
+	r.values[name] = append(r.values[name], value)
+}
+
+// SetDimension dimensions the recorded metrics by name as well, e.g. by API
+// version. They keep being published under the function name alone too, so
+// existing dashboards and alarms see every invocation.
+func (r *Recorder) SetDimension(name, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.extra[name] = value
+}
+
+// Flush writes the recorded metrics as one EMF document and resets the
+// Recorder. Flushing an empty Recorder writes nothing.
+func (r *Recorder) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.order) == 0 {
+		return
+	}
+
+	type metricDef struct {
+		Name string `json:"Name"`
+		Unit Unit   `json:"Unit"`
+	}
+	type directive struct {
+		Namespace  string      `json:"Namespace"`
+		Dimensions [][]string  `json:"Dimensions"`
+		Metrics    []metricDef `json:"Metrics"`
+	}
+
+	dimNames := make([]string, 0, len(r.dimensions))
+	doc := map[string]any{}
+	for k, v := range r.dimensions {
+		dimNames = append(dimNames, k)
+		doc[k] = v
+	}
+
+	d := directive{Namespace: r.namespace, Dimensions: [][]string{dimNames}}
+	if len(r.extra) > 0 {
+		all := slices.Clone(dimNames)
+		for k, v := range r.extra {
+			all = append(all, k)
+			doc[k] = v
+		}
+		slices.Sort(all[len(dimNames):])
+		d.Dimensions = append(d.Dimensions, all)
+	}
+	for _, name := range r.order {
+		d.Metrics = append(d.Metrics, metricDef{Name: name, Unit: r.units[name]})
+		if vals := r.values[name]; len(vals) == 1 {
+			doc[name] = vals[0]
+		} else {
+			doc[name] = vals
+		}
+	}
+	doc["_aws"] = map[string]any{
+		"Timestamp":         time.Now().UnixMilli(),
+		"CloudWatchMetrics": []directive{d},
+	}
+
+	line, err := json.Marshal(doc)
+	if err != nil {
+		slog.Error("Error encoding metrics", "error", err)
+	} else {
+		_, _ = output.Write(append(line, '\n'))
+	}
+
+	r.units = map[string]Unit{}
+	r.values = map[string][]float64{}
+	r.order = nil
+}
+
+// Add records a metric on the context's Recorder, or emits it on its own
+// when there is none.
+func Add(ctx context.Context, name string, value float64, unit Unit) {
+	if r, ok := ctx.Value(ctxKey{}).(*Recorder); ok {
+		r.Add(name, value, unit)
+		return
+	}
+	r := NewRecorder()
+	r.Add(name, value, unit)
+	r.Flush()
+}
+
+// SetDimension dimensions the metrics of the context's Recorder by name as
+// well. It does nothing without a Recorder.
+func SetDimension(ctx context.Context, name, value string) {
+	if r, ok := ctx.Value(ctxKey{}).(*Recorder); ok {
+		r.SetDimension(name, value)
+	}
+}
+
+// Count records one occurrence of a counter.
+func Count(ctx context.Context, name string) {
+	Add(ctx, name, 1, UnitCount)
+}
+
+// Since records the milliseconds elapsed since start.
+func Since(ctx context.Context, name string, start time.Time) {
+	Add(ctx, name, float64(time.Since(start).Microseconds())/1000, UnitMilliseconds)
+}
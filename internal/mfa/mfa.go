@@ -0,0 +1,205 @@
+// Package mfa keeps the second factors of accounts, and the step-up check
+// of high-risk operations.
+//
+// Cognito holds the factors themselves: a TOTP secret is associated with,
+// and verified against, the user's access token, and the user's MFA
+// preference makes Cognito ask for a code at every sign-in. Each factor is
+// also recorded as an entity of the single table (see package userdata),
+// with what Cognito does not keep: its name, its status, and when it was
+// enrolled and verified. Factors are listed and checked from there without
+// calling Cognito.
+//
+// Cognito tokens do not say which challenges their sign-in answered, but a
+// user with an active factor cannot sign in without it, so their auth_time
+// is the time of their last MFA challenge. Guard refuses high-risk
+// operations to such users when that is older than MFA_MAX_AGE, and, with
+// MFA_REQUIRED, to users without an active factor.
+package mfa
+
+import (
+	"context"
+	"time"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/userdata"
+)
+
+// TypeTOTP is the type of authenticator app factors, the only type so far.
+const TypeTOTP = "totp"
+
+// Statuses of a factor.
+const (
+	StatusPending = "pending" // secret handed out, no code verified yet
+	StatusActive  = "active"  // verified; Cognito asks for it at sign-in
+)
+
+var (
+	// ErrMFARequired refuses high-risk operations to users without an
+	// active factor when MFA_REQUIRED is set.
+	ErrMFARequired = &apperr.Error{Kind: apperr.KindForbidden, Code: "MFA_REQUIRED", Message: "Set up two-factor authentication to do this"}
+
+	// ErrReauthRequired refuses high-risk operations to users whose last
+	// MFA challenge is older than MFA_MAX_AGE.
+	ErrReauthRequired = &apperr.Error{Kind: apperr.KindForbidden, Code: "REAUTHENTICATION_REQUIRED", Message: "Sign in again to do this"}
+)
+
+// Factor is the model of a second factor.
+type Factor struct {
+	UserID     string `dynamodbav:"user_id" json:"-"`
+	Type       string `dynamodbav:"type" json:"type"`
+	Name       string `dynamodbav:"name" json:"name"` // chosen by the user, e.g. "Phone"
+	Status     string `dynamodbav:"status" json:"status"`
+	CreatedAt  string `dynamodbav:"created_at" json:"created_at"`                       // RFC 3339
+	VerifiedAt string `dynamodbav:"verified_at,omitempty" json:"verified_at,omitempty"` // RFC 3339
+}
+
+// Store reads and writes the factors of users.
+type Store struct {
+	Data *userdata.Store
+}
+
+// NewStore returns a store over the single table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{Data: userdata.NewStore(client, cfg)}
+}
+
+// Put writes f, replacing the factor of its user and type.
+func (s *Store) Put(ctx context.Context, f Factor) error {
+	return s.Data.Put(ctx, userdata.MFAFactor, f.UserID, userdata.MFASK(f.Type), f)
+}
+
+// Get returns the factor of userID of type factorType, or nil if there is
+// none.
+func (s *Store) Get(ctx context.Context, userID, factorType string) (*Factor, error) {
+	item, err := s.Data.Get(ctx, userID, userdata.MFASK(factorType))
+	if err != nil || item == nil {
+		return nil, err
+	}
+	f, err := userdata.Unmarshal[Factor](item, userdata.MFAFactor)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// List returns the factors of userID, pending ones included.
+func (s *Store) List(ctx context.Context, userID string) ([]Factor, error) {
+	items, err := s.Data.List(ctx, userID, userdata.MFAPrefix)
+	if err != nil {
+		return nil, err
+	}
+	factors := make([]Factor, 0, len(items))
+	for _, item := range items {
+		f, err := userdata.Unmarshal[Factor](item, userdata.MFAFactor)
+		if err != nil {
+			return nil, err
+		}
+		factors = append(factors, f)
+	}
+	return factors, nil
+}
+
+// Delete removes the factor of userID of type factorType, if any.
+func (s *Store) Delete(ctx context.Context, userID, factorType string) error {
+	return s.Data.Delete(ctx, userID, userdata.MFASK(factorType))
+}
+
+// Enrolled reports whether userID has an active factor.
+func (s *Store) Enrolled(ctx context.Context, userID string) (bool, error) {
+	factors, err := s.List(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range factors {
+		if f.Status == StatusActive {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Guard is the step-up check of high-risk operations.
+type Guard struct {
+	Factors  *Store
+	MaxAge   time.Duration
+	Required bool
+	Now      func() time.Time // time.Now when nil
+}
+
+// NewGuard returns the guard configured by cfg.
+func NewGuard(client *db.Client, cfg *config.Config) *Guard {
+	return &Guard{Factors: NewStore(client, cfg), MaxAge: cfg.MFAMaxAge, Required: cfg.MFARequired}
+}
+
+// Check returns nil when id may take a high-risk operation: it signed in,
+// answering its MFA challenge, within MaxAge, or has no factor and factors
+// are not Required. API keys are let through; they never sign in, and
+// their grants bound what they can do.
+func (g *Guard) Check(ctx context.Context, id *auth.Identity) error {
+	if id.TokenUse == auth.TokenUseAPIKey {
+		return nil
+	}
+	enrolled, err := g.Factors.Enrolled(ctx, id.Subject)
+	if err != nil {
+		return err
+	}
+	if !enrolled {
+		if g.Required {
+			return ErrMFARequired
+		}
+		return nil
+	}
+	return g.CheckRecent(id)
+}
+
+// CheckRecent returns ErrReauthRequired unless id signed in within MaxAge,
+// whatever its factors.
+func (g *Guard) CheckRecent(id *auth.Identity) error {
+	if id.AuthTime.IsZero() || g.now().Sub(id.AuthTime) > g.MaxAge {
+		return ErrReauthRequired
+	}
+	return nil
+}
+
+// Require runs Check on the verified caller of r. Direct invocations, and
+// every request when g is nil, pass.
+func (g *Guard) Require(ctx context.Context, r *httpx.Request) error {
+	if g == nil || r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	return g.Check(ctx, id)
+}
+
+// Middleware returns a middleware refusing the requests Require fails,
+// for functions whose every route is high-risk. It goes after
+// auth.Middleware.
+func (g *Guard) Middleware() httpx.Middleware {
+	return func(next httpx.Handler) httpx.Handler {
+		return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+			err := g.Require(ctx, r)
+			switch apperr.KindOf(err) {
+			case apperr.KindForbidden, apperr.KindUnauthorized:
+				return httpx.Error(err), nil
+			}
+			if err != nil {
+				return httpx.Response{}, err
+			}
+			return next(ctx, r)
+		}
+	}
+}
+
+func (g *Guard) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,89 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/userdata"
+)
+
+// factorsOf answers queries with one factor of the given status, or none.
+func factorsOf(status string) func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		if status == "" {
+			return &dynamodb.QueryOutput{}, nil
+		}
+		item, err := userdata.Marshal(userdata.MFAFactor, "u1", userdata.MFASK(TypeTOTP), Factor{UserID: "u1", Type: TypeTOTP, Status: status})
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.QueryOutput{Items: []db.Item{item}}, nil
+	}
+}
+
+func TestGuardCheck(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		status   string // of the user's factor, "" for none
+		required bool
+		authTime time.Time
+		tokenUse string
+		want     error
+	}{
+		{name: "recent sign-in", status: StatusActive, authTime: now.Add(-5 * time.Minute)},
+		{name: "old sign-in", status: StatusActive, authTime: now.Add(-time.Hour), want: ErrReauthRequired},
+		{name: "no auth_time", status: StatusActive, want: ErrReauthRequired},
+		{name: "no factor", authTime: now.Add(-time.Hour)},
+		{name: "pending factor", status: StatusPending, authTime: now.Add(-time.Hour)},
+		{name: "no factor, required", required: true, authTime: now, want: ErrMFARequired},
+		{name: "API key", status: StatusActive, tokenUse: auth.TokenUseAPIKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: factorsOf(tt.status)}
+			g := &Guard{
+				Factors:  &Store{Data: &userdata.Store{DB: m.Client(), Table: "user-data"}},
+				MaxAge:   15 * time.Minute,
+				Required: tt.required,
+				Now:      func() time.Time { return now },
+			}
+			tokenUse := tt.tokenUse
+			if tokenUse == "" {
+				tokenUse = "access"
+			}
+			err := g.Check(context.Background(), &auth.Identity{Subject: "u1", TokenUse: tokenUse, AuthTime: tt.authTime})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Check = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	m := &dbtest.Mock{QueryFunc: factorsOf(StatusActive)}
+	s := &Store{Data: &userdata.Store{DB: m.Client(), Table: "user-data"}}
+	factors, err := s.List(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(factors) != 1 || factors[0].Type != TypeTOTP || factors[0].Status != StatusActive {
+		t.Errorf("factors = %+v", factors)
+	}
+
+	if err := s.Delete(context.Background(), "u1", TypeTOTP); err != nil {
+		t.Fatal(err)
+	}
+	in := m.Calls[len(m.Calls)-1].Input.(*dynamodb.DeleteItemInput)
+	if sk, ok := in.Key[userdata.SortKey].(*types.AttributeValueMemberS); !ok || sk.Value != "MFA#totp" {
+		t.Errorf("deleted key = %v", in.Key)
+	}
+}
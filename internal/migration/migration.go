@@ -0,0 +1,231 @@
+// Package migration evolves the shape of stored items. Items carry the
+// version of the shape they were written in as VersionAttribute, absent for
+// items that predate it and count as version 0. A Schema lists the steps
+// from each version to the next; repositories upgrade the items they read
+// with it, so code only ever sees the current shape, and Backfill rewrites
+// the items of a table in place, run by the migrate command, so the steps
+// can eventually be dropped.
+//
+// A step must only add, change and remove attributes of the item, and leave
+// items it does not apply to as they are: an upgrade on read and a backfill
+// can run it on the same item, in any order.
+package migration
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+)
+
+// VersionAttribute holds the schema version of an item.
+const VersionAttribute = "schema_version"
+
+// Step upgrades item, in place, from the version it is at to the next one.
+// It replaces attribute values rather than modifying them: Backfill keeps
+// the item as read to tell what changed.
+type Step func(item db.Item) error
+
+// Schema is the history of the shape of the items of a table.
+type Schema struct {
+	Name  string   // names the schema in errors, flags and logs
+	Key   []string // attributes of the primary key of the table
+	Steps []Step   // Steps[v] upgrades items from version v to v+1
+
+	// Of reports whether item is of the schema; the others, such as
+	// sentinels sharing the table, are left alone. Nil for every item.
+	Of func(item db.Item) bool
+}
+
+// Current returns the version items are upgraded to and written in.
+func (s *Schema) Current() int {
+	return len(s.Steps)
+}
+
+// Version returns the schema version of item, 0 if it has none.
+func Version(item db.Item) int {
+	if v, ok := item[VersionAttribute].(*types.AttributeValueMemberN); ok {
+		n, _ := strconv.Atoi(v.Value)
+		return n
+	}
+	return 0
+}
+
+// Upgrade runs the steps from the version of item to the current one, in
+// place, and reports whether there were any. Items written by newer code,
+// at a version past the current one, are left as they are.
+func (s *Schema) Upgrade(item db.Item) (bool, error) {
+	if item == nil || (s.Of != nil && !s.Of(item)) {
+		return false, nil
+	}
+	from := Version(item)
+	if from >= s.Current() {
+		return false, nil
+	}
+	for v := from; v < s.Current(); v++ {
+		if err := s.Steps[v](item); err != nil {
+			return false, fmt.Errorf("upgrading %s item from version %d: %w", s.Name, v, err)
+		}
+	}
+	item[VersionAttribute] = &types.AttributeValueMemberN{Value: strconv.Itoa(s.Current())}
+	return true, nil
+}
+
+// Options tune a Backfill.
+type Options struct {
+	Segments int     // parallel scan segments; at least 1
+	Rate     float64 // items read per second, across segments; 0 for no limit
+	DryRun   bool    // count the items to upgrade without writing them
+}
+
+// Stats counts what a Backfill did. Conflicts are items written by someone
+// else between the read and the upgrade of the backfill; they are left for
+// the next run, or for the upgrade on read.
+type Stats struct {
+	Schema    string
+	Scanned   int
+	Upgraded  int
+	Conflicts int
+}
+
+// pageSize bounds the items a rate-limited scan reads at once, so it does
+// not burst far past the rate.
+const pageSize = 100
+
+// Backfill upgrades every item of table that is behind the current version
+// of s, reading it with parallel scans. Each item is written with an update
+// of the attributes its upgrade changed, on the condition that its version
+// is still the one read, so writes of other attributes made meanwhile are
+// kept and an item upgraded by someone else is not upgraded twice.
+func (s *Schema) Backfill(ctx context.Context, client *db.Client, table string, opts Options) (Stats, error) {
+	stats := Stats{Schema: s.Name}
+	var mu sync.Mutex
+	input := &dynamodb.ScanInput{TableName: aws.String(table)}
+	var limit *pacer
+	if opts.Rate > 0 {
+		input.Limit = aws.Int32(int32(min(pageSize, max(1, int(opts.Rate)))))
+		limit = &pacer{interval: time.Duration(float64(time.Second) / opts.Rate)}
+	}
+
+	err := client.ScanSegments(ctx, input, opts.Segments, func(ctx context.Context, items []db.Item) error {
+		if err := limit.wait(ctx, len(items)); err != nil {
+			return err
+		}
+		var upgraded, conflicts int
+		for _, item := range items {
+			read := maps.Clone(item)
+			ok, err := s.Upgrade(item)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			upgraded++
+			if opts.DryRun {
+				continue
+			}
+			if err := s.write(ctx, client, table, read, item); db.ConditionFailed(err, 0) {
+				upgraded--
+				conflicts++
+			} else if err != nil {
+				return err
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		stats.Scanned += len(items)
+		stats.Upgraded += upgraded
+		stats.Conflicts += conflicts
+		return nil
+	})
+	return stats, err
+}
+
+// write stores the upgrade of read to item.
+func (s *Schema) write(ctx context.Context, client *db.Client, table string, read, item db.Item) error {
+	key := make(db.Item, len(s.Key))
+	for _, name := range s.Key {
+		key[name] = read[name]
+	}
+
+	var update expression.UpdateBuilder
+	for name, v := range item {
+		if !reflect.DeepEqual(read[name], v) {
+			update = update.Set(expression.Name(name), expression.Value(v))
+		}
+	}
+	for name := range read {
+		if _, ok := item[name]; !ok {
+			update = update.Remove(expression.Name(name))
+		}
+	}
+	cond := expression.AttributeExists(expression.Name(s.Key[0]))
+	if from, ok := read[VersionAttribute]; ok {
+		cond = cond.And(expression.Name(VersionAttribute).Equal(expression.Value(from)))
+	} else {
+		cond = cond.And(expression.AttributeNotExists(expression.Name(VersionAttribute)))
+	}
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(cond).Build()
+	if err != nil {
+		return fmt.Errorf("building %s upgrade: %w", s.Name, err)
+	}
+
+	start := time.Now()
+	_, err = client.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(table),
+		Key:                       key,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil && !db.ConditionFailed(err, 0) {
+		return db.Wrap(err, "upgrading "+s.Name+" item")
+	}
+	return err
+}
+
+// pacer spaces out reads to one item per interval, shared by the segments
+// of a scan. A nil pacer does not wait.
+type pacer struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n more items may be read.
+func (p *pacer) wait(ctx context.Context, n int) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(n) * p.interval)
+	until := p.next
+	p.mu.Unlock()
+
+	// The items are already read: wait for the time they take up, so the
+	// next page comes after it
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(until.Sub(now)):
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+package migration
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+// schema renames nick to display_name, then adds status.
+var schema = &Schema{
+	Name: "test",
+	Key:  []string{"id"},
+	Of:   func(item db.Item) bool { return !strings.HasPrefix(str(item, "id"), "LOCK#") },
+	Steps: []Step{
+		func(item db.Item) error {
+			if nick, ok := item["nick"]; ok {
+				item["display_name"] = nick
+				delete(item, "nick")
+			}
+			return nil
+		},
+		func(item db.Item) error {
+			item["status"] = &types.AttributeValueMemberS{Value: "active"}
+			return nil
+		},
+	},
+}
+
+func str(item db.Item, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func versioned(item db.Item, v string) db.Item {
+	item[VersionAttribute] = &types.AttributeValueMemberN{Value: v}
+	return item
+}
+
+func TestUpgrade(t *testing.T) {
+	item := dbtest.Item("id", "u1", "nick", "jane")
+	ok, err := schema.Upgrade(item)
+	if err != nil || !ok {
+		t.Fatalf("Upgrade = %v, %v", ok, err)
+	}
+	if str(item, "display_name") != "jane" || str(item, "status") != "active" || item["nick"] != nil {
+		t.Errorf("upgraded = %v", item)
+	}
+	if v := Version(item); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+
+	// Only the steps past the version of the item run
+	item = versioned(dbtest.Item("id", "u1", "nick", "jane"), "1")
+	if ok, _ := schema.Upgrade(item); !ok || str(item, "nick") != "jane" || str(item, "status") != "active" {
+		t.Errorf("upgraded from 1 = %v", item)
+	}
+
+	for name, item := range map[string]db.Item{
+		"current": versioned(dbtest.Item("id", "u1"), "2"),
+		"newer":   versioned(dbtest.Item("id", "u1"), "3"),
+		"other":   dbtest.Item("id", "LOCK#jane"),
+	} {
+		if ok, _ := schema.Upgrade(item); ok || len(item) > 2 {
+			t.Errorf("%s: upgraded to %v", name, item)
+		}
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	rows := []db.Item{
+		dbtest.Item("id", "u1", "nick", "jane"),
+		versioned(dbtest.Item("id", "u2", "status", "active"), "2"),
+		dbtest.Item("id", "LOCK#jane"),
+		versioned(dbtest.Item("id", "u3"), "1"),
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		var (
+			mu      sync.Mutex
+			updates = map[string]*dynamodb.UpdateItemInput{}
+		)
+		m := &dbtest.Mock{
+			ScanFunc: func(in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+				if aws.ToInt32(in.Segment) != 0 {
+					return &dynamodb.ScanOutput{}, nil
+				}
+				items := make([]db.Item, len(rows))
+				for i, row := range rows {
+					items[i] = maps.Clone(row)
+				}
+				return &dynamodb.ScanOutput{Items: items}, nil
+			},
+			UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				id := str(in.Key, "id")
+				updates[id] = in
+				if id == "u3" {
+					// Upgraded by someone else since it was read
+					return nil, dbtest.ConditionFailed()
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			},
+		}
+
+		stats, err := schema.Backfill(context.Background(), m.Client(), "items", Options{Segments: 2, DryRun: dryRun})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Stats{Schema: "test", Scanned: 4, Upgraded: 1, Conflicts: 1}
+		if dryRun {
+			want = Stats{Schema: "test", Scanned: 4, Upgraded: 2}
+			if len(updates) != 0 {
+				t.Errorf("dry run wrote %d items", len(updates))
+			}
+		}
+		if stats != want {
+			t.Errorf("dryRun=%v: stats = %+v, want %+v", dryRun, stats, want)
+		}
+		if dryRun {
+			continue
+		}
+
+		in := updates["u1"]
+		if in == nil {
+			t.Fatal("u1 not upgraded")
+		}
+		update := expand(aws.ToString(in.UpdateExpression), in.ExpressionAttributeNames)
+		for _, want := range []string{"display_name", "status", VersionAttribute, "REMOVE nick"} {
+			if !strings.Contains(update, want) {
+				t.Errorf("update %q does not touch %s", update, want)
+			}
+		}
+		if strings.Contains(update, "SET id") {
+			t.Errorf("update %q rewrites the key", update)
+		}
+		if cond := expand(aws.ToString(in.ConditionExpression), in.ExpressionAttributeNames); !strings.Contains(cond, "attribute_not_exists (schema_version)") {
+			t.Errorf("condition = %q", cond)
+		}
+	}
+}
+
+// expand replaces the name placeholders of expr with the names.
+func expand(expr string, names map[string]string) string {
+	for placeholder, name := range names {
+		expr = strings.ReplaceAll(expr, placeholder, name)
+	}
+	return expr
+}
+
+func TestPacer(t *testing.T) {
+	p := &pacer{interval: time.Millisecond}
+	start := time.Now()
+	for range 3 {
+		if err := p.wait(context.Background(), 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("30 items at 1000/s took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.wait(ctx, 1000); err == nil {
+		t.Error("wait ignored a cancelled context")
+	}
+	if err := (*pacer)(nil).wait(ctx, 1000); err != nil {
+		t.Errorf("nil pacer: %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+// Package notifications keeps the in-app notification inbox of each user.
+// A notification is one item of the notification table, keyed by user_id
+// and notification_id, written by the createNotification consumer of the
+// event bus. Notification IDs start with their creation time, so they sort
+// by it. The inbox attribute, the sort key of the inbox index, is the ID
+// prefixed with 1 while the notification is unread and 0 once read, so a
+// descending query lists unread notifications first, each part newest
+// first. Notifications expire through the table's TTL attribute,
+// expires_at; DynamoDB deletes them lazily, so listings also filter them.
+package notifications
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+// Types of notifications, which tell clients how to read Data.
+const (
+	TypeFriendRequest  = "friend_request"
+	TypeFriendAccepted = "friend_accepted"
+	TypeAchievement    = "achievement"
+	TypeReferral       = "referral"
+	TypeSystem         = "system"
+)
+
+// MaxMarkRead bounds the notifications one call marks read.
+const MaxMarkRead = 25
+
+const (
+	// Prefixes of the inbox attribute.
+	unreadPrefix = "1#"
+	readPrefix   = "0#"
+
+	// idTimeLayout starts notification IDs: compact, URL-safe and sorted.
+	idTimeLayout = "20060102T150405Z"
+)
+
+// Notification is one notification of a user.
+type Notification struct {
+	UserID    string            `json:"user_id" dynamodbav:"user_id"`
+	ID        string            `json:"notification_id" dynamodbav:"notification_id"`
+	Type      string            `json:"type" dynamodbav:"type"` // one of the Type* values
+	Title     string            `json:"title" dynamodbav:"title"`
+	Body      string            `json:"body,omitempty" dynamodbav:"body,omitempty"`
+	Data      map[string]string `json:"data,omitempty" dynamodbav:"data,omitempty"`
+	CreatedAt time.Time         `json:"created_at" dynamodbav:"created_at"`
+	ReadAt    *time.Time        `json:"read_at,omitempty" dynamodbav:"read_at,omitempty"`
+	Inbox     string            `json:"-" dynamodbav:"inbox"`
+	ExpiresAt int64             `json:"-" dynamodbav:"expires_at"` // Unix seconds, the TTL attribute
+}
+
+// Store reads and writes the notification table.
+type Store struct {
+	DB         *db.Client
+	Table      string
+	InboxIndex string
+	TTL        time.Duration
+}
+
+// NewStore returns a store over the notification table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.NotificationTableName, InboxIndex: cfg.NotificationInboxIndexName, TTL: cfg.NotificationTTL}
+}
+
+// NewRef returns a random reference, for notifications not created from
+// anything with an ID of its own.
+func NewRef() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ID returns the ID of the notification created at at from ref, the ID of
+// what it is about, such as the event or the announcement.
+func ID(at time.Time, ref string) string {
+	return at.UTC().Format(idTimeLayout) + "-" + ref
+}
+
+// Create stores n, unread, as the notification created at at from ref, and
+// reports whether it did. A notification of the same time and ref is left
+// as it is, so redelivered events do not mark their notifications unread
+// again.
+func (s *Store) Create(ctx context.Context, n Notification, ref string, at time.Time) (bool, error) {
+	item, err := s.item(n, ref, at)
+	if err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	_, err = s.DB.DynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(notification_id)"),
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return false, nil
+	}
+	if err != nil {
+		return false, db.Wrap(err, "storing notification")
+	}
+	return true, nil
+}
+
+// CreateMany stores ns, all created at at from ref, like Create, with
+// batched reads and writes, and returns how many it stored. The
+// notifications already there are read
+// first and left out; one written between the read and the write, by a
+// concurrent call for the same ref, is overwritten with the same content.
+func (s *Store) CreateMany(ctx context.Context, ns []Notification, ref string, at time.Time) (int, error) {
+	items := make([]db.Item, len(ns))
+	keys := make([]db.Item, len(ns))
+	for i, n := range ns {
+		item, err := s.item(n, ref, at)
+		if err != nil {
+			return 0, err
+		}
+		items[i] = item
+		keys[i] = key(str(item, "user_id"), str(item, "notification_id"))
+	}
+	existing, err := s.DB.BatchGet(ctx, s.Table, keys, "user_id")
+	if err != nil {
+		return 0, err
+	}
+	// Users listed twice are notified once, which BatchWriteItem requires
+	skip := make(map[string]bool, len(items))
+	for _, item := range existing {
+		skip[str(item, "user_id")] = true
+	}
+	var fresh []db.Item
+	for _, item := range items {
+		if userID := str(item, "user_id"); !skip[userID] {
+			skip[userID] = true
+			fresh = append(fresh, item)
+		}
+	}
+	if err := s.DB.BatchPut(ctx, s.Table, fresh); err != nil {
+		return 0, err
+	}
+	return len(fresh), nil
+}
+
+// item returns the item of n, unread, as the notification created at at
+// from ref.
+func (s *Store) item(n Notification, ref string, at time.Time) (db.Item, error) {
+	at = at.UTC().Truncate(time.Second)
+	n.ID = ID(at, ref)
+	n.CreatedAt, n.ReadAt = at, nil
+	n.Inbox = unreadPrefix + n.ID
+	n.ExpiresAt = at.Add(s.TTL).Unix()
+	item, err := attributevalue.MarshalMap(n)
+	if err != nil {
+		return nil, fmt.Errorf("encoding notification: %w", err)
+	}
+	return item, nil
+}
+
+// List returns one page of the unexpired notifications of userID, unread
+// first, and the key to pass as start for the next page (nil on the last).
+func (s *Store) List(ctx context.Context, userID string, limit int32, start db.Item) ([]Notification, db.Item, error) {
+	begin := time.Now()
+	result, err := s.DB.DynamoDB.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.InboxIndex),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		FilterExpression:       aws.String("expires_at > :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+			":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(begin.Unix(), 10)},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		ExclusiveStartKey: start,
+		Limit:             aws.Int32(limit),
+	})
+	db.Observe(ctx, begin, err)
+	if err != nil {
+		return nil, nil, db.Wrap(err, "listing notifications")
+	}
+	list := []Notification{}
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &list); err != nil {
+		return nil, nil, fmt.Errorf("decoding notifications: %w", err)
+	}
+	if len(result.LastEvaluatedKey) == 0 {
+		return list, nil, nil
+	}
+	return list, result.LastEvaluatedKey, nil
+}
+
+// MarkRead marks the notifications of ids of userID read, and returns how
+// many it marked. Notifications read already, or missing, are skipped.
+func (s *Store) MarkRead(ctx context.Context, userID string, ids []string, now time.Time) (int, error) {
+	if len(ids) == 0 || len(ids) > MaxMarkRead {
+		return 0, apperr.Invalid("NOTIFICATION_IDS_INVALID", "notification_ids", fmt.Sprintf("notification_ids must list 1 to %d notifications", MaxMarkRead))
+	}
+	now = now.UTC().Truncate(time.Second)
+	readAt, err := attributevalue.Marshal(now)
+	if err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for _, id := range ids {
+		start := time.Now()
+		_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:           aws.String(s.Table),
+			Key:                 key(userID, id),
+			UpdateExpression:    aws.String("SET read_at = :now, inbox = :read"),
+			ConditionExpression: aws.String("attribute_exists(notification_id) AND attribute_not_exists(read_at)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now":  readAt,
+				":read": &types.AttributeValueMemberS{Value: readPrefix + id},
+			},
+		})
+		db.Observe(ctx, start, err)
+		if db.ConditionFailed(err, -1) {
+			continue
+		}
+		if err != nil {
+			return marked, db.Wrap(err, "marking notification read")
+		}
+		marked++
+	}
+	return marked, nil
+}
+
+// Conflicts of the move of a notification to another account.
+var (
+	errNotificationGone   = errors.New("notification already moved or expired")
+	errNotificationExists = errors.New("notification exists on the surviving account")
+)
+
+// Move re-parents the unexpired notifications of from, a duplicate account
+// merged into to, and returns how many it moved and dropped. Each moves in
+// one transaction, put on to as it is, read or unread, and deleted from
+// from; one to has already, such as an announcement both accounts got, is
+// only deleted, and dropped. With dryRun nothing is written.
+func (s *Store) Move(ctx context.Context, from, to string, dryRun bool) (moved, dropped int, err error) {
+	var pending []db.Item
+	err = s.DB.QueryPages(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		FilterExpression:       aws.String("expires_at > :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: from},
+			":now":     &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	}, func(items []db.Item) bool {
+		pending = append(pending, items...)
+		return true
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if dryRun {
+		keys := make([]db.Item, len(pending))
+		for i, item := range pending {
+			keys[i] = key(to, str(item, "notification_id"))
+		}
+		existing, err := s.DB.BatchGet(ctx, s.Table, keys, "user_id")
+		if err != nil {
+			return 0, 0, err
+		}
+		return len(pending) - len(existing), len(existing), nil
+	}
+
+	for _, item := range pending {
+		id := str(item, "notification_id")
+		moving := maps.Clone(item)
+		moving["user_id"] = &types.AttributeValueMemberS{Value: to}
+		remove := db.Write{Item: types.TransactWriteItem{Delete: &types.Delete{
+			TableName:           aws.String(s.Table),
+			Key:                 key(from, id),
+			ConditionExpression: aws.String("attribute_exists(notification_id)"),
+		}}, Conflict: errNotificationGone}
+
+		err := s.DB.Transact(ctx, db.Put(s.Table, moving, "attribute_not_exists(notification_id)", errNotificationExists), remove)
+		if errors.Is(err, errNotificationExists) {
+			dropped++
+			err = s.DB.Transact(ctx, remove)
+		} else if err == nil {
+			moved++
+		}
+		if errors.Is(err, errNotificationGone) {
+			continue
+		}
+		if err != nil {
+			return moved, dropped, err
+		}
+	}
+	return moved, dropped, nil
+}
+
+// key returns the primary key of a notification.
+func key(userID, id string) db.Item {
+	return db.Item{
+		"user_id":         &types.AttributeValueMemberS{Value: userID},
+		"notification_id": &types.AttributeValueMemberS{Value: id},
+	}
+}
+
+// str returns the string attribute name of item, or "".
+func str(item db.Item, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
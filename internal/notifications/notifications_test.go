@@ -0,0 +1,95 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+var now = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCreated bool
+	}{
+		{name: "creates", wantCreated: true},
+		{name: "redelivered", err: dbtest.ConditionFailed()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored db.Item
+			m := &dbtest.Mock{PutItemFunc: func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+				stored = in.Item
+				return &dynamodb.PutItemOutput{}, tt.err
+			}}
+			s := &Store{DB: m.Client(), Table: "notifications", TTL: time.Hour}
+
+			created, err := s.Create(context.Background(), Notification{UserID: "u1", Type: TypeSystem, Title: "Hi"}, "e1", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created != tt.wantCreated {
+				t.Errorf("created = %v, want %v", created, tt.wantCreated)
+			}
+			if id := str(stored, "notification_id"); id != "20261014T120000Z-e1" {
+				t.Errorf("notification_id = %q", id)
+			}
+			if inbox := str(stored, "inbox"); inbox != "1#20261014T120000Z-e1" {
+				t.Errorf("inbox = %q, want unread", inbox)
+			}
+		})
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	tests := []struct {
+		name       string
+		ids        []string
+		read       map[string]bool // notifications read already
+		wantMarked int
+		wantKind   apperr.Kind
+	}{
+		{name: "marks", ids: []string{"a", "b"}, wantMarked: 2},
+		{name: "skips read", ids: []string{"a", "b"}, read: map[string]bool{"a": true}, wantMarked: 1},
+		{name: "none", wantKind: apperr.KindInvalid},
+		{name: "too many", ids: make([]string, MaxMarkRead+1), wantKind: apperr.KindInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				id := str(in.Key, "notification_id")
+				if got := in.ExpressionAttributeValues[":read"].(*types.AttributeValueMemberS).Value; got != "0#"+id {
+					t.Errorf("inbox = %q, want read", got)
+				}
+				if tt.read[id] {
+					return nil, dbtest.ConditionFailed()
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			}}
+			s := &Store{DB: m.Client(), Table: "notifications"}
+
+			marked, err := s.MarkRead(context.Background(), "u1", tt.ids, now)
+			if tt.wantKind != 0 {
+				if apperr.KindOf(err) != tt.wantKind {
+					t.Fatalf("err = %v, want kind %v", err, tt.wantKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if marked != tt.wantMarked {
+				t.Errorf("marked = %d, want %d", marked, tt.wantMarked)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Package offload serves responses too large for Lambda from S3.
+//
+// A synchronous invocation returns at most 6MB, so Wrap writes a response
+// whose body exceeds MaxSize, after compression, to the result bucket and
+// answers with a 303 See Other whose Location, also in the body, is a
+// presigned link to it. The object keeps the content type and encoding of
+// the response, so clients following the redirect get the body they asked
+// for. A lifecycle rule of the bucket is expected to delete them after a
+// day.
+package offload
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/awscfg"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+)
+
+// MaxSize is the largest body returned directly, leaving room below the
+// Lambda limit for the headers and the envelope of the proxy response.
+const MaxSize = 5 << 20
+
+// errTooLarge fails oversized responses of functions without a result
+// bucket.
+var errTooLarge = errors.New("response exceeds the payload limit and no result bucket is configured")
+
+// Result is the body of the 303 response pointing at an offloaded response.
+type Result struct {
+	URL       string    `json:"result_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ObjectAPI is the part of the S3 API the store uses.
+type ObjectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// PresignAPI is the part of the S3 presign client the store uses.
+type PresignAPI interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// Store writes responses to the result bucket and links to them.
+type Store struct {
+	S3      ObjectAPI
+	Presign PresignAPI
+	Bucket  string
+	URLTTL  time.Duration // lifetime of the links
+}
+
+// NewStore returns the store over the result bucket named in cfg.
+func NewStore(client *s3.Client, cfg *config.Config) *Store {
+	return &Store{S3: client, Presign: s3.NewPresignClient(client), Bucket: cfg.ResultBucket, URLTTL: cfg.ResultURLTTL}
+}
+
+// Put stores the body of resp and returns a link to it.
+func (s *Store) Put(ctx context.Context, resp httpx.Response) (Result, error) {
+	body := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(resp.Body); err != nil {
+			return Result{}, fmt.Errorf("decoding response: %w", err)
+		}
+	}
+
+	key := "results/" + time.Now().UTC().Format("2006/01/02") + "/" + rand.Text()
+	in := &s3.PutObjectInput{
+		Bucket:               aws.String(s.Bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(body),
+		ServerSideEncryption: s3types.ServerSideEncryptionAwsKms,
+	}
+	if v := resp.Headers["Content-Type"]; v != "" {
+		in.ContentType = aws.String(v)
+	}
+	if v := resp.Headers["Content-Encoding"]; v != "" {
+		in.ContentEncoding = aws.String(v)
+	}
+	if _, err := s.S3.PutObject(ctx, in); err != nil {
+		return Result{}, fmt.Errorf("uploading response: %w", err)
+	}
+
+	expires := time.Now().Add(s.URLTTL).UTC().Truncate(time.Second)
+	req, err := s.Presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(s.URLTTL))
+	if err != nil {
+		return Result{}, fmt.Errorf("presigning response: %w", err)
+	}
+	return Result{URL: req.URL, ExpiresAt: expires}, nil
+}
+
+// Wrap returns next with its oversized responses offloaded to store. A nil
+// store fails them with a 500 rather than letting Lambda fail the
+// invocation. Direct invocations are answered as they are.
+func Wrap(store *Store, next httpx.Handler) httpx.Handler {
+	return wrap(func() *Store { return store }, next)
+}
+
+// wrap is Wrap with the store returned by get, which is only called for
+// oversized responses.
+func wrap(get func() *Store, next httpx.Handler) httpx.Handler {
+	return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+		resp, err := next(ctx, r)
+		if err != nil || r.Direct || len(resp.Body) <= MaxSize {
+			return resp, err
+		}
+		metrics.Count(ctx, metrics.ResponseOffloaded)
+
+		store := get()
+		if store == nil {
+			slog.ErrorContext(ctx, "Response too large", "size", len(resp.Body))
+			return httpx.Error(apperr.Internal(errTooLarge)), nil
+		}
+		result, err := store.Put(ctx, resp)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error offloading response", "size", len(resp.Body), logging.Err(err))
+			return httpx.Error(apperr.Internal(err)), nil
+		}
+		slog.InfoContext(ctx, "Offloaded response", "size", len(resp.Body), "status", resp.StatusCode)
+
+		out := httpx.JSON(303, result)
+		out.Headers["Location"] = result.URL
+		return out, nil
+	}
+}
+
+// Middleware returns the offloading of oversized responses to the result
+// bucket of cfg. Lambda entry points pass it to httpx.Adapt, which runs it
+// after compression. Without a bucket oversized responses fail. The S3
+// client is built by the first oversized response, so the cold starts of
+// the many functions that never offload do not pay for it.
+func Middleware(cfg *config.Config) httpx.Middleware {
+	store := sync.OnceValue(func() *Store {
+		if cfg.ResultBucket == "" {
+			return nil
+		}
+		awsCfg, err := awscfg.Shared(context.Background(), cfg)
+		if err != nil {
+			slog.Warn("Result bucket unavailable", logging.Err(err))
+			return nil
+		}
+		return NewStore(s3.NewFromConfig(awsCfg), cfg)
+	})
+	return func(next httpx.Handler) httpx.Handler {
+		return wrap(store, next)
+	}
+}
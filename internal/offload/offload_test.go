@@ -0,0 +1,89 @@
+package offload
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"troggle-backend/internal/httpx"
+)
+
+// fakeS3 records the objects put and presigns links to them.
+type fakeS3 struct {
+	puts []*s3.PutObjectInput
+	body []byte
+}
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.puts = append(f.puts, in)
+	f.body, _ = io.ReadAll(in.Body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) PresignGetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return &v4.PresignedHTTPRequest{URL: "https://results.example/" + aws.ToString(in.Key) + "?X-Amz-Signature=sig"}, nil
+}
+
+func TestWrap(t *testing.T) {
+	large := strings.Repeat("x", MaxSize+1)
+	tests := []struct {
+		name       string
+		body       string
+		direct     bool
+		noStore    bool
+		wantStatus int
+		wantPut    bool
+	}{
+		{name: "small", body: `"ok"`, wantStatus: 200},
+		{name: "large", body: large, wantStatus: 303, wantPut: true},
+		{name: "large without a bucket", body: large, noStore: true, wantStatus: 500},
+		{name: "direct invocation", body: large, direct: true, wantStatus: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeS3{}
+			store := &Store{S3: fake, Presign: fake, Bucket: "results", URLTTL: 5 * time.Minute}
+			if tt.noStore {
+				store = nil
+			}
+			h := Wrap(store, func(context.Context, *httpx.Request) (httpx.Response, error) {
+				resp := httpx.Text(200, tt.body)
+				resp.Headers["Content-Encoding"] = "gzip"
+				return resp, nil
+			})
+
+			resp, err := h(context.Background(), &httpx.Request{Direct: tt.direct})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if (len(fake.puts) > 0) != tt.wantPut {
+				t.Fatalf("puts = %d, want put = %v", len(fake.puts), tt.wantPut)
+			}
+			if !tt.wantPut {
+				return
+			}
+
+			put := fake.puts[0]
+			if aws.ToString(put.ContentEncoding) != "gzip" || !strings.HasPrefix(aws.ToString(put.ContentType), "text/plain") || string(fake.body) != tt.body {
+				t.Errorf("put %s, encoding %s, %d bytes", aws.ToString(put.ContentType), aws.ToString(put.ContentEncoding), len(fake.body))
+			}
+			var result Result
+			if err := json.Unmarshal([]byte(resp.Body), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.URL == "" || resp.Headers["Location"] != result.URL || result.ExpiresAt.IsZero() {
+				t.Errorf("result = %+v, Location = %q", result, resp.Headers["Location"])
+			}
+		})
+	}
+}
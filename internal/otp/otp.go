@@ -0,0 +1,169 @@
+// Package otp stores the one-time codes emailed to users: those of the
+// passwordless email login, which Cognito runs through its custom auth
+// challenge triggers, and those of password resets.
+//
+// A user has at most one code of each kind, an item of the challenge table
+// keyed by user_id, behind the Prefix of the kind's Store: issuing a code
+// replaces the previous one. Items hold a hash of
+// the code, never the code, the number of wrong answers given so far, and
+// expires_at, the table's TTL attribute. A code is good for MaxAttempts
+// answers until it expires, and is deleted once answered correctly, so it
+// cannot be used twice.
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+const (
+	// Digits is the length of a code.
+	Digits = 6
+
+	// TTL is how long a code can be used.
+	TTL = 10 * time.Minute
+
+	// MaxAttempts bounds the answers checked against one code.
+	MaxAttempts = 3
+)
+
+// ErrNoCode is returned by Verify when the user has no usable code: none
+// was issued, it expired, it was used, or it ran out of attempts.
+var ErrNoCode = errors.New("no usable one-time code")
+
+// record is an item of the challenge table.
+type record struct {
+	UserID    string `dynamodbav:"user_id"`
+	CodeHash  []byte `dynamodbav:"code_hash"`
+	Attempts  int    `dynamodbav:"attempts"`
+	CreatedAt string `dynamodbav:"created_at"` // RFC 3339
+	ExpiresAt int64  `dynamodbav:"expires_at"` // Unix seconds, the TTL attribute
+}
+
+// ResetPrefix is the key prefix of password reset codes.
+const ResetPrefix = "password_reset#"
+
+// Store reads and writes the challenge table.
+type Store struct {
+	DB     *db.Client
+	Table  string
+	Prefix string // prepended to user IDs in keys; "" for sign-in codes
+}
+
+// NewStore returns a store of sign-in codes over the challenge table named
+// in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.ChallengeTableName}
+}
+
+// NewResetStore returns a store of password reset codes over the challenge
+// table named in cfg.
+func NewResetStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.ChallengeTableName, Prefix: ResetPrefix}
+}
+
+// Issue generates a code for userID, replacing any previous one, and
+// returns it with its expiry. The caller sends it to the user.
+func (s *Store) Issue(ctx context.Context, userID string) (code string, expires time.Time, err error) {
+	code, err = generate()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	expires = now.Add(TTL)
+	item, err := attributevalue.MarshalMap(record{
+		UserID:    s.Prefix + userID,
+		CodeHash:  hash(userID, code),
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: expires.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if err := s.DB.PutItem(ctx, s.Table, item); err != nil {
+		return "", time.Time{}, err
+	}
+	return code, expires, nil
+}
+
+// Verify reports whether answer is the code of userID. Every answer uses up
+// one attempt, counted before comparing so that concurrent answers cannot
+// exceed MaxAttempts; a correct answer deletes the code. It returns
+// ErrNoCode when there is no code left to check answer against.
+func (s *Store) Verify(ctx context.Context, userID, answer string) (bool, error) {
+	start := time.Now()
+	out, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 s.key(userID),
+		UpdateExpression:    aws.String("SET attempts = attempts + :one"),
+		ConditionExpression: aws.String("attribute_exists(user_id) AND attempts < :max AND expires_at > :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+			":max": &types.AttributeValueMemberN{Value: fmt.Sprint(MaxAttempts)},
+			":now": &types.AttributeValueMemberN{Value: fmt.Sprint(start.Unix())},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return false, ErrNoCode
+	}
+	if err != nil {
+		return false, db.Wrap(err, "counting one-time code attempt")
+	}
+
+	var rec record
+	if err := attributevalue.UnmarshalMap(out.Attributes, &rec); err != nil {
+		return false, fmt.Errorf("decoding one-time code: %w", err)
+	}
+	if subtle.ConstantTimeCompare(rec.CodeHash, hash(userID, answer)) != 1 {
+		return false, nil
+	}
+	return true, s.Delete(ctx, userID)
+}
+
+// Delete removes the code of userID, if any.
+func (s *Store) Delete(ctx context.Context, userID string) error {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key:       s.key(userID),
+	})
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, "removing one-time code")
+}
+
+// generate returns a random code of Digits digits.
+func generate() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000)) // 10^Digits
+	if err != nil {
+		return "", fmt.Errorf("generating one-time code: %w", err)
+	}
+	return fmt.Sprintf("%0*d", Digits, n), nil
+}
+
+// hash returns the stored form of code. The user ID salts it, so equal
+// codes of different users hash differently.
+func hash(userID, code string) []byte {
+	sum := sha256.Sum256([]byte(userID + ":" + code))
+	return sum[:]
+}
+
+// key returns the primary key of the code of userID.
+func (s *Store) key(userID string) db.Item {
+	return db.Item{"user_id": &types.AttributeValueMemberS{Value: s.Prefix + userID}}
+}
@@ -0,0 +1,116 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db/dbtest"
+)
+
+// issued answers attempt updates with the item Issue stored, with attempts
+// counted, until max attempts.
+func issued(m *dbtest.Mock) {
+	attempts := 0
+	m.UpdateItemFunc = func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		if attempts >= MaxAttempts {
+			return nil, dbtest.ConditionFailed()
+		}
+		attempts++
+		item := m.Calls[0].Input.(*dynamodb.PutItemInput).Item
+		return &dynamodb.UpdateItemOutput{Attributes: item}, nil
+	}
+}
+
+func TestIssue(t *testing.T) {
+	m := &dbtest.Mock{}
+	s := &Store{DB: m.Client(), Table: "challenges"}
+
+	code, expires, err := s.Issue(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != Digits {
+		t.Errorf("code = %q, want %d digits", code, Digits)
+	}
+	if d := time.Until(expires); d <= TTL-time.Minute || d > TTL {
+		t.Errorf("expires in %v, want %v", d, TTL)
+	}
+	item := m.Calls[0].Input.(*dynamodb.PutItemInput).Item
+	stored, _ := item["code_hash"].(*types.AttributeValueMemberB)
+	if stored == nil || string(stored.Value) == code {
+		t.Errorf("code_hash = %v, want a hash of the code", item["code_hash"])
+	}
+	if item["expires_at"] == nil {
+		t.Error("item has no expires_at")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	m := &dbtest.Mock{}
+	s := &Store{DB: m.Client(), Table: "challenges"}
+	code, _, err := s.Issue(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued(m)
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	if ok, err := s.Verify(ctx, "u1", wrong); ok || err != nil {
+		t.Errorf("Verify(wrong) = %v, %v", ok, err)
+	}
+	if ok, err := s.Verify(ctx, "u2", code); ok || err != nil {
+		t.Errorf("Verify(code of another user) = %v, %v", ok, err)
+	}
+	if ok, err := s.Verify(ctx, "u1", code); !ok || err != nil {
+		t.Errorf("Verify(code) = %v, %v", ok, err)
+	}
+	if got := m.Ops()[len(m.Ops())-1]; got != "DeleteItem" {
+		t.Errorf("last op = %s, want the code deleted", got)
+	}
+	if _, err := s.Verify(ctx, "u1", code); !errors.Is(err, ErrNoCode) {
+		t.Errorf("Verify after %d attempts: err = %v, want ErrNoCode", MaxAttempts, err)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	ctx := context.Background()
+	m := &dbtest.Mock{}
+	s := &Store{DB: m.Client(), Table: "challenges", Prefix: ResetPrefix}
+	if _, _, err := s.Issue(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Verify(ctx, "u1", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	put := m.Calls[0].Input.(*dynamodb.PutItemInput).Item["user_id"].(*types.AttributeValueMemberS).Value
+	update := m.Calls[1].Input.(*dynamodb.UpdateItemInput).Key["user_id"].(*types.AttributeValueMemberS).Value
+	if put != "password_reset#u1" || update != put {
+		t.Errorf("keys = %q, %q, want password_reset#u1, apart from the sign-in code", put, update)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	seen := map[string]bool{}
+	for range 100 {
+		code, err := generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != Digits {
+			t.Fatalf("code = %q", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 90 {
+		t.Errorf("%d distinct codes in 100", len(seen))
+	}
+}
@@ -0,0 +1,162 @@
+// Package pagination encodes the next_token cursors of paged listings. A
+// cursor holds the position of the next page, the LastEvaluatedKey of a
+// DynamoDB query or an offset, and the filters of the listing it belongs
+// to, signed by package signing.
+//
+// Clients can therefore neither forge a start key, which would let them
+// read partitions the handler's access checks never saw, nor replay a
+// cursor in a listing with other filters: Decode rejects both with
+// INVALID_NEXT_TOKEN. The key is the pagination_key secret (see package
+// secrets). Rotating it invalidates the cursors in flight, whose clients
+// start over from the first page.
+package pagination
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/secrets"
+	"troggle-backend/internal/signing"
+)
+
+// SecretName is the secret, below SECRETS_PREFIX, holding the signing key.
+const SecretName = "pagination_key"
+
+// Filters are the parameters of the listing a cursor is valid in, e.g. the
+// user whose friends are listed.
+type Filters map[string]string
+
+// Cursor is the position of the next page of a listing.
+type Cursor struct {
+	Key    db.Item // ExclusiveStartKey of a DynamoDB query
+	Offset int     // entries before the page, for listings not read as one query
+}
+
+// payload is the signed content of a token. Key attributes are strings or
+// numbers, which covers the keys of every table and index paged through.
+type payload struct {
+	Key     map[string]attribute `json:"k,omitempty"`
+	Offset  int                  `json:"o,omitempty"`
+	Filters Filters              `json:"f,omitempty"`
+}
+
+// attribute is a key attribute of a payload.
+type attribute struct {
+	S *string `json:"s,omitempty"`
+	N *string `json:"n,omitempty"`
+}
+
+// Codec signs and verifies cursors.
+type Codec struct {
+	Signer signing.Signer
+}
+
+// NewCodec returns a codec signing with the pagination_key secret of keys.
+func NewCodec(keys signing.Keys) *Codec {
+	return &Codec{Signer: signing.Signer{Keys: keys, Secret: SecretName}}
+}
+
+// New returns the codec signing with the container's secrets cache.
+func New(ctx context.Context, cfg *config.Config) (*Codec, error) {
+	cache, err := secrets.Shared(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating secrets cache: %w", err)
+	}
+	return NewCodec(cache), nil
+}
+
+// invalid is the error of tokens that do not decode.
+func invalid() error {
+	return apperr.Invalid("INVALID_NEXT_TOKEN", "next_token", "next_token is not valid")
+}
+
+// Encode returns the token of cur in the listing with filters.
+func (c *Codec) Encode(ctx context.Context, cur Cursor, filters Filters) (string, error) {
+	p := payload{Offset: cur.Offset, Filters: filters}
+	if len(cur.Key) > 0 {
+		p.Key = make(map[string]attribute, len(cur.Key))
+	}
+	for name, v := range cur.Key {
+		switch v := v.(type) {
+		case *types.AttributeValueMemberS:
+			p.Key[name] = attribute{S: &v.Value}
+		case *types.AttributeValueMemberN:
+			p.Key[name] = attribute{N: &v.Value}
+		default:
+			return "", fmt.Errorf("key attribute %s is neither a string nor a number", name)
+		}
+	}
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return c.Signer.Sign(ctx, raw)
+}
+
+// Decode returns the cursor of token, which must have been encoded for a
+// listing with the same filters.
+func (c *Codec) Decode(ctx context.Context, token string, filters Filters) (Cursor, error) {
+	raw, err := c.Signer.Open(ctx, token)
+	if errors.Is(err, signing.ErrInvalid) {
+		return Cursor{}, invalid()
+	}
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	var p payload
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil || p.Offset < 0 || !maps.Equal(p.Filters, filters) {
+		return Cursor{}, invalid()
+	}
+	cur := Cursor{Offset: p.Offset}
+	if len(p.Key) > 0 {
+		cur.Key = make(db.Item, len(p.Key))
+	}
+	for name, a := range p.Key {
+		switch {
+		case a.S != nil && a.N == nil:
+			cur.Key[name] = &types.AttributeValueMemberS{Value: *a.S}
+		case a.N != nil && a.S == nil:
+			cur.Key[name] = &types.AttributeValueMemberN{Value: *a.N}
+		default:
+			return Cursor{}, invalid()
+		}
+	}
+	return cur, nil
+}
+
+// Next returns the token of the page starting after key, or "" when key is
+// empty, i.e. on the last page.
+func (c *Codec) Next(ctx context.Context, key db.Item, filters Filters) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	return c.Encode(ctx, Cursor{Key: key}, filters)
+}
+
+// Start returns the start key of the page of token, or nil for the first
+// page, when token is empty.
+func (c *Codec) Start(ctx context.Context, token string, filters Filters) (db.Item, error) {
+	if token == "" {
+		return nil, nil
+	}
+	cur, err := c.Decode(ctx, token, filters)
+	if err != nil {
+		return nil, err
+	}
+	if len(cur.Key) == 0 {
+		return nil, invalid()
+	}
+	return cur.Key, nil
+}
@@ -0,0 +1,93 @@
+package pagination
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/signing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	c := NewCodec(signing.StaticKey("secret"))
+	key := db.Item{
+		"pk":         &types.AttributeValueMemberS{Value: "USER#u1"},
+		"created_at": &types.AttributeValueMemberN{Value: "1700000000"},
+	}
+	filters := Filters{"user_id": "u1"}
+
+	token, err := c.Next(ctx, key, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Start(ctx, token, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := got["pk"].(*types.AttributeValueMemberS).Value; s != "USER#u1" {
+		t.Errorf("pk = %q", s)
+	}
+	if n := got["created_at"].(*types.AttributeValueMemberN).Value; n != "1700000000" {
+		t.Errorf("created_at = %q", n)
+	}
+
+	token, err = c.Encode(ctx, Cursor{Offset: 50}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := c.Decode(ctx, token, nil)
+	if err != nil || cur.Offset != 50 {
+		t.Errorf("Decode = %+v, %v", cur, err)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	ctx := context.Background()
+	c := NewCodec(signing.StaticKey("secret"))
+	filters := Filters{"user_id": "u1"}
+	token, err := c.Encode(ctx, Cursor{Key: db.Item{"pk": &types.AttributeValueMemberS{Value: "USER#u1"}}}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	other, err := NewCodec(signing.StaticKey("other")).Encode(ctx, Cursor{Key: db.Item{"pk": &types.AttributeValueMemberS{Value: "USER#u2"}}}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	tests := map[string]struct {
+		token   string
+		filters Filters
+	}{
+		"garbage":         {token: "not-a-token", filters: filters},
+		"bad base64":      {token: "!!." + sig, filters: filters},
+		"other key":       {token: other, filters: filters},
+		"forged payload":  {token: otherPayload + "." + sig, filters: filters},
+		"other filters":   {token: token, filters: Filters{"user_id": "u2"}},
+		"missing filters": {token: token},
+		"truncated":       {token: payload + "." + sig[:len(sig)-2], filters: filters},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.Decode(ctx, tt.token, tt.filters)
+			var ae *apperr.Error
+			if !errors.As(err, &ae) || ae.Code != "INVALID_NEXT_TOKEN" {
+				t.Errorf("Decode error = %v, want INVALID_NEXT_TOKEN", err)
+			}
+		})
+	}
+}
+
+func TestStartEmpty(t *testing.T) {
+	key, err := NewCodec(signing.StaticKey("secret")).Start(context.Background(), "", nil)
+	if key != nil || err != nil {
+		t.Errorf("Start(\"\") = %v, %v", key, err)
+	}
+}
@@ -0,0 +1,357 @@
+// Package policies tracks the versions of the terms of service and the
+// privacy policy, and which of them each user accepted.
+//
+// Admins publish versions through publishPolicy; they are numbered from 1
+// per policy and kept in the policy table, keyed by policy and version. A
+// version is required when users must accept it to keep using troggle, as
+// opposed to a correction they may simply be shown. Each version records
+// the latest required version as of its publication, so the newest version
+// of a policy tells both what to show and what must have been accepted.
+//
+// Users accept versions through acceptPolicies, which records each
+// acceptance, with its time and the caller's IP address, as an entity of
+// the user in the single table (see package userdata). Acceptances are
+// never overwritten by later ones: they are the record of what each user
+// agreed to.
+//
+// Guard flags the responses to users who have not accepted the latest
+// required version of a policy, so that clients prompt them; publishing a
+// required version flags every user within CacheTTL.
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/userdata"
+)
+
+// Policies.
+const (
+	Terms   = "terms"
+	Privacy = "privacy"
+)
+
+// Names lists the policies, in the order they are reported.
+var Names = []string{Terms, Privacy}
+
+// CacheTTL is how long the latest versions are cached before being read
+// again.
+const CacheTTL = time.Minute
+
+// Header is the response header listing, comma separated, the policies the
+// caller must accept a new version of.
+const Header = "Policy-Acceptance-Required"
+
+var (
+	// ErrUnknownPolicy is returned for policies other than Names.
+	ErrUnknownPolicy = apperr.Invalid("UNKNOWN_POLICY", "policy", "policy must be terms or privacy")
+
+	// ErrUnknownVersion is returned for versions that were never published.
+	ErrUnknownVersion = apperr.Invalid("UNKNOWN_POLICY_VERSION", "version", "The policy version was never published")
+
+	// ErrOutdatedVersion is returned for accepting a version that a newer one
+	// replaced; the caller should show the latest and accept that.
+	ErrOutdatedVersion = &apperr.Error{Kind: apperr.KindConflict, Code: "POLICY_VERSION_OUTDATED", Message: "A newer version of the policy was published"}
+
+	// ErrConcurrentPublish is returned when another version of the policy
+	// was published while Publish ran.
+	ErrConcurrentPublish = &apperr.Error{Kind: apperr.KindConflict, Code: "CONCURRENT_PUBLISH", Message: "Another version of the policy was just published; retry"}
+)
+
+// Version is the model of a published version of a policy.
+type Version struct {
+	Policy          string `dynamodbav:"policy" json:"policy"`
+	Version         int64  `dynamodbav:"version" json:"version"`
+	URL             string `dynamodbav:"url" json:"url"`                             // where the text is published
+	Summary         string `dynamodbav:"summary,omitempty" json:"summary,omitempty"` // what changed, shown in the prompt
+	Required        bool   `dynamodbav:"required" json:"required"`
+	RequiredVersion int64  `dynamodbav:"required_version" json:"required_version"` // the latest required version, this one when Required
+	PublishedAt     string `dynamodbav:"published_at" json:"published_at"`         // RFC 3339
+	PublishedBy     string `dynamodbav:"published_by,omitempty" json:"-"`
+}
+
+// Acceptance is the model of a user's acceptance of a version.
+type Acceptance struct {
+	UserID     string `dynamodbav:"user_id" json:"-"`
+	Policy     string `dynamodbav:"policy" json:"policy"`
+	Version    int64  `dynamodbav:"version" json:"version"`
+	AcceptedAt string `dynamodbav:"accepted_at" json:"accepted_at"` // RFC 3339
+	IP         string `dynamodbav:"ip,omitempty" json:"-"`          // of the request accepting it
+}
+
+// Store reads and writes the policy table and the acceptances.
+type Store struct {
+	DB       *db.Client
+	Table    string
+	UserData *userdata.Store
+
+	mu      sync.Mutex
+	latest  map[string]Version // by policy
+	fetched time.Time
+}
+
+// NewStore returns a store over the tables named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.PolicyTableName, UserData: userdata.NewStore(client, cfg)}
+}
+
+// Valid reports whether policy is one of Names.
+func Valid(policy string) bool {
+	return slices.Contains(Names, policy)
+}
+
+// Latest returns the newest version of policy, or nil if none was
+// published.
+func (s *Store) Latest(ctx context.Context, policy string) (*Version, error) {
+	items, err := s.DB.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("#policy = :policy"),
+		ExpressionAttributeNames: map[string]string{
+			"#policy": "policy",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":policy": &types.AttributeValueMemberS{Value: policy},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(1),
+	})
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	v, err := db.Decode[Version](items[0])
+	if err != nil {
+		return nil, fmt.Errorf("decoding policy version: %w", err)
+	}
+	return &v, nil
+}
+
+// Current returns the newest version of each published policy, read at
+// most CacheTTL ago. A failed refresh keeps the versions read before.
+func (s *Store) Current(ctx context.Context) (map[string]Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latest != nil && time.Since(s.fetched) < CacheTTL {
+		return s.latest, nil
+	}
+	latest := make(map[string]Version, len(Names))
+	for _, policy := range Names {
+		v, err := s.Latest(ctx, policy)
+		if err != nil {
+			if s.latest == nil {
+				return nil, err
+			}
+			slog.WarnContext(ctx, "Failed to refresh policy versions", logging.Err(err))
+			s.fetched = time.Now()
+			return s.latest, nil
+		}
+		if v != nil {
+			latest[policy] = *v
+		}
+	}
+	s.latest, s.fetched = latest, time.Now()
+	return latest, nil
+}
+
+// Publish publishes the next version of v.Policy, with v's URL, summary and
+// Required, and returns it. It fails with ErrConcurrentPublish when another
+// version was published meanwhile.
+func (s *Store) Publish(ctx context.Context, v Version, now time.Time) (Version, error) {
+	if !Valid(v.Policy) {
+		return Version{}, ErrUnknownPolicy
+	}
+	prev, err := s.Latest(ctx, v.Policy)
+	if err != nil {
+		return Version{}, err
+	}
+	v.Version, v.RequiredVersion = 1, 0
+	if prev != nil {
+		v.Version, v.RequiredVersion = prev.Version+1, prev.RequiredVersion
+	}
+	if v.Required {
+		v.RequiredVersion = v.Version
+	}
+	v.PublishedAt = now.UTC().Format(time.RFC3339)
+
+	item, err := db.Encode(v)
+	if err != nil {
+		return Version{}, fmt.Errorf("encoding policy version: %w", err)
+	}
+	put := db.Write{
+		Item: types.TransactWriteItem{Put: &types.Put{
+			TableName:           aws.String(s.Table),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#version)"),
+			ExpressionAttributeNames: map[string]string{
+				"#version": "version", // reserved word
+			},
+		}},
+		Conflict: ErrConcurrentPublish,
+	}
+	if err := s.DB.Transact(ctx, put); err != nil {
+		return Version{}, err
+	}
+	// This container prompts at once; the others within CacheTTL
+	s.mu.Lock()
+	s.latest = nil
+	s.mu.Unlock()
+	return v, nil
+}
+
+// Accept records that userID accepted version of policy from ip, and
+// returns the acceptance and true. Only the newest version can be accepted:
+// older ones fail with ErrOutdatedVersion. Accepting a version again keeps
+// the first acceptance, which is returned with false.
+func (s *Store) Accept(ctx context.Context, userID, policy string, version int64, ip string, now time.Time) (Acceptance, bool, error) {
+	if !Valid(policy) {
+		return Acceptance{}, false, ErrUnknownPolicy
+	}
+	latest, err := s.Latest(ctx, policy)
+	if err != nil {
+		return Acceptance{}, false, err
+	}
+	switch {
+	case latest == nil || version < 1 || version > latest.Version:
+		return Acceptance{}, false, ErrUnknownVersion
+	case version < latest.Version:
+		return Acceptance{}, false, ErrOutdatedVersion
+	}
+
+	a := Acceptance{UserID: userID, Policy: policy, Version: version, AcceptedAt: now.UTC().Format(time.RFC3339), IP: ip}
+	sk := userdata.AcceptanceSK(policy, version)
+	item, err := userdata.Marshal(userdata.Acceptance, userID, sk, a)
+	if err != nil {
+		return Acceptance{}, false, fmt.Errorf("encoding policy acceptance: %w", err)
+	}
+	err = s.DB.Transact(ctx, db.Put(s.UserData.Table, item, "attribute_not_exists(sk)", errAccepted))
+	if errors.Is(err, errAccepted) {
+		item, err := s.UserData.Get(ctx, userID, sk)
+		if err != nil {
+			return Acceptance{}, false, err
+		}
+		first, err := userdata.Unmarshal[Acceptance](item, userdata.Acceptance)
+		return first, false, err
+	}
+	if err != nil {
+		return Acceptance{}, false, err
+	}
+	return a, true, nil
+}
+
+// errAccepted cancels recording an acceptance recorded before.
+var errAccepted = apperr.Conflict("policy version accepted already")
+
+// Acceptances returns the acceptances of userID, by policy and oldest
+// first.
+func (s *Store) Acceptances(ctx context.Context, userID string) ([]Acceptance, error) {
+	items, err := s.UserData.List(ctx, userID, userdata.PolicyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	acceptances := make([]Acceptance, 0, len(items))
+	for _, item := range items {
+		a, err := userdata.Unmarshal[Acceptance](item, userdata.Acceptance)
+		if err != nil {
+			return nil, err
+		}
+		acceptances = append(acceptances, a)
+	}
+	return acceptances, nil
+}
+
+// Pending returns the policies whose latest required version userID has
+// not accepted, in the order of Names. Policies never published, or with no
+// required version, are not pending.
+func (s *Store) Pending(ctx context.Context, userID string) ([]string, error) {
+	current, err := s.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	required := false
+	for _, v := range current {
+		required = required || v.RequiredVersion > 0
+	}
+	if !required {
+		return nil, nil
+	}
+	acceptances, err := s.Acceptances(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return PendingOf(current, acceptances), nil
+}
+
+// PendingOf returns the policies of current whose required version none of
+// acceptances reaches, in the order of Names.
+func PendingOf(current map[string]Version, acceptances []Acceptance) []string {
+	accepted := make(map[string]int64, len(Names))
+	for _, a := range acceptances {
+		accepted[a.Policy] = max(accepted[a.Policy], a.Version)
+	}
+	var pending []string
+	for _, policy := range Names {
+		if v, ok := current[policy]; ok && v.RequiredVersion > accepted[policy] {
+			pending = append(pending, policy)
+		}
+	}
+	return pending
+}
+
+// Guard flags the responses to users with pending policies.
+type Guard struct {
+	Policies *Store
+}
+
+// NewGuard returns the guard over the tables named in cfg.
+func NewGuard(client *db.Client, cfg *config.Config) *Guard {
+	return &Guard{Policies: NewStore(client, cfg)}
+}
+
+// Middleware returns a middleware setting Header on the responses to users
+// with pending policies. It does not refuse them: clients prompt, and the
+// endpoints keep working until the user accepts. API keys, direct
+// invocations, and every request when g is nil, are not flagged, and
+// neither are requests when the check fails. It goes after
+// auth.Middleware.
+func (g *Guard) Middleware() httpx.Middleware {
+	return func(next httpx.Handler) httpx.Handler {
+		return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+			resp, err := next(ctx, r)
+			if err != nil || g == nil || r.Direct {
+				return resp, err
+			}
+			id, ok := auth.FromContext(ctx)
+			if !ok || id.TokenUse == auth.TokenUseAPIKey {
+				return resp, nil
+			}
+			pending, perr := g.Policies.Pending(ctx, id.Subject)
+			if perr != nil {
+				slog.WarnContext(ctx, "Failed to check policy acceptance", logging.Err(perr))
+				return resp, nil
+			}
+			if len(pending) > 0 {
+				if resp.Headers == nil {
+					resp.Headers = map[string]string{}
+				}
+				resp.Headers[Header] = strings.Join(pending, ",")
+			}
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/userdata"
+)
+
+func testStore(m *dbtest.Mock) *Store {
+	return NewStore(m.Client(), &config.Config{PolicyTableName: "policies", UserDataTableName: "user-data"})
+}
+
+// published answers the queries of the policy table with the newest of
+// versions of the policy queried.
+func published(versions ...Version) func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		policy := in.ExpressionAttributeValues[":policy"].(*types.AttributeValueMemberS).Value
+		for i := len(versions) - 1; i >= 0; i-- {
+			if versions[i].Policy == policy {
+				return &dynamodb.QueryOutput{Items: []db.Item{db.MustEncode(versions[i])}}, nil
+			}
+		}
+		return &dynamodb.QueryOutput{}, nil
+	}
+}
+
+func TestPublish(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		prev         []Version
+		required     bool
+		wantVersion  int64
+		wantRequired int64
+	}{
+		{name: "first", required: true, wantVersion: 1, wantRequired: 1},
+		{name: "correction", prev: []Version{{Policy: Terms, Version: 3, RequiredVersion: 2}}, wantVersion: 4, wantRequired: 2},
+		{name: "required", prev: []Version{{Policy: Terms, Version: 3, RequiredVersion: 2}}, required: true, wantVersion: 4, wantRequired: 4},
+		{name: "first correction", wantVersion: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: published(tt.prev...)}
+			v, err := testStore(m).Publish(context.Background(), Version{Policy: Terms, URL: "https://troggle.example/terms", Required: tt.required}, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Version != tt.wantVersion || v.RequiredVersion != tt.wantRequired || v.PublishedAt != "2026-10-15T09:00:00Z" {
+				t.Errorf("Publish = %+v, want version %d requiring %d", v, tt.wantVersion, tt.wantRequired)
+			}
+			tx := m.Calls[1].Input.(*dynamodb.TransactWriteItemsInput)
+			if cond := aws.ToString(tx.TransactItems[0].Put.ConditionExpression); cond != "attribute_not_exists(#version)" {
+				t.Errorf("condition = %q, want new versions only", cond)
+			}
+		})
+	}
+
+	m := &dbtest.Mock{TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+		return nil, dbtest.TransactionCanceled("ConditionalCheckFailed")
+	}}
+	if _, err := testStore(m).Publish(context.Background(), Version{Policy: Privacy}, now); !errors.Is(err, ErrConcurrentPublish) {
+		t.Errorf("Publish(concurrent) = %v, want ErrConcurrentPublish", err)
+	}
+	if _, err := testStore(m).Publish(context.Background(), Version{Policy: "cookies"}, now); !errors.Is(err, ErrUnknownPolicy) {
+		t.Errorf("Publish(cookies) = %v, want ErrUnknownPolicy", err)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		policy   string
+		version  int64
+		accepted bool // the version was accepted before
+		wantErr  error
+	}{
+		{name: "latest", policy: Terms, version: 3},
+		{name: "again", policy: Terms, version: 3, accepted: true},
+		{name: "outdated", policy: Terms, version: 2, wantErr: ErrOutdatedVersion},
+		{name: "unpublished", policy: Terms, version: 4, wantErr: ErrUnknownVersion},
+		{name: "never published", policy: Privacy, version: 1, wantErr: ErrUnknownVersion},
+		{name: "unknown policy", policy: "cookies", version: 1, wantErr: ErrUnknownPolicy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := Acceptance{UserID: "u1", Policy: Terms, Version: 3, AcceptedAt: "2026-10-01T12:00:00Z", IP: "192.0.2.1"}
+			m := &dbtest.Mock{
+				QueryFunc: published(Version{Policy: Terms, Version: 3, RequiredVersion: 3}),
+				TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					if tt.accepted {
+						return nil, dbtest.TransactionCanceled("ConditionalCheckFailed")
+					}
+					return &dynamodb.TransactWriteItemsOutput{}, nil
+				},
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					item, err := userdata.Marshal(userdata.Acceptance, "u1", userdata.AcceptanceSK(Terms, 3), first)
+					return &dynamodb.GetItemOutput{Item: item}, err
+				},
+			}
+			a, created, err := testStore(m).Accept(context.Background(), "u1", tt.policy, tt.version, "198.51.100.7", now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Accept = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if created == tt.accepted {
+				t.Errorf("created = %v, want %v", created, !tt.accepted)
+			}
+			want := Acceptance{UserID: "u1", Policy: Terms, Version: 3, AcceptedAt: "2026-10-15T09:00:00Z", IP: "198.51.100.7"}
+			if tt.accepted {
+				want = first
+			}
+			if a != want {
+				t.Errorf("Accept = %+v, want %+v", a, want)
+			}
+		})
+	}
+}
+
+func TestPendingOf(t *testing.T) {
+	current := map[string]Version{
+		Terms:   {Policy: Terms, Version: 4, RequiredVersion: 3},
+		Privacy: {Policy: Privacy, Version: 2, RequiredVersion: 2},
+	}
+	tests := []struct {
+		name        string
+		acceptances []Acceptance
+		want        []string
+	}{
+		{name: "none", want: []string{Terms, Privacy}},
+		{name: "required versions", acceptances: []Acceptance{{Policy: Terms, Version: 3}, {Policy: Privacy, Version: 2}}},
+		{name: "older terms", acceptances: []Acceptance{{Policy: Terms, Version: 2}, {Policy: Privacy, Version: 2}}, want: []string{Terms}},
+		{name: "past correction", acceptances: []Acceptance{{Policy: Terms, Version: 1}, {Policy: Terms, Version: 4}}, want: []string{Privacy}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PendingOf(current, tt.acceptances); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PendingOf = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGuardMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokenUse string
+		accepted bool
+		want     string
+	}{
+		{name: "pending", want: "terms"},
+		{name: "accepted", accepted: true},
+		{name: "API key", tokenUse: auth.TokenUseAPIKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				if aws.ToString(in.TableName) == "policies" {
+					return published(Version{Policy: Terms, Version: 2, RequiredVersion: 2})(in)
+				}
+				if !tt.accepted {
+					return &dynamodb.QueryOutput{}, nil
+				}
+				item, err := userdata.Marshal(userdata.Acceptance, "u1", userdata.AcceptanceSK(Terms, 2), Acceptance{UserID: "u1", Policy: Terms, Version: 2})
+				return &dynamodb.QueryOutput{Items: []db.Item{item}}, err
+			}}
+			g := &Guard{Policies: testStore(m)}
+			tokenUse := tt.tokenUse
+			if tokenUse == "" {
+				tokenUse = "access"
+			}
+			ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "u1", TokenUse: tokenUse})
+
+			h := g.Middleware()(func(context.Context, *httpx.Request) (httpx.Response, error) {
+				return httpx.JSON(200, map[string]string{}), nil
+			})
+			resp, err := h(ctx, &httpx.Request{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Headers[Header]; got != tt.want {
+				t.Errorf("%s = %q, want %q", Header, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+// Package preferences stores the notification and privacy settings of each
+// user in the preference table, one item per user_id.
+//
+// Settings are grouped ("notifications", "privacy") and every known setting
+// has a default, so callers always see a complete document even for users
+// who never changed anything. Unknown settings are rejected on write and
+// dropped on read.
+package preferences
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+// Setting describes one known preference.
+type Setting struct {
+	Default any      // bool, or string for settings with Values
+	Values  []string // allowed values of a string setting; nil for booleans
+}
+
+// Groups of settings.
+const (
+	Notifications = "notifications"
+	Privacy       = "privacy"
+)
+
+// Schema lists every known setting by group.
+var Schema = map[string]map[string]Setting{
+	Notifications: {
+		"email_marketing":   {Default: false},
+		"email_security":    {Default: true},
+		"push_enabled":      {Default: true},
+		"push_social":       {Default: true},
+		"digest_frequency":  {Default: "weekly", Values: []string{"never", "daily", "weekly"}},
+		"quiet_hours_start": {Default: "none", Values: quietHours()},
+	},
+	Privacy: {
+		"profile_visibility": {Default: "public", Values: []string{"public", "friends", "private"}},
+		"show_online_status": {Default: true},
+		"searchable":         {Default: true},
+	},
+}
+
+// quietHours returns "none" and the hours of the day, "00" to "23".
+func quietHours() []string {
+	hours := []string{"none"}
+	for h := range 24 {
+		hours = append(hours, fmt.Sprintf("%02d", h))
+	}
+	return hours
+}
+
+// maxAttempts bounds how often Update re-reads and retries after a
+// concurrent update of the same user.
+const maxAttempts = 3
+
+// Preferences maps group -> setting -> value.
+type Preferences map[string]map[string]any
+
+// Defaults returns every setting at its default value.
+func Defaults() Preferences {
+	p := Preferences{}
+	for group, settings := range Schema {
+		p[group] = map[string]any{}
+		for name, s := range settings {
+			p[group][name] = s.Default
+		}
+	}
+	return p
+}
+
+// Resolve returns the defaults overlaid with the known settings of stored.
+func Resolve(stored Preferences) Preferences {
+	p := Defaults()
+	for group, values := range stored {
+		for name, v := range values {
+			if s, ok := Schema[group][name]; ok && s.valid(v) {
+				p[group][name] = v
+			}
+		}
+	}
+	return p
+}
+
+// Merge returns a copy of p with the settings in patch applied.
+func (p Preferences) Merge(patch Preferences) Preferences {
+	out := Preferences{}
+	for _, src := range []Preferences{p, patch} {
+		for group, values := range src {
+			if out[group] == nil {
+				out[group] = map[string]any{}
+			}
+			for name, v := range values {
+				out[group][name] = v
+			}
+		}
+	}
+	return out
+}
+
+// Changed returns the names of the settings of group whose value differs
+// between p and other, sorted.
+func (p Preferences) Changed(other Preferences, group string) []string {
+	var changed []string
+	for name := range Schema[group] {
+		if p[group][name] != other[group][name] {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// valid reports whether v is an acceptable value of s.
+func (s Setting) valid(v any) bool {
+	if s.Values == nil {
+		_, ok := v.(bool)
+		return ok
+	}
+	str, ok := v.(string)
+	return ok && slices.Contains(s.Values, str)
+}
+
+// ParsePatch validates a partial JSON document such as
+// {"notifications": {"push_enabled": false}}. Unknown groups and settings
+// and values of the wrong type are rejected, and at least one setting must
+// be present.
+func ParsePatch(body []byte) (Preferences, error) {
+	var doc map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, errors.New("body must be a JSON object of preference groups")
+	}
+
+	patch := Preferences{}
+	for _, group := range sortedKeys(doc) {
+		settings, ok := Schema[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown preference group %q", group)
+		}
+		for _, name := range sortedKeys(doc[group]) {
+			s, ok := settings[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown preference %s.%s", group, name)
+			}
+			var v any
+			if err := json.Unmarshal(doc[group][name], &v); err != nil || !s.valid(v) {
+				return nil, fmt.Errorf("%s.%s must be %s", group, name, s.describe())
+			}
+			if patch[group] == nil {
+				patch[group] = map[string]any{}
+			}
+			patch[group][name] = v
+		}
+	}
+	if len(patch) == 0 {
+		return nil, errors.New("no preferences to update")
+	}
+	return patch, nil
+}
+
+// describe names the values s accepts, for error messages.
+func (s Setting) describe() string {
+	if s.Values == nil {
+		return "true or false"
+	}
+	quoted := make([]string, len(s.Values))
+	for i, v := range s.Values {
+		quoted[i] = strconv.Quote(v)
+	}
+	if len(quoted) > 5 {
+		return fmt.Sprintf("one of %s ... %s", quoted[0], quoted[len(quoted)-1])
+	}
+	return "one of " + strings.Join(quoted, ", ")
+}
+
+// sortedKeys returns the keys of m in order, so validation errors are
+// deterministic.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ErrConflict is returned when the item kept changing under Update.
+var ErrConflict = errors.New("preferences were modified concurrently")
+
+// Store reads and writes preference items.
+type Store struct {
+	DB    *db.Client
+	Table string
+}
+
+// NewStore returns a store over the preference table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.PreferenceTableName}
+}
+
+// item is the stored form of a user's preferences.
+type item struct {
+	UserID      string      `dynamodbav:"user_id"`
+	Preferences Preferences `dynamodbav:"preferences"`
+	Version     int         `dynamodbav:"version"`
+	UpdatedAt   string      `dynamodbav:"updated_at"`
+	DeletedAt   string      `dynamodbav:"deleted_at,omitempty"` // RFC 3339; set while the user is soft deleted
+}
+
+// Get returns the settings stored for userID, without defaults, and the
+// version of the item; version 0 means nothing is stored yet. The settings
+// of a soft-deleted user read as unset, at the version stored, so an update
+// replaces them.
+func (s *Store) Get(ctx context.Context, userID string) (Preferences, int, error) {
+	start := time.Now()
+	result, err := s.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.Table),
+		Key:            key(userID),
+		ConsistentRead: aws.Bool(true), // Update bases its condition on this read
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return nil, 0, db.Wrap(err, "getting preferences of "+userID)
+	}
+	if result.Item == nil {
+		return Preferences{}, 0, nil
+	}
+
+	stored, err := db.Decode[item](result.Item)
+	if err != nil {
+		return nil, 0, err
+	}
+	if stored.DeletedAt != "" {
+		return Preferences{}, stored.Version, nil
+	}
+	return stored.Preferences, stored.Version, nil
+}
+
+// Update applies patch to the settings of userID and returns them, with
+// defaults, before and after. Concurrent updates are detected with the
+// item's version and retried, since patches of different settings do not
+// conflict.
+func (s *Store) Update(ctx context.Context, userID string, patch Preferences) (before, after Preferences, err error) {
+	for range maxAttempts {
+		stored, version, err := s.Get(ctx, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		merged := stored.Merge(patch)
+		err = s.put(ctx, item{
+			UserID:      userID,
+			Preferences: merged,
+			Version:     version + 1,
+			UpdatedAt:   time.Now().UTC().Format(time.RFC3339),
+		}, version)
+		if db.ConditionFailed(err, -1) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return Resolve(stored), Resolve(merged), nil
+	}
+	return nil, nil, ErrConflict
+}
+
+// put writes it if the stored item is still at version.
+func (s *Store) put(ctx context.Context, it item, version int) error {
+	av, err := db.Encode(it)
+	if err != nil {
+		return err
+	}
+
+	// Items written before versioning have no version attribute
+	cond := expression.AttributeNotExists(expression.Name("version"))
+	if version > 0 {
+		cond = expression.Name("version").Equal(expression.Value(version))
+	}
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return fmt.Errorf("building preferences condition: %w", err)
+	}
+
+	start := time.Now()
+	_, err = s.DB.DynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(s.Table),
+		Item:                      av,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, "putting preferences of "+it.UserID)
+}
+
+// itemKey is the primary key of a preference item.
+type itemKey struct {
+	UserID string `dynamodbav:"user_id"`
+}
+
+// key returns the primary key of the preference item of userID.
+func key(userID string) db.Item {
+	return db.MustEncode(itemKey{UserID: userID})
+}
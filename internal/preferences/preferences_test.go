@@ -0,0 +1,107 @@
+package preferences
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+)
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		body    string
+		wantErr string // substring; empty means valid
+	}{
+		{body: `{"notifications":{"push_enabled":false}}`},
+		{body: `{"notifications":{"digest_frequency":"daily","quiet_hours_start":"22"},"privacy":{"searchable":false}}`},
+		{body: `{}`, wantErr: "no preferences"},
+		{body: `{"notifications":{}}`, wantErr: "no preferences"},
+		{body: `{"theme":{"dark":true}}`, wantErr: `unknown preference group "theme"`},
+		{body: `{"privacy":{"show_email":true}}`, wantErr: "unknown preference privacy.show_email"},
+		{body: `{"notifications":{"push_enabled":"no"}}`, wantErr: "must be true or false"},
+		{body: `{"privacy":{"profile_visibility":"hidden"}}`, wantErr: `one of "public", "friends", "private"`},
+		{body: `{"notifications":{"quiet_hours_start":"24"}}`, wantErr: `one of "none" ... "23"`},
+		{body: `{"notifications":true}`, wantErr: "JSON object"},
+	}
+	for _, tt := range tests {
+		_, err := ParsePatch([]byte(tt.body))
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("ParsePatch(%s) = %v", tt.body, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParsePatch(%s) error = %v, want %q", tt.body, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	stored := Preferences{
+		Notifications: {"push_enabled": false, "retired_setting": true},
+		Privacy:       {"profile_visibility": "bogus"},
+		"retired":     {"x": true},
+	}
+	got := Resolve(stored)
+
+	want := Defaults()
+	want[Notifications]["push_enabled"] = false
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve = %v, want %v", got, want)
+	}
+}
+
+func TestMergeChanged(t *testing.T) {
+	before := Resolve(Preferences{Notifications: {"email_marketing": true}})
+	after := Resolve(before.Merge(Preferences{
+		Notifications: {"email_marketing": false, "push_enabled": true}, // push_enabled unchanged
+		Privacy:       {"searchable": false},
+	}))
+
+	if got := before.Changed(after, Notifications); !reflect.DeepEqual(got, []string{"email_marketing"}) {
+		t.Errorf("Changed(notifications) = %v", got)
+	}
+	if got := before.Changed(after, Privacy); !reflect.DeepEqual(got, []string{"searchable"}) {
+		t.Errorf("Changed(privacy) = %v", got)
+	}
+	if before[Notifications]["email_marketing"] != true {
+		t.Error("Merge modified its receiver")
+	}
+}
+
+func TestItemRoundTrip(t *testing.T) {
+	it := item{
+		UserID:      "u1",
+		Preferences: Preferences{Notifications: {"push_enabled": false, "digest_frequency": "daily"}},
+		Version:     3,
+		UpdatedAt:   "2026-10-14T12:00:00Z",
+		DeletedAt:   "2026-10-15T12:00:00Z",
+	}
+	av, err := db.Encode(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := av["version"].(*types.AttributeValueMemberN); !ok {
+		t.Errorf("version = %#v, want a number", av["version"])
+	}
+	got, err := db.Decode[item](av)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, it) {
+		t.Errorf("round trip = %+v, want %+v", got, it)
+	}
+
+	// The deletion mark is only stored while set
+	av, _ = db.Encode(item{UserID: "u1"})
+	if _, ok := av["deleted_at"]; ok {
+		t.Errorf("deleted_at stored while unset: %v", av)
+	}
+	if !reflect.DeepEqual(key("u1"), db.Item{"user_id": av["user_id"]}) {
+		t.Errorf("key = %v", key("u1"))
+	}
+}
@@ -0,0 +1,139 @@
+package presence
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+)
+
+// ErrGone is returned when posting to a connection that no longer exists.
+var ErrGone = errors.New("connection is gone")
+
+// Message is the envelope of every message pushed to clients; Type tells
+// them how to read Data.
+type Message struct {
+	Type string `json:"type"` // e.g. "friend_request"
+	Data any    `json:"data,omitempty"`
+}
+
+// Result counts the connections a message was pushed to.
+type Result struct {
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+	Pruned int `json:"pruned"` // connections found gone, and removed
+}
+
+// Poster pushes messages to WebSocket clients through the API Gateway
+// management API, with SigV4-signed requests.
+type Poster struct {
+	Endpoint    string // e.g. https://abc123.execute-api.eu-west-1.amazonaws.com/prod
+	Region      string
+	Credentials aws.CredentialsProvider
+	Signer      *v4.Signer
+	HTTP        *http.Client
+	Connections *Store
+}
+
+// NewPoster returns a poster for the WebSocket API in cfg, signing with
+// awsCfg and finding users' connections in store.
+func NewPoster(store *Store, awsCfg aws.Config, cfg *config.Config) *Poster {
+	return &Poster{
+		Endpoint:    cfg.WebSocketEndpoint,
+		Region:      awsCfg.Region,
+		Credentials: awsCfg.Credentials,
+		Signer:      v4.NewSigner(),
+		HTTP:        http.DefaultClient,
+		Connections: store,
+	}
+}
+
+// Send pushes msg to every connection of userID, removing the connections
+// found gone. A user without connections is not an error; a failed post is
+// counted and logged, as the user's other connections may still get it.
+func (p *Poster) Send(ctx context.Context, userID string, msg Message) (Result, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return Result{}, err
+	}
+	conns, err := p.Connections.Connections(ctx, userID)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var res Result
+	for _, c := range conns {
+		err := p.PostToConnection(ctx, c.ID, data)
+		switch {
+		case err == nil:
+			res.Sent++
+			metrics.Count(ctx, metrics.RealtimeSent)
+		case errors.Is(err, ErrGone):
+			if err := p.Connections.Disconnect(ctx, c.ID); err != nil {
+				return res, err
+			}
+			res.Pruned++
+			metrics.Count(ctx, metrics.RealtimePruned)
+		default:
+			res.Failed++
+			metrics.Count(ctx, metrics.RealtimeFailed)
+			slog.WarnContext(ctx, "Realtime message failed", "user_id", userID, "connection_id", c.ID, logging.Err(err))
+		}
+	}
+	return res, nil
+}
+
+// PostToConnection sends data to connection connID as one WebSocket
+// message. It returns ErrGone if the client disconnected; throttling is
+// apperr.Throttled.
+func (p *Poster) PostToConnection(ctx context.Context, connID string, data []byte) error {
+	target := strings.TrimSuffix(p.Endpoint, "/") + "/@connections/" + url.PathEscape(connID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	creds, err := p.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving credentials: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if err := p.Signer.SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "execute-api", p.Region, time.Now()); err != nil {
+		return fmt.Errorf("signing request: %w", err)
+	}
+
+	start := time.Now()
+	resp, err := p.HTTP.Do(req)
+	metrics.Since(ctx, metrics.RealtimeLatency, start)
+	if err != nil {
+		return fmt.Errorf("posting to connection %s: %w", connID, err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return apperr.Throttled(fmt.Errorf("posting to connection %s: %s", connID, resp.Status))
+	}
+	return fmt.Errorf("posting to connection %s: %s: %.200s", connID, resp.Status, raw)
+}
@@ -0,0 +1,106 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/apperr"
+)
+
+// fakeAPI answers posts to each connection with the status in statuses
+// (200 when absent) and records the messages accepted.
+func fakeAPI(t *testing.T, statuses map[string]int) (*Poster, map[string]string) {
+	t.Helper()
+	posted := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") || !strings.Contains(r.Header.Get("Authorization"), "/execute-api/") {
+			t.Errorf("%s %s is not signed for execute-api", r.Method, r.URL.Path)
+		}
+		id, ok := strings.CutPrefix(r.URL.Path, "/prod/@connections/")
+		if r.Method != http.MethodPost || !ok {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if status, ok := statuses[id]; ok && status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		posted[id] = string(body)
+	}))
+	t.Cleanup(srv.Close)
+	return &Poster{
+		Endpoint: srv.URL + "/prod",
+		Region:   "eu-west-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+		Signer: v4.NewSigner(),
+		HTTP:   srv.Client(),
+	}, posted
+}
+
+func TestPostToConnection(t *testing.T) {
+	tests := []struct {
+		status   int
+		wantGone bool
+		wantKind apperr.Kind
+		wantErr  bool
+	}{
+		{status: 200},
+		{status: 410, wantGone: true, wantErr: true},
+		{status: 429, wantKind: apperr.KindThrottled, wantErr: true},
+		{status: 500, wantKind: apperr.KindInternal, wantErr: true},
+	}
+	for _, tt := range tests {
+		p, posted := fakeAPI(t, map[string]int{"c1": tt.status})
+
+		err := p.PostToConnection(context.Background(), "c1", []byte(`{"type":"ping"}`))
+		switch {
+		case (err != nil) != tt.wantErr:
+			t.Errorf("status %d: err = %v, want error %v", tt.status, err, tt.wantErr)
+		case errors.Is(err, ErrGone) != tt.wantGone:
+			t.Errorf("status %d: err = %v, want gone %v", tt.status, err, tt.wantGone)
+		case err != nil && !tt.wantGone && apperr.KindOf(err) != tt.wantKind:
+			t.Errorf("status %d: kind = %v, want %v", tt.status, apperr.KindOf(err), tt.wantKind)
+		case err == nil && posted["c1"] != `{"type":"ping"}`:
+			t.Errorf("posted %q", posted["c1"])
+		}
+	}
+}
+
+func TestSendPrunesGoneConnections(t *testing.T) {
+	m := connections(map[string][]string{"u1": {"c1", "c2", "c3"}})
+	p, posted := fakeAPI(t, map[string]int{"c2": 410, "c3": 500})
+	p.Connections = &Store{DB: m.Client(), Table: "connections", UserIndex: "user-index", TTL: time.Minute}
+
+	res, err := p.Send(context.Background(), "u1", Message{Type: "friend_request", Data: map[string]string{"user_id": "u2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (Result{Sent: 1, Failed: 1, Pruned: 1}) {
+		t.Errorf("Send = %+v", res)
+	}
+	if posted["c1"] != `{"type":"friend_request","data":{"user_id":"u2"}}` {
+		t.Errorf("posted %q", posted["c1"])
+	}
+
+	var deleted []string
+	for _, c := range m.Calls {
+		if in, ok := c.Input.(*dynamodb.DeleteItemInput); ok {
+			deleted = append(deleted, str(in.Key["connection_id"]))
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != "c2" {
+		t.Errorf("deleted %v, want [c2]", deleted)
+	}
+}
@@ -0,0 +1,170 @@
+// Package presence tracks which users are online through their connections
+// to the WebSocket API, and pushes realtime messages to them.
+//
+// The connection table is keyed by connection_id, with a GSI on user_id. A
+// connection is stored when the wsConnect function accepts it and deleted
+// by wsDisconnect; in between, clients send a heartbeat every few minutes,
+// which pushes expires_at, the table's TTL attribute, forward. API Gateway
+// does not always report disconnects (a phone losing its network drops the
+// socket silently), so connections without a heartbeat expire, and a user is
+// online while they have at least one unexpired connection.
+package presence
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/fanout"
+)
+
+// onlineConcurrency bounds the parallel queries of one Online call.
+const onlineConcurrency = 10
+
+// Connection is an open WebSocket connection of a user.
+type Connection struct {
+	ID          string    `json:"connection_id"`
+	UserID      string    `json:"user_id"`
+	ConnectedAt time.Time `json:"connected_at"`
+	ExpiresAt   time.Time `json:"expires_at"` // when the connection expires without a heartbeat
+}
+
+// record is an item of the connection table.
+type record struct {
+	ID          string `dynamodbav:"connection_id"`
+	UserID      string `dynamodbav:"user_id"`
+	ConnectedAt string `dynamodbav:"connected_at"` // RFC 3339
+	ExpiresAt   int64  `dynamodbav:"expires_at"`   // Unix seconds, the TTL attribute
+}
+
+func (r *record) connection() Connection {
+	c := Connection{ID: r.ID, UserID: r.UserID, ExpiresAt: time.Unix(r.ExpiresAt, 0).UTC()}
+	c.ConnectedAt, _ = time.Parse(time.RFC3339, r.ConnectedAt)
+	return c
+}
+
+// Store reads and writes the connection table.
+type Store struct {
+	DB        *db.Client
+	Table     string
+	UserIndex string        // GSI keyed by user_id
+	TTL       time.Duration // how long a connection lasts without a heartbeat
+}
+
+// NewStore returns a store over the connection table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.ConnectionTableName, UserIndex: cfg.ConnectionUserIndexName, TTL: cfg.ConnectionTTL}
+}
+
+// Connect records connection connID of userID.
+func (s *Store) Connect(ctx context.Context, connID, userID string) (Connection, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	c := Connection{ID: connID, UserID: userID, ConnectedAt: now, ExpiresAt: now.Add(s.TTL)}
+	item, err := attributevalue.MarshalMap(record{
+		ID:          c.ID,
+		UserID:      c.UserID,
+		ConnectedAt: now.Format(time.RFC3339),
+		ExpiresAt:   c.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return Connection{}, err
+	}
+	if err := s.DB.PutItem(ctx, s.Table, item); err != nil {
+		return Connection{}, err
+	}
+	return c, nil
+}
+
+// Heartbeat keeps connection connID alive for another TTL. A connection
+// that already expired, or was never stored, is apperr.NotFound: the
+// client must reconnect.
+func (s *Store) Heartbeat(ctx context.Context, connID string) error {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 key(connID),
+		UpdateExpression:    aws.String("SET expires_at = :expires_at"),
+		ConditionExpression: aws.String("attribute_exists(connection_id) AND expires_at > :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expires_at": &types.AttributeValueMemberN{Value: fmt.Sprint(start.Add(s.TTL).Unix())},
+			":now":        &types.AttributeValueMemberN{Value: fmt.Sprint(start.Unix())},
+		},
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return apperr.NotFound("Connection not found")
+	}
+	return db.Wrap(err, "refreshing connection")
+}
+
+// Disconnect removes connection connID. Removing a connection that is not
+// stored is not an error: API Gateway may report a disconnect after the
+// connection expired, or more than once.
+func (s *Store) Disconnect(ctx context.Context, connID string) error {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key:       key(connID),
+	})
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, "removing connection")
+}
+
+// Connections returns the unexpired connections of userID, the targets of a
+// realtime message to that user. DynamoDB deletes expired items lazily, so
+// they are filtered here.
+func (s *Store) Connections(ctx context.Context, userID string) ([]Connection, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.UserIndex),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		FilterExpression:       aws.String("expires_at > :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": &types.AttributeValueMemberS{Value: userID},
+			":now":     &types.AttributeValueMemberN{Value: fmt.Sprint(time.Now().Unix())},
+		},
+	}
+
+	conns := []Connection{}
+	var decodeErr error
+	err := s.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		var recs []record
+		if decodeErr = attributevalue.UnmarshalListOfMaps(items, &recs); decodeErr != nil {
+			return false
+		}
+		for _, rec := range recs {
+			conns = append(conns, rec.connection())
+		}
+		return true
+	})
+	if err == nil && decodeErr != nil {
+		err = fmt.Errorf("decoding connections: %w", decodeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return conns, nil
+}
+
+// Online reports whether each of userIDs has an unexpired connection. The
+// users are looked up in parallel; the first failure cancels the remaining
+// queries and is returned.
+func (s *Store) Online(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	return fanout.Map(ctx, onlineConcurrency, userIDs, func(ctx context.Context, userID string) (bool, error) {
+		conns, err := s.Connections(ctx, userID)
+		return len(conns) > 0, err
+	})
+}
+
+// key returns the primary key of a connection item.
+func key(connID string) db.Item {
+	return db.Item{"connection_id": &types.AttributeValueMemberS{Value: connID}}
+}
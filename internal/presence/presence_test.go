@@ -0,0 +1,130 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+// connections answers index queries with the connections of each user, as
+// connection IDs.
+func connections(byUser map[string][]string) *dbtest.Mock {
+	expires := fmt.Sprint(time.Now().Add(time.Minute).Unix())
+	return &dbtest.Mock{
+		QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			user := in.ExpressionAttributeValues[":user_id"]
+			var items []db.Item
+			for _, id := range byUser[str(user)] {
+				item := dbtest.Item("connection_id", id, "user_id", str(user), "connected_at", "2026-10-01T12:00:00Z")
+				item["expires_at"] = num(expires)
+				items = append(items, item)
+			}
+			return &dynamodb.QueryOutput{Items: items}, nil
+		},
+	}
+}
+
+func str(v types.AttributeValue) string {
+	s, _ := v.(*types.AttributeValueMemberS)
+	if s == nil {
+		return ""
+	}
+	return s.Value
+}
+
+func num(v string) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: v}
+}
+
+func TestConnect(t *testing.T) {
+	m := &dbtest.Mock{}
+	s := &Store{DB: m.Client(), Table: "connections", TTL: 10 * time.Minute}
+
+	c, err := s.Connect(context.Background(), "c1", "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != "c1" || c.UserID != "u1" || c.ExpiresAt.Sub(c.ConnectedAt) != 10*time.Minute {
+		t.Errorf("Connect = %+v", c)
+	}
+	in := m.Calls[0].Input.(*dynamodb.PutItemInput)
+	if str(in.Item["user_id"]) != "u1" || in.Item["expires_at"] == nil {
+		t.Errorf("stored %v", in.Item)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantKind apperr.Kind
+		wantErr  bool
+	}{
+		{name: "alive"},
+		{name: "expired", err: dbtest.ConditionFailed(), wantKind: apperr.KindNotFound, wantErr: true},
+		{name: "throttled", err: dbtest.Throttled(), wantKind: apperr.KindThrottled, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+					return &dynamodb.UpdateItemOutput{}, tt.err
+				},
+			}
+			s := &Store{DB: m.Client(), Table: "connections", TTL: time.Minute}
+
+			err := s.Heartbeat(context.Background(), "c1")
+			if (err != nil) != tt.wantErr || tt.wantErr && apperr.KindOf(err) != tt.wantKind {
+				t.Errorf("Heartbeat = %v, want kind %v", err, tt.wantKind)
+			}
+			in := m.Calls[0].Input.(*dynamodb.UpdateItemInput)
+			if aws.ToString(in.ConditionExpression) == "" {
+				t.Error("heartbeat would resurrect a deleted connection")
+			}
+		})
+	}
+}
+
+func TestOnline(t *testing.T) {
+	m := connections(map[string][]string{"u1": {"c1", "c2"}, "u3": {"c3"}})
+	s := &Store{DB: m.Client(), Table: "connections", UserIndex: "user-index"}
+
+	online, err := s.Online(context.Background(), []string{"u1", "u2", "u3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"u1": true, "u2": false, "u3": true}
+	for id, w := range want {
+		if got, ok := online[id]; !ok || got != w {
+			t.Errorf("online[%s] = %v, %v; want %v", id, got, ok, w)
+		}
+	}
+	for _, c := range m.Calls {
+		in := c.Input.(*dynamodb.QueryInput)
+		if aws.ToString(in.IndexName) != "user-index" || aws.ToString(in.FilterExpression) == "" {
+			t.Errorf("query %+v does not filter expired connections of the user index", in)
+		}
+	}
+}
+
+func TestOnlineFailure(t *testing.T) {
+	m := &dbtest.Mock{
+		QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			return nil, dbtest.Throttled()
+		},
+	}
+	s := &Store{DB: m.Client(), Table: "connections", UserIndex: "user-index"}
+
+	if _, err := s.Online(context.Background(), []string{"u1", "u2"}); apperr.KindOf(err) != apperr.KindThrottled {
+		t.Errorf("Online = %v, want throttled", err)
+	}
+}
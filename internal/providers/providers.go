@@ -0,0 +1,260 @@
+// Package providers keeps the external identity providers, Google and
+// Apple, linked to accounts.
+//
+// A first sign-in through a provider creates a Cognito user of its own,
+// apart from any account with the same address. Linking makes Cognito sign
+// that provider account in as an existing user instead: the provider's
+// Cognito user is deleted and its identity attached to the account. Links
+// are recorded on the user record, which this package owns two attributes
+// of: providers, mapping each linked provider to when it was linked, for
+// clients to show connected accounts, and provider_subjects, mapping it to
+// the account at the provider, which unlinking needs and callers never see
+// (see users.SensitiveAttributes).
+package providers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/relationships"
+	"troggle-backend/internal/users"
+)
+
+// Providers, as clients and the user record name them.
+const (
+	Google = "google"
+	Apple  = "apple"
+)
+
+// Attributes of the user record the package owns.
+const (
+	Attribute         = "providers"         // provider -> RFC 3339 time linked
+	SubjectsAttribute = "provider_subjects" // provider -> account at the provider
+)
+
+// cognitoNames are the names of the providers in the user pool.
+var cognitoNames = map[string]string{
+	Google: "Google",
+	Apple:  "SignInWithApple",
+}
+
+// CognitoName returns the name of provider in the user pool, and whether
+// it is supported.
+func CognitoName(provider string) (string, bool) {
+	name, ok := cognitoNames[provider]
+	return name, ok
+}
+
+// FromCognito returns the provider of the user pool's name, and whether it
+// is supported.
+func FromCognito(name string) (string, bool) {
+	for provider, n := range cognitoNames {
+		if strings.EqualFold(n, name) {
+			return provider, true
+		}
+	}
+	return "", false
+}
+
+// mergedFields are the profile fields Merge carries over.
+var mergedFields = []string{"display_name", "bio", "avatar_url"}
+
+var (
+	// ErrAlreadyLinked refuses links of a provider the account has a link
+	// of already.
+	ErrAlreadyLinked = &apperr.Error{Kind: apperr.KindConflict, Code: "PROVIDER_ALREADY_LINKED", Message: "An account of this provider is linked already; unlink it first"}
+
+	// ErrAccountInUse refuses merges of provider accounts with data that
+	// would be lost, such as friends or followers.
+	ErrAccountInUse = &apperr.Error{Kind: apperr.KindConflict, Code: "PROVIDER_ACCOUNT_IN_USE", Message: "The provider account has a profile in use; delete it before linking"}
+)
+
+// Store reads and writes the links of the user table.
+type Store struct {
+	DB    *db.Client
+	Table string
+}
+
+// NewStore returns a store over the user table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.UserTableName}
+}
+
+// Link records the link of provider to userID at now, with the account at
+// the provider, subject. It fails with ErrAlreadyLinked when the user has a
+// link of provider, or with apperr.NotFound when the user is gone.
+func (s *Store) Link(ctx context.Context, userID, provider, subject string, now time.Time) error {
+	at := &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)}
+	sub := &types.AttributeValueMemberS{Value: subject}
+	live := "attribute_exists(user_id) AND attribute_not_exists(" + users.DeletedAttribute + ")"
+
+	// Nested attributes can only be set in maps that exist, and a map can
+	// only be created whole, so the first link takes another update; each
+	// is retried once should the other win a race
+	for range 2 {
+		err := s.update(ctx, &dynamodb.UpdateItemInput{
+			Key:                      users.Key(userID),
+			UpdateExpression:         aws.String("SET #links.#p = :at, #subjects.#p = :sub"),
+			ConditionExpression:      aws.String(live + " AND attribute_exists(#links) AND attribute_not_exists(#links.#p)"),
+			ExpressionAttributeNames: map[string]string{"#links": Attribute, "#subjects": SubjectsAttribute, "#p": provider},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":at":  at,
+				":sub": sub,
+			},
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		}, "linking provider")
+		if !db.ConditionFailed(err, -1) {
+			return err
+		}
+		if current := db.CurrentItem(err); current == nil {
+			return apperr.NotFound("User not found")
+		} else if _, ok := current[users.DeletedAttribute]; ok {
+			return apperr.NotFound("User not found")
+		} else if links, ok := current[Attribute].(*types.AttributeValueMemberM); ok {
+			if _, linked := links.Value[provider]; linked {
+				return ErrAlreadyLinked
+			}
+			continue
+		}
+
+		err = s.update(ctx, &dynamodb.UpdateItemInput{
+			Key:                      users.Key(userID),
+			UpdateExpression:         aws.String("SET #links = :links, #subjects = :subjects"),
+			ConditionExpression:      aws.String(live + " AND attribute_not_exists(#links)"),
+			ExpressionAttributeNames: map[string]string{"#links": Attribute, "#subjects": SubjectsAttribute},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":links":    &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{provider: at}},
+				":subjects": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{provider: sub}},
+			},
+		}, "linking provider")
+		if !db.ConditionFailed(err, -1) {
+			return err
+		}
+	}
+	return apperr.Conflict("Links of the account changed meanwhile; retry")
+}
+
+// Unlink removes the link of provider from userID, and reports whether
+// there was one.
+func (s *Store) Unlink(ctx context.Context, userID, provider string) (bool, error) {
+	err := s.update(ctx, &dynamodb.UpdateItemInput{
+		Key:                      users.Key(userID),
+		UpdateExpression:         aws.String("REMOVE #links.#p, #subjects.#p"),
+		ConditionExpression:      aws.String("attribute_exists(#links.#p)"),
+		ExpressionAttributeNames: map[string]string{"#links": Attribute, "#subjects": SubjectsAttribute, "#p": provider},
+	}, "unlinking provider")
+	if db.ConditionFailed(err, -1) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Subject returns the account at provider linked to user, as read from the
+// user table, or "" when there is none.
+func Subject(user db.Item, provider string) string {
+	subjects, ok := user[SubjectsAttribute].(*types.AttributeValueMemberM)
+	if !ok {
+		return ""
+	}
+	sub, _ := subjects.Value[provider].(*types.AttributeValueMemberS)
+	if sub == nil {
+		return ""
+	}
+	return sub.Value
+}
+
+// Mergeable returns ErrAccountInUse when the record of a provider account,
+// from, has relationships, which merging it would lose.
+func Mergeable(from db.Item) error {
+	for _, counter := range relationships.Counters {
+		if n, ok := from[counter].(*types.AttributeValueMemberN); ok && n.Value != "0" {
+			return ErrAccountInUse
+		}
+	}
+	return nil
+}
+
+// Merge carries the profile of from, the record of a provider account
+// linked to into, over to into: the fields into leaves empty take the
+// values of from. It fails as Mergeable, changing nothing, and with
+// users.ErrVersionConflict when into changed since it was read. It returns
+// the fields it set.
+func (s *Store) Merge(ctx context.Context, into, from db.Item, now time.Time) (map[string]string, error) {
+	if err := Mergeable(from); err != nil {
+		return nil, err
+	}
+	target, err := db.Decode[map[string]any](into)
+	if err != nil {
+		return nil, err
+	}
+	source, err := db.Decode[map[string]any](from)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	for _, name := range mergedFields {
+		if v, _ := source[name].(string); v != "" {
+			if current, _ := target[name].(string); current == "" {
+				fields[name] = v
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return fields, nil
+	}
+
+	user, err := db.Decode[users.User](into)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{"#version": "version"}
+	values := map[string]types.AttributeValue{
+		":updated_at": &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		":next":       &types.AttributeValueMemberN{Value: strconv.Itoa(user.Version + 1)},
+	}
+	sets := []string{"#version = :next", "updated_at = :updated_at"}
+	for name, v := range fields {
+		names["#"+name] = name
+		values[":"+name] = &types.AttributeValueMemberS{Value: v}
+		sets = append(sets, "#"+name+" = :"+name)
+	}
+	condition := "attribute_exists(user_id) AND #version = :version"
+	if user.Version == 0 {
+		// Records written before versioning have no version attribute
+		condition = "attribute_exists(user_id) AND attribute_not_exists(#version)"
+	} else {
+		values[":version"] = &types.AttributeValueMemberN{Value: strconv.Itoa(user.Version)}
+	}
+	err = s.update(ctx, &dynamodb.UpdateItemInput{
+		Key:                       users.Key(user.UserID),
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ")),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}, "merging profile")
+	if db.ConditionFailed(err, -1) {
+		return nil, users.ErrVersionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// update runs in on the user table.
+func (s *Store) update(ctx context.Context, in *dynamodb.UpdateItemInput, op string) error {
+	in.TableName = aws.String(s.Table)
+	start := time.Now()
+	_, err := s.DB.DynamoDB.UpdateItem(ctx, in)
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, op)
+}
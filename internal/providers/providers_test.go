@@ -0,0 +1,126 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/users"
+)
+
+func TestLink(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		current db.Item // the record as the first update finds it
+		want    error
+		updates int
+	}{
+		{name: "first link", current: dbtest.Item("user_id", "u1"), updates: 2},
+		{name: "second provider", current: withLinks(dbtest.Item("user_id", "u1"), Apple), updates: 1},
+		{name: "linked already", current: withLinks(dbtest.Item("user_id", "u1"), Google), want: ErrAlreadyLinked, updates: 1},
+		{name: "no user", updates: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updates []string
+			m := &dbtest.Mock{UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				expr := aws.ToString(in.UpdateExpression)
+				updates = append(updates, expr)
+				links, hasLinks := tt.current[Attribute].(*types.AttributeValueMemberM)
+				linked := false
+				if hasLinks {
+					_, linked = links.Value[Google]
+				}
+				nested := strings.Contains(expr, "#links.#p")
+				if tt.current == nil || linked || nested != hasLinks {
+					return nil, &types.ConditionalCheckFailedException{Item: tt.current}
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			}}
+			s := &Store{DB: m.Client(), Table: "users"}
+			err := s.Link(context.Background(), "u1", Google, "g-123", now)
+			if tt.current == nil {
+				if err == nil {
+					t.Fatal("Link succeeded without a user")
+				}
+			} else if !errors.Is(err, tt.want) {
+				t.Fatalf("Link = %v, want %v", err, tt.want)
+			}
+			if len(updates) != tt.updates {
+				t.Errorf("updates = %q, want %d", updates, tt.updates)
+			}
+		})
+	}
+}
+
+// withLinks returns item with links of the given providers.
+func withLinks(item db.Item, linked ...string) db.Item {
+	links := map[string]types.AttributeValue{}
+	subjects := map[string]types.AttributeValue{}
+	for _, p := range linked {
+		links[p] = &types.AttributeValueMemberS{Value: "2026-01-01T00:00:00Z"}
+		subjects[p] = &types.AttributeValueMemberS{Value: "sub-" + p}
+	}
+	item[Attribute] = &types.AttributeValueMemberM{Value: links}
+	item[SubjectsAttribute] = &types.AttributeValueMemberM{Value: subjects}
+	return item
+}
+
+func TestSubject(t *testing.T) {
+	item := withLinks(dbtest.Item("user_id", "u1"), Google)
+	if got := Subject(item, Google); got != "sub-google" {
+		t.Errorf("Subject(google) = %q", got)
+	}
+	if got := Subject(item, Apple); got != "" {
+		t.Errorf("Subject(apple) = %q, want none", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	into := dbtest.Item("user_id", "u1", "display_name", "Jane", "bio", "")
+	into["version"] = &types.AttributeValueMemberN{Value: "3"}
+	from := dbtest.Item("user_id", "u2", "display_name", "Janey", "bio", "Hi", "avatar_url", "https://cdn.example/a.png")
+
+	m := &dbtest.Mock{}
+	s := &Store{DB: m.Client(), Table: "users"}
+	fields, err := s.Merge(context.Background(), into, from, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 || fields["bio"] != "Hi" || fields["avatar_url"] != "https://cdn.example/a.png" {
+		t.Errorf("merged = %v, want bio and avatar_url", fields)
+	}
+	in := m.Calls[0].Input.(*dynamodb.UpdateItemInput)
+	if v := in.ExpressionAttributeValues[":version"].(*types.AttributeValueMemberN).Value; v != "3" {
+		t.Errorf("condition version = %s, want 3", v)
+	}
+
+	from["friend_count"] = &types.AttributeValueMemberN{Value: "2"}
+	if _, err := s.Merge(context.Background(), into, from, time.Now()); !errors.Is(err, ErrAccountInUse) {
+		t.Errorf("Merge of a profile with friends = %v, want ErrAccountInUse", err)
+	}
+	if len(m.Calls) != 1 {
+		t.Errorf("%d calls, want nothing written for a refused merge", len(m.Calls))
+	}
+
+	if _, ok := users.SensitiveAttributes[SubjectsAttribute]; !ok {
+		t.Errorf("%s is not a sensitive attribute", SubjectsAttribute)
+	}
+}
+
+func TestFromCognito(t *testing.T) {
+	for name, want := range map[string]string{"Google": Google, "SignInWithApple": Apple, "Facebook": ""} {
+		if got, _ := FromCognito(name); got != want {
+			t.Errorf("FromCognito(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Package push delivers notifications to the registered devices of a user
+// through SNS mobile push.
+//
+// Every device token gets an SNS platform endpoint in the platform
+// application of its platform (APNs, APNs sandbox or FCM). The endpoint is
+// created on the first push and remembered on the device record. When SNS
+// reports an endpoint disabled, because APNs or FCM said the token is no
+// longer valid (the app was uninstalled, or the token rotated), the device is
+// unregistered and the endpoint deleted.
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/devices"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+)
+
+// KindSocial marks notifications about other users' activity, which users
+// can turn off separately (the push_social preference).
+const KindSocial = "social"
+
+// maxPayloadBytes is the largest notification APNs accepts; FCM allows
+// slightly more.
+const maxPayloadBytes = 4096
+
+// Notification is what a user is shown.
+type Notification struct {
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Kind  string            `json:"kind,omitempty"` // "" or KindSocial
+	Data  map[string]string `json:"data,omitempty"` // passed to the app, e.g. a deep link
+	Badge *int              `json:"badge,omitempty"`
+	Sound string            `json:"sound,omitempty"`
+}
+
+// Validate checks that n can be delivered.
+func (n *Notification) Validate() error {
+	if n.Title == "" && n.Body == "" {
+		return errors.New("notification needs a title or a body")
+	}
+	if n.Kind != "" && n.Kind != KindSocial {
+		return fmt.Errorf("unknown notification kind %q", n.Kind)
+	}
+	apns, _, err := n.payloads()
+	if err != nil {
+		return err
+	}
+	if len(apns) > maxPayloadBytes {
+		return fmt.Errorf("notification exceeds %d bytes", maxPayloadBytes)
+	}
+	return nil
+}
+
+// payloads returns the APNs and FCM payloads of n.
+func (n *Notification) payloads() (apns, fcm string, err error) {
+	aps := map[string]any{"alert": map[string]string{"title": n.Title, "body": n.Body}}
+	if n.Badge != nil {
+		aps["badge"] = *n.Badge
+	}
+	if n.Sound != "" {
+		aps["sound"] = n.Sound
+	}
+	apnsDoc := map[string]any{"aps": aps}
+	if len(n.Data) > 0 {
+		apnsDoc["data"] = n.Data
+	}
+
+	message := map[string]any{"notification": map[string]string{"title": n.Title, "body": n.Body}}
+	if len(n.Data) > 0 {
+		message["data"] = n.Data
+	}
+	if n.Sound != "" {
+		message["android"] = map[string]any{"notification": map[string]string{"sound": n.Sound}}
+	}
+	fcmDoc := map[string]any{"fcmV1Message": map[string]any{"message": message}}
+
+	a, err := json.Marshal(apnsDoc)
+	if err != nil {
+		return "", "", err
+	}
+	f, err := json.Marshal(fcmDoc)
+	if err != nil {
+		return "", "", err
+	}
+	return string(a), string(f), nil
+}
+
+// message returns the SNS message of n, with one payload per platform.
+func (n *Notification) message() (string, error) {
+	apns, fcm, err := n.payloads()
+	if err != nil {
+		return "", fmt.Errorf("encoding notification: %w", err)
+	}
+	body := n.Body
+	if body == "" {
+		body = n.Title
+	}
+	msg, err := json.Marshal(map[string]string{
+		"default":      body,
+		"APNS":         apns,
+		"APNS_SANDBOX": apns,
+		"GCM":          fcm,
+	})
+	if err != nil {
+		return "", fmt.Errorf("encoding notification: %w", err)
+	}
+	return string(msg), nil
+}
+
+// SNSAPI is the subset of the SNS client used for mobile push.
+type SNSAPI interface {
+	CreatePlatformEndpoint(ctx context.Context, params *sns.CreatePlatformEndpointInput, optFns ...func(*sns.Options)) (*sns.CreatePlatformEndpointOutput, error)
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+	DeleteEndpoint(ctx context.Context, params *sns.DeleteEndpointInput, optFns ...func(*sns.Options)) (*sns.DeleteEndpointOutput, error)
+}
+
+// Apps are the SNS platform application ARNs by platform. An empty ARN
+// disables pushes to that platform.
+type Apps struct {
+	APNs        string
+	APNsSandbox string
+	FCM         string
+}
+
+// For returns the platform application of d.
+func (a Apps) For(d devices.Device) string {
+	switch {
+	case d.Platform == devices.PlatformAPNs && d.Sandbox:
+		return a.APNsSandbox
+	case d.Platform == devices.PlatformAPNs:
+		return a.APNs
+	case d.Platform == devices.PlatformFCM:
+		return a.FCM
+	}
+	return ""
+}
+
+// Result counts what happened to each device of a push.
+type Result struct {
+	Sent    int `json:"sent"`
+	Failed  int `json:"failed"`
+	Pruned  int `json:"pruned"`  // devices whose token was no longer valid
+	Skipped int `json:"skipped"` // devices of a platform without an application
+}
+
+// Sender pushes notifications to the devices of users.
+type Sender struct {
+	SNS     SNSAPI
+	Devices *devices.Store
+	Apps    Apps
+}
+
+// NewSender returns a sender using the platform applications in cfg.
+func NewSender(client SNSAPI, store *devices.Store, cfg *config.Config) *Sender {
+	return &Sender{
+		SNS:     client,
+		Devices: store,
+		Apps:    Apps{APNs: cfg.PushAPNsApp, APNsSandbox: cfg.PushAPNsSandboxApp, FCM: cfg.PushFCMApp},
+	}
+}
+
+// errPruned reports a device removed because its endpoint was disabled.
+var errPruned = errors.New("push endpoint disabled")
+
+// Send pushes n to every device of userID. Failures of single devices are
+// counted in the result, not returned: the other devices still get the
+// notification.
+func (s *Sender) Send(ctx context.Context, userID string, n Notification) (Result, error) {
+	msg, err := n.message()
+	if err != nil {
+		return Result{}, err
+	}
+	devs, err := s.Devices.ForUser(ctx, userID)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var res Result
+	for _, d := range devs {
+		app := s.Apps.For(d)
+		if app == "" {
+			res.Skipped++
+			continue
+		}
+		err := s.deliver(ctx, d, app, msg)
+		switch {
+		case err == nil:
+			res.Sent++
+			metrics.Count(ctx, metrics.PushSent)
+		case errors.Is(err, errPruned):
+			res.Pruned++
+			metrics.Count(ctx, metrics.PushPruned)
+		default:
+			res.Failed++
+			metrics.Count(ctx, metrics.PushFailed)
+			slog.WarnContext(ctx, "Push failed", "user_id", userID, "platform", d.Platform, logging.Err(err))
+		}
+	}
+	return res, nil
+}
+
+// deliver publishes msg to the endpoint of d, creating it if needed.
+func (s *Sender) deliver(ctx context.Context, d devices.Device, app, msg string) error {
+	arn := d.EndpointARN
+	if arn == "" {
+		var err error
+		if arn, err = s.createEndpoint(ctx, d, app); err != nil {
+			return err
+		}
+	}
+
+	err := s.publish(ctx, arn, msg)
+	var missing *snstypes.NotFoundException
+	if errors.As(err, &missing) && d.EndpointARN != "" {
+		// The remembered endpoint was deleted outside of troggle
+		if arn, err = s.createEndpoint(ctx, d, app); err != nil {
+			return err
+		}
+		err = s.publish(ctx, arn, msg)
+	}
+
+	var disabled *snstypes.EndpointDisabledException
+	if errors.As(err, &disabled) {
+		s.prune(ctx, d, arn)
+		return errPruned
+	}
+	return err
+}
+
+func (s *Sender) publish(ctx context.Context, arn, msg string) error {
+	_, err := s.SNS.Publish(ctx, &sns.PublishInput{
+		TargetArn:        aws.String(arn),
+		Message:          aws.String(msg),
+		MessageStructure: aws.String("json"),
+	})
+	return err
+}
+
+// existingEndpoint extracts the ARN from the error SNS returns when the
+// token already has an endpoint with other attributes.
+var existingEndpoint = regexp.MustCompile(`Endpoint (arn:aws[\w-]*:sns:\S+) already exists`)
+
+// createEndpoint returns the endpoint of d's token in app, creating it if
+// needed, and remembers it on the device.
+func (s *Sender) createEndpoint(ctx context.Context, d devices.Device, app string) (string, error) {
+	var arn string
+	out, err := s.SNS.CreatePlatformEndpoint(ctx, &sns.CreatePlatformEndpointInput{
+		PlatformApplicationArn: aws.String(app),
+		Token:                  aws.String(d.Token),
+	})
+	var invalid *snstypes.InvalidParameterException
+	switch {
+	case err == nil:
+		arn = aws.ToString(out.EndpointArn)
+	case errors.As(err, &invalid) && existingEndpoint.MatchString(invalid.ErrorMessage()):
+		arn = existingEndpoint.FindStringSubmatch(invalid.ErrorMessage())[1]
+	default:
+		return "", fmt.Errorf("creating push endpoint: %w", err)
+	}
+
+	if err := s.Devices.SetEndpoint(ctx, d.UserID, d.Token, arn); err != nil {
+		// Only costs a CreatePlatformEndpoint call next time
+		slog.WarnContext(ctx, "Error saving push endpoint", logging.Err(err))
+	}
+	return arn, nil
+}
+
+// prune unregisters d and deletes its endpoint. Failures are logged: the
+// device is pruned again on the next push, and expires eventually anyway.
+func (s *Sender) prune(ctx context.Context, d devices.Device, arn string) {
+	if err := s.Devices.Unregister(ctx, d.UserID, d.Token); err != nil {
+		slog.WarnContext(ctx, "Error unregistering disabled device", logging.Err(err))
+	}
+	if _, err := s.SNS.DeleteEndpoint(ctx, &sns.DeleteEndpointInput{EndpointArn: aws.String(arn)}); err != nil {
+		slog.WarnContext(ctx, "Error deleting disabled push endpoint", logging.Err(err))
+	}
+	slog.InfoContext(ctx, "Pruned device with a disabled push endpoint", "user_id", d.UserID, "platform", d.Platform)
+}
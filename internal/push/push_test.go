@@ -0,0 +1,150 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/devices"
+)
+
+// fakeSNS answers publishes to the endpoints in failures with their error.
+type fakeSNS struct {
+	createErr error
+	failures  map[string]error
+	created   []string // tokens
+	published []string // endpoint ARNs
+	deleted   []string // endpoint ARNs
+}
+
+func (f *fakeSNS) CreatePlatformEndpoint(_ context.Context, in *sns.CreatePlatformEndpointInput, _ ...func(*sns.Options)) (*sns.CreatePlatformEndpointOutput, error) {
+	f.created = append(f.created, aws.ToString(in.Token))
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	return &sns.CreatePlatformEndpointOutput{EndpointArn: aws.String("arn:aws:sns:us-east-1:1:endpoint/new/" + aws.ToString(in.Token))}, nil
+}
+
+func (f *fakeSNS) Publish(_ context.Context, in *sns.PublishInput, _ ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	arn := aws.ToString(in.TargetArn)
+	if err := f.failures[arn]; err != nil {
+		return nil, err
+	}
+	f.published = append(f.published, arn)
+	return &sns.PublishOutput{}, nil
+}
+
+func (f *fakeSNS) DeleteEndpoint(_ context.Context, in *sns.DeleteEndpointInput, _ ...func(*sns.Options)) (*sns.DeleteEndpointOutput, error) {
+	f.deleted = append(f.deleted, aws.ToString(in.EndpointArn))
+	return &sns.DeleteEndpointOutput{}, nil
+}
+
+// device is a stored device item.
+func device(token, platform, endpoint string, sandbox bool) db.Item {
+	item := dbtest.Item("user_id", "u1", "token", token, "platform", platform)
+	item["expires_at"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)}
+	item["sandbox"] = &types.AttributeValueMemberBOOL{Value: sandbox}
+	if endpoint != "" {
+		item["endpoint_arn"] = &types.AttributeValueMemberS{Value: endpoint}
+	}
+	return item
+}
+
+func TestSend(t *testing.T) {
+	m := &dbtest.Mock{QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		return &dynamodb.QueryOutput{Items: []db.Item{
+			device("ios", devices.PlatformAPNs, "arn:ios", false),
+			device("dev", devices.PlatformAPNs, "", true),
+			device("gone", devices.PlatformFCM, "arn:gone", false),
+			device("broken", devices.PlatformFCM, "arn:broken", false),
+		}}, nil
+	}}
+	fake := &fakeSNS{failures: map[string]error{
+		"arn:gone":   &snstypes.EndpointDisabledException{Message: aws.String("disabled")},
+		"arn:broken": &snstypes.InternalErrorException{Message: aws.String("oops")},
+	}}
+	s := &Sender{
+		SNS:     fake,
+		Devices: &devices.Store{DB: m.Client(), Table: "devices"},
+		Apps:    Apps{APNs: "arn:app/apns", APNsSandbox: "arn:app/sandbox", FCM: "arn:app/fcm"},
+	}
+
+	res, err := s.Send(context.Background(), "u1", Notification{Title: "Hi", Body: "You have a new follower"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Result{Sent: 2, Failed: 1, Pruned: 1}); res != want {
+		t.Errorf("Send = %+v, want %+v", res, want)
+	}
+	if len(fake.created) != 1 || fake.created[0] != "dev" {
+		t.Errorf("created endpoints for %v, want [dev]", fake.created)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "arn:gone" {
+		t.Errorf("deleted endpoints %v, want [arn:gone]", fake.deleted)
+	}
+
+	var saved, unregistered []string
+	for _, c := range m.Calls {
+		switch in := c.Input.(type) {
+		case *dynamodb.UpdateItemInput:
+			saved = append(saved, in.Key["token"].(*types.AttributeValueMemberS).Value)
+		case *dynamodb.DeleteItemInput:
+			unregistered = append(unregistered, in.Key["token"].(*types.AttributeValueMemberS).Value)
+		}
+	}
+	if len(saved) != 1 || saved[0] != "dev" {
+		t.Errorf("saved endpoints of %v, want [dev]", saved)
+	}
+	if len(unregistered) != 1 || unregistered[0] != "gone" {
+		t.Errorf("unregistered %v, want [gone]", unregistered)
+	}
+}
+
+func TestCreateEndpointExisting(t *testing.T) {
+	fake := &fakeSNS{createErr: &snstypes.InvalidParameterException{Message: aws.String(
+		"Invalid parameter: Token Reason: Endpoint arn:aws:sns:us-east-1:1:endpoint/GCM/app/abc already exists with the same Token, but different attributes.",
+	)}}
+	s := &Sender{SNS: fake, Devices: &devices.Store{DB: (&dbtest.Mock{}).Client(), Table: "devices"}}
+
+	arn, err := s.createEndpoint(context.Background(), devices.Device{UserID: "u1", Token: "t"}, "arn:app/fcm")
+	if err != nil || arn != "arn:aws:sns:us-east-1:1:endpoint/GCM/app/abc" {
+		t.Errorf("createEndpoint = %q, %v", arn, err)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	badge := 3
+	n := Notification{Title: "Hi", Body: "Hello", Data: map[string]string{"link": "troggle://x"}, Badge: &badge}
+	msg, err := n.message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var platforms map[string]string
+	if err := json.Unmarshal([]byte(msg), &platforms); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"default", "APNS", "APNS_SANDBOX", "GCM"} {
+		if platforms[key] == "" {
+			t.Errorf("message has no %s payload: %s", key, msg)
+		}
+	}
+	if !strings.Contains(platforms["APNS"], `"badge":3`) || !strings.Contains(platforms["GCM"], `"fcmV1Message"`) {
+		t.Errorf("payloads = %s", msg)
+	}
+
+	big := Notification{Body: strings.Repeat("x", maxPayloadBytes)}
+	if err := big.Validate(); err == nil {
+		t.Error("Validate accepted an oversized notification")
+	}
+}
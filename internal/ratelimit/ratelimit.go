@@ -0,0 +1,331 @@
+// Package ratelimit throttles callers with token buckets stored in DynamoDB,
+// so the limit holds across every concurrent Lambda container.
+//
+// Each bucket is one item holding the remaining tokens and the time they were
+// last counted. Tokens refill continuously at the limit's rate up to its
+// burst; a request takes one token, or several when it is worth several
+// (see Limiter.Charge), or is rejected with 429 and a Retry-After telling the
+// caller when the tokens will be there.
+//
+// A function's built-in policy can be overridden per stage through
+// RATE_LIMIT_PER_IP and RATE_LIMIT_PER_USER, and at runtime through the
+// rate_limits/<function>/per_ip and per_user parameters of package
+// dynconfig, which take precedence.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/dynconfig"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+)
+
+// Environment variables overriding a function's built-in policy, in the
+// format accepted by ParseLimit. "off" disables the limit.
+const (
+	EnvPerIP   = "RATE_LIMIT_PER_IP"
+	EnvPerUser = "RATE_LIMIT_PER_USER"
+)
+
+// maxAttempts bounds the optimistic read-modify-write loop on a contended
+// bucket.
+const maxAttempts = 3
+
+// Limit is a token bucket: Rate tokens per second, holding at most Burst.
+// The zero Limit disables limiting.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute returns a limit of n requests per minute with a burst of n.
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+// ParseLimit parses "<n>/<s|m|h>", e.g. "60/m", into a limit of n requests
+// per unit with a burst of n.
+func ParseLimit(s string) (Limit, error) {
+	if s == "off" {
+		return Limit{}, nil
+	}
+	count, unit, ok := strings.Cut(s, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if per == 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit unit in %q", s)
+	}
+	return Limit{Rate: float64(n) / per.Seconds(), Burst: n}, nil
+}
+
+func (l Limit) enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Policy is the set of limits applied to one endpoint. Each caller has one
+// bucket per limit: by source IP, and by Cognito subject once authenticated.
+type Policy struct {
+	PerIP   Limit
+	PerUser Limit
+}
+
+// FromEnv returns p with any limits overridden by RATE_LIMIT_PER_IP and
+// RATE_LIMIT_PER_USER.
+func (p Policy) FromEnv() (Policy, error) {
+	var errs []error
+	for env, dst := range map[string]*Limit{EnvPerIP: &p.PerIP, EnvPerUser: &p.PerUser} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		l, err := ParseLimit(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", env, err))
+			continue
+		}
+		*dst = l
+	}
+	return p, errors.Join(errs...)
+}
+
+// Limiter applies policies using buckets in the rate limit table. Items
+// expire through the table's TTL attribute, expires_at.
+type Limiter struct {
+	DB     *db.Client
+	Table  string
+	Params *dynconfig.Store // runtime overrides of policies; nil for none
+}
+
+// New returns a Limiter backed by the configured rate limit table, reading
+// overrides from the container's dynconfig store.
+func New(client *db.Client, cfg *config.Config) *Limiter {
+	params, err := dynconfig.Shared(context.Background(), cfg)
+	if err != nil {
+		slog.Warn("Rate limit overrides unavailable", logging.Err(err))
+	}
+	return &Limiter{DB: client, Table: cfg.RateLimitTableName, Params: params}
+}
+
+// override returns p with any limits overridden by parameters of the
+// running function. Invalid overrides are ignored.
+func (l *Limiter) override(ctx context.Context, p Policy) Policy {
+	for kind, dst := range map[string]*Limit{"per_ip": &p.PerIP, "per_user": &p.PerUser} {
+		name := dynconfig.RateLimit(lambdacontext.FunctionName, kind)
+		v := l.Params.String(ctx, name, "")
+		if v == "" {
+			continue
+		}
+		limit, err := ParseLimit(v)
+		if err != nil {
+			slog.WarnContext(ctx, "Ignoring invalid rate limit override", "parameter", name, logging.Err(err))
+			continue
+		}
+		*dst = limit
+	}
+	return p
+}
+
+// Middleware returns Wrap(p, ...) as a middleware.
+func (l *Limiter) Middleware(p Policy) httpx.Middleware {
+	return func(next httpx.Handler) httpx.Handler {
+		return l.Wrap(p, next)
+	}
+}
+
+// Wrap returns a handler enforcing p, as overridden at the time of each
+// request, before calling next. Direct invocations
+// are not limited. When DynamoDB cannot be reached the request is let
+// through: an outage of the limiter should not take the API down with it.
+func (l *Limiter) Wrap(p Policy, next httpx.Handler) httpx.Handler {
+	return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+		if err := l.take(ctx, r, p, 1, 1); err != nil {
+			return httpx.Error(err), nil
+		}
+		return next(ctx, r)
+	}
+}
+
+// Charge makes a request Wrap let through cost n tokens in all, for
+// requests worth several, such as batches of lookups: it takes the n-1
+// tokens Wrap did not from each of the caller's buckets. A bucket short of
+// them is an apperr.RateLimited error, and n above a limit's burst, which no
+// wait would allow, a KindInvalid one.
+func (l *Limiter) Charge(ctx context.Context, r *httpx.Request, p Policy, n int) error {
+	return l.take(ctx, r, p, n-1, n)
+}
+
+// take takes n tokens from each of the caller's buckets under p, for a
+// request costing cost tokens in all.
+func (l *Limiter) take(ctx context.Context, r *httpx.Request, p Policy, n, cost int) error {
+	if r.Direct || n < 1 {
+		return nil
+	}
+	p = l.override(ctx, p)
+
+	checks := []struct {
+		kind, id string
+		limit    Limit
+	}{
+		{"ip", r.SourceIP, p.PerIP},
+		{"user", subject(ctx, r), p.PerUser},
+	}
+	for _, c := range checks {
+		if c.id == "" || !c.limit.enabled() {
+			continue
+		}
+		if cost > c.limit.Burst {
+			return apperr.Invalid("REQUEST_TOO_LARGE", "", fmt.Sprintf("at most %d items may be sent at once", c.limit.Burst))
+		}
+		bucket := lambdacontext.FunctionName + "#" + c.kind + "#" + c.id
+		wait, err := l.TakeN(ctx, bucket, c.limit, n)
+		if err != nil {
+			slog.WarnContext(ctx, "Rate limiter unavailable", logging.Err(err))
+			return nil
+		}
+		if wait > 0 {
+			slog.WarnContext(ctx, "Rate limit exceeded", "limit", c.kind, "retry_after_ms", wait.Milliseconds())
+			metrics.Count(ctx, metrics.RateLimited)
+			return apperr.RateLimited(wait)
+		}
+	}
+	return nil
+}
+
+// subject returns the authenticated caller, if any.
+func subject(ctx context.Context, r *httpx.Request) string {
+	if id, ok := auth.FromContext(ctx); ok {
+		return id.Subject
+	}
+	return r.Subject()
+}
+
+// Take removes one token from the bucket, creating it full if it does not
+// exist. It returns zero when the token was taken, or how long the caller
+// has to wait for the next one. Wrap uses it for API callers; other
+// consumers name their own buckets.
+func (l *Limiter) Take(ctx context.Context, bucket string, limit Limit) (time.Duration, error) {
+	return l.TakeN(ctx, bucket, limit, 1)
+}
+
+// TakeN removes n tokens from the bucket at once, or none, returning how
+// long the caller has to wait for the bucket to hold n. n must not exceed
+// the limit's burst.
+func (l *Limiter) TakeN(ctx context.Context, bucket string, limit Limit, n int) (time.Duration, error) {
+	cost := float64(n)
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		tokens, last, exists, err := l.read(ctx, bucket)
+		if err != nil {
+			return 0, err
+		}
+
+		now := time.Now()
+		if !exists {
+			tokens = float64(limit.Burst)
+		} else {
+			tokens = math.Min(float64(limit.Burst), tokens+now.Sub(last).Seconds()*limit.Rate)
+		}
+		if tokens < cost {
+			return time.Duration((cost - tokens) / limit.Rate * float64(time.Second)), nil
+		}
+
+		ok, err := l.write(ctx, bucket, tokens-cost, now, last, exists, limit)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return 0, nil
+		}
+		// Another request updated the bucket first; recount
+	}
+	// Heavy contention on one bucket means the caller is hammering us
+	return time.Second, nil
+}
+
+// read returns the bucket's token count and when it was last updated.
+func (l *Limiter) read(ctx context.Context, bucket string) (tokens float64, last time.Time, exists bool, err error) {
+	start := time.Now()
+	result, err := l.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(l.Table),
+		Key:            db.Item{"bucket": &types.AttributeValueMemberS{Value: bucket}},
+		ConsistentRead: aws.Bool(true),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return 0, time.Time{}, false, db.Wrap(err, "reading rate limit bucket")
+	}
+	if result.Item == nil {
+		return 0, time.Time{}, false, nil
+	}
+
+	t, _ := result.Item["tokens"].(*types.AttributeValueMemberN)
+	u, _ := result.Item["updated_at"].(*types.AttributeValueMemberN)
+	if t == nil || u == nil {
+		return 0, time.Time{}, false, fmt.Errorf("malformed rate limit bucket %q", bucket)
+	}
+	tokens, err = strconv.ParseFloat(t.Value, 64)
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+	ms, err := strconv.ParseInt(u.Value, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false, err
+	}
+	return tokens, time.UnixMilli(ms), true, nil
+}
+
+// write stores the new token count, provided nobody changed the bucket since
+// it was read. It returns false when that condition failed.
+func (l *Limiter) write(ctx context.Context, bucket string, tokens float64, now, last time.Time, exists bool, limit Limit) (bool, error) {
+	// A bucket left alone long enough to refill completely can be forgotten
+	refill := time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second))
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(l.Table),
+		Item: db.Item{
+			"bucket":     &types.AttributeValueMemberS{Value: bucket},
+			"tokens":     &types.AttributeValueMemberN{Value: strconv.FormatFloat(tokens, 'f', 3, 64)},
+			"updated_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+			"expires_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(refill).Unix()+1, 10)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(bucket)"),
+	}
+	if exists {
+		input.ConditionExpression = aws.String("updated_at = :last")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":last": &types.AttributeValueMemberN{Value: strconv.FormatInt(last.UnixMilli(), 10)},
+		}
+	}
+
+	start := time.Now()
+	_, err := l.DB.DynamoDB.PutItem(ctx, input)
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return false, nil
+	}
+	if err != nil {
+		return false, db.Wrap(err, "writing rate limit bucket")
+	}
+	return true, nil
+}
@@ -0,0 +1,486 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/dynconfig"
+	"troggle-backend/internal/httpx"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{in: "60/m", want: Limit{Rate: 1, Burst: 60}},
+		{in: "5/s", want: Limit{Rate: 5, Burst: 5}},
+		{in: "3600/h", want: Limit{Rate: 1, Burst: 3600}},
+		{in: "off", want: Limit{}},
+		{in: "0/m", wantErr: true},
+		{in: "-1/m", wantErr: true},
+		{in: "ten/m", wantErr: true},
+		{in: "60", wantErr: true},
+		{in: "60/d", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLimit(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLimit(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	builtin := Policy{PerIP: PerMinute(30), PerUser: PerMinute(10)}
+
+	tests := []struct {
+		name           string
+		perIP, perUser string
+		want           Policy
+		wantErr        bool
+	}{
+		{name: "no overrides", want: builtin},
+		{name: "per IP", perIP: "120/h", want: Policy{PerIP: Limit{Rate: 120.0 / 3600, Burst: 120}, PerUser: PerMinute(10)}},
+		{name: "per user off", perUser: "off", want: Policy{PerIP: PerMinute(30)}},
+		{name: "invalid", perIP: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvPerIP, tt.perIP)
+			t.Setenv(EnvPerUser, tt.perUser)
+			got, err := builtin.FromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromEnv() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("FromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// bucketItem is a stored bucket holding tokens, last updated age ago.
+func bucketItem(tokens float64, age time.Duration) db.Item {
+	return db.Item{
+		"bucket":     &types.AttributeValueMemberS{Value: "b"},
+		"tokens":     &types.AttributeValueMemberN{Value: strconv.FormatFloat(tokens, 'f', 3, 64)},
+		"updated_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(-age).UnixMilli(), 10)},
+	}
+}
+
+// written returns the token count of the last bucket written to m.
+func written(t *testing.T, m *dbtest.Mock) float64 {
+	t.Helper()
+	var put *dynamodb.PutItemInput
+	for _, c := range m.Calls {
+		if c.Op == "PutItem" {
+			put = c.Input.(*dynamodb.PutItemInput)
+		}
+	}
+	if put == nil {
+		t.Fatal("bucket not written")
+	}
+	tokens, err := strconv.ParseFloat(put.Item["tokens"].(*types.AttributeValueMemberN).Value, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestTakeN(t *testing.T) {
+	limit := Limit{Rate: 0.5, Burst: 10} // a token every two seconds
+
+	tests := []struct {
+		name       string
+		stored     db.Item // nil for a new bucket
+		n          int
+		wantWait   time.Duration // approximate; zero when the tokens are taken
+		wantTokens float64       // left in the bucket when taken
+	}{
+		{name: "new bucket", n: 1, wantTokens: 9},
+		{name: "new bucket, several tokens", n: 4, wantTokens: 6},
+		{name: "tokens left", stored: bucketItem(3, 0), n: 1, wantTokens: 2},
+		{name: "refilled", stored: bucketItem(0, 4*time.Second), n: 1, wantTokens: 1},
+		{name: "refill capped at burst", stored: bucketItem(2, time.Hour), n: 1, wantTokens: 9},
+		{name: "empty", stored: bucketItem(0, 0), n: 1, wantWait: 2 * time.Second},
+		{name: "partly refilled", stored: bucketItem(0.5, 0), n: 1, wantWait: time.Second},
+		{name: "too few for n", stored: bucketItem(3, 0), n: 5, wantWait: 4 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: tt.stored}, nil
+				},
+			}
+			l := &Limiter{DB: m.Client(), Table: "rate-limits"}
+
+			wait, err := l.TakeN(context.Background(), "b", limit, tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantWait == 0 {
+				if wait != 0 {
+					t.Fatalf("wait = %v, want tokens taken", wait)
+				}
+				if got := written(t, m); got < tt.wantTokens || got > tt.wantTokens+0.1 {
+					t.Errorf("tokens left = %v, want %v", got, tt.wantTokens)
+				}
+				return
+			}
+			// The bucket refills a little between being stored and read
+			if wait > tt.wantWait || wait < tt.wantWait-100*time.Millisecond {
+				t.Errorf("wait = %v, want %v", wait, tt.wantWait)
+			}
+			if ops := m.Ops(); len(ops) != 1 {
+				t.Errorf("calls = %v, want the bucket only read", ops)
+			}
+		})
+	}
+}
+
+func TestTakeNConditions(t *testing.T) {
+	stored := bucketItem(5, 0)
+	m := &dbtest.Mock{
+		GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{Item: stored}, nil
+		},
+	}
+	l := &Limiter{DB: m.Client(), Table: "rate-limits"}
+	if _, err := l.Take(context.Background(), "b", PerMinute(10)); err != nil {
+		t.Fatal(err)
+	}
+
+	put := m.Calls[1].Input.(*dynamodb.PutItemInput)
+	if got := aws.ToString(put.ConditionExpression); got != "updated_at = :last" {
+		t.Errorf("condition = %q, want updated_at = :last", got)
+	}
+	last := put.ExpressionAttributeValues[":last"].(*types.AttributeValueMemberN).Value
+	if want := stored["updated_at"].(*types.AttributeValueMemberN).Value; last != want {
+		t.Errorf(":last = %s, want the updated_at read, %s", last, want)
+	}
+
+	stored = nil
+	m.Calls = nil
+	if _, err := l.Take(context.Background(), "b", PerMinute(10)); err != nil {
+		t.Fatal(err)
+	}
+	put = m.Calls[1].Input.(*dynamodb.PutItemInput)
+	if got := aws.ToString(put.ConditionExpression); got != "attribute_not_exists(bucket)" {
+		t.Errorf("condition for a new bucket = %q, want attribute_not_exists(bucket)", got)
+	}
+}
+
+func TestTakeNContention(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts int // conditional writes failing before one succeeds
+		wantWait  time.Duration
+		wantPuts  int
+	}{
+		{name: "no conflict", conflicts: 0, wantPuts: 1},
+		{name: "retried", conflicts: 2, wantPuts: 3},
+		{name: "gives up", conflicts: maxAttempts, wantWait: time.Second, wantPuts: maxAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			puts := 0
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: bucketItem(5, 0)}, nil
+				},
+				PutItemFunc: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					puts++
+					if puts <= tt.conflicts {
+						return nil, dbtest.ConditionFailed()
+					}
+					return &dynamodb.PutItemOutput{}, nil
+				},
+			}
+			l := &Limiter{DB: m.Client(), Table: "rate-limits"}
+
+			wait, err := l.Take(context.Background(), "b", PerMinute(10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != tt.wantWait {
+				t.Errorf("wait = %v, want %v", wait, tt.wantWait)
+			}
+			if puts != tt.wantPuts {
+				t.Errorf("writes = %d, want %d", puts, tt.wantPuts)
+			}
+		})
+	}
+}
+
+func TestTakeNErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		mock *dbtest.Mock
+	}{
+		{
+			name: "read fails",
+			mock: &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return nil, dbtest.Throttled()
+				},
+			},
+		},
+		{
+			name: "write fails",
+			mock: &dbtest.Mock{
+				PutItemFunc: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					return nil, dbtest.Throttled()
+				},
+			},
+		},
+		{
+			name: "malformed bucket",
+			mock: &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: dbtest.Item("bucket", "b", "tokens", "5")}, nil
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Limiter{DB: tt.mock.Client(), Table: "rate-limits"}
+			if _, err := l.Take(context.Background(), "b", PerMinute(10)); err == nil {
+				t.Error("Take succeeded")
+			}
+		})
+	}
+}
+
+// errorCode returns the error code of an error response.
+func errorCode(t *testing.T, resp httpx.Response) string {
+	t.Helper()
+	var body httpx.ErrorBody
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Error.Code
+}
+
+func TestWrap(t *testing.T) {
+	policy := Policy{PerIP: PerMinute(60), PerUser: PerMinute(6)}
+	empty := func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		return &dynamodb.GetItemOutput{Item: bucketItem(0, 0)}, nil
+	}
+
+	tests := []struct {
+		name           string
+		req            *httpx.Request
+		caller         string // authenticated by an outer middleware
+		getItem        func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		wantStatus     int
+		wantRetryAfter string
+		wantBuckets    []string
+	}{
+		{
+			name:        "anonymous",
+			req:         &httpx.Request{SourceIP: "192.0.2.1"},
+			wantStatus:  http.StatusOK,
+			wantBuckets: []string{"#ip#192.0.2.1"},
+		},
+		{
+			name:        "authenticated",
+			req:         &httpx.Request{SourceIP: "192.0.2.1", Claims: map[string]string{"sub": "u1"}},
+			wantStatus:  http.StatusOK,
+			wantBuckets: []string{"#ip#192.0.2.1", "#user#u1"},
+		},
+		{
+			name:        "API key caller",
+			req:         &httpx.Request{SourceIP: "192.0.2.1"},
+			caller:      "u2",
+			wantStatus:  http.StatusOK,
+			wantBuckets: []string{"#ip#192.0.2.1", "#user#u2"},
+		},
+		{
+			name:           "IP bucket empty",
+			req:            &httpx.Request{SourceIP: "192.0.2.1"},
+			getItem:        empty,
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "1",
+			wantBuckets:    []string{"#ip#192.0.2.1"},
+		},
+		{
+			name: "user bucket empty",
+			req:  &httpx.Request{SourceIP: "192.0.2.1", Claims: map[string]string{"sub": "u1"}},
+			getItem: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+				if in.Key["bucket"].(*types.AttributeValueMemberS).Value == "#user#u1" {
+					return empty(in)
+				}
+				return &dynamodb.GetItemOutput{}, nil
+			},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "10",
+			wantBuckets:    []string{"#ip#192.0.2.1", "#user#u1"},
+		},
+		{
+			name: "DynamoDB unavailable",
+			req:  &httpx.Request{SourceIP: "192.0.2.1", Claims: map[string]string{"sub": "u1"}},
+			getItem: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+				return nil, dbtest.Throttled()
+			},
+			wantStatus:  http.StatusOK,
+			wantBuckets: []string{"#ip#192.0.2.1"},
+		},
+		{
+			name:       "direct invocation",
+			req:        &httpx.Request{Direct: true},
+			getItem:    empty,
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: tt.getItem}
+			l := &Limiter{DB: m.Client(), Table: "rate-limits"}
+			ctx := context.Background()
+			if tt.caller != "" {
+				ctx = auth.NewContext(ctx, &auth.Identity{Subject: tt.caller, TokenUse: auth.TokenUseAPIKey})
+			}
+			called := false
+			h := l.Wrap(policy, func(context.Context, *httpx.Request) (httpx.Response, error) {
+				called = true
+				return httpx.JSON(http.StatusOK, nil), nil
+			})
+
+			resp, err := h(ctx, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler called = %v", called)
+			}
+			if got := resp.Headers["Retry-After"]; got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			var buckets []string
+			for _, c := range m.Calls {
+				if in, ok := c.Input.(*dynamodb.GetItemInput); ok {
+					buckets = append(buckets, in.Key["bucket"].(*types.AttributeValueMemberS).Value)
+				}
+			}
+			if len(buckets) != len(tt.wantBuckets) {
+				t.Fatalf("buckets = %v, want %v", buckets, tt.wantBuckets)
+			}
+			for i := range buckets {
+				if buckets[i] != tt.wantBuckets[i] {
+					t.Errorf("buckets = %v, want %v", buckets, tt.wantBuckets)
+				}
+			}
+		})
+	}
+}
+
+func TestCharge(t *testing.T) {
+	policy := Policy{PerIP: PerMinute(60), PerUser: PerMinute(10)}
+	req := &httpx.Request{SourceIP: "192.0.2.1", Claims: map[string]string{"sub": "u1"}}
+
+	tests := []struct {
+		name       string
+		n          int
+		stored     float64 // tokens in each bucket
+		wantCode   string  // "" for success
+		wantTokens float64 // left in the user bucket
+		wantReads  int
+	}{
+		{name: "single item", n: 1, stored: 10, wantReads: 0},
+		{name: "batch", n: 4, stored: 10, wantTokens: 7, wantReads: 2},
+		{name: "whole burst", n: 10, stored: 10, wantTokens: 1, wantReads: 2},
+		{name: "bucket short", n: 4, stored: 2, wantCode: "RATE_LIMITED", wantReads: 1},
+		{name: "above burst", n: 11, stored: 10, wantCode: "REQUEST_TOO_LARGE", wantReads: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{
+				GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					return &dynamodb.GetItemOutput{Item: bucketItem(tt.stored, 0)}, nil
+				},
+			}
+			l := &Limiter{DB: m.Client(), Table: "rate-limits"}
+
+			err := l.Charge(context.Background(), req, policy, tt.n)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("Charge() = %v", err)
+				}
+			} else if code := errorCode(t, httpx.Error(err)); code != tt.wantCode {
+				t.Fatalf("Charge() = %v, want %s", err, tt.wantCode)
+			}
+			reads := 0
+			for _, op := range m.Ops() {
+				if op == "GetItem" {
+					reads++
+				}
+			}
+			if reads != tt.wantReads {
+				t.Errorf("buckets read = %d, want %d", reads, tt.wantReads)
+			}
+			if tt.wantCode == "" && tt.wantReads > 0 {
+				if got := written(t, m); got < tt.wantTokens || got > tt.wantTokens+0.1 {
+					t.Errorf("tokens left = %v, want %v", got, tt.wantTokens)
+				}
+			}
+		})
+	}
+}
+
+// fakeSSM serves parameters from values, keyed by full name.
+type fakeSSM struct{ values map[string]string }
+
+func (f fakeSSM) GetParametersByPath(_ context.Context, _ *ssm.GetParametersByPathInput, _ ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	var out ssm.GetParametersByPathOutput
+	for name, v := range f.values {
+		out.Parameters = append(out.Parameters, ssmtypes.Parameter{Name: aws.String(name), Value: aws.String(v)})
+	}
+	return &out, nil
+}
+
+func TestOverride(t *testing.T) {
+	defer func(name string) { lambdacontext.FunctionName = name }(lambdacontext.FunctionName)
+	lambdacontext.FunctionName = "searchUsers"
+
+	l := &Limiter{Params: &dynconfig.Store{
+		API: fakeSSM{values: map[string]string{
+			"/troggle/test/" + dynconfig.RateLimit("searchUsers", "per_ip"):   "off",
+			"/troggle/test/" + dynconfig.RateLimit("searchUsers", "per_user"): "bogus",
+			"/troggle/test/" + dynconfig.RateLimit("otherFunc", "per_user"):   "1/h",
+		}},
+		Path: "/troggle/test/",
+		TTL:  time.Minute,
+	}}
+
+	got := l.override(context.Background(), Policy{PerIP: PerMinute(30), PerUser: PerMinute(10)})
+	if want := (Policy{PerUser: PerMinute(10)}); got != want {
+		t.Errorf("override() = %+v, want %+v", got, want)
+	}
+}
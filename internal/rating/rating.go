@@ -0,0 +1,257 @@
+// Package rating computes the skill ratings of players. Ratings are Elo
+// ratings extended to matches of more than two players: a match counts as
+// a game between every pair of its players, won by the better placed, and
+// a player's change is their K-factor times their total surprise over those
+// games, divided by the number of their opponents. Players new to rated
+// matches move faster, with the provisional K-factor, until their ratings
+// settle.
+//
+// Ratings live in the player records of the player table, which package
+// matches keeps. Every change, from a match or from decay, also appends an
+// entry to the rating history of the player, in the history table keyed by
+// user_id and entry.
+//
+// Players who stop playing decay: once they have not played for
+// RATING_DECAY_AFTER, the daily decay job takes DecayPoints off their
+// rating every day until it reaches Default. Ratings at or below Default
+// never decay.
+package rating
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+const (
+	// Default is the rating of players without rated matches, and the
+	// floor of decay.
+	Default = 1000
+
+	// ProvisionalMatches is how many matches a player's rating moves with
+	// the provisional K-factor.
+	ProvisionalMatches = 10
+
+	// DecayPoints is the rating an inactive player loses per day.
+	DecayPoints = 5
+
+	// scale is the rating difference at which the better player is
+	// expected to win ten games for every one they lose.
+	scale = 400
+)
+
+// Reasons of rating history entries.
+const (
+	ReasonMatch = "match"
+	ReasonDecay = "decay"
+)
+
+// Params tunes rating changes.
+type Params struct {
+	K            float64       // K-factor of settled players
+	ProvisionalK float64       // K-factor of a player's first ProvisionalMatches matches
+	DecayAfter   time.Duration // inactivity after which ratings decay
+}
+
+// Player is the rating of a player before a match.
+type Player struct {
+	UserID        string
+	Rating        int
+	MatchesPlayed int // before the match
+	Place         int // 1 for the winners; ties share a place
+}
+
+// Expected returns the expected score, between 0 and 1, of a player rated
+// a in a game against one rated b.
+func Expected(a, b int) float64 {
+	return 1 / (1 + math.Pow(10, float64(b-a)/scale))
+}
+
+// Changes returns the rating change of each of players, the placed players
+// of one match.
+func (p Params) Changes(players []Player) []int {
+	changes := make([]int, len(players))
+	if len(players) < 2 {
+		return changes
+	}
+	for i, a := range players {
+		var surprise float64
+		for j, b := range players {
+			if i == j {
+				continue
+			}
+			surprise += score(a.Place, b.Place) - Expected(a.Rating, b.Rating)
+		}
+		k := p.K
+		if a.MatchesPlayed < ProvisionalMatches {
+			k = p.ProvisionalK
+		}
+		changes[i] = int(math.Round(k * surprise / float64(len(players)-1)))
+	}
+	return changes
+}
+
+// Decayed returns rating after a day of decay for a player last active at
+// lastPlayed, which is rating itself while they are active or at Default.
+func (p Params) Decayed(rating int, lastPlayed, now time.Time) int {
+	if rating <= Default || now.Sub(lastPlayed) < p.DecayAfter {
+		return rating
+	}
+	return max(rating-DecayPoints, Default)
+}
+
+// score returns the score of a player placed a against one placed b.
+func score(a, b int) float64 {
+	switch {
+	case a < b:
+		return 1
+	case a == b:
+		return 0.5
+	}
+	return 0
+}
+
+// Entry is one change in the rating history of a player.
+type Entry struct {
+	UserID  string    `json:"user_id" dynamodbav:"user_id"`
+	Entry   string    `json:"-" dynamodbav:"entry"` // at#match_id, or at#decay
+	Reason  string    `json:"reason" dynamodbav:"reason"`
+	MatchID string    `json:"match_id,omitempty" dynamodbav:"match_id,omitempty"`
+	Before  int       `json:"before" dynamodbav:"before"`
+	After   int       `json:"after" dynamodbav:"after"`
+	At      time.Time `json:"at" dynamodbav:"at"`
+}
+
+// Store reads and writes the ratings of the player table and the history
+// table.
+type Store struct {
+	DB           *db.Client
+	PlayerTable  string
+	HistoryTable string
+	Params       Params
+}
+
+// NewStore returns a store over the tables named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{
+		DB:           client,
+		PlayerTable:  cfg.PlayerTableName,
+		HistoryTable: cfg.RatingHistoryTableName,
+		Params:       NewParams(cfg),
+	}
+}
+
+// NewParams returns the rating parameters of cfg.
+func NewParams(cfg *config.Config) Params {
+	return Params{K: cfg.RatingKFactor, ProvisionalK: cfg.RatingProvisionalKFactor, DecayAfter: cfg.RatingDecayAfter}
+}
+
+// Record returns the put of e into the history table, for a transaction
+// that also changes the rating.
+func (s *Store) Record(e Entry) (types.TransactWriteItem, error) {
+	e.At = e.At.UTC().Truncate(time.Second)
+	ref := e.MatchID
+	if ref == "" {
+		ref = e.Reason
+	}
+	e.Entry = e.At.Format(time.RFC3339) + "#" + ref
+	item, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("encoding rating history: %w", err)
+	}
+	return types.TransactWriteItem{Put: &types.Put{TableName: aws.String(s.HistoryTable), Item: item}}, nil
+}
+
+// record is the part of a player record decay reads.
+type record struct {
+	UserID       string    `dynamodbav:"user_id"`
+	Rating       int       `dynamodbav:"rating"`
+	LastPlayedAt time.Time `dynamodbav:"last_played_at"`
+}
+
+// Decay applies a day of decay to every inactive player, and returns how
+// many players decayed. Each decay is a conditional write on the rating
+// and activity the scan read, along with its history entry, so a player
+// who played meanwhile does not decay; nor does one decayed already today,
+// which makes a repeated run harmless. The player table is scanned, which
+// a daily background job can afford.
+func (s *Store) Decay(ctx context.Context, now time.Time) (int, error) {
+	now = now.UTC().Truncate(time.Second)
+	today := now.Format(time.DateOnly)
+	paginator := dynamodb.NewScanPaginator(s.DB.DynamoDB, &dynamodb.ScanInput{
+		TableName:        aws.String(s.PlayerTable),
+		FilterExpression: aws.String("rating > :default AND last_played_at < :cutoff AND (attribute_not_exists(decayed_on) OR decayed_on < :today)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":default": &types.AttributeValueMemberN{Value: strconv.Itoa(Default)},
+			":cutoff":  &types.AttributeValueMemberS{Value: now.Add(-s.Params.DecayAfter).Format(time.RFC3339)},
+			":today":   &types.AttributeValueMemberS{Value: today},
+		},
+	})
+
+	decayed := 0
+	for paginator.HasMorePages() {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		db.Observe(ctx, start, err)
+		if err != nil {
+			return decayed, db.Wrap(err, "scanning players")
+		}
+		var players []record
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &players); err != nil {
+			return decayed, fmt.Errorf("decoding players: %w", err)
+		}
+		for _, p := range players {
+			ok, err := s.decay(ctx, p, today, now)
+			if err != nil {
+				return decayed, err
+			}
+			if ok {
+				decayed++
+			}
+		}
+	}
+	return decayed, nil
+}
+
+// decay applies a day of decay to p, unless p changed since it was read,
+// and reports whether it did.
+func (s *Store) decay(ctx context.Context, p record, today string, now time.Time) (bool, error) {
+	after := s.Params.Decayed(p.Rating, p.LastPlayedAt, now)
+	if after == p.Rating {
+		return false, nil
+	}
+	history, err := s.Record(Entry{UserID: p.UserID, Reason: ReasonDecay, Before: p.Rating, After: after, At: now})
+	if err != nil {
+		return false, err
+	}
+	lastPlayed, err := attributevalue.Marshal(p.LastPlayedAt)
+	if err != nil {
+		return false, fmt.Errorf("encoding player: %w", err)
+	}
+	err = s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{{Update: &types.Update{
+		TableName:           aws.String(s.PlayerTable),
+		Key:                 db.Item{"user_id": &types.AttributeValueMemberS{Value: p.UserID}},
+		UpdateExpression:    aws.String("SET rating = :after, decayed_on = :today"),
+		ConditionExpression: aws.String("rating = :before AND last_played_at = :last_played_at AND (attribute_not_exists(decayed_on) OR decayed_on < :today)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":after":          &types.AttributeValueMemberN{Value: strconv.Itoa(after)},
+			":before":         &types.AttributeValueMemberN{Value: strconv.Itoa(p.Rating)},
+			":last_played_at": lastPlayed,
+			":today":          &types.AttributeValueMemberS{Value: today},
+		},
+	}}, history})
+	if db.ConditionFailed(err, 0) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,132 @@
+package rating
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+var now = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+func TestChanges(t *testing.T) {
+	params := Params{K: 32, ProvisionalK: 64}
+	tests := []struct {
+		name    string
+		players []Player
+		want    []int
+	}{
+		{
+			name:    "even pair",
+			players: []Player{{Rating: 1000, MatchesPlayed: 20, Place: 1}, {Rating: 1000, MatchesPlayed: 20, Place: 2}},
+			want:    []int{16, -16},
+		},
+		{
+			name:    "favourite wins",
+			players: []Player{{Rating: 1400, MatchesPlayed: 20, Place: 1}, {Rating: 1000, MatchesPlayed: 20, Place: 2}},
+			want:    []int{3, -3},
+		},
+		{
+			name:    "upset",
+			players: []Player{{Rating: 1000, MatchesPlayed: 20, Place: 1}, {Rating: 1400, MatchesPlayed: 20, Place: 2}},
+			want:    []int{29, -29},
+		},
+		{
+			name:    "draw of unequal players",
+			players: []Player{{Rating: 1200, MatchesPlayed: 20, Place: 1}, {Rating: 1000, MatchesPlayed: 20, Place: 1}},
+			want:    []int{-8, 8},
+		},
+		{
+			name:    "provisional player",
+			players: []Player{{Rating: 1000, MatchesPlayed: 0, Place: 1}, {Rating: 1000, MatchesPlayed: 20, Place: 2}},
+			want:    []int{32, -16},
+		},
+		{
+			name: "four players",
+			players: []Player{
+				{Rating: 1000, MatchesPlayed: 20, Place: 1}, {Rating: 1000, MatchesPlayed: 20, Place: 2},
+				{Rating: 1000, MatchesPlayed: 20, Place: 3}, {Rating: 1000, MatchesPlayed: 20, Place: 4},
+			},
+			want: []int{16, 5, -5, -16},
+		},
+		{name: "alone", players: []Player{{Rating: 1000, Place: 1}}, want: []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := params.Changes(tt.players); !slices.Equal(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecayed(t *testing.T) {
+	params := Params{DecayAfter: 30 * 24 * time.Hour}
+	tests := []struct {
+		name   string
+		rating int
+		idle   time.Duration
+		want   int
+	}{
+		{name: "active", rating: 1500, idle: 24 * time.Hour, want: 1500},
+		{name: "inactive", rating: 1500, idle: 40 * 24 * time.Hour, want: 1500 - DecayPoints},
+		{name: "near the floor", rating: Default + 2, idle: 40 * 24 * time.Hour, want: Default},
+		{name: "below the floor", rating: 900, idle: 40 * 24 * time.Hour, want: 900},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := params.Decayed(tt.rating, now.Add(-tt.idle), now); got != tt.want {
+				t.Errorf("decayed = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecay(t *testing.T) {
+	player := func(userID, rating, lastPlayed string) db.Item {
+		item := dbtest.Item("user_id", userID, "last_played_at", lastPlayed)
+		item["rating"] = &types.AttributeValueMemberN{Value: rating}
+		return item
+	}
+	m := &dbtest.Mock{
+		ScanFunc: func(*dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+			return &dynamodb.ScanOutput{Items: []db.Item{
+				player("u1", "1500", "2026-08-01T00:00:00Z"),
+				player("u2", "1300", "2026-08-01T00:00:00Z"),
+				player("u3", "1200", "2026-10-13T00:00:00Z"), // played since the scan's cutoff
+			}}, nil
+		},
+		TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+			update := in.TransactItems[0].Update
+			if update.Key["user_id"].(*types.AttributeValueMemberS).Value == "u2" {
+				// u2 finished a match meanwhile
+				return nil, dbtest.TransactionCanceled("ConditionalCheckFailed", "None")
+			}
+			if got := update.ExpressionAttributeValues[":after"].(*types.AttributeValueMemberN).Value; got != "1495" {
+				t.Errorf("decayed to %s, want 1495", got)
+			}
+			if in.TransactItems[1].Put.Item["reason"].(*types.AttributeValueMemberS).Value != ReasonDecay {
+				t.Error("history entry not written")
+			}
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		},
+	}
+	s := &Store{DB: m.Client(), PlayerTable: "players", HistoryTable: "history", Params: Params{DecayAfter: 30 * 24 * time.Hour}}
+
+	decayed, err := s.Decay(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decayed != 1 {
+		t.Errorf("decayed %d players, want 1", decayed)
+	}
+	if ops := m.Ops(); !slices.Equal(ops, []string{"Scan", "TransactWriteItems", "TransactWriteItems"}) {
+		t.Errorf("ops = %v", ops)
+	}
+}
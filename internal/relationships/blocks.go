@@ -0,0 +1,199 @@
+package relationships
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+)
+
+// A block is symmetric: whoever blocked whom, neither user may interact
+// with the other. So a block is one BLOCK edge on each end, whose
+// blocked_by string set holds the users who asked for it, and IsBlocked is
+// a single read of the caller's own partition in either direction. The
+// edge goes away when the last of them unblocks.
+
+// Block makes me block other: the users' friendship, follows and pending
+// friend requests are removed, and IsBlocked reports them blocked until me
+// unblocks other. Blocking twice is not an error.
+func (s *Store) Block(ctx context.Context, me, other string) error {
+	if err := checkPair(me, other); err != nil {
+		return err
+	}
+	now := time.Now()
+	err := s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		s.addBlocker(me, other, now, me),
+		s.addBlocker(other, me, now, me),
+		{ConditionCheck: &types.ConditionCheck{
+			TableName:           aws.String(s.UserTable),
+			Key:                 userKey(other),
+			ConditionExpression: aws.String("attribute_exists(user_id)"),
+		}},
+	})
+	if db.ConditionFailed(err, 2) {
+		return apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return db.Wrap(err, "blocking user")
+	}
+
+	return s.separate(ctx, me, other)
+}
+
+// separate removes the friendship, follows and pending friend requests of
+// a and b, as a block between them requires. Each removal is idempotent, so
+// a retry after a failure finishes the job.
+func (s *Store) separate(ctx context.Context, a, b string) error {
+	if err := s.Unfriend(ctx, a, b); err != nil {
+		return err
+	}
+	if err := s.Unfollow(ctx, a, b); err != nil {
+		return err
+	}
+	if err := s.Unfollow(ctx, b, a); err != nil {
+		return err
+	}
+	return s.RemoveRequests(ctx, a, b)
+}
+
+// Unblock lifts the block of other by me. The users stay blocked if other
+// blocked me too. Unblocking a user not blocked is not an error; nothing
+// removed by Block is restored.
+func (s *Store) Unblock(ctx context.Context, me, other string) error {
+	if err := checkPair(me, other); err != nil {
+		return err
+	}
+	err := s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		s.removeBlocker(me, other, me),
+		s.removeBlocker(other, me, me),
+	})
+	if db.ConditionFailed(err, -1) {
+		return nil
+	}
+	if err != nil {
+		return db.Wrap(err, "unblocking user")
+	}
+
+	// Deleting the last element of a set removes the attribute, not the
+	// item; edges nobody blocks any more go now
+	for _, k := range []db.Item{Key(me, Block, other), Key(other, Block, me)} {
+		start := time.Now()
+		_, err := s.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName:           aws.String(s.Table),
+			Key:                 k,
+			ConditionExpression: aws.String("attribute_not_exists(blocked_by)"),
+		})
+		db.Observe(ctx, start, err)
+		if err != nil && !db.ConditionFailed(err, -1) {
+			return db.Wrap(err, "deleting block")
+		}
+	}
+	return nil
+}
+
+// IsBlocked reports whether a or b blocked the other, in which case
+// neither may interact with the other: handlers letting one user reach
+// another (friend requests, follows, messages) consult it first.
+func (s *Store) IsBlocked(ctx context.Context, a, b string) (bool, error) {
+	item, err := s.getItem(ctx, a, Block, b)
+	if err != nil || item == nil {
+		return false, err
+	}
+	blockers, ok := item["blocked_by"].(*types.AttributeValueMemberSS)
+	return ok && len(blockers.Value) > 0, nil
+}
+
+// Blocked returns one page of the users me blocked, as List does. Blocks of
+// me by others are filtered out, so a page may hold fewer than limit users
+// and still not be the last.
+func (s *Store) Blocked(ctx context.Context, me string, limit int32, start db.Item) ([]Edge, db.Item, error) {
+	return s.list(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("user_id = :id AND begins_with(edge, :type)"),
+		FilterExpression:       aws.String("contains(blocked_by, :id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id":   &types.AttributeValueMemberS{Value: me},
+			":type": &types.AttributeValueMemberS{Value: Block + "#"},
+		},
+		ExclusiveStartKey: start,
+		Limit:             aws.Int32(limit),
+	})
+}
+
+// Mute makes me mute other. A mute only concerns me: other is not told,
+// and can still interact with me; handlers building what me sees consult
+// IsMuted. Muting twice is not an error.
+func (s *Store) Mute(ctx context.Context, me, other string) error {
+	if err := checkPair(me, other); err != nil {
+		return err
+	}
+	err := s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		s.put(me, Mute, other, time.Now()),
+		{ConditionCheck: &types.ConditionCheck{
+			TableName:           aws.String(s.UserTable),
+			Key:                 userKey(other),
+			ConditionExpression: aws.String("attribute_exists(user_id)"),
+		}},
+	})
+	switch {
+	case db.ConditionFailed(err, 0):
+		return nil
+	case db.ConditionFailed(err, 1):
+		return apperr.NotFound("User not found")
+	}
+	return db.Wrap(err, "muting user")
+}
+
+// Unmute lifts the mute of other by me, if there is one.
+func (s *Store) Unmute(ctx context.Context, me, other string) error {
+	if err := checkPair(me, other); err != nil {
+		return err
+	}
+	start := time.Now()
+	_, err := s.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key:       Key(me, Mute, other),
+	})
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, "unmuting user")
+}
+
+// IsMuted reports whether me muted other.
+func (s *Store) IsMuted(ctx context.Context, me, other string) (bool, error) {
+	item, err := s.getItem(ctx, me, Mute, other)
+	return item != nil, err
+}
+
+// addBlocker adds blockers to the blockers of the owner's block edge to
+// other, creating the edge if needed.
+func (s *Store) addBlocker(owner, other string, now time.Time, blockers ...string) types.TransactWriteItem {
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:                aws.String(s.Table),
+		Key:                      Key(owner, Block, other),
+		UpdateExpression:         aws.String("SET #type = :type, other_id = :other, created_at = if_not_exists(created_at, :now) ADD blocked_by :by"),
+		ExpressionAttributeNames: map[string]string{"#type": "type"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":type":  &types.AttributeValueMemberS{Value: Block},
+			":other": &types.AttributeValueMemberS{Value: other},
+			":now":   &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+			":by":    &types.AttributeValueMemberSS{Value: blockers},
+		},
+	}}
+}
+
+// removeBlocker removes blocker from the blockers of the owner's block edge
+// to other, failing the transaction if there is no such edge.
+func (s *Store) removeBlocker(owner, other, blocker string) types.TransactWriteItem {
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(s.Table),
+		Key:                       Key(owner, Block, other),
+		UpdateExpression:          aws.String("DELETE blocked_by :by"),
+		ConditionExpression:       aws.String("attribute_exists(edge)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":by": &types.AttributeValueMemberSS{Value: []string{blocker}}},
+	}}
+}
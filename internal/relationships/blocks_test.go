@@ -0,0 +1,98 @@
+package relationships
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func TestBlock(t *testing.T) {
+	var txs [][]string
+	m := &dbtest.Mock{TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+		tx := writes(in)
+		txs = append(txs, tx)
+		if strings.HasPrefix(tx[2], "count") {
+			// No friendship and no follows to remove
+			return nil, dbtest.TransactionCanceled("ConditionalCheckFailed", "None", "None", "None")
+		}
+		return &dynamodb.TransactWriteItemsOutput{}, nil
+	}}
+	if err := newStore(m).Block(context.Background(), "u1", "u2"); err != nil {
+		t.Fatal(err)
+	}
+
+	block := m.Calls[0].Input.(*dynamodb.TransactWriteItemsInput).TransactItems[1].Update
+	if by := block.ExpressionAttributeValues[":by"].(*types.AttributeValueMemberSS).Value; str(block.Key, "edge") != "BLOCK#u1" || by[0] != "u1" {
+		t.Errorf("block edge of u2 = %s blocked by %v, want BLOCK#u1 by u1", str(block.Key, "edge"), by)
+	}
+	var firsts []string
+	for _, tx := range txs {
+		firsts = append(firsts, tx[0])
+	}
+	want := []string{"", "delete u1 FRIEND#u2", "delete u1 FOLLOWING#u2", "delete u2 FOLLOWING#u1", "delete u1 REQUEST_OUT#u2"}
+	if len(firsts) != len(want) {
+		t.Fatalf("transactions = %q", txs)
+	}
+	for i := 1; i < len(want); i++ {
+		if firsts[i] != want[i] {
+			t.Errorf("transaction %d starts with %q, want %q", i, firsts[i], want[i])
+		}
+	}
+}
+
+func TestBlockUnknownUser(t *testing.T) {
+	m := &dbtest.Mock{TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+		return nil, dbtest.TransactionCanceled("None", "None", "ConditionalCheckFailed")
+	}}
+	if err := newStore(m).Block(context.Background(), "u1", "u9"); apperr.KindOf(err) != apperr.KindNotFound {
+		t.Errorf("Block = %v, want not found", err)
+	}
+	if len(m.Calls) != 1 {
+		t.Errorf("made %d calls, want only the block", len(m.Calls))
+	}
+}
+
+func TestUnblock(t *testing.T) {
+	m := &dbtest.Mock{DeleteItemFunc: func(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+		if str(in.Key, "user_id") == "u2" {
+			// u2 blocked u1 too, so its edge stays
+			return nil, dbtest.ConditionFailed()
+		}
+		return &dynamodb.DeleteItemOutput{}, nil
+	}}
+	if err := newStore(m).Unblock(context.Background(), "u1", "u2"); err != nil {
+		t.Fatal(err)
+	}
+	ops := m.Ops()
+	if len(ops) != 3 || ops[0] != "TransactWriteItems" || ops[1] != "DeleteItem" || ops[2] != "DeleteItem" {
+		t.Errorf("ops = %v", ops)
+	}
+}
+
+func TestIsBlocked(t *testing.T) {
+	tests := []struct {
+		name string
+		item db.Item
+		want bool
+	}{
+		{name: "no edge"},
+		{name: "blocked", item: db.Item{"blocked_by": &types.AttributeValueMemberSS{Value: []string{"u2"}}}, want: true},
+		{name: "unblocked, not yet deleted", item: dbtest.Item("user_id", "u1", "edge", "BLOCK#u2")},
+	}
+	for _, tt := range tests {
+		m := &dbtest.Mock{GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{Item: tt.item}, nil
+		}}
+		got, err := newStore(m).IsBlocked(context.Background(), "u1", "u2")
+		if err != nil || got != tt.want {
+			t.Errorf("%s: IsBlocked = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package relationships
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+)
+
+// Conflicts of the move of an edge to another account.
+var (
+	errEdgeGone   = errors.New("edge already moved or removed")
+	errEdgeExists = errors.New("edge exists on the surviving account")
+)
+
+// Move re-parents the edges of from, a duplicate account merged into to,
+// and returns how many it moved and dropped. Each edge becomes the same
+// edge of to, in one transaction with the removal of the old one, the
+// other user's halves and the counters of both accounts. An edge is
+// dropped instead, both halves removed, when it links the two accounts,
+// when to has an edge of the same type with the other user, when it is a
+// friend request and to is friends with them, and when to and they are
+// blocked. Blocks move first, with their blockers, from becoming to, and
+// end what a block ends between to and the other user. With dryRun nothing
+// is written and the edges are only counted. A failure part way leaves
+// every edge whole on one account or the other, and a retry picks up the
+// rest.
+func (s *Store) Move(ctx context.Context, from, to string, dryRun bool) (moved, dropped int, err error) {
+	if err := checkPair(from, to); err != nil {
+		return 0, 0, err
+	}
+	items, err := s.partition(ctx, from)
+	if err != nil {
+		return 0, 0, err
+	}
+	existing, err := s.partition(ctx, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	has := make(map[string]bool, len(existing))
+	for _, item := range existing {
+		has[str(item, "edge")] = true
+	}
+
+	var blocks, others []db.Item
+	for _, item := range items {
+		if edgeOf(item).Type == Block {
+			blocks = append(blocks, item)
+		} else {
+			others = append(others, item)
+		}
+	}
+	for _, item := range append(blocks, others...) {
+		e := edgeOf(item)
+		if e.OtherID == to || !keeps(has, e, blockers(item)) {
+			dropped++
+			if !dryRun {
+				if err := s.drop(ctx, e); err != nil {
+					return moved, dropped, err
+				}
+			}
+			continue
+		}
+		if !dryRun {
+			if e.Type == Block {
+				err = s.moveBlock(ctx, e, to, blockers(item))
+			} else {
+				err = s.move(ctx, e, to)
+			}
+			switch {
+			case errors.Is(err, errEdgeGone):
+				continue
+			case errors.Is(err, errEdgeExists):
+				// to got the edge meanwhile
+				dropped++
+				if err := s.drop(ctx, e); err != nil {
+					return moved, dropped, err
+				}
+				continue
+			case err != nil:
+				return moved, dropped, err
+			}
+		}
+		moved++
+		track(has, e)
+	}
+	return moved, dropped, nil
+}
+
+// keeps reports whether to, with the edges in has, takes over e, whose
+// blockers are given for a block.
+func keeps(has map[string]bool, e Edge, blockers []string) bool {
+	switch {
+	case e.Type == Block:
+		return len(blockers) > 0
+	case has[e.Type+"#"+e.OtherID], has[Block+"#"+e.OtherID]:
+		return false
+	case e.Type == RequestOut, e.Type == RequestIn:
+		return !has[Friend+"#"+e.OtherID]
+	}
+	return true
+}
+
+// track records in has the edges of to once e moved to it.
+func track(has map[string]bool, e Edge) {
+	switch e.Type {
+	case Block:
+		for _, typ := range []string{Friend, Following, Follower, RequestOut, RequestIn} {
+			delete(has, typ+"#"+e.OtherID)
+		}
+	case Friend:
+		delete(has, RequestOut+"#"+e.OtherID)
+		delete(has, RequestIn+"#"+e.OtherID)
+	}
+	has[e.Type+"#"+e.OtherID] = true
+}
+
+// move replaces the edge e with the same edge of to, keeping its creation
+// time. A friendship settles the friend requests between to and the
+// friend.
+func (s *Store) move(ctx context.Context, e Edge, to string) error {
+	writes := []db.Write{
+		{Item: s.delete(e.UserID, e.Type, e.OtherID, true), Conflict: errEdgeGone},
+		{Item: s.put(to, e.Type, e.OtherID, e.CreatedAt), Conflict: errEdgeExists},
+	}
+	if typ, ok := reciprocal[e.Type]; ok {
+		writes = append(writes,
+			db.Write{Item: s.delete(e.OtherID, typ, e.UserID, false)},
+			db.Write{Item: s.put(e.OtherID, typ, to, e.CreatedAt), Conflict: errEdgeExists},
+		)
+	}
+	if _, counted := Counters[e.Type]; counted {
+		writes = append(writes, db.Write{Item: s.count(e.UserID, e.Type, -1)}, db.Write{Item: s.count(to, e.Type, 1)})
+	}
+	if e.Type == Friend {
+		for _, typ := range []string{RequestOut, RequestIn} {
+			writes = append(writes,
+				db.Write{Item: s.delete(to, typ, e.OtherID, false)},
+				db.Write{Item: s.delete(e.OtherID, reciprocal[typ], to, false)},
+			)
+		}
+	}
+	return s.DB.Transact(ctx, writes...)
+}
+
+// moveBlock replaces the block e with a block of to, blocked by the
+// blockers of e with its owner replaced by to, then separates to and the
+// other user as Block does.
+func (s *Store) moveBlock(ctx context.Context, e Edge, to string, blockers []string) error {
+	for i, b := range blockers {
+		if b == e.UserID {
+			blockers[i] = to
+		}
+	}
+	err := s.DB.Transact(ctx,
+		db.Write{Item: s.delete(e.UserID, Block, e.OtherID, true), Conflict: errEdgeGone},
+		db.Write{Item: s.delete(e.OtherID, Block, e.UserID, false)},
+		db.Write{Item: s.addBlocker(to, e.OtherID, e.CreatedAt, blockers...)},
+		db.Write{Item: s.addBlocker(e.OtherID, to, e.CreatedAt, blockers...)},
+	)
+	if err != nil {
+		return err
+	}
+	return s.separate(ctx, to, e.OtherID)
+}
+
+// drop removes the edge e and its other half, counting both down.
+func (s *Store) drop(ctx context.Context, e Edge) error {
+	writes := []db.Write{{Item: s.delete(e.UserID, e.Type, e.OtherID, true), Conflict: errEdgeGone}}
+	if _, counted := Counters[e.Type]; counted {
+		writes = append(writes, db.Write{Item: s.count(e.UserID, e.Type, -1)})
+	}
+	if typ, ok := reciprocal[e.Type]; ok {
+		writes = append(writes, db.Write{Item: s.delete(e.OtherID, typ, e.UserID, false)})
+		if _, counted := Counters[typ]; counted {
+			writes = append(writes, db.Write{Item: s.count(e.OtherID, typ, -1)})
+		}
+	}
+	err := s.DB.Transact(ctx, writes...)
+	if errors.Is(err, errEdgeGone) {
+		return nil
+	}
+	return err
+}
+
+// blockers returns the blocked_by set of a block item.
+func blockers(item db.Item) []string {
+	if by, ok := item["blocked_by"].(*types.AttributeValueMemberSS); ok {
+		return by.Value
+	}
+	return nil
+}
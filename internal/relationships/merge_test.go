@@ -0,0 +1,106 @@
+package relationships
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+// partitions answers the queries of the edges of each user with items.
+func partitions(items map[string][]db.Item) func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		return &dynamodb.QueryOutput{Items: items[str(in.ExpressionAttributeValues, ":id")]}, nil
+	}
+}
+
+func TestMove(t *testing.T) {
+	edges := map[string][]db.Item{
+		"u1": {
+			dbtest.Item("user_id", "u1", "edge", "FOLLOWER#u4"),  // u2 has the follower already
+			dbtest.Item("user_id", "u1", "edge", "FOLLOWING#u2"), // the surviving account
+			dbtest.Item("user_id", "u1", "edge", "FRIEND#u3", "created_at", "2026-01-01T00:00:00Z"),
+			dbtest.Item("user_id", "u1", "edge", "MUTE#u5"),
+		},
+		"u2": {dbtest.Item("user_id", "u2", "edge", "FOLLOWER#u4")},
+	}
+	want := [][]string{
+		{"delete u1 FOLLOWER#u4", "count u1 follower_count -1", "delete u4 FOLLOWING#u1", "count u4 following_count -1"},
+		{"delete u1 FOLLOWING#u2", "count u1 following_count -1", "delete u2 FOLLOWER#u1", "count u2 follower_count -1"},
+		{
+			"delete u1 FRIEND#u3", "put u2 FRIEND#u3", "delete u3 FRIEND#u1", "put u3 FRIEND#u2",
+			"count u1 friend_count -1", "count u2 friend_count 1",
+			"delete u2 REQUEST_OUT#u3", "delete u3 REQUEST_IN#u2", "delete u2 REQUEST_IN#u3", "delete u3 REQUEST_OUT#u2",
+		},
+		{"delete u1 MUTE#u5", "put u2 MUTE#u5"},
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		var txs [][]string
+		m := &dbtest.Mock{
+			QueryFunc: partitions(edges),
+			TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				txs = append(txs, writes(in))
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			},
+		}
+		moved, dropped, err := newStore(m).Move(context.Background(), "u1", "u2", dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved != 2 || dropped != 2 {
+			t.Errorf("dry run %v: moved %d, dropped %d; want 2 and 2", dryRun, moved, dropped)
+		}
+		if dryRun {
+			if len(txs) != 0 {
+				t.Errorf("dry run wrote %q", txs)
+			}
+			continue
+		}
+		if len(txs) != len(want) {
+			t.Fatalf("transactions = %q", txs)
+		}
+		for i := range want {
+			if !equal(txs[i], want[i]) {
+				t.Errorf("transaction %d = %q, want %q", i, txs[i], want[i])
+			}
+		}
+	}
+}
+
+func TestMoveBlock(t *testing.T) {
+	block := dbtest.Item("user_id", "u1", "edge", "BLOCK#u3")
+	block["blocked_by"] = &types.AttributeValueMemberSS{Value: []string{"u1", "u3"}}
+	m := &dbtest.Mock{
+		QueryFunc: partitions(map[string][]db.Item{
+			"u1": {dbtest.Item("user_id", "u1", "edge", "REQUEST_IN#u4"), block},
+			"u2": {dbtest.Item("user_id", "u2", "edge", "FRIEND#u4")},
+		}),
+	}
+	moved, dropped, err := newStore(m).Move(context.Background(), "u1", "u2", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 || dropped != 1 {
+		t.Errorf("moved %d, dropped %d; want the block moved and the request dropped", moved, dropped)
+	}
+
+	// The block moves first, then separates u2 and u3, then the request of
+	// a friend is dropped
+	first := writes(m.Calls[2].Input.(*dynamodb.TransactWriteItemsInput))
+	if !equal(first, []string{"delete u1 BLOCK#u3", "delete u3 BLOCK#u1", "update u2 BLOCK#u3", "update u3 BLOCK#u2"}) {
+		t.Fatalf("first transaction = %q", first)
+	}
+	update := m.Calls[2].Input.(*dynamodb.TransactWriteItemsInput).TransactItems[2].Update
+	if by := update.ExpressionAttributeValues[":by"].(*types.AttributeValueMemberSS).Value; !equal(by, []string{"u2", "u3"}) {
+		t.Errorf("moved block blocked by %v, want u2 and u3", by)
+	}
+	last := writes(m.Calls[len(m.Calls)-1].Input.(*dynamodb.TransactWriteItemsInput))
+	if last[0] != "delete u1 REQUEST_IN#u4" {
+		t.Errorf("last transaction = %q, want the request dropped", last)
+	}
+}
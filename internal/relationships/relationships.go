@@ -0,0 +1,422 @@
+// Package relationships is the friend and follow graph, and the block and
+// mute lists. Its table holds an adjacency list: each user's partition
+// (user_id) has one item per edge to another user, sorted by edge,
+// "<TYPE>#<other_id>", so one Query with begins_with lists the friends,
+// followers, followees, pending requests, blocks or mutes of a user.
+//
+// Every edge but mutes is stored on both ends, FOLLOWING on the follower
+// and FOLLOWER on the followee, REQUEST_OUT on the sender and REQUEST_IN on
+// the recipient, FRIEND and BLOCK on both, and both halves are written in
+// one transaction together with the counters kept on the user records (see
+// Counters), so the two ends and the counts never disagree.
+package relationships
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+// Edge types.
+const (
+	Friend     = "FRIEND"
+	Following  = "FOLLOWING"   // the owner follows the other user
+	Follower   = "FOLLOWER"    // the other user follows the owner
+	RequestOut = "REQUEST_OUT" // the owner asked the other user to be friends
+	RequestIn  = "REQUEST_IN"  // the other user asked the owner to be friends
+	Block      = "BLOCK"       // either user blocked the other; see Store.Block
+	Mute       = "MUTE"        // the owner muted the other user
+)
+
+// reciprocal is the type of the other half of an edge of each type.
+var reciprocal = map[string]string{
+	Friend:     Friend,
+	Following:  Follower,
+	Follower:   Following,
+	RequestOut: RequestIn,
+	RequestIn:  RequestOut,
+	Block:      Block,
+}
+
+// Counters are the attributes of user records counting their edges, by
+// edge type. They are maintained with ADD and do not bump the record's
+// version, so they never conflict with profile updates.
+var Counters = map[string]string{
+	Friend:    "friend_count",
+	Follower:  "follower_count",
+	Following: "following_count",
+}
+
+// CodeSelf is the error code of a relationship of a user with themselves.
+const CodeSelf = "RELATIONSHIP_SELF"
+
+// ErrAlreadyFriends is returned when asking an existing friend to be friends.
+var ErrAlreadyFriends = errors.New("already friends")
+
+// Edge is one end of a relationship, as its owner sees it.
+type Edge struct {
+	UserID    string    `json:"-"`
+	OtherID   string    `json:"user_id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Profile of the other user, where the listing fills it in
+	Username    string `json:"username,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// Store reads and writes the relationship table and the counters of the
+// user table.
+type Store struct {
+	DB        *db.Client
+	Table     string
+	UserTable string
+}
+
+// NewStore returns a store over the tables named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.RelationshipTableName, UserTable: cfg.UserTableName}
+}
+
+// Follow makes from follow to. Following a user already followed is not an
+// error; created reports whether the edge is new.
+func (s *Store) Follow(ctx context.Context, from, to string) (created bool, err error) {
+	if err := checkPair(from, to); err != nil {
+		return false, err
+	}
+	now := time.Now()
+	err = s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		s.put(from, Following, to, now),
+		s.put(to, Follower, from, now),
+		s.count(from, Following, 1),
+		s.count(to, Follower, 1),
+	})
+	switch {
+	case db.ConditionFailed(err, 0), db.ConditionFailed(err, 1):
+		return false, nil
+	case db.ConditionFailed(err, 2), db.ConditionFailed(err, 3):
+		return false, apperr.NotFound("User not found")
+	case err != nil:
+		return false, db.Wrap(err, "following user")
+	}
+	return true, nil
+}
+
+// Unfollow makes from stop following to. Unfollowing a user not followed is
+// not an error.
+func (s *Store) Unfollow(ctx context.Context, from, to string) error {
+	return s.unlink(ctx, from, Following, to, "unfollowing user")
+}
+
+// Unfriend ends the friendship of a and b, if there is one.
+func (s *Store) Unfriend(ctx context.Context, a, b string) error {
+	return s.unlink(ctx, a, Friend, b, "unfriending user")
+}
+
+// unlink removes both halves of the owner's edge of type typ to other and
+// decrements the counters.
+func (s *Store) unlink(ctx context.Context, owner, typ, other, op string) error {
+	if err := checkPair(owner, other); err != nil {
+		return err
+	}
+	err := s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		s.delete(owner, typ, other, true),
+		s.delete(other, reciprocal[typ], owner, true),
+		s.count(owner, typ, -1),
+		s.count(other, reciprocal[typ], -1),
+	})
+	switch {
+	case db.ConditionFailed(err, 0), db.ConditionFailed(err, 1):
+		return nil
+	case db.ConditionFailed(err, 2), db.ConditionFailed(err, 3):
+		return apperr.NotFound("User not found")
+	}
+	return db.Wrap(err, op)
+}
+
+// RequestFriend asks to to be friends with from. If to already asked from,
+// the request is accepted instead and accepted is true. Asking twice is not
+// an error; asking a friend is ErrAlreadyFriends.
+func (s *Store) RequestFriend(ctx context.Context, from, to string) (accepted bool, err error) {
+	if err := checkPair(from, to); err != nil {
+		return false, err
+	}
+	pending, err := s.getItem(ctx, from, RequestIn, to)
+	if err != nil {
+		return false, err
+	}
+	if pending != nil {
+		return true, s.Accept(ctx, from, to)
+	}
+
+	now := time.Now()
+	err = s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		{ConditionCheck: &types.ConditionCheck{
+			TableName:           aws.String(s.Table),
+			Key:                 Key(from, Friend, to),
+			ConditionExpression: aws.String("attribute_not_exists(edge)"),
+		}},
+		s.put(from, RequestOut, to, now),
+		s.put(to, RequestIn, from, now),
+		{ConditionCheck: &types.ConditionCheck{
+			TableName:           aws.String(s.UserTable),
+			Key:                 userKey(to),
+			ConditionExpression: aws.String("attribute_exists(user_id)"),
+		}},
+	})
+	switch {
+	case db.ConditionFailed(err, 0):
+		return false, ErrAlreadyFriends
+	case db.ConditionFailed(err, 1), db.ConditionFailed(err, 2):
+		return false, nil
+	case db.ConditionFailed(err, 3):
+		return false, apperr.NotFound("User not found")
+	}
+	return false, db.Wrap(err, "requesting friendship")
+}
+
+// Accept accepts the friend request from to me: the requests between them,
+// in either direction, are replaced by the friendship. Accepting a request
+// that does not exist is an apperr.NotFound error.
+func (s *Store) Accept(ctx context.Context, me, from string) error {
+	if err := checkPair(me, from); err != nil {
+		return err
+	}
+	now := time.Now()
+	err := s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		s.delete(me, RequestIn, from, true),
+		s.delete(from, RequestOut, me, false),
+		// Requests crossing each other leave one in each direction
+		s.delete(me, RequestOut, from, false),
+		s.delete(from, RequestIn, me, false),
+		s.put(me, Friend, from, now),
+		s.put(from, Friend, me, now),
+		s.count(me, Friend, 1),
+		s.count(from, Friend, 1),
+	})
+	switch {
+	case db.ConditionFailed(err, 0):
+		return apperr.NotFound("Friend request not found")
+	case db.ConditionFailed(err, 4), db.ConditionFailed(err, 5):
+		return ErrAlreadyFriends
+	case db.ConditionFailed(err, 6), db.ConditionFailed(err, 7):
+		return apperr.NotFound("User not found")
+	}
+	return db.Wrap(err, "accepting friend request")
+}
+
+// RemoveRequests withdraws the friend request of me to other and declines
+// theirs to me, whichever exist.
+func (s *Store) RemoveRequests(ctx context.Context, me, other string) error {
+	if err := checkPair(me, other); err != nil {
+		return err
+	}
+	err := s.DB.TransactWriteItems(ctx, []types.TransactWriteItem{
+		s.delete(me, RequestOut, other, false),
+		s.delete(other, RequestIn, me, false),
+		s.delete(me, RequestIn, other, false),
+		s.delete(other, RequestOut, me, false),
+	})
+	return db.Wrap(err, "removing friend requests")
+}
+
+// List returns one page of the edges of type typ of userID, ordered by the
+// other user's ID, and the key to pass as start for the next page, nil on
+// the last one.
+func (s *Store) List(ctx context.Context, userID, typ string, limit int32, start db.Item) ([]Edge, db.Item, error) {
+	return s.list(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("user_id = :id AND begins_with(edge, :type)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id":   &types.AttributeValueMemberS{Value: userID},
+			":type": &types.AttributeValueMemberS{Value: typ + "#"},
+		},
+		ExclusiveStartKey: start,
+		Limit:             aws.Int32(limit),
+	})
+}
+
+// list runs one page of a listing query.
+func (s *Store) list(ctx context.Context, input *dynamodb.QueryInput) ([]Edge, db.Item, error) {
+	begin := time.Now()
+	result, err := s.DB.DynamoDB.Query(ctx, input)
+	db.Observe(ctx, begin, err)
+	if err != nil {
+		return nil, nil, db.Wrap(err, "listing relationships")
+	}
+
+	edges := make([]Edge, 0, len(result.Items))
+	for _, item := range result.Items {
+		edges = append(edges, edgeOf(item))
+	}
+	if len(result.LastEvaluatedKey) == 0 {
+		return edges, nil, nil
+	}
+	return edges, result.LastEvaluatedKey, nil
+}
+
+// RemoveAll deletes every edge of a deleted user, and the other half of
+// each with the count it contributes to on the other user. Each edge is
+// removed in one transaction with its other half, so a failure part way
+// leaves no edge without its other half, and a retry picks up the rest.
+func (s *Store) RemoveAll(ctx context.Context, userID string) error {
+	items, err := s.partition(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := s.remove(ctx, edgeOf(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partition returns every edge item of userID.
+func (s *Store) partition(ctx context.Context, userID string) ([]db.Item, error) {
+	var all []db.Item
+	err := s.DB.QueryPages(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		KeyConditionExpression: aws.String("user_id = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: userID},
+		},
+	}, func(items []db.Item) bool {
+		all = append(all, items...)
+		return true
+	})
+	return all, err
+}
+
+// Conflicts of the removal of an edge with its other half.
+var (
+	errHalfGone  = errors.New("other half already deleted")
+	errOtherGone = errors.New("other user deleted")
+)
+
+// remove deletes e together with the other user's half of it, decrementing
+// their counter. A half already gone was counted down already; an other
+// user who is gone too has no counter left to decrement. Mutes have no
+// other half.
+func (s *Store) remove(ctx context.Context, e Edge) error {
+	own := db.Write{Item: s.delete(e.UserID, e.Type, e.OtherID, false)}
+	typ, ok := reciprocal[e.Type]
+	if !ok {
+		return s.DB.Transact(ctx, own)
+	}
+	other := db.Write{Item: s.delete(e.OtherID, typ, e.UserID, false)}
+	if _, counted := Counters[typ]; !counted {
+		return s.DB.Transact(ctx, own, other)
+	}
+	err := s.DB.Transact(ctx, own,
+		db.Write{Item: s.delete(e.OtherID, typ, e.UserID, true), Conflict: errHalfGone},
+		db.Write{Item: s.count(e.OtherID, typ, -1), Conflict: errOtherGone},
+	)
+	switch {
+	case errors.Is(err, errHalfGone):
+		return s.DB.Transact(ctx, own)
+	case errors.Is(err, errOtherGone):
+		return s.DB.Transact(ctx, own, other)
+	}
+	return err
+}
+
+// getItem returns the edge item, or nil. The read is strongly consistent,
+// as writes and permissions are decided on it.
+func (s *Store) getItem(ctx context.Context, owner, typ, other string) (db.Item, error) {
+	start := time.Now()
+	result, err := s.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.Table),
+		Key:            Key(owner, typ, other),
+		ConsistentRead: aws.Bool(true),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return nil, db.Wrap(err, "getting relationship")
+	}
+	return result.Item, nil
+}
+
+// put writes an edge created at at, failing the transaction if it exists.
+func (s *Store) put(owner, typ, other string, at time.Time) types.TransactWriteItem {
+	item := Key(owner, typ, other)
+	item["type"] = &types.AttributeValueMemberS{Value: typ}
+	item["other_id"] = &types.AttributeValueMemberS{Value: other}
+	item["created_at"] = &types.AttributeValueMemberS{Value: at.UTC().Format(time.RFC3339)}
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(edge)"),
+	}}
+}
+
+// delete removes an edge; with mustExist, failing the transaction if there
+// is none.
+func (s *Store) delete(owner, typ, other string, mustExist bool) types.TransactWriteItem {
+	d := &types.Delete{TableName: aws.String(s.Table), Key: Key(owner, typ, other)}
+	if mustExist {
+		d.ConditionExpression = aws.String("attribute_exists(edge)")
+	}
+	return types.TransactWriteItem{Delete: d}
+}
+
+// count adds delta to the counter of edges of type typ on the record of
+// userID, failing the transaction if there is no such user.
+func (s *Store) count(userID, typ string, delta int) types.TransactWriteItem {
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(s.UserTable),
+		Key:                       userKey(userID),
+		UpdateExpression:          aws.String("ADD #count :delta"),
+		ConditionExpression:       aws.String("attribute_exists(user_id)"),
+		ExpressionAttributeNames:  map[string]string{"#count": Counters[typ]},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":delta": &types.AttributeValueMemberN{Value: fmt.Sprint(delta)}},
+	}}
+}
+
+// checkPair rejects relationships of a user with themselves.
+func checkPair(a, b string) error {
+	if a == b {
+		return apperr.Invalid(CodeSelf, "other_id", "a user cannot have a relationship with themselves")
+	}
+	return nil
+}
+
+// Key returns the primary key of the owner's edge of type typ to other.
+func Key(owner, typ, other string) db.Item {
+	return db.Item{
+		"user_id": &types.AttributeValueMemberS{Value: owner},
+		"edge":    &types.AttributeValueMemberS{Value: typ + "#" + other},
+	}
+}
+
+func userKey(userID string) db.Item {
+	return db.Item{"user_id": &types.AttributeValueMemberS{Value: userID}}
+}
+
+// edgeOf decodes an edge item. The type and other user are read from the
+// sort key, which every item has.
+func edgeOf(item db.Item) Edge {
+	e := Edge{UserID: str(item, "user_id")}
+	e.Type, e.OtherID, _ = strings.Cut(str(item, "edge"), "#")
+	e.CreatedAt, _ = time.Parse(time.RFC3339, str(item, "created_at"))
+	return e
+}
+
+// str returns the string attribute name of item, or "".
+func str(item db.Item, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
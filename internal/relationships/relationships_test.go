@@ -0,0 +1,249 @@
+package relationships
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func newStore(m *dbtest.Mock) *Store {
+	return &Store{DB: m.Client(), Table: "relationships", UserTable: "users"}
+}
+
+// writes returns a description of each item of the transaction in.
+func writes(in *dynamodb.TransactWriteItemsInput) []string {
+	var out []string
+	for _, w := range in.TransactItems {
+		switch {
+		case w.Put != nil:
+			out = append(out, "put "+str(w.Put.Item, "user_id")+" "+str(w.Put.Item, "edge"))
+		case w.Delete != nil:
+			out = append(out, "delete "+str(w.Delete.Key, "user_id")+" "+str(w.Delete.Key, "edge"))
+		case w.Update != nil && w.Update.ExpressionAttributeValues[":delta"] == nil:
+			out = append(out, "update "+str(w.Update.Key, "user_id")+" "+str(w.Update.Key, "edge"))
+		case w.Update != nil:
+			out = append(out, "count "+str(w.Update.Key, "user_id")+" "+w.Update.ExpressionAttributeNames["#count"]+" "+
+				w.Update.ExpressionAttributeValues[":delta"].(*types.AttributeValueMemberN).Value)
+		case w.ConditionCheck != nil:
+			out = append(out, "check "+aws.ToString(w.ConditionCheck.TableName)+" "+str(w.ConditionCheck.Key, "user_id"))
+		}
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFollow(t *testing.T) {
+	tests := []struct {
+		name        string
+		txErr       error
+		wantCreated bool
+		wantMissing bool // a user not found
+	}{
+		{name: "new", wantCreated: true},
+		{name: "already following", txErr: dbtest.TransactionCanceled("ConditionalCheckFailed", "None", "None", "None")},
+		{name: "no such user", txErr: dbtest.TransactionCanceled("None", "None", "None", "ConditionalCheckFailed"), wantMissing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			m := &dbtest.Mock{TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+				got = writes(in)
+				return &dynamodb.TransactWriteItemsOutput{}, tt.txErr
+			}}
+			created, err := newStore(m).Follow(context.Background(), "u1", "u2")
+			if created != tt.wantCreated || (err != nil) != tt.wantMissing || err != nil && apperr.KindOf(err) != apperr.KindNotFound {
+				t.Fatalf("Follow = %v, %v; want %v, missing %v", created, err, tt.wantCreated, tt.wantMissing)
+			}
+			want := []string{"put u1 FOLLOWING#u2", "put u2 FOLLOWER#u1", "count u1 following_count 1", "count u2 follower_count 1"}
+			if !equal(got, want) {
+				t.Errorf("transaction = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFollowSelf(t *testing.T) {
+	m := &dbtest.Mock{}
+	_, err := newStore(m).Follow(context.Background(), "u1", "u1")
+	if apperr.As(err) == nil || apperr.As(err).Code != CodeSelf {
+		t.Errorf("Follow(self) = %v, want %s", err, CodeSelf)
+	}
+	if len(m.Calls) != 0 {
+		t.Errorf("made %d calls, want none", len(m.Calls))
+	}
+}
+
+func TestUnfriend(t *testing.T) {
+	var got []string
+	m := &dbtest.Mock{TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+		got = writes(in)
+		return nil, dbtest.TransactionCanceled("ConditionalCheckFailed", "None", "None", "None")
+	}}
+	if err := newStore(m).Unfriend(context.Background(), "u1", "u2"); err != nil {
+		t.Fatalf("Unfriend of a non-friend = %v, want nil", err)
+	}
+	want := []string{"delete u1 FRIEND#u2", "delete u2 FRIEND#u1", "count u1 friend_count -1", "count u2 friend_count -1"}
+	if !equal(got, want) {
+		t.Errorf("transaction = %q, want %q", got, want)
+	}
+}
+
+func TestRequestFriend(t *testing.T) {
+	tests := []struct {
+		name         string
+		pending      bool // the other user asked first
+		txErr        error
+		wantAccepted bool
+		wantErr      func(error) bool
+		wantTx       []string
+	}{
+		{
+			name:   "new",
+			wantTx: []string{"check relationships u1", "put u1 REQUEST_OUT#u2", "put u2 REQUEST_IN#u1", "check users u2"},
+		},
+		{
+			name:    "already friends",
+			txErr:   dbtest.TransactionCanceled("ConditionalCheckFailed", "None", "None", "None"),
+			wantErr: func(err error) bool { return errors.Is(err, ErrAlreadyFriends) },
+			wantTx:  []string{"check relationships u1", "put u1 REQUEST_OUT#u2", "put u2 REQUEST_IN#u1", "check users u2"},
+		},
+		{
+			name:   "requested twice",
+			txErr:  dbtest.TransactionCanceled("None", "ConditionalCheckFailed", "ConditionalCheckFailed", "None"),
+			wantTx: []string{"check relationships u1", "put u1 REQUEST_OUT#u2", "put u2 REQUEST_IN#u1", "check users u2"},
+		},
+		{
+			name:    "no such user",
+			txErr:   dbtest.TransactionCanceled("None", "None", "None", "ConditionalCheckFailed"),
+			wantErr: func(err error) bool { return apperr.KindOf(err) == apperr.KindNotFound },
+			wantTx:  []string{"check relationships u1", "put u1 REQUEST_OUT#u2", "put u2 REQUEST_IN#u1", "check users u2"},
+		},
+		{
+			name:         "crossing requests",
+			pending:      true,
+			wantAccepted: true,
+			wantTx: []string{
+				"delete u1 REQUEST_IN#u2", "delete u2 REQUEST_OUT#u1", "delete u1 REQUEST_OUT#u2", "delete u2 REQUEST_IN#u1",
+				"put u1 FRIEND#u2", "put u2 FRIEND#u1", "count u1 friend_count 1", "count u2 friend_count 1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					if !aws.ToBool(in.ConsistentRead) {
+						t.Error("pending request read is not consistent")
+					}
+					if tt.pending {
+						return &dynamodb.GetItemOutput{Item: dbtest.Item("user_id", "u1", "edge", "REQUEST_IN#u2")}, nil
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+				TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					got = writes(in)
+					return &dynamodb.TransactWriteItemsOutput{}, tt.txErr
+				},
+			}
+			accepted, err := newStore(m).RequestFriend(context.Background(), "u1", "u2")
+			switch {
+			case tt.wantErr == nil && err != nil, tt.wantErr != nil && !tt.wantErr(err):
+				t.Fatalf("RequestFriend error = %v", err)
+			case accepted != tt.wantAccepted:
+				t.Errorf("accepted = %v, want %v", accepted, tt.wantAccepted)
+			}
+			if !equal(got, tt.wantTx) {
+				t.Errorf("transaction = %q, want %q", got, tt.wantTx)
+			}
+		})
+	}
+}
+
+func TestAcceptWithoutRequest(t *testing.T) {
+	m := &dbtest.Mock{TransactWriteItemsFunc: func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+		return nil, dbtest.TransactionCanceled("ConditionalCheckFailed", "None", "None", "None", "None", "None", "None", "None")
+	}}
+	err := newStore(m).Accept(context.Background(), "u1", "u2")
+	if apperr.KindOf(err) != apperr.KindNotFound {
+		t.Errorf("Accept = %v, want not found", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	last := dbtest.Item("user_id", "u1", "edge", "FRIEND#u3")
+	m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		if v := in.ExpressionAttributeValues[":type"].(*types.AttributeValueMemberS).Value; v != "FRIEND#" {
+			t.Errorf(":type = %q, want FRIEND#", v)
+		}
+		return &dynamodb.QueryOutput{
+			Items: []db.Item{
+				dbtest.Item("user_id", "u1", "edge", "FRIEND#u2", "created_at", "2026-03-01T10:00:00Z"),
+				dbtest.Item("user_id", "u1", "edge", "FRIEND#u3", "created_at", "2026-03-02T10:00:00Z"),
+			},
+			LastEvaluatedKey: last,
+		}, nil
+	}}
+	edges, next, err := newStore(m).List(context.Background(), "u1", Friend, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edges) != 2 || edges[0].OtherID != "u2" || edges[1].OtherID != "u3" || edges[1].Type != Friend || edges[1].CreatedAt.Day() != 2 {
+		t.Errorf("edges = %+v", edges)
+	}
+	if str(next, "edge") != "FRIEND#u3" {
+		t.Errorf("next = %v, want the last evaluated key", next)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	var got []string
+	m := &dbtest.Mock{
+		QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			return &dynamodb.QueryOutput{Items: []db.Item{
+				dbtest.Item("user_id", "u1", "edge", "FOLLOWER#u2"),
+				dbtest.Item("user_id", "u1", "edge", "FRIEND#u3"),
+				dbtest.Item("user_id", "u1", "edge", "REQUEST_IN#u4"),
+			}}, nil
+		},
+		TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+			tx := writes(in)
+			got = append(got, tx...)
+			if len(tx) == 3 && tx[1] == "delete u3 FRIEND#u1" {
+				// u3 is gone too
+				return nil, dbtest.TransactionCanceled("None", "None", "ConditionalCheckFailed")
+			}
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		},
+	}
+	if err := newStore(m).RemoveAll(context.Background(), "u1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"delete u1 FOLLOWER#u2", "delete u2 FOLLOWING#u1", "count u2 following_count -1",
+		"delete u1 FRIEND#u3", "delete u3 FRIEND#u1", "count u3 friend_count -1", "delete u1 FRIEND#u3", "delete u3 FRIEND#u1",
+		"delete u1 REQUEST_IN#u4", "delete u4 REQUEST_OUT#u1",
+	}
+	if !equal(got, want) {
+		t.Errorf("writes = %q\nwant %q", got, want)
+	}
+}
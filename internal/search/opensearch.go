@@ -0,0 +1,170 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/metrics"
+)
+
+// Mapping is the index mapping the OpenSearch backend expects; create the
+// index with it before pointing the stream at the cluster. Usernames are
+// matched exactly and by prefix on the lowercase keyword, display names by
+// analyzed words.
+const Mapping = `{
+  "settings": {"analysis": {"normalizer": {"folded": {"type": "custom", "filter": ["lowercase"]}}}},
+  "mappings": {"properties": {
+    "user_id":      {"type": "keyword"},
+    "username":     {"type": "text", "fields": {"keyword": {"type": "keyword", "normalizer": "folded"}}},
+    "display_name": {"type": "text"},
+    "avatar_url":   {"type": "keyword", "index": false}
+  }}
+}`
+
+// OpenSearch is the OpenSearch backend, talking to the domain's REST API
+// with SigV4-signed requests.
+type OpenSearch struct {
+	Endpoint    string // e.g. https://search-troggle-abc123.eu-west-1.es.amazonaws.com
+	Index       string
+	Region      string
+	Credentials aws.CredentialsProvider
+	Signer      *v4.Signer
+	HTTP        *http.Client
+}
+
+// Put indexes doc under its user ID, replacing the previous version.
+func (o *OpenSearch) Put(ctx context.Context, doc Document) error {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = o.do(ctx, http.MethodPut, "/"+o.Index+"/_doc/"+url.PathEscape(doc.UserID), body)
+	return err
+}
+
+// Delete removes the document of userID.
+func (o *OpenSearch) Delete(ctx context.Context, userID string) error {
+	_, err := o.do(ctx, http.MethodDelete, "/"+o.Index+"/_doc/"+url.PathEscape(userID), nil)
+	return err
+}
+
+// Search runs q as a scored query: an exact username first, then username
+// and display name prefixes, then fuzzy matches when asked for.
+func (o *OpenSearch) Search(ctx context.Context, q Query) ([]Hit, bool, error) {
+	if q.Offset >= maxResults {
+		return nil, false, ErrTooDeep
+	}
+	text := Fold(q.Text)
+	should := []any{
+		map[string]any{"term": map[string]any{"username.keyword": map[string]any{"value": text, "boost": 10}}},
+		map[string]any{"prefix": map[string]any{"username.keyword": map[string]any{"value": text, "boost": 6}}},
+		map[string]any{"match_phrase_prefix": map[string]any{"display_name": map[string]any{"query": text, "boost": 4}}},
+	}
+	if q.Fuzzy {
+		should = append(should, map[string]any{"multi_match": map[string]any{
+			"query":         text,
+			"fields":        []string{"username", "display_name"},
+			"fuzziness":     "AUTO",
+			"prefix_length": 1,
+		}})
+	}
+	size := min(q.Limit, maxResults-q.Offset)
+	body, err := json.Marshal(map[string]any{
+		"from":             q.Offset,
+		"size":             size + 1, // one more tells whether there is a next page
+		"track_total_hits": false,
+		"query":            map[string]any{"bool": map[string]any{"should": should, "minimum_should_match": 1}},
+		"sort":             []any{"_score", map[string]any{"user_id": "asc"}},
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	raw, err := o.do(ctx, http.MethodPost, "/"+o.Index+"/_search", body)
+	if err != nil {
+		return nil, false, err
+	}
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Score  float64  `json:"_score"`
+				Source Document `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, false, fmt.Errorf("decoding search results: %w", err)
+	}
+
+	hits := make([]Hit, 0, len(result.Hits.Hits))
+	for _, h := range result.Hits.Hits {
+		hits = append(hits, Hit{Document: h.Source, Score: h.Score})
+	}
+	more := len(hits) > size
+	if more {
+		hits = hits[:size]
+	}
+	return hits, more && q.Offset+size < maxResults, nil
+}
+
+// service is the SigV4 signing name of the endpoint: Serverless collections
+// sign as "aoss", managed domains as "es".
+func (o *OpenSearch) service() string {
+	if strings.Contains(o.Endpoint, ".aoss.") {
+		return "aoss"
+	}
+	return "es"
+}
+
+// do sends a signed request and returns the response body. Missing
+// documents are not an error; throttling is apperr.Throttled.
+func (o *OpenSearch) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(o.Endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	creds, err := o.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving credentials: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	if err := o.Signer.SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), o.service(), o.Region, time.Now()); err != nil {
+		return nil, fmt.Errorf("signing request: %w", err)
+	}
+
+	start := time.Now()
+	resp, err := o.HTTP.Do(req)
+	metrics.Since(ctx, metrics.SearchLatency, start)
+	if err != nil {
+		return nil, fmt.Errorf("opensearch %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading opensearch response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode < 300, resp.StatusCode == http.StatusNotFound && method == http.MethodDelete:
+		return raw, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, apperr.Throttled(fmt.Errorf("opensearch %s %s: %s", method, path, resp.Status))
+	}
+	return nil, fmt.Errorf("opensearch %s %s: %s: %.200s", method, path, resp.Status, raw)
+}
@@ -0,0 +1,128 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+
+	"troggle-backend/internal/apperr"
+)
+
+// fakeCluster answers searches with the given hits and records requests.
+func fakeCluster(t *testing.T, status int, hits string) (*OpenSearch, *[]string) {
+	t.Helper()
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			t.Errorf("%s %s is not signed", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		w.WriteHeader(status)
+		io.WriteString(w, `{"hits":{"hits":`+hits+`}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return &OpenSearch{
+		Endpoint: srv.URL,
+		Index:    "users",
+		Region:   "eu-west-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+		Signer: v4.NewSigner(),
+		HTTP:   srv.Client(),
+	}, &requests
+}
+
+func TestOpenSearchSearch(t *testing.T) {
+	o, requests := fakeCluster(t, 200, `[
+		{"_score": 12.5, "_source": {"user_id": "u1", "username": "jane"}},
+		{"_score": 3.1, "_source": {"user_id": "u2", "display_name": "Janet"}},
+		{"_score": 1.2, "_source": {"user_id": "u3", "display_name": "Jan"}}
+	]`)
+
+	hits, more, err := o.Search(context.Background(), Query{Text: "Jane", Fuzzy: true, Limit: 2, Offset: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || hits[0].UserID != "u1" || hits[0].Score != 12.5 || !more {
+		t.Errorf("hits = %+v, more = %v", hits, more)
+	}
+
+	method, rest, _ := strings.Cut((*requests)[0], " ")
+	path, body, _ := strings.Cut(rest, " ")
+	if method != http.MethodPost || path != "/users/_search" {
+		t.Errorf("request = %s %s", method, path)
+	}
+	var req struct {
+		From  int `json:"from"`
+		Size  int `json:"size"`
+		Query struct {
+			Bool struct {
+				Should []map[string]any `json:"should"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.From != 4 || req.Size != 3 || len(req.Query.Bool.Should) != 4 {
+		t.Errorf("from %d, size %d, %d clauses", req.From, req.Size, len(req.Query.Bool.Should))
+	}
+	if !strings.Contains(body, `"value":"jane"`) {
+		t.Errorf("query text is not folded: %s", body)
+	}
+}
+
+func TestOpenSearchErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		call    func(o *OpenSearch) error
+		wantErr bool
+		kind    apperr.Kind
+	}{
+		{
+			name: "deleting a missing document", status: 404,
+			call: func(o *OpenSearch) error { return o.Delete(context.Background(), "u1") },
+		},
+		{
+			name: "throttled", status: 429, wantErr: true, kind: apperr.KindThrottled,
+			call: func(o *OpenSearch) error { return o.Put(context.Background(), Document{UserID: "u1"}) },
+		},
+		{
+			name: "missing index", status: 404, wantErr: true, kind: apperr.KindInternal,
+			call: func(o *OpenSearch) error {
+				_, _, err := o.Search(context.Background(), Query{Text: "ja", Limit: 1})
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, _ := fakeCluster(t, tt.status, `[]`)
+			err := tt.call(o)
+			if (err != nil) != tt.wantErr || err != nil && apperr.KindOf(err) != tt.kind {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenSearchService(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"https://abc123.eu-west-1.aoss.amazonaws.com":           "aoss",
+		"https://search-troggle-abc.eu-west-1.es.amazonaws.com": "es",
+	} {
+		if got := (&OpenSearch{Endpoint: endpoint}).service(); got != want {
+			t.Errorf("service(%s) = %s, want %s", endpoint, got, want)
+		}
+	}
+}
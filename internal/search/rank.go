@@ -0,0 +1,109 @@
+package search
+
+import (
+	"sort"
+	"strings"
+)
+
+// Field weights: a match on the username, which is unique, says more than a
+// match on a display name.
+const (
+	weightUsername    = 1.0
+	weightDisplayName = 0.9
+)
+
+// score rates how well the folded term of a user's field matches the folded
+// query q, from 0 to 100, and reports whether it matches at all. Exact
+// matches rank above prefixes, prefixes above typos, and a prefix covering
+// more of the term above a shorter one.
+func score(q, term, field string, fuzzy bool) (float64, bool) {
+	weight := weightDisplayName
+	if field == "username" {
+		weight = weightUsername
+	}
+	switch {
+	case term == q:
+		return 100 * weight, true
+	case strings.HasPrefix(term, q):
+		return (60 + 20*float64(len(q))/float64(len(term))) * weight, true
+	case !fuzzy:
+		return 0, false
+	}
+
+	// A typo in the whole term, or in what was typed of it so far; the
+	// latter ranks lower, as the rest of the term is unconfirmed
+	qr, tr := []rune(q), []rune(term)
+	allowed := maxEdits(len(qr))
+	if d := distance(qr, tr); d <= allowed {
+		return (40 - 10*float64(d)) * weight, true
+	}
+	if len(tr) > len(qr) {
+		if d := distance(qr, tr[:len(qr)]); d <= allowed {
+			return (30 - 10*float64(d)) * weight, true
+		}
+	}
+	return 0, false
+}
+
+// maxEdits is the number of typos tolerated in a query of n characters, as
+// OpenSearch's AUTO fuzziness does.
+func maxEdits(n int) int {
+	switch {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	}
+	return 2
+}
+
+// distance is the Damerau-Levenshtein (optimal string alignment) distance
+// between a and b: the edits, counting a swap of neighbors as one, turning
+// one into the other.
+func distance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// rankHits sorts hits best first; ties go to the shorter name, then to the
+// user ID so pages are stable.
+func rankHits(hits []Hit) {
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if la, lb := len(a.Username)+len(a.DisplayName), len(b.Username)+len(b.DisplayName); la != lb {
+			return la < lb
+		}
+		return a.UserID < b.UserID
+	})
+}
+
+// page returns the hits of q's page out of every ranked hit.
+func page(hits []Hit, q Query) ([]Hit, bool) {
+	if q.Offset >= len(hits) {
+		return nil, false
+	}
+	end := min(q.Offset+q.Limit, len(hits), maxResults)
+	return hits[q.Offset:end], end < len(hits) && end < maxResults
+}
@@ -0,0 +1,167 @@
+// Package search finds users by username and display name, by prefix and,
+// optionally, with typos. The index is a projection of the user table, kept
+// in sync by the userStream function, and has two backends:
+//
+//   - OpenSearch (SEARCH_BACKEND=opensearch), which scores and fuzzy-matches
+//     natively.
+//   - A DynamoDB table of lowercase search terms with a prefix GSI
+//     (SEARCH_BACKEND=dynamodb, the default), for stages without a cluster.
+//     Fuzzy matching there only forgives typos after the first two
+//     characters, and results are ranked in the function.
+//
+// Only active users are indexed, and only attributes every signed-in user
+// may see are stored. Users are indexed when their record next changes, so
+// existing tables need a backfill touching each record once.
+package search
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+const (
+	// MinQueryLength is the shortest query accepted, in characters: the
+	// DynamoDB backend partitions terms by their first two.
+	MinQueryLength = 2
+
+	// MaxQueryLength bounds queries; no username or display name is longer.
+	MaxQueryLength = 64
+
+	// maxResults bounds how deep callers may page, in either backend.
+	maxResults = 500
+)
+
+// Indexed attributes of user records; a change to any other attribute
+// leaves the index as it is.
+var Indexed = []string{"username", "display_name", "avatar_url", "status"}
+
+// Document is the indexed form of a user.
+type Document struct {
+	UserID      string `json:"user_id"`
+	Username    string `json:"username,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// Hit is a user matching a query.
+type Hit struct {
+	Document
+	Score float64 `json:"score"`
+}
+
+// Query is a search request. Offset counts the hits of earlier pages.
+type Query struct {
+	Text   string
+	Fuzzy  bool
+	Limit  int
+	Offset int
+}
+
+// Index is a search backend.
+type Index interface {
+	// Put adds or replaces the document of a user.
+	Put(ctx context.Context, doc Document) error
+
+	// Delete removes a user from the index; removing an absent user is not
+	// an error.
+	Delete(ctx context.Context, userID string) error
+
+	// Search returns the hits of one page, best first, and whether there
+	// are more.
+	Search(ctx context.Context, q Query) (hits []Hit, more bool, err error)
+}
+
+// ErrTooDeep is returned for pages past the deepest one the backends serve.
+var ErrTooDeep = errors.New("search results are limited to the first 500 users")
+
+// New returns the index backend cfg selects. awsCfg signs requests to
+// OpenSearch.
+func New(client *db.Client, awsCfg aws.Config, cfg *config.Config) Index {
+	if cfg.SearchBackend == config.SearchOpenSearch {
+		return &OpenSearch{
+			Endpoint:    cfg.OpenSearchEndpoint,
+			Index:       cfg.OpenSearchIndex,
+			Region:      awsCfg.Region,
+			Credentials: awsCfg.Credentials,
+			Signer:      v4.NewSigner(),
+			HTTP:        http.DefaultClient,
+		}
+	}
+	return &Table{DB: client, Table: cfg.SearchTableName, PrefixIndex: cfg.SearchPrefixIndexName}
+}
+
+// DocumentOf returns the document of the user record item, and false if
+// the user must not be found by searches (it is not active, or has
+// neither a username nor a display name).
+func DocumentOf(item db.Item) (Document, bool) {
+	if status := str(item, "status"); status != "" && status != "active" {
+		return Document{}, false
+	}
+	doc := documentOf(item)
+	return doc, doc.UserID != "" && (doc.Username != "" || doc.DisplayName != "")
+}
+
+// documentOf reads the document attributes of a user record or term item.
+func documentOf(item db.Item) Document {
+	return Document{
+		UserID:      str(item, "user_id"),
+		Username:    str(item, "username"),
+		DisplayName: str(item, "display_name"),
+		AvatarURL:   str(item, "avatar_url"),
+	}
+}
+
+// str returns the string attribute name of item, or "".
+func str(item db.Item, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+
+// Fold returns the form s is matched in: trimmed, lower-cased and with runs
+// of whitespace collapsed.
+func Fold(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// CheckQuery validates the text of a query, returning its folded form.
+func CheckQuery(text string) (string, error) {
+	folded := Fold(text)
+	if n := utf8.RuneCountInString(folded); n < MinQueryLength || n > MaxQueryLength {
+		return "", apperr.Invalid("SEARCH_QUERY_INVALID", "q", fmt.Sprintf("q must be %d to %d characters", MinQueryLength, MaxQueryLength))
+	}
+	return folded, nil
+}
+
+// EncodeToken turns the offset of the next page into an opaque token.
+func EncodeToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o" + strconv.Itoa(offset)))
+}
+
+// DecodeToken reverses EncodeToken.
+func DecodeToken(token string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) < 2 || raw[0] != 'o' {
+		return 0, errors.New("malformed token")
+	}
+	offset, err := strconv.Atoi(string(raw[1:]))
+	if err != nil || offset < 0 {
+		return 0, errors.New("malformed token")
+	}
+	return offset, nil
+}
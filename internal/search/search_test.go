@@ -0,0 +1,181 @@
+package search
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		q, term, field string
+		fuzzy          bool
+		want           bool
+	}{
+		{q: "jane", term: "jane", field: "username", want: true},
+		{q: "ja", term: "jane doe", field: "display_name", want: true},
+		{q: "jnae", term: "jane", field: "username", want: false},
+		{q: "jnae", term: "jane", field: "username", fuzzy: true, want: true},    // swapped letters
+		{q: "janx", term: "janedoe", field: "username", fuzzy: true, want: true}, // typo in the prefix
+		{q: "jo", term: "ja", field: "username", fuzzy: true, want: false},       // too short for typos
+		{q: "jaxxxx", term: "jane", field: "username", fuzzy: true, want: false},
+	}
+	for _, tt := range tests {
+		if _, got := score(tt.q, tt.term, tt.field, tt.fuzzy); got != tt.want {
+			t.Errorf("score(%q, %q, fuzzy=%v) matches = %v, want %v", tt.q, tt.term, tt.fuzzy, got, tt.want)
+		}
+	}
+
+	exact, _ := score("jane", "jane", "username", false)
+	prefix, _ := score("jane", "janet", "username", false)
+	display, _ := score("jane", "janet", "display_name", false)
+	typo, _ := score("jnae", "jane", "username", true)
+	if !(exact > prefix && prefix > display && display > typo) {
+		t.Errorf("scores: exact %v, prefix %v, display name %v, typo %v", exact, prefix, display, typo)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"jane", "jane", 0},
+		{"jane", "jnae", 1},
+		{"jane", "jan", 1},
+		{"jane", "june", 1},
+		{"", "abc", 3},
+	}
+	for _, tt := range tests {
+		if got := distance([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	offset, err := DecodeToken(EncodeToken(40))
+	if err != nil || offset != 40 {
+		t.Errorf("round trip = %d, %v", offset, err)
+	}
+	if _, err := DecodeToken("bm9wZQ"); err == nil {
+		t.Error("foreign token accepted")
+	}
+}
+
+func TestDocumentOf(t *testing.T) {
+	tests := []struct {
+		name string
+		item db.Item
+		want bool
+	}{
+		{name: "active", item: dbtest.Item("user_id", "u1", "display_name", "Jane", "status", "active"), want: true},
+		{name: "no status", item: dbtest.Item("user_id", "u1", "username", "jane"), want: true},
+		{name: "pending deletion", item: dbtest.Item("user_id", "u1", "display_name", "Jane", "status", "pending_deletion")},
+		{name: "nameless", item: dbtest.Item("user_id", "u1", "status", "active")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := DocumentOf(tt.item); ok != tt.want {
+				t.Errorf("indexed = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+// termItem is a term of the search table.
+func termItem(userID, term, field, username, displayName string) db.Item {
+	return dbtest.Item("user_id", userID, "term", term, "prefix", prefix(term), "field", field, "username", username, "display_name", displayName)
+}
+
+func TestTableSearch(t *testing.T) {
+	index := []db.Item{
+		termItem("u3", "janet", "display_name", "", "Janet"),
+		termItem("u1", "jane", "username", "jane", "Jane Doe"),
+		termItem("u1", "jane doe", "display_name", "jane", "Jane Doe"),
+		termItem("u2", "janex", "username", "janex", ""),
+		termItem("u4", "jarvis", "username", "jarvis", ""),
+	}
+	m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		text, _ := in.ExpressionAttributeValues[":text"].(*types.AttributeValueMemberS)
+		var out []db.Item
+		for _, item := range index {
+			if text == nil || len(str(item, "term")) >= len(text.Value) && str(item, "term")[:len(text.Value)] == text.Value {
+				out = append(out, item)
+			}
+		}
+		return &dynamodb.QueryOutput{Items: out}, nil
+	}}
+	tbl := &Table{DB: m.Client(), Table: "search", PrefixIndex: "prefix-index"}
+
+	tests := []struct {
+		name     string
+		q        Query
+		want     []string
+		wantMore bool
+	}{
+		{name: "prefix", q: Query{Text: "Jane", Limit: 10}, want: []string{"u1", "u2", "u3"}},
+		{name: "first page", q: Query{Text: "jane", Limit: 2}, want: []string{"u1", "u2"}, wantMore: true},
+		{name: "second page", q: Query{Text: "jane", Limit: 2, Offset: 2}, want: []string{"u3"}},
+		// Typos past the first two characters only
+		{name: "fuzzy", q: Query{Text: "jaen", Fuzzy: true, Limit: 10}, want: []string{"u1", "u2", "u3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.Calls = nil
+			hits, more, err := tbl.Search(context.Background(), tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, h := range hits {
+				got = append(got, h.UserID)
+			}
+			if len(got) != len(tt.want) || more != tt.wantMore {
+				t.Fatalf("hits = %v, more = %v; want %v, %v", got, more, tt.want, tt.wantMore)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("hits = %v, want %v", got, tt.want)
+				}
+			}
+			in := m.Calls[0].Input.(*dynamodb.QueryInput)
+			if aws.ToString(in.IndexName) != "prefix-index" || in.ExpressionAttributeValues[":prefix"].(*types.AttributeValueMemberS).Value != "ja" {
+				t.Errorf("query = %s on %s", aws.ToString(in.KeyConditionExpression), aws.ToString(in.IndexName))
+			}
+		})
+	}
+}
+
+func TestTablePutReplacesTerms(t *testing.T) {
+	m := &dbtest.Mock{QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		return &dynamodb.QueryOutput{Items: []db.Item{dbtest.Item("term", "jane"), dbtest.Item("term", "doe")}}, nil
+	}}
+	tbl := &Table{DB: m.Client(), Table: "search", PrefixIndex: "prefix-index"}
+
+	if err := tbl.Put(context.Background(), Document{UserID: "u1", Username: "jane", DisplayName: "Jane Smith"}); err != nil {
+		t.Fatal(err)
+	}
+	var deleted, put []string
+	for _, c := range m.Calls {
+		switch in := c.Input.(type) {
+		case *dynamodb.DeleteItemInput:
+			deleted = append(deleted, str(in.Key, "term"))
+		case *dynamodb.PutItemInput:
+			put = append(put, str(in.Item, "term"))
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != "doe" {
+		t.Errorf("deleted %v, want [doe]", deleted)
+	}
+	// jane (the username, also a word of the name), smith and "jane smith"
+	if len(put) != 3 {
+		t.Errorf("put %v", put)
+	}
+}
@@ -0,0 +1,191 @@
+package search
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+)
+
+// maxCandidates bounds the terms one search reads from the prefix index;
+// they are ranked in memory, so this also bounds the work per query.
+const maxCandidates = 2000
+
+// Table is the DynamoDB backend. Its items are the search terms of each
+// user, keyed by user_id and term: the folded username, display name and
+// each word of the display name. The prefix GSI is keyed by the first two
+// characters of the term and sorted by the term, so a query is a
+// begins_with on one partition.
+type Table struct {
+	DB          *db.Client
+	Table       string
+	PrefixIndex string
+}
+
+// terms returns the search terms of doc with the field each comes from.
+func terms(doc Document) map[string]string {
+	out := map[string]string{}
+	name := Fold(doc.DisplayName)
+	for _, word := range strings.Fields(name) {
+		out[word] = "display_name"
+	}
+	if name != "" {
+		out[name] = "display_name"
+	}
+	if username := Fold(doc.Username); username != "" {
+		out[username] = "username"
+	}
+	for term := range out {
+		if len([]rune(term)) < MinQueryLength {
+			delete(out, term)
+		}
+	}
+	return out
+}
+
+// prefix returns the partition of term in the prefix index.
+func prefix(term string) string {
+	return string([]rune(term)[:MinQueryLength])
+}
+
+// Put replaces the terms of doc.UserID with those of doc.
+func (t *Table) Put(ctx context.Context, doc Document) error {
+	want := terms(doc)
+	have, err := t.termsOf(ctx, doc.UserID)
+	if err != nil {
+		return err
+	}
+	for _, term := range have {
+		if _, ok := want[term]; !ok {
+			if err := t.deleteTerm(ctx, doc.UserID, term); err != nil {
+				return err
+			}
+		}
+	}
+	for term, field := range want {
+		item := db.Item{
+			"user_id":      &types.AttributeValueMemberS{Value: doc.UserID},
+			"term":         &types.AttributeValueMemberS{Value: term},
+			"prefix":       &types.AttributeValueMemberS{Value: prefix(term)},
+			"field":        &types.AttributeValueMemberS{Value: field},
+			"username":     &types.AttributeValueMemberS{Value: doc.Username},
+			"display_name": &types.AttributeValueMemberS{Value: doc.DisplayName},
+			"avatar_url":   &types.AttributeValueMemberS{Value: doc.AvatarURL},
+		}
+		if err := t.DB.PutItem(ctx, t.Table, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes every term of userID.
+func (t *Table) Delete(ctx context.Context, userID string) error {
+	have, err := t.termsOf(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, term := range have {
+		if err := t.deleteTerm(ctx, userID, term); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// termsOf returns the indexed terms of userID.
+func (t *Table) termsOf(ctx context.Context, userID string) ([]string, error) {
+	items, err := t.DB.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(t.Table),
+		KeyConditionExpression:    aws.String("user_id = :id"),
+		ProjectionExpression:      aws.String("#term"),
+		ExpressionAttributeNames:  map[string]string{"#term": "term"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":id": &types.AttributeValueMemberS{Value: userID}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		out = append(out, str(item, "term"))
+	}
+	return out, nil
+}
+
+func (t *Table) deleteTerm(ctx context.Context, userID, term string) error {
+	start := time.Now()
+	_, err := t.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(t.Table),
+		Key: db.Item{
+			"user_id": &types.AttributeValueMemberS{Value: userID},
+			"term":    &types.AttributeValueMemberS{Value: term},
+		},
+	})
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, "deleting search term")
+}
+
+// Search reads the terms starting with the query (with fuzzy set, every
+// term sharing its first two characters), ranks the users they belong to
+// and returns the requested page.
+func (t *Table) Search(ctx context.Context, q Query) ([]Hit, bool, error) {
+	if q.Offset >= maxResults {
+		return nil, false, ErrTooDeep
+	}
+	text := Fold(q.Text)
+	condition := "#prefix = :prefix AND begins_with(#term, :text)"
+	names := map[string]string{"#prefix": "prefix", "#term": "term"}
+	values := map[string]types.AttributeValue{
+		":prefix": &types.AttributeValueMemberS{Value: prefix(text)},
+		":text":   &types.AttributeValueMemberS{Value: text},
+	}
+	if q.Fuzzy {
+		condition = "#prefix = :prefix"
+		delete(names, "#term")
+		delete(values, ":text")
+	}
+
+	best := map[string]Hit{}
+	read := 0
+	err := t.DB.QueryPages(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(t.Table),
+		IndexName:                 aws.String(t.PrefixIndex),
+		KeyConditionExpression:    aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}, func(items []db.Item) bool {
+		for _, item := range items {
+			hit, ok := hitOf(text, item, q.Fuzzy)
+			if ok && hit.Score > best[hit.UserID].Score {
+				best[hit.UserID] = hit
+			}
+		}
+		read += len(items)
+		return read < maxCandidates
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	hits := make([]Hit, 0, len(best))
+	for _, hit := range best {
+		hits = append(hits, hit)
+	}
+	rankHits(hits)
+	out, more := page(hits, q)
+	return out, more, nil
+}
+
+// hitOf scores the term item against the folded query text.
+func hitOf(text string, item db.Item, fuzzy bool) (Hit, bool) {
+	s, ok := score(text, str(item, "term"), str(item, "field"), fuzzy)
+	if !ok {
+		return Hit{}, false
+	}
+	return Hit{Document: documentOf(item), Score: s}, true
+}
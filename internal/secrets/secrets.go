@@ -0,0 +1,185 @@
+// Package secrets loads secrets, such as third-party API keys and signing
+// keys, from AWS Secrets Manager. Secrets do not belong in environment
+// variables, which anyone allowed to read the function's configuration can
+// see; functions name the secrets they need instead, and a Cache fetches
+// them at cold start and keeps them for SECRETS_CACHE_TTL.
+//
+// Secret names are relative to SECRETS_PREFIX, e.g. "troggle/prod/", so the
+// same binary reads the secrets of the environment it is deployed to.
+// Secrets stored as key/value pairs are JSON objects; Field reads one key.
+//
+// Rotation promotes a new version of a secret to AWSCURRENT and keeps the
+// old one valid as AWSPREVIOUS until the next rotation, so a cached value
+// keeps working until the cache picks up the new one. A caller whose
+// credential is rejected before then calls Refresh.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+
+	"troggle-backend/internal/awscfg"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/logging"
+)
+
+// minRefresh limits forced refetches of one secret, so a caller retrying a
+// rejected credential cannot turn into a flood of Secrets Manager calls.
+const minRefresh = time.Minute
+
+// ErrNotFound is returned for secrets that do not exist.
+var ErrNotFound = errors.New("secret not found")
+
+// API is the subset of the Secrets Manager client used to read secrets.
+type API interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// entry is a cached secret.
+type entry struct {
+	value   string
+	version string
+	fetched time.Time
+}
+
+// Cache holds the secrets a container has read.
+type Cache struct {
+	API    API
+	Prefix string        // prepended to secret names
+	TTL    time.Duration // how long fetched values are used before being refreshed
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+var (
+	sharedOnce  sync.Once
+	sharedCache *Cache
+	sharedErr   error
+)
+
+// Shared returns the container-wide cache, creating it on first use. cfg is
+// only consulted by the first call.
+func Shared(ctx context.Context, cfg *config.Config) (*Cache, error) {
+	sharedOnce.Do(func() {
+		awsCfg, err := awscfg.Shared(ctx, cfg)
+		if err != nil {
+			sharedErr = err
+			return
+		}
+		sharedCache = NewCache(secretsmanager.NewFromConfig(awsCfg), cfg)
+	})
+	return sharedCache, sharedErr
+}
+
+// NewCache returns a cache reading the secrets of the environment in cfg.
+func NewCache(client API, cfg *config.Config) *Cache {
+	return &Cache{API: client, Prefix: cfg.SecretsPrefix, TTL: cfg.SecretsCacheTTL}
+}
+
+// Preload fetches the named secrets, so that functions fail at cold start
+// rather than on their first request when one is missing.
+func (c *Cache) Preload(ctx context.Context, names ...string) error {
+	var errs []error
+	for _, name := range names {
+		if _, err := c.Get(ctx, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Get returns the value of the named secret, fetching it when it is not
+// cached or older than the cache's TTL. A failed refresh falls back to the
+// cached value.
+func (c *Cache) Get(ctx context.Context, name string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[name]
+	if ok && time.Since(e.fetched) < c.TTL {
+		return e.value, nil
+	}
+	return c.refresh(ctx, name, e)
+}
+
+// Refresh refetches the named secret and returns its current value. Callers
+// use it when a credential read from the cache is rejected, which means the
+// secret was rotated. Secrets fetched less than a minute ago are not
+// refetched.
+func (c *Cache) Refresh(ctx context.Context, name string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[name]
+	if ok && time.Since(e.fetched) < minRefresh {
+		return e.value, nil
+	}
+	return c.refresh(ctx, name, e)
+}
+
+// refresh fetches the named secret into the cache, falling back to the
+// cached entry e, if any, when that fails. c.mu must be held.
+func (c *Cache) refresh(ctx context.Context, name string, e *entry) (string, error) {
+	fresh, err := c.fetch(ctx, name)
+	if err != nil {
+		if e != nil {
+			slog.WarnContext(ctx, "Serving cached secret", "secret", name, logging.Err(err))
+			return e.value, nil
+		}
+		return "", err
+	}
+	if e != nil && e.version != fresh.version {
+		slog.InfoContext(ctx, "Secret rotated", "secret", name, "version", fresh.version)
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*entry)
+	}
+	c.entries[name] = fresh
+	return fresh.value, nil
+}
+
+// fetch reads the current version of the named secret.
+func (c *Cache) fetch(ctx context.Context, name string) (*entry, error) {
+	out, err := c.API.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(c.Prefix + name),
+	})
+	var missing *smtypes.ResourceNotFoundException
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, c.Prefix+name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %s: %w", c.Prefix+name, err)
+	}
+	if out.SecretString == nil {
+		return nil, fmt.Errorf("secret %s is binary", c.Prefix+name)
+	}
+	return &entry{value: *out.SecretString, version: aws.ToString(out.VersionId), fetched: time.Now()}, nil
+}
+
+// Field returns the value of key in the named secret, which must hold
+// key/value pairs.
+func (c *Cache) Field(ctx context.Context, name, key string) (string, error) {
+	v, err := c.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(v), &fields); err != nil {
+		return "", fmt.Errorf("secret %s does not hold key/value pairs", c.Prefix+name)
+	}
+	f, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %q", c.Prefix+name, key)
+	}
+	return f, nil
+}
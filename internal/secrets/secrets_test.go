@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// fakeAPI answers reads from values, keyed by secret ID, with the version
+// counting the reads.
+type fakeAPI struct {
+	values map[string]string
+	err    error
+	reads  int
+}
+
+func (f *fakeAPI) GetSecretValue(_ context.Context, in *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	f.reads++
+	if f.err != nil {
+		return nil, f.err
+	}
+	v, ok := f.values[aws.ToString(in.SecretId)]
+	if !ok {
+		return nil, &smtypes.ResourceNotFoundException{Message: aws.String("not found")}
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(v), VersionId: aws.String(string(rune('a' + f.reads)))}, nil
+}
+
+func TestGet(t *testing.T) {
+	api := &fakeAPI{values: map[string]string{"troggle/test/partner": "s3cret"}}
+	c := &Cache{API: api, Prefix: "troggle/test/", TTL: time.Hour}
+
+	for range 3 {
+		v, err := c.Get(context.Background(), "partner")
+		if err != nil || v != "s3cret" {
+			t.Fatalf("Get = %q, %v", v, err)
+		}
+	}
+	if api.reads != 1 {
+		t.Errorf("reads = %d, want 1", api.reads)
+	}
+
+	if _, err := c.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing secret: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetExpired(t *testing.T) {
+	api := &fakeAPI{values: map[string]string{"partner": "old"}}
+	c := &Cache{API: api, TTL: time.Hour}
+	if _, err := c.Get(context.Background(), "partner"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated, and the cached value is past its TTL
+	api.values["partner"] = "new"
+	c.entries["partner"].fetched = time.Now().Add(-2 * time.Hour)
+	if v, _ := c.Get(context.Background(), "partner"); v != "new" {
+		t.Errorf("after TTL: Get = %q, want new", v)
+	}
+
+	// A failed refresh serves the cached value
+	api.err = errors.New("throttled")
+	c.entries["partner"].fetched = time.Now().Add(-2 * time.Hour)
+	if v, err := c.Get(context.Background(), "partner"); err != nil || v != "new" {
+		t.Errorf("failed refresh: Get = %q, %v; want new", v, err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	api := &fakeAPI{values: map[string]string{"partner": "old"}}
+	c := &Cache{API: api, TTL: time.Hour}
+	if _, err := c.Get(context.Background(), "partner"); err != nil {
+		t.Fatal(err)
+	}
+	api.values["partner"] = "new"
+
+	// Fetched moments ago: not refetched
+	if v, _ := c.Refresh(context.Background(), "partner"); v != "old" || api.reads != 1 {
+		t.Errorf("recent: Refresh = %q after %d reads, want old after 1", v, api.reads)
+	}
+
+	c.entries["partner"].fetched = time.Now().Add(-2 * minRefresh)
+	if v, _ := c.Refresh(context.Background(), "partner"); v != "new" {
+		t.Errorf("Refresh = %q, want new", v)
+	}
+}
+
+func TestField(t *testing.T) {
+	api := &fakeAPI{values: map[string]string{
+		"partner": `{"client_id":"abc","client_secret":"xyz"}`,
+		"plain":   "xyz",
+	}}
+	c := &Cache{API: api, TTL: time.Hour}
+
+	if v, err := c.Field(context.Background(), "partner", "client_secret"); err != nil || v != "xyz" {
+		t.Errorf("Field = %q, %v; want xyz", v, err)
+	}
+	if _, err := c.Field(context.Background(), "partner", "nope"); err == nil {
+		t.Error("missing key: want error")
+	}
+	if _, err := c.Field(context.Background(), "plain", "client_secret"); err == nil {
+		t.Error("plain secret: want error")
+	}
+}
+
+func TestPreload(t *testing.T) {
+	api := &fakeAPI{values: map[string]string{"a": "1"}}
+	c := &Cache{API: api, TTL: time.Hour}
+	if err := c.Preload(context.Background(), "a", "b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Preload = %v, want ErrNotFound", err)
+	}
+	if v, _ := c.Get(context.Background(), "a"); v != "1" || api.reads != 2 {
+		t.Errorf("Get after Preload = %q after %d reads, want 1 after 2", v, api.reads)
+	}
+}
@@ -0,0 +1,378 @@
+// Package sessions keeps a server-side record of each sign-in (device, IP,
+// issue and expiry times) in the session table, keyed by user_id and
+// session_id, so users can see where they are signed in and revoke sessions.
+//
+// The session_id of a sign-in is the origin_jti claim Cognito puts in every
+// token refreshed from it. That is what lets the auth middleware reject the
+// tokens of a revoked session: see Store.Revoked and auth.WithRevocation.
+// Records expire through the table's TTL attribute, expires_at.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/accountstatus"
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/authz"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+)
+
+// Session statuses.
+const (
+	StatusActive  = "active"
+	StatusRevoked = "revoked"
+	StatusExpired = "expired"
+)
+
+// Session is a sign-in of a user, and the model of its item.
+type Session struct {
+	UserID    string     `json:"user_id" dynamodbav:"user_id"`
+	SessionID string     `json:"session_id" dynamodbav:"session_id"`
+	Device    string     `json:"device,omitempty" dynamodbav:"device,omitempty"`         // client-supplied name, e.g. "Jane's iPhone"
+	IP        string     `json:"ip,omitempty" dynamodbav:"ip,omitempty"`                 // source IP of the request that created the session
+	UserAgent string     `json:"user_agent,omitempty" dynamodbav:"user_agent,omitempty"` // User-Agent of that request
+	IssuedAt  time.Time  `json:"issued_at" dynamodbav:"issued_at"`
+	ExpiresAt time.Time  `json:"expires_at" dynamodbav:"expires_at,unixtime"` // the TTL attribute
+	RevokedAt *time.Time `json:"revoked_at,omitempty" dynamodbav:"revoked_at,omitempty"`
+	MovedTo   string     `json:"-" dynamodbav:"moved_to,omitempty"` // the account the session was moved to; see Store.Move
+}
+
+// Status returns whether the session is active, revoked or expired at now.
+func (s *Session) Status(now time.Time) string {
+	switch {
+	case s.RevokedAt != nil:
+		return StatusRevoked
+	case !now.Before(s.ExpiresAt):
+		return StatusExpired
+	}
+	return StatusActive
+}
+
+// NewID returns a random session ID, for sign-ins whose tokens carry no
+// origin_jti.
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sessionKey is the primary key of a session item.
+type sessionKey struct {
+	UserID    string `dynamodbav:"user_id"`
+	SessionID string `dynamodbav:"session_id"`
+}
+
+// Store reads and writes session records.
+type Store struct {
+	DB    *db.Client
+	Table string
+	TTL   time.Duration // lifetime of new sessions
+}
+
+// NewStore returns a store over the session table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.SessionTableName, TTL: cfg.SessionTTL}
+}
+
+// NewVerifier returns the Cognito token verifier for cfg, made to reject the
+// tokens of revoked sessions and of suspended or banned accounts, and to
+// fill in the roles granted in the role table.
+func NewVerifier(client *db.Client, cfg *config.Config) (auth.TokenVerifier, error) {
+	v, err := auth.NewVerifier(cfg)
+	if err != nil {
+		return nil, err
+	}
+	checked := auth.WithStatus(auth.WithRevocation(v, NewStore(client, cfg)), accountstatus.NewChecker(client, cfg))
+	return authz.WithRoles(checked, authz.NewStore(client, cfg)), nil
+}
+
+// Create records sess, stamping it as issued now and expiring after the
+// store's TTL. If the session already exists (a client retrying, or signing
+// in again from a refreshed token) the stored record is returned instead and
+// created is false.
+func (s *Store) Create(ctx context.Context, sess Session) (stored Session, created bool, err error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	sess.IssuedAt, sess.ExpiresAt, sess.RevokedAt = now, now.Add(s.TTL), nil
+
+	item, err := db.Encode(sess)
+	if err != nil {
+		return Session{}, false, err
+	}
+	expr, err := expression.NewBuilder().
+		WithCondition(expression.AttributeNotExists(expression.Name("session_id"))).
+		Build()
+	if err != nil {
+		return Session{}, false, fmt.Errorf("building session condition: %w", err)
+	}
+
+	start := time.Now()
+	_, err = s.DB.DynamoDB.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(s.Table),
+		Item:                     item,
+		ConditionExpression:      expr.Condition(),
+		ExpressionAttributeNames: expr.Names(),
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		existing, err := s.Get(ctx, sess.UserID, sess.SessionID)
+		if err != nil {
+			return Session{}, false, err
+		}
+		if existing == nil {
+			// Expired and deleted between the two calls
+			return Session{}, false, errors.New("session disappeared while being created")
+		}
+		return *existing, false, nil
+	}
+	if err != nil {
+		return Session{}, false, db.Wrap(err, "creating session")
+	}
+	return sess, true, nil
+}
+
+// Get returns the session, or nil if there is no such session. The read is
+// strongly consistent, so a revocation is seen as soon as it is written.
+func (s *Store) Get(ctx context.Context, userID, sessionID string) (*Session, error) {
+	start := time.Now()
+	result, err := s.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.Table),
+		Key:            key(userID, sessionID),
+		ConsistentRead: aws.Bool(true),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return nil, db.Wrap(err, "getting session")
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	sess, err := decode(result.Item)
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// ListActive returns the sessions of userID that are neither revoked nor
+// expired. DynamoDB deletes expired items lazily, so they are filtered here.
+func (s *Store) ListActive(ctx context.Context, userID string) ([]Session, error) {
+	now := time.Now()
+	return s.list(ctx, userID, func(sess Session) bool { return sess.Status(now) == StatusActive })
+}
+
+// list returns the sessions of userID that keep accepts.
+func (s *Store) list(ctx context.Context, userID string, keep func(Session) bool) ([]Session, error) {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key("user_id").Equal(expression.Value(userID))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("building session query: %w", err)
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(s.Table),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+
+	list := []Session{}
+	var decodeErr error
+	err = s.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		for _, item := range items {
+			var sess Session
+			if sess, decodeErr = decode(item); decodeErr != nil {
+				return false
+			}
+			if keep(sess) {
+				list = append(list, sess)
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Revoke marks the session revoked. The record is kept until it expires, so
+// its tokens stay rejected for as long as they could be refreshed. Revoking
+// twice keeps the first revocation time. A missing session is an
+// apperr.NotFound error.
+func (s *Store) Revoke(ctx context.Context, userID, sessionID string) error {
+	start := time.Now()
+	revokedAt := expression.Name("revoked_at")
+	expr, err := expression.NewBuilder().
+		WithUpdate(expression.Set(revokedAt, expression.IfNotExists(revokedAt, expression.Value(start.UTC().Truncate(time.Second))))).
+		WithCondition(expression.AttributeExists(expression.Name("session_id"))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("building session revocation: %w", err)
+	}
+	_, err = s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.Table),
+		Key:                       key(userID, sessionID),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		return apperr.NotFound("Session not found")
+	}
+	return db.Wrap(err, "revoking session")
+}
+
+// RevokeAll revokes every active session of userID and returns how many it
+// revoked. Sessions that disappear meanwhile are skipped.
+func (s *Store) RevokeAll(ctx context.Context, userID string) (int, error) {
+	active, err := s.ListActive(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, sess := range active {
+		err := s.Revoke(ctx, userID, sess.SessionID)
+		if apperr.KindOf(err) == apperr.KindNotFound {
+			continue
+		}
+		if err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// Conflicts of the move of a session to another account.
+var (
+	errSessionGone   = errors.New("session expired")
+	errSessionExists = errors.New("session exists on the surviving account")
+)
+
+// Move re-parents the sessions of from, a duplicate account merged into
+// to, and returns how many it moved and dropped. Each unexpired session is
+// copied to to, revoked, as history of where the user signed in; the record
+// of from is kept until it expires, revoked and marked moved, so the tokens
+// of the session stay rejected and a retry skips it. A session to has
+// already is only revoked, and dropped. With dryRun nothing is written.
+func (s *Store) Move(ctx context.Context, from, to string, dryRun bool) (moved, dropped int, err error) {
+	now := time.Now()
+	pending, err := s.list(ctx, from, func(sess Session) bool {
+		return sess.MovedTo == "" && sess.Status(now) != StatusExpired
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	revokedAt := now.UTC().Truncate(time.Second)
+	for _, sess := range pending {
+		if dryRun {
+			existing, err := s.Get(ctx, to, sess.SessionID)
+			if err != nil {
+				return moved, dropped, err
+			}
+			if existing != nil {
+				dropped++
+			} else {
+				moved++
+			}
+			continue
+		}
+
+		moving := sess
+		moving.UserID, moving.MovedTo = to, ""
+		if moving.RevokedAt == nil {
+			moving.RevokedAt = &revokedAt
+		}
+		item, err := db.Encode(moving)
+		if err != nil {
+			return moved, dropped, err
+		}
+		tombstone, err := s.tombstone(from, sess.SessionID, to, revokedAt)
+		if err != nil {
+			return moved, dropped, err
+		}
+		err = s.DB.Transact(ctx, db.Put(s.Table, item, "attribute_not_exists(session_id)", errSessionExists), tombstone)
+		if errors.Is(err, errSessionExists) {
+			dropped++
+			err = s.DB.Transact(ctx, tombstone)
+		} else if err == nil {
+			moved++
+		}
+		if errors.Is(err, errSessionGone) {
+			continue
+		}
+		if err != nil {
+			return moved, dropped, err
+		}
+	}
+	return moved, dropped, nil
+}
+
+// tombstone returns the update revoking the session of userID and marking
+// it moved to to.
+func (s *Store) tombstone(userID, sessionID, to string, now time.Time) (db.Write, error) {
+	revokedAt := expression.Name("revoked_at")
+	expr, err := expression.NewBuilder().
+		WithUpdate(expression.
+			Set(revokedAt, expression.IfNotExists(revokedAt, expression.Value(now))).
+			Set(expression.Name("moved_to"), expression.Value(to))).
+		WithCondition(expression.AttributeExists(expression.Name("session_id"))).
+		Build()
+	if err != nil {
+		return db.Write{}, fmt.Errorf("building session move: %w", err)
+	}
+	return db.Write{Item: types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(s.Table),
+		Key:                       key(userID, sessionID),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}}, Conflict: errSessionGone}, nil
+}
+
+// Revoked reports whether the session of id was revoked, implementing
+// auth.RevocationChecker. Tokens without a session ID, and sessions that
+// were never recorded, are not revoked.
+func (s *Store) Revoked(ctx context.Context, id *auth.Identity) (bool, error) {
+	if id.SessionID == "" {
+		return false, nil
+	}
+	sess, err := s.Get(ctx, id.Subject, id.SessionID)
+	if err != nil || sess == nil {
+		return false, err
+	}
+	return sess.RevokedAt != nil, nil
+}
+
+// decode returns the session of item. Expiry times, stored as Unix
+// seconds, are returned in UTC like the others.
+func decode(item db.Item) (Session, error) {
+	sess, err := db.Decode[Session](item)
+	sess.ExpiresAt = sess.ExpiresAt.UTC()
+	return sess, err
+}
+
+// key returns the primary key of a session item.
+func key(userID, sessionID string) db.Item {
+	return db.MustEncode(sessionKey{UserID: userID, SessionID: sessionID})
+}
@@ -0,0 +1,176 @@
+package sessions
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func TestStatus(t *testing.T) {
+	now := time.Now()
+	revoked := now.Add(-time.Minute)
+	tests := []struct {
+		name string
+		sess Session
+		want string
+	}{
+		{"active", Session{ExpiresAt: now.Add(time.Hour)}, StatusActive},
+		{"expired", Session{ExpiresAt: now}, StatusExpired},
+		{"revoked", Session{ExpiresAt: now.Add(time.Hour), RevokedAt: &revoked}, StatusRevoked},
+	}
+	for _, tt := range tests {
+		if got := tt.sess.Status(now); got != tt.want {
+			t.Errorf("%s: Status = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestModelRoundTrip(t *testing.T) {
+	issued := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	revoked := issued.Add(time.Hour)
+	sess := Session{
+		UserID:    "u1",
+		SessionID: "s1",
+		Device:    "Jane's iPhone",
+		IP:        "203.0.113.7",
+		UserAgent: "troggle/1.0",
+		IssuedAt:  issued,
+		ExpiresAt: issued.Add(30 * 24 * time.Hour),
+		RevokedAt: &revoked,
+	}
+	item, err := attributevalue.MarshalMap(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stored forms: RFC 3339 times, and Unix seconds for the TTL
+	if got := item["issued_at"].(*types.AttributeValueMemberS).Value; got != "2026-01-01T00:00:00Z" {
+		t.Errorf("issued_at = %s", got)
+	}
+	if got := item["expires_at"].(*types.AttributeValueMemberN).Value; got != "1769817600" {
+		t.Errorf("expires_at = %s", got)
+	}
+
+	got, err := decode(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, sess) {
+		t.Errorf("round trip = %+v, want %+v", got, sess)
+	}
+
+	key := key("u1", "s1")
+	if len(key) != 2 || key["session_id"].(*types.AttributeValueMemberS).Value != "s1" {
+		t.Errorf("key = %v", key)
+	}
+}
+
+// storedSession answers GetItem with sess.
+func storedSession(sess Session) func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		item, err := attributevalue.MarshalMap(sess)
+		return &dynamodb.GetItemOutput{Item: item}, err
+	}
+}
+
+func TestRevoked(t *testing.T) {
+	active := Session{UserID: "u1", SessionID: "s1", IssuedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), ExpiresAt: time.Now().Add(time.Hour)}
+	revoked := active
+	at := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	revoked.RevokedAt = &at
+
+	tests := []struct {
+		name string
+		id   *auth.Identity
+		get  func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+		want bool
+	}{
+		{name: "no session id", id: &auth.Identity{Subject: "u1"}, get: storedSession(revoked)},
+		{name: "not recorded", id: &auth.Identity{Subject: "u1", SessionID: "s1"}},
+		{name: "active", id: &auth.Identity{Subject: "u1", SessionID: "s1"}, get: storedSession(active)},
+		{name: "revoked", id: &auth.Identity{Subject: "u1", SessionID: "s1"}, get: storedSession(revoked), want: true},
+	}
+	for _, tt := range tests {
+		m := &dbtest.Mock{GetItemFunc: tt.get}
+		s := &Store{DB: m.Client(), Table: "sessions"}
+		got, err := s.Revoked(context.Background(), tt.id)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Revoked = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestCreateExisting(t *testing.T) {
+	m := &dbtest.Mock{
+		PutItemFunc: func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			return nil, dbtest.ConditionFailed()
+		},
+		GetItemFunc: storedSession(Session{UserID: "u1", SessionID: "s1", Device: "laptop", IssuedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}),
+	}
+	s := &Store{DB: m.Client(), Table: "sessions", TTL: time.Hour}
+
+	got, created, err := s.Create(context.Background(), Session{UserID: "u1", SessionID: "s1", Device: "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || got.Device != "laptop" {
+		t.Errorf("Create = %+v, created %v; want the stored session", got, created)
+	}
+}
+
+func TestMove(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	var items []map[string]types.AttributeValue
+	for _, sess := range []Session{
+		{UserID: "u1", SessionID: "s1", IssuedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{UserID: "u1", SessionID: "s2", IssuedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{UserID: "u1", SessionID: "s3", IssuedAt: now, ExpiresAt: now.Add(-time.Hour)},               // expired
+		{UserID: "u1", SessionID: "s4", IssuedAt: now, ExpiresAt: now.Add(time.Hour), MovedTo: "u2"}, // moved already
+	} {
+		item, err := attributevalue.MarshalMap(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+
+	var txs []*dynamodb.TransactWriteItemsInput
+	m := &dbtest.Mock{
+		QueryFunc: func(*dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			return &dynamodb.QueryOutput{Items: items}, nil
+		},
+		TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+			txs = append(txs, in)
+			if put := in.TransactItems[0].Put; put != nil && put.Item["session_id"].(*types.AttributeValueMemberS).Value == "s2" {
+				// u2 has the session already
+				return nil, dbtest.TransactionCanceled("ConditionalCheckFailed", "None")
+			}
+			return &dynamodb.TransactWriteItemsOutput{}, nil
+		},
+	}
+	s := &Store{DB: m.Client(), Table: "sessions"}
+	moved, dropped, err := s.Move(context.Background(), "u1", "u2", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 || dropped != 1 {
+		t.Errorf("moved %d, dropped %d; want 1 and 1", moved, dropped)
+	}
+	if len(txs) != 3 {
+		t.Fatalf("%d transactions, want s1 moved, s2 tried and only revoked", len(txs))
+	}
+	copied, err := decode(txs[0].TransactItems[0].Put.Item)
+	if err != nil || copied.UserID != "u2" || copied.RevokedAt == nil {
+		t.Errorf("copy = %+v, %v; want a revoked session of u2", copied, err)
+	}
+	if tombstone := txs[2].TransactItems; len(tombstone) != 1 || tombstone[0].Update == nil {
+		t.Errorf("transaction of the dropped session = %+v, want its tombstone only", tombstone)
+	}
+}
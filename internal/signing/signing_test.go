@@ -0,0 +1,45 @@
+package signing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSignOpen(t *testing.T) {
+	ctx := context.Background()
+	s := Signer{Keys: StaticKey("secret"), Secret: "test_key"}
+	token, err := s.Sign(ctx, []byte(`{"u":"u1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Open(ctx, token)
+	if err != nil || string(got) != `{"u":"u1"}` {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	other, err := Signer{Keys: StaticKey("other"), Secret: "test_key"}.Sign(ctx, []byte(`{"u":"u2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPayload, _, _ := strings.Cut(other, ".")
+	for name, token := range map[string]string{
+		"empty":           "",
+		"no signature":    payload,
+		"bad encoding":    payload + ".!!",
+		"swapped payload": otherPayload + "." + sig,
+		"other key":       other,
+		"truncated":       token[:len(token)-2],
+	} {
+		if _, err := s.Open(ctx, token); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: err = %v, want ErrInvalid", name, err)
+		}
+	}
+
+	empty := Signer{Keys: StaticKey(""), Secret: "test_key"}
+	if _, err := empty.Open(ctx, token); err == nil || errors.Is(err, ErrInvalid) {
+		t.Errorf("Open with an empty key: err = %v, want a key error", err)
+	}
+}
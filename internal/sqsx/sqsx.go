@@ -0,0 +1,151 @@
+// Package sqsx runs queue workers: it turns a function processing one typed
+// message into a Lambda handler for SQS event source mappings.
+//
+// The mapping must enable ReportBatchItemFailures. Messages whose processing
+// returns an error are reported as batch item failures and delivered again;
+// the rest of the batch is deleted. A message that can never be processed
+// (its body does not decode, or the worker wraps the error with Drop) is
+// logged and deleted instead of being retried into the dead-letter queue. A
+// panic fails only its own message.
+//
+// Every message is logged with its SQS message ID and receive count, and its
+// last failing delivery before SQS moves it to the dead-letter queue (per
+// QUEUE_MAX_RECEIVE_COUNT) is logged as an error, so an operator looking at
+// the DLQ finds why each message is there.
+package sqsx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+)
+
+// Message is a queue message with its body decoded.
+type Message[T any] struct {
+	ID           string
+	Body         T
+	ReceiveCount int // deliveries so far, including this one
+	Raw          events.SQSMessage
+}
+
+// First reports whether this is the first delivery of the message, as
+// opposed to a retry.
+func (m *Message[T]) First() bool {
+	return m.ReceiveCount <= 1
+}
+
+// ProcessFunc processes one message. Returning an error retries the message
+// unless the error is wrapped with Drop.
+type ProcessFunc[T any] func(ctx context.Context, msg *Message[T]) error
+
+// dropped marks an error retrying cannot fix.
+type dropped struct{ err error }
+
+func (d dropped) Error() string { return d.err.Error() }
+func (d dropped) Unwrap() error { return d.err }
+
+// Drop wraps err so the message that caused it is deleted rather than
+// delivered again.
+func Drop(err error) error {
+	if err == nil {
+		return nil
+	}
+	return dropped{err}
+}
+
+// Consumer processes the messages of one queue.
+type Consumer[T any] struct {
+	Process ProcessFunc[T]
+
+	// MaxReceiveCount is the maxReceiveCount of the queue's redrive policy;
+	// zero if unknown, which only loses the dead-letter log line.
+	MaxReceiveCount int
+}
+
+// Handle processes every message of the batch and reports the ones to
+// retry. It never returns an error: that would retry the whole batch.
+func (c *Consumer[T]) Handle(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	ctx, recorder := metrics.NewContext(ctx)
+	defer recorder.Flush()
+
+	var resp events.SQSEventResponse
+	failedGroups := map[string]bool{}
+	for _, raw := range event.Records {
+		msgCtx := logging.With(ctx, "sqs_message_id", raw.MessageId, "receive_count", receiveCount(raw))
+
+		// FIFO queues deliver a group in order: once one of its messages
+		// fails, the ones after it must wait for the retry too
+		group := raw.Attributes["MessageGroupId"]
+		if group != "" && failedGroups[group] {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: raw.MessageId})
+			continue
+		}
+
+		if err := c.handleOne(msgCtx, raw); err != nil {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: raw.MessageId})
+			if group != "" {
+				failedGroups[group] = true
+			}
+		}
+	}
+	return resp, nil
+}
+
+// handleOne decodes and processes raw, logging the outcome of a failure. It
+// returns an error only when raw should be delivered again.
+func (c *Consumer[T]) handleOne(ctx context.Context, raw events.SQSMessage) error {
+	msg := &Message[T]{ID: raw.MessageId, ReceiveCount: receiveCount(raw), Raw: raw}
+	if err := json.Unmarshal([]byte(raw.Body), &msg.Body); err != nil {
+		slog.ErrorContext(ctx, "Dropping malformed message", logging.Err(err))
+		metrics.Count(ctx, metrics.QueueMessageDropped)
+		return nil
+	}
+
+	err := c.run(ctx, msg)
+	var drop dropped
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &drop):
+		slog.ErrorContext(ctx, "Dropping message", logging.Err(err))
+		metrics.Count(ctx, metrics.QueueMessageDropped)
+		return nil
+	case c.MaxReceiveCount > 0 && msg.ReceiveCount >= c.MaxReceiveCount:
+		slog.ErrorContext(ctx, "Message failed its last delivery and moves to the dead-letter queue", logging.Err(err))
+		metrics.Count(ctx, metrics.QueueMessageDeadLettered)
+	default:
+		slog.WarnContext(ctx, "Message will be retried", logging.Err(err))
+		metrics.Count(ctx, metrics.QueueMessageRetried)
+	}
+	return err
+}
+
+// run calls Process, turning a panic into an error.
+func (c *Consumer[T]) run(ctx context.Context, msg *Message[T]) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "Message processing panicked", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+			metrics.Count(ctx, metrics.QueuePanic)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.Process(ctx, msg)
+}
+
+// receiveCount returns the ApproximateReceiveCount of raw; 1 if it is missing.
+func receiveCount(raw events.SQSMessage) int {
+	n, err := strconv.Atoi(raw.Attributes["ApproximateReceiveCount"])
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,128 @@
+package sqsx
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type job struct {
+	Action string `json:"action"`
+}
+
+// record is a message with body, its receive count and FIFO group.
+func record(id, body string, receives int, group string) events.SQSMessage {
+	attrs := map[string]string{"ApproximateReceiveCount": strconv.Itoa(receives)}
+	if group != "" {
+		attrs["MessageGroupId"] = group
+	}
+	return events.SQSMessage{MessageId: id, Body: body, Attributes: attrs}
+}
+
+func TestHandle(t *testing.T) {
+	process := func(_ context.Context, msg *Message[job]) error {
+		switch msg.Body.Action {
+		case "fail":
+			return errors.New("unavailable")
+		case "drop":
+			return Drop(errors.New("unknown user"))
+		case "panic":
+			panic("boom")
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name        string
+		records     []events.SQSMessage
+		wantFailed  []string
+		wantProcess []string // IDs passed to Process
+	}{
+		{
+			name:        "all succeed",
+			records:     []events.SQSMessage{record("1", `{"action":"ok"}`, 1, ""), record("2", `{"action":"ok"}`, 1, "")},
+			wantProcess: []string{"1", "2"},
+		},
+		{
+			name:        "only failures are retried",
+			records:     []events.SQSMessage{record("1", `{"action":"fail"}`, 1, ""), record("2", `{"action":"ok"}`, 1, "")},
+			wantFailed:  []string{"1"},
+			wantProcess: []string{"1", "2"},
+		},
+		{
+			name:        "malformed and dropped messages are deleted",
+			records:     []events.SQSMessage{record("1", `{`, 1, ""), record("2", `{"action":"drop"}`, 1, "")},
+			wantProcess: []string{"2"},
+		},
+		{
+			name:        "a panic fails its own message",
+			records:     []events.SQSMessage{record("1", `{"action":"panic"}`, 1, ""), record("2", `{"action":"ok"}`, 1, "")},
+			wantFailed:  []string{"1"},
+			wantProcess: []string{"1", "2"},
+		},
+		{
+			name:        "last delivery still reported",
+			records:     []events.SQSMessage{record("1", `{"action":"fail"}`, 5, "")},
+			wantFailed:  []string{"1"},
+			wantProcess: []string{"1"},
+		},
+		{
+			name: "fifo group stops at its first failure",
+			records: []events.SQSMessage{
+				record("1", `{"action":"fail"}`, 1, "a"),
+				record("2", `{"action":"ok"}`, 1, "b"),
+				record("3", `{"action":"ok"}`, 1, "a"),
+			},
+			wantFailed:  []string{"1", "3"},
+			wantProcess: []string{"1", "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var processed []string
+			c := &Consumer[job]{
+				Process: func(ctx context.Context, msg *Message[job]) error {
+					processed = append(processed, msg.ID)
+					return process(ctx, msg)
+				},
+				MaxReceiveCount: 5,
+			}
+
+			resp, err := c.Handle(context.Background(), events.SQSEvent{Records: tt.records})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var failed []string
+			for _, f := range resp.BatchItemFailures {
+				failed = append(failed, f.ItemIdentifier)
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) {
+				t.Errorf("failures = %v, want %v", failed, tt.wantFailed)
+			}
+			if !reflect.DeepEqual(processed, tt.wantProcess) {
+				t.Errorf("processed = %v, want %v", processed, tt.wantProcess)
+			}
+		})
+	}
+}
+
+func TestFirst(t *testing.T) {
+	var got []bool
+	c := &Consumer[job]{Process: func(_ context.Context, msg *Message[job]) error {
+		got = append(got, msg.First())
+		return nil
+	}}
+	unset := events.SQSMessage{MessageId: "3", Body: `{}`}
+	if _, err := c.Handle(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+		record("1", `{}`, 1, ""), record("2", `{}`, 2, ""), unset,
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, false, true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("First = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+// Package streams dispatches the records of a DynamoDB stream to
+// projections: code keeping some derived data (counters, the audit log, a
+// search index) in sync with a table.
+//
+// Records of a shard arrive in order. The dispatcher applies each record to
+// every projection before moving to the next one and stops at the first
+// failure, reporting that record's sequence number as the batch item
+// failure: Lambda then checkpoints the records before it and restarts the
+// shard iterator at the failed one, so no projection ever sees a shard's
+// changes out of order. A record can therefore reach a projection more than
+// once, and projections must tolerate that. The event source mapping must
+// enable ReportBatchItemFailures and should set a maximum record age and
+// retry count, so a record that keeps failing cannot block its shard until
+// the stream's 24 hours of retention run out.
+//
+// Aggregators sum values (counter deltas) over the records instead of writing
+// for each one. Their running totals travel in the tumbling window state when
+// the mapping sets a window, and are written once at the end of the window;
+// without a window they are written at the end of every batch.
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+)
+
+// Change actions.
+const (
+	Insert = "INSERT"
+	Modify = "MODIFY"
+	Remove = "REMOVE"
+)
+
+// staleAge is the record age past which the dispatcher warns that the shard
+// is falling behind: the stream keeps records for 24 hours only.
+const staleAge = 12 * time.Hour
+
+// Change is a decoded stream record.
+type Change struct {
+	EventID        string
+	Action         string // Insert, Modify or Remove
+	SequenceNumber string
+	At             time.Time // approximate time of the change
+	Keys           db.Item
+	Old            db.Item // nil for Insert, or if the stream has no old images
+	New            db.Item // nil for Remove
+
+	// Expired is set on removals by the table's TTL rather than a caller.
+	Expired bool
+}
+
+// Attr returns the string attribute name of item; "" if item has no such
+// string attribute.
+func Attr(item db.Item, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+// Changed returns the sorted names of the attributes that differ between
+// the old and the new image.
+func (c *Change) Changed() []string {
+	var names []string
+	for name, v := range c.New {
+		if !reflect.DeepEqual(v, c.Old[name]) {
+			names = append(names, name)
+		}
+	}
+	for name := range c.Old {
+		if _, ok := c.New[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Projection keeps derived data in sync with the changes of a table.
+type Projection interface {
+	Name() string
+	Apply(ctx context.Context, c *Change) error
+}
+
+// Aggregator is a projection summing values over a window of changes.
+type Aggregator interface {
+	Name() string
+
+	// Aggregate adds the contribution of c to totals.
+	Aggregate(c *Change, totals map[string]int64)
+
+	// Flush writes totals, the sums of a window or batch.
+	Flush(ctx context.Context, totals map[string]int64) error
+}
+
+// Dispatcher routes the records of a stream to its projections.
+type Dispatcher struct {
+	projections []Projection
+	aggregators []Aggregator
+
+	// Skip, if set, filters out changes no projection cares about (such as
+	// the email reservation items of the user table).
+	Skip func(c *Change) bool
+}
+
+// Register adds a projection; they run in the order registered.
+func (d *Dispatcher) Register(p Projection) {
+	d.projections = append(d.projections, p)
+}
+
+// RegisterAggregator adds an aggregator.
+func (d *Dispatcher) RegisterAggregator(a Aggregator) {
+	d.aggregators = append(d.aggregators, a)
+}
+
+// Handle applies the records of event. It accepts both plain stream events
+// and tumbling window events, which carry the same records.
+func (d *Dispatcher) Handle(ctx context.Context, event events.DynamoDBTimeWindowEvent) (events.DynamoDBTimeWindowEventResponse, error) {
+	ctx, recorder := metrics.NewContext(ctx)
+	defer recorder.Flush()
+
+	totals := d.decodeState(ctx, event.State)
+	var resp events.DynamoDBTimeWindowEventResponse
+	for _, rec := range event.Records {
+		seq := rec.Change.SequenceNumber
+		recCtx := logging.With(ctx, "event_id", rec.EventID, "sequence_number", seq)
+		if err := d.apply(recCtx, rec, totals); err != nil {
+			slog.ErrorContext(recCtx, "Stream record failed; the shard resumes from it", logging.Err(err))
+			metrics.Count(recCtx, metrics.StreamRecordFailed)
+			resp.BatchItemFailures = []events.DynamoDBBatchItemFailure{{ItemIdentifier: seq}}
+			break
+		}
+	}
+
+	// A failure is retried with the state returned here, which holds the
+	// totals of the records before it only
+	windowed := !event.Window.Start.IsZero()
+	if windowed && !event.IsFinalInvokeForWindow {
+		resp.State = encodeState(totals)
+		return resp, nil
+	}
+	if err := d.flush(ctx, totals); err != nil {
+		return events.DynamoDBTimeWindowEventResponse{}, err
+	}
+	return resp, nil
+}
+
+// apply decodes rec and applies it to every projection and aggregator.
+func (d *Dispatcher) apply(ctx context.Context, rec events.DynamoDBEventRecord, totals map[string]map[string]int64) error {
+	c, err := Decode(rec)
+	if err != nil {
+		// Retrying cannot fix a record that does not decode
+		slog.ErrorContext(ctx, "Skipping undecodable stream record", logging.Err(err))
+		return nil
+	}
+	if !c.At.IsZero() {
+		age := time.Since(c.At)
+		metrics.Add(ctx, metrics.StreamRecordAge, float64(age.Milliseconds()), metrics.UnitMilliseconds)
+		if age > staleAge {
+			slog.WarnContext(ctx, "Stream processing is falling behind", "age_minutes", int(age.Minutes()))
+		}
+	}
+	if d.Skip != nil && d.Skip(c) {
+		return nil
+	}
+
+	for _, p := range d.projections {
+		if err := p.Apply(ctx, c); err != nil {
+			return fmt.Errorf("projection %s: %w", p.Name(), err)
+		}
+	}
+	for _, a := range d.aggregators {
+		t := totals[a.Name()]
+		if t == nil {
+			t = map[string]int64{}
+			totals[a.Name()] = t
+		}
+		a.Aggregate(c, t)
+	}
+	return nil
+}
+
+// flush writes the totals of every aggregator.
+func (d *Dispatcher) flush(ctx context.Context, totals map[string]map[string]int64) error {
+	var errs []error
+	for _, a := range d.aggregators {
+		t := totals[a.Name()]
+		for k, v := range t {
+			if v == 0 {
+				delete(t, k)
+			}
+		}
+		if len(t) == 0 {
+			continue
+		}
+		if err := a.Flush(ctx, t); err != nil {
+			errs = append(errs, fmt.Errorf("aggregator %s: %w", a.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeState reads the window state, whose keys are "<aggregator>/<key>".
+func (d *Dispatcher) decodeState(ctx context.Context, state map[string]string) map[string]map[string]int64 {
+	totals := map[string]map[string]int64{}
+	for k, v := range state {
+		name, key, ok := strings.Cut(k, "/")
+		n, err := strconv.ParseInt(v, 10, 64)
+		if !ok || err != nil {
+			slog.WarnContext(ctx, "Ignoring invalid window state", "key", k)
+			continue
+		}
+		if totals[name] == nil {
+			totals[name] = map[string]int64{}
+		}
+		totals[name][key] = n
+	}
+	return totals
+}
+
+// encodeState is the inverse of decodeState.
+func encodeState(totals map[string]map[string]int64) map[string]string {
+	state := map[string]string{}
+	for name, t := range totals {
+		for key, n := range t {
+			state[name+"/"+key] = strconv.FormatInt(n, 10)
+		}
+	}
+	return state
+}
+
+// Decode converts a stream record to a Change.
+func Decode(rec events.DynamoDBEventRecord) (*Change, error) {
+	c := &Change{
+		EventID:        rec.EventID,
+		Action:         rec.EventName,
+		SequenceNumber: rec.Change.SequenceNumber,
+		At:             rec.Change.ApproximateCreationDateTime.Time,
+		Keys:           item(rec.Change.Keys),
+		Old:            item(rec.Change.OldImage),
+		New:            item(rec.Change.NewImage),
+	}
+	switch c.Action {
+	case Insert, Modify:
+		if c.New == nil {
+			return nil, fmt.Errorf("%s record without a new image; the stream must include new images", c.Action)
+		}
+	case Remove:
+	default:
+		return nil, fmt.Errorf("unknown stream action %q", c.Action)
+	}
+	if id := rec.UserIdentity; id != nil && id.Type == "Service" && id.PrincipalID == "dynamodb.amazonaws.com" {
+		c.Expired = true
+	}
+	return c, nil
+}
+
+// item converts a stream image to the SDK's attribute values; nil stays nil.
+func item(image map[string]events.DynamoDBAttributeValue) db.Item {
+	if image == nil {
+		return nil
+	}
+	out := make(db.Item, len(image))
+	for k, v := range image {
+		out[k] = attributeValue(v)
+	}
+	return out
+}
+
+func attributeValue(v events.DynamoDBAttributeValue) types.AttributeValue {
+	switch v.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: v.String()}
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: v.Number()}
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: v.Boolean()}
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: v.Binary()}
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: v.StringSet()}
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: v.NumberSet()}
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: v.BinarySet()}
+	case events.DataTypeList:
+		list := v.List()
+		out := make([]types.AttributeValue, len(list))
+		for i, e := range list {
+			out[i] = attributeValue(e)
+		}
+		return &types.AttributeValueMemberL{Value: out}
+	case events.DataTypeMap:
+		return &types.AttributeValueMemberM{Value: item(v.Map())}
+	}
+	return &types.AttributeValueMemberNULL{Value: true}
+}
@@ -0,0 +1,180 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// rec is a stream record changing the user id from status old to status new;
+// an empty status means no image.
+func rec(seq, action, id, old, new string) events.DynamoDBEventRecord {
+	image := func(status string) map[string]events.DynamoDBAttributeValue {
+		if status == "" {
+			return nil
+		}
+		return map[string]events.DynamoDBAttributeValue{
+			"user_id": events.NewStringAttribute(id),
+			"status":  events.NewStringAttribute(status),
+		}
+	}
+	return events.DynamoDBEventRecord{
+		EventID:   "ev" + seq,
+		EventName: action,
+		Change: events.DynamoDBStreamRecord{
+			SequenceNumber:              seq,
+			ApproximateCreationDateTime: events.SecondsEpochTime{Time: time.Now()},
+			Keys:                        map[string]events.DynamoDBAttributeValue{"user_id": events.NewStringAttribute(id)},
+			OldImage:                    image(old),
+			NewImage:                    image(new),
+		},
+	}
+}
+
+// recorder is a projection recording the users it saw, failing for fail.
+type recorder struct {
+	seen []string
+	fail string
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Apply(_ context.Context, c *Change) error {
+	id := Attr(c.Keys, "user_id")
+	if id == r.fail {
+		return errors.New("unavailable")
+	}
+	r.seen = append(r.seen, id)
+	return nil
+}
+
+// counter counts changes by action.
+type counter struct {
+	flushed []map[string]int64
+}
+
+func (c *counter) Name() string { return "count" }
+
+func (c *counter) Aggregate(ch *Change, totals map[string]int64) { totals[ch.Action]++ }
+
+func (c *counter) Flush(_ context.Context, totals map[string]int64) error {
+	c.flushed = append(c.flushed, totals)
+	return nil
+}
+
+func TestHandle(t *testing.T) {
+	window := events.Window{Start: events.RFC3339EpochTime{Time: time.Now().Add(-time.Minute)}, End: events.RFC3339EpochTime{Time: time.Now()}}
+	tests := []struct {
+		name        string
+		event       events.DynamoDBTimeWindowEvent
+		fail        string
+		skip        string
+		wantSeen    []string
+		wantFailed  string
+		wantState   map[string]string
+		wantFlushed []map[string]int64
+	}{
+		{
+			name:        "batch without a window flushes",
+			event:       batch(rec("1", Insert, "u1", "", "active"), rec("2", Modify, "u1", "active", "banned")),
+			wantSeen:    []string{"u1", "u1"},
+			wantFlushed: []map[string]int64{{Insert: 1, Modify: 1}},
+		},
+		{
+			name:        "stops at the first failure",
+			event:       batch(rec("1", Insert, "u1", "", "active"), rec("2", Insert, "u2", "", "active"), rec("3", Insert, "u3", "", "active")),
+			fail:        "u2",
+			wantSeen:    []string{"u1"},
+			wantFailed:  "2",
+			wantFlushed: []map[string]int64{{Insert: 1}},
+		},
+		{
+			name: "window state carries totals",
+			event: events.DynamoDBTimeWindowEvent{
+				DynamoDBEvent:        events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{rec("1", Remove, "u1", "active", "")}},
+				TimeWindowProperties: events.TimeWindowProperties{Window: window, State: map[string]string{"count/REMOVE": "2"}},
+			},
+			wantSeen:  []string{"u1"},
+			wantState: map[string]string{"count/REMOVE": "3"},
+		},
+		{
+			name: "final invoke of a window flushes",
+			event: events.DynamoDBTimeWindowEvent{
+				TimeWindowProperties: events.TimeWindowProperties{Window: window, State: map[string]string{"count/INSERT": "4"}, IsFinalInvokeForWindow: true},
+			},
+			wantFlushed: []map[string]int64{{Insert: 4}},
+		},
+		{
+			name:        "skipped and undecodable records",
+			event:       batch(rec("1", Insert, "lock", "", "active"), rec("2", Insert, "u2", "", ""), rec("3", "UNKNOWN", "u3", "", "active")),
+			skip:        "lock",
+			wantFlushed: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &recorder{fail: tt.fail}
+			c := &counter{}
+			d := &Dispatcher{Skip: func(c *Change) bool { return Attr(c.Keys, "user_id") == tt.skip }}
+			d.Register(p)
+			d.RegisterAggregator(c)
+
+			resp, err := d.Handle(context.Background(), tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.seen, tt.wantSeen) {
+				t.Errorf("seen = %v, want %v", p.seen, tt.wantSeen)
+			}
+			var failed string
+			if len(resp.BatchItemFailures) > 0 {
+				failed = resp.BatchItemFailures[0].ItemIdentifier
+			}
+			if failed != tt.wantFailed {
+				t.Errorf("failed = %q, want %q", failed, tt.wantFailed)
+			}
+			if len(resp.State)+len(tt.wantState) > 0 && !reflect.DeepEqual(resp.State, tt.wantState) {
+				t.Errorf("state = %v, want %v", resp.State, tt.wantState)
+			}
+			if !reflect.DeepEqual(c.flushed, tt.wantFlushed) {
+				t.Errorf("flushed = %v, want %v", c.flushed, tt.wantFlushed)
+			}
+		})
+	}
+}
+
+func batch(records ...events.DynamoDBEventRecord) events.DynamoDBTimeWindowEvent {
+	return events.DynamoDBTimeWindowEvent{DynamoDBEvent: events.DynamoDBEvent{Records: records}}
+}
+
+func TestDecode(t *testing.T) {
+	r := rec("1", Modify, "u1", "active", "banned")
+	r.Change.NewImage["tags"] = events.NewListAttribute([]events.DynamoDBAttributeValue{
+		events.NewNumberAttribute("7"),
+		events.NewMapAttribute(map[string]events.DynamoDBAttributeValue{"ok": events.NewBooleanAttribute(true)}),
+	})
+	r.UserIdentity = &events.DynamoDBUserIdentity{Type: "Service", PrincipalID: "dynamodb.amazonaws.com"}
+
+	c, err := Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &types.AttributeValueMemberL{Value: []types.AttributeValue{
+		&types.AttributeValueMemberN{Value: "7"},
+		&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"ok": &types.AttributeValueMemberBOOL{Value: true}}},
+	}}
+	if !reflect.DeepEqual(c.New["tags"], want) {
+		t.Errorf("tags = %#v", c.New["tags"])
+	}
+	if got := c.Changed(); !reflect.DeepEqual(got, []string{"status", "tags"}) {
+		t.Errorf("Changed = %v", got)
+	}
+	if !c.Expired {
+		t.Error("TTL removal not marked expired")
+	}
+}
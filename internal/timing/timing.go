@@ -0,0 +1,26 @@
+// Package timing hides from callers how long an endpoint's work took, so
+// response times cannot tell them apart, e.g. whether an email address has
+// an account.
+package timing
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Pad sleeps until at least minLatency plus a random jitter below maxJitter
+// has passed since start, or until ctx is done.
+func Pad(ctx context.Context, start time.Time, minLatency, maxJitter time.Duration) {
+	target := minLatency + rand.N(maxJitter)
+	wait := target - time.Since(start)
+	if wait <= 0 {
+		return
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
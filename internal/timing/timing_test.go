@@ -0,0 +1,38 @@
+package timing
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPad(t *testing.T) {
+	const minLatency, jitter = 30 * time.Millisecond, 20 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration // before Pad is called
+		cancel  bool
+		wantMin time.Duration // total, from start
+		wantMax time.Duration
+	}{
+		{name: "fast answer padded", wantMin: minLatency, wantMax: minLatency + jitter + 50*time.Millisecond},
+		{name: "slow answer not padded", elapsed: minLatency + jitter, wantMin: minLatency + jitter, wantMax: minLatency + jitter + 50*time.Millisecond},
+		{name: "canceled", cancel: true, wantMax: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			start := time.Now().Add(-tt.elapsed)
+
+			Pad(ctx, start, minLatency, jitter)
+			if took := time.Since(start); took < tt.wantMin || took > tt.wantMax {
+				t.Errorf("took %v, want between %v and %v", took, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Package tracing wires AWS X-Ray into the troggle Lambdas.
+//
+// Every SDK client built from awscfg is instrumented, so DynamoDB, Cognito
+// and EventBridge calls appear as subsegments automatically. Handlers and the
+// db helpers add their own subsegments (Capture) with searchable annotations
+// (Annotate) such as table name, index name and result count.
+//
+// Outside Lambda there is no trace context; the SDK is configured to ignore
+// that rather than log an error on every call. Set AWS_XRAY_SDK_DISABLED=true
+// to turn tracing off entirely.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
+	"github.com/aws/aws-xray-sdk-go/strategy/ctxmissing"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// init configures the recorder before any segment can be created.
+func init() {
+	_ = xray.Configure(xray.Config{
+		ContextMissingStrategy: ctxmissing.NewDefaultIgnoreErrorStrategy(),
+	})
+}
+
+// InstrumentAWS adds the X-Ray middleware to every client built from cfg.
+func InstrumentAWS(cfg *aws.Config) {
+	awsv2.AWSV2Instrumentor(&cfg.APIOptions)
+}
+
+// Capture runs fn inside a subsegment named name. The subsegment records the
+// error fn returns, and fn's context carries it so nested calls and
+// annotations attach to it.
+func Capture(ctx context.Context, name string, fn func(context.Context) error) error {
+	return xray.Capture(ctx, name, fn)
+}
+
+// Annotate adds an indexed annotation to the current subsegment. It is a
+// no-op when ctx carries no subsegment (the Lambda facade segment itself
+// cannot be annotated).
+func Annotate(ctx context.Context, key string, value any) {
+	_ = xray.AddAnnotation(ctx, key, value)
+}
+
+// HTTPClient wraps c (or http.DefaultClient when nil) so outgoing requests
+// are traced as remote calls.
+func HTTPClient(c *http.Client) *http.Client {
+	return xray.Client(c)
+}
@@ -0,0 +1,314 @@
+// Package userdata is the single-table layout of the entities that belong
+// to a user: the profile, sessions, preferences, push devices and
+// relationship edges, which live in tables of their own until they are
+// moved, and the second factors of package mfa, the invitations of package
+// invitations and the policy acceptances of package policies, which were
+// born here. Every entity of a user shares
+// the partition key
+//
+//	pk = USER#<user_id>
+//
+// and is told apart by a sort key starting with the prefix of its type:
+//
+//	PROFILE                   the user record
+//	PREFERENCES               the preference document
+//	SESSION#<session_id>      a sign-in
+//	DEVICE#<token>            a push device
+//	EDGE#<TYPE>#<other_id>    a relationship edge, e.g. EDGE#FRIEND#u2
+//	MFA#<type>                a second factor, e.g. MFA#totp
+//	INVITE#<invitation_id>    an invitation the user sent
+//	POLICY#<policy>#<version> a policy version the user accepted
+//
+// so one query reads everything about a user, or every entity of a type
+// with begins_with on the sort key. Items also name their entity type, and
+// keep the attributes of the models they hold, user_id included, so models
+// decode from them unchanged.
+//
+// Backfill copies the rows of the separate tables into the layout; the
+// migrateuserdata command runs it.
+package userdata
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/users"
+)
+
+// Attributes of the layout.
+const (
+	PartitionKey = "pk"
+	SortKey      = "sk"
+	EntityType   = "entity"
+)
+
+// Entity types, and the sort keys or sort key prefixes of their items.
+const (
+	Profile     = "profile"
+	Preferences = "preferences"
+	Session     = "session"
+	Device      = "device"
+	Edge        = "edge"
+	MFAFactor   = "mfa_factor"
+	Invitation  = "invitation"
+	Acceptance  = "policy_acceptance"
+
+	userPrefix    = "USER#"
+	ProfileSK     = "PROFILE"
+	PreferencesSK = "PREFERENCES"
+	SessionPrefix = "SESSION#"
+	DevicePrefix  = "DEVICE#"
+	EdgePrefix    = "EDGE#"
+	MFAPrefix     = "MFA#"
+	InvitePrefix  = "INVITE#"
+	PolicyPrefix  = "POLICY#"
+)
+
+// PK returns the partition key of the entities of userID.
+func PK(userID string) string {
+	return userPrefix + userID
+}
+
+// SessionSK, DeviceSK, EdgeSK, MFASK and InviteSK return the sort keys of a
+// session, a device, a relationship edge, a second factor and an
+// invitation; edge is the edge as the relationship table keys it, e.g.
+// FRIEND#u2.
+func SessionSK(sessionID string) string   { return SessionPrefix + sessionID }
+func DeviceSK(token string) string        { return DevicePrefix + token }
+func EdgeSK(edge string) string           { return EdgePrefix + edge }
+func MFASK(factorType string) string      { return MFAPrefix + factorType }
+func InviteSK(invitationID string) string { return InvitePrefix + invitationID }
+
+// AcceptanceSK returns the sort key of the acceptance of a version of
+// policy. Versions are zero-padded, so a user's acceptances of a policy
+// sort oldest first.
+func AcceptanceSK(policy string, version int64) string {
+	return fmt.Sprintf("%s%s#%06d", PolicyPrefix, policy, version)
+}
+
+// header holds the attributes of the layout that every item carries.
+type header struct {
+	PK     string `dynamodbav:"pk"`
+	SK     string `dynamodbav:"sk"`
+	Entity string `dynamodbav:"entity,omitempty"`
+}
+
+// Key returns the primary key of the entity of userID at sk.
+func Key(userID, sk string) db.Item {
+	return db.MustEncode(header{PK: PK(userID), SK: sk})
+}
+
+// Marshal returns the item holding model as the entity of type entity of
+// userID at sk: the attributes of the model, with those of the layout.
+func Marshal(entity, userID, sk string, model any) (db.Item, error) {
+	item, err := db.Encode(model)
+	if err != nil {
+		return nil, err
+	}
+	return withHeader(item, entity, userID, sk), nil
+}
+
+// Unmarshal decodes the model of type T that item holds as an entity of
+// type entity. The attributes of the layout are left out, unless T has
+// fields for them.
+func Unmarshal[T any](item db.Item, entity string) (T, error) {
+	if got := EntityOf(item); got != entity {
+		var zero T
+		return zero, fmt.Errorf("decoding %s: item holds a %q entity", entity, got)
+	}
+	return db.Decode[T](item)
+}
+
+// EntityOf returns the entity type of item, or "" for an item that is not
+// of the layout.
+func EntityOf(item db.Item) string {
+	h, _ := db.Decode[header](item)
+	return h.Entity
+}
+
+// withHeader adds the attributes of the layout to item, in place.
+func withHeader(item db.Item, entity, userID, sk string) db.Item {
+	maps.Copy(item, db.MustEncode(header{PK: PK(userID), SK: sk, Entity: entity}))
+	return item
+}
+
+// Store reads and writes the single table.
+type Store struct {
+	DB    *db.Client
+	Table string
+}
+
+// NewStore returns a store over the table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{DB: client, Table: cfg.UserDataTableName}
+}
+
+// Put writes model as the entity of type entity of userID at sk, replacing
+// what is there.
+func (s *Store) Put(ctx context.Context, entity, userID, sk string, model any) error {
+	item, err := Marshal(entity, userID, sk, model)
+	if err != nil {
+		return err
+	}
+	return s.DB.PutItem(ctx, s.Table, item)
+}
+
+// Get returns the item of the entity of userID at sk, or nil if there is
+// none.
+func (s *Store) Get(ctx context.Context, userID, sk string) (db.Item, error) {
+	return s.DB.GetItem(ctx, s.Table, Key(userID, sk))
+}
+
+// Delete removes the entity of userID at sk, if any.
+func (s *Store) Delete(ctx context.Context, userID, sk string) error {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.Table),
+		Key:       Key(userID, sk),
+	})
+	db.Observe(ctx, start, err)
+	return db.Wrap(err, "deleting user data")
+}
+
+// List returns the items of userID whose sort key starts with prefix, in
+// sort key order: every entity of the user for "", every session for
+// SessionPrefix.
+func (s *Store) List(ctx context.Context, userID, prefix string) ([]db.Item, error) {
+	cond := expression.Key(PartitionKey).Equal(expression.Value(PK(userID)))
+	if prefix != "" {
+		cond = cond.And(expression.Key(SortKey).BeginsWith(prefix))
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(cond).Build()
+	if err != nil {
+		return nil, fmt.Errorf("building user data query: %w", err)
+	}
+	var items []db.Item
+	err = s.DB.QueryPages(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(s.Table),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}, func(page []db.Item) bool {
+		items = append(items, page...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Source is a table whose rows Backfill moves into the layout.
+type Source struct {
+	Name  string // names the source in flags and logs
+	Table string
+
+	// convert returns the entity type, user and sort key of row, and false
+	// for rows that are not entities of a user.
+	convert func(row db.Item) (entity, userID, sk string, ok bool)
+}
+
+// Sources returns the tables named in cfg that Backfill can move, in the
+// order it moves them.
+func Sources(cfg *config.Config) []Source {
+	return []Source{
+		{Name: "users", Table: cfg.UserTableName, convert: func(row db.Item) (string, string, string, bool) {
+			userID := str(row, "user_id")
+			// Email and username reservations stay with the user table
+			return Profile, userID, ProfileSK, userID != "" && !users.IsLock(userID)
+		}},
+		{Name: "sessions", Table: cfg.SessionTableName, convert: func(row db.Item) (string, string, string, bool) {
+			return sorted(row, Session, "session_id", SessionSK)
+		}},
+		{Name: "preferences", Table: cfg.PreferenceTableName, convert: func(row db.Item) (string, string, string, bool) {
+			userID := str(row, "user_id")
+			return Preferences, userID, PreferencesSK, userID != ""
+		}},
+		{Name: "devices", Table: cfg.DeviceTableName, convert: func(row db.Item) (string, string, string, bool) {
+			return sorted(row, Device, "token", DeviceSK)
+		}},
+		{Name: "relationships", Table: cfg.RelationshipTableName, convert: func(row db.Item) (string, string, string, bool) {
+			return sorted(row, Edge, "edge", EdgeSK)
+		}},
+	}
+}
+
+// sorted converts a row of a table keyed by user_id and the sort key
+// attribute name, whose value sk turns into the sort key of the layout.
+func sorted(row db.Item, entity, name string, sk func(string) string) (string, string, string, bool) {
+	userID, sort := str(row, "user_id"), str(row, name)
+	return entity, userID, sk(sort), userID != "" && sort != ""
+}
+
+// Convert returns the item of the layout holding row of src, with every
+// attribute of the row kept, and false for rows that are not entities of a
+// user.
+func (src Source) Convert(row db.Item) (db.Item, bool) {
+	entity, userID, sk, ok := src.convert(row)
+	if !ok {
+		return nil, false
+	}
+	item := make(db.Item, len(row)+3)
+	for name, v := range row {
+		item[name] = v
+	}
+	return withHeader(item, entity, userID, sk), true
+}
+
+// Stats counts the rows of one source a Backfill read and wrote; the rows
+// that are not entities of a user are read but not written.
+type Stats struct {
+	Source  string
+	Read    int
+	Written int
+}
+
+// Backfill copies every row of src into the table of s, reading the
+// source with segments parallel scans, and returns what it did. Rows are
+// written as they are, replacing what is there, so running it again, or
+// after it failed half-way, converges on the same items. With dryRun it
+// only counts.
+//
+// Rows written to the source while it runs may be missed: run it again
+// once writers have moved to the layout.
+func (s *Store) Backfill(ctx context.Context, src Source, segments int, dryRun bool) (Stats, error) {
+	stats := Stats{Source: src.Name}
+	var mu sync.Mutex
+	err := s.DB.ScanSegments(ctx, &dynamodb.ScanInput{TableName: aws.String(src.Table)}, segments, func(ctx context.Context, rows []db.Item) error {
+		items := make([]db.Item, 0, len(rows))
+		for _, row := range rows {
+			if item, ok := src.Convert(row); ok {
+				items = append(items, item)
+			}
+		}
+		if !dryRun {
+			if err := s.DB.BatchPut(ctx, s.Table, items); err != nil {
+				return fmt.Errorf("backfilling %s: %w", src.Name, err)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		stats.Read += len(rows)
+		stats.Written += len(items)
+		return nil
+	})
+	return stats, err
+}
+
+// str returns the string attribute name of item, or "".
+func str(item db.Item, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
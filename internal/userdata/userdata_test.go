@@ -0,0 +1,193 @@
+package userdata
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/users"
+)
+
+var cfg = &config.Config{
+	UserTableName:         "users",
+	SessionTableName:      "sessions",
+	PreferenceTableName:   "preferences",
+	DeviceTableName:       "devices",
+	RelationshipTableName: "relationships",
+	UserDataTableName:     "user_data",
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	user := users.User{UserID: "u1", Email: "jane@example.com", DisplayName: "Jane", Status: "active", Version: 2}
+	item, err := Marshal(Profile, "u1", ProfileSK, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk, sk := str(item, PartitionKey), str(item, SortKey); pk != "USER#u1" || sk != "PROFILE" {
+		t.Errorf("key = %s, %s", pk, sk)
+	}
+	if got := EntityOf(item); got != Profile {
+		t.Errorf("EntityOf = %q, want %q", got, Profile)
+	}
+
+	got, err := Unmarshal[users.User](item, Profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != user {
+		t.Errorf("Unmarshal = %+v, want %+v", got, user)
+	}
+
+	if _, err := Unmarshal[users.User](item, Session); err == nil {
+		t.Error("decoding a profile as a session succeeded")
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	for _, tc := range []struct{ got, want string }{
+		{SessionSK("s1"), "SESSION#s1"},
+		{DeviceSK("tok"), "DEVICE#tok"},
+		{EdgeSK("FRIEND#u2"), "EDGE#FRIEND#u2"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("sort key = %q, want %q", tc.got, tc.want)
+		}
+	}
+	if key := Key("u1", ProfileSK); len(key) != 2 || str(key, PartitionKey) != "USER#u1" {
+		t.Errorf("Key = %v", key)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	sources := map[string]Source{}
+	for _, src := range Sources(cfg) {
+		sources[src.Name] = src
+	}
+
+	cases := []struct {
+		source     string
+		row        db.Item
+		entity, sk string
+		skipped    bool
+	}{
+		{source: "users", row: dbtest.Item("user_id", "u1", "email", "jane@example.com"), entity: Profile, sk: "PROFILE"},
+		{source: "users", row: dbtest.Item("user_id", users.EmailLockPrefix+"jane@example.com", "owner", "u1"), skipped: true},
+		{source: "sessions", row: dbtest.Item("user_id", "u1", "session_id", "s1"), entity: Session, sk: "SESSION#s1"},
+		{source: "preferences", row: dbtest.Item("user_id", "u1", "theme", "dark"), entity: Preferences, sk: "PREFERENCES"},
+		{source: "devices", row: dbtest.Item("user_id", "u1", "token", "tok"), entity: Device, sk: "DEVICE#tok"},
+		{source: "relationships", row: dbtest.Item("user_id", "u1", "edge", "FRIEND#u2"), entity: Edge, sk: "EDGE#FRIEND#u2"},
+		{source: "relationships", row: dbtest.Item("edge", "FRIEND#u2"), skipped: true},
+	}
+	for _, tc := range cases {
+		item, ok := sources[tc.source].Convert(tc.row)
+		if ok == tc.skipped {
+			t.Errorf("%s %v: converted = %v", tc.source, tc.row, ok)
+			continue
+		}
+		if tc.skipped {
+			continue
+		}
+		if str(item, PartitionKey) != "USER#u1" || str(item, SortKey) != tc.sk || EntityOf(item) != tc.entity {
+			t.Errorf("%s: item = %v", tc.source, item)
+		}
+		for name := range tc.row {
+			if _, ok := item[name]; !ok {
+				t.Errorf("%s: attribute %s dropped", tc.source, name)
+			}
+		}
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	rows := []db.Item{
+		dbtest.Item("user_id", "u1", "session_id", "s1"),
+		dbtest.Item("user_id", "u1", "session_id", "s2"),
+		dbtest.Item("user_id", "u2", "session_id", "s3"),
+		dbtest.Item("session_id", "orphan"),
+	}
+	var sessions Source
+	for _, src := range Sources(cfg) {
+		if src.Name == "sessions" {
+			sessions = src
+		}
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		var (
+			mu      sync.Mutex
+			written []db.Item
+		)
+		m := &dbtest.Mock{
+			ScanFunc: func(in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+				if aws.ToString(in.TableName) != "sessions" {
+					t.Errorf("scanned %s", aws.ToString(in.TableName))
+				}
+				// Every row lands in the first of the segments
+				if aws.ToInt32(in.Segment) != 0 {
+					return &dynamodb.ScanOutput{}, nil
+				}
+				return &dynamodb.ScanOutput{Items: rows}, nil
+			},
+			BatchWriteItemFunc: func(in *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				for _, req := range in.RequestItems["user_data"] {
+					written = append(written, req.PutRequest.Item)
+				}
+				return &dynamodb.BatchWriteItemOutput{}, nil
+			},
+		}
+		store := NewStore(m.Client(), cfg)
+
+		stats, err := store.Backfill(context.Background(), sessions, 2, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats != (Stats{Source: "sessions", Read: 4, Written: 3}) {
+			t.Errorf("dryRun=%v: stats = %+v", dryRun, stats)
+		}
+		want := 3
+		if dryRun {
+			want = 0
+		}
+		if len(written) != want {
+			t.Errorf("dryRun=%v: wrote %d items, want %d", dryRun, len(written), want)
+		}
+		for _, item := range written {
+			if EntityOf(item) != Session {
+				t.Errorf("wrote %v", item)
+			}
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	var input *dynamodb.QueryInput
+	m := &dbtest.Mock{QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+		input = in
+		return &dynamodb.QueryOutput{Items: []db.Item{Key("u1", SessionSK("s1"))}}, nil
+	}}
+	items, err := NewStore(m.Client(), cfg).List(context.Background(), "u1", SessionPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Errorf("List returned %d items", len(items))
+	}
+	var prefix bool
+	for _, v := range input.ExpressionAttributeValues {
+		if s, ok := v.(*types.AttributeValueMemberS); ok && s.Value == SessionPrefix {
+			prefix = true
+		}
+	}
+	if aws.ToString(input.TableName) != "user_data" || !prefix {
+		t.Errorf("query = %s %v", aws.ToString(input.TableName), input.ExpressionAttributeValues)
+	}
+}
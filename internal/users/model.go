@@ -0,0 +1,47 @@
+package users
+
+import "troggle-backend/internal/db"
+
+// User is the model of a user record. Records carry more attributes than
+// these, such as the relationship counters and the marks of soft deletion,
+// each written by the package that owns it; Get returns the whole item.
+type User struct {
+	UserID        string `dynamodbav:"user_id"`
+	Email         string `dynamodbav:"email,omitempty"` // moved to deleted_email by soft deletion
+	EmailVerified bool   `dynamodbav:"email_verified"`  // the user proved they read Email's mailbox; see package verification
+	Username      string `dynamodbav:"username,omitempty"`
+	DisplayName   string `dynamodbav:"display_name"`
+	Bio           string `dynamodbav:"bio"`
+	AvatarURL     string `dynamodbav:"avatar_url"`
+	Status        string `dynamodbav:"status"`
+	CreatedAt     string `dynamodbav:"created_at"` // RFC 3339
+	UpdatedAt     string `dynamodbav:"updated_at"` // RFC 3339
+	Version       int    `dynamodbav:"version"`
+
+	SchemaVersion int `dynamodbav:"schema_version,omitempty"` // see Schema
+
+	DeletedAt    string `dynamodbav:"deleted_at,omitempty"` // see DeletedAttribute
+	DeletedEmail string `dynamodbav:"deleted_email,omitempty"`
+
+	LegacyID   string `dynamodbav:"legacy_id,omitempty"`   // account in the legacy system the user was migrated from
+	MigratedAt string `dynamodbav:"migrated_at,omitempty"` // RFC 3339
+}
+
+// Lock is the model of a reservation sentinel: of an email, keyed on
+// EmailLockPrefix, or of a username, keyed on UsernameLockPrefix and the
+// skeleton of the username it holds.
+type Lock struct {
+	UserID   string `dynamodbav:"user_id"`
+	Owner    string `dynamodbav:"owner,omitempty"`
+	Username string `dynamodbav:"username,omitempty"`
+}
+
+// userKey is the primary key of an item of the user table.
+type userKey struct {
+	UserID string `dynamodbav:"user_id"`
+}
+
+// Key returns the primary key of the user item with the given user_id.
+func Key(userID string) db.Item {
+	return db.MustEncode(userKey{UserID: userID})
+}
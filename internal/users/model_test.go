@@ -0,0 +1,128 @@
+package users
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+)
+
+func TestUserRoundTrip(t *testing.T) {
+	user := User{
+		UserID:      "u1",
+		Email:       "jane@example.com",
+		Username:    "jane",
+		DisplayName: "Jane",
+		Bio:         "hello",
+		Status:      "active",
+		CreatedAt:   "2026-10-14T12:00:00Z",
+		UpdatedAt:   "2026-10-14T12:00:00Z",
+		Version:     2,
+	}
+	item, err := db.Encode(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := item["version"].(*types.AttributeValueMemberN); !ok {
+		t.Errorf("version = %#v, want a number", item["version"])
+	}
+	// An empty avatar is stored, unlike the deletion marks
+	if _, ok := item["avatar_url"]; !ok {
+		t.Error("avatar_url left out")
+	}
+	if _, ok := item[DeletedAttribute]; ok {
+		t.Errorf("%s stored while unset", DeletedAttribute)
+	}
+	got, err := db.Decode[User](item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != user {
+		t.Errorf("round trip = %+v, want %+v", got, user)
+	}
+
+	// Soft deletion moves the email aside; the email index must not see ""
+	item, _ = db.Encode(User{UserID: "u1", DeletedEmail: "jane@example.com", DeletedAt: "2026-10-15T12:00:00Z"})
+	if _, ok := item["email"]; ok {
+		t.Error("empty email stored")
+	}
+}
+
+func TestLockRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		item db.Item
+		want Lock
+	}{
+		{
+			name: "email",
+			item: EmailLock("jane@example.com", "u1"),
+			want: Lock{UserID: EmailLockPrefix + "jane@example.com", Owner: "u1"},
+		},
+		{
+			name: "username",
+			item: UsernameLock("jane", "u1"),
+			want: Lock{UserID: UsernameLockPrefix + "jane", Owner: "u1", Username: "jane"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.Decode[Lock](tt.item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("lock = %+v, want %+v", got, tt.want)
+			}
+			if !IsLock(got.UserID) {
+				t.Errorf("IsLock(%q) = false", got.UserID)
+			}
+		})
+	}
+	if got := Key("u1"); !reflect.DeepEqual(got, db.Item{"user_id": &types.AttributeValueMemberS{Value: "u1"}}) {
+		t.Errorf("Key = %v", got)
+	}
+}
+
+func TestVersionConflict(t *testing.T) {
+	current, _ := db.Encode(User{UserID: "u1", Bio: "hello", DisplayName: "Jane", Version: 3})
+	err := VersionConflict(current, map[string]string{"bio": "hi", "display_name": "Jane", "website": "x"})
+
+	e := apperr.As(err)
+	if e == nil || e.Current == nil {
+		t.Fatalf("err = %v, want a conflict with the current state", err)
+	}
+	want := &apperr.Current{Version: 3, Changed: map[string]any{"bio": "hello", "website": nil}}
+	if !reflect.DeepEqual(e.Current, want) {
+		t.Errorf("current = %+v, want %+v", e.Current, want)
+	}
+	if err := VersionConflict(nil, nil); err != ErrVersionConflict {
+		t.Errorf("without an item: err = %v", err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	item := db.Item{
+		"user_id":    &types.AttributeValueMemberS{Value: "u1"},
+		"created_at": &types.AttributeValueMemberS{Value: "2024-01-01T00:00:00Z"},
+	}
+	if ok, err := Schema.Upgrade(item); !ok || err != nil {
+		t.Fatalf("Upgrade = %v, %v", ok, err)
+	}
+	user, err := db.Decode[User](item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Status != "active" || user.UpdatedAt != user.CreatedAt || !user.EmailVerified || user.SchemaVersion != Schema.Current() {
+		t.Errorf("upgraded = %+v", user)
+	}
+
+	// Reservations share the table but are not user records
+	lock := EmailLock("jane@example.com", "u1")
+	if ok, _ := Schema.Upgrade(lock); ok {
+		t.Errorf("upgraded the email reservation to %v", lock)
+	}
+}
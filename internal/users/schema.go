@@ -0,0 +1,44 @@
+package users
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/migration"
+)
+
+// Schema is the history of the shape of user records. Create writes
+// records at its current version, and Get and GetMany upgrade the whole
+// records they read to it.
+var Schema = &migration.Schema{
+	Name: "users",
+	Key:  []string{"user_id"},
+	Of: func(item db.Item) bool {
+		key, err := db.Decode[userKey](item)
+		return err == nil && key.UserID != "" && !IsLock(key.UserID)
+	},
+	Steps: []migration.Step{
+		// 1: records from before accounts had a status, or an update time,
+		// get the ones new records start with
+		func(item db.Item) error {
+			if _, ok := item["status"]; !ok {
+				item["status"] = &types.AttributeValueMemberS{Value: "active"}
+			}
+			if _, ok := item["updated_at"]; !ok {
+				if created, ok := item["created_at"]; ok {
+					item["updated_at"] = created
+				}
+			}
+			return nil
+		},
+		// 2: records from before email verification was tracked count as
+		// verified, as they were treated until then; nearly all are of
+		// sign-ups Cognito confirmed by email
+		func(item db.Item) error {
+			if _, ok := item["email_verified"]; !ok {
+				item["email_verified"] = &types.AttributeValueMemberBOOL{Value: true}
+			}
+			return nil
+		},
+	},
+}
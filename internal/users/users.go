@@ -0,0 +1,452 @@
+// Package users is the data-access layer of the user table. The table is
+// keyed on user_id, the Cognito sub, and indexed on email; every lookup by
+// either key goes through Repository so the access patterns live in one
+// place.
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/logging"
+	"troggle-backend/internal/metrics"
+	"troggle-backend/internal/migration"
+	"troggle-backend/internal/validation"
+)
+
+// SensitiveAttributes are never returned to callers, even when requested
+// explicitly.
+var SensitiveAttributes = map[string]bool{
+	"phone_number":  true,
+	"last_login_ip": true,
+	"mfa_secret":    true,
+	"legacy_id":     true,
+
+	"provider_subjects": true, // see package providers
+	"status_reason":     true, // see package accountstatus
+	"status_note":       true,
+	"referred_by":       true, // see package invitations
+	"referred_at":       true,
+	"referral_count":    true,
+	DeletedAttribute:    true, // see package erasure
+	"purge_at":          true,
+	"deleted_email":     true,
+	"previous_status":   true,
+}
+
+// EmailLockPrefix prefixes the user_id of the sentinel item that reserves an
+// email address. createUser writes it in the same transaction as the user
+// record. Sentinels carry no email attribute, so they never show up in the
+// email GSI.
+const EmailLockPrefix = "EMAIL#"
+
+// UsernameLockPrefix prefixes the user_id of the sentinel item that reserves
+// a username. The sentinel is keyed on the username's skeleton, so handles
+// that only differ by look-alike characters share one reservation, and it is
+// written in the same transaction as the profile update setting the name.
+const UsernameLockPrefix = "UNAME#"
+
+// DeletedAttribute marks a soft-deleted item with the time of its deletion;
+// see package erasure. Read paths treat marked items as missing.
+const DeletedAttribute = "deleted_at"
+
+// Repository reads user records by primary key or email.
+type Repository struct {
+	DB         *db.Client
+	Table      string
+	EmailIndex string    // GSI keyed on email, projecting at least user_id
+	Cache      *db.Cache // serves repeated Get and IDByEmail calls; nil disables caching
+
+	// IncludeDeleted makes Get return soft-deleted records too
+	IncludeDeleted bool
+}
+
+// NewRepository returns a repository over the user table named in cfg,
+// backed by the container-wide cache.
+func NewRepository(client *db.Client, cfg *config.Config) *Repository {
+	return &Repository{
+		DB:         client,
+		Table:      cfg.UserTableName,
+		EmailIndex: cfg.EmailIndexName,
+		Cache:      db.SharedCache(cfg),
+	}
+}
+
+// Uncached returns a copy of r that always reads DynamoDB. Writers use it
+// for the reads their decisions depend on.
+func (r *Repository) Uncached() *Repository {
+	c := *r
+	c.Cache = nil
+	return &c
+}
+
+// WithDeleted returns a copy of r that also reads soft-deleted records. The
+// erasure uses it for the records it purges.
+func (r *Repository) WithDeleted() *Repository {
+	c := *r
+	c.IncludeDeleted = true
+	return &c
+}
+
+// Invalidate drops what the cache holds for the user and their email, either
+// of which may be empty. Every mutation of a user record calls it.
+func (r *Repository) Invalidate(userID, email string) {
+	var keys []string
+	if userID != "" {
+		keys = append(keys, userCacheKey(userID))
+	}
+	if email != "" {
+		keys = append(keys, emailCacheKey(email))
+	}
+	r.Cache.Invalidate(keys...)
+}
+
+// userCacheKey and emailCacheKey name the cache entries of Get and
+// IDByEmail.
+func userCacheKey(userID string) string { return "user:" + userID }
+func emailCacheKey(email string) string { return "email:" + email }
+
+// EmailLockKey returns the primary key of the sentinel reserving email.
+func EmailLockKey(email string) db.Item {
+	return Key(EmailLockPrefix + email)
+}
+
+// UsernameLockKey returns the primary key of the sentinel reserving username,
+// which must already be normalized.
+func UsernameLockKey(username string) db.Item {
+	return Key(usernameLockID(username))
+}
+
+// usernameLockID returns the user_id of the sentinel reserving username.
+func usernameLockID(username string) string {
+	return UsernameLockPrefix + validation.UsernameSkeleton(username)
+}
+
+// EmailLock returns the sentinel reserving email for userID.
+func EmailLock(email, userID string) db.Item {
+	return db.MustEncode(Lock{UserID: EmailLockPrefix + email, Owner: userID})
+}
+
+// UsernameLock returns the sentinel reserving username for userID.
+func UsernameLock(username, userID string) db.Item {
+	return db.MustEncode(Lock{
+		UserID:   usernameLockID(username),
+		Owner:    userID,
+		Username: username,
+	})
+}
+
+// IsLock reports whether userID is the key of a reservation sentinel rather
+// than of a user.
+func IsLock(userID string) bool {
+	return strings.HasPrefix(userID, EmailLockPrefix) || strings.HasPrefix(userID, UsernameLockPrefix)
+}
+
+// Get fetches the record of the user whose Cognito sub is userID, limited to
+// fields when it is non-empty. Returns nil if there is no such user, or if
+// the user is soft deleted and r does not include deleted records.
+//
+// With a cache, whole records are cached and fields are picked from them: a
+// projection costs the same read capacity as the full item. Whole records are
+// upgraded to the current version of Schema before they are cached, so
+// callers only see the current shape.
+func (r *Repository) Get(ctx context.Context, userID string, fields []string) (db.Item, error) {
+	if r.Cache == nil {
+		return r.get(ctx, userID, fields)
+	}
+	if cached, ok := r.Cache.Get(ctx, userCacheKey(userID)); ok {
+		if r.hidden(cached.(db.Item)) {
+			return nil, nil
+		}
+		return pick(cached.(db.Item), fields), nil
+	}
+	item, err := r.get(ctx, userID, nil)
+	if err != nil || item == nil {
+		// Missing users are not cached so a new record shows up at once
+		return nil, err
+	}
+	r.Cache.Set(userCacheKey(userID), item)
+	return pick(item, fields), nil
+}
+
+// GetMany fetches the records of the users whose Cognito subs are userIDs,
+// limited to fields when it is non-empty, with batched reads. The result is
+// keyed on user_id and leaves out the users Get would return nil for; whole
+// records are upgraded as Get upgrades them.
+func (r *Repository) GetMany(ctx context.Context, userIDs []string, fields []string) (map[string]db.Item, error) {
+	out := make(map[string]db.Item, len(userIDs))
+	var keys []db.Item
+	for _, id := range userIDs {
+		if r.Cache != nil {
+			if cached, ok := r.Cache.Get(ctx, userCacheKey(id)); ok {
+				if !r.hidden(cached.(db.Item)) {
+					out[id] = pick(cached.(db.Item), fields)
+				}
+				continue
+			}
+		}
+		keys = append(keys, Key(id))
+	}
+
+	var attributes []string
+	if r.Cache == nil && len(fields) > 0 {
+		// The key tells the items apart, the deletion mark soft-deleted ones
+		attributes = slices.Clip(fields)
+		for _, a := range []string{"user_id", DeletedAttribute} {
+			if !slices.Contains(attributes, a) {
+				attributes = append(attributes, a)
+			}
+		}
+	}
+	items, err := r.DB.BatchGet(ctx, r.Table, keys, attributes...)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		key, err := db.Decode[userKey](item)
+		if err != nil {
+			return nil, err
+		}
+		if attributes == nil {
+			if _, err := Schema.Upgrade(item); err != nil {
+				return nil, err
+			}
+		}
+		id := key.UserID
+		if r.Cache != nil {
+			r.Cache.Set(userCacheKey(id), item)
+		}
+		if !r.hidden(item) {
+			out[id] = pick(item, fields)
+		}
+	}
+	return out, nil
+}
+
+// get reads the record from the table, upgraded to the current version of
+// Schema unless it is limited to fields.
+func (r *Repository) get(ctx context.Context, userID string, fields []string) (db.Item, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(r.Table),
+		Key:       Key(userID),
+	}
+	marked := len(fields) == 0 || slices.Contains(fields, DeletedAttribute)
+	if len(fields) > 0 {
+		// The deletion mark is read along to tell soft-deleted records apart
+		if !marked {
+			fields = append(slices.Clip(fields), DeletedAttribute)
+		}
+		expr, err := db.Projection(fields...)
+		if err != nil {
+			return nil, err
+		}
+		input.ProjectionExpression, input.ExpressionAttributeNames = expr.Projection(), expr.Names()
+	}
+
+	start := time.Now()
+	result, err := r.DB.DynamoDB.GetItem(ctx, input)
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return nil, db.Wrap(err, "getting user "+userID)
+	}
+	if result.Item == nil || r.hidden(result.Item) {
+		return nil, nil
+	}
+	if !marked {
+		delete(result.Item, DeletedAttribute)
+	}
+	if len(fields) == 0 {
+		// Steps may need any attribute: projections are returned as stored
+		if _, err := Schema.Upgrade(result.Item); err != nil {
+			return nil, err
+		}
+	}
+	return result.Item, nil
+}
+
+// hidden reports whether item is soft deleted and r leaves such records out.
+func (r *Repository) hidden(item db.Item) bool {
+	_, deleted := item[DeletedAttribute]
+	return deleted && !r.IncludeDeleted
+}
+
+// IDByEmail returns the user_id registered with email, or "" if there is
+// none. It is the fast path: it asks the index for a single entry and only
+// follows further pages while they come back empty. email must already be
+// normalized.
+//
+// Answers are cached, misses included: signup retries ask about the same
+// unregistered email over and over. A user created in another container
+// may therefore read as missing until the entry expires; ReservedBy is the
+// authoritative check.
+func (r *Repository) IDByEmail(ctx context.Context, email string) (string, error) {
+	if cached, ok := r.Cache.Get(ctx, emailCacheKey(email)); ok {
+		return cached.(string), nil
+	}
+	ids, err := r.idsByEmail(ctx, email, 1)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	if len(ids) > 0 {
+		id = ids[0]
+	}
+	r.Cache.Set(emailCacheKey(email), id)
+	return id, nil
+}
+
+// IDsByEmail returns the user_id of every record registered with email,
+// reading every page of the index. Emails are unique, so more than one
+// record is a data-integrity problem: it is logged and counted as
+// metrics.DuplicateEmail, and the caller decides what else to do.
+func (r *Repository) IDsByEmail(ctx context.Context, email string) ([]string, error) {
+	ids, err := r.idsByEmail(ctx, email, 0)
+	if err == nil && len(ids) > 1 {
+		slog.WarnContext(ctx, "Duplicate email records", logging.EmailHash(email), "user_ids", ids)
+		metrics.Count(ctx, metrics.DuplicateEmail)
+	}
+	return ids, err
+}
+
+// idsByEmail queries the email index until it has collected limit user_ids
+// (0 for all of them) or has read the last page.
+func (r *Repository) idsByEmail(ctx context.Context, email string, limit int) ([]string, error) {
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(expression.Key("email").Equal(expression.Value(email))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("building email query: %w", err)
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(r.Table),
+		IndexName:                 aws.String(r.EmailIndex),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int32(int32(limit))
+	}
+
+	var ids []string
+	var decodeErr error
+	err = r.DB.QueryPages(ctx, input, func(items []db.Item) bool {
+		for _, item := range items {
+			key, err := db.Decode[userKey](item)
+			if err == nil && key.UserID == "" {
+				err = errors.New("email index entry has no user_id")
+			}
+			if err != nil {
+				decodeErr = err
+				return false
+			}
+			ids = append(ids, key.UserID)
+		}
+		return limit == 0 || len(ids) < limit
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// ReservedBy returns the user_id holding the reservation of email, or "" if
+// there is none. Unlike the index lookups it is a strongly consistent read of
+// the table itself, so it sees a user created a moment ago: GSIs are only
+// eventually consistent. email must already be normalized.
+func (r *Repository) ReservedBy(ctx context.Context, email string) (string, error) {
+	return r.lockOwner(ctx, EmailLockKey(email), "email")
+}
+
+// UsernameOwner returns the user_id holding username, or of any handle
+// confusable with it, or "" if it is free. username must already be
+// normalized.
+func (r *Repository) UsernameOwner(ctx context.Context, username string) (string, error) {
+	return r.lockOwner(ctx, UsernameLockKey(username), "username")
+}
+
+// lockOwner reads the owner of the sentinel at key with a strongly
+// consistent read.
+func (r *Repository) lockOwner(ctx context.Context, key db.Item, what string) (string, error) {
+	expr, err := db.Projection("owner")
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	result, err := r.DB.DynamoDB.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:                aws.String(r.Table),
+		Key:                      key,
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     expr.Projection(),
+		ExpressionAttributeNames: expr.Names(),
+	})
+	db.Observe(ctx, start, err)
+	if err != nil {
+		return "", db.Wrap(err, "reading "+what+" reservation")
+	}
+	lock, err := db.Decode[Lock](result.Item)
+	if err != nil {
+		return "", err
+	}
+	return lock.Owner, nil
+}
+
+// GetByEmail resolves email through the index and then reads the record by
+// primary key, since the index does not project every attribute. Returns nil
+// if there is no such user.
+func (r *Repository) GetByEmail(ctx context.Context, email string, fields []string) (db.Item, error) {
+	userID, err := r.IDByEmail(ctx, email)
+	if err != nil || userID == "" {
+		return nil, err
+	}
+	return r.Get(ctx, userID, fields)
+}
+
+// Profile converts a user item to plain JSON-friendly values, removing
+// sensitive attributes and the schema version, which is bookkeeping.
+func Profile(item db.Item) (map[string]any, error) {
+	var profile map[string]any
+	if err := attributevalue.UnmarshalMap(item, &profile); err != nil {
+		return nil, fmt.Errorf("decoding user item: %w", err)
+	}
+	for attr := range SensitiveAttributes {
+		delete(profile, attr)
+	}
+	delete(profile, migration.VersionAttribute)
+	return profile, nil
+}
+
+// pick returns the attributes of item named in fields, or item itself when
+// fields is empty. Attributes missing from item are left out, as a
+// ProjectionExpression would.
+func pick(item db.Item, fields []string) db.Item {
+	if len(fields) == 0 {
+		return item
+	}
+	out := make(db.Item, len(fields))
+	for _, f := range fields {
+		if v, ok := item[f]; ok {
+			out[f] = v
+		}
+	}
+	return out
+}
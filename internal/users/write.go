@@ -0,0 +1,244 @@
+package users
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/db"
+)
+
+// Conflicts of the writes of a user record with its reservations.
+var (
+	ErrUserExists = &apperr.Error{Kind: apperr.KindConflict, Code: "USER_EXISTS", Message: "User already exists"}
+	ErrEmailTaken = &apperr.Error{Kind: apperr.KindConflict, Code: "EMAIL_TAKEN", Message: "Email already registered"}
+
+	// ErrUsernameTaken is also returned for a username confusable with one
+	// that is taken: reservations are keyed on the skeleton.
+	ErrUsernameTaken = &apperr.Error{Kind: apperr.KindConflict, Code: "USERNAME_TAKEN", Message: "Username is taken"}
+
+	// ErrVersionConflict is returned, with the current state as
+	// VersionConflict builds it, when a write was based on an older version
+	// of the record than the stored one, or the user does not exist.
+	ErrVersionConflict = &apperr.Error{Kind: apperr.KindConflict, Code: "VERSION_CONFLICT", Message: "Profile was modified by another request; reload and retry"}
+
+	// ErrEmailChanged is returned by ChangeEmail when the record no longer
+	// has the email the change was requested from, or the user is gone.
+	ErrEmailChanged = &apperr.Error{Kind: apperr.KindConflict, Code: "EMAIL_CHANGED", Message: "The email address changed since this change was requested"}
+
+	// ErrEmailVerified is returned by VerifyEmail when the address was
+	// verified already.
+	ErrEmailVerified = &apperr.Error{Kind: apperr.KindConflict, Code: "EMAIL_ALREADY_VERIFIED", Message: "The email address is already verified"}
+)
+
+// notExists is the condition of puts that must not overwrite an item.
+const notExists = "attribute_not_exists(user_id)"
+
+// Create writes the record of user in one transaction with the
+// reservations of its email and, if it has one, its username. It fails with
+// ErrUserExists, ErrEmailTaken or ErrUsernameTaken, writing nothing, when
+// any of them is already there, so two sign-ups racing for an email cannot
+// both get it. The record is written at the current version of Schema.
+func (r *Repository) Create(ctx context.Context, user User, extra ...db.Write) error {
+	user.SchemaVersion = Schema.Current()
+	item, err := db.Encode(user)
+	if err != nil {
+		return err
+	}
+	writes := []db.Write{
+		db.Put(r.Table, item, notExists, ErrUserExists),
+		db.Put(r.Table, EmailLock(user.Email, user.UserID), notExists, ErrEmailTaken),
+	}
+	if user.Username != "" {
+		writes = append(writes, db.Put(r.Table, UsernameLock(user.Username, user.UserID), notExists, ErrUsernameTaken))
+	}
+	if err := r.DB.Transact(ctx, append(writes, extra...)...); err != nil {
+		return err
+	}
+	r.Invalidate(user.UserID, user.Email)
+	return nil
+}
+
+// ChangeEmail moves the record of userID from oldEmail to newEmail in one
+// transaction with the reservations: newEmail's is taken and oldEmail's
+// released, so two users confirming the same address at once cannot both
+// get it. It fails with ErrEmailChanged when the record is not at oldEmail
+// anymore, or with ErrEmailTaken, writing nothing. The new address is
+// marked verified: the change is only confirmed from its mailbox. It returns
+// the version of the record after the change.
+func (r *Repository) ChangeEmail(ctx context.Context, userID, oldEmail, newEmail string, now time.Time) (int, error) {
+	// Transactions cannot return the new version, so the record is read
+	// first; the version condition makes sure it is still the one written
+	current, err := r.Uncached().get(ctx, userID, []string{"email", "version"})
+	if err != nil {
+		return 0, err
+	}
+	if current == nil {
+		return 0, ErrEmailChanged
+	}
+	user, err := db.Decode[User](current)
+	if err != nil {
+		return 0, err
+	}
+	if user.Email != oldEmail {
+		return 0, ErrEmailChanged
+	}
+
+	condition := "email = :old AND #version = :version"
+	values := map[string]types.AttributeValue{
+		":old":        &types.AttributeValueMemberS{Value: oldEmail},
+		":new":        &types.AttributeValueMemberS{Value: newEmail},
+		":verified":   &types.AttributeValueMemberBOOL{Value: true},
+		":updated_at": &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		":next":       &types.AttributeValueMemberN{Value: strconv.Itoa(user.Version + 1)},
+	}
+	if user.Version == 0 {
+		// Records written before versioning have no version attribute
+		condition = "email = :old AND attribute_not_exists(#version)"
+	} else {
+		values[":version"] = &types.AttributeValueMemberN{Value: strconv.Itoa(user.Version)}
+	}
+	owned := map[string]types.AttributeValue{":me": &types.AttributeValueMemberS{Value: userID}}
+	err = r.DB.Transact(ctx,
+		db.Write{
+			Item: types.TransactWriteItem{Update: &types.Update{
+				TableName:                 aws.String(r.Table),
+				Key:                       Key(userID),
+				UpdateExpression:          aws.String("SET email = :new, email_verified = :verified, updated_at = :updated_at, #version = :next"),
+				ConditionExpression:       aws.String(condition + " AND attribute_not_exists(" + DeletedAttribute + ")"),
+				ExpressionAttributeNames:  map[string]string{"#version": "version"},
+				ExpressionAttributeValues: values,
+			}},
+			Conflict: ErrEmailChanged,
+		},
+		db.Write{
+			Item: types.TransactWriteItem{Put: &types.Put{
+				TableName:                 aws.String(r.Table),
+				Item:                      EmailLock(newEmail, userID),
+				ConditionExpression:       aws.String(notExists + " OR #owner = :me"),
+				ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+				ExpressionAttributeValues: owned,
+			}},
+			Conflict: ErrEmailTaken,
+		},
+		db.Write{Item: types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(r.Table),
+			Key:                       EmailLockKey(oldEmail),
+			ConditionExpression:       aws.String(notExists + " OR #owner = :me"),
+			ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+			ExpressionAttributeValues: owned,
+		}}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	r.Invalidate(userID, oldEmail)
+	r.Invalidate(userID, newEmail)
+	return user.Version + 1, nil
+}
+
+// VerifyEmail marks email, the address of the record of userID, verified.
+// It fails with ErrEmailVerified when it is already, and with
+// ErrEmailChanged when the record is not at email anymore or the user is
+// gone. Records without email_verified count as verified (see Schema). It
+// returns the version of the record after the change.
+func (r *Repository) VerifyEmail(ctx context.Context, userID, email string, now time.Time) (int, error) {
+	start := time.Now()
+	out, err := r.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(r.Table),
+		Key:                      Key(userID),
+		UpdateExpression:         aws.String("SET email_verified = :verified, updated_at = :updated_at ADD #version :one"),
+		ConditionExpression:      aws.String("email = :email AND email_verified = :unverified AND attribute_not_exists(" + DeletedAttribute + ")"),
+		ExpressionAttributeNames: map[string]string{"#version": "version"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":email":      &types.AttributeValueMemberS{Value: email},
+			":verified":   &types.AttributeValueMemberBOOL{Value: true},
+			":unverified": &types.AttributeValueMemberBOOL{Value: false},
+			":updated_at": &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+			":one":        &types.AttributeValueMemberN{Value: "1"},
+		},
+		ReturnValues:                        types.ReturnValueUpdatedNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, -1) {
+		current, _ := db.Decode[User](db.CurrentItem(err))
+		if current.Email == email && current.DeletedAt == "" {
+			return 0, ErrEmailVerified
+		}
+		return 0, ErrEmailChanged
+	}
+	if err != nil {
+		return 0, db.Wrap(err, "verifying email")
+	}
+	r.Invalidate(userID, email)
+	updated, err := db.Decode[User](out.Attributes)
+	if err != nil {
+		return 0, err
+	}
+	return updated.Version, nil
+}
+
+// Delete deletes the user record user, as read, in one transaction with the
+// reservations of its email and username and the related writes extra, e.g.
+// of items keyed on the user. Either all of them are gone afterwards or
+// none is.
+func (r *Repository) Delete(ctx context.Context, item db.Item, extra ...db.Write) error {
+	user, err := db.Decode[User](item)
+	if err != nil {
+		return err
+	}
+	writes := []db.Write{db.Delete(r.Table, Key(user.UserID))}
+	email := user.Email
+	if email == "" {
+		// Soft deletion moves the email aside, keeping the reservation
+		email = user.DeletedEmail
+	}
+	if email != "" {
+		writes = append(writes, db.Delete(r.Table, EmailLockKey(email)))
+	}
+	if user.Username != "" {
+		writes = append(writes, db.Delete(r.Table, UsernameLockKey(user.Username)))
+	}
+	if err := r.DB.Transact(ctx, append(writes, extra...)...); err != nil {
+		return err
+	}
+	r.Invalidate(user.UserID, email)
+	return nil
+}
+
+// VersionConflict returns the conflict of a write of fields based on an
+// older version of the user record than current, as read when the version
+// condition failed: ErrVersionConflict with the stored version and the
+// current values of the fields that differ from the write, so clients can
+// merge. Without current, the user is gone and it is ErrVersionConflict.
+func VersionConflict(current db.Item, fields map[string]string) error {
+	if len(current) == 0 {
+		return ErrVersionConflict
+	}
+	user, err := db.Decode[User](current)
+	if err != nil {
+		return err
+	}
+	values, err := db.Decode[map[string]any](current)
+	if err != nil {
+		return err
+	}
+	changed := map[string]any{}
+	for name, value := range fields {
+		if v, ok := values[name].(string); !ok {
+			changed[name] = nil
+		} else if v != value {
+			changed[name] = v
+		}
+	}
+	return apperr.VersionConflict(ErrVersionConflict, "Profile was modified by another request; merge and retry", &apperr.Current{
+		Version: user.Version,
+		Changed: changed,
+	})
+}
@@ -0,0 +1,103 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func TestChangeEmail(t *testing.T) {
+	ctx := context.Background()
+	stored := func(email string) *dbtest.Mock {
+		return &dbtest.Mock{GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			item := dbtest.Item("user_id", "u1", "email", email)
+			item["version"] = &types.AttributeValueMemberN{Value: "3"}
+			return &dynamodb.GetItemOutput{Item: item}, nil
+		}}
+	}
+
+	t.Run("moves record and reservations", func(t *testing.T) {
+		m := stored("jane@example.com")
+		r := &Repository{DB: m.Client(), Table: "users"}
+		version, err := r.ChangeEmail(ctx, "u1", "jane@example.com", "jane@example.org", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 4 {
+			t.Errorf("version = %d, want 4", version)
+		}
+		items := m.Calls[1].Input.(*dynamodb.TransactWriteItemsInput).TransactItems
+		if len(items) != 3 || items[0].Update == nil || items[1].Put == nil || items[2].Delete == nil {
+			t.Fatalf("transaction = %+v, want the update, the new reservation and the old one released", items)
+		}
+		if got, want := items[1].Put.Item, EmailLock("jane@example.org", "u1"); !reflect.DeepEqual(got, want) {
+			t.Errorf("reservation = %v, want %v", got, want)
+		}
+		if got, want := items[2].Delete.Key, EmailLockKey("jane@example.com"); !reflect.DeepEqual(got, want) {
+			t.Errorf("released = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("already moved", func(t *testing.T) {
+		m := stored("jane@example.org")
+		r := &Repository{DB: m.Client(), Table: "users"}
+		if _, err := r.ChangeEmail(ctx, "u1", "jane@example.com", "jane@example.org", time.Now()); !errors.Is(err, ErrEmailChanged) {
+			t.Errorf("err = %v, want ErrEmailChanged", err)
+		}
+		if len(m.Calls) != 1 {
+			t.Errorf("ops = %v, want only the read", m.Ops())
+		}
+	})
+
+	t.Run("address taken", func(t *testing.T) {
+		m := stored("jane@example.com")
+		m.TransactWriteItemsFunc = func(*dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+			return nil, dbtest.TransactionCanceled("None", "ConditionalCheckFailed", "None")
+		}
+		r := &Repository{DB: m.Client(), Table: "users"}
+		if _, err := r.ChangeEmail(ctx, "u1", "jane@example.com", "jane@example.org", time.Now()); !errors.Is(err, ErrEmailTaken) {
+			t.Errorf("err = %v, want ErrEmailTaken", err)
+		}
+	})
+}
+
+func TestVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		current db.Item // on a failed condition
+		failed  bool
+		want    error
+	}{
+		{name: "unverified"},
+		{name: "verified already", failed: true, current: dbtest.Item("user_id", "u1", "email", "jane@example.com"), want: ErrEmailVerified},
+		{name: "address changed", failed: true, current: dbtest.Item("user_id", "u1", "email", "jane@example.org"), want: ErrEmailChanged},
+		{name: "gone", failed: true, want: ErrEmailChanged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				if tt.failed {
+					return nil, &types.ConditionalCheckFailedException{Item: tt.current}
+				}
+				return &dynamodb.UpdateItemOutput{Attributes: db.Item{"version": &types.AttributeValueMemberN{Value: "4"}}}, nil
+			}}
+			r := &Repository{DB: m.Client(), Table: "users"}
+			version, err := r.VerifyEmail(ctx, "u1", "jane@example.com", time.Now())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && version != 4 {
+				t.Errorf("version = %d, want 4", version)
+			}
+		})
+	}
+}
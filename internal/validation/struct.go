@@ -0,0 +1,264 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/httpx"
+)
+
+// Codes of the per-field errors of Struct.
+const (
+	CodeRequired   = "REQUIRED"
+	CodeTooShort   = "TOO_SHORT"
+	CodeTooLong    = "TOO_LONG"
+	CodeOutOfRange = "OUT_OF_RANGE"
+	CodeNotAllowed = "NOT_ALLOWED"
+)
+
+// Struct checks v, a struct or a pointer to one, against the validate tags
+// of its fields and reports every field that fails as one apperr
+// InvalidFields error. Fields are named as in JSON. The rules of a tag are
+// separated by commas:
+//
+//	required   not empty, zero or nil
+//	min=N      at least N characters or entries, or a number of at least N
+//	max=N      at most N characters or entries, or a number of at most N
+//	oneof=a b  one of the space-separated values
+//	email      a valid email address
+//
+// Rules other than required pass empty values. Nested structs, and structs
+// in slices, are checked too, named e.g. "results[1].score". A malformed
+// tag is a programming error and panics.
+func Struct(v any) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validation: Struct of %s", rv.Type()))
+	}
+	var errs []apperr.FieldError
+	checkStruct(rv, "", &errs)
+	if len(errs) > 0 {
+		return apperr.InvalidFields(errs)
+	}
+	return nil
+}
+
+// Body returns middleware that decodes the request body into a T and checks
+// it with Struct before the handler runs, answering malformed bodies with a
+// 400 and invalid ones with a 422. The handler decodes the body again;
+// bodies are small, and this keeps handlers' signatures unchanged. An empty
+// body is checked as the zero T. Only fields read from the body should carry
+// tags: path parameters are merged in by the handler, after the check.
+func Body[T any]() httpx.Middleware {
+	return func(next httpx.Handler) httpx.Handler {
+		return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+			var body T
+			if len(r.Body) > 0 {
+				if err := r.Decode(&body); err != nil {
+					return httpx.Error(apperr.BadRequest("Invalid request")), nil
+				}
+			}
+			if err := Struct(&body); err != nil {
+				return httpx.Error(err), nil
+			}
+			return next(ctx, r)
+		}
+	}
+}
+
+// field is a struct field with its parsed rules.
+type field struct {
+	index int
+	name  string
+	rules []rule
+}
+
+// rule is one rule of a validate tag.
+type rule struct {
+	name  string
+	n     float64  // argument of min and max
+	allow []string // argument of oneof
+}
+
+// fieldCache holds the fields of each struct type checked so far.
+var fieldCache sync.Map // reflect.Type -> []field
+
+// fieldsOf returns the exported fields of t, parsing their tags once.
+func fieldsOf(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+	var fields []field
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		f := field{index: i, name: name}
+		if tag := sf.Tag.Get("validate"); tag != "" {
+			for _, spec := range strings.Split(tag, ",") {
+				f.rules = append(f.rules, parseRule(t, sf.Name, spec))
+			}
+		}
+		fields = append(fields, f)
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// parseRule parses one rule of the tag of t's named field.
+func parseRule(t reflect.Type, fieldName, spec string) rule {
+	name, arg, _ := strings.Cut(spec, "=")
+	r := rule{name: name}
+	switch name {
+	case "required", "email":
+	case "min", "max":
+		n, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: bad %s on %s.%s: %q", name, t, fieldName, arg))
+		}
+		r.n = n
+	case "oneof":
+		r.allow = strings.Fields(arg)
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q on %s.%s", name, t, fieldName))
+	}
+	return r
+}
+
+// checkStruct appends the invalid fields of the struct v, whose path is
+// prefix, to errs.
+func checkStruct(v reflect.Value, prefix string, errs *[]apperr.FieldError) {
+	for _, f := range fieldsOf(v.Type()) {
+		fv := v.Field(f.index)
+		path := f.name
+		if prefix != "" {
+			path = prefix + "." + f.name
+		}
+		if fe, ok := checkField(fv, path, f.rules); !ok {
+			*errs = append(*errs, fe)
+			continue
+		}
+		checkNested(fv, path, errs)
+	}
+}
+
+// checkNested checks the structs within v, a field at path.
+func checkNested(v reflect.Value, path string, errs *[]apperr.FieldError) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			checkNested(v.Elem(), path, errs)
+		}
+	case reflect.Struct:
+		checkStruct(v, path, errs)
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			checkNested(v.Index(i), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	}
+}
+
+// checkField applies rules to v, reporting the first one it fails.
+func checkField(v reflect.Value, path string, rules []rule) (apperr.FieldError, bool) {
+	empty := isEmpty(v)
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	for _, r := range rules {
+		if r.name == "required" {
+			if empty {
+				return apperr.FieldError{Field: path, Code: CodeRequired, Message: path + " is required"}, false
+			}
+			continue
+		}
+		if empty {
+			continue
+		}
+		if fe, ok := r.check(v, path); !ok {
+			return fe, false
+		}
+	}
+	return apperr.FieldError{}, true
+}
+
+// check applies a rule other than required to the non-empty v.
+func (r rule) check(v reflect.Value, path string) (apperr.FieldError, bool) {
+	fail := func(code, format string, args ...any) (apperr.FieldError, bool) {
+		return apperr.FieldError{Field: path, Code: code, Message: path + " " + fmt.Sprintf(format, args...)}, false
+	}
+	switch r.name {
+	case "min", "max":
+		n, unit := size(v)
+		switch {
+		case r.name == "min" && n < r.n && unit == "":
+			return fail(CodeOutOfRange, "must be at least %v", r.n)
+		case r.name == "max" && n > r.n && unit == "":
+			return fail(CodeOutOfRange, "must be at most %v", r.n)
+		case r.name == "min" && n < r.n:
+			return fail(CodeTooShort, "must have at least %v %s", r.n, unit)
+		case r.name == "max" && n > r.n:
+			return fail(CodeTooLong, "must have at most %v %s", r.n, unit)
+		}
+	case "oneof":
+		if v.Kind() == reflect.String && !slices.Contains(r.allow, v.String()) {
+			return fail(CodeNotAllowed, "must be one of %s", strings.Join(r.allow, ", "))
+		}
+	case "email":
+		if v.Kind() == reflect.String {
+			if _, err := NormalizeEmail(v.String(), false); err != nil {
+				return fail(CodeEmailInvalid, "is not a valid address")
+			}
+		}
+	}
+	return apperr.FieldError{}, true
+}
+
+// size returns the value of a number, or the length of a string in
+// characters or of a slice or map in entries, with that unit.
+func size(v reflect.Value) (n float64, unit string) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), "characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), "entries"
+	}
+	return 0, ""
+}
+
+// isEmpty reports whether v is missing: nil, zero, or a string of spaces.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
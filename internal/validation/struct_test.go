@@ -0,0 +1,118 @@
+package validation
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/httpx"
+)
+
+type testResult struct {
+	UserID string `json:"user_id" validate:"required"`
+	Score  *int64 `json:"score" validate:"required,min=0"`
+}
+
+type testRequest struct {
+	Name    string       `json:"name" validate:"required,max=5"`
+	Email   string       `json:"email" validate:"email"`
+	Kind    string       `json:"kind" validate:"oneof=ranked casual"`
+	Players int          `json:"players" validate:"min=2,max=8"`
+	Tags    []string     `json:"tags" validate:"max=2"`
+	Results []testResult `json:"results"`
+	Note    string       // untagged
+}
+
+func TestStruct(t *testing.T) {
+	zero, negative := int64(0), int64(-1)
+	tests := []struct {
+		name string
+		req  testRequest
+		want []apperr.FieldError
+	}{
+		{
+			name: "valid",
+			req:  testRequest{Name: "jane", Email: "jane@example.com", Kind: "ranked", Players: 2, Results: []testResult{{UserID: "u1", Score: &zero}}},
+		},
+		{
+			name: "empty optional fields",
+			req:  testRequest{Name: "jane"},
+		},
+		{
+			name: "every rule",
+			req:  testRequest{Name: "janedoe", Email: "nope", Kind: "solo", Players: 9, Tags: []string{"a", "b", "c"}},
+			want: []apperr.FieldError{
+				{Field: "name", Code: CodeTooLong, Message: "name must have at most 5 characters"},
+				{Field: "email", Code: CodeEmailInvalid, Message: "email is not a valid address"},
+				{Field: "kind", Code: CodeNotAllowed, Message: "kind must be one of ranked, casual"},
+				{Field: "players", Code: CodeOutOfRange, Message: "players must be at most 8"},
+				{Field: "tags", Code: CodeTooLong, Message: "tags must have at most 2 entries"},
+			},
+		},
+		{
+			name: "nested",
+			req:  testRequest{Name: " ", Results: []testResult{{UserID: "u1", Score: &zero}, {Score: &negative}, {UserID: "u3"}}},
+			want: []apperr.FieldError{
+				{Field: "name", Code: CodeRequired, Message: "name is required"},
+				{Field: "results[1].user_id", Code: CodeRequired, Message: "results[1].user_id is required"},
+				{Field: "results[1].score", Code: CodeOutOfRange, Message: "results[1].score must be at least 0"},
+				{Field: "results[2].score", Code: CodeRequired, Message: "results[2].score is required"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Struct(&tt.req)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Struct = %v, want nil", err)
+				}
+				return
+			}
+			e := apperr.As(err)
+			if e.Kind != apperr.KindInvalid || !reflect.DeepEqual(e.Fields, tt.want) {
+				t.Errorf("Struct fields = %+v, want %+v", e.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructBadTag(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("want a panic")
+		}
+	}()
+	Struct(&struct {
+		Name string `validate:"requird"`
+	}{})
+}
+
+func TestBody(t *testing.T) {
+	var called bool
+	h := httpx.Chain(func(context.Context, *httpx.Request) (httpx.Response, error) {
+		called = true
+		return httpx.NoContent(), nil
+	}, Body[testRequest]())
+
+	tests := []struct {
+		body       string
+		wantStatus int
+	}{
+		{`{"name":"jane"}`, 204},
+		{`{"name":"janedoe"}`, 422},
+		{``, 422},
+		{`{"name":`, 400},
+	}
+	for _, tt := range tests {
+		called = false
+		resp, err := h(context.Background(), &httpx.Request{Body: []byte(tt.body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.wantStatus || called != (tt.wantStatus == 204) {
+			t.Errorf("body %q: status = %d, handler called = %v; want %d", tt.body, resp.StatusCode, called, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package validation
+
+import (
+	"strings"
+
+	"troggle-backend/internal/apperr"
+)
+
+// Username error codes.
+const (
+	CodeUsernameRequired = "USERNAME_REQUIRED"
+	CodeUsernameInvalid  = "USERNAME_INVALID"
+	CodeUsernameReserved = "USERNAME_RESERVED"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 30
+)
+
+// reservedUsernames could be mistaken for the service or its staff. They are
+// compared by skeleton, so "adm1n" and "sup.port" are reserved too.
+var reservedUsernames = []string{
+	"admin", "administrator", "api", "help", "me", "moderator", "null",
+	"root", "security", "staff", "support", "system", "troggle",
+}
+
+// NormalizeUsername trims and case-folds a handle and checks its syntax: 3
+// to 30 ASCII letters, digits, '.' and '_', starting with a letter, with no
+// separator at the end or next to another. Restricting handles to ASCII
+// rules out look-alikes from other scripts ("аdmin" with a Cyrillic а);
+// look-alikes within ASCII are caught by UsernameSkeleton.
+func NormalizeUsername(raw string) (string, error) {
+	username := strings.ToLower(strings.TrimSpace(raw))
+	if username == "" {
+		return "", apperr.Invalid(CodeUsernameRequired, "username", "username is required")
+	}
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return "", apperr.Invalid(CodeUsernameInvalid, "username", "username must be 3 to 30 characters")
+	}
+	if strings.IndexFunc(username, notUsernameRune) >= 0 {
+		return "", apperr.Invalid(CodeUsernameInvalid, "username", "username may only contain letters, digits, '.' and '_'")
+	}
+	if username[0] < 'a' || username[0] > 'z' {
+		return "", apperr.Invalid(CodeUsernameInvalid, "username", "username must start with a letter")
+	}
+	if last := username[len(username)-1]; isSeparator(last) || strings.Contains(username, "..") ||
+		strings.Contains(username, "__") || strings.Contains(username, "._") || strings.Contains(username, "_.") {
+		return "", apperr.Invalid(CodeUsernameInvalid, "username", "username separators must be between letters or digits")
+	}
+
+	skeleton := UsernameSkeleton(username)
+	for _, reserved := range reservedUsernames {
+		if skeleton == UsernameSkeleton(reserved) {
+			return "", apperr.Invalid(CodeUsernameReserved, "username", "username is reserved")
+		}
+	}
+	return username, nil
+}
+
+// UsernameSkeleton maps a normalized username to the form uniqueness is
+// enforced on, so that handles which read the same are one handle:
+// separators are dropped and characters that are easily confused collapse
+// to one: "jane_doe" and "jane.d0e" both become "janedoe", "rnary" becomes
+// "mary" and "il1" becomes "lll".
+func UsernameSkeleton(username string) string {
+	s := strings.NewReplacer(".", "", "_", "").Replace(username)
+	s = strings.NewReplacer("rn", "m", "vv", "w").Replace(s)
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '0':
+			return 'o'
+		case '1', 'i':
+			return 'l'
+		case '5':
+			return 's'
+		}
+		return r
+	}, s)
+}
+
+func notUsernameRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '_')
+}
+
+func isSeparator(b byte) bool {
+	return b == '.' || b == '_'
+}
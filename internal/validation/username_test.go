@@ -0,0 +1,60 @@
+package validation
+
+import (
+	"testing"
+
+	"troggle-backend/internal/apperr"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := []struct {
+		raw      string
+		want     string
+		wantCode string
+	}{
+		{raw: " Jane.Doe ", want: "jane.doe"},
+		{raw: "j_d_2", want: "j_d_2"},
+		{raw: "", wantCode: CodeUsernameRequired},
+		{raw: "jd", wantCode: CodeUsernameInvalid},
+		{raw: "jane doe", wantCode: CodeUsernameInvalid},
+		{raw: "jäne", wantCode: CodeUsernameInvalid},
+		{raw: "2jane", wantCode: CodeUsernameInvalid},
+		{raw: "jane.", wantCode: CodeUsernameInvalid},
+		{raw: "jane._doe", wantCode: CodeUsernameInvalid},
+		{raw: "Admin", wantCode: CodeUsernameReserved},
+		{raw: "adm1n", wantCode: CodeUsernameReserved},
+		{raw: "sup.p0rt", wantCode: CodeUsernameReserved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := NormalizeUsername(tt.raw)
+			if tt.wantCode != "" {
+				if e := apperr.As(err); err == nil || e.Code != tt.wantCode {
+					t.Fatalf("err = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizeUsername = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsernameSkeleton(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"jane_doe", "jane.doe"},
+		{"jane.d0e", "janedoe"},
+		{"rnary", "mary"},
+		{"vvill", "wlll"},
+		{"sam5", "sams"},
+	}
+	for _, tt := range tests {
+		if UsernameSkeleton(tt.a) != UsernameSkeleton(tt.b) {
+			t.Errorf("%q and %q have different skeletons", tt.a, tt.b)
+		}
+	}
+	if UsernameSkeleton("jane") == UsernameSkeleton("jade") {
+		t.Error("distinct names share a skeleton")
+	}
+}
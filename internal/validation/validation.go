@@ -0,0 +1,101 @@
+// Package validation normalizes and validates user-supplied input before it
+// reaches the database. Failures are apperr errors of KindInvalid carrying a
+// machine-readable code, so every function answers bad input the same way
+// (422 with {"error": {"code": ..., "field": ..., "message": ...}}).
+//
+// Request bodies declare their rules in validate struct tags, which Body
+// checks before the handler runs; see Struct.
+package validation
+
+import (
+	"net/mail"
+	"strings"
+
+	"troggle-backend/internal/apperr"
+)
+
+// Machine-readable error codes returned to clients.
+const (
+	CodeEmailRequired  = "EMAIL_REQUIRED"
+	CodeEmailInvalid   = "EMAIL_INVALID"
+	CodeUserIDRequired = "USER_ID_REQUIRED"
+	CodeUserIDInvalid  = "USER_ID_INVALID"
+
+	CodeSessionIDRequired = "SESSION_ID_REQUIRED"
+	CodeSessionIDInvalid  = "SESSION_ID_INVALID"
+
+	CodePlatformInvalid     = "PLATFORM_INVALID"
+	CodeDeviceTokenRequired = "DEVICE_TOKEN_REQUIRED"
+	CodeDeviceTokenInvalid  = "DEVICE_TOKEN_INVALID"
+)
+
+const (
+	// maxEmailLength is the longest address SMTP allows (RFC 5321).
+	maxEmailLength = 254
+
+	// maxUserIDLength bounds user IDs; Cognito subs are 36-character UUIDs.
+	maxUserIDLength = 128
+
+	// maxSessionIDLength bounds session IDs; Cognito's origin_jti is a UUID.
+	maxSessionIDLength = 64
+)
+
+// NormalizeEmail trims and lower-cases an address and checks its syntax.
+// With stripPlusAlias, a "+tag" suffix of the local part is removed so that
+// "jane+news@example.com" and "jane@example.com" are the same account.
+func NormalizeEmail(raw string, stripPlusAlias bool) (string, error) {
+	email := strings.ToLower(strings.TrimSpace(raw))
+	if email == "" {
+		return "", apperr.Invalid(CodeEmailRequired, "email", "email is required")
+	}
+	if len(email) > maxEmailLength {
+		return "", apperr.Invalid(CodeEmailInvalid, "email", "email is too long")
+	}
+
+	// ParseAddress accepts display names and comments ("Jane <jane@x.com>");
+	// only a bare address that round-trips unchanged is valid here
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", apperr.Invalid(CodeEmailInvalid, "email", "email is not a valid address")
+	}
+
+	local, domain, _ := strings.Cut(email, "@")
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", apperr.Invalid(CodeEmailInvalid, "email", "email domain is not valid")
+	}
+
+	if stripPlusAlias {
+		if base, _, found := strings.Cut(local, "+"); found && base != "" {
+			local = base
+		}
+	}
+	return local + "@" + domain, nil
+}
+
+// UserID checks that id can be a user's primary key. Keys containing '#' are
+// reserved for internal sentinel items in the user table.
+func UserID(id string) error {
+	switch {
+	case id == "":
+		return apperr.Invalid(CodeUserIDRequired, "user_id", "user_id is required")
+	case len(id) > maxUserIDLength, strings.ContainsAny(id, "# \t\r\n"):
+		return apperr.Invalid(CodeUserIDInvalid, "user_id", "user_id is not valid")
+	}
+	return nil
+}
+
+// SessionID checks that id can be a session's sort key: letters, digits and
+// dashes only.
+func SessionID(id string) error {
+	switch {
+	case id == "":
+		return apperr.Invalid(CodeSessionIDRequired, "session_id", "session_id is required")
+	case len(id) > maxSessionIDLength, strings.IndexFunc(id, notSessionIDRune) >= 0:
+		return apperr.Invalid(CodeSessionIDInvalid, "session_id", "session_id is not valid")
+	}
+	return nil
+}
+
+func notSessionIDRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-')
+}
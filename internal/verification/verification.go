@@ -0,0 +1,111 @@
+// Package verification gates what accounts whose email address is not
+// verified may do.
+//
+// The user record's email_verified attribute follows Cognito's: createUser
+// records it from the PostConfirmation trigger, migrateUser from the legacy
+// account, and confirmEmailChange sets it when a link proves the address,
+// including the links resendVerification mails to users who never verified
+// theirs. Records from before the attribute count as verified (see
+// users.Schema).
+//
+// Guard refuses the endpoints reaching other users, such as friend
+// requests, follows and messages, to unverified accounts, so that throwaway
+// sign-ups cannot spam players.
+package verification
+
+import (
+	"context"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/httpx"
+	"troggle-backend/internal/users"
+)
+
+// Attribute is the user record attribute holding whether the address is
+// verified.
+const Attribute = "email_verified"
+
+// ErrUnverified refuses gated endpoints to users whose email address is
+// not verified.
+var ErrUnverified = &apperr.Error{Kind: apperr.KindForbidden, Code: "EMAIL_NOT_VERIFIED", Message: "Verify your email address to do this"}
+
+// Guard is the check of gated endpoints.
+type Guard struct {
+	Users *users.Repository
+}
+
+// NewGuard returns the guard over the user table named in cfg.
+func NewGuard(client *db.Client, cfg *config.Config) *Guard {
+	return &Guard{Users: users.NewRepository(client, cfg)}
+}
+
+// Verified reports whether the email address of userID is verified. Users
+// without a record, or whose record predates the attribute, count as
+// verified: the handler answers for the former.
+func (g *Guard) Verified(ctx context.Context, userID string) (bool, error) {
+	item, err := g.Users.Get(ctx, userID, []string{Attribute})
+	if err != nil {
+		return false, err
+	}
+	user, err := db.Decode[record](item)
+	if err != nil {
+		return false, err
+	}
+	return user.Verified == nil || *user.Verified, nil
+}
+
+// record is the part of a user record Verified reads.
+type record struct {
+	Verified *bool `dynamodbav:"email_verified"`
+}
+
+// Check returns ErrUnverified unless id may use gated endpoints. API keys
+// are let through; they have no address, and their grants bound what they
+// can do.
+func (g *Guard) Check(ctx context.Context, id *auth.Identity) error {
+	if id.TokenUse == auth.TokenUseAPIKey {
+		return nil
+	}
+	ok, err := g.Verified(ctx, id.Subject)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUnverified
+	}
+	return nil
+}
+
+// Require runs Check on the verified caller of r. Direct invocations, and
+// every request when g is nil, pass.
+func (g *Guard) Require(ctx context.Context, r *httpx.Request) error {
+	if g == nil || r.Direct {
+		return nil
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return apperr.Unauthorized(auth.ErrNoToken)
+	}
+	return g.Check(ctx, id)
+}
+
+// Middleware returns a middleware refusing the requests Require fails. It
+// goes after auth.Middleware.
+func (g *Guard) Middleware() httpx.Middleware {
+	return func(next httpx.Handler) httpx.Handler {
+		return func(ctx context.Context, r *httpx.Request) (httpx.Response, error) {
+			err := g.Require(ctx, r)
+			switch apperr.KindOf(err) {
+			case apperr.KindForbidden, apperr.KindUnauthorized:
+				return httpx.Error(err), nil
+			}
+			if err != nil {
+				return httpx.Response{}, err
+			}
+			return next(ctx, r)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/auth"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+	"troggle-backend/internal/users"
+)
+
+func TestGuardCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     db.Item
+		tokenUse string
+		want     error
+	}{
+		{name: "verified", user: db.Item{Attribute: &types.AttributeValueMemberBOOL{Value: true}}},
+		{name: "unverified", user: db.Item{Attribute: &types.AttributeValueMemberBOOL{Value: false}}, want: ErrUnverified},
+		{name: "record from before", user: dbtest.Item("user_id", "u1")},
+		{name: "no record"},
+		{name: "API key", user: db.Item{Attribute: &types.AttributeValueMemberBOOL{Value: false}}, tokenUse: auth.TokenUseAPIKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &dbtest.Mock{GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+				return &dynamodb.GetItemOutput{Item: tt.user}, nil
+			}}
+			g := &Guard{Users: users.NewRepository(m.Client(), &config.Config{UserTableName: "users"})}
+			tokenUse := tt.tokenUse
+			if tokenUse == "" {
+				tokenUse = "access"
+			}
+			err := g.Check(context.Background(), &auth.Identity{Subject: "u1", TokenUse: tokenUse})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Check = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
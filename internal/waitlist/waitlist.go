@@ -0,0 +1,312 @@
+// Package waitlist keeps the waitlist of people waiting for an account while
+// sign-ups are closed (WAITLIST_MODE), and lets them in a wave at a time.
+//
+// The waitlist table holds one entry per normalized address, keyed by
+// email. An entry's position is its number in the sequence of joins, drawn
+// from a counter (see package counters), less ReferralBoost for each person
+// who joined with its referral code: referrals move people up. Referral
+// codes are random and resolved through an item of the same table keyed by
+// "REF#" and the code, like the reservations of package users; the entry,
+// its code and the referrer's boost are written in one transaction.
+//
+// Waiting entries carry the queue attribute, which keys the sparse queue
+// index sorted by position; admitting an entry removes it, so the index
+// lists who is still waiting, first in line first. The estimated place in
+// line is the position less the number admitted so far, which another
+// counter keeps. It is an estimate: boosts reorder the line, and the
+// counters are not updated in the transactions they follow.
+package waitlist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/apperr"
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/counters"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/logging"
+)
+
+// ReferralBoost is how many places each referral moves the referrer up.
+const ReferralBoost = 5
+
+// Statuses of an entry.
+const (
+	StatusWaiting  = "waiting"
+	StatusAdmitted = "admitted"
+)
+
+// queueWaiting is the queue attribute of waiting entries.
+const queueWaiting = "waiting"
+
+// referralPrefix starts the keys of referral codes.
+const referralPrefix = "REF#"
+
+// Counters of the joins and admissions.
+const (
+	joinedCounter   = "waitlist#joined"
+	admittedCounter = "waitlist#admitted"
+)
+
+var (
+	// ErrInvalidReferral is returned for referral codes nobody holds.
+	ErrInvalidReferral = apperr.Invalid("INVALID_REFERRAL_CODE", "referral_code", "The referral code is invalid")
+
+	// ErrNotWaiting is returned by Admit for entries admitted already.
+	ErrNotWaiting = &apperr.Error{Kind: apperr.KindConflict, Code: "NOT_WAITING", Message: "The entry was admitted already"}
+
+	// errJoined cancels the join of an address that joined meanwhile.
+	errJoined = errors.New("address joined the waitlist already")
+)
+
+// codePattern matches referral codes, 8 characters of unpadded base32.
+var codePattern = regexp.MustCompile(`^[A-Z2-7]{8}$`)
+
+// Entry is the model of a waitlist entry.
+type Entry struct {
+	Email        string `dynamodbav:"email"` // normalized
+	Position     int64  `dynamodbav:"position"`
+	ReferralCode string `dynamodbav:"referral_code"`
+	ReferredBy   string `dynamodbav:"referred_by,omitempty"` // address of the referrer
+	Referrals    int64  `dynamodbav:"referrals,omitempty"`
+	Status       string `dynamodbav:"status"`
+	Queue        string `dynamodbav:"queue,omitempty"` // set while waiting, for the queue index
+	JoinedAt     string `dynamodbav:"joined_at"`       // RFC 3339
+	AdmittedAt   string `dynamodbav:"admitted_at,omitempty"`
+	UserID       string `dynamodbav:"user_id,omitempty"` // of the account created on admission
+}
+
+// Store reads and writes the waitlist table.
+type Store struct {
+	DB         *db.Client
+	Table      string
+	QueueIndex string
+	Counters   *counters.Store
+}
+
+// NewStore returns a store over the waitlist table named in cfg.
+func NewStore(client *db.Client, cfg *config.Config) *Store {
+	return &Store{
+		DB:         client,
+		Table:      cfg.WaitlistTableName,
+		QueueIndex: cfg.WaitlistQueueIndexName,
+		Counters:   counters.NewStore(client, cfg),
+	}
+}
+
+// Get returns the entry of email, normalized, or nil if there is none.
+func (s *Store) Get(ctx context.Context, email string) (*Entry, error) {
+	item, err := s.DB.GetItem(ctx, s.Table, key(email))
+	if err != nil || item == nil {
+		return nil, err
+	}
+	e, err := db.Decode[Entry](item)
+	if err != nil {
+		return nil, fmt.Errorf("decoding waitlist entry: %w", err)
+	}
+	return &e, nil
+}
+
+// Join puts email, normalized, on the waitlist, referred by the holder of
+// referralCode unless it is empty, and returns its entry and true. Addresses
+// on the waitlist already keep their entry, which is returned with false.
+// Unknown codes fail with ErrInvalidReferral either way, so the answer does
+// not tell whether an address is on the waitlist.
+func (s *Store) Join(ctx context.Context, email, referralCode string, now time.Time) (Entry, bool, error) {
+	var referrer string
+	if referralCode != "" {
+		var err error
+		if referrer, err = s.holder(ctx, referralCode); err != nil {
+			return Entry{}, false, err
+		}
+	}
+	existing, err := s.Get(ctx, email)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	if existing != nil {
+		return *existing, false, nil
+	}
+	position, err := s.Counters.Next(ctx, joinedCounter)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	e := Entry{
+		Email:        email,
+		Position:     position,
+		ReferralCode: newCode(),
+		ReferredBy:   referrer,
+		Status:       StatusWaiting,
+		Queue:        queueWaiting,
+		JoinedAt:     now.UTC().Format(time.RFC3339),
+	}
+	item, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("encoding waitlist entry: %w", err)
+	}
+	writes := []db.Write{
+		db.Put(s.Table, item, "attribute_not_exists(email)", errJoined),
+		db.Put(s.Table, db.Item{
+			"email": &types.AttributeValueMemberS{Value: referralPrefix + e.ReferralCode},
+			"owner": &types.AttributeValueMemberS{Value: email},
+		}, "attribute_not_exists(email)", nil),
+	}
+	if referrer != "" {
+		writes = append(writes, db.Write{
+			Item: types.TransactWriteItem{Update: &types.Update{
+				TableName:           aws.String(s.Table),
+				Key:                 key(referrer),
+				UpdateExpression:    aws.String("ADD referrals :one, #position :boost"),
+				ConditionExpression: aws.String("attribute_exists(email)"),
+				ExpressionAttributeNames: map[string]string{
+					"#position": "position", // reserved word
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one":   &types.AttributeValueMemberN{Value: "1"},
+					":boost": &types.AttributeValueMemberN{Value: strconv.Itoa(-ReferralBoost)},
+				},
+			}},
+			Conflict: ErrInvalidReferral,
+		})
+	}
+
+	err = s.DB.Transact(ctx, writes...)
+	if errors.Is(err, errJoined) {
+		// A concurrent join of the same address won; its number is skipped
+		existing, err := s.Get(ctx, email)
+		if err != nil || existing == nil {
+			return Entry{}, false, err
+		}
+		return *existing, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	return e, true, nil
+}
+
+// holder returns the address holding the referral code, or
+// ErrInvalidReferral.
+func (s *Store) holder(ctx context.Context, code string) (string, error) {
+	if !codePattern.MatchString(code) {
+		return "", ErrInvalidReferral
+	}
+	item, err := s.DB.GetItem(ctx, s.Table, key(referralPrefix+code))
+	if err != nil {
+		return "", err
+	}
+	owner, ok := item["owner"].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", ErrInvalidReferral
+	}
+	return owner.Value, nil
+}
+
+// Place returns the estimated place in line of e, from 1, or 0 when it was
+// admitted.
+func (s *Store) Place(ctx context.Context, e Entry) (int64, error) {
+	if e.Status != StatusWaiting {
+		return 0, nil
+	}
+	admitted, err := s.Counters.Get(ctx, admittedCounter)
+	if err != nil {
+		return 0, err
+	}
+	return max(1, e.Position-admitted), nil
+}
+
+// Next returns up to n waiting entries, first in line first.
+func (s *Store) Next(ctx context.Context, n int) ([]Entry, error) {
+	items, err := s.DB.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.Table),
+		IndexName:              aws.String(s.QueueIndex),
+		KeyConditionExpression: aws.String("queue = :queue"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":queue": &types.AttributeValueMemberS{Value: queueWaiting},
+		},
+		Limit: aws.Int32(int32(n)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := attributevalue.UnmarshalListOfMaps(items, &entries); err != nil {
+		return nil, fmt.Errorf("decoding waitlist entries: %w", err)
+	}
+	return entries, nil
+}
+
+// Admit marks e admitted with the account userID, taking it out of the
+// queue, and counts the admission. It fails with ErrNotWaiting when e was
+// admitted already.
+func (s *Store) Admit(ctx context.Context, e Entry, userID string, now time.Time) error {
+	start := time.Now()
+	_, err := s.DB.DynamoDB.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 key(e.Email),
+		UpdateExpression:    aws.String("SET #status = :admitted, user_id = :user, admitted_at = :at REMOVE queue"),
+		ConditionExpression: aws.String("attribute_exists(queue)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status", // reserved word
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":admitted": &types.AttributeValueMemberS{Value: StatusAdmitted},
+			":user":     &types.AttributeValueMemberS{Value: userID},
+			":at":       &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		},
+	})
+	db.Observe(ctx, start, err)
+	if db.ConditionFailed(err, 0) {
+		return ErrNotWaiting
+	}
+	if err != nil {
+		return db.Wrap(err, "admitting waitlist entry")
+	}
+	// The estimates can do with a missed count, the admission cannot be undone
+	if err := s.Counters.Add(ctx, map[string]int64{admittedCounter: 1}); err != nil {
+		slog.WarnContext(ctx, "Failed to count waitlist admission", logging.Err(err))
+	}
+	return nil
+}
+
+// Admitted reports whether email, normalized, was admitted from the
+// waitlist.
+func (s *Store) Admitted(ctx context.Context, email string) (bool, error) {
+	e, err := s.Get(ctx, email)
+	if err != nil || e == nil {
+		return false, err
+	}
+	return e.Status == StatusAdmitted, nil
+}
+
+// NormalizeCode returns code as referral codes are stored: trimmed and in
+// upper case, as people type them from the email.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// newCode returns a random referral code.
+func newCode() string {
+	b := make([]byte, 5)
+	_, _ = rand.Read(b)
+	return base32.StdEncoding.EncodeToString(b)
+}
+
+// key returns the primary key of the item of email.
+func key(email string) db.Item {
+	return db.Item{"email": &types.AttributeValueMemberS{Value: email}}
+}
@@ -0,0 +1,169 @@
+package waitlist
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/db/dbtest"
+)
+
+func testStore(m *dbtest.Mock) *Store {
+	return NewStore(m.Client(), &config.Config{WaitlistTableName: "waitlist", WaitlistQueueIndexName: "queue-index", CounterTableName: "counters"})
+}
+
+// next answers the counter update of Next with value.
+func next(value string) func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		return &dynamodb.UpdateItemOutput{Attributes: db.Item{"value": &types.AttributeValueMemberN{Value: value}}}, nil
+	}
+}
+
+func TestJoin(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		code        string
+		existing    bool // the address is on the waitlist
+		holder      string
+		txErr       error
+		wantErr     error
+		wantCreated bool
+		wantWrites  int
+	}{
+		{name: "joined", wantCreated: true, wantWrites: 2},
+		{name: "referred", code: "ABCD2345", holder: "kim@example.com", wantCreated: true, wantWrites: 3},
+		{name: "on the waitlist", code: "ABCD2345", holder: "kim@example.com", existing: true},
+		{name: "on the waitlist, unknown code", code: "ABCD2345", existing: true, wantErr: ErrInvalidReferral},
+		{name: "unknown code", code: "ABCD2345", wantErr: ErrInvalidReferral},
+		{name: "malformed code", code: "abc", holder: "kim@example.com", wantErr: ErrInvalidReferral},
+		{name: "concurrent join", txErr: dbtest.TransactionCanceled("ConditionalCheckFailed", "None"), wantWrites: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tx *dynamodb.TransactWriteItemsInput
+			m := &dbtest.Mock{
+				GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+					switch k := in.Key["email"].(*types.AttributeValueMemberS).Value; {
+					case k == "REF#"+tt.code && tt.holder != "":
+						return &dynamodb.GetItemOutput{Item: dbtest.Item("email", k, "owner", tt.holder)}, nil
+					case k == "sam@example.com" && (tt.existing || tx != nil):
+						item := dbtest.Item("email", k, "referral_code", "QRST6789", "status", StatusWaiting, "queue", queueWaiting)
+						item["position"] = &types.AttributeValueMemberN{Value: "3"}
+						return &dynamodb.GetItemOutput{Item: item}, nil
+					}
+					return &dynamodb.GetItemOutput{}, nil
+				},
+				UpdateItemFunc: next("7"),
+				TransactWriteItemsFunc: func(in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					tx = in
+					return &dynamodb.TransactWriteItemsOutput{}, tt.txErr
+				},
+			}
+
+			e, created, err := testStore(m).Join(context.Background(), "sam@example.com", tt.code, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Join = %v, want %v", err, tt.wantErr)
+			}
+			if created != tt.wantCreated {
+				t.Errorf("created = %v, want %v", created, tt.wantCreated)
+			}
+			if tt.wantWrites == 0 {
+				if tx != nil {
+					t.Errorf("transaction = %+v, want none", tx)
+				}
+				return
+			}
+			if n := len(tx.TransactItems); n != tt.wantWrites {
+				t.Fatalf("transaction has %d items, want %d", n, tt.wantWrites)
+			}
+			if !created {
+				if e.ReferralCode != "QRST6789" {
+					t.Errorf("entry = %+v, want the one of the concurrent join", e)
+				}
+				return
+			}
+
+			if e.Position != 7 || e.Status != StatusWaiting || e.Queue != queueWaiting || e.ReferredBy != tt.holder || !codePattern.MatchString(e.ReferralCode) {
+				t.Errorf("entry = %+v", e)
+			}
+			code := tx.TransactItems[1].Put.Item["email"].(*types.AttributeValueMemberS).Value
+			if code != "REF#"+e.ReferralCode {
+				t.Errorf("code item = %q, want the entry's code", code)
+			}
+			if tt.holder != "" {
+				boost := tx.TransactItems[2].Update
+				if k := boost.Key["email"].(*types.AttributeValueMemberS).Value; k != tt.holder {
+					t.Errorf("boost of %q, want the referrer", k)
+				}
+				if v := boost.ExpressionAttributeValues[":boost"].(*types.AttributeValueMemberN).Value; v != "-5" {
+					t.Errorf("boost = %s, want -5", v)
+				}
+			}
+		})
+	}
+}
+
+func TestPlace(t *testing.T) {
+	m := &dbtest.Mock{GetItemFunc: func(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+		item := dbtest.Item("counter", admittedCounter)
+		item["value"] = &types.AttributeValueMemberN{Value: "40"}
+		return &dynamodb.GetItemOutput{Item: item}, nil
+	}}
+	s := testStore(m)
+	for _, tt := range []struct {
+		entry Entry
+		want  int64
+	}{
+		{Entry{Position: 52, Status: StatusWaiting}, 12},
+		{Entry{Position: 31, Status: StatusWaiting}, 1}, // boosted past the admitted
+		{Entry{Position: 12, Status: StatusAdmitted}, 0},
+	} {
+		got, err := s.Place(context.Background(), tt.entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Place(%+v) = %d, want %d", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	now := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+	e := Entry{Email: "sam@example.com", Position: 3, Status: StatusWaiting, Queue: queueWaiting}
+
+	m := &dbtest.Mock{}
+	if err := testStore(m).Admit(context.Background(), e, "u1", now); err != nil {
+		t.Fatal(err)
+	}
+	if ops := m.Ops(); !reflect.DeepEqual(ops, []string{"UpdateItem", "UpdateItem"}) {
+		t.Fatalf("ops = %v, want the entry and the counter", ops)
+	}
+	update := m.Calls[0].Input.(*dynamodb.UpdateItemInput)
+	if aws.ToString(update.ConditionExpression) != "attribute_exists(queue)" {
+		t.Errorf("condition = %q, want waiting entries only", aws.ToString(update.ConditionExpression))
+	}
+	counter := m.Calls[1].Input.(*dynamodb.UpdateItemInput)
+	if name := counter.Key["counter"].(*types.AttributeValueMemberS).Value; name != admittedCounter {
+		t.Errorf("counter = %q, want %q", name, admittedCounter)
+	}
+
+	m = &dbtest.Mock{UpdateItemFunc: func(*dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+		return nil, dbtest.ConditionFailed()
+	}}
+	if err := testStore(m).Admit(context.Background(), e, "u1", now); !errors.Is(err, ErrNotWaiting) {
+		t.Errorf("Admit(admitted) = %v, want ErrNotWaiting", err)
+	}
+	if ops := m.Ops(); len(ops) != 1 {
+		t.Errorf("ops = %v, want no admission counted", ops)
+	}
+}
@@ -0,0 +1,117 @@
+// Package warmup gets a container ready for traffic before the traffic
+// arrives. Packages owning container-wide state, such as the shared AWS
+// config, the DynamoDB client and the JWKS cache, Register a warmer when
+// they build it; Run calls every warmer, so credentials are resolved,
+// connections opened and caches filled.
+//
+// httpx.Adapt runs the warmers in two cases: at init, in containers
+// initialized for provisioned concurrency, which is when AWS runs init
+// ahead of traffic; and on pings, which a scheduler rule sends every few
+// minutes to keep on-demand functions warm:
+//
+//	{"warmup": true}
+//
+// EventBridge "Scheduled Event" payloads are pings too. A ping warms the
+// one container it reaches, and never reaches the handler.
+package warmup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"troggle-backend/internal/logging"
+)
+
+// EnvInitType is set by Lambda to "provisioned-concurrency" in containers
+// initialized for provisioned concurrency.
+const EnvInitType = "AWS_LAMBDA_INITIALIZATION_TYPE"
+
+// timeout bounds a run, so a slow dependency cannot hold up init.
+const timeout = 5 * time.Second
+
+// Func warms one dependency.
+type Func func(ctx context.Context) error
+
+var (
+	mu      sync.Mutex
+	warmers = map[string]Func{}
+)
+
+// Register adds the warmer of the named dependency, replacing any of the
+// same name.
+func Register(name string, fn Func) {
+	mu.Lock()
+	defer mu.Unlock()
+	warmers[name] = fn
+}
+
+// Run calls every registered warmer in parallel, logging how long each
+// took, and returns their failures. A failed warmer leaves its dependency
+// to be set up by the first request, as without warming.
+func Run(ctx context.Context) error {
+	mu.Lock()
+	names := make([]string, 0, len(warmers))
+	for name := range warmers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	fns := make([]Func, len(names))
+	for i, name := range names {
+		fns[i] = warmers[name]
+	}
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	errs := make([]error, len(fns))
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Go(func() {
+			t := time.Now()
+			if err := fn(ctx); err != nil {
+				errs[i] = fmt.Errorf("warming %s: %w", names[i], err)
+				return
+			}
+			slog.DebugContext(ctx, "Warmed", "dependency", names[i], logging.Latency(t))
+		})
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err != nil {
+		slog.WarnContext(ctx, "Warm-up incomplete", logging.Latency(start), logging.Err(err))
+	} else {
+		slog.InfoContext(ctx, "Warmed up", "dependencies", len(fns), logging.Latency(start))
+	}
+	return err
+}
+
+// Provisioned reports whether the container was initialized for
+// provisioned concurrency.
+func Provisioned() bool {
+	return os.Getenv(EnvInitType) == "provisioned-concurrency"
+}
+
+// ping holds the fields that mark a payload as a ping.
+type ping struct {
+	Warmup     bool   `json:"warmup"`
+	Source     string `json:"source"`
+	DetailType string `json:"detail-type"`
+}
+
+// IsPing reports whether payload is a ping rather than a request.
+func IsPing(payload json.RawMessage) bool {
+	var p ping
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return false
+	}
+	return p.Warmup || (p.Source == "aws.events" && p.DetailType == "Scheduled Event")
+}
@@ -0,0 +1,52 @@
+package warmup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsPing(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    bool
+	}{
+		{`{"warmup": true}`, true},
+		{`{"source": "aws.events", "detail-type": "Scheduled Event", "detail": {}}`, true},
+		{`{"warmup": false}`, false},
+		{`{"httpMethod": "GET", "path": "/users/u1"}`, false},
+		{`{"source": "aws.events", "detail-type": "UserStatusChanged"}`, false},
+		{`[1, 2]`, false},
+	}
+	for _, tt := range tests {
+		if got := IsPing(json.RawMessage(tt.payload)); got != tt.want {
+			t.Errorf("IsPing(%s) = %v, want %v", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Cleanup(func() { warmers = map[string]Func{} })
+
+	var calls atomic.Int32
+	Register("ok", func(context.Context) error {
+		calls.Add(1)
+		return nil
+	})
+	Register("broken", func(context.Context) error { return errors.New("unreachable") })
+	Register("broken", func(context.Context) error {
+		calls.Add(1)
+		return errors.New("still unreachable")
+	})
+
+	err := Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "warming broken: still unreachable") {
+		t.Errorf("err = %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("%d warmers called, want 2", calls.Load())
+	}
+}
@@ -0,0 +1,10 @@
+// Package integration runs the handlers end to end against LocalStack. The
+// tests are behind the integration build tag and need Docker:
+//
+//	go test -tags integration ./tests/integration/...
+//
+// TestMain starts a LocalStack container with testcontainers-go, creates a
+// uniquely named copy of every table and removes the container (and with it
+// the tables) when the run ends. Set LOCALSTACK_ENDPOINT to reuse a running
+// LocalStack instead.
+package integration
@@ -0,0 +1,115 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/localstack"
+
+	"troggle-backend/internal/config"
+	"troggle-backend/internal/db"
+	"troggle-backend/internal/localdev"
+	"troggle-backend/internal/logging"
+)
+
+const (
+	// localstackImage is pinned so runs are reproducible.
+	localstackImage = "localstack/localstack:3.8"
+
+	// envEndpoint points the suite at an already running LocalStack.
+	envEndpoint = "LOCALSTACK_ENDPOINT"
+)
+
+// testCfg is the configuration every test builds its handlers from. Its
+// table names are unique to the run.
+var testCfg *config.Config
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	ctx := context.Background()
+
+	endpoint := os.Getenv(envEndpoint)
+	if endpoint == "" {
+		container, err := localstack.Run(ctx, localstackImage,
+			testcontainers.WithEnv(map[string]string{"SERVICES": "dynamodb,events"}))
+		defer func() {
+			if err := testcontainers.TerminateContainer(container); err != nil {
+				slog.Error("Failed to terminate LocalStack", logging.Err(err))
+			}
+		}()
+		if err != nil {
+			slog.Error("Failed to start LocalStack", logging.Err(err))
+			return 1
+		}
+		if endpoint, err = container.PortEndpoint(ctx, "4566/tcp", "http"); err != nil {
+			slog.Error("Failed to resolve LocalStack endpoint", logging.Err(err))
+			return 1
+		}
+	}
+
+	cfg, err := setup(endpoint)
+	if err != nil {
+		slog.Error("Failed to set up integration environment", logging.Err(err))
+		return 1
+	}
+	testCfg = cfg
+
+	client, err := db.NewSDK(ctx, cfg)
+	if err != nil {
+		slog.Error("Failed to create DynamoDB client", logging.Err(err))
+		return 1
+	}
+	if err := localdev.CreateTables(ctx, client, cfg); err != nil {
+		slog.Error("Failed to create tables", logging.Err(err))
+		return 1
+	}
+	// Needed when LOCALSTACK_ENDPOINT reuses a long-lived instance
+	defer func() {
+		if err := localdev.DeleteTables(ctx, client, cfg); err != nil {
+			slog.Error("Failed to delete tables", logging.Err(err))
+		}
+	}()
+
+	return m.Run()
+}
+
+// setup points every AWS client at endpoint and loads a configuration whose
+// table names carry a per-run prefix.
+func setup(endpoint string) (*config.Config, error) {
+	prefix := fmt.Sprintf("it%d-", time.Now().UnixNano())
+	env := map[string]string{
+		"AWS_ENDPOINT_URL":             endpoint,
+		"AWS_REGION":                   "us-east-1",
+		"AWS_ACCESS_KEY_ID":            "test",
+		"AWS_SECRET_ACCESS_KEY":        "test",
+		config.EnvDynamoDBEndpoint:     endpoint,
+		config.EnvUserPoolID:           "us-east-1_integration",
+		config.EnvAppClientIDs:         "integration",
+		config.EnvUserTableName:        prefix + config.DefaultUserTableName,
+		config.EnvSessionTableName:     prefix + config.DefaultSessionTableName,
+		config.EnvPreferenceTableName:  prefix + config.DefaultPreferenceTableName,
+		config.EnvDeviceTableName:      prefix + config.DefaultDeviceTableName,
+		config.EnvAPIKeyTableName:      prefix + config.DefaultAPIKeyTableName,
+		config.EnvIdempotencyTableName: prefix + config.DefaultIdempotencyTableName,
+		config.EnvRateLimitTableName:   prefix + config.DefaultRateLimitTableName,
+		logging.EnvMaskPII:             "false",
+	}
+	for k, v := range env {
+		if err := os.Setenv(k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	logging.Init()
+	return config.Load()
+}
@@ -0,0 +1,141 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+
+	"troggle-backend/internal/functions/checkuserexists"
+	"troggle-backend/internal/functions/createuser"
+	"troggle-backend/internal/functions/deleteuser"
+	"troggle-backend/internal/functions/getuserprofile"
+	"troggle-backend/internal/functions/updateuserprofile"
+	"troggle-backend/internal/httpx"
+)
+
+// noopCognito stands in for the user pool, which LocalStack only offers in
+// its paid edition.
+type noopCognito struct{}
+
+func (noopCognito) AdminDisableUser(context.Context, *cognitoidentityprovider.AdminDisableUserInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDisableUserOutput, error) {
+	return &cognitoidentityprovider.AdminDisableUserOutput{}, nil
+}
+
+func (noopCognito) AdminEnableUser(context.Context, *cognitoidentityprovider.AdminEnableUserInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminEnableUserOutput, error) {
+	return &cognitoidentityprovider.AdminEnableUserOutput{}, nil
+}
+
+func (noopCognito) AdminDeleteUser(context.Context, *cognitoidentityprovider.AdminDeleteUserInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AdminDeleteUserOutput, error) {
+	return &cognitoidentityprovider.AdminDeleteUserOutput{}, nil
+}
+
+// handlers are the real endpoint handlers, wired to LocalStack.
+type handlers struct {
+	create httpx.Handler
+	exists httpx.Handler
+	get    httpx.Handler
+	update httpx.Handler
+	delete httpx.Handler
+}
+
+func newHandlers(t *testing.T) handlers {
+	t.Helper()
+	ctx := context.Background()
+
+	create, err := createuser.New(ctx, testCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := checkuserexists.New(ctx, testCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get, err := getuserprofile.New(ctx, testCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update, err := updateuserprofile.New(ctx, testCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	del, err := deleteuser.New(ctx, testCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	del.Cognito = noopCognito{}
+
+	return handlers{
+		create: create.HTTP(),
+		exists: exists.HTTP(),
+		get:    get.HTTP(),
+		update: update.HTTP(),
+		delete: del.HTTP(),
+	}
+}
+
+// invoke sends body to h as a direct invocation and decodes the JSON
+// response into out, when out is non-nil.
+func invoke(t *testing.T, h httpx.Handler, body string, wantStatus int, out any) {
+	t.Helper()
+	resp, err := httpx.Adapt(h)(context.Background(), json.RawMessage(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s: status = %d, want %d (body %s)", body, resp.StatusCode, wantStatus, resp.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal([]byte(resp.Body), out); err != nil {
+			t.Fatalf("decoding %s: %v", resp.Body, err)
+		}
+	}
+}
+
+func TestUserLifecycle(t *testing.T) {
+	h := newHandlers(t)
+	userID := fmt.Sprintf("user-%d", time.Now().UnixNano())
+	email := userID + "@example.com"
+
+	// Create
+	invoke(t, h.create, `{"user_id":"`+userID+`","email":"`+email+`","display_name":"Jane"}`, 201, nil)
+	// A retried create is a no-op; another user with the email is rejected
+	invoke(t, h.create, `{"user_id":"`+userID+`","email":"`+email+`"}`, 201, nil)
+	invoke(t, h.create, `{"user_id":"other-`+userID+`","email":"`+email+`"}`, 409, nil)
+
+	// Check
+	var exists struct {
+		Exists bool `json:"exists"`
+	}
+	invoke(t, h.exists, `{"email":"`+email+`"}`, 200, &exists)
+	if !exists.Exists {
+		t.Fatal("created user does not exist")
+	}
+
+	// Update
+	var profile struct {
+		DisplayName string `json:"display_name"`
+		Bio         string `json:"bio"`
+		Version     int    `json:"version"`
+	}
+	invoke(t, h.update, `{"user_id":"`+userID+`","version":1,"bio":"Caver"}`, 200, nil)
+	invoke(t, h.update, `{"user_id":"`+userID+`","version":1,"bio":"Stale"}`, 409, nil)
+	invoke(t, h.get, `{"user_id":"`+userID+`"}`, 200, &profile)
+	if profile.Bio != "Caver" || profile.DisplayName != "Jane" || profile.Version != 2 {
+		t.Errorf("profile after update = %+v", profile)
+	}
+
+	// Delete
+	invoke(t, h.delete, `{"user_id":"`+userID+`"}`, 204, nil)
+	invoke(t, h.get, `{"user_id":"`+userID+`"}`, 404, nil)
+	invoke(t, h.exists, `{"email":"`+email+`"}`, 404, nil)
+	invoke(t, h.delete, `{"user_id":"`+userID+`"}`, 404, nil)
+
+	// The email reservation went with the user, so the address is free again
+	invoke(t, h.create, `{"user_id":"other-`+userID+`","email":"`+email+`"}`, 201, nil)
+}